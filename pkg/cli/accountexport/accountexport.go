@@ -0,0 +1,193 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package accountexport reads the account exports of the dnote server, which
+// are zip archives of the notes and the books of an account in JSON, so that
+// they can be loaded into the local database without the server
+package accountexport
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// FormatVersion is the latest version of the format of the exports that this
+// version of dnote can read
+const FormatVersion = 1
+
+// the names of the files in an export
+const (
+	metadataName = "metadata.json"
+	booksName    = "books.json"
+	notesName    = "notes.json"
+)
+
+// Metadata describes the account and the time of an export
+type Metadata struct {
+	FormatVersion int `json:"format_version"`
+	// UserUUID identifies the account
+	UserUUID string `json:"user_uuid"`
+	// MaxUSN is the usn of the latest change to the account at the export
+	MaxUSN int `json:"max_usn"`
+	// ExportedAt is the time of the server at the export in seconds since
+	// the epoch
+	ExportedAt int64 `json:"exported_at"`
+}
+
+// Book is a book in an export, in the form of a book in a sync fragment
+type Book struct {
+	UUID    string `json:"uuid"`
+	USN     int    `json:"usn"`
+	Label   string `json:"label"`
+	AddedOn int64  `json:"added_on"`
+	Deleted bool   `json:"deleted"`
+}
+
+// Note is a note in an export, in the form of a note in a sync fragment. The
+// timestamps are in nanoseconds since the epoch, and EditedOn is zero for a
+// note that has never been edited.
+type Note struct {
+	UUID     string `json:"uuid"`
+	BookUUID string `json:"book_uuid"`
+	USN      int    `json:"usn"`
+	AddedOn  int64  `json:"added_on"`
+	EditedOn int64  `json:"edited_on"`
+	Body     string `json:"content"`
+	Public   bool   `json:"public"`
+	Deleted  bool   `json:"deleted"`
+}
+
+// Export is the content of an account export
+type Export struct {
+	Metadata Metadata
+	Books    []Book
+	Notes    []Note
+}
+
+// readJSON decodes the file of the given name in the archive into v. It
+// returns false if the archive does not have the file.
+func readJSON(r *zip.Reader, name string, v interface{}) (bool, error) {
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return false, errors.Wrapf(err, "opening %s", name)
+		}
+		defer rc.Close()
+
+		if err := json.NewDecoder(rc).Decode(v); err != nil {
+			return false, errors.Wrapf(err, "decoding %s", name)
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// validate checks that the notes and the books of the export are consistent
+func (e Export) validate() error {
+	if e.Metadata.UserUUID == "" {
+		return errors.New("the export does not name its account")
+	}
+
+	books := map[string]bool{}
+	for _, b := range e.Books {
+		if b.UUID == "" {
+			return errors.New("a book has no uuid")
+		}
+		if b.USN > e.Metadata.MaxUSN {
+			return errors.Errorf("the book %s has the usn %d, after the max usn %d of the export", b.UUID, b.USN, e.Metadata.MaxUSN)
+		}
+
+		books[b.UUID] = true
+	}
+
+	for _, n := range e.Notes {
+		if n.UUID == "" {
+			return errors.New("a note has no uuid")
+		}
+		if n.USN > e.Metadata.MaxUSN {
+			return errors.Errorf("the note %s has the usn %d, after the max usn %d of the export", n.UUID, n.USN, e.Metadata.MaxUSN)
+		}
+		if !n.Deleted && !books[n.BookUUID] {
+			return errors.Errorf("the note %s is in the book %s, which is not in the export", n.UUID, n.BookUUID)
+		}
+	}
+
+	return nil
+}
+
+// Read reads an account export from r, which has the given size. It fails if
+// the export is written in a later format or is inconsistent.
+func Read(r io.ReaderAt, size int64) (Export, error) {
+	var ret Export
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return ret, errors.Wrap(err, "opening the archive")
+	}
+
+	ok, err := readJSON(zr, metadataName, &ret.Metadata)
+	if err != nil {
+		return ret, err
+	}
+	if !ok {
+		return ret, errors.Errorf("the archive has no %s, so it is not a dnote account export", metadataName)
+	}
+	if ret.Metadata.FormatVersion > FormatVersion {
+		return ret, errors.Errorf("the export is in the format %d, which is newer than this version of dnote supports (%d). Upgrade dnote", ret.Metadata.FormatVersion, FormatVersion)
+	}
+
+	ret.Books = []Book{}
+	if _, err := readJSON(zr, booksName, &ret.Books); err != nil {
+		return ret, err
+	}
+	ret.Notes = []Note{}
+	if _, err := readJSON(zr, notesName, &ret.Notes); err != nil {
+		return ret, err
+	}
+
+	if err := ret.validate(); err != nil {
+		return ret, errors.Wrap(err, "invalid export")
+	}
+
+	return ret, nil
+}
+
+// ReadFile reads the account export at the given path
+func ReadFile(path string) (Export, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Export{}, errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return Export{}, errors.Wrapf(err, "reading %s", path)
+	}
+
+	return Read(f, info.Size())
+}
@@ -0,0 +1,151 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package accountexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+// writeZip returns a zip archive of the files with the given names and contents
+func writeZip(t *testing.T, files map[string]string) *bytes.Reader {
+	var buf bytes.Buffer
+
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "creating %s", name))
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(errors.Wrapf(err, "writing %s", name))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the archive"))
+	}
+
+	return bytes.NewReader(buf.Bytes())
+}
+
+func readZip(t *testing.T, files map[string]string) (Export, error) {
+	r := writeZip(t, files)
+
+	return Read(r, r.Size())
+}
+
+const testMetadata = `{"format_version": 1, "user_uuid": "u1-uuid", "max_usn": 5, "exported_at": 1600000000}`
+
+func TestRead(t *testing.T) {
+	e, err := readZip(t, map[string]string{
+		"metadata.json": testMetadata,
+		"books.json":    `[{"uuid": "b1-uuid", "usn": 1, "label": "js"}, {"uuid": "b2-uuid", "usn": 4, "label": "", "deleted": true}]`,
+		"notes.json":    `[{"uuid": "n1-uuid", "book_uuid": "b1-uuid", "usn": 2, "added_on": 1, "edited_on": 2, "content": "n1 body", "public": true}, {"uuid": "n2-uuid", "book_uuid": "b2-uuid", "usn": 5, "deleted": true}]`,
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading"))
+	}
+
+	assert.DeepEqual(t, e, Export{
+		Metadata: Metadata{FormatVersion: 1, UserUUID: "u1-uuid", MaxUSN: 5, ExportedAt: 1600000000},
+		Books: []Book{
+			{UUID: "b1-uuid", USN: 1, Label: "js"},
+			{UUID: "b2-uuid", USN: 4, Deleted: true},
+		},
+		Notes: []Note{
+			{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 2, AddedOn: 1, EditedOn: 2, Body: "n1 body", Public: true},
+			{UUID: "n2-uuid", BookUUID: "b2-uuid", USN: 5, Deleted: true},
+		},
+	}, "export mismatch")
+}
+
+func TestRead_empty(t *testing.T) {
+	e, err := readZip(t, map[string]string{"metadata.json": testMetadata})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading"))
+	}
+
+	assert.DeepEqual(t, e.Books, []Book{}, "books mismatch")
+	assert.DeepEqual(t, e.Notes, []Note{}, "notes mismatch")
+}
+
+func TestRead_invalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{
+			name:     "no metadata",
+			files:    map[string]string{"books.json": `[]`},
+			expected: "not a dnote account export",
+		},
+		{
+			name:     "newer format",
+			files:    map[string]string{"metadata.json": `{"format_version": 2, "user_uuid": "u1-uuid"}`},
+			expected: "newer than this version of dnote supports",
+		},
+		{
+			name:     "no account",
+			files:    map[string]string{"metadata.json": `{"format_version": 1}`},
+			expected: "does not name its account",
+		},
+		{
+			name:     "malformed notes",
+			files:    map[string]string{"metadata.json": testMetadata, "notes.json": `{`},
+			expected: "decoding notes.json",
+		},
+		{
+			name: "usn after the max usn",
+			files: map[string]string{
+				"metadata.json": testMetadata,
+				"books.json":    `[{"uuid": "b1-uuid", "usn": 6, "label": "js"}]`,
+			},
+			expected: "after the max usn",
+		},
+		{
+			name: "note without its book",
+			files: map[string]string{
+				"metadata.json": testMetadata,
+				"notes.json":    `[{"uuid": "n1-uuid", "book_uuid": "b1-uuid", "usn": 1}]`,
+			},
+			expected: "not in the export",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readZip(t, tc.files)
+
+			assert.NotEqual(t, err, nil, "error mismatch")
+			assert.Equal(t, strings.Contains(err.Error(), tc.expected), true, "error message mismatch: "+err.Error())
+		})
+	}
+
+	t.Run("not a zip", func(t *testing.T) {
+		r := bytes.NewReader([]byte("not a zip"))
+		_, err := Read(r, r.Size())
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+	})
+}
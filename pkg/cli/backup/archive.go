@@ -0,0 +1,122 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package backup creates the backup archives of the dnote files and uploads
+// them to an S3-compatible bucket
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// archiveTimeFormat is the format of the time in the name of an archive,
+// which sorts the archives by the time they were created
+const archiveTimeFormat = "20060102T150405Z"
+
+// archiveNameRegex matches the name of an archive, which may be encrypted
+var archiveNameRegex = regexp.MustCompile(`^dnote-\d{8}T\d{6}Z\.tar\.gz(\.gpg)?$`)
+
+// ArchiveName returns the name of the archive created at the given time
+func ArchiveName(t time.Time) string {
+	return "dnote-" + t.UTC().Format(archiveTimeFormat) + ".tar.gz"
+}
+
+// IsArchiveName checks if the given name is that of an archive
+func IsArchiveName(name string) bool {
+	return archiveNameRegex.MatchString(name)
+}
+
+// addFile adds the file at the given path to the archive under the given name
+func addFile(tw *tar.Writer, path, name string, modTime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening the file")
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "getting the file info")
+	}
+
+	hdr := tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    fi.Size(),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(&hdr); err != nil {
+		return errors.Wrap(err, "writing the header")
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return errors.Wrap(err, "writing the content")
+	}
+
+	return nil
+}
+
+// WriteArchive writes a gzipped tar archive of a consistent snapshot of the
+// database and of the config file, if it exists. The snapshot is taken with
+// VACUUM INTO so that the writes in progress are either included or not.
+func WriteArchive(w io.Writer, db *database.DB, configPath string, now time.Time) error {
+	tmpDir, err := ioutil.TempDir("", "dnote-backup")
+	if err != nil {
+		return errors.Wrap(err, "creating a temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshotPath := filepath.Join(tmpDir, consts.DnoteDBFileName)
+	if _, err := db.Exec("VACUUM INTO ?", snapshotPath); err != nil {
+		return errors.Wrap(err, "taking a snapshot of the database")
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	if err := addFile(tw, snapshotPath, consts.DnoteDBFileName, now); err != nil {
+		return errors.Wrap(err, "adding the database")
+	}
+
+	if _, err := os.Stat(configPath); err == nil {
+		if err := addFile(tw, configPath, consts.ConfigFilename, now); err != nil {
+			return errors.Wrap(err, "adding the config file")
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "checking the config file")
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "closing the archive")
+	}
+	if err := gw.Close(); err != nil {
+		return errors.Wrap(err, "closing the compressed stream")
+	}
+
+	return nil
+}
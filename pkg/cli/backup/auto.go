@@ -0,0 +1,241 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+// autoSnapshotRegex matches the name of an automatic snapshot
+var autoSnapshotRegex = regexp.MustCompile(`^dnote-\d{8}T\d{6}Z\.db$`)
+
+// autoStateFilename is the name of the file that records the database files
+// as they were when the newest automatic snapshot was taken
+const autoStateFilename = "last.json"
+
+// AutoDir returns the directory of the automatic snapshots in the given cache
+// directory
+func AutoDir(cacheDir string) string {
+	return filepath.Join(cacheDir, consts.DnoteDirName, consts.AutoBackupDirName)
+}
+
+// AutoSnapshotName returns the name of the automatic snapshot taken at the
+// given time
+func AutoSnapshotName(t time.Time) string {
+	return "dnote-" + t.UTC().Format(archiveTimeFormat) + ".db"
+}
+
+// AutoSnapshotTime returns the time that the automatic snapshot at the given
+// path was taken
+func AutoSnapshotTime(path string) (time.Time, error) {
+	name := filepath.Base(path)
+	if !autoSnapshotRegex.MatchString(name) {
+		return time.Time{}, errors.Errorf("%s is not an automatic snapshot", name)
+	}
+
+	return time.Parse(archiveTimeFormat, name[len("dnote-"):len(name)-len(".db")])
+}
+
+// fileStamp is the size and the modification time of a file, which change
+// whenever SQLite writes to it. Both are zero if the file does not exist.
+type fileStamp struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mod_time"`
+}
+
+func getFileStamp(path string) (fileStamp, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fileStamp{}, nil
+	} else if err != nil {
+		return fileStamp{}, errors.Wrapf(err, "checking %s", path)
+	}
+
+	return fileStamp{Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}, nil
+}
+
+// autoState is the name of the newest automatic snapshot and the stamps of the
+// database file and of its write-ahead log when it was taken
+type autoState struct {
+	Snapshot string    `json:"snapshot"`
+	DB       fileStamp `json:"db"`
+	WAL      fileStamp `json:"wal"`
+}
+
+func readAutoState(dir string) (autoState, error) {
+	var ret autoState
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, autoStateFilename))
+	if os.IsNotExist(err) {
+		return ret, nil
+	} else if err != nil {
+		return ret, errors.Wrap(err, "reading the state")
+	}
+
+	// a corrupt state only costs a snapshot that could have been skipped
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return autoState{}, nil
+	}
+
+	return ret, nil
+}
+
+// getAutoState returns the stamps of the files of the database
+func getAutoState(db *database.DB) (autoState, error) {
+	dbStamp, err := getFileStamp(db.Filepath)
+	if err != nil {
+		return autoState{}, err
+	}
+	walStamp, err := getFileStamp(db.Filepath + "-wal")
+	if err != nil {
+		return autoState{}, err
+	}
+
+	return autoState{DB: dbStamp, WAL: walStamp}, nil
+}
+
+// ListAutoSnapshots returns the paths of the automatic snapshots in the given
+// directory from the oldest to the newest
+func ListAutoSnapshots(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading the directory")
+	}
+
+	ret := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && autoSnapshotRegex.MatchString(e.Name()) {
+			ret = append(ret, filepath.Join(dir, e.Name()))
+		}
+	}
+	// the names sort by the time
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+// LatestAutoSnapshot returns the path of the newest automatic snapshot in the
+// given directory, or an empty string if there is none
+func LatestAutoSnapshot(dir string) (string, error) {
+	paths, err := ListAutoSnapshots(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", nil
+	}
+
+	return paths[len(paths)-1], nil
+}
+
+// AutoSnapshot takes a snapshot of the database into the given directory and
+// deletes the oldest snapshots beyond the given number. It returns the path
+// of the snapshot, or an empty string if it is skipped because the database
+// files have not changed since the newest snapshot was taken, so that the
+// syncs that fail, such as when offline, do not rotate out the snapshots that
+// differ.
+func AutoSnapshot(db *database.DB, dir string, keep int, now time.Time) (string, error) {
+	if keep < 1 {
+		return "", errors.Errorf("invalid number of the snapshots to keep %d", keep)
+	}
+
+	state, err := getAutoState(db)
+	if err != nil {
+		return "", errors.Wrap(err, "checking the database files")
+	}
+	last, err := readAutoState(dir)
+	if err != nil {
+		return "", err
+	}
+	// a database that is not in a file, such as in memory, is always taken
+	if state.DB != (fileStamp{}) && last.Snapshot != "" && state.DB == last.DB && state.WAL == last.WAL {
+		if _, err := os.Stat(filepath.Join(dir, last.Snapshot)); err == nil {
+			return "", nil
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", errors.Wrap(err, "creating the directory")
+	}
+
+	name := AutoSnapshotName(now)
+	path := filepath.Join(dir, name)
+	// VACUUM INTO fails if the file exists, e.g. after a crash
+	tmpPath := filepath.Join(dir, "."+name)
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "removing a partial snapshot")
+	}
+	if _, err := db.Exec("VACUUM INTO ?", tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", errors.Wrap(err, "taking a snapshot of the database")
+	}
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		os.Remove(tmpPath)
+		return "", errors.Wrap(err, "setting the permission of the snapshot")
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", errors.Wrap(err, "moving the snapshot into place")
+	}
+
+	state.Snapshot = name
+	b, err := json.Marshal(state)
+	if err != nil {
+		return "", errors.Wrap(err, "encoding the state")
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(dir, autoStateFilename), b, 0600); err != nil {
+		return "", errors.Wrap(err, "writing the state")
+	}
+
+	if err := pruneAutoSnapshots(dir, keep); err != nil {
+		return "", errors.Wrap(err, "deleting the old snapshots")
+	}
+
+	return path, nil
+}
+
+// pruneAutoSnapshots deletes the oldest automatic snapshots in the given
+// directory beyond the given number
+func pruneAutoSnapshots(dir string, keep int) error {
+	paths, err := ListAutoSnapshots(dir)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(paths)-keep; i++ {
+		if err := os.Remove(paths[i]); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "deleting %s", paths[i])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func TestAutoSnapshotTime(t *testing.T) {
+	got, err := AutoSnapshotTime(filepath.Join("backups", AutoSnapshotName(time.Date(2021, 3, 15, 12, 30, 45, 0, time.UTC))))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "parsing the time"))
+	}
+	assert.Equal(t, got, time.Date(2021, 3, 15, 12, 30, 45, 0, time.UTC), "time mismatch")
+
+	_, err = AutoSnapshotTime("dnote-20210315T123045Z.tar.gz")
+	assert.NotEqual(t, err, nil, "an archive should not be taken for a snapshot")
+}
+
+func TestAutoSnapshot(t *testing.T) {
+	db := database.InitTestDB(t, "dnote.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(t, db)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+
+	dir := filepath.Join(t.TempDir(), "backups")
+	start := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	latest, err := LatestAutoSnapshot(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the latest snapshot of a missing directory"))
+	}
+	assert.Equal(t, latest, "", "latest snapshot mismatch without any")
+
+	snapshot := func(minute int) string {
+		path, err := AutoSnapshot(db, dir, 2, start.Add(time.Duration(minute)*time.Minute))
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "taking the snapshot at minute %d", minute))
+		}
+
+		return path
+	}
+	addNote := func(i int) {
+		database.MustExec(t, "inserting a note", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", fmt.Sprintf("n%d-uuid", i), "b1-uuid", "body", i)
+	}
+	countNotes := func(path string) int {
+		s, err := database.Open(path)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "opening the snapshot"))
+		}
+		defer s.Close()
+
+		var ret int
+		database.MustScan(t, "counting notes", s.QueryRow("SELECT count(*) FROM notes"), &ret)
+
+		return ret
+	}
+
+	// the oldest snapshots are rotated out
+	for i := 1; i <= 3; i++ {
+		addNote(i)
+		path := snapshot(i)
+		assert.Equal(t, path, filepath.Join(dir, AutoSnapshotName(start.Add(time.Duration(i)*time.Minute))), fmt.Sprintf("path mismatch for snapshot %d", i))
+	}
+
+	paths, err := ListAutoSnapshots(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "listing the snapshots"))
+	}
+	assert.DeepEqual(t, paths, []string{
+		filepath.Join(dir, "dnote-20210315T120200Z.db"),
+		filepath.Join(dir, "dnote-20210315T120300Z.db"),
+	}, "snapshots mismatch")
+	assert.Equal(t, countNotes(paths[0]), 2, "note count mismatch in the older snapshot")
+	assert.Equal(t, countNotes(paths[1]), 3, "note count mismatch in the newer snapshot")
+
+	// an unchanged database is not taken again
+	assert.Equal(t, snapshot(4), "", "the snapshot of an unchanged database should be skipped")
+
+	latest, err = LatestAutoSnapshot(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the latest snapshot"))
+	}
+	assert.Equal(t, latest, paths[1], "latest snapshot mismatch after a skip")
+
+	// unless the newest snapshot is gone
+	if err := os.Remove(paths[1]); err != nil {
+		t.Fatal(errors.Wrap(err, "removing the newest snapshot"))
+	}
+	assert.Equal(t, snapshot(5), filepath.Join(dir, "dnote-20210315T120500Z.db"), "the snapshot should be taken again once the newest is gone")
+
+	// a changed database is taken
+	addNote(6)
+	assert.Equal(t, snapshot(6), filepath.Join(dir, "dnote-20210315T120600Z.db"), "the snapshot of a changed database should be taken")
+
+	paths, err = ListAutoSnapshots(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "listing the snapshots"))
+	}
+	assert.DeepEqual(t, paths, []string{
+		filepath.Join(dir, "dnote-20210315T120500Z.db"),
+		filepath.Join(dir, "dnote-20210315T120600Z.db"),
+	}, "snapshots mismatch after the changes")
+
+	t.Run("invalid keep", func(t *testing.T) {
+		_, err := AutoSnapshot(db, dir, 0, start)
+		assert.NotEqual(t, err, nil, "keeping no snapshot should fail")
+	})
+}
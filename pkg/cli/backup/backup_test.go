@@ -0,0 +1,240 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	stdCtx "context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// memUploader is an Uploader that keeps the objects in memory
+type memUploader struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemUploader(keys ...string) *memUploader {
+	u := &memUploader{objects: map[string][]byte{}}
+	for _, key := range keys {
+		u.objects[key] = []byte(key)
+	}
+
+	return u
+}
+
+func (u *memUploader) Put(ctx stdCtx.Context, key string, r io.ReadSeeker) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.objects[key] = b
+
+	return nil
+}
+
+func (u *memUploader) List(ctx stdCtx.Context, prefix string) ([]string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	ret := []string{}
+	for key := range u.objects {
+		if strings.HasPrefix(key, prefix) {
+			ret = append(ret, key)
+		}
+	}
+
+	return ret, nil
+}
+
+func (u *memUploader) Delete(ctx stdCtx.Context, key string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.objects, key)
+
+	return nil
+}
+
+func (u *memUploader) keys() []string {
+	ret, _ := u.List(stdCtx.Background(), "")
+	sort.Strings(ret)
+
+	return ret
+}
+
+func TestArchiveName(t *testing.T) {
+	name := ArchiveName(time.Date(2026, time.October, 16, 9, 5, 3, 0, time.UTC))
+
+	assert.Equal(t, name, "dnote-20261016T090503Z.tar.gz", "name mismatch")
+	assert.Equal(t, IsArchiveName(name), true, "the name is not an archive name")
+	assert.Equal(t, IsArchiveName(name+".gpg"), true, "an encrypted name is not an archive name")
+	assert.Equal(t, IsArchiveName("dnote.db"), false, "dnote.db is an archive name")
+	assert.Equal(t, IsArchiveName("dnote-20261016T090503Z.tar.gz.tmp"), false, "a temporary name is an archive name")
+}
+
+func TestWriteArchive(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, "dnote.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(t, db)
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743)
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "dnoterc")
+	if err := ioutil.WriteFile(configPath, []byte("editor: vim\n"), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the config file"))
+	}
+
+	// execute
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, db, configPath, time.Now()); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the compressed stream"))
+	}
+	tr := tar.NewReader(gr)
+
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(errors.Wrap(err, "reading the archive"))
+		}
+
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading a file"))
+		}
+		files[hdr.Name] = b
+	}
+
+	assert.Equal(t, len(files), 2, "file count mismatch")
+	assert.Equal(t, string(files["dnoterc"]), "editor: vim\n", "config mismatch")
+
+	snapshotPath := filepath.Join(t.TempDir(), "dnote.db")
+	if err := ioutil.WriteFile(snapshotPath, files["dnote.db"], 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the snapshot"))
+	}
+	snapshot, err := database.Open(snapshotPath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the snapshot"))
+	}
+	defer snapshot.Close()
+
+	var body string
+	database.MustScan(t, "getting n1", snapshot.QueryRow("SELECT body FROM notes WHERE uuid = ?", "n1-uuid"), &body)
+	assert.Equal(t, body, "n1 body", "body mismatch")
+
+	t.Run("without a config file", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteArchive(&buf, db, filepath.Join(dir, "nonexistent"), time.Now()); err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+	})
+}
+
+func TestPrune(t *testing.T) {
+	testCases := []struct {
+		name     string
+		prefix   string
+		keys     []string
+		keep     int
+		expected []string
+		deleted  []string
+	}{
+		{
+			name:   "more than kept",
+			prefix: "dnote",
+			keys: []string{
+				"dnote/dnote-20261013T000000Z.tar.gz",
+				"dnote/dnote-20261016T000000Z.tar.gz",
+				"dnote/dnote-20261014T000000Z.tar.gz",
+				"dnote/dnote-20261015T000000Z.tar.gz",
+				"dnote/notes.txt",
+				"dnote/old/dnote-20201013T000000Z.tar.gz",
+				"dnote-old/dnote-20201013T000000Z.tar.gz",
+			},
+			keep: 2,
+			expected: []string{
+				"dnote-old/dnote-20201013T000000Z.tar.gz",
+				"dnote/dnote-20261015T000000Z.tar.gz",
+				"dnote/dnote-20261016T000000Z.tar.gz",
+				"dnote/notes.txt",
+				"dnote/old/dnote-20201013T000000Z.tar.gz",
+			},
+			deleted: []string{
+				"dnote/dnote-20261013T000000Z.tar.gz",
+				"dnote/dnote-20261014T000000Z.tar.gz",
+			},
+		},
+		{
+			name:     "fewer than kept",
+			prefix:   "dnote",
+			keys:     []string{"dnote/dnote-20261013T000000Z.tar.gz"},
+			keep:     2,
+			expected: []string{"dnote/dnote-20261013T000000Z.tar.gz"},
+			deleted:  nil,
+		},
+		{
+			name:     "no prefix",
+			prefix:   "",
+			keys:     []string{"dnote-20261013T000000Z.tar.gz", "dnote-20261014T000000Z.tar.gz", "dnote/dnote-20261012T000000Z.tar.gz"},
+			keep:     1,
+			expected: []string{"dnote-20261014T000000Z.tar.gz", "dnote/dnote-20261012T000000Z.tar.gz"},
+			deleted:  []string{"dnote-20261013T000000Z.tar.gz"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := newMemUploader(tc.keys...)
+
+			deleted, err := Prune(stdCtx.Background(), u, tc.prefix, tc.keep)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.DeepEqual(t, deleted, tc.deleted, "deleted mismatch")
+			assert.DeepEqual(t, u.keys(), tc.expected, "keys mismatch")
+		})
+	}
+}
@@ -0,0 +1,170 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/gpg"
+	"github.com/pkg/errors"
+)
+
+// gzipMagic is the header of a gzip stream, by which an archive is told apart
+// from a snapshot of the database
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ExtractDatabase writes the database in the archive read from r to w
+func ExtractDatabase(r io.Reader, w io.Writer) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "reading the compressed stream")
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return errors.Errorf("no %s in the archive", consts.DnoteDBFileName)
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading the archive")
+		}
+		if hdr.Name != consts.DnoteDBFileName {
+			continue
+		}
+
+		if _, err := io.Copy(w, tr); err != nil {
+			return errors.Wrap(err, "extracting the database")
+		}
+
+		return nil
+	}
+}
+
+// extractFile writes the database in the archive at the given path into a new
+// file in dir, and returns the path of the file
+func extractFile(path, dir string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+
+	ret := filepath.Join(dir, consts.DnoteDBFileName)
+	out, err := os.OpenFile(ret, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "creating the database file")
+	}
+	defer out.Close()
+
+	if err := ExtractDatabase(f, out); err != nil {
+		return "", errors.Wrapf(err, "extracting %s", path)
+	}
+
+	return ret, errors.Wrap(out.Close(), "closing the database file")
+}
+
+// decryptFile decrypts the file at the given path with gpg into a new file in
+// dir, and returns the path of the file
+func decryptFile(path, dir string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+
+	ret := filepath.Join(dir, filepath.Base(path[:len(path)-len(gpg.Ext)]))
+	out, err := os.OpenFile(ret, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "creating the decrypted file")
+	}
+	defer out.Close()
+
+	if err := gpg.Decrypt(f, out); err != nil {
+		return "", errors.Wrapf(err, "decrypting %s", path)
+	}
+
+	return ret, errors.Wrap(out.Close(), "closing the decrypted file")
+}
+
+// isArchive checks if the file at the given path is compressed as an archive
+// is, rather than being a snapshot of the database
+func isArchive(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+
+	b, err := bufio.NewReader(f).Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return false, errors.Wrapf(err, "reading %s", path)
+	}
+
+	return bytes.Equal(b, gzipMagic), nil
+}
+
+// OpenDatabase opens the database in the backup archive at the given path
+// read-only. The path may also be that of an automatic snapshot, which is a
+// database itself. An archive is extracted into dir, after being decrypted
+// with gpg if it is encrypted, and the caller removes dir after closing the
+// database.
+func OpenDatabase(path, dir string) (*database.DB, error) {
+	src := path
+
+	var err error
+	if gpg.HasExt(path) {
+		if path, err = decryptFile(path, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	ok, err := isArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if path, err = extractFile(path, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := database.OpenReadOnly(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", src)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name IN ('books', 'notes')").Scan(&count)
+	if err != nil || count != 2 {
+		db.Close()
+		return nil, errors.Errorf("%s is not a backup archive or a snapshot of dnote", src)
+	}
+
+	return db, nil
+}
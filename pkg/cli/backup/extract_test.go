@@ -0,0 +1,94 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func TestOpenDatabase(t *testing.T) {
+	db := database.InitTestDB(t, "dnote.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(t, db)
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743)
+
+	dir := t.TempDir()
+
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, db, filepath.Join(dir, "nonexistent"), time.Now()); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the archive"))
+	}
+	archivePath := filepath.Join(dir, ArchiveName(time.Now()))
+	if err := ioutil.WriteFile(archivePath, buf.Bytes(), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the archive file"))
+	}
+
+	snapshotPath, err := AutoSnapshot(db, filepath.Join(dir, "auto"), 1, time.Now())
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "taking a snapshot"))
+	}
+
+	for _, path := range []string{archivePath, snapshotPath} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			src, err := OpenDatabase(path, t.TempDir())
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "opening the database"))
+			}
+			defer src.Close()
+
+			var body string
+			database.MustScan(t, "getting n1", src.QueryRow("SELECT body FROM notes WHERE uuid = ?", "n1-uuid"), &body)
+			assert.Equal(t, body, "n1 body", "body mismatch")
+		})
+	}
+
+	t.Run("not a backup", func(t *testing.T) {
+		path := filepath.Join(dir, "notes.txt")
+		if err := ioutil.WriteFile(path, []byte("n1 body\n"), 0600); err != nil {
+			t.Fatal(errors.Wrap(err, "writing the file"))
+		}
+
+		_, err := OpenDatabase(path, t.TempDir())
+		assert.NotEqual(t, err, nil, "error mismatch")
+	})
+
+	t.Run("truncated archive", func(t *testing.T) {
+		path := filepath.Join(dir, "truncated.tar.gz")
+		if err := ioutil.WriteFile(path, buf.Bytes()[:10], 0600); err != nil {
+			t.Fatal(errors.Wrap(err, "writing the file"))
+		}
+
+		_, err := OpenDatabase(path, t.TempDir())
+		if err == nil {
+			t.Fatal("error should have been returned")
+		}
+		assert.Equal(t, strings.Contains(err.Error(), "extracting"), true, fmt.Sprintf("error message mismatch: %s", err))
+	})
+}
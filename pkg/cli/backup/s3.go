@@ -0,0 +1,438 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	stdCtx "context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3Config holds the location of a bucket and the credentials to access it
+type S3Config struct {
+	// Endpoint is the URL of the S3-compatible service, such as MinIO or B2.
+	// It defaults to AWS in the region.
+	Endpoint        string `yaml:"endpoint,omitempty"`
+	Region          string `yaml:"region,omitempty"`
+	AccessKeyID     string `yaml:"accessKeyID,omitempty"`
+	SecretAccessKey string `yaml:"secretAccessKey,omitempty"`
+	SessionToken    string `yaml:"-"`
+}
+
+// defaultRegion is the region used if none is configured
+const defaultRegion = "us-east-1"
+
+// ParseS3URL parses a URL in the form of s3://bucket/prefix
+func ParseS3URL(s string) (string, string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", errors.Wrap(err, "parsing the URL")
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", errors.Errorf("invalid S3 URL '%s'. Use s3://bucket/prefix", s)
+	}
+
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// readINI reads the key-value pairs in the given section of a file in the
+// format of the AWS shared config and credentials files
+func readINI(path, section string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+
+	ret := map[string]string{}
+	var current string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		if current != section {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		ret[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+
+	return ret, nil
+}
+
+// ResolveS3Config fills in the settings missing in the config file from the
+// standard AWS environment variables and the shared credentials and config
+// files in the given home directory
+func ResolveS3Config(c S3Config, getenv func(string) string, homeDir string) (S3Config, error) {
+	profile := getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	if c.AccessKeyID == "" && c.SecretAccessKey == "" {
+		c.AccessKeyID = getenv("AWS_ACCESS_KEY_ID")
+		c.SecretAccessKey = getenv("AWS_SECRET_ACCESS_KEY")
+		c.SessionToken = getenv("AWS_SESSION_TOKEN")
+	}
+	if c.AccessKeyID == "" && c.SecretAccessKey == "" {
+		path := getenv("AWS_SHARED_CREDENTIALS_FILE")
+		if path == "" {
+			path = filepath.Join(homeDir, ".aws", "credentials")
+		}
+
+		creds, err := readINI(path, profile)
+		if err != nil {
+			return c, errors.Wrap(err, "reading the shared credentials file")
+		}
+
+		c.AccessKeyID = creds["aws_access_key_id"]
+		c.SecretAccessKey = creds["aws_secret_access_key"]
+		c.SessionToken = creds["aws_session_token"]
+	}
+
+	if c.Region == "" {
+		c.Region = getenv("AWS_REGION")
+	}
+	if c.Region == "" {
+		c.Region = getenv("AWS_DEFAULT_REGION")
+	}
+	if c.Region == "" {
+		path := getenv("AWS_CONFIG_FILE")
+		if path == "" {
+			path = filepath.Join(homeDir, ".aws", "config")
+		}
+
+		section := "profile " + profile
+		if profile == "default" {
+			section = profile
+		}
+
+		conf, err := readINI(path, section)
+		if err != nil {
+			return c, errors.Wrap(err, "reading the shared config file")
+		}
+
+		c.Region = conf["region"]
+	}
+	if c.Region == "" {
+		c.Region = defaultRegion
+	}
+
+	if c.Endpoint == "" {
+		c.Endpoint = getenv("AWS_ENDPOINT_URL_S3")
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = getenv("AWS_ENDPOINT_URL")
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.Region)
+	}
+
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return c, errors.New("no S3 credentials found. Set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or backup.s3.accessKeyID and backup.s3.secretAccessKey in the config file")
+	}
+
+	return c, nil
+}
+
+// S3 uploads the archives to a bucket using the path-style requests signed
+// with the AWS Signature Version 4, which S3-compatible services also accept
+type S3 struct {
+	Config     S3Config
+	Bucket     string
+	HTTPClient *http.Client
+	// now returns the time at which a request is signed
+	now func() time.Time
+}
+
+// NewS3 returns a new S3 uploader for the given bucket
+func NewS3(c S3Config, bucket string) *S3 {
+	return &S3{
+		Config:     c,
+		Bucket:     bucket,
+		HTTPClient: &http.Client{Timeout: 10 * time.Minute},
+		now:        time.Now,
+	}
+}
+
+// escapePath escapes each segment of a path as S3 expects in the canonical request
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign signs the request with the AWS Signature Version 4. The host and the
+// x-amz-*, content-md5, content-type and range headers are signed.
+func sign(req *http.Request, c S3Config, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", c.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-md5" || name == "content-type" || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// the query values are escaped in the same way as the path segments
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	params := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, escapePath(k)+"="+escapePath(v))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, c.Region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// s3Error is an error response from S3
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends a signed request for the given key and query, and returns the
+// response if it succeeded
+func (s *S3) do(ctx stdCtx.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.Config.Endpoint, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing the endpoint")
+	}
+	u.Path = "/" + s.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing the request")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	sign(req, s.Config, hashHex(body), s.now())
+
+	res, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "sending the request")
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+
+		b, _ := ioutil.ReadAll(res.Body)
+		var e s3Error
+		if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+			return nil, errors.Errorf("%s %s: %s: %s", method, u.Path, e.Code, e.Message)
+		}
+
+		return nil, errors.Errorf("%s %s: status %d", method, u.Path, res.StatusCode)
+	}
+
+	return res, nil
+}
+
+// Put uploads the content with its MD5 digest so that the service rejects a
+// corrupt upload instead of storing it. A PUT never leaves a partial object,
+// and the ETag is checked as well where it is the MD5 digest.
+func (s *S3) Put(ctx stdCtx.Context, key string, r io.ReadSeeker) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "rewinding the content")
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "reading the content")
+	}
+
+	digest := md5.Sum(body)
+
+	header := http.Header{}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(digest[:]))
+	header.Set("Content-Type", "application/gzip")
+
+	res, err := s.do(ctx, http.MethodPut, key, nil, header, body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	etag := strings.Trim(res.Header.Get("ETag"), `"`)
+	if len(etag) == 32 && etag != hex.EncodeToString(digest[:]) {
+		if err := s.Delete(ctx, key); err != nil {
+			return errors.Wrapf(err, "deleting the corrupt object after the ETag %s did not match", etag)
+		}
+
+		return errors.Errorf("the ETag %s does not match the content", etag)
+	}
+
+	return nil
+}
+
+// listResult is the response of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the keys of the objects whose key begins with the prefix
+func (s *S3) List(ctx stdCtx.Context, prefix string) ([]string, error) {
+	ret := []string{}
+
+	var token string
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding the list")
+		}
+
+		for _, c := range result.Contents {
+			ret = append(ret, c.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return ret, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Delete deletes the object under the given key
+func (s *S3) Delete(ctx stdCtx.Context, key string) error {
+	res, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
@@ -0,0 +1,96 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	stdCtx "context"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Uploader stores the archives in a bucket
+type Uploader interface {
+	// Put uploads the content read from r under the given key. A failed
+	// upload must not leave an object under the key.
+	Put(ctx stdCtx.Context, key string, r io.ReadSeeker) error
+	// List returns the keys of the objects whose key begins with the prefix
+	List(ctx stdCtx.Context, prefix string) ([]string, error)
+	// Delete deletes the object under the given key
+	Delete(ctx stdCtx.Context, key string) error
+}
+
+// ArchiveKey returns the key of the archive with the given name under the prefix
+func ArchiveKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return path.Join(prefix, name)
+}
+
+// Upload uploads the archive with the given name under the prefix
+func Upload(ctx stdCtx.Context, u Uploader, prefix, name string, r io.ReadSeeker) (string, error) {
+	key := ArchiveKey(prefix, name)
+	if err := u.Put(ctx, key, r); err != nil {
+		return "", errors.Wrapf(err, "uploading %s", key)
+	}
+
+	return key, nil
+}
+
+// Prune deletes the archives under the prefix except the given number of the
+// most recent ones, and returns the deleted keys. The objects that are not
+// archives are left alone.
+func Prune(ctx stdCtx.Context, u Uploader, prefix string, keep int) ([]string, error) {
+	listPrefix := prefix
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+
+	keys, err := u.List(ctx, listPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing the archives")
+	}
+
+	archives := []string{}
+	for _, key := range keys {
+		dir, name := path.Split(key)
+		if dir == listPrefix && IsArchiveName(name) {
+			archives = append(archives, key)
+		}
+	}
+	if len(archives) <= keep {
+		return nil, nil
+	}
+
+	// the names sort by the time the archives were created
+	sort.Strings(archives)
+
+	ret := archives[:len(archives)-keep]
+	for _, key := range ret {
+		if err := u.Delete(ctx, key); err != nil {
+			return nil, errors.Wrapf(err, "deleting %s", key)
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,196 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package bookarchive reads and writes the archives of a single book, which
+// carry the book from one installation of dnote to another
+package bookarchive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Ext is the extension of the name of an archive
+const Ext = ".dnotebook"
+
+// FormatVersion is the version of the format of the archives written by this
+// version of dnote. It is increased by the changes that the earlier versions
+// cannot read.
+const FormatVersion = 1
+
+// the names of the files in an archive
+const (
+	manifestName = "manifest.json"
+	notesName    = "notes.json"
+)
+
+// Manifest describes the book in an archive
+type Manifest struct {
+	FormatVersion int    `json:"format_version"`
+	Book          string `json:"book"`
+	NoteCount     int    `json:"note_count"`
+	// ExportedAt is the time of the export in nanoseconds since the epoch
+	ExportedAt int64 `json:"exported_at"`
+	// DnoteVersion is the version of dnote that wrote the archive
+	DnoteVersion string `json:"dnote_version"`
+}
+
+// Note is a note in an archive. The timestamps are in nanoseconds since the
+// epoch, as in the database, and EditedOn is zero for a note that has never
+// been edited.
+type Note struct {
+	UUID     string `json:"uuid"`
+	Body     string `json:"body"`
+	AddedOn  int64  `json:"added_on"`
+	EditedOn int64  `json:"edited_on"`
+}
+
+// Archive is a book and its notes
+type Archive struct {
+	Manifest Manifest
+	Notes    []Note
+}
+
+// addJSON adds the value encoded as JSON to the archive under the given name
+func addJSON(tw *tar.Writer, name string, v interface{}, modTime time.Time) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding")
+	}
+
+	hdr := tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(b)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(&hdr); err != nil {
+		return errors.Wrap(err, "writing the header")
+	}
+	if _, err := tw.Write(b); err != nil {
+		return errors.Wrap(err, "writing the content")
+	}
+
+	return nil
+}
+
+// Write writes the archive as a gzipped tar archive of the manifest and the
+// notes. The format version and the note count of the manifest are set from
+// the archive.
+func Write(w io.Writer, a Archive) error {
+	m := a.Manifest
+	m.FormatVersion = FormatVersion
+	m.NoteCount = len(a.Notes)
+
+	notes := a.Notes
+	if notes == nil {
+		notes = []Note{}
+	}
+
+	modTime := time.Unix(0, m.ExportedAt)
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	if err := addJSON(tw, manifestName, m, modTime); err != nil {
+		return errors.Wrap(err, "adding the manifest")
+	}
+	if err := addJSON(tw, notesName, notes, modTime); err != nil {
+		return errors.Wrap(err, "adding the notes")
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "closing the archive")
+	}
+	if err := gw.Close(); err != nil {
+		return errors.Wrap(err, "closing the compressed stream")
+	}
+
+	return nil
+}
+
+// Read reads an archive written by Write. It fails for an archive written by
+// a newer version of dnote in a format that this one does not know.
+func Read(r io.Reader) (Archive, error) {
+	var ret Archive
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return ret, errors.Wrap(err, "not a book archive")
+	}
+	defer gr.Close()
+
+	var hasManifest, hasNotes bool
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ret, errors.Wrap(err, "reading the archive")
+		}
+
+		switch hdr.Name {
+		case manifestName:
+			if err := decodeJSON(tr, &ret.Manifest); err != nil {
+				return ret, errors.Wrap(err, "reading the manifest")
+			}
+			hasManifest = true
+		case notesName:
+			if err := decodeJSON(tr, &ret.Notes); err != nil {
+				return ret, errors.Wrap(err, "reading the notes")
+			}
+			hasNotes = true
+		}
+	}
+
+	if !hasManifest {
+		return ret, errors.New("the archive has no manifest")
+	}
+	if ret.Manifest.FormatVersion > FormatVersion {
+		return ret, errors.Errorf("the archive is in the format version %d, which is newer than this version of dnote can read. Upgrade dnote to import it", ret.Manifest.FormatVersion)
+	}
+	if !hasNotes {
+		return ret, errors.New("the archive has no notes")
+	}
+	if len(ret.Notes) != ret.Manifest.NoteCount {
+		return ret, errors.Errorf("the archive has %d notes but its manifest lists %d. It may be truncated", len(ret.Notes), ret.Manifest.NoteCount)
+	}
+
+	return ret, nil
+}
+
+func decodeJSON(r io.Reader, v interface{}) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "reading")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.Wrap(err, "decoding")
+	}
+
+	return nil
+}
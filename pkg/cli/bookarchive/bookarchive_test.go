@@ -0,0 +1,135 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bookarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func TestWriteRead(t *testing.T) {
+	testCases := []struct {
+		name  string
+		notes []Note
+	}{
+		{
+			name: "notes",
+			notes: []Note{
+				{UUID: "a3a82d4c-06a6-4a5e-a1d3-f6e7e6b5d1b1", Body: "kubectl get pods", AddedOn: 1541108743000000000},
+				{UUID: "b5f3c7f1-93a2-4c2b-8c35-3d1e8a2f6f0e", Body: "line 1\n\tline 2 with \"quotes\"", AddedOn: 1541108744000000000, EditedOn: 1541108745000000000},
+			},
+		},
+		{
+			name:  "empty book",
+			notes: []Note{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			m := Manifest{Book: "kubernetes", ExportedAt: 1541108746000000000, DnoteVersion: "0.15.0"}
+			if err := Write(&buf, Archive{Manifest: m, Notes: tc.notes}); err != nil {
+				t.Fatal(errors.Wrap(err, "writing"))
+			}
+
+			got, err := Read(&buf)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "reading"))
+			}
+
+			m.FormatVersion = FormatVersion
+			m.NoteCount = len(tc.notes)
+			assert.Equal(t, got.Manifest, m, "manifest mismatch")
+			assert.DeepEqual(t, got.Notes, tc.notes, "notes mismatch")
+		})
+	}
+}
+
+// writeRaw writes a gzipped tar archive of the given files
+func writeRaw(t *testing.T, files map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content))}); err != nil {
+			t.Fatal(errors.Wrap(err, "writing the header"))
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(errors.Wrap(err, "writing the content"))
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the archive"))
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the compressed stream"))
+	}
+
+	return &buf
+}
+
+func TestRead_invalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{
+			name:     "no manifest",
+			files:    map[string]string{notesName: `[]`},
+			expected: "no manifest",
+		},
+		{
+			name:     "newer format",
+			files:    map[string]string{manifestName: `{"format_version": 2, "book": "js", "note_count": 0}`, notesName: `[]`},
+			expected: "Upgrade dnote",
+		},
+		{
+			name:     "truncated",
+			files:    map[string]string{manifestName: `{"format_version": 1, "book": "js", "note_count": 2}`, notesName: `[{"uuid": "n1", "body": "n1 body"}]`},
+			expected: "truncated",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Read(writeRaw(t, tc.files))
+
+			if err == nil {
+				t.Fatal("error should have been returned")
+			}
+			assert.Equal(t, strings.Contains(err.Error(), tc.expected), true, "error mismatch: "+err.Error())
+		})
+	}
+
+	t.Run("not an archive", func(t *testing.T) {
+		_, err := Read(strings.NewReader("# kubernetes\n"))
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+	})
+}
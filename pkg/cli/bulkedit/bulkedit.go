@@ -0,0 +1,218 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package bulkedit writes the notes of a book into a single text, in which
+// every note follows a marker line carrying its uuid, and reads the edited
+// text back into the changes to the notes. It does not change any note
+// itself; the changes are applied by the caller.
+package bulkedit
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// newMarker is the marker line under which a new note is added
+const newMarker = "=== dnote: new note ==="
+
+// markerRegex matches a valid marker line
+var markerRegex = regexp.MustCompile(`^=== dnote: (?:note ([0-9A-Za-z-]+)|new note) ===$`)
+
+// markerLikeRegex matches the lines that are taken for a marker, so that a
+// marker mangled by the user is reported rather than read as part of a note
+var markerLikeRegex = regexp.MustCompile(`^[^0-9A-Za-z]*dnote\s*:`)
+
+// Note is a note of the book
+type Note struct {
+	UUID string
+	Body string
+}
+
+// Section is the text under a marker line
+type Section struct {
+	// UUID is the uuid of the note, or empty for a new note
+	UUID string
+	Body string
+	// Line is the line number of the marker
+	Line int
+}
+
+// SyntaxError is an error in the edited text
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Changes are the changes to the notes of a book made in the edited text
+type Changes struct {
+	// Updated are the notes whose bodies changed, with their new bodies
+	Updated []Note
+	// Added are the bodies of the new notes in the order of the text
+	Added []string
+	// Removed are the uuids of the removed notes
+	Removed []string
+}
+
+// IsZero returns true if nothing changed
+func (c Changes) IsZero() bool {
+	return len(c.Updated) == 0 && len(c.Added) == 0 && len(c.Removed) == 0
+}
+
+// trimBody removes the blank lines at the end of a body, which separate the
+// sections of the text
+func trimBody(body string) string {
+	return strings.TrimRight(body, " \t\n")
+}
+
+// isEscaped reports whether the line of a body is escaped so that it is not
+// taken for a marker
+func isEscaped(line string) bool {
+	return markerLikeRegex.MatchString(strings.TrimLeft(line, `\`))
+}
+
+// Format writes the notes of the book with the given label into a text to be
+// edited
+func Format(label string, notes []Note) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# The notes of the book '%s', each under its marker line.\n", label)
+	b.WriteString("# Change the text under a marker to edit the note, and remove a marker\n")
+	b.WriteString("# along with its text to remove the note. Add a note under a new marker:\n")
+	fmt.Fprintf(&b, "# %s\n", newMarker)
+	b.WriteString("# The lines starting with # above the first marker are ignored.\n")
+
+	for _, n := range notes {
+		fmt.Fprintf(&b, "\n=== dnote: note %s ===\n", n.UUID)
+
+		body := trimBody(n.Body)
+		if body == "" {
+			continue
+		}
+		for _, line := range strings.Split(body, "\n") {
+			if isEscaped(line) {
+				line = `\` + line
+			}
+
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+
+	return b.String()
+}
+
+// Parse reads the sections of an edited text. A line that looks like a marker
+// but is not a valid one is an error, as is any text other than a comment
+// above the first marker.
+func Parse(text string) ([]Section, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	ret := []Section{}
+	var cur *Section
+	var body []string
+
+	flush := func() {
+		if cur != nil {
+			cur.Body = trimBody(strings.Join(body, "\n"))
+			ret = append(ret, *cur)
+		}
+	}
+
+	for i, line := range lines {
+		num := i + 1
+
+		if cur == nil && strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if isEscaped(line) && strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		} else if markerLikeRegex.MatchString(line) {
+			m := markerRegex.FindStringSubmatch(strings.TrimRight(line, " \t"))
+			if m == nil {
+				return nil, &SyntaxError{Line: num, Msg: fmt.Sprintf("invalid marker '%s'. A marker is '=== dnote: note <uuid> ===' or '%s'", line, newMarker)}
+			}
+
+			flush()
+			cur = &Section{UUID: m[1], Line: num}
+			body = nil
+			continue
+		}
+
+		if cur == nil {
+			if strings.TrimSpace(line) != "" {
+				return nil, &SyntaxError{Line: num, Msg: "text above the first marker. Add a marker above it for a new note"}
+			}
+			continue
+		}
+
+		body = append(body, line)
+	}
+	flush()
+
+	return ret, nil
+}
+
+// Diff returns the changes to the notes made in the sections. A section of a
+// note that is not one of the notes, or a second section of the same note, is
+// an error. A note whose section is removed or emptied is removed, and an
+// empty section of a new note is ignored.
+func Diff(notes []Note, sections []Section) (Changes, error) {
+	var ret Changes
+
+	// seen are the notes with a section, which is true if it is not empty
+	seen := map[string]bool{}
+	bodies := map[string]string{}
+	for _, n := range notes {
+		bodies[n.UUID] = n.Body
+	}
+
+	for _, s := range sections {
+		if s.UUID == "" {
+			if s.Body != "" {
+				ret.Added = append(ret.Added, s.Body)
+			}
+			continue
+		}
+
+		old, ok := bodies[s.UUID]
+		if !ok {
+			return Changes{}, &SyntaxError{Line: s.Line, Msg: fmt.Sprintf("unknown note %s. Use '%s' for a new note", s.UUID, newMarker)}
+		}
+		if _, ok := seen[s.UUID]; ok {
+			return Changes{}, &SyntaxError{Line: s.Line, Msg: fmt.Sprintf("the note %s appears more than once", s.UUID)}
+		}
+		seen[s.UUID] = s.Body != ""
+
+		if s.Body != "" && s.Body != trimBody(old) {
+			ret.Updated = append(ret.Updated, Note{UUID: s.UUID, Body: s.Body})
+		}
+	}
+
+	for _, n := range notes {
+		if !seen[n.UUID] {
+			ret.Removed = append(ret.Removed, n.UUID)
+		}
+	}
+
+	return ret, nil
+}
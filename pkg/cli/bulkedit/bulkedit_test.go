@@ -0,0 +1,173 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package bulkedit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+var testNotes = []Note{
+	{UUID: "n1-uuid", Body: "Closures\n- capture the scope\n"},
+	{UUID: "n2-uuid", Body: "Promises chain"},
+	{UUID: "n3-uuid", Body: "=== dnote: note n1-uuid ===\nis not a marker\n\\dnote: nor this"},
+}
+
+// edit formats the test notes and applies the edit to the text
+func edit(t *testing.T, f func(string) string) (Changes, error) {
+	sections, err := Parse(f(Format("js", testNotes)))
+	if err != nil {
+		return Changes{}, err
+	}
+
+	return Diff(testNotes, sections)
+}
+
+func TestRoundTrip(t *testing.T) {
+	text := Format("js", testNotes)
+
+	sections, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.DeepEqual(t, sections, []Section{
+		{UUID: "n1-uuid", Body: "Closures\n- capture the scope", Line: 7},
+		{UUID: "n2-uuid", Body: "Promises chain", Line: 11},
+		{UUID: "n3-uuid", Body: "=== dnote: note n1-uuid ===\nis not a marker\n\\dnote: nor this", Line: 14},
+	}, "sections mismatch")
+
+	c, err := Diff(testNotes, sections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, c.IsZero(), true, "changes mismatch")
+}
+
+func TestRoundTrip_changes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		edit     func(string) string
+		expected Changes
+	}{
+		{
+			name: "edit",
+			edit: func(s string) string {
+				return strings.Replace(s, "Promises chain", "Promises chain\nand resolve once", 1)
+			},
+			expected: Changes{Updated: []Note{{UUID: "n2-uuid", Body: "Promises chain\nand resolve once"}}},
+		},
+		{
+			name: "delete",
+			edit: func(s string) string {
+				return strings.Replace(s, "=== dnote: note n2-uuid ===\nPromises chain\n", "", 1)
+			},
+			expected: Changes{Removed: []string{"n2-uuid"}},
+		},
+		{
+			name: "empty",
+			edit: func(s string) string {
+				return strings.Replace(s, "Promises chain\n", "\n", 1)
+			},
+			expected: Changes{Removed: []string{"n2-uuid"}},
+		},
+		{
+			name: "add",
+			edit: func(s string) string {
+				s = strings.Replace(s, "\n=== dnote: note n2-uuid", "\n=== dnote: new note ===\nHoisting\n\n=== dnote: note n2-uuid", 1)
+				return s + "=== dnote: new note ===\nEvent loop\n=== dnote: new note ===\n\n"
+			},
+			expected: Changes{Added: []string{"Hoisting", "Event loop"}},
+		},
+		{
+			name: "trailing blank lines",
+			edit: func(s string) string {
+				return strings.Replace(s, "Promises chain\n", "Promises chain\n\n\n  \n", 1)
+			},
+			expected: Changes{},
+		},
+		{
+			name: "crlf",
+			edit: func(s string) string {
+				return strings.ReplaceAll(s, "\n", "\r\n")
+			},
+			expected: Changes{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := edit(t, tc.edit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.DeepEqual(t, c, tc.expected, "changes mismatch")
+		})
+	}
+}
+
+func TestRoundTrip_invalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		edit func(string) string
+		line int
+	}{
+		{
+			name: "mangled marker",
+			edit: func(s string) string {
+				return strings.Replace(s, "=== dnote: note n2-uuid ===", "== dnote: note n2-uuid ===", 1)
+			},
+			line: 11,
+		},
+		{
+			name: "mangled uuid",
+			edit: func(s string) string {
+				return strings.Replace(s, "=== dnote: note n2-uuid ===", "=== dnote: note n2-uid ===", 1)
+			},
+			line: 11,
+		},
+		{
+			name: "duplicate marker",
+			edit: func(s string) string {
+				return s + "=== dnote: note n1-uuid ===\nagain\n"
+			},
+			line: 18,
+		},
+		{
+			name: "text above the first marker",
+			edit: func(s string) string {
+				return "Scope\n" + s
+			},
+			line: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := edit(t, tc.edit)
+
+			se, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("error mismatch: %v", err)
+			}
+			assert.Equal(t, se.Line, tc.line, "line mismatch")
+		})
+	}
+}
@@ -32,6 +32,7 @@ import (
 
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
 	"github.com/pkg/errors"
 )
 
@@ -41,6 +42,61 @@ var ErrInvalidLogin = errors.New("wrong credentials")
 // ErrContentTypeMismatch is an error for invalid credentials for login
 var ErrContentTypeMismatch = errors.New("content type mismatch")
 
+// The codes of the API errors, which are stable so that the scripts consuming
+// the JSON output of the commands can tell the errors apart
+const (
+	// CodeBadRequest is the code of a request rejected by the server
+	CodeBadRequest = "bad_request"
+	// CodeUnauthorized is the code of a request without a valid session
+	CodeUnauthorized = "unauthorized"
+	// CodeForbidden is the code of a request that is not allowed for the user
+	CodeForbidden = "forbidden"
+	// CodeNotFound is the code of a request for a resource that does not exist
+	CodeNotFound = "not_found"
+	// CodeConflict is the code of a request that conflicts with the server state
+	CodeConflict = "conflict"
+	// CodeTooLarge is the code of a request with a body too large for the server
+	CodeTooLarge = "too_large"
+	// CodeRateLimited is the code of a request rejected for exceeding the rate limit
+	CodeRateLimited = "rate_limited"
+	// CodeServerError is the code of a request that the server failed to handle
+	CodeServerError = "server_error"
+)
+
+// APIError is an error for a response from the server with an error status
+type APIError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Body is the body of the response, without the trailing newlines
+	Body string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf(`response %d "%s"`, e.StatusCode, e.Body)
+}
+
+// Code returns the stable code of the error based on its status
+func (e *APIError) Code() string {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return CodeUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return CodeForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return CodeNotFound
+	case e.StatusCode == http.StatusConflict:
+		return CodeConflict
+	case e.StatusCode == http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case e.StatusCode == http.StatusTooManyRequests:
+		return CodeRateLimited
+	case e.StatusCode >= 500:
+		return CodeServerError
+	default:
+		return CodeBadRequest
+	}
+}
+
 var contentTypeApplicationJSON = "application/json"
 var contentTypeNone = ""
 
@@ -49,6 +105,8 @@ type requestOptions struct {
 	HTTPClient *http.Client
 	// ExpectedContentType is the Content-Type that the client is expecting from the server
 	ExpectedContentType *string
+	// Header is added to the headers of the request
+	Header http.Header
 }
 
 var defaultRequestOptions = requestOptions{
@@ -56,8 +114,13 @@ var defaultRequestOptions = requestOptions{
 }
 
 func getReq(ctx context.DnoteCtx, path, method, body string) (*http.Request, error) {
-	endpoint := fmt.Sprintf("%s%s", ctx.APIEndpoint, path)
-	req, err := http.NewRequest(method, endpoint, strings.NewReader(body))
+	apiEndpoint, err := utils.NormalizeEndpoint(ctx.APIEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s%s", apiEndpoint, path)
+	req, err := http.NewRequestWithContext(ctx.Context(), method, endpoint, strings.NewReader(body))
 	if err != nil {
 		return nil, errors.Wrap(err, "constructing http request")
 	}
@@ -80,6 +143,14 @@ func getHTTPClient(options *requestOptions) http.Client {
 	return http.Client{}
 }
 
+func getHeader(options *requestOptions) http.Header {
+	if options != nil {
+		return options.Header
+	}
+
+	return nil
+}
+
 func getExpectedContentType(options *requestOptions) string {
 	if options != nil && options.ExpectedContentType != nil {
 		return *options.ExpectedContentType
@@ -100,8 +171,7 @@ func checkRespErr(res *http.Response) error {
 		return errors.Wrapf(err, "server responded with %d but client could not read the response body", res.StatusCode)
 	}
 
-	bodyStr := string(body)
-	return errors.Errorf(`response %d "%s"`, res.StatusCode, strings.TrimRight(bodyStr, "\n"))
+	return &APIError{StatusCode: res.StatusCode, Body: strings.TrimRight(string(body), "\n")}
 }
 
 func checkContentType(res *http.Response, options *requestOptions) error {
@@ -115,27 +185,109 @@ func checkContentType(res *http.Response, options *requestOptions) error {
 	return nil
 }
 
-// doReq does a http request to the given path in the api endpoint
-func doReq(ctx context.DnoteCtx, method, path, body string, options *requestOptions) (*http.Response, error) {
+// isRetryable reports whether a request with the given method can be retried
+// after it got the given response or error. Only the requests that can be
+// repeated without changing the result are retried, so that a note is not
+// created twice.
+func isRetryable(ctx context.DnoteCtx, method string, res *http.Response, err error) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	// the request was canceled rather than failed
+	if ctx.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return res == nil || res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	}
+
+	return false
+}
+
+// doAttempt makes a single attempt of a http request to the given path in
+// the api endpoint
+func doAttempt(ctx context.DnoteCtx, hc http.Client, method, path, body string, header http.Header, attempt int) (*http.Response, error) {
 	req, err := getReq(ctx, path, method, body)
 	if err != nil {
 		return nil, errors.Wrap(err, "getting request")
 	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
 
 	log.Debug("HTTP request: %+v\n", req)
 
-	hc := getHTTPClient(options)
+	start := time.Now()
 	res, err := hc.Do(req)
+
+	// the query string is omitted because it can contain personal information
+	fields := log.Fields{
+		"method":      method,
+		"path":        req.URL.Path,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}
+	if attempt > 0 {
+		fields["attempt"] = attempt + 1
+	}
 	if err != nil {
-		return res, errors.Wrap(err, "making http request")
+		fields["error"] = err.Error()
+		ctx.FileLogger.Info("http", fields)
+
+		return nil, errors.Wrap(err, "making http request")
 	}
 
+	fields["status"] = res.StatusCode
+	ctx.FileLogger.Info("http", fields)
+
 	log.Debug("HTTP response: %+v\n", res)
 
 	if err = checkRespErr(res); err != nil {
 		return res, errors.Wrap(err, "server responded with an error")
 	}
 
+	return res, nil
+}
+
+// doReq does a http request to the given path in the api endpoint. The
+// request times out and is retried after a network or a server error as set
+// in ctx.SyncTimeouts, waiting twice as long before each retry.
+func doReq(ctx context.DnoteCtx, method, path, body string, options *requestOptions) (*http.Response, error) {
+	t := ctx.SyncTimeouts
+
+	hc := getHTTPClient(options)
+	// the timeout of the client also covers reading the body of the response
+	// after doReq returns
+	if hc.Timeout == 0 {
+		hc.Timeout = t.RequestTimeout
+	}
+
+	var res *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		res, err = doAttempt(ctx, hc, method, path, body, getHeader(options), attempt)
+		if attempt >= t.MaxRetries || !isRetryable(ctx, method, res, err) {
+			break
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+
+		delay := t.RetryBaseDelay << attempt
+		log.Debug("retrying %s %s in %s: %s\n", method, path, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Context().Done():
+			return nil, errors.Wrap(ctx.Context().Err(), "waiting to retry the request")
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+
 	if err = checkContentType(res, options); err != nil {
 		return res, errors.Wrap(err, "unexpected Content-Type")
 	}
@@ -158,6 +310,14 @@ type GetSyncStateResp struct {
 	FullSyncBefore int   `json:"full_sync_before"`
 	MaxUSN         int   `json:"max_usn"`
 	CurrentTime    int64 `json:"current_time"`
+	// UserUUID identifies the account. It is empty if the server is older
+	// than the field.
+	UserUUID string `json:"user_uuid,omitempty"`
+	// MinCLIVersion is the oldest version of the CLI that the server
+	// supports, and LatestCLIVersion is the latest release. They are empty if
+	// the server does not advertise them.
+	MinCLIVersion    string `json:"min_cli_version,omitempty"`
+	LatestCLIVersion string `json:"latest_cli_version,omitempty"`
 }
 
 // GetSyncState gets the sync state response from the server
@@ -181,6 +341,38 @@ func GetSyncState(ctx context.DnoteCtx) (GetSyncStateResp, error) {
 	return ret, nil
 }
 
+// GetLimitsResp is the response from get limits endpoint. A zero maximum is
+// no limit.
+type GetLimitsResp struct {
+	MaxNotes  int `json:"max_notes"`
+	NoteCount int `json:"note_count"`
+	MaxBooks  int `json:"max_books"`
+	BookCount int `json:"book_count"`
+}
+
+// GetLimits gets the numbers of the notes and the books that the account may
+// hold on the server, and the numbers it holds. A server that does not limit
+// the accounts responds with 404.
+func GetLimits(ctx context.DnoteCtx) (GetLimitsResp, error) {
+	var ret GetLimitsResp
+
+	res, err := doAuthorizedReq(ctx, "GET", "/v3/limits", "", nil)
+	if err != nil {
+		return ret, errors.Wrap(err, "constructing http request")
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return ret, errors.Wrap(err, "reading the response body")
+	}
+
+	if err = json.Unmarshal(body, &ret); err != nil {
+		return ret, errors.Wrap(err, "unmarshalling the payload")
+	}
+
+	return ret, nil
+}
+
 // SyncFragNote represents a note in a sync fragment and contains only the necessary information
 // for the client to sync the note locally
 type SyncFragNote struct {
@@ -222,16 +414,74 @@ type SyncFragment struct {
 // GetSyncFragmentResp is the response from the get sync fragment endpoint
 type GetSyncFragmentResp struct {
 	Fragment SyncFragment `json:"fragment"`
+	// Size is the number of bytes of the response body
+	Size int64 `json:"-"`
 }
 
 // GetSyncFragment gets a sync fragment response from the server
 func GetSyncFragment(ctx context.DnoteCtx, afterUSN int) (GetSyncFragmentResp, error) {
 	v := url.Values{}
 	v.Set("after_usn", strconv.Itoa(afterUSN))
+
+	return getSyncFragment(ctx, v)
+}
+
+// GetSyncManifest gets a sync fragment without the bodies of the notes and the
+// labels of the books, such as to compare only which of them exist. A server
+// that does not omit them responds with the whole fragment. The server may
+// split the fragment into pages, which are followed and put together.
+func GetSyncManifest(ctx context.DnoteCtx, afterUSN int) (GetSyncFragmentResp, error) {
+	v := url.Values{}
+	v.Set("after_usn", strconv.Itoa(afterUSN))
+	v.Set("fields", "uuid")
+
+	var ret GetSyncFragmentResp
+	err := paginate(ctx, fmt.Sprintf("/v3/sync/fragment?%s", v.Encode()), func(body []byte) error {
+		var page GetSyncFragmentResp
+		if err := json.Unmarshal(body, &page); err != nil {
+			return errors.Wrap(err, "unmarshalling the payload")
+		}
+
+		ret.Fragment = joinFragments(ret.Fragment, page.Fragment)
+		ret.Size += int64(len(body))
+
+		return nil
+	})
+	if err != nil {
+		return GetSyncFragmentResp{}, err
+	}
+
+	return ret, nil
+}
+
+// joinFragments returns the fragment with the items of both of the given pages
+// of a fragment. The usns and the time are those of the later page, except
+// that the fragment ends at the largest usn of either.
+func joinFragments(a, b SyncFragment) SyncFragment {
+	ret := b
+	if a.FragMaxUSN > ret.FragMaxUSN {
+		ret.FragMaxUSN = a.FragMaxUSN
+	}
+	ret.Notes = append(a.Notes, b.Notes...)
+	ret.Books = append(a.Books, b.Books...)
+	ret.ExpungedNotes = append(a.ExpungedNotes, b.ExpungedNotes...)
+	ret.ExpungedBooks = append(a.ExpungedBooks, b.ExpungedBooks...)
+
+	return ret
+}
+
+func getSyncFragment(ctx context.DnoteCtx, v url.Values) (GetSyncFragmentResp, error) {
+	if ctx.SyncPageSize > 0 {
+		v.Set(pageSizeParam, strconv.Itoa(ctx.SyncPageSize))
+	}
 	queryStr := v.Encode()
 
 	path := fmt.Sprintf("/v3/sync/fragment?%s", queryStr)
 	res, err := doAuthorizedReq(ctx, "GET", path, "", nil)
+	if err != nil {
+		return GetSyncFragmentResp{}, errors.Wrap(err, "making http request")
+	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -242,6 +492,7 @@ func GetSyncFragment(ctx context.DnoteCtx, afterUSN int) (GetSyncFragmentResp, e
 	if err = json.Unmarshal(body, &resp); err != nil {
 		return resp, errors.Wrap(err, "unmarshalling the payload")
 	}
+	resp.Size = int64(len(body))
 
 	return resp, nil
 }
@@ -344,6 +595,23 @@ func DeleteBook(ctx context.DnoteCtx, uuid string) (DeleteBookResp, error) {
 	return resp, nil
 }
 
+// GetBook gets a book from the server. It fails with an APIError whose code is
+// CodeNotFound if the book does not exist.
+func GetBook(ctx context.DnoteCtx, uuid string) (RespBook, error) {
+	endpoint := fmt.Sprintf("/v3/books/%s", uuid)
+	res, err := doAuthorizedReq(ctx, "GET", endpoint, "", nil)
+	if err != nil {
+		return RespBook{}, errors.Wrap(err, "getting a book from the server")
+	}
+
+	var resp RespBook
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return resp, errors.Wrap(err, "decoding the response")
+	}
+
+	return resp, nil
+}
+
 // CreateNotePayload is a payload for creating a note
 type CreateNotePayload struct {
 	BookUUID string `json:"book_uuid"`
@@ -377,8 +645,14 @@ type RespNote struct {
 	User      respNoteUser `json:"user"`
 }
 
-// CreateNote creates a note in the server
-func CreateNote(ctx context.DnoteCtx, bookUUID, content string) (CreateNoteResp, error) {
+// IdempotencyKeyHeader is the header of the key that identifies a request to
+// create a note, so that a server that supports it creates the note only once
+// however many times the request is sent
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// CreateNote creates a note in the server. The idempotency key is sent with the
+// request unless it is empty.
+func CreateNote(ctx context.DnoteCtx, bookUUID, content, idempotencyKey string) (CreateNoteResp, error) {
 	payload := CreateNotePayload{
 		BookUUID: bookUUID,
 		Body:     content,
@@ -388,7 +662,12 @@ func CreateNote(ctx context.DnoteCtx, bookUUID, content string) (CreateNoteResp,
 		return CreateNoteResp{}, errors.Wrap(err, "marshaling payload")
 	}
 
-	res, err := doAuthorizedReq(ctx, "POST", "/v3/notes", string(b), nil)
+	var options *requestOptions
+	if idempotencyKey != "" {
+		options = &requestOptions{Header: http.Header{IdempotencyKeyHeader: []string{idempotencyKey}}}
+	}
+
+	res, err := doAuthorizedReq(ctx, "POST", "/v3/notes", string(b), options)
 	if err != nil {
 		return CreateNoteResp{}, errors.Wrap(err, "posting a book to the server")
 	}
@@ -461,22 +740,126 @@ func DeleteNote(ctx context.DnoteCtx, uuid string) (DeleteNoteResp, error) {
 	return resp, nil
 }
 
+// ErrSharesUnsupported is an error for a server without the share link API
+var ErrSharesUnsupported = errors.New("the server does not support share links. Make the note public with the web interface instead")
+
+// Share is a link by which anyone can view a note until it expires or is
+// revoked
+type Share struct {
+	ID        string    `json:"id"`
+	NoteUUID  string    `json:"note_uuid"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is nil if the link does not expire
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateSharePayload is a payload for creating a share link
+type CreateSharePayload struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateShareResp is the response from create share endpoint
+type CreateShareResp struct {
+	Share Share `json:"share"`
+}
+
+// GetSharesResp is the response from get shares endpoint
+type GetSharesResp struct {
+	Shares []Share `json:"shares"`
+}
+
+// sharesError returns the error of a request to the share link API. The
+// servers that do not have the API respond with 404 or 405.
+func sharesError(err error, message string) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusMethodNotAllowed) {
+		return ErrSharesUnsupported
+	}
+
+	return errors.Wrap(err, message)
+}
+
+// CreateShare creates a link to the note in the server. The link expires at
+// the given time, or never if it is nil. It fails with ErrSharesUnsupported if
+// the server does not support share links.
+func CreateShare(ctx context.DnoteCtx, noteUUID string, expiresAt *time.Time) (Share, error) {
+	b, err := json.Marshal(CreateSharePayload{ExpiresAt: expiresAt})
+	if err != nil {
+		return Share{}, errors.Wrap(err, "marshaling payload")
+	}
+
+	endpoint := fmt.Sprintf("/v1/notes/%s/shares", noteUUID)
+	res, err := doAuthorizedReq(ctx, "POST", endpoint, string(b), nil)
+	if err != nil {
+		return Share{}, sharesError(err, "creating a share link in the server")
+	}
+
+	var resp CreateShareResp
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return Share{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp.Share, nil
+}
+
+// GetShares gets the links to the note that have not expired or been revoked.
+// It fails with ErrSharesUnsupported if the server does not support share
+// links.
+func GetShares(ctx context.DnoteCtx, noteUUID string) ([]Share, error) {
+	endpoint := fmt.Sprintf("/v1/notes/%s/shares", noteUUID)
+	res, err := doAuthorizedReq(ctx, "GET", endpoint, "", nil)
+	if err != nil {
+		return nil, sharesError(err, "getting the share links from the server")
+	}
+
+	var resp GetSharesResp
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp.Shares, nil
+}
+
+// DeleteShare revokes a link to the note in the server. It fails with an
+// APIError whose code is CodeNotFound if the link does not exist.
+func DeleteShare(ctx context.DnoteCtx, noteUUID, shareID string) error {
+	endpoint := fmt.Sprintf("/v1/notes/%s/shares/%s", noteUUID, shareID)
+	if _, err := doAuthorizedReq(ctx, "DELETE", endpoint, "", nil); err != nil {
+		return errors.Wrap(err, "revoking a share link in the server")
+	}
+
+	return nil
+}
+
 // GetBooksResp is a response from get books endpoint
 type GetBooksResp []struct {
 	UUID  string `json:"uuid"`
 	Label string `json:"label"`
 }
 
+// GetBookPages gets the books from the server one page at a time, and calls fn
+// with each page
+func GetBookPages(ctx context.DnoteCtx, fn func(GetBooksResp) error) error {
+	return paginate(ctx, "/v3/books", func(body []byte) error {
+		var page GetBooksResp
+		if err := decodeItems(body, "books", &page); err != nil {
+			return err
+		}
+
+		return fn(page)
+	})
+}
+
 // GetBooks gets books from the server
 func GetBooks(ctx context.DnoteCtx, sessionKey string) (GetBooksResp, error) {
-	res, err := doAuthorizedReq(ctx, "GET", "/v3/books", "", nil)
+	resp := GetBooksResp{}
+	err := GetBookPages(ctx, func(page GetBooksResp) error {
+		resp = append(resp, page...)
+		return nil
+	})
 	if err != nil {
-		return GetBooksResp{}, errors.Wrap(err, "making http request")
-	}
-
-	var resp GetBooksResp
-	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return GetBooksResp{}, errors.Wrap(err, "decoding payload")
+		return GetBooksResp{}, err
 	}
 
 	return resp, nil
@@ -23,7 +23,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dnote/dnote/pkg/assert"
 	"github.com/dnote/dnote/pkg/cli/context"
@@ -156,4 +158,265 @@ func TestSignOut(t *testing.T) {
 
 		assert.Equal(t, errors.Cause(err), ErrContentTypeMismatch, "error cause mismatch")
 	})
+
+	t.Run("trailing slash", func(t *testing.T) {
+		err := Signout(context.DnoteCtx{SessionKey: "somekey", APIEndpoint: correctEndpoint + "/"}, "alice@example.com")
+		if err != nil {
+			t.Errorf("got signin request error: %+v", err.Error())
+		}
+	})
+
+	t.Run("missing scheme", func(t *testing.T) {
+		endpoint := strings.TrimPrefix(correctEndpoint, "http://")
+		err := Signout(context.DnoteCtx{SessionKey: "somekey", APIEndpoint: endpoint}, "alice@example.com")
+		if err == nil {
+			t.Fatal("error should have been returned")
+		}
+
+		assert.Equal(t, strings.Contains(err.Error(), "has no scheme"), true, "error mismatch")
+	})
+}
+
+func TestDoReq_retries(t *testing.T) {
+	testCases := []struct {
+		name             string
+		method           string
+		path             string
+		failures         int
+		maxRetries       int
+		expectedErr      bool
+		expectedRequests int
+	}{
+		{
+			name:             "retried until success",
+			method:           "GET",
+			path:             "/v3/sync/state",
+			failures:         2,
+			maxRetries:       2,
+			expectedErr:      false,
+			expectedRequests: 3,
+		},
+		{
+			name:             "out of retries",
+			method:           "GET",
+			path:             "/v3/sync/state",
+			failures:         2,
+			maxRetries:       1,
+			expectedErr:      true,
+			expectedRequests: 2,
+		},
+		{
+			name:             "no retries",
+			method:           "GET",
+			path:             "/v3/sync/state",
+			failures:         1,
+			maxRetries:       0,
+			expectedErr:      true,
+			expectedRequests: 1,
+		},
+		{
+			// creating a note again could create it twice
+			name:             "not repeatable",
+			method:           "POST",
+			path:             "/v3/notes",
+			failures:         1,
+			maxRetries:       2,
+			expectedErr:      true,
+			expectedRequests: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := testutils.NewMockServer(t)
+			defer srv.Close()
+			srv.Fail(tc.method, tc.path, http.StatusServiceUnavailable, tc.failures)
+
+			ctx := context.DnoteCtx{
+				SessionKey:   "somekey",
+				APIEndpoint:  srv.URL(),
+				SyncTimeouts: context.SyncTimeouts{MaxRetries: tc.maxRetries, RetryBaseDelay: time.Millisecond},
+			}
+
+			var err error
+			if tc.method == "POST" {
+				_, err = CreateNote(ctx, "b1-uuid", "n1 body", "")
+			} else {
+				_, err = GetSyncState(ctx)
+			}
+
+			assert.Equal(t, err != nil, tc.expectedErr, fmt.Sprintf("error mismatch: %v", err))
+			assert.Equal(t, len(srv.Requests()), tc.expectedRequests, "request count mismatch")
+		})
+	}
+}
+
+func TestDoReq_requestTimeout(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+	srv.Delay("GET", "/v3/sync/state", 5*time.Second)
+
+	ctx := context.DnoteCtx{
+		SessionKey:   "somekey",
+		APIEndpoint:  srv.URL(),
+		SyncTimeouts: context.SyncTimeouts{RequestTimeout: 100 * time.Millisecond},
+	}
+
+	start := time.Now()
+	_, err := GetSyncState(ctx)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("error should have been returned")
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("the request took %s despite the timeout", elapsed)
+	}
+}
+
+func TestCreateNote_idempotencyKey(t *testing.T) {
+	var keys []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(CreateNoteResp{Result: RespNote{UUID: "n1-uuid", USN: 1}}); err != nil {
+			t.Fatal(errors.Wrap(err, "encoding the response"))
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL}
+
+	if _, err := CreateNote(ctx, "b1-uuid", "n1 body", "key-1"); err != nil {
+		t.Fatal(errors.Wrap(err, "creating with a key"))
+	}
+	if _, err := CreateNote(ctx, "b1-uuid", "n2 body", ""); err != nil {
+		t.Fatal(errors.Wrap(err, "creating without a key"))
+	}
+
+	assert.DeepEqual(t, keys, []string{"key-1", ""}, "keys mismatch")
+}
+
+func TestCreateShare(t *testing.T) {
+	expiresAt := time.Date(2021, 3, 16, 12, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	var paths []string
+	var payloads []CreateSharePayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+
+		var payload CreateSharePayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding the payload"))
+		}
+		payloads = append(payloads, payload)
+
+		resp := CreateShareResp{Share: Share{ID: "s1", NoteUUID: "n1-uuid", URL: "https://example.com/s/s1", CreatedAt: createdAt, ExpiresAt: payload.ExpiresAt}}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(errors.Wrap(err, "encoding the response"))
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL}
+
+	share, err := CreateShare(ctx, "n1-uuid", &expiresAt)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating an expiring link"))
+	}
+	assert.Equal(t, share.ID, "s1", "id mismatch")
+	assert.Equal(t, share.URL, "https://example.com/s/s1", "url mismatch")
+	assert.Equal(t, share.ExpiresAt.Equal(expiresAt), true, "expires_at mismatch")
+
+	share, err = CreateShare(ctx, "n1-uuid", nil)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating a link that never expires"))
+	}
+	assert.Equal(t, share.ExpiresAt == nil, true, "expires_at should be nil")
+
+	assert.DeepEqual(t, paths, []string{"POST /v1/notes/n1-uuid/shares", "POST /v1/notes/n1-uuid/shares"}, "paths mismatch")
+	assert.Equal(t, len(payloads), 2, "payload count mismatch")
+	assert.Equal(t, payloads[0].ExpiresAt.Equal(expiresAt), true, "payload expires_at mismatch")
+	assert.Equal(t, payloads[1].ExpiresAt == nil, true, "payload expires_at should be omitted")
+}
+
+func TestShares_unsupported(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusMethodNotAllowed} {
+		t.Run(fmt.Sprintf("status %d", status), func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+			defer ts.Close()
+
+			ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL}
+
+			_, err := CreateShare(ctx, "n1-uuid", nil)
+			assert.Equal(t, err, ErrSharesUnsupported, "create error mismatch")
+
+			_, err = GetShares(ctx, "n1-uuid")
+			assert.Equal(t, err, ErrSharesUnsupported, "get error mismatch")
+		})
+	}
+}
+
+func TestGetShares(t *testing.T) {
+	createdAt := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/v1/notes/n1-uuid/shares" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		resp := GetSharesResp{Shares: []Share{
+			{ID: "s1", NoteUUID: "n1-uuid", URL: "https://example.com/s/s1", CreatedAt: createdAt},
+			{ID: "s2", NoteUUID: "n1-uuid", URL: "https://example.com/s/s2", CreatedAt: createdAt},
+		}}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(errors.Wrap(err, "encoding the response"))
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL}
+
+	shares, err := GetShares(ctx, "n1-uuid")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the links"))
+	}
+
+	assert.Equal(t, len(shares), 2, "share count mismatch")
+	assert.Equal(t, shares[0].ID, "s1", "shares[0] id mismatch")
+	assert.Equal(t, shares[1].ID, "s2", "shares[1] id mismatch")
+}
+
+func TestDeleteShare(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/s2") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL}
+
+	if err := DeleteShare(ctx, "n1-uuid", "s1"); err != nil {
+		t.Fatal(errors.Wrap(err, "revoking a link"))
+	}
+
+	err := DeleteShare(ctx, "n1-uuid", "s2")
+	var apiErr *APIError
+	assert.Equal(t, errors.As(err, &apiErr), true, "should fail with an APIError")
+	assert.Equal(t, apiErr.Code(), CodeNotFound, "code mismatch")
+
+	assert.DeepEqual(t, paths, []string{"DELETE /v1/notes/n1-uuid/shares/s1", "DELETE /v1/notes/n1-uuid/shares/s2"}, "paths mismatch")
 }
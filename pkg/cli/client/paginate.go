@@ -0,0 +1,222 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+// cursorParam is the query parameter of the cursor of a page
+const cursorParam = "cursor"
+
+// pageSizeParam is the query parameter of the number of the items in a page
+const pageSizeParam = "limit"
+
+// PageFunc is called with the body of each page of a paginated response
+type PageFunc func(body []byte) error
+
+// withPageSize returns the path with the page size of the context in its
+// query, or the path as it is if the page size is left to the server
+func withPageSize(ctx context.DnoteCtx, path string) (string, error) {
+	if ctx.SyncPageSize <= 0 {
+		return path, nil
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing the path %s", path)
+	}
+	q := u.Query()
+	q.Set(pageSizeParam, strconv.Itoa(ctx.SyncPageSize))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// parseNextLink returns the URL of the link with the relation next in the
+// value of a Link header, or an empty string if there is none
+func parseNextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "rel" {
+				continue
+			}
+
+			for _, rel := range strings.Fields(strings.Trim(kv[1], `"`)) {
+				if strings.ToLower(rel) == "next" {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// linkPath returns the path in the api endpoint of the URL in a Link header of
+// the response. A URL outside the api endpoint is refused so that the session
+// key is not sent elsewhere.
+func linkPath(ctx context.DnoteCtx, res *http.Response, link string) (string, error) {
+	endpoint, err := utils.NormalizeEndpoint(ctx.APIEndpoint)
+	if err != nil {
+		return "", err
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing the api endpoint")
+	}
+
+	u, err := res.Request.URL.Parse(link)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing the next link %s", link)
+	}
+	if u.Scheme != base.Scheme || u.Host != base.Host || !strings.HasPrefix(u.Path, base.Path+"/") {
+		return "", errors.Errorf("the next link %s is outside the api endpoint", link)
+	}
+
+	ret := strings.TrimPrefix(u.Path, base.Path)
+	if u.RawQuery != "" {
+		ret += "?" + u.RawQuery
+	}
+
+	return ret, nil
+}
+
+// cursorPath returns the path with the cursor in its query
+func cursorPath(path, cursor string) (string, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing the path %s", path)
+	}
+	q := u.Query()
+	q.Set(cursorParam, cursor)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// bodyCursor returns the cursor of the next page in the body of a page, which
+// is in the next field of a JSON object. It is empty if the page is the last
+// one, or if the body is not an object, such as a list of the items.
+func bodyCursor(body []byte) string {
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		return ""
+	}
+
+	var page struct {
+		Next string `json:"next"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return ""
+	}
+
+	return page.Next
+}
+
+// nextPage returns the path of the page after the one in the response with the
+// given body, or an empty string if the page is the last one. The server
+// gives the next page either as a Link header with the relation next, or as a
+// cursor in the body, which is sent back with the path of the first page.
+func nextPage(ctx context.DnoteCtx, firstPath string, res *http.Response, body []byte) (string, error) {
+	if link := parseNextLink(strings.Join(res.Header.Values("Link"), ",")); link != "" {
+		return linkPath(ctx, res, link)
+	}
+	if cursor := bodyCursor(body); cursor != "" {
+		return cursorPath(firstPath, cursor)
+	}
+
+	return "", nil
+}
+
+// paginate gets the pages of the collection at the path one at a time and
+// calls fn with the body of each, so that the whole collection is never held
+// in memory. It follows the next pages until the server gives none, and fails
+// if the server gives a page that was already got, which would never end.
+func paginate(ctx context.DnoteCtx, path string, fn PageFunc) error {
+	path, err := withPageSize(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	firstPath := path
+	seen := map[string]bool{}
+	for path != "" {
+		if seen[path] {
+			return errors.Errorf("the server repeated the page %s", path)
+		}
+		seen[path] = true
+
+		res, err := doAuthorizedReq(ctx, "GET", path, "", nil)
+		if err != nil {
+			return errors.Wrap(err, "making http request")
+		}
+
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return errors.Wrap(err, "reading the response body")
+		}
+
+		if err := fn(body); err != nil {
+			return err
+		}
+
+		if path, err = nextPage(ctx, firstPath, res, body); err != nil {
+			return errors.Wrap(err, "getting the next page")
+		}
+	}
+
+	return nil
+}
+
+// decodeItems decodes the items in the body of a page, which is either a list
+// of them or a JSON object that has them in the given field
+func decodeItems(body []byte, field string, v interface{}) error {
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		return errors.Wrap(json.Unmarshal(body, v), "decoding payload")
+	}
+
+	var page map[string]json.RawMessage
+	if err := json.Unmarshal(body, &page); err != nil {
+		return errors.Wrap(err, "decoding payload")
+	}
+	items, ok := page[field]
+	if !ok {
+		return errors.Errorf("no %s in the payload", field)
+	}
+
+	return errors.Wrapf(json.Unmarshal(items, v), "decoding %s", field)
+}
@@ -0,0 +1,255 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/pkg/errors"
+)
+
+func TestParseNextLink(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected string
+	}{
+		{
+			header:   "",
+			expected: "",
+		},
+		{
+			header:   `</v3/books?cursor=c2>; rel="next"`,
+			expected: "/v3/books?cursor=c2",
+		},
+		{
+			header:   `</v3/books?cursor=c1>; rel="prev", </v3/books?cursor=c3>; rel="next"`,
+			expected: "/v3/books?cursor=c3",
+		},
+		{
+			header:   `<https://example.com/v3/books?page=2>; rel=next`,
+			expected: "https://example.com/v3/books?page=2",
+		},
+		{
+			header:   `</v3/books?cursor=c2>; rel="last next"`,
+			expected: "/v3/books?cursor=c2",
+		},
+		{
+			header:   `</v3/books?cursor=c1>; rel="prev"`,
+			expected: "",
+		},
+		{
+			header:   `/v3/books?cursor=c2; rel="next"`,
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			assert.Equal(t, parseNextLink(tc.header), tc.expected, "link mismatch")
+		})
+	}
+}
+
+func TestGetBookPages_bodyCursor(t *testing.T) {
+	var queries []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/books" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		queries = append(queries, q.Get(cursorParam)+"/"+q.Get(pageSizeParam))
+
+		var body string
+		switch q.Get(cursorParam) {
+		case "":
+			body = `{"books":[{"uuid":"b1-uuid","label":"js"}],"next":"c2"}`
+		case "c2":
+			body = `{"books":[{"uuid":"b2-uuid","label":"css"}],"next":"c3"}`
+		case "c3":
+			body = `{"books":[{"uuid":"b3-uuid","label":"go"}]}`
+		default:
+			t.Errorf("unexpected cursor %s", q.Get(cursorParam))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL + "/api", SyncPageSize: 1}
+
+	var labels []string
+	err := GetBookPages(ctx, func(page GetBooksResp) error {
+		assert.Equal(t, len(page), 1, "page size mismatch")
+		for _, b := range page {
+			labels = append(labels, b.Label)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the books"))
+	}
+
+	assert.DeepEqual(t, labels, []string{"js", "css", "go"}, "labels mismatch")
+	assert.DeepEqual(t, queries, []string{"/1", "c2/1", "c3/1"}, "queries mismatch")
+}
+
+func TestGetSyncManifest_linkHeader(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/v3/sync/fragment" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("after_usn") != "4" || q.Get("fields") != "uuid" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		if q.Get(pageSizeParam) != "" {
+			t.Errorf("unexpected page size %s", q.Get(pageSizeParam))
+		}
+
+		var frag SyncFragment
+		switch q.Get("page") {
+		case "":
+			w.Header().Set("Link", `</v3/sync/fragment?after_usn=4&fields=uuid&page=2>; rel="next"`)
+			frag = SyncFragment{FragMaxUSN: 6, UserMaxUSN: 9, CurrentTime: 1550436136, Notes: []SyncFragNote{{UUID: "n1-uuid"}, {UUID: "n2-uuid"}}}
+		case "2":
+			w.Header().Set("Link", `</v3/sync/fragment?after_usn=4&fields=uuid&page=1>; rel="prev", </v3/sync/fragment?after_usn=4&fields=uuid&page=3>; rel="next"`)
+			frag = SyncFragment{FragMaxUSN: 8, UserMaxUSN: 9, CurrentTime: 1550436136, Books: []SyncFragBook{{UUID: "b1-uuid"}}}
+		case "3":
+			frag = SyncFragment{FragMaxUSN: 9, UserMaxUSN: 9, CurrentTime: 1550436137, Notes: []SyncFragNote{{UUID: "n3-uuid"}}, ExpungedNotes: []string{"n4-uuid"}}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(GetSyncFragmentResp{Fragment: frag}); err != nil {
+			t.Fatal(errors.Wrap(err, "encoding the response"))
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL}
+
+	resp, err := GetSyncManifest(ctx, 4)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the manifest"))
+	}
+
+	var uuids []string
+	for _, n := range resp.Fragment.Notes {
+		uuids = append(uuids, n.UUID)
+	}
+	assert.DeepEqual(t, uuids, []string{"n1-uuid", "n2-uuid", "n3-uuid"}, "note uuids mismatch")
+	assert.Equal(t, len(resp.Fragment.Books), 1, "book count mismatch")
+	assert.DeepEqual(t, resp.Fragment.ExpungedNotes, []string{"n4-uuid"}, "expunged notes mismatch")
+	assert.Equal(t, resp.Fragment.FragMaxUSN, 9, "frag max usn mismatch")
+	assert.Equal(t, resp.Fragment.CurrentTime, int64(1550436137), "current time mismatch")
+	assert.Equal(t, requests, 3, "request count mismatch")
+}
+
+func TestGetBooks_list(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"uuid":"b1-uuid","label":"js"},{"uuid":"b2-uuid","label":"css"}]`)
+	}))
+	defer ts.Close()
+
+	ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL}
+
+	books, err := GetBooks(ctx, ctx.SessionKey)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the books"))
+	}
+
+	assert.Equal(t, len(books), 2, "book count mismatch")
+	assert.Equal(t, books[0].Label, "js", "books[0] label mismatch")
+	assert.Equal(t, books[1].Label, "css", "books[1] label mismatch")
+	assert.Equal(t, requests, 1, "request count mismatch")
+}
+
+func TestPaginate_repeatedPage(t *testing.T) {
+	testCases := []struct {
+		name   string
+		handle func(w http.ResponseWriter, r *http.Request)
+	}{
+		{
+			name: "body cursor",
+			handle: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"books":[],"next":"c2"}`)
+			},
+		},
+		{
+			name: "link header",
+			handle: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Link", `</v3/books?page=2>; rel="next"`)
+				fmt.Fprint(w, `[]`)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests > 5 {
+					t.Errorf("the pagination did not stop")
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				tc.handle(w, r)
+			}))
+			defer ts.Close()
+
+			ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL}
+
+			_, err := GetBooks(ctx, ctx.SessionKey)
+			assert.NotEqual(t, err, nil, "error mismatch")
+			assert.Equal(t, requests, 2, "request count mismatch")
+		})
+	}
+}
+
+func TestPaginate_foreignLink(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Link", `<https://example.com/v3/books?page=2>; rel="next"`)
+		fmt.Fprint(w, `[]`)
+	}))
+	defer ts.Close()
+
+	ctx := context.DnoteCtx{SessionKey: "somekey", APIEndpoint: ts.URL}
+
+	_, err := GetBooks(ctx, ctx.SessionKey)
+	assert.NotEqual(t, err, nil, "error mismatch")
+	assert.Equal(t, requests, 1, "request count mismatch")
+}
@@ -0,0 +1,170 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package clipboard reads and writes the clipboard of the system
+package clipboard
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Clipboard is a clipboard that holds text
+type Clipboard interface {
+	// Read returns the text on the clipboard
+	Read() (string, error)
+	// Write places the text on the clipboard, replacing what it held
+	Write(text string) error
+}
+
+// UnavailableError is an error for a system without a clipboard that can be
+// used. It lists what was looked for.
+type UnavailableError struct {
+	Tried []string
+}
+
+func (e UnavailableError) Error() string {
+	return fmt.Sprintf("no clipboard is available. Looked for %s", strings.Join(e.Tried, ", "))
+}
+
+// tool is a pair of programs that read from and write to a clipboard
+type tool struct {
+	paste []string
+	copy  []string
+	// env is an environment variable that must be set for the tool to reach
+	// the clipboard, such as the display of the window system
+	env string
+}
+
+func (t tool) name() string {
+	ret := t.copy[0]
+	if t.paste[0] != ret {
+		ret = fmt.Sprintf("%s/%s", ret, t.paste[0])
+	}
+	if t.env != "" {
+		ret = fmt.Sprintf("%s with %s set", ret, t.env)
+	}
+
+	return ret
+}
+
+// commandClipboard is a clipboard reached through the first of the tools
+// that is installed
+type commandClipboard struct {
+	tools    []tool
+	lookPath func(string) (string, error)
+	getenv   func(string) string
+}
+
+func newCommandClipboard(tools ...tool) commandClipboard {
+	return commandClipboard{tools: tools, lookPath: exec.LookPath, getenv: os.Getenv}
+}
+
+// find returns the first of the tools whose programs are installed
+func (c commandClipboard) find() (tool, error) {
+	var tried []string
+
+	for _, t := range c.tools {
+		tried = append(tried, t.name())
+
+		if t.env != "" && c.getenv(t.env) == "" {
+			continue
+		}
+		if _, err := c.lookPath(t.copy[0]); err != nil {
+			continue
+		}
+		if _, err := c.lookPath(t.paste[0]); err != nil {
+			continue
+		}
+
+		return t, nil
+	}
+
+	return tool{}, UnavailableError{Tried: tried}
+}
+
+func (c commandClipboard) Read() (string, error) {
+	t, err := c.find()
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(t.paste[0], t.paste[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "running %s: %s", t.paste[0], strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+func (c commandClipboard) Write(text string) error {
+	t, err := c.find()
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(t.copy[0], t.copy[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "running %s: %s", t.copy[0], strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// Memory is a clipboard kept in memory, e.g. for tests
+type Memory struct {
+	mu   sync.Mutex
+	text string
+}
+
+// NewMemory returns a clipboard in memory that holds the given text
+func NewMemory(text string) *Memory {
+	return &Memory{text: text}
+}
+
+// Read returns the text on the clipboard
+func (m *Memory) Read() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.text, nil
+}
+
+// Write places the text on the clipboard
+func (m *Memory) Write(text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.text = text
+
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build !darwin && !windows
+
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package clipboard
+
+// System returns the clipboard of the window system, which is reached through
+// wl-clipboard on Wayland, and xclip or xsel on X11
+func System() Clipboard {
+	return newCommandClipboard(
+		tool{paste: []string{"wl-paste", "--no-newline"}, copy: []string{"wl-copy"}, env: "WAYLAND_DISPLAY"},
+		tool{paste: []string{"xclip", "-selection", "clipboard", "-out"}, copy: []string{"xclip", "-selection", "clipboard", "-in"}, env: "DISPLAY"},
+		tool{paste: []string{"xsel", "--clipboard", "--output"}, copy: []string{"xsel", "--clipboard", "--input"}, env: "DISPLAY"},
+	)
+}
@@ -0,0 +1,130 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package clipboard
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory("initial")
+
+	got, err := m.Read()
+	assert.Equal(t, err, nil, "reading")
+	assert.Equal(t, got, "initial", "initial text mismatch")
+
+	assert.Equal(t, m.Write("line 1\nline 2"), nil, "writing")
+
+	got, err = m.Read()
+	assert.Equal(t, err, nil, "reading")
+	assert.Equal(t, got, "line 1\nline 2", "written text mismatch")
+}
+
+func TestCommandClipboard_find(t *testing.T) {
+	wayland := tool{paste: []string{"wl-paste"}, copy: []string{"wl-copy"}, env: "WAYLAND_DISPLAY"}
+	xclip := tool{paste: []string{"xclip", "-out"}, copy: []string{"xclip", "-in"}, env: "DISPLAY"}
+	pb := tool{paste: []string{"pbpaste"}, copy: []string{"pbcopy"}}
+
+	testCases := []struct {
+		name      string
+		tools     []tool
+		installed []string
+		env       map[string]string
+		expected  string
+		expectErr string
+	}{
+		{
+			name:      "first available",
+			tools:     []tool{wayland, xclip},
+			installed: []string{"wl-copy", "wl-paste", "xclip"},
+			env:       map[string]string{"WAYLAND_DISPLAY": "wayland-0", "DISPLAY": ":0"},
+			expected:  "wl-copy",
+		},
+		{
+			name:      "environment not set",
+			tools:     []tool{wayland, xclip},
+			installed: []string{"wl-copy", "wl-paste", "xclip"},
+			env:       map[string]string{"DISPLAY": ":0"},
+			expected:  "xclip",
+		},
+		{
+			name:      "program missing",
+			tools:     []tool{wayland, xclip},
+			installed: []string{"wl-copy", "xclip"},
+			env:       map[string]string{"WAYLAND_DISPLAY": "wayland-0", "DISPLAY": ":0"},
+			expected:  "xclip",
+		},
+		{
+			name:      "unavailable",
+			tools:     []tool{wayland, xclip, pb},
+			installed: []string{},
+			env:       map[string]string{},
+			expectErr: "no clipboard is available. Looked for wl-copy/wl-paste with WAYLAND_DISPLAY set, xclip with DISPLAY set, pbcopy/pbpaste",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newCommandClipboard(tc.tools...)
+			c.lookPath = func(name string) (string, error) {
+				for _, p := range tc.installed {
+					if p == name {
+						return "/usr/bin/" + name, nil
+					}
+				}
+
+				return "", exec.ErrNotFound
+			}
+			c.getenv = func(key string) string { return tc.env[key] }
+
+			got, err := c.find()
+			if tc.expectErr != "" {
+				if _, ok := err.(UnavailableError); !ok {
+					t.Fatalf("expected an UnavailableError, got %v", err)
+				}
+				assert.Equal(t, err.Error(), tc.expectErr, "error mismatch")
+				return
+			}
+
+			assert.Equal(t, err, nil, "finding the tool")
+			assert.Equal(t, got.copy[0], tc.expected, "tool mismatch")
+		})
+	}
+}
+
+func TestCommandClipboard_roundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake tool is a shell command")
+	}
+
+	path := filepath.Join(t.TempDir(), "clipboard")
+	c := newCommandClipboard(tool{paste: []string{"cat", path}, copy: []string{"sh", "-c", "cat > " + path}})
+
+	text := "first line\nsecond line with ünïcode\n"
+	assert.Equal(t, c.Write(text), nil, "writing")
+
+	got, err := c.Read()
+	assert.Equal(t, err, nil, "reading")
+	assert.Equal(t, got, text, "text mismatch")
+}
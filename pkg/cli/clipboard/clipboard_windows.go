@@ -0,0 +1,145 @@
+//go:build windows
+
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package clipboard
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// cfUnicodeText is the clipboard format of the UTF-16 text
+	cfUnicodeText = 13
+	// gmemMoveable allocates the memory that the clipboard takes over
+	gmemMoveable = 0x0002
+)
+
+var (
+	user32   = syscall.NewLazyDLL("user32.dll")
+	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	openClipboard    = user32.NewProc("OpenClipboard")
+	closeClipboard   = user32.NewProc("CloseClipboard")
+	emptyClipboard   = user32.NewProc("EmptyClipboard")
+	getClipboardData = user32.NewProc("GetClipboardData")
+	setClipboardData = user32.NewProc("SetClipboardData")
+	globalAlloc      = kernel32.NewProc("GlobalAlloc")
+	globalFree       = kernel32.NewProc("GlobalFree")
+	globalLock       = kernel32.NewProc("GlobalLock")
+	globalUnlock     = kernel32.NewProc("GlobalUnlock")
+)
+
+// System returns the clipboard of Windows, which is reached through the
+// Windows API without any program
+func System() Clipboard {
+	return windowsClipboard{}
+}
+
+type windowsClipboard struct{}
+
+// openClipboardRetry opens the clipboard, retrying for a while since another
+// program may be holding it
+func openClipboardRetry() error {
+	var err error
+	for i := 0; i < 10; i++ {
+		var r uintptr
+		if r, _, err = openClipboard.Call(0); r != 0 {
+			return nil
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return errors.Wrap(err, "opening the clipboard")
+}
+
+// pointer converts an address returned by the Windows API to a pointer
+func pointer(addr uintptr) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+}
+
+func (windowsClipboard) Read() (string, error) {
+	if err := openClipboardRetry(); err != nil {
+		return "", err
+	}
+	defer closeClipboard.Call()
+
+	// the clipboard holds no data in the text format
+	h, _, _ := getClipboardData.Call(cfUnicodeText)
+	if h == 0 {
+		return "", nil
+	}
+
+	addr, _, err := globalLock.Call(h)
+	if addr == 0 {
+		return "", errors.Wrap(err, "locking the clipboard data")
+	}
+	defer globalUnlock.Call(h)
+
+	// the text is terminated by a NUL character
+	p := pointer(addr)
+	n := 0
+	for *(*uint16)(unsafe.Add(p, n*2)) != 0 {
+		n++
+	}
+
+	return syscall.UTF16ToString(unsafe.Slice((*uint16)(p), n)), nil
+}
+
+func (windowsClipboard) Write(text string) error {
+	u, err := syscall.UTF16FromString(text)
+	if err != nil {
+		return errors.Wrap(err, "encoding the text")
+	}
+
+	if err := openClipboardRetry(); err != nil {
+		return err
+	}
+	defer closeClipboard.Call()
+
+	if r, _, err := emptyClipboard.Call(); r == 0 {
+		return errors.Wrap(err, "emptying the clipboard")
+	}
+
+	h, _, err := globalAlloc.Call(gmemMoveable, uintptr(len(u)*2))
+	if h == 0 {
+		return errors.Wrap(err, "allocating the clipboard data")
+	}
+
+	addr, _, err := globalLock.Call(h)
+	if addr == 0 {
+		globalFree.Call(h)
+		return errors.Wrap(err, "locking the clipboard data")
+	}
+	copy(unsafe.Slice((*uint16)(pointer(addr)), len(u)), u)
+	globalUnlock.Call(h)
+
+	// the system owns the memory once it is set on the clipboard
+	if r, _, err := setClipboardData.Call(cfUnicodeText, h); r == 0 {
+		globalFree.Call(h)
+		return errors.Wrap(err, "setting the clipboard data")
+	}
+
+	return nil
+}
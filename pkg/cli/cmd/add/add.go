@@ -19,35 +19,46 @@
 package add
 
 import (
-	"database/sql"
-	"time"
-
+	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/output"
 	"github.com/dnote/dnote/pkg/cli/ui"
 	"github.com/dnote/dnote/pkg/cli/upgrade"
-	"github.com/dnote/dnote/pkg/cli/utils"
 	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/dnote/dnote/pkg/dnote"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 var contentFlag string
+var clipboardFlag bool
+var createFlag bool
+var forceFlag bool
 
 var example = `
  * Open an editor to write content
  dnote add git
 
  * Skip the editor by providing content directly
- dnote add git -c "time is a part of the commit hash"`
+ dnote add git -c "time is a part of the commit hash"
+
+ * Take the content from the clipboard
+ dnote add git --clipboard
+
+ * Create a new book even if its name is similar to an existing one or autoCreateBook is false
+ dnote add gti --create`
 
 func preRun(cmd *cobra.Command, args []string) error {
 	if len(args) != 1 {
 		return errors.New("Incorrect number of argument")
 	}
+	if contentFlag != "" && clipboardFlag {
+		return errors.New("--content and --clipboard cannot be used together")
+	}
 
 	return nil
 }
@@ -65,6 +76,9 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 
 	f := cmd.Flags()
 	f.StringVarP(&contentFlag, "content", "c", "", "The new content for the note")
+	f.BoolVarP(&clipboardFlag, "clipboard", "", false, "Take the content from the clipboard")
+	f.BoolVarP(&createFlag, "create", "", false, "Create a new book without matching the name against existing books, regardless of autoCreateBook")
+	f.BoolVarP(&forceFlag, "force", "", false, "Replace the bytes that are not valid UTF-8 in the content and allow a content above the size limit instead of failing")
 
 	return cmd
 }
@@ -73,13 +87,21 @@ func getContent(ctx context.DnoteCtx) (string, error) {
 	if contentFlag != "" {
 		return contentFlag, nil
 	}
+	if clipboardFlag {
+		c, err := ctx.Clipboard.Read()
+		if err != nil {
+			return "", errors.Wrap(err, "reading the clipboard")
+		}
+
+		return c, nil
+	}
 
 	fpath, err := ui.GetTmpContentPath(ctx)
 	if err != nil {
 		return "", errors.Wrap(err, "getting temporarily content file path")
 	}
 
-	c, err := ui.GetEditorInput(ctx, fpath)
+	c, err := ui.GetEditorInput(ctx, fpath, "--content")
 	if err != nil {
 		return "", errors.Wrap(err, "Failed to get editor input")
 	}
@@ -87,6 +109,44 @@ func getContent(ctx context.DnoteCtx) (string, error) {
 	return c, nil
 }
 
+// resolveBookName returns the name of the book to add the note to, and a
+// boolean indicating if the user chose to proceed
+func resolveBookName(ctx context.DnoteCtx, name string) (string, bool, error) {
+	if createFlag {
+		return name, true, nil
+	}
+
+	ret, err := ui.ResolveBook(ctx, name)
+	if err != nil {
+		return "", false, errors.Wrap(err, "resolving the book")
+	}
+
+	var count int
+	if err := ctx.DB.QueryRow("SELECT count(*) FROM books WHERE label = ?", ret).Scan(&count); err != nil {
+		return "", false, errors.Wrap(err, "counting books")
+	}
+	if count > 0 {
+		return ret, true, nil
+	}
+
+	// the policies other than the default already list the similar books
+	if ctx.AutoCreateBook == consts.AutoCreateBookFalse || ctx.AutoCreateBook == consts.AutoCreateBookPrompt {
+		ok, err := ui.AllowNewBook(ctx, ret, ctx.AutoCreateBook, "--create")
+		if err != nil {
+			return "", false, errors.Wrap(err, "creating a new book")
+		}
+
+		return ret, ok, nil
+	}
+
+	ok, err := ui.ConfirmNewBook(ctx, ret)
+	if err != nil {
+		return "", false, errors.Wrap(err, "confirming a new book")
+	}
+
+	return ret, ok, nil
+}
+
 func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
 		bookName := args[0]
@@ -94,6 +154,15 @@ func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 			return errors.Wrap(err, "invalid book name")
 		}
 
+		bookName, ok, err := resolveBookName(ctx, bookName)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Warn(i18n.T("aborted"))
+			return nil
+		}
+
 		content, err := getContent(ctx)
 		if err != nil {
 			return errors.Wrap(err, "getting content")
@@ -101,83 +170,32 @@ func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 		if content == "" {
 			return errors.New("Empty content")
 		}
+		content, err = ui.NormalizeContent(content, forceFlag)
+		if err != nil {
+			return errors.Wrap(err, "invalid content")
+		}
+		if err := ui.CheckContentSize(ctx, content, forceFlag); err != nil {
+			return errors.Wrap(err, "invalid content")
+		}
 
-		ts := time.Now().UnixNano()
-		noteRowID, err := writeNote(ctx, bookName, content, ts)
+		note, err := dnote.NewStore(ctx).AddNote(bookName, content)
 		if err != nil {
 			return errors.Wrap(err, "Failed to write note")
 		}
 
-		log.Successf("added to %s\n", bookName)
+		log.Success(i18n.T("add.added", bookName))
 
-		db := ctx.DB
-		info, err := database.GetNoteInfo(db, noteRowID)
+		info, err := database.GetNoteInfo(ctx.DB, note.Index)
 		if err != nil {
 			return err
 		}
 
-		output.NoteInfo(info)
+		output.NoteInfo(ctx, info)
 
 		if err := upgrade.Check(ctx); err != nil {
-			log.Error(errors.Wrap(err, "automatically checking updates").Error())
+			log.Error(i18n.T("update_check_failed", err))
 		}
 
 		return nil
 	}
 }
-
-func writeNote(ctx context.DnoteCtx, bookLabel string, content string, ts int64) (int, error) {
-	tx, err := ctx.DB.Begin()
-	if err != nil {
-		return 0, errors.Wrap(err, "beginning a transaction")
-	}
-
-	var bookUUID string
-	err = tx.QueryRow("SELECT uuid FROM books WHERE label = ?", bookLabel).Scan(&bookUUID)
-	if err == sql.ErrNoRows {
-		bookUUID, err = utils.GenerateUUID()
-		if err != nil {
-			return 0, errors.Wrap(err, "generating uuid")
-		}
-
-		b := database.NewBook(bookUUID, bookLabel, 0, false, true)
-		err = b.Insert(tx)
-		if err != nil {
-			tx.Rollback()
-			return 0, errors.Wrap(err, "creating the book")
-		}
-	} else if err != nil {
-		return 0, errors.Wrap(err, "finding the book")
-	}
-
-	noteUUID, err := utils.GenerateUUID()
-	if err != nil {
-		return 0, errors.Wrap(err, "generating uuid")
-	}
-
-	n := database.NewNote(noteUUID, bookUUID, content, ts, 0, 0, false, false, true)
-
-	err = n.Insert(tx)
-	if err != nil {
-		tx.Rollback()
-		return 0, errors.Wrap(err, "creating the note")
-	}
-
-	var noteRowID int
-	err = tx.QueryRow(`SELECT notes.rowid
-			FROM notes
-			WHERE notes.uuid = ?`, noteUUID).
-		Scan(&noteRowID)
-	if err != nil {
-		tx.Rollback()
-		return noteRowID, errors.Wrap(err, "getting the note rowid")
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
-		return noteRowID, errors.Wrap(err, "committing a transaction")
-	}
-
-	return noteRowID, nil
-}
@@ -0,0 +1,183 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package add
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/clipboard"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+)
+
+func TestAdd_autoCreateBook(t *testing.T) {
+	testCases := []struct {
+		name          string
+		policy        string
+		args          []string
+		expectedBooks int
+		expectedErr   string
+	}{
+		{
+			name:          "true",
+			policy:        consts.AutoCreateBookTrue,
+			args:          []string{"python", "-c", "n1 body"},
+			expectedBooks: 2,
+		},
+		{
+			name:          "false",
+			policy:        consts.AutoCreateBookFalse,
+			args:          []string{"golnag", "-c", "n1 body"},
+			expectedBooks: 1,
+			expectedErr:   "book 'golnag' does not exist. Close matches: golang. Use --create to create it",
+		},
+		{
+			name:          "false with --create",
+			policy:        consts.AutoCreateBookFalse,
+			args:          []string{"golnag", "-c", "n1 body", "--create"},
+			expectedBooks: 2,
+		},
+		{
+			name:          "prompt in the non-interactive mode",
+			policy:        consts.AutoCreateBookPrompt,
+			args:          []string{"python", "-c", "n1 body"},
+			expectedBooks: 1,
+			expectedErr:   "cannot confirm creating a new book in the non-interactive mode. Use --create",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			ctx.AutoCreateBook = tc.policy
+			ui.SetInteractive(false)
+			defer ui.SetInteractive(ui.IsTerminal())
+
+			f := testutils.NewFixture(t, ctx.DB)
+			f.Book("golang")
+
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			if tc.expectedErr == "" {
+				if result.Err != nil {
+					t.Fatal(errors.Wrap(result.Err, "executing"))
+				}
+				assert.Equal(t, f.MustCountNotes(), 1, "note count mismatch")
+			} else {
+				if result.Err == nil || !strings.Contains(result.Err.Error(), tc.expectedErr) {
+					t.Errorf("expected an error containing %q but got %v", tc.expectedErr, result.Err)
+				}
+				assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch")
+			}
+			assert.Equal(t, f.MustCountBooks(), tc.expectedBooks, "book count mismatch")
+		})
+	}
+}
+
+func TestAdd_clipboard(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	ctx.Clipboard = clipboard.NewMemory("copied\nfrom the clipboard")
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("js")
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "js", "--clipboard")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "executing"))
+	}
+
+	var body string
+	database.MustScan(t, "getting the note", ctx.DB.QueryRow("SELECT body FROM notes WHERE book_uuid = ?", b.UUID), &body)
+	assert.Equal(t, body, "copied\nfrom the clipboard", "body mismatch")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "js", "--clipboard", "-c", "n1 body")
+	if result.Err == nil || !strings.Contains(result.Err.Error(), "--content and --clipboard cannot be used together") {
+		t.Errorf("expected an error for both flags but got %v", result.Err)
+	}
+	assert.Equal(t, f.MustCountNotes(), 1, "note count mismatch")
+}
+
+func TestAdd_noteSize(t *testing.T) {
+	testCases := []struct {
+		name          string
+		args          []string
+		expectedCount int
+		expectedErr   string
+		expectedWarn  string
+	}{
+		{
+			name:          "below the warning",
+			args:          []string{"js", "-c", "small"},
+			expectedCount: 1,
+		},
+		{
+			name:          "above the warning",
+			args:          []string{"js", "-c", "a larger note"},
+			expectedCount: 1,
+			expectedWarn:  "the content is 13 bytes, above 10 bytes",
+		},
+		{
+			name:          "above the limit",
+			args:          []string{"js", "-c", "a note that is too large"},
+			expectedCount: 0,
+			expectedErr:   "The content is 24 bytes, above the limit of 20 bytes",
+		},
+		{
+			name:          "above the limit with force",
+			args:          []string{"js", "-c", "a note that is too large", "--force"},
+			expectedCount: 1,
+			expectedWarn:  "saving the content of 24 bytes above the limit of 20 bytes",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			ctx.NoteSizeWarn = 10
+			ctx.NoteSizeMax = 20
+
+			f := testutils.NewFixture(t, ctx.DB)
+			f.Book("js")
+
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			if tc.expectedErr == "" {
+				assert.Equal(t, result.Err, nil, "error mismatch")
+			} else if result.Err == nil || !strings.Contains(result.Err.Error(), tc.expectedErr) {
+				t.Errorf("expected an error containing '%s' but got %v", tc.expectedErr, result.Err)
+			}
+			if tc.expectedWarn != "" && !strings.Contains(string(result.Stdout), tc.expectedWarn) {
+				t.Errorf("expected a warning containing '%s' but got %q", tc.expectedWarn, result.Stdout)
+			}
+			assert.Equal(t, f.MustCountNotes(), tc.expectedCount, "note count mismatch")
+		})
+	}
+}
@@ -0,0 +1,169 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package alias
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/cmd/root"
+	"github.com/dnote/dnote/pkg/cli/config"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * List all aliases
+ dnote alias list
+
+ * Add an alias
+ dnote alias add til "add learnings"
+
+ * Remove an alias
+ dnote alias remove til`
+
+// NewCmd returns a new alias command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "alias",
+		Short:   "Manage command aliases",
+		Example: example,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List all aliases",
+		Args:  cobra.NoArgs,
+		RunE:  newListRun(ctx),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "add <name> <command>",
+		Short: "Add an alias or replace an existing one",
+		Args:  cobra.ExactArgs(2),
+		RunE:  newAddRun(ctx),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove an alias",
+		Args:  cobra.ExactArgs(1),
+		RunE:  newRemoveRun(ctx),
+	})
+
+	return cmd
+}
+
+func newListRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		cf, err := config.Read(ctx)
+		if err != nil {
+			return errors.Wrap(err, "reading config")
+		}
+
+		names := make([]string, 0, len(cf.Aliases))
+		for name := range cf.Aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			log.Plainf("%s %s\n", log.ColorYellow.Sprintf("%s:", name), cf.Aliases[name])
+		}
+
+		return nil
+	}
+}
+
+// validate checks that the alias can be added to the existing aliases
+func validate(aliases map[string]string, name, value string) error {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, " \t\n") {
+		return errors.Errorf("invalid alias name '%s'", name)
+	}
+	if root.IsBuiltin(name) {
+		return errors.Errorf("'%s' is a built-in command", name)
+	}
+
+	next := map[string]string{name: value}
+	for k, v := range aliases {
+		if k != name {
+			next[k] = v
+		}
+	}
+
+	if _, err := resolve(next, name, root.IsBuiltin); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func newAddRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		name, value := args[0], args[1]
+
+		cf, err := config.Read(ctx)
+		if err != nil {
+			return errors.Wrap(err, "reading config")
+		}
+
+		if err := validate(cf.Aliases, name, value); err != nil {
+			return errors.Wrap(err, "validating the alias")
+		}
+
+		if cf.Aliases == nil {
+			cf.Aliases = map[string]string{}
+		}
+		cf.Aliases[name] = value
+
+		if err := config.Write(ctx, cf); err != nil {
+			return errors.Wrap(err, "writing config")
+		}
+
+		log.Success(i18n.T("alias.added", name))
+
+		return nil
+	}
+}
+
+func newRemoveRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+
+		cf, err := config.Read(ctx)
+		if err != nil {
+			return errors.Wrap(err, "reading config")
+		}
+
+		if _, ok := cf.Aliases[name]; !ok {
+			return errors.Errorf("alias '%s' not found", name)
+		}
+		delete(cf.Aliases, name)
+
+		if err := config.Write(ctx, cf); err != nil {
+			return errors.Wrap(err, "writing config")
+		}
+
+		log.Success(i18n.T("alias.removed", name))
+
+		return nil
+	}
+}
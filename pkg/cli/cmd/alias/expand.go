@@ -0,0 +1,157 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package alias
+
+import (
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/cmd/root"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// noAliasFlag is the global flag that disables the alias expansion
+const noAliasFlag = "--no-alias"
+
+// split splits the string into words in the way a shell does, honoring
+// single quotes, double quotes and backslash escapes
+func split(s string) ([]string, error) {
+	var ret []string
+	var cur strings.Builder
+	var quote rune
+
+	inWord := false
+	escaped := false
+
+	for _, c := range s {
+		if escaped {
+			cur.WriteRune(c)
+			escaped = false
+			continue
+		}
+
+		switch {
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				ret = append(ret, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(c)
+			inWord = true
+		}
+	}
+
+	if escaped {
+		return nil, errors.New("unterminated escape")
+	}
+	if quote != 0 {
+		return nil, errors.Errorf("unterminated quote %c", quote)
+	}
+	if inWord {
+		ret = append(ret, cur.String())
+	}
+
+	return ret, nil
+}
+
+// resolve returns the words that the given name expands to, following the
+// aliases that refer to other aliases until a name that is not an alias is
+// reached.
+func resolve(aliases map[string]string, name string, isBuiltin func(string) bool) ([]string, error) {
+	words := []string{name}
+	seen := map[string]bool{}
+
+	for {
+		head := words[0]
+		if isBuiltin(head) {
+			return words, nil
+		}
+
+		value, ok := aliases[head]
+		if !ok {
+			return words, nil
+		}
+
+		if seen[head] {
+			return nil, errors.Errorf("alias '%s' is recursive", name)
+		}
+		seen[head] = true
+
+		expansion, err := split(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing alias '%s'", head)
+		}
+		if len(expansion) == 0 {
+			return nil, errors.Errorf("alias '%s' is empty", head)
+		}
+
+		words = append(expansion, words[1:]...)
+	}
+}
+
+// Expand replaces the command name in the given command line arguments with
+// the alias it refers to, if any. Built-in commands take precedence over the
+// aliases of the same name. The rest of the arguments are kept as they are.
+func Expand(aliases map[string]string, args []string, isBuiltin func(string) bool) ([]string, error) {
+	idx := root.CommandIndex(args)
+	if idx == -1 {
+		return args, nil
+	}
+	for _, arg := range args[:idx] {
+		if arg == noAliasFlag {
+			return args, nil
+		}
+	}
+
+	name := args[idx]
+	if isBuiltin(name) {
+		if _, ok := aliases[name]; ok {
+			log.Warn(i18n.T("alias.shadowed", name))
+		}
+
+		return args, nil
+	}
+
+	words, err := resolve(aliases, name, isBuiltin)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(args)+len(words)-1)
+	ret = append(ret, args[:idx]...)
+	ret = append(ret, words...)
+	ret = append(ret, args[idx+1:]...)
+
+	return ret, nil
+}
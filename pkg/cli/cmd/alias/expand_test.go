@@ -0,0 +1,201 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package alias
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func isBuiltin(name string) bool {
+	return name == "add" || name == "find" || name == "view" || name == "v"
+}
+
+func TestSplit(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			input:    "",
+			expected: nil,
+		},
+		{
+			input:    "find -i",
+			expected: []string{"find", "-i"},
+		},
+		{
+			input:    "  add   learnings ",
+			expected: []string{"add", "learnings"},
+		},
+		{
+			input:    `add js -c "a quoted note"`,
+			expected: []string{"add", "js", "-c", "a quoted note"},
+		},
+		{
+			input:    `add js -c 'it''s "single"'`,
+			expected: []string{"add", "js", "-c", `its "single"`},
+		},
+		{
+			input:    `add js -c a\ b\"c`,
+			expected: []string{"add", "js", "-c", `a b"c`},
+		},
+		{
+			input:    `add js -c ""`,
+			expected: []string{"add", "js", "-c", ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := split(tc.input)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.DeepEqual(t, result, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestSplit_invalid(t *testing.T) {
+	testCases := []string{
+		`add "js`,
+		`add 'js`,
+		`add js\`,
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc, func(t *testing.T) {
+			_, err := split(tc)
+			assert.NotEqual(t, err, nil, "error mismatch")
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	aliases := map[string]string{
+		"a":    "add",
+		"til":  "add learnings",
+		"f":    "find -i",
+		"t":    "til",
+		"note": `add js -c "a quoted note"`,
+		"add":  "view",
+	}
+
+	testCases := []struct {
+		args     []string
+		expected []string
+	}{
+		{
+			args:     []string{},
+			expected: []string{},
+		},
+		{
+			args:     []string{"a", "js"},
+			expected: []string{"add", "js"},
+		},
+		{
+			args:     []string{"til", "-c", "a note with  spaces and \"quotes\""},
+			expected: []string{"add", "learnings", "-c", "a note with  spaces and \"quotes\""},
+		},
+		{
+			args:     []string{"--verbose", "f", "foo"},
+			expected: []string{"--verbose", "find", "-i", "foo"},
+		},
+		{
+			// aliases referring to another alias
+			args:     []string{"t", "-c", "foo"},
+			expected: []string{"add", "learnings", "-c", "foo"},
+		},
+		{
+			args:     []string{"note"},
+			expected: []string{"add", "js", "-c", "a quoted note"},
+		},
+		{
+			// built-in commands take precedence
+			args:     []string{"add", "js"},
+			expected: []string{"add", "js"},
+		},
+		{
+			args:     []string{"v", "js"},
+			expected: []string{"v", "js"},
+		},
+		{
+			// only the command name is expanded
+			args:     []string{"find", "a"},
+			expected: []string{"find", "a"},
+		},
+		{
+			args:     []string{"--no-alias", "a", "js"},
+			expected: []string{"--no-alias", "a", "js"},
+		},
+		{
+			// the values of the global flags are not taken for the command name
+			args:     []string{"--log-file", "x.log", "a", "js"},
+			expected: []string{"--log-file", "x.log", "add", "js"},
+		},
+		{
+			args:     []string{"--log-file", "a", "a", "js"},
+			expected: []string{"--log-file", "a", "add", "js"},
+		},
+		{
+			args:     []string{"--log-file", "x.log", "--no-alias", "a", "js"},
+			expected: []string{"--log-file", "x.log", "--no-alias", "a", "js"},
+		},
+		{
+			args:     []string{"unknown", "js"},
+			expected: []string{"unknown", "js"},
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			result, err := Expand(aliases, tc.args, isBuiltin)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.DeepEqual(t, result, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestExpand_recursive(t *testing.T) {
+	testCases := []map[string]string{
+		{
+			"a": "a",
+		},
+		{
+			"a": "b -c foo",
+			"b": "c",
+			"c": "a",
+		},
+	}
+
+	for idx, aliases := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			_, err := Expand(aliases, []string{"a"}, isBuiltin)
+			assert.NotEqual(t, err, nil, "error mismatch")
+		})
+	}
+}
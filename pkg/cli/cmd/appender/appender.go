@@ -0,0 +1,124 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package appender
+
+import (
+	"github.com/dnote/dnote/pkg/cli/cmd/last"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/output"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Append a line to the note added last
+ dnote append "one more thought"
+
+ * Append a line to the note added last to a book
+ dnote append javascript "one more thought"
+
+ * Append a line to a note in a book by id
+ dnote append javascript 12 "one more thought"`
+
+// NewCmd returns a new append command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "append <book name?> <note id|uuid prefix?> <text>",
+		Short:   "Append a line to a note",
+		Example: example,
+		Args:    cobra.RangeArgs(1, 3),
+		RunE:    newRun(ctx),
+	}
+
+	return cmd
+}
+
+// findNote returns the note with the given id or uuid prefix in the book with
+// the given name
+func findNote(ctx context.DnoteCtx, bookName, noteRef string) (string, error) {
+	bookName, err := ui.ResolveBook(ctx, bookName)
+	if err != nil {
+		return "", errors.Wrap(err, "resolving the book")
+	}
+
+	rowID, ok, err := database.ResolveNoteRef(ctx.DB, noteRef, false)
+	if err != nil {
+		return "", errors.Wrap(err, "resolving the note")
+	}
+	if !ok {
+		return "", errors.Errorf("note '%s' not found. Use its id or a prefix of its uuid of at least %d characters", noteRef, database.MinUUIDPrefixLength)
+	}
+
+	info, err := database.GetNoteInfo(ctx.DB, rowID)
+	if err != nil {
+		return "", errors.Wrap(err, "getting the note")
+	}
+	if info.BookLabel != bookName {
+		return "", errors.Errorf("note '%s' is not in book '%s'", noteRef, bookName)
+	}
+
+	return info.UUID, nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		text := args[len(args)-1]
+
+		var uuid string
+		if len(args) == 3 {
+			var err error
+			if uuid, err = findNote(ctx, args[0], args[1]); err != nil {
+				return err
+			}
+		} else {
+			var bookName string
+			if len(args) == 2 {
+				bookName = args[0]
+			}
+
+			note, err := last.Find(ctx, bookName)
+			if err != nil {
+				return err
+			}
+
+			uuid = note.UUID
+		}
+
+		note, err := dnote.NewStore(ctx).AppendNote(uuid, text)
+		if err != nil {
+			return errors.Wrap(err, "appending to the note")
+		}
+
+		info, err := database.GetNoteInfoByUUID(ctx.DB, note.UUID)
+		if err != nil {
+			return errors.Wrap(err, "getting the note")
+		}
+
+		log.Success(i18n.T("append.appended", note.Book))
+		output.NoteInfo(ctx, info)
+
+		return nil
+	}
+}
@@ -0,0 +1,89 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package appender
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+)
+
+func TestAppend(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		// expected is the index of the note to which the text is appended, or
+		// -1 if the command fails
+		expected    int
+		expectedErr string
+	}{
+		{name: "last note", args: []string{"more text"}, expected: 1},
+		{name: "last note in a book", args: []string{"js", "more text"}, expected: 2},
+		{name: "note by id", args: []string{"js", "1", "more text"}, expected: 0},
+		{name: "note in another book", args: []string{"css", "1", "more text"}, expected: -1, expectedErr: "note '1' is not in book 'css'"},
+		{name: "empty book", args: []string{"go", "more text"}, expected: -1, expectedErr: "no notes in book 'go'"},
+		{name: "empty text", args: []string{"js", " "}, expected: -1, expectedErr: "appending to the note: empty content"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			now := testutils.CmdNow
+			f := testutils.NewFixture(t, ctx.DB)
+			js := f.Book("js")
+			css := f.Book("css")
+			f.Book("go")
+			bodies := []string{"n1 body", "n2 body\n", "n3 body"}
+			uuids := []string{
+				f.Note(js, bodies[0], testutils.With.USN(1), testutils.With.AddedOn(now.Add(-3*time.Hour).UnixNano())).UUID,
+				f.Note(css, bodies[1], testutils.With.USN(2), testutils.With.AddedOn(now.Add(-1*time.Hour).UnixNano())).UUID,
+				f.Note(js, bodies[2], testutils.With.USN(3), testutils.With.AddedOn(now.Add(-2*time.Hour).UnixNano())).UUID,
+			}
+
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			if tc.expectedErr == "" {
+				assert.Equal(t, result.Err, nil, "error mismatch")
+			} else if result.Err == nil || !strings.Contains(result.Err.Error(), tc.expectedErr) {
+				t.Errorf("expected an error containing '%s' but got %v", tc.expectedErr, result.Err)
+			}
+
+			for idx, uuid := range uuids {
+				note := f.MustGetNote(uuid)
+
+				if idx == tc.expected {
+					assert.Equal(t, note.Body, fmt.Sprintf("n%d body\nmore text", idx+1), "appended body mismatch")
+					assert.Equal(t, note.EditedOn, now.UnixNano(), "edited_on mismatch")
+					assert.Equal(t, note.Dirty, true, "dirty mismatch")
+				} else {
+					assert.Equal(t, note.Body, bodies[idx], "body mismatch")
+					assert.Equal(t, note.Dirty, false, "dirty mismatch")
+				}
+			}
+		})
+	}
+}
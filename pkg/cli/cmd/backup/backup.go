@@ -0,0 +1,239 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dnote/dnote/pkg/cli/backup"
+	"github.com/dnote/dnote/pkg/cli/config"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/gpg"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Write a backup archive to the current directory
+ dnote backup
+
+ * Write a backup archive to a directory
+ dnote backup --output ~/backups
+
+ * Upload a backup archive to a bucket and keep the 14 most recent ones
+ dnote backup --s3 s3://my-bucket/dnote --keep 14
+
+ * Encrypt the backup archive to a gpg key
+ dnote backup --encrypt me@example.com`
+
+var outputFlag string
+var s3Flag string
+var keepFlag int
+var encryptFlag string
+
+// NewCmd returns a new backup command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "backup",
+		Short:   "Back up the database and the config file",
+		Example: example,
+		Args:    cobra.NoArgs,
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&outputFlag, "output", "o", ".", "the directory to write the backup archive to")
+	f.StringVarP(&s3Flag, "s3", "", "", "upload the backup archive to an S3-compatible bucket, given as s3://bucket/prefix")
+	f.IntVarP(&keepFlag, "keep", "", 0, "delete the older backup archives in the bucket, keeping the given number of the most recent ones")
+	f.StringVarP(&encryptFlag, "encrypt", "", "", "encrypt the backup archive with gpg to the given recipient")
+
+	return cmd
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if keepFlag < 0 {
+		return errors.New("--keep cannot be negative")
+	}
+	if keepFlag > 0 && s3Flag == "" {
+		return errors.New("--keep requires --s3")
+	}
+
+	return nil
+}
+
+// archiveName returns the name of a backup archive created now
+func archiveName(ctx context.DnoteCtx, recipient string) string {
+	name := backup.ArchiveName(ctx.Clock.Now())
+	if recipient != "" {
+		name += gpg.Ext
+	}
+
+	return name
+}
+
+// encryptArchive encrypts the archive at the given path to the recipient,
+// replacing it with a temporary file in the same directory, and returns the
+// path of the encrypted archive
+func encryptArchive(path, recipient string) (string, error) {
+	defer os.Remove(path)
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err, "opening the archive")
+	}
+	defer src.Close()
+
+	dst, err := ioutil.TempFile(filepath.Dir(path), ".dnote-backup-*")
+	if err != nil {
+		return "", errors.Wrap(err, "creating a temporary file")
+	}
+
+	err = gpg.Encrypt(src, dst, recipient)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", errors.Wrap(err, "encrypting the archive")
+	}
+
+	return dst.Name(), nil
+}
+
+// writeArchive writes a backup archive to a temporary file in the given
+// directory and returns its path. The archive is encrypted if the recipient
+// is not empty.
+func writeArchive(ctx context.DnoteCtx, dir, recipient string) (string, error) {
+	f, err := ioutil.TempFile(dir, ".dnote-backup-*")
+	if err != nil {
+		return "", errors.Wrap(err, "creating a temporary file")
+	}
+
+	err = backup.WriteArchive(f, ctx.DB, config.GetPath(ctx), ctx.Clock.Now())
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "writing the archive")
+	}
+
+	if recipient != "" {
+		return encryptArchive(f.Name(), recipient)
+	}
+
+	return f.Name(), nil
+}
+
+// writeLocal writes a backup archive to the given directory. The archive is
+// renamed into place once complete so that a failure does not leave a
+// partial archive.
+func writeLocal(ctx context.DnoteCtx, dir, recipient string) (string, error) {
+	tmpPath, err := writeArchive(ctx, dir, recipient)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, archiveName(ctx, recipient))
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", errors.Wrap(err, "moving the archive into place")
+	}
+
+	return path, nil
+}
+
+// upload uploads a backup archive under the prefix and deletes the older
+// archives beyond the given number, unless it is zero
+func upload(ctx context.DnoteCtx, u backup.Uploader, prefix string, keep int, recipient string) error {
+	tmpPath, err := writeArchive(ctx, "", recipient)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return errors.Wrap(err, "opening the archive")
+	}
+	defer f.Close()
+
+	key, err := backup.Upload(ctx.Context(), u, prefix, archiveName(ctx, recipient), f)
+	if err != nil {
+		return err
+	}
+	log.Success(i18n.T("backup.uploaded", key))
+
+	if keep == 0 {
+		return nil
+	}
+
+	deleted, err := backup.Prune(ctx.Context(), u, prefix, keep)
+	if err != nil {
+		return errors.Wrap(err, "deleting the older backups")
+	}
+	for _, key := range deleted {
+		log.Info(i18n.T("backup.deleted_older", key))
+	}
+
+	return nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if s3Flag == "" {
+			path, err := writeLocal(ctx, outputFlag, encryptFlag)
+			if err != nil {
+				return err
+			}
+
+			log.Success(i18n.T("backup.wrote", path))
+			return nil
+		}
+
+		bucket, prefix, err := backup.ParseS3URL(s3Flag)
+		if err != nil {
+			return err
+		}
+
+		cf, err := config.Read(ctx)
+		if err != nil {
+			return errors.Wrap(err, "reading config")
+		}
+
+		s3Config, err := backup.ResolveS3Config(cf.Backup.S3, os.Getenv, ctx.Paths.Home)
+		if err != nil {
+			return errors.Wrap(err, "getting the S3 settings")
+		}
+
+		return upload(ctx, backup.NewS3(s3Config, bucket), prefix, keepFlag, encryptFlag)
+	}
+}
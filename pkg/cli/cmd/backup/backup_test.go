@@ -0,0 +1,78 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/gpg"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestBackup_encrypt(t *testing.T) {
+	testutils.SetupGPG(t)
+
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	outDir := filepath.Join(dir, "backups")
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "creating the directory"))
+	}
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "--output", outDir, "--encrypt", testutils.GPGRecipient)
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	matches, err := filepath.Glob(filepath.Join(outDir, "*"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "listing the directory"))
+	}
+	assert.Equal(t, len(matches), 1, "file count mismatch")
+	assert.Equal(t, gpg.HasExt(matches[0]), true, "the archive does not have the encrypted extension")
+
+	f, err := os.Open(matches[0])
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the archive"))
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if err := gpg.Decrypt(f, &buf); err != nil {
+		t.Fatal(errors.Wrap(err, "decrypting the archive"))
+	}
+
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the compressed stream"))
+	}
+	hdr, err := tar.NewReader(gr).Next()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the archive"))
+	}
+	assert.Equal(t, hdr.Name, "dnote.db", "file name mismatch")
+}
@@ -0,0 +1,41 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package book implements the commands that work on a whole book
+package book
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/spf13/cobra"
+)
+
+// NewCmd returns a new book command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "book",
+		Short: "Export, import, edit and set the retention policy or the merge strategy of a single book",
+	}
+
+	cmd.AddCommand(newExportCmd(ctx))
+	cmd.AddCommand(newImportCmd(ctx))
+	cmd.AddCommand(newRetentionCmd(ctx))
+	cmd.AddCommand(newStrategyCmd(ctx))
+	cmd.AddCommand(newEditAllCmd(ctx))
+
+	return cmd
+}
@@ -0,0 +1,323 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package book
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func newTestCtx(t *testing.T) context.DnoteCtx {
+	dir := t.TempDir()
+
+	return context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+}
+
+// exportBook exports the kubernetes book with two notes, a removed note and
+// a note in another book, and returns the path of the archive and the notes
+func exportBook(t *testing.T) (string, []database.Note) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("kubernetes")
+	b2 := f.Book("css")
+	n1 := f.Note(b1, "kubectl get pods", testutils.With.UUID("a3a82d4c-06a6-4a5e-a1d3-f6e7e6b5d1b1"), testutils.With.AddedOn(1541108743000000000))
+	n2 := f.Note(b1, "kubectl logs -f <pod>", testutils.With.UUID("b5f3c7f1-93a2-4c2b-8c35-3d1e8a2f6f0e"), testutils.With.AddedOn(1541108744000000000), testutils.With.EditedOn(1541108745000000000))
+	f.Note(b1, "removed note", testutils.With.AddedOn(1541108746000000000), testutils.With.Deleted())
+	f.Note(b2, "other book", testutils.With.AddedOn(1541108747000000000))
+
+	path := filepath.Join(t.TempDir(), "kubernetes.dnotebook")
+	result := testutils.RunCmd(t, ctx, NewCmd, "export", "kubernetes", "--out", path)
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "exporting"))
+	}
+
+	return path, []database.Note{n1, n2}
+}
+
+// getBookNotes returns the notes in the book with the label in the order in
+// which they were added
+func getBookNotes(t *testing.T, f *testutils.Fixture, db *database.DB, label string) (database.Book, []database.Note) {
+	bookUUID, err := database.GetBookUUID(db, label)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "finding the book"))
+	}
+
+	rows, err := db.Query("SELECT uuid FROM notes WHERE book_uuid = ? ORDER BY added_on ASC", bookUUID)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "querying notes"))
+	}
+	defer rows.Close()
+
+	uuids := []string{}
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			t.Fatal(errors.Wrap(err, "scanning a row"))
+		}
+		uuids = append(uuids, uuid)
+	}
+
+	notes := []database.Note{}
+	for _, uuid := range uuids {
+		notes = append(notes, f.MustGetNote(uuid))
+	}
+
+	return f.MustGetBook(bookUUID), notes
+}
+
+func TestBook_roundTrip(t *testing.T) {
+	path, expected := exportBook(t)
+
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+	f := testutils.NewFixture(t, ctx.DB)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "import", path)
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "importing"))
+	}
+
+	book, notes := getBookNotes(t, f, ctx.DB, "kubernetes")
+	assert.Equal(t, book.USN, 0, "book usn mismatch")
+	assert.Equal(t, book.Dirty, true, "book dirty mismatch")
+	assert.Equal(t, len(notes), len(expected), "note count mismatch")
+
+	for i, n := range notes {
+		assert.NotEqual(t, n.UUID, expected[i].UUID, "the uuid should be new")
+		assert.Equal(t, n.BookUUID, book.UUID, "book uuid mismatch")
+		assert.Equal(t, n.Body, expected[i].Body, "body mismatch")
+		assert.Equal(t, n.AddedOn, expected[i].AddedOn, "added on mismatch")
+		assert.Equal(t, n.EditedOn, expected[i].EditedOn, "edited on mismatch")
+		assert.Equal(t, n.USN, 0, "usn mismatch")
+		assert.Equal(t, n.Dirty, true, "dirty mismatch")
+	}
+	assert.Equal(t, f.MustCountNotes(), 2, "the removed note and the other book should not be imported")
+}
+
+func TestBookImport_preserveUUIDs(t *testing.T) {
+	path, expected := exportBook(t)
+
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+	f := testutils.NewFixture(t, ctx.DB)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "import", path, "--preserve-uuids")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "importing"))
+	}
+
+	_, notes := getBookNotes(t, f, ctx.DB, "kubernetes")
+	assert.Equal(t, len(notes), len(expected), "note count mismatch")
+	for i, n := range notes {
+		assert.Equal(t, n.UUID, expected[i].UUID, "uuid mismatch")
+	}
+}
+
+func TestBookImport_collision(t *testing.T) {
+	path, expected := exportBook(t)
+
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("kubernetes")
+	f.Note(b1, expected[0].Body)
+	f.Book("kubernetes_2")
+
+	// the book is renamed and the note that is already here is skipped
+	result := testutils.RunCmd(t, ctx, NewCmd, "import", path)
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "importing"))
+	}
+
+	_, notes := getBookNotes(t, f, ctx.DB, "kubernetes_3")
+	assert.Equal(t, len(notes), 1, "note count mismatch")
+	assert.Equal(t, notes[0].Body, expected[1].Body, "body mismatch")
+
+	// every note is a duplicate now, so no book is created
+	result = testutils.RunCmd(t, ctx, NewCmd, "import", path, "--preserve-uuids")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "importing again"))
+	}
+
+	assert.Equal(t, f.MustCountBooks(), 3, "book count mismatch")
+	assert.Equal(t, f.MustCountNotes(), 2, "note count mismatch")
+}
+
+func TestBook_invalid(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	f.Book("kubernetes")
+
+	dir := t.TempDir()
+	testCases := [][]string{
+		{"export", "kubernetes"},
+		{"export", "css", "--out", filepath.Join(dir, "css.dnotebook")},
+		{"import", filepath.Join(dir, "missing.dnotebook")},
+	}
+
+	for _, args := range testCases {
+		t.Run(args[0]+" "+args[1], func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+
+			assert.NotEqual(t, result.Err, nil, "error mismatch")
+		})
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("inbox")
+
+	getPolicy := func() database.BookRetention {
+		r, err := database.GetBookRetention(ctx.DB, b.UUID)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting the policy"))
+		}
+
+		return r
+	}
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "retention", "inbox", "--max-age", "90d", "--max-count", "500")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "setting the policy"))
+	}
+	assert.Equal(t, getPolicy().MaxAge, 90*24*time.Hour, "max age mismatch")
+	assert.Equal(t, getPolicy().MaxCount, 500, "max count mismatch")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "retention", "inbox", "--max-count", "0")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "updating the policy"))
+	}
+	assert.Equal(t, getPolicy().MaxAge, 90*24*time.Hour, "max age mismatch after update")
+	assert.Equal(t, getPolicy().MaxCount, 0, "max count mismatch after update")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "retention", "inbox")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "printing the policy"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "more than 90d ago"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "retention", "inbox", "--clear")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "clearing the policy"))
+	}
+	assert.Equal(t, getPolicy().MaxAge, time.Duration(0), "max age mismatch after clear")
+
+	t.Run("invalid", func(t *testing.T) {
+		testCases := [][]string{
+			{"retention", "inbox", "--max-age", "1w"},
+			{"retention", "inbox", "--max-count=-1"},
+			{"retention", "inbox", "--clear", "--max-count", "1"},
+			{"retention", "unknown", "--max-count", "1"},
+		}
+
+		for _, args := range testCases {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+			assert.NotEqual(t, result.Err, nil, fmt.Sprintf("error mismatch for %v", args))
+		}
+	})
+}
+
+func TestSetStrategy(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("journal")
+
+	getStrategy := func() string {
+		s, err := database.GetBookStrategy(ctx.DB, b.UUID)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting the strategy"))
+		}
+
+		return s
+	}
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "set-strategy", "journal", "local")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "setting the strategy"))
+	}
+	assert.Equal(t, getStrategy(), "local", "strategy mismatch")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "set-strategy", "journal")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "printing the strategy"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "journal is local"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "set-strategy", "journal", "--clear")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "clearing the strategy"))
+	}
+	assert.Equal(t, getStrategy(), "", "strategy mismatch after clear")
+
+	t.Run("aliases", func(t *testing.T) {
+		testCases := []struct {
+			alias    string
+			expected string
+		}{
+			{alias: "keep-local", expected: consts.ConflictStrategyLocal},
+			{alias: "keep-server", expected: consts.ConflictStrategyServer},
+			{alias: "newest", expected: consts.ConflictStrategyNewer},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.alias, func(t *testing.T) {
+				result := testutils.RunCmd(t, ctx, NewCmd, "set-strategy", "journal", tc.alias)
+				if result.Err != nil {
+					t.Fatal(errors.Wrap(result.Err, "setting the strategy"))
+				}
+
+				assert.Equal(t, getStrategy(), tc.expected, "strategy mismatch")
+			})
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		testCases := [][]string{
+			{"set-strategy", "journal", "keep-remote"},
+			{"set-strategy", "journal", "local", "--clear"},
+			{"set-strategy", "unknown", "local"},
+		}
+
+		for _, args := range testCases {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+			assert.NotEqual(t, result.Err, nil, fmt.Sprintf("error mismatch for %v", args))
+		}
+	})
+}
@@ -0,0 +1,174 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package book
+
+import (
+	"io/ioutil"
+
+	"github.com/dnote/dnote/pkg/cli/bulkedit"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var editAllExample = `
+ * Edit all notes of javascript at once in the editor
+ dnote book edit-all javascript
+
+ * Apply an edited text that was kept after an invalid edit
+ dnote book edit-all javascript --file ~/.cache/dnote/DNOTE_TMPCONTENT_0.md`
+
+var fileFlag string
+
+func newEditAllCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "edit-all <book name>",
+		Short:   "Edit all notes of a book at once in the editor",
+		Example: editAllExample,
+		Args:    cobra.ExactArgs(1),
+		RunE:    newEditAllRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&fileFlag, "file", "", "", "apply the edited text in the given file instead of launching the editor")
+	f.BoolVarP(&forceFlag, "force", "", false, "save the notes above the size limit, and replace the invalid bytes in the notes")
+
+	return cmd
+}
+
+// parseEdit returns the changes to the notes made in the edited text
+func parseEdit(ctx context.DnoteCtx, notes []bulkedit.Note, text string) (bulkedit.Changes, error) {
+	sections, err := bulkedit.Parse(text)
+	if err != nil {
+		return bulkedit.Changes{}, err
+	}
+
+	c, err := bulkedit.Diff(notes, sections)
+	if err != nil {
+		return bulkedit.Changes{}, err
+	}
+
+	for i, n := range c.Updated {
+		body, err := ui.NormalizeContent(n.Body, forceFlag)
+		if err != nil {
+			return bulkedit.Changes{}, errors.Wrapf(err, "invalid content of the note %s", n.UUID)
+		}
+		if err := ui.CheckContentSize(ctx, body, forceFlag); err != nil {
+			return bulkedit.Changes{}, errors.Wrapf(err, "invalid content of the note %s", n.UUID)
+		}
+
+		c.Updated[i].Body = body
+	}
+	for i, body := range c.Added {
+		body, err := ui.NormalizeContent(body, forceFlag)
+		if err != nil {
+			return bulkedit.Changes{}, errors.Wrap(err, "invalid content of a new note")
+		}
+		if err := ui.CheckContentSize(ctx, body, forceFlag); err != nil {
+			return bulkedit.Changes{}, errors.Wrap(err, "invalid content of a new note")
+		}
+
+		c.Added[i] = body
+	}
+
+	return c, nil
+}
+
+// editText returns the text of the notes edited in the editor, or read from
+// the file given by --file, and the path of the file in which the text is kept
+// if it cannot be applied
+func editText(ctx context.DnoteCtx, label string, notes []bulkedit.Note) (string, string, error) {
+	if fileFlag != "" {
+		b, err := ioutil.ReadFile(fileFlag)
+		if err != nil {
+			return "", "", errors.Wrap(err, "reading the file")
+		}
+
+		return string(b), fileFlag, nil
+	}
+
+	fpath, err := ui.GetTmpContentPath(ctx)
+	if err != nil {
+		return "", "", errors.Wrap(err, "getting temporarily content file path")
+	}
+	if err := ioutil.WriteFile(fpath, []byte(bulkedit.Format(label, notes)), 0644); err != nil {
+		return "", "", errors.Wrap(err, "preparing tmp content file")
+	}
+
+	text, err := ui.GetEditorInput(ctx, fpath, "--file")
+	if err != nil {
+		return "", "", errors.Wrap(err, "getting editor input")
+	}
+
+	return text, fpath, nil
+}
+
+func newEditAllRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		label := args[0]
+		store := dnote.NewStore(ctx)
+
+		list, err := store.Notes(dnote.NoteFilter{Book: label})
+		if err == dnote.ErrBookNotFound {
+			return errors.Errorf("book '%s' not found", label)
+		} else if err != nil {
+			return errors.Wrap(err, "getting the notes")
+		}
+
+		notes := make([]bulkedit.Note, len(list))
+		for i, n := range list {
+			notes[i] = bulkedit.Note{UUID: n.UUID, Body: n.Body}
+		}
+
+		text, fpath, err := editText(ctx, label, notes)
+		if err != nil {
+			return err
+		}
+
+		c, err := parseEdit(ctx, notes, text)
+		if err == nil && !c.IsZero() {
+			err = store.ApplyBookEdit(label, c)
+		}
+		if err != nil {
+			// the editor input removes its file, which is written back so
+			// that the edit is not lost
+			if fileFlag == "" {
+				if werr := ioutil.WriteFile(fpath, []byte(text), 0644); werr != nil {
+					log.Errorf("%s\n", errors.Wrap(werr, "keeping the edited text").Error())
+					return err
+				}
+			}
+
+			return errors.Wrapf(err, "the edit was not applied. Fix %s and apply it with 'dnote book edit-all %s --file %s'", fpath, label, fpath)
+		}
+
+		if c.IsZero() {
+			log.Info(i18n.T("book.edit_all_nothing"))
+			return nil
+		}
+
+		log.Success(i18n.T("book.edit_all_result", len(c.Updated), len(c.Added), len(c.Removed), label))
+
+		return nil
+	}
+}
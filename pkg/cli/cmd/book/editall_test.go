@@ -0,0 +1,181 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package book
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+)
+
+const (
+	editN1UUID = "1a6d4c2e-7b3f-4a5e-8c9d-0e1f2a3b4c5d"
+	editN2UUID = "3c8d6f2a-9e4b-4f7c-8d1e-2b3c4d5e6f7a"
+	editN3UUID = "5e0f8a4c-1b6d-4e9f-8a3b-4c5d6e7f8a9b"
+)
+
+// setupEditAll returns a context with the notes of the js book, whose editor
+// replaces the text with the given one
+func setupEditAll(t *testing.T, edited string) (context.DnoteCtx, *testutils.Fixture) {
+	ctx := newTestCtx(t)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("js")
+	f.Note(b, "Closures\n- capture the scope", testutils.With.UUID(editN1UUID), testutils.With.AddedOn(1))
+	f.Note(b, "Hoisting", testutils.With.UUID(editN2UUID), testutils.With.AddedOn(2))
+	f.Note(b, "Promises chain", testutils.With.UUID(editN3UUID), testutils.With.AddedOn(3))
+
+	src := filepath.Join(t.TempDir(), "edited.md")
+	writeEditAllFile(t, src, edited)
+	ctx.Editor = fmt.Sprintf("cp '%s'", src)
+
+	return ctx, f
+}
+
+func writeEditAllFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the file"))
+	}
+}
+
+// countBookNotes returns the number of the notes in the book that are not
+// removed
+func countBookNotes(t *testing.T, db *database.DB, label string) int {
+	var count int
+	database.MustScan(t, "counting notes", db.QueryRow(`SELECT count(*) FROM notes
+		INNER JOIN books ON books.uuid = notes.book_uuid
+		WHERE books.label = ? AND notes.deleted = false`, label), &count)
+
+	return count
+}
+
+func TestEditAll(t *testing.T) {
+	ui.SetInteractive(true)
+	defer ui.SetInteractive(ui.IsTerminal())
+
+	// the first note is edited, the second removed, and a note is added
+	edited := `# The notes of the book 'js'
+
+=== dnote: note ` + editN1UUID + ` ===
+Closures
+- capture the scope
+- even after returning
+
+=== dnote: new note ===
+Event loop
+
+=== dnote: note ` + editN3UUID + ` ===
+Promises chain
+`
+
+	ctx, f := setupEditAll(t, edited)
+	defer context.TeardownTestCtx(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "edit-all", "js")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "running the command"))
+	}
+
+	n1 := f.MustGetNote(editN1UUID)
+	assert.Equal(t, n1.Body, "Closures\n- capture the scope\n- even after returning", "n1 body mismatch")
+	assert.Equal(t, n1.Dirty, true, "n1 dirty mismatch")
+	n2 := f.MustGetNote(editN2UUID)
+	assert.Equal(t, n2.Deleted, true, "n2 deleted mismatch")
+	assert.Equal(t, n2.Dirty, true, "n2 dirty mismatch")
+	n3 := f.MustGetNote(editN3UUID)
+	assert.Equal(t, n3.Body, "Promises chain", "n3 body mismatch")
+	assert.Equal(t, n3.Dirty, false, "n3 dirty mismatch")
+
+	var body string
+	var dirty bool
+	database.MustScan(t, "getting the new note", ctx.DB.QueryRow("SELECT body, dirty FROM notes WHERE uuid NOT IN (?, ?, ?)", editN1UUID, editN2UUID, editN3UUID), &body, &dirty)
+	assert.Equal(t, body, "Event loop", "new note body mismatch")
+	assert.Equal(t, dirty, true, "new note dirty mismatch")
+	assert.Equal(t, countBookNotes(t, ctx.DB, "js"), 3, "note count mismatch")
+}
+
+func TestEditAll_mangledMarker(t *testing.T) {
+	ui.SetInteractive(true)
+	defer ui.SetInteractive(ui.IsTerminal())
+
+	edited := `=== dnote: note ` + editN1UUID + ` ===
+Closures
+
+== dnote: note ` + editN2UUID + ` ===
+Hoisting moves the declarations up
+
+=== dnote: note ` + editN3UUID + ` ===
+Promises chain
+`
+
+	ctx, f := setupEditAll(t, edited)
+	defer context.TeardownTestCtx(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "edit-all", "js")
+	assert.NotEqual(t, result.Err, nil, "error mismatch")
+	assert.Equal(t, strings.Contains(result.Err.Error(), "line 4: invalid marker"), true, fmt.Sprintf("error message mismatch: %s", result.Err))
+
+	// nothing is changed
+	assert.Equal(t, f.MustGetNote(editN1UUID).Body, "Closures\n- capture the scope", "n1 body mismatch")
+	assert.Equal(t, f.MustGetNote(editN2UUID).Body, "Hoisting", "n2 body mismatch")
+	assert.Equal(t, countBookNotes(t, ctx.DB, "js"), 3, "note count mismatch")
+
+	// the edited text is kept
+	kept := filepath.Join(ctx.Paths.Cache, fmt.Sprintf("%s_0.%s", consts.TmpContentFileBase, consts.TmpContentFileExt))
+	b, err := ioutil.ReadFile(kept)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the kept text"))
+	}
+	assert.Equal(t, string(b), edited, "kept text mismatch")
+
+	t.Run("apply the fixed file", func(t *testing.T) {
+		writeEditAllFile(t, kept, strings.Replace(edited, "\n== dnote", "\n=== dnote", 1))
+
+		result := testutils.RunCmd(t, ctx, NewCmd, "edit-all", "js", "--file", kept)
+		if result.Err != nil {
+			t.Fatal(errors.Wrap(result.Err, "running the command"))
+		}
+
+		assert.Equal(t, f.MustGetNote(editN1UUID).Body, "Closures", "n1 body mismatch")
+		assert.Equal(t, f.MustGetNote(editN2UUID).Body, "Hoisting moves the declarations up", "n2 body mismatch")
+		assert.Equal(t, f.MustGetNote(editN3UUID).Dirty, false, "n3 dirty mismatch")
+	})
+}
+
+func TestEditAll_nonInteractive(t *testing.T) {
+	ui.SetInteractive(false)
+	defer ui.SetInteractive(ui.IsTerminal())
+
+	ctx, f := setupEditAll(t, "")
+	defer context.TeardownTestCtx(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "edit-all", "js")
+	_, ok := errors.Cause(result.Err).(ui.NonInteractiveError)
+	assert.Equal(t, ok, true, fmt.Sprintf("error mismatch: %v", result.Err))
+	assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+}
@@ -0,0 +1,126 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package book
+
+import (
+	"bytes"
+
+	"github.com/dnote/dnote/pkg/cli/bookarchive"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var exportExample = `
+ * Export a book to hand it to someone else
+ dnote book export kubernetes --out kubernetes.dnotebook`
+
+var outFlag string
+
+func newExportCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "export <book name>",
+		Short:   "Export a book as an archive",
+		Example: exportExample,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: exportPreRun,
+		RunE:    newExportRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&outFlag, "out", "o", "", "the path of the file to write the archive to, such as kubernetes"+bookarchive.Ext)
+
+	return cmd
+}
+
+func exportPreRun(cmd *cobra.Command, args []string) error {
+	if outFlag == "" {
+		return errors.New("--out is required")
+	}
+
+	return nil
+}
+
+// loadArchive returns the archive of the book with the given label. The notes
+// are in the order in which the book lists them, and the removed ones are left
+// out.
+func loadArchive(ctx context.DnoteCtx, label string) (bookarchive.Archive, error) {
+	ret := bookarchive.Archive{
+		Manifest: bookarchive.Manifest{
+			Book:         label,
+			ExportedAt:   ctx.Clock.Now().UnixNano(),
+			DnoteVersion: ctx.Version,
+		},
+		Notes: []bookarchive.Note{},
+	}
+
+	bookUUID, err := database.GetBookUUID(ctx.DB, label)
+	if err != nil {
+		return ret, errors.Wrap(err, "finding the book")
+	}
+
+	rows, err := ctx.DB.Query(`SELECT uuid, body, added_on, edited_on
+	FROM notes
+	WHERE book_uuid = ? AND deleted = false
+	ORDER BY position ASC, added_on ASC, rowid ASC`, bookUUID)
+	if err != nil {
+		return ret, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n bookarchive.Note
+		if err := rows.Scan(&n.UUID, &n.Body, &n.AddedOn, &n.EditedOn); err != nil {
+			return ret, errors.Wrap(err, "scanning a row")
+		}
+
+		ret.Notes = append(ret.Notes, n)
+	}
+	if err := rows.Err(); err != nil {
+		return ret, errors.Wrap(err, "iterating rows")
+	}
+
+	return ret, nil
+}
+
+func newExportRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		a, err := loadArchive(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := bookarchive.Write(&buf, a); err != nil {
+			return errors.Wrap(err, "writing the archive")
+		}
+		if err := utils.WriteFileAtomic(outFlag, buf.Bytes(), 0644); err != nil {
+			return errors.Wrap(err, "writing the archive")
+		}
+
+		log.Success(i18n.T("book.exported", len(a.Notes), a.Manifest.Book, outFlag))
+
+		return nil
+	}
+}
@@ -0,0 +1,271 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package book
+
+import (
+	"crypto/sha256"
+	"os"
+
+	"github.com/dnote/dnote/pkg/cli/bookarchive"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var importExample = `
+ * Import a book exported by someone else
+ dnote book import kubernetes.dnotebook
+
+ * Keep the uuids of the notes, e.g. to move a book between the machines of
+   the same account
+ dnote book import kubernetes.dnotebook --preserve-uuids`
+
+var preserveUUIDsFlag bool
+var forceFlag bool
+
+func newImportCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "import <file>",
+		Short:   "Import a book from an archive",
+		Example: importExample,
+		Args:    cobra.ExactArgs(1),
+		RunE:    newImportRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&preserveUUIDsFlag, "preserve-uuids", "", false, "keep the uuids of the notes in the archive instead of generating new ones")
+	f.BoolVarP(&forceFlag, "force", "", false, "import the notes above the size limit instead of skipping them")
+
+	return cmd
+}
+
+// readArchive reads the archive at the given path
+func readArchive(path string) (bookarchive.Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return bookarchive.Archive{}, errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+
+	a, err := bookarchive.Read(f)
+	if err != nil {
+		return a, errors.Wrapf(err, "reading %s", path)
+	}
+	if err := validate.BookName(a.Manifest.Book); err != nil {
+		return a, errors.Wrapf(err, "invalid book name in %s", path)
+	}
+
+	return a, nil
+}
+
+// hashBody returns the hash of the body by which the duplicate notes are found
+func hashBody(body string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(body))
+}
+
+// getBodyHashes returns the hashes of the bodies of the notes that are not
+// removed
+func getBodyHashes(db *database.DB) (map[[sha256.Size]byte]bool, error) {
+	rows, err := db.Query("SELECT body FROM notes WHERE deleted = false")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	ret := map[[sha256.Size]byte]bool{}
+	for rows.Next() {
+		var body string
+		if err := rows.Scan(&body); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		ret[hashBody(utils.NormalizeBody(body))] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating rows")
+	}
+
+	return ret, nil
+}
+
+// importResult is the outcome of an import
+type importResult struct {
+	// label is the label of the created book, or empty if no book was created
+	label      string
+	created    int
+	duplicates int
+	tooLarge   int
+}
+
+// noteUUID returns the uuid of the imported note, which is that in the
+// archive if it is preserved. It returns false if a note with the uuid exists.
+func noteUUID(tx *database.DB, n bookarchive.Note) (string, bool, error) {
+	if !preserveUUIDsFlag {
+		ret, err := utils.GenerateUUID()
+		if err != nil {
+			return "", false, errors.Wrap(err, "generating uuid")
+		}
+
+		return ret, true, nil
+	}
+
+	if _, err := uuid.Parse(n.UUID); err != nil {
+		return "", false, errors.Errorf("invalid uuid '%s'", n.UUID)
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", n.UUID).Scan(&count); err != nil {
+		return "", false, errors.Wrapf(err, "finding %s", n.UUID)
+	}
+
+	return n.UUID, count == 0, nil
+}
+
+// importArchive creates the book in the archive and adds its notes as new
+// notes to be synced, in a single transaction. A note whose body is the same
+// as that of a note already here is skipped as a duplicate. The book is given
+// a new label if one with the same label exists, and is not created if all of
+// its notes are skipped.
+func importArchive(ctx context.DnoteCtx, a bookarchive.Archive) (importResult, error) {
+	var res importResult
+
+	err := infra.WithTx(ctx.DB, func(tx *database.DB) error {
+		seen, err := getBodyHashes(tx)
+		if err != nil {
+			return errors.Wrap(err, "hashing the notes")
+		}
+
+		notes := []database.Note{}
+		for _, n := range a.Notes {
+			if err := validate.NoteBody(n.Body); err != nil {
+				return errors.Wrapf(err, "invalid note %s", n.UUID)
+			}
+			body := utils.NormalizeBody(n.Body)
+
+			// a note too large for the server would fail every sync
+			if !forceFlag {
+				if err := validate.NoteSize(body, ctx.NoteSizeMax); err != nil {
+					log.Warn(i18n.T("book.skip_too_large", n.UUID, err.Error()))
+					res.tooLarge++
+					continue
+				}
+			}
+
+			h := hashBody(body)
+			if seen[h] {
+				res.duplicates++
+				continue
+			}
+
+			id, ok, err := noteUUID(tx, n)
+			if err != nil {
+				return errors.Wrapf(err, "getting the uuid of %s", n.UUID)
+			}
+			if !ok {
+				res.duplicates++
+				continue
+			}
+			seen[h] = true
+
+			addedOn := n.AddedOn
+			if addedOn == 0 {
+				addedOn = ctx.Clock.Now().UnixNano()
+			}
+
+			note := database.NewNote(id, "", body, addedOn, n.EditedOn, 0, false, false, true)
+			note.OriginDevice = ctx.DeviceID
+			notes = append(notes, note)
+		}
+
+		if len(notes) == 0 {
+			return nil
+		}
+
+		label := a.Manifest.Book
+		var count int
+		if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", label).Scan(&count); err != nil {
+			return errors.Wrap(err, "counting books")
+		}
+		if count > 0 {
+			label, err = database.ResolveLabel(tx, label)
+			if err != nil {
+				return errors.Wrap(err, "resolving the book label")
+			}
+		}
+
+		bookUUID, err := utils.GenerateUUID()
+		if err != nil {
+			return errors.Wrap(err, "generating uuid")
+		}
+		b := database.NewBook(bookUUID, label, 0, false, true)
+		if err := b.Insert(tx); err != nil {
+			return errors.Wrap(err, "creating the book")
+		}
+
+		for _, note := range notes {
+			note.BookUUID = bookUUID
+			if err := note.Insert(tx); err != nil {
+				return errors.Wrapf(err, "creating the note %s", note.UUID)
+			}
+		}
+
+		res.label = label
+		res.created = len(notes)
+
+		return nil
+	})
+
+	return res, err
+}
+
+func newImportRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		a, err := readArchive(args[0])
+		if err != nil {
+			return err
+		}
+
+		res, err := importArchive(ctx, a)
+		if err != nil {
+			return errors.Wrap(err, "importing the book")
+		}
+
+		if res.label == "" {
+			log.Warn(i18n.T("book.import_nothing", a.Manifest.Book, res.duplicates))
+		} else {
+			if res.label != a.Manifest.Book {
+				log.Info(i18n.T("book.import_renamed", a.Manifest.Book, res.label))
+			}
+
+			log.Success(i18n.T("book.imported", res.created, res.label, res.duplicates))
+		}
+		if res.tooLarge > 0 {
+			log.Warn(i18n.T("book.skipped_too_large", res.tooLarge))
+		}
+
+		return nil
+	}
+}
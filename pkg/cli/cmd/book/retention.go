@@ -0,0 +1,133 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package book
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var retentionExample = `
+ * Keep the notes of the last 90 days in inbox, and at most 500 of them
+ dnote book retention inbox --max-age 90d --max-count 500
+
+ * Print the retention policy of inbox
+ dnote book retention inbox
+
+ * Keep every note of inbox again
+ dnote book retention inbox --clear`
+
+var maxAgeFlag string
+var maxCountFlag int
+var clearFlag bool
+
+func newRetentionCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "retention <book name>",
+		Short:   "Set or print the retention policy of a book",
+		Example: retentionExample,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: retentionPreRun,
+		RunE:    newRetentionRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&maxAgeFlag, "max-age", "", "", "remove the notes last changed longer ago than the given age, such as 90d or 12h. 0 is no limit")
+	f.IntVarP(&maxCountFlag, "max-count", "", 0, "keep only the given number of the most recently changed notes. 0 is no limit")
+	f.BoolVarP(&clearFlag, "clear", "", false, "remove the retention policy, keeping every note")
+
+	return cmd
+}
+
+func retentionPreRun(cmd *cobra.Command, args []string) error {
+	f := cmd.Flags()
+	if clearFlag && (f.Changed("max-age") || f.Changed("max-count")) {
+		return errors.New("--clear cannot be used with --max-age or --max-count")
+	}
+	if _, err := utils.ParseAge(maxAgeFlag); err != nil {
+		return errors.Wrap(err, "invalid --max-age")
+	}
+	if maxCountFlag < 0 {
+		return errors.New("--max-count cannot be negative")
+	}
+
+	return nil
+}
+
+// printRetention prints the retention policy of a book
+func printRetention(r database.BookRetention) {
+	if r.MaxAge == 0 && r.MaxCount == 0 {
+		log.Plain(i18n.T("book.retention_none", r.BookLabel))
+		return
+	}
+
+	log.Plain(i18n.T("book.retention_policy", r.BookLabel))
+	if r.MaxAge > 0 {
+		log.Plain(i18n.T("book.retention_max_age", utils.FormatAge(r.MaxAge)))
+	}
+	if r.MaxCount > 0 {
+		log.Plain(i18n.T("book.retention_max_count", r.MaxCount))
+	}
+}
+
+func newRetentionRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		bookUUID, err := database.GetBookUUID(ctx.DB, args[0])
+		if err != nil {
+			return err
+		}
+
+		r, err := database.GetBookRetention(ctx.DB, bookUUID)
+		if err != nil {
+			return errors.Wrap(err, "getting the retention policy")
+		}
+
+		f := cmd.Flags()
+		if !clearFlag && !f.Changed("max-age") && !f.Changed("max-count") {
+			printRetention(r)
+			return nil
+		}
+
+		if clearFlag {
+			r.MaxAge = 0
+			r.MaxCount = 0
+		}
+		if f.Changed("max-age") {
+			// validated by the pre-run
+			r.MaxAge, _ = utils.ParseAge(maxAgeFlag)
+		}
+		if f.Changed("max-count") {
+			r.MaxCount = maxCountFlag
+		}
+
+		if err := database.SetBookRetention(ctx.DB, bookUUID, r.MaxAge, r.MaxCount); err != nil {
+			return err
+		}
+
+		log.Success(i18n.T("book.retention_set", r.BookLabel))
+		printRetention(r)
+
+		return nil
+	}
+}
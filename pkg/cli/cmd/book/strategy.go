@@ -0,0 +1,115 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package book
+
+import (
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/config"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/sync"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var strategyExample = `
+ * Always keep the local copy of the notes in journal that were changed on the server too
+ dnote book set-strategy journal local
+
+ * Print the merge strategy of journal
+ dnote book set-strategy journal
+
+ * Merge the notes of journal with conflict markers again
+ dnote book set-strategy journal --clear`
+
+var clearStrategyFlag bool
+
+func newStrategyCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set-strategy <book name> [strategy]",
+		Short: "Set or print how the notes of a book changed both locally and on the server are merged",
+		Long: "Set or print how the notes of a book changed both locally and on the server are merged by sync. The strategy is one of " +
+			strings.Join(sync.StrategyNames(), ", ") + ", or keep-local, keep-server and newest for local, server and newer. A book without one uses sync.conflictStrategy, or merges them with conflict markers if it is not set.",
+		Example: strategyExample,
+		Args:    cobra.RangeArgs(1, 2),
+		PreRunE: strategyPreRun,
+		RunE:    newStrategyRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&clearStrategyFlag, "clear", "", false, "remove the merge strategy")
+
+	return cmd
+}
+
+func strategyPreRun(cmd *cobra.Command, args []string) error {
+	if clearStrategyFlag && len(args) > 1 {
+		return errors.New("--clear cannot be used with a strategy")
+	}
+	if len(args) > 1 && !sync.IsStrategy(config.NormalizeConflictStrategy(args[1])) {
+		return errors.Errorf("unknown merge strategy '%s'. Use %s", args[1], strings.Join(sync.StrategyNames(), ", "))
+	}
+
+	return nil
+}
+
+func newStrategyRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		label := args[0]
+		bookUUID, err := database.GetBookUUID(ctx.DB, label)
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 1 && !clearStrategyFlag {
+			strategy, err := database.GetBookStrategy(ctx.DB, bookUUID)
+			if err != nil {
+				return errors.Wrap(err, "getting the merge strategy")
+			}
+
+			if strategy == "" {
+				log.Plain(i18n.T("book.strategy_none", label))
+			} else {
+				log.Plain(i18n.T("book.strategy", label, strategy))
+			}
+
+			return nil
+		}
+
+		var strategy string
+		if len(args) > 1 {
+			strategy = config.NormalizeConflictStrategy(args[1])
+		}
+		if err := database.SetBookStrategy(ctx.DB, bookUUID, strategy); err != nil {
+			return err
+		}
+
+		if strategy == "" {
+			log.Success(i18n.T("book.strategy_cleared", label))
+		} else {
+			log.Success(i18n.T("book.strategy_set", label, strategy))
+		}
+
+		return nil
+	}
+}
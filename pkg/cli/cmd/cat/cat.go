@@ -19,10 +19,9 @@
 package cat
 
 import (
-	"strconv"
-
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/output"
@@ -55,7 +54,7 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 		Aliases:    []string{"c"},
 		Short:      "See a note",
 		Example:    example,
-		RunE:       NewRun(ctx, false),
+		RunE:       NewRun(ctx, Options{}),
 		PreRunE:    preRun,
 		Deprecated: deprecationWarning,
 	}
@@ -63,34 +62,81 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 	return cmd
 }
 
-// NewRun returns a new run function
-func NewRun(ctx context.DnoteCtx, contentOnly bool) infra.RunEFunc {
+// Options are the options of viewing a note
+type Options struct {
+	// ContentOnly prints the content without the details of the note
+	ContentOnly bool
+	// IncludeDeleted allows the note to be a removed one
+	IncludeDeleted bool
+	// Copy places the content on the clipboard instead of printing the note
+	Copy bool
+	// Print prints the note even if it is copied
+	Print bool
+	// Long prints the device on which the note was added or last edited
+	// locally
+	Long bool
+}
+
+// NewRun returns a new run function. The note is referred to by its index or
+// a prefix of its uuid.
+func NewRun(ctx context.DnoteCtx, opts Options) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
-		var noteRowIDArg string
+		var noteRef string
 
 		if len(args) == 2 {
-			log.Plain(log.ColorYellow.Sprintf("DEPRECATED: you no longer need to pass book name to the view command. e.g. `dnote view 123`.\n\n"))
+			log.Plain(log.ColorYellow.Sprint(i18n.T("deprecated_book_name", "view")))
 
-			noteRowIDArg = args[1]
+			noteRef = args[1]
 		} else {
-			noteRowIDArg = args[0]
+			noteRef = args[0]
 		}
 
-		noteRowID, err := strconv.Atoi(noteRowIDArg)
+		db := ctx.DB
+		noteRowID, ok, err := database.ResolveNoteRef(db, noteRef, opts.IncludeDeleted)
 		if err != nil {
-			return errors.Wrap(err, "invalid rowid")
+			return errors.Wrap(err, "resolving the note")
+		}
+		if !ok {
+			return errors.Errorf("note '%s' not found. Use its id or a prefix of its uuid of at least %d characters", noteRef, database.MinUUIDPrefixLength)
 		}
 
-		db := ctx.DB
-		info, err := database.GetNoteInfo(db, noteRowID)
+		var info database.NoteInfo
+		if opts.IncludeDeleted {
+			info, err = database.GetNoteInfoWithDeleted(db, noteRowID)
+		} else {
+			info, err = database.GetNoteInfo(db, noteRowID)
+		}
 		if err != nil {
 			return err
 		}
 
-		if contentOnly {
+		// the note is printed with the views before this one
+		infra.RecordNoteView(ctx, info.RowID)
+
+		if opts.Copy {
+			if err := ctx.Clipboard.Write(info.Content); err != nil {
+				return errors.Wrap(err, "copying the note to the clipboard")
+			}
+			if !opts.Print {
+				log.Success(i18n.T("view.copied", info.RowID))
+				return nil
+			}
+		}
+
+		if opts.ContentOnly {
 			output.NoteContent(info)
 		} else {
-			output.NoteInfo(info)
+			output.NoteInfo(ctx, info)
+			output.NoteStats(ctx, info)
+
+			shares, err := database.GetShares(db, info.UUID, ctx.Clock.Now().UnixNano())
+			if err != nil {
+				return err
+			}
+			output.NoteShares(ctx, shares)
+			if opts.Long {
+				output.NoteDevice(ctx, info)
+			}
 		}
 
 		return nil
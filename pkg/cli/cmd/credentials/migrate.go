@@ -0,0 +1,105 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	creds "github.com/dnote/dnote/pkg/cli/credentials"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var migrateExample = `
+ * Move the session key into the backend set by credentials.backend
+ dnote credentials migrate`
+
+// ErrPlaintextBackend is an error for migrating while the configured backend
+// is the plaintext one that the key is moved out of
+var ErrPlaintextBackend = errors.New("the credentials backend is plaintext. Set credentials.backend to keyring or file in the config file first")
+
+func newMigrateCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "migrate",
+		Short:   "Move the session key out of the database into the configured backend",
+		Example: migrateExample,
+		Args:    cobra.NoArgs,
+		RunE:    newMigrateRun(ctx),
+	}
+
+	return cmd
+}
+
+// Migrate moves the session key stored unencrypted in the database into the
+// configured credentials backend, and overwrites the old copy. It returns
+// false if there was no key to move.
+func Migrate(ctx context.DnoteCtx) (bool, error) {
+	switch ctx.CredentialsBackend {
+	case "", consts.CredentialsBackendPlaintext:
+		return false, ErrPlaintextBackend
+	}
+
+	src := creds.NewPlaintext(ctx.DB)
+	key, err := src.Get()
+	if err == creds.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "reading the session key from the database")
+	}
+
+	if err := ctx.Credentials.Set(key); err != nil {
+		return false, errors.Wrapf(err, "saving the session key to the %s backend", ctx.CredentialsBackend)
+	}
+
+	// the old copy is removed only after the new one is known to be readable
+	got, err := ctx.Credentials.Get()
+	if err != nil {
+		return false, errors.Wrapf(err, "reading back the session key from the %s backend", ctx.CredentialsBackend)
+	}
+	if got != key {
+		return false, errors.Errorf("the session key read back from the %s backend does not match", ctx.CredentialsBackend)
+	}
+
+	if err := src.Delete(); err != nil {
+		return false, errors.Wrap(err, "deleting the session key from the database")
+	}
+
+	return true, nil
+}
+
+func newMigrateRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		ok, err := Migrate(ctx)
+		if err != nil {
+			return errors.Wrap(err, "migrating the session key")
+		}
+
+		if !ok {
+			log.Info(i18n.T("credentials.no_session_key"))
+			return nil
+		}
+
+		log.Success(i18n.T("credentials.moved", ctx.CredentialsBackend))
+
+		return nil
+	}
+}
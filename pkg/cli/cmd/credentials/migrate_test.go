@@ -0,0 +1,117 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	creds "github.com/dnote/dnote/pkg/cli/credentials"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func setupFileBackend(t *testing.T, ctx context.DnoteCtx) context.DnoteCtx {
+	path := filepath.Join(t.TempDir(), consts.CredentialsFilename)
+
+	ctx.CredentialsBackend = consts.CredentialsBackendFile
+	ctx.Credentials = creds.NewFile(path, func() (string, error) { return "pass", nil })
+
+	return ctx
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	database.MustExec(t, "inserting the session key", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSessionKey, "someSessionKey")
+	ctx = setupFileBackend(t, ctx)
+
+	ok, err := Migrate(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "migrating"))
+	}
+	assert.Equal(t, ok, true, "result mismatch")
+
+	got, err := ctx.Credentials.Get()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the migrated key"))
+	}
+	assert.Equal(t, got, "someSessionKey", "migrated key mismatch")
+
+	var count int
+	database.MustScan(t, "counting the session key", ctx.DB.QueryRow("SELECT count(*) FROM system WHERE key = ?", consts.SystemSessionKey), &count)
+	assert.Equal(t, count, 0, "the session key remains in the database")
+}
+
+func TestMigrate_nothingStored(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	ctx = setupFileBackend(t, ctx)
+
+	ok, err := Migrate(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "migrating"))
+	}
+	assert.Equal(t, ok, false, "result mismatch")
+
+	_, err = ctx.Credentials.Get()
+	assert.Equal(t, err, creds.ErrNotFound, "error mismatch")
+}
+
+func TestMigrate_plaintextBackend(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	database.MustExec(t, "inserting the session key", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSessionKey, "someSessionKey")
+	ctx.CredentialsBackend = consts.CredentialsBackendPlaintext
+
+	_, err := Migrate(ctx)
+	assert.Equal(t, err, ErrPlaintextBackend, "error mismatch")
+
+	var count int
+	database.MustScan(t, "counting the session key", ctx.DB.QueryRow("SELECT count(*) FROM system WHERE key = ?", consts.SystemSessionKey), &count)
+	assert.Equal(t, count, 1, "the session key was removed")
+}
+
+func TestMigrate_setError(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	database.MustExec(t, "inserting the session key", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSessionKey, "someSessionKey")
+	ctx.CredentialsBackend = consts.CredentialsBackendFile
+	ctx.Credentials = creds.NewFile(filepath.Join(t.TempDir(), consts.CredentialsFilename), func() (string, error) {
+		return "", errors.New("no terminal")
+	})
+
+	_, err := Migrate(ctx)
+	assert.NotEqual(t, err, nil, "no error")
+
+	var count int
+	database.MustScan(t, "counting the session key", ctx.DB.QueryRow("SELECT count(*) FROM system WHERE key = ?", consts.SystemSessionKey), &count)
+	assert.Equal(t, count, 1, "the session key was removed")
+}
@@ -16,20 +16,21 @@
  * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
  */
 
-package sync
+package db
 
 import (
 	"github.com/dnote/dnote/pkg/cli/context"
-	"path/filepath"
+	"github.com/spf13/cobra"
 )
 
-var testDir = "../../tmp"
+// NewCmd returns a new db command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Manage the local database",
+	}
 
-var paths context.Paths = context.Paths{
-	Home:   testDir,
-	Cache:  testDir,
-	Config: testDir,
-	Data:   testDir,
-}
+	cmd.AddCommand(newMaintainCmd(ctx))
 
-var dbPath = filepath.Join(testDir, "test.db")
+	return cmd
+}
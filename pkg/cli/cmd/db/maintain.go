@@ -0,0 +1,307 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var fullFlag bool
+
+var maintainExample = `
+ * Optimize the database and reclaim unused space
+ dnote db maintain
+
+ * Rebuild the whole database file
+ dnote db maintain --full`
+
+// autoVacuumIncremental is the value of 'PRAGMA auto_vacuum' for the
+// incremental auto-vacuum
+const autoVacuumIncremental = 2
+
+// numLargestTables is the number of the largest tables to report
+const numLargestTables = 5
+
+func newMaintainCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "maintain",
+		Short:   "Optimize the database and reclaim unused space",
+		Example: maintainExample,
+		Args:    cobra.NoArgs,
+		RunE:    newMaintainRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&fullFlag, "full", "", false, "run a full vacuum even if the incremental auto-vacuum is enabled")
+
+	return cmd
+}
+
+// tableSize is the number of rows in a table
+type tableSize struct {
+	Name string
+	Rows int
+}
+
+// stats is the statistics about the database
+type stats struct {
+	FileSize  int64
+	PageSize  int
+	PageCount int
+	FreePages int
+	Tables    []tableSize
+}
+
+// getFileSize returns the size of the database, including the write-ahead log
+func getFileSize(dbPath string) (int64, error) {
+	var ret int64
+
+	for _, p := range []string{dbPath, dbPath + "-wal"} {
+		fi, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, errors.Wrapf(err, "getting the file info of %s", p)
+		}
+
+		ret += fi.Size()
+	}
+
+	return ret, nil
+}
+
+func getTableSizes(ctx context.DnoteCtx) ([]tableSize, error) {
+	rows, err := ctx.DB.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying tables")
+	}
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		names = append(names, name)
+	}
+	rows.Close()
+
+	ret := []tableSize{}
+	for _, name := range names {
+		var count int
+		if err := ctx.DB.QueryRow(fmt.Sprintf(`SELECT count(*) FROM "%s"`, name)).Scan(&count); err != nil {
+			return nil, errors.Wrapf(err, "counting rows in %s", name)
+		}
+
+		ret = append(ret, tableSize{Name: name, Rows: count})
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Rows > ret[j].Rows
+	})
+
+	return ret, nil
+}
+
+func getStats(ctx context.DnoteCtx) (stats, error) {
+	var ret stats
+
+	db := ctx.DB
+	if err := db.QueryRow("PRAGMA page_size").Scan(&ret.PageSize); err != nil {
+		return ret, errors.Wrap(err, "getting page_size")
+	}
+	if err := db.QueryRow("PRAGMA page_count").Scan(&ret.PageCount); err != nil {
+		return ret, errors.Wrap(err, "getting page_count")
+	}
+	if err := db.QueryRow("PRAGMA freelist_count").Scan(&ret.FreePages); err != nil {
+		return ret, errors.Wrap(err, "getting freelist_count")
+	}
+
+	fileSize, err := getFileSize(db.Filepath)
+	if err != nil {
+		return ret, errors.Wrap(err, "getting the file size")
+	}
+	ret.FileSize = fileSize
+
+	tables, err := getTableSizes(ctx)
+	if err != nil {
+		return ret, errors.Wrap(err, "getting table sizes")
+	}
+	ret.Tables = tables
+
+	return ret, nil
+}
+
+// checkFreeSpace checks if there is enough disk space for a full vacuum,
+// which needs up to twice the size of the database for a temporary copy and
+// the write-ahead log
+func checkFreeSpace(ctx context.DnoteCtx, s stats) error {
+	free, err := utils.FreeDiskSpace(filepath.Dir(ctx.DB.Filepath))
+	if err != nil {
+		return errors.Wrap(err, "getting the free disk space")
+	}
+
+	required := uint64(s.FileSize) * 2
+	if free < required {
+		return errors.Errorf("not enough disk space to vacuum. %d bytes are required but only %d bytes are available", required, free)
+	}
+
+	return nil
+}
+
+// vacuum reclaims the unused pages. It runs an incremental vacuum if the
+// incremental auto-vacuum is in effect, and a full vacuum otherwise.
+func vacuum(ctx context.DnoteCtx, s stats, full bool) error {
+	db := ctx.DB
+
+	var autoVacuum int
+	if err := db.QueryRow("PRAGMA auto_vacuum").Scan(&autoVacuum); err != nil {
+		return errors.Wrap(err, "getting auto_vacuum")
+	}
+
+	if autoVacuum == autoVacuumIncremental && !full {
+		log.Verbosef("running an incremental vacuum\n")
+
+		// The pragma frees one page per step, so step through all of the rows.
+		rows, err := db.Query("PRAGMA incremental_vacuum")
+		if err != nil {
+			return errors.Wrap(err, "running an incremental vacuum")
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "running an incremental vacuum")
+		}
+	} else {
+		if err := checkFreeSpace(ctx, s); err != nil {
+			return errors.Wrap(err, "checking the free disk space")
+		}
+
+		// A change of the auto_vacuum mode of an existing database takes effect
+		// only by a vacuum run on the same connection.
+		query := "VACUUM"
+		if ctx.AutoVacuum {
+			query = "PRAGMA auto_vacuum = INCREMENTAL; VACUUM"
+		}
+
+		log.Verbosef("running a full vacuum\n")
+
+		if _, err := db.Exec(query); err != nil {
+			return errors.Wrap(err, "running a full vacuum")
+		}
+	}
+
+	// shrink the file by moving the pages from the write-ahead log into it
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return errors.Wrap(err, "checkpointing the write-ahead log")
+	}
+
+	return nil
+}
+
+// maintain drops the archived expunged notes past the configured limits,
+// optimizes the database and reclaims the unused space. It returns the
+// statistics before and after.
+func maintain(ctx context.DnoteCtx, full bool) (stats, stats, error) {
+	var before, after stats
+
+	locked, err := infra.IsSyncLocked(ctx)
+	if err != nil {
+		return before, after, errors.Wrap(err, "checking the sync lock")
+	}
+	if locked {
+		return before, after, errors.Wrap(infra.ErrSyncLocked, "cannot maintain the database")
+	}
+
+	before, err = getStats(ctx)
+	if err != nil {
+		return before, after, errors.Wrap(err, "getting the statistics")
+	}
+
+	if err := infra.CapExpungedArchive(ctx, ctx.DB); err != nil {
+		return before, after, err
+	}
+
+	if _, err := ctx.DB.Exec("ANALYZE"); err != nil {
+		return before, after, errors.Wrap(err, "analyzing")
+	}
+	if _, err := ctx.DB.Exec("PRAGMA optimize"); err != nil {
+		return before, after, errors.Wrap(err, "optimizing")
+	}
+
+	if err := vacuum(ctx, before, full); err != nil {
+		return before, after, errors.Wrap(err, "vacuuming")
+	}
+
+	after, err = getStats(ctx)
+	if err != nil {
+		return before, after, errors.Wrap(err, "getting the statistics")
+	}
+
+	return before, after, nil
+}
+
+func printStats(before, after stats) {
+	log.Info(i18n.T("db.file_size", before.FileSize, after.FileSize))
+	log.Info(i18n.T("db.pages", before.PageCount, after.PageCount, after.PageSize))
+	log.Info(i18n.T("db.free_pages", before.FreePages, after.FreePages))
+
+	log.Info(i18n.T("db.largest_tables"))
+	for i, t := range after.Tables {
+		if i == numLargestTables {
+			break
+		}
+
+		log.Plainf("  %s %s\n", t.Name, log.ColorYellow.Sprint(i18n.T("db.table_rows", t.Rows)))
+	}
+}
+
+// Maintain optimizes the database, reclaims the unused space and prints the
+// statistics, as 'dnote db maintain' does
+func Maintain(ctx context.DnoteCtx, full bool) error {
+	before, after, err := maintain(ctx, full)
+	if err != nil {
+		return err
+	}
+
+	printStats(before, after)
+	log.Success(i18n.T("db.maintained"))
+
+	return nil
+}
+
+func newMaintainRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		return Maintain(ctx, fullFlag)
+	}
+}
@@ -0,0 +1,109 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/pkg/errors"
+)
+
+// bloat inserts large notes and deletes them, leaving free pages behind
+func bloat(t *testing.T, db *database.DB) {
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+
+	body := strings.Repeat("a", 4096)
+	for i := 0; i < 200; i++ {
+		database.MustExec(t, fmt.Sprintf("inserting note %d", i), db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", fmt.Sprintf("n%d-uuid", i), "b1-uuid", body, 1542058875)
+	}
+
+	database.MustExec(t, "deleting notes", db, "DELETE FROM notes")
+}
+
+func TestMaintain(t *testing.T) {
+	db := database.InitTestDB(t, "../../tmp/dnote-test.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db}
+	bloat(t, db)
+
+	before, after, err := maintain(ctx, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.NotEqual(t, before.FreePages, 0, "free pages before mismatch")
+	assert.Equal(t, after.FreePages, 0, "free pages after mismatch")
+	if after.FileSize >= before.FileSize {
+		t.Errorf("file did not shrink. before: %d, after: %d", before.FileSize, after.FileSize)
+	}
+
+	rows := map[string]int{}
+	for _, table := range after.Tables {
+		rows[table.Name] = table.Rows
+	}
+	assert.Equal(t, rows["books"], 1, "books rows mismatch")
+	assert.Equal(t, rows["notes"], 0, "notes rows mismatch")
+}
+
+func TestMaintain_autoVacuum(t *testing.T) {
+	db := database.InitTestDB(t, "../../tmp/dnote-test.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db, AutoVacuum: true}
+
+	if _, _, err := maintain(ctx, false); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	var autoVacuum int
+	database.MustScan(t, "getting auto_vacuum", db.QueryRow("PRAGMA auto_vacuum"), &autoVacuum)
+	assert.Equal(t, autoVacuum, autoVacuumIncremental, "auto_vacuum mismatch")
+
+	// subsequent runs reclaim the space incrementally
+	bloat(t, db)
+
+	before, after, err := maintain(ctx, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.NotEqual(t, before.FreePages, 0, "free pages before mismatch")
+	assert.Equal(t, after.FreePages, 0, "free pages after mismatch")
+}
+
+func TestMaintain_syncLocked(t *testing.T) {
+	db := database.InitTestDB(t, "../../tmp/dnote-test.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db}
+	if err := infra.AcquireSyncLock(ctx); err != nil {
+		t.Fatal(errors.Wrap(err, "acquiring the lock"))
+	}
+	defer infra.ReleaseSyncLock(ctx)
+
+	_, _, err := maintain(ctx, false)
+	assert.Equal(t, errors.Cause(err), infra.ErrSyncLocked, "error mismatch")
+}
@@ -0,0 +1,103 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package digest
+
+import (
+	"os"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/digest"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Summarize the last 7 days
+ dnote digest
+
+ * Summarize the last 30 days in Markdown
+ dnote digest --days 30 --format md
+
+ * Mail an HTML digest
+ dnote digest --format html | mail -s "Dnote digest" -a "Content-Type: text/html" me@example.com`
+
+var daysFlag int
+var reviewFlag int
+var formatFlag string
+var seedFlag int64
+
+// NewCmd returns a new digest command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "digest",
+		Short:   "Summarize the recent notes and resurface older ones",
+		Example: example,
+		Args:    cobra.NoArgs,
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.IntVarP(&daysFlag, "days", "d", 7, "the number of days to summarize, including today")
+	f.IntVarP(&reviewFlag, "review", "", 5, "the number of older notes to select for review")
+	f.StringVarP(&formatFlag, "format", "f", digest.FormatText, "the output format, which is text, md or html")
+	f.Int64VarP(&seedFlag, "seed", "", 0, "seed the selection of the notes for review. If not given, the selection is random")
+
+	return cmd
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if daysFlag < 1 {
+		return errors.New("--days must be positive")
+	}
+	if reviewFlag < 0 {
+		return errors.New("--review cannot be negative")
+	}
+
+	switch formatFlag {
+	case digest.FormatText, digest.FormatMarkdown, digest.FormatHTML:
+	default:
+		return errors.Errorf("unknown format '%s'. Use text, md or html", formatFlag)
+	}
+
+	return nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		now := ctx.Clock.Now()
+
+		seed := seedFlag
+		if !cmd.Flags().Changed("seed") {
+			seed = now.UnixNano()
+		}
+
+		d, err := digest.Build(ctx.DB, now, digest.Options{
+			Days:   daysFlag,
+			Review: reviewFlag,
+			Seed:   seed,
+		})
+		if err != nil {
+			return errors.Wrap(err, "building the digest")
+		}
+
+		return digest.Write(os.Stdout, d, formatFlag)
+	}
+}
@@ -0,0 +1,163 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package doctor
+
+import (
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/config"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewCmd returns a new doctor command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Print information for troubleshooting",
+		Args:  cobra.NoArgs,
+		RunE:  newRun(ctx),
+	}
+
+	return cmd
+}
+
+// getLogFileInfo describes the state of the log file
+func getLogFileInfo(ctx context.DnoteCtx) string {
+	level := ctx.FileLogger.Level()
+	if level == log.FileLevelOff {
+		return "disabled. Set log.level to info or debug in the config file, or use --log-file, to enable it"
+	}
+
+	return i18n.T("doctor.log_level", ctx.FileLogger.Path(), level)
+}
+
+// getDuplicateInfo describes the notes that share a uuid. They are prevented
+// by a unique index, which a database may lack if it was modified by hand.
+// Dnote resolves them on startup before creating the index, so they are only
+// found if the database was changed since. Books cannot share a uuid because
+// it is their primary key.
+func getDuplicateInfo(db *database.DB) (string, error) {
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM (SELECT uuid FROM notes GROUP BY uuid HAVING count(*) > 1)").Scan(&count); err != nil {
+		return "", errors.Wrap(err, "counting duplicate note uuids")
+	}
+
+	if count == 0 {
+		return i18n.T("doctor.none"), nil
+	}
+
+	return i18n.T("doctor.duplicate_count", count), nil
+}
+
+// untitledLabel is the label given to a book whose label is empty
+const untitledLabel = "untitled"
+
+// repairEmptyLabels renames the books whose label is empty or only has
+// whitespace, which can be left by an older version or by another client.
+// The books are marked dirty so that the server is given the new label.
+// It returns the new labels.
+func repairEmptyLabels(tx *database.DB) ([]string, error) {
+	rows, err := tx.Query("SELECT uuid, label FROM books")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying books")
+	}
+
+	var uuids []string
+	for rows.Next() {
+		var uuid, label string
+		if err := rows.Scan(&uuid, &label); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "scanning a book")
+		}
+
+		if strings.TrimSpace(label) == "" {
+			uuids = append(uuids, uuid)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating books")
+	}
+
+	ret := []string{}
+	for _, uuid := range uuids {
+		label := untitledLabel
+
+		var count int
+		if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", label).Scan(&count); err != nil {
+			return nil, errors.Wrapf(err, "checking availability of label %s", label)
+		}
+		if count > 0 {
+			label, err = database.ResolveLabel(tx, label)
+			if err != nil {
+				return nil, errors.Wrap(err, "resolving the label")
+			}
+		}
+
+		if _, err := tx.Exec("UPDATE books SET label = ?, dirty = (dirty OR NOT deleted) WHERE uuid = ?", label, uuid); err != nil {
+			return nil, errors.Wrapf(err, "renaming book %s", uuid)
+		}
+
+		ret = append(ret, label)
+	}
+
+	return ret, nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		log.Info(i18n.T("doctor.version", ctx.Version))
+		log.Info(i18n.T("doctor.config_file", config.GetPath(ctx)))
+		log.Info(i18n.T("doctor.database", ctx.DB.Filepath))
+		log.Info(i18n.T("doctor.log_file", getLogFileInfo(ctx)))
+
+		duplicates, err := getDuplicateInfo(ctx.DB)
+		if err != nil {
+			return errors.Wrap(err, "checking duplicate uuids")
+		}
+		log.Info(i18n.T("doctor.duplicates", duplicates))
+
+		var renamed []string
+		err = infra.WithTxRetry(ctx.DB, func(tx *database.DB) error {
+			labels, err := repairEmptyLabels(tx)
+			if err != nil {
+				return err
+			}
+			renamed = labels
+
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "repairing empty book labels")
+		}
+		if len(renamed) == 0 {
+			log.Info(i18n.T("doctor.empty_labels_none"))
+		} else {
+			log.Info(i18n.T("doctor.empty_labels_renamed", strings.Join(renamed, ", ")))
+		}
+
+		return nil
+	}
+}
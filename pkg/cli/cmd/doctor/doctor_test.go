@@ -0,0 +1,185 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package doctor
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+)
+
+func TestGetLogFileInfo(t *testing.T) {
+	testCases := []struct {
+		fileLogger *log.FileLogger
+		expected   string
+	}{
+		{
+			fileLogger: nil,
+			expected:   "disabled. Set log.level to info or debug in the config file, or use --log-file, to enable it",
+		},
+		{
+			fileLogger: log.NewFileLogger("/tmp/dnote/dnote.log", log.FileLevelOff),
+			expected:   "disabled. Set log.level to info or debug in the config file, or use --log-file, to enable it",
+		},
+		{
+			fileLogger: log.NewFileLogger("/tmp/dnote/dnote.log", log.FileLevelInfo),
+			expected:   "/tmp/dnote/dnote.log (level info)",
+		},
+		{
+			fileLogger: log.NewFileLogger("/tmp/dnote/dnote.log", log.FileLevelDebug),
+			expected:   "/tmp/dnote/dnote.log (level debug)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			ctx := context.DnoteCtx{FileLogger: tc.fileLogger}
+
+			assert.Equal(t, getLogFileInfo(ctx), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestGetDuplicateInfo(t *testing.T) {
+	testCases := []struct {
+		name     string
+		setup    func(t *testing.T, db *database.DB)
+		expected string
+	}{
+		{
+			name:     "no duplicates",
+			setup:    func(t *testing.T, db *database.DB) {},
+			expected: "none",
+		},
+		{
+			name: "duplicate notes",
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "dropping the note uuid index", db, "DROP INDEX idx_notes_uuid")
+				database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+				database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1)
+				database.MustExec(t, "inserting n1 again", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1)
+				database.MustExec(t, "inserting n1 a third time", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1)
+				database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n2-uuid", "b1-uuid", "n2 body", 1)
+			},
+			expected: "1 note uuids are shared by more than one note",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := database.InitTestDB(t, "../../tmp/.dnote", nil)
+			defer database.TeardownTestDB(t, db)
+			tc.setup(t, db)
+
+			got, err := getDuplicateInfo(db)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestRepairEmptyLabels(t *testing.T) {
+	type book struct {
+		label   string
+		dirty   bool
+		deleted bool
+	}
+
+	testCases := []struct {
+		name     string
+		books    map[string]book
+		expected map[string]book
+		renamed  []string
+	}{
+		{
+			name: "no empty labels",
+			books: map[string]book{
+				"b1-uuid": {label: "js"},
+			},
+			expected: map[string]book{
+				"b1-uuid": {label: "js"},
+			},
+			renamed: []string{},
+		},
+		{
+			name: "empty label",
+			books: map[string]book{
+				"b1-uuid": {label: "js"},
+				"b2-uuid": {label: ""},
+			},
+			expected: map[string]book{
+				"b1-uuid": {label: "js"},
+				"b2-uuid": {label: "untitled", dirty: true},
+			},
+			renamed: []string{"untitled"},
+		},
+		{
+			name: "whitespace label and untitled taken",
+			books: map[string]book{
+				"b1-uuid": {label: "untitled"},
+				"b2-uuid": {label: " \t"},
+			},
+			expected: map[string]book{
+				"b1-uuid": {label: "untitled"},
+				"b2-uuid": {label: "untitled_2", dirty: true},
+			},
+			renamed: []string{"untitled_2"},
+		},
+		{
+			name: "deleted book",
+			books: map[string]book{
+				"b1-uuid": {label: "", deleted: true},
+			},
+			expected: map[string]book{
+				"b1-uuid": {label: "untitled", deleted: true},
+			},
+			renamed: []string{"untitled"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := database.InitTestDB(t, "../../tmp/.dnote", nil)
+			defer database.TeardownTestDB(t, db)
+
+			for uuid, b := range tc.books {
+				database.MustExec(t, "inserting a book", db, "INSERT INTO books (uuid, label, dirty, deleted) VALUES (?, ?, ?, ?)", uuid, b.label, b.dirty, b.deleted)
+			}
+
+			got, err := repairEmptyLabels(db)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			assert.DeepEqual(t, got, tc.renamed, "renamed mismatch")
+			for uuid, expected := range tc.expected {
+				var b book
+				database.MustScan(t, "getting a book", db.QueryRow("SELECT label, dirty, deleted FROM books WHERE uuid = ?", uuid), &b.label, &b.dirty, &b.deleted)
+				assert.Equal(t, b, expected, fmt.Sprintf("book %s mismatch", uuid))
+			}
+		})
+	}
+}
@@ -23,10 +23,12 @@ import (
 
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/output"
 	"github.com/dnote/dnote/pkg/cli/ui"
 	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/dnote/dnote/pkg/dnote"
 	"github.com/pkg/errors"
 )
 
@@ -47,7 +49,7 @@ func waitEditorBookName(ctx context.DnoteCtx) (string, error) {
 		return "", errors.Wrap(err, "getting temporarily content file path")
 	}
 
-	c, err := ui.GetEditorInput(ctx, fpath)
+	c, err := ui.GetEditorInput(ctx, fpath, "--name")
 	if err != nil {
 		return "", errors.Wrap(err, "getting editor input")
 	}
@@ -78,6 +80,11 @@ func runBook(ctx context.DnoteCtx, bookName string) error {
 		return errors.Wrap(err, "validating flags.")
 	}
 
+	bookName, err = ui.ResolveBook(ctx, bookName)
+	if err != nil {
+		return errors.Wrap(err, "resolving the book")
+	}
+
 	db := ctx.DB
 	uuid, err := database.GetBookUUID(db, bookName)
 	if err != nil {
@@ -94,30 +101,16 @@ func runBook(ctx context.DnoteCtx, bookName string) error {
 		return errors.Wrap(err, "validating book name")
 	}
 
-	tx, err := ctx.DB.Begin()
-	if err != nil {
-		return errors.Wrap(err, "beginning a transaction")
-	}
-
-	err = database.UpdateBookName(tx, uuid, name)
-	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "updating the book name")
+	if _, err := dnote.NewStore(ctx).RenameBook(bookName, name); err != nil {
+		return err
 	}
 
-	bookInfo, err := database.GetBookInfo(tx, uuid)
+	bookInfo, err := database.GetBookInfo(db, uuid)
 	if err != nil {
-		tx.Rollback()
 		return errors.Wrap(err, "getting book info")
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "committing a transaction")
-	}
-
-	log.Success("edited the book\n")
+	log.Success(i18n.T("edit.book_edited"))
 	output.BookInfo(bookInfo)
 
 	return nil
@@ -20,9 +20,10 @@ package edit
 
 import (
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
-	"github.com/dnote/dnote/pkg/cli/utils"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -30,11 +31,15 @@ import (
 var contentFlag string
 var bookFlag string
 var nameFlag string
+var forceFlag bool
 
 var example = `
   * Edit a note by id
   dnote edit 3
 
+  * Edit a note by a prefix of its uuid
+  dnote edit 3a7c1d
+
   * Edit a note without launching an editor
   dnote edit 3 -c "new content"
 
@@ -51,7 +56,7 @@ var example = `
 // NewCmd returns a new edit command
 func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:     "edit <note id|book name>",
+		Use:     "edit <note id|uuid prefix|book name>",
 		Short:   "Edit a note or a book",
 		Aliases: []string{"e"},
 		Example: example,
@@ -63,6 +68,7 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 	f.StringVarP(&contentFlag, "content", "c", "", "a new content for the note")
 	f.StringVarP(&bookFlag, "book", "b", "", "the name of the book to move the note to")
 	f.StringVarP(&nameFlag, "name", "n", "", "a new name for a book")
+	f.BoolVarP(&forceFlag, "force", "", false, "replace the bytes that are not valid UTF-8 in the content and allow a content above the size limit instead of failing")
 
 	return cmd
 }
@@ -77,25 +83,26 @@ func preRun(cmd *cobra.Command, args []string) error {
 
 func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
+		target := args[0]
+
 		// DEPRECATED: Remove in 1.0.0
 		if len(args) == 2 {
-			log.Plain(log.ColorYellow.Sprintf("DEPRECATED: you no longer need to pass book name to the view command. e.g. `dnote view 123`.\n\n"))
+			log.Plain(log.ColorYellow.Sprint(i18n.T("deprecated_book_name", "view")))
 
-			target := args[1]
-
-			if err := runNote(ctx, target); err != nil {
-				return errors.Wrap(err, "editing note")
-			}
-
-			return nil
+			target = args[1]
 		}
 
-		target := args[0]
+		rowID, ok, err := database.ResolveNoteRef(ctx.DB, target, false)
+		if err != nil {
+			return errors.Wrap(err, "resolving the note")
+		}
 
-		if utils.IsNumber(target) {
-			if err := runNote(ctx, target); err != nil {
+		if ok {
+			if err := runNote(ctx, rowID); err != nil {
 				return errors.Wrap(err, "editing note")
 			}
+		} else if len(args) == 2 {
+			return errors.Errorf("note '%s' not found", target)
 		} else {
 			if err := runBook(ctx, target); err != nil {
 				return errors.Wrap(err, "editing book")
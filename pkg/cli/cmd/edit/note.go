@@ -21,13 +21,14 @@ package edit
 import (
 	"database/sql"
 	"io/ioutil"
-	"strconv"
 
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/output"
 	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/dnote"
 	"github.com/pkg/errors"
 )
 
@@ -49,7 +50,7 @@ func waitEditorNoteContent(ctx context.DnoteCtx, note database.Note) (string, er
 		return "", errors.Wrap(err, "preparing tmp content file")
 	}
 
-	c, err := ui.GetEditorInput(ctx, fpath)
+	c, err := ui.GetEditorInput(ctx, fpath, "--content")
 	if err != nil {
 		return "", errors.Wrap(err, "getting editor input")
 	}
@@ -70,59 +71,35 @@ func getContent(ctx context.DnoteCtx, note database.Note) (string, error) {
 	return c, nil
 }
 
-func changeContent(ctx context.DnoteCtx, tx *database.DB, note database.Note, content string) error {
-	if note.Body == content {
-		return errors.New("Nothing changed")
+// allowBook checks if the note may be moved to the book with the given name,
+// which is created under the autoCreateBook policy if it does not exist
+func allowBook(ctx context.DnoteCtx, name string) (bool, error) {
+	var count int
+	if err := ctx.DB.QueryRow("SELECT count(*) FROM books WHERE label = ?", name).Scan(&count); err != nil {
+		return false, errors.Wrap(err, "counting books")
 	}
-
-	if err := database.UpdateNoteContent(tx, ctx.Clock, note.RowID, content); err != nil {
-		return errors.Wrap(err, "updating the note")
-	}
-
-	return nil
-}
-
-func moveBook(ctx context.DnoteCtx, tx *database.DB, note database.Note, bookName string) error {
-	targetBookUUID, err := database.GetBookUUID(tx, bookName)
-	if err != nil {
-		return errors.Wrap(err, "finding book uuid")
-	}
-
-	if note.BookUUID == targetBookUUID {
-		return errors.New("book has not changed")
+	if count > 0 {
+		return true, nil
 	}
 
-	if err := database.UpdateNoteBook(tx, ctx.Clock, note.RowID, targetBookUUID); err != nil {
-		return errors.Wrap(err, "moving book")
-	}
-
-	return nil
+	return ui.AllowNewBook(ctx, name, ctx.AutoCreateBook, "")
 }
 
-func updateNote(ctx context.DnoteCtx, tx *database.DB, note database.Note, bookName, content string) error {
-	if bookName != "" {
-		if err := moveBook(ctx, tx, note, bookName); err != nil {
-			return errors.Wrap(err, "moving book")
-		}
-	}
-	if content != "" {
-		if err := changeContent(ctx, tx, note, content); err != nil {
-			return errors.Wrap(err, "changing content")
-		}
-	}
-
-	return nil
-}
-
-func runNote(ctx context.DnoteCtx, rowIDArg string) error {
+func runNote(ctx context.DnoteCtx, rowID int) error {
 	err := validateRunNoteFlags()
 	if err != nil {
 		return errors.Wrap(err, "validating flags.")
 	}
 
-	rowID, err := strconv.Atoi(rowIDArg)
-	if err != nil {
-		return errors.Wrap(err, "invalid rowid")
+	if bookFlag != "" {
+		ok, err := allowBook(ctx, bookFlag)
+		if err != nil {
+			return errors.Wrap(err, "moving the note")
+		}
+		if !ok {
+			log.Warn(i18n.T("aborted"))
+			return nil
+		}
 	}
 
 	db := ctx.DB
@@ -144,32 +121,28 @@ func runNote(ctx context.DnoteCtx, rowIDArg string) error {
 
 		content = c
 	}
-
-	tx, err := ctx.DB.Begin()
-	if err != nil {
-		return errors.Wrap(err, "beginning a transaction")
+	if content != "" {
+		content, err = ui.NormalizeContent(content, forceFlag)
+		if err != nil {
+			return errors.Wrap(err, "invalid content")
+		}
+		if err := ui.CheckContentSize(ctx, content, forceFlag); err != nil {
+			return errors.Wrap(err, "invalid content")
+		}
 	}
 
-	err = updateNote(ctx, tx, note, bookFlag, content)
-	if err != nil {
-		tx.Rollback()
+	update := dnote.NoteUpdate{Book: bookFlag, Body: content, CreateBook: true}
+	if _, err := dnote.NewStore(ctx).UpdateNote(note.UUID, update); err != nil {
 		return errors.Wrap(err, "updating note fields")
 	}
 
-	noteInfo, err := database.GetNoteInfo(tx, rowID)
+	noteInfo, err := database.GetNoteInfo(ctx.DB, rowID)
 	if err != nil {
-		tx.Rollback()
 		return errors.Wrap(err, "getting note info")
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "committing a transaction")
-	}
-
-	log.Success("edited the note\n")
-	output.NoteInfo(noteInfo)
+	log.Success(i18n.T("edit.note_edited"))
+	output.NoteInfo(ctx, noteInfo)
 
 	return nil
 }
@@ -0,0 +1,97 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package edit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+)
+
+func TestEditNote_autoCreateBook(t *testing.T) {
+	testCases := []struct {
+		name         string
+		policy       string
+		book         string
+		expectedBook string
+		expectedErr  string
+	}{
+		{
+			name:         "existing book",
+			policy:       consts.AutoCreateBookFalse,
+			book:         "golang",
+			expectedBook: "golang",
+		},
+		{
+			name:         "true",
+			policy:       consts.AutoCreateBookTrue,
+			book:         "golnag",
+			expectedBook: "golnag",
+		},
+		{
+			name:         "false",
+			policy:       consts.AutoCreateBookFalse,
+			book:         "golnag",
+			expectedBook: "js",
+			expectedErr:  "book 'golnag' does not exist. Close matches: golang",
+		},
+		{
+			name:         "prompt in the non-interactive mode",
+			policy:       consts.AutoCreateBookPrompt,
+			book:         "golnag",
+			expectedBook: "js",
+			expectedErr:  "cannot confirm creating a new book in the non-interactive mode",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			ctx.AutoCreateBook = tc.policy
+			ui.SetInteractive(false)
+			defer ui.SetInteractive(ui.IsTerminal())
+
+			f := testutils.NewFixture(t, ctx.DB)
+			js := f.Book("js")
+			f.Book("golang")
+			n := f.Note(js, "n1 body")
+
+			result := testutils.RunCmd(t, ctx, NewCmd, n.UUID, "-b", tc.book)
+
+			if tc.expectedErr == "" {
+				if result.Err != nil {
+					t.Fatal(errors.Wrap(result.Err, "executing"))
+				}
+			} else if result.Err == nil || !strings.Contains(result.Err.Error(), tc.expectedErr) {
+				t.Errorf("expected an error containing %q but got %v", tc.expectedErr, result.Err)
+			}
+
+			book := f.MustGetBook(f.MustGetNote(n.UUID).BookUUID)
+			assert.Equal(t, book.Label, tc.expectedBook, "book mismatch")
+		})
+	}
+}
@@ -0,0 +1,254 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var ankiExample = `
+ * Export a book as a deck, using the first line of each note as the front
+ dnote export anki javascript --out deck.txt
+
+ * Split each note into the front and the back on a '---' line instead
+ dnote export anki javascript --out deck.txt --split divider
+
+ * Add tags to the cards besides the book name
+ dnote export anki javascript --out deck.txt --tag study --tag exam`
+
+// split modes
+const (
+	// splitLine uses the first line as the front and the rest as the back
+	splitLine = "line"
+	// splitDivider splits on the first line that consists of ankiDivider
+	splitDivider = "divider"
+)
+
+// ankiDivider is the line that separates the front and the back of a note in
+// the divider split mode
+const ankiDivider = "---"
+
+// ankiHeader is the header of the Anki import file. It makes the importer
+// treat the fields as HTML, update the cards with the same GUID instead of
+// adding duplicates, and read the tags from the last column.
+const ankiHeader = `#separator:tab
+#html:true
+#guid column:1
+#tags column:4
+`
+
+var ankiOutFlag string
+var ankiSplitFlag string
+var ankiTagFlags []string
+
+func newAnkiCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "anki <book name>",
+		Short:   "Export a book as an Anki deck",
+		Example: ankiExample,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: ankiPreRun,
+		RunE:    newAnkiRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&ankiOutFlag, "out", "o", "", "the path of the file to write the deck to")
+	f.StringVarP(&ankiSplitFlag, "split", "", splitLine, "how to split a note into the front and the back: 'line' or 'divider'")
+	f.StringArrayVarP(&ankiTagFlags, "tag", "", nil, "a tag to add to every card, in addition to the book name")
+
+	return cmd
+}
+
+func ankiPreRun(cmd *cobra.Command, args []string) error {
+	if ankiOutFlag == "" {
+		return errors.New("--out is required")
+	}
+	if ankiSplitFlag != splitLine && ankiSplitFlag != splitDivider {
+		return errors.Errorf("invalid --split '%s'. Use 'line' or 'divider'", ankiSplitFlag)
+	}
+
+	return nil
+}
+
+// splitNote splits a note body into the front and the back of a card in the
+// given mode. It returns false if the body does not match the mode.
+func splitNote(body, mode string) (string, string, bool) {
+	var front, back string
+
+	switch mode {
+	case splitLine:
+		parts := strings.SplitN(strings.TrimSpace(body), "\n", 2)
+		if len(parts) != 2 {
+			return "", "", false
+		}
+
+		front, back = parts[0], parts[1]
+	case splitDivider:
+		lines := strings.Split(body, "\n")
+
+		idx := -1
+		for i, line := range lines {
+			if strings.TrimSpace(line) == ankiDivider {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return "", "", false
+		}
+
+		front = strings.Join(lines[:idx], "\n")
+		back = strings.Join(lines[idx+1:], "\n")
+	}
+
+	front, back = strings.TrimSpace(front), strings.TrimSpace(back)
+	if front == "" || back == "" {
+		return "", "", false
+	}
+
+	return front, back, true
+}
+
+// escapeAnkiField escapes a text as an HTML field of the import file. The
+// newlines become line breaks, and the tabs, which separate the fields, are
+// written as character references.
+func escapeAnkiField(s string) string {
+	s = html.EscapeString(s)
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\n", "<br>", -1)
+	s = strings.Replace(s, "\t", "&#9;", -1)
+
+	return s
+}
+
+// ankiTag turns a text into a tag, which cannot contain any whitespace
+func ankiTag(s string) string {
+	return strings.Join(strings.Fields(s), "_")
+}
+
+// ankiTags returns the tags of the cards exported from the given book
+func ankiTags(bookLabel string, extra []string) string {
+	tags := []string{ankiTag(bookLabel)}
+	for _, t := range extra {
+		if tag := ankiTag(t); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return html.EscapeString(strings.Join(tags, " "))
+}
+
+// skippedNote is a note that does not match the split mode
+type skippedNote struct {
+	RowID int
+	UUID  string
+}
+
+// writeAnkiDeck writes the notes in the book as an Anki import file, and
+// returns the notes that were skipped
+func writeAnkiDeck(w io.Writer, db *database.DB, bookLabel, mode string, tags []string) (int, []skippedNote, error) {
+	bookUUID, err := database.GetBookUUID(db, bookLabel)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "finding the book")
+	}
+
+	rows, err := db.Query(`SELECT rowid, uuid, body
+	FROM notes
+	WHERE book_uuid = ? AND deleted = ?
+	ORDER BY added_on ASC, rowid ASC`, bookUUID, false)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	if _, err := io.WriteString(w, ankiHeader); err != nil {
+		return 0, nil, errors.Wrap(err, "writing the header")
+	}
+
+	tagField := ankiTags(bookLabel, tags)
+
+	count := 0
+	skipped := []skippedNote{}
+	for rows.Next() {
+		var rowID int
+		var uuid, body string
+		if err := rows.Scan(&rowID, &uuid, &body); err != nil {
+			return 0, nil, errors.Wrap(err, "scanning a row")
+		}
+
+		front, back, ok := splitNote(body, mode)
+		if !ok {
+			skipped = append(skipped, skippedNote{RowID: rowID, UUID: uuid})
+			continue
+		}
+
+		// The note UUID is the GUID so that a re-export updates the cards.
+		line := strings.Join([]string{uuid, escapeAnkiField(front), escapeAnkiField(back), tagField}, "\t")
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return 0, nil, errors.Wrap(err, "writing a card")
+		}
+
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, errors.Wrap(err, "iterating rows")
+	}
+
+	return count, skipped, nil
+}
+
+func newAnkiRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		bookLabel := args[0]
+
+		var buf bytes.Buffer
+		count, skipped, err := writeAnkiDeck(&buf, ctx.DB, bookLabel, ankiSplitFlag, ankiTagFlags)
+		if err != nil {
+			return err
+		}
+
+		if err := utils.WriteFileAtomic(ankiOutFlag, buf.Bytes(), 0644); err != nil {
+			return errors.Wrap(err, "writing the deck")
+		}
+
+		if len(skipped) > 0 {
+			log.Warn(i18n.T("export.anki_skipped", len(skipped), ankiSplitFlag))
+			for _, n := range skipped {
+				log.Plainf("  (%d) %s\n", n.RowID, n.UUID)
+			}
+		}
+
+		log.Success(i18n.T("export.anki_exported", count, ankiOutFlag))
+
+		return nil
+	}
+}
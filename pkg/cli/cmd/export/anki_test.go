@@ -0,0 +1,107 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+func TestExportAnki(t *testing.T) {
+	testCases := []struct {
+		split   string
+		skipped []string
+	}{
+		{split: "line", skipped: []string{"(5) "}},
+		{split: "divider", skipped: []string{"(1) ", "(2) ", "(3) ", "(5) ", "(7) "}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.split, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			f := testutils.NewFixture(t, ctx.DB)
+			b1 := f.Book("web dev")
+			b2 := f.Book("css")
+			f.Note(b1, "What is a <div>?\nA block element & a \"container\"", testutils.With.UUID("a3a82d4c-06a6-4a5e-a1d3-f6e7e6b5d1b1"), testutils.With.AddedOn(1))
+			f.Note(b1, "tab\tin front\nline 1\n\tindented line 2", testutils.With.UUID("b5f3c7f1-93a2-4c2b-8c35-3d1e8a2f6f0e"), testutils.With.AddedOn(2))
+			f.Note(b1, "front\r\nback with 'quotes'", testutils.With.UUID("c1d2e3f4-5a6b-4c7d-8e9f-0a1b2c3d4e5f"), testutils.With.AddedOn(3))
+			f.Note(b1, "question\n  ---  \nanswer\n---\nmore", testutils.With.UUID("d9e8f7a6-b5c4-4d3e-9f2a-1b0c9d8e7f6a"), testutils.With.AddedOn(4))
+			f.Note(b1, "single line", testutils.With.UUID("e0f1a2b3-c4d5-4e6f-8a9b-0c1d2e3f4a5b"), testutils.With.AddedOn(5))
+			f.Note(b1, "removed\nnote", testutils.With.AddedOn(6), testutils.With.Deleted())
+			f.Note(b1, "---\nno front", testutils.With.UUID("f6a5b4c3-d2e1-4f0a-9b8c-7d6e5f4a3b2c"), testutils.With.AddedOn(7))
+			f.Note(b2, "other\nbook", testutils.With.AddedOn(8))
+
+			outPath := filepath.Join(dir, "deck.txt")
+			result := testutils.RunCmd(t, ctx, NewCmd, "anki", "web dev", "--out", outPath, "--split", tc.split, "--tag", "study group")
+			assert.Equal(t, result.Err, nil, "error mismatch")
+
+			got, err := ioutil.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "reading the deck"))
+			}
+			testutils.AssertGolden(t, got, "anki_"+tc.split)
+
+			for _, s := range tc.skipped {
+				assert.Equal(t, strings.Contains(string(result.Stdout), s), true, fmt.Sprintf("skipped note %s mismatch", s))
+			}
+		})
+	}
+}
+
+func TestExportAnki_invalid(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	f.Book("js")
+
+	outPath := filepath.Join(dir, "deck.txt")
+	testCases := [][]string{
+		{"anki", "js"},
+		{"anki", "js", "--out", outPath, "--split", "heading"},
+		{"anki", "css", "--out", outPath},
+	}
+
+	for idx, args := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+
+			assert.NotEqual(t, result.Err, nil, "error mismatch")
+		})
+	}
+
+	ok, err := utils.FileExists(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ok, false, "deck should not be written")
+}
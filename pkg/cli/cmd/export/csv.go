@@ -0,0 +1,214 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var csvExample = `
+ * Export all notes
+ dnote export csv > notes.csv
+
+ * Export the notes in a book
+ dnote export csv --book javascript > javascript.csv
+
+ * Export only the metadata of the notes
+ dnote export csv --no-body > notes.csv
+
+ * Export the given columns
+ dnote export csv --fields book,added_on,body > notes.csv`
+
+// csvFields are the columns that can be exported, in the default order
+var csvFields = []string{"uuid", "book", "added_on", "edited_on", "public", "body"}
+
+var csvBookFlag string
+var csvFieldsFlag string
+var csvNoBodyFlag bool
+
+func newCSVCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "csv",
+		Short:   "Export notes as CSV",
+		Example: csvExample,
+		Args:    cobra.NoArgs,
+		RunE:    newCSVRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&csvBookFlag, "book", "b", "", "the book to export. If not given, all books are exported")
+	f.StringVarP(&csvFieldsFlag, "fields", "", strings.Join(csvFields, ","), "the comma-separated columns to export")
+	f.BoolVarP(&csvNoBodyFlag, "no-body", "", false, "leave out the body column")
+
+	return cmd
+}
+
+// parseCSVFields parses the value of the fields flag
+func parseCSVFields(s string, noBody bool) ([]string, error) {
+	valid := map[string]bool{}
+	for _, f := range csvFields {
+		valid[f] = true
+	}
+
+	ret := []string{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if !valid[f] {
+			return nil, errors.Errorf("invalid field '%s'. Use %s", f, strings.Join(csvFields, ", "))
+		}
+		if noBody && f == "body" {
+			continue
+		}
+
+		ret = append(ret, f)
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("no fields to export")
+	}
+
+	return ret, nil
+}
+
+// formatCSVTime formats a timestamp in ISO 8601. The zero timestamp, which a
+// note that has never been edited has, is empty.
+func formatCSVTime(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+
+	return time.Unix(0, ts).UTC().Format(time.RFC3339)
+}
+
+// writeCSV writes the notes in the book, or in all books if the label is
+// empty, as CSV with a header row. The rows are written as they are read so
+// that the memory use does not grow with the number of notes, and the bodies
+// are not read unless they are exported.
+func writeCSV(w io.Writer, db *database.DB, bookLabel string, fields []string) error {
+	bodyColumn := "''"
+	for _, f := range fields {
+		if f == "body" {
+			bodyColumn = "notes.body"
+		}
+	}
+
+	query := `SELECT notes.uuid, books.label, notes.added_on, notes.edited_on, notes.public, ` + bodyColumn + `
+	FROM notes
+	INNER JOIN books ON books.uuid = notes.book_uuid
+	WHERE notes.deleted = ?`
+	args := []interface{}{false}
+
+	if bookLabel != "" {
+		bookUUID, err := database.GetBookUUID(db, bookLabel)
+		if err != nil {
+			return errors.Wrap(err, "finding the book")
+		}
+
+		query += " AND notes.book_uuid = ?"
+		args = append(args, bookUUID)
+	}
+	query += " ORDER BY books.label ASC, notes.added_on ASC, notes.rowid ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	// RFC 4180 ends each record with CRLF
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+
+	if err := cw.Write(fields); err != nil {
+		return errors.Wrap(err, "writing the header")
+	}
+
+	record := make([]string, len(fields))
+	for rows.Next() {
+		var uuid, label, body string
+		var addedOn, editedOn int64
+		var public bool
+		if err := rows.Scan(&uuid, &label, &addedOn, &editedOn, &public, &body); err != nil {
+			return errors.Wrap(err, "scanning a row")
+		}
+
+		for i, f := range fields {
+			switch f {
+			case "uuid":
+				record[i] = uuid
+			case "book":
+				record[i] = label
+			case "added_on":
+				record[i] = formatCSVTime(addedOn)
+			case "edited_on":
+				record[i] = formatCSVTime(editedOn)
+			case "public":
+				record[i] = strconv.FormatBool(public)
+			case "body":
+				record[i] = body
+			}
+		}
+
+		// A record of a single empty field would be written as an empty line,
+		// which the readers skip, so quote it.
+		if len(record) == 1 && record[0] == "" {
+			cw.Flush()
+			if _, err := io.WriteString(w, "\"\"\r\n"); err != nil {
+				return errors.Wrap(err, "writing a row")
+			}
+
+			continue
+		}
+
+		if err := cw.Write(record); err != nil {
+			return errors.Wrap(err, "writing a row")
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "iterating rows")
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return errors.Wrap(err, "flushing")
+	}
+
+	return nil
+}
+
+func newCSVRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		fields, err := parseCSVFields(csvFieldsFlag, csvNoBodyFlag)
+		if err != nil {
+			return err
+		}
+
+		return writeCSV(os.Stdout, ctx.DB, csvBookFlag, fields)
+	}
+}
@@ -0,0 +1,140 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// csvBodies are the bodies of the notes exported by the CSV tests
+var csvBodies = []string{
+	"plain",
+	"comma, separated",
+	"quote \"inside\" the body",
+	"ends with a quote then a newline \"\nsecond line",
+	"\"\n",
+	"line 1\nline 2\n\nline 4",
+	" leading and trailing spaces ",
+	"",
+}
+
+func setupCSVFixture(t *testing.T, ctx context.DnoteCtx) {
+	f := testutils.NewFixture(t, ctx.DB)
+
+	js := f.Book("js")
+	css := f.Book("css, etc")
+
+	addedOn := time.Date(2021, time.March, 1, 9, 30, 0, 0, time.UTC)
+	for i, body := range csvBodies {
+		opts := []testutils.FixtureOption{
+			testutils.With.UUID(fmt.Sprintf("00000000-0000-4000-8000-%012d", i)),
+			testutils.With.AddedOn(addedOn.Add(time.Duration(i) * time.Hour).UnixNano()),
+		}
+		if i%2 == 0 {
+			opts = append(opts, testutils.With.Public(), testutils.With.EditedOn(addedOn.Add(48*time.Hour).UnixNano()))
+		}
+
+		f.Note(js, body, opts...)
+	}
+
+	f.Note(css, "flexbox", testutils.With.UUID("00000000-0000-4000-8000-100000000000"), testutils.With.AddedOn(addedOn.UnixNano()))
+	f.Note(css, "removed", testutils.With.AddedOn(addedOn.UnixNano()), testutils.With.Deleted())
+}
+
+func TestExportCSV(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "all", args: []string{}},
+		{name: "book", args: []string{"--book", "css, etc"}},
+		{name: "fields", args: []string{"--fields", "body,book"}},
+		{name: "no_body", args: []string{"--no-body"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			setupCSVFixture(t, ctx)
+
+			result := testutils.RunCmd(t, ctx, NewCmd, append([]string{"csv"}, tc.args...)...)
+			assert.Equal(t, result.Err, nil, "error mismatch")
+			assert.Equal(t, string(result.Stderr), "", "stderr mismatch")
+
+			testutils.AssertGolden(t, result.Stdout, "csv_"+tc.name)
+		})
+	}
+}
+
+func TestExportCSV_roundTrip(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupCSVFixture(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "csv", "--book", "js", "--fields", "body")
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	records, err := csv.NewReader(bytes.NewReader(result.Stdout)).ReadAll()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the CSV"))
+	}
+
+	got := []string{}
+	for _, r := range records[1:] {
+		got = append(got, r[0])
+	}
+	assert.DeepEqual(t, records[0], []string{"body"}, "header mismatch")
+	assert.DeepEqual(t, got, csvBodies, "bodies mismatch")
+}
+
+func TestExportCSV_invalid(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	testutils.NewFixture(t, ctx.DB).Book("js")
+
+	testCases := [][]string{
+		{"csv", "--fields", "uuid,title"},
+		{"csv", "--fields", "body", "--no-body"},
+		{"csv", "--book", "css"},
+	}
+
+	for idx, args := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+
+			assert.NotEqual(t, result.Err, nil, "error mismatch")
+		})
+	}
+}
@@ -0,0 +1,373 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var htmlExample = `
+ * Export all books as a static site
+ dnote export html --out ./site
+
+ * Export the public notes in some books
+ dnote export html --out ./site --public-only --book javascript --book css`
+
+var htmlOutFlag string
+var htmlPublicOnlyFlag bool
+var htmlBookFlags []string
+
+// htmlDateFormat is the format of the dates in the note footers
+const htmlDateFormat = "2006-01-02"
+
+// noteLinkRegex matches the destination of a link to a note, such as the URL
+// of the note in the web application or its bare UUID
+var noteLinkRegex = regexp.MustCompile(`^(?:.*/notes/)?([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})/?$`)
+
+func newHTMLCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "html",
+		Short:   "Export notes as a static HTML site",
+		Example: htmlExample,
+		Args:    cobra.NoArgs,
+		PreRunE: htmlPreRun,
+		RunE:    newHTMLRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&htmlOutFlag, "out", "o", "", "the directory to write the site to")
+	f.BoolVarP(&htmlPublicOnlyFlag, "public-only", "", false, "export only the public notes")
+	f.StringArrayVarP(&htmlBookFlags, "book", "b", nil, "a book to export. If not given, all books are exported")
+
+	return cmd
+}
+
+func htmlPreRun(cmd *cobra.Command, args []string) error {
+	if htmlOutFlag == "" {
+		return errors.New("--out is required")
+	}
+
+	return nil
+}
+
+// siteNote is a note on the site
+type siteNote struct {
+	UUID     string
+	Title    string
+	Body     string
+	AddedOn  int64
+	EditedOn int64
+}
+
+// siteBook is a book on the site and its notes
+type siteBook struct {
+	Label string
+	Notes []siteNote
+}
+
+// Dir returns the name of the directory of the book. The label is escaped so
+// that a label containing a slash is a single directory.
+func (b siteBook) Dir() string {
+	return url.PathEscape(b.Label)
+}
+
+// Href returns the link to the directory of the book, relative to the root
+func (b siteBook) Href() string {
+	return url.PathEscape(b.Dir())
+}
+
+// noteTitle returns the first non-empty line of a note body without the
+// Markdown heading marker
+func noteTitle(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+		if line != "" {
+			return line
+		}
+	}
+
+	return "Untitled"
+}
+
+// getSiteBooks returns the books with the given labels, or all books if none
+// is given, and their notes. The books without any notes to export are left
+// out.
+func getSiteBooks(db *database.DB, labels []string, publicOnly bool) ([]siteBook, error) {
+	if len(labels) == 0 {
+		var err error
+		labels, err = database.GetBookLabels(db)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting the books")
+		}
+	}
+
+	ret := []siteBook{}
+	seen := map[string]bool{}
+	for _, label := range labels {
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+
+		bookUUID, err := database.GetBookUUID(db, label)
+		if err != nil {
+			return nil, errors.Wrap(err, "finding the book")
+		}
+
+		rows, err := db.Query(`SELECT uuid, body, added_on, edited_on
+		FROM notes
+		WHERE book_uuid = ? AND deleted = ? AND (public = ? OR ?)
+		ORDER BY added_on ASC, rowid ASC`, bookUUID, false, true, !publicOnly)
+		if err != nil {
+			return nil, errors.Wrapf(err, "querying the notes in %s", label)
+		}
+
+		book := siteBook{Label: label}
+		for rows.Next() {
+			var n siteNote
+			if err := rows.Scan(&n.UUID, &n.Body, &n.AddedOn, &n.EditedOn); err != nil {
+				rows.Close()
+				return nil, errors.Wrap(err, "scanning a row")
+			}
+
+			n.Title = noteTitle(n.Body)
+			book.Notes = append(book.Notes, n)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, errors.Wrap(err, "iterating rows")
+		}
+
+		if len(book.Notes) > 0 {
+			ret = append(ret, book)
+		}
+	}
+
+	// sort the books by label regardless of the order of the flags
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Label < ret[j].Label
+	})
+
+	return ret, nil
+}
+
+// noteLinker rewrites the links to the exported notes into relative links to
+// their pages
+type noteLinker struct {
+	// hrefs maps the UUIDs of the exported notes to their links relative to a
+	// book directory
+	hrefs map[string]string
+}
+
+func (l noteLinker) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		link, ok := n.(*ast.Link)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		m := noteLinkRegex.FindSubmatch(link.Destination)
+		if m == nil {
+			return ast.WalkContinue, nil
+		}
+		if href, ok := l.hrefs[strings.ToLower(string(m[1]))]; ok {
+			link.Destination = []byte(href)
+		}
+
+		return ast.WalkContinue, nil
+	})
+}
+
+// newMarkdown returns a Markdown renderer that resolves the links to the
+// exported notes. Raw HTML in the notes is not rendered.
+func newMarkdown(books []siteBook) goldmark.Markdown {
+	hrefs := map[string]string{}
+	for _, b := range books {
+		for _, n := range b.Notes {
+			hrefs[strings.ToLower(n.UUID)] = "../" + b.Href() + "/" + n.UUID + ".html"
+		}
+	}
+
+	return goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(
+			parser.WithASTTransformers(util.Prioritized(noteLinker{hrefs: hrefs}, 100)),
+		),
+	)
+}
+
+// siteStyle is the stylesheet embedded in every page
+const siteStyle = `body{max-width:42rem;margin:2rem auto;padding:0 1rem;font-family:-apple-system,BlinkMacSystemFont,"Segoe UI",Helvetica,Arial,sans-serif;line-height:1.6;color:#222}
+a{color:#1a5fb4}
+nav{margin-bottom:1.5rem;font-size:.9rem}
+pre{overflow-x:auto;padding:.75rem;background:#f4f4f4}
+code{font-size:.9em}
+ul.list{padding-left:1.2rem}
+footer{margin-top:2rem;padding-top:.5rem;border-top:1px solid #ddd;font-size:.85rem;color:#666}`
+
+var siteTemplates = template.Must(template.New("site").Parse(`{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+` + siteStyle + `
+</style>
+</head>
+<body>
+{{end}}
+{{define "index"}}{{template "head" "Dnote"}}<h1>Books</h1>
+<ul class="list">
+{{range .}}<li><a href="{{.Href}}/index.html">{{.Label}}</a> ({{len .Notes}})</li>
+{{end}}</ul>
+</body>
+</html>
+{{end}}
+{{define "book"}}{{template "head" .Label}}<nav><a href="../index.html">Books</a></nav>
+<h1>{{.Label}}</h1>
+<ul class="list">
+{{range .Notes}}<li><a href="{{.UUID}}.html">{{.Title}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+{{end}}
+{{define "note"}}{{template "head" .Title}}<nav><a href="../index.html">Books</a> / <a href="index.html">{{.Book}}</a></nav>
+<article>
+{{.Content}}</article>
+<footer>Added {{.AddedOn}}{{if .EditedOn}} · Edited {{.EditedOn}}{{end}}</footer>
+</body>
+</html>
+{{end}}`))
+
+// notePage is the data of a note page
+type notePage struct {
+	Title    string
+	Book     string
+	Content  template.HTML
+	AddedOn  string
+	EditedOn string
+}
+
+func formatSiteDate(ts int64) string {
+	return time.Unix(0, ts).UTC().Format(htmlDateFormat)
+}
+
+// writeSite writes the pages of the books to the given directory. The pages
+// depend only on the notes, so that exporting the same notes again produces
+// the same files.
+func writeSite(dir string, books []siteBook) (int, error) {
+	md := newMarkdown(books)
+
+	render := func(path, name string, data interface{}) error {
+		var buf bytes.Buffer
+		if err := siteTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+			return errors.Wrapf(err, "rendering %s", path)
+		}
+		if err := utils.WriteFileAtomic(path, buf.Bytes(), 0644); err != nil {
+			return errors.Wrapf(err, "writing %s", path)
+		}
+
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, errors.Wrap(err, "creating the directory")
+	}
+	if err := render(filepath.Join(dir, "index.html"), "index", books); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, b := range books {
+		bookDir := filepath.Join(dir, b.Dir())
+		if err := os.MkdirAll(bookDir, 0755); err != nil {
+			return 0, errors.Wrapf(err, "creating the directory of %s", b.Label)
+		}
+		if err := render(filepath.Join(bookDir, "index.html"), "book", b); err != nil {
+			return 0, err
+		}
+
+		for _, n := range b.Notes {
+			var content bytes.Buffer
+			if err := md.Convert([]byte(n.Body), &content); err != nil {
+				return 0, errors.Wrapf(err, "rendering the note %s", n.UUID)
+			}
+
+			page := notePage{
+				Title:   n.Title,
+				Book:    b.Label,
+				Content: template.HTML(content.String()),
+				AddedOn: formatSiteDate(n.AddedOn),
+			}
+			if n.EditedOn != 0 {
+				page.EditedOn = formatSiteDate(n.EditedOn)
+			}
+
+			if err := render(filepath.Join(bookDir, n.UUID+".html"), "note", page); err != nil {
+				return 0, err
+			}
+
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func newHTMLRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		books, err := getSiteBooks(ctx.DB, htmlBookFlags, htmlPublicOnlyFlag)
+		if err != nil {
+			return err
+		}
+
+		count, err := writeSite(htmlOutFlag, books)
+		if err != nil {
+			return errors.Wrap(err, "writing the site")
+		}
+
+		log.Success(i18n.T("export.exported", count, len(books), htmlOutFlag))
+
+		return nil
+	}
+}
@@ -0,0 +1,142 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// readSite returns the contents of the files in the site directory by their
+// paths relative to it
+func readSite(t *testing.T, dir string) map[string][]byte {
+	ret := map[string][]byte{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		ret[filepath.ToSlash(rel)] = b
+		return nil
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the site"))
+	}
+
+	return ret
+}
+
+func setupSiteFixture(t *testing.T, ctx context.DnoteCtx) {
+	f := testutils.NewFixture(t, ctx.DB)
+
+	js := f.Book("js")
+	css := f.Book("css")
+	f.Book("empty")
+	cpp := f.Book("c/c++")
+
+	day := int64(24 * 60 * 60 * 1e9)
+	f.Note(js, "# Closures\n\nA closure is a function that captures its scope. See [hoisting](https://app.getdnote.com/notes/2b7c5e1f-8d3a-4e6b-9c0d-1a2b3c4d5e6f).\n\n```js\nconst f = () => x;\n```", testutils.With.UUID("1a6d4c2e-7b3f-4a5e-8c9d-0e1f2a3b4c5d"), testutils.With.AddedOn(18000*day), testutils.With.Public())
+	f.Note(js, "Hoisting moves the declarations to the top. <script>alert(1)</script>\n\nBack to [closures](1a6d4c2e-7b3f-4a5e-8c9d-0e1f2a3b4c5d) and the [private note](3c8d6f2a-9e4b-4f7c-8d1e-2b3c4d5e6f7a).", testutils.With.UUID("2b7c5e1f-8d3a-4e6b-9c0d-1a2b3c4d5e6f"), testutils.With.AddedOn(18001*day), testutils.With.EditedOn(18005*day), testutils.With.Public())
+	f.Note(js, "private note", testutils.With.UUID("3c8d6f2a-9e4b-4f7c-8d1e-2b3c4d5e6f7a"), testutils.With.AddedOn(18002*day))
+	f.Note(js, "removed note", testutils.With.AddedOn(18003*day), testutils.With.Deleted(), testutils.With.Public())
+	f.Note(css, "- flexbox\n- grid", testutils.With.UUID("4d9e7a3b-0f5c-4a8d-9e2f-3c4d5e6f7a8b"), testutils.With.AddedOn(18004*day))
+	f.Note(cpp, "pointers & references", testutils.With.UUID("5e0f8b4c-1a6d-4b9e-8f3a-4d5e6f7a8b9c"), testutils.With.AddedOn(18006*day), testutils.With.Public())
+}
+
+func TestExportHTML(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "all", args: []string{}},
+		{name: "public", args: []string{"--public-only"}},
+		{name: "books", args: []string{"--book", "css", "--book", "c/c++"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			setupSiteFixture(t, ctx)
+
+			outDir := filepath.Join(dir, "site")
+			args := append([]string{"html", "--out", outDir}, tc.args...)
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+			assert.Equal(t, result.Err, nil, "error mismatch")
+
+			site := readSite(t, outDir)
+
+			paths := []string{}
+			for path := range site {
+				paths = append(paths, path)
+				testutils.AssertGolden(t, site[path], filepath.Join("html_"+tc.name, path))
+			}
+			sort.Strings(paths)
+			testutils.AssertGolden(t, []byte(strings.Join(paths, "\n")+"\n"), filepath.Join("html_"+tc.name, "files"))
+
+			// exporting again produces the same files
+			result = testutils.RunCmd(t, ctx, NewCmd, args...)
+			assert.Equal(t, result.Err, nil, "error mismatch on the second export")
+			assert.DeepEqual(t, readSite(t, outDir), site, "site mismatch on the second export")
+		})
+	}
+}
+
+func TestExportHTML_invalid(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	testutils.NewFixture(t, ctx.DB).Book("js")
+
+	testCases := [][]string{
+		{"html"},
+		{"html", "--out", filepath.Join(dir, "site"), "--book", "css"},
+	}
+
+	for idx, args := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+
+			assert.NotEqual(t, result.Err, nil, "error mismatch")
+		})
+	}
+}
@@ -0,0 +1,304 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var icsExample = `
+ * Export the note activity as a calendar
+ dnote export ics --out notes.ics
+
+ * Export the notes in a book added or edited since a date
+ dnote export ics --out notes.ics --book javascript --since 2021-01-01 --edits`
+
+var icsOutFlag string
+var icsBookFlag string
+var icsSinceFlag string
+var icsEditsFlag bool
+
+const (
+	// icsSinceFormat is the format of the since flag
+	icsSinceFormat = "2006-01-02"
+	// icsTimeFormat is the format of the dates in UTC
+	icsTimeFormat = "20060102T150405Z"
+	// icsLineLimit is the maximum length of a content line in octets,
+	// excluding the line break
+	icsLineLimit = 75
+	// icsSummaryLimit is the maximum length of a summary in characters
+	icsSummaryLimit = 60
+)
+
+func newICSCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "ics",
+		Short:   "Export the note activity as an iCalendar file",
+		Example: icsExample,
+		Args:    cobra.NoArgs,
+		PreRunE: icsPreRun,
+		RunE:    newICSRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&icsOutFlag, "out", "o", "", "the file to write the calendar to")
+	f.StringVarP(&icsBookFlag, "book", "b", "", "the book to export. If not given, all books are exported")
+	f.StringVarP(&icsSinceFlag, "since", "", "", "export only the activity on or after the date, in YYYY-MM-DD")
+	f.BoolVarP(&icsEditsFlag, "edits", "", false, "add an event for the last edit of each note")
+
+	return cmd
+}
+
+func icsPreRun(cmd *cobra.Command, args []string) error {
+	if icsOutFlag == "" {
+		return errors.New("--out is required")
+	}
+
+	return nil
+}
+
+// parseICSSince parses the value of the since flag as the start of the day
+// in UTC. An empty value is the zero timestamp.
+func parseICSSince(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	t, err := time.Parse(icsSinceFormat, s)
+	if err != nil {
+		return 0, errors.Errorf("invalid date '%s'. Use YYYY-MM-DD", s)
+	}
+
+	return t.UnixNano(), nil
+}
+
+// icsEvent is an event in the calendar
+type icsEvent struct {
+	uid         string
+	ts          int64
+	summary     string
+	description string
+	category    string
+}
+
+// escapeICSText escapes a TEXT value as in RFC 5545 section 3.3.11
+func escapeICSText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\', ';', ',':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// foldICSLine splits a content line into lines of at most icsLineLimit
+// octets, joined by a line break and a space as in RFC 5545 section 3.1.
+// A multi-octet character is never split.
+func foldICSLine(line string) string {
+	var b strings.Builder
+
+	limit := icsLineLimit
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+
+		b.WriteString(line[:i])
+		b.WriteString("\r\n ")
+		line = line[i:]
+
+		// the leading space of a continuation line counts toward the limit
+		limit = icsLineLimit - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+
+	return b.String()
+}
+
+// icsSummary returns the title of a note, truncated to icsSummaryLimit
+// characters
+func icsSummary(body string) string {
+	title := noteTitle(body)
+
+	if utf8.RuneCountInString(title) <= icsSummaryLimit {
+		return title
+	}
+
+	runes := []rune(title)
+	return strings.TrimSpace(string(runes[:icsSummaryLimit-3])) + "..."
+}
+
+func formatICSTime(ts int64) string {
+	return time.Unix(0, ts).UTC().Format(icsTimeFormat)
+}
+
+// getICSEvents returns the events of the notes in the book, or in all books
+// if the label is empty, that happened on or after the timestamp. A note has
+// an event for when it was added, and for when it was last edited if edits
+// is true, because the earlier edits are not kept.
+func getICSEvents(db *database.DB, bookLabel string, since int64, edits bool) ([]icsEvent, error) {
+	query := `SELECT notes.uuid, books.label, notes.body, notes.added_on, notes.edited_on
+	FROM notes
+	INNER JOIN books ON books.uuid = notes.book_uuid
+	WHERE notes.deleted = ? AND (notes.added_on >= ? OR (? AND notes.edited_on >= ?))`
+	args := []interface{}{false, since, edits, since}
+
+	if bookLabel != "" {
+		bookUUID, err := database.GetBookUUID(db, bookLabel)
+		if err != nil {
+			return nil, errors.Wrap(err, "finding the book")
+		}
+
+		query += " AND notes.book_uuid = ?"
+		args = append(args, bookUUID)
+	}
+	query += " ORDER BY notes.added_on ASC, notes.rowid ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	ret := []icsEvent{}
+	for rows.Next() {
+		var uuid, label, body string
+		var addedOn, editedOn int64
+		if err := rows.Scan(&uuid, &label, &body, &addedOn, &editedOn); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		summary := icsSummary(body)
+
+		if addedOn >= since {
+			ret = append(ret, icsEvent{
+				uid:         uuid + "@dnote",
+				ts:          addedOn,
+				summary:     summary,
+				description: body,
+				category:    label,
+			})
+		}
+		if edits && editedOn != 0 && editedOn >= since {
+			ret = append(ret, icsEvent{
+				uid:         uuid + "-edited@dnote",
+				ts:          editedOn,
+				summary:     "Edited: " + summary,
+				description: body,
+				category:    label,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating rows")
+	}
+
+	return ret, nil
+}
+
+// writeICS writes the events as an iCalendar object. The stamp of an event
+// is the time of the activity rather than the time of the export, so that
+// exporting the same notes again produces the same file.
+func writeICS(w io.Writer, events []icsEvent) error {
+	bw := bufio.NewWriter(w)
+
+	write := func(name, value string) {
+		bw.WriteString(foldICSLine(name + ":" + value))
+	}
+
+	write("BEGIN", "VCALENDAR")
+	write("VERSION", "2.0")
+	write("PRODID", "-//Dnote//Dnote CLI//EN")
+	write("CALSCALE", "GREGORIAN")
+
+	for _, e := range events {
+		ts := formatICSTime(e.ts)
+
+		write("BEGIN", "VEVENT")
+		write("UID", e.uid)
+		write("DTSTAMP", ts)
+		write("DTSTART", ts)
+		write("SUMMARY", escapeICSText(e.summary))
+		write("DESCRIPTION", escapeICSText(e.description))
+		write("CATEGORIES", escapeICSText(e.category))
+		write("TRANSP", "TRANSPARENT")
+		write("END", "VEVENT")
+	}
+
+	write("END", "VCALENDAR")
+
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "writing the calendar")
+	}
+
+	return nil
+}
+
+func newICSRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		since, err := parseICSSince(icsSinceFlag)
+		if err != nil {
+			return err
+		}
+
+		events, err := getICSEvents(ctx.DB, icsBookFlag, since, icsEditsFlag)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := writeICS(&buf, events); err != nil {
+			return err
+		}
+
+		if err := utils.WriteFileAtomic(icsOutFlag, buf.Bytes(), 0644); err != nil {
+			return errors.Wrapf(err, "writing %s", icsOutFlag)
+		}
+
+		log.Success(i18n.T("export.ics_exported", len(events), icsOutFlag))
+
+		return nil
+	}
+}
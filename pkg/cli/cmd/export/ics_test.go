@@ -0,0 +1,294 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// icsProperty is a property of a component in an iCalendar object
+type icsProperty struct {
+	name  string
+	value string
+}
+
+// icsComponent is a component in an iCalendar object and its subcomponents
+type icsComponent struct {
+	name       string
+	properties []icsProperty
+	components []icsComponent
+}
+
+func (c icsComponent) get(name string) string {
+	for _, p := range c.properties {
+		if p.name == name {
+			return p.value
+		}
+	}
+
+	return ""
+}
+
+// unescapeICSText reverses escapeICSText
+func unescapeICSText(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
+
+// parseICS parses an iCalendar object as in RFC 5545, checking the line
+// breaks, the line lengths and the nesting of the components
+func parseICS(s string) (icsComponent, error) {
+	if !strings.HasSuffix(s, "\r\n") {
+		return icsComponent{}, errors.New("the object does not end with a line break")
+	}
+
+	var lines []string
+	for i, line := range strings.Split(strings.TrimSuffix(s, "\r\n"), "\r\n") {
+		if strings.Contains(line, "\n") {
+			return icsComponent{}, errors.Errorf("line %d has a bare line feed", i+1)
+		}
+		if len(line) > 75 {
+			return icsComponent{}, errors.Errorf("line %d is longer than 75 octets", i+1)
+		}
+		if !utf8.ValidString(line) {
+			return icsComponent{}, errors.Errorf("line %d splits a character", i+1)
+		}
+
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if len(lines) == 0 {
+				return icsComponent{}, errors.New("the object starts with a continuation line")
+			}
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	stack := []icsComponent{{}}
+	for _, line := range lines {
+		idx := strings.Index(line, ":")
+		if idx < 1 {
+			return icsComponent{}, errors.Errorf("invalid content line '%s'", line)
+		}
+		name, value := line[:idx], line[idx+1:]
+
+		switch name {
+		case "BEGIN":
+			stack = append(stack, icsComponent{name: value})
+		case "END":
+			cur := stack[len(stack)-1]
+			if len(stack) == 1 || cur.name != value {
+				return icsComponent{}, errors.Errorf("unexpected END:%s", value)
+			}
+
+			stack = stack[:len(stack)-1]
+			parent := &stack[len(stack)-1]
+			parent.components = append(parent.components, cur)
+		default:
+			cur := &stack[len(stack)-1]
+			cur.properties = append(cur.properties, icsProperty{name: name, value: value})
+		}
+	}
+
+	if len(stack) != 1 || len(stack[0].components) != 1 {
+		return icsComponent{}, errors.New("the object is not a single component")
+	}
+
+	return stack[0].components[0], nil
+}
+
+func TestFoldICSLine(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "SUMMARY:short",
+			expected: "SUMMARY:short\r\n",
+		},
+		{
+			input:    strings.Repeat("a", 75),
+			expected: strings.Repeat("a", 75) + "\r\n",
+		},
+		{
+			input:    strings.Repeat("a", 76),
+			expected: strings.Repeat("a", 75) + "\r\n a\r\n",
+		},
+		{
+			input:    strings.Repeat("a", 75+74+1),
+			expected: strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n a\r\n",
+		},
+		{
+			// the multi-octet character at the limit moves to the next line
+			input:    strings.Repeat("a", 74) + "é",
+			expected: strings.Repeat("a", 74) + "\r\n é\r\n",
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			assert.Equal(t, foldICSLine(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestEscapeICSText(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "plain", expected: "plain"},
+		{input: "a, b; c", expected: `a\, b\; c`},
+		{input: `C:\dir`, expected: `C:\\dir`},
+		{input: "line 1\nline 2\r\nline 3", expected: `line 1\nline 2\nline 3`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			assert.Equal(t, escapeICSText(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestExportICS(t *testing.T) {
+	day := int64(24 * 60 * 60 * 1e9)
+
+	testCases := []struct {
+		name string
+		args []string
+		uids []string
+	}{
+		{
+			name: "all",
+			args: []string{},
+			uids: []string{
+				"1a6d4c2e-7b3f-4a5e-8c9d-0e1f2a3b4c5d@dnote",
+				"2b7c5e1f-8d3a-4e6b-9c0d-1a2b3c4d5e6f@dnote",
+				"3c8d6f2a-9e4b-4f7c-8d1e-2b3c4d5e6f7a@dnote",
+				"4d9e7a3b-0f5c-4a8d-9e2f-3c4d5e6f7a8b@dnote",
+				"5e0f8b4c-1a6d-4b9e-8f3a-4d5e6f7a8b9c@dnote",
+				"6f1a9c5d-2b7e-4c0f-9a4b-5e6f7a8b9c0d@dnote",
+			},
+		},
+		{
+			name: "edits",
+			args: []string{"--edits", "--since", "2019-04-16"},
+			uids: []string{
+				"2b7c5e1f-8d3a-4e6b-9c0d-1a2b3c4d5e6f-edited@dnote",
+				"3c8d6f2a-9e4b-4f7c-8d1e-2b3c4d5e6f7a@dnote",
+				"4d9e7a3b-0f5c-4a8d-9e2f-3c4d5e6f7a8b@dnote",
+				"5e0f8b4c-1a6d-4b9e-8f3a-4d5e6f7a8b9c@dnote",
+				"6f1a9c5d-2b7e-4c0f-9a4b-5e6f7a8b9c0d@dnote",
+			},
+		},
+		{
+			name: "book",
+			args: []string{"--book", "js", "--since", "2019-04-15"},
+			uids: []string{
+				"2b7c5e1f-8d3a-4e6b-9c0d-1a2b3c4d5e6f@dnote",
+				"3c8d6f2a-9e4b-4f7c-8d1e-2b3c4d5e6f7a@dnote",
+				"6f1a9c5d-2b7e-4c0f-9a4b-5e6f7a8b9c0d@dnote",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			setupSiteFixture(t, ctx)
+
+			f := testutils.NewFixture(t, ctx.DB)
+			var js database.Book
+			database.MustScan(t, "getting js", ctx.DB.QueryRow("SELECT uuid, label FROM books WHERE label = ?", "js"), &js.UUID, &js.Label)
+			body := "Über die Rückgabewerte; Fehler, Ausnahmen und Panik in einer sehr langen Überschrift\nC:\\pfad"
+			f.Note(js, body, testutils.With.UUID("6f1a9c5d-2b7e-4c0f-9a4b-5e6f7a8b9c0d"), testutils.With.AddedOn(18007*day))
+
+			outPath := filepath.Join(dir, "notes.ics")
+			args := append([]string{"ics", "--out", outPath}, tc.args...)
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+			assert.Equal(t, result.Err, nil, "error mismatch")
+
+			b, err := ioutil.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "reading the calendar"))
+			}
+			testutils.AssertGolden(t, b, "ics_"+tc.name)
+
+			cal, err := parseICS(string(b))
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "parsing the calendar"))
+			}
+			assert.Equal(t, cal.name, "VCALENDAR", "component mismatch")
+			assert.Equal(t, cal.get("VERSION"), "2.0", "version mismatch")
+
+			uids := []string{}
+			for _, e := range cal.components {
+				assert.Equal(t, e.name, "VEVENT", "component mismatch")
+				assert.Equal(t, strings.HasSuffix(e.get("DTSTART"), "Z"), true, "DTSTART is not in UTC")
+				assert.Equal(t, strings.HasSuffix(e.get("DTSTAMP"), "Z"), true, "DTSTAMP is not in UTC")
+
+				uids = append(uids, e.get("UID"))
+
+				if e.get("UID") == "6f1a9c5d-2b7e-4c0f-9a4b-5e6f7a8b9c0d@dnote" {
+					assert.Equal(t, unescapeICSText(e.get("DESCRIPTION")), body, "description mismatch")
+					assert.Equal(t, unescapeICSText(e.get("SUMMARY")), "Über die Rückgabewerte; Fehler, Ausnahmen und Panik in ei...", "summary mismatch")
+					assert.Equal(t, unescapeICSText(e.get("CATEGORIES")), "js", "category mismatch")
+				}
+			}
+			assert.DeepEqual(t, uids, tc.uids, "uids mismatch")
+		})
+	}
+}
+
+func TestExportICS_invalid(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	testutils.NewFixture(t, ctx.DB).Book("js")
+
+	testCases := [][]string{
+		{"ics"},
+		{"ics", "--out", filepath.Join(dir, "notes.ics"), "--book", "css"},
+		{"ics", "--out", filepath.Join(dir, "notes.ics"), "--since", "01/02/2021"},
+	}
+
+	for _, args := range testCases {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+
+			assert.NotEqual(t, result.Err, nil, "error mismatch")
+		})
+	}
+}
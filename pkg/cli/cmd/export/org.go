@@ -0,0 +1,148 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/gpg"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/orgmode"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var orgExample = `
+ * Export all books as Org files
+ dnote export org --out ./org
+
+ * Export a book
+ dnote export org --out ./org --book javascript
+
+ * Encrypt the files to a gpg key
+ dnote export org --out ./org --encrypt me@example.com`
+
+var orgOutFlag string
+var orgBookFlag string
+var orgEncryptFlag string
+
+func newOrgCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "org",
+		Short:   "Export notes as Org files",
+		Example: orgExample,
+		Args:    cobra.NoArgs,
+		PreRunE: orgPreRun,
+		RunE:    newOrgRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&orgOutFlag, "out", "o", "", "the directory to write the files to")
+	f.StringVarP(&orgBookFlag, "book", "b", "", "the book to export. If not given, all books are exported")
+	f.StringVarP(&orgEncryptFlag, "encrypt", "", "", "encrypt the files with gpg to the given recipient")
+
+	return cmd
+}
+
+func orgPreRun(cmd *cobra.Command, args []string) error {
+	if orgOutFlag == "" {
+		return errors.New("--out is required")
+	}
+
+	return nil
+}
+
+// orgFilename returns the name of the Org file of a book. The label is
+// escaped so that a label containing a slash is a single file.
+func orgFilename(label string) string {
+	return url.PathEscape(label) + ".org"
+}
+
+// writeOrgFiles writes an Org file for each book into the directory and
+// returns the number of notes written. The files are encrypted if the
+// recipient is not empty.
+func writeOrgFiles(dir string, books []siteBook, recipient string) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, errors.Wrap(err, "creating the directory")
+	}
+
+	count := 0
+	for _, b := range books {
+		f := orgmode.File{Book: b.Label}
+		for _, n := range b.Notes {
+			f.Notes = append(f.Notes, orgmode.Note{
+				UUID:     n.UUID,
+				AddedOn:  n.AddedOn,
+				EditedOn: n.EditedOn,
+				Body:     n.Body,
+			})
+		}
+
+		path := filepath.Join(dir, orgFilename(b.Label))
+		content := []byte(orgmode.Format(f))
+
+		if recipient != "" {
+			var buf bytes.Buffer
+			if err := gpg.Encrypt(bytes.NewReader(content), &buf, recipient); err != nil {
+				return 0, errors.Wrapf(err, "encrypting %s", b.Label)
+			}
+
+			path += gpg.Ext
+			content = buf.Bytes()
+		}
+
+		if err := utils.WriteFileAtomic(path, content, 0644); err != nil {
+			return 0, errors.Wrapf(err, "writing %s", path)
+		}
+
+		count += len(b.Notes)
+	}
+
+	return count, nil
+}
+
+func newOrgRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		var labels []string
+		if orgBookFlag != "" {
+			labels = []string{orgBookFlag}
+		}
+
+		books, err := getSiteBooks(ctx.DB, labels, false)
+		if err != nil {
+			return err
+		}
+
+		count, err := writeOrgFiles(orgOutFlag, books, orgEncryptFlag)
+		if err != nil {
+			return errors.Wrap(err, "writing the Org files")
+		}
+
+		log.Success(i18n.T("export.exported", count, len(books), orgOutFlag))
+
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+)
+
+func TestExportOrg(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "all", args: []string{}},
+		{name: "book", args: []string{"--book", "c/c++"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			setupSiteFixture(t, ctx)
+
+			outDir := filepath.Join(dir, "org")
+			args := append([]string{"org", "--out", outDir}, tc.args...)
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+			assert.Equal(t, result.Err, nil, "error mismatch")
+
+			files := readSite(t, outDir)
+
+			paths := []string{}
+			for path := range files {
+				paths = append(paths, path)
+				testutils.AssertGolden(t, files[path], filepath.Join("org_"+tc.name, path))
+			}
+			sort.Strings(paths)
+			testutils.AssertGolden(t, []byte(strings.Join(paths, "\n")+"\n"), filepath.Join("org_"+tc.name, "files"))
+		})
+	}
+}
+
+func TestExportOrg_invalid(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	testCases := [][]string{
+		{"org"},
+		{"org", "--out", filepath.Join(dir, "org"), "--book", "css"},
+	}
+
+	for _, args := range testCases {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+
+			assert.NotEqual(t, result.Err, nil, "error mismatch")
+		})
+	}
+}
@@ -108,9 +108,9 @@ func formatFTSSnippet(s string) (string, error) {
 	return fmt.Sprintf(format.String(), args...), nil
 }
 
-// escapePhrase escapes the user-supplied FTS keywords by wrapping each term around
+// EscapePhrase escapes the user-supplied FTS keywords by wrapping each term around
 // double quotations so that they are treated as 'strings' as defined by SQLite FTS5.
-func escapePhrase(s string) (string, error) {
+func EscapePhrase(s string) (string, error) {
 	var b strings.Builder
 
 	terms := strings.Fields(s)
@@ -155,7 +155,7 @@ func doQuery(ctx context.DnoteCtx, query, bookName string) (*sql.Rows, error) {
 
 func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
-		phrase, err := escapePhrase(args[0])
+		phrase, err := EscapePhrase(args[0])
 		if err != nil {
 			return errors.Wrap(err, "escaping phrase")
 		}
@@ -0,0 +1,129 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package importer
+
+import (
+	"github.com/dnote/dnote/pkg/cli/accountexport"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var dnoteArchiveExample = `
+ * Load an account export of the dnote server into a new install
+ dnote import dnote-archive dnote-export.zip
+
+ * Load an export of an account other than the one logged in
+ dnote import dnote-archive dnote-export.zip --force`
+
+func newDnoteArchiveCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "dnote-archive <file.zip>",
+		Short:   "Load an account export of the dnote server",
+		Example: dnoteArchiveExample,
+		Args:    cobra.ExactArgs(1),
+		RunE:    newDnoteArchiveRun(ctx),
+	}
+
+	return cmd
+}
+
+// checkAccount checks that the account export with the given user uuid is of
+// the account logged in. Any account is accepted if none is logged in.
+func checkAccount(ctx context.DnoteCtx, userUUID string) error {
+	ok, err := infra.HasSessionKey(ctx)
+	if err != nil {
+		return errors.Wrap(err, "checking the session key")
+	}
+	if !ok {
+		return nil
+	}
+
+	ctx, err = infra.LoadSessionKey(ctx)
+	if err != nil {
+		return errors.Wrap(err, "loading the session key")
+	}
+
+	state, err := client.GetSyncState(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting the account logged in from the server")
+	}
+	if state.UserUUID == "" {
+		return errors.New("the server does not tell which account is logged in, so the account of the export cannot be checked. Use --force to load it anyway")
+	}
+	if state.UserUUID != userUUID {
+		return errors.New("the export is of an account other than the one logged in. Use --force to load it anyway")
+	}
+
+	return nil
+}
+
+// countLoaded returns the numbers of the notes and the books in the export
+// that are loaded, which are the ones that are not removed
+func countLoaded(e accountexport.Export) (int, int) {
+	books := map[string]bool{}
+	for _, b := range e.Books {
+		if !b.Deleted {
+			books[b.UUID] = true
+		}
+	}
+
+	notes := 0
+	for _, n := range e.Notes {
+		if !n.Deleted && books[n.BookUUID] {
+			notes++
+		}
+	}
+
+	return notes, len(books)
+}
+
+func newDnoteArchiveRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if ctx.SyncBackend == consts.SyncBackendGit {
+			return errors.New("an account export of the dnote server cannot be loaded while syncing through git")
+		}
+
+		e, err := accountexport.ReadFile(args[0])
+		if err != nil {
+			return errors.Wrap(err, "reading the export")
+		}
+
+		if !forceFlag {
+			if err := checkAccount(ctx, e.Metadata.UserUUID); err != nil {
+				return errors.Wrap(err, "checking the account")
+			}
+		}
+
+		if err := dnote.NewSyncer(dnote.NewStore(ctx)).LoadAccountExport(e); err != nil {
+			return errors.Wrap(err, "loading the export")
+		}
+
+		notes, books := countLoaded(e)
+		log.Success(i18n.T("import.account_loaded", notes, books))
+		log.Info(i18n.T("import.account_next_sync", e.Metadata.MaxUSN))
+
+		return nil
+	}
+}
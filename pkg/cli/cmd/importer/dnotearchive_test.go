@@ -0,0 +1,132 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package importer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+)
+
+var accountArchive = filepath.Join("testdata", "account.zip")
+
+// accountUserUUID is the user uuid in the metadata of accountArchive
+const accountUserUUID = "0f8c2a4e-5b6d-4e7f-9a1b-2c3d4e5f6a7b"
+
+func TestImportDnoteArchive(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "dnote-archive", accountArchive)
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "running the command"))
+	}
+
+	f := testutils.NewFixture(t, ctx.DB)
+	assert.Equal(t, f.MustCountBooks(), 2, "book count mismatch")
+	assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+
+	js := f.MustGetBook("b1a2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d")
+	assert.Equal(t, js.Label, "js", "js label mismatch")
+	assert.Equal(t, js.USN, 1, "js usn mismatch")
+	assert.Equal(t, js.Dirty, false, "js dirty mismatch")
+
+	n := f.MustGetNote("f5e6a7b8-c9d0-4e1f-8a3b-4c5d6e7f8a9b")
+	assert.Equal(t, n.BookUUID, js.UUID, "note book_uuid mismatch")
+	assert.Equal(t, n.Body, "Promises chain", "note body mismatch")
+	assert.Equal(t, n.USN, 11, "note usn mismatch")
+	assert.Equal(t, n.AddedOn, int64(1640995600000000000), "note added_on mismatch")
+	assert.Equal(t, n.EditedOn, int64(1641000000000000000), "note edited_on mismatch")
+	assert.Equal(t, n.Public, true, "note public mismatch")
+	assert.Equal(t, n.Dirty, false, "note dirty mismatch")
+
+	var lastMaxUSN, lastSyncAt int
+	database.MustScan(t, "getting last_max_usn", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	database.MustScan(t, "getting last_sync_time", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastSyncAt), &lastSyncAt)
+	assert.Equal(t, lastMaxUSN, 12, "last_max_usn mismatch")
+	assert.Equal(t, lastSyncAt, 1641081600, "last_sync_time mismatch")
+
+	t.Run("import again", func(t *testing.T) {
+		result := testutils.RunCmd(t, ctx, NewCmd, "dnote-archive", accountArchive)
+		assert.Equal(t, errors.Cause(result.Err), dnote.ErrAlreadySynced, "error mismatch")
+		assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+	})
+}
+
+func TestImportDnoteArchive_account(t *testing.T) {
+	testCases := []struct {
+		name     string
+		userUUID string
+		args     []string
+		loaded   bool
+	}{
+		{
+			name:     "same account",
+			userUUID: accountUserUUID,
+			loaded:   true,
+		},
+		{
+			name:     "another account",
+			userUUID: "9e8d7c6b-5a4f-4e3d-8c2b-1a0f9e8d7c6b",
+			loaded:   false,
+		},
+		{
+			name:     "another account with force",
+			userUUID: "9e8d7c6b-5a4f-4e3d-8c2b-1a0f9e8d7c6b",
+			args:     []string{"--force"},
+			loaded:   true,
+		},
+		{
+			name:     "server without user uuid",
+			userUUID: "",
+			loaded:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := testutils.NewMockServer(t)
+			defer srv.Close()
+			srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 20, CurrentTime: 1641081700, UserUUID: tc.userUUID})
+
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			testutils.Login(t, &ctx)
+			ctx.APIEndpoint = srv.URL()
+
+			result := testutils.RunCmd(t, ctx, NewCmd, append([]string{"dnote-archive", accountArchive}, tc.args...)...)
+			assert.Equal(t, result.Err == nil, tc.loaded, "success mismatch")
+
+			expected := 0
+			if tc.loaded {
+				expected = 3
+			}
+			assert.Equal(t, testutils.NewFixture(t, ctx.DB).MustCountNotes(), expected, "note count mismatch")
+		})
+	}
+}
@@ -0,0 +1,469 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/config"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var autoCreateBooksFlag string
+var forceFlag bool
+var dryRunFlag bool
+
+// NewCmd returns a new import command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import notes from other formats",
+	}
+
+	f := cmd.PersistentFlags()
+	f.StringVarP(&autoCreateBooksFlag, "auto-create-books", "", "", "whether to create the books that do not exist, which is true, false or prompt. Defaults to autoCreateBook in the config")
+	f.BoolVarP(&forceFlag, "force", "", false, "import the notes above the size limit instead of skipping their files, or load an account export without checking that it is of the account logged in")
+
+	cmd.AddCommand(newOrgCmd(ctx))
+	cmd.AddCommand(newDnoteArchiveCmd(ctx))
+	cmd.AddCommand(newSimplenoteCmd(ctx))
+	cmd.AddCommand(newStandardNotesCmd(ctx))
+
+	return cmd
+}
+
+// allowBooks checks if the notes may be imported into the books with the given
+// labels, creating the ones that do not exist under the policy set by the flag
+// or the config. It returns false if the user declines to create a book.
+func allowBooks(ctx context.DnoteCtx, labels []string) (bool, error) {
+	policy := ctx.AutoCreateBook
+	if autoCreateBooksFlag != "" {
+		p, err := config.ParseAutoCreateBook(autoCreateBooksFlag)
+		if err != nil {
+			return false, errors.Wrap(err, "invalid --auto-create-books")
+		}
+
+		policy = p
+	}
+
+	seen := map[string]bool{}
+	for _, label := range labels {
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+
+		var count int
+		if err := ctx.DB.QueryRow("SELECT count(*) FROM books WHERE label = ?", label).Scan(&count); err != nil {
+			return false, errors.Wrap(err, "counting books")
+		}
+		if count > 0 {
+			continue
+		}
+
+		ok, err := ui.AllowNewBook(ctx, label, policy, "--auto-create-books=true")
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+
+	return true, nil
+}
+
+// note is a note read from an export
+type note struct {
+	// uuid is kept if it is a valid uuid, so that importing the same export
+	// again updates the note instead of adding it again, and is generated
+	// otherwise
+	uuid string
+	// ref names the note in the messages, such as its id in the export
+	ref      string
+	book     string
+	body     string
+	addedOn  int64
+	editedOn int64
+	// deleted imports the note as removed
+	deleted bool
+}
+
+// importResult is the number of the notes imported by the kind of change
+type importResult struct {
+	added     int
+	updated   int
+	unchanged int
+	skipped   int
+	// duplicates is the number of the new notes left out because a note has
+	// the same body
+	duplicates int
+}
+
+// errDryRun rolls back the transaction of a dry run
+var errDryRun = errors.New("dry run")
+
+// hashBody returns the hash by which the notes with the same body are found
+func hashBody(body string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(body))
+}
+
+// checkNoteSize checks the body of a note against the size limits of the
+// context. It tells if the body is above the soft limit, and returns an error
+// if it is above the hard limit unless forced.
+func checkNoteSize(ctx context.DnoteCtx, body string) (bool, error) {
+	body = utils.NormalizeBody(body)
+
+	if !forceFlag {
+		if err := validate.NoteSize(body, ctx.NoteSizeMax); err != nil {
+			return false, err
+		}
+	}
+
+	return ctx.NoteSizeWarn > 0 && int64(len(body)) > ctx.NoteSizeWarn, nil
+}
+
+// filterNoteSizes leaves out the notes above the hard size limit unless
+// forced, warning about each of them, and warns about the number of the notes
+// in the export at the path that are above the soft limit
+func filterNoteSizes(ctx context.DnoteCtx, path string, notes []note) []note {
+	ret := []note{}
+	large := 0
+	for _, n := range notes {
+		isLarge, err := checkNoteSize(ctx, n.body)
+		if err != nil {
+			// a note too large for the server would fail every sync
+			log.Warn(i18n.T("import.skip_too_large", n.ref, err.Error()))
+			continue
+		}
+		if isLarge {
+			large++
+		}
+
+		ret = append(ret, n)
+	}
+
+	if large > 0 {
+		log.Warn(i18n.T("import.large_notes", path, large, ctx.NoteSizeWarn))
+	}
+
+	return ret
+}
+
+// tagBook returns the label of the book for a note with the tags, which is
+// the first tag that is a valid book name once its spaces are replaced with
+// dashes, or the given default
+func tagBook(tags []string, def string) string {
+	for _, tag := range tags {
+		label := strings.Join(strings.Fields(tag), "-")
+		if validate.BookName(label) == nil {
+			return label
+		}
+	}
+
+	return def
+}
+
+// timestamp is a time in an export in nanoseconds. It is given either as a
+// string in RFC 3339, or as a number of seconds since the epoch.
+type timestamp int64
+
+func (t *timestamp) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if s == "" {
+			*t = 0
+			return nil
+		}
+
+		v, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return errors.Errorf("invalid time '%s'", s)
+		}
+
+		*t = timestamp(v.UnixNano())
+		return nil
+	}
+
+	var secs float64
+	if err := json.Unmarshal(b, &secs); err != nil {
+		return errors.Errorf("invalid time %s", string(b))
+	}
+
+	*t = timestamp(secs * float64(time.Second))
+	return nil
+}
+
+// editedOn returns the time a note was edited given the time it was added
+// and the time it was last modified, which is zero if it was never modified
+func editedOn(added, modified timestamp) int64 {
+	if modified <= added {
+		return 0
+	}
+
+	return int64(modified)
+}
+
+// getOrCreateBook returns the uuid of the book with the label, creating it
+// if it does not exist
+func getOrCreateBook(tx *database.DB, label string) (string, error) {
+	var bookUUID string
+	err := tx.QueryRow("SELECT uuid FROM books WHERE label = ?", label).Scan(&bookUUID)
+	if err == nil {
+		return bookUUID, nil
+	} else if err != sql.ErrNoRows {
+		return "", errors.Wrap(err, "finding the book")
+	}
+
+	bookUUID, err = utils.GenerateUUID()
+	if err != nil {
+		return "", errors.Wrap(err, "generating uuid")
+	}
+
+	b := database.NewBook(bookUUID, label, 0, false, true)
+	if err := b.Insert(tx); err != nil {
+		return "", errors.Wrap(err, "creating the book")
+	}
+
+	return bookUUID, nil
+}
+
+// importer imports the notes into the database in a transaction
+type importer struct {
+	ctx context.DnoteCtx
+	tx  *database.DB
+	// normalize converts the body of an existing note into the form it has
+	// in the export, so that an unchanged note is not updated
+	normalize func(string) string
+	// books is the uuids of the books by label
+	books map[string]string
+	// hashes is the hashes of the bodies of the notes that are not removed
+	hashes map[[sha256.Size]byte]bool
+	res    importResult
+}
+
+func newImporter(ctx context.DnoteCtx, tx *database.DB, normalize func(string) string) (*importer, error) {
+	imp := &importer{
+		ctx:       ctx,
+		tx:        tx,
+		normalize: normalize,
+		books:     map[string]string{},
+		hashes:    map[[sha256.Size]byte]bool{},
+	}
+
+	rows, err := tx.Query("SELECT body FROM notes WHERE NOT deleted")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the notes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var body string
+		if err := rows.Scan(&body); err != nil {
+			return nil, errors.Wrap(err, "scanning a note")
+		}
+
+		imp.hashes[hashBody(normalize(body))] = true
+	}
+
+	return imp, rows.Err()
+}
+
+// book returns the uuid of the book with the label, creating it if it does
+// not exist
+func (imp *importer) book(label string) (string, error) {
+	if ret, ok := imp.books[label]; ok {
+		return ret, nil
+	}
+
+	ret, err := getOrCreateBook(imp.tx, label)
+	if err != nil {
+		return "", errors.Wrapf(err, "getting the book %s", label)
+	}
+	imp.books[label] = ret
+
+	return ret, nil
+}
+
+// importNote adds the note to its book, or updates the note with the same
+// uuid if it has changed. A new note is left out if a note has the same body.
+// Importing the same export again therefore does not change anything.
+func (imp *importer) importNote(n note) error {
+	if err := validate.NoteBody(n.body); err != nil {
+		return errors.Wrapf(err, "invalid note %s", n.ref)
+	}
+	body := utils.NormalizeBody(n.body)
+
+	bookUUID, err := imp.book(n.book)
+	if err != nil {
+		return err
+	}
+
+	ctx, tx := imp.ctx, imp.tx
+
+	if n.uuid != "" {
+		var rowID int
+		var curBookUUID, curBody string
+		var deleted bool
+		err := tx.QueryRow("SELECT rowid, book_uuid, body, deleted FROM notes WHERE uuid = ?", n.uuid).
+			Scan(&rowID, &curBookUUID, &curBody, &deleted)
+		if err == nil {
+			if deleted {
+				if !n.deleted {
+					log.Warn(i18n.T("import.skip_removed", n.ref))
+				}
+				imp.res.skipped++
+				return nil
+			}
+
+			changed := false
+			if imp.normalize(curBody) != body {
+				if err := database.UpdateNoteContent(tx, ctx.Clock, rowID, body, ctx.DeviceID); err != nil {
+					return errors.Wrapf(err, "updating %s", n.ref)
+				}
+				imp.hashes[hashBody(body)] = true
+				changed = true
+			}
+			if curBookUUID != bookUUID {
+				if err := database.UpdateNoteBook(tx, ctx.Clock, rowID, bookUUID, ctx.DeviceID); err != nil {
+					return errors.Wrapf(err, "moving %s", n.ref)
+				}
+				changed = true
+			}
+
+			if changed {
+				imp.res.updated++
+			} else {
+				imp.res.unchanged++
+			}
+			return nil
+		} else if err != sql.ErrNoRows {
+			return errors.Wrapf(err, "finding %s", n.ref)
+		}
+	}
+
+	hash := hashBody(body)
+	if !n.deleted && imp.hashes[hash] {
+		imp.res.duplicates++
+		return nil
+	}
+
+	noteUUID := n.uuid
+	if _, err := uuid.Parse(noteUUID); err != nil {
+		noteUUID, err = utils.GenerateUUID()
+		if err != nil {
+			return errors.Wrap(err, "generating uuid")
+		}
+	}
+
+	addedOn := n.addedOn
+	if addedOn == 0 {
+		addedOn = ctx.Clock.Now().UnixNano()
+	}
+
+	dn := database.NewNote(noteUUID, bookUUID, body, addedOn, n.editedOn, 0, false, n.deleted, true)
+	dn.OriginDevice = ctx.DeviceID
+	if err := dn.Insert(tx); err != nil {
+		return errors.Wrap(err, "creating the note")
+	}
+	if !n.deleted {
+		imp.hashes[hash] = true
+	}
+	imp.res.added++
+
+	return nil
+}
+
+// importNotes imports the notes in a single transaction so that an invalid
+// note does not leave a partial import. If dryRun is true, nothing is changed
+// and the result is what would be imported.
+func importNotes(ctx context.DnoteCtx, notes []note, normalize func(string) string, dryRun bool) (importResult, error) {
+	var res importResult
+
+	err := infra.WithTx(ctx.DB, func(tx *database.DB) error {
+		imp, err := newImporter(ctx, tx, normalize)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range notes {
+			if err := imp.importNote(n); err != nil {
+				return errors.Wrapf(err, "importing a note in %s", n.book)
+			}
+		}
+
+		res = imp.res
+		if dryRun {
+			return errDryRun
+		}
+
+		return nil
+	})
+	if err == errDryRun {
+		return res, nil
+	}
+
+	return res, err
+}
+
+// runImport imports the notes into their books, checking first that the books
+// may be created unless it is a dry run, and prints the result
+func runImport(ctx context.DnoteCtx, notes []note, normalize func(string) string) error {
+	if !dryRunFlag {
+		labels := []string{}
+		for _, n := range notes {
+			labels = append(labels, n.book)
+		}
+		ok, err := allowBooks(ctx, labels)
+		if err != nil {
+			return errors.Wrap(err, "checking the books")
+		}
+		if !ok {
+			log.Warn(i18n.T("aborted"))
+			return nil
+		}
+	}
+
+	res, err := importNotes(ctx, notes, normalize, dryRunFlag)
+	if err != nil {
+		return errors.Wrap(err, "importing the notes")
+	}
+
+	if dryRunFlag {
+		log.Info(i18n.T("import.dry_run", res.added, res.updated, res.unchanged, res.skipped))
+	} else {
+		log.Success(i18n.T("import.result", res.added, res.updated, res.unchanged, res.skipped))
+	}
+	if res.duplicates > 0 {
+		log.Info(i18n.T("import.duplicates", res.duplicates))
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+func TestTimestamp(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    int64
+		expectedErr bool
+	}{
+		{input: `"2021-03-15T09:30:00.000Z"`, expected: time.Date(2021, time.March, 15, 9, 30, 0, 0, time.UTC).UnixNano()},
+		{input: `"2021-03-15T09:30:00+09:00"`, expected: time.Date(2021, time.March, 15, 0, 30, 0, 0, time.UTC).UnixNano()},
+		{input: `1615800600`, expected: 1615800600 * int64(time.Second)},
+		{input: `""`, expected: 0},
+		{input: `"yesterday"`, expectedErr: true},
+		{input: `true`, expectedErr: true},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			var got timestamp
+			err := json.Unmarshal([]byte(tc.input), &got)
+
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+			if !tc.expectedErr {
+				assert.Equal(t, int64(got), tc.expected, "result mismatch")
+			}
+		})
+	}
+}
+
+func TestTagBook(t *testing.T) {
+	testCases := []struct {
+		tags     []string
+		expected string
+	}{
+		{tags: nil, expected: "default"},
+		{tags: []string{"golang", "work"}, expected: "golang"},
+		{tags: []string{"Work Notes"}, expected: "Work-Notes"},
+		{tags: []string{"2021", " ", "golang"}, expected: "golang"},
+		{tags: []string{"2021"}, expected: "default"},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			assert.Equal(t, tagBook(tc.tags, "default"), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestImportNotes(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	js := f.Book("js")
+	f.Note(js, "Closures\r\n- capture the scope")
+
+	notes := []note{
+		{ref: "n1", book: "js", body: "Closures\n- capture the scope"},
+		{ref: "n2", book: "css", body: "Flexbox"},
+		{ref: "n3", book: "css", body: "Flexbox"},
+		{ref: "n4", book: "css", body: "Flexbox", deleted: true},
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		res, err := importNotes(ctx, notes, utils.NormalizeBody, true)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, res, importResult{added: 2, duplicates: 2}, "result mismatch")
+		assert.Equal(t, f.MustCountNotes(), 1, "note count mismatch")
+		assert.Equal(t, f.MustCountBooks(), 1, "book count mismatch")
+	})
+
+	t.Run("import", func(t *testing.T) {
+		res, err := importNotes(ctx, notes, utils.NormalizeBody, false)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		// the removed note is not compared with the others
+		assert.Equal(t, res, importResult{added: 2, duplicates: 2}, "result mismatch")
+		assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+		assert.Equal(t, f.MustCountBooks(), 2, "book count mismatch")
+	})
+}
@@ -0,0 +1,220 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/gpg"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/orgmode"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var orgExample = `
+ * Import an Org file
+ dnote import org javascript.org
+
+ * Import the Org files in a directory
+ dnote import org ./org
+
+ * Import an Org file encrypted with gpg
+ dnote import org javascript.org.gpg
+
+ * Import the Org files only into the existing books
+ dnote import org ./org --auto-create-books=false
+
+ * Import the notes above the size limit as well
+ dnote import org ./org --force
+
+ * Show what would be imported without importing anything
+ dnote import org ./org --dry-run`
+
+func newOrgCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "org <file-or-dir>",
+		Short:   "Import notes from Org files",
+		Example: orgExample,
+		Args:    cobra.ExactArgs(1),
+		RunE:    newOrgRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&dryRunFlag, "dry-run", "", false, "print what would be imported without changing anything")
+
+	return cmd
+}
+
+// orgPaths returns the path if it is a file, or the Org files in it in the
+// lexical order if it is a directory. The encrypted Org files are included.
+func orgPaths(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	ret := []string{}
+	for _, pattern := range []string{"*.org", "*.org" + gpg.Ext} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return nil, errors.Wrap(err, "listing the Org files")
+		}
+
+		ret = append(ret, matches...)
+	}
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+// readOrgFile parses the Org file, decrypting it first if it has the
+// extension of an encrypted file. The book is the title of the file, or its
+// name if it does not have one, as it is named by the export.
+func readOrgFile(path string) (orgmode.File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return orgmode.File{}, errors.Wrapf(err, "reading %s", path)
+	}
+
+	if gpg.HasExt(path) {
+		var buf bytes.Buffer
+		if err := gpg.Decrypt(bytes.NewReader(b), &buf); err != nil {
+			return orgmode.File{}, errors.Wrapf(err, "decrypting %s", path)
+		}
+
+		b = buf.Bytes()
+	}
+
+	f, err := orgmode.Parse(string(b))
+	if err != nil {
+		return f, errors.Wrapf(err, "parsing %s", path)
+	}
+
+	if f.Book == "" {
+		name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), gpg.Ext), ".org")
+		if label, err := url.PathUnescape(name); err == nil {
+			f.Book = label
+		} else {
+			f.Book = name
+		}
+	}
+	if err := validate.BookName(f.Book); err != nil {
+		return f, errors.Wrapf(err, "invalid book name in %s", path)
+	}
+
+	return f, nil
+}
+
+// checkNoteSizes checks the sizes of the notes in the file against the limits
+// of the context. It warns about the notes above the soft limit, and returns
+// an error for the first note above the hard limit unless forced.
+func checkNoteSizes(ctx context.DnoteCtx, path string, f orgmode.File) error {
+	large := 0
+	for _, n := range f.Notes {
+		isLarge, err := checkNoteSize(ctx, n.Body)
+		if err != nil {
+			return err
+		}
+		if isLarge {
+			large++
+		}
+	}
+
+	if large > 0 {
+		log.Warn(i18n.T("import.large_notes", path, large, ctx.NoteSizeWarn))
+	}
+
+	return nil
+}
+
+// orgNotes returns the notes in the Org files to be imported
+func orgNotes(files []orgmode.File) []note {
+	ret := []note{}
+	for _, f := range files {
+		for _, n := range f.Notes {
+			ret = append(ret, note{
+				uuid:     n.UUID,
+				ref:      n.UUID,
+				book:     f.Book,
+				body:     n.Body,
+				addedOn:  n.AddedOn,
+				editedOn: n.EditedOn,
+			})
+		}
+	}
+
+	return ret
+}
+
+func newOrgRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		paths, err := orgPaths(args[0])
+		if err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			return errors.Errorf("no Org files in %s", args[0])
+		}
+
+		files := []orgmode.File{}
+		skippedFiles := 0
+		for _, path := range paths {
+			f, err := readOrgFile(path)
+			if err != nil {
+				return err
+			}
+
+			// a note too large for the server would fail every sync, so the
+			// file is left out and can be split and imported again
+			if err := checkNoteSizes(ctx, path, f); err != nil {
+				log.Warn(i18n.T("import.skip_too_large", path, err.Error()))
+				skippedFiles++
+				continue
+			}
+
+			files = append(files, f)
+		}
+
+		// the Org conversion is not lossless, so the notes are compared in the
+		// form they take once exported
+		if err := runImport(ctx, orgNotes(files), orgmode.Normalize); err != nil {
+			return err
+		}
+
+		if skippedFiles > 0 {
+			log.Warn(i18n.T("import.skipped_files", skippedFiles))
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,315 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/cmd/export"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the file"))
+	}
+}
+
+func TestImportOrg(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	js := f.Book("js")
+	f.Book("css")
+	f.Note(js, "Closures\n- capture the scope", testutils.With.UUID("1a6d4c2e-7b3f-4a5e-8c9d-0e1f2a3b4c5d"), testutils.With.AddedOn(1))
+	f.Note(js, "removed", testutils.With.UUID("3c8d6f2a-9e4b-4f7c-8d1e-2b3c4d5e6f7a"), testutils.With.Deleted())
+
+	orgDir := filepath.Join(dir, "org")
+	if err := os.Mkdir(orgDir, 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "creating the directory"))
+	}
+
+	// the note moves to css and a new note is added to a new book
+	writeFile(t, filepath.Join(orgDir, "css.org"), `#+TITLE: css
+
+* Closures
+:PROPERTIES:
+:UUID: 1a6d4c2e-7b3f-4a5e-8c9d-0e1f2a3b4c5d
+:ADDED: [1970-01-01 Thu 00:00]
+:END:
+- capture the scope
+
+* removed
+:PROPERTIES:
+:UUID: 3c8d6f2a-9e4b-4f7c-8d1e-2b3c4d5e6f7a
+:END:
+`)
+	writeFile(t, filepath.Join(orgDir, "c%2Fc++.org"), `* Pointers
+:PROPERTIES:
+:UUID: not-a-uuid
+:ADDED: [2021-03-15 Mon 09:30]
+:END:
+#+BEGIN_SRC c
+,* p = 1;
+#+END_SRC
+`)
+	writeFile(t, filepath.Join(orgDir, "notes.txt"), "* not imported\n")
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "org", orgDir)
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	assert.Equal(t, f.MustCountBooks(), 3, "book count mismatch")
+	assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+
+	cssUUID, err := database.GetBookUUID(ctx.DB, "css")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting css"))
+	}
+	n1 := f.MustGetNote("1a6d4c2e-7b3f-4a5e-8c9d-0e1f2a3b4c5d")
+	assert.Equal(t, n1.BookUUID, cssUUID, "n1 book mismatch")
+	assert.Equal(t, n1.Body, "Closures\n- capture the scope", "n1 body mismatch")
+	assert.Equal(t, n1.Dirty, true, "n1 dirty mismatch")
+
+	removed := f.MustGetNote("3c8d6f2a-9e4b-4f7c-8d1e-2b3c4d5e6f7a")
+	assert.Equal(t, removed.Deleted, true, "removed note was restored")
+
+	cppUUID, err := database.GetBookUUID(ctx.DB, "c/c++")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting c/c++"))
+	}
+	var body string
+	var usn int
+	var dirty bool
+	database.MustScan(t, "getting the new note", ctx.DB.QueryRow("SELECT body, usn, dirty FROM notes WHERE book_uuid = ?", cppUUID), &body, &usn, &dirty)
+	assert.Equal(t, body, "Pointers\n```c\n* p = 1;\n```", "new note body mismatch")
+	assert.Equal(t, usn, 0, "new note usn mismatch")
+	assert.Equal(t, dirty, true, "new note dirty mismatch")
+
+	t.Run("import again", func(t *testing.T) {
+		database.MustExec(t, "cleaning the notes", ctx.DB, "UPDATE notes SET dirty = ?", false)
+
+		result := testutils.RunCmd(t, ctx, NewCmd, "org", filepath.Join(orgDir, "css.org"))
+		assert.Equal(t, result.Err, nil, "error mismatch")
+
+		n1 := f.MustGetNote("1a6d4c2e-7b3f-4a5e-8c9d-0e1f2a3b4c5d")
+		assert.Equal(t, n1.Dirty, false, "n1 was changed")
+		assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		invalid := filepath.Join(dir, "invalid.org")
+		writeFile(t, invalid, "* new note\n:PROPERTIES:\n:UUID: x\n")
+
+		result := testutils.RunCmd(t, ctx, NewCmd, "org", invalid)
+		assert.NotEqual(t, result.Err, nil, "error mismatch")
+		assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+	})
+}
+
+func TestImportOrg_encrypted(t *testing.T) {
+	testutils.SetupGPG(t)
+
+	srcDir := t.TempDir()
+	src := context.InitTestCtx(t, context.Paths{Home: srcDir, Data: srcDir, Config: srcDir, Cache: srcDir}, nil)
+	defer context.TeardownTestCtx(t, src)
+
+	f := testutils.NewFixture(t, src.DB)
+	js := f.Book("js")
+	// the Org timestamps have the precision of a minute
+	addedOn := time.Date(2018, time.November, 1, 21, 45, 0, 0, time.UTC).UnixNano()
+	n1 := f.Note(js, "Closures\n- capture the scope", testutils.With.AddedOn(addedOn))
+
+	orgDir := filepath.Join(srcDir, "org")
+	result := testutils.RunCmd(t, src, export.NewCmd, "org", "--out", orgDir, "--encrypt", testutils.GPGRecipient)
+	assert.Equal(t, result.Err, nil, "export error mismatch")
+
+	b, err := ioutil.ReadFile(filepath.Join(orgDir, "js.org.gpg"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the encrypted file"))
+	}
+	assert.Equal(t, strings.Contains(string(b), "Closures"), false, "the file is not encrypted")
+
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "org", orgDir)
+	assert.Equal(t, result.Err, nil, "import error mismatch")
+
+	got := testutils.MustGetNote(t, ctx.DB, n1.UUID)
+	assert.Equal(t, got.Body, n1.Body, "body mismatch")
+	assert.Equal(t, got.AddedOn, addedOn, "added_on mismatch")
+
+	bookUUID, err := database.GetBookUUID(ctx.DB, "js")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the book"))
+	}
+	assert.Equal(t, got.BookUUID, bookUUID, "book mismatch")
+}
+
+func TestImportOrg_autoCreateBooks(t *testing.T) {
+	testCases := []struct {
+		name          string
+		policy        string
+		args          []string
+		expectedBooks int
+		expectedErr   string
+	}{
+		{
+			name:          "true",
+			policy:        consts.AutoCreateBookTrue,
+			expectedBooks: 3,
+		},
+		{
+			name:          "false",
+			policy:        consts.AutoCreateBookFalse,
+			expectedBooks: 1,
+			expectedErr:   "book 'golnag' does not exist. Close matches: golang. Use --auto-create-books=true to create it",
+		},
+		{
+			name:          "false overridden by the flag",
+			policy:        consts.AutoCreateBookFalse,
+			args:          []string{"--auto-create-books", "true"},
+			expectedBooks: 3,
+		},
+		{
+			name:          "the flag overriding true",
+			policy:        consts.AutoCreateBookTrue,
+			args:          []string{"--auto-create-books", "false"},
+			expectedBooks: 1,
+			expectedErr:   "book 'golnag' does not exist",
+		},
+		{
+			name:          "prompt in the non-interactive mode",
+			policy:        consts.AutoCreateBookPrompt,
+			expectedBooks: 1,
+			expectedErr:   "cannot confirm creating a new book in the non-interactive mode",
+		},
+		{
+			name:          "invalid flag",
+			policy:        consts.AutoCreateBookTrue,
+			args:          []string{"--auto-create-books", "ask"},
+			expectedBooks: 1,
+			expectedErr:   "unknown auto-create policy 'ask'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			ctx.AutoCreateBook = tc.policy
+			ui.SetInteractive(false)
+			defer ui.SetInteractive(ui.IsTerminal())
+
+			f := testutils.NewFixture(t, ctx.DB)
+			f.Book("golang")
+
+			orgDir := filepath.Join(dir, "org")
+			if err := os.Mkdir(orgDir, 0755); err != nil {
+				t.Fatal(errors.Wrap(err, "creating the directory"))
+			}
+			writeFile(t, filepath.Join(orgDir, "golnag.org"), "* goroutines\n")
+			writeFile(t, filepath.Join(orgDir, "rust.org"), "* ownership\n")
+
+			result := testutils.RunCmd(t, ctx, NewCmd, append([]string{"org", orgDir}, tc.args...)...)
+
+			if tc.expectedErr == "" {
+				assert.Equal(t, result.Err, nil, "error mismatch")
+				assert.Equal(t, f.MustCountNotes(), 2, "note count mismatch")
+			} else {
+				if result.Err == nil || !strings.Contains(result.Err.Error(), tc.expectedErr) {
+					t.Errorf("expected an error containing %q but got %v", tc.expectedErr, result.Err)
+				}
+				assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch")
+			}
+			assert.Equal(t, f.MustCountBooks(), tc.expectedBooks, "book count mismatch")
+		})
+	}
+}
+
+func TestImportOrg_noteSize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "skipping the file",
+			expected: []string{"goroutines"},
+		},
+		{
+			name:     "forced",
+			args:     []string{"--force"},
+			expected: []string{"goroutines", "ownership " + strings.Repeat("x", 20)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			ctx.NoteSizeMax = 20
+
+			f := testutils.NewFixture(t, ctx.DB)
+
+			orgDir := filepath.Join(dir, "org")
+			if err := os.Mkdir(orgDir, 0755); err != nil {
+				t.Fatal(errors.Wrap(err, "creating the directory"))
+			}
+			writeFile(t, filepath.Join(orgDir, "golang.org"), "* goroutines\n")
+			writeFile(t, filepath.Join(orgDir, "rust.org"), "* ownership "+strings.Repeat("x", 20)+"\n")
+
+			result := testutils.RunCmd(t, ctx, NewCmd, append([]string{"org", orgDir}, tc.args...)...)
+			assert.Equal(t, result.Err, nil, "error mismatch")
+
+			var bodies []string
+			rows, err := ctx.DB.Query("SELECT body FROM notes ORDER BY body")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "querying the notes"))
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var body string
+				if err := rows.Scan(&body); err != nil {
+					t.Fatal(errors.Wrap(err, "scanning a note"))
+				}
+				bodies = append(bodies, body)
+			}
+
+			assert.DeepEqual(t, bodies, tc.expected, "bodies mismatch")
+			assert.Equal(t, f.MustCountBooks(), len(tc.expected), "book count mismatch")
+		})
+	}
+}
@@ -0,0 +1,198 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var simplenoteExample = `
+ * Import a Simplenote export into books named after the first tag of each note
+ dnote import simplenote notes.zip
+
+ * Import a Simplenote export into a single book
+ dnote import simplenote notes.zip --book simplenote
+
+ * Import the notes in the trash as well, as removed notes
+ dnote import simplenote notes.zip --include-trashed`
+
+// simplenoteBook is the book of the notes without a tag
+const simplenoteBook = "simplenote"
+
+// simplenoteNamespace derives the uuids of the notes from their ids in
+// Simplenote, so that importing the same export again finds them
+var simplenoteNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://simplenote.com"))
+
+var bookFlag string
+var includeTrashedFlag bool
+
+func newSimplenoteCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "simplenote <file.zip>",
+		Short:   "Import notes from a Simplenote export",
+		Example: simplenoteExample,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: preRunTagged,
+		RunE:    newSimplenoteRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&bookFlag, "book", "", "", "import all notes into the book instead of the books named after their first tag")
+	f.BoolVarP(&includeTrashedFlag, "include-trashed", "", false, "import the notes in the trash as removed notes")
+	f.BoolVarP(&dryRunFlag, "dry-run", "", false, "print what would be imported without changing anything")
+
+	return cmd
+}
+
+// preRunTagged validates the flags of the importers of the exports whose
+// notes are tagged
+func preRunTagged(cmd *cobra.Command, args []string) error {
+	if bookFlag == "" {
+		return nil
+	}
+	if err := validate.BookName(bookFlag); err != nil {
+		return errors.Wrap(err, "invalid --book")
+	}
+
+	return nil
+}
+
+// simplenoteNote is a note in a Simplenote export
+type simplenoteNote struct {
+	ID           string    `json:"id"`
+	Content      string    `json:"content"`
+	CreationDate timestamp `json:"creationDate"`
+	LastModified timestamp `json:"lastModified"`
+	Tags         []string  `json:"tags"`
+}
+
+// simplenoteExport is the notes.json of a Simplenote export
+type simplenoteExport struct {
+	ActiveNotes  []simplenoteNote `json:"activeNotes"`
+	TrashedNotes []simplenoteNote `json:"trashedNotes"`
+}
+
+// readSimplenoteJSON returns the notes.json in the Simplenote export zip, or
+// the file itself if it is the notes.json taken out of one
+func readSimplenoteJSON(p string) ([]byte, error) {
+	if strings.EqualFold(filepath.Ext(p), ".json") {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", p)
+		}
+
+		return b, nil
+	}
+
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", p)
+	}
+	defer zr.Close()
+
+	// the notes.json is in the source directory, and the text files next to
+	// it lack the tags and the times
+	for _, f := range zr.File {
+		if path.Base(f.Name) != "notes.json" {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening %s", f.Name)
+		}
+		defer r.Close()
+
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", f.Name)
+		}
+
+		return b, nil
+	}
+
+	return nil, errors.Errorf("no notes.json in %s. Is it a Simplenote export?", p)
+}
+
+// simplenoteNotes returns the notes in the Simplenote export to be imported.
+// The empty notes are left out.
+func simplenoteNotes(e simplenoteExport, book string, includeTrashed bool) []note {
+	ret := []note{}
+
+	add := func(notes []simplenoteNote, deleted bool) {
+		for _, n := range notes {
+			if strings.TrimSpace(n.Content) == "" {
+				continue
+			}
+
+			label := book
+			if label == "" {
+				label = tagBook(n.Tags, simplenoteBook)
+			}
+
+			ret = append(ret, note{
+				uuid:     uuid.NewSHA1(simplenoteNamespace, []byte(n.ID)).String(),
+				ref:      n.ID,
+				book:     label,
+				body:     n.Content,
+				addedOn:  int64(n.CreationDate),
+				editedOn: editedOn(n.CreationDate, n.LastModified),
+				deleted:  deleted,
+			})
+		}
+	}
+
+	add(e.ActiveNotes, false)
+	if includeTrashed {
+		add(e.TrashedNotes, true)
+	}
+
+	return ret
+}
+
+func newSimplenoteRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		b, err := readSimplenoteJSON(args[0])
+		if err != nil {
+			return err
+		}
+
+		var e simplenoteExport
+		if err := json.Unmarshal(b, &e); err != nil {
+			return errors.Wrapf(err, "parsing the notes in %s", args[0])
+		}
+
+		notes := filterNoteSizes(ctx, args[0], simplenoteNotes(e, bookFlag, includeTrashedFlag))
+
+		return runImport(ctx, notes, utils.NormalizeBody)
+	}
+}
@@ -0,0 +1,177 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// zipSimplenote returns the path of a Simplenote export of the notes.json in
+// the testdata, with a text file per note as the export has
+func zipSimplenote(t *testing.T) string {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "simplenote", "notes.json"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the fixture"))
+	}
+
+	p := filepath.Join(t.TempDir(), "notes.zip")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating the zip"))
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	files := []struct {
+		name string
+		body []byte
+	}{
+		{"Goroutines.txt", []byte("Goroutines\n\nLightweight threads managed by the runtime")},
+		{"source/notes.json", b},
+	}
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "adding %s", file.name))
+		}
+		if _, err := w.Write(file.body); err != nil {
+			t.Fatal(errors.Wrapf(err, "writing %s", file.name))
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the zip"))
+	}
+
+	return p
+}
+
+// simplenoteUUID returns the uuid of the note imported from the Simplenote
+// note with the id
+func simplenoteUUID(id string) string {
+	return uuid.NewSHA1(simplenoteNamespace, []byte(id)).String()
+}
+
+func TestImportSimplenote(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	js := f.Book("js")
+	f.Note(js, "Closures\n- capture the scope")
+
+	path := zipSimplenote(t)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "simplenote", path)
+	assert.Equal(t, result.Err, nil, "error mismatch")
+	assert.Equal(t, strings.Contains(string(result.Stdout), "added 2, updated 0, unchanged 0, skipped 0 notes"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+	assert.Equal(t, strings.Contains(string(result.Stdout), "left out 1 new notes"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+
+	// the empty note and the note already in js are left out
+	assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+	assert.Equal(t, f.MustCountBooks(), 3, "book count mismatch")
+
+	// the numeric tag is not a valid book name
+	golang, err := database.GetBookUUID(ctx.DB, "golang")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting golang"))
+	}
+	n1 := f.MustGetNote(simplenoteUUID("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	assert.Equal(t, n1.BookUUID, golang, "n1 book mismatch")
+	assert.Equal(t, n1.Body, "Goroutines\n\nLightweight threads managed by the runtime", "n1 body mismatch")
+	assert.Equal(t, n1.AddedOn, time.Date(2021, time.March, 15, 9, 30, 0, 0, time.UTC).UnixNano(), "n1 added_on mismatch")
+	assert.Equal(t, n1.EditedOn, time.Date(2021, time.March, 16, 10, 0, 0, 0, time.UTC).UnixNano(), "n1 edited_on mismatch")
+	assert.Equal(t, n1.Dirty, true, "n1 dirty mismatch")
+	assert.Equal(t, n1.USN, 0, "n1 usn mismatch")
+
+	// the older exports have the times in seconds
+	untagged, err := database.GetBookUUID(ctx.DB, simplenoteBook)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the book of the untagged notes"))
+	}
+	n2 := f.MustGetNote(simplenoteUUID("b2c3d4e5f60718293a4b5c6d7e8f90a1"))
+	assert.Equal(t, n2.BookUUID, untagged, "n2 book mismatch")
+	assert.Equal(t, n2.AddedOn, int64(1615800600)*int64(time.Second), "n2 added_on mismatch")
+	assert.Equal(t, n2.EditedOn, int64(0), "n2 edited_on mismatch")
+
+	t.Run("import again", func(t *testing.T) {
+		result := testutils.RunCmd(t, ctx, NewCmd, "simplenote", path)
+		assert.Equal(t, result.Err, nil, "error mismatch")
+		assert.Equal(t, strings.Contains(string(result.Stdout), "added 0, updated 0, unchanged 2, skipped 0 notes"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+		assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+	})
+
+	t.Run("include trashed", func(t *testing.T) {
+		result := testutils.RunCmd(t, ctx, NewCmd, "simplenote", path, "--include-trashed")
+		assert.Equal(t, result.Err, nil, "error mismatch")
+
+		ideas, err := database.GetBookUUID(ctx.DB, "ideas")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting ideas"))
+		}
+		n := f.MustGetNote(simplenoteUUID("e5f60718293a4b5c6d7e8f90a1b2c3d4"))
+		assert.Equal(t, n.BookUUID, ideas, "book mismatch")
+		assert.Equal(t, n.Deleted, true, "deleted mismatch")
+		assert.Equal(t, n.Dirty, true, "dirty mismatch")
+
+		// the removed note is not imported again
+		result = testutils.RunCmd(t, ctx, NewCmd, "simplenote", path, "--include-trashed")
+		assert.Equal(t, result.Err, nil, "error mismatch")
+		assert.Equal(t, f.MustCountNotes(), 4, "note count mismatch")
+	})
+}
+
+func TestImportSimplenote_book(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+
+	// the notes.json taken out of the export is accepted as well
+	path := filepath.Join("testdata", "simplenote", "notes.json")
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "simplenote", path, "--book", "simplenote-import", "--dry-run")
+	assert.Equal(t, result.Err, nil, "dry run error mismatch")
+	assert.Equal(t, strings.Contains(string(result.Stdout), "would add 3, update 0, leave unchanged 0, and skip 0 notes"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+	assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch after the dry run")
+	assert.Equal(t, f.MustCountBooks(), 0, "book count mismatch after the dry run")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "simplenote", path, "--book", "simplenote-import")
+	assert.Equal(t, result.Err, nil, "error mismatch")
+	assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+	assert.Equal(t, f.MustCountBooks(), 1, "book count mismatch")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "simplenote", path, "--book", "two words")
+	assert.NotEqual(t, result.Err, nil, "invalid book error mismatch")
+}
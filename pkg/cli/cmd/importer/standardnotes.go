@@ -0,0 +1,220 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var standardNotesExample = `
+ * Import a Standard Notes backup into books named after the first tag of each note
+ dnote import standardnotes backup.txt
+
+ * Import a Standard Notes backup into a single book
+ dnote import standardnotes backup.txt --book standardnotes
+
+ * Import the notes in the trash as well, as removed notes
+ dnote import standardnotes backup.txt --include-trashed`
+
+// standardNotesBook is the book of the notes without a tag
+const standardNotesBook = "standardnotes"
+
+// The content types of the items in a Standard Notes backup that are imported
+const (
+	standardNotesNoteType = "Note"
+	standardNotesTagType  = "Tag"
+)
+
+func newStandardNotesCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "standardnotes <file>",
+		Short:   "Import notes from a decrypted Standard Notes backup",
+		Example: standardNotesExample,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: preRunTagged,
+		RunE:    newStandardNotesRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&bookFlag, "book", "", "", "import all notes into the book instead of the books named after their first tag")
+	f.BoolVarP(&includeTrashedFlag, "include-trashed", "", false, "import the notes in the trash as removed notes")
+	f.BoolVarP(&dryRunFlag, "dry-run", "", false, "print what would be imported without changing anything")
+
+	return cmd
+}
+
+// standardNotesItem is an item in a Standard Notes backup. The content is
+// an object in a decrypted backup, and a string in an encrypted one.
+type standardNotesItem struct {
+	UUID        string          `json:"uuid"`
+	ContentType string          `json:"content_type"`
+	Content     json.RawMessage `json:"content"`
+	CreatedAt   timestamp       `json:"created_at"`
+	UpdatedAt   timestamp       `json:"updated_at"`
+	Deleted     bool            `json:"deleted"`
+}
+
+// standardNotesNote is the content of a note
+type standardNotesNote struct {
+	Title   string `json:"title"`
+	Text    string `json:"text"`
+	Trashed bool   `json:"trashed"`
+	AppData struct {
+		SN struct {
+			// ClientUpdatedAt is when the note was last edited, whereas the
+			// updated_at of the item also changes when the note is synced
+			ClientUpdatedAt timestamp `json:"client_updated_at"`
+		} `json:"org.standardnotes.sn"`
+	} `json:"appData"`
+}
+
+// standardNotesTag is the content of a tag, which refers to its notes
+type standardNotesTag struct {
+	Title      string `json:"title"`
+	References []struct {
+		UUID        string `json:"uuid"`
+		ContentType string `json:"content_type"`
+	} `json:"references"`
+}
+
+// standardNotesBackup is a Standard Notes backup
+type standardNotesBackup struct {
+	Items []standardNotesItem `json:"items"`
+}
+
+// isEncrypted tells if the content of an item is encrypted
+func (i standardNotesItem) isEncrypted() bool {
+	return bytes.HasPrefix(bytes.TrimSpace(i.Content), []byte(`"`))
+}
+
+// standardNotesBody returns the body of a note, whose title is on its first
+// line
+func standardNotesBody(c standardNotesNote) string {
+	if c.Title == "" {
+		return c.Text
+	}
+	if c.Text == "" {
+		return c.Title
+	}
+
+	return c.Title + "\n\n" + c.Text
+}
+
+// standardNotesNotes returns the notes in the Standard Notes backup to be
+// imported. The empty notes, the items that are not notes and the removed
+// items are left out.
+func standardNotesNotes(b standardNotesBackup, book string, includeTrashed bool) ([]note, error) {
+	// the tags of a note are in the order of the tags in the backup
+	tags := map[string][]string{}
+	for _, item := range b.Items {
+		if item.ContentType != standardNotesTagType || item.Deleted {
+			continue
+		}
+		if item.isEncrypted() {
+			return nil, errors.New("the backup is encrypted. Export a decrypted backup from Standard Notes")
+		}
+
+		var t standardNotesTag
+		if err := json.Unmarshal(item.Content, &t); err != nil {
+			return nil, errors.Wrapf(err, "parsing the tag %s", item.UUID)
+		}
+
+		for _, ref := range t.References {
+			if ref.ContentType == standardNotesNoteType {
+				tags[ref.UUID] = append(tags[ref.UUID], t.Title)
+			}
+		}
+	}
+
+	ret := []note{}
+	for _, item := range b.Items {
+		if item.ContentType != standardNotesNoteType || item.Deleted {
+			continue
+		}
+		if item.isEncrypted() {
+			return nil, errors.New("the backup is encrypted. Export a decrypted backup from Standard Notes")
+		}
+
+		var c standardNotesNote
+		if err := json.Unmarshal(item.Content, &c); err != nil {
+			return nil, errors.Wrapf(err, "parsing the note %s", item.UUID)
+		}
+		if c.Trashed && !includeTrashed {
+			continue
+		}
+
+		body := standardNotesBody(c)
+		if strings.TrimSpace(body) == "" {
+			continue
+		}
+
+		label := book
+		if label == "" {
+			label = tagBook(tags[item.UUID], standardNotesBook)
+		}
+
+		modified := c.AppData.SN.ClientUpdatedAt
+		if modified == 0 {
+			modified = item.UpdatedAt
+		}
+
+		ret = append(ret, note{
+			uuid:     item.UUID,
+			ref:      item.UUID,
+			book:     label,
+			body:     body,
+			addedOn:  int64(item.CreatedAt),
+			editedOn: editedOn(item.CreatedAt, modified),
+			deleted:  c.Trashed,
+		})
+	}
+
+	return ret, nil
+}
+
+func newStandardNotesRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		b, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", args[0])
+		}
+
+		var backup standardNotesBackup
+		if err := json.Unmarshal(b, &backup); err != nil {
+			return errors.Wrapf(err, "parsing %s", args[0])
+		}
+
+		notes, err := standardNotesNotes(backup, bookFlag, includeTrashedFlag)
+		if err != nil {
+			return err
+		}
+		notes = filterNoteSizes(ctx, args[0], notes)
+
+		return runImport(ctx, notes, utils.NormalizeBody)
+	}
+}
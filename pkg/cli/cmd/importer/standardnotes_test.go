@@ -0,0 +1,96 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestImportStandardNotes(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	path := filepath.Join("testdata", "standardnotes", "backup.txt")
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "standardnotes", path)
+	assert.Equal(t, result.Err, nil, "error mismatch")
+	assert.Equal(t, strings.Contains(string(result.Stdout), "added 2, updated 0, unchanged 0, skipped 0 notes"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+
+	// the removed, the empty and the trashed notes are left out
+	assert.Equal(t, f.MustCountNotes(), 2, "note count mismatch")
+	assert.Equal(t, f.MustCountBooks(), 2, "book count mismatch")
+
+	// the spaces in the first tag become dashes
+	work, err := database.GetBookUUID(ctx.DB, "Work-Notes")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting Work-Notes"))
+	}
+	n1 := f.MustGetNote("5b1c3a2e-8d4f-4e6a-9b7c-1d2e3f4a5b6c")
+	assert.Equal(t, n1.BookUUID, work, "n1 book mismatch")
+	assert.Equal(t, n1.Body, "Goroutines\n\nLightweight threads managed by the runtime", "n1 body mismatch")
+	assert.Equal(t, n1.AddedOn, time.Date(2021, time.March, 15, 9, 30, 0, 0, time.UTC).UnixNano(), "n1 added_on mismatch")
+	// the time of the last edit is kept rather than that of the last sync
+	assert.Equal(t, n1.EditedOn, time.Date(2021, time.March, 16, 10, 0, 0, 0, time.UTC).UnixNano(), "n1 edited_on mismatch")
+	assert.Equal(t, n1.Dirty, true, "n1 dirty mismatch")
+
+	untagged, err := database.GetBookUUID(ctx.DB, standardNotesBook)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the book of the untagged notes"))
+	}
+	n2 := f.MustGetNote("6c2d4b3f-9e5a-4f7b-8c8d-2e3f4a5b6c7d")
+	assert.Equal(t, n2.BookUUID, untagged, "n2 book mismatch")
+	assert.Equal(t, n2.Body, "Shopping list\n- milk", "n2 body mismatch")
+	assert.Equal(t, n2.EditedOn, int64(0), "n2 edited_on mismatch")
+
+	t.Run("include trashed", func(t *testing.T) {
+		result := testutils.RunCmd(t, ctx, NewCmd, "standardnotes", path, "--include-trashed")
+		assert.Equal(t, result.Err, nil, "error mismatch")
+		assert.Equal(t, strings.Contains(string(result.Stdout), "added 1, updated 0, unchanged 2, skipped 0 notes"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+
+		ideas, err := database.GetBookUUID(ctx.DB, "ideas")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting ideas"))
+		}
+		n := f.MustGetNote("7d3e5c4a-0f6b-4a8c-9d9e-3f4a5b6c7d8e")
+		assert.Equal(t, n.BookUUID, ideas, "book mismatch")
+		assert.Equal(t, n.Body, "Old idea", "body mismatch")
+		assert.Equal(t, n.Deleted, true, "deleted mismatch")
+		assert.Equal(t, n.Dirty, true, "dirty mismatch")
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		result := testutils.RunCmd(t, ctx, NewCmd, "standardnotes", filepath.Join("testdata", "standardnotes", "encrypted.txt"))
+		if result.Err == nil || !strings.Contains(result.Err.Error(), "the backup is encrypted") {
+			t.Errorf("expected an error for an encrypted backup but got %v", result.Err)
+		}
+		assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+	})
+}
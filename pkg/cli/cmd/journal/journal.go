@@ -0,0 +1,168 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package journal
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Show the journal of the changes
+ dnote log
+
+ * Show the changes to a note or a book
+ dnote log --uuid 3a7c1d2e-0b4f-4c8a-9e2d-5f6a7b8c9d0e
+
+ * Show the changes to a note or a book by a prefix of its uuid
+ dnote log --uuid 3a7c1d
+
+ * Show the changes since a date
+ dnote log --since 2022-01-31`
+
+var uuidFlag string
+var sinceFlag string
+
+// sinceFormat is the format of the --since date
+const sinceFormat = "2006-01-02"
+
+// uuidLength is the length of a full uuid
+const uuidLength = 36
+
+// timeFormat is the format of the time of an action if none is configured
+const timeFormat = "2006-01-02 15:04:05"
+
+// NewCmd returns a new log command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "log",
+		Short:   "Show the journal of the changes to the notes and the books",
+		Example: example,
+		Args:    cobra.NoArgs,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&uuidFlag, "uuid", "", "", "show only the changes to the note or the book with the uuid or the uuid prefix")
+	f.StringVarP(&sinceFlag, "since", "", "", "show only the changes on or after the date in YYYY-MM-DD, in the local time")
+
+	return cmd
+}
+
+// parseSince parses the --since date into a timestamp in nanoseconds
+func parseSince(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	t, err := time.ParseInLocation(sinceFormat, s, time.Local)
+	if err != nil {
+		return 0, errors.Errorf("invalid date '%s'. Use YYYY-MM-DD", s)
+	}
+
+	return t.UnixNano(), nil
+}
+
+// resolveUUID returns the uuid of the note or the book that starts with the
+// given prefix, including the removed ones. The prefix is returned as it is if
+// nothing matches, because the journal keeps the changes to the notes and the
+// books that are expunged.
+func resolveUUID(db *database.DB, prefix string) (string, error) {
+	if len(prefix) == uuidLength || !database.IsUUIDPrefix(prefix) {
+		return prefix, nil
+	}
+
+	uuid, err := database.ResolveNoteUUID(db, prefix, true)
+	if err == nil {
+		return uuid, nil
+	} else if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	uuid, err = database.ResolveBookUUID(db, prefix, true)
+	if err == nil {
+		return uuid, nil
+	} else if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	return prefix, nil
+}
+
+// writeActions writes the actions, one per line
+func writeActions(w io.Writer, actions []database.Action, format string) error {
+	if format == "" {
+		format = timeFormat
+	}
+
+	for _, a := range actions {
+		line := fmt.Sprintf("%s  %s  %s", time.Unix(0, a.Timestamp).Format(format), a.Actor, a.Operation)
+		if a.NoteUUID != "" {
+			line += fmt.Sprintf("  note:%s", a.NoteUUID)
+		}
+		if a.BookUUID != "" {
+			line += fmt.Sprintf("  book:%s", a.BookUUID)
+		}
+		if a.Summary != "" {
+			line += fmt.Sprintf("  %s", a.Summary)
+		}
+		if a.Device != "" {
+			line += fmt.Sprintf("  device:%s", a.Device)
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return errors.Wrap(err, "writing an action")
+		}
+	}
+
+	return nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		since, err := parseSince(sinceFlag)
+		if err != nil {
+			return err
+		}
+
+		uuid, err := resolveUUID(ctx.DB, uuidFlag)
+		if err != nil {
+			return errors.Wrap(err, "resolving the uuid")
+		}
+
+		actions, err := database.GetActions(ctx.DB, database.ActionFilter{
+			UUID:  uuid,
+			Since: since,
+		})
+		if err != nil {
+			return errors.Wrap(err, "getting the actions")
+		}
+
+		return writeActions(os.Stdout, actions, ctx.TimeFormat)
+	}
+}
@@ -0,0 +1,65 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package journal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func TestParseSince(t *testing.T) {
+	got, err := parseSince("2022-01-31")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "parsing"))
+	}
+	assert.Equal(t, got, time.Date(2022, time.January, 31, 0, 0, 0, 0, time.Local).UnixNano(), "timestamp mismatch")
+
+	got, err = parseSince("")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "parsing an empty date"))
+	}
+	assert.Equal(t, got, int64(0), "empty timestamp mismatch")
+
+	if _, err := parseSince("31/01/2022"); err == nil {
+		t.Error("expected an error for an invalid date")
+	}
+}
+
+func TestWriteActions(t *testing.T) {
+	ts := time.Date(2022, time.January, 31, 10, 20, 30, 0, time.Local).UnixNano()
+	actions := []database.Action{
+		{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpAddNote, NoteUUID: "n1-uuid", BookUUID: "b1-uuid", Summary: "body: 0 -> 7 bytes"},
+		{Timestamp: ts, Actor: database.ActorSyncClean, Operation: database.OpRemoveBook, BookUUID: "b1-uuid"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeActions(&buf, actions, ""); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	expected := `2022-01-31 10:20:30  cli-command  add-note  note:n1-uuid  book:b1-uuid  body: 0 -> 7 bytes
+2022-01-31 10:20:30  sync-clean  remove-book  book:b1-uuid
+`
+	assert.Equal(t, buf.String(), expected, "output mismatch")
+}
@@ -0,0 +1,100 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package last
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/output"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * See the note added last
+ dnote last
+
+ * See the note added last to a book
+ dnote last javascript`
+
+// NewCmd returns a new last command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "last <book name?>",
+		Short:   "See the note added last",
+		Example: example,
+		Args:    cobra.MaximumNArgs(1),
+		RunE:    newRun(ctx),
+	}
+
+	return cmd
+}
+
+// Find returns the note added last to the book with the given name, or
+// to any book if the name is empty
+func Find(ctx context.DnoteCtx, bookName string) (dnote.Note, error) {
+	if bookName != "" {
+		var err error
+		bookName, err = ui.ResolveBook(ctx, bookName)
+		if err != nil {
+			return dnote.Note{}, errors.Wrap(err, "resolving the book")
+		}
+	}
+
+	note, err := dnote.NewStore(ctx).LastNote(bookName)
+	if err == dnote.ErrBookNotFound {
+		return dnote.Note{}, errors.Errorf("book '%s' not found", bookName)
+	} else if err == dnote.ErrNoteNotFound {
+		if bookName != "" {
+			return dnote.Note{}, errors.Errorf("no notes in book '%s'", bookName)
+		}
+
+		return dnote.Note{}, errors.New("no notes")
+	} else if err != nil {
+		return dnote.Note{}, errors.Wrap(err, "getting the last note")
+	}
+
+	return note, nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		var bookName string
+		if len(args) == 1 {
+			bookName = args[0]
+		}
+
+		note, err := Find(ctx, bookName)
+		if err != nil {
+			return err
+		}
+
+		info, err := database.GetNoteInfoByUUID(ctx.DB, note.UUID)
+		if err != nil {
+			return errors.Wrap(err, "getting the note")
+		}
+
+		output.NoteInfo(ctx, info)
+
+		return nil
+	}
+}
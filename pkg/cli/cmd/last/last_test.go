@@ -0,0 +1,68 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package last
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+)
+
+func TestLast(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	js := f.Book("js")
+	css := f.Book("css")
+	f.Book("go")
+	n1 := f.Note(js, "n1 body", testutils.With.AddedOn(1))
+	n2 := f.Note(css, "n2 body", testutils.With.AddedOn(3))
+	f.Note(js, "n3 body", testutils.With.AddedOn(4), testutils.With.Deleted())
+
+	testCases := []struct {
+		name         string
+		args         []string
+		expectedUUID string
+		expectedBody string
+	}{
+		{name: "all books", args: []string{}, expectedUUID: n2.UUID, expectedBody: "n2 body"},
+		{name: "book", args: []string{"js"}, expectedUUID: n1.UUID, expectedBody: "n1 body"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+			assert.Equal(t, result.Err, nil, "error mismatch")
+
+			out := string(result.Stdout)
+			assert.Equal(t, strings.Contains(out, "note uuid: "+tc.expectedUUID), true, "uuid mismatch")
+			assert.Equal(t, strings.Contains(out, tc.expectedBody), true, "body mismatch")
+		})
+	}
+
+	t.Run("empty book", func(t *testing.T) {
+		result := testutils.RunCmd(t, ctx, NewCmd, "go")
+		assert.NotEqual(t, result.Err, nil, "expected an error")
+	})
+}
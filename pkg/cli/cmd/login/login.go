@@ -24,9 +24,11 @@ import (
 	"strconv"
 
 	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/config"
 	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/ui"
@@ -55,27 +57,41 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 	return cmd
 }
 
-// Do dervies credentials on the client side and requests a session token from the server
+// Do dervies credentials on the client side and requests a session token from
+// the server. The local-only mode is turned off once the user is logged in.
 func Do(ctx context.DnoteCtx, email, password string) error {
 	signinResp, err := client.Signin(ctx, email, password)
 	if err != nil {
 		return errors.Wrap(err, "requesting session")
 	}
 
-	db := ctx.DB
-	tx, err := db.Begin()
-	if err != nil {
-		return errors.Wrap(err, "beginning a transaction")
+	if err := ctx.Credentials.Set(signinResp.Key); err != nil {
+		return errors.Wrap(err, "saving session key")
+	}
+	if err := database.UpsertSystem(ctx.DB, consts.SystemSessionKeyExpiry, strconv.FormatInt(signinResp.ExpiresAt, 10)); err != nil {
+		return errors.Wrap(err, "saving session key expiry")
 	}
 
-	if err := database.UpsertSystem(tx, consts.SystemSessionKey, signinResp.Key); err != nil {
-		return errors.Wrap(err, "saving session key")
+	if ctx.LocalOnly {
+		if err := disableLocalOnly(ctx); err != nil {
+			return errors.Wrap(err, "turning off the local-only mode")
+		}
 	}
-	if err := database.UpsertSystem(tx, consts.SystemSessionKeyExpiry, strconv.FormatInt(signinResp.ExpiresAt, 10)); err != nil {
-		return errors.Wrap(err, "saving session key")
+
+	return nil
+}
+
+// disableLocalOnly records in the config file that the notes are synced
+func disableLocalOnly(ctx context.DnoteCtx) error {
+	cf, err := config.Read(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading the config")
 	}
 
-	tx.Commit()
+	cf.LocalOnly = false
+	if err := config.Write(ctx, cf); err != nil {
+		return errors.Wrap(err, "writing the config")
+	}
 
 	return nil
 }
@@ -86,7 +102,7 @@ func getUsername() (string, error) {
 	}
 
 	var email string
-	if err := ui.PromptInput("email", &email); err != nil {
+	if err := ui.PromptInput(i18n.T("login.email"), "--username", &email); err != nil {
 		return "", errors.Wrap(err, "getting email input")
 	}
 	if email == "" {
@@ -102,7 +118,7 @@ func getPassword() (string, error) {
 	}
 
 	var password string
-	if err := ui.PromptPassword("password", &password); err != nil {
+	if err := ui.PromptPassword(i18n.T("login.password"), "--password", &password); err != nil {
 		return "", errors.Wrap(err, "getting password input")
 	}
 	if password == "" {
@@ -126,8 +142,8 @@ func getBaseURL(rawURL string) (string, error) {
 }
 
 func getServerDisplayURL(ctx context.DnoteCtx) string {
-	if ctx.APIEndpoint == "https://api.getdnote.com" {
-		return "https://www.getdnote.com"
+	if ctx.APIEndpoint == consts.HostedAPIEndpoint {
+		return consts.HostedWebEndpoint
 	}
 
 	baseURL, err := getBaseURL(ctx.APIEndpoint)
@@ -139,7 +155,7 @@ func getServerDisplayURL(ctx context.DnoteCtx) string {
 }
 
 func getGreeting(ctx context.DnoteCtx) string {
-	base := "Welcome to Dnote Pro"
+	base := i18n.T("login.welcome")
 
 	serverURL := getServerDisplayURL(ctx)
 	if serverURL == "" {
@@ -171,13 +187,13 @@ func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 
 		err = Do(ctx, email, password)
 		if errors.Cause(err) == client.ErrInvalidLogin {
-			log.Error("wrong login\n")
+			log.Error(i18n.T("login.wrong"))
 			return nil
 		} else if err != nil {
 			return errors.Wrap(err, "logging in")
 		}
 
-		log.Success("logged in\n")
+		log.Success(i18n.T("login.logged_in"))
 
 		return nil
 	}
@@ -19,12 +19,12 @@
 package logout
 
 import (
-	"database/sql"
-
 	"github.com/dnote/dnote/pkg/cli/client"
 	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/credentials"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/pkg/errors"
@@ -51,15 +51,8 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 
 // Do performs logout
 func Do(ctx context.DnoteCtx) error {
-	db := ctx.DB
-	tx, err := db.Begin()
-	if err != nil {
-		return errors.Wrap(err, "beginning a transaction")
-	}
-
-	var key string
-	err = database.GetSystem(tx, consts.SystemSessionKey, &key)
-	if errors.Cause(err) == sql.ErrNoRows {
+	key, err := ctx.Credentials.Get()
+	if err == credentials.ErrNotFound {
 		return ErrNotLoggedIn
 	} else if err != nil {
 		return errors.Wrap(err, "getting session key")
@@ -70,15 +63,13 @@ func Do(ctx context.DnoteCtx) error {
 		return errors.Wrap(err, "requesting logout")
 	}
 
-	if err := database.DeleteSystem(tx, consts.SystemSessionKey); err != nil {
+	if err := ctx.Credentials.Delete(); err != nil {
 		return errors.Wrap(err, "deleting session key")
 	}
-	if err := database.DeleteSystem(tx, consts.SystemSessionKeyExpiry); err != nil {
+	if err := database.DeleteSystem(ctx.DB, consts.SystemSessionKeyExpiry); err != nil {
 		return errors.Wrap(err, "deleting session key expiry")
 	}
 
-	tx.Commit()
-
 	return nil
 }
 
@@ -86,13 +77,13 @@ func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
 		err := Do(ctx)
 		if err == ErrNotLoggedIn {
-			log.Error("not logged in\n")
+			log.Error(i18n.T("logout.not_logged_in"))
 			return nil
 		} else if err != nil {
 			return errors.Wrap(err, "logging out")
 		}
 
-		log.Success("logged out\n")
+		log.Success(i18n.T("logout.logged_out"))
 
 		return nil
 	}
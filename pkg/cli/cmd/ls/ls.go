@@ -22,10 +22,17 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/output"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/cli/utils"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -34,8 +41,27 @@ var example = `
  * List all books
  dnote ls
 
+ * List the books with at least 10 notes, the largest first
+ dnote ls --min-notes 10 --sort count --reverse
+
  * List notes in a book
  dnote ls javascript
+
+ * List the 10 most recently edited notes in a book
+ dnote ls javascript --sort edited --reverse --limit 10
+
+ * List the unsynced notes added in March 2021
+ dnote ls javascript --dirty --since 2021-03-01 --until 2021-03-31
+
+ * List the notes added today in a book
+ dnote ls journal --on today
+
+ * List the notes in a book with active share links
+ dnote ls javascript --shared
+
+ * List notes in a book with a prefix of their uuids, their sizes and the
+   time they were last edited
+ dnote ls javascript -l
  `
 
 var deprecationWarning = `and "view" will replace it in the future version.
@@ -43,6 +69,214 @@ var deprecationWarning = `and "view" will replace it in the future version.
 Run "dnote view --help" for more information.
 `
 
+var absolute bool
+var listOpts ListOptions
+
+// excerptLength is the number of characters of a note body loaded to print
+// its excerpt
+const excerptLength = 1000
+
+// sortColumns are the columns by which the notes are sorted for each value of
+// the sort flag. The notes at the same position are sorted by when they were
+// added, and the notes viewed as many times by when they were last viewed.
+var sortColumns = map[string][]string{
+	"position": {"notes.position", "notes.added_on"},
+	"added":    {"notes.added_on"},
+	"edited":   {"CASE notes.edited_on WHEN 0 THEN notes.added_on ELSE notes.edited_on END"},
+	"index":    {"notes.rowid"},
+	"views":    {"notes.view_count", "notes.last_viewed_at"},
+}
+
+// bookSortColumns are the columns by which the books are sorted for each value
+// of the sort flag. A book is added when its first listed note was added, and
+// edited when its last listed note was edited.
+var bookSortColumns = map[string]string{
+	"name":   "books.label",
+	"count":  "note_count",
+	"added":  "min(notes.added_on)",
+	"edited": "max(CASE notes.edited_on WHEN 0 THEN notes.added_on ELSE notes.edited_on END)",
+}
+
+// ListOptions are the options for listing the books or the notes in a book.
+// The filters are combined, so that only the items matching all of them are
+// listed.
+type ListOptions struct {
+	// Limit is the maximum number of notes to list. If zero, all notes are listed.
+	Limit  int
+	Offset int
+	// Sort is the key by which the items are sorted. The books are sorted by
+	// "name", "count", "added" or "edited", and by name if empty. The notes
+	// are sorted by "position", "added", "edited", "index" or "views", and by
+	// position if empty.
+	Sort string
+	// Reverse sorts the items in the descending order
+	Reverse bool
+	// Since and Until list only the notes added on or after, and on or before,
+	// the given dates in the local time. On lists only the notes added on the
+	// given date. A date is today, yesterday or in YYYY-MM-DD. The books are
+	// listed only if they have such notes, and their counts include only such
+	// notes.
+	Since string
+	Until string
+	On    string
+	// MinNotes lists only the books with at least the given number of notes
+	MinNotes int
+	// Dirty lists only the notes with changes that are not synced, and the
+	// books that either have such changes or have such notes
+	Dirty bool
+	// Deleted lists the removed books and notes as well
+	Deleted bool
+	// Shared lists only the notes with share links that have not expired, as
+	// of the last time the links were got from the server, and the books
+	// that have such notes
+	Shared bool
+	// Long prints a prefix of the uuid of each note, by which it can be
+	// referred to in other commands, along with its word and character counts
+	// and the time it was last edited
+	Long bool
+}
+
+func (o ListOptions) validate(books bool, now time.Time) error {
+	if o.Limit < 0 {
+		return errors.New("--limit must not be negative")
+	}
+	if o.Offset < 0 {
+		return errors.New("--offset must not be negative")
+	}
+	if o.MinNotes < 0 {
+		return errors.New("--min-notes must not be negative")
+	}
+	if books {
+		if _, ok := bookSortColumns[o.Sort]; o.Sort != "" && !ok {
+			return errors.Errorf("invalid sort '%s' for books. It must be one of name, count, added and edited", o.Sort)
+		}
+	} else {
+		if _, ok := sortColumns[o.Sort]; o.Sort != "" && !ok {
+			return errors.Errorf("invalid sort '%s' for notes. It must be one of position, added, edited, index and views", o.Sort)
+		}
+		if o.MinNotes > 0 {
+			return errors.New("--min-notes is only valid when listing books")
+		}
+	}
+	if _, _, err := o.addedRange(now); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseDate parses the given date expression in the local time, and returns
+// the start of the day and of the next day
+func parseDate(flag, s string, now time.Time) (time.Time, time.Time, error) {
+	t, err := utils.ParseDay(s, now.Local())
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Errorf("invalid %s '%s'. It must be today, yesterday or a date in YYYY-MM-DD", flag, s)
+	}
+
+	start, end := utils.DayRange(t)
+	return start, end, nil
+}
+
+// addedRange returns the range of the added_on of the listed notes in unix
+// nanoseconds. The end is exclusive, and a zero bound means no bound. The
+// dates relative to today are resolved against now.
+func (o ListOptions) addedRange(now time.Time) (int64, int64, error) {
+	var start, end int64
+
+	if o.On != "" {
+		if o.Since != "" || o.Until != "" {
+			return 0, 0, errors.New("--on cannot be used with --since or --until")
+		}
+
+		s, e, err := parseDate("--on", o.On, now)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return s.UnixNano(), e.UnixNano(), nil
+	}
+	if o.Since != "" {
+		t, _, err := parseDate("--since", o.Since, now)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		start = t.UnixNano()
+	}
+	if o.Until != "" {
+		_, t, err := parseDate("--until", o.Until, now)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		end = t.UnixNano()
+	}
+	if start != 0 && end != 0 && start >= end {
+		return 0, 0, errors.New("--since must not be later than --until")
+	}
+
+	return start, end, nil
+}
+
+// noteConds returns the conditions on the listed notes and their arguments
+func (o ListOptions) noteConds(now time.Time) ([]string, []interface{}) {
+	conds := []string{}
+	args := []interface{}{}
+
+	if !o.Deleted {
+		conds = append(conds, "notes.deleted = ?")
+		args = append(args, false)
+	}
+	if o.Dirty {
+		conds = append(conds, "notes.dirty = ?")
+		args = append(args, true)
+	}
+	if o.Shared {
+		conds = append(conds, database.ActiveShareCond)
+		args = append(args, now.UnixNano())
+	}
+
+	// the range is validated beforehand
+	start, end, _ := o.addedRange(now)
+	if start != 0 {
+		conds = append(conds, "notes.added_on >= ?")
+		args = append(args, start)
+	}
+	if end != 0 {
+		conds = append(conds, "notes.added_on < ?")
+		args = append(args, end)
+	}
+
+	return conds, args
+}
+
+// order returns the direction of the sort
+func (o ListOptions) order() string {
+	if o.Reverse {
+		return "DESC"
+	}
+
+	return "ASC"
+}
+
+// AddListFlags adds the flags for listing the books or the notes in a book to
+// the given command
+func AddListFlags(cmd *cobra.Command, opts *ListOptions) {
+	f := cmd.Flags()
+	f.IntVarP(&opts.Limit, "limit", "", 0, "list at most the given number of notes")
+	f.IntVarP(&opts.Offset, "offset", "", 0, "skip the given number of notes")
+	f.StringVarP(&opts.Sort, "sort", "", "", "sort books by 'name', 'count', 'added' or 'edited', and notes by 'position', 'added', 'edited', 'index' or 'views'")
+	f.BoolVarP(&opts.Reverse, "reverse", "", false, "sort in the descending order")
+	f.StringVarP(&opts.Since, "since", "", "", "list only the notes added on or after the date, which is 'today', 'yesterday' or in YYYY-MM-DD")
+	f.StringVarP(&opts.Until, "until", "", "", "list only the notes added on or before the date, which is 'today', 'yesterday' or in YYYY-MM-DD")
+	f.StringVarP(&opts.On, "on", "", "", "list only the notes added on the date, which is 'today', 'yesterday' or in YYYY-MM-DD")
+	f.IntVarP(&opts.MinNotes, "min-notes", "", 0, "list only the books with at least the given number of notes")
+	f.BoolVarP(&opts.Dirty, "dirty", "", false, "list only the books and notes with unsynced changes")
+	f.BoolVarP(&opts.Deleted, "deleted", "", false, "list the removed books and notes as well")
+	f.BoolVarP(&opts.Shared, "shared", "", false, "list only the books and notes with share links that have not expired, as of the last sync")
+	f.BoolVarP(&opts.Long, "long", "l", false, "print a prefix of the uuid, the word and character counts and the last edited time of each note")
+}
+
 func preRun(cmd *cobra.Command, args []string) error {
 	if len(args) > 1 {
 		return errors.New("Incorrect number of argument")
@@ -58,27 +292,48 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 		Aliases:    []string{"l", "notes"},
 		Short:      "List all notes",
 		Example:    example,
-		RunE:       NewRun(ctx, false),
+		RunE:       NewRun(ctx, false, &listOpts),
 		PreRunE:    preRun,
 		Deprecated: deprecationWarning,
 	}
 
+	f := cmd.Flags()
+	f.BoolVarP(&absolute, "absolute", "", false, "print absolute timestamps instead of relative ones")
+	AddListFlags(cmd, &listOpts)
+
 	return cmd
 }
 
-// NewRun returns a new run function for ls
-func NewRun(ctx context.DnoteCtx, nameOnly bool) infra.RunEFunc {
+// NewRun returns a new run function for ls. The books, or the notes in a book,
+// are listed with the given options, which are read when the command runs.
+func NewRun(ctx context.DnoteCtx, nameOnly bool, opts *ListOptions) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
+		if absolute && ctx.TimeFormat == "" {
+			ctx.TimeFormat = time.RFC3339
+		}
+
 		if len(args) == 0 {
-			if err := printBooks(ctx, nameOnly); err != nil {
+			if err := opts.validate(true, ctx.Clock.Now()); err != nil {
+				return err
+			}
+
+			if err := printBooks(ctx, nameOnly, *opts); err != nil {
 				return errors.Wrap(err, "viewing books")
 			}
 
 			return nil
 		}
 
-		bookName := args[0]
-		if err := printNotes(ctx, bookName); err != nil {
+		bookName, err := ui.ResolveBook(ctx, args[0])
+		if err != nil {
+			return errors.Wrap(err, "resolving the book")
+		}
+
+		if err := opts.validate(false, ctx.Clock.Now()); err != nil {
+			return err
+		}
+
+		if err := printNotes(ctx, bookName, *opts); err != nil {
 			return errors.Wrapf(err, "viewing book '%s'", bookName)
 		}
 
@@ -90,12 +345,84 @@ func NewRun(ctx context.DnoteCtx, nameOnly bool) infra.RunEFunc {
 type bookInfo struct {
 	BookLabel string
 	NoteCount int
+	Deleted   bool
 }
 
 // noteInfo is an information about the note to be printed on screen
 type noteInfo struct {
 	RowID int
-	Body  string
+	UUID  string
+	// Excerpt is at most the first excerptLength characters of the body
+	Excerpt string
+	// BodyLength is the number of the characters of the body
+	BodyLength int
+	// WordCount is the number of the words of the body, which is only
+	// counted in the long mode
+	WordCount int
+	AddedOn   int64
+	EditedOn  int64
+	Deleted   bool
+}
+
+// noteColumns are the widths of the columns printed for each note, so that
+// the columns are aligned
+type noteColumns struct {
+	rowID  int
+	words  int
+	chars  int
+	edited int
+}
+
+// pad pads the given string with spaces to the given width. The string is
+// aligned to the right if right is true.
+func pad(s string, width int, right bool) string {
+	n := width - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return s
+	}
+
+	if right {
+		return strings.Repeat(" ", n) + s
+	}
+
+	return s + strings.Repeat(" ", n)
+}
+
+// formatRowID returns the rowid of a note as it is printed
+func formatRowID(info noteInfo) string {
+	return fmt.Sprintf("(%d)", info.RowID)
+}
+
+// formatEdited returns the time the note was last edited
+func formatEdited(ctx context.DnoteCtx, info noteInfo, now time.Time) string {
+	ts := info.EditedOn
+	if ts == 0 {
+		ts = info.AddedOn
+	}
+
+	return output.FormatTime(time.Unix(0, ts), now, ctx.TimeFormat)
+}
+
+// getNoteColumns returns the widths of the columns of the given notes
+func getNoteColumns(ctx context.DnoteCtx, infos []noteInfo, now time.Time) noteColumns {
+	var ret noteColumns
+
+	for _, info := range infos {
+		ret.rowID = maxInt(ret.rowID, len(formatRowID(info)))
+		ret.words = maxInt(ret.words, utf8.RuneCountInString(i18n.T("ls.words", info.WordCount)))
+		ret.chars = maxInt(ret.chars, utf8.RuneCountInString(i18n.T("ls.chars", info.BodyLength)))
+		ret.edited = maxInt(ret.edited, utf8.RuneCountInString(formatEdited(ctx, info, now)))
+	}
+
+	return ret
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
 }
 
 // getNewlineIdx returns the index of newline character in a string
@@ -130,43 +457,107 @@ func printBookLine(info bookInfo, nameOnly bool) {
 	if nameOnly {
 		fmt.Println(info.BookLabel)
 	} else {
-		log.Printf("%s %s\n", info.BookLabel, log.ColorYellow.Sprintf("(%d)", info.NoteCount))
+		line := fmt.Sprintf("%s %s", info.BookLabel, log.ColorYellow.Sprintf("(%d)", info.NoteCount))
+		if info.Deleted {
+			line = fmt.Sprintf("%s %s", line, log.ColorGray.Sprint(i18n.T("ls.removed")))
+		}
+
+		log.Printf("%s\n", line)
 	}
 }
 
-func printBooks(ctx context.DnoteCtx, nameOnly bool) error {
-	db := ctx.DB
+// printBooks prints the books matching the given options. The notes are
+// counted in the same query, which groups them by their books.
+func printBooks(ctx context.DnoteCtx, nameOnly bool, opts ListOptions) error {
+	noteConds, args := opts.noteConds(ctx.Clock.Now())
+	joinConds := append([]string{"notes.book_uuid = books.uuid"}, noteConds...)
+
+	where := "1"
+	if !opts.Deleted {
+		where = "books.deleted = ?"
+		args = append(args, false)
+	}
+
+	having := []string{"1"}
+	if opts.Dirty {
+		having = append(having, "(books.dirty OR note_count > 0)")
+	}
+	if opts.Since != "" || opts.Until != "" || opts.On != "" || opts.Shared {
+		having = append(having, "note_count > 0")
+	}
+	if opts.MinNotes > 0 {
+		having = append(having, "note_count >= ?")
+		args = append(args, opts.MinNotes)
+	}
 
-	rows, err := db.Query(`SELECT books.label, count(notes.uuid) note_count
+	sort := opts.Sort
+	if sort == "" {
+		sort = "name"
+	}
+
+	query := fmt.Sprintf(`SELECT books.label, books.deleted, count(notes.uuid) note_count
 	FROM books
-	LEFT JOIN notes ON notes.book_uuid = books.uuid AND notes.deleted = false
-	WHERE books.deleted = false
+	LEFT JOIN notes ON %s
+	WHERE %s
 	GROUP BY books.uuid
-	ORDER BY books.label ASC;`)
+	HAVING %s
+	ORDER BY %s %s, books.label %s;`, strings.Join(joinConds, " AND "), where, strings.Join(having, " AND "), bookSortColumns[sort], opts.order(), opts.order())
+
+	rows, err := ctx.DB.Query(query, args...)
 	if err != nil {
 		return errors.Wrap(err, "querying books")
 	}
 	defer rows.Close()
 
-	infos := []bookInfo{}
 	for rows.Next() {
 		var info bookInfo
-		err = rows.Scan(&info.BookLabel, &info.NoteCount)
-		if err != nil {
+		if err := rows.Scan(&info.BookLabel, &info.Deleted, &info.NoteCount); err != nil {
 			return errors.Wrap(err, "scanning a row")
 		}
 
-		infos = append(infos, info)
-	}
-
-	for _, info := range infos {
 		printBookLine(info, nameOnly)
 	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "iterating rows")
+	}
 
 	return nil
 }
 
-func printNotes(ctx context.DnoteCtx, bookName string) error {
+// printNoteLine prints the excerpt of the given note. In the long mode, the
+// columns are aligned to the given widths.
+func printNoteLine(ctx context.DnoteCtx, info noteInfo, now time.Time, long bool, cols noteColumns) {
+	body, isExcerpt := formatBody(info.Excerpt)
+
+	rowid := log.ColorYellow.Sprint(formatRowID(info))
+	if long {
+		rowid = fmt.Sprintf("%s %s %s %s %s",
+			log.ColorYellow.Sprint(pad(formatRowID(info), cols.rowID, false)),
+			log.ColorGray.Sprint(database.ShortUUID(info.UUID)),
+			pad(i18n.T("ls.words", info.WordCount), cols.words, true),
+			pad(i18n.T("ls.chars", info.BodyLength), cols.chars, true),
+			log.ColorGray.Sprint(pad(formatEdited(ctx, info, now), cols.edited, false)),
+		)
+	}
+	if isExcerpt || info.BodyLength > excerptLength {
+		body = fmt.Sprintf("%s %s", body, log.ColorYellow.Sprint(i18n.T("ls.more")))
+	}
+	if info.Deleted {
+		body = fmt.Sprintf("%s %s", body, log.ColorGray.Sprint(i18n.T("ls.removed")))
+	}
+
+	// the long mode prints the time the note was last edited instead
+	if long {
+		log.Plainf("%s %s\n", rowid, body)
+		return
+	}
+
+	addedOn := log.ColorGray.Sprint(output.FormatTime(time.Unix(0, info.AddedOn), now, ctx.TimeFormat))
+
+	log.Plainf("%s %s %s\n", rowid, body, addedOn)
+}
+
+func printNotes(ctx context.DnoteCtx, bookName string, opts ListOptions) error {
 	db := ctx.DB
 
 	var bookUUID string
@@ -177,7 +568,38 @@ func printNotes(ctx context.DnoteCtx, bookName string) error {
 		return errors.Wrap(err, "querying the book")
 	}
 
-	rows, err := db.Query(`SELECT rowid, body FROM notes WHERE book_uuid = ? AND deleted = ? ORDER BY added_on ASC;`, bookUUID, false)
+	// A negative limit means no limit. The rowid breaks ties so that the pages
+	// neither overlap nor skip any note.
+	limit := -1
+	if opts.Limit > 0 {
+		limit = opts.Limit
+	}
+
+	sort := opts.Sort
+	if sort == "" {
+		sort = "position"
+	}
+
+	order := []string{}
+	for _, column := range append(sortColumns[sort], "notes.rowid") {
+		order = append(order, fmt.Sprintf("%s %s", column, opts.order()))
+	}
+
+	noteConds, condArgs := opts.noteConds(ctx.Clock.Now())
+	conds := append([]string{"notes.book_uuid = ?"}, noteConds...)
+
+	// the whole body is only loaded to count the words in the long mode
+	query := fmt.Sprintf(`SELECT notes.rowid, notes.uuid, substr(notes.body, 1, ?), length(notes.body),
+		CASE WHEN ? THEN notes.body ELSE '' END, notes.added_on, notes.edited_on, notes.deleted
+	FROM notes
+	WHERE %s
+	ORDER BY %s
+	LIMIT ? OFFSET ?;`, strings.Join(conds, " AND "), strings.Join(order, ", "))
+
+	args := append([]interface{}{excerptLength, opts.Long, bookUUID}, condArgs...)
+	args = append(args, limit, opts.Offset)
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return errors.Wrap(err, "querying notes")
 	}
@@ -186,25 +608,31 @@ func printNotes(ctx context.DnoteCtx, bookName string) error {
 	infos := []noteInfo{}
 	for rows.Next() {
 		var info noteInfo
-		err = rows.Scan(&info.RowID, &info.Body)
+		var body string
+		err = rows.Scan(&info.RowID, &info.UUID, &info.Excerpt, &info.BodyLength, &body, &info.AddedOn, &info.EditedOn, &info.Deleted)
 		if err != nil {
 			return errors.Wrap(err, "scanning a row")
 		}
+		if opts.Long {
+			info.WordCount = utils.CountWords(body)
+		}
 
 		infos = append(infos, info)
 	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "iterating rows")
+	}
 
-	log.Infof("on book %s\n", bookName)
-
-	for _, info := range infos {
-		body, isExcerpt := formatBody(info.Body)
+	log.Info(i18n.T("ls.on_book", bookName))
 
-		rowid := log.ColorYellow.Sprintf("(%d)", info.RowID)
-		if isExcerpt {
-			body = fmt.Sprintf("%s %s", body, log.ColorYellow.Sprintf("[---More---]"))
-		}
+	now := ctx.Clock.Now().Local()
+	var cols noteColumns
+	if opts.Long {
+		cols = getNoteColumns(ctx, infos, now)
+	}
 
-		log.Plainf("%s %s\n", rowid, body)
+	for _, info := range infos {
+		printNoteLine(ctx, info, now, opts.Long, cols)
 	}
 
 	return nil
@@ -0,0 +1,445 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+func TestLs(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	now := testutils.CmdNow
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	f.Book("css")
+	f.Note(b1, "arrow functions", testutils.With.AddedOn(now.Add(-3*time.Hour).UnixNano()))
+	f.Note(b1, "closures", testutils.With.AddedOn(now.Add(-10*24*time.Hour).UnixNano()))
+
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "books", args: []string{}},
+		{name: "notes", args: []string{"js"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			absolute = false
+
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			assert.Equal(t, result.Err, nil, "error mismatch")
+			assert.Equal(t, string(result.Stderr), "", "stderr mismatch")
+			testutils.AssertGolden(t, result.Stdout, "ls_"+tc.name)
+		})
+	}
+}
+
+var rowIDRegex = regexp.MustCompile(`\((\d+)\) `)
+
+// listRowIDs runs ls with the given arguments and returns the rowids of the
+// listed notes in order
+func listRowIDs(t *testing.T, ctx context.DnoteCtx, args ...string) []string {
+	result := testutils.RunCmd(t, ctx, NewCmd, args...)
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "running ls"))
+	}
+
+	ret := []string{}
+	for _, m := range rowIDRegex.FindAllStringSubmatch(string(result.Stdout), -1) {
+		ret = append(ret, m[1])
+	}
+
+	return ret
+}
+
+func TestLs_sort(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	f.Note(b1, "n1", testutils.With.AddedOn(3), testutils.With.EditedOn(10), testutils.With.Position(2))
+	f.Note(b1, "n2", testutils.With.AddedOn(1), testutils.With.Position(3), testutils.With.Viewed(2, 1))
+	f.Note(b1, "n3", testutils.With.AddedOn(2), testutils.With.EditedOn(5), testutils.With.Position(1), testutils.With.Viewed(1, 9))
+	f.Note(b1, "n4", testutils.With.AddedOn(1), testutils.With.Deleted())
+
+	testCases := []struct {
+		sort     string
+		expected []string
+	}{
+		{sort: "added", expected: []string{"2", "3", "1"}},
+		{sort: "edited", expected: []string{"2", "3", "1"}},
+		{sort: "index", expected: []string{"1", "2", "3"}},
+		{sort: "position", expected: []string{"3", "1", "2"}},
+		{sort: "views", expected: []string{"1", "3", "2"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.sort, func(t *testing.T) {
+			assert.DeepEqual(t, listRowIDs(t, ctx, "js", "--sort", tc.sort), tc.expected, "rowids mismatch")
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		assert.DeepEqual(t, listRowIDs(t, ctx, "js"), []string{"3", "1", "2"}, "rowids mismatch")
+	})
+
+	t.Run("edited ties", func(t *testing.T) {
+		database.MustExec(t, "editing n2", ctx.DB, "UPDATE notes SET edited_on = ? WHERE rowid = ?", 7, 2)
+
+		assert.DeepEqual(t, listRowIDs(t, ctx, "js", "--sort", "edited"), []string{"3", "2", "1"}, "rowids mismatch")
+	})
+}
+
+func TestLs_pagination(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	for i := 0; i < 8; i++ {
+		// notes added at the same time are ordered by their rowids
+		f.Note(b1, fmt.Sprintf("n%d", i), testutils.With.AddedOn(int64(i/3)), testutils.With.EditedOn(int64(8-i)/2))
+	}
+
+	for _, sort := range []string{"added", "edited", "index"} {
+		t.Run(sort, func(t *testing.T) {
+			all := listRowIDs(t, ctx, "js", "--sort", sort)
+			assert.Equal(t, len(all), 8, "note count mismatch")
+
+			paged := []string{}
+			for offset := 0; offset < 10; offset += 3 {
+				page := listRowIDs(t, ctx, "js", "--sort", sort, "--limit", "3", "--offset", fmt.Sprint(offset))
+
+				paged = append(paged, page...)
+			}
+
+			assert.DeepEqual(t, paged, all, "paged rowids mismatch")
+		})
+	}
+}
+
+func TestLs_invalidFlags(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	f.Book("js")
+
+	testCases := [][]string{
+		{"js", "--sort", "title"},
+		{"js", "--limit", "-1"},
+		{"js", "--offset", "-1"},
+		{"--sort", "index"},
+		{"js", "--sort", "name"},
+		{"js", "--min-notes", "1"},
+		{"--min-notes", "-1"},
+		{"js", "--since", "03/01/2021"},
+		{"js", "--since", "2021-03-10", "--until", "2021-03-01"},
+		{"js", "--on", "tomorrow"},
+		{"js", "--on", "2021-03-01", "--since", "2021-03-01"},
+	}
+
+	for idx, args := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+
+			assert.NotEqual(t, result.Err, nil, "error mismatch")
+		})
+	}
+}
+
+func TestLs_long(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	now := testutils.CmdNow
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	f.Note(b1, "n1", testutils.With.UUID("3a7c1d2e-0b4f-4c8a-9e2d-5f6a7b8c9d0e"), testutils.With.AddedOn(now.Add(-3*time.Hour).UnixNano()))
+	f.Note(b1, "closures capture variables\nand more", testutils.With.UUID("9f8e7d6c-0b4f-4c8a-9e2d-5f6a7b8c9d0e"),
+		testutils.With.AddedOn(now.Add(-2*time.Hour).UnixNano()), testutils.With.EditedOn(now.Add(-2*time.Minute).UnixNano()))
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "js", "-l")
+	assert.Equal(t, result.Err, nil, "error mismatch")
+	// the counts are aligned to the right, and the edited time replaces the added time
+	assert.Equal(t, strings.Contains(string(result.Stdout), "(1) 3a7c1d2e 1 words  2 chars 3h ago n1\n"), true, "output mismatch")
+	assert.Equal(t, strings.Contains(string(result.Stdout), "(2) 9f8e7d6c 5 words 35 chars 2m ago closures capture variables"), true, "output mismatch")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "js")
+	assert.Equal(t, result.Err, nil, "error mismatch")
+	assert.Equal(t, strings.Contains(string(result.Stdout), "3a7c1d2e"), false, "the uuid should not be printed without --long")
+}
+
+func TestLs_excerpt(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	f.Note(b1, strings.Repeat("a", excerptLength))
+	f.Note(b1, strings.Repeat("b", excerptLength+1))
+	f.Note(b1, "first line\n"+strings.Repeat("c", 2*excerptLength))
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "js")
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	lines := strings.Split(strings.TrimSpace(string(result.Stdout)), "\n")
+	lines = lines[len(lines)-3:]
+
+	assert.Equal(t, strings.Contains(lines[0], strings.Repeat("a", excerptLength)), true, "note 1 mismatch")
+	assert.Equal(t, strings.Contains(lines[0], "[---More---]"), false, "note 1 excerpt mismatch")
+	assert.Equal(t, strings.Contains(lines[1], strings.Repeat("b", excerptLength)+" [---More---]"), true, "note 2 mismatch")
+	assert.Equal(t, strings.Contains(lines[2], "(3) first line [---More---]"), true, "note 3 mismatch")
+}
+
+var bookLineRegex = regexp.MustCompile(`• (\S+) \((\d+)\)( \[removed\])?`)
+
+// listBooks runs ls with the given arguments and returns the listed books in
+// order, each as its label and its note count, followed by a marker if removed
+func listBooks(t *testing.T, ctx context.DnoteCtx, args ...string) []string {
+	result := testutils.RunCmd(t, ctx, NewCmd, args...)
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "running ls"))
+	}
+
+	ret := []string{}
+	for _, m := range bookLineRegex.FindAllStringSubmatch(string(result.Stdout), -1) {
+		line := fmt.Sprintf("%s:%s", m[1], m[2])
+		if m[3] != "" {
+			line += " removed"
+		}
+
+		ret = append(ret, line)
+	}
+
+	return ret
+}
+
+// setupListFixture creates the books and the notes listed by the tests of the
+// sort and the filter flags. The notes are added in 2021 on the given days.
+func setupListFixture(t *testing.T, ctx context.DnoteCtx) {
+	day := func(month time.Month, d int) int64 {
+		return time.Date(2021, month, d, 9, 0, 0, 0, time.UTC).UnixNano()
+	}
+
+	f := testutils.NewFixture(t, ctx.DB)
+	js := f.Book("js", testutils.With.USN(1))
+	css := f.Book("css", testutils.With.USN(2))
+	f.Book("go", testutils.With.Dirty())
+	f.Book("old", testutils.With.Deleted(), testutils.With.Dirty())
+
+	// rowid 1
+	f.Note(js, "n1", testutils.With.USN(3), testutils.With.AddedOn(day(time.March, 1)))
+	// rowid 2
+	f.Note(js, "n2", testutils.With.AddedOn(day(time.March, 10)), testutils.With.EditedOn(day(time.March, 14)), testutils.With.Dirty())
+	// rowid 3
+	f.Note(js, "n3", testutils.With.AddedOn(day(time.February, 1)), testutils.With.Deleted(), testutils.With.Dirty())
+	// rowid 4
+	f.Note(css, "n4", testutils.With.USN(4), testutils.With.AddedOn(day(time.March, 5)))
+	// rowid 5
+	f.Note(css, "n5", testutils.With.USN(5), testutils.With.AddedOn(day(time.January, 20)))
+	// rowid 6
+	f.Note(js, "n6", testutils.With.USN(6), testutils.With.AddedOn(day(time.January, 5)))
+}
+
+func TestLs_books(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupListFixture(t, ctx)
+
+	testCases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{name: "default", args: []string{}, expected: []string{"css:2", "go:0", "js:3"}},
+		{name: "sort name reverse", args: []string{"--sort", "name", "--reverse"}, expected: []string{"js:3", "go:0", "css:2"}},
+		{name: "sort count", args: []string{"--sort", "count"}, expected: []string{"go:0", "css:2", "js:3"}},
+		{name: "sort count reverse", args: []string{"--sort", "count", "--reverse"}, expected: []string{"js:3", "css:2", "go:0"}},
+		{name: "sort added", args: []string{"--sort", "added"}, expected: []string{"go:0", "js:3", "css:2"}},
+		{name: "sort edited", args: []string{"--sort", "edited"}, expected: []string{"go:0", "css:2", "js:3"}},
+		{name: "since", args: []string{"--since", "2021-03-01"}, expected: []string{"css:1", "js:2"}},
+		{name: "until", args: []string{"--until", "2021-01-20"}, expected: []string{"css:1", "js:1"}},
+		{name: "min notes", args: []string{"--min-notes", "3"}, expected: []string{"js:3"}},
+		{name: "dirty", args: []string{"--dirty"}, expected: []string{"go:0", "js:1"}},
+		{name: "deleted", args: []string{"--deleted"}, expected: []string{"css:2", "go:0", "js:4", "old:0 removed"}},
+		{name: "deleted and dirty", args: []string{"--deleted", "--dirty"}, expected: []string{"go:0", "js:2", "old:0 removed"}},
+		{name: "since and min notes", args: []string{"--since", "2021-03-01", "--min-notes", "2", "--sort", "count"}, expected: []string{"js:2"}},
+		{name: "range and dirty", args: []string{"--since", "2021-03-01", "--until", "2021-03-09", "--dirty"}, expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.DeepEqual(t, listBooks(t, ctx, tc.args...), tc.expected, "books mismatch")
+		})
+	}
+}
+
+func TestLs_noteFilters(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupListFixture(t, ctx)
+
+	testCases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{name: "default", args: []string{}, expected: []string{"6", "1", "2"}},
+		{name: "reverse", args: []string{"--reverse"}, expected: []string{"2", "1", "6"}},
+		{name: "sort edited reverse", args: []string{"--sort", "edited", "--reverse"}, expected: []string{"2", "1", "6"}},
+		{name: "since", args: []string{"--since", "2021-03-01"}, expected: []string{"1", "2"}},
+		{name: "until", args: []string{"--until", "2021-03-01"}, expected: []string{"6", "1"}},
+		{name: "since and until", args: []string{"--since", "2021-03-01", "--until", "2021-03-01"}, expected: []string{"1"}},
+		{name: "on", args: []string{"--on", "2021-03-10"}, expected: []string{"2"}},
+		{name: "until yesterday", args: []string{"--until", "yesterday"}, expected: []string{"6", "1", "2"}},
+		{name: "dirty", args: []string{"--dirty"}, expected: []string{"2"}},
+		{name: "deleted", args: []string{"--deleted"}, expected: []string{"6", "3", "1", "2"}},
+		{name: "deleted and dirty", args: []string{"--deleted", "--dirty", "--reverse"}, expected: []string{"2", "3"}},
+		{name: "deleted since with limit", args: []string{"--deleted", "--since", "2021-02-01", "--limit", "2"}, expected: []string{"3", "1"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"js"}, tc.args...)
+
+			assert.DeepEqual(t, listRowIDs(t, ctx, args...), tc.expected, "rowids mismatch")
+		})
+	}
+
+	t.Run("removed marker", func(t *testing.T) {
+		result := testutils.RunCmd(t, ctx, NewCmd, "js", "--deleted")
+		assert.Equal(t, result.Err, nil, "error mismatch")
+
+		for _, line := range strings.Split(string(result.Stdout), "\n") {
+			line = strings.TrimSpace(line)
+
+			if strings.HasPrefix(line, "(3)") {
+				assert.Equal(t, strings.Contains(line, "[removed]"), true, "removed note should be marked")
+			} else if strings.HasPrefix(line, "(1)") {
+				assert.Equal(t, strings.Contains(line, "[removed]"), false, "note should not be marked")
+			}
+		}
+	})
+}
+
+func TestLs_shared(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupListFixture(t, ctx)
+
+	share := func(rowID int, id string, expiresAt int64) {
+		var uuid string
+		database.MustScan(t, "getting the note uuid", ctx.DB.QueryRow("SELECT uuid FROM notes WHERE rowid = ?", rowID), &uuid)
+
+		s := database.Share{ID: id, NoteUUID: uuid, URL: "https://example.com/s/" + id, ExpiresAt: expiresAt}
+		if err := database.SaveShare(ctx.DB, s); err != nil {
+			t.Fatal(errors.Wrap(err, "saving a share"))
+		}
+	}
+	share(1, "s1", 0)
+	share(4, "s2", testutils.CmdNow.Add(time.Hour).UnixNano())
+	share(6, "s3", testutils.CmdNow.Add(-time.Hour).UnixNano())
+
+	assert.DeepEqual(t, listBooks(t, ctx, "--shared"), []string{"css:1", "js:1"}, "books mismatch")
+	assert.DeepEqual(t, listRowIDs(t, ctx, "js", "--shared"), []string{"1"}, "rowids mismatch")
+}
+
+// benchmarkLargeBodyNotes is the number of notes listed by BenchmarkPrintNotes,
+// each of which has a body of benchmarkLargeBodySize bytes
+const (
+	benchmarkLargeBodyNotes = 20
+	benchmarkLargeBodySize  = 1 << 20
+)
+
+// BenchmarkPrintNotes lists a book of notes with large bodies
+func BenchmarkPrintNotes(b *testing.B) {
+	db := database.InitTestDB(b, filepath.Join(b.TempDir(), "bench.db"), &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(b, db)
+
+	database.MustExec(b, "inserting a book", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+
+	var notes []database.Note
+	for i := 0; i < benchmarkLargeBodyNotes; i++ {
+		body := fmt.Sprintf("note %d\n%s", i, strings.Repeat("x", benchmarkLargeBodySize))
+		notes = append(notes, database.NewNote(fmt.Sprintf("n%d-uuid", i), "b1-uuid", body, int64(i), 0, 0, false, false, false))
+	}
+	if err := database.InsertNotes(db, notes); err != nil {
+		b.Fatal(errors.Wrap(err, "inserting notes"))
+	}
+
+	ctx := context.DnoteCtx{DB: db, Clock: clock.NewMock()}
+
+	logger := log.Default()
+	origOut, origErrOut := logger.Output()
+	logger.SetOutput(ioutil.Discard, ioutil.Discard)
+	defer logger.SetOutput(origOut, origErrOut)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := printNotes(ctx, "js", ListOptions{}); err != nil {
+			b.Fatal(errors.Wrap(err, "printing notes"))
+		}
+	}
+}
+
+func TestPrintNotes_memory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+
+	result := testing.Benchmark(BenchmarkPrintNotes)
+
+	// loading the bodies would allocate at least their total size
+	budget := int64(benchmarkLargeBodyNotes*benchmarkLargeBodySize) / 10
+	if result.AllocedBytesPerOp() > budget {
+		t.Errorf("allocated %d bytes per listing, more than the budget of %d bytes", result.AllocedBytesPerOp(), budget)
+	}
+}
@@ -0,0 +1,91 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migrate
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	dbmigrate "github.com/dnote/dnote/pkg/cli/migrate"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var statusFlag bool
+
+var example = `
+ * Print the schema versions of the database and pending migrations
+ dnote migrate --status`
+
+// NewCmd returns a new migrate command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "migrate",
+		Short:   "Manage database migrations",
+		Long:    "Manage database migrations. Local migrations are run automatically on startup, and remote migrations on sync.",
+		Example: example,
+		Args:    cobra.NoArgs,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&statusFlag, "status", "", false, "print the schema versions and pending migrations")
+
+	return cmd
+}
+
+func printStatus(label string, s dbmigrate.Status) {
+	if len(s.Pending) == 0 {
+		log.Info(i18n.T("migrate.up_to_date", label, s.Current, s.Latest))
+		return
+	}
+
+	log.Info(i18n.T("migrate.pending", label, s.Current, s.Latest, len(s.Pending)))
+	for _, name := range s.Pending {
+		log.Plainf("  %s\n", name)
+	}
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if !statusFlag {
+			log.Plain(i18n.T("migrate.already_run"))
+			return nil
+		}
+
+		local, err := dbmigrate.GetStatus(ctx, dbmigrate.LocalSequence, dbmigrate.LocalMode)
+		if err != nil {
+			return errors.Wrap(err, "getting the local migration status")
+		}
+		remote, err := dbmigrate.GetStatus(ctx, dbmigrate.RemoteSequence, dbmigrate.RemoteMode)
+		if err != nil {
+			return errors.Wrap(err, "getting the remote migration status")
+		}
+
+		printStatus(i18n.T("migrate.local"), local)
+		printStatus(i18n.T("migrate.remote"), remote)
+
+		if len(remote.Pending) > 0 {
+			log.Plain(i18n.T("migrate.remote_on_sync"))
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,164 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package open
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var printFlag bool
+
+var example = `
+ * Open a public note in the browser
+ dnote open 12
+
+ * Open a public note by a prefix of its uuid
+ dnote open 3a7c1d
+
+ * Print the URL of a public note
+ dnote open 12 --print`
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errors.New("Incorrect number of arguments")
+	}
+
+	return nil
+}
+
+// NewCmd returns a new open command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "open <book name?> <note id|uuid prefix>",
+		Short:   "Open a public note in the browser",
+		Example: example,
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&printFlag, "print", "", false, "print the URL instead of opening it")
+
+	return cmd
+}
+
+// getWebEndpoint returns the base URL of the web interface. It is derived from
+// the API endpoint unless configured explicitly, since the API of a self-hosted
+// server is served under the same host.
+func getWebEndpoint(ctx context.DnoteCtx) (string, error) {
+	if ctx.WebEndpoint != "" {
+		return strings.TrimRight(ctx.WebEndpoint, "/"), nil
+	}
+	if ctx.APIEndpoint == consts.HostedAPIEndpoint {
+		return consts.HostedWebEndpoint, nil
+	}
+
+	u, err := url.Parse(ctx.APIEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing the API endpoint")
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", errors.Errorf("cannot derive the web endpoint from the API endpoint '%s'. Please set webEndpoint in the config", ctx.APIEndpoint)
+	}
+
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host), nil
+}
+
+// getNoteURL returns the URL at which the given note can be viewed
+func getNoteURL(ctx context.DnoteCtx, note database.Note) (string, error) {
+	if note.USN == 0 {
+		return "", errors.New("the note has not been synced yet. Please run `dnote sync` first")
+	}
+	if !note.Public {
+		return "", errors.New("the note is not public")
+	}
+
+	base, err := getWebEndpoint(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "getting the web endpoint")
+	}
+
+	return fmt.Sprintf("%s/notes/%s", base, note.UUID), nil
+}
+
+// getOpenCmd returns a command that opens the given URL with the default
+// application of the platform
+func getOpenCmd(u string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", u)
+	case "windows":
+		return exec.Command("cmd", "/c", "start", "", u)
+	default:
+		return exec.Command("xdg-open", u)
+	}
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		// the book name is optional, as note ids are unique across books
+		noteRef := args[len(args)-1]
+		noteRowID, ok, err := database.ResolveNoteRef(ctx.DB, noteRef, false)
+		if err != nil {
+			return errors.Wrap(err, "resolving the note")
+		}
+		if !ok {
+			return errors.Errorf("note '%s' not found. Use its id or a prefix of its uuid of at least %d characters", noteRef, database.MinUUIDPrefixLength)
+		}
+
+		note, err := database.GetActiveNote(ctx.DB, noteRowID)
+		if err == sql.ErrNoRows {
+			return errors.Errorf("note %d not found", noteRowID)
+		} else if err != nil {
+			return errors.Wrap(err, "getting the note")
+		}
+
+		u, err := getNoteURL(ctx, note)
+		if err != nil {
+			return err
+		}
+
+		if printFlag {
+			fmt.Println(u)
+			return nil
+		}
+
+		if err := getOpenCmd(u).Run(); err != nil {
+			return errors.Wrapf(err, "opening %s", u)
+		}
+
+		log.Success(i18n.T("open.opened", u))
+
+		return nil
+	}
+}
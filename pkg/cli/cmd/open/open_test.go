@@ -0,0 +1,110 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package open
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func TestGetNoteURL(t *testing.T) {
+	note := database.Note{UUID: "n1-uuid", USN: 3, Public: true}
+
+	testCases := []struct {
+		apiEndpoint string
+		webEndpoint string
+		expected    string
+	}{
+		{
+			apiEndpoint: "https://api.getdnote.com",
+			expected:    "https://www.getdnote.com/notes/n1-uuid",
+		},
+		{
+			apiEndpoint: "http://localhost:3000/api",
+			expected:    "http://localhost:3000/notes/n1-uuid",
+		},
+		{
+			apiEndpoint: "https://dnote.example.com/api",
+			expected:    "https://dnote.example.com/notes/n1-uuid",
+		},
+		{
+			apiEndpoint: "https://api.example.com",
+			webEndpoint: "https://notes.example.com/",
+			expected:    "https://notes.example.com/notes/n1-uuid",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("api %s web %s", tc.apiEndpoint, tc.webEndpoint), func(t *testing.T) {
+			ctx := context.DnoteCtx{
+				APIEndpoint: tc.apiEndpoint,
+				WebEndpoint: tc.webEndpoint,
+			}
+
+			result, err := getNoteURL(ctx, note)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.Equal(t, result, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestGetNoteURL_unavailable(t *testing.T) {
+	ctx := context.DnoteCtx{APIEndpoint: "http://localhost:3000/api"}
+
+	testCases := []struct {
+		note        database.Note
+		expectedErr string
+	}{
+		{
+			note:        database.Note{UUID: "n1-uuid", USN: 0, Public: true},
+			expectedErr: "the note has not been synced yet. Please run `dnote sync` first",
+		},
+		{
+			note:        database.Note{UUID: "n1-uuid", USN: 3, Public: false},
+			expectedErr: "the note is not public",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expectedErr, func(t *testing.T) {
+			_, err := getNoteURL(ctx, tc.note)
+			if err == nil {
+				t.Fatal("error was not returned")
+			}
+
+			assert.Equal(t, err.Error(), tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestGetNoteURL_invalidEndpoint(t *testing.T) {
+	ctx := context.DnoteCtx{APIEndpoint: "localhost"}
+	note := database.Note{UUID: "n1-uuid", USN: 3, Public: true}
+
+	_, err := getNoteURL(ctx, note)
+	assert.NotEqual(t, err, nil, "error mismatch")
+}
@@ -0,0 +1,148 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package purge
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/cmd/db"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Purge the removed notes and books that are synced
+ dnote purge
+
+ * Purge the ones removed more than 30 days ago
+ dnote purge --older-than 30d
+
+ * Show what would be purged without removing anything
+ dnote purge --dry-run
+
+ * Purge and reclaim the space in the database file
+ dnote purge --vacuum`
+
+var olderThanFlag string
+var dryRunFlag bool
+var vacuumFlag bool
+
+// NewCmd returns a new purge command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "purge",
+		Short:   "Permanently remove the notes and books whose removal is synced",
+		Example: example,
+		Args:    cobra.NoArgs,
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&olderThanFlag, "older-than", "", "", "purge only the notes and books removed longer ago than the age, e.g. 30d or 12h")
+	f.BoolVarP(&dryRunFlag, "dry-run", "", false, "print what would be purged without removing anything")
+	f.BoolVarP(&vacuumFlag, "vacuum", "", false, "reclaim the space in the database file after purging, as 'dnote db maintain' does")
+
+	return cmd
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if _, err := utils.ParseAge(olderThanFlag); err != nil {
+		return err
+	}
+	if dryRunFlag && vacuumFlag {
+		return errors.New("--dry-run and --vacuum cannot be used together")
+	}
+
+	return nil
+}
+
+// purge removes the notes and the books that were removed longer than the
+// given age ago, in a single transaction
+func purge(ctx context.DnoteCtx, age time.Duration, dryRun bool) (database.PurgeResult, error) {
+	var ret database.PurgeResult
+
+	locked, err := infra.IsSyncLocked(ctx)
+	if err != nil {
+		return ret, errors.Wrap(err, "checking the sync lock")
+	}
+	if locked {
+		return ret, errors.Wrap(infra.ErrSyncLocked, "cannot purge")
+	}
+
+	before := ctx.Clock.Now().Add(-age).UnixNano()
+
+	err = infra.WithTxRetry(ctx.DB, func(tx *database.DB) error {
+		r, err := database.Purge(tx, before, dryRun)
+		if err != nil {
+			return err
+		}
+
+		ret = r
+
+		return nil
+	})
+
+	return ret, err
+}
+
+// printExpunged prints the number of the notes purged from the archive of the
+// expunged notes, if any
+func printExpunged(res database.PurgeResult) {
+	if res.ExpungedNotes > 0 {
+		log.Info(i18n.T("purge.expunged", res.ExpungedNotes))
+	}
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		age, err := utils.ParseAge(olderThanFlag)
+		if err != nil {
+			return err
+		}
+
+		res, err := purge(ctx, age, dryRunFlag)
+		if err != nil {
+			return errors.Wrap(err, "purging")
+		}
+
+		if dryRunFlag {
+			log.Info(i18n.T("purge.dry_run", res.Notes, res.Books, res.Bytes))
+			printExpunged(res)
+			return nil
+		}
+
+		log.Success(i18n.T("purge.purged", res.Notes, res.Books, res.Bytes))
+		printExpunged(res)
+
+		if vacuumFlag {
+			if err := db.Maintain(ctx, false); err != nil {
+				return errors.Wrap(err, "maintaining the database")
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,132 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package purge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// setupPurge inserts a note removed 40 days ago, one removed 10 days ago, and
+// one whose removal is not synced yet
+func setupPurge(t *testing.T, ctx context.DnoteCtx) (database.Note, database.Note, database.Note) {
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js", testutils.With.USN(1))
+	old := f.Note(b1, "", testutils.With.USN(2), testutils.With.Deleted())
+	recent := f.Note(b1, "", testutils.With.USN(3), testutils.With.Deleted())
+	dirty := f.Note(b1, "", testutils.With.USN(4), testutils.With.Deleted(), testutils.With.Dirty())
+
+	removedAt := map[string]time.Time{
+		old.UUID:    testutils.CmdNow.Add(-40 * 24 * time.Hour),
+		recent.UUID: testutils.CmdNow.Add(-10 * 24 * time.Hour),
+		dirty.UUID:  testutils.CmdNow.Add(-40 * 24 * time.Hour),
+	}
+	for uuid, ts := range removedAt {
+		a := database.Action{Timestamp: ts.UnixNano(), Actor: database.ActorCLI, Operation: database.OpRemoveNote, NoteUUID: uuid, BookUUID: b1.UUID}
+		if err := database.InsertAction(ctx.DB, a); err != nil {
+			t.Fatal(errors.Wrap(err, "inserting an action"))
+		}
+	}
+
+	return old, recent, dirty
+}
+
+func countNote(t *testing.T, db *database.DB, uuid string) int {
+	var ret int
+	database.MustScan(t, "counting the note", db.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", uuid), &ret)
+
+	return ret
+}
+
+func TestPurge(t *testing.T) {
+	testCases := []struct {
+		name            string
+		args            []string
+		expectedOld     int
+		expectedRecent  int
+		expectedMessage string
+	}{
+		{
+			name:            "all",
+			args:            []string{},
+			expectedOld:     0,
+			expectedRecent:  0,
+			expectedMessage: "purged 2 notes and 0 books",
+		},
+		{
+			name:            "older than",
+			args:            []string{"--older-than", "30d"},
+			expectedOld:     0,
+			expectedRecent:  1,
+			expectedMessage: "purged 1 notes and 0 books",
+		},
+		{
+			name:            "dry run",
+			args:            []string{"--older-than", "30d", "--dry-run"},
+			expectedOld:     1,
+			expectedRecent:  1,
+			expectedMessage: "would purge 1 notes and 0 books",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			old, recent, dirty := setupPurge(t, ctx)
+
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+			assert.Equal(t, result.Err, nil, "error mismatch")
+
+			assert.Equal(t, countNote(t, ctx.DB, old.UUID), tc.expectedOld, "old note count mismatch")
+			assert.Equal(t, countNote(t, ctx.DB, recent.UUID), tc.expectedRecent, "recent note count mismatch")
+			// a note whose removal the server has not acknowledged is never purged
+			assert.Equal(t, countNote(t, ctx.DB, dirty.UUID), 1, "dirty note count mismatch")
+			assert.Equal(t, strings.Contains(string(result.Stdout), tc.expectedMessage), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+		})
+	}
+}
+
+func TestPurge_syncLocked(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	old, _, _ := setupPurge(t, ctx)
+
+	if err := infra.AcquireSyncLock(ctx); err != nil {
+		t.Fatal(errors.Wrap(err, "acquiring the lock"))
+	}
+	defer infra.ReleaseSyncLock(ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd)
+	assert.Equal(t, errors.Cause(result.Err), infra.ErrSyncLocked, "error mismatch")
+	assert.Equal(t, countNote(t, ctx.DB, old.UUID), 1, "note count mismatch")
+}
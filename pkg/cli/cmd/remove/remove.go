@@ -19,16 +19,14 @@
 package remove
 
 import (
-	"fmt"
-	"strconv"
-
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/output"
 	"github.com/dnote/dnote/pkg/cli/ui"
-	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/dnote"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -40,6 +38,9 @@ var example = `
   * Delete a note by id
   dnote delete 2
 
+  * Delete a note by a prefix of its uuid
+  dnote delete 3a7c1d
+
   * Delete a book by name
   dnote delete js
 `
@@ -47,7 +48,7 @@ var example = `
 // NewCmd returns a new remove command
 func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:     "remove <note id|book name>",
+		Use:     "remove <note id|uuid prefix|book name>",
 		Short:   "Remove a note or a book",
 		Aliases: []string{"rm", "d", "delete"},
 		Example: example,
@@ -77,7 +78,7 @@ func maybeConfirm(message string, defaultValue bool) (bool, error) {
 		return true, nil
 	}
 
-	return ui.Confirm(message, defaultValue)
+	return ui.Confirm(message, "--yes", defaultValue)
 }
 
 func newRun(ctx context.DnoteCtx) infra.RunEFunc {
@@ -91,24 +92,26 @@ func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 			return nil
 		}
 
+		target := args[0]
+
 		// DEPRECATED: Remove in 1.0.0
 		if len(args) == 2 {
-			log.Plain(log.ColorYellow.Sprintf("DEPRECATED: you no longer need to pass book name to the remove command. e.g. `dnote remove 123`.\n\n"))
+			log.Plain(log.ColorYellow.Sprint(i18n.T("deprecated_book_name", "remove")))
 
-			target := args[1]
-			if err := runNote(ctx, target); err != nil {
-				return errors.Wrap(err, "removing the note")
-			}
-
-			return nil
+			target = args[1]
 		}
 
-		target := args[0]
+		rowID, ok, err := database.ResolveNoteRef(ctx.DB, target, false)
+		if err != nil {
+			return errors.Wrap(err, "resolving the note")
+		}
 
-		if utils.IsNumber(target) {
-			if err := runNote(ctx, target); err != nil {
+		if ok {
+			if err := runNote(ctx, rowID); err != nil {
 				return errors.Wrap(err, "removing the note")
 			}
+		} else if len(args) == 2 {
+			return errors.Errorf("note '%s' not found", target)
 		} else {
 			if err := runBook(ctx, target); err != nil {
 				return errors.Wrap(err, "removing the book")
@@ -119,47 +122,30 @@ func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 	}
 }
 
-func runNote(ctx context.DnoteCtx, rowIDArg string) error {
+func runNote(ctx context.DnoteCtx, noteRowID int) error {
 	db := ctx.DB
 
-	noteRowID, err := strconv.Atoi(rowIDArg)
-	if err != nil {
-		return errors.Wrap(err, "invalid rowid")
-	}
-
 	noteInfo, err := database.GetNoteInfo(db, noteRowID)
 	if err != nil {
 		return err
 	}
 
-	output.NoteInfo(noteInfo)
+	output.NoteInfo(ctx, noteInfo)
 
-	ok, err := maybeConfirm("remove this note?", false)
+	ok, err := maybeConfirm(i18n.T("remove.confirm_note"), false)
 	if err != nil {
 		return errors.Wrap(err, "getting confirmation")
 	}
 	if !ok {
-		log.Warnf("aborted by user\n")
+		log.Warn(i18n.T("aborted"))
 		return nil
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		return errors.Wrap(err, "beginning a transaction")
-	}
-
-	if _, err = tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE uuid = ?", true, true, "", noteInfo.UUID); err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "removing the note")
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "comitting transaction")
+	if err := dnote.NewStore(ctx).RemoveNote(noteInfo.UUID); err != nil {
+		return err
 	}
 
-	log.Successf("removed from %s\n", noteInfo.BookLabel)
+	log.Success(i18n.T("remove.note_removed", noteInfo.BookLabel))
 
 	return nil
 }
@@ -167,48 +153,29 @@ func runNote(ctx context.DnoteCtx, rowIDArg string) error {
 func runBook(ctx context.DnoteCtx, bookLabel string) error {
 	db := ctx.DB
 
-	bookUUID, err := database.GetBookUUID(db, bookLabel)
+	bookLabel, err := ui.ResolveBook(ctx, bookLabel)
 	if err != nil {
+		return errors.Wrap(err, "resolving the book")
+	}
+
+	if _, err := database.GetBookUUID(db, bookLabel); err != nil {
 		return errors.Wrap(err, "finding book uuid")
 	}
 
-	ok, err := maybeConfirm(fmt.Sprintf("delete book '%s' and all its notes?", bookLabel), false)
+	ok, err := maybeConfirm(i18n.T("remove.confirm_book", bookLabel), false)
 	if err != nil {
 		return errors.Wrap(err, "getting confirmation")
 	}
 	if !ok {
-		log.Warnf("aborted by user\n")
+		log.Warn(i18n.T("aborted"))
 		return nil
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		return errors.Wrap(err, "beginning a transaction")
-	}
-
-	if _, err = tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE book_uuid = ?", true, true, "", bookUUID); err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "removing notes in the book")
-	}
-
-	// override the label with a random string
-	uniqLabel, err := utils.GenerateUUID()
-	if err != nil {
-		return errors.Wrap(err, "generating uuid to override with")
-	}
-
-	if _, err = tx.Exec("UPDATE books SET deleted = ?, dirty = ?, label = ? WHERE uuid = ?", true, true, uniqLabel, bookUUID); err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "removing the book")
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "committing transaction")
+	if err := dnote.NewStore(ctx).RemoveBook(bookLabel); err != nil {
+		return err
 	}
 
-	log.Success("removed book\n")
+	log.Success(i18n.T("remove.book_removed"))
 
 	return nil
 }
@@ -0,0 +1,129 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package reorder
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var beforeFlag string
+var afterFlag string
+
+var example = `
+ * Move the note 12 right before the note 3 in a book
+ dnote reorder javascript 12 --before 3
+
+ * Move the note 3 right after the note 12 in a book
+ dnote reorder javascript 3 --after 12`
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if beforeFlag == "" && afterFlag == "" {
+		return errors.New("either --before or --after is required")
+	}
+	if beforeFlag != "" && afterFlag != "" {
+		return errors.New("--before and --after cannot be used together")
+	}
+
+	return nil
+}
+
+// NewCmd returns a new reorder command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "reorder <book name> <note id|uuid prefix>",
+		Short:   "Move a note before or after another note in its book",
+		Example: example,
+		Args:    cobra.ExactArgs(2),
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&beforeFlag, "before", "", "", "the id or uuid prefix of the note to move the note before")
+	f.StringVarP(&afterFlag, "after", "", "", "the id or uuid prefix of the note to move the note after")
+
+	return cmd
+}
+
+// findNote returns the info of the note with the given id or uuid prefix in
+// the book with the given name
+func findNote(ctx context.DnoteCtx, bookName, noteRef string) (database.NoteInfo, error) {
+	rowID, ok, err := database.ResolveNoteRef(ctx.DB, noteRef, false)
+	if err != nil {
+		return database.NoteInfo{}, errors.Wrap(err, "resolving the note")
+	}
+	if !ok {
+		return database.NoteInfo{}, errors.Errorf("note '%s' not found. Use its id or a prefix of its uuid of at least %d characters", noteRef, database.MinUUIDPrefixLength)
+	}
+
+	info, err := database.GetNoteInfo(ctx.DB, rowID)
+	if err != nil {
+		return database.NoteInfo{}, errors.Wrap(err, "getting the note")
+	}
+	if info.BookLabel != bookName {
+		return database.NoteInfo{}, errors.Errorf("note '%s' is not in book '%s'", noteRef, bookName)
+	}
+
+	return info, nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		bookName, err := ui.ResolveBook(ctx, args[0])
+		if err != nil {
+			return errors.Wrap(err, "resolving the book")
+		}
+
+		note, err := findNote(ctx, bookName, args[1])
+		if err != nil {
+			return err
+		}
+
+		after := afterFlag != ""
+		targetRef := beforeFlag
+		if after {
+			targetRef = afterFlag
+		}
+
+		target, err := findNote(ctx, bookName, targetRef)
+		if err != nil {
+			return err
+		}
+
+		if _, err := dnote.NewStore(ctx).ReorderNote(note.UUID, target.UUID, after); err != nil {
+			return errors.Wrap(err, "reordering the note")
+		}
+
+		if after {
+			log.Success(i18n.T("reorder.moved_after", note.RowID, target.RowID))
+		} else {
+			log.Success(i18n.T("reorder.moved_before", note.RowID, target.RowID))
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package reorder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+)
+
+func TestReorder(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		// expected is the order of the notes in the js book by their index
+		expected    []int
+		expectedErr string
+	}{
+		{name: "before", args: []string{"js", "3", "--before", "1"}, expected: []int{3, 1, 2}},
+		{name: "after", args: []string{"js", "1", "--after", "3"}, expected: []int{2, 3, 1}},
+		{name: "between", args: []string{"js", "3", "--after", "1"}, expected: []int{1, 3, 2}},
+		{name: "no target", args: []string{"js", "3"}, expected: []int{1, 2, 3}, expectedErr: "either --before or --after is required"},
+		{name: "both targets", args: []string{"js", "3", "--before", "1", "--after", "2"}, expected: []int{1, 2, 3}, expectedErr: "--before and --after cannot be used together"},
+		{name: "note in another book", args: []string{"js", "4", "--before", "1"}, expected: []int{1, 2, 3}, expectedErr: "note '4' is not in book 'js'"},
+		{name: "target in another book", args: []string{"js", "1", "--before", "4"}, expected: []int{1, 2, 3}, expectedErr: "note '4' is not in book 'js'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			f := testutils.NewFixture(t, ctx.DB)
+			js := f.Book("js")
+			css := f.Book("css")
+			f.Note(js, "n1", testutils.With.Position(1))
+			f.Note(js, "n2", testutils.With.Position(2))
+			f.Note(js, "n3", testutils.With.Position(3))
+			f.Note(css, "n4", testutils.With.Position(1))
+
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			if tc.expectedErr == "" {
+				assert.Equal(t, result.Err, nil, "error mismatch")
+			} else if result.Err == nil || !strings.Contains(result.Err.Error(), tc.expectedErr) {
+				t.Errorf("expected an error containing '%s' but got %v", tc.expectedErr, result.Err)
+			}
+
+			var order []int
+			rows, err := ctx.DB.Query("SELECT rowid FROM notes WHERE book_uuid = ? ORDER BY position, rowid", js.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var rowID int
+				if err := rows.Scan(&rowID); err != nil {
+					t.Fatal(err)
+				}
+				order = append(order, rowID)
+			}
+
+			assert.DeepEqual(t, order, tc.expected, "order mismatch")
+
+			var dirty int
+			database.MustScan(t, "counting dirty notes", ctx.DB.QueryRow("SELECT count(*) FROM notes WHERE dirty"), &dirty)
+			assert.Equal(t, dirty, 0, "dirty count mismatch")
+		})
+	}
+}
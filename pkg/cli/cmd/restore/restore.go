@@ -0,0 +1,219 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package restore
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/dnote/dnote/pkg/cli/backup"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/output"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Restore the snapshot of the database taken before the last sync
+ dnote restore --latest-auto
+
+ * Restore a book from a backup archive, leaving the rest of the database as
+   it is
+ dnote restore dnote-20210308T120000Z.tar.gz --book golang
+
+ * Restore a note from the snapshot taken before the last sync
+ dnote restore --latest-auto --note 3a7c1d`
+
+var latestAutoFlag bool
+var yesFlag bool
+var bookFlags []string
+var noteFlags []string
+var preserveUUIDsFlag bool
+
+// NewCmd returns a new restore command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "restore <archive?>",
+		Short:   "Restore the database, or some of its books and notes, from a backup",
+		Example: example,
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&latestAutoFlag, "latest-auto", "", false, "restore the newest snapshot taken by sync.autoBackup")
+	f.BoolVarP(&yesFlag, "yes", "y", false, "Assume yes to the prompts and run in non-interactive mode")
+	f.StringArrayVarP(&bookFlags, "book", "b", nil, "a book in the backup to restore as new notes, leaving the rest of the database as it is")
+	f.StringArrayVarP(&noteFlags, "note", "", nil, "the uuid, or a prefix of it, of a note in the backup to restore as a new note")
+	f.BoolVarP(&preserveUUIDsFlag, "preserve-uuids", "", false, "keep the uuids of the restored notes that are absent here instead of generating new ones")
+
+	return cmd
+}
+
+// isSelective checks if only some books and notes are restored
+func isSelective() bool {
+	return len(bookFlags) > 0 || len(noteFlags) > 0
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 && latestAutoFlag {
+		return errors.New("choose either a backup archive or --latest-auto")
+	}
+	if len(args) > 0 && !isSelective() {
+		return errors.New("choose the books or the notes to restore from the backup archive with --book or --note")
+	}
+	if len(args) == 0 && !latestAutoFlag {
+		return errors.New("choose the snapshot to restore with --latest-auto, or a backup archive to restore some books or notes from")
+	}
+	if preserveUUIDsFlag && !isSelective() {
+		return errors.New("--preserve-uuids requires --book or --note")
+	}
+
+	return nil
+}
+
+// Restore replaces the database with the newest automatic snapshot, after a
+// confirmation unless yes is true. It holds the sync lock so that no sync
+// changes the database meanwhile.
+func Restore(ctx context.DnoteCtx, yes bool) (bool, error) {
+	path, err := latestAutoSnapshot(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	takenAt, err := backup.AutoSnapshotTime(path)
+	if err != nil {
+		return false, err
+	}
+	log.Info(i18n.T("restore.will_restore", output.FormatTime(takenAt, ctx.Clock.Now(), ctx.TimeFormat), path))
+
+	if !yes {
+		ok, err := ui.Confirm(i18n.T("restore.confirm"), "--yes", false)
+		if err != nil {
+			return false, errors.Wrap(err, "getting confirmation")
+		}
+		if !ok {
+			log.Warn(i18n.T("aborted"))
+			return false, nil
+		}
+	}
+
+	if err := infra.AcquireSyncLock(ctx); err != nil {
+		return false, errors.Wrap(err, "acquiring the sync lock")
+	}
+	defer func() {
+		if err := infra.ReleaseSyncLock(ctx); err != nil {
+			log.Errorf("%s\n", errors.Wrap(err, "releasing the sync lock").Error())
+		}
+	}()
+
+	if err := database.Restore(ctx.DB, path); err != nil {
+		return false, errors.Wrap(err, "restoring the database")
+	}
+
+	log.Success(i18n.T("restore.restored"))
+
+	return true, nil
+}
+
+// latestAutoSnapshot returns the path of the newest automatic snapshot
+func latestAutoSnapshot(ctx context.DnoteCtx) (string, error) {
+	dir := backup.AutoDir(ctx.Paths.Cache)
+	path, err := backup.LatestAutoSnapshot(dir)
+	if err != nil {
+		return "", errors.Wrap(err, "finding the newest snapshot")
+	}
+	if path == "" {
+		return "", errors.New(i18n.T("restore.none", dir))
+	}
+
+	return path, nil
+}
+
+// restoreFrom restores the selected books and notes from the backup at the
+// given path as new notes, and prints what was restored and what was skipped
+func restoreFrom(ctx context.DnoteCtx, path string, sel selection) error {
+	dir, err := ioutil.TempDir("", "dnote-restore")
+	if err != nil {
+		return errors.Wrap(err, "creating a temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := backup.OpenDatabase(path, dir)
+	if err != nil {
+		return errors.Wrap(err, "opening the backup")
+	}
+	defer src.Close()
+
+	res, err := restoreSelected(ctx, src, sel)
+	if err != nil {
+		return errors.Wrap(err, "restoring from the backup")
+	}
+
+	labels := []string{}
+	for label := range res.renamed {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		log.Info(i18n.T("restore.book_renamed", label, res.renamed[label]))
+	}
+
+	for _, n := range res.restored {
+		log.Plain(i18n.T("restore.note_restored", database.ShortUUID(n.archivedUUID), n.book, database.ShortUUID(n.uuid)))
+	}
+	for _, uuid := range res.skipped {
+		log.Plain(i18n.T("restore.note_skipped", database.ShortUUID(uuid)))
+	}
+	log.Success(i18n.T("restore.notes_restored", len(res.restored), len(res.skipped)))
+
+	return nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if !isSelective() {
+			_, err := Restore(ctx, yesFlag)
+			return err
+		}
+
+		var path string
+		if len(args) > 0 {
+			path = args[0]
+		} else {
+			var err error
+			if path, err = latestAutoSnapshot(ctx); err != nil {
+				return err
+			}
+		}
+
+		return restoreFrom(ctx, path, selection{
+			books:         bookFlags,
+			notes:         noteFlags,
+			preserveUUIDs: preserveUUIDsFlag,
+		})
+	}
+}
@@ -0,0 +1,337 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package restore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/backup"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+)
+
+func newTestCtx(t *testing.T) context.DnoteCtx {
+	dir := t.TempDir()
+
+	return context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+}
+
+func TestRestore(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	n1 := f.Note(b1, "n1 body")
+
+	if _, err := backup.AutoSnapshot(ctx.DB, backup.AutoDir(ctx.Paths.Cache), 2, testutils.CmdNow); err != nil {
+		t.Fatal(errors.Wrap(err, "taking a snapshot"))
+	}
+	database.MustExec(t, "removing n1", ctx.DB, "DELETE FROM notes WHERE uuid = ?", n1.UUID)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "--latest-auto", "--yes")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "restoring"))
+	}
+
+	assert.Equal(t, strings.Contains(string(result.Stdout), "restored the database"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+	assert.Equal(t, f.MustGetNote(n1.UUID).Body, "n1 body", "body mismatch")
+}
+
+func TestRestore_nonInteractive(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	ui.SetInteractive(false)
+	defer ui.SetInteractive(ui.IsTerminal())
+
+	f := testutils.NewFixture(t, ctx.DB)
+	f.Book("js")
+	if _, err := backup.AutoSnapshot(ctx.DB, backup.AutoDir(ctx.Paths.Cache), 2, testutils.CmdNow); err != nil {
+		t.Fatal(errors.Wrap(err, "taking a snapshot"))
+	}
+	f.Book("css")
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "--latest-auto")
+
+	_, ok := errors.Cause(result.Err).(ui.NonInteractiveError)
+	assert.Equal(t, ok, true, fmt.Sprintf("error mismatch: %v", result.Err))
+	assert.Equal(t, f.MustCountBooks(), 2, "book count mismatch")
+}
+
+func TestRestore_errors(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{}, expected: "--latest-auto"},
+		{args: []string{"--latest-auto", "--yes"}, expected: "no snapshot found"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			assert.NotEqual(t, result.Err, nil, "error mismatch")
+			assert.Equal(t, strings.Contains(result.Err.Error(), tc.expected), true, fmt.Sprintf("error message mismatch: %v", result.Err))
+		})
+	}
+}
+
+const (
+	archiveGolangUUID = "b1000000-0000-4000-8000-000000000000"
+	archiveJSUUID     = "b2000000-0000-4000-8000-000000000000"
+	archiveG1UUID     = "a1000000-0000-4000-8000-000000000000"
+	archiveG2UUID     = "a2000000-0000-4000-8000-000000000000"
+	archiveG3UUID     = "a3000000-0000-4000-8000-000000000000"
+	archiveG4UUID     = "a4000000-0000-4000-8000-000000000000"
+	archiveJ1UUID     = "c1000000-0000-4000-8000-000000000000"
+)
+
+// writeTestArchive writes a backup archive with the books golang and js, and
+// returns its path
+func writeTestArchive(t *testing.T) string {
+	db := database.InitTestDB(t, "dnote.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(t, db)
+
+	database.MustExec(t, "inserting golang", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", archiveGolangUUID, "golang", 1)
+	database.MustExec(t, "inserting js", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", archiveJSUUID, "js", 2)
+	database.MustExec(t, "inserting g1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", archiveG1UUID, archiveGolangUUID, "g1 body", 1, 3)
+	database.MustExec(t, "inserting g2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn) VALUES (?, ?, ?, ?, ?, ?)", archiveG2UUID, archiveGolangUUID, "g2 body", 2, 20, 4)
+	database.MustExec(t, "inserting g3", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", archiveG3UUID, archiveGolangUUID, "g3 body", 3, 5)
+	database.MustExec(t, "inserting g4", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, deleted) VALUES (?, ?, ?, ?, ?, ?)", archiveG4UUID, archiveGolangUUID, "", 4, 6, true)
+	database.MustExec(t, "inserting j1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", archiveJ1UUID, archiveJSUUID, "j1 body", 5, 7)
+
+	path := filepath.Join(t.TempDir(), backup.ArchiveName(testutils.CmdNow))
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating the archive"))
+	}
+	defer f.Close()
+
+	if err := backup.WriteArchive(f, db, filepath.Join(t.TempDir(), "nonexistent"), testutils.CmdNow); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the archive"))
+	}
+
+	return path
+}
+
+// setupOverlap sets up the database with the book golang of the archive, with
+// g1 as it is in the archive and g2 edited since, and with another book named
+// js
+func setupOverlap(t *testing.T, db *database.DB) {
+	database.MustExec(t, "inserting golang", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", archiveGolangUUID, "golang", 1)
+	database.MustExec(t, "inserting js", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "b3000000-0000-4000-8000-000000000000", "js", 8)
+	database.MustExec(t, "inserting g1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", archiveG1UUID, archiveGolangUUID, "g1 body", 1, 3)
+	database.MustExec(t, "inserting g2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn) VALUES (?, ?, ?, ?, ?, ?)", archiveG2UUID, archiveGolangUUID, "g2 edited", 2, 30, 9)
+}
+
+// getBookNotes returns the bodies of the notes in the book with the label, in
+// the order they were added, and whether they are dirty
+func getBookNotes(t *testing.T, db *database.DB, label string) ([]string, []bool) {
+	rows, err := db.Query(`SELECT notes.body, notes.dirty FROM notes
+		INNER JOIN books ON books.uuid = notes.book_uuid
+		WHERE books.label = ? ORDER BY notes.added_on, notes.rowid`, label)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "querying the notes"))
+	}
+	defer rows.Close()
+
+	bodies, dirty := []string{}, []bool{}
+	for rows.Next() {
+		var body string
+		var d bool
+		if err := rows.Scan(&body, &d); err != nil {
+			t.Fatal(errors.Wrap(err, "scanning a row"))
+		}
+
+		bodies = append(bodies, body)
+		dirty = append(dirty, d)
+	}
+
+	return bodies, dirty
+}
+
+func TestRestore_book(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	path := writeTestArchive(t)
+	setupOverlap(t, ctx.DB)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, path, "--book", "golang")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "restoring golang"))
+	}
+
+	stdout := string(result.Stdout)
+	assert.Equal(t, strings.Contains(stdout, "restored a2000000 in golang as "), true, fmt.Sprintf("output mismatch: %s", stdout))
+	assert.Equal(t, strings.Contains(stdout, "restored a3000000 in golang as "), true, fmt.Sprintf("output mismatch: %s", stdout))
+	assert.Equal(t, strings.Contains(stdout, "skipped a1000000, which is already present"), true, fmt.Sprintf("output mismatch: %s", stdout))
+	assert.Equal(t, strings.Contains(stdout, "restored 2 notes, skipped 1 already present"), true, fmt.Sprintf("output mismatch: %s", stdout))
+
+	// the old version of g2 is restored next to the edited one, and the
+	// removed g4 is not restored
+	bodies, dirty := getBookNotes(t, ctx.DB, "golang")
+	assert.DeepEqual(t, bodies, []string{"g1 body", "g2 edited", "g2 body", "g3 body"}, "bodies mismatch")
+	assert.DeepEqual(t, dirty, []bool{false, false, true, true}, "dirty mismatch")
+
+	// the restored notes are given new uuids
+	var count int
+	database.MustScan(t, "counting the notes with the uuid of g3", ctx.DB.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", archiveG3UUID), &count)
+	assert.Equal(t, count, 0, "g3 uuid count mismatch")
+	database.MustScan(t, "counting the books", ctx.DB.QueryRow("SELECT count(*) FROM books"), &count)
+	assert.Equal(t, count, 2, "book count mismatch")
+
+	// restoring again skips all of them
+	result = testutils.RunCmd(t, ctx, NewCmd, path, "--book", "golang")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "restoring golang again"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "restored 0 notes, skipped 3 already present"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+}
+
+func TestRestore_note(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	path := writeTestArchive(t)
+	setupOverlap(t, ctx.DB)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, path, "--note", "c10000")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "restoring j1"))
+	}
+
+	// another book is named js, so a new book is created for j1
+	stdout := string(result.Stdout)
+	assert.Equal(t, strings.Contains(stdout, "a book named js already exists, so its notes are restored into js_2"), true, fmt.Sprintf("output mismatch: %s", stdout))
+	assert.Equal(t, strings.Contains(stdout, "restored 1 notes, skipped 0 already present"), true, fmt.Sprintf("output mismatch: %s", stdout))
+
+	bodies, _ := getBookNotes(t, ctx.DB, "js")
+	assert.DeepEqual(t, bodies, []string{}, "js bodies mismatch")
+	bodies, dirty := getBookNotes(t, ctx.DB, "js_2")
+	assert.DeepEqual(t, bodies, []string{"j1 body"}, "js_2 bodies mismatch")
+	assert.DeepEqual(t, dirty, []bool{true}, "js_2 dirty mismatch")
+
+	var bookDirty bool
+	database.MustScan(t, "getting js_2", ctx.DB.QueryRow("SELECT dirty FROM books WHERE label = ?", "js_2"), &bookDirty)
+	assert.Equal(t, bookDirty, true, "js_2 should be dirty")
+}
+
+func TestRestore_preserveUUIDs(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	path := writeTestArchive(t)
+	setupOverlap(t, ctx.DB)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, path, "--book", "golang", "--note", archiveJ1UUID, "--preserve-uuids")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "restoring"))
+	}
+
+	// g3 and j1 are absent here and keep their uuids, while g2 is not
+	var body string
+	database.MustScan(t, "getting g3", ctx.DB.QueryRow("SELECT body FROM notes WHERE uuid = ?", archiveG3UUID), &body)
+	assert.Equal(t, body, "g3 body", "g3 body mismatch")
+	database.MustScan(t, "getting j1", ctx.DB.QueryRow("SELECT body FROM notes WHERE uuid = ?", archiveJ1UUID), &body)
+	assert.Equal(t, body, "j1 body", "j1 body mismatch")
+	database.MustScan(t, "getting g2", ctx.DB.QueryRow("SELECT body FROM notes WHERE uuid = ?", archiveG2UUID), &body)
+	assert.Equal(t, body, "g2 edited", "g2 body mismatch")
+
+	var count int
+	database.MustScan(t, "counting the restored g2", ctx.DB.QueryRow("SELECT count(*) FROM notes WHERE body = ? AND dirty", "g2 body"), &count)
+	assert.Equal(t, count, 1, "restored g2 count mismatch")
+}
+
+func TestRestore_latestAutoSelective(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	n1 := f.Note(b1, "n1 body")
+	f.Note(b1, "n2 body")
+
+	if _, err := backup.AutoSnapshot(ctx.DB, backup.AutoDir(ctx.Paths.Cache), 2, testutils.CmdNow); err != nil {
+		t.Fatal(errors.Wrap(err, "taking a snapshot"))
+	}
+	database.MustExec(t, "removing n1", ctx.DB, "DELETE FROM notes WHERE uuid = ?", n1.UUID)
+	f.Note(b1, "n3 body")
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "--latest-auto", "--note", n1.UUID)
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "restoring n1"))
+	}
+
+	// only n1 is restored, and n3 added since the snapshot is kept
+	var count int
+	database.MustScan(t, "counting the notes", ctx.DB.QueryRow("SELECT count(*) FROM notes"), &count)
+	assert.Equal(t, count, 3, "note count mismatch")
+	database.MustScan(t, "counting the restored n1", ctx.DB.QueryRow("SELECT count(*) FROM notes WHERE body = ? AND uuid != ?", "n1 body", n1.UUID), &count)
+	assert.Equal(t, count, 1, "restored n1 count mismatch")
+}
+
+func TestRestore_selectiveErrors(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	path := writeTestArchive(t)
+	setupOverlap(t, ctx.DB)
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{path}, expected: "--book or --note"},
+		{args: []string{path, "--latest-auto", "--book", "golang"}, expected: "either"},
+		{args: []string{"--latest-auto", "--preserve-uuids"}, expected: "--preserve-uuids requires"},
+		{args: []string{path, "--book", "css"}, expected: "book 'css' not found in the backup"},
+		{args: []string{path, "--note", "d10000"}, expected: "note 'd10000' not found in the backup"},
+		// a removed note is not restored
+		{args: []string{path, "--note", archiveG4UUID}, expected: "not found in the backup"},
+		{args: []string{path, "--note", "a"}, expected: "invalid uuid prefix"},
+		{args: []string{filepath.Join(t.TempDir(), "nonexistent.tar.gz"), "--book", "golang"}, expected: "opening"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			assert.NotEqual(t, result.Err, nil, "error mismatch")
+			assert.Equal(t, strings.Contains(result.Err.Error(), tc.expected), true, fmt.Sprintf("error message mismatch: %v", result.Err))
+		})
+	}
+
+	// nothing is restored when any of the selection is not found
+	bodies, _ := getBookNotes(t, ctx.DB, "golang")
+	assert.DeepEqual(t, bodies, []string{"g1 body", "g2 edited"}, "bodies mismatch")
+}
@@ -0,0 +1,325 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package restore
+
+import (
+	"crypto/sha256"
+	"database/sql"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+// selection is the books and the notes to restore from a backup
+type selection struct {
+	// books are the labels of the books in the backup
+	books []string
+	// notes are the uuids, or prefixes of them, of the notes in the backup
+	notes []string
+	// preserveUUIDs keeps the uuids of the notes that are absent locally
+	preserveUUIDs bool
+}
+
+// archivedNote is a note in a backup
+type archivedNote struct {
+	uuid      string
+	bookUUID  string
+	bookLabel string
+	body      string
+	addedOn   int64
+	editedOn  int64
+}
+
+// restoredNote is a note restored from a backup
+type restoredNote struct {
+	// archivedUUID is the uuid of the note in the backup
+	archivedUUID string
+	uuid         string
+	book         string
+}
+
+// selectiveResult is the outcome of a selective restore
+type selectiveResult struct {
+	restored []restoredNote
+	// skipped are the uuids in the backup of the notes already present
+	skipped []string
+	// renamed maps the labels of the books in the backup that were restored
+	// under another label to the new labels
+	renamed map[string]string
+}
+
+// hashBody returns the hash of the body by which the notes already present
+// are found
+func hashBody(body string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(utils.NormalizeBody(body)))
+}
+
+const archivedNoteColumns = `notes.uuid, notes.book_uuid, books.label, notes.body, notes.added_on, notes.edited_on
+	FROM notes INNER JOIN books ON books.uuid = notes.book_uuid`
+
+func scanArchivedNotes(rows *sql.Rows) ([]archivedNote, error) {
+	defer rows.Close()
+
+	ret := []archivedNote{}
+	for rows.Next() {
+		var n archivedNote
+		if err := rows.Scan(&n.uuid, &n.bookUUID, &n.bookLabel, &n.body, &n.addedOn, &n.editedOn); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		ret = append(ret, n)
+	}
+
+	return ret, errors.Wrap(rows.Err(), "iterating rows")
+}
+
+// getSelectedNotes returns the notes in the backup that are selected, each
+// once, in the order of the selection. The notes of a book are in the order
+// they were added.
+func getSelectedNotes(src *database.DB, sel selection) ([]archivedNote, error) {
+	ret := []archivedNote{}
+	seen := map[string]bool{}
+	add := func(notes []archivedNote) {
+		for _, n := range notes {
+			if !seen[n.uuid] {
+				seen[n.uuid] = true
+				ret = append(ret, n)
+			}
+		}
+	}
+
+	for _, label := range sel.books {
+		var count int
+		if err := src.QueryRow("SELECT count(*) FROM books WHERE label = ? AND deleted = false", label).Scan(&count); err != nil {
+			return nil, errors.Wrapf(err, "finding the book %s", label)
+		}
+		if count == 0 {
+			return nil, errors.Errorf("book '%s' not found in the backup", label)
+		}
+
+		rows, err := src.Query("SELECT "+archivedNoteColumns+`
+			WHERE books.label = ? AND books.deleted = false AND notes.deleted = false
+			ORDER BY notes.added_on, notes.uuid`, label)
+		if err != nil {
+			return nil, errors.Wrapf(err, "querying the notes of %s", label)
+		}
+		notes, err := scanArchivedNotes(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting the notes of %s", label)
+		}
+
+		add(notes)
+	}
+
+	for _, prefix := range sel.notes {
+		uuid, err := database.ResolveNoteUUID(src, prefix, false)
+		if err == sql.ErrNoRows {
+			return nil, errors.Errorf("note '%s' not found in the backup", prefix)
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "finding the note %s", prefix)
+		}
+
+		rows, err := src.Query("SELECT "+archivedNoteColumns+" WHERE notes.uuid = ?", uuid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "querying the note %s", uuid)
+		}
+		notes, err := scanArchivedNotes(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting the note %s", uuid)
+		}
+		if len(notes) == 0 {
+			return nil, errors.Errorf("the book of the note '%s' is not found in the backup", prefix)
+		}
+
+		add(notes)
+	}
+
+	return ret, nil
+}
+
+// restorer restores the notes into the database in a transaction
+type restorer struct {
+	ctx    context.DnoteCtx
+	tx     *database.DB
+	sel    selection
+	hashes map[[sha256.Size]byte]bool
+	// books maps the uuids of the books in the backup to the books that
+	// their notes are restored into
+	books map[string]database.Book
+	res   selectiveResult
+}
+
+func newRestorer(ctx context.DnoteCtx, tx *database.DB, sel selection) (*restorer, error) {
+	rows, err := tx.Query("SELECT body FROM notes WHERE deleted = false")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	hashes := map[[sha256.Size]byte]bool{}
+	for rows.Next() {
+		var body string
+		if err := rows.Scan(&body); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		hashes[hashBody(body)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating rows")
+	}
+
+	return &restorer{
+		ctx:    ctx,
+		tx:     tx,
+		sel:    sel,
+		hashes: hashes,
+		books:  map[string]database.Book{},
+		res:    selectiveResult{renamed: map[string]string{}},
+	}, nil
+}
+
+// getBook returns the uuid and the label of the book to restore the note
+// into. The book is the same as in the backup if it still exists. Otherwise a
+// book is created with the label in the backup, or a new label if another
+// book has the label.
+func (r *restorer) getBook(n archivedNote) (string, string, error) {
+	if b, ok := r.books[n.bookUUID]; ok {
+		return b.UUID, b.Label, nil
+	}
+
+	var label string
+	err := r.tx.QueryRow("SELECT label FROM books WHERE uuid = ? AND deleted = false", n.bookUUID).Scan(&label)
+	if err == nil {
+		r.books[n.bookUUID] = database.Book{UUID: n.bookUUID, Label: label}
+		return n.bookUUID, label, nil
+	} else if err != sql.ErrNoRows {
+		return "", "", errors.Wrapf(err, "finding the book %s", n.bookUUID)
+	}
+
+	label = n.bookLabel
+	var count int
+	if err := r.tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", label).Scan(&count); err != nil {
+		return "", "", errors.Wrap(err, "counting books")
+	}
+	if count > 0 {
+		if label, err = database.ResolveLabel(r.tx, label); err != nil {
+			return "", "", errors.Wrap(err, "resolving the book label")
+		}
+		r.res.renamed[n.bookLabel] = label
+	}
+
+	uuid, err := utils.GenerateUUID()
+	if err != nil {
+		return "", "", errors.Wrap(err, "generating uuid")
+	}
+	b := database.NewBook(uuid, label, 0, false, true)
+	if err := b.Insert(r.tx); err != nil {
+		return "", "", errors.Wrap(err, "creating the book")
+	}
+	r.books[n.bookUUID] = b
+
+	return uuid, label, nil
+}
+
+// noteUUID returns the uuid of the restored note, which is that in the backup
+// if it is preserved and no note here has it, removed or not
+func (r *restorer) noteUUID(n archivedNote) (string, error) {
+	if r.sel.preserveUUIDs {
+		var count int
+		if err := r.tx.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", n.uuid).Scan(&count); err != nil {
+			return "", errors.Wrapf(err, "finding %s", n.uuid)
+		}
+		if count == 0 {
+			return n.uuid, nil
+		}
+	}
+
+	ret, err := utils.GenerateUUID()
+	if err != nil {
+		return "", errors.Wrap(err, "generating uuid")
+	}
+
+	return ret, nil
+}
+
+// restoreNote adds the note as a new note to be synced, unless a note with the
+// same body is already present
+func (r *restorer) restoreNote(n archivedNote) error {
+	hash := hashBody(n.body)
+	if r.hashes[hash] {
+		r.res.skipped = append(r.res.skipped, n.uuid)
+		return nil
+	}
+
+	bookUUID, label, err := r.getBook(n)
+	if err != nil {
+		return err
+	}
+	uuid, err := r.noteUUID(n)
+	if err != nil {
+		return err
+	}
+
+	note := database.NewNote(uuid, bookUUID, utils.NormalizeBody(n.body), n.addedOn, n.editedOn, 0, false, false, true)
+	note.OriginDevice = r.ctx.DeviceID
+	if note.Position, err = database.NextNotePosition(r.tx, bookUUID); err != nil {
+		return errors.Wrap(err, "finding the position")
+	}
+	if err := note.Insert(r.tx); err != nil {
+		return errors.Wrap(err, "creating the note")
+	}
+
+	r.hashes[hash] = true
+	r.res.restored = append(r.res.restored, restoredNote{archivedUUID: n.uuid, uuid: uuid, book: label})
+
+	return nil
+}
+
+// restoreSelected copies the selected notes in the backup into the database
+// as new notes to be synced, in a single transaction. A note whose body is the
+// same as that of a note already here is skipped.
+func restoreSelected(ctx context.DnoteCtx, src *database.DB, sel selection) (selectiveResult, error) {
+	notes, err := getSelectedNotes(src, sel)
+	if err != nil {
+		return selectiveResult{}, err
+	}
+
+	var res selectiveResult
+	err = infra.WithTx(ctx.DB, func(tx *database.DB) error {
+		r, err := newRestorer(ctx, tx, sel)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range notes {
+			if err := r.restoreNote(n); err != nil {
+				return errors.Wrapf(err, "restoring the note %s", n.uuid)
+			}
+		}
+
+		res = r.res
+		return nil
+	})
+
+	return res, err
+}
@@ -0,0 +1,212 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package retention implements the command that applies the retention
+// policies of the books
+package retention
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/retention"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var applyExample = `
+ * Remove the notes that expired under the retention policies of the books
+ dnote retention apply
+
+ * Remove them without a confirmation, e.g. in a script
+ dnote retention apply --yes`
+
+var yesFlag bool
+
+// NewCmd returns a new retention command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "retention",
+		Short: "Apply the retention policies of the books",
+	}
+
+	cmd.AddCommand(newApplyCmd(ctx))
+
+	return cmd
+}
+
+func newApplyCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "apply",
+		Short:   "Remove the notes that expired under the retention policies",
+		Example: applyExample,
+		Args:    cobra.NoArgs,
+		RunE:    newApplyRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&yesFlag, "yes", "y", false, "Assume yes to the prompts and run in non-interactive mode")
+
+	return cmd
+}
+
+// expiredNote is a note to be removed by a retention policy
+type expiredNote struct {
+	RowID     int
+	UUID      string
+	BookLabel string
+	Body      string
+}
+
+// getBookNotes returns the notes of the book with the given uuid that are not
+// removed, and their bodies by uuid
+func getBookNotes(db *database.DB, bookUUID string) ([]retention.Note, map[string]string, error) {
+	rows, err := db.Query("SELECT rowid, uuid, body, added_on, edited_on FROM notes WHERE book_uuid = ? AND deleted = false", bookUUID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "querying the notes")
+	}
+	defer rows.Close()
+
+	notes := []retention.Note{}
+	bodies := map[string]string{}
+	for rows.Next() {
+		var n retention.Note
+		var body string
+		if err := rows.Scan(&n.RowID, &n.UUID, &body, &n.AddedOn, &n.EditedOn); err != nil {
+			return nil, nil, errors.Wrap(err, "scanning a note")
+		}
+
+		notes = append(notes, n)
+		bodies[n.UUID] = body
+	}
+
+	return notes, bodies, rows.Err()
+}
+
+// plan returns the notes that expired under the retention policies of the
+// books, grouped by book and from the least recently changed
+func plan(ctx context.DnoteCtx) ([]expiredNote, error) {
+	policies, err := database.GetBookRetentions(ctx.DB)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the retention policies")
+	}
+
+	now := ctx.Clock.Now()
+
+	ret := []expiredNote{}
+	for _, r := range policies {
+		notes, bodies, err := getBookNotes(ctx.DB, r.BookUUID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting the notes of %s", r.BookLabel)
+		}
+
+		p := retention.Policy{MaxAge: r.MaxAge, MaxCount: r.MaxCount}
+		for _, n := range retention.Expired(p, notes, now) {
+			ret = append(ret, expiredNote{
+				RowID:     n.RowID,
+				UUID:      n.UUID,
+				BookLabel: r.BookLabel,
+				Body:      bodies[n.UUID],
+			})
+		}
+	}
+
+	return ret, nil
+}
+
+// firstLine returns the first line of a note body to identify the note
+func firstLine(body string) string {
+	return strings.SplitN(strings.TrimSpace(body), "\n", 2)[0]
+}
+
+// printPlan prints the notes to be removed under the label of their book
+func printPlan(notes []expiredNote) {
+	var label string
+	for _, n := range notes {
+		if n.BookLabel != label {
+			label = n.BookLabel
+			log.Plain(label + "\n")
+		}
+
+		log.Plain(fmt.Sprintf("  (%d) %s\n", n.RowID, firstLine(n.Body)))
+	}
+}
+
+// Apply removes the notes that expired under the retention policies of the
+// books, after printing them. It asks for a confirmation unless yes is true,
+// and fails if it cannot ask. The removals are uploaded on the next sync. It
+// returns the number of the notes removed.
+func Apply(ctx context.DnoteCtx, yes bool) (int, error) {
+	locked, err := infra.IsSyncLocked(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "checking the sync lock")
+	}
+	if locked {
+		return 0, errors.Wrap(infra.ErrSyncLocked, "cannot apply the retention policies")
+	}
+
+	notes, err := plan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(notes) == 0 {
+		log.Info(i18n.T("retention.nothing"))
+		return 0, nil
+	}
+
+	log.Info(i18n.T("retention.will_remove", len(notes)))
+	printPlan(notes)
+
+	if !yes {
+		ok, err := ui.Confirm(i18n.T("retention.confirm"), "--yes", false)
+		if err != nil {
+			return 0, errors.Wrap(err, "getting confirmation")
+		}
+		if !ok {
+			log.Warn(i18n.T("aborted"))
+			return 0, nil
+		}
+	}
+
+	uuids := []string{}
+	for _, n := range notes {
+		uuids = append(uuids, n.UUID)
+	}
+
+	count, err := dnote.NewStore(ctx).ExpireNotes(uuids)
+	if err != nil {
+		return 0, errors.Wrap(err, "removing the notes")
+	}
+
+	log.Success(i18n.T("retention.removed", count))
+
+	return count, nil
+}
+
+func newApplyRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		_, err := Apply(ctx, yesFlag)
+		return err
+	}
+}
@@ -0,0 +1,119 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package retention
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+)
+
+func newTestCtx(t *testing.T) context.DnoteCtx {
+	dir := t.TempDir()
+
+	return context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+}
+
+// setupRetention keeps 2 notes of 30 days or less in inbox, which has an old
+// note, two recent ones and a newer one, and has a book without a policy
+func setupRetention(t *testing.T, ctx context.DnoteCtx) (*testutils.Fixture, []database.Note) {
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("inbox")
+	b2 := f.Book("js")
+
+	day := 24 * time.Hour
+	notes := []database.Note{
+		f.Note(b1, "old note\nsecond line", testutils.With.AddedOn(testutils.CmdNow.Add(-40*day).UnixNano())),
+		f.Note(b1, "recent note", testutils.With.AddedOn(testutils.CmdNow.Add(-10*day).UnixNano())),
+		f.Note(b1, "edited note", testutils.With.AddedOn(testutils.CmdNow.Add(-50*day).UnixNano()), testutils.With.EditedOn(testutils.CmdNow.Add(-5*day).UnixNano())),
+		f.Note(b1, "new note", testutils.With.AddedOn(testutils.CmdNow.Add(-1*day).UnixNano())),
+		f.Note(b2, "js note", testutils.With.AddedOn(testutils.CmdNow.Add(-400*day).UnixNano())),
+	}
+
+	if err := database.SetBookRetention(ctx.DB, b1.UUID, 30*day, 2); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the retention policy"))
+	}
+
+	return f, notes
+}
+
+func TestApply(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f, notes := setupRetention(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "apply", "--yes")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "applying"))
+	}
+
+	out := string(result.Stdout)
+	assert.Equal(t, strings.Contains(out, fmt.Sprintf("(%d) old note\n", notes[0].RowID)), true, fmt.Sprintf("old note output mismatch: %s", out))
+	assert.Equal(t, strings.Contains(out, fmt.Sprintf("(%d) recent note\n", notes[1].RowID)), true, fmt.Sprintf("recent note output mismatch: %s", out))
+	assert.Equal(t, strings.Contains(out, "second line"), false, fmt.Sprintf("output mismatch: %s", out))
+
+	expected := []bool{true, true, false, false, false}
+	for i, n := range notes {
+		note := f.MustGetNote(n.UUID)
+		assert.Equal(t, note.Deleted, expected[i], fmt.Sprintf("deleted mismatch for note %d", i))
+		assert.Equal(t, note.Dirty, expected[i], fmt.Sprintf("dirty mismatch for note %d", i))
+	}
+
+	var actionCount int
+	database.MustScan(t, "counting the actions", ctx.DB.QueryRow("SELECT count(*) FROM actions WHERE actor = ?", database.ActorRetention), &actionCount)
+	assert.Equal(t, actionCount, 2, "action count mismatch")
+
+	t.Run("nothing to remove", func(t *testing.T) {
+		result := testutils.RunCmd(t, ctx, NewCmd, "apply", "--yes")
+		if result.Err != nil {
+			t.Fatal(errors.Wrap(result.Err, "applying"))
+		}
+
+		assert.Equal(t, strings.Contains(string(result.Stdout), "no notes expired"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+	})
+}
+
+func TestApply_nonInteractive(t *testing.T) {
+	ctx := newTestCtx(t)
+	defer context.TeardownTestCtx(t, ctx)
+
+	ui.SetInteractive(false)
+	defer ui.SetInteractive(ui.IsTerminal())
+
+	f, notes := setupRetention(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "apply")
+
+	_, ok := errors.Cause(result.Err).(ui.NonInteractiveError)
+	assert.Equal(t, ok, true, fmt.Sprintf("error mismatch: %v", result.Err))
+	// the notes are printed before the confirmation
+	assert.Equal(t, strings.Contains(string(result.Stdout), "old note"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+
+	for i, n := range notes {
+		assert.Equal(t, f.MustGetNote(n.UUID).Deleted, false, fmt.Sprintf("deleted mismatch for note %d", i))
+	}
+}
@@ -19,14 +19,87 @@
 package root
 
 import (
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+var quietFlag bool
+var verboseFlag bool
+var noAliasFlag bool
+var logFileFlag string
+var nonInteractiveFlag bool
+var noOnboardingFlag bool
+
 var root = &cobra.Command{
-	Use:           "dnote",
-	Short:         "Dnote - a simple command line notebook",
-	SilenceErrors: true,
-	SilenceUsage:  true,
+	Use:               "dnote",
+	Short:             "Dnote - a simple command line notebook",
+	SilenceErrors:     true,
+	SilenceUsage:      true,
+	PersistentPreRunE: preRun,
+}
+
+func init() {
+	f := root.PersistentFlags()
+	f.BoolVarP(&quietFlag, "quiet", "q", false, "suppress all output except for errors and the requested data")
+	f.BoolVarP(&verboseFlag, "verbose", "v", false, "print detailed messages to stderr")
+	f.BoolVarP(&noAliasFlag, "no-alias", "", false, "do not expand command aliases")
+	f.StringVarP(&logFileFlag, "log-file", "", "", "write the log file to the given path for troubleshooting")
+	f.BoolVarP(&nonInteractiveFlag, "non-interactive", "", false, "fail instead of prompting. Implied if the standard input is not a terminal")
+	f.BoolVarP(&noOnboardingFlag, "no-onboarding", "", false, "skip the setup on the first run and use the defaults")
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if err := setLogLevel(cmd, args); err != nil {
+		return err
+	}
+
+	setLogFile()
+	setInteractive(cmd)
+
+	return nil
+}
+
+// setInteractive sets whether the user can be prompted based on the global
+// flag. Without the flag, the user is prompted only if the standard input is
+// a terminal, and --non-interactive=false prompts even if it is not.
+func setInteractive(cmd *cobra.Command) {
+	if cmd.Flags().Changed("non-interactive") {
+		ui.SetInteractive(!nonInteractiveFlag)
+	}
+}
+
+// setLogLevel sets the level of the logger based on the global flags
+func setLogLevel(cmd *cobra.Command, args []string) error {
+	if quietFlag && verboseFlag {
+		return errors.New("--quiet and --verbose cannot be used together")
+	}
+
+	if quietFlag {
+		log.SetLevel(log.LevelQuiet)
+	} else if verboseFlag {
+		log.SetLevel(log.LevelVerbose)
+	}
+
+	return nil
+}
+
+// setLogFile writes the log file to the path given by the flag, enabling it at
+// the info level unless a level is configured
+func setLogFile() {
+	if logFileFlag == "" {
+		return
+	}
+
+	fileLogger := log.DefaultFile()
+	fileLogger.SetPath(logFileFlag)
+	if fileLogger.Level() == log.FileLevelOff {
+		fileLogger.SetLevel(log.FileLevelInfo)
+	}
 }
 
 // Register adds a new command
@@ -34,7 +107,138 @@ func Register(cmd *cobra.Command) {
 	root.AddCommand(cmd)
 }
 
-// Execute runs the main command
-func Execute() error {
+// IsBuiltin checks if the given name refers to a built-in command
+func IsBuiltin(name string) bool {
+	switch name {
+	case "help", "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return true
+	}
+
+	for _, cmd := range root.Commands() {
+		if cmd.Name() == name || cmd.HasAlias(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CommandIndex returns the index of the command name in the given command line
+// arguments, or -1 if there is none. The values of the global flags that take
+// one, such as --log-file, are skipped so that they are not taken for the
+// command name.
+func CommandIndex(args []string) int {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return i
+		}
+
+		if takesValue(arg) {
+			i++
+		}
+	}
+
+	return -1
+}
+
+// takesValue checks if the given argument is a global flag whose value is given
+// in the next argument
+func takesValue(arg string) bool {
+	if strings.Contains(arg, "=") {
+		return false
+	}
+
+	var flag *pflag.Flag
+	if strings.HasPrefix(arg, "--") {
+		flag = root.PersistentFlags().Lookup(arg[2:])
+	} else if len(arg) == 2 {
+		flag = root.PersistentFlags().ShorthandLookup(arg[1:])
+	}
+
+	// the boolean flags have a default for when no value is given
+	return flag != nil && flag.NoOptDefVal == ""
+}
+
+// readOnlyCmds are the names and aliases of the commands that do not modify
+// the database
+var readOnlyCmds = map[string]bool{
+	"ls": true, "l": true, "notes": true,
+	"view": true, "v": true,
+	"find": true, "f": true,
+	"cat": true, "c": true,
+	"status": true,
+	"last":   true,
+}
+
+// IsReadOnly checks if the command given by the command line arguments does not
+// modify the database
+func IsReadOnly(args []string) bool {
+	idx := CommandIndex(args)
+	if idx == -1 {
+		return false
+	}
+
+	return readOnlyCmds[args[idx]]
+}
+
+// SetVersion sets the version printed by the --version flag
+func SetVersion(version string) {
+	root.Version = version
+	root.SetVersionTemplate("dnote {{.Version}}\n")
+}
+
+// standaloneCmds are the names of the commands that use neither the database nor
+// the config
+var standaloneCmds = map[string]bool{
+	"help": true, "completion": true, "version": true,
+	cobra.ShellCompRequestCmd: true, cobra.ShellCompNoDescRequestCmd: true,
+}
+
+// NeedsEnv checks if the command given by the command line arguments uses the
+// database or the config. Printing the version or the help of the main command
+// does not, so that it is fast and works even if the database is unusable.
+func NeedsEnv(args []string) bool {
+	idx := CommandIndex(args)
+
+	flags := args
+	if idx != -1 {
+		flags = args[:idx]
+	}
+	for _, arg := range flags {
+		switch arg {
+		case "--version", "-h", "--help":
+			return false
+		}
+	}
+
+	// without a command, the help is printed
+	if idx == -1 {
+		return false
+	}
+
+	return !standaloneCmds[args[idx]]
+}
+
+// ShouldOnboard checks if the command given by the command line arguments may
+// set up Dnote on the first run. The main command without arguments does, so
+// that a new user is guided instead of shown the help, and so do the commands
+// that use the database or the config, except for printing their help.
+// --no-onboarding and --non-interactive skip the setup.
+func ShouldOnboard(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "--no-onboarding", "--no-onboarding=true", "--non-interactive", "--non-interactive=true", "-h", "--help":
+			return false
+		}
+	}
+
+	return len(args) == 0 || NeedsEnv(args)
+}
+
+// Execute runs the main command with the given arguments
+func Execute(args []string) error {
+	root.SetArgs(args)
+
 	return root.Execute()
 }
@@ -0,0 +1,131 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package root
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestCommandIndex(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected int
+	}{
+		{args: []string{}, expected: -1},
+		{args: []string{"ls"}, expected: 0},
+		{args: []string{"--verbose", "ls"}, expected: 1},
+		{args: []string{"-q", "ls", "js"}, expected: 1},
+		{args: []string{"--log-file", "x.log", "ls"}, expected: 2},
+		{args: []string{"--log-file=x.log", "ls"}, expected: 1},
+		{args: []string{"--verbose", "--log-file", "x.log", "--no-alias", "ls"}, expected: 4},
+		{args: []string{"--log-file", "x.log"}, expected: -1},
+		{args: []string{"--version"}, expected: -1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.args), func(t *testing.T) {
+			assert.Equal(t, CommandIndex(tc.args), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestIsReadOnly(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected bool
+	}{
+		{args: []string{}, expected: false},
+		{args: []string{"ls"}, expected: true},
+		{args: []string{"ls", "js"}, expected: true},
+		{args: []string{"--verbose", "view", "js"}, expected: true},
+		{args: []string{"f", "-b", "js", "foo"}, expected: true},
+		{args: []string{"cat", "js", "1"}, expected: true},
+		{args: []string{"status", "--format", "json"}, expected: true},
+		{args: []string{"last", "js"}, expected: true},
+		{args: []string{"add", "js", "-c", "foo"}, expected: false},
+		{args: []string{"append", "js", "foo"}, expected: false},
+		{args: []string{"sync"}, expected: false},
+		{args: []string{"--no-alias", "til"}, expected: false},
+		{args: []string{"--log-file", "x.log", "ls"}, expected: true},
+		{args: []string{"--log-file", "ls", "sync"}, expected: false},
+		{args: []string{"--log-file=x.log", "ls"}, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.args), func(t *testing.T) {
+			assert.Equal(t, IsReadOnly(tc.args), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestNeedsEnv(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected bool
+	}{
+		{args: []string{}, expected: false},
+		{args: []string{"--version"}, expected: false},
+		{args: []string{"-h"}, expected: false},
+		{args: []string{"--help"}, expected: false},
+		{args: []string{"version"}, expected: false},
+		{args: []string{"help", "add"}, expected: false},
+		{args: []string{"completion", "bash"}, expected: false},
+		{args: []string{"__complete", "view", ""}, expected: false},
+		{args: []string{"--verbose", "version"}, expected: false},
+		{args: []string{"ls"}, expected: true},
+		{args: []string{"add", "--help"}, expected: true},
+		{args: []string{"--verbose", "view", "js"}, expected: true},
+		{args: []string{"til"}, expected: true},
+		{args: []string{"--log-file", "x.log", "version"}, expected: false},
+		{args: []string{"--log-file", "version", "ls"}, expected: true},
+		{args: []string{"--log-file", "x.log"}, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.args), func(t *testing.T) {
+			assert.Equal(t, NeedsEnv(tc.args), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestShouldOnboard(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected bool
+	}{
+		{args: []string{}, expected: true},
+		{args: []string{"ls"}, expected: true},
+		{args: []string{"add", "js", "-c", "foo"}, expected: true},
+		{args: []string{"--version"}, expected: false},
+		{args: []string{"version"}, expected: false},
+		{args: []string{"add", "--help"}, expected: false},
+		{args: []string{"--no-onboarding"}, expected: false},
+		{args: []string{"add", "js", "--no-onboarding"}, expected: false},
+		{args: []string{"--non-interactive", "ls"}, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.args), func(t *testing.T) {
+			assert.Equal(t, ShouldOnboard(tc.args), tc.expected, "result mismatch")
+		})
+	}
+}
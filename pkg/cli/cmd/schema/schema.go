@@ -0,0 +1,65 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package schema
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/migrate"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Print the schema of the local database
+ dnote schema
+
+ * List the stable columns
+ dnote schema | jq '.tables[] | .name as $t | .columns[] | select(.stability == "stable") | "\($t).\(.name)"'`
+
+// NewCmd returns a new schema command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the schema of the local database",
+		Long: `Print the schema version and the tables of the local database in JSON, for the tools that read the database directly.
+
+A column is either stable or internal. A stable column is never renamed, removed, or repurposed, and new columns are only added. An internal column may change in any release.`,
+		Example: example,
+		Args:    cobra.NoArgs,
+		RunE:    newRun(ctx),
+	}
+
+	return cmd
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(migrate.Describe()); err != nil {
+			return errors.Wrap(err, "encoding the schema")
+		}
+
+		return nil
+	}
+}
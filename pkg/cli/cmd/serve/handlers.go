@@ -0,0 +1,407 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package serve
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/cmd/find"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+)
+
+// pagination
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// maxBodySize is the maximum size of a request body
+const maxBodySize = 1 << 20
+
+// bookResponse is a book in a response
+type bookResponse struct {
+	UUID      string `json:"uuid"`
+	Label     string `json:"label"`
+	NoteCount int    `json:"note_count"`
+}
+
+// noteResponse is a note in a response
+type noteResponse struct {
+	UUID         string `json:"uuid"`
+	RowID        int    `json:"rowid"`
+	Book         string `json:"book"`
+	Content      string `json:"content"`
+	AddedOn      int64  `json:"added_on"`
+	EditedOn     int64  `json:"edited_on"`
+	ViewCount    int    `json:"view_count"`
+	LastViewedAt int64  `json:"last_viewed_at"`
+}
+
+func presentNote(info database.NoteInfo) noteResponse {
+	return noteResponse{
+		UUID:         info.UUID,
+		RowID:        info.RowID,
+		Book:         info.BookLabel,
+		Content:      info.Content,
+		AddedOn:      info.AddedOn,
+		EditedOn:     info.EditedOn,
+		ViewCount:    info.ViewCount,
+		LastViewedAt: info.LastViewedAt,
+	}
+}
+
+// server serves the notes in the local database
+type server struct {
+	ctx        context.DnoteCtx
+	token      string
+	allowWrite bool
+	mux        *http.ServeMux
+}
+
+func newServer(ctx context.DnoteCtx, token string, allowWrite bool) *server {
+	s := &server{
+		ctx:        ctx,
+		token:      token,
+		allowWrite: allowWrite,
+		mux:        http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/books", s.getBooks)
+	s.mux.HandleFunc("/books/", s.getBookNotes)
+	s.mux.HandleFunc("/notes", s.createNote)
+	s.mux.HandleFunc("/notes/", s.getNote)
+	s.mux.HandleFunc("/search", s.search)
+
+	return s
+}
+
+// ServeHTTP requires the session token on every request
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		respondError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func respondJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Verbosef("writing a response: %s\n", err.Error())
+	}
+}
+
+func respondError(w http.ResponseWriter, status int, msg string) {
+	respondJSON(w, status, map[string]string{"error": msg})
+}
+
+// respondInternalError logs the error and responds without its details
+func respondInternalError(w http.ResponseWriter, err error) {
+	log.Errorf("%s\n", err.Error())
+	respondError(w, http.StatusInternalServerError, "internal error")
+}
+
+// checkMethod responds with an error unless the request has the method
+func checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+
+	return true
+}
+
+// parseIntParam parses a non-negative integer query parameter
+func parseIntParam(q url.Values, key string, defaultVal int) (int, error) {
+	s := q.Get(key)
+	if s == "" {
+		return defaultVal, nil
+	}
+
+	ret, err := strconv.Atoi(s)
+	if err != nil || ret < 0 {
+		return 0, errors.Errorf("invalid %s '%s'", key, s)
+	}
+
+	return ret, nil
+}
+
+// parsePage parses the limit and the offset of a page
+func parsePage(q url.Values) (int, int, error) {
+	limit, err := parseIntParam(q, "limit", defaultLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+	if limit == 0 || limit > maxLimit {
+		limit = maxLimit
+	}
+
+	offset, err := parseIntParam(q, "offset", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return limit, offset, nil
+}
+
+// scanNotes scans the rows into notes
+func scanNotes(rows *sql.Rows) ([]noteResponse, error) {
+	defer rows.Close()
+
+	ret := []noteResponse{}
+	for rows.Next() {
+		var info database.NoteInfo
+		if err := rows.Scan(&info.BookLabel, &info.UUID, &info.Content, &info.AddedOn, &info.EditedOn, &info.RowID, &info.ViewCount, &info.LastViewedAt); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		ret = append(ret, presentNote(info))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating rows")
+	}
+
+	return ret, nil
+}
+
+func (s *server) getBooks(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	rows, err := s.ctx.DB.Query(`SELECT books.uuid, books.label, count(notes.uuid)
+	FROM books
+	LEFT JOIN notes ON notes.book_uuid = books.uuid AND notes.deleted = false
+	WHERE books.deleted = false
+	GROUP BY books.uuid
+	ORDER BY books.label ASC`)
+	if err != nil {
+		respondInternalError(w, errors.Wrap(err, "querying books"))
+		return
+	}
+	defer rows.Close()
+
+	books := []bookResponse{}
+	for rows.Next() {
+		var b bookResponse
+		if err := rows.Scan(&b.UUID, &b.Label, &b.NoteCount); err != nil {
+			respondInternalError(w, errors.Wrap(err, "scanning a row"))
+			return
+		}
+
+		books = append(books, b)
+	}
+	if err := rows.Err(); err != nil {
+		respondInternalError(w, errors.Wrap(err, "iterating rows"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"books": books})
+}
+
+// getBookNotes serves a page of the notes in a book at /books/{label}/notes.
+// The label is escaped in the path so that it can contain a slash.
+func (s *server) getBookNotes(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/books/"), "/")
+	if len(parts) != 2 || parts[1] != "notes" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	label, err := url.PathUnescape(parts[0])
+	if err != nil || label == "" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	limit, offset, err := parsePage(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bookUUID, err := database.GetBookUUID(s.ctx.DB, label)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "book not found")
+		return
+	}
+
+	rows, err := s.ctx.DB.Query(`SELECT books.label, notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.rowid, notes.view_count, notes.last_viewed_at
+	FROM notes
+	INNER JOIN books ON books.uuid = notes.book_uuid
+	WHERE notes.book_uuid = ? AND notes.deleted = false
+	ORDER BY notes.position ASC, notes.added_on ASC, notes.rowid ASC
+	LIMIT ? OFFSET ?`, bookUUID, limit, offset)
+	if err != nil {
+		respondInternalError(w, errors.Wrap(err, "querying notes"))
+		return
+	}
+
+	notes, err := scanNotes(rows)
+	if err != nil {
+		respondInternalError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"notes": notes})
+}
+
+func (s *server) getNote(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	uuid := strings.TrimPrefix(r.URL.Path, "/notes/")
+
+	info, err := database.GetNoteInfoByUUID(s.ctx.DB, uuid)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "note not found")
+		return
+	} else if err != nil {
+		respondInternalError(w, err)
+		return
+	}
+
+	// the note is returned with the views before this one
+	infra.RecordNoteView(s.ctx, info.RowID)
+
+	respondJSON(w, http.StatusOK, presentNote(info))
+}
+
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	q := r.URL.Query()
+	if strings.TrimSpace(q.Get("q")) == "" {
+		respondError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit, offset, err := parsePage(q)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	phrase, err := find.EscapePhrase(q.Get("q"))
+	if err != nil {
+		respondInternalError(w, errors.Wrap(err, "escaping the phrase"))
+		return
+	}
+
+	rows, err := s.ctx.DB.Query(`SELECT books.label, notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.rowid, notes.view_count, notes.last_viewed_at
+	FROM note_fts
+	INNER JOIN notes ON notes.rowid = note_fts.rowid
+	INNER JOIN books ON books.uuid = notes.book_uuid
+	WHERE note_fts MATCH ? AND notes.deleted = false
+	ORDER BY rank, notes.rowid ASC
+	LIMIT ? OFFSET ?`, phrase, limit, offset)
+	if err != nil {
+		respondInternalError(w, errors.Wrap(err, "querying notes"))
+		return
+	}
+
+	notes, err := scanNotes(rows)
+	if err != nil {
+		respondInternalError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"notes": notes})
+}
+
+// createNoteParams is the body of a request to create a note
+type createNoteParams struct {
+	Book    string `json:"book"`
+	Content string `json:"content"`
+}
+
+// createNote adds a note in the same way as the add command does, creating
+// the book if it does not exist
+func (s *server) createNote(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !s.allowWrite {
+		respondError(w, http.StatusForbidden, "writing is not allowed. Restart the server with --allow-write")
+		return
+	}
+
+	var params createNoteParams
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&params); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	if err := validate.BookName(params.Book); err != nil {
+		respondError(w, http.StatusBadRequest, errors.Wrap(err, "invalid book name").Error())
+		return
+	}
+	if params.Content == "" {
+		respondError(w, http.StatusBadRequest, "empty content")
+		return
+	}
+	if err := validate.NoteBody(params.Content); err != nil {
+		respondError(w, http.StatusBadRequest, errors.Wrap(err, "invalid content").Error())
+		return
+	}
+	content := utils.NormalizeBody(params.Content)
+	if err := validate.NoteSize(content, s.ctx.NoteSizeMax); err != nil {
+		respondError(w, http.StatusRequestEntityTooLarge, errors.Wrap(err, "invalid content").Error())
+		return
+	}
+
+	note, err := dnote.NewStore(s.ctx).AddNote(params.Book, content)
+	if err != nil {
+		respondInternalError(w, errors.Wrap(err, "writing the note"))
+		return
+	}
+
+	info, err := database.GetNoteInfo(s.ctx.DB, note.Index)
+	if err != nil {
+		respondInternalError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, presentNote(info))
+}
@@ -0,0 +1,351 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+const testToken = "test-token"
+
+func setupServer(t *testing.T, allowWrite bool) (context.DnoteCtx, *httptest.Server) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	t.Cleanup(func() {
+		context.TeardownTestCtx(t, ctx)
+	})
+
+	c := clock.NewMock()
+	c.SetNow(testutils.CmdNow)
+	ctx.Clock = c
+
+	srv := httptest.NewServer(newServer(ctx, testToken, allowWrite))
+	t.Cleanup(srv.Close)
+
+	return ctx, srv
+}
+
+// doRequest makes a request with the token and decodes the JSON response
+func doRequest(t *testing.T, srv *httptest.Server, method, path string, body io.Reader, dest interface{}) int {
+	req, err := http.NewRequest(method, srv.URL+path, body)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "constructing the request"))
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making the request"))
+	}
+	defer res.Body.Close()
+
+	if dest != nil {
+		if err := json.NewDecoder(res.Body).Decode(dest); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding the response"))
+		}
+	}
+
+	return res.StatusCode
+}
+
+type notesPayload struct {
+	Notes []noteResponse `json:"notes"`
+}
+
+func noteUUIDs(notes []noteResponse) []string {
+	ret := []string{}
+	for _, n := range notes {
+		ret = append(ret, n.UUID)
+	}
+
+	return ret
+}
+
+func TestAuth(t *testing.T) {
+	_, srv := setupServer(t, false)
+
+	testCases := []string{"", "Bearer wrong-token", testToken}
+
+	for _, header := range testCases {
+		t.Run(header, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/books", nil)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "constructing the request"))
+			}
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+
+			res, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "making the request"))
+			}
+			res.Body.Close()
+
+			assert.Equal(t, res.StatusCode, http.StatusUnauthorized, "status code mismatch")
+		})
+	}
+}
+
+func TestGetBooks(t *testing.T) {
+	ctx, srv := setupServer(t, false)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	f.Book("css")
+	f.Book("removed", testutils.With.Deleted())
+	f.Note(b1, "n1")
+	f.Note(b1, "n2")
+	f.Note(b1, "n3", testutils.With.Deleted())
+
+	var payload struct {
+		Books []bookResponse `json:"books"`
+	}
+	status := doRequest(t, srv, http.MethodGet, "/books", nil, &payload)
+
+	assert.Equal(t, status, http.StatusOK, "status code mismatch")
+	assert.Equal(t, len(payload.Books), 2, "book count mismatch")
+	assert.Equal(t, payload.Books[0].Label, "css", "book 0 label mismatch")
+	assert.Equal(t, payload.Books[0].NoteCount, 0, "book 0 note count mismatch")
+	assert.Equal(t, payload.Books[1].Label, "js", "book 1 label mismatch")
+	assert.Equal(t, payload.Books[1].UUID, b1.UUID, "book 1 uuid mismatch")
+	assert.Equal(t, payload.Books[1].NoteCount, 2, "book 1 note count mismatch")
+}
+
+func TestGetBookNotes(t *testing.T) {
+	ctx, srv := setupServer(t, false)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	b2 := f.Book("c/c++")
+	n1 := f.Note(b1, "n1", testutils.With.AddedOn(3))
+	n2 := f.Note(b1, "n2", testutils.With.AddedOn(1))
+	n3 := f.Note(b1, "n3", testutils.With.AddedOn(2))
+	f.Note(b1, "n4", testutils.With.AddedOn(4), testutils.With.Deleted())
+	n5 := f.Note(b2, "n5")
+
+	testCases := []struct {
+		path     string
+		expected []string
+	}{
+		{path: "/books/js/notes", expected: []string{n2.UUID, n3.UUID, n1.UUID}},
+		{path: "/books/js/notes?limit=2", expected: []string{n2.UUID, n3.UUID}},
+		{path: "/books/js/notes?limit=2&offset=2", expected: []string{n1.UUID}},
+		{path: "/books/js/notes?offset=3", expected: []string{}},
+		{path: "/books/c%2Fc++/notes", expected: []string{n5.UUID}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			var payload notesPayload
+			status := doRequest(t, srv, http.MethodGet, tc.path, nil, &payload)
+
+			assert.Equal(t, status, http.StatusOK, "status code mismatch")
+			assert.DeepEqual(t, noteUUIDs(payload.Notes), tc.expected, "notes mismatch")
+		})
+	}
+
+	t.Run("note fields", func(t *testing.T) {
+		var payload notesPayload
+		doRequest(t, srv, http.MethodGet, "/books/c%2Fc++/notes", nil, &payload)
+
+		assert.Equal(t, payload.Notes[0].Book, "c/c++", "book mismatch")
+		assert.Equal(t, payload.Notes[0].Content, "n5", "content mismatch")
+		assert.Equal(t, payload.Notes[0].RowID, n5.RowID, "rowid mismatch")
+	})
+
+	errorCases := []struct {
+		path   string
+		status int
+	}{
+		{path: "/books/css/notes", status: http.StatusNotFound},
+		{path: "/books/js", status: http.StatusNotFound},
+		{path: "/books/js/notes?limit=-1", status: http.StatusBadRequest},
+		{path: "/books/js/notes?offset=x", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range errorCases {
+		t.Run(tc.path, func(t *testing.T) {
+			status := doRequest(t, srv, http.MethodGet, tc.path, nil, nil)
+
+			assert.Equal(t, status, tc.status, "status code mismatch")
+		})
+	}
+}
+
+func TestGetNote(t *testing.T) {
+	ctx, srv := setupServer(t, false)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	n1 := f.Note(b1, "n1 content", testutils.With.AddedOn(10), testutils.With.EditedOn(20), testutils.With.Viewed(2, 15))
+	n2 := f.Note(b1, "n2 content", testutils.With.Deleted())
+
+	var got noteResponse
+	status := doRequest(t, srv, http.MethodGet, "/notes/"+n1.UUID, nil, &got)
+
+	assert.Equal(t, status, http.StatusOK, "status code mismatch")
+	assert.DeepEqual(t, got, noteResponse{
+		UUID:         n1.UUID,
+		RowID:        n1.RowID,
+		Book:         "js",
+		Content:      "n1 content",
+		AddedOn:      10,
+		EditedOn:     20,
+		ViewCount:    2,
+		LastViewedAt: 15,
+	}, "note mismatch")
+
+	viewed := f.MustGetNote(n1.UUID)
+	assert.Equal(t, viewed.ViewCount, 3, "view count mismatch")
+	assert.Equal(t, viewed.LastViewedAt, testutils.CmdNow.UnixNano(), "last viewed at mismatch")
+
+	for _, uuid := range []string{n2.UUID, "nonexistent"} {
+		status := doRequest(t, srv, http.MethodGet, "/notes/"+uuid, nil, nil)
+
+		assert.Equal(t, status, http.StatusNotFound, fmt.Sprintf("status code mismatch for %s", uuid))
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ctx, srv := setupServer(t, false)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	b2 := f.Book("css")
+	n1 := f.Note(b1, "arrow functions are concise")
+	f.Note(b1, "closures capture the scope")
+	n3 := f.Note(b2, "css functions like calc()")
+	f.Note(b2, "removed functions", testutils.With.Deleted())
+
+	var payload notesPayload
+	status := doRequest(t, srv, http.MethodGet, "/search?q=functions", nil, &payload)
+
+	assert.Equal(t, status, http.StatusOK, "status code mismatch")
+	got := noteUUIDs(payload.Notes)
+	assert.Equal(t, len(got), 2, "result count mismatch")
+	assert.Equal(t, strings.Contains(strings.Join(got, " "), n1.UUID), true, "n1 mismatch")
+	assert.Equal(t, strings.Contains(strings.Join(got, " "), n3.UUID), true, "n3 mismatch")
+
+	status = doRequest(t, srv, http.MethodGet, "/search", nil, nil)
+	assert.Equal(t, status, http.StatusBadRequest, "status code mismatch without q")
+}
+
+func TestCreateNote(t *testing.T) {
+	t.Run("not allowed", func(t *testing.T) {
+		ctx, srv := setupServer(t, false)
+
+		status := doRequest(t, srv, http.MethodPost, "/notes", strings.NewReader(`{"book": "js", "content": "n1"}`), nil)
+
+		assert.Equal(t, status, http.StatusForbidden, "status code mismatch")
+		assert.Equal(t, testutils.NewFixture(t, ctx.DB).MustCountNotes(), 0, "note count mismatch")
+	})
+
+	t.Run("new book", func(t *testing.T) {
+		ctx, srv := setupServer(t, true)
+
+		var got noteResponse
+		status := doRequest(t, srv, http.MethodPost, "/notes", strings.NewReader(`{"book": "js", "content": "  n1 content\r\n"}`), &got)
+		assert.Equal(t, status, http.StatusCreated, "status code mismatch")
+
+		n := testutils.MustGetNote(t, ctx.DB, got.UUID)
+		assert.Equal(t, n.Body, "  n1 content\n", "body mismatch")
+		assert.Equal(t, n.AddedOn, testutils.CmdNow.UnixNano(), "added_on mismatch")
+		assert.Equal(t, n.USN, 0, "usn mismatch")
+		assert.Equal(t, n.Dirty, true, "dirty mismatch")
+
+		b := testutils.MustGetBook(t, ctx.DB, n.BookUUID)
+		assert.Equal(t, b.Label, "js", "label mismatch")
+		assert.Equal(t, b.Dirty, true, "book dirty mismatch")
+		assert.Equal(t, got.Book, "js", "response book mismatch")
+	})
+
+	t.Run("existing book", func(t *testing.T) {
+		ctx, srv := setupServer(t, true)
+
+		b1 := testutils.NewFixture(t, ctx.DB).Book("js")
+
+		var got noteResponse
+		status := doRequest(t, srv, http.MethodPost, "/notes", strings.NewReader(`{"book": "js", "content": "n1"}`), &got)
+		assert.Equal(t, status, http.StatusCreated, "status code mismatch")
+
+		n := testutils.MustGetNote(t, ctx.DB, got.UUID)
+		assert.Equal(t, n.BookUUID, b1.UUID, "book uuid mismatch")
+
+		var bookCount int
+		database.MustScan(t, "counting books", ctx.DB.QueryRow("SELECT count(*) FROM books"), &bookCount)
+		assert.Equal(t, bookCount, 1, "book count mismatch")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		ctx, srv := setupServer(t, true)
+
+		testCases := []string{
+			`{"book": "js", "content": ""}`,
+			`{"book": "", "content": "n1"}`,
+			`{"book": "   ", "content": "n1"}`,
+			`{"book": "js", "content": "\xff"}`,
+			`not json`,
+		}
+
+		for _, body := range testCases {
+			status := doRequest(t, srv, http.MethodPost, "/notes", strings.NewReader(body), nil)
+
+			assert.Equal(t, status, http.StatusBadRequest, fmt.Sprintf("status code mismatch for %s", body))
+		}
+
+		assert.Equal(t, testutils.NewFixture(t, ctx.DB).MustCountNotes(), 0, "note count mismatch")
+	})
+}
+
+func TestCheckLoopback(t *testing.T) {
+	testCases := []struct {
+		addr string
+		ok   bool
+	}{
+		{addr: "127.0.0.1:0", ok: true},
+		{addr: "localhost:3030", ok: true},
+		{addr: "[::1]:3030", ok: true},
+		{addr: "0.0.0.0:3030", ok: false},
+		{addr: ":3030", ok: false},
+		{addr: "192.168.1.2:3030", ok: false},
+		{addr: "127.0.0.1", ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.addr, func(t *testing.T) {
+			err := checkLoopback(tc.addr)
+
+			assert.Equal(t, err == nil, tc.ok, "result mismatch")
+		})
+	}
+}
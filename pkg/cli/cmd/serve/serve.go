@@ -0,0 +1,149 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package serve
+
+import (
+	stdCtx "context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Serve the notes on a random port
+ dnote serve
+
+ * Serve the notes on a given port and allow adding notes
+ dnote serve --addr 127.0.0.1:3030 --allow-write`
+
+var addrFlag string
+var allowWriteFlag bool
+
+// shutdownTimeout is how long the requests in progress are waited for upon
+// an interrupt
+const shutdownTimeout = 5 * time.Second
+
+// NewCmd returns a new serve command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "serve",
+		Short:   "Serve the notes over a local HTTP API",
+		Example: example,
+		Args:    cobra.NoArgs,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&addrFlag, "addr", "", "127.0.0.1:0", "the loopback address to listen on. Port 0 picks a free port")
+	f.BoolVarP(&allowWriteFlag, "allow-write", "", false, "allow adding notes")
+
+	return cmd
+}
+
+// checkLoopback checks that the address is on the loopback interface so that
+// the notes are not exposed to the network
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid address '%s'", addr)
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return errors.Errorf("the address '%s' is not a loopback address. Use 127.0.0.1 or ::1", addr)
+}
+
+// generateToken returns a random token for a session
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "reading random bytes")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := checkLoopback(addrFlag); err != nil {
+			return err
+		}
+
+		token, err := generateToken()
+		if err != nil {
+			return errors.Wrap(err, "generating the token")
+		}
+
+		ln, err := net.Listen("tcp", addrFlag)
+		if err != nil {
+			return errors.Wrap(err, "listening")
+		}
+
+		srv := &http.Server{
+			Handler:           newServer(ctx, token, allowWriteFlag),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		// The address and the token are the output for the clients to read,
+		// so they are printed regardless of the log level.
+		fmt.Fprintf(os.Stdout, "address: http://%s\ntoken: %s\n", ln.Addr(), token)
+
+		sigCtx, stop := signal.NotifyContext(ctx.Context(), os.Interrupt)
+		defer stop()
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- srv.Serve(ln)
+		}()
+
+		select {
+		case err := <-errCh:
+			return errors.Wrap(err, "serving")
+		case <-sigCtx.Done():
+		}
+
+		log.Info(i18n.T("serve.shutting_down"))
+
+		shutdownCtx, cancel := stdCtx.WithTimeout(stdCtx.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return errors.Wrap(err, "shutting down")
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,296 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package share
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/output"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Create a link to a note that anyone can view
+ dnote share 12
+
+ * Create a link that expires in a week
+ dnote share javascript 12 --expires 7d
+
+ * List the links to a note, as they are on the server
+ dnote share list 12
+
+ * List the links to all notes, as of the last sync
+ dnote share list
+
+ * Revoke a link
+ dnote share revoke 5f2a9c`
+
+var expiresFlag string
+
+// NewCmd returns a new share command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "share <book name?> <note id|uuid prefix>",
+		Short:   "Create a link by which anyone can view a note",
+		Example: example,
+		Args:    cobra.RangeArgs(1, 2),
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&expiresFlag, "expires", "", "", "expire the link after the given time, such as 7d or 12h")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <book name?> <note id|uuid prefix?>",
+		Short: "List the links to a note, or to all notes as of the last sync",
+		Args:  cobra.MaximumNArgs(2),
+		RunE:  newListRun(ctx),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "revoke <share id>",
+		Short: "Revoke a link",
+		Args:  cobra.ExactArgs(1),
+		RunE:  newRevokeRun(ctx),
+	})
+
+	return cmd
+}
+
+// resolveNote returns the note that the last argument refers to. The book name
+// is optional, as note ids are unique across books. The note must be synced so
+// that the server has it.
+func resolveNote(ctx context.DnoteCtx, args []string) (database.Note, error) {
+	noteRef := args[len(args)-1]
+	noteRowID, ok, err := database.ResolveNoteRef(ctx.DB, noteRef, false)
+	if err != nil {
+		return database.Note{}, errors.Wrap(err, "resolving the note")
+	}
+	if !ok {
+		return database.Note{}, errors.Errorf("note '%s' not found. Use its id or a prefix of its uuid of at least %d characters", noteRef, database.MinUUIDPrefixLength)
+	}
+
+	note, err := database.GetActiveNote(ctx.DB, noteRowID)
+	if err == sql.ErrNoRows {
+		return note, errors.Errorf("note %d not found", noteRowID)
+	} else if err != nil {
+		return note, errors.Wrap(err, "getting the note")
+	}
+	if note.USN == 0 {
+		return note, errors.New("the note has not been synced yet. Please run `dnote sync` first")
+	}
+
+	return note, nil
+}
+
+// loadSession returns the context with the session key of the user, or an
+// error if the user is not logged in
+func loadSession(ctx context.DnoteCtx) (context.DnoteCtx, error) {
+	ctx, err := infra.LoadSessionKey(ctx)
+	if err != nil {
+		return ctx, errors.Wrap(err, "loading the session key")
+	}
+	if ctx.SessionKey == "" {
+		return ctx, errors.New("not logged in. Please run `dnote login` first")
+	}
+
+	return ctx, nil
+}
+
+// apiError wraps an error of the server unless it is about the server not
+// supporting share links, which is explained on its own
+func apiError(err error, message string) error {
+	if errors.Is(err, client.ErrSharesUnsupported) {
+		return err
+	}
+
+	return errors.Wrap(err, message)
+}
+
+// parseExpires returns the time at which a link created now expires with the
+// given flag, or nil if it does not expire
+func parseExpires(s string, now time.Time) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	d, err := utils.ParseAge(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing --expires")
+	}
+	if d == 0 {
+		return nil, errors.New("--expires must be longer than zero. Leave it out for a link that does not expire")
+	}
+
+	ret := now.Add(d).UTC()
+	return &ret, nil
+}
+
+// formatExpiry returns when the link expires
+func formatExpiry(ctx context.DnoteCtx, s database.Share) string {
+	var t time.Time
+	if s.ExpiresAt != 0 {
+		t = time.Unix(0, s.ExpiresAt)
+	}
+
+	return output.FormatExpiry(t, ctx.Clock.Now().Local(), ctx.TimeFormat)
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		expiresAt, err := parseExpires(expiresFlag, ctx.Clock.Now())
+		if err != nil {
+			return err
+		}
+
+		note, err := resolveNote(ctx, args)
+		if err != nil {
+			return err
+		}
+
+		ctx, err := loadSession(ctx)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.CreateShare(ctx, note.UUID, expiresAt)
+		if err != nil {
+			return apiError(err, "creating the link")
+		}
+
+		s := infra.ShareFromServer(resp)
+		s.NoteUUID = note.UUID
+		if s.ExpiresAt == 0 && expiresAt != nil {
+			s.ExpiresAt = expiresAt.UnixNano()
+		}
+		if err := database.SaveShare(ctx.DB, s); err != nil {
+			return err
+		}
+
+		log.Success(i18n.T("share.created", s.ID, note.RowID, formatExpiry(ctx, s)))
+		fmt.Println(s.URL)
+
+		return nil
+	}
+}
+
+// printShares prints the links with the ids of their notes
+func printShares(ctx context.DnoteCtx, shares []database.Share) error {
+	if len(shares) == 0 {
+		log.Plain(i18n.T("share.none"))
+		return nil
+	}
+
+	for _, s := range shares {
+		var rowID int
+		err := ctx.DB.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", s.NoteUUID).Scan(&rowID)
+		if err != nil && err != sql.ErrNoRows {
+			return errors.Wrapf(err, "getting the note %s", s.NoteUUID)
+		}
+
+		log.Plainf("%s %s %s %s\n",
+			log.ColorYellow.Sprintf("(%d)", rowID),
+			s.ID,
+			s.URL,
+			log.ColorGray.Sprint(formatExpiry(ctx, s)),
+		)
+	}
+
+	return nil
+}
+
+func newListRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		now := ctx.Clock.Now().UnixNano()
+
+		if len(args) == 0 {
+			shares, err := database.GetShares(ctx.DB, "", now)
+			if err != nil {
+				return err
+			}
+
+			return printShares(ctx, shares)
+		}
+
+		note, err := resolveNote(ctx, args)
+		if err != nil {
+			return err
+		}
+
+		ctx, err := loadSession(ctx)
+		if err != nil {
+			return err
+		}
+
+		if _, err := infra.RefreshShares(ctx, ctx.DB, note.UUID); err != nil {
+			return apiError(err, "getting the links")
+		}
+
+		shares, err := database.GetShares(ctx.DB, note.UUID, now)
+		if err != nil {
+			return err
+		}
+
+		return printShares(ctx, shares)
+	}
+}
+
+func newRevokeRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		id := args[0]
+
+		s, ok, err := database.GetShare(ctx.DB, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.Errorf("link '%s' not found. Run `dnote share list <note>` to get the links to a note from the server", id)
+		}
+
+		ctx, err := loadSession(ctx)
+		if err != nil {
+			return err
+		}
+
+		// a link that the server no longer has was revoked or has expired
+		err = client.DeleteShare(ctx, s.NoteUUID, s.ID)
+		var apiErr *client.APIError
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound) {
+			return errors.Wrap(err, "revoking the link")
+		}
+
+		if err := database.DeleteShare(ctx.DB, s.ID); err != nil {
+			return err
+		}
+
+		log.Success(i18n.T("share.revoked", s.ID))
+
+		return nil
+	}
+}
@@ -0,0 +1,242 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package share
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// shareServer is a test server with the share link API, keeping the links it
+// creates in memory
+type shareServer struct {
+	*httptest.Server
+	shares []client.Share
+}
+
+func newShareServer(t *testing.T) *shareServer {
+	s := &shareServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/notes/"), "/")
+		noteUUID := parts[0]
+
+		var resp interface{}
+		switch {
+		case r.Method == "POST" && len(parts) == 2:
+			var payload client.CreateSharePayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding the payload"))
+			}
+
+			id := fmt.Sprintf("s%d", len(s.shares)+1)
+			share := client.Share{ID: id, NoteUUID: noteUUID, URL: "https://example.com/s/" + id, CreatedAt: testutils.CmdNow, ExpiresAt: payload.ExpiresAt}
+			s.shares = append(s.shares, share)
+			resp = client.CreateShareResp{Share: share}
+		case r.Method == "GET" && len(parts) == 2:
+			ret := client.GetSharesResp{Shares: []client.Share{}}
+			for _, share := range s.shares {
+				if share.NoteUUID == noteUUID {
+					ret.Shares = append(ret.Shares, share)
+				}
+			}
+			resp = ret
+		case r.Method == "DELETE" && len(parts) == 3:
+			for i, share := range s.shares {
+				if share.ID == parts[2] {
+					s.shares = append(s.shares[:i], s.shares[i+1:]...)
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(errors.Wrap(err, "encoding the response"))
+		}
+	}))
+
+	return s
+}
+
+func TestShare(t *testing.T) {
+	srv := newShareServer(t)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	ctx.SessionKey = "somekey"
+	ctx.APIEndpoint = srv.URL
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	n1 := f.Note(b1, "n1 body", testutils.With.UUID("n1-uuid"), testutils.With.USN(1))
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "js", "1", "--expires", "7d")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "creating an expiring link"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "created the link s1 to the note 1, which expires in 7d"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+	assert.Equal(t, strings.Contains(string(result.Stdout), "https://example.com/s/s1\n"), true, fmt.Sprintf("url mismatch: %s", result.Stdout))
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "1")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "creating a link"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "which never expires"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+
+	s1, ok, err := database.GetShare(ctx.DB, "s1")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting s1"))
+	}
+	assert.Equal(t, ok, true, "s1 should be cached")
+	assert.Equal(t, s1.NoteUUID, n1.UUID, "s1 note mismatch")
+	assert.Equal(t, s1.ExpiresAt, testutils.CmdNow.Add(7*24*time.Hour).UnixNano(), "s1 expires_at mismatch")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "list")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "listing the cached links"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "(1) s1 https://example.com/s/s1 expires in 7d"), true, fmt.Sprintf("list output mismatch: %s", result.Stdout))
+	assert.Equal(t, strings.Contains(string(result.Stdout), "(1) s2 https://example.com/s/s2 never expires"), true, fmt.Sprintf("list output mismatch: %s", result.Stdout))
+
+	// a link revoked elsewhere is dropped when the links are refreshed
+	srv.shares = srv.shares[1:]
+	result = testutils.RunCmd(t, ctx, NewCmd, "list", "1")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "listing the links of the note"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "s1"), false, fmt.Sprintf("list output mismatch: %s", result.Stdout))
+	assert.Equal(t, strings.Contains(string(result.Stdout), "s2"), true, fmt.Sprintf("list output mismatch: %s", result.Stdout))
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "revoke", "s2")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "revoking s2"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "revoked the link s2"), true, fmt.Sprintf("revoke output mismatch: %s", result.Stdout))
+	assert.Equal(t, len(srv.shares), 0, "server share count mismatch")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "list")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "listing the cached links"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "no link is active"), true, fmt.Sprintf("list output mismatch: %s", result.Stdout))
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "revoke", "s2")
+	assert.NotEqual(t, result.Err, nil, "revoking an unknown link should fail")
+}
+
+func TestShare_revokeGone(t *testing.T) {
+	srv := newShareServer(t)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	ctx.SessionKey = "somekey"
+	ctx.APIEndpoint = srv.URL
+
+	if err := database.SaveShare(ctx.DB, database.Share{ID: "s1", NoteUUID: "n1-uuid", URL: "https://example.com/s/s1"}); err != nil {
+		t.Fatal(errors.Wrap(err, "saving s1"))
+	}
+
+	// the server no longer has the link
+	result := testutils.RunCmd(t, ctx, NewCmd, "revoke", "s1")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "revoking s1"))
+	}
+
+	_, ok, err := database.GetShare(ctx.DB, "s1")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting s1"))
+	}
+	assert.Equal(t, ok, false, "s1 should be removed from the cache")
+}
+
+func TestShare_unsupported(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	ctx.SessionKey = "somekey"
+	ctx.APIEndpoint = srv.URL
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	f.Note(b1, "n1 body", testutils.With.USN(1))
+
+	for _, args := range [][]string{{"1"}, {"list", "1"}} {
+		result := testutils.RunCmd(t, ctx, NewCmd, args...)
+		assert.Equal(t, result.Err, client.ErrSharesUnsupported, fmt.Sprintf("error mismatch for %v", args))
+	}
+}
+
+func TestShare_invalid(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	ctx.SessionKey = "somekey"
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	f.Note(b1, "unsynced body")
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"1"}, "has not been synced yet"},
+		{[]string{"2"}, "not found"},
+		{[]string{"1", "--expires", "0d"}, "longer than zero"},
+		{[]string{"1", "--expires", "soon"}, "parsing --expires"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+			if result.Err == nil {
+				t.Fatal("error should have been returned")
+			}
+			assert.Equal(t, strings.Contains(result.Err.Error(), tc.expected), true, fmt.Sprintf("error mismatch: %s", result.Err))
+		})
+	}
+}
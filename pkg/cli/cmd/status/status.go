@@ -0,0 +1,256 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package status
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/output"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Show the state of the local copy
+ dnote status
+
+ * Print the state as JSON, e.g. for a shell prompt
+ dnote status --format json`
+
+const (
+	// formatText is the human readable output
+	formatText = "text"
+	// formatJSON is the machine readable output
+	formatJSON = "json"
+)
+
+var formatFlag string
+
+// NewCmd returns a new status command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "status",
+		Short:   "Show the state of the local copy without talking to the server",
+		Example: example,
+		Args:    cobra.NoArgs,
+		PreRunE: preRun,
+		RunE:    output.JSONErrors(&formatFlag, newRun(ctx)),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&formatFlag, "format", "f", formatText, "the output format, which is text or json")
+
+	return cmd
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	switch formatFlag {
+	case formatText, formatJSON:
+	default:
+		return errors.Errorf("unknown format '%s'. Use text or json", formatFlag)
+	}
+
+	return nil
+}
+
+// reviewBook is a book into which Dnote moves the notes that need to be
+// reviewed, such as the conflicts found during a sync
+type reviewBook struct {
+	Label string `json:"label"`
+	Notes int    `json:"notes"`
+}
+
+// status is the state of the local copy
+type status struct {
+	// LastSyncAt is the time of the server at the last sync in seconds, or 0
+	// if the client has never synced
+	LastSyncAt int64 `json:"last_sync_at"`
+	// LastMaxUSN is the cursor of the last change seen from the server
+	LastMaxUSN string `json:"last_max_usn"`
+	// Dirty are the numbers of the local changes not sent to the server yet
+	Dirty database.DirtyCounts `json:"dirty"`
+	// ReviewBooks are the conflicts and the recovered books with notes in them
+	ReviewBooks []reviewBook `json:"review_books"`
+	// SyncLocked indicates whether a running process holds the sync lock
+	SyncLocked bool `json:"sync_locked"`
+	// SyncLockPID is the ID of the process that holds the sync lock, or 0
+	SyncLockPID int `json:"sync_lock_pid"`
+	// APIKeyConfigured indicates whether a session key is configured
+	APIKeyConfigured bool `json:"api_key_configured"`
+}
+
+// getReviewBooks returns the conflicts and the recovered books that have notes,
+// along with the numbers of their notes
+func getReviewBooks(db *database.DB) ([]reviewBook, error) {
+	rows, err := db.Query(`SELECT books.label, count(notes.uuid)
+		FROM books
+		INNER JOIN notes ON notes.book_uuid = books.uuid AND NOT notes.deleted
+		WHERE NOT books.deleted
+			AND (books.label IN ('conflicts', 'recovered') OR books.label GLOB 'recovered_[0-9]*')
+		GROUP BY books.label
+		ORDER BY books.label`)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the books")
+	}
+	defer rows.Close()
+
+	ret := []reviewBook{}
+	for rows.Next() {
+		var b reviewBook
+		if err := rows.Scan(&b.Label, &b.Notes); err != nil {
+			return nil, errors.Wrap(err, "scanning a book")
+		}
+
+		ret = append(ret, b)
+	}
+
+	return ret, rows.Err()
+}
+
+// getSystem scans the system configuration with the given key onto the
+// destination, leaving it unchanged if the record does not exist
+func getSystem(db *database.DB, key string, dest interface{}) error {
+	err := database.GetSystem(db, key, dest)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil
+	}
+
+	return errors.Wrapf(err, "getting %s", key)
+}
+
+// getStatus reads the state of the local copy. It neither talks to the server
+// nor prompts for the passphrase of the credentials.
+func getStatus(ctx context.DnoteCtx) (status, error) {
+	var ret status
+	var err error
+
+	if err := getSystem(ctx.DB, consts.SystemLastSyncAt, &ret.LastSyncAt); err != nil {
+		return ret, err
+	}
+	if err := getSystem(ctx.DB, consts.SystemLastMaxUSN, &ret.LastMaxUSN); err != nil {
+		return ret, err
+	}
+
+	if ret.Dirty, err = database.CountDirty(ctx.DB); err != nil {
+		return ret, errors.Wrap(err, "counting the local changes")
+	}
+	if ret.ReviewBooks, err = getReviewBooks(ctx.DB); err != nil {
+		return ret, errors.Wrap(err, "getting the books to review")
+	}
+
+	if ret.SyncLockPID, err = infra.SyncLockHolder(ctx); err != nil {
+		return ret, errors.Wrap(err, "checking the sync lock")
+	}
+	ret.SyncLocked = ret.SyncLockPID != 0
+
+	if ret.APIKeyConfigured, err = infra.HasSessionKey(ctx); err != nil {
+		return ret, errors.Wrap(err, "checking the session key")
+	}
+
+	return ret, nil
+}
+
+// formatChanges returns a human readable summary of the change counts
+func formatChanges(c database.ChangeCounts) string {
+	return i18n.T("status.changes", c.Created, c.Updated, c.Deleted)
+}
+
+// writeText writes the status in the human readable format
+func writeText(w io.Writer, s status, now time.Time) error {
+	lastSync := i18n.T("status.never")
+	if s.LastSyncAt != 0 {
+		lastSync = output.FormatTime(time.Unix(s.LastSyncAt, 0), now, "")
+	}
+
+	lastMaxUSN := s.LastMaxUSN
+	if lastMaxUSN == "" {
+		lastMaxUSN = i18n.T("status.none")
+	}
+
+	lock := i18n.T("status.lock_free")
+	if s.SyncLocked {
+		lock = i18n.T("status.lock_held", s.SyncLockPID)
+	}
+
+	apiKey := i18n.T("status.api_key_missing")
+	if s.APIKeyConfigured {
+		apiKey = i18n.T("status.api_key_configured")
+	}
+
+	lines := []string{
+		i18n.T("status.last_sync", lastSync),
+		i18n.T("status.last_max_usn", lastMaxUSN),
+		i18n.T("status.dirty_notes", formatChanges(s.Dirty.Notes)),
+		i18n.T("status.dirty_books", formatChanges(s.Dirty.Books)),
+	}
+	if len(s.ReviewBooks) == 0 {
+		lines = append(lines, i18n.T("status.review_none"))
+	} else {
+		lines = append(lines, i18n.T("status.review"))
+		for _, b := range s.ReviewBooks {
+			lines = append(lines, fmt.Sprintf("  %s (%d)", b.Label, b.Notes))
+		}
+	}
+	lines = append(lines,
+		i18n.T("status.sync_lock", lock),
+		i18n.T("status.api_key", apiKey),
+	)
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return errors.Wrap(err, "writing the status")
+		}
+	}
+
+	return nil
+}
+
+// writeStatus writes the status in the given format
+func writeStatus(w io.Writer, s status, format string, now time.Time) error {
+	if format == formatJSON {
+		if err := json.NewEncoder(w).Encode(s); err != nil {
+			return errors.Wrap(err, "encoding the status")
+		}
+
+		return nil
+	}
+
+	return writeText(w, s, now)
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		s, err := getStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		return writeStatus(os.Stdout, s, formatFlag, ctx.Clock.Now())
+	}
+}
@@ -0,0 +1,158 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// setupStatus seeds a note and a book in every dirty category, along with the
+// books to review and the state of the last sync
+func setupStatus(t *testing.T, ctx context.DnoteCtx) {
+	f := testutils.NewFixture(t, ctx.DB)
+
+	b1 := f.Book("js", testutils.With.USN(1))
+	f.Book("css", testutils.With.Dirty())
+	f.Book("go", testutils.With.USN(2), testutils.With.Dirty())
+	f.Book("rust", testutils.With.USN(3), testutils.With.Dirty(), testutils.With.Deleted())
+
+	f.Note(b1, "synced", testutils.With.USN(4))
+	f.Note(b1, "created", testutils.With.Dirty())
+	f.Note(b1, "created too", testutils.With.Dirty())
+	f.Note(b1, "updated", testutils.With.USN(5), testutils.With.Dirty())
+	f.Note(b1, "deleted", testutils.With.USN(6), testutils.With.Dirty(), testutils.With.Deleted())
+	// a note that is deleted before it is ever synced is still a pending deletion
+	f.Note(b1, "created and deleted", testutils.With.Dirty(), testutils.With.Deleted())
+
+	conflicts := f.Book("conflicts", testutils.With.USN(7))
+	f.Note(conflicts, "conflict 1", testutils.With.USN(8))
+	f.Note(conflicts, "conflict 2", testutils.With.USN(9))
+	f.Note(conflicts, "resolved", testutils.With.USN(10), testutils.With.Deleted())
+	recovered := f.Book("recovered_2", testutils.With.USN(11))
+	f.Note(recovered, "recovered", testutils.With.USN(12))
+	// a recovered book that is emptied no longer needs a review
+	f.Book("recovered")
+
+	if err := database.UpsertSystem(ctx.DB, consts.SystemLastSyncAt, testutils.CmdNow.Add(-3*time.Hour).Unix()); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the last sync time"))
+	}
+	if err := database.UpsertSystem(ctx.DB, consts.SystemLastMaxUSN, "12"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the last max usn"))
+	}
+}
+
+func TestStatus(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupStatus(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd)
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	expected := `last sync:      3h ago
+last max usn:   12
+dirty notes:    2 created, 1 updated, 2 deleted
+dirty books:    1 created, 1 updated, 1 deleted
+books to review:
+  conflicts (2)
+  recovered_2 (1)
+sync lock:      not held
+api key:        not configured
+`
+	assert.Equal(t, string(result.Stdout), expected, "output mismatch")
+}
+
+func TestStatus_json(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupStatus(t, ctx)
+	if err := ctx.Credentials.Set("someSessionKey"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the session key"))
+	}
+
+	lockPath := filepath.Join(filepath.Dir(ctx.DB.Filepath), consts.SyncLockFilename)
+	if err := ioutil.WriteFile(lockPath, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the lock file"))
+	}
+	defer os.Remove(lockPath)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "--format", "json")
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	var got status
+	if err := json.Unmarshal(result.Stdout, &got); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding the output"))
+	}
+
+	expected := status{
+		LastSyncAt: testutils.CmdNow.Add(-3 * time.Hour).Unix(),
+		LastMaxUSN: "12",
+		Dirty: database.DirtyCounts{
+			Notes: database.ChangeCounts{Created: 2, Updated: 1, Deleted: 2},
+			Books: database.ChangeCounts{Created: 1, Updated: 1, Deleted: 1},
+		},
+		ReviewBooks: []reviewBook{
+			{Label: "conflicts", Notes: 2},
+			{Label: "recovered_2", Notes: 1},
+		},
+		SyncLocked:       true,
+		SyncLockPID:      os.Getpid(),
+		APIKeyConfigured: true,
+	}
+	assert.DeepEqual(t, got, expected, "status mismatch")
+}
+
+func TestStatus_empty(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	s, err := getStatus(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the status"))
+	}
+
+	assert.DeepEqual(t, s, status{ReviewBooks: []reviewBook{}}, "status mismatch")
+}
+
+func TestStatus_unknownFormat(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "--format", "yaml")
+	assert.NotEqual(t, result.Err, nil, "error mismatch")
+}
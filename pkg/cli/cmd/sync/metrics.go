@@ -0,0 +1,202 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+)
+
+// metrics is what the metrics file tells about a sync
+type metrics struct {
+	// Time is when the sync finished
+	Time    time.Time
+	Success bool
+	Report  dnote.SyncReport
+	// Dirty is the local changes left to upload, or nil if they could not
+	// be counted
+	Dirty *database.DirtyCounts
+	// Books is the number of the notes in each book, or nil if the metrics
+	// of the books are not written
+	Books []bookMetric
+}
+
+// bookMetric is the number of the notes in a book
+type bookMetric struct {
+	Label string
+	Notes int
+}
+
+// metricSample is a value of a metric, with at most one label
+type metricSample struct {
+	label string
+	value float64
+}
+
+// escapeLabelValue escapes a label value in the Prometheus text format, in
+// which a backslash, a double quote and a line feed are escaped
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// writeMetric writes a gauge with its help text and samples, or nothing if
+// there is no sample
+func writeMetric(b *bytes.Buffer, name, help string, samples ...metricSample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s gauge\n", name)
+
+	for _, s := range samples {
+		fmt.Fprintf(b, "%s%s %s\n", name, s.label, strconv.FormatFloat(s.value, 'f', -1, 64))
+	}
+}
+
+// label returns a label set with a single label
+func label(name, value string) string {
+	return fmt.Sprintf(`{%s="%s"}`, name, escapeLabelValue(value))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// formatMetrics formats the metrics in the Prometheus text format, as read by
+// the textfile collector of the node exporter
+func formatMetrics(m metrics) []byte {
+	var b bytes.Buffer
+	r := m.Report
+
+	writeMetric(&b, "dnote_sync_last_run_timestamp_seconds", "The time at which the last sync finished.",
+		metricSample{value: float64(m.Time.Unix())})
+	writeMetric(&b, "dnote_sync_success", "Whether the last sync succeeded.",
+		metricSample{value: boolValue(m.Success)})
+	writeMetric(&b, "dnote_sync_duration_seconds", "The time taken by the last sync.",
+		metricSample{value: r.Duration.Seconds()})
+
+	var durations, items []metricSample
+	for _, p := range r.Phases {
+		durations = append(durations, metricSample{label: label("phase", p.Name), value: p.Duration.Seconds()})
+		items = append(items, metricSample{label: label("phase", p.Name), value: float64(p.Items)})
+	}
+	writeMetric(&b, "dnote_sync_phase_duration_seconds", "The time spent in each phase of the last sync.", durations...)
+	writeMetric(&b, "dnote_sync_phase_items", "The number of the changes downloaded and of the notes and the books uploaded by the last sync.", items...)
+
+	writeMetric(&b, "dnote_sync_uploaded", "The number of the notes and the books created or updated by the last sync.",
+		metricSample{label: label("kind", "note"), value: float64(r.UploadedNotes)},
+		metricSample{label: label("kind", "book"), value: float64(r.UploadedBooks)})
+	writeMetric(&b, "dnote_sync_stopped_early", "Whether the last sync stopped before it got all the changes.",
+		metricSample{value: boolValue(r.StoppedEarly != "")})
+	if r.StoppedEarly != "" && r.Remaining >= 0 {
+		writeMetric(&b, "dnote_sync_remaining_changes", "The number of the changes left for the next sync.",
+			metricSample{value: float64(r.Remaining)})
+	}
+
+	if m.Dirty != nil {
+		writeMetric(&b, "dnote_sync_dirty", "The number of the local changes left to upload.",
+			metricSample{label: label("kind", "note"), value: float64(m.Dirty.Notes.Total())},
+			metricSample{label: label("kind", "book"), value: float64(m.Dirty.Books.Total())})
+	}
+
+	if m.Success {
+		writeMetric(&b, "dnote_notes", "The number of the notes after the last sync.",
+			metricSample{value: float64(r.Notes)})
+		writeMetric(&b, "dnote_books", "The number of the books after the last sync.",
+			metricSample{value: float64(r.Books)})
+	}
+
+	if m.Books != nil {
+		var samples []metricSample
+		for _, book := range m.Books {
+			samples = append(samples, metricSample{label: label("book", book.Label), value: float64(book.Notes)})
+		}
+		writeMetric(&b, "dnote_book_notes", "The number of the notes in each book.", samples...)
+	}
+
+	return b.Bytes()
+}
+
+// countBookNotes returns the number of the notes in each book, sorted by label
+func countBookNotes(db *database.DB) ([]bookMetric, error) {
+	rows, err := db.Query(`SELECT books.label, count(notes.uuid)
+		FROM books
+		LEFT JOIN notes ON notes.book_uuid = books.uuid AND NOT notes.deleted
+		WHERE NOT books.deleted
+		GROUP BY books.uuid
+		ORDER BY books.label`)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the books")
+	}
+	defer rows.Close()
+
+	ret := []bookMetric{}
+	for rows.Next() {
+		var b bookMetric
+		if err := rows.Scan(&b.Label, &b.Notes); err != nil {
+			return nil, errors.Wrap(err, "scanning a book")
+		}
+
+		ret = append(ret, b)
+	}
+
+	return ret, rows.Err()
+}
+
+// writeMetrics replaces the metrics file with the metrics of the sync. The
+// counts that cannot be got, such as when the database is locked, are left
+// out so that a failed sync is still reported.
+func writeMetrics(ctx context.DnoteCtx, path string, r dnote.SyncReport, success, books bool) error {
+	m := metrics{Time: ctx.Clock.Now(), Success: success, Report: r}
+
+	dirty, err := database.CountDirty(ctx.DB)
+	if err != nil {
+		log.Debug("counting the local changes for the metrics: %s\n", err.Error())
+	} else {
+		m.Dirty = &dirty
+	}
+
+	if books {
+		if m.Books, err = countBookNotes(ctx.DB); err != nil {
+			log.Debug("counting the notes of the books for the metrics: %s\n", err.Error())
+			m.Books = nil
+		}
+	}
+
+	if err := utils.WriteFileAtomic(path, formatMetrics(m), 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", path)
+	}
+
+	return nil
+}
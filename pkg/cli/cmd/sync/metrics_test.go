@@ -0,0 +1,136 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/dnote"
+)
+
+func TestFormatMetrics(t *testing.T) {
+	finishedAt := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+	report := dnote.SyncReport{
+		Backend:       "server",
+		UploadedNotes: 15,
+		UploadedBooks: 2,
+		Notes:         1204,
+		Books:         31,
+		Duration:      2100 * time.Millisecond,
+		Remaining:     -1,
+		Phases: []dnote.SyncPhase{
+			{Name: "download", Items: 1204, Duration: 600 * time.Millisecond},
+			{Name: "apply", Duration: 300 * time.Millisecond},
+			{Name: "upload", Items: 17, Duration: 1200 * time.Millisecond},
+			{Name: "clean"},
+		},
+	}
+	dirty := database.DirtyCounts{
+		Notes: database.ChangeCounts{Created: 1, Updated: 2, Deleted: 1},
+		Books: database.ChangeCounts{Created: 1},
+	}
+
+	stopped := report
+	stopped.StoppedEarly = "ops_cap"
+	stopped.Remaining = 350
+
+	testCases := []struct {
+		name    string
+		metrics metrics
+	}{
+		{
+			name:    "success",
+			metrics: metrics{Time: finishedAt, Success: true, Report: report, Dirty: &dirty},
+		},
+		{
+			name:    "failure",
+			metrics: metrics{Time: finishedAt, Success: false, Report: dnote.SyncReport{Backend: "server", Remaining: -1}, Dirty: &dirty},
+		},
+		{
+			name:    "stopped_early",
+			metrics: metrics{Time: finishedAt, Success: true, Report: stopped},
+		},
+		{
+			name: "books",
+			metrics: metrics{Time: finishedAt, Success: true, Report: report, Dirty: &dirty, Books: []bookMetric{
+				{Label: "linux", Notes: 120},
+				{Label: `say "hi"`, Notes: 3},
+				{Label: `C:\notes`, Notes: 1},
+				{Label: "two\nlines", Notes: 0},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.AssertGolden(t, formatMetrics(tc.metrics), "metrics_"+tc.name)
+		})
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "linux", expected: "linux"},
+		{input: "", expected: ""},
+		{input: `say "hi"`, expected: `say \"hi\"`},
+		{input: `C:\notes`, expected: `C:\\notes`},
+		{input: "two\nlines", expected: `two\nlines`},
+		{input: `\"`, expected: `\\\"`},
+		{input: "日本語", expected: "日本語"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			assert.Equal(t, escapeLabelValue(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestCountBookNotes(t *testing.T) {
+	db := database.InitTestDB(t, "../../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	f := testutils.NewFixture(t, db)
+	b1 := f.Book("linux")
+	b2 := f.Book("recipes")
+	f.Book("empty")
+	f.Book("removed", testutils.With.Deleted())
+	f.Note(b1, "n1 body")
+	f.Note(b1, "n2 body")
+	f.Note(b1, "n3 body", testutils.With.Deleted())
+	f.Note(b2, "n4 body")
+
+	got, err := countBookNotes(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.DeepEqual(t, got, []bookMetric{
+		{Label: "empty", Notes: 0},
+		{Label: "linux", Notes: 2},
+		{Label: "recipes", Notes: 1},
+	}, "result mismatch")
+}
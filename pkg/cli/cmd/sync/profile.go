@@ -0,0 +1,109 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+
+	"github.com/pkg/errors"
+)
+
+// The names of the files written to the profile directory
+const (
+	cpuProfileFilename  = "cpu.pprof"
+	heapProfileFilename = "heap.pprof"
+	traceFilename       = "trace.out"
+)
+
+// profiler writes the CPU and heap profiles, and optionally an execution trace,
+// of a sync to a directory
+type profiler struct {
+	dir       string
+	cpuFile   *os.File
+	traceFile *os.File
+}
+
+// startProfile starts profiling the CPU, and tracing the execution if withTrace
+// is true. The heap profile is written when the profiler is stopped.
+func startProfile(dir string, withTrace bool) (*profiler, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "creating the profile directory")
+	}
+
+	p := &profiler{dir: dir}
+
+	cpuFile, err := os.Create(filepath.Join(dir, cpuProfileFilename))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the CPU profile")
+	}
+	if err := pprof.StartCPUProfile(cpuFile); err != nil {
+		cpuFile.Close()
+		return nil, errors.Wrap(err, "starting the CPU profile")
+	}
+	p.cpuFile = cpuFile
+
+	if withTrace {
+		traceFile, err := os.Create(filepath.Join(dir, traceFilename))
+		if err != nil {
+			p.stop()
+			return nil, errors.Wrap(err, "creating the trace")
+		}
+		if err := trace.Start(traceFile); err != nil {
+			traceFile.Close()
+			p.stop()
+			return nil, errors.Wrap(err, "starting the trace")
+		}
+		p.traceFile = traceFile
+	}
+
+	return p, nil
+}
+
+// stop stops the profiles and writes the heap profile
+func (p *profiler) stop() error {
+	if p.traceFile != nil {
+		trace.Stop()
+		if err := p.traceFile.Close(); err != nil {
+			return errors.Wrap(err, "closing the trace")
+		}
+	}
+
+	pprof.StopCPUProfile()
+	if err := p.cpuFile.Close(); err != nil {
+		return errors.Wrap(err, "closing the CPU profile")
+	}
+
+	heapFile, err := os.Create(filepath.Join(p.dir, heapProfileFilename))
+	if err != nil {
+		return errors.Wrap(err, "creating the heap profile")
+	}
+	defer heapFile.Close()
+
+	// collect the garbage so that the profile shows the live objects
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(heapFile); err != nil {
+		return errors.Wrap(err, "writing the heap profile")
+	}
+
+	return nil
+}
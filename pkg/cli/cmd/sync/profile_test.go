@@ -0,0 +1,72 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func TestProfile(t *testing.T) {
+	testCases := []struct {
+		withTrace bool
+		expected  []string
+	}{
+		{
+			withTrace: false,
+			expected:  []string{cpuProfileFilename, heapProfileFilename},
+		},
+		{
+			withTrace: true,
+			expected:  []string{cpuProfileFilename, heapProfileFilename, traceFilename},
+		},
+	}
+
+	for _, tc := range testCases {
+		dir := filepath.Join(t.TempDir(), "profile")
+
+		p, err := startProfile(dir, tc.withTrace)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "starting the profile"))
+		}
+		if err := p.stop(); err != nil {
+			t.Fatal(errors.Wrap(err, "stopping the profile"))
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading the profile directory"))
+		}
+
+		var names []string
+		for _, f := range files {
+			if f.Size() == 0 {
+				t.Errorf("%s is empty", f.Name())
+			}
+
+			names = append(names, f.Name())
+		}
+
+		assert.DeepEqual(t, names, tc.expected, "files mismatch")
+	}
+}
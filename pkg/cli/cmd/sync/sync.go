@@ -19,950 +19,472 @@
 package sync
 
 import (
-	"database/sql"
-	"fmt"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"time"
 
-	"github.com/dnote/dnote/pkg/cli/client"
-	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/cmd/retention"
+	"github.com/dnote/dnote/pkg/cli/config"
 	"github.com/dnote/dnote/pkg/cli/context"
-	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
-	"github.com/dnote/dnote/pkg/cli/migrate"
+	"github.com/dnote/dnote/pkg/cli/output"
 	"github.com/dnote/dnote/pkg/cli/upgrade"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/dnote"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
-const (
-	modeInsert = iota
-	modeUpdate
-)
-
 var example = `
-  dnote sync`
+  dnote sync
 
-var isFullSync bool
+  * Get the changes from the server without sending the local ones
+  dnote sync --pull-only
 
-// NewCmd returns a new sync command
-func NewCmd(ctx context.DnoteCtx) *cobra.Command {
-	cmd := &cobra.Command{
-		Use:     "sync",
-		Aliases: []string{"s"},
-		Short:   "Sync data with the server",
-		Example: example,
-		RunE:    newRun(ctx),
-	}
+  * Apply the changes that discard local data without confirming them
+  dnote sync --full --force
 
-	f := cmd.Flags()
-	f.BoolVarP(&isFullSync, "full", "f", false, "perform a full sync instead of incrementally syncing only the changed data.")
+  * Stop after downloading about 5MB or applying 200 changes, e.g. when tethered
+  dnote sync --max-download 5MB --max-ops 200
 
-	return cmd
-}
+  * Give up on a slow connection after a minute, keeping the changes got so far
+  dnote sync --total-timeout 1m
 
-func getLastSyncAt(tx *database.DB) (int, error) {
-	var ret int
+  * Upload the oldest new notes that fit in the limits of the account
+  dnote sync --partial
 
-	if err := database.GetSystem(tx, consts.SystemLastSyncAt, &ret); err != nil {
-		return ret, errors.Wrap(err, "querying last sync time")
-	}
+  * Keep only some of the books on this device, starting from a new database
+  dnote sync --books linux,recipes --initial
 
-	return ret, nil
-}
+  * Add a book to those kept on this device
+  dnote sync --books linux,recipes,go
 
-func getLastMaxUSN(tx *database.DB) (int, error) {
-	var ret int
+  * List the notes and books that only the local copy or the server has,
+  * without changing anything
+  dnote sync --preview-clean
 
-	if err := database.GetSystem(tx, consts.SystemLastMaxUSN, &ret); err != nil {
-		return ret, errors.Wrap(err, "querying last user max_usn")
-	}
+  * Print the retries and the timeouts that a sync would use
+  dnote sync --max-retries 5 --print-config
 
-	return ret, nil
-}
+  * Print the result or the error as JSON, e.g. for a script
+  dnote sync --format json
 
-// syncList is an aggregation of resources represented in the sync fragments
-type syncList struct {
-	Notes          map[string]client.SyncFragNote
-	Books          map[string]client.SyncFragBook
-	ExpungedNotes  map[string]bool
-	ExpungedBooks  map[string]bool
-	MaxUSN         int
-	MaxCurrentTime int64
-}
+  * Write the metrics of the sync for the textfile collector of the node exporter
+  dnote sync --metrics-file /var/lib/node_exporter/textfile/dnote.prom`
 
-func (l syncList) getLength() int {
-	return len(l.Notes) + len(l.Books) + len(l.ExpungedNotes) + len(l.ExpungedBooks)
-}
+const (
+	// formatText is the human readable output
+	formatText = "text"
+	// formatJSON is the machine readable output
+	formatJSON = "json"
+)
 
-// processFragments categorizes items in sync fragments into a sync list. It also decrypts any
-// encrypted data in sync fragments.
-func processFragments(fragments []client.SyncFragment) (syncList, error) {
-	notes := map[string]client.SyncFragNote{}
-	books := map[string]client.SyncFragBook{}
-	expungedNotes := map[string]bool{}
-	expungedBooks := map[string]bool{}
-	var maxUSN int
-	var maxCurrentTime int64
-
-	for _, fragment := range fragments {
-		for _, note := range fragment.Notes {
-			notes[note.UUID] = note
-		}
-		for _, book := range fragment.Books {
-			books[book.UUID] = book
-		}
-		for _, uuid := range fragment.ExpungedBooks {
-			expungedBooks[uuid] = true
-		}
-		for _, uuid := range fragment.ExpungedNotes {
-			expungedNotes[uuid] = true
-		}
+var isFullSync bool
+var pullOnlyFlag bool
+var pushOnlyFlag bool
+var forceFlag bool
+var profileDir string
+var profileTrace bool
+var formatFlag string
+var maxDownloadFlag string
+var maxOpsFlag int
+var maxRetriesFlag int
+var retryBaseDelayFlag time.Duration
+var requestTimeoutFlag time.Duration
+var totalTimeoutFlag time.Duration
+var printConfigFlag bool
+var partialFlag bool
+var previewCleanFlag bool
+var booksFlag []string
+var initialFlag bool
+var metricsFileFlag string
+var metricsBooksFlag bool
+
+// maxDownload is the parsed value of maxDownloadFlag
+var maxDownload int64
 
-		if fragment.FragMaxUSN > maxUSN {
-			maxUSN = fragment.FragMaxUSN
-		}
-		if fragment.CurrentTime > maxCurrentTime {
-			maxCurrentTime = fragment.CurrentTime
-		}
+// NewCmd returns a new sync command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "sync",
+		Aliases: []string{"s"},
+		Short:   "Sync data with the server",
+		Example: example,
+		PreRunE: preRun,
+		RunE:    output.JSONErrors(&formatFlag, newRun(ctx)),
 	}
 
-	sl := syncList{
-		Notes:          notes,
-		Books:          books,
-		ExpungedNotes:  expungedNotes,
-		ExpungedBooks:  expungedBooks,
-		MaxUSN:         maxUSN,
-		MaxCurrentTime: maxCurrentTime,
-	}
+	f := cmd.Flags()
+	f.BoolVarP(&isFullSync, "full", "f", false, "perform a full sync instead of incrementally syncing only the changed data.")
+	f.BoolVarP(&pullOnlyFlag, "pull-only", "", false, "get the changes from the server without sending the local changes")
+	f.BoolVarP(&pushOnlyFlag, "push-only", "", false, "send the local changes without getting the changes from the server")
+	f.BoolVarP(&forceFlag, "force", "", false, "apply the changes that discard local data regardless of the sync safety in the config")
+	f.StringVarP(&maxDownloadFlag, "max-download", "", "", "stop after downloading the changes of the given size, such as 5MB. The next sync continues from there")
+	f.IntVarP(&maxOpsFlag, "max-ops", "", 0, "stop after getting the given number of changes. The next sync continues from there")
+	f.IntVarP(&maxRetriesFlag, "max-retries", "", 0, "retry a failed request to the server the given number of times, overriding sync.maxRetries in the config")
+	f.DurationVarP(&retryBaseDelayFlag, "retry-base-delay", "", 0, "wait the given duration before the first retry, doubling it for each retry, overriding sync.retryBaseDelay in the config")
+	f.DurationVarP(&requestTimeoutFlag, "request-timeout", "", 0, "give up on a request to the server after the given duration, overriding sync.requestTimeout in the config. 0 is no limit")
+	f.DurationVarP(&totalTimeoutFlag, "total-timeout", "", 0, "stop after the given duration, keeping the changes got so far, overriding sync.totalTimeout in the config. 0 is no limit")
+	f.BoolVarP(&partialFlag, "partial", "", false, "upload the oldest of the new notes and books that fit in the limits of the account when not all of them do")
+	f.StringSliceVarP(&booksFlag, "books", "", nil, "sync only the books with the given comma-separated labels. The later syncs keep to them, and books can be added to them by giving them all with the new ones")
+	f.BoolVarP(&initialFlag, "initial", "", false, "set the books given by --books on the first sync of a new database")
+	f.BoolVarP(&previewCleanFlag, "preview-clean", "", false, "list the notes and books that only the local copy or the server has, which a full sync would upload, expunge or download, without changing anything")
+	f.BoolVarP(&printConfigFlag, "print-config", "", false, "print the retries and the timeouts that the sync would use, and exit")
+	f.StringVarP(&formatFlag, "format", "", formatText, "the output format, which is text or json. The progress is written to the error output in json")
+	f.StringVarP(&metricsFileFlag, "metrics-file", "", "", "write the metrics of the sync in the Prometheus text format to the given file, replacing it, whether the sync succeeds or not")
+	f.BoolVarP(&metricsBooksFlag, "metrics-books", "", false, "also write the number of the notes in each book to the metrics file")
+	f.StringVarP(&profileDir, "profile", "", "", "write the CPU and heap profiles of the sync to the given directory")
+	f.BoolVarP(&profileTrace, "profile-trace", "", false, "also write an execution trace to the profile directory")
+	f.MarkHidden("profile")
+	f.MarkHidden("profile-trace")
 
-	return sl, nil
+	return cmd
 }
 
-// getSyncList gets a list of all sync fragments after the specified usn
-// and aggregates them into a syncList data structure
-func getSyncList(ctx context.DnoteCtx, afterUSN int) (syncList, error) {
-	fragments, err := getSyncFragments(ctx, afterUSN)
-	if err != nil {
-		return syncList{}, errors.Wrap(err, "getting sync fragments")
+func preRun(cmd *cobra.Command, args []string) error {
+	if pullOnlyFlag && pushOnlyFlag {
+		return errors.New("--pull-only and --push-only cannot be used together")
 	}
 
-	ret, err := processFragments(fragments)
-	if err != nil {
-		return syncList{}, errors.Wrap(err, "making sync list")
+	switch formatFlag {
+	case formatText, formatJSON:
+	default:
+		return errors.Errorf("unknown format '%s'. Use text or json", formatFlag)
 	}
 
-	return ret, nil
-}
-
-// getSyncFragments repeatedly gets all sync fragments after the specified usn until there is no more new data
-// remaining and returns the buffered list
-func getSyncFragments(ctx context.DnoteCtx, afterUSN int) ([]client.SyncFragment, error) {
-	var buf []client.SyncFragment
-
-	nextAfterUSN := afterUSN
-
-	for {
-		resp, err := client.GetSyncFragment(ctx, nextAfterUSN)
+	maxDownload = 0
+	if maxDownloadFlag != "" {
+		n, err := utils.ParseByteSize(maxDownloadFlag)
 		if err != nil {
-			return buf, errors.Wrap(err, "getting sync fragment")
-		}
-
-		frag := resp.Fragment
-		buf = append(buf, frag)
-
-		nextAfterUSN = frag.FragMaxUSN
-
-		// if there is no more data, break
-		if nextAfterUSN == 0 {
-			break
+			return errors.Wrap(err, "invalid --max-download")
 		}
-	}
-
-	log.Debug("received sync fragments: %+v\n", buf)
-
-	return buf, nil
-}
-
-// resolveLabel resolves a book label conflict by repeatedly appending an increasing integer
-// to the label until it finds a unique label. It returns the first non-conflicting label.
-func resolveLabel(tx *database.DB, label string) (string, error) {
-	var ret string
-
-	for i := 2; ; i++ {
-		ret = fmt.Sprintf("%s_%d", label, i)
-
-		var cnt int
-		if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", ret).Scan(&cnt); err != nil {
-			return "", errors.Wrapf(err, "checking availability of label %s", ret)
+		if n == 0 {
+			return errors.New("--max-download must be greater than 0")
 		}
 
-		if cnt == 0 {
-			break
-		}
+		maxDownload = n
 	}
-
-	return ret, nil
-}
-
-// mergeBook inserts or updates the given book in the local database.
-// If a book with a duplicate label exists locally, it renames the duplicate by appending a number.
-func mergeBook(tx *database.DB, b client.SyncFragBook, mode int) error {
-	var count int
-	if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", b.Label).Scan(&count); err != nil {
-		return errors.Wrapf(err, "checking for books with a duplicate label %s", b.Label)
+	if maxOpsFlag < 0 {
+		return errors.New("--max-ops cannot be negative")
 	}
-
-	// if duplicate exists locally, rename it and mark it dirty
-	if count > 0 {
-		newLabel, err := resolveLabel(tx, b.Label)
-		if err != nil {
-			return errors.Wrap(err, "getting a new book label for conflict resolution")
-		}
-
-		if _, err := tx.Exec("UPDATE books SET label = ?, dirty = ? WHERE label = ?", newLabel, true, b.Label); err != nil {
-			return errors.Wrap(err, "resolving duplicate book label")
-		}
+	if initialFlag && len(booksFlag) == 0 {
+		return errors.New("--initial needs the books to sync, given by --books")
 	}
-
-	if mode == modeInsert {
-		book := database.NewBook(b.UUID, b.Label, b.USN, false, false)
-		if err := book.Insert(tx); err != nil {
-			return errors.Wrapf(err, "inserting note with uuid %s", b.UUID)
-		}
-	} else if mode == modeUpdate {
-		// The state from the server overwrites the local state. In other words, the server change always wins.
-		if _, err := tx.Exec("UPDATE books SET usn = ?, uuid = ?, label = ?, deleted = ? WHERE uuid = ?",
-			b.USN, b.UUID, b.Label, b.Deleted, b.UUID); err != nil {
-			return errors.Wrapf(err, "updating local book %s", b.UUID)
-		}
+	if len(booksFlag) > 0 && pushOnlyFlag {
+		return errors.New("--books and --push-only cannot be used together")
 	}
-
-	return nil
-}
-
-func stepSyncBook(tx *database.DB, b client.SyncFragBook) error {
-	var localUSN int
-	var dirty bool
-	err := tx.QueryRow("SELECT usn, dirty FROM books WHERE uuid = ?", b.UUID).Scan(&localUSN, &dirty)
-	if err != nil && err != sql.ErrNoRows {
-		return errors.Wrapf(err, "getting local book %s", b.UUID)
-	}
-
-	// if book exists in the server and does not exist in the client
-	if err == sql.ErrNoRows {
-		if e := mergeBook(tx, b, modeInsert); e != nil {
-			return errors.Wrapf(e, "resolving book")
-		}
-
-		return nil
-	}
-
-	if e := mergeBook(tx, b, modeUpdate); e != nil {
-		return errors.Wrapf(e, "resolving book")
+	if metricsBooksFlag && metricsFileFlag == "" {
+		return errors.New("--metrics-books needs the metrics file, given by --metrics-file")
 	}
 
 	return nil
 }
 
-func mergeNote(tx *database.DB, serverNote client.SyncFragNote, localNote database.Note) error {
-	var bookDeleted bool
-	err := tx.QueryRow("SELECT deleted FROM books WHERE uuid = ?", localNote.BookUUID).Scan(&bookDeleted)
-	if err != nil {
-		return errors.Wrapf(err, "checking if local book %s is deleted", localNote.BookUUID)
-	}
+// getTimeouts returns the retries and the timeouts of the sync in the config,
+// overridden by the flags that are set
+func getTimeouts(cmd *cobra.Command, ctx context.DnoteCtx) (context.SyncTimeouts, error) {
+	ret := ctx.SyncTimeouts
 
-	// if the book is deleted, noop
-	if bookDeleted {
-		return nil
+	f := cmd.Flags()
+	if f.Changed("max-retries") {
+		ret.MaxRetries = maxRetriesFlag
 	}
-
-	// if the local copy is deleted, and it was edited on the server, override with server values and mark it not dirty.
-	if localNote.Deleted {
-		if _, err := tx.Exec("UPDATE notes SET usn = ?, book_uuid = ?, body = ?, edited_on = ?, deleted = ?, public = ?, dirty = ? WHERE uuid = ?",
-			serverNote.USN, serverNote.BookUUID, serverNote.Body, serverNote.EditedOn, serverNote.Deleted, serverNote.Public, false, serverNote.UUID); err != nil {
-			return errors.Wrapf(err, "updating local note %s", serverNote.UUID)
-		}
-
-		return nil
+	if f.Changed("retry-base-delay") {
+		ret.RetryBaseDelay = retryBaseDelayFlag
 	}
-
-	mr, err := mergeNoteFields(tx, localNote, serverNote)
-	if err != nil {
-		return errors.Wrapf(err, "reporting note conflict for note %s", localNote.UUID)
+	if f.Changed("request-timeout") {
+		ret.RequestTimeout = requestTimeoutFlag
 	}
-
-	if _, err := tx.Exec("UPDATE notes SET usn = ?, book_uuid = ?, body = ?, edited_on = ?, deleted = ?  WHERE uuid = ?",
-		serverNote.USN, mr.bookUUID, mr.body, mr.editedOn, serverNote.Deleted, serverNote.UUID); err != nil {
-		return errors.Wrapf(err, "updating local note %s", serverNote.UUID)
+	if f.Changed("total-timeout") {
+		ret.TotalTimeout = totalTimeoutFlag
 	}
 
-	return nil
-}
-
-func stepSyncNote(tx *database.DB, n client.SyncFragNote) error {
-	var localNote database.Note
-	err := tx.QueryRow("SELECT body, usn, book_uuid, dirty, deleted FROM notes WHERE uuid = ?", n.UUID).
-		Scan(&localNote.Body, &localNote.USN, &localNote.BookUUID, &localNote.Dirty, &localNote.Deleted)
-	if err != nil && err != sql.ErrNoRows {
-		return errors.Wrapf(err, "getting local note %s", n.UUID)
+	if err := config.ValidateSyncTimeouts(ret); err != nil {
+		return ret, errors.Wrap(err, "invalid retries or timeouts")
 	}
 
-	// if note exists in the server and does not exist in the client, insert the note.
-	if err == sql.ErrNoRows {
-		note := database.NewNote(n.UUID, n.BookUUID, n.Body, n.AddedOn, n.EditedOn, n.USN, n.Public, n.Deleted, false)
-
-		if err := note.Insert(tx); err != nil {
-			return errors.Wrapf(err, "inserting note with uuid %s", n.UUID)
-		}
-	} else {
-		if err := mergeNote(tx, n, localNote); err != nil {
-			return errors.Wrap(err, "merging local note")
-		}
-	}
+	return ret, nil
+}
 
-	return nil
+// timeoutsReport is the machine readable form of the retries and the timeouts
+// of a sync, in which a duration of 0 is no limit
+type timeoutsReport struct {
+	MaxRetries       int   `json:"max_retries"`
+	RetryBaseDelayMs int64 `json:"retry_base_delay_ms"`
+	RequestTimeoutMs int64 `json:"request_timeout_ms"`
+	TotalTimeoutMs   int64 `json:"total_timeout_ms"`
 }
 
-func fullSyncNote(tx *database.DB, n client.SyncFragNote) error {
-	var localNote database.Note
-	err := tx.QueryRow("SELECT body, usn, book_uuid, dirty, deleted FROM notes WHERE uuid = ?", n.UUID).
-		Scan(&localNote.Body, &localNote.USN, &localNote.BookUUID, &localNote.Dirty, &localNote.Deleted)
-	if err != nil && err != sql.ErrNoRows {
-		return errors.Wrapf(err, "getting local note %s", n.UUID)
+// formatLimit formats a timeout, of which 0 is no limit
+func formatLimit(d time.Duration) string {
+	if d == 0 {
+		return i18n.T("sync.no_limit")
 	}
 
-	// if note exists in the server and does not exist in the client, insert the note.
-	if err == sql.ErrNoRows {
-		note := database.NewNote(n.UUID, n.BookUUID, n.Body, n.AddedOn, n.EditedOn, n.USN, n.Public, n.Deleted, false)
+	return d.String()
+}
 
-		if err := note.Insert(tx); err != nil {
-			return errors.Wrapf(err, "inserting note with uuid %s", n.UUID)
+// printTimeouts prints the retries and the timeouts of a sync
+func printTimeouts(ctx context.DnoteCtx, t context.SyncTimeouts) error {
+	if formatFlag == formatJSON {
+		v := timeoutsReport{
+			MaxRetries:       t.MaxRetries,
+			RetryBaseDelayMs: t.RetryBaseDelay.Milliseconds(),
+			RequestTimeoutMs: t.RequestTimeout.Milliseconds(),
+			TotalTimeoutMs:   t.TotalTimeout.Milliseconds(),
 		}
-	} else if n.USN > localNote.USN {
-		if err := mergeNote(tx, n, localNote); err != nil {
-			return errors.Wrap(err, "merging local note")
+		if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+			return errors.Wrap(err, "encoding the config")
 		}
-	}
-
-	return nil
-}
-
-func syncDeleteNote(tx *database.DB, noteUUID string) error {
-	var localUSN int
-	var dirty bool
-	err := tx.QueryRow("SELECT usn, dirty FROM notes WHERE uuid = ?", noteUUID).Scan(&localUSN, &dirty)
-	if err != nil && err != sql.ErrNoRows {
-		return errors.Wrapf(err, "getting local note %s", noteUUID)
-	}
 
-	// if note does not exist on client, noop
-	if err == sql.ErrNoRows {
 		return nil
 	}
 
-	// if local copy is not dirty, delete
-	if !dirty {
-		_, err = tx.Exec("DELETE FROM notes WHERE uuid = ?", noteUUID)
-		if err != nil {
-			return errors.Wrapf(err, "deleting local note %s", noteUUID)
-		}
-	}
+	ctx.Logger.Printf("%s", i18n.T("sync.config_max_retries", t.MaxRetries))
+	ctx.Logger.Printf("%s", i18n.T("sync.config_retry_base_delay", t.RetryBaseDelay))
+	ctx.Logger.Printf("%s", i18n.T("sync.config_request_timeout", formatLimit(t.RequestTimeout)))
+	ctx.Logger.Printf("%s", i18n.T("sync.config_total_timeout", formatLimit(t.TotalTimeout)))
 
 	return nil
 }
 
-// checkNotesPristine checks that none of the notes in the given book are dirty
-func checkNotesPristine(tx *database.DB, bookUUID string) (bool, error) {
-	var count int
-	if err := tx.QueryRow("SELECT count(*) FROM notes WHERE book_uuid = ? AND dirty = ?", bookUUID, true).Scan(&count); err != nil {
-		return false, errors.Wrapf(err, "counting notes that are dirty in book %s", bookUUID)
-	}
-
-	if count > 0 {
-		return false, nil
-	}
+// previewItem is the machine readable form of a note or a book in a preview of
+// a full sync
+type previewItem struct {
+	Kind        string `json:"kind"`
+	UUID        string `json:"uuid"`
+	Description string `json:"description"`
+	Unsynced    bool   `json:"unsynced"`
+}
 
-	return true, nil
+// previewReport is the machine readable result of the preview of a full sync
+type previewReport struct {
+	Upload   []previewItem `json:"upload"`
+	Expunge  []previewItem `json:"expunge"`
+	Download []previewItem `json:"download"`
 }
 
-func syncDeleteBook(tx *database.DB, bookUUID string) error {
-	var localUSN int
-	var dirty bool
-	err := tx.QueryRow("SELECT usn, dirty FROM books WHERE uuid = ?", bookUUID).Scan(&localUSN, &dirty)
-	if err != nil && err != sql.ErrNoRows {
-		return errors.Wrapf(err, "getting local book %s", bookUUID)
+// toPreviewItems returns the machine readable form of the items, which is an
+// empty list rather than null if there are none
+func toPreviewItems(items []dnote.SyncCleanItem) []previewItem {
+	ret := []previewItem{}
+	for _, i := range items {
+		ret = append(ret, previewItem{Kind: i.Kind, UUID: i.UUID, Description: i.Description, Unsynced: i.Unsynced})
 	}
 
-	// if book does not exist on client, noop
-	if err == sql.ErrNoRows {
-		return nil
-	}
+	return ret
+}
 
-	// if local copy is dirty, noop. it will be uploaded to the server later
-	if dirty {
-		return nil
+// previewClean prints the notes and the books that a full sync would upload,
+// expunge or download
+func previewClean(ctx context.DnoteCtx, timeouts context.SyncTimeouts) error {
+	if formatFlag == formatJSON {
+		// leave the standard output to the JSON
+		out, errOut := ctx.Logger.Output()
+		ctx.Logger.SetOutput(errOut, errOut)
+		defer ctx.Logger.SetOutput(out, errOut)
 	}
 
-	ok, err := checkNotesPristine(tx, bookUUID)
+	syncer := dnote.NewSyncer(dnote.NewStore(ctx))
+	p, err := syncer.PreviewClean(ctx.Context(), dnote.SyncOptions{Timeouts: &timeouts})
 	if err != nil {
-		return errors.Wrap(err, "checking if any notes are dirty in book")
+		return err
 	}
-	// if the local book is not pristine, do not delete but mark it as dirty
-	// so that it can be uploaded to the server later and become un-deleted
-	if !ok {
-		_, err = tx.Exec("UPDATE books SET dirty = ? WHERE uuid = ?", true, bookUUID)
-		if err != nil {
-			return errors.Wrapf(err, "marking a book dirty with uuid %s", bookUUID)
+
+	if formatFlag == formatJSON {
+		v := previewReport{
+			Upload:   toPreviewItems(p.Upload),
+			Expunge:  toPreviewItems(p.Expunge),
+			Download: toPreviewItems(p.Download),
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+			return errors.Wrap(err, "encoding the preview")
 		}
 
 		return nil
 	}
 
-	_, err = tx.Exec("DELETE FROM notes WHERE book_uuid = ?", bookUUID)
-	if err != nil {
-		return errors.Wrapf(err, "deleting local notes of the book %s", bookUUID)
+	if len(p.Upload) == 0 && len(p.Expunge) == 0 && len(p.Download) == 0 {
+		ctx.Logger.Successf("%s", i18n.T("sync.preview_none"))
+		return nil
 	}
 
-	_, err = tx.Exec("DELETE FROM books WHERE uuid = ?", bookUUID)
-	if err != nil {
-		return errors.Wrapf(err, "deleting local book %s", bookUUID)
-	}
+	printGroup(ctx, i18n.T("sync.preview_upload", len(p.Upload)), p.Upload, false)
+	printGroup(ctx, i18n.T("sync.preview_expunge", len(p.Expunge)), p.Expunge, true)
+	printGroup(ctx, i18n.T("sync.preview_download", len(p.Download)), p.Download, false)
 
 	return nil
 }
 
-func fullSyncBook(tx *database.DB, b client.SyncFragBook) error {
-	var localUSN int
-	var dirty bool
-	err := tx.QueryRow("SELECT usn, dirty FROM books WHERE uuid = ?", b.UUID).Scan(&localUSN, &dirty)
-	if err != nil && err != sql.ErrNoRows {
-		return errors.Wrapf(err, "getting local book %s", b.UUID)
+// printGroup prints a group of the notes and the books in a preview of a full
+// sync under the header, if it is not empty. The expunged ones with unsynced
+// changes are marked.
+func printGroup(ctx context.DnoteCtx, header string, items []dnote.SyncCleanItem, expunge bool) {
+	if len(items) == 0 {
+		return
 	}
 
-	// if book exists in the server and does not exist in the client
-	if err == sql.ErrNoRows {
-		if e := mergeBook(tx, b, modeInsert); e != nil {
-			return errors.Wrapf(e, "resolving book")
+	ctx.Logger.Printf("%s", header)
+	for _, item := range items {
+		desc := item.String()
+		if expunge && item.Unsynced {
+			desc = i18n.T("sync.preview_unsynced", desc)
 		}
-	} else if b.USN > localUSN {
-		if e := mergeBook(tx, b, modeUpdate); e != nil {
-			return errors.Wrapf(e, "resolving book")
-		}
-	}
 
-	return nil
-}
-
-// checkNoteInList checks if the given syncList contains the note with the given uuid
-func checkNoteInList(uuid string, list *syncList) bool {
-	if _, ok := list.Notes[uuid]; ok {
-		return true
+		ctx.Logger.Plainf("  %s\n", desc)
 	}
-
-	if _, ok := list.ExpungedNotes[uuid]; ok {
-		return true
-	}
-
-	return false
 }
 
-// checkBookInList checks if the given syncList contains the book with the given uuid
-func checkBookInList(uuid string, list *syncList) bool {
-	if _, ok := list.Books[uuid]; ok {
-		return true
-	}
-
-	if _, ok := list.ExpungedBooks[uuid]; ok {
-		return true
-	}
-
-	return false
-}
-
-// cleanLocalNotes deletes from the local database any notes that are in invalid state
-// judging by the full list of resources in the server. Concretely, the only acceptable
-// situation in which a local note is not present in the server is if it is new and has not been
-// uploaded (i.e. dirty and usn is 0). Otherwise, it is a result of some kind of error and should be cleaned.
-func cleanLocalNotes(tx *database.DB, fullList *syncList) error {
-	rows, err := tx.Query("SELECT uuid, usn, dirty FROM notes")
-	if err != nil {
-		return errors.Wrap(err, "getting local notes")
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var note database.Note
-		if err := rows.Scan(&note.UUID, &note.USN, &note.Dirty); err != nil {
-			return errors.Wrap(err, "scanning a row for local note")
-		}
-
-		ok := checkNoteInList(note.UUID, fullList)
-		if !ok && (!note.Dirty || note.USN != 0) {
-			err = note.Expunge(tx)
-			if err != nil {
-				return errors.Wrap(err, "expunging a note")
-			}
-		}
-	}
-
-	return nil
+// report is the machine readable result of a sync
+type report struct {
+	Backend       string `json:"backend"`
+	UploadedNotes int    `json:"uploaded_notes"`
+	UploadedBooks int    `json:"uploaded_books"`
+	Notes         int    `json:"notes"`
+	Books         int    `json:"books"`
+	// DurationMs is the duration of the sync in milliseconds
+	DurationMs int64 `json:"duration_ms"`
+	// StoppedEarly is the limit that stopped the sync, or empty
+	StoppedEarly string `json:"stopped_early,omitempty"`
+	// Remaining is the number of the changes left if the sync stopped early,
+	// or -1 if it is unknown
+	Remaining *int `json:"remaining,omitempty"`
+	// Phases is the time spent in each phase of the sync
+	Phases []phaseReport `json:"phases"`
 }
 
-// cleanLocalBooks deletes from the local database any books that are in invalid state
-func cleanLocalBooks(tx *database.DB, fullList *syncList) error {
-	rows, err := tx.Query("SELECT uuid, usn, dirty FROM books")
-	if err != nil {
-		return errors.Wrap(err, "getting local books")
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var book database.Book
-		if err := rows.Scan(&book.UUID, &book.USN, &book.Dirty); err != nil {
-			return errors.Wrap(err, "scanning a row for local book")
-		}
-
-		ok := checkBookInList(book.UUID, fullList)
-		if !ok && (!book.Dirty || book.USN != 0) {
-			err = book.Expunge(tx)
-			if err != nil {
-				return errors.Wrap(err, "expunging a book")
-			}
-		}
-	}
-
-	return nil
+// phaseReport is the machine readable time spent in a phase of a sync
+type phaseReport struct {
+	Name string `json:"name"`
+	// Items is the number of the items downloaded or uploaded in the phase
+	Items      int   `json:"items"`
+	DurationMs int64 `json:"duration_ms"`
 }
 
-func fullSync(ctx context.DnoteCtx, tx *database.DB) error {
-	log.Debug("performing a full sync\n")
-	log.Info("resolving delta.")
-
-	list, err := getSyncList(ctx, 0)
-	if err != nil {
-		return errors.Wrap(err, "getting sync list")
-	}
-
-	fmt.Printf(" (total %d).", list.getLength())
-
-	// clean resources that are in erroneous states
-	if err := cleanLocalNotes(tx, &list); err != nil {
-		return errors.Wrap(err, "cleaning up local notes")
-	}
-	if err := cleanLocalBooks(tx, &list); err != nil {
-		return errors.Wrap(err, "cleaning up local books")
-	}
-
-	for _, note := range list.Notes {
-		if err := fullSyncNote(tx, note); err != nil {
-			return errors.Wrap(err, "merging note")
-		}
-	}
-	for _, book := range list.Books {
-		if err := fullSyncBook(tx, book); err != nil {
-			return errors.Wrap(err, "merging book")
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		timeouts, err := getTimeouts(cmd, ctx)
+		if err != nil {
+			return err
 		}
-	}
-
-	for noteUUID := range list.ExpungedNotes {
-		if err := syncDeleteNote(tx, noteUUID); err != nil {
-			return errors.Wrap(err, "deleting note")
+		if printConfigFlag {
+			return printTimeouts(ctx, timeouts)
 		}
-	}
-	for bookUUID := range list.ExpungedBooks {
-		if err := syncDeleteBook(tx, bookUUID); err != nil {
-			return errors.Wrap(err, "deleting book")
+		if previewCleanFlag {
+			return previewClean(ctx, timeouts)
 		}
-	}
-
-	err = saveSyncState(tx, list.MaxCurrentTime, list.MaxUSN)
-	if err != nil {
-		return errors.Wrap(err, "saving sync state")
-	}
-
-	fmt.Println(" done.")
-
-	return nil
-}
-
-func stepSync(ctx context.DnoteCtx, tx *database.DB, afterUSN int) error {
-	log.Debug("performing a step sync\n")
 
-	log.Info("resolving delta.")
-
-	list, err := getSyncList(ctx, afterUSN)
-	if err != nil {
-		return errors.Wrap(err, "getting sync list")
-	}
-
-	fmt.Printf(" (total %d).", list.getLength())
-
-	for _, note := range list.Notes {
-		if err := stepSyncNote(tx, note); err != nil {
-			return errors.Wrap(err, "merging note")
+		if formatFlag == formatJSON {
+			// leave the standard output to the JSON
+			out, errOut := ctx.Logger.Output()
+			ctx.Logger.SetOutput(errOut, errOut)
+			defer ctx.Logger.SetOutput(out, errOut)
 		}
-	}
-	for _, book := range list.Books {
-		if err := stepSyncBook(tx, book); err != nil {
-			return errors.Wrap(err, "merging book")
-		}
-	}
 
-	for noteUUID := range list.ExpungedNotes {
-		if err := syncDeleteNote(tx, noteUUID); err != nil {
-			return errors.Wrap(err, "deleting note")
+		// the environment takes precedence over the flag
+		dir := profileDir
+		if ctx.SyncProfileDir != "" {
+			dir = ctx.SyncProfileDir
 		}
-	}
-	for bookUUID := range list.ExpungedBooks {
-		if err := syncDeleteBook(tx, bookUUID); err != nil {
-			return errors.Wrap(err, "deleting book")
-		}
-	}
 
-	err = saveSyncState(tx, list.MaxCurrentTime, list.MaxUSN)
-	if err != nil {
-		return errors.Wrap(err, "saving sync state")
-	}
-
-	fmt.Println(" done.")
-
-	return nil
-}
-
-func sendBooks(ctx context.DnoteCtx, tx *database.DB) (bool, error) {
-	isBehind := false
-
-	rows, err := tx.Query("SELECT uuid, label, usn, deleted FROM books WHERE dirty")
-	if err != nil {
-		return isBehind, errors.Wrap(err, "getting syncable books")
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var book database.Book
-
-		if err = rows.Scan(&book.UUID, &book.Label, &book.USN, &book.Deleted); err != nil {
-			return isBehind, errors.Wrap(err, "scanning a syncable book")
-		}
-
-		log.Debug("sending book %s\n", book.UUID)
-
-		var respUSN int
-
-		// if new, create it in the server, or else, update.
-		if book.USN == 0 {
-			if book.Deleted {
-				err = book.Expunge(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "expunging a book locally")
-				}
-
-				continue
-			} else {
-				resp, err := client.CreateBook(ctx, book.Label)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "creating a book")
-				}
-
-				_, err = tx.Exec("UPDATE notes SET book_uuid = ? WHERE book_uuid = ?", resp.Book.UUID, book.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating book_uuids of notes")
-				}
-
-				book.Dirty = false
-				book.USN = resp.Book.USN
-				err = book.Update(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "marking book dirty")
-				}
-
-				err = book.UpdateUUID(tx, resp.Book.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating book uuid")
-				}
-
-				respUSN = resp.Book.USN
+		if dir != "" {
+			p, err := startProfile(dir, profileTrace)
+			if err != nil {
+				return errors.Wrap(err, "starting the profile")
 			}
-		} else {
-			if book.Deleted {
-				resp, err := client.DeleteBook(ctx, book.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "deleting a book")
-				}
-
-				err = book.Expunge(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "expunging a book locally")
-				}
-
-				respUSN = resp.Book.USN
-			} else {
-				resp, err := client.UpdateBook(ctx, book.Label, book.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating a book")
+			defer func() {
+				if err := p.stop(); err != nil {
+					log.Errorf("%s\n", errors.Wrap(err, "stopping the profile").Error())
 				}
-
-				book.Dirty = false
-				book.USN = resp.Book.USN
-				err = book.Update(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "marking book dirty")
-				}
-
-				respUSN = resp.Book.USN
+			}()
+		}
+
+		// cancel the requests to the server upon an interrupt so that the
+		// transaction is rolled back and the lock is released
+		sigCtx, stop := signal.NotifyContext(ctx.Context(), os.Interrupt)
+		defer stop()
+
+		syncer := dnote.NewSyncer(dnote.NewStore(ctx))
+		r, err := syncer.Sync(sigCtx, dnote.SyncOptions{
+			Full:     isFullSync,
+			PullOnly: pullOnlyFlag,
+			PushOnly: pushOnlyFlag,
+			Force:    forceFlag,
+			Limits:   dnote.SyncLimits{MaxDownload: maxDownload, MaxOps: maxOpsFlag},
+			Timeouts: &timeouts,
+			Partial:  partialFlag,
+			Books:    booksFlag,
+			Initial:  initialFlag,
+		})
+		if dir != "" && r.PhaseSummary() != "" {
+			ctx.Logger.Printf("%s", i18n.T("sync.profiles_written", r.PhaseSummary(), dir))
+		}
+		// a failed sync is written too so that the monitoring can tell
+		if metricsFileFlag != "" {
+			if err := writeMetrics(ctx, metricsFileFlag, r, err == nil, metricsBooksFlag); err != nil {
+				log.Errorf("%s\n", errors.Wrap(err, "writing the metrics").Error())
 			}
 		}
-
-		lastMaxUSN, err := getLastMaxUSN(tx)
+		// a user who chose to keep the notes locally is only reminded how to
+		// sync, unless a script expects the result
+		if err == dnote.ErrLocalOnly && formatFlag != formatJSON {
+			log.Warnf("%s\n", err.Error())
+			return nil
+		}
 		if err != nil {
-			return isBehind, errors.Wrap(err, "getting last max usn")
+			return err
 		}
 
-		log.Debug("sent book %s. response USN %d. last max usn: %d\n", book.UUID, respUSN, lastMaxUSN)
-
-		if respUSN == lastMaxUSN+1 {
-			err = updateLastMaxUSN(tx, lastMaxUSN+1)
-			if err != nil {
-				return isBehind, errors.Wrap(err, "updating last max usn")
+		// the removals are uploaded on the next sync
+		if ctx.ApplyRetention {
+			if _, err := retention.Apply(ctx, true); err != nil {
+				log.Errorf("%s\n", errors.Wrap(err, "applying the retention policies").Error())
 			}
-		} else {
-			isBehind = true
 		}
-	}
-
-	return isBehind, nil
-}
-
-func sendNotes(ctx context.DnoteCtx, tx *database.DB) (bool, error) {
-	isBehind := false
-
-	rows, err := tx.Query("SELECT uuid, book_uuid, body, public, deleted, usn, added_on FROM notes WHERE dirty")
-	if err != nil {
-		return isBehind, errors.Wrap(err, "getting syncable notes")
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var note database.Note
-
-		if err = rows.Scan(&note.UUID, &note.BookUUID, &note.Body, &note.Public, &note.Deleted, &note.USN, &note.AddedOn); err != nil {
-			return isBehind, errors.Wrap(err, "scanning a syncable note")
-		}
-
-		log.Debug("sending note %s\n", note.UUID)
-
-		var respUSN int
-
-		// if new, create it in the server, or else, update.
-		if note.USN == 0 {
-			if note.Deleted {
-				// if a note was added and deleted locally, simply expunge
-				err = note.Expunge(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "expunging a note locally")
-				}
 
-				continue
-			} else {
-				resp, err := client.CreateNote(ctx, note.BookUUID, note.Body)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "creating a note")
-				}
-
-				note.Dirty = false
-				note.USN = resp.Result.USN
-				err = note.Update(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "marking note dirty")
-				}
-
-				err = note.UpdateUUID(tx, resp.Result.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating note uuid")
-				}
-
-				respUSN = resp.Result.USN
-			}
-		} else {
-			if note.Deleted {
-				resp, err := client.DeleteNote(ctx, note.UUID)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "deleting a note")
-				}
-
-				err = note.Expunge(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "expunging a note locally")
-				}
-
-				respUSN = resp.Result.USN
-			} else {
-				resp, err := client.UpdateNote(ctx, note.UUID, note.BookUUID, note.Body, note.Public)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "updating a note")
-				}
-
-				note.Dirty = false
-				note.USN = resp.Result.USN
-				err = note.Update(tx)
-				if err != nil {
-					return isBehind, errors.Wrap(err, "marking note dirty")
-				}
-
-				respUSN = resp.Result.USN
+		if formatFlag != formatJSON {
+			if err := upgrade.Nudge(ctx); err != nil {
+				log.Errorf("%s\n", errors.Wrap(err, "checking for a newer release").Error())
 			}
 		}
 
-		lastMaxUSN, err := getLastMaxUSN(tx)
-		if err != nil {
-			return isBehind, errors.Wrap(err, "getting last max usn")
+		if err := upgrade.Check(ctx); err != nil {
+			log.Error(i18n.T("update_check_failed", err))
 		}
 
-		log.Debug("sent note %s. response USN %d. last max usn: %d\n", note.UUID, respUSN, lastMaxUSN)
-
-		if respUSN == lastMaxUSN+1 {
-			err = updateLastMaxUSN(tx, lastMaxUSN+1)
-			if err != nil {
-				return isBehind, errors.Wrap(err, "updating last max usn")
-			}
-		} else {
-			isBehind = true
+		if formatFlag == formatJSON {
+			return writeReport(r)
 		}
-	}
 
-	return isBehind, nil
-}
-
-func sendChanges(ctx context.DnoteCtx, tx *database.DB) (bool, error) {
-	log.Info("sending changes.")
-
-	var delta int
-	err := tx.QueryRow("SELECT (SELECT count(*) FROM notes WHERE dirty) + (SELECT count(*) FROM books WHERE dirty)").Scan(&delta)
-
-	fmt.Printf(" (total %d).", delta)
-
-	behind1, err := sendBooks(ctx, tx)
-	if err != nil {
-		return behind1, errors.Wrap(err, "sending books")
-	}
-
-	behind2, err := sendNotes(ctx, tx)
-	if err != nil {
-		return behind2, errors.Wrap(err, "sending notes")
+		return nil
 	}
-
-	fmt.Println(" done.")
-
-	isBehind := behind1 || behind2
-
-	return isBehind, nil
 }
 
-func updateLastMaxUSN(tx *database.DB, val int) error {
-	if err := database.UpdateSystem(tx, consts.SystemLastMaxUSN, val); err != nil {
-		return errors.Wrapf(err, "updating %s", consts.SystemLastMaxUSN)
+// writeReport writes the result of the sync to the standard output as JSON
+func writeReport(r dnote.SyncReport) error {
+	v := report{
+		Backend:       r.Backend,
+		UploadedNotes: r.UploadedNotes,
+		UploadedBooks: r.UploadedBooks,
+		Notes:         r.Notes,
+		Books:         r.Books,
+		DurationMs:    r.Duration.Milliseconds(),
+		Phases:        []phaseReport{},
 	}
-
-	return nil
-}
-
-func updateLastSyncAt(tx *database.DB, val int64) error {
-	if err := database.UpdateSystem(tx, consts.SystemLastSyncAt, val); err != nil {
-		return errors.Wrapf(err, "updating %s", consts.SystemLastSyncAt)
+	for _, p := range r.Phases {
+		v.Phases = append(v.Phases, phaseReport{Name: p.Name, Items: p.Items, DurationMs: p.Duration.Milliseconds()})
 	}
-
-	return nil
-}
-
-func saveSyncState(tx *database.DB, serverTime int64, serverMaxUSN int) error {
-	if err := updateLastMaxUSN(tx, serverMaxUSN); err != nil {
-		return errors.Wrap(err, "updating last max usn")
+	if r.StoppedEarly != "" {
+		v.StoppedEarly = string(r.StoppedEarly)
+		v.Remaining = &r.Remaining
 	}
-	if err := updateLastSyncAt(tx, serverTime); err != nil {
-		return errors.Wrap(err, "updating last sync at")
+
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		return errors.Wrap(err, "encoding the report")
 	}
 
 	return nil
 }
-
-func newRun(ctx context.DnoteCtx) infra.RunEFunc {
-	return func(cmd *cobra.Command, args []string) error {
-		if ctx.SessionKey == "" {
-			return errors.New("not logged in")
-		}
-
-		if err := migrate.Run(ctx, migrate.RemoteSequence, migrate.RemoteMode); err != nil {
-			return errors.Wrap(err, "running remote migrations")
-		}
-
-		tx, err := ctx.DB.Begin()
-		if err != nil {
-			return errors.Wrap(err, "beginning a transaction")
-		}
-
-		syncState, err := client.GetSyncState(ctx)
-		if err != nil {
-			return errors.Wrap(err, "getting the sync state from the server")
-		}
-		lastSyncAt, err := getLastSyncAt(tx)
-		if err != nil {
-			return errors.Wrap(err, "getting the last sync time")
-		}
-		lastMaxUSN, err := getLastMaxUSN(tx)
-		if err != nil {
-			return errors.Wrap(err, "getting the last max_usn")
-		}
-
-		log.Debug("lastSyncAt: %d, lastMaxUSN: %d, syncState: %+v\n", lastSyncAt, lastMaxUSN, syncState)
-
-		var syncErr error
-		if isFullSync || lastSyncAt < syncState.FullSyncBefore {
-			syncErr = fullSync(ctx, tx)
-		} else if lastMaxUSN != syncState.MaxUSN {
-			syncErr = stepSync(ctx, tx, lastMaxUSN)
-		} else {
-			// if no need to sync from the server, simply update the last sync timestamp and proceed to send changes
-			err = updateLastSyncAt(tx, syncState.CurrentTime)
-			if err != nil {
-				return errors.Wrap(err, "updating last sync at")
-			}
-		}
-		if syncErr != nil {
-			tx.Rollback()
-			return errors.Wrap(syncErr, "syncing changes from the server")
-		}
-
-		isBehind, err := sendChanges(ctx, tx)
-		if err != nil {
-			tx.Rollback()
-			return errors.Wrap(err, "sending changes")
-		}
-
-		// if server state gets ahead of that of client during the sync, do an additional step sync
-		if isBehind {
-			log.Debug("performing another step sync because client is behind\n")
-
-			updatedLastMaxUSN, err := getLastMaxUSN(tx)
-			if err != nil {
-				tx.Rollback()
-				return errors.Wrap(err, "getting the new last max_usn")
-			}
-
-			err = stepSync(ctx, tx, updatedLastMaxUSN)
-			if err != nil {
-				tx.Rollback()
-				return errors.Wrap(err, "performing the follow-up step sync")
-			}
-		}
-
-		tx.Commit()
-
-		log.Success("success\n")
-
-		if err := upgrade.Check(ctx); err != nil {
-			log.Error(errors.Wrap(err, "automatically checking updates").Error())
-		}
-
-		return nil
-	}
-}
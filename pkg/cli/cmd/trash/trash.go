@@ -0,0 +1,139 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package trash
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/output"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * List the notes that sync removed because they were removed on another device
+ dnote trash --expunged
+
+ * Restore the expunged note 3 as a new note
+ dnote trash --expunged --restore 3`
+
+var expungedFlag bool
+var restoreFlag int
+
+// excerptLength is the number of the characters of a body listed
+const excerptLength = 60
+
+// NewCmd returns a new trash command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "trash",
+		Short:   "List and restore the notes that sync removed because they were removed on another device",
+		Example: example,
+		Args:    cobra.NoArgs,
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&expungedFlag, "expunged", "", false, "list the notes that sync removed because they were removed on another device")
+	f.IntVarP(&restoreFlag, "restore", "", 0, "restore the expunged note with the id as a new note")
+
+	return cmd
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	// the body of a note removed here is cleared at once, and is on the server
+	// until the removal is synced
+	if !expungedFlag {
+		return errors.New("only the notes that sync removed are kept. Use --expunged")
+	}
+	if restoreFlag < 0 {
+		return errors.Errorf("invalid id %d", restoreFlag)
+	}
+
+	return nil
+}
+
+// excerpt returns the first line of the body, shortened to excerptLength
+// characters
+func excerpt(body string) string {
+	ret := strings.TrimSpace(body)
+	if i := strings.Index(ret, "\n"); i != -1 {
+		ret = ret[:i] + "..."
+	}
+
+	runes := []rune(ret)
+	if len(runes) > excerptLength {
+		return string(runes[:excerptLength]) + "..."
+	}
+
+	return ret
+}
+
+// printExpunged lists the notes in the archive of the expunged notes
+func printExpunged(ctx context.DnoteCtx) error {
+	notes, err := database.GetExpungedNotes(ctx.DB)
+	if err != nil {
+		return err
+	}
+
+	if len(notes) == 0 {
+		log.Plain(i18n.T("trash.none"))
+		return nil
+	}
+
+	now := ctx.Clock.Now()
+	for _, n := range notes {
+		expungedAt := output.FormatTime(time.Unix(0, n.ExpungedAt), now, ctx.TimeFormat)
+
+		log.Plainf("%s %s %s %s\n",
+			log.ColorYellow.Sprintf("(%d)", n.ID),
+			fmt.Sprintf("%s:", n.BookLabel),
+			excerpt(n.Body),
+			log.ColorGray.Sprint(i18n.T("trash.expunged_at", expungedAt)),
+		)
+	}
+
+	return nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if restoreFlag == 0 {
+			return printExpunged(ctx)
+		}
+
+		n, err := dnote.NewStore(ctx).RestoreExpungedNote(restoreFlag)
+		if err != nil {
+			return errors.Wrap(err, "restoring the note")
+		}
+
+		log.Success(i18n.T("trash.restored", restoreFlag, n.Book, n.Index))
+
+		return nil
+	}
+}
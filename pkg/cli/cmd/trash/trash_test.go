@@ -0,0 +1,95 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package trash
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestExcerpt(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"n1 body", "n1 body"},
+		{"  n1 body\n", "n1 body"},
+		{"first line\nsecond line", "first line..."},
+		{strings.Repeat("a", 70), strings.Repeat("a", 60) + "..."},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			assert.Equal(t, excerpt(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestTrash(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	result := testutils.RunCmd(t, ctx, NewCmd, "--expunged")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "listing the empty archive"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "no note was removed by sync"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+
+	database.MustExec(t, "archiving n1", ctx.DB, "INSERT INTO expunged_archive (note_uuid, book_label, body, added_on, expunged_at) VALUES (?, ?, ?, ?, ?)",
+		"n1-uuid", "js", "n1 body", 1541108743000000000, 1541108745000000000)
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "--expunged")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "listing the archive"))
+	}
+	assert.Equal(t, strings.Contains(string(result.Stdout), "(1) js: n1 body"), true, fmt.Sprintf("output mismatch: %s", result.Stdout))
+
+	result = testutils.RunCmd(t, ctx, NewCmd, "--expunged", "--restore", "1")
+	if result.Err != nil {
+		t.Fatal(errors.Wrap(result.Err, "restoring"))
+	}
+
+	var uuid, body string
+	var dirty bool
+	database.MustScan(t, "getting the restored note", ctx.DB.QueryRow("SELECT uuid, body, dirty FROM notes"), &uuid, &body, &dirty)
+	assert.NotEqual(t, uuid, "n1-uuid", "uuid mismatch")
+	assert.Equal(t, body, "n1 body", "body mismatch")
+	assert.Equal(t, dirty, true, "dirty mismatch")
+
+	t.Run("invalid", func(t *testing.T) {
+		testCases := [][]string{
+			{},
+			{"--restore", "1"},
+			{"--expunged", "--restore", "1"},
+		}
+
+		for _, args := range testCases {
+			result := testutils.RunCmd(t, ctx, NewCmd, args...)
+			assert.NotEqual(t, result.Err, nil, fmt.Sprintf("error mismatch for %v", args))
+		}
+	})
+}
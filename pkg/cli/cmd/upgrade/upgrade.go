@@ -0,0 +1,98 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	"os"
+	"os/signal"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/upgrade"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+ * Upgrade dnote to the latest release
+ dnote upgrade
+
+ * Check if a new release is available without installing it
+ dnote upgrade --check`
+
+var checkFlag bool
+
+// NewCmd returns a new upgrade command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "upgrade",
+		Short:   "Upgrade dnote to the latest release",
+		Example: example,
+		Args:    cobra.NoArgs,
+		RunE:    newRun(ctx, upgrade.NewUpdater),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&checkFlag, "check", "", false, "only check if a new release is available")
+
+	return cmd
+}
+
+// newRun returns the run function of the command, which gets the updater
+// from newUpdater with the configured manifest URL
+func newRun(ctx context.DnoteCtx, newUpdater func(manifestURL string) upgrade.Updater) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		// the executable is left as it is if the download is interrupted
+		sigCtx, stop := signal.NotifyContext(ctx.Context(), os.Interrupt)
+		defer stop()
+
+		u := newUpdater(ctx.UpgradeManifestURL)
+
+		log.Info(i18n.T("upgrade.current", ctx.Version))
+		latest, ok, err := u.Check(sigCtx, ctx.Version)
+		if err != nil {
+			return errors.Wrap(err, "checking the latest release")
+		}
+		log.Info(i18n.T("upgrade.latest", latest.Version))
+
+		if !ok {
+			log.Success(i18n.T("upgrade.up_to_date"))
+			return nil
+		}
+		if checkFlag {
+			log.Info(i18n.T("upgrade.available", latest.Version))
+			return nil
+		}
+
+		log.Info(i18n.T("upgrade.installing", latest.Version))
+		result, err := u.Install(sigCtx, latest)
+		if err != nil {
+			return errors.Wrapf(err, "installing %s", latest.Version)
+		}
+
+		log.Success(i18n.T("upgrade.installed", latest.Version, result.Path))
+		if result.Restart {
+			log.Warnf("%s", i18n.T("upgrade.restart"))
+		}
+
+		return nil
+	}
+}
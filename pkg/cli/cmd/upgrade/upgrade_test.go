@@ -0,0 +1,110 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	stdCtx "context"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/upgrade"
+	"github.com/spf13/cobra"
+)
+
+type fakeSource struct {
+	version string
+}
+
+func (s fakeSource) Latest(ctx stdCtx.Context) (upgrade.Release, error) {
+	return upgrade.Release{Version: s.version}, nil
+}
+
+// newTestCmd returns the command with an updater that finds the given latest
+// version, and cannot download anything
+func newTestCmd(latest string) func(context.DnoteCtx) *cobra.Command {
+	return func(ctx context.DnoteCtx) *cobra.Command {
+		cmd := NewCmd(ctx)
+		cmd.RunE = newRun(ctx, func(manifestURL string) upgrade.Updater {
+			return upgrade.Updater{Source: fakeSource{version: latest}}
+		})
+
+		return cmd
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	testCases := []struct {
+		name        string
+		current     string
+		latest      string
+		args        []string
+		expectedOut string
+		expectedErr string
+	}{
+		{
+			name:        "up to date",
+			current:     "0.15.0",
+			latest:      "0.15.0",
+			expectedOut: "you are up-to-date",
+		},
+		{
+			name:        "check",
+			current:     "0.14.2",
+			latest:      "0.15.0",
+			args:        []string{"--check"},
+			expectedOut: "version 0.15.0 is available",
+		},
+		{
+			name:        "development build",
+			current:     "master",
+			latest:      "0.15.0",
+			expectedErr: "the current version 'master' is not a release",
+		},
+		{
+			name:        "no binary",
+			current:     "0.14.2",
+			latest:      "0.15.0",
+			expectedErr: "the release 0.15.0 has no binary",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			ctx.Version = tc.current
+
+			result := testutils.RunCmd(t, ctx, newTestCmd(tc.latest), tc.args...)
+
+			if tc.expectedErr != "" {
+				if result.Err == nil || !strings.Contains(result.Err.Error(), tc.expectedErr) {
+					t.Fatalf("expected an error containing %q but got %v", tc.expectedErr, result.Err)
+				}
+				return
+			}
+
+			assert.Equal(t, result.Err, nil, "error mismatch")
+			assert.Equal(t, strings.Contains(string(result.Stdout), tc.expectedOut), true, "output mismatch")
+		})
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/spf13/cobra"
 )
 
@@ -32,7 +33,7 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 		Short: "Print the version number of Dnote",
 		Long:  "Print the version number of Dnote",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("dnote %s\n", ctx.Version)
+			fmt.Print(i18n.T("version.version", ctx.Version))
 		},
 	}
 
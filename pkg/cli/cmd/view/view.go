@@ -19,8 +19,13 @@
 package view
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
 	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/ui"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
@@ -38,15 +43,47 @@ var example = `
 
  * View a particular note in a book
  dnote view javascript 0
+
+ * View the note added today in a book, or list the notes if there are more
+ dnote view journal today
+
+ * View the notes added on a date in a book
+ dnote view journal 2024-06-01
+
+ * View a note by a prefix of its uuid
+ dnote view 3a7c1d
+
+ * View a removed note by a prefix of its uuid
+ dnote view 3a7c1d --include-deleted
+
+ * View a note with absolute timestamps
+ dnote view 12 --absolute
+
+ * View a note along with the device that last changed it
+ dnote view 12 --long
+
+ * Copy the content of a note to the clipboard
+ dnote view 12 --copy
+
+ * List the second page of 20 notes in a book
+ dnote view javascript --limit 20 --offset 20
  `
 
 var nameOnly bool
 var contentOnly bool
+var absolute bool
+var includeDeleted bool
+var copyFlag bool
+var printFlag bool
+var listOpts ls.ListOptions
 
 func preRun(cmd *cobra.Command, args []string) error {
 	if len(args) > 2 {
 		return errors.New("Incorrect number of argument")
 	}
+	if printFlag && !copyFlag {
+		return errors.New("--print is only valid with --copy")
+	}
 
 	return nil
 }
@@ -54,7 +91,7 @@ func preRun(cmd *cobra.Command, args []string) error {
 // NewCmd returns a new view command
 func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:     "view <book name?> <note index?>",
+		Use:     "view <book name?> <note index|uuid prefix|date?>",
 		Aliases: []string{"v"},
 		Short:   "List books, notes or view a content",
 		Example: example,
@@ -65,6 +102,11 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 	f := cmd.Flags()
 	f.BoolVarP(&nameOnly, "name-only", "", false, "print book names only")
 	f.BoolVarP(&contentOnly, "content-only", "", false, "print the note content only")
+	f.BoolVarP(&absolute, "absolute", "", false, "print absolute timestamps instead of relative ones")
+	f.BoolVarP(&includeDeleted, "include-deleted", "", false, "view a removed note by a prefix of its uuid")
+	f.BoolVarP(&copyFlag, "copy", "", false, "copy the note content to the clipboard instead of printing the note")
+	f.BoolVarP(&printFlag, "print", "", false, "print the note as well when copying it")
+	ls.AddListFlags(cmd, &listOpts)
 
 	return cmd
 }
@@ -73,21 +115,53 @@ func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
 		var run infra.RunEFunc
 
+		// Unless a layout is configured, absolute timestamps are printed in ISO-8601
+		if absolute && ctx.TimeFormat == "" {
+			ctx.TimeFormat = time.RFC3339
+		}
+
+		noteOpts := cat.Options{
+			ContentOnly:    contentOnly,
+			IncludeDeleted: includeDeleted,
+			Copy:           copyFlag,
+			Print:          printFlag,
+			Long:           listOpts.Long,
+		}
+
 		if len(args) == 0 {
-			run = ls.NewRun(ctx, nameOnly)
+			if copyFlag {
+				return errors.New("--copy flag is only valid when viewing a note")
+			}
+
+			run = ls.NewRun(ctx, nameOnly, &listOpts)
 		} else if len(args) == 1 {
 			if nameOnly {
 				return errors.New("--name-only flag is only valid when viewing books")
 			}
 
-			if utils.IsNumber(args[0]) {
-				run = cat.NewRun(ctx, contentOnly)
+			isNote := utils.IsNumber(args[0])
+			if !isNote {
+				_, ok, err := database.ResolveNoteRef(ctx.DB, args[0], includeDeleted)
+				if err != nil {
+					return errors.Wrap(err, "resolving the note")
+				}
+
+				isNote = ok
+			}
+
+			if isNote {
+				run = cat.NewRun(ctx, noteOpts)
+			} else if copyFlag {
+				return errors.New("--copy flag is only valid when viewing a note")
 			} else {
-				run = ls.NewRun(ctx, false)
+				run = ls.NewRun(ctx, false, &listOpts)
 			}
+		} else if len(args) == 2 && utils.IsDateExpr(args[1]) {
+			return viewOnDate(ctx, cmd, args[0], args[1], noteOpts)
 		} else if len(args) == 2 {
 			// DEPRECATED: passing book name to view command is deprecated
-			run = cat.NewRun(ctx, false)
+			noteOpts.ContentOnly = false
+			run = cat.NewRun(ctx, noteOpts)
 		} else {
 			return errors.New("Incorrect number of arguments")
 		}
@@ -95,3 +169,67 @@ func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 		return run(cmd, args)
 	}
 }
+
+// viewOnDate prints the note added in the given book on the date of the given
+// date expression if there is exactly one such note, and lists the notes
+// otherwise
+func viewOnDate(ctx context.DnoteCtx, cmd *cobra.Command, bookName, date string, noteOpts cat.Options) error {
+	if listOpts.On != "" || listOpts.Since != "" || listOpts.Until != "" {
+		return errors.New("a date cannot be used with --on, --since or --until")
+	}
+
+	label, err := ui.ResolveBook(ctx, bookName)
+	if err != nil {
+		return errors.Wrap(err, "resolving the book")
+	}
+
+	day, err := utils.ParseDay(date, ctx.Clock.Now().Local())
+	if err != nil {
+		return err
+	}
+	start, end := utils.DayRange(day)
+
+	rowIDs, err := getRowIDsAdded(ctx.DB, label, start, end)
+	if err != nil {
+		return err
+	}
+
+	if len(rowIDs) == 1 {
+		return cat.NewRun(ctx, noteOpts)(cmd, []string{strconv.Itoa(rowIDs[0])})
+	}
+	if copyFlag {
+		return errors.Errorf("--copy flag is only valid when a single note was added on %s", date)
+	}
+
+	listOpts.On = date
+	return ls.NewRun(ctx, false, &listOpts)(cmd, []string{label})
+}
+
+// getRowIDsAdded returns the rowids of at most two notes in the given book
+// added in the given range, which is enough to tell if there is exactly one
+func getRowIDsAdded(db *database.DB, label string, start, end time.Time) ([]int, error) {
+	rows, err := db.Query(`SELECT notes.rowid
+	FROM notes
+	INNER JOIN books ON books.uuid = notes.book_uuid
+	WHERE books.label = ? AND notes.deleted = ? AND notes.added_on >= ? AND notes.added_on < ?
+	LIMIT 2;`, label, false, start.UnixNano(), end.UnixNano())
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the notes")
+	}
+	defer rows.Close()
+
+	ret := []int{}
+	for rows.Next() {
+		var rowID int
+		if err := rows.Scan(&rowID); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		ret = append(ret, rowID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating rows")
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,307 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package view
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/clipboard"
+	"github.com/dnote/dnote/pkg/cli/cmd/root"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestView(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	now := testutils.CmdNow
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	b2 := f.Book("css")
+	f.Book("deleted", testutils.With.Deleted())
+	f.Note(b1, "arrow functions", testutils.With.AddedOn(now.Add(-3*time.Hour).UnixNano()))
+	n2 := f.Note(b1, "closures\ncapture variables", testutils.With.UUID("n2-uuid"), testutils.With.AddedOn(now.Add(-50*time.Hour).UnixNano()), testutils.With.EditedOn(now.Add(-2*time.Minute).UnixNano()))
+	// added_on is ahead of the local clock, as if synced from a server with a clock skew
+	f.Note(b1, "hoisting", testutils.With.AddedOn(now.Add(5*time.Minute).UnixNano()))
+	f.Note(b1, "removed", testutils.With.Deleted())
+	f.Note(b2, "flexbox", testutils.With.AddedOn(time.Date(2020, time.December, 25, 9, 0, 0, 0, time.UTC).UnixNano()))
+
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "books", args: []string{}},
+		{name: "books_name_only", args: []string{"--name-only"}},
+		{name: "notes", args: []string{"js"}},
+		{name: "notes_absolute", args: []string{"js", "--absolute"}},
+		{name: "note", args: []string{fmt.Sprint(n2.RowID)}},
+		{name: "note_content_only", args: []string{fmt.Sprint(n2.RowID), "--content-only"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nameOnly, contentOnly, absolute = false, false, false
+
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			assert.Equal(t, result.Err, nil, "error mismatch")
+			assert.Equal(t, string(result.Stderr), "", "stderr mismatch")
+			testutils.AssertGolden(t, result.Stdout, "view_"+tc.name)
+		})
+	}
+}
+
+func TestView_copy(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("js")
+	n := f.Note(b, "closures\ncapture variables")
+	noteRef := fmt.Sprint(n.RowID)
+
+	testCases := []struct {
+		name          string
+		args          []string
+		expectedPrint bool
+		expectedErr   string
+	}{
+		{name: "copy", args: []string{noteRef, "--copy"}},
+		{name: "copy content only", args: []string{noteRef, "--copy", "--content-only"}},
+		{name: "copy and print", args: []string{noteRef, "--copy", "--print"}, expectedPrint: true},
+		{name: "copy and print content only", args: []string{noteRef, "--copy", "--print", "--content-only"}, expectedPrint: true},
+		{name: "copy a book", args: []string{"js", "--copy"}, expectedErr: "--copy flag is only valid when viewing a note"},
+		{name: "copy books", args: []string{"--copy"}, expectedErr: "--copy flag is only valid when viewing a note"},
+		{name: "print without copy", args: []string{noteRef, "--print"}, expectedErr: "--print is only valid with --copy"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cb := clipboard.NewMemory("")
+			ctx.Clipboard = cb
+
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			if tc.expectedErr != "" {
+				if result.Err == nil || !strings.Contains(result.Err.Error(), tc.expectedErr) {
+					t.Fatalf("expected an error containing %q but got %v", tc.expectedErr, result.Err)
+				}
+				return
+			}
+
+			assert.Equal(t, result.Err, nil, "error mismatch")
+			got, err := cb.Read()
+			assert.Equal(t, err, nil, "reading the clipboard")
+			assert.Equal(t, got, n.Body, "clipboard mismatch")
+			assert.Equal(t, strings.Contains(string(result.Stdout), "capture variables"), tc.expectedPrint, "printed body mismatch")
+		})
+	}
+}
+
+func TestView_views(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("js")
+	n := f.Note(b, "closures", testutils.With.USN(3))
+	noteRef := fmt.Sprint(n.RowID)
+
+	// listing the notes does not count as viewing them
+	result := testutils.RunCmd(t, ctx, NewCmd, "js")
+	assert.Equal(t, result.Err, nil, "error mismatch")
+	assert.Equal(t, f.MustGetNote(n.UUID).ViewCount, 0, "view count mismatch after listing")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, noteRef)
+	assert.Equal(t, result.Err, nil, "error mismatch")
+	assert.Equal(t, strings.Contains(string(result.Stdout), "not viewed before"), true, "first view output mismatch")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, noteRef, "--content-only")
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	result = testutils.RunCmd(t, ctx, NewCmd, noteRef)
+	assert.Equal(t, result.Err, nil, "error mismatch")
+	assert.Equal(t, strings.Contains(string(result.Stdout), "viewed 2 times before, last just now"), true, "third view output mismatch")
+
+	got := f.MustGetNote(n.UUID)
+	assert.Equal(t, got.ViewCount, 3, "view count mismatch")
+	assert.Equal(t, got.LastViewedAt, testutils.CmdNow.UnixNano(), "last viewed at mismatch")
+	assert.Equal(t, got.Dirty, false, "dirty mismatch")
+	assert.Equal(t, got.USN, 3, "usn mismatch")
+}
+
+// TestView_viewsReadOnly tests that viewing a note counts the view when the
+// database is opened read-only for the view command, as it is by the main
+// command
+func TestView_viewsReadOnly(t *testing.T) {
+	t.Setenv(consts.HomeEnv, t.TempDir())
+
+	setupCtx, err := infra.Init("", "test", false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "initializing"))
+	}
+	defer setupCtx.DB.Close()
+
+	f := testutils.NewFixture(t, setupCtx.DB)
+	b := f.Book("js")
+	n := f.Note(b, "closures")
+	noteRef := fmt.Sprint(n.RowID)
+
+	args := []string{"view", noteRef}
+	readOnly := root.IsReadOnly(args)
+	assert.Equal(t, readOnly, true, "read-only mismatch")
+
+	ctx, err := infra.Init("", "test", readOnly)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "initializing read-only"))
+	}
+	defer ctx.DB.Close()
+	assert.Equal(t, ctx.DB.ReadOnly, true, "database read-only mismatch")
+
+	result := testutils.RunCmd(t, *ctx, NewCmd, args[1:]...)
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	result = testutils.RunCmd(t, *ctx, NewCmd, args[1:]...)
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	got := f.MustGetNote(n.UUID)
+	assert.Equal(t, got.ViewCount, 2, "view count mismatch")
+	assert.Equal(t, got.LastViewedAt, testutils.CmdNow.UnixNano(), "last viewed at mismatch")
+}
+
+func TestView_shares(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("js")
+	n := f.Note(b, "closures", testutils.With.USN(3))
+
+	shares := []database.Share{
+		{ID: "s1", NoteUUID: n.UUID, URL: "https://example.com/s/s1"},
+		{ID: "s2", NoteUUID: n.UUID, URL: "https://example.com/s/s2", ExpiresAt: testutils.CmdNow.Add(7 * 24 * time.Hour).UnixNano()},
+		{ID: "s3", NoteUUID: n.UUID, URL: "https://example.com/s/s3", ExpiresAt: testutils.CmdNow.Add(-time.Hour).UnixNano()},
+	}
+	if err := database.ReplaceNoteShares(ctx.DB, n.UUID, shares); err != nil {
+		t.Fatal(errors.Wrap(err, "saving the shares"))
+	}
+
+	result := testutils.RunCmd(t, ctx, NewCmd, fmt.Sprint(n.RowID))
+	assert.Equal(t, result.Err, nil, "error mismatch")
+
+	out := string(result.Stdout)
+	assert.Equal(t, strings.Contains(out, "shared at https://example.com/s/s1, which never expires"), true, "s1 output mismatch")
+	assert.Equal(t, strings.Contains(out, "shared at https://example.com/s/s2, which expires in 7d"), true, "s2 output mismatch")
+	assert.Equal(t, strings.Contains(out, "s3"), false, "expired share should not be printed")
+}
+
+func TestView_date(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	now := testutils.CmdNow
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("journal")
+	f.Note(b, "ran 5k", testutils.With.AddedOn(now.Add(-3*time.Hour).UnixNano()))
+	f.Note(b, "read a book", testutils.With.AddedOn(now.Add(-20*time.Hour).UnixNano()))
+	f.Note(b, "wrote tests", testutils.With.AddedOn(now.Add(-30*time.Hour).UnixNano()))
+	f.Note(b, "removed", testutils.With.AddedOn(now.Add(-2*time.Hour).UnixNano()), testutils.With.Deleted())
+
+	testCases := []struct {
+		name        string
+		args        []string
+		contains    []string
+		notContains []string
+		expectedErr string
+	}{
+		{
+			name:        "one note",
+			args:        []string{"journal", "today"},
+			contains:    []string{"ran 5k", "not viewed before"},
+			notContains: []string{"read a book", "removed"},
+		},
+		{
+			name:        "many notes",
+			args:        []string{"journal", "yesterday"},
+			contains:    []string{"read a book", "wrote tests"},
+			notContains: []string{"ran 5k", "not viewed before"},
+		},
+		{
+			name:        "explicit date",
+			args:        []string{"journal", "2021-03-14"},
+			contains:    []string{"read a book", "wrote tests"},
+			notContains: []string{"ran 5k"},
+		},
+		{
+			name:        "no notes",
+			args:        []string{"journal", "2021-03-01"},
+			notContains: []string{"ran 5k", "read a book", "wrote tests"},
+		},
+		{
+			name:     "content only",
+			args:     []string{"journal", "today", "--content-only"},
+			contains: []string{"ran 5k"},
+		},
+		{
+			name:        "with a range flag",
+			args:        []string{"journal", "today", "--since", "2021-03-01"},
+			expectedErr: "a date cannot be used with --on, --since or --until",
+		},
+		{
+			name:        "copy many notes",
+			args:        []string{"journal", "yesterday", "--copy"},
+			expectedErr: "--copy flag is only valid when a single note was added on yesterday",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := testutils.RunCmd(t, ctx, NewCmd, tc.args...)
+
+			if tc.expectedErr != "" {
+				if result.Err == nil || !strings.Contains(result.Err.Error(), tc.expectedErr) {
+					t.Fatalf("expected an error containing %q but got %v", tc.expectedErr, result.Err)
+				}
+				return
+			}
+
+			assert.Equal(t, result.Err, nil, "error mismatch")
+			for _, s := range tc.contains {
+				assert.Equal(t, strings.Contains(string(result.Stdout), s), true, fmt.Sprintf("%q missing in %q", s, result.Stdout))
+			}
+			for _, s := range tc.notContains {
+				assert.Equal(t, strings.Contains(string(result.Stdout), s), false, fmt.Sprintf("%q unexpected in %q", s, result.Stdout))
+			}
+		})
+	}
+}
@@ -19,11 +19,18 @@
 package config
 
 import (
-	"fmt"
+	"bytes"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/dnote/dnote/pkg/cli/backup"
 	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/hooks"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/utils"
 	"github.com/pkg/errors"
@@ -32,12 +39,463 @@ import (
 
 // Config holds dnote configuration
 type Config struct {
-	Editor      string `yaml:"editor"`
-	APIEndpoint string `yaml:"apiEndpoint"`
+	Editor      string            `yaml:"editor"`
+	APIEndpoint string            `yaml:"apiEndpoint"`
+	WebEndpoint string            `yaml:"webEndpoint,omitempty"`
+	TimeFormat  string            `yaml:"timeFormat,omitempty"`
+	Aliases     map[string]string `yaml:"aliases,omitempty"`
+	AutoVacuum  bool              `yaml:"autoVacuum,omitempty"`
+	Log         LogConfig         `yaml:"log,omitempty"`
+	Sync        SyncConfig        `yaml:"sync,omitempty"`
+	Backup      BackupConfig      `yaml:"backup,omitempty"`
+	Hooks       hooks.Config      `yaml:"hooks,omitempty"`
+	Credentials CredentialsConfig `yaml:"credentials,omitempty"`
+	Journal     JournalConfig     `yaml:"journal,omitempty"`
+	NoteSize    NoteSizeConfig    `yaml:"noteSize,omitempty"`
+	Retention   RetentionConfig   `yaml:"retention,omitempty"`
+	Upgrade     UpgradeConfig     `yaml:"upgrade,omitempty"`
+	// ExpungedArchive limits the archive of the notes that the syncs removed
+	// because they were removed on another device
+	ExpungedArchive ExpungedArchiveConfig `yaml:"expungedArchive,omitempty"`
+	// Notifications turns on or off the messages that dnote prints on its own
+	Notifications NotificationsConfig `yaml:"notifications,omitempty"`
+	// AutoCreateBook is one of the consts.AutoCreateBook values, and defaults
+	// to true if empty
+	AutoCreateBook string `yaml:"autoCreateBook,omitempty"`
+	// DataDir is the directory that keeps the dnote data directory, in place
+	// of the data directory of the user, if not empty
+	DataDir string `yaml:"dataDir,omitempty"`
+	// LocalOnly indicates that the notes are not synced with a server, so
+	// that the sync explains how to set it up instead of failing
+	LocalOnly bool `yaml:"localOnly,omitempty"`
+	// Locale is the language of the messages, in place of the one given by
+	// the environment, if not empty
+	Locale string `yaml:"locale,omitempty"`
+	// DeviceName is the name of this device shown along with the notes made
+	// here, in place of the hostname, if not empty
+	DeviceName string `yaml:"deviceName,omitempty"`
+}
+
+// JournalConfig holds the configuration of the journal of the changes
+type JournalConfig struct {
+	// MaxAgeDays is the number of days that an action is kept in the journal.
+	// It defaults to 90 if zero, and a negative value keeps the actions forever.
+	MaxAgeDays int `yaml:"maxAgeDays,omitempty"`
+}
+
+// DefaultJournalMaxAgeDays is the number of days that an action is kept in the
+// journal if not configured
+const DefaultJournalMaxAgeDays = 90
+
+// RetentionConfig holds the configuration of the retention policies of the
+// books
+type RetentionConfig struct {
+	// ApplyAfterSync applies the retention policies after every successful
+	// sync, without a confirmation
+	ApplyAfterSync bool `yaml:"applyAfterSync,omitempty"`
+}
+
+// ExpungedArchiveConfig holds the limits of the archive of the notes that the
+// syncs removed because they were removed on another device, given as an age
+// such as 30d and a size such as 10MB. An age or a size of 0 turns the archive
+// off.
+type ExpungedArchiveConfig struct {
+	// MaxAge is how long an archived note is kept. It defaults to
+	// DefaultExpungedArchiveMaxAge if empty.
+	MaxAge string `yaml:"maxAge,omitempty"`
+	// MaxSize is the size of the archived bodies above which the oldest notes
+	// are dropped. It defaults to DefaultExpungedArchiveMaxSize if empty.
+	MaxSize string `yaml:"maxSize,omitempty"`
+}
+
+// DefaultExpungedArchiveMaxAge is how long an archived note is kept if not
+// configured
+const DefaultExpungedArchiveMaxAge = 30 * 24 * time.Hour
+
+// DefaultExpungedArchiveMaxSize is the number of bytes of the archived bodies
+// kept if not configured
+const DefaultExpungedArchiveMaxSize = 10 * 1000 * 1000
+
+// UpgradeConfig holds the configuration of dnote upgrade
+type UpgradeConfig struct {
+	// ManifestURL is the URL of a JSON document that describes the latest
+	// release, in place of the GitHub releases, if not empty
+	ManifestURL string `yaml:"manifestURL,omitempty"`
+}
+
+// NotificationsConfig holds the configuration of the messages that dnote
+// prints on its own
+type NotificationsConfig struct {
+	// UpgradeNudge tells after a sync that a newer release is available, and
+	// defaults to true if nil
+	UpgradeNudge *bool `yaml:"upgradeNudge,omitempty"`
+}
+
+// UpgradeNudgeEnabled tells if the upgrade nudge is turned on
+func (c NotificationsConfig) UpgradeNudgeEnabled() bool {
+	return c.UpgradeNudge == nil || *c.UpgradeNudge
+}
+
+// NoteSizeConfig holds the limits of the size of a note body, given as sizes
+// such as 100KB or 1MB. A size of 0 disables the limit.
+type NoteSizeConfig struct {
+	// Warn is the size above which adding or editing a note prints a warning.
+	// It defaults to DefaultNoteSizeWarn if empty.
+	Warn string `yaml:"warn,omitempty"`
+	// Max is the size above which adding or editing a note fails unless
+	// forced. It defaults to DefaultNoteSizeMax if empty.
+	Max string `yaml:"max,omitempty"`
+}
+
+// DefaultNoteSizeWarn is the number of bytes above which a note body is warned
+// about if not configured
+const DefaultNoteSizeWarn = 100 * 1000
+
+// DefaultNoteSizeMax is the number of bytes above which a note body is refused
+// if not configured
+const DefaultNoteSizeMax = 1000 * 1000
+
+// CredentialsConfig holds the configuration of the session key storage
+type CredentialsConfig struct {
+	// Backend is where the session key is stored. It is one of the
+	// consts.CredentialsBackend values, and defaults to plaintext if empty.
+	Backend string `yaml:"backend,omitempty"`
+}
+
+// BackupConfig holds the configuration of the backups
+type BackupConfig struct {
+	// S3 is the bucket settings and credentials that override the ones from
+	// the AWS environment variables and shared files
+	S3 backup.S3Config `yaml:"s3,omitempty"`
+}
+
+// LogConfig holds the configuration of the log file
+type LogConfig struct {
+	// Level is the level of the entries written to the log file, which is
+	// either info or debug. The log file is disabled if empty.
+	Level string `yaml:"level,omitempty"`
+}
+
+// SyncConfig holds the configuration of the sync
+type SyncConfig struct {
+	// ConflictStrategy decides which copy of a note is kept when the note was
+	// changed locally and the server copy is different, unless the book of the
+	// note has its own strategy. It is local, server or newer. If empty, a note
+	// whose server copy has the same usn is resolved by newer, and a note
+	// changed on both sides is merged with conflict markers.
+	ConflictStrategy string `yaml:"conflictStrategy,omitempty"`
+	// PruneEmptyBooks deletes the local copy of a book when the server
+	// expunges its last note and the book itself is unchanged.
+	PruneEmptyBooks bool `yaml:"pruneEmptyBooks,omitempty"`
+	// Backend is what the notes are synced with. It is one of the
+	// consts.SyncBackend values, and defaults to server if empty.
+	Backend string `yaml:"backend,omitempty"`
+	// GitDir is the repository that the git backend syncs through. It
+	// defaults to a directory in the data directory if empty.
+	GitDir string `yaml:"gitDir,omitempty"`
+	// Mirrors are the servers that the local changes are pushed to after
+	// they are synced with the server
+	Mirrors []MirrorConfig `yaml:"mirrors,omitempty"`
+	// Safety decides how the changes that discard local data are applied. It
+	// is one of the consts.SyncSafety values, and defaults to normal if empty.
+	Safety string `yaml:"safety,omitempty"`
+	// DuplicateLabelPolicy decides which book is renamed when a downloaded
+	// book has the label of another local book. It is one of the
+	// consts.DuplicateLabel values, and defaults to rename-local if empty.
+	DuplicateLabelPolicy string `yaml:"duplicateLabelPolicy,omitempty"`
+	// MaxRetries is the number of times that a request to the server is
+	// retried after a network or a server error. It defaults to
+	// DefaultSyncMaxRetries if unset.
+	MaxRetries *int `yaml:"maxRetries,omitempty"`
+	// RetryBaseDelay is the delay before the first retry, such as 500ms,
+	// which doubles with each retry. It defaults to DefaultSyncRetryBaseDelay
+	// if empty.
+	RetryBaseDelay string `yaml:"retryBaseDelay,omitempty"`
+	// RequestTimeout is how long a request to the server can take, such as
+	// 30s. It defaults to DefaultSyncRequestTimeout if empty, and 0 is no limit.
+	RequestTimeout string `yaml:"requestTimeout,omitempty"`
+	// TotalTimeout is how long a sync can take, such as 5m. A sync that
+	// takes longer keeps the changes got so far. It is no limit if empty or 0.
+	TotalTimeout string `yaml:"totalTimeout,omitempty"`
+	// AutoBackup takes a snapshot of the database before every sync, which
+	// 'dnote restore --latest-auto' restores
+	AutoBackup bool `yaml:"autoBackup,omitempty"`
+	// AutoBackupKeep is the number of the snapshots kept. It defaults to
+	// DefaultSyncAutoBackupKeep if unset.
+	AutoBackupKeep int `yaml:"autoBackupKeep,omitempty"`
+	// PageSize is the number of the items asked for in each page of the
+	// paginated responses of the server. The server decides if unset.
+	PageSize int `yaml:"pageSize,omitempty"`
+}
+
+// DefaultSyncMaxRetries is the number of retries of a request to the server
+// if not configured
+const DefaultSyncMaxRetries = 3
+
+// DefaultSyncAutoBackupKeep is the number of the snapshots taken before the
+// syncs that are kept if not configured
+const DefaultSyncAutoBackupKeep = 5
+
+// DefaultSyncRetryBaseDelay is the delay before the first retry if not configured
+const DefaultSyncRetryBaseDelay = 500 * time.Millisecond
+
+// DefaultSyncRequestTimeout is how long a request to the server can take if
+// not configured
+const DefaultSyncRequestTimeout = time.Minute
+
+// MirrorConfig holds the configuration of a mirror server
+type MirrorConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	APIKey   string `yaml:"apiKey"`
+}
+
+// NormalizeConflictStrategy returns the name of the conflict strategy that the
+// given one stands for, if it is one of the other names, or the given one
+func NormalizeConflictStrategy(s string) string {
+	switch s {
+	case consts.ConflictStrategyKeepLocal:
+		return consts.ConflictStrategyLocal
+	case consts.ConflictStrategyKeepServer:
+		return consts.ConflictStrategyServer
+	case consts.ConflictStrategyNewest:
+		return consts.ConflictStrategyNewer
+	}
+
+	return s
+}
+
+// ParseConflictStrategy parses the conflict strategy as it appears in the config
+// file. It is empty if none is set.
+func ParseConflictStrategy(s string) (string, error) {
+	s = NormalizeConflictStrategy(s)
+
+	switch s {
+	case "", consts.ConflictStrategyLocal, consts.ConflictStrategyServer, consts.ConflictStrategyNewer:
+		return s, nil
+	}
+
+	return "", errors.Errorf("unknown conflict strategy '%s'. Use local, server or newer", s)
+}
+
+// ParseSyncBackend parses the sync backend as it appears in the config file
+func ParseSyncBackend(s string) (string, error) {
+	switch s {
+	case "":
+		return consts.SyncBackendServer, nil
+	case consts.SyncBackendServer, consts.SyncBackendGit:
+		return s, nil
+	}
+
+	return consts.SyncBackendServer, errors.Errorf("unknown sync backend '%s'. Use server or git", s)
+}
+
+// ParseSyncSafety parses the sync safety level as it appears in the config file
+func ParseSyncSafety(s string) (string, error) {
+	switch s {
+	case "":
+		return consts.SyncSafetyNormal, nil
+	case consts.SyncSafetyStrict, consts.SyncSafetyNormal, consts.SyncSafetyPermissive:
+		return s, nil
+	}
+
+	return consts.SyncSafetyNormal, errors.Errorf("unknown sync safety '%s'. Use strict, normal or permissive", s)
+}
+
+// ParseDuplicateLabelPolicy parses the policy for the duplicate book labels as
+// it appears in the config file
+func ParseDuplicateLabelPolicy(s string) (string, error) {
+	switch s {
+	case "":
+		return consts.DuplicateLabelRenameLocal, nil
+	case consts.DuplicateLabelRenameLocal, consts.DuplicateLabelRenameIncoming, consts.DuplicateLabelPrompt:
+		return s, nil
+	}
+
+	return consts.DuplicateLabelRenameLocal, errors.Errorf("unknown duplicate label policy '%s'. Use rename-local, rename-incoming or prompt", s)
+}
+
+// ParseAutoCreateBook parses the policy for creating missing books as it
+// appears in the config file or in a flag
+func ParseAutoCreateBook(s string) (string, error) {
+	switch s {
+	case "":
+		return consts.AutoCreateBookTrue, nil
+	case consts.AutoCreateBookTrue, consts.AutoCreateBookFalse, consts.AutoCreateBookPrompt:
+		return s, nil
+	}
+
+	return consts.AutoCreateBookTrue, errors.Errorf("unknown auto-create policy '%s'. Use true, false or prompt", s)
+}
+
+// ParseLocale parses the locale of the messages as it appears in the config
+// file. An empty locale leaves the choice to the environment.
+func ParseLocale(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	l := i18n.Normalize(s)
+	if _, ok := i18n.Lookup(l); ok {
+		return l, nil
+	}
+
+	return "", errors.Errorf("unknown locale '%s'. Use %s", s, strings.Join(i18n.Locales(), ", "))
+}
+
+// ParseNoteSize parses a limit of the size of a note body as it appears in the
+// config file, returning the default if it is empty
+func ParseNoteSize(s string, def int64) (int64, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := utils.ParseByteSize(s)
+	if err != nil {
+		return def, err
+	}
+
+	return n, nil
+}
+
+// ParseSyncAutoBackup returns the number of the snapshots of the database kept
+// by the automatic backup before the syncs, which is 0 if it is off. An invalid
+// number falls back to the default.
+func ParseSyncAutoBackup(c SyncConfig) (int, error) {
+	if !c.AutoBackup {
+		return 0, nil
+	}
+	if c.AutoBackupKeep < 0 {
+		return DefaultSyncAutoBackupKeep, errors.Errorf("invalid sync.autoBackupKeep %d. Use a positive number", c.AutoBackupKeep)
+	}
+	if c.AutoBackupKeep == 0 {
+		return DefaultSyncAutoBackupKeep, nil
+	}
+
+	return c.AutoBackupKeep, nil
+}
+
+// ParseSyncPageSize returns the number of the items asked for in each page of
+// the paginated responses of the server, which is 0 to leave it to the server.
+// A negative number falls back to 0.
+func ParseSyncPageSize(c SyncConfig) (int, error) {
+	if c.PageSize < 0 {
+		return 0, errors.Errorf("invalid sync.pageSize %d. Use a positive number", c.PageSize)
+	}
+
+	return c.PageSize, nil
+}
+
+// ParseExpungedArchive parses the limits of the archive of the expunged notes
+// as they appear in the config file, returning the defaults if any of them is
+// empty or invalid
+func ParseExpungedArchive(c ExpungedArchiveConfig) (time.Duration, int64, error) {
+	maxAge, maxSize := DefaultExpungedArchiveMaxAge, int64(DefaultExpungedArchiveMaxSize)
+
+	if c.MaxAge != "" {
+		v, err := utils.ParseAge(c.MaxAge)
+		if err != nil {
+			return DefaultExpungedArchiveMaxAge, DefaultExpungedArchiveMaxSize, errors.Wrap(err, "invalid expungedArchive.maxAge")
+		}
+
+		maxAge = v
+	}
+	if c.MaxSize != "" {
+		v, err := utils.ParseByteSize(c.MaxSize)
+		if err != nil {
+			return DefaultExpungedArchiveMaxAge, DefaultExpungedArchiveMaxSize, errors.Wrap(err, "invalid expungedArchive.maxSize")
+		}
+
+		maxSize = v
+	}
+
+	return maxAge, maxSize, nil
+}
+
+// DefaultSyncTimeouts returns the retries and the timeouts of the sync if not
+// configured
+func DefaultSyncTimeouts() context.SyncTimeouts {
+	return context.SyncTimeouts{
+		MaxRetries:     DefaultSyncMaxRetries,
+		RetryBaseDelay: DefaultSyncRetryBaseDelay,
+		RequestTimeout: DefaultSyncRequestTimeout,
+	}
+}
+
+// ParseSyncTimeouts parses the retries and the timeouts of the sync as they
+// appear in the config file, returning the defaults if any of them is invalid
+func ParseSyncTimeouts(c SyncConfig) (context.SyncTimeouts, error) {
+	def := DefaultSyncTimeouts()
+	ret := def
+
+	if c.MaxRetries != nil {
+		ret.MaxRetries = *c.MaxRetries
+	}
+
+	durations := []struct {
+		key   string
+		value string
+		dest  *time.Duration
+	}{
+		{"sync.retryBaseDelay", c.RetryBaseDelay, &ret.RetryBaseDelay},
+		{"sync.requestTimeout", c.RequestTimeout, &ret.RequestTimeout},
+		{"sync.totalTimeout", c.TotalTimeout, &ret.TotalTimeout},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return def, errors.Errorf("invalid %s '%s'. Use a duration such as 500ms, 30s or 5m", d.key, d.value)
+		}
+
+		*d.dest = v
+	}
+
+	if err := ValidateSyncTimeouts(ret); err != nil {
+		return def, err
+	}
+
+	return ret, nil
+}
+
+// ValidateSyncTimeouts checks that none of the retries and the timeouts of the
+// sync is negative, and that a sync can take as long as a single request
+func ValidateSyncTimeouts(t context.SyncTimeouts) error {
+	if t.MaxRetries < 0 {
+		return errors.New("the maximum retries cannot be negative")
+	}
+	if t.RetryBaseDelay < 0 {
+		return errors.New("the retry base delay cannot be negative")
+	}
+	if t.RequestTimeout < 0 {
+		return errors.New("the request timeout cannot be negative")
+	}
+	if t.TotalTimeout < 0 {
+		return errors.New("the total timeout cannot be negative")
+	}
+	if t.TotalTimeout > 0 && t.TotalTimeout < t.RequestTimeout {
+		return errors.Errorf("the total timeout of %s cannot be shorter than the request timeout of %s", t.TotalTimeout, t.RequestTimeout)
+	}
+
+	return nil
+}
+
+// ParseCredentialsBackend parses the credentials backend as it appears in the config file
+func ParseCredentialsBackend(s string) (string, error) {
+	switch s {
+	case "":
+		return consts.CredentialsBackendPlaintext, nil
+	case consts.CredentialsBackendPlaintext, consts.CredentialsBackendKeyring, consts.CredentialsBackendFile:
+		return s, nil
+	}
+
+	return consts.CredentialsBackendPlaintext, errors.Errorf("unknown credentials backend '%s'. Use plaintext, keyring or file", s)
 }
 
 func checkLegacyPath(ctx context.DnoteCtx) (string, bool) {
-	legacyPath := fmt.Sprintf("%s/%s", ctx.Paths.LegacyDnote, consts.ConfigFilename)
+	legacyPath := filepath.Join(ctx.Paths.LegacyDnote, consts.ConfigFilename)
 
 	ok, err := utils.FileExists(legacyPath)
 	if err != nil {
@@ -57,9 +515,12 @@ func GetPath(ctx context.DnoteCtx) string {
 		return legacyPath
 	}
 
-	return fmt.Sprintf("%s/%s/%s", ctx.Paths.Config, consts.DnoteDirName, consts.ConfigFilename)
+	return filepath.Join(ctx.Paths.Config, consts.DnoteDirName, consts.ConfigFilename)
 }
 
+// ErrCorrupt is an error for a config file that cannot be parsed
+var ErrCorrupt = errors.New("the config file is corrupt")
+
 // Read reads the config file
 func Read(ctx context.DnoteCtx) (Config, error) {
 	var ret Config
@@ -70,15 +531,22 @@ func Read(ctx context.DnoteCtx) (Config, error) {
 		return ret, errors.Wrap(err, "reading config file")
 	}
 
+	// an empty file is a result of an interrupted write, because a config file
+	// always has at least the editor and the API endpoint
+	if len(bytes.TrimSpace(b)) == 0 {
+		return ret, errors.Wrap(ErrCorrupt, "the config file is empty")
+	}
+
 	err = yaml.Unmarshal(b, &ret)
 	if err != nil {
-		return ret, errors.Wrap(err, "unmarshalling config")
+		return ret, errors.Wrapf(ErrCorrupt, "unmarshalling config: %s", err.Error())
 	}
 
 	return ret, nil
 }
 
-// Write writes the config to the config file
+// Write writes the config to the config file. The file is replaced atomically
+// so that a crash in the middle does not leave a partially written file.
 func Write(ctx context.DnoteCtx, cf Config) error {
 	path := GetPath(ctx)
 
@@ -87,10 +555,36 @@ func Write(ctx context.DnoteCtx, cf Config) error {
 		return errors.Wrap(err, "marshalling config into YAML")
 	}
 
-	err = ioutil.WriteFile(path, b, 0644)
+	err = utils.WriteFileAtomic(path, b, 0644)
 	if err != nil {
 		return errors.Wrap(err, "writing the config file")
 	}
 
 	return nil
 }
+
+// Recover replaces the config file with the given config if it is corrupt.
+// The corrupt file is moved aside with a '.corrupt' suffix so that it can be
+// inspected. It returns the path of the corrupt file if the config was
+// recovered, and an empty string otherwise.
+func Recover(ctx context.DnoteCtx, cf Config) (string, error) {
+	_, err := Read(ctx)
+	if err == nil {
+		return "", nil
+	}
+	if errors.Cause(err) != ErrCorrupt {
+		return "", errors.Wrap(err, "reading the config file")
+	}
+
+	path := GetPath(ctx)
+	corruptPath := path + ".corrupt"
+	if err := os.Rename(path, corruptPath); err != nil {
+		return "", errors.Wrap(err, "moving the corrupt config file")
+	}
+
+	if err := Write(ctx, cf); err != nil {
+		return "", errors.Wrap(err, "writing a new config file")
+	}
+
+	return corruptPath, nil
+}
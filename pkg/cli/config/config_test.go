@@ -0,0 +1,503 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/pkg/errors"
+)
+
+func newTestCtx(t *testing.T) context.DnoteCtx {
+	dir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(dir, consts.DnoteDirName), 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "creating the dnote dir"))
+	}
+
+	return context.DnoteCtx{
+		Paths: context.Paths{
+			Config:      dir,
+			LegacyDnote: filepath.Join(dir, "legacy"),
+		},
+	}
+}
+
+// tornConfig is a config file cut off in the middle of a key, as a crash
+// during a non-atomic write would leave it
+var tornConfig = []byte("editor: vim\napiEnd")
+
+var testConfig = Config{
+	Editor:      "vim",
+	APIEndpoint: "https://api.getdnote.com",
+	Aliases:     map[string]string{"til": "add learnings"},
+	Sync:        SyncConfig{ConflictStrategy: consts.ConflictStrategyServer, PruneEmptyBooks: true},
+	// a policy that YAML would read as a boolean if it were not quoted
+	AutoCreateBook: consts.AutoCreateBookFalse,
+}
+
+func TestWrite(t *testing.T) {
+	ctx := newTestCtx(t)
+
+	if err := Write(ctx, testConfig); err != nil {
+		t.Fatal(errors.Wrap(err, "writing"))
+	}
+
+	result, err := Read(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading"))
+	}
+
+	assert.DeepEqual(t, result, testConfig, "config mismatch")
+}
+
+func TestRead_corrupt(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "empty", content: []byte{}},
+		{name: "whitespace", content: []byte("\n  \n")},
+		{name: "torn", content: tornConfig},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := newTestCtx(t)
+			if err := ioutil.WriteFile(GetPath(ctx), tc.content, 0644); err != nil {
+				t.Fatal(errors.Wrap(err, "writing the config"))
+			}
+
+			_, err := Read(ctx)
+			assert.Equal(t, errors.Cause(err), ErrCorrupt, "error mismatch")
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	defaultConfig := Config{Editor: "vi", APIEndpoint: "https://api.getdnote.com"}
+
+	t.Run("torn write", func(t *testing.T) {
+		ctx := newTestCtx(t)
+		if err := ioutil.WriteFile(GetPath(ctx), tornConfig, 0644); err != nil {
+			t.Fatal(errors.Wrap(err, "writing the torn config"))
+		}
+
+		corruptPath, err := Recover(ctx, defaultConfig)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "recovering"))
+		}
+
+		assert.Equal(t, corruptPath, GetPath(ctx)+".corrupt", "corrupt path mismatch")
+
+		b, err := ioutil.ReadFile(corruptPath)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading the corrupt file"))
+		}
+		assert.Equal(t, string(b), string(tornConfig), "corrupt file content mismatch")
+
+		result, err := Read(ctx)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading the recovered config"))
+		}
+		assert.DeepEqual(t, result, defaultConfig, "recovered config mismatch")
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		ctx := newTestCtx(t)
+		if err := Write(ctx, testConfig); err != nil {
+			t.Fatal(errors.Wrap(err, "writing"))
+		}
+
+		corruptPath, err := Recover(ctx, defaultConfig)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "recovering"))
+		}
+
+		assert.Equal(t, corruptPath, "", "corrupt path mismatch")
+
+		result, err := Read(ctx)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading"))
+		}
+		assert.DeepEqual(t, result, testConfig, "config was modified")
+	})
+}
+
+func TestParseConflictStrategy(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    string
+		expectedErr bool
+	}{
+		{input: "", expected: ""},
+		{input: "local", expected: consts.ConflictStrategyLocal},
+		{input: "server", expected: consts.ConflictStrategyServer},
+		{input: "newer", expected: consts.ConflictStrategyNewer},
+		{input: "keep-local", expected: consts.ConflictStrategyLocal},
+		{input: "keep-server", expected: consts.ConflictStrategyServer},
+		{input: "newest", expected: consts.ConflictStrategyNewer},
+		{input: "remote", expected: "", expectedErr: true},
+		{input: "merge", expected: "", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseConflictStrategy(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestNormalizeConflictStrategy(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "keep-local", expected: consts.ConflictStrategyLocal},
+		{input: "keep-server", expected: consts.ConflictStrategyServer},
+		{input: "newest", expected: consts.ConflictStrategyNewer},
+		{input: "local", expected: consts.ConflictStrategyLocal},
+		{input: "merge", expected: consts.ConflictStrategyMerge},
+		{input: "remote", expected: "remote"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			assert.Equal(t, NormalizeConflictStrategy(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestParseDuplicateLabelPolicy(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    string
+		expectedErr bool
+	}{
+		{input: "", expected: consts.DuplicateLabelRenameLocal},
+		{input: "rename-local", expected: consts.DuplicateLabelRenameLocal},
+		{input: "rename-incoming", expected: consts.DuplicateLabelRenameIncoming},
+		{input: "prompt", expected: consts.DuplicateLabelPrompt},
+		{input: "rename-server", expected: consts.DuplicateLabelRenameLocal, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseDuplicateLabelPolicy(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseSyncBackend(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    string
+		expectedErr bool
+	}{
+		{input: "", expected: consts.SyncBackendServer},
+		{input: "server", expected: consts.SyncBackendServer},
+		{input: "git", expected: consts.SyncBackendGit},
+		{input: "svn", expected: consts.SyncBackendServer, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseSyncBackend(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseSyncSafety(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    string
+		expectedErr bool
+	}{
+		{input: "", expected: consts.SyncSafetyNormal},
+		{input: "strict", expected: consts.SyncSafetyStrict},
+		{input: "normal", expected: consts.SyncSafetyNormal},
+		{input: "permissive", expected: consts.SyncSafetyPermissive},
+		{input: "paranoid", expected: consts.SyncSafetyNormal, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseSyncSafety(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseLocale(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    string
+		expectedErr bool
+	}{
+		{input: "", expected: ""},
+		{input: "en", expected: "en"},
+		{input: "es", expected: "es"},
+		{input: "es_ES.UTF-8", expected: "es"},
+		{input: "klingon", expected: "", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseLocale(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseCredentialsBackend(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    string
+		expectedErr bool
+	}{
+		{input: "", expected: consts.CredentialsBackendPlaintext},
+		{input: "plaintext", expected: consts.CredentialsBackendPlaintext},
+		{input: "keyring", expected: consts.CredentialsBackendKeyring},
+		{input: "file", expected: consts.CredentialsBackendFile},
+		{input: "vault", expected: consts.CredentialsBackendPlaintext, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseCredentialsBackend(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseAutoCreateBook(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    string
+		expectedErr bool
+	}{
+		{input: "", expected: consts.AutoCreateBookTrue},
+		{input: "true", expected: consts.AutoCreateBookTrue},
+		{input: "false", expected: consts.AutoCreateBookFalse},
+		{input: "prompt", expected: consts.AutoCreateBookPrompt},
+		{input: "ask", expected: consts.AutoCreateBookTrue, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseAutoCreateBook(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseNoteSize(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    int64
+		expectedErr bool
+	}{
+		{input: "", expected: DefaultNoteSizeMax},
+		{input: "500KB", expected: 500000},
+		{input: "2MiB", expected: 2 << 20},
+		{input: "0", expected: 0},
+		{input: "lots", expected: DefaultNoteSizeMax, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseNoteSize(tc.input, DefaultNoteSizeMax)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseSyncAutoBackup(t *testing.T) {
+	testCases := []struct {
+		input       SyncConfig
+		expected    int
+		expectedErr bool
+	}{
+		{input: SyncConfig{}, expected: 0},
+		{input: SyncConfig{AutoBackupKeep: 3}, expected: 0},
+		{input: SyncConfig{AutoBackup: true}, expected: DefaultSyncAutoBackupKeep},
+		{input: SyncConfig{AutoBackup: true, AutoBackupKeep: 3}, expected: 3},
+		{input: SyncConfig{AutoBackup: true, AutoBackupKeep: -1}, expected: DefaultSyncAutoBackupKeep, expectedErr: true},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			got, err := ParseSyncAutoBackup(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseSyncPageSize(t *testing.T) {
+	testCases := []struct {
+		input       SyncConfig
+		expected    int
+		expectedErr bool
+	}{
+		{input: SyncConfig{}, expected: 0},
+		{input: SyncConfig{PageSize: 500}, expected: 500},
+		{input: SyncConfig{PageSize: -1}, expected: 0, expectedErr: true},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			got, err := ParseSyncPageSize(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseExpungedArchive(t *testing.T) {
+	testCases := []struct {
+		input           ExpungedArchiveConfig
+		expectedMaxAge  time.Duration
+		expectedMaxSize int64
+		expectedErr     bool
+	}{
+		{input: ExpungedArchiveConfig{}, expectedMaxAge: DefaultExpungedArchiveMaxAge, expectedMaxSize: DefaultExpungedArchiveMaxSize},
+		{input: ExpungedArchiveConfig{MaxAge: "7d", MaxSize: "1MB"}, expectedMaxAge: 7 * 24 * time.Hour, expectedMaxSize: 1000000},
+		{input: ExpungedArchiveConfig{MaxAge: "0"}, expectedMaxAge: 0, expectedMaxSize: DefaultExpungedArchiveMaxSize},
+		{input: ExpungedArchiveConfig{MaxAge: "soon", MaxSize: "1MB"}, expectedMaxAge: DefaultExpungedArchiveMaxAge, expectedMaxSize: DefaultExpungedArchiveMaxSize, expectedErr: true},
+		{input: ExpungedArchiveConfig{MaxSize: "big"}, expectedMaxAge: DefaultExpungedArchiveMaxAge, expectedMaxSize: DefaultExpungedArchiveMaxSize, expectedErr: true},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			maxAge, maxSize, err := ParseExpungedArchive(tc.input)
+
+			assert.Equal(t, maxAge, tc.expectedMaxAge, "max age mismatch")
+			assert.Equal(t, maxSize, tc.expectedMaxSize, "max size mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestParseSyncTimeouts(t *testing.T) {
+	zero := 0
+	negative := -1
+
+	testCases := []struct {
+		name        string
+		input       SyncConfig
+		expected    context.SyncTimeouts
+		expectedErr bool
+	}{
+		{
+			name:     "default",
+			input:    SyncConfig{},
+			expected: DefaultSyncTimeouts(),
+		},
+		{
+			name:  "set",
+			input: SyncConfig{MaxRetries: &zero, RetryBaseDelay: "1s", RequestTimeout: "10s", TotalTimeout: "5m"},
+			expected: context.SyncTimeouts{
+				MaxRetries:     0,
+				RetryBaseDelay: time.Second,
+				RequestTimeout: 10 * time.Second,
+				TotalTimeout:   5 * time.Minute,
+			},
+		},
+		{
+			name:  "no request timeout",
+			input: SyncConfig{RequestTimeout: "0", TotalTimeout: "1s"},
+			expected: context.SyncTimeouts{
+				MaxRetries:     DefaultSyncMaxRetries,
+				RetryBaseDelay: DefaultSyncRetryBaseDelay,
+				TotalTimeout:   time.Second,
+			},
+		},
+		{
+			name:        "negative retries",
+			input:       SyncConfig{MaxRetries: &negative},
+			expected:    DefaultSyncTimeouts(),
+			expectedErr: true,
+		},
+		{
+			name:        "negative delay",
+			input:       SyncConfig{RetryBaseDelay: "-1s"},
+			expected:    DefaultSyncTimeouts(),
+			expectedErr: true,
+		},
+		{
+			name:        "invalid duration",
+			input:       SyncConfig{RequestTimeout: "soon"},
+			expected:    DefaultSyncTimeouts(),
+			expectedErr: true,
+		},
+		{
+			name:        "total shorter than request",
+			input:       SyncConfig{RequestTimeout: "30s", TotalTimeout: "10s"},
+			expected:    DefaultSyncTimeouts(),
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseSyncTimeouts(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestUpgradeNudgeEnabled(t *testing.T) {
+	on, off := true, false
+
+	assert.Equal(t, NotificationsConfig{}.UpgradeNudgeEnabled(), true, "default mismatch")
+	assert.Equal(t, NotificationsConfig{UpgradeNudge: &on}.UpgradeNudgeEnabled(), true, "on mismatch")
+	assert.Equal(t, NotificationsConfig{UpgradeNudge: &off}.UpgradeNudgeEnabled(), false, "off mismatch")
+}
@@ -32,6 +32,45 @@ var (
 	TmpContentFileExt = "md"
 	// ConfigFilename is the name of the config file
 	ConfigFilename = "dnoterc"
+	// SyncLockFilename is the name of the file that exists while a sync is in progress
+	SyncLockFilename = "sync.lock"
+	// UploadIntentsFilename is the name of the database in the dnote directory
+	// that the notes being uploaded by a sync are recorded in
+	UploadIntentsFilename = "upload-intents.db"
+	// LogFilename is the name of the log file in the cache directory
+	LogFilename = "dnote.log"
+	// GitDirName is the name of the default repository of the git sync backend
+	// in the data directory
+	GitDirName = "git"
+	// AutoBackupDirName is the name of the directory in the cache directory
+	// that the snapshots of the database taken before the syncs are kept in
+	AutoBackupDirName = "backups"
+	// CredentialsFilename is the name of the file in the data directory that
+	// the file credentials backend keeps the session key in
+	CredentialsFilename = "credentials"
+	// PassphraseEnv is the name of the environment variable holding the
+	// passphrase of the credentials file
+	PassphraseEnv = "DNOTE_PASSPHRASE"
+	// APIKeyEnv is the name of the environment variable holding the session
+	// key to use instead of the stored one
+	APIKeyEnv = "DNOTE_API_KEY"
+	// APIEndpointEnv is the name of the environment variable overriding the
+	// API endpoint in the config
+	APIEndpointEnv = "DNOTE_API_ENDPOINT"
+	// HostedAPIEndpoint is the API endpoint of the hosted Dnote server
+	HostedAPIEndpoint = "https://api.getdnote.com"
+	// HostedWebEndpoint is the web endpoint of the hosted Dnote server
+	HostedWebEndpoint = "https://www.getdnote.com"
+	// HomeEnv is the name of the environment variable holding the directory
+	// that keeps the config, the data and the cache, instead of the
+	// directories of the user
+	HomeEnv = "DNOTE_HOME"
+	// EditorEnv is the name of the environment variable overriding the editor
+	// in the config
+	EditorEnv = "DNOTE_EDITOR"
+	// ProfileEnv is the name of the environment variable holding the directory
+	// that the sync writes its profiles to
+	ProfileEnv = "DNOTE_PROFILE"
 
 	// SystemSchema is the key for schema in the system table
 	SystemSchema = "schema"
@@ -47,4 +86,117 @@ var (
 	SystemSessionKey = "session_token"
 	// SystemSessionKeyExpiry is the timestamp at which the session key will expire
 	SystemSessionKeyExpiry = "session_token_expiry"
+	// SystemLastGitCommit is the commit of the repository at the last sync
+	// with the git backend
+	SystemLastGitCommit = "last_git_commit"
+	// SystemMirrorStatePrefix is the prefix of the keys for the changes yet to
+	// be pushed to each mirror, which are followed by the mirror endpoint
+	SystemMirrorStatePrefix = "mirror_state:"
+	// SystemDeviceID is the key for the id of this install, which is generated
+	// at the first run and stamped on the notes and the actions made here
+	SystemDeviceID = "device_id"
+	// SystemLatestVersion is the latest release of dnote advertised by the
+	// server at the last sync
+	SystemLatestVersion = "latest_version"
+	// SystemLastUpgradeNudge is the timestamp at which dnote last told that
+	// a newer release is available
+	SystemLastUpgradeNudge = "last_upgrade_nudge"
+	// SystemSyncBooks is the labels of the books that the syncs are scoped to,
+	// as a JSON array. It is missing if the syncs get every book.
+	SystemSyncBooks = "sync_books"
+)
+
+// The strategies for resolving a note that was changed locally and whose server
+// copy is different. sync.conflictStrategy in the config file sets one of
+// local, server and newer for all notes, and dnote book set-strategy sets any
+// of them for the notes of a book. Without either, a note whose usn is the same
+// as that of the server copy is resolved by newer, and a note changed on both
+// sides is merged with conflict markers.
+const (
+	// ConflictStrategyLocal keeps the local copy, which is uploaded
+	ConflictStrategyLocal = "local"
+	// ConflictStrategyServer keeps the server copy
+	ConflictStrategyServer = "server"
+	// ConflictStrategyNewer keeps the copy edited more recently, or the local copy
+	// if both were edited at the same time
+	ConflictStrategyNewer = "newer"
+	// ConflictStrategyMerge merges the bodies with conflict markers
+	ConflictStrategyMerge = "merge"
+	// ConflictStrategyConflictCopy keeps the server copy, and the local copy as
+	// a new note in the conflicts book
+	ConflictStrategyConflictCopy = "conflict-copy"
+)
+
+// The other names of the conflict strategies, which are accepted wherever a
+// strategy is set and stand for the ones above
+const (
+	// ConflictStrategyKeepLocal stands for ConflictStrategyLocal
+	ConflictStrategyKeepLocal = "keep-local"
+	// ConflictStrategyKeepServer stands for ConflictStrategyServer
+	ConflictStrategyKeepServer = "keep-server"
+	// ConflictStrategyNewest stands for ConflictStrategyNewer
+	ConflictStrategyNewest = "newest"
+)
+
+// The backends that notes are synced with, which are set by sync.backend in the
+// config file
+const (
+	// SyncBackendServer syncs with the dnote server. It is the default.
+	SyncBackendServer = "server"
+	// SyncBackendGit syncs through a git repository
+	SyncBackendGit = "git"
+)
+
+// The levels of safety for the changes of a sync that discard local data, which
+// are set by sync.safety in the config file
+const (
+	// SyncSafetyStrict asks for a confirmation before every such change, and
+	// fails in the non-interactive mode
+	SyncSafetyStrict = "strict"
+	// SyncSafetyNormal keeps the local changes that were not sent to the
+	// server, and applies the other changes. It is the default.
+	SyncSafetyNormal = "normal"
+	// SyncSafetyPermissive applies every change
+	SyncSafetyPermissive = "permissive"
+)
+
+// The policies for a book downloaded by a sync whose label is taken by another
+// local book, which are set by sync.duplicateLabelPolicy in the config file.
+// The renamed book is marked dirty so that its new label is uploaded.
+const (
+	// DuplicateLabelRenameLocal appends a number to the label of the local
+	// book. It is the default.
+	DuplicateLabelRenameLocal = "rename-local"
+	// DuplicateLabelRenameIncoming appends a number to the label of the
+	// downloaded book
+	DuplicateLabelRenameIncoming = "rename-incoming"
+	// DuplicateLabelPrompt asks which of the books to rename, and renames the
+	// local book in the non-interactive mode
+	DuplicateLabelPrompt = "prompt"
+)
+
+// The policies for creating a book that a note is added or moved to, or that
+// notes are imported into, if it does not exist. They are set by autoCreateBook
+// in the config file.
+const (
+	// AutoCreateBookTrue creates the book. It is the default.
+	AutoCreateBookTrue = "true"
+	// AutoCreateBookFalse fails, listing the books with similar names
+	AutoCreateBookFalse = "false"
+	// AutoCreateBookPrompt asks for a confirmation, and fails in the
+	// non-interactive mode
+	AutoCreateBookPrompt = "prompt"
+)
+
+// The backends that the session key is stored in, which are set by
+// credentials.backend in the config file
+const (
+	// CredentialsBackendPlaintext stores the key unencrypted in the database.
+	// It is the default.
+	CredentialsBackendPlaintext = "plaintext"
+	// CredentialsBackendKeyring stores the key in the keyring of the operating
+	// system
+	CredentialsBackendKeyring = "keyring"
+	// CredentialsBackendFile stores the key in a file encrypted with a passphrase
+	CredentialsBackendFile = "file"
 )
@@ -20,7 +20,14 @@
 package context
 
 import (
+	stdCtx "context"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/clipboard"
+	"github.com/dnote/dnote/pkg/cli/credentials"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/hooks"
+	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/clock"
 )
 
@@ -37,12 +44,125 @@ type Paths struct {
 type DnoteCtx struct {
 	Paths            Paths
 	APIEndpoint      string
+	WebEndpoint      string
 	Version          string
 	DB               *database.DB
 	SessionKey       string
 	SessionKeyExpiry int64
+	// CredentialsBackend is the consts.CredentialsBackend value that the
+	// Credentials store is created for
+	CredentialsBackend string
+	// Credentials is where the session key is stored. SessionKey is empty
+	// until the key is read from it by infra.LoadSessionKey.
+	Credentials      credentials.Store
 	Editor           string
+	TimeFormat       string
+	Aliases          map[string]string
+	AutoVacuum       bool
+	ConflictStrategy string
+	PruneEmptyBooks  bool
+	SyncBackend      string
+	GitDir           string
+	SyncMirrors      []SyncMirror
+	Hooks            hooks.Config
 	Clock            clock.Clock
+	Logger           *log.Logger
+	FileLogger       *log.FileLogger
+	// SyncProfileDir is the directory that the sync writes its profiles to.
+	// It is set by consts.ProfileEnv and takes precedence over the flag.
+	SyncProfileDir string
+	// AutoCreateBook is the consts.AutoCreateBook policy for creating the
+	// missing books
+	AutoCreateBook string
+	// SyncSafety is the consts.SyncSafety level that decides how a sync
+	// applies the changes that discard local data
+	SyncSafety string
+	// SyncDuplicateLabelPolicy is the consts.DuplicateLabel policy that
+	// decides which book is renamed when a downloaded book has the label of
+	// another local book
+	SyncDuplicateLabelPolicy string
+	// Ctx is canceled to abort the HTTP requests, e.g. when the command is
+	// interrupted. It may be nil.
+	Ctx stdCtx.Context
+	// LocalOnly indicates that the user chose not to sync with a server
+	LocalOnly bool
+	// Clipboard is the clipboard that notes are added from and copied to
+	Clipboard clipboard.Clipboard
+	// DeviceID is the id of this install, which is stamped on the notes and
+	// the actions made here
+	DeviceID string
+	// DeviceName is the human-friendly name of this install
+	DeviceName string
+	// NoteSizeWarn is the number of bytes above which a note body is warned
+	// about when it is written, and is not checked if zero
+	NoteSizeWarn int64
+	// NoteSizeMax is the number of bytes above which a note body is refused
+	// when it is written unless forced, and is not checked if zero
+	NoteSizeMax int64
+	// SyncTimeouts decides how the requests to the server are retried and
+	// how long they and a sync can take
+	SyncTimeouts SyncTimeouts
+	// SyncPageSize is the number of the items asked for in each page of the
+	// paginated responses of the server, or zero to leave it to the server
+	SyncPageSize int
+	// ExpungedMaxAge is how long the notes removed by the syncs are kept in
+	// the archive of the expunged notes, which is off if zero
+	ExpungedMaxAge time.Duration
+	// ExpungedMaxSize is the number of bytes of the archived bodies above
+	// which the oldest ones are dropped, and the archive is off if zero
+	ExpungedMaxSize int64
+	// ApplyRetention applies the retention policies of the books after every
+	// successful sync
+	ApplyRetention bool
+	// UpgradeManifestURL is the URL of the document that describes the latest
+	// release, or empty to find it on GitHub
+	UpgradeManifestURL string
+	// UpgradeNudge tells after a sync that a newer release is available
+	UpgradeNudge bool
+	// SyncPartial makes a sync upload as many of the new notes and books as
+	// fit in the limits of the account, instead of failing when not all of
+	// them fit
+	SyncPartial bool
+	// SyncBooks are the labels of the books that a sync is scoped to, which
+	// are saved and kept by the later syncs. A sync gets every book if it is
+	// empty and no scope is saved.
+	SyncBooks []string
+	// SyncInitial makes the sync with SyncBooks the first one of the database
+	SyncInitial bool
+	// SyncAutoBackup is the number of the snapshots of the database, taken
+	// before every sync, that are kept. No snapshot is taken if it is zero.
+	SyncAutoBackup int
+}
+
+// SyncMirror is a server that the local changes are pushed to after a sync
+type SyncMirror struct {
+	Endpoint string
+	APIKey   string
+}
+
+// SyncTimeouts decides how the requests to the server are retried and timed
+// out. The zero value makes a single attempt of each request without a timeout.
+type SyncTimeouts struct {
+	// MaxRetries is the number of times that a request which can be repeated
+	// safely is retried after a network error or a server error
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry, which doubles with
+	// each of the following ones
+	RetryBaseDelay time.Duration
+	// RequestTimeout is how long a request can take, or zero for no limit
+	RequestTimeout time.Duration
+	// TotalTimeout is how long a sync can take, or zero for no limit
+	TotalTimeout time.Duration
+}
+
+// Context returns the context to which the HTTP requests are bound. It never
+// returns nil.
+func (c DnoteCtx) Context() stdCtx.Context {
+	if c.Ctx == nil {
+		return stdCtx.Background()
+	}
+
+	return c.Ctx
 }
 
 // Redact replaces private information from the context with a set of
@@ -56,5 +176,11 @@ func Redact(ctx DnoteCtx) DnoteCtx {
 	}
 	ctx.SessionKey = sessionKey
 
+	mirrors := make([]SyncMirror, len(ctx.SyncMirrors))
+	for i, m := range ctx.SyncMirrors {
+		mirrors[i] = SyncMirror{Endpoint: m.Endpoint}
+	}
+	ctx.SyncMirrors = mirrors
+
 	return ctx
 }
@@ -19,26 +19,32 @@
 package context
 
 import (
-	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/dnote/dnote/pkg/cli/clipboard"
 	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/credentials"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/clock"
 	"github.com/pkg/errors"
 )
 
 // InitTestCtx initializes a test context
 func InitTestCtx(t *testing.T, paths Paths, dbOpts *database.TestDBOptions) DnoteCtx {
-	dbPath := fmt.Sprintf("%s/%s/%s", paths.Data, consts.DnoteDirName, consts.DnoteDBFileName)
+	dbPath := filepath.Join(paths.Data, consts.DnoteDirName, consts.DnoteDBFileName)
 
 	db := database.InitTestDB(t, dbPath, dbOpts)
 
 	return DnoteCtx{
-		DB:    db,
-		Paths: paths,
-		Clock: clock.NewMock(), // Use a mock clock to test times
+		DB:          db,
+		Paths:       paths,
+		Credentials: credentials.NewPlaintext(db),
+		Clock:       clock.NewMock(), // Use a mock clock to test times
+		Logger:      log.Default(),
+		Clipboard:   clipboard.NewMemory(""),
 	}
 }
 
@@ -0,0 +1,38 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package credentials stores the session key that authenticates the client
+// with the server
+package credentials
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is an error for no session key being stored
+var ErrNotFound = errors.New("no session key is stored")
+
+// Store is where the session key is kept
+type Store interface {
+	// Get returns the session key. It returns ErrNotFound if none is stored.
+	Get() (string, error)
+	// Set saves the session key, replacing the existing one
+	Set(key string) error
+	// Delete removes the session key. It does nothing if none is stored.
+	Delete() error
+}
@@ -0,0 +1,146 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/dnote/dnote/pkg/cli/crypt"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrWrongPassphrase is an error for a passphrase that does not decrypt the
+// credentials file
+var ErrWrongPassphrase = errors.New("wrong passphrase for the credentials file")
+
+// PassphraseFunc returns the passphrase of the credentials file
+type PassphraseFunc func() (string, error)
+
+// fileIterations is the number of the PBKDF2 iterations that derive the
+// encryption key from the passphrase
+const fileIterations = 100000
+
+// saltSize is the size of the random salt of the key derivation
+const saltSize = 16
+
+// credentialsFile is the content of the credentials file
+type credentialsFile struct {
+	Salt       string `json:"salt"`
+	Iterations int    `json:"iterations"`
+	// Key is the session key encrypted by crypt.AesGcmEncrypt
+	Key string `json:"key"`
+}
+
+// fileStore keeps the session key in a file, encrypted with a key derived
+// from a passphrase
+type fileStore struct {
+	path       string
+	passphrase PassphraseFunc
+}
+
+// NewFile returns a store that keeps the session key encrypted in the file at
+// the path. The passphrase is asked only when the file is read or written.
+func NewFile(path string, passphrase PassphraseFunc) Store {
+	return fileStore{path: path, passphrase: passphrase}
+}
+
+func deriveKey(passphrase string, salt []byte, iterations int) []byte {
+	return pbkdf2.Key([]byte(passphrase), salt, iterations, 32, sha256.New)
+}
+
+func (s fileStore) Get() (string, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", errors.Wrap(err, "reading the credentials file")
+	}
+
+	var f credentialsFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return "", errors.Wrap(err, "unmarshalling the credentials file")
+	}
+	salt, err := base64.StdEncoding.DecodeString(f.Salt)
+	if err != nil {
+		return "", errors.Wrap(err, "decoding the salt")
+	}
+
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return "", errors.Wrap(err, "getting the passphrase")
+	}
+
+	key, err := crypt.AesGcmDecrypt(deriveKey(passphrase, salt, f.Iterations), f.Key)
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+
+	return string(key), nil
+}
+
+func (s fileStore) Set(key string) error {
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return errors.Wrap(err, "getting the passphrase")
+	}
+	if passphrase == "" {
+		return errors.New("the passphrase is empty")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return errors.Wrap(err, "generating the salt")
+	}
+
+	encrypted, err := crypt.AesGcmEncrypt(deriveKey(passphrase, salt, fileIterations), []byte(key))
+	if err != nil {
+		return errors.Wrap(err, "encrypting the session key")
+	}
+
+	b, err := json.Marshal(credentialsFile{
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Iterations: fileIterations,
+		Key:        encrypted,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshalling the credentials file")
+	}
+
+	if err := utils.WriteFileAtomic(s.path, b, 0600); err != nil {
+		return errors.Wrap(err, "writing the credentials file")
+	}
+
+	return nil
+}
+
+func (s fileStore) Delete() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing the credentials file")
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func newTestFileStore(t *testing.T, passphrase string) (Store, string) {
+	path := filepath.Join(t.TempDir(), "credentials")
+
+	return NewFile(path, func() (string, error) { return passphrase, nil }), path
+}
+
+func TestFileStore(t *testing.T) {
+	s, path := newTestFileStore(t, "pass")
+
+	_, err := s.Get()
+	assert.Equal(t, err, ErrNotFound, "error mismatch before set")
+
+	if err := s.Set("someSessionKey"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the key"))
+	}
+
+	got, err := s.Get()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the key"))
+	}
+	assert.Equal(t, got, "someSessionKey", "key mismatch")
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the file"))
+	}
+	assert.Equal(t, bytes.Contains(b, []byte("someSessionKey")), false, "the file contains the plaintext key")
+
+	if err := s.Delete(); err != nil {
+		t.Fatal(errors.Wrap(err, "deleting the key"))
+	}
+
+	_, err = s.Get()
+	assert.Equal(t, err, ErrNotFound, "error mismatch after delete")
+	_, err = os.Stat(path)
+	assert.Equal(t, os.IsNotExist(err), true, "the file was not removed")
+}
+
+func TestFileStore_replace(t *testing.T) {
+	s, _ := newTestFileStore(t, "pass")
+
+	if err := s.Set("key1"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the first key"))
+	}
+	if err := s.Set("key2"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the second key"))
+	}
+
+	got, err := s.Get()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the key"))
+	}
+	assert.Equal(t, got, "key2", "key mismatch")
+}
+
+func TestFileStore_wrongPassphrase(t *testing.T) {
+	s, path := newTestFileStore(t, "pass")
+	if err := s.Set("someSessionKey"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the key"))
+	}
+
+	other := NewFile(path, func() (string, error) { return "wrong", nil })
+	_, err := other.Get()
+	assert.Equal(t, err, ErrWrongPassphrase, "error mismatch")
+}
+
+func TestFileStore_emptyPassphrase(t *testing.T) {
+	s, path := newTestFileStore(t, "")
+
+	err := s.Set("someSessionKey")
+	assert.NotEqual(t, err, nil, "no error for an empty passphrase")
+
+	_, err = os.Stat(path)
+	assert.Equal(t, os.IsNotExist(err), true, "the file was written")
+}
+
+func TestFileStore_passphraseError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	s := NewFile(path, func() (string, error) { return "", errors.New("no terminal") })
+
+	assert.NotEqual(t, s.Set("someSessionKey"), nil, "no error for set")
+
+	// the passphrase is not needed to find out that nothing is stored
+	_, err := s.Get()
+	assert.Equal(t, err, ErrNotFound, "error mismatch for get")
+}
+
+func TestFileStore_deleteMissing(t *testing.T) {
+	s, _ := newTestFileStore(t, "pass")
+
+	assert.Equal(t, s.Delete(), nil, "error mismatch")
+}
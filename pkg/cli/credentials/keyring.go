@@ -0,0 +1,87 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrKeyringNotFound is an error for a keyring entry that does not exist
+var ErrKeyringNotFound = errors.New("the keyring entry is not found")
+
+// Keyring is a secret storage of the operating system
+type Keyring interface {
+	// Get returns the secret of the entry. It returns ErrKeyringNotFound if
+	// the entry does not exist.
+	Get(service, account string) (string, error)
+	// Set creates or replaces the entry
+	Set(service, account, secret string) error
+	// Delete removes the entry. It returns ErrKeyringNotFound if the entry
+	// does not exist.
+	Delete(service, account string) error
+}
+
+// keyringService is the service name of the keyring entries of dnote
+const keyringService = "dnote"
+
+// keyringStore keeps the session key in a keyring
+type keyringStore struct {
+	keyring Keyring
+	account string
+}
+
+// NewKeyring returns a store that keeps the session key in the keyring. The
+// account distinguishes the keys for different servers, and is usually the
+// API endpoint.
+func NewKeyring(keyring Keyring, account string) Store {
+	return keyringStore{keyring: keyring, account: account}
+}
+
+func (s keyringStore) Get() (string, error) {
+	key, err := s.keyring.Get(keyringService, s.account)
+	if err == ErrKeyringNotFound {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", errors.Wrap(err, "reading the keyring")
+	}
+
+	return key, nil
+}
+
+func (s keyringStore) Set(key string) error {
+	if err := s.keyring.Set(keyringService, s.account, key); err != nil {
+		return errors.Wrap(err, "writing to the keyring")
+	}
+
+	return nil
+}
+
+func (s keyringStore) Delete() error {
+	err := s.keyring.Delete(keyringService, s.account)
+	if err != nil && err != ErrKeyringNotFound {
+		return errors.Wrap(err, "deleting from the keyring")
+	}
+
+	return nil
+}
+
+// SystemKeyring returns the keyring of the operating system
+func SystemKeyring() Keyring {
+	return systemKeyring{}
+}
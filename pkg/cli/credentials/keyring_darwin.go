@@ -0,0 +1,74 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"github.com/pkg/errors"
+)
+
+// security is the program that manages the macOS keychain
+const security = "security"
+
+// securityNotFound is the exit code of security for an item that does not exist
+const securityNotFound = 44
+
+// systemKeyring is the login keychain
+type systemKeyring struct{}
+
+func (systemKeyring) Get(service, account string) (string, error) {
+	out, code, err := runKeyringProgram("", security, "find-generic-password", "-s", service, "-a", account, "-w")
+	if err != nil {
+		return "", err
+	}
+	if code == securityNotFound {
+		return "", ErrKeyringNotFound
+	}
+	if code != 0 {
+		return "", errors.Errorf("finding the password: %s", out)
+	}
+
+	return out, nil
+}
+
+func (systemKeyring) Set(service, account, secret string) error {
+	out, code, err := runKeyringProgram("", security, "add-generic-password", "-U", "-s", service, "-a", account, "-w", secret)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return errors.Errorf("adding the password: %s", out)
+	}
+
+	return nil
+}
+
+func (systemKeyring) Delete(service, account string) error {
+	out, code, err := runKeyringProgram("", security, "delete-generic-password", "-s", service, "-a", account)
+	if err != nil {
+		return err
+	}
+	if code == securityNotFound {
+		return ErrKeyringNotFound
+	}
+	if code != 0 {
+		return errors.Errorf("deleting the password: %s", out)
+	}
+
+	return nil
+}
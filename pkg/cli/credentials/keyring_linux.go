@@ -0,0 +1,78 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// secretTool is the program that manages the Secret Service keyring, such as
+// GNOME Keyring and KWallet
+const secretTool = "secret-tool"
+
+// systemKeyring is the Secret Service keyring
+type systemKeyring struct{}
+
+func (systemKeyring) Get(service, account string) (string, error) {
+	out, code, err := runKeyringProgram("", secretTool, "lookup", "service", service, "account", account)
+	if err != nil {
+		return "", err
+	}
+	// secret-tool exits with 1 and prints nothing if the entry does not exist
+	if code == 1 && out == "" {
+		return "", ErrKeyringNotFound
+	}
+	if code != 0 {
+		return "", errors.Errorf("looking up the secret: %s", out)
+	}
+
+	return out, nil
+}
+
+func (systemKeyring) Set(service, account, secret string) error {
+	label := fmt.Sprintf("%s (%s)", service, account)
+
+	out, code, err := runKeyringProgram(secret, secretTool, "store", "--label", label, "service", service, "account", account)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return errors.Errorf("storing the secret: %s", out)
+	}
+
+	return nil
+}
+
+func (k systemKeyring) Delete(service, account string) error {
+	if _, err := k.Get(service, account); err != nil {
+		return err
+	}
+
+	out, code, err := runKeyringProgram("", secretTool, "clear", "service", service, "account", account)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return errors.Errorf("clearing the secret: %s", out)
+	}
+
+	return nil
+}
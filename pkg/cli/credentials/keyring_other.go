@@ -0,0 +1,44 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+//go:build !linux && !darwin
+
+
+package credentials
+
+import (
+	"github.com/pkg/errors"
+)
+
+// errKeyringUnsupported is an error for a platform without a supported keyring
+var errKeyringUnsupported = errors.New("the keyring is not supported on this platform. Use the file credentials backend")
+
+// systemKeyring is a keyring that is not available
+type systemKeyring struct{}
+
+func (systemKeyring) Get(service, account string) (string, error) {
+	return "", errKeyringUnsupported
+}
+
+func (systemKeyring) Set(service, account, secret string) error {
+	return errKeyringUnsupported
+}
+
+func (systemKeyring) Delete(service, account string) error {
+	return errKeyringUnsupported
+}
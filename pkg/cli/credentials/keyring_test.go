@@ -0,0 +1,130 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+// fakeKeyring is an in-memory keyring
+type fakeKeyring struct {
+	entries map[string]string
+	err     error
+}
+
+func newFakeKeyring() *fakeKeyring {
+	return &fakeKeyring{entries: map[string]string{}}
+}
+
+func (k *fakeKeyring) Get(service, account string) (string, error) {
+	if k.err != nil {
+		return "", k.err
+	}
+
+	secret, ok := k.entries[service+"/"+account]
+	if !ok {
+		return "", ErrKeyringNotFound
+	}
+
+	return secret, nil
+}
+
+func (k *fakeKeyring) Set(service, account, secret string) error {
+	if k.err != nil {
+		return k.err
+	}
+
+	k.entries[service+"/"+account] = secret
+
+	return nil
+}
+
+func (k *fakeKeyring) Delete(service, account string) error {
+	if k.err != nil {
+		return k.err
+	}
+
+	if _, ok := k.entries[service+"/"+account]; !ok {
+		return ErrKeyringNotFound
+	}
+	delete(k.entries, service+"/"+account)
+
+	return nil
+}
+
+func TestKeyringStore(t *testing.T) {
+	kr := newFakeKeyring()
+	s := NewKeyring(kr, "https://api.getdnote.com")
+
+	_, err := s.Get()
+	assert.Equal(t, err, ErrNotFound, "error mismatch before set")
+
+	if err := s.Set("someSessionKey"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the key"))
+	}
+	assert.Equal(t, kr.entries["dnote/https://api.getdnote.com"], "someSessionKey", "entry mismatch")
+
+	got, err := s.Get()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the key"))
+	}
+	assert.Equal(t, got, "someSessionKey", "key mismatch")
+
+	if err := s.Delete(); err != nil {
+		t.Fatal(errors.Wrap(err, "deleting the key"))
+	}
+	_, err = s.Get()
+	assert.Equal(t, err, ErrNotFound, "error mismatch after delete")
+
+	// deleting again is not an error
+	assert.Equal(t, s.Delete(), nil, "error mismatch for deleting a missing key")
+}
+
+func TestKeyringStore_accounts(t *testing.T) {
+	kr := newFakeKeyring()
+	s1 := NewKeyring(kr, "https://api.getdnote.com")
+	s2 := NewKeyring(kr, "https://dnote.example.com/api")
+
+	if err := s1.Set("key1"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the first key"))
+	}
+	if err := s2.Set("key2"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the second key"))
+	}
+
+	got, err := s1.Get()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the first key"))
+	}
+	assert.Equal(t, got, "key1", "first key mismatch")
+}
+
+func TestKeyringStore_error(t *testing.T) {
+	kr := newFakeKeyring()
+	kr.err = errors.New("locked")
+	s := NewKeyring(kr, "https://api.getdnote.com")
+
+	_, err := s.Get()
+	assert.Equal(t, errors.Cause(err), kr.err, "get error mismatch")
+	assert.Equal(t, errors.Cause(s.Set("someSessionKey")), kr.err, "set error mismatch")
+	assert.Equal(t, errors.Cause(s.Delete()), kr.err, "delete error mismatch")
+}
@@ -0,0 +1,56 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+//go:build linux || darwin
+
+
+package credentials
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// runKeyringProgram runs the program that manages the keyring, writing the
+// input to its standard input. It returns what the program printed and its
+// exit code. The error is nil for a non-zero exit code.
+func runKeyringProgram(input, program string, args ...string) (string, int, error) {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return "", 0, errors.Errorf("%s is not found on the PATH. Install it or use the file credentials backend", program)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return strings.TrimSpace(stderr.String()), exitErr.ExitCode(), nil
+		}
+
+		return "", 0, errors.Wrapf(err, "running %s", program)
+	}
+
+	return strings.TrimRight(stdout.String(), "\n"), 0, nil
+}
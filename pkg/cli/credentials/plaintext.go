@@ -0,0 +1,87 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"database/sql"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// plaintextStore keeps the session key unencrypted in the system table of the
+// database, which is where it has always been stored
+type plaintextStore struct {
+	db *database.DB
+}
+
+// NewPlaintext returns a store that keeps the session key in the database
+func NewPlaintext(db *database.DB) Store {
+	return plaintextStore{db: db}
+}
+
+func (s plaintextStore) Get() (string, error) {
+	var key string
+	err := database.GetSystem(s.db, consts.SystemSessionKey, &key)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", errors.Wrap(err, "getting the session key")
+	}
+
+	return key, nil
+}
+
+func (s plaintextStore) Set(key string) error {
+	if err := database.UpsertSystem(s.db, consts.SystemSessionKey, key); err != nil {
+		return errors.Wrap(err, "saving the session key")
+	}
+
+	return nil
+}
+
+// Delete removes the session key and overwrites its content in the database
+// file, so that it cannot be recovered from the free pages or the
+// write-ahead log
+func (s plaintextStore) Delete() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "beginning a transaction")
+	}
+
+	// secure_delete is a setting of the connection, which the transaction holds
+	if _, err := tx.Exec("PRAGMA secure_delete = ON"); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "enabling the secure delete")
+	}
+	if err := database.DeleteSystem(tx, consts.SystemSessionKey); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "deleting the session key")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing the transaction")
+	}
+
+	if _, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return errors.Wrap(err, "checkpointing the write-ahead log")
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func TestPlaintextStore(t *testing.T) {
+	db := database.InitTestDB(t, filepath.Join(t.TempDir(), "dnote.db"), nil)
+	defer database.TeardownTestDB(t, db)
+
+	s := NewPlaintext(db)
+
+	_, err := s.Get()
+	assert.Equal(t, err, ErrNotFound, "error mismatch before set")
+
+	if err := s.Set("someSessionKey"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the key"))
+	}
+
+	var stored string
+	database.MustScan(t, "getting the key", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSessionKey), &stored)
+	assert.Equal(t, stored, "someSessionKey", "stored key mismatch")
+
+	got, err := s.Get()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the key"))
+	}
+	assert.Equal(t, got, "someSessionKey", "key mismatch")
+
+	if err := s.Delete(); err != nil {
+		t.Fatal(errors.Wrap(err, "deleting the key"))
+	}
+	_, err = s.Get()
+	assert.Equal(t, err, ErrNotFound, "error mismatch after delete")
+}
+
+func TestPlaintextStore_shred(t *testing.T) {
+	db := database.InitTestDB(t, filepath.Join(t.TempDir(), "dnote.db"), &database.TestDBOptions{Mode: database.TestDBModeDisk})
+	defer database.TeardownTestDB(t, db)
+
+	s := NewPlaintext(db)
+	if err := s.Set("someSessionKey"); err != nil {
+		t.Fatal(errors.Wrap(err, "setting the key"))
+	}
+	if err := s.Delete(); err != nil {
+		t.Fatal(errors.Wrap(err, "deleting the key"))
+	}
+
+	for _, suffix := range []string{"", "-wal"} {
+		b, err := ioutil.ReadFile(db.Filepath + suffix)
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "reading the database file%s", suffix))
+		}
+
+		assert.Equal(t, bytes.Contains(b, []byte("someSessionKey")), false, "the key remains in the database file"+suffix)
+	}
+}
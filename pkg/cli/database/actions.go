@@ -0,0 +1,221 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The actors of the actions in the journal
+const (
+	// ActorCLI is a command run by the user
+	ActorCLI = "cli-command"
+	// ActorSyncDownload is a sync applying the changes from the remote copy
+	ActorSyncDownload = "sync-download"
+	// ActorSyncClean is a full sync removing the notes and the books that are
+	// not on the remote copy
+	ActorSyncClean = "sync-clean"
+	// ActorRetention is the retention policy of a book removing the notes
+	// that expired under it
+	ActorRetention = "retention"
+)
+
+// The operations of the actions in the journal
+const (
+	OpAddNote     = "add-note"
+	OpEditNote    = "edit-note"
+	OpMoveNote    = "move-note"
+	OpRemoveNote  = "remove-note"
+	OpReorderNote = "reorder-note"
+	OpAddBook     = "add-book"
+	OpRenameBook  = "rename-book"
+	OpRemoveBook  = "remove-book"
+)
+
+// Action is an entry in the journal of the changes to the local notes and
+// books. The journal is local and is never synced. An action must be inserted
+// in the transaction of the change it records, so that it is recorded if and
+// only if the change is made.
+type Action struct {
+	ID        int
+	Timestamp int64
+	Actor     string
+	Operation string
+	NoteUUID  string
+	BookUUID  string
+	Summary   string
+	// Device is the id of the device on which the action was made
+	Device string
+}
+
+// InsertAction appends the given action to the journal
+func InsertAction(db *DB, a Action) error {
+	_, err := db.Exec("INSERT INTO actions (timestamp, actor, operation, note_uuid, book_uuid, summary, device) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		a.Timestamp, a.Actor, a.Operation, a.NoteUUID, a.BookUUID, a.Summary, a.Device)
+	if err != nil {
+		return errors.Wrapf(err, "inserting the action %s", a.Operation)
+	}
+
+	return nil
+}
+
+// InsertActions appends the given actions to the journal using multi-row
+// INSERT statements
+func InsertActions(db *DB, actions []Action) error {
+	columns := []string{"timestamp", "actor", "operation", "note_uuid", "book_uuid", "summary", "device"}
+
+	rows := make([][]interface{}, len(actions))
+	for i, a := range actions {
+		rows[i] = []interface{}{a.Timestamp, a.Actor, a.Operation, a.NoteUUID, a.BookUUID, a.Summary, a.Device}
+	}
+
+	if err := insertBatch(db, "actions", columns, rows); err != nil {
+		return errors.Wrap(err, "inserting actions")
+	}
+
+	return nil
+}
+
+// InsertNoteActions appends a copy of the given action for each note
+// selected by the condition on the notes table, such as before the notes are
+// removed at once
+func InsertNoteActions(db *DB, a Action, cond string, args ...interface{}) error {
+	query := fmt.Sprintf(`INSERT INTO actions (timestamp, actor, operation, note_uuid, book_uuid, summary, device)
+		SELECT ?, ?, ?, uuid, book_uuid, ?, ? FROM notes WHERE %s`, cond)
+
+	if _, err := db.Exec(query, append([]interface{}{a.Timestamp, a.Actor, a.Operation, a.Summary, a.Device}, args...)...); err != nil {
+		return errors.Wrapf(err, "inserting the actions %s", a.Operation)
+	}
+
+	return nil
+}
+
+// InsertBookActions appends a copy of the given action for each book selected
+// by the condition on the books table
+func InsertBookActions(db *DB, a Action, cond string, args ...interface{}) error {
+	query := fmt.Sprintf(`INSERT INTO actions (timestamp, actor, operation, book_uuid, summary, device)
+		SELECT ?, ?, ?, uuid, ?, ? FROM books WHERE %s`, cond)
+
+	if _, err := db.Exec(query, append([]interface{}{a.Timestamp, a.Actor, a.Operation, a.Summary, a.Device}, args...)...); err != nil {
+		return errors.Wrapf(err, "inserting the actions %s", a.Operation)
+	}
+
+	return nil
+}
+
+// ActionFilter selects the actions in the journal
+type ActionFilter struct {
+	// UUID selects the actions on the note or the book with the uuid
+	UUID string
+	// Since selects the actions at or after the timestamp
+	Since int64
+}
+
+// GetActions returns the actions selected by the filter, oldest first
+func GetActions(db *DB, f ActionFilter) ([]Action, error) {
+	conds := []string{"timestamp >= ?"}
+	args := []interface{}{f.Since}
+	if f.UUID != "" {
+		conds = append(conds, "(note_uuid = ? OR book_uuid = ?)")
+		args = append(args, f.UUID, f.UUID)
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT id, timestamp, actor, operation, note_uuid, book_uuid, summary, device
+		FROM actions
+		WHERE %s
+		ORDER BY timestamp ASC, id ASC`, strings.Join(conds, " AND ")), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying actions")
+	}
+	defer rows.Close()
+
+	ret := []Action{}
+	for rows.Next() {
+		var a Action
+		if err := rows.Scan(&a.ID, &a.Timestamp, &a.Actor, &a.Operation, &a.NoteUUID, &a.BookUUID, &a.Summary, &a.Device); err != nil {
+			return nil, errors.Wrap(err, "scanning an action")
+		}
+
+		ret = append(ret, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating actions")
+	}
+
+	return ret, nil
+}
+
+// PruneActions deletes the actions before the given timestamp and returns the
+// number of the deleted actions
+func PruneActions(db *DB, before int64) (int64, error) {
+	res, err := db.Exec("DELETE FROM actions WHERE timestamp < ?", before)
+	if err != nil {
+		return 0, errors.Wrap(err, "deleting actions")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "counting the deleted actions")
+	}
+
+	return n, nil
+}
+
+// FieldChanges summarizes the changes to the fields of a note or a book as
+// "field: old -> new" pairs. A body is summarized by its length so that the
+// journal does not keep a copy of the notes.
+type FieldChanges struct {
+	parts []string
+}
+
+// Field adds the change to the field if the value changed
+func (c *FieldChanges) Field(name, old, new string) {
+	if old == new {
+		return
+	}
+
+	c.parts = append(c.parts, fmt.Sprintf("%s: %q -> %q", name, old, new))
+}
+
+// Body adds the change to the body if it changed
+func (c *FieldChanges) Body(old, new string) {
+	if old == new {
+		return
+	}
+
+	c.parts = append(c.parts, fmt.Sprintf("body: %d -> %d bytes", len(old), len(new)))
+}
+
+// String returns the summary of the changes
+func (c FieldChanges) String() string {
+	return strings.Join(c.parts, ", ")
+}
+
+// NoteEditOperation returns the operation of an edit to a note, which is a
+// move if only its book changed
+func NoteEditOperation(oldBookUUID, newBookUUID, oldBody, newBody string) string {
+	if oldBookUUID != newBookUUID && oldBody == newBody {
+		return OpMoveNote
+	}
+
+	return OpEditNote
+}
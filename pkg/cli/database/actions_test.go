@@ -0,0 +1,199 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func TestInsertActions(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	a1 := Action{Timestamp: 1, Actor: ActorCLI, Operation: OpAddBook, BookUUID: "b1-uuid", Summary: `label: "" -> "js"`}
+	a2 := Action{Timestamp: 2, Actor: ActorCLI, Operation: OpAddNote, NoteUUID: "n1-uuid", BookUUID: "b1-uuid"}
+	a3 := Action{Timestamp: 3, Actor: ActorSyncDownload, Operation: OpEditNote, NoteUUID: "n1-uuid", BookUUID: "b1-uuid"}
+
+	if err := InsertAction(db, a1); err != nil {
+		t.Fatal(errors.Wrap(err, "inserting an action"))
+	}
+	if err := InsertActions(db, []Action{a2, a3}); err != nil {
+		t.Fatal(errors.Wrap(err, "inserting actions"))
+	}
+
+	got, err := GetActions(db, ActionFilter{})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting actions"))
+	}
+
+	a1.ID, a2.ID, a3.ID = 1, 2, 3
+	assert.DeepEqual(t, got, []Action{a1, a2, a3}, "actions mismatch")
+}
+
+func TestInsertNoteActions(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "b2-label")
+	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1)
+	MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, deleted) VALUES (?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", "", 1, true)
+	MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n3-uuid", "b2-uuid", "n3 body", 1)
+
+	a := Action{Timestamp: 10, Actor: ActorSyncClean, Operation: OpRemoveNote, Summary: "not found on the server"}
+	if err := InsertNoteActions(db, a, "book_uuid = ? AND NOT deleted", "b1-uuid"); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	got, err := GetActions(db, ActionFilter{})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting actions"))
+	}
+
+	assert.DeepEqual(t, got, []Action{
+		{ID: 1, Timestamp: 10, Actor: ActorSyncClean, Operation: OpRemoveNote, NoteUUID: "n1-uuid", BookUUID: "b1-uuid", Summary: "not found on the server"},
+	}, "actions mismatch")
+}
+
+func TestInsertBookActions(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, deleted) VALUES (?, ?, ?)", "b2-uuid", "b2-label", true)
+
+	a := Action{Timestamp: 10, Actor: ActorSyncClean, Operation: OpRemoveBook}
+	if err := InsertBookActions(db, a, "NOT deleted"); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	got, err := GetActions(db, ActionFilter{})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting actions"))
+	}
+
+	assert.DeepEqual(t, got, []Action{
+		{ID: 1, Timestamp: 10, Actor: ActorSyncClean, Operation: OpRemoveBook, BookUUID: "b1-uuid"},
+	}, "actions mismatch")
+}
+
+func TestGetActions(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	actions := []Action{
+		{Timestamp: 3, Actor: ActorCLI, Operation: OpEditNote, NoteUUID: "n1-uuid", BookUUID: "b1-uuid"},
+		{Timestamp: 1, Actor: ActorCLI, Operation: OpAddBook, BookUUID: "b1-uuid"},
+		{Timestamp: 2, Actor: ActorCLI, Operation: OpAddNote, NoteUUID: "n2-uuid", BookUUID: "b2-uuid"},
+	}
+	if err := InsertActions(db, actions); err != nil {
+		t.Fatal(errors.Wrap(err, "inserting actions"))
+	}
+
+	testCases := []struct {
+		name     string
+		filter   ActionFilter
+		expected []int
+	}{
+		{
+			name:     "all",
+			filter:   ActionFilter{},
+			expected: []int{2, 3, 1},
+		},
+		{
+			name:     "note uuid",
+			filter:   ActionFilter{UUID: "n1-uuid"},
+			expected: []int{1},
+		},
+		{
+			name:     "book uuid",
+			filter:   ActionFilter{UUID: "b1-uuid"},
+			expected: []int{2, 1},
+		},
+		{
+			name:     "since",
+			filter:   ActionFilter{Since: 2},
+			expected: []int{3, 1},
+		},
+		{
+			name:     "uuid and since",
+			filter:   ActionFilter{UUID: "b1-uuid", Since: 2},
+			expected: []int{1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := GetActions(db, tc.filter)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			ids := []int{}
+			for _, a := range got {
+				ids = append(ids, a.ID)
+			}
+			assert.DeepEqual(t, ids, tc.expected, "ids mismatch")
+		})
+	}
+}
+
+func TestPruneActions(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	actions := []Action{
+		{Timestamp: 1, Actor: ActorCLI, Operation: OpAddBook},
+		{Timestamp: 2, Actor: ActorCLI, Operation: OpAddNote},
+		{Timestamp: 3, Actor: ActorCLI, Operation: OpEditNote},
+	}
+	if err := InsertActions(db, actions); err != nil {
+		t.Fatal(errors.Wrap(err, "inserting actions"))
+	}
+
+	n, err := PruneActions(db, 3)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, n, int64(2), "deleted count mismatch")
+
+	var count int
+	MustScan(t, "counting actions", db.QueryRow("SELECT count(*) FROM actions"), &count)
+	assert.Equal(t, count, 1, "action count mismatch")
+}
+
+func TestFieldChanges(t *testing.T) {
+	var c FieldChanges
+	c.Field("book", "js", "js")
+	c.Field("book", "js", "css")
+	c.Body("foo", "foo")
+	c.Body("foo", "foo bar")
+
+	assert.Equal(t, c.String(), `book: "js" -> "css", body: 3 -> 7 bytes`, "summary mismatch")
+}
+
+func TestNoteEditOperation(t *testing.T) {
+	assert.Equal(t, NoteEditOperation("b1-uuid", "b2-uuid", "foo", "foo"), OpMoveNote, "move mismatch")
+	assert.Equal(t, NoteEditOperation("b1-uuid", "b2-uuid", "foo", "bar"), OpEditNote, "move and edit mismatch")
+	assert.Equal(t, NoteEditOperation("b1-uuid", "b1-uuid", "foo", "bar"), OpEditNote, "edit mismatch")
+}
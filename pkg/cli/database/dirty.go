@@ -0,0 +1,80 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ChangeCounts are the numbers of the records changed locally and not sent to
+// the server yet
+type ChangeCounts struct {
+	// Created is the number of the records that the server does not have
+	Created int `json:"created"`
+	// Updated is the number of the records that were changed
+	Updated int `json:"updated"`
+	// Deleted is the number of the records that were removed
+	Deleted int `json:"deleted"`
+}
+
+// Total returns the number of all the changed records
+func (c ChangeCounts) Total() int {
+	return c.Created + c.Updated + c.Deleted
+}
+
+// DirtyCounts are the numbers of the notes and the books changed locally. The
+// sync report and the status command both count the changes with it, so that
+// they agree.
+type DirtyCounts struct {
+	Notes ChangeCounts `json:"notes"`
+	Books ChangeCounts `json:"books"`
+}
+
+func countDirty(db *DB, table string) (ChangeCounts, error) {
+	var ret ChangeCounts
+
+	query := fmt.Sprintf(`SELECT
+		COALESCE(SUM(usn = 0 AND NOT deleted), 0),
+		COALESCE(SUM(usn > 0 AND NOT deleted), 0),
+		COALESCE(SUM(deleted), 0)
+		FROM %s WHERE dirty`, table)
+	if err := db.QueryRow(query).Scan(&ret.Created, &ret.Updated, &ret.Deleted); err != nil {
+		return ret, errors.Wrapf(err, "counting dirty %s", table)
+	}
+
+	return ret, nil
+}
+
+// CountDirty returns the numbers of the notes and the books changed locally
+// since the last sync
+func CountDirty(db *DB) (DirtyCounts, error) {
+	var ret DirtyCounts
+	var err error
+
+	if ret.Notes, err = countDirty(db, "notes"); err != nil {
+		return ret, err
+	}
+	if ret.Books, err = countDirty(db, "books"); err != nil {
+		return ret, err
+	}
+
+	return ret, nil
+}
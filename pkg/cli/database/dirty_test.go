@@ -0,0 +1,81 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func TestCountDirty(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 0, true, false)
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b2-uuid", "b2-label", 2, true, false)
+	MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b3-uuid", "b3-label", 3, false, false)
+	MustExec(t, "inserting b4", db, "INSERT INTO books (uuid, label, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b4-uuid", "", 4, true, true)
+
+	notes := []struct {
+		uuid    string
+		usn     int
+		dirty   bool
+		deleted bool
+	}{
+		{"n1-uuid", 0, true, false},
+		{"n2-uuid", 0, true, false},
+		{"n3-uuid", 3, true, false},
+		{"n4-uuid", 4, true, true},
+		{"n5-uuid", 5, true, true},
+		{"n6-uuid", 6, true, true},
+		{"n7-uuid", 7, false, false},
+		{"n8-uuid", 8, false, true},
+	}
+	for _, n := range notes {
+		MustExec(t, "inserting "+n.uuid, db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?, ?, ?)", n.uuid, "b1-uuid", "", 1541108743, n.usn, n.dirty, n.deleted)
+	}
+
+	// execute
+	got, err := CountDirty(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	assert.Equal(t, got, DirtyCounts{
+		Notes: ChangeCounts{Created: 2, Updated: 1, Deleted: 3},
+		Books: ChangeCounts{Created: 1, Updated: 1, Deleted: 1},
+	}, "counts mismatch")
+	assert.Equal(t, got.Notes.Total(), 6, "note total mismatch")
+}
+
+func TestCountDirty_empty(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	got, err := CountDirty(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, got, DirtyCounts{}, "counts mismatch")
+}
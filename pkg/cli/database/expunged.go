@@ -0,0 +1,161 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// ExpungedNote is a note that a sync removed because it was removed on another
+// device, kept in the archive of the expunged notes. The archive is local and
+// is never synced.
+type ExpungedNote struct {
+	ID        int
+	NoteUUID  string
+	BookLabel string
+	Body      string
+	AddedOn   int64
+	EditedOn  int64
+	// ExpungedAt is the time of the sync that removed the note
+	ExpungedAt int64
+}
+
+// ArchiveNotes copies the notes selected by the given condition on the notes
+// table into the archive of the expunged notes, as expunged at the given time
+// in nanoseconds. The removed notes are skipped, because their bodies are
+// already gone. It should be called before the notes are deleted.
+func ArchiveNotes(db *DB, expungedAt int64, cond string, args ...interface{}) error {
+	query := `INSERT INTO expunged_archive (note_uuid, book_label, body, added_on, edited_on, expunged_at)
+		SELECT notes.uuid, COALESCE(books.label, ''), notes.body, notes.added_on, COALESCE(notes.edited_on, 0), ?
+		FROM notes LEFT JOIN books ON books.uuid = notes.book_uuid
+		WHERE NOT notes.deleted AND (` + cond + `)`
+
+	if _, err := db.Exec(query, append([]interface{}{expungedAt}, args...)...); err != nil {
+		return errors.Wrap(err, "archiving the notes")
+	}
+
+	return nil
+}
+
+// CapExpungedNotes removes the archived notes expunged before the given time in
+// nanoseconds, and then the oldest ones until their bodies take at most maxSize
+// bytes. It returns the number of the removed notes.
+func CapExpungedNotes(db *DB, before int64, maxSize int64) (int64, error) {
+	res, err := db.Exec("DELETE FROM expunged_archive WHERE expunged_at < ?", before)
+	if err != nil {
+		return 0, errors.Wrap(err, "removing the expired notes")
+	}
+	ret, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "counting the expired notes")
+	}
+
+	// find the newest note that does not fit, from which on every older one
+	// is removed
+	rows, err := db.Query("SELECT id, length(CAST(body AS BLOB)) FROM expunged_archive ORDER BY id DESC")
+	if err != nil {
+		return ret, errors.Wrap(err, "querying the sizes of the notes")
+	}
+	defer rows.Close()
+
+	var total int64
+	cutoff := 0
+	for rows.Next() {
+		var id int
+		var size int64
+		if err := rows.Scan(&id, &size); err != nil {
+			return ret, errors.Wrap(err, "scanning the size of a note")
+		}
+
+		total += size
+		if total > maxSize {
+			cutoff = id
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ret, errors.Wrap(err, "iterating the sizes of the notes")
+	}
+	rows.Close()
+
+	if cutoff == 0 {
+		return ret, nil
+	}
+
+	res, err = db.Exec("DELETE FROM expunged_archive WHERE id <= ?", cutoff)
+	if err != nil {
+		return ret, errors.Wrap(err, "removing the oldest notes")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return ret, errors.Wrap(err, "counting the oldest notes")
+	}
+
+	return ret + n, nil
+}
+
+// GetExpungedNotes returns the archived notes, the most recently expunged
+// first
+func GetExpungedNotes(db *DB) ([]ExpungedNote, error) {
+	rows, err := db.Query(`SELECT id, note_uuid, book_label, body, added_on, edited_on, expunged_at
+		FROM expunged_archive ORDER BY id DESC`)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the expunged notes")
+	}
+	defer rows.Close()
+
+	ret := []ExpungedNote{}
+	for rows.Next() {
+		var n ExpungedNote
+		if err := rows.Scan(&n.ID, &n.NoteUUID, &n.BookLabel, &n.Body, &n.AddedOn, &n.EditedOn, &n.ExpungedAt); err != nil {
+			return nil, errors.Wrap(err, "scanning an expunged note")
+		}
+
+		ret = append(ret, n)
+	}
+
+	return ret, rows.Err()
+}
+
+// GetExpungedNote returns the archived note with the given id
+func GetExpungedNote(db *DB, id int) (ExpungedNote, error) {
+	var ret ExpungedNote
+
+	err := db.QueryRow(`SELECT id, note_uuid, book_label, body, added_on, edited_on, expunged_at
+		FROM expunged_archive WHERE id = ?`, id).
+		Scan(&ret.ID, &ret.NoteUUID, &ret.BookLabel, &ret.Body, &ret.AddedOn, &ret.EditedOn, &ret.ExpungedAt)
+	if err == sql.ErrNoRows {
+		return ret, errors.Errorf("expunged note %d not found", id)
+	} else if err != nil {
+		return ret, errors.Wrap(err, "querying the expunged note")
+	}
+
+	return ret, nil
+}
+
+// DeleteExpungedNote removes the archived note with the given id
+func DeleteExpungedNote(db *DB, id int) error {
+	if _, err := db.Exec("DELETE FROM expunged_archive WHERE id = ?", id); err != nil {
+		return errors.Wrap(err, "removing the expunged note")
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func getExpungedUUIDs(t *testing.T, db *DB) []string {
+	notes, err := GetExpungedNotes(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the expunged notes"))
+	}
+
+	ret := []string{}
+	for _, n := range notes {
+		ret = append(ret, n.NoteUUID)
+	}
+
+	return ret
+}
+
+func TestArchiveNotes(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743, 1541108744)
+	MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n2-uuid", "b1-uuid", "n2 body", 1541108745)
+	MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, deleted) VALUES (?, ?, ?, ?, ?)", "n3-uuid", "b1-uuid", "", 1541108746, true)
+
+	if err := ArchiveNotes(db, 2000, "notes.uuid = ?", "n1-uuid"); err != nil {
+		t.Fatal(errors.Wrap(err, "archiving n1"))
+	}
+	if err := ArchiveNotes(db, 3000, "notes.book_uuid = ?", "b1-uuid"); err != nil {
+		t.Fatal(errors.Wrap(err, "archiving b1"))
+	}
+
+	notes, err := GetExpungedNotes(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the expunged notes"))
+	}
+
+	// the removed note is skipped
+	assert.Equal(t, len(notes), 3, "note count mismatch")
+	assert.Equal(t, notes[0].NoteUUID, "n2-uuid", "note 0 uuid mismatch")
+	assert.Equal(t, notes[1].NoteUUID, "n1-uuid", "note 1 uuid mismatch")
+	assert.Equal(t, notes[2].NoteUUID, "n1-uuid", "note 2 uuid mismatch")
+
+	got, err := GetExpungedNote(db, notes[2].ID)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the expunged note"))
+	}
+	assert.DeepEqual(t, got, ExpungedNote{
+		ID:         notes[2].ID,
+		NoteUUID:   "n1-uuid",
+		BookLabel:  "js",
+		Body:       "n1 body",
+		AddedOn:    1541108743,
+		EditedOn:   1541108744,
+		ExpungedAt: 2000,
+	}, "note mismatch")
+
+	if err := DeleteExpungedNote(db, notes[2].ID); err != nil {
+		t.Fatal(errors.Wrap(err, "deleting the expunged note"))
+	}
+	if _, err := GetExpungedNote(db, notes[2].ID); err == nil {
+		t.Error("expected an error for a deleted note")
+	}
+}
+
+func TestCapExpungedNotes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		before   int64
+		maxSize  int64
+		expected []string
+	}{
+		{
+			name:     "within the limits",
+			before:   1000,
+			maxSize:  100,
+			expected: []string{"n4-uuid", "n3-uuid", "n2-uuid", "n1-uuid"},
+		},
+		{
+			name:     "age",
+			before:   2500,
+			maxSize:  100,
+			expected: []string{"n4-uuid", "n3-uuid"},
+		},
+		{
+			name:     "size",
+			before:   1000,
+			maxSize:  20,
+			expected: []string{"n4-uuid", "n3-uuid"},
+		},
+		{
+			name:     "size of the newest note",
+			before:   1000,
+			maxSize:  5,
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+			defer TeardownTestDB(t, db)
+
+			// every body takes 10 bytes
+			for i := 1; i <= 4; i++ {
+				MustExec(t, "archiving a note", db, "INSERT INTO expunged_archive (note_uuid, book_label, body, added_on, expunged_at) VALUES (?, ?, ?, ?, ?)",
+					fmt.Sprintf("n%d-uuid", i), "js", fmt.Sprintf("n%d body 10", i), 1, i*1000)
+			}
+
+			n, err := CapExpungedNotes(db, tc.before, tc.maxSize)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.Equal(t, n, int64(4-len(tc.expected)), "removed count mismatch")
+			assert.DeepEqual(t, getExpungedUUIDs(t, db), tc.expected, "notes mismatch")
+		})
+	}
+}
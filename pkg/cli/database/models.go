@@ -19,9 +19,17 @@
 package database
 
 import (
+	"database/sql"
+	"fmt"
+	"strings"
+
 	"github.com/pkg/errors"
 )
 
+// insertBatchParams is the maximum number of parameters bound to a single
+// multi-row INSERT statement
+const insertBatchParams = 500
+
 // Book holds a metadata and its notes
 type Book struct {
 	UUID    string `json:"uuid"`
@@ -44,6 +52,18 @@ type Note struct {
 	Public   bool   `json:"public"`
 	Deleted  bool   `json:"deleted"`
 	Dirty    bool   `json:"dirty"`
+	// OriginDevice is the id of the device on which the note was added or
+	// last edited locally. It is local metadata that is never synced, and is
+	// empty for the notes that have only been changed elsewhere.
+	OriginDevice string `json:"-"`
+	// Position orders the note in its book. It is local metadata that is
+	// never synced.
+	Position float64 `json:"-"`
+	// ViewCount is the number of times the note was viewed on this device
+	ViewCount int `json:"-"`
+	// LastViewedAt is when the note was last viewed on this device, or zero
+	// if it has never been viewed
+	LastViewedAt int64 `json:"-"`
 }
 
 // NewNote constructs a note with the given data
@@ -63,8 +83,8 @@ func NewNote(uuid, bookUUID, body string, addedOn, editedOn int64, usn int, publ
 
 // Insert inserts a new note
 func (n Note) Insert(db *DB) error {
-	_, err := db.Exec("INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		n.UUID, n.BookUUID, n.Body, n.AddedOn, n.EditedOn, n.USN, n.Public, n.Deleted, n.Dirty)
+	_, err := db.Exec("INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty, origin_device, position, view_count, last_viewed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		n.UUID, n.BookUUID, n.Body, n.AddedOn, n.EditedOn, n.USN, n.Public, n.Deleted, n.Dirty, n.OriginDevice, n.Position, n.ViewCount, n.LastViewedAt)
 
 	if err != nil {
 		return errors.Wrapf(err, "inserting note with uuid %s", n.UUID)
@@ -73,7 +93,9 @@ func (n Note) Insert(db *DB) error {
 	return nil
 }
 
-// Update updates the note with the given data
+// Update updates the note with the given data. The origin device, the
+// position and the view stats are left as they are, so that a sync updating
+// the note does not lose them.
 func (n Note) Update(db *DB) error {
 	_, err := db.Exec("UPDATE notes SET book_uuid = ?, body = ?, added_on = ?, edited_on = ?, usn = ?, public = ?, deleted = ?, dirty = ? WHERE uuid = ?",
 		n.BookUUID, n.Body, n.AddedOn, n.EditedOn, n.USN, n.Public, n.Deleted, n.Dirty, n.UUID)
@@ -108,6 +130,23 @@ func (n Note) Expunge(db *DB) error {
 	return nil
 }
 
+// InsertNotes inserts the given notes using multi-row INSERT statements, which is
+// much faster than inserting them one by one
+func InsertNotes(db *DB, notes []Note) error {
+	columns := []string{"uuid", "book_uuid", "body", "added_on", "edited_on", "usn", "public", "deleted", "dirty", "origin_device", "position", "view_count", "last_viewed_at"}
+
+	rows := make([][]interface{}, len(notes))
+	for i, n := range notes {
+		rows[i] = []interface{}{n.UUID, n.BookUUID, n.Body, n.AddedOn, n.EditedOn, n.USN, n.Public, n.Deleted, n.Dirty, n.OriginDevice, n.Position, n.ViewCount, n.LastViewedAt}
+	}
+
+	if err := insertBatch(db, "notes", columns, rows); err != nil {
+		return errors.Wrap(err, "inserting notes")
+	}
+
+	return nil
+}
+
 // NewBook constructs a book with the given data
 func NewBook(uuid, label string, usn int, deleted, dirty bool) Book {
 	return Book{
@@ -165,3 +204,85 @@ func (b Book) Expunge(db *DB) error {
 
 	return nil
 }
+
+// InsertBooks inserts the given books using multi-row INSERT statements
+func InsertBooks(db *DB, books []Book) error {
+	columns := []string{"uuid", "label", "usn", "dirty", "deleted"}
+
+	rows := make([][]interface{}, len(books))
+	for i, b := range books {
+		rows[i] = []interface{}{b.UUID, b.Label, b.USN, b.Dirty, b.Deleted}
+	}
+
+	if err := insertBatch(db, "books", columns, rows); err != nil {
+		return errors.Wrap(err, "inserting books")
+	}
+
+	return nil
+}
+
+// InsertUUIDs inserts the given uuids into the uuid column of the table using
+// multi-row INSERT statements
+func InsertUUIDs(db *DB, table string, uuids []string) error {
+	rows := make([][]interface{}, len(uuids))
+	for i, uuid := range uuids {
+		rows[i] = []interface{}{uuid}
+	}
+
+	if err := insertBatch(db, table, []string{"uuid"}, rows); err != nil {
+		return errors.Wrapf(err, "inserting into %s", table)
+	}
+
+	return nil
+}
+
+// insertBatch inserts the rows into the table in chunks, binding at most
+// insertBatchParams parameters to each statement. The statement for a full
+// chunk is prepared once and reused for every full chunk.
+func insertBatch(db *DB, table string, columns []string, rows [][]interface{}) error {
+	rowsPerStmt := insertBatchParams / len(columns)
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+
+	getQuery := func(n int) string {
+		placeholders := strings.TrimSuffix(strings.Repeat(placeholder+", ", n), ", ")
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(columns, ", "), placeholders)
+	}
+
+	var fullStmt *sql.Stmt
+	defer func() {
+		if fullStmt != nil {
+			fullStmt.Close()
+		}
+	}()
+
+	args := make([]interface{}, 0, rowsPerStmt*len(columns))
+	for start := 0; start < len(rows); start += rowsPerStmt {
+		end := start + rowsPerStmt
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		args = args[:0]
+		for _, row := range rows[start:end] {
+			args = append(args, row...)
+		}
+
+		var err error
+		if end-start == rowsPerStmt {
+			if fullStmt == nil {
+				if fullStmt, err = db.Prepare(getQuery(rowsPerStmt)); err != nil {
+					return errors.Wrap(err, "preparing statement")
+				}
+			}
+
+			_, err = fullStmt.Exec(args...)
+		} else {
+			_, err = db.Exec(getQuery(end-start), args...)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "inserting rows %d to %d", start, end)
+		}
+	}
+
+	return nil
+}
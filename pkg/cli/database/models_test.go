@@ -288,6 +288,9 @@ func TestNoteUpdate(t *testing.T) {
 				Dirty:    false,
 			}
 
+			MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+			MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "b2-label")
+			MustExec(t, "inserting b10", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b10-uuid", "b10-label")
 			MustExec(t, fmt.Sprintf("inserting n1 for test case %d", idx), db, "INSERT INTO notes (uuid, book_uuid, usn, added_on, edited_on, body, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", n1.UUID, n1.BookUUID, n1.USN, n1.AddedOn, n1.EditedOn, n1.Body, n1.Public, n1.Deleted, n1.Dirty)
 			MustExec(t, fmt.Sprintf("inserting n2 for test case %d", idx), db, "INSERT INTO notes (uuid, book_uuid, usn, added_on, edited_on, body, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", n2.UUID, n2.BookUUID, n2.USN, n2.AddedOn, n2.EditedOn, n2.Body, n2.Public, n2.Deleted, n2.Dirty)
 
@@ -381,6 +384,7 @@ func TestNoteUpdateUUID(t *testing.T) {
 				Dirty:    false,
 			}
 
+			MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
 			MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", n1.UUID, n1.BookUUID, n1.Body, n1.AddedOn, n1.USN, n1.Deleted, n1.Dirty)
 			MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", n2.UUID, n2.BookUUID, n2.Body, n2.AddedOn, n2.USN, n2.Deleted, n2.Dirty)
 
@@ -440,6 +444,8 @@ func TestNoteExpunge(t *testing.T) {
 		Dirty:    false,
 	}
 
+	MustExec(t, "inserting b9", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b9-uuid", "b9-label")
+	MustExec(t, "inserting b10", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b10-uuid", "b10-label")
 	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, added_on, edited_on, body, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", n1.UUID, n1.BookUUID, n1.USN, n1.AddedOn, n1.EditedOn, n1.Body, n1.Public, n1.Deleted, n1.Dirty)
 	MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, added_on, edited_on, body, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", n2.UUID, n2.BookUUID, n2.USN, n2.AddedOn, n2.EditedOn, n2.Body, n2.Public, n2.Deleted, n2.Dirty)
 
@@ -478,6 +484,61 @@ func TestNoteExpunge(t *testing.T) {
 	assert.Equal(t, n2Record.Dirty, n2.Dirty, "n2 dirty mismatch")
 }
 
+func TestInsertNotes(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+
+	// more notes than fit in a single statement
+	var notes []Note
+	for i := 0; i < 120; i++ {
+		notes = append(notes, NewNote(fmt.Sprintf("n%d-uuid", i), "b1-uuid", fmt.Sprintf("n%d body", i), 1542058875, int64(i), i+1, i%2 == 0, false, i%3 == 0))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+	if err := InsertNotes(tx, notes); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+	tx.Commit()
+
+	var count int
+	MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &count)
+	assert.Equal(t, count, 120, "note count mismatch")
+
+	for _, idx := range []int{0, 55, 119} {
+		want := notes[idx]
+
+		var got Note
+		MustScan(t, fmt.Sprintf("getting note %d", idx),
+			db.QueryRow("SELECT uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty FROM notes WHERE uuid = ?", want.UUID),
+			&got.UUID, &got.BookUUID, &got.Body, &got.AddedOn, &got.EditedOn, &got.USN, &got.Public, &got.Deleted, &got.Dirty)
+
+		assert.DeepEqual(t, got, want, fmt.Sprintf("note %d mismatch", idx))
+	}
+
+	var ftsCount int
+	MustScan(t, "counting fts rows", db.QueryRow("SELECT count(*) FROM note_fts WHERE note_fts MATCH ?", "body"), &ftsCount)
+	assert.Equal(t, ftsCount, 120, "fts count mismatch")
+}
+
+func TestInsertNotes_empty(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	if err := InsertNotes(db, nil); err != nil {
+		t.Fatal(errors.Wrap(err, "executing").Error())
+	}
+
+	var count int
+	MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &count)
+	assert.Equal(t, count, 0, "note count mismatch")
+}
+
 func TestNewBook(t *testing.T) {
 	testCases := []struct {
 		uuid    string
@@ -804,11 +865,65 @@ func TestBookExpunge(t *testing.T) {
 }
 
 // TestNoteFTS tests that note full text search indices stay in sync with the notes after insert, update and delete
+func TestInsertBooks(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	var books []Book
+	for i := 0; i < 150; i++ {
+		books = append(books, NewBook(fmt.Sprintf("b%d-uuid", i), fmt.Sprintf("b%d-label", i), i+1, false, i%2 == 0))
+	}
+
+	if err := InsertBooks(db, books); err != nil {
+		t.Fatal(errors.Wrap(err, "executing").Error())
+	}
+
+	var count int
+	MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &count)
+	assert.Equal(t, count, 150, "book count mismatch")
+
+	for _, idx := range []int{0, 100, 149} {
+		want := books[idx]
+
+		var got Book
+		MustScan(t, fmt.Sprintf("getting book %d", idx),
+			db.QueryRow("SELECT uuid, label, usn, deleted, dirty FROM books WHERE uuid = ?", want.UUID),
+			&got.UUID, &got.Label, &got.USN, &got.Deleted, &got.Dirty)
+
+		assert.DeepEqual(t, got, want, fmt.Sprintf("book %d mismatch", idx))
+	}
+}
+
+func TestInsertUUIDs(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "creating a temporary table", db, "CREATE TEMP TABLE uuids (uuid text PRIMARY KEY)")
+
+	var uuids []string
+	for i := 0; i < 1200; i++ {
+		uuids = append(uuids, fmt.Sprintf("n%d-uuid", i))
+	}
+
+	if err := InsertUUIDs(db, "temp.uuids", uuids); err != nil {
+		t.Fatal(errors.Wrap(err, "executing").Error())
+	}
+
+	var count int
+	MustScan(t, "counting uuids", db.QueryRow("SELECT count(*) FROM temp.uuids"), &count)
+	assert.Equal(t, count, 1200, "uuid count mismatch")
+
+	var last string
+	MustScan(t, "getting the last uuid", db.QueryRow("SELECT uuid FROM temp.uuids WHERE uuid = ?", "n1199-uuid"), &last)
+	assert.Equal(t, last, "n1199-uuid", "last uuid mismatch")
+}
+
 func TestNoteFTS(t *testing.T) {
 	// set up
 	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
 	defer TeardownTestDB(t, db)
 
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
 	// execute - insert
 	n := Note{
 		UUID:     "n1-uuid",
@@ -0,0 +1,177 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"github.com/pkg/errors"
+)
+
+// minPositionGap is the smallest gap between the positions of two adjacent
+// notes. A note is placed at the midpoint of its new neighbors, so repeatedly
+// moving notes into the same gap halves it, and the positions of the book are
+// renormalized once the gap gets smaller than this.
+const minPositionGap = 1e-9
+
+// nextPositionSQL is the position after the last note in a book, which is 1
+// for an empty book
+const nextPositionSQL = "(SELECT COALESCE(MAX(position), 0) + 1 FROM notes WHERE book_uuid = ?)"
+
+// NextNotePosition returns the position that places a note after all notes
+// in the given book
+func NextNotePosition(db *DB, bookUUID string) (float64, error) {
+	var ret float64
+	if err := db.QueryRow("SELECT "+nextPositionSQL, bookUUID).Scan(&ret); err != nil {
+		return 0, errors.Wrap(err, "finding the last position")
+	}
+
+	return ret, nil
+}
+
+// notePosition is the position of a note in its book
+type notePosition struct {
+	rowID    int
+	position float64
+}
+
+// getNotePositions returns the positions of the notes in the given book that
+// are not deleted, in their order
+func getNotePositions(db *DB, bookUUID string) ([]notePosition, error) {
+	rows, err := db.Query(`SELECT rowid, position FROM notes
+		WHERE book_uuid = ? AND deleted = false
+		ORDER BY position ASC, added_on ASC, rowid ASC`, bookUUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the positions")
+	}
+	defer rows.Close()
+
+	ret := []notePosition{}
+	for rows.Next() {
+		var p notePosition
+		if err := rows.Scan(&p.rowID, &p.position); err != nil {
+			return nil, errors.Wrap(err, "scanning a position")
+		}
+
+		ret = append(ret, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating positions")
+	}
+
+	return ret, nil
+}
+
+// ReorderNote moves the note with the given rowid right before or after the
+// target note, which must be in the same book, and returns its new position.
+// Only the moved note changes its position unless there is no room left
+// between its new neighbors, in which case the positions of all notes in the
+// book are renormalized to 1, 2, 3 and so on. The position is local and is not
+// synced, so the notes are not marked dirty.
+func ReorderNote(db *DB, rowID, targetRowID int, after bool) (float64, error) {
+	if rowID == targetRowID {
+		return 0, errors.New("cannot move a note relative to itself")
+	}
+
+	var bookUUID, targetBookUUID string
+	if err := db.QueryRow("SELECT book_uuid FROM notes WHERE rowid = ?", rowID).Scan(&bookUUID); err != nil {
+		return 0, errors.Wrap(err, "finding the book of the note")
+	}
+	if err := db.QueryRow("SELECT book_uuid FROM notes WHERE rowid = ?", targetRowID).Scan(&targetBookUUID); err != nil {
+		return 0, errors.Wrap(err, "finding the book of the target note")
+	}
+	if bookUUID != targetBookUUID {
+		return 0, errors.New("the notes are in different books")
+	}
+
+	positions, err := getNotePositions(db, bookUUID)
+	if err != nil {
+		return 0, err
+	}
+
+	// the order of the other notes, into which the note is inserted
+	others := make([]notePosition, 0, len(positions))
+	for _, p := range positions {
+		if p.rowID != rowID {
+			others = append(others, p)
+		}
+	}
+
+	idx := -1
+	for i, p := range others {
+		if p.rowID == targetRowID {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return 0, errors.New("the target note is removed")
+	}
+	if after {
+		idx++
+	}
+
+	var position float64
+	ok := true
+	switch {
+	case idx == 0:
+		position = others[0].position - 1
+	case idx == len(others):
+		position = others[idx-1].position + 1
+	default:
+		prev, next := others[idx-1].position, others[idx].position
+		position = prev + (next-prev)/2
+		ok = next-prev >= minPositionGap && position > prev && position < next
+	}
+
+	if !ok {
+		order := make([]notePosition, 0, len(others)+1)
+		order = append(order, others[:idx]...)
+		order = append(order, notePosition{rowID: rowID})
+		order = append(order, others[idx:]...)
+
+		if err := renormalizePositions(db, order); err != nil {
+			return 0, errors.Wrap(err, "renormalizing the positions")
+		}
+
+		return float64(idx + 1), nil
+	}
+
+	if _, err := db.Exec("UPDATE notes SET position = ? WHERE rowid = ?", position, rowID); err != nil {
+		return 0, errors.Wrap(err, "updating the position")
+	}
+
+	return position, nil
+}
+
+// renormalizePositions assigns 1, 2, 3 and so on to the positions of the
+// notes in the given order
+func renormalizePositions(db *DB, order []notePosition) error {
+	stmt, err := db.Prepare("UPDATE notes SET position = ? WHERE rowid = ?")
+	if err != nil {
+		return errors.Wrap(err, "preparing the statement")
+	}
+	defer stmt.Close()
+
+	for i, p := range order {
+		if _, err := stmt.Exec(float64(i+1), p.rowID); err != nil {
+			return errors.Wrapf(err, "updating the position of the note %d", p.rowID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+// setupPositions inserts the notes n1 to n4 in the book b1 at the positions 1
+// to 4, a removed note in between, and a note in another book. It returns the
+// rowids of the notes by uuid.
+func setupPositions(t *testing.T, db *DB) map[string]int {
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "css")
+
+	notes := []struct {
+		uuid     string
+		bookUUID string
+		position float64
+		deleted  bool
+	}{
+		{"n1-uuid", "b1-uuid", 1, false},
+		{"n2-uuid", "b1-uuid", 2, false},
+		{"n3-uuid", "b1-uuid", 3, false},
+		{"n4-uuid", "b1-uuid", 4, false},
+		{"n5-uuid", "b1-uuid", 2.5, true},
+		{"n6-uuid", "b2-uuid", 1, false},
+	}
+
+	ret := map[string]int{}
+	for _, n := range notes {
+		MustExec(t, "inserting "+n.uuid, db, "INSERT INTO notes (uuid, book_uuid, body, added_on, position, deleted) VALUES (?, ?, ?, ?, ?, ?)", n.uuid, n.bookUUID, "", 1, n.position, n.deleted)
+
+		var rowID int
+		MustScan(t, "getting the rowid of "+n.uuid, db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", n.uuid), &rowID)
+		ret[n.uuid] = rowID
+	}
+
+	return ret
+}
+
+// getOrder returns the uuids of the notes in the book that are not deleted,
+// in their order
+func getOrder(t *testing.T, db *DB, bookUUID string) []string {
+	rows, err := db.Query("SELECT uuid FROM notes WHERE book_uuid = ? AND deleted = false ORDER BY position ASC, added_on ASC, rowid ASC", bookUUID)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "querying the order"))
+	}
+	defer rows.Close()
+
+	ret := []string{}
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			t.Fatal(errors.Wrap(err, "scanning a uuid"))
+		}
+
+		ret = append(ret, uuid)
+	}
+
+	return ret
+}
+
+func TestNextNotePosition(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	setupPositions(t, db)
+
+	got, err := NextNotePosition(db, "b1-uuid")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+	assert.Equal(t, got, float64(5), "position mismatch for b1")
+
+	got, err = NextNotePosition(db, "b3-uuid")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+	assert.Equal(t, got, float64(1), "position mismatch for an empty book")
+}
+
+func TestReorderNote(t *testing.T) {
+	testCases := []struct {
+		note     string
+		target   string
+		after    bool
+		position float64
+		order    []string
+	}{
+		{
+			note:     "n4-uuid",
+			target:   "n2-uuid",
+			after:    false,
+			position: 1.5,
+			order:    []string{"n1-uuid", "n4-uuid", "n2-uuid", "n3-uuid"},
+		},
+		{
+			note:     "n1-uuid",
+			target:   "n2-uuid",
+			after:    true,
+			position: 2.5,
+			order:    []string{"n2-uuid", "n1-uuid", "n3-uuid", "n4-uuid"},
+		},
+		{
+			note:     "n3-uuid",
+			target:   "n1-uuid",
+			after:    false,
+			position: 0,
+			order:    []string{"n3-uuid", "n1-uuid", "n2-uuid", "n4-uuid"},
+		},
+		{
+			note:     "n1-uuid",
+			target:   "n4-uuid",
+			after:    true,
+			position: 5,
+			order:    []string{"n2-uuid", "n3-uuid", "n4-uuid", "n1-uuid"},
+		},
+		{
+			// already in place
+			note:     "n2-uuid",
+			target:   "n3-uuid",
+			after:    false,
+			position: 2,
+			order:    []string{"n1-uuid", "n2-uuid", "n3-uuid", "n4-uuid"},
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+			defer TeardownTestDB(t, db)
+
+			rowIDs := setupPositions(t, db)
+
+			got, err := ReorderNote(db, rowIDs[tc.note], rowIDs[tc.target], tc.after)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.Equal(t, got, tc.position, "position mismatch")
+			assert.DeepEqual(t, getOrder(t, db, "b1-uuid"), tc.order, "order mismatch")
+
+			var dirty bool
+			MustScan(t, "getting the note", db.QueryRow("SELECT dirty FROM notes WHERE uuid = ?", tc.note), &dirty)
+			assert.Equal(t, dirty, false, "dirty mismatch")
+		})
+	}
+}
+
+func TestReorderNote_invalid(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	rowIDs := setupPositions(t, db)
+
+	if _, err := ReorderNote(db, rowIDs["n1-uuid"], rowIDs["n6-uuid"], false); err == nil {
+		t.Error("expected an error for a note in another book")
+	}
+	if _, err := ReorderNote(db, rowIDs["n1-uuid"], rowIDs["n1-uuid"], false); err == nil {
+		t.Error("expected an error for the same note")
+	}
+	if _, err := ReorderNote(db, rowIDs["n1-uuid"], rowIDs["n5-uuid"], false); err == nil {
+		t.Error("expected an error for a removed target")
+	}
+
+	assert.DeepEqual(t, getOrder(t, db, "b1-uuid"), []string{"n1-uuid", "n2-uuid", "n3-uuid", "n4-uuid"}, "order mismatch")
+}
+
+func TestReorderNote_renormalize(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	rowIDs := setupPositions(t, db)
+
+	// moving n3 and n4 in turn right after n1 halves the gap after n1 each time
+	renormalized := false
+	note, other := "n4-uuid", "n3-uuid"
+	for i := 0; i < 60 && !renormalized; i++ {
+		note, other = other, note
+
+		got, err := ReorderNote(db, rowIDs[note], rowIDs["n1-uuid"], true)
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "executing move %d", i))
+		}
+
+		renormalized = got == 2
+	}
+
+	if !renormalized {
+		t.Fatal("the positions were not renormalized")
+	}
+
+	expected := []string{"n1-uuid", note, other, "n2-uuid"}
+	assert.DeepEqual(t, getOrder(t, db, "b1-uuid"), expected, "order mismatch")
+
+	for i, uuid := range expected {
+		var position float64
+		MustScan(t, "getting the position of "+uuid, db.QueryRow("SELECT position FROM notes WHERE uuid = ?", uuid), &position)
+		assert.Equal(t, position, float64(i+1), fmt.Sprintf("position mismatch for %s", uuid))
+	}
+}
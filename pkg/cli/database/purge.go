@@ -0,0 +1,112 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"github.com/pkg/errors"
+)
+
+// purgeableNoteCond selects the removed notes whose removal the server has
+// acknowledged, which is when the notes are no longer dirty, and that were
+// removed at or before the time bound to the parameter. The time of the removal
+// is that of the last change to the note in the journal. A note without one is
+// deemed to have been removed long ago, because the journal keeps the recent
+// changes.
+const purgeableNoteCond = `notes.deleted AND NOT notes.dirty
+	AND COALESCE((SELECT max(timestamp) FROM actions WHERE actions.note_uuid = notes.uuid), 0) <= ?`
+
+// purgeableBookCond selects the removed books whose removal the server has
+// acknowledged and that were removed at or before the time bound to the first
+// parameter, as purgeableNoteCond does for the notes. A book is selected only
+// if all of its notes are purgeable at the time bound to the second parameter,
+// so that no note is left without a book.
+const purgeableBookCond = `books.deleted AND NOT books.dirty
+	AND COALESCE((SELECT max(timestamp) FROM actions WHERE actions.book_uuid = books.uuid AND actions.note_uuid = ''), 0) <= ?
+	AND NOT EXISTS (SELECT 1 FROM notes WHERE notes.book_uuid = books.uuid AND NOT (` + purgeableNoteCond + `))`
+
+// PurgeResult is the numbers of the notes and the books purged
+type PurgeResult struct {
+	Notes int
+	Books int
+	// ExpungedNotes is the number of the notes purged from the archive of the
+	// notes that the syncs removed
+	ExpungedNotes int
+	// Bytes is an estimate of the space taken by the purged rows, which is
+	// the sum of the lengths of their text columns
+	Bytes int64
+}
+
+// countPurgeable counts the notes and the books that Purge removes
+func countPurgeable(db *DB, before int64) (PurgeResult, error) {
+	var ret PurgeResult
+
+	var noteBytes, bookBytes int64
+	err := db.QueryRow(`SELECT count(*), COALESCE(SUM(length(uuid) + length(book_uuid) + length(CAST(body AS BLOB))), 0)
+		FROM notes WHERE `+purgeableNoteCond, before).Scan(&ret.Notes, &noteBytes)
+	if err != nil {
+		return ret, errors.Wrap(err, "counting the notes")
+	}
+
+	err = db.QueryRow(`SELECT count(*), COALESCE(SUM(length(uuid) + length(CAST(label AS BLOB))), 0)
+		FROM books WHERE `+purgeableBookCond, before, before).Scan(&ret.Books, &bookBytes)
+	if err != nil {
+		return ret, errors.Wrap(err, "counting the books")
+	}
+
+	var expungedBytes int64
+	err = db.QueryRow(`SELECT count(*), COALESCE(SUM(length(note_uuid) + length(CAST(book_label AS BLOB)) + length(CAST(body AS BLOB))), 0)
+		FROM expunged_archive WHERE expunged_at <= ?`, before).Scan(&ret.ExpungedNotes, &expungedBytes)
+	if err != nil {
+		return ret, errors.Wrap(err, "counting the expunged notes")
+	}
+
+	ret.Bytes = noteBytes + bookBytes + expungedBytes
+
+	return ret, nil
+}
+
+// Purge permanently removes the notes and the books that were removed at or
+// before the given time in nanoseconds, and whose removal the server has
+// acknowledged, as well as the notes that the syncs removed at or before then
+// from the archive of the expunged notes. The rows that are still dirty are never touched, because their
+// removal has yet to be sent. The search index of the notes is updated by the
+// triggers on the notes, and the journal is kept. Nothing is removed if dryRun
+// is true. It should be called in a transaction so that the notes and the
+// books are removed together.
+func Purge(db *DB, before int64, dryRun bool) (PurgeResult, error) {
+	ret, err := countPurgeable(db, before)
+	if err != nil {
+		return ret, err
+	}
+	if dryRun {
+		return ret, nil
+	}
+
+	if _, err := db.Exec("DELETE FROM notes WHERE "+purgeableNoteCond, before); err != nil {
+		return ret, errors.Wrap(err, "removing the notes")
+	}
+	if _, err := db.Exec("DELETE FROM books WHERE "+purgeableBookCond, before, before); err != nil {
+		return ret, errors.Wrap(err, "removing the books")
+	}
+	if _, err := db.Exec("DELETE FROM expunged_archive WHERE expunged_at <= ?", before); err != nil {
+		return ret, errors.Wrap(err, "removing the expunged notes")
+	}
+
+	return ret, nil
+}
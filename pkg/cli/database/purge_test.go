@@ -0,0 +1,168 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+// setupPurge inserts the notes and the books in every state of the removal. The
+// journal has the removals at the time 100 and 900, and none for n4.
+func setupPurge(t *testing.T, db *DB) {
+	books := []struct {
+		uuid    string
+		label   string
+		dirty   bool
+		deleted bool
+	}{
+		{"b1-uuid", "js", false, false},
+		{"b2-uuid", "css", false, true},
+		// has a note whose removal is not sent yet
+		{"b3-uuid", "go", false, true},
+		{"b4-uuid", "rust", true, true},
+		{"b5-uuid", "c", false, true},
+	}
+	for _, b := range books {
+		MustExec(t, "inserting "+b.uuid, db, "INSERT INTO books (uuid, label, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?)", b.uuid, b.label, 1, b.dirty, b.deleted)
+	}
+
+	notes := []struct {
+		uuid     string
+		bookUUID string
+		dirty    bool
+		deleted  bool
+	}{
+		{"n1-uuid", "b1-uuid", false, false},
+		{"n2-uuid", "b1-uuid", false, true},
+		{"n3-uuid", "b1-uuid", true, true},
+		{"n4-uuid", "b2-uuid", false, true},
+		{"n5-uuid", "b3-uuid", true, true},
+		{"n6-uuid", "b1-uuid", false, true},
+		{"n7-uuid", "b5-uuid", false, true},
+	}
+	for _, n := range notes {
+		MustExec(t, "inserting "+n.uuid, db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?, ?, ?)", n.uuid, n.bookUUID, "", 1, 1, n.dirty, n.deleted)
+	}
+
+	actions := []Action{
+		{Timestamp: 50, Actor: ActorCLI, Operation: OpAddNote, NoteUUID: "n2-uuid", BookUUID: "b1-uuid"},
+		{Timestamp: 100, Actor: ActorCLI, Operation: OpRemoveNote, NoteUUID: "n2-uuid", BookUUID: "b1-uuid"},
+		{Timestamp: 100, Actor: ActorCLI, Operation: OpRemoveBook, BookUUID: "b2-uuid"},
+		{Timestamp: 100, Actor: ActorCLI, Operation: OpRemoveBook, BookUUID: "b3-uuid"},
+		{Timestamp: 900, Actor: ActorSyncDownload, Operation: OpRemoveNote, NoteUUID: "n6-uuid", BookUUID: "b1-uuid"},
+		{Timestamp: 900, Actor: ActorSyncDownload, Operation: OpRemoveNote, NoteUUID: "n7-uuid", BookUUID: "b5-uuid"},
+		{Timestamp: 900, Actor: ActorSyncDownload, Operation: OpRemoveBook, BookUUID: "b5-uuid"},
+	}
+	if err := InsertActions(db, actions); err != nil {
+		t.Fatal(errors.Wrap(err, "inserting actions"))
+	}
+}
+
+func getUUIDs(t *testing.T, db *DB, table string) []string {
+	rows, err := db.Query("SELECT uuid FROM " + table + " ORDER BY uuid")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "querying uuids"))
+	}
+	defer rows.Close()
+
+	ret := []string{}
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			t.Fatal(errors.Wrap(err, "scanning a uuid"))
+		}
+
+		ret = append(ret, uuid)
+	}
+
+	return ret
+}
+
+func TestPurge(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	setupPurge(t, db)
+
+	t.Run("dry run", func(t *testing.T) {
+		got, err := Purge(db, 500, true)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, got, PurgeResult{Notes: 2, Books: 1, Bytes: 38}, "result mismatch")
+		assert.Equal(t, len(getUUIDs(t, db, "notes")), 7, "note count mismatch")
+		assert.Equal(t, len(getUUIDs(t, db, "books")), 5, "book count mismatch")
+	})
+
+	t.Run("older than", func(t *testing.T) {
+		got, err := Purge(db, 500, false)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, got, PurgeResult{Notes: 2, Books: 1, Bytes: 38}, "result mismatch")
+		assert.DeepEqual(t, getUUIDs(t, db, "notes"), []string{"n1-uuid", "n3-uuid", "n5-uuid", "n6-uuid", "n7-uuid"}, "notes mismatch")
+		assert.DeepEqual(t, getUUIDs(t, db, "books"), []string{"b1-uuid", "b3-uuid", "b4-uuid", "b5-uuid"}, "books mismatch")
+
+		var indexed int
+		MustScan(t, "counting the indexed notes", db.QueryRow("SELECT count(*) FROM note_fts_docsize"), &indexed)
+		assert.Equal(t, indexed, 5, "indexed note count mismatch")
+	})
+
+	t.Run("all", func(t *testing.T) {
+		got, err := Purge(db, 1000, false)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, got.Notes, 2, "note count mismatch")
+		assert.Equal(t, got.Books, 1, "book count mismatch")
+		// the dirty rows and the book of a dirty note are kept
+		assert.DeepEqual(t, getUUIDs(t, db, "notes"), []string{"n1-uuid", "n3-uuid", "n5-uuid"}, "notes mismatch")
+		assert.DeepEqual(t, getUUIDs(t, db, "books"), []string{"b1-uuid", "b3-uuid", "b4-uuid"}, "books mismatch")
+
+		// the journal is kept
+		var actions int
+		MustScan(t, "counting the actions", db.QueryRow("SELECT count(*) FROM actions"), &actions)
+		assert.Equal(t, actions, 7, "action count mismatch")
+	})
+}
+
+func TestPurge_expunged(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "archiving n1", db, "INSERT INTO expunged_archive (note_uuid, book_label, body, added_on, expunged_at) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "js", "n1 body", 1, 400)
+	MustExec(t, "archiving n2", db, "INSERT INTO expunged_archive (note_uuid, book_label, body, added_on, expunged_at) VALUES (?, ?, ?, ?, ?)", "n2-uuid", "js", "n2 body", 1, 600)
+
+	got, err := Purge(db, 500, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, got, PurgeResult{ExpungedNotes: 1, Bytes: 16}, "result mismatch")
+
+	var uuid string
+	MustScan(t, "getting the kept note", db.QueryRow("SELECT note_uuid FROM expunged_archive"), &uuid)
+	assert.Equal(t, uuid, "n2-uuid", "kept note mismatch")
+}
@@ -20,7 +20,9 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 
+	"github.com/dnote/dnote/pkg/cli/utils"
 	"github.com/dnote/dnote/pkg/clock"
 	"github.com/pkg/errors"
 )
@@ -44,7 +46,7 @@ func InsertSystem(db *DB, key, val string) error {
 }
 
 // UpsertSystem inserts or updates a system configuration
-func UpsertSystem(db *DB, key, val string) error {
+func UpsertSystem(db *DB, key string, val interface{}) error {
 	var count int
 	if err := db.QueryRow("SELECT count(*) FROM system WHERE key = ?", key).Scan(&count); err != nil {
 		return errors.Wrap(err, "counting system record")
@@ -89,17 +91,39 @@ type NoteInfo struct {
 	Content   string
 	AddedOn   int64
 	EditedOn  int64
+	// OriginDevice is the id of the device on which the note was added or
+	// last edited locally
+	OriginDevice string
+	// ViewCount is the number of times the note was viewed on this device
+	ViewCount int
+	// LastViewedAt is when the note was last viewed on this device
+	LastViewedAt int64
 }
 
 // GetNoteInfo returns a NoteInfo for the note with the given noteRowID
 func GetNoteInfo(db *DB, noteRowID int) (NoteInfo, error) {
+	return getNoteInfo(db, noteRowID, false)
+}
+
+// GetNoteInfoWithDeleted returns a NoteInfo for the note with the given
+// noteRowID, which may be deleted
+func GetNoteInfoWithDeleted(db *DB, noteRowID int) (NoteInfo, error) {
+	return getNoteInfo(db, noteRowID, true)
+}
+
+func getNoteInfo(db *DB, noteRowID int, includeDeleted bool) (NoteInfo, error) {
 	var ret NoteInfo
 
-	err := db.QueryRow(`SELECT books.label, notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.rowid
+	query := `SELECT books.label, notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.rowid, notes.origin_device, notes.view_count, notes.last_viewed_at
 			FROM notes
 			INNER JOIN books ON books.uuid = notes.book_uuid
-			WHERE notes.rowid = ? AND notes.deleted = false`, noteRowID).
-		Scan(&ret.BookLabel, &ret.UUID, &ret.Content, &ret.AddedOn, &ret.EditedOn, &ret.RowID)
+			WHERE notes.rowid = ?`
+	if !includeDeleted {
+		query += " AND notes.deleted = false"
+	}
+
+	err := db.QueryRow(query, noteRowID).
+		Scan(&ret.BookLabel, &ret.UUID, &ret.Content, &ret.AddedOn, &ret.EditedOn, &ret.RowID, &ret.OriginDevice, &ret.ViewCount, &ret.LastViewedAt)
 	if err == sql.ErrNoRows {
 		return ret, errors.Errorf("note %d not found", noteRowID)
 	} else if err != nil {
@@ -109,6 +133,24 @@ func GetNoteInfo(db *DB, noteRowID int) (NoteInfo, error) {
 	return ret, nil
 }
 
+// GetNoteInfoByUUID returns a NoteInfo for the note with the given uuid
+func GetNoteInfoByUUID(db *DB, uuid string) (NoteInfo, error) {
+	var ret NoteInfo
+
+	err := db.QueryRow(`SELECT books.label, notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.rowid, notes.origin_device, notes.view_count, notes.last_viewed_at
+			FROM notes
+			INNER JOIN books ON books.uuid = notes.book_uuid
+			WHERE notes.uuid = ? AND notes.deleted = false`, uuid).
+		Scan(&ret.BookLabel, &ret.UUID, &ret.Content, &ret.AddedOn, &ret.EditedOn, &ret.RowID, &ret.OriginDevice, &ret.ViewCount, &ret.LastViewedAt)
+	if err == sql.ErrNoRows {
+		return ret, err
+	} else if err != nil {
+		return ret, errors.Wrap(err, "querying the note")
+	}
+
+	return ret, nil
+}
+
 // BookInfo is a basic information about a book
 type BookInfo struct {
 	RowID int
@@ -146,6 +188,79 @@ func GetBookUUID(db *DB, label string) (string, error) {
 	return ret, nil
 }
 
+// GetBookLabels returns the labels of all books that are not deleted
+func GetBookLabels(db *DB) ([]string, error) {
+	rows, err := db.Query("SELECT label FROM books WHERE deleted = false ORDER BY label ASC")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying books")
+	}
+	defer rows.Close()
+
+	ret := []string{}
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		ret = append(ret, label)
+	}
+
+	return ret, nil
+}
+
+// ResolveLabel resolves a book label conflict by repeatedly appending an increasing integer
+// to the label until it finds a unique label. It returns the first non-conflicting label.
+func ResolveLabel(db *DB, label string) (string, error) {
+	var ret string
+
+	for i := 2; ; i++ {
+		ret = fmt.Sprintf("%s_%d", label, i)
+
+		var cnt int
+		if err := db.QueryRow("SELECT count(*) FROM books WHERE label = ?", ret).Scan(&cnt); err != nil {
+			return "", errors.Wrapf(err, "checking availability of label %s", ret)
+		}
+
+		if cnt == 0 {
+			break
+		}
+	}
+
+	return ret, nil
+}
+
+// CreateRecoveryBook creates a new book, which is uploaded by the next sync, to
+// keep the notes that would otherwise be lost. It is named recovered, or
+// recovered_2 and so on if the name is taken. It returns the uuid and the label
+// of the book.
+func CreateRecoveryBook(db *DB) (string, string, error) {
+	label := "recovered"
+
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM books WHERE label = ?", label).Scan(&count); err != nil {
+		return "", "", errors.Wrap(err, "counting the books with the label")
+	}
+	if count > 0 {
+		var err error
+		if label, err = ResolveLabel(db, label); err != nil {
+			return "", "", errors.Wrap(err, "getting a label for the book")
+		}
+	}
+
+	uuid, err := utils.GenerateUUID()
+	if err != nil {
+		return "", "", err
+	}
+
+	b := NewBook(uuid, label, 0, false, true)
+	if err := b.Insert(db); err != nil {
+		return "", "", errors.Wrap(err, "inserting the book")
+	}
+
+	return uuid, label, nil
+}
+
 // UpdateBookName updates a book name
 func UpdateBookName(db *DB, uuid string, name string) error {
 	_, err := db.Exec(`UPDATE books
@@ -195,13 +310,14 @@ func GetActiveNote(db *DB, rowid int) (Note, error) {
 	return ret, nil
 }
 
-// UpdateNoteContent updates the note content and marks the note as dirty
-func UpdateNoteContent(db *DB, c clock.Clock, rowID int, content string) error {
+// UpdateNoteContent updates the note content and marks the note as dirty. The
+// note is stamped with the given device on which it is edited.
+func UpdateNoteContent(db *DB, c clock.Clock, rowID int, content, device string) error {
 	ts := c.Now().UnixNano()
 
 	_, err := db.Exec(`UPDATE notes
-			SET body = ?, edited_on = ?, dirty = ?
-			WHERE rowid = ?`, content, ts, true, rowID)
+			SET body = ?, edited_on = ?, dirty = ?, origin_device = ?
+			WHERE rowid = ?`, content, ts, true, device, rowID)
 	if err != nil {
 		return errors.Wrap(err, "updating the note")
 	}
@@ -209,16 +325,29 @@ func UpdateNoteContent(db *DB, c clock.Clock, rowID int, content string) error {
 	return nil
 }
 
-// UpdateNoteBook moves the note to a different book and marks the note as dirty
-func UpdateNoteBook(db *DB, c clock.Clock, rowID int, bookUUID string) error {
+// UpdateNoteBook moves the note to the end of a different book and marks the
+// note as dirty. The note is stamped with the given device on which it is
+// moved.
+func UpdateNoteBook(db *DB, c clock.Clock, rowID int, bookUUID, device string) error {
 	ts := c.Now().UnixNano()
 
 	_, err := db.Exec(`UPDATE notes
-			SET book_uuid = ?, edited_on = ?, dirty = ?
-			WHERE rowid = ?`, bookUUID, ts, true, rowID)
+			SET book_uuid = ?, edited_on = ?, dirty = ?, origin_device = ?, position = `+nextPositionSQL+`
+			WHERE rowid = ?`, bookUUID, ts, true, device, bookUUID, rowID)
 	if err != nil {
 		return errors.Wrap(err, "updating the note")
 	}
 
 	return nil
 }
+
+// RecordNoteView counts a view of the note and stamps the time of it. The note
+// is not marked dirty because the view stats are never synced.
+func RecordNoteView(db *DB, c clock.Clock, rowID int) error {
+	_, err := db.Exec("UPDATE notes SET view_count = view_count + 1, last_viewed_at = ? WHERE rowid = ?", c.Now().UnixNano(), rowID)
+	if err != nil {
+		return errors.Wrap(err, "updating the view stats of the note")
+	}
+
+	return nil
+}
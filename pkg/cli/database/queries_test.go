@@ -242,6 +242,7 @@ func TestGetActiveNote(t *testing.T) {
 		defer TeardownTestDB(t, db)
 
 		n1UUID := "n1-uuid"
+		MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
 		MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", n1UUID, "b1-uuid", "n1 content", 1542058875, 1542058876, 1, true, false, true)
 
 		var n1RowID int
@@ -272,6 +273,7 @@ func TestGetActiveNote(t *testing.T) {
 		defer TeardownTestDB(t, db)
 
 		n1UUID := "n1-uuid"
+		MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
 		MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", n1UUID, "b1-uuid", "n1 content", 1542058875, 1542058876, 1, true, true, true)
 
 		var n1RowID int
@@ -296,6 +298,7 @@ func TestUpdateNoteContent(t *testing.T) {
 	defer TeardownTestDB(t, db)
 
 	uuid := "n1-uuid"
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
 	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", uuid, "b1-uuid", "n1 content", 1542058875, 0, 1, false, false, false)
 
 	var rowid int
@@ -306,20 +309,50 @@ func TestUpdateNoteContent(t *testing.T) {
 	now := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
 	c.SetNow(now)
 
-	err := UpdateNoteContent(db, c, rowid, "n1 content updated")
+	err := UpdateNoteContent(db, c, rowid, "n1 content updated", "device-1")
 	if err != nil {
 		t.Fatal(errors.Wrap(err, "executing"))
 	}
 
-	var content string
+	var content, originDevice string
 	var editedOn int
 	var dirty bool
 
-	MustScan(t, "getting the note record", db.QueryRow("SELECT body, edited_on, dirty FROM notes WHERE rowid = ?", rowid), &content, &editedOn, &dirty)
+	MustScan(t, "getting the note record", db.QueryRow("SELECT body, edited_on, dirty, origin_device FROM notes WHERE rowid = ?", rowid), &content, &editedOn, &dirty, &originDevice)
 
 	assert.Equal(t, content, "n1 content updated", "content mismatch")
 	assert.Equal(t, int64(editedOn), now.UnixNano(), "editedOn mismatch")
 	assert.Equal(t, dirty, true, "dirty mismatch")
+	assert.Equal(t, originDevice, "device-1", "originDevice mismatch")
+}
+
+func TestUpdateNoteContent_successive(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	uuid := "n1-uuid"
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", uuid, "b1-uuid", "n1 content", 1542058875, 0, 1, false, false, false)
+
+	var rowid int
+	MustScan(t, "getting rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", uuid), &rowid)
+
+	start := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
+	c := clock.NewStepping(start, time.Minute)
+
+	// execute and test
+	for i, content := range []string{"n1 content v2", "n1 content v3"} {
+		if err := UpdateNoteContent(db, c, rowid, content, ""); err != nil {
+			t.Fatal(errors.Wrapf(err, "executing edit %d", i))
+		}
+
+		var editedOn int64
+		MustScan(t, "getting the note record", db.QueryRow("SELECT edited_on FROM notes WHERE rowid = ?", rowid), &editedOn)
+
+		expected := start.Add(time.Duration(i) * time.Minute).UnixNano()
+		assert.Equal(t, editedOn, expected, fmt.Sprintf("editedOn mismatch for edit %d", i))
+	}
 }
 
 func TestUpdateNoteBook(t *testing.T) {
@@ -343,20 +376,63 @@ func TestUpdateNoteBook(t *testing.T) {
 	now := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
 	c.SetNow(now)
 
-	err := UpdateNoteBook(db, c, rowid, b2UUID)
+	err := UpdateNoteBook(db, c, rowid, b2UUID, "device-1")
 	if err != nil {
 		t.Fatal(errors.Wrap(err, "executing"))
 	}
 
-	var bookUUID string
+	var bookUUID, originDevice string
 	var editedOn int
 	var dirty bool
 
-	MustScan(t, "getting the note record", db.QueryRow("SELECT book_uuid, edited_on, dirty FROM notes WHERE rowid = ?", rowid), &bookUUID, &editedOn, &dirty)
+	MustScan(t, "getting the note record", db.QueryRow("SELECT book_uuid, edited_on, dirty, origin_device FROM notes WHERE rowid = ?", rowid), &bookUUID, &editedOn, &dirty, &originDevice)
 
 	assert.Equal(t, bookUUID, b2UUID, "content mismatch")
 	assert.Equal(t, int64(editedOn), now.UnixNano(), "editedOn mismatch")
 	assert.Equal(t, dirty, true, "dirty mismatch")
+	assert.Equal(t, originDevice, "device-1", "originDevice mismatch")
+}
+
+func TestRecordNoteView(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 content", 1542058875, 0, 1, false)
+	MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", "n2 content", 1542058876, 0, 2, false)
+
+	var rowid int
+	MustScan(t, "getting rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", "n1-uuid"), &rowid)
+
+	// execute
+	c := clock.NewMock()
+	first := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
+	c.SetNow(first)
+	if err := RecordNoteView(db, c, rowid); err != nil {
+		t.Fatal(errors.Wrap(err, "recording the first view"))
+	}
+
+	second := first.Add(time.Hour)
+	c.SetNow(second)
+	if err := RecordNoteView(db, c, rowid); err != nil {
+		t.Fatal(errors.Wrap(err, "recording the second view"))
+	}
+
+	// test
+	var viewCount, usn int
+	var lastViewedAt, editedOn int64
+	var dirty bool
+	MustScan(t, "getting n1", db.QueryRow("SELECT view_count, last_viewed_at, edited_on, usn, dirty FROM notes WHERE uuid = ?", "n1-uuid"), &viewCount, &lastViewedAt, &editedOn, &usn, &dirty)
+	assert.Equal(t, viewCount, 2, "view_count mismatch")
+	assert.Equal(t, lastViewedAt, second.UnixNano(), "last_viewed_at mismatch")
+	assert.Equal(t, editedOn, int64(0), "edited_on mismatch")
+	assert.Equal(t, usn, 1, "usn mismatch")
+	assert.Equal(t, dirty, false, "dirty mismatch")
+
+	MustScan(t, "getting n2", db.QueryRow("SELECT view_count, last_viewed_at FROM notes WHERE uuid = ?", "n2-uuid"), &viewCount, &lastViewedAt)
+	assert.Equal(t, viewCount, 0, "n2 view_count mismatch")
+	assert.Equal(t, lastViewedAt, int64(0), "n2 last_viewed_at mismatch")
 }
 
 func TestUpdateBookName(t *testing.T) {
@@ -382,3 +458,98 @@ func TestUpdateBookName(t *testing.T) {
 	assert.Equal(t, b1.USN, 8, "USN mismatch")
 	assert.Equal(t, b1.Deleted, false, "Deleted mismatch")
 }
+
+func TestResolveLabel(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "js",
+			expected: "js_2",
+		},
+		{
+			input:    "css",
+			expected: "css_3",
+		},
+		{
+			input:    "linux",
+			expected: "linux_4",
+		},
+		{
+			input:    "cool_ideas",
+			expected: "cool_ideas_2",
+		},
+	}
+
+	for idx, tc := range testCases {
+		func() {
+			// set up
+			db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+			defer TeardownTestDB(t, db)
+
+			MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+			MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "css_2")
+			MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b3-uuid", "linux_(1)")
+			MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b4-uuid", "linux_2")
+			MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b5-uuid", "linux_3")
+			MustExec(t, fmt.Sprintf("inserting book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b6-uuid", "cool_ideas")
+
+			// execute
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+			}
+
+			got, err := ResolveLabel(tx, tc.input)
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+			}
+			tx.Rollback()
+
+			assert.Equal(t, got, tc.expected, fmt.Sprintf("output mismatch for test case %d", idx))
+		}()
+	}
+}
+
+func TestGetBookLabels(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "linux", 1, false, false)
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b2-uuid", "css", 2, false, false)
+	MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b3-uuid", "", 3, true, false)
+
+	// execute
+	labels, err := GetBookLabels(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	assert.DeepEqual(t, labels, []string{"css", "linux"}, "labels mismatch")
+}
+
+func TestGetNoteInfoByUUID(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 content", 1542058875, 1542058876, 1, true, false, true)
+	MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", "", 1542058875, 1542058876, 1, false, true, true)
+
+	got, err := GetNoteInfoByUUID(db, "n1-uuid")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, got.UUID, "n1-uuid", "UUID mismatch")
+	assert.Equal(t, got.BookLabel, "b1-label", "BookLabel mismatch")
+	assert.Equal(t, got.Content, "n1 content", "Content mismatch")
+	assert.Equal(t, got.AddedOn, int64(1542058875), "AddedOn mismatch")
+	assert.Equal(t, got.EditedOn, int64(1542058876), "EditedOn mismatch")
+
+	_, err = GetNoteInfoByUUID(db, "n2-uuid")
+	assert.Equal(t, err, sql.ErrNoRows, "error mismatch for a deleted note")
+}
@@ -0,0 +1,76 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	stdCtx "context"
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// Restore replaces the content of the database with that of the database file
+// at the given path. It uses the online backup API of SQLite, which copies the
+// file page by page under a lock, so that the other connections in the pool
+// see the restored content and the database file is never left half written.
+func Restore(db *DB, srcPath string) error {
+	pool, ok := db.Conn.(*sql.DB)
+	if !ok {
+		return errors.New("cannot restore the database in a transaction")
+	}
+
+	conn, err := pool.Conn(stdCtx.Background())
+	if err != nil {
+		return errors.Wrap(err, "getting a connection")
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		dst, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errors.New("the database is not an SQLite database")
+		}
+
+		c, err := (&sqlite3.SQLiteDriver{}).Open(fmt.Sprintf("file:%s?%s", srcPath, readOnlyConnParams))
+		if err != nil {
+			return errors.Wrap(err, "opening the database to restore")
+		}
+		defer c.Close()
+		src := c.(*sqlite3.SQLiteConn)
+
+		b, err := dst.Backup("main", src, "main")
+		if err != nil {
+			return errors.Wrap(err, "starting the restore")
+		}
+		// all the pages are copied in one step
+		done, err := b.Step(-1)
+		if err != nil {
+			b.Finish()
+			return errors.Wrap(err, "copying the database")
+		}
+		if !done {
+			b.Finish()
+			return errors.New("copying the database did not finish")
+		}
+
+		return errors.Wrap(b.Finish(), "finishing the restore")
+	})
+}
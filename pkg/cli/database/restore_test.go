@@ -0,0 +1,70 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func TestRestore(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", &TestDBOptions{Mode: TestDBModeTempDir})
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1)
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.db")
+	MustExec(t, "taking a snapshot", db, "VACUUM INTO ?", snapshotPath)
+
+	MustExec(t, "removing n1", db, "DELETE FROM notes WHERE uuid = ?", "n1-uuid")
+	MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n2-uuid", "b1-uuid", "n2 body", 2)
+
+	// a transaction cannot be restored into
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+	assert.NotEqual(t, Restore(tx, snapshotPath), nil, "restoring in a transaction should fail")
+	tx.Rollback()
+
+	if err := Restore(db, snapshotPath); err != nil {
+		t.Fatal(errors.Wrap(err, "restoring"))
+	}
+
+	assert.DeepEqual(t, getUUIDs(t, db, "notes"), []string{"n1-uuid"}, "notes mismatch")
+
+	var body string
+	MustScan(t, "getting n1", db.QueryRow("SELECT body FROM notes WHERE uuid = ?", "n1-uuid"), &body)
+	assert.Equal(t, body, "n1 body", "body mismatch")
+
+	// the full text index is restored along with the notes
+	var count int
+	MustScan(t, "searching", db.QueryRow("SELECT count(*) FROM note_fts WHERE note_fts MATCH ?", "body"), &count)
+	assert.Equal(t, count, 1, "search result count mismatch")
+
+	t.Run("missing file", func(t *testing.T) {
+		err := Restore(db, filepath.Join(t.TempDir(), "missing.db"))
+		assert.NotEqual(t, err, nil, "restoring a missing file should fail")
+		assert.DeepEqual(t, getUUIDs(t, db, "notes"), []string{"n1-uuid"}, "notes mismatch after a failed restore")
+	})
+}
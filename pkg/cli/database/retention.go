@@ -0,0 +1,100 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package database
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BookRetention is the retention policy of a book. The policies are local
+// settings and are never synced. A zero MaxAge or MaxCount sets no limit.
+type BookRetention struct {
+	BookUUID  string
+	BookLabel string
+	// MaxAge is the age past which a note expires, counted from its last change
+	MaxAge time.Duration
+	// MaxCount is the number of the most recently changed notes to keep
+	MaxCount int
+}
+
+// SetBookRetention sets the retention policy of the book with the given uuid,
+// or removes it if the policy sets no limit
+func SetBookRetention(db *DB, bookUUID string, maxAge time.Duration, maxCount int) error {
+	if maxAge == 0 && maxCount == 0 {
+		if _, err := db.Exec("DELETE FROM book_retention WHERE book_uuid = ?", bookUUID); err != nil {
+			return errors.Wrap(err, "removing the retention policy")
+		}
+
+		return nil
+	}
+
+	_, err := db.Exec(`INSERT INTO book_retention (book_uuid, max_age, max_count) VALUES (?, ?, ?)
+		ON CONFLICT(book_uuid) DO UPDATE SET max_age = excluded.max_age, max_count = excluded.max_count`,
+		bookUUID, int64(maxAge/time.Second), maxCount)
+	if err != nil {
+		return errors.Wrap(err, "setting the retention policy")
+	}
+
+	return nil
+}
+
+// GetBookRetention returns the retention policy of the book with the given
+// uuid. A book without a policy has a zero policy.
+func GetBookRetention(db *DB, bookUUID string) (BookRetention, error) {
+	ret := BookRetention{BookUUID: bookUUID}
+
+	var maxAge int64
+	err := db.QueryRow(`SELECT books.label, COALESCE(book_retention.max_age, 0), COALESCE(book_retention.max_count, 0)
+		FROM books LEFT JOIN book_retention ON book_retention.book_uuid = books.uuid
+		WHERE books.uuid = ?`, bookUUID).Scan(&ret.BookLabel, &maxAge, &ret.MaxCount)
+	if err != nil {
+		return ret, errors.Wrap(err, "querying the retention policy")
+	}
+	ret.MaxAge = time.Duration(maxAge) * time.Second
+
+	return ret, nil
+}
+
+// GetBookRetentions returns the retention policies of the books that are not
+// removed, ordered by the label of the book
+func GetBookRetentions(db *DB) ([]BookRetention, error) {
+	rows, err := db.Query(`SELECT books.uuid, books.label, book_retention.max_age, book_retention.max_count
+		FROM book_retention INNER JOIN books ON books.uuid = book_retention.book_uuid
+		WHERE NOT books.deleted
+		ORDER BY books.label ASC`)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the retention policies")
+	}
+	defer rows.Close()
+
+	ret := []BookRetention{}
+	for rows.Next() {
+		var r BookRetention
+		var maxAge int64
+		if err := rows.Scan(&r.BookUUID, &r.BookLabel, &maxAge, &r.MaxCount); err != nil {
+			return nil, errors.Wrap(err, "scanning a retention policy")
+		}
+		r.MaxAge = time.Duration(maxAge) * time.Second
+
+		ret = append(ret, r)
+	}
+
+	return ret, rows.Err()
+}
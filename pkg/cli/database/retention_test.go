@@ -0,0 +1,84 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func TestBookRetention(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "css")
+	MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, deleted) VALUES (?, ?, ?)", "b3-uuid", "go", true)
+
+	t.Run("without a policy", func(t *testing.T) {
+		got, err := GetBookRetention(db, "b1-uuid")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting the policy"))
+		}
+
+		assert.DeepEqual(t, got, BookRetention{BookUUID: "b1-uuid", BookLabel: "js"}, "policy mismatch")
+	})
+
+	t.Run("set and update", func(t *testing.T) {
+		if err := SetBookRetention(db, "b1-uuid", 90*24*time.Hour, 0); err != nil {
+			t.Fatal(errors.Wrap(err, "setting the policy"))
+		}
+		if err := SetBookRetention(db, "b1-uuid", 90*24*time.Hour, 500); err != nil {
+			t.Fatal(errors.Wrap(err, "updating the policy"))
+		}
+		if err := SetBookRetention(db, "b2-uuid", 0, 10); err != nil {
+			t.Fatal(errors.Wrap(err, "setting the policy"))
+		}
+		if err := SetBookRetention(db, "b3-uuid", 0, 10); err != nil {
+			t.Fatal(errors.Wrap(err, "setting the policy"))
+		}
+
+		got, err := GetBookRetention(db, "b1-uuid")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting the policy"))
+		}
+		assert.DeepEqual(t, got, BookRetention{BookUUID: "b1-uuid", BookLabel: "js", MaxAge: 90 * 24 * time.Hour, MaxCount: 500}, "policy mismatch")
+
+		all, err := GetBookRetentions(db)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting the policies"))
+		}
+		assert.DeepEqual(t, all, []BookRetention{
+			{BookUUID: "b2-uuid", BookLabel: "css", MaxCount: 10},
+			{BookUUID: "b1-uuid", BookLabel: "js", MaxAge: 90 * 24 * time.Hour, MaxCount: 500},
+		}, "policies mismatch")
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		if err := SetBookRetention(db, "b2-uuid", 0, 0); err != nil {
+			t.Fatal(errors.Wrap(err, "clearing the policy"))
+		}
+
+		var count int
+		MustScan(t, "counting the policies", db.QueryRow("SELECT count(*) FROM book_retention WHERE book_uuid = ?", "b2-uuid"), &count)
+		assert.Equal(t, count, 0, "count mismatch")
+	})
+}
@@ -0,0 +1,146 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// Share is a link by which anyone can view a note, cached from the server.
+// The cache is refreshed by the share commands and the syncs.
+type Share struct {
+	ID       string
+	NoteUUID string
+	URL      string
+	// CreatedAt is the time the link was created in Unix nanoseconds
+	CreatedAt int64
+	// ExpiresAt is the time the link expires in Unix nanoseconds, or 0 if it
+	// does not expire
+	ExpiresAt int64
+}
+
+// Active reports whether the link has not expired at the given time in Unix
+// nanoseconds
+func (s Share) Active(now int64) bool {
+	return s.ExpiresAt == 0 || s.ExpiresAt > now
+}
+
+// ActiveShareCond is the condition on the notes table for the notes with the
+// links that have not expired at the time given as its argument in Unix
+// nanoseconds
+const ActiveShareCond = "notes.uuid IN (SELECT note_uuid FROM note_shares WHERE expires_at = 0 OR expires_at > ?)"
+
+// SaveShare caches the share link, replacing the one with the same id
+func SaveShare(db *DB, s Share) error {
+	if _, err := db.Exec("INSERT OR REPLACE INTO note_shares (id, note_uuid, url, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		s.ID, s.NoteUUID, s.URL, s.CreatedAt, s.ExpiresAt); err != nil {
+		return errors.Wrapf(err, "saving the share %s", s.ID)
+	}
+
+	return nil
+}
+
+// ReplaceNoteShares replaces the cached share links of the note with the given
+// ones
+func ReplaceNoteShares(db *DB, noteUUID string, shares []Share) error {
+	if _, err := db.Exec("DELETE FROM note_shares WHERE note_uuid = ?", noteUUID); err != nil {
+		return errors.Wrapf(err, "removing the shares of the note %s", noteUUID)
+	}
+
+	for _, s := range shares {
+		if err := SaveShare(db, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteShare removes the share link from the cache
+func DeleteShare(db *DB, id string) error {
+	if _, err := db.Exec("DELETE FROM note_shares WHERE id = ?", id); err != nil {
+		return errors.Wrapf(err, "removing the share %s", id)
+	}
+
+	return nil
+}
+
+// GetShare returns the cached share link of the id. The boolean is false if
+// no such link is cached.
+func GetShare(db *DB, id string) (Share, bool, error) {
+	var s Share
+	err := db.QueryRow("SELECT id, note_uuid, url, created_at, expires_at FROM note_shares WHERE id = ?", id).
+		Scan(&s.ID, &s.NoteUUID, &s.URL, &s.CreatedAt, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return s, false, nil
+	} else if err != nil {
+		return s, false, errors.Wrapf(err, "getting the share %s", id)
+	}
+
+	return s, true, nil
+}
+
+// GetShares returns the cached share links that have not expired at the given
+// time in Unix nanoseconds, oldest first. If noteUUID is not empty, only the
+// links to the note are returned.
+func GetShares(db *DB, noteUUID string, now int64) ([]Share, error) {
+	rows, err := db.Query(`SELECT id, note_uuid, url, created_at, expires_at FROM note_shares
+		WHERE (? = '' OR note_uuid = ?) AND (expires_at = 0 OR expires_at > ?)
+		ORDER BY created_at, id`, noteUUID, noteUUID, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the shares")
+	}
+	defer rows.Close()
+
+	ret := []Share{}
+	for rows.Next() {
+		var s Share
+		if err := rows.Scan(&s.ID, &s.NoteUUID, &s.URL, &s.CreatedAt, &s.ExpiresAt); err != nil {
+			return nil, errors.Wrap(err, "scanning a share")
+		}
+
+		ret = append(ret, s)
+	}
+
+	return ret, errors.Wrap(rows.Err(), "iterating the shares")
+}
+
+// GetSharedNoteUUIDs returns the uuids of the notes that have cached share
+// links, expired or not
+func GetSharedNoteUUIDs(db *DB) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT note_uuid FROM note_shares ORDER BY note_uuid")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the shared notes")
+	}
+	defer rows.Close()
+
+	var ret []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, errors.Wrap(err, "scanning a shared note")
+		}
+
+		ret = append(ret, uuid)
+	}
+
+	return ret, errors.Wrap(rows.Err(), "iterating the shared notes")
+}
@@ -0,0 +1,93 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func getShareIDs(t *testing.T, db *DB, noteUUID string, now int64) []string {
+	shares, err := GetShares(db, noteUUID, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the shares"))
+	}
+
+	ret := []string{}
+	for _, s := range shares {
+		ret = append(ret, s.ID)
+	}
+
+	return ret
+}
+
+func TestShares(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	shares := []Share{
+		{ID: "s1", NoteUUID: "n1-uuid", URL: "https://example.com/s/s1", CreatedAt: 1000},
+		{ID: "s2", NoteUUID: "n1-uuid", URL: "https://example.com/s/s2", CreatedAt: 2000, ExpiresAt: 5000},
+		{ID: "s3", NoteUUID: "n2-uuid", URL: "https://example.com/s/s3", CreatedAt: 1500, ExpiresAt: 3000},
+	}
+	for _, s := range shares {
+		if err := SaveShare(db, s); err != nil {
+			t.Fatal(errors.Wrapf(err, "saving %s", s.ID))
+		}
+	}
+
+	assert.DeepEqual(t, getShareIDs(t, db, "", 2500), []string{"s1", "s3", "s2"}, "all shares mismatch")
+	assert.DeepEqual(t, getShareIDs(t, db, "", 3000), []string{"s1", "s2"}, "shares after s3 expired mismatch")
+	assert.DeepEqual(t, getShareIDs(t, db, "n1-uuid", 6000), []string{"s1"}, "n1 shares mismatch")
+
+	// the expired links are still cached until replaced
+	uuids, err := GetSharedNoteUUIDs(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the shared notes"))
+	}
+	assert.DeepEqual(t, uuids, []string{"n1-uuid", "n2-uuid"}, "shared notes mismatch")
+
+	s, ok, err := GetShare(db, "s2")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting s2"))
+	}
+	assert.Equal(t, ok, true, "s2 should be found")
+	assert.Equal(t, s, shares[1], "s2 mismatch")
+	assert.Equal(t, s.Active(4999), true, "s2 should be active before it expires")
+	assert.Equal(t, s.Active(5000), false, "s2 should not be active when it expires")
+	assert.Equal(t, shares[0].Active(1<<62), true, "s1 should never expire")
+
+	if err := ReplaceNoteShares(db, "n1-uuid", []Share{{ID: "s4", NoteUUID: "n1-uuid", URL: "https://example.com/s/s4", CreatedAt: 4000}}); err != nil {
+		t.Fatal(errors.Wrap(err, "replacing the shares of n1"))
+	}
+	assert.DeepEqual(t, getShareIDs(t, db, "", 0), []string{"s3", "s4"}, "shares after replace mismatch")
+
+	if err := DeleteShare(db, "s3"); err != nil {
+		t.Fatal(errors.Wrap(err, "deleting s3"))
+	}
+	assert.DeepEqual(t, getShareIDs(t, db, "", 0), []string{"s4"}, "shares after delete mismatch")
+
+	_, ok, err = GetShare(db, "s3")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting s3"))
+	}
+	assert.Equal(t, ok, false, "s3 should not be found")
+}
@@ -20,10 +20,10 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 
+	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
-	// use sqlite
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // SQLCommon is the minimal interface required by a db connection
@@ -49,6 +49,8 @@ type sqlTx interface {
 type DB struct {
 	Conn     SQLCommon
 	Filepath string
+	// ReadOnly indicates whether the database was opened in the read-only mode
+	ReadOnly bool
 }
 
 // Begin begins a transaction
@@ -118,9 +120,38 @@ func (d *DB) Close() error {
 	return errors.New("can't close db")
 }
 
+// connParams are the parameters applied to every connection in the pool.
+// WAL mode lets readers proceed while a writer holds a transaction, and the
+// busy timeout makes concurrent writers wait for the lock instead of failing.
+const connParams = "_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on&_synchronous=NORMAL"
+
 // Open initializes a new connection to the sqlite database
 func Open(dbPath string) (*DB, error) {
-	dbConn, err := sql.Open("sqlite3", dbPath)
+	dbConn, err := sql.Open("sqlite3", fmt.Sprintf("%s?%s", dbPath, connParams))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening db connection")
+	}
+
+	db := &DB{
+		Conn:     dbConn,
+		Filepath: dbPath,
+	}
+
+	return db, nil
+}
+
+// readOnlyConnParams are the parameters for the connections in the read-only
+// mode, which does not create or modify any file other than the shared memory
+// index of the write-ahead log.
+const readOnlyConnParams = "mode=ro&_busy_timeout=5000&_foreign_keys=on"
+
+// immutableConnParams are the parameters for the connections to a database in a
+// read-only directory, where the shared memory index cannot be created. SQLite
+// reads such a database without any locking.
+const immutableConnParams = "mode=ro&immutable=1&_foreign_keys=on"
+
+func openReadOnly(dbPath, params string) (*DB, error) {
+	dbConn, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?%s", dbPath, params))
 	if err != nil {
 		return nil, errors.Wrap(err, "opening db connection")
 	}
@@ -128,7 +159,53 @@ func Open(dbPath string) (*DB, error) {
 	db := &DB{
 		Conn:     dbConn,
 		Filepath: dbPath,
+		ReadOnly: true,
 	}
 
 	return db, nil
 }
+
+// OpenReadOnly initializes a new read-only connection to an existing sqlite
+// database. If the shared memory index cannot be created, for instance in a
+// read-only directory, the database is opened as immutable.
+func OpenReadOnly(dbPath string) (*DB, error) {
+	db, err := openReadOnly(dbPath, readOnlyConnParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	err = db.QueryRow("SELECT count(*) FROM sqlite_master").Scan(&count)
+	if err == nil {
+		return db, nil
+	}
+
+	db.Close()
+	if !IsReadOnlyError(err) {
+		return nil, errors.Wrap(err, "reading the database")
+	}
+
+	return openReadOnly(dbPath, immutableConnParams)
+}
+
+// IsReadOnlyError checks if the given error is caused by an attempt to write
+// to a read-only database
+func IsReadOnlyError(err error) bool {
+	e, ok := errors.Cause(err).(sqlite3.Error)
+	if !ok {
+		return false
+	}
+
+	return e.Code == sqlite3.ErrReadonly
+}
+
+// IsBusyError checks if the given error is caused by the database being locked
+// by another connection, such as another dnote process
+func IsBusyError(err error) bool {
+	e, ok := errors.Cause(err).(sqlite3.Error)
+	if !ok {
+		return false
+	}
+
+	return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+}
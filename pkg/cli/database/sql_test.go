@@ -0,0 +1,141 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+func TestOpen_concurrentRead(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", &TestDBOptions{Mode: TestDBModeTempDir})
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+
+	reader, err := Open(db.Filepath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening another connection"))
+	}
+	defer reader.Close()
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+	defer tx.Rollback()
+
+	MustExec(t, "updating b1", tx, "UPDATE books SET label = ? WHERE uuid = ?", "b1-label-edited", "b1-uuid")
+	MustExec(t, "inserting b2", tx, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "b2-label")
+
+	// test
+	var label string
+	MustScan(t, "reading b1 while the transaction is open", reader.QueryRow("SELECT label FROM books WHERE uuid = ?", "b1-uuid"), &label)
+	assert.Equal(t, label, "b1-label", "uncommitted change was visible")
+
+	var count int
+	MustScan(t, "counting books while the transaction is open", reader.QueryRow("SELECT count(*) FROM books"), &count)
+	assert.Equal(t, count, 1, "book count mismatch")
+}
+
+func TestOpen_foreignKeys(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", &TestDBOptions{Mode: TestDBModeTempDir})
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+
+	// execute
+	_, err := db.Exec("INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "bogus-uuid", "n1 body", 1542058875)
+
+	// test
+	assert.NotEqual(t, err, nil, "inserting a note with a bogus book_uuid did not fail")
+
+	var count int
+	MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &count)
+	assert.Equal(t, count, 0, "note count mismatch")
+
+	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1542058875)
+}
+
+func TestOpen_pragmas(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", &TestDBOptions{Mode: TestDBModeTempDir})
+	defer TeardownTestDB(t, db)
+
+	// test
+	var journalMode string
+	var busyTimeout, foreignKeys, synchronous int
+	MustScan(t, "getting journal_mode", db.QueryRow("PRAGMA journal_mode"), &journalMode)
+	MustScan(t, "getting busy_timeout", db.QueryRow("PRAGMA busy_timeout"), &busyTimeout)
+	MustScan(t, "getting foreign_keys", db.QueryRow("PRAGMA foreign_keys"), &foreignKeys)
+	MustScan(t, "getting synchronous", db.QueryRow("PRAGMA synchronous"), &synchronous)
+
+	assert.Equal(t, journalMode, "wal", "journal_mode mismatch")
+	assert.Equal(t, busyTimeout, 5000, "busy_timeout mismatch")
+	assert.Equal(t, foreignKeys, 1, "foreign_keys mismatch")
+	// 1 is NORMAL
+	assert.Equal(t, synchronous, 1, "synchronous mismatch")
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", &TestDBOptions{Mode: TestDBModeTempDir})
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+
+	if err := os.Chmod(db.Filepath, 0444); err != nil {
+		t.Fatal(errors.Wrap(err, "making the database file read-only"))
+	}
+
+	// execute
+	reader, err := OpenReadOnly(db.Filepath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening a read-only connection"))
+	}
+	defer reader.Close()
+
+	// test
+	assert.Equal(t, reader.ReadOnly, true, "ReadOnly mismatch")
+
+	var label string
+	MustScan(t, "reading b1", reader.QueryRow("SELECT label FROM books WHERE uuid = ?", "b1-uuid"), &label)
+	assert.Equal(t, label, "b1-label", "label mismatch")
+
+	_, err = reader.Exec("INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "b2-label")
+	assert.Equal(t, IsReadOnlyError(errors.Wrap(err, "inserting b2")), true, "write error mismatch")
+
+	var count int
+	MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &count)
+	assert.Equal(t, count, 1, "book count mismatch")
+}
+
+func TestIsReadOnlyError(t *testing.T) {
+	assert.Equal(t, IsReadOnlyError(nil), false, "nil mismatch")
+	assert.Equal(t, IsReadOnlyError(errors.New("some error")), false, "generic error mismatch")
+	assert.Equal(t, IsReadOnlyError(errors.Wrap(sqlite3.Error{Code: sqlite3.ErrReadonly}, "inserting")), true, "read-only error mismatch")
+	assert.Equal(t, IsReadOnlyError(sqlite3.Error{Code: sqlite3.ErrBusy}), false, "busy error mismatch")
+}
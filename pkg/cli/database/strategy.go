@@ -0,0 +1,91 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// BookStrategy is the merge strategy of a book, which resolves its notes that
+// were changed both locally and on the server. The strategies are local
+// settings and are never synced.
+type BookStrategy struct {
+	BookUUID  string
+	BookLabel string
+	Strategy  string
+}
+
+// SetBookStrategy sets the merge strategy of the book with the given uuid, or
+// removes it if the strategy is empty
+func SetBookStrategy(db *DB, bookUUID, strategy string) error {
+	if strategy == "" {
+		if _, err := db.Exec("DELETE FROM book_strategy WHERE book_uuid = ?", bookUUID); err != nil {
+			return errors.Wrap(err, "removing the merge strategy")
+		}
+
+		return nil
+	}
+
+	_, err := db.Exec(`INSERT INTO book_strategy (book_uuid, strategy) VALUES (?, ?)
+		ON CONFLICT(book_uuid) DO UPDATE SET strategy = excluded.strategy`, bookUUID, strategy)
+	if err != nil {
+		return errors.Wrap(err, "setting the merge strategy")
+	}
+
+	return nil
+}
+
+// GetBookStrategy returns the merge strategy of the book with the given uuid.
+// A book without a strategy has an empty one.
+func GetBookStrategy(db *DB, bookUUID string) (string, error) {
+	var ret string
+	err := db.QueryRow("SELECT strategy FROM book_strategy WHERE book_uuid = ?", bookUUID).Scan(&ret)
+	if err != nil && err != sql.ErrNoRows {
+		return "", errors.Wrap(err, "querying the merge strategy")
+	}
+
+	return ret, nil
+}
+
+// GetBookStrategies returns the merge strategies of the books that are not
+// removed, ordered by the label of the book
+func GetBookStrategies(db *DB) ([]BookStrategy, error) {
+	rows, err := db.Query(`SELECT books.uuid, books.label, book_strategy.strategy
+		FROM book_strategy INNER JOIN books ON books.uuid = book_strategy.book_uuid
+		WHERE NOT books.deleted
+		ORDER BY books.label ASC`)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying the merge strategies")
+	}
+	defer rows.Close()
+
+	ret := []BookStrategy{}
+	for rows.Next() {
+		var s BookStrategy
+		if err := rows.Scan(&s.BookUUID, &s.BookLabel, &s.Strategy); err != nil {
+			return nil, errors.Wrap(err, "scanning a merge strategy")
+		}
+
+		ret = append(ret, s)
+	}
+
+	return ret, rows.Err()
+}
@@ -0,0 +1,84 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func TestBookStrategy(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "css")
+	MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, deleted) VALUES (?, ?, ?)", "b3-uuid", "go", true)
+
+	t.Run("without a strategy", func(t *testing.T) {
+		got, err := GetBookStrategy(db, "b1-uuid")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting the strategy"))
+		}
+
+		assert.Equal(t, got, "", "strategy mismatch")
+	})
+
+	t.Run("set and update", func(t *testing.T) {
+		if err := SetBookStrategy(db, "b1-uuid", "local"); err != nil {
+			t.Fatal(errors.Wrap(err, "setting the strategy"))
+		}
+		if err := SetBookStrategy(db, "b1-uuid", "server"); err != nil {
+			t.Fatal(errors.Wrap(err, "updating the strategy"))
+		}
+		if err := SetBookStrategy(db, "b2-uuid", "newer"); err != nil {
+			t.Fatal(errors.Wrap(err, "setting the strategy"))
+		}
+		if err := SetBookStrategy(db, "b3-uuid", "merge"); err != nil {
+			t.Fatal(errors.Wrap(err, "setting the strategy"))
+		}
+
+		got, err := GetBookStrategy(db, "b1-uuid")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting the strategy"))
+		}
+		assert.Equal(t, got, "server", "strategy mismatch")
+
+		all, err := GetBookStrategies(db)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting the strategies"))
+		}
+		assert.DeepEqual(t, all, []BookStrategy{
+			{BookUUID: "b2-uuid", BookLabel: "css", Strategy: "newer"},
+			{BookUUID: "b1-uuid", BookLabel: "js", Strategy: "server"},
+		}, "strategies mismatch")
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		if err := SetBookStrategy(db, "b2-uuid", ""); err != nil {
+			t.Fatal(errors.Wrap(err, "clearing the strategy"))
+		}
+
+		var count int
+		MustScan(t, "counting strategies", db.QueryRow("SELECT count(*) FROM book_strategy WHERE book_uuid = ?", "b2-uuid"), &count)
+		assert.Equal(t, count, 0, "strategy count mismatch")
+	})
+}
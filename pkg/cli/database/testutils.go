@@ -23,10 +23,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 
 	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 )
 
@@ -45,7 +47,7 @@ CREATE UNIQUE INDEX idx_books_uuid ON books(uuid);
 CREATE TABLE IF NOT EXISTS "notes"
 		(
 			uuid text NOT NULL,
-			book_uuid text NOT NULL,
+			book_uuid text NOT NULL REFERENCES books(uuid) ON UPDATE CASCADE DEFERRABLE INITIALLY DEFERRED,
 			body text NOT NULL,
 			added_on integer NOT NULL,
 			edited_on integer DEFAULT 0,
@@ -53,7 +55,7 @@ CREATE TABLE IF NOT EXISTS "notes"
 			dirty bool DEFAULT false,
 			usn int DEFAULT 0 NOT NULL,
 			deleted bool DEFAULT false
-		);
+		, origin_device text NOT NULL DEFAULT '', position real NOT NULL DEFAULT 0, view_count integer NOT NULL DEFAULT 0, last_viewed_at integer NOT NULL DEFAULT 0);
 CREATE VIRTUAL TABLE note_fts USING fts5(content=notes, body, tokenize="porter unicode61 categories 'L* N* Co Ps Pe'")
 /* note_fts(body) */;
 CREATE TABLE IF NOT EXISTS 'note_fts_data'(id INTEGER PRIMARY KEY, block BLOB);
@@ -71,18 +73,57 @@ CREATE TRIGGER notes_after_update AFTER UPDATE ON notes BEGIN
 				INSERT INTO note_fts(rowid, body) VALUES (new.rowid, new.body);
 			END;
 CREATE TABLE actions
+				(
+					id integer PRIMARY KEY AUTOINCREMENT,
+					timestamp integer NOT NULL,
+					actor text NOT NULL,
+					operation text NOT NULL,
+					note_uuid text NOT NULL DEFAULT '',
+					book_uuid text NOT NULL DEFAULT '',
+					summary text NOT NULL DEFAULT ''
+				, device text NOT NULL DEFAULT '');
+CREATE UNIQUE INDEX idx_notes_uuid ON notes(uuid);
+CREATE INDEX idx_notes_book_uuid_deleted ON notes(book_uuid, deleted, added_on);
+CREATE INDEX idx_notes_dirty ON notes(book_uuid) WHERE dirty;
+CREATE INDEX idx_notes_uuid_usn_dirty ON notes(uuid, usn, dirty);
+CREATE INDEX idx_books_dirty ON books(uuid) WHERE dirty;
+CREATE INDEX idx_actions_timestamp ON actions(timestamp);
+CREATE INDEX idx_actions_note_uuid ON actions(note_uuid);
+CREATE INDEX idx_actions_book_uuid ON actions(book_uuid);
+CREATE INDEX idx_notes_book_uuid_position ON notes(book_uuid, position);
+CREATE TABLE book_retention
 		(
-			uuid text PRIMARY KEY,
-			schema integer NOT NULL,
-			type text NOT NULL,
-			data text NOT NULL,
-			timestamp integer NOT NULL
+			book_uuid text PRIMARY KEY,
+			max_age integer NOT NULL DEFAULT 0,
+			max_count integer NOT NULL DEFAULT 0
+		);;
+CREATE TABLE book_strategy
+		(
+			book_uuid text PRIMARY KEY,
+			strategy text NOT NULL
 		);
-CREATE UNIQUE INDEX idx_notes_uuid ON notes(uuid);
-CREATE INDEX idx_notes_book_uuid ON notes(book_uuid);`
+CREATE TABLE expunged_archive
+		(
+			id integer PRIMARY KEY AUTOINCREMENT,
+			note_uuid text NOT NULL,
+			book_label text NOT NULL,
+			body text NOT NULL,
+			added_on integer NOT NULL,
+			edited_on integer NOT NULL DEFAULT 0,
+			expunged_at integer NOT NULL
+		);
+CREATE TABLE note_shares
+		(
+			id text PRIMARY KEY,
+			note_uuid text NOT NULL,
+			url text NOT NULL,
+			created_at integer NOT NULL DEFAULT 0,
+			expires_at integer NOT NULL DEFAULT 0
+		);
+CREATE INDEX idx_note_shares_note_uuid ON note_shares(note_uuid)`
 
 // MustScan scans the given row and fails a test in case of any errors
-func MustScan(t *testing.T, message string, row *sql.Row, args ...interface{}) {
+func MustScan(t testing.TB, message string, row *sql.Row, args ...interface{}) {
 	err := row.Scan(args...)
 	if err != nil {
 		t.Fatal(errors.Wrap(errors.Wrap(err, "scanning a row"), message))
@@ -90,7 +131,7 @@ func MustScan(t *testing.T, message string, row *sql.Row, args ...interface{}) {
 }
 
 // MustExec executes the given SQL query and fails a test if an error occurs
-func MustExec(t *testing.T, message string, db *DB, query string, args ...interface{}) sql.Result {
+func MustExec(t testing.TB, message string, db *DB, query string, args ...interface{}) sql.Result {
 	result, err := db.Exec(query, args...)
 	if err != nil {
 		t.Fatal(errors.Wrap(errors.Wrap(err, "executing sql"), message))
@@ -99,25 +140,115 @@ func MustExec(t *testing.T, message string, db *DB, query string, args ...interf
 	return result
 }
 
+// TestDBMode is where a test database is stored
+type TestDBMode string
+
+const (
+	// TestDBModeDisk stores the test database at the given path
+	TestDBModeDisk TestDBMode = "disk"
+	// TestDBModeTempDir stores the test database in a temporary directory owned
+	// by the test, so that tests do not share any file and can run in parallel
+	TestDBModeTempDir TestDBMode = "tempdir"
+	// TestDBModeMemory keeps the test database in memory
+	TestDBModeMemory TestDBMode = "memory"
+)
+
+// TestDBModeEnv is the environment variable that sets the mode of the test
+// databases whose options do not specify one, e.g. DNOTE_TEST_DB=memory
+const TestDBModeEnv = "DNOTE_TEST_DB"
+
 // TestDBOptions contains options for test database
 type TestDBOptions struct {
 	SchemaSQLPath string
 	SkipMigration bool
+	// Mode is where the database is stored. If empty, it is read from the
+	// TestDBModeEnv environment variable, and defaults to TestDBModeDisk.
+	// A test that needs to open the database file again should set it to
+	// TestDBModeDisk or TestDBModeTempDir.
+	Mode TestDBMode
 }
 
-// InitTestDB initializes a test database and opens connection to it
-func InitTestDB(t *testing.T, dbPath string, options *TestDBOptions) *DB {
-	db, err := Open(dbPath)
-	if err != nil {
-		t.Fatal(errors.Wrap(err, "opening database connection"))
+func getTestDBMode(t testing.TB, options *TestDBOptions) TestDBMode {
+	var mode TestDBMode
+	if options != nil && options.Mode != "" {
+		mode = options.Mode
+	} else {
+		mode = TestDBMode(os.Getenv(TestDBModeEnv))
 	}
 
-	dir, _ := filepath.Split(dbPath)
-	err = os.MkdirAll(dir, 0777)
+	switch mode {
+	case "":
+		return TestDBModeDisk
+	case TestDBModeDisk, TestDBModeTempDir, TestDBModeMemory:
+		return mode
+	}
+
+	t.Fatalf("unknown test database mode %q", mode)
+	return ""
+}
+
+// memoryDBSeq numbers the in-memory test databases so that each has a unique name
+var memoryDBSeq int64
+
+// memoryDriverName is the name of the driver for the in-memory test databases
+const memoryDriverName = "sqlite3_dnote_test_memory"
+
+func init() {
+	// Connections to a shared cache lock the tables they read. Reading
+	// uncommitted data lets a connection read a table while another connection
+	// writes to it, as the write-ahead log allows for a database on disk.
+	sql.Register(memoryDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec("PRAGMA read_uncommitted = true", nil)
+			return err
+		},
+	})
+}
+
+// openMemoryTestDB opens a new in-memory database. It uses a shared cache so
+// that all connections in the pool see the same database, which lives until
+// the last connection is closed.
+func openMemoryTestDB() (*DB, error) {
+	name := fmt.Sprintf("dnote-test-%d", atomic.AddInt64(&memoryDBSeq, 1))
+
+	dbConn, err := sql.Open(memoryDriverName, fmt.Sprintf("file:%s?mode=memory&cache=shared&_busy_timeout=5000&_foreign_keys=on", name))
 	if err != nil {
+		return nil, errors.Wrap(err, "opening db connection")
+	}
+
+	db := &DB{
+		Conn:     dbConn,
+		Filepath: memoryFilepath,
+	}
+
+	return db, nil
+}
+
+// memoryFilepath is the Filepath of an in-memory test database
+const memoryFilepath = ":memory:"
+
+// InitTestDB initializes a test database and opens connection to it
+func InitTestDB(t testing.TB, dbPath string, options *TestDBOptions) *DB {
+	// The directory is created in every mode because tests put other files in it
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0777); err != nil {
 		t.Fatal(errors.Wrap(err, "creating the directory for test database file"))
 	}
 
+	var db *DB
+	var err error
+
+	switch getTestDBMode(t, options) {
+	case TestDBModeMemory:
+		db, err = openMemoryTestDB()
+	case TestDBModeTempDir:
+		db, err = Open(filepath.Join(t.TempDir(), filepath.Base(dbPath)))
+	default:
+		db, err = Open(dbPath)
+	}
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening database connection"))
+	}
+
 	var schemaSQL string
 	if options != nil && options.SchemaSQLPath != "" {
 		b := utils.ReadFileAbs(options.SchemaSQLPath)
@@ -137,21 +268,29 @@ func InitTestDB(t *testing.T, dbPath string, options *TestDBOptions) *DB {
 	return db
 }
 
-// TeardownTestDB closes the test database and removes the its file
-func TeardownTestDB(t *testing.T, db *DB) {
+// TeardownTestDB closes the test database and removes the its file. The file
+// of a database in a temporary directory is removed along with the directory
+// at the end of the test.
+func TeardownTestDB(t testing.TB, db *DB) {
 	if err := db.Close(); err != nil {
 		t.Fatal(errors.Wrap(err, "closing database"))
 	}
 
-	if err := os.RemoveAll(db.Filepath); err != nil {
-		t.Fatal(errors.Wrap(err, "removing database file"))
+	if db.Filepath == memoryFilepath {
+		return
+	}
+
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.RemoveAll(db.Filepath + suffix); err != nil {
+			t.Fatal(errors.Wrap(err, "removing database file"))
+		}
 	}
 }
 
 // OpenTestDB opens the database connection to a test database
 // without initializing any schema
 func OpenTestDB(t *testing.T, dnoteDir string) *DB {
-	dbPath := fmt.Sprintf("%s/%s/%s", dnoteDir, consts.DnoteDirName, consts.DnoteDBFileName)
+	dbPath := filepath.Join(dnoteDir, consts.DnoteDirName, consts.DnoteDBFileName)
 	db, err := Open(dbPath)
 	if err != nil {
 		t.Fatal(errors.Wrap(err, "opening database connection to the test database"))
@@ -161,8 +300,8 @@ func OpenTestDB(t *testing.T, dnoteDir string) *DB {
 }
 
 // MarkMigrationComplete marks all migrations as complete in the database
-func MarkMigrationComplete(t *testing.T, db *DB) {
-	if _, err := db.Exec("INSERT INTO system (key, value) VALUES (? , ?);", consts.SystemSchema, 12); err != nil {
+func MarkMigrationComplete(t testing.TB, db *DB) {
+	if _, err := db.Exec("INSERT INTO system (key, value) VALUES (? , ?);", consts.SystemSchema, 22); err != nil {
 		t.Fatal(errors.Wrap(err, "inserting schema"))
 	}
 	if _, err := db.Exec("INSERT INTO system (key, value) VALUES (? , ?);", consts.SystemRemoteSchema, 1); err != nil {
@@ -0,0 +1,50 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestInitTestDB_isolated(t *testing.T) {
+	for _, mode := range []TestDBMode{TestDBModeTempDir, TestDBModeMemory} {
+		mode := mode
+
+		t.Run(string(mode), func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+					t.Parallel()
+
+					// every test passes the same path but owns its database
+					db := InitTestDB(t, "../tmp/dnote-test.db", &TestDBOptions{Mode: mode})
+					defer TeardownTestDB(t, db)
+
+					MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+
+					var count int
+					MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &count)
+					assert.Equal(t, count, 1, "book count mismatch")
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+// MinUUIDPrefixLength is the minimum length of a prefix that refers to a uuid
+const MinUUIDPrefixLength = 6
+
+// ShortUUIDLength is the length of the uuid prefixes that are printed
+const ShortUUIDLength = 8
+
+// maxPrefixCandidates is the maximum number of the candidates listed for an
+// ambiguous prefix
+const maxPrefixCandidates = 5
+
+// regexUUIDPrefix matches the characters of a uuid
+var regexUUIDPrefix = regexp.MustCompile(`^[0-9a-fA-F-]+$`)
+
+// AmbiguousPrefixError is an error for a uuid prefix that matches more than
+// one note or book
+type AmbiguousPrefixError struct {
+	Prefix     string
+	Candidates []string
+}
+
+func (e *AmbiguousPrefixError) Error() string {
+	return fmt.Sprintf("ambiguous prefix '%s', candidates: %s", e.Prefix, strings.Join(e.Candidates, ", "))
+}
+
+// ShortUUID returns the prefix of the given uuid that is printed in place of it
+func ShortUUID(uuid string) string {
+	if len(uuid) <= ShortUUIDLength {
+		return uuid
+	}
+
+	return uuid[:ShortUUIDLength]
+}
+
+// IsUUIDPrefix checks if the given string is long enough to refer to a uuid
+// and consists of the characters of a uuid
+func IsUUIDPrefix(s string) bool {
+	return len(s) >= MinUUIDPrefixLength && regexUUIDPrefix.MatchString(s)
+}
+
+// prefixUpperBound returns the smallest string that is greater than every
+// string with the given prefix, so that the prefix can be looked up in the
+// index on the uuid
+func prefixUpperBound(prefix string) string {
+	b := []byte(prefix)
+	b[len(b)-1]++
+
+	return string(b)
+}
+
+// resolveUUIDPrefix returns the uuid in the given table that starts with the
+// prefix. It returns sql.ErrNoRows if none does, and an AmbiguousPrefixError
+// if more than one does. The deleted rows are excluded unless includeDeleted.
+func resolveUUIDPrefix(db *DB, table, prefix string, includeDeleted bool) (string, error) {
+	if !IsUUIDPrefix(prefix) {
+		return "", errors.Errorf("invalid uuid prefix '%s'. It must be at least %d hexadecimal characters", prefix, MinUUIDPrefixLength)
+	}
+
+	prefix = strings.ToLower(prefix)
+
+	query := fmt.Sprintf("SELECT uuid FROM %s WHERE uuid >= ? AND uuid < ?", table)
+	if !includeDeleted {
+		query += " AND deleted = false"
+	}
+	query += " ORDER BY uuid ASC LIMIT ?"
+
+	rows, err := db.Query(query, prefix, prefixUpperBound(prefix), maxPrefixCandidates+1)
+	if err != nil {
+		return "", errors.Wrapf(err, "querying %s by the uuid prefix", table)
+	}
+	defer rows.Close()
+
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return "", errors.Wrap(err, "scanning a row")
+		}
+
+		uuids = append(uuids, uuid)
+	}
+	if err := rows.Err(); err != nil {
+		return "", errors.Wrap(err, "iterating rows")
+	}
+
+	switch len(uuids) {
+	case 0:
+		return "", sql.ErrNoRows
+	case 1:
+		return uuids[0], nil
+	}
+
+	candidates := uuids
+	if len(candidates) > maxPrefixCandidates {
+		candidates = append(candidates[:maxPrefixCandidates:maxPrefixCandidates], "...")
+	}
+
+	return "", &AmbiguousPrefixError{Prefix: prefix, Candidates: candidates}
+}
+
+// ResolveNoteUUID returns the uuid of the note that starts with the given
+// prefix. It returns sql.ErrNoRows if no note does, and an AmbiguousPrefixError
+// if more than one does. The deleted notes are excluded unless includeDeleted.
+func ResolveNoteUUID(db *DB, prefix string, includeDeleted bool) (string, error) {
+	return resolveUUIDPrefix(db, "notes", prefix, includeDeleted)
+}
+
+// ResolveBookUUID returns the uuid of the book that starts with the given
+// prefix, in the same way as ResolveNoteUUID
+func ResolveBookUUID(db *DB, prefix string, includeDeleted bool) (string, error) {
+	return resolveUUIDPrefix(db, "books", prefix, includeDeleted)
+}
+
+// ResolveNoteRef returns the rowid of the note that the given argument refers
+// to, which is either the index of the note or a prefix of its uuid. An index
+// is read as a prefix only if no note has the index. The boolean result is
+// false if the argument is neither an index nor a prefix of the uuid of a
+// note, and is true for an index that no note has so that the caller reports
+// it. The deleted notes are excluded unless includeDeleted.
+func ResolveNoteRef(db *DB, ref string, includeDeleted bool) (int, bool, error) {
+	if utils.IsNumber(ref) {
+		index, err := strconv.Atoi(ref)
+		if err != nil {
+			return 0, false, errors.Wrap(err, "invalid index")
+		}
+
+		query := "SELECT count(*) FROM notes WHERE rowid = ?"
+		if !includeDeleted {
+			query += " AND deleted = false"
+		}
+
+		var count int
+		if err := db.QueryRow(query, index).Scan(&count); err != nil {
+			return 0, false, errors.Wrap(err, "counting the notes with the index")
+		}
+		if count > 0 || !IsUUIDPrefix(ref) {
+			return index, true, nil
+		}
+
+		rowID, found, err := resolveNoteRowID(db, ref, includeDeleted)
+		if err != nil {
+			return 0, false, err
+		}
+		if found {
+			return rowID, true, nil
+		}
+
+		// the caller reports that no note has the index
+		return index, true, nil
+	}
+
+	if !IsUUIDPrefix(ref) {
+		return 0, false, nil
+	}
+
+	return resolveNoteRowID(db, ref, includeDeleted)
+}
+
+// resolveNoteRowID returns the rowid of the note whose uuid starts with the
+// given prefix
+func resolveNoteRowID(db *DB, prefix string, includeDeleted bool) (int, bool, error) {
+	uuid, err := ResolveNoteUUID(db, prefix, includeDeleted)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	var rowID int
+	if err := db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", uuid).Scan(&rowID); err != nil {
+		return 0, false, errors.Wrap(err, "querying the note")
+	}
+
+	return rowID, true, nil
+}
@@ -0,0 +1,200 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func TestIsUUIDPrefix(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected bool
+	}{
+		{"3a7c1d", true},
+		{"3A7C1D2E-0B4F", true},
+		{"3a7c1d2e-0b4f-4c8a-9e2d-5f6a7b8c9d0e", true},
+		{"3a7c1", false},
+		{"3a7c1g", false},
+		{"javascript", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, IsUUIDPrefix(tc.input), tc.expected, "result mismatch for "+tc.input)
+	}
+}
+
+func TestShortUUID(t *testing.T) {
+	assert.Equal(t, ShortUUID("3a7c1d2e-0b4f-4c8a-9e2d-5f6a7b8c9d0e"), "3a7c1d2e", "short uuid mismatch")
+	assert.Equal(t, ShortUUID("3a7c"), "3a7c", "short input mismatch")
+}
+
+// setupPrefixNotes inserts notes whose uuids share prefixes
+func setupPrefixNotes(t *testing.T, db *DB) {
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1a2c3d4-0000-4000-8000-000000000000", "js")
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, deleted) VALUES (?, ?, ?)", "b1a2c3ff-0000-4000-8000-000000000000", "css", true)
+
+	notes := []struct {
+		uuid    string
+		deleted bool
+	}{
+		{"3a7c1d2e-0000-4000-8000-000000000001", false},
+		{"3a7c1d2f-0000-4000-8000-000000000002", false},
+		{"3a7c1e00-0000-4000-8000-000000000003", false},
+		{"4b8d2e3f-0000-4000-8000-000000000004", true},
+		{"123456ab-0000-4000-8000-000000000005", false},
+	}
+	for _, n := range notes {
+		MustExec(t, "inserting a note", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, deleted) VALUES (?, ?, ?, ?, ?)",
+			n.uuid, "b1a2c3d4-0000-4000-8000-000000000000", "body", 1, n.deleted)
+	}
+}
+
+func TestResolveNoteUUID(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	setupPrefixNotes(t, db)
+
+	t.Run("unique", func(t *testing.T) {
+		got, err := ResolveNoteUUID(db, "3a7c1e", false)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, got, "3a7c1e00-0000-4000-8000-000000000003", "uuid mismatch")
+	})
+
+	t.Run("uppercase", func(t *testing.T) {
+		got, err := ResolveNoteUUID(db, "3A7C1D2F", false)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, got, "3a7c1d2f-0000-4000-8000-000000000002", "uuid mismatch")
+	})
+
+	t.Run("ambiguous", func(t *testing.T) {
+		_, err := ResolveNoteUUID(db, "3a7c1d", false)
+
+		ambiguous, ok := err.(*AmbiguousPrefixError)
+		if !ok {
+			t.Fatalf("expected an AmbiguousPrefixError but got %v", err)
+		}
+		assert.DeepEqual(t, ambiguous.Candidates, []string{
+			"3a7c1d2e-0000-4000-8000-000000000001",
+			"3a7c1d2f-0000-4000-8000-000000000002",
+		}, "candidates mismatch")
+		assert.Equal(t, err.Error(), "ambiguous prefix '3a7c1d', candidates: 3a7c1d2e-0000-4000-8000-000000000001, 3a7c1d2f-0000-4000-8000-000000000002", "message mismatch")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := ResolveNoteUUID(db, "ffffff", false)
+		assert.Equal(t, err, sql.ErrNoRows, "error mismatch")
+	})
+
+	t.Run("non-hex", func(t *testing.T) {
+		if _, err := ResolveNoteUUID(db, "3a7c1z", false); err == nil {
+			t.Error("expected an error for a prefix that is not hexadecimal")
+		}
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		if _, err := ResolveNoteUUID(db, "3a7c1", false); err == nil {
+			t.Error("expected an error for a prefix that is too short")
+		}
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		_, err := ResolveNoteUUID(db, "4b8d2e", false)
+		assert.Equal(t, err, sql.ErrNoRows, "error mismatch")
+
+		got, err := ResolveNoteUUID(db, "4b8d2e", true)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing with the deleted notes"))
+		}
+		assert.Equal(t, got, "4b8d2e3f-0000-4000-8000-000000000004", "uuid mismatch")
+	})
+}
+
+func TestResolveBookUUID(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	setupPrefixNotes(t, db)
+
+	got, err := ResolveBookUUID(db, "b1a2c3", false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+	assert.Equal(t, got, "b1a2c3d4-0000-4000-8000-000000000000", "uuid mismatch")
+
+	_, err = ResolveBookUUID(db, "b1a2c3", true)
+	if _, ok := err.(*AmbiguousPrefixError); !ok {
+		t.Errorf("expected an AmbiguousPrefixError with the deleted books but got %v", err)
+	}
+}
+
+func TestResolveNoteRef(t *testing.T) {
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer TeardownTestDB(t, db)
+
+	setupPrefixNotes(t, db)
+
+	testCases := []struct {
+		name           string
+		ref            string
+		includeDeleted bool
+		rowID          int
+		ok             bool
+	}{
+		{name: "index", ref: "2", rowID: 2, ok: true},
+		{name: "prefix", ref: "3a7c1e", rowID: 3, ok: true},
+		{name: "numeric prefix", ref: "123456", rowID: 5, ok: true},
+		{name: "missing index", ref: "99", rowID: 99, ok: true},
+		{name: "deleted index", ref: "4", rowID: 4, ok: true},
+		{name: "deleted prefix", ref: "4b8d2e", ok: false},
+		{name: "deleted prefix included", ref: "4b8d2e", includeDeleted: true, rowID: 4, ok: true},
+		{name: "unknown prefix", ref: "ffffff", ok: false},
+		{name: "book name", ref: "javascript", ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rowID, ok, err := ResolveNoteRef(db, tc.ref, tc.includeDeleted)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.Equal(t, ok, tc.ok, "ok mismatch")
+			assert.Equal(t, rowID, tc.rowID, "rowid mismatch")
+		})
+	}
+
+	t.Run("ambiguous", func(t *testing.T) {
+		if _, _, err := ResolveNoteRef(db, "3a7c1d", false); err == nil {
+			t.Error("expected an error for an ambiguous prefix")
+		}
+	})
+}
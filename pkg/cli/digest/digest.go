@@ -0,0 +1,276 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package digest builds the summaries of the recent and resurfaced notes
+package digest
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// Options is the options for building a digest
+type Options struct {
+	// Days is the number of days, up to now, whose added notes are listed
+	Days int
+	// Review is the number of the older notes to select for review
+	Review int
+	// Seed seeds the selection of the notes for review
+	Seed int64
+}
+
+// Note is a note in a digest
+type Note struct {
+	UUID    string
+	Book    string
+	Title   string
+	AddedOn time.Time
+}
+
+// Book is a book and its notes added in the window of a digest
+type Book struct {
+	Label string
+	Notes []Note
+}
+
+// Stats is the statistics of the notes in a digest
+type Stats struct {
+	// TotalNotes is the number of all notes
+	TotalNotes int
+	// TotalBooks is the number of the books with notes
+	TotalBooks int
+	// Added is the number of the notes added in the window
+	Added int
+	// ActiveDays is the number of the days in the window with an added note
+	ActiveDays int
+	// Streak is the number of the consecutive days with an added note, up
+	// to today, or to yesterday if none has been added today yet
+	Streak int
+}
+
+// Digest is a summary of the recent and resurfaced notes
+type Digest struct {
+	// Days is the number of the days in the window
+	Days   int
+	Since  time.Time
+	Until  time.Time
+	Added  []Book
+	Review []Note
+	Stats  Stats
+}
+
+// noteRow is a note read from the database
+type noteRow struct {
+	uuid     string
+	book     string
+	body     string
+	addedOn  int64
+	editedOn int64
+}
+
+// title returns the first non-empty line of a note body without the
+// Markdown heading marker
+func title(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+		if line != "" {
+			return line
+		}
+	}
+
+	return "Untitled"
+}
+
+func (r noteRow) note(loc *time.Location) Note {
+	return Note{
+		UUID:    r.uuid,
+		Book:    r.book,
+		Title:   title(r.body),
+		AddedOn: time.Unix(0, r.addedOn).In(loc),
+	}
+}
+
+func getNoteRows(db *database.DB) ([]noteRow, error) {
+	rows, err := db.Query(`SELECT notes.uuid, books.label, notes.body, notes.added_on, notes.edited_on
+	FROM notes
+	INNER JOIN books ON books.uuid = notes.book_uuid
+	WHERE notes.deleted = ?
+	ORDER BY notes.added_on ASC, notes.rowid ASC`, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	ret := []noteRow{}
+	for rows.Next() {
+		var r noteRow
+		if err := rows.Scan(&r.uuid, &r.book, &r.body, &r.addedOn, &r.editedOn); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		ret = append(ret, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating rows")
+	}
+
+	return ret, nil
+}
+
+// dayKey returns the calendar day of the timestamp in the location
+func dayKey(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// streak returns the number of the consecutive days with an added note up
+// to today, or to yesterday if there is none today
+func streak(days map[string]bool, now time.Time) int {
+	day := now
+	if !days[dayKey(day)] {
+		day = day.AddDate(0, 0, -1)
+	}
+
+	ret := 0
+	for days[dayKey(day)] {
+		ret++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	return ret
+}
+
+// selectReview selects up to n of the candidates at random, weighting each
+// note by the number of days since its book last had a note added or edited,
+// so that the notes in the neglected books resurface more often.
+func selectReview(candidates []noteRow, lastActive map[string]int64, now time.Time, n int, seed int64) []noteRow {
+	weights := make([]float64, len(candidates))
+	for i, c := range candidates {
+		idle := now.Sub(time.Unix(0, lastActive[c.book])).Hours() / 24
+		if idle < 0 {
+			idle = 0
+		}
+
+		weights[i] = idle + 1
+	}
+
+	rnd := rand.New(rand.NewSource(seed))
+	pool := append([]noteRow{}, candidates...)
+
+	ret := []noteRow{}
+	for len(ret) < n && len(pool) > 0 {
+		total := 0.0
+		for _, w := range weights {
+			total += w
+		}
+
+		pick := len(pool) - 1
+		x := rnd.Float64() * total
+		for i, w := range weights {
+			if x < w {
+				pick = i
+				break
+			}
+			x -= w
+		}
+
+		ret = append(ret, pool[pick])
+		pool = append(pool[:pick], pool[pick+1:]...)
+		weights = append(weights[:pick], weights[pick+1:]...)
+	}
+
+	return ret
+}
+
+// Build builds the digest of the notes as of now. The days are the calendar
+// days in the location of now. The same options and notes build the same
+// digest, so that the selection for review can be reproduced.
+func Build(db *database.DB, now time.Time, opts Options) (Digest, error) {
+	if opts.Days < 1 {
+		return Digest{}, errors.New("the number of days must be positive")
+	}
+
+	loc := now.Location()
+	y, m, d := now.Date()
+	since := time.Date(y, m, d, 0, 0, 0, 0, loc).AddDate(0, 0, 1-opts.Days)
+
+	rows, err := getNoteRows(db)
+	if err != nil {
+		return Digest{}, errors.Wrap(err, "getting the notes")
+	}
+
+	ret := Digest{Days: opts.Days, Since: since, Until: now}
+
+	books := map[string]*Book{}
+	lastActive := map[string]int64{}
+	days := map[string]bool{}
+	activeDays := map[string]bool{}
+	candidates := []noteRow{}
+
+	for _, r := range rows {
+		addedOn := time.Unix(0, r.addedOn).In(loc)
+		days[dayKey(addedOn)] = true
+
+		if r.addedOn > lastActive[r.book] {
+			lastActive[r.book] = r.addedOn
+		}
+		if r.editedOn > lastActive[r.book] {
+			lastActive[r.book] = r.editedOn
+		}
+
+		if addedOn.Before(since) {
+			candidates = append(candidates, r)
+			continue
+		}
+		if addedOn.After(now) {
+			continue
+		}
+
+		b, ok := books[r.book]
+		if !ok {
+			b = &Book{Label: r.book}
+			books[r.book] = b
+		}
+		b.Notes = append(b.Notes, r.note(loc))
+
+		activeDays[dayKey(addedOn)] = true
+		ret.Stats.Added++
+	}
+
+	for _, b := range books {
+		ret.Added = append(ret.Added, *b)
+	}
+	sort.Slice(ret.Added, func(i, j int) bool {
+		return ret.Added[i].Label < ret.Added[j].Label
+	})
+
+	for _, r := range selectReview(candidates, lastActive, now, opts.Review, opts.Seed) {
+		ret.Review = append(ret.Review, r.note(loc))
+	}
+
+	ret.Stats.TotalNotes = len(rows)
+	ret.Stats.TotalBooks = len(lastActive)
+	ret.Stats.ActiveDays = len(activeDays)
+	ret.Stats.Streak = streak(days, now)
+
+	return ret, nil
+}
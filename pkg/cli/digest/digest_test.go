@@ -0,0 +1,201 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package digest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// now is the time that the digests in the tests are built at
+var now = time.Date(2021, time.March, 15, 18, 0, 0, 0, time.UTC)
+
+func daysAgo(n int) int64 {
+	return now.AddDate(0, 0, -n).UnixNano()
+}
+
+func setupFixture(t *testing.T, db *database.DB) {
+	f := testutils.NewFixture(t, db)
+
+	js := f.Book("js")
+	css := f.Book("css")
+	f.Book("empty")
+	old := f.Book("old")
+
+	f.Note(js, "# Closures\ncapture the scope", testutils.With.UUID("n1"), testutils.With.AddedOn(daysAgo(0)))
+	f.Note(js, "Hoisting", testutils.With.UUID("n2"), testutils.With.AddedOn(daysAgo(1)))
+	f.Note(css, "flexbox & grid", testutils.With.UUID("n3"), testutils.With.AddedOn(daysAgo(3)))
+	f.Note(js, "Promises", testutils.With.UUID("n4"), testutils.With.AddedOn(daysAgo(30)), testutils.With.EditedOn(daysAgo(2)))
+	f.Note(old, "<b>bold</b> idea", testutils.With.UUID("n5"), testutils.With.AddedOn(daysAgo(400)))
+	f.Note(old, "another idea", testutils.With.UUID("n6"), testutils.With.AddedOn(daysAgo(300)))
+	f.Note(js, "removed", testutils.With.UUID("n7"), testutils.With.AddedOn(daysAgo(0)), testutils.With.Deleted())
+}
+
+func TestBuild(t *testing.T) {
+	db := database.InitTestDB(t, "dnote.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(t, db)
+
+	setupFixture(t, db)
+
+	d, err := Build(db, now, Options{Days: 7, Review: 2, Seed: 1})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "building"))
+	}
+
+	assert.Equal(t, d.Since, time.Date(2021, time.March, 9, 0, 0, 0, 0, time.UTC), "since mismatch")
+	assert.Equal(t, len(d.Added), 2, "book count mismatch")
+	assert.Equal(t, d.Added[0].Label, "css", "book 0 mismatch")
+	assert.Equal(t, len(d.Added[0].Notes), 1, "book 0 note count mismatch")
+	assert.Equal(t, d.Added[1].Label, "js", "book 1 mismatch")
+	assert.Equal(t, len(d.Added[1].Notes), 2, "book 1 note count mismatch")
+	assert.Equal(t, d.Added[1].Notes[0].Title, "Hoisting", "note title mismatch")
+	assert.Equal(t, d.Added[1].Notes[1].Title, "Closures", "heading title mismatch")
+
+	assert.Equal(t, len(d.Review), 2, "review count mismatch")
+	for _, n := range d.Review {
+		assert.Equal(t, n.AddedOn.Before(d.Since), true, "a recent note is selected for review")
+	}
+
+	assert.Equal(t, d.Stats, Stats{
+		TotalNotes: 6,
+		TotalBooks: 3,
+		Added:      3,
+		ActiveDays: 3,
+		Streak:     2,
+	}, "stats mismatch")
+
+	t.Run("same seed", func(t *testing.T) {
+		again, err := Build(db, now, Options{Days: 7, Review: 2, Seed: 1})
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "building"))
+		}
+
+		assert.DeepEqual(t, again, d, "digest mismatch")
+	})
+
+	t.Run("more review than candidates", func(t *testing.T) {
+		d, err := Build(db, now, Options{Days: 1, Review: 10, Seed: 1})
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "building"))
+		}
+
+		assert.Equal(t, len(d.Review), 5, "review count mismatch")
+		assert.Equal(t, d.Stats.Added, 1, "added mismatch")
+	})
+
+	t.Run("invalid days", func(t *testing.T) {
+		_, err := Build(db, now, Options{Days: 0})
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+	})
+}
+
+func TestStreak(t *testing.T) {
+	testCases := []struct {
+		name     string
+		days     []string
+		expected int
+	}{
+		{name: "none", days: []string{}, expected: 0},
+		{name: "today", days: []string{"2021-03-15", "2021-03-14", "2021-03-12"}, expected: 2},
+		{name: "until yesterday", days: []string{"2021-03-14", "2021-03-13"}, expected: 2},
+		{name: "broken", days: []string{"2021-03-13", "2021-03-12"}, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			days := map[string]bool{}
+			for _, d := range tc.days {
+				days[d] = true
+			}
+
+			assert.Equal(t, streak(days, now), tc.expected, "streak mismatch")
+		})
+	}
+}
+
+func TestSelectReview(t *testing.T) {
+	candidates := []noteRow{
+		{uuid: "active", book: "active"},
+		{uuid: "neglected", book: "neglected"},
+	}
+	lastActive := map[string]int64{
+		"active":    daysAgo(0),
+		"neglected": daysAgo(99),
+	}
+
+	count := 0
+	for seed := int64(0); seed < 1000; seed++ {
+		selected := selectReview(candidates, lastActive, now, 1, seed)
+		if selected[0].uuid == "neglected" {
+			count++
+		}
+	}
+
+	// the note in the neglected book weighs 100 times the other
+	if count < 950 {
+		t.Errorf("the note in the neglected book was selected %d times out of 1000", count)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	db := database.InitTestDB(t, "dnote.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(t, db)
+
+	setupFixture(t, db)
+
+	d, err := Build(db, now, Options{Days: 7, Review: 2, Seed: 1})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "building"))
+	}
+
+	empty, err := Build(db, now.AddDate(1, 0, 0), Options{Days: 1, Review: 0, Seed: 1})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "building the empty digest"))
+	}
+
+	for _, format := range []string{FormatText, FormatMarkdown, FormatHTML} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, d, format); err != nil {
+				t.Fatal(errors.Wrap(err, "writing"))
+			}
+			testutils.AssertGolden(t, buf.Bytes(), "digest."+format)
+
+			buf.Reset()
+			if err := Write(&buf, empty, format); err != nil {
+				t.Fatal(errors.Wrap(err, "writing the empty digest"))
+			}
+			testutils.AssertGolden(t, buf.Bytes(), "empty."+format)
+		})
+	}
+
+	t.Run("unknown format", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := Write(&buf, d, "pdf")
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+	})
+}
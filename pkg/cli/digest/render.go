@@ -0,0 +1,153 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package digest
+
+import (
+	htmlTemplate "html/template"
+	"io"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Formats are the formats that a digest can be rendered in
+const (
+	FormatText     = "text"
+	FormatMarkdown = "md"
+	FormatHTML     = "html"
+)
+
+// dateFormat is the format of the dates in a digest
+const dateFormat = "2006-01-02"
+
+var funcs = map[string]interface{}{
+	"date": func(t time.Time) string {
+		return t.Format(dateFormat)
+	},
+	"plural": func(n int, word string) string {
+		if n == 1 {
+			return word
+		}
+
+		return word + "s"
+	},
+}
+
+var textDigestTemplate = template.Must(template.New("text").Funcs(funcs).Parse(`Digest for {{date .Since}} to {{date .Until}}
+
+Added
+{{- range .Added}}
+  {{.Label}}
+{{- range .Notes}}
+    - {{.Title}} ({{date .AddedOn}})
+{{- end}}
+{{- else}}
+  No notes were added.
+{{- end}}
+
+Review
+{{- range .Review}}
+  - [{{.Book}}] {{.Title}} (added {{date .AddedOn}})
+{{- else}}
+  No older notes to review.
+{{- end}}
+
+Stats
+  notes: {{.Stats.TotalNotes}} in {{.Stats.TotalBooks}} {{plural .Stats.TotalBooks "book"}}
+  added: {{.Stats.Added}} on {{.Stats.ActiveDays}} of {{.Days}} {{plural .Days "day"}}
+  streak: {{.Stats.Streak}} {{plural .Stats.Streak "day"}}
+`))
+
+var markdownDigestTemplate = template.Must(template.New("md").Funcs(funcs).Parse(`# Dnote digest: {{date .Since}} to {{date .Until}}
+
+## Added
+{{range .Added}}
+### {{.Label}}
+{{range .Notes}}
+- {{.Title}} ({{date .AddedOn}})
+{{- end}}
+{{else}}
+No notes were added.
+{{end}}
+## Review
+{{if .Review}}
+{{range .Review -}}
+- **{{.Book}}**: {{.Title}} (added {{date .AddedOn}})
+{{end}}
+{{- else}}
+No older notes to review.
+{{end}}
+## Stats
+
+- Notes: {{.Stats.TotalNotes}} in {{.Stats.TotalBooks}} {{plural .Stats.TotalBooks "book"}}
+- Added: {{.Stats.Added}} on {{.Stats.ActiveDays}} of {{.Days}} {{plural .Days "day"}}
+- Streak: {{.Stats.Streak}} {{plural .Stats.Streak "day"}}
+`))
+
+var htmlDigestTemplate = htmlTemplate.Must(htmlTemplate.New("html").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Dnote digest</title>
+</head>
+<body>
+<h1>Dnote digest: {{date .Since}} to {{date .Until}}</h1>
+<h2>Added</h2>
+{{range .Added}}<h3>{{.Label}}</h3>
+<ul>
+{{range .Notes}}<li>{{.Title}} ({{date .AddedOn}})</li>
+{{end}}</ul>
+{{else}}<p>No notes were added.</p>
+{{end}}<h2>Review</h2>
+{{if .Review}}<ul>
+{{range .Review}}<li><strong>{{.Book}}</strong>: {{.Title}} (added {{date .AddedOn}})</li>
+{{end}}</ul>
+{{else}}<p>No older notes to review.</p>
+{{end}}<h2>Stats</h2>
+<ul>
+<li>Notes: {{.Stats.TotalNotes}} in {{.Stats.TotalBooks}} {{plural .Stats.TotalBooks "book"}}</li>
+<li>Added: {{.Stats.Added}} on {{.Stats.ActiveDays}} of {{.Days}} {{plural .Days "day"}}</li>
+<li>Streak: {{.Stats.Streak}} {{plural .Stats.Streak "day"}}</li>
+</ul>
+</body>
+</html>
+`))
+
+// Write renders the digest in the format
+func Write(w io.Writer, d Digest, format string) error {
+	var err error
+
+	switch format {
+	case FormatText:
+		err = textDigestTemplate.Execute(w, d)
+	case FormatMarkdown:
+		err = markdownDigestTemplate.Execute(w, d)
+	case FormatHTML:
+		err = htmlDigestTemplate.Execute(w, d)
+	default:
+		return errors.Errorf("unknown format '%s'. Use text, md or html", format)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "rendering the digest")
+	}
+
+	return nil
+}
@@ -49,13 +49,21 @@ func Reload() {
 	initDirs()
 }
 
+// getHomeDir returns the home directory of the user. It falls back to the
+// environment, which is $HOME on Unix and %USERPROFILE% on Windows, if the
+// user cannot be looked up, e.g. in a binary built without cgo.
 func getHomeDir() string {
 	usr, err := user.Current()
-	if err != nil {
+	if err == nil {
+		return usr.HomeDir
+	}
+
+	home, envErr := os.UserHomeDir()
+	if envErr != nil {
 		panic(errors.Wrap(err, "getting home dir"))
 	}
 
-	return usr.HomeDir
+	return home
 }
 
 func readPath(envName, defaultPath string) string {
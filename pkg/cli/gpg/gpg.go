@@ -0,0 +1,86 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package gpg encrypts and decrypts files with the gpg program
+package gpg
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Ext is the extension of an encrypted file
+const Ext = ".gpg"
+
+// ErrNotFound is an error for gpg not being on the PATH
+var ErrNotFound = errors.New("gpg is not found on the PATH. Install GnuPG to encrypt or decrypt files")
+
+// program is the name of the gpg program
+var program = "gpg"
+
+// HasExt checks if the path has the extension of an encrypted file
+func HasExt(path string) bool {
+	return strings.HasSuffix(path, Ext)
+}
+
+// run runs gpg with the arguments, reading from r and writing to w. The
+// error includes what gpg printed to the standard error.
+func run(r io.Reader, w io.Writer, args ...string) error {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, append([]string{"--batch", "--no-tty", "--quiet"}, args...)...)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.Errorf("running gpg: %s", msg)
+		}
+
+		return errors.Wrap(err, "running gpg")
+	}
+
+	return nil
+}
+
+// Encrypt encrypts what is read from r to the recipient, which is a key ID,
+// a fingerprint or an email address of a public key in the keyring, and
+// writes the result to w
+func Encrypt(r io.Reader, w io.Writer, recipient string) error {
+	if recipient == "" {
+		return errors.New("no recipient")
+	}
+
+	return run(r, w, "--encrypt", "--recipient", recipient, "--output", "-")
+}
+
+// Decrypt decrypts what is read from r with a secret key in the keyring,
+// and writes the result to w. gpg asks for the passphrase of the key through
+// its agent if needed.
+func Decrypt(r io.Reader, w io.Writer) error {
+	return run(r, w, "--decrypt", "--output", "-")
+}
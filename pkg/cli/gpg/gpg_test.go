@@ -0,0 +1,70 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package gpg
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	testutils.SetupGPG(t)
+
+	plaintext := []byte("dnote backup\x00\xff")
+
+	var encrypted bytes.Buffer
+	if err := Encrypt(bytes.NewReader(plaintext), &encrypted, testutils.GPGRecipient); err != nil {
+		t.Fatal(errors.Wrap(err, "encrypting"))
+	}
+	assert.Equal(t, bytes.Contains(encrypted.Bytes(), plaintext), false, "the plaintext is not encrypted")
+
+	var decrypted bytes.Buffer
+	if err := Decrypt(&encrypted, &decrypted); err != nil {
+		t.Fatal(errors.Wrap(err, "decrypting"))
+	}
+	assert.DeepEqual(t, decrypted.Bytes(), plaintext, "decrypted mismatch")
+
+	t.Run("unknown recipient", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := Encrypt(bytes.NewReader(plaintext), &buf, "nobody@example.com")
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+	})
+
+	t.Run("not encrypted", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := Decrypt(bytes.NewReader(plaintext), &buf)
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+	})
+}
+
+func TestNotFound(t *testing.T) {
+	program = "dnote-nonexistent-gpg"
+	defer func() { program = "gpg" }()
+
+	var buf bytes.Buffer
+	err := Encrypt(bytes.NewReader(nil), &buf, testutils.GPGRecipient)
+
+	assert.Equal(t, err, ErrNotFound, "error mismatch")
+}
@@ -0,0 +1,228 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package hooks notifies the commands and URLs configured by the user of the
+// local changes
+package hooks
+
+import (
+	"bytes"
+	stdCtx "context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// events
+const (
+	EventNoteAdded    = "note.added"
+	EventNoteEdited   = "note.edited"
+	EventSyncComplete = "sync.complete"
+)
+
+// SignatureHeader is the header of the HMAC-SHA256 signature of the payload
+// posted to a URL, in the form of sha256=<hex digest>
+const SignatureHeader = "X-Dnote-Signature"
+
+// EventHeader is the header of the event posted to a URL
+const EventHeader = "X-Dnote-Event"
+
+// timeouts of a hook. A hook runs after the operation is complete, and the
+// command waits for it before exiting, so it must be short.
+var (
+	httpTimeout    = 5 * time.Second
+	commandTimeout = 10 * time.Second
+)
+
+// Config holds the hooks for the events. Each hook is either an HTTP URL,
+// to which the payload is posted, or a shell command, to whose standard input
+// the payload is written.
+type Config struct {
+	OnNoteAdded    string `yaml:"onNoteAdded,omitempty"`
+	OnNoteEdited   string `yaml:"onNoteEdited,omitempty"`
+	OnSyncComplete string `yaml:"onSyncComplete,omitempty"`
+	// Secret is the key with which the payloads posted to the URLs are signed.
+	// They are not signed if empty.
+	Secret string `yaml:"secret,omitempty"`
+}
+
+// Note is a note in a payload
+type Note struct {
+	UUID     string `json:"uuid"`
+	Book     string `json:"book"`
+	Body     string `json:"body"`
+	AddedOn  int64  `json:"added_on"`
+	EditedOn int64  `json:"edited_on"`
+}
+
+// NewNote returns a note in a payload from its information
+func NewNote(info database.NoteInfo) Note {
+	return Note{
+		UUID:     info.UUID,
+		Book:     info.BookLabel,
+		Body:     info.Content,
+		AddedOn:  info.AddedOn,
+		EditedOn: info.EditedOn,
+	}
+}
+
+// SyncSummary is the summary of a sync in a payload
+type SyncSummary struct {
+	Backend string `json:"backend"`
+	// UploadedNotes and UploadedBooks are the numbers of the notes and the
+	// books that had local changes to send
+	UploadedNotes int `json:"uploaded_notes"`
+	UploadedBooks int `json:"uploaded_books"`
+	// Notes and Books are the numbers of the notes and the books after the sync
+	Notes int `json:"notes"`
+	Books int `json:"books"`
+	// Duration is the time the sync took in milliseconds
+	Duration int64 `json:"duration_ms"`
+}
+
+// payload is what a hook receives
+type payload struct {
+	Event string       `json:"event"`
+	Note  *Note        `json:"note,omitempty"`
+	Sync  *SyncSummary `json:"sync,omitempty"`
+}
+
+// NoteAdded runs the hook for an added note, if any
+func (c Config) NoteAdded(n Note) {
+	c.fire(c.OnNoteAdded, payload{Event: EventNoteAdded, Note: &n})
+}
+
+// NoteEdited runs the hook for an edited note, if any
+func (c Config) NoteEdited(n Note) {
+	c.fire(c.OnNoteEdited, payload{Event: EventNoteEdited, Note: &n})
+}
+
+// SyncComplete runs the hook for a completed sync, if any
+func (c Config) SyncComplete(s SyncSummary) {
+	c.fire(c.OnSyncComplete, payload{Event: EventSyncComplete, Sync: &s})
+}
+
+// fire runs the hook with the payload. A failure is logged rather than
+// returned, because the operation that triggered the event has succeeded.
+func (c Config) fire(hook string, p payload) {
+	if hook == "" {
+		return
+	}
+
+	if err := c.run(hook, p); err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "running the %s hook", p.Event).Error())
+	}
+}
+
+// isURL checks if the hook is a URL rather than a command
+func isURL(hook string) bool {
+	return strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://")
+}
+
+func (c Config) run(hook string, p payload) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "marshalling the payload")
+	}
+
+	if isURL(hook) {
+		return c.post(hook, p.Event, b)
+	}
+
+	return runCommand(hook, p.Event, b)
+}
+
+// sign returns the signature of the payload
+func sign(secret string, b []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(b)
+
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+func (c Config) post(u, event string, b []byte) error {
+	ctx, cancel := stdCtx.WithTimeout(stdCtx.Background(), httpTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "constructing the request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	if c.Secret != "" {
+		req.Header.Set(SignatureHeader, sign(c.Secret, b))
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "posting the payload")
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+
+	if res.StatusCode >= 300 {
+		return errors.Errorf("the hook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// shellCommand returns a command that runs the given command line in the shell
+func shellCommand(ctx stdCtx.Context, line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", line)
+	}
+
+	return exec.CommandContext(ctx, "sh", "-c", line)
+}
+
+func runCommand(line, event string, b []byte) error {
+	ctx, cancel := stdCtx.WithTimeout(stdCtx.Background(), commandTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+
+	cmd := shellCommand(ctx, line)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("DNOTE_EVENT=%s", event))
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return errors.Errorf("the command did not finish in %s", commandTimeout)
+		}
+
+		return errors.Wrapf(err, "running the command: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+var testNote = Note{
+	UUID:     "n1-uuid",
+	Book:     "js",
+	Body:     "arrow functions\n\"quoted\"",
+	AddedOn:  1542058875,
+	EditedOn: 1542058876,
+}
+
+// receivedRequest is a request received by a test receiver
+type receivedRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// newReceiver returns a server that responds with the given status and sends
+// the requests it receives to the channel
+func newReceiver(t *testing.T, status int) (*httptest.Server, chan receivedRequest) {
+	ch := make(chan receivedRequest, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(errors.Wrap(err, "reading the body"))
+		}
+
+		ch <- receivedRequest{Header: r.Header, Body: b}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, ch
+}
+
+func TestURL(t *testing.T) {
+	for _, secret := range []string{"", "s3cret"} {
+		t.Run(fmt.Sprintf("secret %q", secret), func(t *testing.T) {
+			srv, ch := newReceiver(t, http.StatusOK)
+
+			c := Config{OnNoteAdded: srv.URL, Secret: secret}
+			if err := c.run(c.OnNoteAdded, payload{Event: EventNoteAdded, Note: &testNote}); err != nil {
+				t.Fatal(errors.Wrap(err, "running the hook"))
+			}
+
+			req := <-ch
+
+			var got payload
+			if err := json.Unmarshal(req.Body, &got); err != nil {
+				t.Fatal(errors.Wrap(err, "unmarshalling the payload"))
+			}
+			assert.Equal(t, got.Event, EventNoteAdded, "event mismatch")
+			assert.DeepEqual(t, *got.Note, testNote, "note mismatch")
+			assert.Equal(t, got.Sync == nil, true, "sync mismatch")
+
+			assert.Equal(t, req.Header.Get("Content-Type"), "application/json", "content type mismatch")
+			assert.Equal(t, req.Header.Get(EventHeader), EventNoteAdded, "event header mismatch")
+
+			expectedSignature := ""
+			if secret != "" {
+				expectedSignature = sign(secret, req.Body)
+			}
+			assert.Equal(t, req.Header.Get(SignatureHeader), expectedSignature, "signature mismatch")
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{"event":"sync.complete"}' | openssl dgst -sha256 -hmac s3cret
+	got := sign("s3cret", []byte(`{"event":"sync.complete"}`))
+
+	assert.Equal(t, got, "sha256=9c33fb0a787aaca1a120781a60bc351e65439aa0351815927eea14dc8a315e48", "signature mismatch")
+}
+
+func TestURL_failure(t *testing.T) {
+	t.Run("error status", func(t *testing.T) {
+		srv, ch := newReceiver(t, http.StatusInternalServerError)
+
+		c := Config{OnSyncComplete: srv.URL}
+		err := c.run(c.OnSyncComplete, payload{Event: EventSyncComplete, Sync: &SyncSummary{Backend: "server"}})
+		<-ch
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+
+		// firing logs the error instead of returning it
+		c.SyncComplete(SyncSummary{Backend: "server"})
+		<-ch
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		origTimeout := httpTimeout
+		httpTimeout = 50 * time.Millisecond
+		defer func() { httpTimeout = origTimeout }()
+
+		done := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		}))
+		defer srv.Close()
+		defer close(done)
+
+		c := Config{OnNoteEdited: srv.URL}
+
+		start := time.Now()
+		err := c.run(c.OnNoteEdited, payload{Event: EventNoteEdited, Note: &testNote})
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+		assert.Equal(t, time.Since(start) < time.Second, true, "the hook was not timed out")
+	})
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake command uses sh")
+	}
+
+	dir := t.TempDir()
+	stdinPath := filepath.Join(dir, "stdin")
+	eventPath := filepath.Join(dir, "event")
+
+	c := Config{
+		OnNoteEdited: fmt.Sprintf(`cat > '%s' && printf '%%s' "$DNOTE_EVENT" > '%s'`, stdinPath, eventPath),
+	}
+	c.NoteEdited(testNote)
+
+	b, err := ioutil.ReadFile(stdinPath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the captured stdin"))
+	}
+
+	var got payload
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(errors.Wrap(err, "unmarshalling the payload"))
+	}
+	assert.Equal(t, got.Event, EventNoteEdited, "event mismatch")
+	assert.DeepEqual(t, *got.Note, testNote, "note mismatch")
+
+	event, err := ioutil.ReadFile(eventPath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the captured event"))
+	}
+	assert.Equal(t, string(event), EventNoteEdited, "DNOTE_EVENT mismatch")
+}
+
+func TestCommand_failure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake command uses sh")
+	}
+
+	t.Run("exit status", func(t *testing.T) {
+		err := runCommand("echo oops >&2; exit 3", EventNoteAdded, []byte("{}"))
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		origTimeout := commandTimeout
+		commandTimeout = 50 * time.Millisecond
+		defer func() { commandTimeout = origTimeout }()
+
+		start := time.Now()
+		err := runCommand("exec sleep 5", EventNoteAdded, []byte("{}"))
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+		assert.Equal(t, time.Since(start) < 2*time.Second, true, "the hook was not timed out")
+	})
+}
+
+func TestFire_noHook(t *testing.T) {
+	// no hook is configured, so nothing runs
+	Config{}.NoteAdded(testNote)
+	Config{}.NoteEdited(testNote)
+	Config{}.SyncComplete(SyncSummary{})
+}
@@ -0,0 +1,170 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package i18n provides the catalogs of the user-facing messages
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultLocale is the locale of the messages missing from the other locales
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var localeFs embed.FS
+
+// Catalog maps the keys of the messages to their templates. The templates
+// are formatted with the fmt package.
+type Catalog map[string]string
+
+var (
+	catalogs map[string]Catalog
+	locale   = DefaultLocale
+	mu       sync.RWMutex
+)
+
+func init() {
+	c, err := loadCatalogs()
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs = c
+}
+
+func loadCatalogs() (map[string]Catalog, error) {
+	entries, err := localeFs.ReadDir("locales")
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the locales")
+	}
+
+	ret := map[string]Catalog{}
+	for _, entry := range entries {
+		b, err := localeFs.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", entry.Name())
+		}
+
+		var c Catalog
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, errors.Wrapf(err, "decoding %s", entry.Name())
+		}
+
+		ret[strings.TrimSuffix(entry.Name(), ".json")] = c
+	}
+
+	return ret, nil
+}
+
+// Locales returns the locales that have a catalog, in alphabetical order
+func Locales() []string {
+	ret := []string{}
+	for l := range catalogs {
+		ret = append(ret, l)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// Lookup returns the catalog of the given locale
+func Lookup(l string) (Catalog, bool) {
+	c, ok := catalogs[l]
+	return c, ok
+}
+
+// SetLocale sets the locale of the messages. A locale without a catalog
+// falls back to the default locale.
+func SetLocale(l string) {
+	l = Normalize(l)
+	if _, ok := catalogs[l]; !ok {
+		l = DefaultLocale
+	}
+
+	mu.Lock()
+	locale = l
+	mu.Unlock()
+}
+
+// Locale returns the locale of the messages
+func Locale() string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return locale
+}
+
+// Normalize returns the language of the given locale, such that
+// "es_ES.UTF-8" becomes "es". It returns an empty string for the C and
+// POSIX locales, which do not name a language.
+func Normalize(l string) string {
+	if i := strings.IndexAny(l, "_-.@"); i != -1 {
+		l = l[:i]
+	}
+
+	l = strings.ToLower(strings.TrimSpace(l))
+	if l == "c" || l == "posix" {
+		return ""
+	}
+
+	return l
+}
+
+// Detect returns the locale from the given override, or from the
+// environment variables in the order of LC_ALL, LC_MESSAGES and LANG
+func Detect(override string, getenv func(string) string) string {
+	candidates := []string{override}
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		candidates = append(candidates, getenv(key))
+	}
+
+	for _, c := range candidates {
+		if l := Normalize(c); l != "" {
+			return l
+		}
+	}
+
+	return DefaultLocale
+}
+
+// T returns the message with the given key in the current locale, formatted
+// with the given arguments. It falls back to the message in the default
+// locale, and to the key itself if no catalog has the message.
+func T(key string, args ...interface{}) string {
+	return fmt.Sprintf(template(Locale(), key), args...)
+}
+
+func template(l, key string) string {
+	if tmpl, ok := catalogs[l][key]; ok {
+		return tmpl
+	}
+	if tmpl, ok := catalogs[DefaultLocale][key]; ok {
+		return tmpl
+	}
+
+	return key
+}
@@ -0,0 +1,195 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+// withCatalogs replaces the catalogs and the locale for the duration of a test
+func withCatalogs(t *testing.T, c map[string]Catalog, l string) {
+	prevCatalogs, prevLocale := catalogs, Locale()
+	t.Cleanup(func() {
+		catalogs = prevCatalogs
+		SetLocale(prevLocale)
+	})
+
+	catalogs = c
+	SetLocale(l)
+}
+
+func TestT(t *testing.T) {
+	withCatalogs(t, map[string]Catalog{
+		"en": {"greeting": "hello %s", "farewell": "bye"},
+		"es": {"greeting": "hola %s"},
+	}, "es")
+
+	assert.Equal(t, T("greeting", "ana"), "hola ana", "translated message mismatch")
+	assert.Equal(t, T("farewell"), "bye", "missing message should fall back to the default locale")
+	assert.Equal(t, T("unknown"), "unknown", "unknown message should fall back to the key")
+}
+
+func TestSetLocale(t *testing.T) {
+	withCatalogs(t, map[string]Catalog{"en": {}, "es": {}}, DefaultLocale)
+
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "es", expected: "es"},
+		{input: "es_AR.UTF-8", expected: "es"},
+		{input: "fr_FR", expected: DefaultLocale},
+		{input: "C", expected: DefaultLocale},
+		{input: "", expected: DefaultLocale},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			SetLocale(tc.input)
+
+			assert.Equal(t, Locale(), tc.expected, "locale mismatch")
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	testCases := []struct {
+		name     string
+		override string
+		env      map[string]string
+		expected string
+	}{
+		{
+			name:     "override",
+			override: "es",
+			env:      map[string]string{"LC_ALL": "fr_FR.UTF-8"},
+			expected: "es",
+		},
+		{
+			name:     "LC_ALL",
+			env:      map[string]string{"LC_ALL": "es_ES.UTF-8", "LC_MESSAGES": "de_DE", "LANG": "fr_FR"},
+			expected: "es",
+		},
+		{
+			name:     "LC_MESSAGES",
+			env:      map[string]string{"LC_MESSAGES": "de_DE", "LANG": "fr_FR"},
+			expected: "de",
+		},
+		{
+			name:     "LANG",
+			env:      map[string]string{"LANG": "es_MX.UTF-8"},
+			expected: "es",
+		},
+		{
+			name:     "C locale",
+			env:      map[string]string{"LC_ALL": "C", "LANG": "es_ES.UTF-8"},
+			expected: "es",
+		},
+		{
+			name:     "empty",
+			env:      map[string]string{},
+			expected: DefaultLocale,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			getenv := func(key string) string { return tc.env[key] }
+
+			assert.Equal(t, Detect(tc.override, getenv), tc.expected, "locale mismatch")
+		})
+	}
+}
+
+func TestCatalogs(t *testing.T) {
+	en, ok := Lookup(DefaultLocale)
+	if !ok {
+		t.Fatal("the default catalog is missing")
+	}
+
+	for _, l := range Locales() {
+		if l == DefaultLocale {
+			continue
+		}
+
+		t.Run(l, func(t *testing.T) {
+			c, _ := Lookup(l)
+
+			for key, tmpl := range en {
+				translated, ok := c[key]
+				if !ok {
+					t.Errorf("the message %q is missing", key)
+					continue
+				}
+
+				assert.DeepEqual(t, verbs(translated), verbs(tmpl), "verbs mismatch for "+key)
+			}
+			for key := range c {
+				if _, ok := en[key]; !ok {
+					t.Errorf("the message %q is not in the default catalog", key)
+				}
+			}
+		})
+	}
+}
+
+// verbs returns the formatting verbs of the template in the order of their
+// arguments, so that the translations may reorder them with explicit indexes
+func verbs(tmpl string) []string {
+	type verb struct {
+		index int
+		verb  string
+	}
+
+	var vs []verb
+	next := 1
+	for _, m := range verbRegexp.FindAllStringSubmatch(tmpl, -1) {
+		v := m[0]
+		if v == "%%" {
+			continue
+		}
+
+		idx := next
+		if m[1] != "" {
+			idx, _ = strconv.Atoi(m[1][1 : len(m[1])-1])
+		}
+		next = idx + 1
+
+		vs = append(vs, verb{index: idx, verb: v[len(v)-1:]})
+	}
+
+	sort.SliceStable(vs, func(i, j int) bool { return vs[i].index < vs[j].index })
+
+	seen := map[int]bool{}
+	ret := []string{}
+	for _, v := range vs {
+		if seen[v.index] {
+			continue
+		}
+		seen[v.index] = true
+
+		ret = append(ret, v.verb)
+	}
+
+	return ret
+}
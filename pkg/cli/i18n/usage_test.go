@@ -0,0 +1,230 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package i18n
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// messageDirs are the directories, relative to this package, whose output
+// is expected to go through the catalogs
+var messageDirs = []string{"../cmd", "../sync", "../onboarding", "../ui", "../output", "../upgrade", "../infra"}
+
+// outputFuncs are the functions that print their first argument
+var outputFuncs = map[string]map[string]bool{
+	"fmt": {"Print": true, "Printf": true, "Println": true},
+	"log": {
+		"Printf": true, "Infof": true, "Info": true, "Success": true, "Successf": true,
+		"Warnf": true, "Warn": true, "Plain": true, "Plainf": true, "Error": true,
+		"Errorf": true, "Rawf": true, "Askf": true,
+	},
+	"ui": {"Confirm": true, "PromptInput": true, "PromptPassword": true, "PromptSelect": true},
+}
+
+// promptFuncs are the functions and methods that show their first argument
+// as a question, whatever they belong to
+var promptFuncs = map[string]bool{"Confirm": true, "Input": true, "Password": true, "maybeConfirm": true}
+
+var (
+	verbRegexp = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z%]`)
+	wordRegexp = regexp.MustCompile(`\pL{2,}`)
+)
+
+type sourceFile struct {
+	path string
+	pkg  string
+	node *ast.File
+	fset *token.FileSet
+}
+
+func parseDir(t *testing.T, dir string, tests bool) []sourceFile {
+	var ret []sourceFile
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(p) != ".go" {
+			return nil
+		}
+		if !tests && strings.HasSuffix(p, "_test.go") {
+			return nil
+		}
+
+		fset := token.NewFileSet()
+		node, err := parser.ParseFile(fset, p, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		ret = append(ret, sourceFile{path: p, pkg: node.Name.Name, node: node, fset: fset})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err, "parsing the sources")
+	}
+
+	return ret
+}
+
+// receiverName returns the name of the package or the logger that the
+// function of the given call belongs to
+func receiverName(f sourceFile, call *ast.CallExpr) (string, string) {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return f.pkg, fun.Name
+	case *ast.SelectorExpr:
+		switch x := fun.X.(type) {
+		case *ast.Ident:
+			return x.Name, fun.Sel.Name
+		case *ast.SelectorExpr:
+			// loggers such as ctx.Logger print like the log package
+			if x.Sel.Name == "Logger" {
+				return "log", fun.Sel.Name
+			}
+		}
+	}
+
+	return "", ""
+}
+
+func isTranslation(call *ast.CallExpr) bool {
+	recv, name := "", ""
+	if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+		if x, ok := sel.X.(*ast.Ident); ok {
+			recv, name = x.Name, sel.Sel.Name
+		}
+	}
+
+	return recv == "i18n" && name == "T"
+}
+
+// literalText returns the English text in the string literals of the given
+// expression, skipping the keys of the translations
+func literalText(expr ast.Expr) []string {
+	var ret []string
+
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if isTranslation(n) {
+				return false
+			}
+		case *ast.BasicLit:
+			if n.Kind != token.STRING {
+				return false
+			}
+
+			s, err := strconv.Unquote(n.Value)
+			if err != nil {
+				return false
+			}
+			if wordRegexp.MatchString(verbRegexp.ReplaceAllString(s, "")) {
+				ret = append(ret, s)
+			}
+		}
+
+		return true
+	})
+
+	return ret
+}
+
+func TestMessagesAreTranslated(t *testing.T) {
+	for _, dir := range messageDirs {
+		for _, f := range parseDir(t, dir, false) {
+			ast.Inspect(f.node, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || len(call.Args) == 0 {
+					return true
+				}
+
+				recv, name := receiverName(f, call)
+				if !outputFuncs[recv][name] && !promptFuncs[name] {
+					return true
+				}
+
+				for _, s := range literalText(call.Args[0]) {
+					t.Errorf("%s: %s.%s prints %q without the catalog", f.fset.Position(call.Pos()), recv, name, s)
+				}
+
+				return true
+			})
+		}
+	}
+}
+
+func TestKeysAreRegistered(t *testing.T) {
+	en, ok := Lookup(DefaultLocale)
+	if !ok {
+		t.Fatal("the default catalog is missing")
+	}
+
+	used := map[string]bool{}
+	for _, f := range parseDir(t, "..", true) {
+		if f.pkg == "i18n" {
+			continue
+		}
+
+		ast.Inspect(f.node, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || !isTranslation(call) || len(call.Args) == 0 {
+				return true
+			}
+
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				t.Errorf("%s: the key of the message is not a string literal", f.fset.Position(call.Pos()))
+				return true
+			}
+
+			key, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatal(err, "unquoting the key")
+			}
+			if _, ok := en[key]; !ok {
+				t.Errorf("%s: the key %q is not in the default catalog", f.fset.Position(call.Pos()), key)
+			}
+			used[key] = true
+
+			return true
+		})
+	}
+
+	var unused []string
+	for key := range en {
+		if !used[key] {
+			unused = append(unused, key)
+		}
+	}
+	sort.Strings(unused)
+
+	for _, key := range unused {
+		t.Errorf("the key %q is not used", key)
+	}
+}
@@ -0,0 +1,138 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/credentials"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+)
+
+// readPassphrase returns the passphrase of the credentials file from the
+// environment, or prompts for it unless Dnote runs in the non-interactive mode
+func readPassphrase() (string, error) {
+	if passphrase := os.Getenv(consts.PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	var passphrase string
+	if err := ui.PromptPassword(i18n.T("credentials.passphrase"), consts.PassphraseEnv, &passphrase); err != nil {
+		return "", errors.Wrap(err, "getting the passphrase input")
+	}
+
+	return passphrase, nil
+}
+
+// memoPassphrase returns a function that reads the passphrase at most once,
+// so that it is asked only once per command
+func memoPassphrase() credentials.PassphraseFunc {
+	var passphrase string
+
+	return func() (string, error) {
+		if passphrase != "" {
+			return passphrase, nil
+		}
+
+		p, err := readPassphrase()
+		if err != nil {
+			return "", err
+		}
+		passphrase = p
+
+		return passphrase, nil
+	}
+}
+
+// getCredentialsFilePath returns the path to the credentials file of the file
+// backend
+func getCredentialsFilePath(ctx context.DnoteCtx) string {
+	return filepath.Join(ctx.Paths.Data, consts.DnoteDirName, consts.CredentialsFilename)
+}
+
+// NewCredentialStore returns the store of the session key for the backend,
+// which is one of the consts.CredentialsBackend values
+func NewCredentialStore(ctx context.DnoteCtx, backend string) credentials.Store {
+	switch backend {
+	case consts.CredentialsBackendKeyring:
+		return credentials.NewKeyring(credentials.SystemKeyring(), ctx.APIEndpoint)
+	case consts.CredentialsBackendFile:
+		return credentials.NewFile(getCredentialsFilePath(ctx), memoPassphrase())
+	}
+
+	return credentials.NewPlaintext(ctx.DB)
+}
+
+// LoadSessionKey returns the context with the session key read from the
+// credential store. The key is read only by the commands that talk to the
+// server, so that the others neither prompt for a passphrase nor touch the
+// keyring. The session key is left empty if it is not stored.
+func LoadSessionKey(ctx context.DnoteCtx) (context.DnoteCtx, error) {
+	if ctx.SessionKey != "" || ctx.Credentials == nil {
+		return ctx, nil
+	}
+
+	key, err := ctx.Credentials.Get()
+	if err == credentials.ErrNotFound {
+		return ctx, nil
+	} else if err != nil {
+		return ctx, errors.Wrap(err, "reading the session key")
+	}
+
+	ctx.SessionKey = key
+
+	return ctx, nil
+}
+
+// HasSessionKey checks if a session key is configured. Unlike LoadSessionKey, it
+// does not decrypt the credentials file, so that it never prompts for the
+// passphrase.
+func HasSessionKey(ctx context.DnoteCtx) (bool, error) {
+	if ctx.SessionKey != "" {
+		return true, nil
+	}
+	if ctx.Credentials == nil {
+		return false, nil
+	}
+
+	if ctx.CredentialsBackend == consts.CredentialsBackendFile {
+		_, err := os.Stat(getCredentialsFilePath(ctx))
+		if os.IsNotExist(err) {
+			return false, nil
+		} else if err != nil {
+			return false, errors.Wrap(err, "checking the credentials file")
+		}
+
+		return true, nil
+	}
+
+	_, err := ctx.Credentials.Get()
+	if err == credentials.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "reading the session key")
+	}
+
+	return true, nil
+}
@@ -0,0 +1,129 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+// countDuplicateNoteUUIDs counts the uuids that are shared by more than one
+// note. It only reads the uuid column, which every version of the schema has.
+func countDuplicateNoteUUIDs(db *database.DB) (int, error) {
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM (SELECT uuid FROM notes GROUP BY uuid HAVING count(*) > 1)").Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "counting duplicate note uuids")
+	}
+
+	return count, nil
+}
+
+// dedupeNotes resolves the notes that share a uuid, which a database without
+// the unique index on notes.uuid may have, for instance if it was restored from
+// an old backup, and then creates the index. The copy with the highest usn
+// keeps the uuid. The others are moved to a recovery book as new notes that
+// the next sync uploads, or expunged if they were removed. It runs after the
+// migrations, on the latest schema.
+func dedupeNotes(ctx context.DnoteCtx) error {
+	count, err := countDuplicateNoteUUIDs(ctx.DB)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	return WithTx(ctx.DB, func(tx *database.DB) error {
+		rows, err := tx.Query(`SELECT rowid, uuid, deleted FROM notes
+			WHERE uuid IN (SELECT uuid FROM notes GROUP BY uuid HAVING count(*) > 1)
+			ORDER BY uuid, usn DESC, rowid DESC`)
+		if err != nil {
+			return errors.Wrap(err, "querying the notes sharing a uuid")
+		}
+
+		type duplicate struct {
+			rowID   int
+			deleted bool
+		}
+
+		var duplicates []duplicate
+		var prevUUID string
+		for rows.Next() {
+			var d duplicate
+			var uuid string
+			if err := rows.Scan(&d.rowID, &uuid, &d.deleted); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "scanning a note")
+			}
+
+			// the first copy of each uuid has the highest usn and is kept
+			if uuid != prevUUID {
+				prevUUID = uuid
+				continue
+			}
+
+			duplicates = append(duplicates, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "iterating the notes sharing a uuid")
+		}
+
+		var moved []int
+		for _, d := range duplicates {
+			if !d.deleted {
+				moved = append(moved, d.rowID)
+				continue
+			}
+
+			if _, err := tx.Exec("DELETE FROM notes WHERE rowid = ?", d.rowID); err != nil {
+				return errors.Wrapf(err, "expunging the note %d", d.rowID)
+			}
+		}
+
+		if len(moved) > 0 {
+			bookUUID, label, err := database.CreateRecoveryBook(tx)
+			if err != nil {
+				return errors.Wrap(err, "creating the recovery book")
+			}
+
+			for _, rowID := range moved {
+				uuid, err := utils.GenerateUUID()
+				if err != nil {
+					return errors.Wrap(err, "generating a uuid")
+				}
+				if _, err := tx.Exec("UPDATE notes SET uuid = ?, book_uuid = ?, usn = 0, dirty = ? WHERE rowid = ?", uuid, bookUUID, true, rowID); err != nil {
+					return errors.Wrapf(err, "moving the note %d", rowID)
+				}
+			}
+
+			log.Warn(i18n.T("init.duplicate_notes", len(moved), label))
+		}
+
+		if _, err := tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_notes_uuid ON notes(uuid)"); err != nil {
+			return errors.Wrap(err, "creating the index on the note uuids")
+		}
+
+		return nil
+	})
+}
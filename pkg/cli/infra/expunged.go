@@ -0,0 +1,50 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// ExpungedArchiveOn tells if the notes that the syncs remove because they
+// were removed on another device are kept in the archive of the expunged notes
+func ExpungedArchiveOn(ctx context.DnoteCtx) bool {
+	return ctx.ExpungedMaxAge > 0 && ctx.ExpungedMaxSize > 0
+}
+
+// CapExpungedArchive removes the archived notes past the configured age and
+// size. The archive is left as it is if it is off.
+func CapExpungedArchive(ctx context.DnoteCtx, db *database.DB) error {
+	if !ExpungedArchiveOn(ctx) {
+		return nil
+	}
+
+	before := ctx.Clock.Now().Add(-ctx.ExpungedMaxAge).UnixNano()
+	n, err := database.CapExpungedNotes(db, before, ctx.ExpungedMaxSize)
+	if err != nil {
+		return errors.Wrap(err, "capping the archive of the expunged notes")
+	}
+
+	log.Debug("dropped %d notes from the archive of the expunged notes\n", n)
+
+	return nil
+}
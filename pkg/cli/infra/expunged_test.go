@@ -0,0 +1,95 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+func TestCapExpungedArchive(t *testing.T) {
+	now := time.Date(2018, time.November, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	testCases := []struct {
+		name     string
+		maxAge   time.Duration
+		maxSize  int64
+		expected []string
+	}{
+		{
+			name:     "off",
+			maxAge:   0,
+			maxSize:  0,
+			expected: []string{"n3-uuid", "n2-uuid", "n1-uuid"},
+		},
+		{
+			name:     "age",
+			maxAge:   30 * day,
+			maxSize:  100,
+			expected: []string{"n3-uuid", "n2-uuid"},
+		},
+		{
+			name:     "size",
+			maxAge:   90 * day,
+			maxSize:  7,
+			expected: []string{"n3-uuid"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+			defer database.TeardownTestDB(t, db)
+
+			ages := []time.Duration{60 * day, 10 * day, day}
+			for i, age := range ages {
+				database.MustExec(t, "archiving a note", db, "INSERT INTO expunged_archive (note_uuid, book_label, body, added_on, expunged_at) VALUES (?, ?, ?, ?, ?)",
+					[]string{"n1-uuid", "n2-uuid", "n3-uuid"}[i], "js", "n body", 1541108743, now.Add(-age).UnixNano())
+			}
+
+			c := clock.NewMock()
+			c.SetNow(now)
+			ctx := context.DnoteCtx{DB: db, Clock: c, ExpungedMaxAge: tc.maxAge, ExpungedMaxSize: tc.maxSize}
+
+			// execute
+			if err := CapExpungedArchive(ctx, db); err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			// test
+			notes, err := database.GetExpungedNotes(db)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "getting the expunged notes"))
+			}
+			got := []string{}
+			for _, n := range notes {
+				got = append(got, n.NoteUUID)
+			}
+			assert.DeepEqual(t, got, tc.expected, "archived notes mismatch")
+		})
+	}
+}
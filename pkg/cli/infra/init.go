@@ -22,17 +22,17 @@ package infra
 
 import (
 	"database/sql"
-	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
-	"time"
 
+	"github.com/dnote/dnote/pkg/cli/clipboard"
 	"github.com/dnote/dnote/pkg/cli/config"
 	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
 	"github.com/dnote/dnote/pkg/cli/dirs"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/migrate"
 	"github.com/dnote/dnote/pkg/cli/utils"
@@ -44,42 +44,54 @@ import (
 // RunEFunc is a function type of dnote commands
 type RunEFunc func(*cobra.Command, []string) error
 
-func checkLegacyDBPath() (string, bool) {
-	legacyDnoteDir := getLegacyDnotePath(dirs.Home)
+func checkLegacyDBPath(legacyDnoteDir string) (string, bool) {
 	ok, err := utils.FileExists(legacyDnoteDir)
 	if ok {
 		return legacyDnoteDir, true
 	}
 
 	if err != nil {
-		log.Errorf(errors.Wrapf(err, "checking legacy dnote directory at %s", legacyDnoteDir).Error())
+		log.Error(i18n.T("init.legacy_dir_failed", legacyDnoteDir, err))
 	}
 
 	return "", false
 }
 
 func getDBPath(paths context.Paths) string {
-	legacyDnoteDir, ok := checkLegacyDBPath()
+	legacyDnoteDir, ok := checkLegacyDBPath(paths.LegacyDnote)
 	if ok {
-		return fmt.Sprintf("%s/%s", legacyDnoteDir, consts.DnoteDBFileName)
+		return filepath.Join(legacyDnoteDir, consts.DnoteDBFileName)
 	}
 
-	return fmt.Sprintf("%s/%s/%s", paths.Data, consts.DnoteDirName, consts.DnoteDBFileName)
+	return filepath.Join(paths.Data, consts.DnoteDirName, consts.DnoteDBFileName)
 }
 
-func newCtx(versionTag string) (context.DnoteCtx, error) {
-	dnoteDir := getLegacyDnotePath(dirs.Home)
-	paths := context.Paths{
+// GetPaths returns the paths of the directories that Dnote uses. If
+// consts.HomeEnv is set, they are all the directory it names.
+func GetPaths() context.Paths {
+	if dir := os.Getenv(consts.HomeEnv); dir != "" {
+		return getDirPaths(dir)
+	}
+
+	return context.Paths{
 		Home:        dirs.Home,
 		Config:      dirs.ConfigHome,
 		Data:        dirs.DataHome,
 		Cache:       dirs.CacheHome,
-		LegacyDnote: dnoteDir,
+		LegacyDnote: getLegacyDnotePath(dirs.Home),
 	}
+}
 
+func newCtx(paths context.Paths, versionTag string, readOnly bool) (context.DnoteCtx, error) {
 	dbPath := getDBPath(paths)
 
-	db, err := database.Open(dbPath)
+	var db *database.DB
+	var err error
+	if readOnly {
+		db, err = database.OpenReadOnly(dbPath)
+	} else {
+		db, err = database.Open(dbPath)
+	}
 	if err != nil {
 		return context.DnoteCtx{}, errors.Wrap(err, "conntecting to db")
 	}
@@ -88,34 +100,142 @@ func newCtx(versionTag string) (context.DnoteCtx, error) {
 		Paths:   paths,
 		Version: versionTag,
 		DB:      db,
+		Clock:   clock.New(),
 	}
 
 	return ctx, nil
 }
 
-// Init initializes the Dnote environment and returns a new dnote context
-func Init(apiEndpoint, versionTag string) (*context.DnoteCtx, error) {
-	ctx, err := newCtx(versionTag)
+// withDataDir returns the paths whose data directory is replaced with the one
+// configured in the config file, if any
+func withDataDir(paths context.Paths) (context.Paths, error) {
+	ctx := context.DnoteCtx{Paths: paths}
+
+	ok, err := utils.FileExists(config.GetPath(ctx))
 	if err != nil {
-		return nil, errors.Wrap(err, "initializing a context")
+		return paths, errors.Wrap(err, "checking if the config file exists")
+	}
+	if !ok {
+		return paths, nil
 	}
 
-	if err := InitFiles(ctx, apiEndpoint); err != nil {
-		return nil, errors.Wrap(err, "initializing files")
+	cf, err := config.Read(ctx)
+	if err != nil {
+		// a corrupt config file is replaced by the default one later
+		if errors.Cause(err) == config.ErrCorrupt {
+			return paths, nil
+		}
+
+		return paths, errors.Wrap(err, "reading the config file")
 	}
 
-	if err := InitDB(ctx); err != nil {
-		return nil, errors.Wrap(err, "initializing database")
+	if cf.DataDir != "" {
+		paths.Data = cf.DataDir
 	}
-	if err := InitSystem(ctx); err != nil {
-		return nil, errors.Wrap(err, "initializing system data")
+
+	return paths, nil
+}
+
+// IsFreshInstall checks if Dnote has never run with the given paths, i.e.
+// neither the database nor the config file exists
+func IsFreshInstall(paths context.Paths) (bool, error) {
+	ctx := context.DnoteCtx{Paths: paths}
+
+	for _, path := range []string{getDBPath(ctx.Paths), config.GetPath(ctx)} {
+		ok, err := utils.FileExists(path)
+		if err != nil {
+			return false, errors.Wrapf(err, "checking if %s exists", path)
+		}
+		if ok {
+			return false, nil
+		}
 	}
 
-	if err := migrate.Legacy(ctx); err != nil {
-		return nil, errors.Wrap(err, "running legacy migration")
+	return true, nil
+}
+
+// canOpenReadOnly checks if the database and the config file exist so that
+// Dnote can run without creating them
+func canOpenReadOnly(paths context.Paths) (bool, error) {
+	ctx := context.DnoteCtx{Paths: paths}
+
+	for _, path := range []string{getDBPath(ctx.Paths), config.GetPath(ctx)} {
+		ok, err := utils.FileExists(path)
+		if err != nil {
+			return false, errors.Wrapf(err, "checking if %s exists", path)
+		}
+		if !ok {
+			return false, nil
+		}
 	}
-	if err := migrate.Run(ctx, migrate.LocalSequence, migrate.LocalMode); err != nil {
-		return nil, errors.Wrap(err, "running migration")
+
+	return true, nil
+}
+
+// Init initializes the Dnote environment and returns a new dnote context. In
+// the read-only mode, the database is opened read-only and no file is created
+// or modified, unless Dnote has not been initialized yet.
+func Init(apiEndpoint, versionTag string, readOnly bool) (*context.DnoteCtx, error) {
+	return initPaths(GetPaths(), apiEndpoint, versionTag, readOnly)
+}
+
+// InitAt initializes the Dnote environment whose config, data and cache are
+// all kept in the given directory, instead of the directories of the user,
+// and returns a new dnote context
+func InitAt(dir, apiEndpoint, versionTag string) (*context.DnoteCtx, error) {
+	return initPaths(getDirPaths(dir), apiEndpoint, versionTag, false)
+}
+
+// getDirPaths returns the paths that keep the config, the data and the cache
+// all in the given directory
+func getDirPaths(dir string) context.Paths {
+	return context.Paths{
+		Home:        dir,
+		Config:      dir,
+		Data:        dir,
+		Cache:       dir,
+		LegacyDnote: getLegacyDnotePath(dir),
+	}
+}
+
+func initPaths(paths context.Paths, apiEndpoint, versionTag string, readOnly bool) (*context.DnoteCtx, error) {
+	paths, err := withDataDir(paths)
+	if err != nil {
+		return nil, errors.Wrap(err, "finding the data directory")
+	}
+
+	if readOnly {
+		ok, err := canOpenReadOnly(paths)
+		if err != nil {
+			return nil, errors.Wrap(err, "checking the existing files")
+		}
+
+		readOnly = ok
+	}
+
+	ctx, err := newCtx(paths, versionTag, readOnly)
+	if err != nil {
+		return nil, errors.Wrap(err, "initializing a context")
+	}
+
+	if readOnly {
+		if err := migrate.Run(ctx, migrate.LocalSequence, migrate.LocalMode); err != nil {
+			return nil, errors.Wrap(err, "checking migration")
+		}
+
+		// the commands that do not modify the database can run on a mismatched
+		// schema, which may only fail the queries on the mismatched columns
+		if err := migrate.CheckSchema(ctx.DB); err != nil {
+			if !migrate.IsSchemaError(err) {
+				return nil, errors.Wrap(err, "checking the schema")
+			}
+
+			log.Warnf("%s\n", err.Error())
+		}
+	} else {
+		if err := initReadWrite(ctx, apiEndpoint); err != nil {
+			return nil, err
+		}
 	}
 
 	ctx, err = SetupCtx(ctx)
@@ -128,18 +248,61 @@ func Init(apiEndpoint, versionTag string) (*context.DnoteCtx, error) {
 	return &ctx, nil
 }
 
+// InitStandalone returns a new dnote context for the commands that use neither
+// the database nor the config, such as printing the version. It does not read
+// or create any file.
+func InitStandalone(versionTag string) *context.DnoteCtx {
+	return &context.DnoteCtx{
+		Paths:      GetPaths(),
+		Version:    versionTag,
+		Clock:      clock.New(),
+		Logger:     log.Default(),
+		FileLogger: log.DefaultFile(),
+	}
+}
+
+// initReadWrite creates the missing files and data, and runs the migrations
+func initReadWrite(ctx context.DnoteCtx, apiEndpoint string) error {
+	if err := InitFiles(ctx, apiEndpoint); err != nil {
+		return errors.Wrap(err, "initializing files")
+	}
+	if err := initAutoVacuum(ctx); err != nil {
+		return errors.Wrap(err, "initializing auto-vacuum")
+	}
+
+	if err := InitDB(ctx); err != nil {
+		return errors.Wrap(err, "initializing database")
+	}
+	if err := InitSystem(ctx); err != nil {
+		return errors.Wrap(err, "initializing system data")
+	}
+
+	if err := migrate.Legacy(ctx); err != nil {
+		return errors.Wrap(err, "running legacy migration")
+	}
+	if err := migrate.Run(ctx, migrate.LocalSequence, migrate.LocalMode); err != nil {
+		return errors.Wrap(err, "running migration")
+	}
+	if err := dedupeNotes(ctx); err != nil {
+		return errors.Wrap(err, "resolving the notes sharing a uuid")
+	}
+	if err := migrate.CheckSchema(ctx.DB); err != nil {
+		return errors.Wrap(err, "checking the schema")
+	}
+	if err := pruneJournal(ctx); err != nil {
+		return errors.Wrap(err, "pruning the journal")
+	}
+
+	return nil
+}
+
 // SetupCtx populates the context and returns a new context
 func SetupCtx(ctx context.DnoteCtx) (context.DnoteCtx, error) {
 	db := ctx.DB
 
-	var sessionKey string
 	var sessionKeyExpiry int64
 
-	err := db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSessionKey).Scan(&sessionKey)
-	if err != nil && err != sql.ErrNoRows {
-		return ctx, errors.Wrap(err, "finding sesison key")
-	}
-	err = db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSessionKeyExpiry).Scan(&sessionKeyExpiry)
+	err := db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSessionKeyExpiry).Scan(&sessionKeyExpiry)
 	if err != nil && err != sql.ErrNoRows {
 		return ctx, errors.Wrap(err, "finding sesison key expiry")
 	}
@@ -149,24 +312,246 @@ func SetupCtx(ctx context.DnoteCtx) (context.DnoteCtx, error) {
 		return ctx, errors.Wrap(err, "reading config")
 	}
 
+	// an invalid level disables the log file rather than every command
+	fileLevel, err := log.ParseFileLevel(cf.Log.Level)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrap(err, "disabling the log file").Error())
+	}
+
+	// an invalid strategy falls back to the default rather than failing every command
+	conflictStrategy, err := config.ParseConflictStrategy(cf.Sync.ConflictStrategy)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "using the %s conflict strategy", conflictStrategy).Error())
+	}
+
+	// an invalid backend falls back to the server rather than syncing elsewhere
+	syncBackend, err := config.ParseSyncBackend(cf.Sync.Backend)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "using the %s sync backend", syncBackend).Error())
+	}
+
+	// an invalid backend falls back to the default rather than losing the session key
+	credentialsBackend, err := config.ParseCredentialsBackend(cf.Credentials.Backend)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "using the %s credentials backend", credentialsBackend).Error())
+	}
+
+	// an invalid level falls back to the default rather than to applying every change
+	syncSafety, err := config.ParseSyncSafety(cf.Sync.Safety)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "using the %s sync safety", syncSafety).Error())
+	}
+
+	// an invalid policy falls back to the default, which is how dnote behaved before it was configurable
+	duplicateLabelPolicy, err := config.ParseDuplicateLabelPolicy(cf.Sync.DuplicateLabelPolicy)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "using the %s duplicate label policy", duplicateLabelPolicy).Error())
+	}
+
+	// an invalid policy falls back to the default, which is how dnote behaved before it was configurable
+	autoCreateBook, err := config.ParseAutoCreateBook(cf.AutoCreateBook)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "using the %s auto-create policy", autoCreateBook).Error())
+	}
+
+	// an invalid locale falls back to the environment rather than failing every command
+	locale, err := config.ParseLocale(cf.Locale)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrap(err, "using the locale of the environment").Error())
+	}
+	i18n.SetLocale(i18n.Detect(locale, os.Getenv))
+
+	// an invalid limit falls back to the default rather than to no limit
+	noteSizeWarn, err := config.ParseNoteSize(cf.NoteSize.Warn, config.DefaultNoteSizeWarn)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "using the note size warning of %d bytes", noteSizeWarn).Error())
+	}
+	noteSizeMax, err := config.ParseNoteSize(cf.NoteSize.Max, config.DefaultNoteSizeMax)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "using the note size limit of %d bytes", noteSizeMax).Error())
+	}
+
+	// invalid retries or timeouts fall back to the defaults rather than failing every sync
+	syncTimeouts, err := config.ParseSyncTimeouts(cf.Sync)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrap(err, "using the default retries and timeouts of the sync").Error())
+	}
+
+	autoBackup, err := config.ParseSyncAutoBackup(cf.Sync)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrapf(err, "keeping %d snapshots of the database", autoBackup).Error())
+	}
+
+	pageSize, err := config.ParseSyncPageSize(cf.Sync)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrap(err, "leaving the page size to the server").Error())
+	}
+
+	expungedMaxAge, expungedMaxSize, err := config.ParseExpungedArchive(cf.ExpungedArchive)
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrap(err, "using the default limits of the archive of the expunged notes").Error())
+	}
+
+	// the id is missing only if the database has never been opened for writing
+	var deviceID string
+	err = db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemDeviceID).Scan(&deviceID)
+	if err != nil && err != sql.ErrNoRows {
+		return ctx, errors.Wrap(err, "finding the device id")
+	}
+
+	deviceName := cf.DeviceName
+	if deviceName == "" {
+		deviceName = getHostname()
+	}
+
+	gitDir := cf.Sync.GitDir
+	if gitDir == "" {
+		gitDir = filepath.Join(ctx.Paths.Data, consts.DnoteDirName, consts.GitDirName)
+	}
+
+	mirrors := []context.SyncMirror{}
+	for _, m := range cf.Sync.Mirrors {
+		mirrors = append(mirrors, context.SyncMirror{Endpoint: m.Endpoint, APIKey: m.APIKey})
+	}
+
+	fileLogger := log.DefaultFile()
+	fileLogger.SetPath(filepath.Join(ctx.Paths.Cache, consts.DnoteDirName, consts.LogFilename))
+	fileLogger.SetLevel(fileLevel)
+
 	ret := context.DnoteCtx{
 		Paths:            ctx.Paths,
 		Version:          ctx.Version,
 		DB:               ctx.DB,
-		SessionKey:       sessionKey,
 		SessionKeyExpiry: sessionKeyExpiry,
 		APIEndpoint:      cf.APIEndpoint,
+		WebEndpoint:      cf.WebEndpoint,
 		Editor:           cf.Editor,
+		TimeFormat:       cf.TimeFormat,
+		Aliases:          cf.Aliases,
+		AutoVacuum:       cf.AutoVacuum,
+		AutoCreateBook:   autoCreateBook,
+		ConflictStrategy: conflictStrategy,
+		PruneEmptyBooks:  cf.Sync.PruneEmptyBooks,
+		SyncSafety:       syncSafety,
+		SyncBackend:      syncBackend,
+		GitDir:           gitDir,
+		SyncMirrors:      mirrors,
+		Hooks:            cf.Hooks,
+		LocalOnly:        cf.LocalOnly,
+		Clipboard:        clipboard.System(),
+		DeviceID:         deviceID,
+		DeviceName:       deviceName,
+		NoteSizeWarn:     noteSizeWarn,
+		NoteSizeMax:      noteSizeMax,
+		SyncTimeouts:     syncTimeouts,
+		ApplyRetention:   cf.Retention.ApplyAfterSync,
 		Clock:            clock.New(),
+		Logger:           log.Default(),
+		FileLogger:       fileLogger,
+	}
+	ret.CredentialsBackend = credentialsBackend
+	ret.SyncDuplicateLabelPolicy = duplicateLabelPolicy
+	ret.UpgradeManifestURL = cf.Upgrade.ManifestURL
+	ret.UpgradeNudge = cf.Notifications.UpgradeNudgeEnabled()
+	ret.SyncAutoBackup = autoBackup
+	ret.SyncPageSize = pageSize
+	ret.ExpungedMaxAge = expungedMaxAge
+	ret.ExpungedMaxSize = expungedMaxSize
+	ret.Credentials = NewCredentialStore(ret, credentialsBackend)
+	ret = applyEnv(ret)
+
+	// a malformed endpoint is reported here rather than by every request
+	apiEndpoint, err := utils.NormalizeEndpoint(ret.APIEndpoint)
+	if err != nil {
+		return ctx, errors.Wrapf(err, "checking apiEndpoint or %s", consts.APIEndpointEnv)
 	}
+	ret.APIEndpoint = apiEndpoint
 
 	return ret, nil
 }
 
+// getHostname returns the hostname of the machine, which names the device by
+// default
+func getHostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		log.Debug("getting the hostname: %s\n", err.Error())
+		return ""
+	}
+
+	return name
+}
+
+// applyEnv overrides the context with the environment variables, which take
+// precedence over the flags and the config. A session key set by
+// consts.APIKeyEnv is used instead of the one in the credentials store.
+func applyEnv(ctx context.DnoteCtx) context.DnoteCtx {
+	if v := os.Getenv(consts.APIKeyEnv); v != "" {
+		ctx.SessionKey = v
+	}
+	if v := os.Getenv(consts.APIEndpointEnv); v != "" {
+		ctx.APIEndpoint = v
+	}
+	if v := os.Getenv(consts.EditorEnv); v != "" {
+		ctx.Editor = v
+	}
+	if v := os.Getenv(consts.ProfileEnv); v != "" {
+		ctx.SyncProfileDir = v
+	}
+
+	return ctx
+}
+
 // getLegacyDnotePath returns a legacy dnote directory path placed under
 // the user's home directory
 func getLegacyDnotePath(homeDir string) string {
-	return fmt.Sprintf("%s/%s", homeDir, consts.LegacyDnoteDirName)
+	return filepath.Join(homeDir, consts.LegacyDnoteDirName)
+}
+
+// initAutoVacuum enables the incremental auto-vacuum if configured. It takes
+// effect immediately for a new database, and for an existing one, after it is
+// vacuumed by "dnote db maintain".
+func initAutoVacuum(ctx context.DnoteCtx) error {
+	cf, err := config.Read(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading config")
+	}
+	if !cf.AutoVacuum {
+		return nil
+	}
+
+	if _, err := ctx.DB.Exec("PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return errors.Wrap(err, "setting auto_vacuum")
+	}
+
+	return nil
+}
+
+// pruneJournal deletes the actions in the journal that are older than the
+// configured age
+func pruneJournal(ctx context.DnoteCtx) error {
+	cf, err := config.Read(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading config")
+	}
+
+	days := cf.Journal.MaxAgeDays
+	if days == 0 {
+		days = config.DefaultJournalMaxAgeDays
+	}
+	if days < 0 {
+		return nil
+	}
+
+	before := ctx.Clock.Now().AddDate(0, 0, -days).UnixNano()
+	n, err := database.PruneActions(ctx.DB, before)
+	if err != nil {
+		return errors.Wrap(err, "deleting the old actions")
+	}
+
+	log.Debug("pruned %d actions from the journal\n", n)
+
+	return nil
 }
 
 // InitDB initializes the database.
@@ -223,13 +608,23 @@ func InitDB(ctx context.DnoteCtx) error {
 
 	_, err = db.Exec(`
 		CREATE UNIQUE INDEX IF NOT EXISTS idx_books_label ON books(label);
-		CREATE UNIQUE INDEX IF NOT EXISTS idx_notes_uuid ON notes(uuid);
-		CREATE UNIQUE INDEX IF NOT EXISTS idx_books_uuid ON books(uuid);
-		CREATE INDEX IF NOT EXISTS idx_notes_book_uuid ON notes(book_uuid);`)
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_books_uuid ON books(uuid);`)
 	if err != nil {
 		return errors.Wrap(err, "creating indices")
 	}
 
+	// the index on the note uuids cannot be created while notes share a uuid.
+	// dedupeNotes resolves them after the migrations and creates it then.
+	duplicates, err := countDuplicateNoteUUIDs(db)
+	if err != nil {
+		return err
+	}
+	if duplicates == 0 {
+		if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_notes_uuid ON notes(uuid)"); err != nil {
+			return errors.Wrap(err, "creating the index on the note uuids")
+		}
+	}
+
 	return nil
 }
 
@@ -244,7 +639,6 @@ func initSystemKV(db *database.DB, key string, val string) error {
 	}
 
 	if _, err := db.Exec("INSERT INTO system (key, value) VALUES (?, ?)", key, val); err != nil {
-		db.Rollback()
 		return errors.Wrapf(err, "inserting %s %s", key, val)
 	}
 
@@ -255,31 +649,34 @@ func initSystemKV(db *database.DB, key string, val string) error {
 func InitSystem(ctx context.DnoteCtx) error {
 	log.Debug("initializing the system\n")
 
-	db := ctx.DB
-
-	tx, err := db.Begin()
-	if err != nil {
-		return errors.Wrap(err, "beginning a transaction")
-	}
-
-	nowStr := strconv.FormatInt(time.Now().Unix(), 10)
-	if err := initSystemKV(tx, consts.SystemLastUpgrade, nowStr); err != nil {
-		return errors.Wrapf(err, "initializing system config for %s", consts.SystemLastUpgrade)
-	}
-	if err := initSystemKV(tx, consts.SystemLastMaxUSN, "0"); err != nil {
-		return errors.Wrapf(err, "initializing system config for %s", consts.SystemLastMaxUSN)
-	}
-	if err := initSystemKV(tx, consts.SystemLastSyncAt, "0"); err != nil {
-		return errors.Wrapf(err, "initializing system config for %s", consts.SystemLastSyncAt)
-	}
-
-	tx.Commit()
+	return WithTx(ctx.DB, func(tx *database.DB) error {
+		nowStr := strconv.FormatInt(ctx.Clock.Now().Unix(), 10)
+		if err := initSystemKV(tx, consts.SystemLastUpgrade, nowStr); err != nil {
+			return errors.Wrapf(err, "initializing system config for %s", consts.SystemLastUpgrade)
+		}
+		if err := initSystemKV(tx, consts.SystemLastMaxUSN, "0"); err != nil {
+			return errors.Wrapf(err, "initializing system config for %s", consts.SystemLastMaxUSN)
+		}
+		if err := initSystemKV(tx, consts.SystemLastSyncAt, "0"); err != nil {
+			return errors.Wrapf(err, "initializing system config for %s", consts.SystemLastSyncAt)
+		}
+
+		deviceID, err := utils.GenerateUUID()
+		if err != nil {
+			return errors.Wrap(err, "generating the device id")
+		}
+		if err := initSystemKV(tx, consts.SystemDeviceID, deviceID); err != nil {
+			return errors.Wrapf(err, "initializing system config for %s", consts.SystemDeviceID)
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // getEditorCommand returns the system's editor command with appropriate flags,
-// if necessary, to make the command wait until editor is close to exit.
+// if necessary, to make the command wait until editor is close to exit. An
+// unknown editor is used as it is, and the platform's default editor is used
+// if none is set.
 func getEditorCommand() string {
 	editor := os.Getenv("EDITOR")
 
@@ -302,8 +699,10 @@ func getEditorCommand() string {
 		ret = "emacs"
 	case "nvim":
 		ret = "nvim"
+	case "":
+		ret = defaultEditor
 	default:
-		ret = "vi"
+		ret = editor
 	}
 
 	return ret
@@ -340,22 +739,34 @@ func initDnoteDir(ctx context.DnoteCtx) error {
 	return nil
 }
 
-// initConfigFile populates a new config file if it does not exist yet
+// DefaultConfig returns the config with which a new config file is populated
+func DefaultConfig(apiEndpoint string) config.Config {
+	return config.Config{
+		Editor:      getEditorCommand(),
+		APIEndpoint: apiEndpoint,
+	}
+}
+
+// initConfigFile populates a new config file if it does not exist yet, and
+// replaces the existing one if it is corrupt
 func initConfigFile(ctx context.DnoteCtx, apiEndpoint string) error {
+	cf := DefaultConfig(apiEndpoint)
+
 	path := config.GetPath(ctx)
 	ok, err := utils.FileExists(path)
 	if err != nil {
 		return errors.Wrap(err, "checking if config exists")
 	}
 	if ok {
-		return nil
-	}
-
-	editor := getEditorCommand()
+		corruptPath, err := config.Recover(ctx, cf)
+		if err != nil {
+			return errors.Wrap(err, "recovering the config file")
+		}
+		if corruptPath != "" {
+			log.Warn(i18n.T("init.config_corrupt", corruptPath))
+		}
 
-	cf := config.Config{
-		Editor:      editor,
-		APIEndpoint: apiEndpoint,
+		return nil
 	}
 
 	if err := config.Write(ctx, cf); err != nil {
@@ -19,10 +19,18 @@
 package infra
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/config"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/clock"
 	"github.com/pkg/errors"
 )
 
@@ -91,3 +99,202 @@ func TestInitSystemKV_existing(t *testing.T) {
 		db.QueryRow("SELECT value FROM system WHERE key = ?", "testKey"), &val)
 	assert.Equal(t, val, "testVal", "system value should not have been updated")
 }
+
+func TestInitSystem(t *testing.T) {
+	// Setup
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	c := clock.NewMock()
+	now := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
+	c.SetNow(now)
+
+	ctx := context.DnoteCtx{DB: db, Clock: c}
+
+	// Execute
+	if err := InitSystem(ctx); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// Test
+	var lastUpgrade int64
+	var lastMaxUSN int
+	var lastSyncAt int64
+	database.MustScan(t, "getting last upgrade", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastUpgrade), &lastUpgrade)
+	database.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	database.MustScan(t, "getting last sync at", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastSyncAt), &lastSyncAt)
+
+	assert.Equal(t, lastUpgrade, now.Unix(), "last upgrade mismatch")
+	assert.Equal(t, lastMaxUSN, 0, "last max usn mismatch")
+	assert.Equal(t, lastSyncAt, int64(0), "last sync at mismatch")
+}
+
+func TestGetEditorCommand(t *testing.T) {
+	testCases := []struct {
+		editor   string
+		expected string
+	}{
+		{editor: "", expected: defaultEditor},
+		{editor: "vim", expected: "vim"},
+		{editor: "code", expected: "code -n -w"},
+		{editor: "micro", expected: "micro"},
+		{editor: `"C:\Program Files\Notepad++\notepad++.exe"`, expected: `"C:\Program Files\Notepad++\notepad++.exe"`},
+	}
+
+	editor, ok := os.LookupEnv("EDITOR")
+	defer func() {
+		if ok {
+			os.Setenv("EDITOR", editor)
+		} else {
+			os.Unsetenv("EDITOR")
+		}
+	}()
+
+	for _, tc := range testCases {
+		t.Run(tc.editor, func(t *testing.T) {
+			os.Setenv("EDITOR", tc.editor)
+
+			assert.Equal(t, getEditorCommand(), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	ctx := context.DnoteCtx{
+		APIEndpoint: "http://config.example.com",
+		Editor:      "vim",
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		assert.DeepEqual(t, applyEnv(ctx), ctx, "context mismatch")
+	})
+
+	t.Run("set", func(t *testing.T) {
+		t.Setenv(consts.APIKeyEnv, "env-session-key")
+		t.Setenv(consts.APIEndpointEnv, "http://env.example.com")
+		t.Setenv(consts.EditorEnv, "nano")
+		t.Setenv(consts.ProfileEnv, "/tmp/profiles")
+
+		got := applyEnv(ctx)
+		assert.Equal(t, got.SessionKey, "env-session-key", "session key mismatch")
+		assert.Equal(t, got.APIEndpoint, "http://env.example.com", "api endpoint mismatch")
+		assert.Equal(t, got.Editor, "nano", "editor mismatch")
+		assert.Equal(t, got.SyncProfileDir, "/tmp/profiles", "sync profile dir mismatch")
+	})
+}
+
+func TestGetPaths_home(t *testing.T) {
+	t.Setenv(consts.HomeEnv, "/tmp/dnote-home")
+
+	assert.DeepEqual(t, GetPaths(), context.Paths{
+		Home:        "/tmp/dnote-home",
+		Config:      "/tmp/dnote-home",
+		Data:        "/tmp/dnote-home",
+		Cache:       "/tmp/dnote-home",
+		LegacyDnote: getLegacyDnotePath("/tmp/dnote-home"),
+	}, "paths mismatch")
+}
+
+func TestIsFreshInstall(t *testing.T) {
+	dir := t.TempDir()
+	paths := getDirPaths(dir)
+
+	ok, err := IsFreshInstall(paths)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking a new directory"))
+	}
+	assert.Equal(t, ok, true, "result mismatch for a new directory")
+
+	ctx, err := InitAt(dir, "http://127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "initializing"))
+	}
+	ctx.DB.Close()
+
+	ok, err = IsFreshInstall(paths)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking an initialized directory"))
+	}
+	assert.Equal(t, ok, false, "result mismatch for an initialized directory")
+}
+
+func TestInitAt_dataDir(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(t.TempDir(), "notes")
+
+	cf := DefaultConfig("http://127.0.0.1")
+	cf.DataDir = dataDir
+	ctx := context.DnoteCtx{Paths: getDirPaths(dir)}
+	if err := os.MkdirAll(filepath.Dir(config.GetPath(ctx)), 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "creating the config directory"))
+	}
+	if err := config.Write(ctx, cf); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the config"))
+	}
+
+	got, err := InitAt(dir, "http://127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "initializing"))
+	}
+	defer got.DB.Close()
+
+	assert.Equal(t, got.Paths.Data, dataDir, "data directory mismatch")
+
+	ok, err := utils.FileExists(filepath.Join(dataDir, consts.DnoteDirName, consts.DnoteDBFileName))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking the database"))
+	}
+	assert.Equal(t, ok, true, "the database should be in the data directory")
+}
+
+func TestInitAt_duplicateNoteUUIDs(t *testing.T) {
+	dir := t.TempDir()
+
+	// set up a database without the index on the note uuids, as one restored
+	// from an old backup may be
+	ctx, err := InitAt(dir, "http://127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "initializing"))
+	}
+	db := ctx.DB
+	database.MustExec(t, "dropping the note uuid index", db, "DROP INDEX idx_notes_uuid")
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 usn 2", 1541108743, 2, true, false)
+	database.MustExec(t, "inserting n1 with a higher usn", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 usn 5", 1541108743, 5, false, false)
+	database.MustExec(t, "inserting removed n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "", 1541108743, 1, true, true)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", "n2 body", 1541108743, 3, false, false)
+	db.Close()
+
+	// execute
+	ctx, err = InitAt(dir, "http://127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "initializing with duplicate note uuids"))
+	}
+	defer ctx.DB.Close()
+	db = ctx.DB
+
+	// test
+	var n1Count, noteCount int
+	database.MustScan(t, "counting n1", db.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", "n1-uuid"), &n1Count)
+	database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+	assert.Equal(t, n1Count, 1, "n1 count mismatch")
+	assert.Equal(t, noteCount, 3, "note count mismatch")
+
+	var n1Body string
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT body FROM notes WHERE uuid = ?", "n1-uuid"), &n1Body)
+	assert.Equal(t, n1Body, "n1 usn 5", "n1 body mismatch")
+
+	var movedUUID, movedLabel string
+	var movedUSN int
+	var movedDirty bool
+	database.MustScan(t, "getting the moved note", db.QueryRow(`SELECT notes.uuid, books.label, notes.usn, notes.dirty
+		FROM notes INNER JOIN books ON books.uuid = notes.book_uuid WHERE notes.body = ?`, "n1 usn 2"), &movedUUID, &movedLabel, &movedUSN, &movedDirty)
+	assert.NotEqual(t, movedUUID, "n1-uuid", "moved note uuid mismatch")
+	assert.Equal(t, movedLabel, "recovered", "moved note book mismatch")
+	assert.Equal(t, movedUSN, 0, "moved note usn mismatch")
+	assert.Equal(t, movedDirty, true, "moved note dirty mismatch")
+
+	var indexCount int
+	database.MustScan(t, "counting the index", db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = ? AND name = ?", "index", "idx_notes_uuid"), &indexCount)
+	assert.Equal(t, indexCount, 1, "index count mismatch")
+}
@@ -0,0 +1,158 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// ErrSyncLocked is an error for a sync lock held by another process
+var ErrSyncLocked = errors.New("another sync is in progress")
+
+// getSyncLockPath returns the path to the sync lock file, which resides next to
+// the database file
+func getSyncLockPath(ctx context.DnoteCtx) string {
+	return filepath.Join(filepath.Dir(ctx.DB.Filepath), consts.SyncLockFilename)
+}
+
+// isStaleSyncLock checks if the sync lock file at the given path was left by a
+// process that is no longer running, e.g. after a crash
+func isStaleSyncLock(path string) bool {
+	pid, err := getSyncLockHolder(path)
+	if err != nil || pid == 0 {
+		return false
+	}
+
+	return !processExists(pid)
+}
+
+// AcquireSyncLock creates the sync lock file. It returns ErrSyncLocked if the
+// file already exists, unless the process that created it is no longer
+// running, in which case the stale lock is replaced.
+func AcquireSyncLock(ctx context.DnoteCtx) error {
+	path := getSyncLockPath(ctx)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) && isStaleSyncLock(path) {
+		log.Warn(i18n.T("init.stale_lock", path))
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "removing the stale lock file")
+		}
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if os.IsExist(err) {
+		return errors.Wrapf(ErrSyncLocked, "lock file %s exists. If no other dnote process is running, remove it and try again", path)
+	} else if err != nil {
+		return errors.Wrap(err, "creating the lock file")
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+		return errors.Wrap(err, "writing the lock file")
+	}
+
+	return nil
+}
+
+// ReleaseSyncLock removes the sync lock file
+func ReleaseSyncLock(ctx context.DnoteCtx) error {
+	if err := os.Remove(getSyncLockPath(ctx)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing the lock file")
+	}
+
+	return nil
+}
+
+// IsSyncLocked checks if the sync lock is held by a running process
+func IsSyncLocked(ctx context.DnoteCtx) (bool, error) {
+	path := getSyncLockPath(ctx)
+
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "checking the lock file")
+	}
+
+	return !isStaleSyncLock(path), nil
+}
+
+// getSyncLockHolder returns the ID of the process that holds the sync lock
+// file at the given path, or 0 if the lock is not held
+func getSyncLockHolder(path string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "reading the lock file")
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, errors.Wrap(err, "parsing the lock file")
+	}
+
+	return pid, nil
+}
+
+// SyncLockHolder returns the ID of the process that holds the sync lock, or 0
+// if the lock is not held by a running process
+func SyncLockHolder(ctx context.DnoteCtx) (int, error) {
+	pid, err := getSyncLockHolder(getSyncLockPath(ctx))
+	if err != nil {
+		return 0, err
+	}
+	if pid == 0 || !processExists(pid) {
+		return 0, nil
+	}
+
+	return pid, nil
+}
+
+func busyMessage(lockPath string) string {
+	pid, err := getSyncLockHolder(lockPath)
+	if err == nil && pid != 0 && processExists(pid) {
+		return fmt.Sprintf("dnote is busy syncing in process %d; retried for %s. Try again shortly", pid, busyRetryWindow)
+	}
+
+	return fmt.Sprintf("the database is in use by another dnote process; retried for %s. Try again shortly", busyRetryWindow)
+}
+
+// BusyMessage explains an error caused by the database being locked by another
+// process, naming the process if it is syncing. It does not need a context so
+// that it can explain the errors during the initialization.
+func BusyMessage() string {
+	// the default paths are used if the config file cannot be read
+	paths, _ := withDataDir(GetPaths())
+	dbPath := getDBPath(paths)
+
+	return busyMessage(filepath.Join(filepath.Dir(dbPath), consts.SyncLockFilename))
+}
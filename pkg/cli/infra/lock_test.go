@@ -0,0 +1,159 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func TestSyncLock(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db}
+
+	locked, err := IsSyncLocked(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking the lock"))
+	}
+	assert.Equal(t, locked, false, "locked mismatch before acquiring")
+
+	if err := AcquireSyncLock(ctx); err != nil {
+		t.Fatal(errors.Wrap(err, "acquiring the lock"))
+	}
+
+	locked, err = IsSyncLocked(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking the lock"))
+	}
+	assert.Equal(t, locked, true, "locked mismatch after acquiring")
+
+	err = AcquireSyncLock(ctx)
+	assert.Equal(t, errors.Cause(err), ErrSyncLocked, "error mismatch for the second acquisition")
+
+	if err := ReleaseSyncLock(ctx); err != nil {
+		t.Fatal(errors.Wrap(err, "releasing the lock"))
+	}
+
+	locked, err = IsSyncLocked(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking the lock"))
+	}
+	assert.Equal(t, locked, false, "locked mismatch after releasing")
+}
+
+// exitedPID returns the ID of a process that has exited
+func exitedPID(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(errors.Wrap(err, "running a process"))
+	}
+
+	return cmd.Process.Pid
+}
+
+func writeSyncLock(t *testing.T, ctx context.DnoteCtx, pid int) {
+	if err := ioutil.WriteFile(getSyncLockPath(ctx), []byte(fmt.Sprintf("%d\n", pid)), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the lock file"))
+	}
+}
+
+func TestProcessExists(t *testing.T) {
+	assert.Equal(t, processExists(os.Getpid()), true, "result mismatch for the current process")
+	assert.Equal(t, processExists(exitedPID(t)), false, "result mismatch for an exited process")
+}
+
+func TestSyncLock_stale(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db}
+	writeSyncLock(t, ctx, exitedPID(t))
+	defer ReleaseSyncLock(ctx)
+
+	locked, err := IsSyncLocked(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking the lock"))
+	}
+	assert.Equal(t, locked, false, "locked mismatch for a stale lock")
+
+	if err := AcquireSyncLock(ctx); err != nil {
+		t.Fatal(errors.Wrap(err, "acquiring a stale lock"))
+	}
+
+	pid, err := getSyncLockHolder(getSyncLockPath(ctx))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the lock holder"))
+	}
+	assert.Equal(t, pid, os.Getpid(), "lock holder mismatch")
+}
+
+func TestBusyMessage(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db}
+	lockPath := getSyncLockPath(ctx)
+	defer ReleaseSyncLock(ctx)
+
+	assert.Equal(t, busyMessage(lockPath), "the database is in use by another dnote process; retried for 5s. Try again shortly", "message mismatch without the lock")
+
+	writeSyncLock(t, ctx, os.Getpid())
+	assert.Equal(t, busyMessage(lockPath), fmt.Sprintf("dnote is busy syncing in process %d; retried for 5s. Try again shortly", os.Getpid()), "message mismatch with the lock")
+
+	writeSyncLock(t, ctx, exitedPID(t))
+	assert.Equal(t, busyMessage(lockPath), "the database is in use by another dnote process; retried for 5s. Try again shortly", "message mismatch with a stale lock")
+}
+
+func TestSyncLockHolder(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db}
+	defer ReleaseSyncLock(ctx)
+
+	pid, err := SyncLockHolder(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the holder without the lock"))
+	}
+	assert.Equal(t, pid, 0, "holder mismatch without the lock")
+
+	writeSyncLock(t, ctx, os.Getpid())
+	pid, err = SyncLockHolder(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the holder with the lock"))
+	}
+	assert.Equal(t, pid, os.Getpid(), "holder mismatch with the lock")
+
+	writeSyncLock(t, ctx, exitedPID(t))
+	pid, err = SyncLockHolder(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the holder with a stale lock"))
+	}
+	assert.Equal(t, pid, 0, "holder mismatch with a stale lock")
+}
@@ -0,0 +1,65 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+)
+
+// ShareFromServer returns the share link from the server as it is cached
+func ShareFromServer(s client.Share) database.Share {
+	ret := database.Share{
+		ID:       s.ID,
+		NoteUUID: s.NoteUUID,
+		URL:      s.URL,
+	}
+	if !s.CreatedAt.IsZero() {
+		ret.CreatedAt = s.CreatedAt.UnixNano()
+	}
+	if s.ExpiresAt != nil {
+		ret.ExpiresAt = s.ExpiresAt.UnixNano()
+	}
+
+	return ret
+}
+
+// RefreshShares replaces the cached share links of the note with the ones on
+// the server, and returns them
+func RefreshShares(ctx context.DnoteCtx, db *database.DB, noteUUID string) ([]database.Share, error) {
+	resp, err := client.GetShares(ctx, noteUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]database.Share, 0, len(resp))
+	for _, s := range resp {
+		share := ShareFromServer(s)
+		// the server may leave out the note of the links to it
+		share.NoteUUID = noteUUID
+		ret = append(ret, share)
+	}
+
+	if err := database.ReplaceNoteShares(db, noteUUID, ret); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
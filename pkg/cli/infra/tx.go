@@ -0,0 +1,83 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// busyRetryWindow is how long WithTxRetry keeps retrying a transaction while
+// the database is locked by another process
+var busyRetryWindow = 5 * time.Second
+
+// busyRetryInterval is the wait between the retries of WithTxRetry
+var busyRetryInterval = 100 * time.Millisecond
+
+// WithTx runs the given function in a transaction. The transaction is committed
+// if the function returns nil, and rolled back if it returns an error or
+// panics. The error from the function is returned as it is.
+func WithTx(db *database.DB, fn func(tx *database.DB) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "beginning a transaction")
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "committing a transaction")
+	}
+
+	return nil
+}
+
+// WithTxRetry runs the given function in a transaction like WithTx, and runs it
+// again while the database is locked by another process, for up to
+// busyRetryWindow. A transaction that has read the database fails immediately
+// rather than waiting for the lock if another process is writing, so the busy
+// timeout of the connection alone does not cover it. The function must be
+// safe to run more than once.
+func WithTxRetry(db *database.DB, fn func(tx *database.DB) error) error {
+	start := time.Now()
+
+	for {
+		err := WithTx(db, fn)
+		if err == nil || !database.IsBusyError(err) || time.Since(start) >= busyRetryWindow {
+			return err
+		}
+
+		log.Debug("the database is locked. retrying the transaction\n")
+		time.Sleep(busyRetryInterval)
+	}
+}
@@ -0,0 +1,169 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func countBooks(t *testing.T, db *database.DB) int {
+	var count int
+	database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &count)
+
+	return count
+}
+
+func TestWithTx(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	err := WithTx(db, func(tx *database.DB) error {
+		database.MustExec(t, "inserting b1", tx, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, countBooks(t, db), 1, "book count mismatch")
+}
+
+func TestWithTx_error(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	fnErr := errors.New("some error")
+
+	err := WithTx(db, func(tx *database.DB) error {
+		database.MustExec(t, "inserting b1", tx, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+		return fnErr
+	})
+
+	assert.Equal(t, err, fnErr, "error mismatch")
+	assert.Equal(t, countBooks(t, db), 0, "book count mismatch")
+}
+
+func TestWithTx_panic(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	func() {
+		defer func() {
+			assert.Equal(t, recover(), "some panic", "panic mismatch")
+		}()
+
+		WithTx(db, func(tx *database.DB) error {
+			database.MustExec(t, "inserting b1", tx, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+			panic("some panic")
+		})
+	}()
+
+	assert.Equal(t, countBooks(t, db), 0, "book count mismatch")
+
+	// the connection is released so that another transaction can begin
+	err := WithTx(db, func(tx *database.DB) error {
+		database.MustExec(t, "inserting b2", tx, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "b2-label")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing after a panic"))
+	}
+
+	assert.Equal(t, countBooks(t, db), 1, "book count mismatch after a panic")
+}
+
+// openLockedDB opens two connections to a new database file, and begins a
+// transaction in the first one that holds the write lock. The returned
+// function rolls back the transaction.
+func openLockedDB(t *testing.T) (*database.DB, func()) {
+	path := filepath.Join(t.TempDir(), "dnote.db")
+
+	holder, err := database.Open(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the holder connection"))
+	}
+	t.Cleanup(func() { holder.Close() })
+	database.MustExec(t, "creating a table", holder, "CREATE TABLE books (uuid text PRIMARY KEY, label text NOT NULL)")
+
+	db, err := database.Open(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the connection"))
+	}
+	t.Cleanup(func() { db.Close() })
+
+	tx, err := holder.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+	database.MustExec(t, "inserting b1", tx, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+
+	return db, func() { tx.Rollback() }
+}
+
+// insertAfterRead reads the database before writing to it, which makes the
+// transaction fail immediately if another connection holds the write lock
+func insertAfterRead(tx *database.DB) error {
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM books").Scan(&count); err != nil {
+		return errors.Wrap(err, "counting books")
+	}
+
+	if _, err := tx.Exec("INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "b2-label"); err != nil {
+		return errors.Wrap(err, "inserting b2")
+	}
+
+	return nil
+}
+
+func setBusyRetryWindow(t *testing.T, d time.Duration) {
+	window := busyRetryWindow
+	busyRetryWindow = d
+	t.Cleanup(func() { busyRetryWindow = window })
+}
+
+func TestWithTxRetry(t *testing.T) {
+	setBusyRetryWindow(t, 3*time.Second)
+
+	db, release := openLockedDB(t)
+
+	timer := time.AfterFunc(300*time.Millisecond, release)
+	defer timer.Stop()
+
+	if err := WithTxRetry(db, insertAfterRead); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, countBooks(t, db), 1, "book count mismatch")
+}
+
+func TestWithTxRetry_timeout(t *testing.T) {
+	setBusyRetryWindow(t, 300*time.Millisecond)
+
+	db, release := openLockedDB(t)
+	defer release()
+
+	err := WithTxRetry(db, insertAfterRead)
+	assert.Equal(t, database.IsBusyError(err), true, "busy error mismatch")
+}
@@ -0,0 +1,48 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+)
+
+// RecordNoteView counts a view of the note with the given rowid. It is
+// best-effort so that a note is still shown when the database is locked by
+// another process or cannot be written, in which case the view is not counted.
+// The commands that show notes open the database read-only, so that they work
+// on a read-only file system, and the view is written on a separate connection.
+func RecordNoteView(ctx context.DnoteCtx, rowID int) {
+	db := ctx.DB
+	if db.ReadOnly {
+		rw, err := database.Open(db.Filepath)
+		if err != nil {
+			log.Debug("not counting the view of the note %d: %s\n", rowID, err.Error())
+			return
+		}
+		defer rw.Close()
+
+		db = rw
+	}
+
+	if err := database.RecordNoteView(db, ctx.Clock, rowID); err != nil {
+		log.Debug("not counting the view of the note %d: %s\n", rowID, err.Error())
+	}
+}
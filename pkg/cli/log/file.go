@@ -0,0 +1,299 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileLevel is the level of the entries written to a log file
+type FileLevel int
+
+const (
+	// FileLevelOff disables the log file
+	FileLevelOff FileLevel = iota
+	// FileLevelInfo writes the summaries of the sync decisions and the HTTP calls
+	FileLevelInfo
+	// FileLevelDebug additionally writes the contents of the sync fragments
+	FileLevelDebug
+)
+
+// ParseFileLevel parses the name of a level as it appears in the config file
+func ParseFileLevel(s string) (FileLevel, error) {
+	switch s {
+	case "", "off":
+		return FileLevelOff, nil
+	case "info":
+		return FileLevelInfo, nil
+	case "debug":
+		return FileLevelDebug, nil
+	}
+
+	return FileLevelOff, errors.Errorf("unknown log level '%s'. Use info or debug", s)
+}
+
+func (l FileLevel) String() string {
+	switch l {
+	case FileLevelInfo:
+		return "info"
+	case FileLevelDebug:
+		return "debug"
+	}
+
+	return "off"
+}
+
+// Fields are the data of a log file entry
+type Fields map[string]interface{}
+
+const (
+	// defaultMaxFileSize is the size in bytes at which the log file is rotated
+	defaultMaxFileSize = 1 << 20
+	// defaultMaxBackups is the number of the rotated log files kept
+	defaultMaxBackups = 3
+)
+
+// FileLogger writes structured entries to a log file as JSON lines. The file
+// is created at the first entry and rotated by size. A nil FileLogger
+// discards all entries.
+type FileLogger struct {
+	mu         sync.Mutex
+	level      FileLevel
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	failed     bool
+	now        func() time.Time
+}
+
+// NewFileLogger returns a new file logger writing to the given path
+func NewFileLogger(path string, level FileLevel) *FileLogger {
+	return &FileLogger{
+		level:      level,
+		path:       path,
+		maxSize:    defaultMaxFileSize,
+		maxBackups: defaultMaxBackups,
+		now:        time.Now,
+	}
+}
+
+var stdFile = NewFileLogger("", FileLevelOff)
+
+// DefaultFile returns the file logger configured for the current run
+func DefaultFile() *FileLogger {
+	return stdFile
+}
+
+// SetLevel sets the level of the file logger
+func (l *FileLogger) SetLevel(level FileLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.level = level
+}
+
+// Level returns the level of the file logger
+func (l *FileLogger) Level() FileLevel {
+	if l == nil {
+		return FileLevelOff
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.level
+}
+
+// SetPath sets the path of the log file. Subsequent entries are written to
+// the new path.
+func (l *FileLogger) SetPath(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closeFile()
+	l.path = path
+	l.failed = false
+}
+
+// Path returns the path of the log file
+func (l *FileLogger) Path() string {
+	if l == nil {
+		return ""
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.path
+}
+
+// Info writes an entry for the given event if the level is info or above
+func (l *FileLogger) Info(event string, fields Fields) {
+	l.write(FileLevelInfo, event, fields)
+}
+
+// Debug writes an entry for the given event if the level is debug
+func (l *FileLogger) Debug(event string, fields Fields) {
+	l.write(FileLevelDebug, event, fields)
+}
+
+// Close closes the log file
+func (l *FileLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.closeFile()
+}
+
+func (l *FileLogger) closeFile() error {
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+	l.size = 0
+
+	return err
+}
+
+func (l *FileLogger) write(level FileLevel, event string, fields Fields) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.level < level || l.path == "" || l.failed {
+		return
+	}
+
+	entry := Fields{}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = l.now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["event"] = event
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		l.fail(errors.Wrapf(err, "marshalling the entry for %s", event))
+		return
+	}
+	b = append(b, '\n')
+
+	if err := l.writeLine(b); err != nil {
+		l.fail(err)
+	}
+}
+
+// fail disables the file logger after warning about the error once so that a
+// broken log file does not interrupt the command
+func (l *FileLogger) fail(err error) {
+	l.failed = true
+	l.closeFile()
+
+	Warnf("disabling the log file: %s\n", err.Error())
+}
+
+func (l *FileLogger) writeLine(b []byte) error {
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return errors.Wrap(err, "opening the log file")
+		}
+	}
+
+	if l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return errors.Wrap(err, "rotating the log file")
+		}
+	}
+
+	n, err := l.file.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "writing the log file")
+	}
+
+	return nil
+}
+
+func (l *FileLogger) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return errors.Wrap(err, "creating the directory")
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "opening the file")
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "getting the file info")
+	}
+
+	l.file = f
+	l.size = fi.Size()
+
+	return nil
+}
+
+// backupPath returns the path of the n-th most recent rotated log file
+func (l *FileLogger) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// rotate renames the log file to the first backup, shifting the existing
+// backups and removing the oldest one, and opens a new log file
+func (l *FileLogger) rotate() error {
+	if err := l.closeFile(); err != nil {
+		return errors.Wrap(err, "closing the file")
+	}
+
+	if err := os.Remove(l.backupPath(l.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing the oldest backup")
+	}
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(l.backupPath(i), l.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "renaming the backup %d", i)
+		}
+	}
+	if err := os.Rename(l.path, l.backupPath(1)); err != nil {
+		return errors.Wrap(err, "renaming the file")
+	}
+
+	return l.open()
+}
@@ -0,0 +1,170 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func readEntries(t *testing.T, path string) []Fields {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the log file"))
+	}
+	defer f.Close()
+
+	var ret []Fields
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Fields
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(errors.Wrapf(err, "unmarshalling %s", scanner.Text()))
+		}
+
+		ret = append(ret, entry)
+	}
+
+	return ret
+}
+
+func TestParseFileLevel(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected FileLevel
+	}{
+		{input: "", expected: FileLevelOff},
+		{input: "off", expected: FileLevelOff},
+		{input: "info", expected: FileLevelInfo},
+		{input: "debug", expected: FileLevelDebug},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := ParseFileLevel(tc.input)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.Equal(t, result, tc.expected, "result mismatch")
+		})
+	}
+
+	_, err := ParseFileLevel("trace")
+	assert.NotEqual(t, err, nil, "error was not returned for an unknown level")
+}
+
+func TestFileLogger(t *testing.T) {
+	testCases := []struct {
+		level    FileLevel
+		expected []string
+	}{
+		{level: FileLevelOff, expected: nil},
+		{level: FileLevelInfo, expected: []string{"e1"}},
+		{level: FileLevelDebug, expected: []string{"e1", "e2"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.level.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "logs", "dnote.log")
+
+			l := NewFileLogger(path, tc.level)
+			l.now = func() time.Time {
+				return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			}
+
+			l.Info("e1", Fields{"uuid": "n1-uuid", "usn": 3})
+			l.Debug("e2", nil)
+			if err := l.Close(); err != nil {
+				t.Fatal(errors.Wrap(err, "closing"))
+			}
+
+			if tc.expected == nil {
+				_, err := os.Stat(path)
+				assert.Equal(t, os.IsNotExist(err), true, "log file was created")
+				return
+			}
+
+			entries := readEntries(t, path)
+			assert.Equal(t, len(entries), len(tc.expected), "entry count mismatch")
+			for i, event := range tc.expected {
+				assert.Equal(t, entries[i]["event"], event, fmt.Sprintf("event %d mismatch", i))
+				assert.Equal(t, entries[i]["time"], "2020-01-02T03:04:05Z", fmt.Sprintf("time %d mismatch", i))
+			}
+			assert.Equal(t, entries[0]["level"], "info", "level mismatch")
+			assert.Equal(t, entries[0]["uuid"], "n1-uuid", "uuid mismatch")
+			assert.Equal(t, entries[0]["usn"], float64(3), "usn mismatch")
+		})
+	}
+}
+
+func TestFileLogger_rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnote.log")
+
+	l := NewFileLogger(path, FileLevelInfo)
+	l.maxSize = 200
+	l.maxBackups = 2
+
+	for i := 0; i < 20; i++ {
+		l.Info("entry", Fields{"n": i})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing"))
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "getting the file info of %s", p))
+		}
+		if fi.Size() > l.maxSize {
+			t.Errorf("%s exceeds the maximum size: %d", p, fi.Size())
+		}
+	}
+
+	_, err := os.Stat(path + ".3")
+	assert.Equal(t, os.IsNotExist(err), true, "too many backups were kept")
+
+	// the last entry is in the current file, and the older ones in the backups
+	entries := readEntries(t, path)
+	assert.Equal(t, entries[len(entries)-1]["n"], float64(19), "last entry mismatch")
+
+	backup := readEntries(t, path+".1")
+	assert.Equal(t, backup[len(backup)-1]["n"], entries[0]["n"].(float64)-1, "backup continuity mismatch")
+}
+
+func TestFileLogger_nil(t *testing.T) {
+	var l *FileLogger
+
+	l.Info("e1", Fields{"uuid": "n1-uuid"})
+	l.Debug("e2", nil)
+
+	assert.Equal(t, l.Level(), FileLevelOff, "level mismatch")
+	assert.Equal(t, l.Path(), "", "path mismatch")
+	assert.Equal(t, l.Close(), nil, "close error mismatch")
+}
@@ -39,54 +39,69 @@ var (
 
 var indent = "  "
 
+// SetLevel sets the level of the default logger
+func SetLevel(level Level) {
+	std.SetLevel(level)
+}
+
 // Info prints information
 func Info(msg string) {
-	fmt.Fprintf(color.Output, "%s%s %s", indent, ColorBlue.Sprint("•"), msg)
+	std.Infof("%s", msg)
 }
 
 // Infof prints information with optional format verbs
 func Infof(msg string, v ...interface{}) {
-	fmt.Fprintf(color.Output, "%s%s %s", indent, ColorBlue.Sprint("•"), fmt.Sprintf(msg, v...))
+	std.Infof(msg, v...)
 }
 
 // Success prints a success message
 func Success(msg string) {
-	fmt.Fprintf(color.Output, "%s%s %s", indent, ColorGreen.Sprint("✔"), msg)
+	std.Successf("%s", msg)
 }
 
 // Successf prints a success message with optional format verbs
 func Successf(msg string, v ...interface{}) {
-	fmt.Fprintf(color.Output, "%s%s %s", indent, ColorGreen.Sprint("✔"), fmt.Sprintf(msg, v...))
+	std.Successf(msg, v...)
 }
 
 // Plain prints a plain message without any prefix symbol
 func Plain(msg string) {
-	fmt.Printf("%s%s", indent, msg)
+	std.Plainf("%s", msg)
 }
 
 // Plainf prints a plain message without any prefix symbol. It takes optional format verbs.
 func Plainf(msg string, v ...interface{}) {
-	fmt.Printf("%s%s", indent, fmt.Sprintf(msg, v...))
+	std.Plainf(msg, v...)
+}
+
+// Warn prints a warning message
+func Warn(msg string) {
+	std.Warnf("%s", msg)
 }
 
 // Warnf prints a warning message with optional format verbs
 func Warnf(msg string, v ...interface{}) {
-	fmt.Fprintf(color.Output, "%s%s %s", indent, ColorRed.Sprint("•"), fmt.Sprintf(msg, v...))
+	std.Warnf(msg, v...)
 }
 
 // Error prints an error message
 func Error(msg string) {
-	fmt.Fprintf(color.Output, "%s%s %s", indent, ColorRed.Sprint("⨯"), msg)
+	std.Errorf("%s", msg)
 }
 
 // Errorf prints an error message with optional format verbs
 func Errorf(msg string, v ...interface{}) {
-	fmt.Fprintf(color.Output, "%s%s %s", indent, ColorRed.Sprintf("⨯"), fmt.Sprintf(msg, v...))
+	std.Errorf(msg, v...)
 }
 
 // Printf prints an normal message
 func Printf(msg string, v ...interface{}) {
-	fmt.Fprintf(color.Output, "%s%s %s", indent, ColorGray.Sprint("•"), fmt.Sprintf(msg, v...))
+	std.Printf(msg, v...)
+}
+
+// Verbosef prints a detailed message if the default logger is verbose
+func Verbosef(msg string, v ...interface{}) {
+	std.Verbosef(msg, v...)
 }
 
 // Askf prints an question with optional format verbs. The leading symbol differs in color depending
@@ -0,0 +1,133 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package log
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dnote/color"
+)
+
+// Level is the verbosity level of a Logger
+type Level int
+
+const (
+	// LevelQuiet suppresses everything but errors
+	LevelQuiet Level = iota
+	// LevelNormal prints the regular progress messages
+	LevelNormal
+	// LevelVerbose additionally prints detailed messages about the internal decisions
+	LevelVerbose
+)
+
+// Logger is a leveled logger. Regular messages are written to the output,
+// and verbose messages are written to the error output so that they do not
+// interfere with the data printed by the commands.
+type Logger struct {
+	level  Level
+	out    io.Writer
+	errOut io.Writer
+}
+
+// NewLogger returns a new logger writing to the given outputs at the normal level
+func NewLogger(out, errOut io.Writer) *Logger {
+	return &Logger{
+		level:  LevelNormal,
+		out:    out,
+		errOut: errOut,
+	}
+}
+
+var std = NewLogger(color.Output, color.Error)
+
+// Default returns the logger used by the package level functions
+func Default() *Logger {
+	return std
+}
+
+// SetLevel sets the level of the logger
+func (l *Logger) SetLevel(level Level) {
+	l.level = level
+}
+
+// Level returns the level of the logger
+func (l *Logger) Level() Level {
+	return l.level
+}
+
+// SetOutput sets the output and the error output of the logger
+func (l *Logger) SetOutput(out, errOut io.Writer) {
+	l.out = out
+	l.errOut = errOut
+}
+
+// Output returns the output and the error output of the logger
+func (l *Logger) Output() (io.Writer, io.Writer) {
+	return l.out, l.errOut
+}
+
+func (l *Logger) print(min Level, w io.Writer, msg string) {
+	if l.level < min {
+		return
+	}
+
+	fmt.Fprint(w, msg)
+}
+
+// Infof prints information with optional format verbs
+func (l *Logger) Infof(msg string, v ...interface{}) {
+	l.print(LevelNormal, l.out, fmt.Sprintf("%s%s %s", indent, ColorBlue.Sprint("•"), fmt.Sprintf(msg, v...)))
+}
+
+// Successf prints a success message with optional format verbs
+func (l *Logger) Successf(msg string, v ...interface{}) {
+	l.print(LevelNormal, l.out, fmt.Sprintf("%s%s %s", indent, ColorGreen.Sprint("✔"), fmt.Sprintf(msg, v...)))
+}
+
+// Plainf prints a plain message without any prefix symbol. It takes optional format verbs.
+func (l *Logger) Plainf(msg string, v ...interface{}) {
+	l.print(LevelNormal, l.out, fmt.Sprintf("%s%s", indent, fmt.Sprintf(msg, v...)))
+}
+
+// Rawf prints a message as it is, without any indentation or prefix symbol.
+// It is useful for appending to a line printed previously.
+func (l *Logger) Rawf(msg string, v ...interface{}) {
+	l.print(LevelNormal, l.out, fmt.Sprintf(msg, v...))
+}
+
+// Printf prints an normal message
+func (l *Logger) Printf(msg string, v ...interface{}) {
+	l.print(LevelNormal, l.out, fmt.Sprintf("%s%s %s", indent, ColorGray.Sprint("•"), fmt.Sprintf(msg, v...)))
+}
+
+// Warnf prints a warning message with optional format verbs
+func (l *Logger) Warnf(msg string, v ...interface{}) {
+	l.print(LevelNormal, l.out, fmt.Sprintf("%s%s %s", indent, ColorRed.Sprint("•"), fmt.Sprintf(msg, v...)))
+}
+
+// Errorf prints an error message with optional format verbs. Errors are printed at every level.
+func (l *Logger) Errorf(msg string, v ...interface{}) {
+	l.print(LevelQuiet, l.out, fmt.Sprintf("%s%s %s", indent, ColorRed.Sprint("⨯"), fmt.Sprintf(msg, v...)))
+}
+
+// Verbosef prints a detailed message to the error output if the logger is verbose
+func (l *Logger) Verbosef(msg string, v ...interface{}) {
+	l.print(LevelVerbose, l.errOut, fmt.Sprintf("%s %s", ColorGray.Sprint("VERBOSE:"), fmt.Sprintf(msg, v...)))
+}
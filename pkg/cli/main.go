@@ -21,22 +21,53 @@ package main
 import (
 	"os"
 
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
+	dbmigrate "github.com/dnote/dnote/pkg/cli/migrate"
+	"github.com/dnote/dnote/pkg/cli/onboarding"
+	"github.com/dnote/dnote/pkg/cli/output"
+	"github.com/dnote/dnote/pkg/cli/ui"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 
 	// commands
 	"github.com/dnote/dnote/pkg/cli/cmd/add"
+	"github.com/dnote/dnote/pkg/cli/cmd/alias"
+	"github.com/dnote/dnote/pkg/cli/cmd/appender"
+	"github.com/dnote/dnote/pkg/cli/cmd/backup"
+	"github.com/dnote/dnote/pkg/cli/cmd/book"
 	"github.com/dnote/dnote/pkg/cli/cmd/cat"
+	"github.com/dnote/dnote/pkg/cli/cmd/credentials"
+	"github.com/dnote/dnote/pkg/cli/cmd/db"
+	"github.com/dnote/dnote/pkg/cli/cmd/digest"
+	"github.com/dnote/dnote/pkg/cli/cmd/doctor"
 	"github.com/dnote/dnote/pkg/cli/cmd/edit"
+	"github.com/dnote/dnote/pkg/cli/cmd/export"
 	"github.com/dnote/dnote/pkg/cli/cmd/find"
+	"github.com/dnote/dnote/pkg/cli/cmd/importer"
+	"github.com/dnote/dnote/pkg/cli/cmd/journal"
+	"github.com/dnote/dnote/pkg/cli/cmd/last"
 	"github.com/dnote/dnote/pkg/cli/cmd/login"
 	"github.com/dnote/dnote/pkg/cli/cmd/logout"
 	"github.com/dnote/dnote/pkg/cli/cmd/ls"
+	"github.com/dnote/dnote/pkg/cli/cmd/migrate"
+	"github.com/dnote/dnote/pkg/cli/cmd/open"
+	"github.com/dnote/dnote/pkg/cli/cmd/purge"
 	"github.com/dnote/dnote/pkg/cli/cmd/remove"
+	"github.com/dnote/dnote/pkg/cli/cmd/reorder"
+	"github.com/dnote/dnote/pkg/cli/cmd/restore"
+	"github.com/dnote/dnote/pkg/cli/cmd/retention"
 	"github.com/dnote/dnote/pkg/cli/cmd/root"
+	"github.com/dnote/dnote/pkg/cli/cmd/schema"
+	"github.com/dnote/dnote/pkg/cli/cmd/serve"
+	"github.com/dnote/dnote/pkg/cli/cmd/share"
+	"github.com/dnote/dnote/pkg/cli/cmd/status"
 	"github.com/dnote/dnote/pkg/cli/cmd/sync"
+	"github.com/dnote/dnote/pkg/cli/cmd/trash"
+	"github.com/dnote/dnote/pkg/cli/cmd/upgrade"
 	"github.com/dnote/dnote/pkg/cli/cmd/version"
 	"github.com/dnote/dnote/pkg/cli/cmd/view"
 )
@@ -45,12 +76,55 @@ import (
 var apiEndpoint string
 var versionTag = "master"
 
-func main() {
-	ctx, err := infra.Init(apiEndpoint, versionTag)
+// readOnlyMessage explains an attempt to write to a read-only database
+const readOnlyMessage = "the database is read-only. Check the permissions of the database file and its directory"
+
+// initCtx initializes the Dnote environment if the command given by the
+// arguments needs it. A new user is guided through the setup first, unless
+// the user cannot be prompted.
+func initCtx(args []string) *context.DnoteCtx {
+	if root.ShouldOnboard(args) && ui.IsInteractive() {
+		if err := onboarding.Run(onboarding.NewTerminalPrompter(), apiEndpoint, versionTag); err != nil {
+			log.Errorf("%s\n", errors.Wrap(err, "setting up dnote").Error())
+			os.Exit(1)
+		}
+	}
+
+	if !root.NeedsEnv(args) {
+		return infra.InitStandalone(versionTag)
+	}
+
+	ctx, err := infra.Init(apiEndpoint, versionTag, root.IsReadOnly(args))
 	if err != nil {
+		if database.IsReadOnlyError(err) {
+			log.Errorf("%s\n", errors.Wrap(err, readOnlyMessage).Error())
+			os.Exit(1)
+		}
+		if database.IsBusyError(err) {
+			log.Errorf("%s\n", errors.Wrap(err, infra.BusyMessage()).Error())
+			os.Exit(1)
+		}
+		if dbmigrate.IsSchemaError(err) {
+			log.Errorf("%s. Only the commands that do not modify the database can be run\n", errors.Cause(err).Error())
+			os.Exit(1)
+		}
+
 		panic(errors.Wrap(err, "initializing context"))
 	}
-	defer ctx.DB.Close()
+
+	return ctx
+}
+
+func main() {
+	root.SetVersion(versionTag)
+	// the config may override the locale once it is read
+	i18n.SetLocale(i18n.Detect("", os.Getenv))
+
+	ctx := initCtx(os.Args[1:])
+	if ctx.DB != nil {
+		defer ctx.DB.Close()
+	}
+	defer ctx.FileLogger.Close()
 
 	root.Register(remove.NewCmd(*ctx))
 	root.Register(edit.NewCmd(*ctx))
@@ -63,8 +137,50 @@ func main() {
 	root.Register(cat.NewCmd(*ctx))
 	root.Register(view.NewCmd(*ctx))
 	root.Register(find.NewCmd(*ctx))
+	root.Register(alias.NewCmd(*ctx))
+	root.Register(open.NewCmd(*ctx))
+	root.Register(migrate.NewCmd(*ctx))
+	root.Register(db.NewCmd(*ctx))
+	root.Register(doctor.NewCmd(*ctx))
+	root.Register(backup.NewCmd(*ctx))
+	root.Register(export.NewCmd(*ctx))
+	root.Register(importer.NewCmd(*ctx))
+	root.Register(serve.NewCmd(*ctx))
+	root.Register(digest.NewCmd(*ctx))
+	root.Register(credentials.NewCmd(*ctx))
+	root.Register(journal.NewCmd(*ctx))
+	root.Register(status.NewCmd(*ctx))
+	root.Register(purge.NewCmd(*ctx))
+	root.Register(last.NewCmd(*ctx))
+	root.Register(appender.NewCmd(*ctx))
+	root.Register(reorder.NewCmd(*ctx))
+	root.Register(book.NewCmd(*ctx))
+	root.Register(retention.NewCmd(*ctx))
+	root.Register(upgrade.NewCmd(*ctx))
+	root.Register(restore.NewCmd(*ctx))
+	root.Register(schema.NewCmd(*ctx))
+	root.Register(trash.NewCmd(*ctx))
+	root.Register(share.NewCmd(*ctx))
+
+	args, err := alias.Expand(ctx.Aliases, os.Args[1:], root.IsBuiltin)
+	if err != nil {
+		log.Errorf("%s\n", errors.Wrap(err, "expanding aliases").Error())
+		os.Exit(1)
+	}
+
+	if err := root.Execute(args); err != nil {
+		// the commands print the errors themselves in the JSON format
+		var reported output.ReportedError
+		if errors.As(err, &reported) {
+			os.Exit(1)
+		}
+
+		if database.IsReadOnlyError(err) {
+			err = errors.Wrap(err, readOnlyMessage)
+		} else if database.IsBusyError(err) {
+			err = errors.Wrap(err, infra.BusyMessage())
+		}
 
-	if err := root.Execute(); err != nil {
 		log.Errorf("%s\n", err.Error())
 		os.Exit(1)
 	}
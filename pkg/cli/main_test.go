@@ -20,14 +20,18 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dnote/dnote/pkg/assert"
 	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/database"
+	dbmigrate "github.com/dnote/dnote/pkg/cli/migrate"
 	"github.com/dnote/dnote/pkg/cli/testutils"
 	"github.com/dnote/dnote/pkg/cli/utils"
 	"github.com/pkg/errors"
@@ -137,7 +141,7 @@ func TestAddNote(t *testing.T) {
 
 	t.Run("existing book", func(t *testing.T) {
 		// Setup
-		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), nil)
+		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), &database.TestDBOptions{Mode: database.TestDBModeDisk})
 		testutils.Setup3(t, db)
 
 		// Execute
@@ -173,12 +177,37 @@ func TestAddNote(t *testing.T) {
 		assert.Equal(t, n2.Body, "foo", "n2 body mismatch")
 		assert.Equal(t, n2.Dirty, true, "n2 dirty mismatch")
 	})
+
+	t.Run("invalid utf-8", func(t *testing.T) {
+		defer testutils.RemoveDir(t, testDir)
+
+		// Execute
+		cmd, _, stdout, err := testutils.NewDnoteCmd(opts, binaryName, "add", "js", "-c", "foo\xff\r\nbar")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting command"))
+		}
+		if err := cmd.Run(); err == nil {
+			t.Fatal("command did not fail without --force")
+		}
+		if !strings.Contains(stdout.String(), "use --force") {
+			t.Errorf("unexpected error message: %s", stdout.String())
+		}
+
+		testutils.RunDnoteCmd(t, opts, binaryName, "add", "js", "-c", "foo\xff\r\nbar", "--force")
+
+		// Test
+		db := database.OpenTestDB(t, testDir)
+
+		var body string
+		database.MustScan(t, "getting the note", db.QueryRow("SELECT body FROM notes"), &body)
+		assert.Equal(t, body, "foo\uFFFD\nbar", "body mismatch")
+	})
 }
 
 func TestEditNote(t *testing.T) {
 	t.Run("content flag", func(t *testing.T) {
 		// Setup
-		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), nil)
+		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), &database.TestDBOptions{Mode: database.TestDBModeDisk})
 		testutils.Setup4(t, db)
 
 		// Execute
@@ -211,7 +240,7 @@ func TestEditNote(t *testing.T) {
 
 	t.Run("book flag", func(t *testing.T) {
 		// Setup
-		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), nil)
+		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), &database.TestDBOptions{Mode: database.TestDBModeDisk})
 		testutils.Setup5(t, db)
 
 		// Execute
@@ -245,7 +274,7 @@ func TestEditNote(t *testing.T) {
 
 	t.Run("book flag and content flag", func(t *testing.T) {
 		// Setup
-		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), nil)
+		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), &database.TestDBOptions{Mode: database.TestDBModeDisk})
 		testutils.Setup5(t, db)
 
 		// Execute
@@ -281,7 +310,7 @@ func TestEditNote(t *testing.T) {
 func TestEditBook(t *testing.T) {
 	t.Run("name flag", func(t *testing.T) {
 		// Setup
-		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), nil)
+		db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), &database.TestDBOptions{Mode: database.TestDBModeDisk})
 		testutils.Setup1(t, db)
 
 		// Execute
@@ -340,14 +369,14 @@ func TestRemoveNote(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("--yes=%t", tc.yesFlag), func(t *testing.T) {
 			// Setup
-			db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), nil)
+			db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), &database.TestDBOptions{Mode: database.TestDBModeDisk})
 			testutils.Setup2(t, db)
 
 			// Execute
 			if tc.yesFlag {
 				testutils.RunDnoteCmd(t, opts, binaryName, "remove", "-y", "1")
 			} else {
-				testutils.WaitDnoteCmd(t, opts, testutils.UserConfirm, binaryName, "remove", "1")
+				testutils.WaitDnoteCmd(t, opts, testutils.UserConfirm, binaryName, "remove", "--non-interactive=false", "1")
 			}
 			defer testutils.RemoveDir(t, testDir)
 
@@ -427,14 +456,14 @@ func TestRemoveBook(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("--yes=%t", tc.yesFlag), func(t *testing.T) {
 			// Setup
-			db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), nil)
+			db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), &database.TestDBOptions{Mode: database.TestDBModeDisk})
 			testutils.Setup2(t, db)
 
 			// Execute
 			if tc.yesFlag {
 				testutils.RunDnoteCmd(t, opts, binaryName, "remove", "-y", "js")
 			} else {
-				testutils.WaitDnoteCmd(t, opts, testutils.UserConfirm, binaryName, "remove", "js")
+				testutils.WaitDnoteCmd(t, opts, testutils.UserConfirm, binaryName, "remove", "--non-interactive=false", "js")
 			}
 
 			defer testutils.RemoveDir(t, testDir)
@@ -499,3 +528,353 @@ func TestRemoveBook(t *testing.T) {
 		})
 	}
 }
+
+func TestReadOnly(t *testing.T) {
+	// set up
+	testutils.RunDnoteCmd(t, opts, binaryName, "add", "js", "-c", "foo")
+	defer testutils.RemoveDir(t, testDir)
+
+	dbPath := fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName)
+	if err := os.Chmod(dbPath, 0444); err != nil {
+		t.Fatal(errors.Wrap(err, "making the database file read-only"))
+	}
+
+	t.Run("read", func(t *testing.T) {
+		for _, args := range [][]string{{"ls"}, {"ls", "js"}, {"view", "js"}, {"find", "foo"}, {"cat", "js", "1"}} {
+			cmd, stderr, stdout, err := testutils.NewDnoteCmd(opts, binaryName, args...)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "getting command"))
+			}
+
+			if err := cmd.Run(); err != nil {
+				t.Fatal(errors.Wrapf(err, "running %v: %s", args, stderr.String()))
+			}
+
+			assert.NotEqual(t, stdout.String(), "", fmt.Sprintf("output of %v was empty", args))
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("file permissions are not enforced for the root user")
+		}
+
+		cmd, stderr, _, err := testutils.NewDnoteCmd(opts, binaryName, "add", "js", "-c", "bar")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting command"))
+		}
+
+		if err := cmd.Run(); err == nil {
+			t.Fatal("command did not fail")
+		}
+
+		if !strings.Contains(stderr.String(), "the database is read-only") {
+			t.Errorf("unexpected error message: %s", stderr.String())
+		}
+	})
+}
+
+// holdWriteLock begins a transaction that holds the write lock of the database
+// in the test directory. The returned function rolls back the transaction.
+func holdWriteLock(t *testing.T) func() {
+	dbPath := fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName)
+	db, err := database.Open(dbPath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the database"))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+	database.MustExec(t, "inserting a book", tx, "INSERT INTO books (uuid, label) VALUES (?, ?)", "locking-book-uuid", "locking-book")
+
+	return func() {
+		tx.Rollback()
+		db.Close()
+	}
+}
+
+func TestDatabaseBusy(t *testing.T) {
+	t.Run("released within the window", func(t *testing.T) {
+		testutils.RunDnoteCmd(t, opts, binaryName, "add", "js", "-c", "foo")
+		defer testutils.RemoveDir(t, testDir)
+
+		release := holdWriteLock(t)
+		timer := time.AfterFunc(time.Second, release)
+		defer timer.Stop()
+
+		cmd, stderr, _, err := testutils.NewDnoteCmd(opts, binaryName, "add", "js", "-c", "bar")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting command"))
+		}
+		if err := cmd.Run(); err != nil {
+			t.Fatal(errors.Wrapf(err, "running add: %s", stderr.String()))
+		}
+
+		db := database.OpenTestDB(t, testDir)
+		var noteCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		assert.Equal(t, noteCount, 2, "note count mismatch")
+	})
+
+	t.Run("held by a sync", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("waits for the retry window")
+		}
+
+		testutils.RunDnoteCmd(t, opts, binaryName, "add", "js", "-c", "foo")
+		defer testutils.RemoveDir(t, testDir)
+
+		lockPath := fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.SyncLockFilename)
+		if err := ioutil.WriteFile(lockPath, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+			t.Fatal(errors.Wrap(err, "writing the sync lock"))
+		}
+
+		release := holdWriteLock(t)
+		defer release()
+
+		cmd, _, stdout, err := testutils.NewDnoteCmd(opts, binaryName, "add", "js", "-c", "bar")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting command"))
+		}
+		if err := cmd.Run(); err == nil {
+			t.Fatal("command did not fail")
+		}
+
+		if !strings.Contains(stdout.String(), fmt.Sprintf("dnote is busy syncing in process %d", os.Getpid())) {
+			t.Errorf("unexpected error message: %s", stdout.String())
+		}
+	})
+}
+
+func TestSchemaMismatch(t *testing.T) {
+	testCases := []struct {
+		name     string
+		setup    func(t *testing.T, db *database.DB)
+		expected string
+	}{
+		{
+			name: "newer version",
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "updating the schema", db, "UPDATE system SET value = ? WHERE key = ?", len(dbmigrate.LocalSequence)+2, consts.SystemSchema)
+			},
+			expected: fmt.Sprintf("your database schema (v%d) is newer than this version of dnote supports (v%d)", len(dbmigrate.LocalSequence)+2, len(dbmigrate.LocalSequence)),
+		},
+		{
+			name: "unknown column",
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "adding a column", db, "ALTER TABLE notes ADD COLUMN pinned bool DEFAULT false")
+			},
+			expected: "has the columns notes.pinned unknown to this version of dnote",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			testutils.RunDnoteCmd(t, opts, binaryName, "add", "js", "-c", "foo")
+			defer testutils.RemoveDir(t, testDir)
+
+			db := database.OpenTestDB(t, testDir)
+			tc.setup(t, db)
+			db.Close()
+
+			t.Run("read", func(t *testing.T) {
+				cmd, stderr, stdout, err := testutils.NewDnoteCmd(opts, binaryName, "view", "js")
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "getting command"))
+				}
+				if err := cmd.Run(); err != nil {
+					t.Fatal(errors.Wrapf(err, "running view: %s", stderr.String()))
+				}
+
+				assert.Equal(t, strings.Contains(stdout.String(), tc.expected), true, fmt.Sprintf("warning mismatch: %s", stdout.String()))
+				assert.Equal(t, strings.Contains(stdout.String(), "foo"), true, fmt.Sprintf("note was not printed: %s", stdout.String()))
+			})
+
+			t.Run("write", func(t *testing.T) {
+				cmd, _, stdout, err := testutils.NewDnoteCmd(opts, binaryName, "add", "js", "-c", "bar")
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "getting command"))
+				}
+				if err := cmd.Run(); err == nil {
+					t.Fatal("command did not fail")
+				}
+
+				assert.Equal(t, strings.Contains(stdout.String(), tc.expected), true, fmt.Sprintf("error mismatch: %s", stdout.String()))
+
+				db := database.OpenTestDB(t, testDir)
+				defer db.Close()
+
+				var noteCount int
+				database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+				assert.Equal(t, noteCount, 1, "note count mismatch")
+			})
+		})
+	}
+}
+
+// runClosedStdin runs a dnote command whose standard input is closed, and
+// returns its exit code and standard output, on which the errors are printed.
+// It fails the test if the command does not exit in time, as if it waited for
+// an input.
+func runClosedStdin(t *testing.T, arg ...string) (int, string) {
+	cmd, _, stdout, err := testutils.NewDnoteCmd(opts, binaryName, arg...)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting command"))
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting stdin"))
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(errors.Wrap(err, "starting command"))
+	}
+	stdin.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), stdout.String()
+		} else if err != nil {
+			t.Fatal(errors.Wrap(err, "running command"))
+		}
+
+		return 0, stdout.String()
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("%s did not exit", strings.Join(arg, " "))
+	}
+
+	return 0, ""
+}
+
+func TestNonInteractive(t *testing.T) {
+	testCases := []struct {
+		args         []string
+		expectedCode int
+		expectedHint string
+	}{
+		{args: []string{"remove", "1"}, expectedCode: 1, expectedHint: "--yes"},
+		{args: []string{"remove", "--yes", "1"}, expectedCode: 0},
+		{args: []string{"add", "jz", "-c", "foo"}, expectedCode: 1, expectedHint: "--create"},
+		{args: []string{"add", "jz", "--create", "-c", "foo"}, expectedCode: 0},
+		{args: []string{"add", "js"}, expectedCode: 1, expectedHint: "--content"},
+		{args: []string{"edit", "1"}, expectedCode: 1, expectedHint: "--content"},
+		{args: []string{"edit", "js"}, expectedCode: 1, expectedHint: "--name"},
+		{args: []string{"login", "-p", "foo"}, expectedCode: 1, expectedHint: "--username"},
+		{args: []string{"--non-interactive", "remove", "1"}, expectedCode: 1, expectedHint: "--yes"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			// set up
+			db := database.InitTestDB(t, fmt.Sprintf("%s/%s/%s", testDir, consts.DnoteDirName, consts.DnoteDBFileName), &database.TestDBOptions{Mode: database.TestDBModeDisk})
+			testutils.Setup2(t, db)
+			defer testutils.RemoveDir(t, testDir)
+
+			// execute
+			code, stdout := runClosedStdin(t, tc.args...)
+
+			// test
+			assert.Equal(t, code, tc.expectedCode, fmt.Sprintf("exit code mismatch. stdout: %s", stdout))
+			if tc.expectedHint != "" && !strings.Contains(stdout, "non-interactive mode. Use "+tc.expectedHint) {
+				t.Errorf("stdout %q does not name %s", stdout, tc.expectedHint)
+			}
+		})
+	}
+}
+
+func TestStandaloneCommands(t *testing.T) {
+	// set up
+	dnoteDir := fmt.Sprintf("%s/%s", testDir, consts.DnoteDirName)
+	if err := os.MkdirAll(dnoteDir, 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "creating the dnote directory"))
+	}
+	defer testutils.RemoveDir(t, testDir)
+
+	dbPath := fmt.Sprintf("%s/%s", dnoteDir, consts.DnoteDBFileName)
+	corrupted := []byte("this is not a database")
+	if err := ioutil.WriteFile(dbPath, corrupted, 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing a corrupted database file"))
+	}
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{"--version"}, expected: "dnote master\n"},
+		{args: []string{"version"}, expected: "dnote master\n"},
+		{args: []string{"help"}, expected: "Dnote - a simple command line notebook"},
+		{args: []string{"--help"}, expected: "Dnote - a simple command line notebook"},
+		{args: []string{"completion", "bash"}, expected: "bash completion"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd, stderr, stdout, err := testutils.NewDnoteCmd(opts, binaryName, tc.args...)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "getting command"))
+			}
+
+			if err := cmd.Run(); err != nil {
+				t.Fatal(errors.Wrapf(err, "running command: %s", stderr.String()))
+			}
+
+			if !strings.Contains(stdout.String(), tc.expected) {
+				t.Errorf("output %q does not contain %q", stdout.String(), tc.expected)
+			}
+		})
+	}
+
+	// the database file must not have been touched
+	b, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the database file"))
+	}
+	assert.Equal(t, string(b), string(corrupted), "database file mismatch")
+
+	ok, err := utils.FileExists(fmt.Sprintf("%s/%s", dnoteDir, consts.ConfigFilename))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking if the config exists"))
+	}
+	assert.Equal(t, ok, false, "config should not have been created")
+}
+
+// BenchmarkStartup roughly measures the time to run a command that uses the
+// database and one that does not, including starting the process
+func BenchmarkStartup(b *testing.B) {
+	b.Run("version", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			benchmarkRunCmd(b, "--version")
+		}
+	})
+
+	b.Run("ls", func(b *testing.B) {
+		benchmarkRunCmd(b, "add", "js", "-c", "foo")
+		defer os.RemoveAll(testDir)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			benchmarkRunCmd(b, "ls")
+		}
+	})
+}
+
+func benchmarkRunCmd(b *testing.B, args ...string) {
+	cmd, stderr, _, err := testutils.NewDnoteCmd(opts, binaryName, args...)
+	if err != nil {
+		b.Fatal(errors.Wrap(err, "getting command"))
+	}
+
+	if err := cmd.Run(); err != nil {
+		b.Fatal(errors.Wrapf(err, "running %v: %s", args, stderr.String()))
+	}
+}
@@ -0,0 +1,91 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migrate
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// drainQuery runs the given query and reads all of its rows
+func drainQuery(b *testing.B, db *database.DB, query string, args ...interface{}) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		b.Fatal(errors.Wrap(err, "querying").Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		b.Fatal(errors.Wrap(err, "iterating rows").Error())
+	}
+}
+
+// benchmarkDirtyLookups runs the queries that look up dirty records and the
+// notes in a book against 50,000 notes in 100 books, 10 of which are dirty
+func benchmarkDirtyLookups(b *testing.B, migrated bool) {
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-14-pre-schema.sql", SkipMigration: true, Mode: database.TestDBModeTempDir}
+	db := database.InitTestDB(b, filepath.Join(b.TempDir(), "bench.db"), &opts)
+	defer database.TeardownTestDB(b, db)
+
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatal(errors.Wrap(err, "beginning a transaction").Error())
+	}
+	for i := 0; i < 100; i++ {
+		database.MustExec(b, "inserting a book", tx, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", fmt.Sprintf("b%d-uuid", i), fmt.Sprintf("b%d-label", i), i+1, i == 0)
+	}
+	for i := 0; i < 50000; i++ {
+		database.MustExec(b, "inserting a note", tx, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn, dirty) VALUES (?, ?, ?, ?, ?, ?)",
+			fmt.Sprintf("n%d-uuid", i), fmt.Sprintf("b%d-uuid", i%100), fmt.Sprintf("note %d body", i), 50000-i, i+1, i%5000 == 0)
+	}
+	if migrated {
+		if err := lm14.run(context.DnoteCtx{DB: db}, tx); err != nil {
+			tx.Rollback()
+			b.Fatal(errors.Wrap(err, "running the migration").Error())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(errors.Wrap(err, "committing").Error())
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		drainQuery(b, db, "SELECT uuid, book_uuid, body, public, deleted, usn, added_on FROM notes WHERE dirty")
+		drainQuery(b, db, "SELECT uuid, label, usn, deleted FROM books WHERE dirty ORDER BY deleted DESC, usn = 0")
+		drainQuery(b, db, "SELECT count(*) FROM notes WHERE book_uuid = ? AND dirty", "b1-uuid")
+		drainQuery(b, db, "SELECT rowid, substr(body, 1, 1000), length(body), added_on FROM notes WHERE book_uuid = ? AND deleted = ? ORDER BY added_on ASC, rowid ASC", "b1-uuid", false)
+	}
+}
+
+func BenchmarkDirtyLookups(b *testing.B) {
+	b.Run("before", func(b *testing.B) {
+		benchmarkDirtyLookups(b, false)
+	})
+
+	b.Run("after", func(b *testing.B) {
+		benchmarkDirtyLookups(b, true)
+	})
+}
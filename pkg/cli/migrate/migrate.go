@@ -20,8 +20,11 @@ package migrate
 
 import (
 	"database/sql"
+	"sync"
+
 	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/pkg/errors"
 )
@@ -33,7 +36,12 @@ const (
 	RemoteMode
 )
 
-// LocalSequence is a list of local migrations to be run
+// LocalSequence is a list of local migrations to be run. The schema version of
+// the database is the number of migrations in the sequence that have been run.
+// Therefore, a new migration must be appended to the end of the sequence, and
+// the existing ones must never be removed or reordered. Remember to update
+// database.MarkMigrationComplete as well, and the tables in schema.go if the
+// migration changes the schema.
 var LocalSequence = []migration{
 	lm1,
 	lm2,
@@ -47,6 +55,16 @@ var LocalSequence = []migration{
 	lm10,
 	lm11,
 	lm12,
+	lm13,
+	lm14,
+	lm15,
+	lm16,
+	lm17,
+	lm18,
+	lm19,
+	lm20,
+	lm21,
+	lm22,
 }
 
 // RemoteSequence is a list of remote migrations to be run
@@ -124,32 +142,113 @@ func execute(ctx context.DnoteCtx, m migration, schemaKey string) error {
 		return errors.Wrap(err, "incrementing schema")
 	}
 
-	tx.Commit()
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "committing a transaction")
+	}
 
 	return nil
 }
 
-// Run performs unrun migrations
+// Run performs unrun migrations. If the database is read-only, it only warns
+// about them.
 func Run(ctx context.DnoteCtx, migrations []migration, mode int) error {
 	schemaKey, err := getSchemaKey(mode)
 	if err != nil {
 		return errors.Wrap(err, "getting schema key")
 	}
 
+	if isUpToDate(ctx.DB, schemaKey, len(migrations)) {
+		return nil
+	}
+
 	schema, err := getSchema(ctx, schemaKey)
 	if err != nil {
 		return errors.Wrap(err, "getting the current schema")
 	}
 
-	log.Debug("current schema: %s %d of %d\n", consts.SystemSchema, schema, len(migrations))
+	log.Debug("current schema: %s %d of %d\n", schemaKey, schema, len(migrations))
+
+	if ctx.DB.ReadOnly {
+		// a newer schema is reported by CheckSchema so that the database can still be read
+		if schema < len(migrations) {
+			log.Warnf("the database schema is outdated (version %d of %d). Run a command that modifies the database, such as 'dnote sync', to upgrade it\n", schema, len(migrations))
+		}
+
+		return nil
+	}
+
+	if schema > len(migrations) {
+		return &SchemaError{Current: schema, Latest: len(migrations)}
+	}
 
-	toRun := migrations[schema:]
+	for i := schema; i < len(migrations); i++ {
+		m := migrations[i]
 
-	for _, m := range toRun {
 		if err := execute(ctx, m, schemaKey); err != nil {
-			return errors.Wrap(err, "running migration")
+			return errors.Wrapf(err, "running migration %d of %d", i+1, len(migrations))
 		}
 	}
 
+	markUpToDate(ctx.DB, schemaKey, len(migrations))
+
 	return nil
 }
+
+// upToDate holds, for each database connection, the schema versions that the
+// migration sequences have been brought to in this process, so that an up to
+// date sequence is not checked again
+var upToDate = struct {
+	sync.Mutex
+	m map[*database.DB]map[string]int
+}{m: map[*database.DB]map[string]int{}}
+
+func isUpToDate(db *database.DB, schemaKey string, schema int) bool {
+	upToDate.Lock()
+	defer upToDate.Unlock()
+
+	v, ok := upToDate.m[db][schemaKey]
+	return ok && v == schema
+}
+
+func markUpToDate(db *database.DB, schemaKey string, schema int) {
+	upToDate.Lock()
+	defer upToDate.Unlock()
+
+	if upToDate.m[db] == nil {
+		upToDate.m[db] = map[string]int{}
+	}
+	upToDate.m[db][schemaKey] = schema
+}
+
+// Status is the state of a migration sequence in the database
+type Status struct {
+	// Current is the number of migrations that have been run
+	Current int
+	// Latest is the number of migrations in the sequence
+	Latest int
+	// Pending is the names of the migrations yet to be run
+	Pending []string
+}
+
+// GetStatus returns the status of the given migration sequence without
+// modifying the database
+func GetStatus(ctx context.DnoteCtx, migrations []migration, mode int) (Status, error) {
+	ret := Status{Latest: len(migrations)}
+
+	schemaKey, err := getSchemaKey(mode)
+	if err != nil {
+		return ret, errors.Wrap(err, "getting schema key")
+	}
+
+	err = ctx.DB.QueryRow("SELECT value FROM system where key = ?", schemaKey).Scan(&ret.Current)
+	if err != nil && err != sql.ErrNoRows {
+		return ret, errors.Wrap(err, "querying schema")
+	}
+
+	for i := ret.Current; i < len(migrations); i++ {
+		ret.Pending = append(ret.Pending, migrations[i].name)
+	}
+
+	return ret, nil
+}
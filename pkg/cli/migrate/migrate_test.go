@@ -25,6 +25,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -324,6 +326,264 @@ func TestRun_up_to_date(t *testing.T) {
 	}
 }
 
+func TestRun_newer_schema(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SkipMigration: true, Mode: database.TestDBModeTempDir}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+	database.MustExec(t, "inserting a schema", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSchema, 3)
+
+	sequence := []migration{
+		{
+			name: "v1",
+			run: func(ctx context.DnoteCtx, db *database.DB) error {
+				return nil
+			},
+		},
+	}
+
+	// execute
+	err := Run(ctx, sequence, LocalMode)
+
+	// test
+	assert.NotEqual(t, err, nil, "error was not returned")
+	assert.Equal(t, IsSchemaError(err), true, "error is not a schema error")
+
+	var schema int
+	database.MustScan(t, "getting schema", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSchema), &schema)
+	assert.Equal(t, schema, 3, "schema was changed")
+
+	t.Run("read-only", func(t *testing.T) {
+		reader, err := database.OpenReadOnly(db.Filepath)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "opening a read-only connection"))
+		}
+		defer reader.Close()
+
+		readOnlyCtx := ctx
+		readOnlyCtx.DB = reader
+
+		if err := Run(readOnlyCtx, sequence, LocalMode); err != nil {
+			t.Fatal(errors.Wrap(err, "running"))
+		}
+	})
+}
+
+func TestRun_readOnly(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SkipMigration: true, Mode: database.TestDBModeTempDir}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+	database.MustExec(t, "inserting a schema", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSchema, 1)
+
+	reader, err := database.OpenReadOnly(db.Filepath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening a read-only connection"))
+	}
+	defer reader.Close()
+
+	readOnlyCtx := ctx
+	readOnlyCtx.DB = reader
+
+	var ran bool
+	sequence := []migration{
+		{
+			name: "v1",
+			run: func(ctx context.DnoteCtx, db *database.DB) error {
+				return nil
+			},
+		},
+		{
+			name: "v2",
+			run: func(ctx context.DnoteCtx, db *database.DB) error {
+				ran = true
+				return nil
+			},
+		},
+	}
+
+	// execute
+	if err := Run(readOnlyCtx, sequence, LocalMode); err != nil {
+		t.Fatal(errors.Wrap(err, "running"))
+	}
+
+	// test
+	assert.Equal(t, ran, false, "pending migration was run")
+
+	var schema int
+	database.MustScan(t, "getting schema", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSchema), &schema)
+	assert.Equal(t, schema, 1, "schema was changed")
+}
+
+func TestRun_failure(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+	database.MustExec(t, "creating a temporary table for testing", db,
+		"CREATE TABLE migrate_run_test ( name string )")
+
+	sequence := []migration{
+		{
+			name: "v1",
+			run: func(ctx context.DnoteCtx, db *database.DB) error {
+				database.MustExec(t, "marking v1 completed", db, "INSERT INTO migrate_run_test (name) VALUES (?)", "v1")
+				return nil
+			},
+		},
+		{
+			name: "v2",
+			run: func(ctx context.DnoteCtx, db *database.DB) error {
+				database.MustExec(t, "marking v2 completed", db, "INSERT INTO migrate_run_test (name) VALUES (?)", "v2")
+				return errors.New("test error")
+			},
+		},
+	}
+
+	// execute
+	err := Run(ctx, sequence, LocalMode)
+
+	// test
+	if err == nil {
+		t.Fatal("error was not returned")
+	}
+	assert.Equal(t, err.Error(), "running migration 2 of 2: running 'v2': test error", "error mismatch")
+
+	var schema int
+	database.MustScan(t, "getting schema", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSchema), &schema)
+	assert.Equal(t, schema, 1, "schema mismatch")
+
+	var testRunCount int
+	database.MustScan(t, "counting test runs", db.QueryRow("SELECT count(*) FROM migrate_run_test"), &testRunCount)
+	assert.Equal(t, testRunCount, 1, "the failed migration was not rolled back")
+}
+
+func TestRun_local_sequence(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-1-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	configPath := fmt.Sprintf("%s/dnoterc", ctx.Paths.LegacyDnote)
+	if err := ioutil.WriteFile(configPath, []byte("editor: vim"), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing config"))
+	}
+	defer os.Remove(configPath)
+
+	db := ctx.DB
+	database.MustExec(t, "inserting js book", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "js-book-uuid", "js")
+	database.MustExec(t, "inserting a note", db, "INSERT INTO notes (uuid, book_uuid, content, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "js-book-uuid", "n1 content", 1542058875)
+
+	// execute and re-run to ensure idempotence
+	for i := 0; i < 2; i++ {
+		if err := Run(ctx, LocalSequence, LocalMode); err != nil {
+			t.Fatal(errors.Wrapf(err, "running the sequence for the time %d", i+1))
+		}
+
+		// test
+		var schema int
+		database.MustScan(t, "getting schema", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSchema), &schema)
+		assert.Equal(t, schema, len(LocalSequence), "schema mismatch")
+
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+		assert.Equal(t, noteCount, 1, "note count mismatch")
+		assert.Equal(t, bookCount, 1, "book count mismatch")
+
+		var body string
+		database.MustScan(t, "getting the note", db.QueryRow("SELECT body FROM notes WHERE uuid = ?", "n1-uuid"), &body)
+		assert.Equal(t, body, "n1 content", "note body mismatch")
+	}
+}
+
+func TestRun_cached(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	var runCount int
+	m := migration{
+		name: "v1",
+		run: func(ctx context.DnoteCtx, db *database.DB) error {
+			runCount++
+			return nil
+		},
+	}
+
+	if err := Run(ctx, []migration{m}, LocalMode); err != nil {
+		t.Fatal(errors.Wrap(err, "running the sequence"))
+	}
+
+	// execute
+	database.MustExec(t, "deleting the schema", db, "DELETE FROM system WHERE key = ?", consts.SystemSchema)
+	if err := Run(ctx, []migration{m}, LocalMode); err != nil {
+		t.Fatal(errors.Wrap(err, "running the sequence again"))
+	}
+
+	// test
+	var schemaCount int
+	database.MustScan(t, "counting schema", db.QueryRow("SELECT count(*) FROM system WHERE key = ?", consts.SystemSchema), &schemaCount)
+	assert.Equal(t, schemaCount, 0, "the up to date sequence should not have been checked again")
+	assert.Equal(t, runCount, 1, "run count mismatch")
+
+	// a longer sequence is checked again
+	if err := Run(ctx, []migration{m, m}, LocalMode); err != nil {
+		t.Fatal(errors.Wrap(err, "running a longer sequence"))
+	}
+
+	var schema int
+	database.MustScan(t, "getting schema", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSchema), &schema)
+	assert.Equal(t, schema, 2, "schema mismatch")
+	assert.Equal(t, runCount, 3, "run count mismatch")
+}
+
+func TestGetStatus(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+	database.MustExec(t, "inserting a schema", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSchema, 1)
+
+	noop := func(ctx context.DnoteCtx, db *database.DB) error {
+		return nil
+	}
+	sequence := []migration{
+		{name: "v1", run: noop},
+		{name: "v2", run: noop},
+		{name: "v3", run: noop},
+	}
+
+	// execute
+	local, err := GetStatus(ctx, sequence, LocalMode)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the local status"))
+	}
+	remote, err := GetStatus(ctx, sequence, RemoteMode)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the remote status"))
+	}
+
+	// test
+	assert.DeepEqual(t, local, Status{Current: 1, Latest: 3, Pending: []string{"v2", "v3"}}, "local status mismatch")
+	assert.DeepEqual(t, remote, Status{Current: 0, Latest: 3, Pending: []string{"v1", "v2", "v3"}}, "remote status mismatch")
+
+	var count int
+	database.MustScan(t, "counting remote schema", db.QueryRow("SELECT count(*) FROM system WHERE key = ?", consts.SystemRemoteSchema), &count)
+	assert.Equal(t, count, 0, "remote schema was initialized")
+}
+
 func TestLocalMigration1(t *testing.T) {
 	// set up
 	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-1-pre-schema.sql", SkipMigration: true}
@@ -1108,6 +1368,72 @@ func TestLocalMigration12(t *testing.T) {
 	assert.NotEqual(t, cf.APIEndpoint, "", "apiEndpoint was not populated")
 }
 
+func TestLocalMigration13(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-13-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1")
+	database.MustExec(t, "inserting recovered book", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "recovered")
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1, 10)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", "n2-uuid", "missing-book-uuid", "n2 body", 2, 11)
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", "n3-uuid", "missing-book-uuid", "n3 body", 3, 12)
+
+	var n1RowID, n2RowID int
+	database.MustScan(t, "getting n1 rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", "n1-uuid"), &n1RowID)
+	database.MustScan(t, "getting n2 rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", "n2-uuid"), &n2RowID)
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm13.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	var bookCount, noteCount int
+	database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+	database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+	assert.Equal(t, bookCount, 3, "book count mismatch")
+	assert.Equal(t, noteCount, 3, "note count mismatch")
+
+	var recovered database.Book
+	database.MustScan(t, "getting the recovered book", db.QueryRow("SELECT label, dirty, usn FROM books WHERE uuid = ?", "missing-book-uuid"),
+		&recovered.Label, &recovered.Dirty, &recovered.USN)
+	assert.Equal(t, recovered.Label, "recovered_2", "recovered book label mismatch")
+	assert.Equal(t, recovered.Dirty, true, "recovered book dirty mismatch")
+	assert.Equal(t, recovered.USN, 0, "recovered book usn mismatch")
+
+	// rowids are preserved and the full text search index still works
+	var n1RowIDAfter, n2RowIDAfter int
+	database.MustScan(t, "getting n1 rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", "n1-uuid"), &n1RowIDAfter)
+	database.MustScan(t, "getting n2 rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", "n2-uuid"), &n2RowIDAfter)
+	assert.Equal(t, n1RowIDAfter, n1RowID, "n1 rowid mismatch")
+	assert.Equal(t, n2RowIDAfter, n2RowID, "n2 rowid mismatch")
+
+	database.MustExec(t, "updating n1", db, "UPDATE notes SET body = ? WHERE uuid = ?", "n1 edited", "n1-uuid")
+
+	var resCount int
+	database.MustScan(t, "searching notes", db.QueryRow("SELECT count(*) FROM note_fts WHERE note_fts MATCH ?", "edited"), &resCount)
+	assert.Equal(t, resCount, 1, "search result count mismatch")
+
+	// the foreign key is enforced
+	_, err = db.Exec("INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n4-uuid", "bogus-book-uuid", "n4 body", 4)
+	assert.NotEqual(t, err, nil, "inserting a note with a bogus book_uuid did not fail")
+}
+
 func TestRemoteMigration1(t *testing.T) {
 	// set up
 	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/remote-1-pre-schema.sql", SkipMigration: true}
@@ -1182,3 +1508,427 @@ func TestRemoteMigration1(t *testing.T) {
 	assert.Equal(t, postCSSBookUUID, newCSSBookUUID, "css book uuid was not updated correctly")
 	assert.Equal(t, postLinuxBookUUID, linuxBookUUID, "linux book uuid changed")
 }
+
+// getQueryPlan returns the details of the query plan of the given query
+func getQueryPlan(t *testing.T, db *database.DB, query string, args ...interface{}) string {
+	rows, err := db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "explaining query plan"))
+	}
+	defer rows.Close()
+
+	var details []string
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			t.Fatal(errors.Wrap(err, "scanning a row"))
+		}
+
+		details = append(details, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(errors.Wrap(err, "iterating rows"))
+	}
+
+	return strings.Join(details, "\n")
+}
+
+func TestLocalMigration14(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-14-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, dirty) VALUES (?, ?, ?)", "b1-uuid", "b1", true)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, dirty) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1, true)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, dirty) VALUES (?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", "n2 body", 2, false)
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm14.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	var indexCount int
+	database.MustScan(t, "counting the superseded index", db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = ?", "idx_notes_book_uuid"), &indexCount)
+	assert.Equal(t, indexCount, 0, "idx_notes_book_uuid was not dropped")
+
+	var dirtyCount int
+	database.MustScan(t, "counting dirty notes", db.QueryRow("SELECT count(*) FROM notes WHERE book_uuid = ? AND dirty", "b1-uuid"), &dirtyCount)
+	assert.Equal(t, dirtyCount, 1, "dirty note count mismatch")
+
+	testCases := []struct {
+		query string
+		args  []interface{}
+		index string
+	}{
+		{
+			query: "SELECT uuid, book_uuid, body, public, deleted, usn, added_on FROM notes WHERE dirty",
+			index: "idx_notes_dirty",
+		},
+		{
+			query: "SELECT uuid, label, usn, deleted FROM books WHERE dirty ORDER BY deleted DESC, usn = 0",
+			index: "idx_books_dirty",
+		},
+		{
+			query: "SELECT count(*) FROM notes WHERE book_uuid = ? AND dirty",
+			args:  []interface{}{"b1-uuid"},
+			index: "idx_notes_dirty",
+		},
+		{
+			query: "SELECT uuid, usn, dirty FROM notes",
+			index: "COVERING INDEX idx_notes_uuid_usn_dirty",
+		},
+		{
+			query: "SELECT rowid, substr(body, 1, 1000), length(body), added_on FROM notes WHERE book_uuid = ? AND deleted = ? ORDER BY added_on ASC, rowid ASC",
+			args:  []interface{}{"b1-uuid", false},
+			index: "idx_notes_book_uuid_deleted",
+		},
+		{
+			query: `SELECT books.label, count(notes.uuid) note_count
+			FROM books
+			LEFT JOIN notes ON notes.book_uuid = books.uuid AND notes.deleted = false
+			WHERE books.deleted = false
+			GROUP BY books.uuid
+			ORDER BY books.label ASC`,
+			index: "idx_notes_book_uuid_deleted",
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			plan := getQueryPlan(t, db, tc.query, tc.args...)
+
+			if !strings.Contains(plan, tc.index) {
+				t.Errorf("query plan does not use %s:\n%s", tc.index, plan)
+			}
+		})
+	}
+
+	// notes in a book are listed in the order of the index without sorting
+	plan := getQueryPlan(t, db, "SELECT rowid, substr(body, 1, 1000), length(body), added_on FROM notes WHERE book_uuid = ? AND deleted = ? ORDER BY added_on ASC, rowid ASC", "b1-uuid", false)
+	if strings.Contains(plan, "TEMP B-TREE") {
+		t.Errorf("query plan sorts notes in a temporary b-tree:\n%s", plan)
+	}
+}
+
+func TestLocalMigration15(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-15-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting a legacy action", db, "INSERT INTO actions (uuid, schema, type, data, timestamp) VALUES (?, ?, ?, ?, ?)", "a1-uuid", 1, "add_note", "{}", 1)
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm15.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	var count int
+	database.MustScan(t, "counting actions", db.QueryRow("SELECT count(*) FROM actions"), &count)
+	assert.Equal(t, count, 0, "the legacy actions were not dropped")
+
+	database.MustExec(t, "inserting an action", db, "INSERT INTO actions (timestamp, actor, operation, note_uuid) VALUES (?, ?, ?, ?)", 1, "cli-command", "add-note", "n1-uuid")
+
+	var bookUUID, summary string
+	database.MustScan(t, "getting the action", db.QueryRow("SELECT book_uuid, summary FROM actions WHERE note_uuid = ?", "n1-uuid"), &bookUUID, &summary)
+	assert.Equal(t, bookUUID, "", "book_uuid mismatch")
+	assert.Equal(t, summary, "", "summary mismatch")
+
+	plan := getQueryPlan(t, db, "SELECT id FROM actions WHERE timestamp < ?", 1)
+	if !strings.Contains(plan, "idx_actions_timestamp") {
+		t.Errorf("query plan does not use idx_actions_timestamp:\n%s", plan)
+	}
+}
+
+func TestLocalMigration16(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-16-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	database.MustExec(t, "inserting note", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1)
+	database.MustExec(t, "inserting an action", db, "INSERT INTO actions (timestamp, actor, operation, note_uuid) VALUES (?, ?, ?, ?)", 1, "cli-command", "add-note", "n1-uuid")
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm16.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	var originDevice string
+	database.MustScan(t, "getting the note", db.QueryRow("SELECT origin_device FROM notes WHERE uuid = ?", "n1-uuid"), &originDevice)
+	assert.Equal(t, originDevice, "", "origin_device mismatch")
+
+	var device string
+	database.MustScan(t, "getting the action", db.QueryRow("SELECT device FROM actions WHERE note_uuid = ?", "n1-uuid"), &device)
+	assert.Equal(t, device, "", "device mismatch")
+}
+
+func TestLocalMigration17(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-17-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	database.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "css")
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 3)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n2-uuid", "b1-uuid", "n2 body", 1)
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n3-uuid", "b1-uuid", "n3 body", 3)
+	database.MustExec(t, "inserting n4", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n4-uuid", "b2-uuid", "n4 body", 2)
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm17.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	expected := map[string]float64{
+		"n1-uuid": 2,
+		"n2-uuid": 1,
+		"n3-uuid": 3,
+		"n4-uuid": 1,
+	}
+	for uuid, position := range expected {
+		var got float64
+		database.MustScan(t, "getting the position of "+uuid, db.QueryRow("SELECT position FROM notes WHERE uuid = ?", uuid), &got)
+		assert.Equal(t, got, position, fmt.Sprintf("position mismatch for %s", uuid))
+	}
+
+	plan := getQueryPlan(t, db, "SELECT rowid FROM notes WHERE book_uuid = ? ORDER BY position ASC", "b1-uuid")
+	if !strings.Contains(plan, "idx_notes_book_uuid_position") {
+		t.Errorf("query plan does not use idx_notes_book_uuid_position:\n%s", plan)
+	}
+}
+
+func TestLocalMigration18(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-18-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1)
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm18.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	var viewCount int
+	var lastViewedAt int64
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT view_count, last_viewed_at FROM notes WHERE uuid = ?", "n1-uuid"), &viewCount, &lastViewedAt)
+	assert.Equal(t, viewCount, 0, "view_count mismatch")
+	assert.Equal(t, lastViewedAt, int64(0), "last_viewed_at mismatch")
+}
+
+func TestLocalMigration19(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-19-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm19.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	database.MustExec(t, "inserting a policy", db, "INSERT INTO book_retention (book_uuid, max_count) VALUES (?, ?)", "b1-uuid", 10)
+
+	var maxAge, maxCount int64
+	database.MustScan(t, "getting the policy", db.QueryRow("SELECT max_age, max_count FROM book_retention WHERE book_uuid = ?", "b1-uuid"), &maxAge, &maxCount)
+	assert.Equal(t, maxAge, int64(0), "max_age mismatch")
+	assert.Equal(t, maxCount, int64(10), "max_count mismatch")
+}
+
+func TestLocalMigration20(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-20-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm20.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	database.MustExec(t, "inserting a strategy", db, "INSERT INTO book_strategy (book_uuid, strategy) VALUES (?, ?)", "b1-uuid", "local")
+
+	var strategy string
+	database.MustScan(t, "getting the strategy", db.QueryRow("SELECT strategy FROM book_strategy WHERE book_uuid = ?", "b1-uuid"), &strategy)
+	assert.Equal(t, strategy, "local", "strategy mismatch")
+}
+
+func TestLocalMigration21(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-21-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm21.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	database.MustExec(t, "archiving a note", db, "INSERT INTO expunged_archive (note_uuid, book_label, body, added_on, expunged_at) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "js", "n1 body", 1541108743, 1541108744)
+
+	var id int
+	var body string
+	var editedOn int64
+	database.MustScan(t, "getting the archived note", db.QueryRow("SELECT id, body, edited_on FROM expunged_archive WHERE note_uuid = ?", "n1-uuid"), &id, &body, &editedOn)
+	assert.Equal(t, id, 1, "id mismatch")
+	assert.Equal(t, body, "n1 body", "body mismatch")
+	assert.Equal(t, editedOn, int64(0), "edited_on mismatch")
+}
+
+func TestLocalMigration22(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-22-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm22.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(errors.Wrap(err, "committing"))
+	}
+
+	// test
+	database.MustExec(t, "caching a share", db, "INSERT INTO note_shares (id, note_uuid, url) VALUES (?, ?, ?)", "s1", "n1-uuid", "https://example.com/s/s1")
+
+	var url string
+	var createdAt, expiresAt int64
+	database.MustScan(t, "getting the share", db.QueryRow("SELECT url, created_at, expires_at FROM note_shares WHERE note_uuid = ?", "n1-uuid"), &url, &createdAt, &expiresAt)
+	assert.Equal(t, url, "https://example.com/s/s1", "url mismatch")
+	assert.Equal(t, createdAt, int64(0), "created_at mismatch")
+	assert.Equal(t, expiresAt, int64(0), "expires_at mismatch")
+}
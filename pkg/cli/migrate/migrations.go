@@ -550,6 +550,301 @@ var lm12 = migration{
 	},
 }
 
+var lm13 = migration{
+	name: "add-foreign-key-from-notes-to-books",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		// Notes whose book no longer exists would violate the foreign key.
+		// Recover them into a new book for each missing book.
+		rows, err := tx.Query("SELECT DISTINCT book_uuid FROM notes WHERE book_uuid NOT IN (SELECT uuid FROM books)")
+		if err != nil {
+			return errors.Wrap(err, "querying orphan notes")
+		}
+
+		var orphanBookUUIDs []string
+		for rows.Next() {
+			var uuid string
+			if err := rows.Scan(&uuid); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "scanning a row")
+			}
+
+			orphanBookUUIDs = append(orphanBookUUIDs, uuid)
+		}
+		rows.Close()
+
+		for _, uuid := range orphanBookUUIDs {
+			label := "recovered"
+			for i := 2; ; i++ {
+				var count int
+				if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", label).Scan(&count); err != nil {
+					return errors.Wrap(err, "counting candidate")
+				}
+				if count == 0 {
+					break
+				}
+
+				label = fmt.Sprintf("recovered_%d", i)
+			}
+
+			b := database.NewBook(uuid, label, 0, false, true)
+			if err := b.Insert(tx); err != nil {
+				return errors.Wrapf(err, "inserting book %s", uuid)
+			}
+
+			log.Warnf("recovered notes without a book into the book '%s'\n", label)
+		}
+
+		_, err = tx.Exec(`CREATE TABLE notes_tmp
+		(
+			uuid text NOT NULL,
+			book_uuid text NOT NULL REFERENCES books(uuid) ON UPDATE CASCADE DEFERRABLE INITIALLY DEFERRED,
+			body text NOT NULL,
+			added_on integer NOT NULL,
+			edited_on integer DEFAULT 0,
+			public bool DEFAULT false,
+			dirty bool DEFAULT false,
+			usn int DEFAULT 0 NOT NULL,
+			deleted bool DEFAULT false
+		);`)
+		if err != nil {
+			return errors.Wrap(err, "creating temporary notes table for migration")
+		}
+
+		// preserve rowid because note_fts refers to notes by rowid
+		_, err = tx.Exec(`INSERT INTO notes_tmp (rowid, uuid, book_uuid, body, added_on, edited_on, public, dirty, usn, deleted)
+			SELECT rowid, uuid, book_uuid, body, added_on, edited_on, public, dirty, usn, deleted FROM notes;`)
+		if err != nil {
+			return errors.Wrap(err, "copying data to new table")
+		}
+
+		_, err = tx.Exec(`DROP TABLE notes;`)
+		if err != nil {
+			return errors.Wrap(err, "dropping the notes table")
+		}
+
+		_, err = tx.Exec(`ALTER TABLE notes_tmp RENAME to notes;`)
+		if err != nil {
+			return errors.Wrap(err, "renaming the temporary notes table")
+		}
+
+		_, err = tx.Exec(`
+			CREATE UNIQUE INDEX idx_notes_uuid ON notes(uuid);
+			CREATE INDEX idx_notes_book_uuid ON notes(book_uuid);
+			CREATE TRIGGER notes_after_insert AFTER INSERT ON notes BEGIN
+				INSERT INTO note_fts(rowid, body) VALUES (new.rowid, new.body);
+			END;
+			CREATE TRIGGER notes_after_delete AFTER DELETE ON notes BEGIN
+				INSERT INTO note_fts(note_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+			END;
+			CREATE TRIGGER notes_after_update AFTER UPDATE ON notes BEGIN
+				INSERT INTO note_fts(note_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+				INSERT INTO note_fts(rowid, body) VALUES (new.rowid, new.body);
+			END;
+		`)
+		if err != nil {
+			return errors.Wrap(err, "recreating indices and triggers")
+		}
+
+		return nil
+	},
+}
+
+var lm14 = migration{
+	name: "add-indexes-for-dirty-lookups-and-book-joins",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		// The partial indexes only contain the dirty rows, so that sending changes
+		// does not scan every note. The index on (uuid, usn, dirty) covers the
+		// queries that compare every local note with the server. The index on
+		// (book_uuid, deleted, added_on) supersedes the one on book_uuid.
+		_, err := tx.Exec(`
+			DROP INDEX IF EXISTS idx_notes_book_uuid;
+			CREATE INDEX idx_notes_book_uuid_deleted ON notes(book_uuid, deleted, added_on);
+			CREATE INDEX idx_notes_dirty ON notes(book_uuid) WHERE dirty;
+			CREATE INDEX idx_notes_uuid_usn_dirty ON notes(uuid, usn, dirty);
+			CREATE INDEX idx_books_dirty ON books(uuid) WHERE dirty;
+		`)
+		if err != nil {
+			return errors.Wrap(err, "creating indexes")
+		}
+
+		return nil
+	},
+}
+
+var lm15 = migration{
+	name: "create-actions-journal",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		// The legacy actions table was dropped by lm6, but is recreated empty by
+		// InitDB on every run. It is replaced by the journal of the changes.
+		_, err := tx.Exec(`
+			DROP TABLE IF EXISTS actions;
+			CREATE TABLE actions
+				(
+					id integer PRIMARY KEY AUTOINCREMENT,
+					timestamp integer NOT NULL,
+					actor text NOT NULL,
+					operation text NOT NULL,
+					note_uuid text NOT NULL DEFAULT '',
+					book_uuid text NOT NULL DEFAULT '',
+					summary text NOT NULL DEFAULT ''
+				);
+			CREATE INDEX idx_actions_timestamp ON actions(timestamp);
+			CREATE INDEX idx_actions_note_uuid ON actions(note_uuid);
+			CREATE INDEX idx_actions_book_uuid ON actions(book_uuid);
+		`)
+		if err != nil {
+			return errors.Wrap(err, "creating the actions table")
+		}
+
+		return nil
+	},
+}
+
+var lm16 = migration{
+	name: "add-device-to-notes-and-actions",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		// The device is local metadata for telling apart the notes and the
+		// actions made on different machines. It is never synced.
+		_, err := tx.Exec("ALTER TABLE notes ADD COLUMN origin_device text NOT NULL DEFAULT ''")
+		if err != nil {
+			return errors.Wrap(err, "adding origin_device column to notes")
+		}
+
+		_, err = tx.Exec("ALTER TABLE actions ADD COLUMN device text NOT NULL DEFAULT ''")
+		if err != nil {
+			return errors.Wrap(err, "adding device column to actions")
+		}
+
+		return nil
+	},
+}
+
+var lm17 = migration{
+	name: "add-position-to-notes",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		_, err := tx.Exec("ALTER TABLE notes ADD COLUMN position real NOT NULL DEFAULT 0")
+		if err != nil {
+			return errors.Wrap(err, "adding position column to notes")
+		}
+
+		// number the notes in each book in the order they were listed so far
+		_, err = tx.Exec(`UPDATE notes SET position = (
+			SELECT count(*) FROM notes AS n
+			WHERE n.book_uuid = notes.book_uuid AND (n.added_on < notes.added_on OR (n.added_on = notes.added_on AND n.rowid <= notes.rowid))
+		)`)
+		if err != nil {
+			return errors.Wrap(err, "numbering the notes")
+		}
+
+		_, err = tx.Exec("CREATE INDEX idx_notes_book_uuid_position ON notes(book_uuid, position)")
+		if err != nil {
+			return errors.Wrap(err, "creating the position index")
+		}
+
+		return nil
+	},
+}
+
+var lm18 = migration{
+	name: "add-view-stats-to-notes",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		_, err := tx.Exec("ALTER TABLE notes ADD COLUMN view_count integer NOT NULL DEFAULT 0")
+		if err != nil {
+			return errors.Wrap(err, "adding view_count column to notes")
+		}
+
+		_, err = tx.Exec("ALTER TABLE notes ADD COLUMN last_viewed_at integer NOT NULL DEFAULT 0")
+		if err != nil {
+			return errors.Wrap(err, "adding last_viewed_at column to notes")
+		}
+
+		return nil
+	},
+}
+
+var lm19 = migration{
+	name: "add-book-retention",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		// The retention policies are local settings. They are never synced.
+		_, err := tx.Exec(`CREATE TABLE book_retention
+		(
+			book_uuid text PRIMARY KEY,
+			max_age integer NOT NULL DEFAULT 0,
+			max_count integer NOT NULL DEFAULT 0
+		)`)
+		if err != nil {
+			return errors.Wrap(err, "creating the book_retention table")
+		}
+
+		return nil
+	},
+}
+
+var lm20 = migration{
+	name: "add-book-strategy",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		// The merge strategies are local settings. They are never synced.
+		_, err := tx.Exec(`CREATE TABLE book_strategy
+		(
+			book_uuid text PRIMARY KEY,
+			strategy text NOT NULL
+		)`)
+		if err != nil {
+			return errors.Wrap(err, "creating the book_strategy table")
+		}
+
+		return nil
+	},
+}
+
+var lm21 = migration{
+	name: "add-expunged-archive",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		// The archive keeps the notes that the syncs removed because they were
+		// removed on another device. It is local and never synced.
+		_, err := tx.Exec(`CREATE TABLE expunged_archive
+		(
+			id integer PRIMARY KEY AUTOINCREMENT,
+			note_uuid text NOT NULL,
+			book_label text NOT NULL,
+			body text NOT NULL,
+			added_on integer NOT NULL,
+			edited_on integer NOT NULL DEFAULT 0,
+			expunged_at integer NOT NULL
+		)`)
+		if err != nil {
+			return errors.Wrap(err, "creating the expunged_archive table")
+		}
+
+		return nil
+	},
+}
+
+var lm22 = migration{
+	name: "add-note-shares",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		// The share links are cached from the server for listing them, and
+		// are refreshed by the syncs.
+		_, err := tx.Exec(`CREATE TABLE note_shares
+		(
+			id text PRIMARY KEY,
+			note_uuid text NOT NULL,
+			url text NOT NULL,
+			created_at integer NOT NULL DEFAULT 0,
+			expires_at integer NOT NULL DEFAULT 0
+		)`)
+		if err != nil {
+			return errors.Wrap(err, "creating the note_shares table")
+		}
+
+		if _, err := tx.Exec("CREATE INDEX idx_note_shares_note_uuid ON note_shares(note_uuid)"); err != nil {
+			return errors.Wrap(err, "creating the index on the note uuid of the shares")
+		}
+
+		return nil
+	},
+}
+
 var rm1 = migration{
 	name: "sync-book-uuids-from-server",
 	run: func(ctx context.DnoteCtx, tx *database.DB) error {
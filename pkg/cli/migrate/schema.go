@@ -0,0 +1,289 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migrate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// The stability of a column of the local database, as described to the tools
+// that read the database directly. A stable column is never renamed, removed,
+// or repurposed, and new columns are only added. An internal column may change
+// in any release.
+const (
+	StabilityStable   = "stable"
+	StabilityInternal = "internal"
+)
+
+// Column describes a column of the local database
+type Column struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Stability   string `json:"stability"`
+	Description string `json:"description"`
+}
+
+// Table describes a table of the local database
+type Table struct {
+	Name    string   `json:"name"`
+	Columns []Column `json:"columns"`
+}
+
+// Schema describes the local database at a schema version
+type Schema struct {
+	Version int     `json:"version"`
+	Tables  []Table `json:"tables"`
+}
+
+// tables are the tables after running LocalSequence, with the types of the
+// columns as declared by the migrations. They must be updated along with a
+// migration that changes the schema. A stable column must keep its name, type
+// and meaning.
+var tables = []Table{
+	{
+		Name: "books",
+		Columns: []Column{
+			{"uuid", "text", StabilityStable, "the uuid of the book"},
+			{"label", "text", StabilityStable, "the name of the book, unique among the books"},
+			{"dirty", "bool", StabilityInternal, "whether the book has changes that are not synced"},
+			{"usn", "int", StabilityInternal, "the update sequence number of the book on the server"},
+			{"deleted", "bool", StabilityStable, "whether the book is deleted. A deleted book is kept until it is synced"},
+		},
+	},
+	{
+		Name: "notes",
+		Columns: []Column{
+			{"uuid", "text", StabilityStable, "the uuid of the note"},
+			{"book_uuid", "text", StabilityStable, "the uuid of the book of the note"},
+			{"body", "text", StabilityStable, "the content of the note"},
+			{"added_on", "integer", StabilityStable, "the time the note was added, in Unix nanoseconds"},
+			{"edited_on", "integer", StabilityStable, "the time the note was last edited, in Unix nanoseconds, or 0"},
+			{"public", "bool", StabilityStable, "whether the note is public"},
+			{"dirty", "bool", StabilityInternal, "whether the note has changes that are not synced"},
+			{"usn", "int", StabilityInternal, "the update sequence number of the note on the server"},
+			{"deleted", "bool", StabilityStable, "whether the note is deleted. A deleted note is kept until it is synced"},
+			{"origin_device", "text", StabilityInternal, "the device that added the note"},
+			{"position", "real", StabilityInternal, "the order of the note in its book"},
+			{"view_count", "integer", StabilityInternal, "the number of times the note was viewed"},
+			{"last_viewed_at", "integer", StabilityInternal, "the time the note was last viewed, in Unix nanoseconds, or 0"},
+		},
+	},
+	{
+		Name: "note_fts",
+		Columns: []Column{
+			{"body", "", StabilityInternal, "the full-text search index of the bodies of the notes"},
+		},
+	},
+	{
+		Name: "system",
+		Columns: []Column{
+			{"key", "string", StabilityInternal, "the name of a setting or a state of dnote"},
+			{"value", "text", StabilityInternal, "the value of the setting or the state"},
+		},
+	},
+	{
+		Name: "actions",
+		Columns: []Column{
+			{"id", "integer", StabilityInternal, "the id of the change"},
+			{"timestamp", "integer", StabilityInternal, "the time of the change, in Unix nanoseconds"},
+			{"actor", "text", StabilityInternal, "what made the change"},
+			{"operation", "text", StabilityInternal, "the kind of the change"},
+			{"note_uuid", "text", StabilityInternal, "the uuid of the changed note, if any"},
+			{"book_uuid", "text", StabilityInternal, "the uuid of the changed book, if any"},
+			{"summary", "text", StabilityInternal, "the changed fields"},
+			{"device", "text", StabilityInternal, "the device that made the change"},
+		},
+	},
+	{
+		Name: "book_retention",
+		Columns: []Column{
+			{"book_uuid", "text", StabilityInternal, "the uuid of the book"},
+			{"max_age", "integer", StabilityInternal, "the age after which the notes are removed, in seconds, or 0"},
+			{"max_count", "integer", StabilityInternal, "the number of the newest notes to keep, or 0"},
+		},
+	},
+	{
+		Name: "book_strategy",
+		Columns: []Column{
+			{"book_uuid", "text", StabilityInternal, "the uuid of the book"},
+			{"strategy", "text", StabilityInternal, "how the notes of the book changed both locally and on the server are merged"},
+		},
+	},
+	{
+		Name: "expunged_archive",
+		Columns: []Column{
+			{"id", "integer", StabilityInternal, "the id of the archived note"},
+			{"note_uuid", "text", StabilityInternal, "the uuid that the note had"},
+			{"book_label", "text", StabilityInternal, "the name of the book that the note was in"},
+			{"body", "text", StabilityInternal, "the content of the note"},
+			{"added_on", "integer", StabilityInternal, "the time the note was added, in Unix nanoseconds"},
+			{"edited_on", "integer", StabilityInternal, "the time the note was last edited, in Unix nanoseconds, or 0"},
+			{"expunged_at", "integer", StabilityInternal, "the time a sync removed the note, in Unix nanoseconds"},
+		},
+	},
+	{
+		Name: "note_shares",
+		Columns: []Column{
+			{"id", "text", StabilityInternal, "the id of the share link on the server"},
+			{"note_uuid", "text", StabilityInternal, "the uuid of the shared note"},
+			{"url", "text", StabilityInternal, "the URL at which the note can be viewed"},
+			{"created_at", "integer", StabilityInternal, "the time the link was created, in Unix nanoseconds"},
+			{"expires_at", "integer", StabilityInternal, "the time the link expires, in Unix nanoseconds, or 0 if it does not"},
+		},
+	},
+}
+
+// Describe returns the schema of the local database after running
+// LocalSequence, for the tools that read the database directly
+func Describe() Schema {
+	return Schema{Version: len(LocalSequence), Tables: tables}
+}
+
+// columnNames returns the names of the columns of the given tables by table
+func columnNames(tables []Table) map[string][]string {
+	ret := map[string][]string{}
+	for _, t := range tables {
+		for _, c := range t.Columns {
+			ret[t.Name] = append(ret[t.Name], c.Name)
+		}
+	}
+
+	return ret
+}
+
+// SchemaError is an error for a database schema that does not match the one
+// this version of dnote expects, such as after downgrading dnote or restoring
+// an old backup
+type SchemaError struct {
+	// Current is the schema version of the database
+	Current int
+	// Latest is the latest schema version known to this version of dnote
+	Latest int
+	// Missing is the expected columns that the database lacks
+	Missing []string
+	// Unknown is the columns of the database that are not expected
+	Unknown []string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Current > e.Latest {
+		return fmt.Sprintf("your database schema (v%d) is newer than this version of dnote supports (v%d). Upgrade dnote or restore an older backup", e.Current, e.Latest)
+	}
+	if len(e.Missing) > 0 {
+		return fmt.Sprintf("your database schema (v%d) lacks the columns %s expected by this version of dnote. Restore a backup of the database", e.Current, strings.Join(e.Missing, ", "))
+	}
+
+	return fmt.Sprintf("your database schema (v%d) has the columns %s unknown to this version of dnote, possibly added by a newer version. Upgrade dnote or restore an older backup", e.Current, strings.Join(e.Unknown, ", "))
+}
+
+// IsSchemaError checks if the given error is caused by a schema mismatch
+func IsSchemaError(err error) bool {
+	_, ok := errors.Cause(err).(*SchemaError)
+	return ok
+}
+
+// getColumns returns the names of the columns of the given table
+func getColumns(db *database.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying the columns of %s", table)
+	}
+	defer rows.Close()
+
+	ret := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt interface{}
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return nil, errors.Wrapf(err, "scanning a column of %s", table)
+		}
+
+		ret[name] = true
+	}
+
+	return ret, rows.Err()
+}
+
+// CheckSchema compares the schema version and the columns of the database
+// with the ones expected by this version of dnote. It returns a *SchemaError
+// if they do not match. It is meant to be called after running the local
+// migrations, or when they cannot be run.
+func CheckSchema(db *database.DB) error {
+	return checkSchema(db, len(LocalSequence), columnNames(tables))
+}
+
+func checkSchema(db *database.DB, latest int, columns map[string][]string) error {
+	var current int
+	if err := db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSchema).Scan(&current); err != nil {
+		return errors.Wrap(err, "querying schema")
+	}
+
+	e := SchemaError{Current: current, Latest: latest}
+	if current > latest {
+		return &e
+	}
+	// the columns of an outdated schema are brought up to date by the migrations
+	if current < latest {
+		return nil
+	}
+
+	tables := []string{}
+	for table := range columns {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		actual, err := getColumns(db, table)
+		if err != nil {
+			return errors.Wrapf(err, "getting the columns of %s", table)
+		}
+
+		expected := map[string]bool{}
+		for _, name := range columns[table] {
+			expected[name] = true
+
+			if !actual[name] {
+				e.Missing = append(e.Missing, table+"."+name)
+			}
+		}
+
+		unknown := []string{}
+		for name := range actual {
+			if !expected[name] {
+				unknown = append(unknown, table+"."+name)
+			}
+		}
+		sort.Strings(unknown)
+		e.Unknown = append(e.Unknown, unknown...)
+	}
+
+	if len(e.Missing) > 0 || len(e.Unknown) > 0 {
+		return &e
+	}
+
+	return nil
+}
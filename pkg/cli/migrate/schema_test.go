@@ -0,0 +1,248 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func TestCheckSchema(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, "../tmp/.dnote/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	// execute
+	err := CheckSchema(db)
+
+	// test
+	assert.Equal(t, err, nil, "the test schema does not match the expected columns")
+}
+
+func TestCheckSchema_mismatch(t *testing.T) {
+	columns := map[string][]string{
+		"books":  {"uuid", "label", "dirty", "usn", "deleted"},
+		"system": {"key", "value"},
+	}
+
+	testCases := []struct {
+		name     string
+		schema   int
+		setup    func(t *testing.T, db *database.DB)
+		expected *SchemaError
+	}{
+		{
+			name:     "match",
+			schema:   2,
+			setup:    func(t *testing.T, db *database.DB) {},
+			expected: nil,
+		},
+		{
+			name:     "ahead",
+			schema:   4,
+			setup:    func(t *testing.T, db *database.DB) {},
+			expected: &SchemaError{Current: 4, Latest: 2},
+		},
+		{
+			name:   "behind",
+			schema: 1,
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "adding a column", db, "ALTER TABLE books ADD COLUMN pinned bool")
+			},
+			expected: nil,
+		},
+		{
+			name:   "missing column",
+			schema: 2,
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "dropping books", db, "DROP TABLE books")
+				database.MustExec(t, "creating books", db, "CREATE TABLE books (uuid text PRIMARY KEY, label text NOT NULL)")
+			},
+			expected: &SchemaError{Current: 2, Latest: 2, Missing: []string{"books.dirty", "books.usn", "books.deleted"}},
+		},
+		{
+			name:   "unknown columns",
+			schema: 2,
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "adding a column to books", db, "ALTER TABLE books ADD COLUMN pinned bool")
+				database.MustExec(t, "adding a column to system", db, "ALTER TABLE system ADD COLUMN updated_at integer")
+			},
+			expected: &SchemaError{Current: 2, Latest: 2, Unknown: []string{"books.pinned", "system.updated_at"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			db := database.InitTestDB(t, "../tmp/.dnote/dnote-test.db", &database.TestDBOptions{SkipMigration: true})
+			defer database.TeardownTestDB(t, db)
+
+			database.MustExec(t, "inserting a schema", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSchema, tc.schema)
+			tc.setup(t, db)
+
+			// execute
+			err := checkSchema(db, 2, columns)
+
+			// test
+			if tc.expected == nil {
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "executing"))
+				}
+
+				return
+			}
+
+			assert.Equal(t, IsSchemaError(err), true, "error mismatch")
+			assert.DeepEqual(t, errors.Cause(err), tc.expected, "schema error mismatch")
+		})
+	}
+}
+
+// stableColumns are the stable columns of the local database with their types.
+// The tools that read the database rely on them, so a stable column must never
+// be renamed, removed, or repurposed. New ones are only appended.
+var stableColumns = []string{
+	"books.uuid text",
+	"books.label text",
+	"books.deleted bool",
+	"notes.uuid text",
+	"notes.book_uuid text",
+	"notes.body text",
+	"notes.added_on integer",
+	"notes.edited_on integer",
+	"notes.public bool",
+	"notes.deleted bool",
+}
+
+func TestDescribe_stableColumns(t *testing.T) {
+	// execute
+	schema := Describe()
+
+	// test
+	assert.Equal(t, schema.Version, len(LocalSequence), "version mismatch")
+
+	got := []string{}
+	for _, table := range schema.Tables {
+		for _, c := range table.Columns {
+			if c.Stability != StabilityStable && c.Stability != StabilityInternal {
+				t.Errorf("unknown stability '%s' of %s.%s", c.Stability, table.Name, c.Name)
+			}
+			if c.Stability == StabilityStable {
+				got = append(got, fmt.Sprintf("%s.%s %s", table.Name, c.Name, c.Type))
+			}
+		}
+	}
+
+	assert.DeepEqual(t, got, stableColumns, "stable columns mismatch")
+}
+
+// getColumnTypes returns the declared types of the columns of the given table
+// by name, in lower case since sqlite may report them in upper case
+func getColumnTypes(t *testing.T, db *database.DB, table string) map[string]string {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		t.Fatal(errors.Wrapf(err, "querying the columns of %s", table))
+	}
+	defer rows.Close()
+
+	ret := map[string]string{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt interface{}
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			t.Fatal(errors.Wrapf(err, "scanning a column of %s", table))
+		}
+
+		ret[name] = strings.ToLower(colType)
+	}
+
+	return ret
+}
+
+// TestDescribe_live tests that the schema description matches the database
+// created by running the migrations, so that a migration cannot change the
+// schema without updating it
+func TestDescribe_live(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-1-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, paths, &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	configPath := fmt.Sprintf("%s/dnoterc", ctx.Paths.LegacyDnote)
+	if err := ioutil.WriteFile(configPath, []byte("editor: vim"), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing config"))
+	}
+	defer os.Remove(configPath)
+
+	if err := Run(ctx, LocalSequence, LocalMode); err != nil {
+		t.Fatal(errors.Wrap(err, "running the migrations"))
+	}
+
+	// execute
+	schema := Describe()
+
+	// test
+	db := ctx.DB
+
+	// the internal tables of sqlite and the shadow tables of the full-text
+	// search index are not described
+	rows, err := db.Query(`SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite\_%' ESCAPE '\' AND name NOT LIKE 'note\_fts\_%' ESCAPE '\'
+		ORDER BY name`)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "querying tables"))
+	}
+	liveTables := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(errors.Wrap(err, "scanning a table"))
+		}
+
+		liveTables = append(liveTables, name)
+	}
+	rows.Close()
+
+	describedTables := []string{}
+	for _, table := range schema.Tables {
+		describedTables = append(describedTables, table.Name)
+	}
+	sort.Strings(describedTables)
+	assert.DeepEqual(t, describedTables, liveTables, "table mismatch")
+
+	for _, table := range schema.Tables {
+		described := map[string]string{}
+		for _, c := range table.Columns {
+			described[c.Name] = c.Type
+		}
+
+		assert.DeepEqual(t, described, getColumnTypes(t, db, table.Name), fmt.Sprintf("columns of %s mismatch", table.Name))
+	}
+}
@@ -0,0 +1,338 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package onboarding sets up Dnote on the first run by asking a new user where
+// to keep the notes, whether to sync them and which editor to use
+package onboarding
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/cmd/login"
+	"github.com/dnote/dnote/pkg/cli/config"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+)
+
+// hint is the flag that skips the prompts of the onboarding
+const hint = "--no-onboarding"
+
+// exampleBook is the book of the example note
+const exampleBook = "getting-started"
+
+var exampleNote = `Welcome to Dnote! This is an example note.
+
+- dnote add <book> adds a note to a book
+- dnote view lists the books, and dnote view <book> the notes in a book
+- dnote find <keywords> searches the notes
+- dnote edit <id> and dnote remove <id> change or remove a note
+- dnote sync syncs the notes with the server
+`
+
+// Prompter asks the questions of the onboarding. It is injected so that the
+// steps can run without a terminal.
+type Prompter interface {
+	// Input asks for a text, and returns the default value if the answer is empty
+	Input(question, defaultValue string) (string, error)
+	// Password asks for a text without echoing it
+	Password(question string) (string, error)
+	// Confirm asks a yes or no question, and returns the default value if the
+	// answer is empty
+	Confirm(question string, defaultValue bool) (bool, error)
+}
+
+// terminalPrompter asks the questions on the terminal
+type terminalPrompter struct{}
+
+// NewTerminalPrompter returns a prompter that asks the user on the terminal
+func NewTerminalPrompter() Prompter {
+	return terminalPrompter{}
+}
+
+func (terminalPrompter) Input(question, defaultValue string) (string, error) {
+	message := question
+	if defaultValue != "" {
+		message = fmt.Sprintf("%s (%s)", question, defaultValue)
+	}
+
+	var ret string
+	if err := ui.PromptInput(message, hint, &ret); err != nil {
+		return "", err
+	}
+
+	ret = strings.TrimSpace(ret)
+	if ret == "" {
+		return defaultValue, nil
+	}
+
+	return ret, nil
+}
+
+func (terminalPrompter) Password(question string) (string, error) {
+	var ret string
+	if err := ui.PromptPassword(question, hint, &ret); err != nil {
+		return "", err
+	}
+
+	return ret, nil
+}
+
+func (terminalPrompter) Confirm(question string, defaultValue bool) (bool, error) {
+	return ui.Confirm(question, hint, defaultValue)
+}
+
+// Answers are the choices of the user
+type Answers struct {
+	// DataDir is the directory that keeps the dnote data directory
+	DataDir string
+	// LocalOnly indicates that the notes are not synced with a server
+	LocalOnly   bool
+	APIEndpoint string
+	// Email and Password log the user in if Email is not empty
+	Email    string
+	Password string
+	Editor   string
+	// Example adds an example note
+	Example bool
+}
+
+// step asks a question and records the answer
+type step func(p Prompter, a *Answers) error
+
+// steps are the questions of the onboarding in the order they are asked
+var steps = []step{askDataDir, askSync, askEditor, askExample}
+
+// Ask asks the questions of the onboarding. The defaults are kept for the
+// questions that are not answered.
+func Ask(p Prompter, defaults Answers) (Answers, error) {
+	a := defaults
+
+	for _, s := range steps {
+		if err := s(p, &a); err != nil {
+			return a, err
+		}
+	}
+
+	return a, nil
+}
+
+// expandDir returns the absolute path of the given directory, in which a
+// leading ~ is the home directory of the user
+func expandDir(dir string) (string, error) {
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "finding the home directory")
+		}
+
+		dir = filepath.Join(home, dir[1:])
+	}
+
+	return filepath.Abs(dir)
+}
+
+func askDataDir(p Prompter, a *Answers) error {
+	dir, err := p.Input(i18n.T("onboarding.data_dir"), a.DataDir)
+	if err != nil {
+		return errors.Wrap(err, "asking for the data directory")
+	}
+
+	if a.DataDir, err = expandDir(dir); err != nil {
+		return errors.Wrapf(err, "invalid directory '%s'", dir)
+	}
+
+	return nil
+}
+
+func askSync(p Prompter, a *Answers) error {
+	ok, err := p.Confirm(i18n.T("onboarding.sync"), true)
+	if err != nil {
+		return errors.Wrap(err, "asking whether to sync")
+	}
+	if !ok {
+		a.LocalOnly = true
+		return nil
+	}
+
+	if a.APIEndpoint, err = p.Input(i18n.T("onboarding.api_endpoint"), a.APIEndpoint); err != nil {
+		return errors.Wrap(err, "asking for the API endpoint")
+	}
+	if a.APIEndpoint, err = utils.NormalizeEndpoint(a.APIEndpoint); err != nil {
+		return err
+	}
+
+	ok, err = p.Confirm(i18n.T("onboarding.login"), true)
+	if err != nil {
+		return errors.Wrap(err, "asking whether to log in")
+	}
+	if !ok {
+		return nil
+	}
+
+	// an empty email skips logging in
+	if a.Email, err = p.Input(i18n.T("login.email"), ""); err != nil {
+		return errors.Wrap(err, "asking for the email")
+	}
+	if a.Email == "" {
+		return nil
+	}
+	if a.Password, err = p.Password(i18n.T("login.password")); err != nil {
+		return errors.Wrap(err, "asking for the password")
+	}
+
+	return nil
+}
+
+func askEditor(p Prompter, a *Answers) error {
+	editor, err := p.Input(i18n.T("onboarding.editor"), a.Editor)
+	if err != nil {
+		return errors.Wrap(err, "asking for the editor")
+	}
+
+	a.Editor = editor
+
+	return nil
+}
+
+func askExample(p Prompter, a *Answers) error {
+	ok, err := p.Confirm(i18n.T("onboarding.example"), a.Example)
+	if err != nil {
+		return errors.Wrap(err, "asking whether to add an example note")
+	}
+
+	a.Example = ok
+
+	return nil
+}
+
+// writeConfig writes a new config file with the answers, by which Dnote is
+// initialized
+func writeConfig(paths context.Paths, a Answers) error {
+	ctx := context.DnoteCtx{Paths: paths}
+
+	cf := config.Config{
+		Editor:      a.Editor,
+		APIEndpoint: a.APIEndpoint,
+		LocalOnly:   a.LocalOnly,
+	}
+	if a.DataDir != paths.Data {
+		cf.DataDir = a.DataDir
+	}
+
+	if err := os.MkdirAll(filepath.Dir(config.GetPath(ctx)), 0755); err != nil {
+		return errors.Wrap(err, "creating the config directory")
+	}
+	if err := config.Write(ctx, cf); err != nil {
+		return errors.Wrap(err, "writing the config")
+	}
+
+	return nil
+}
+
+// finish logs the user in and adds the example note. A failure to log in does
+// not fail the onboarding because the user can log in later.
+func finish(ctx context.DnoteCtx, a Answers) error {
+	if a.Example {
+		if _, err := dnote.NewStore(ctx).AddNote(exampleBook, exampleNote); err != nil {
+			return errors.Wrap(err, "adding the example note")
+		}
+
+		log.Info(i18n.T("onboarding.example_added", exampleBook))
+	}
+
+	if a.Email != "" {
+		err := login.Do(ctx, a.Email, a.Password)
+		if errors.Cause(err) == client.ErrInvalidLogin {
+			log.Warn(i18n.T("onboarding.wrong_login"))
+		} else if err != nil {
+			log.Warn(i18n.T("onboarding.login_failed", err))
+		} else {
+			log.Success(i18n.T("login.logged_in"))
+		}
+	}
+
+	return nil
+}
+
+// Run sets up Dnote with the answers of the user if it has never run with the
+// paths of the user, and does nothing otherwise
+func Run(p Prompter, apiEndpoint, versionTag string) error {
+	return run(p, infra.GetPaths(), apiEndpoint, func() (*context.DnoteCtx, error) {
+		return infra.Init(apiEndpoint, versionTag, false)
+	})
+}
+
+// run sets up Dnote with the given paths. initCtx initializes Dnote once the
+// config file is written.
+func run(p Prompter, paths context.Paths, apiEndpoint string, initCtx func() (*context.DnoteCtx, error)) error {
+	ok, err := infra.IsFreshInstall(paths)
+	if err != nil {
+		return errors.Wrap(err, "checking for a fresh install")
+	}
+	if !ok {
+		return nil
+	}
+
+	log.Plain(i18n.T("onboarding.welcome"))
+
+	cf := infra.DefaultConfig(apiEndpoint)
+	defaults := Answers{
+		DataDir:     paths.Data,
+		APIEndpoint: cf.APIEndpoint,
+		Editor:      cf.Editor,
+		Example:     true,
+	}
+
+	a, err := Ask(p, defaults)
+	if err != nil {
+		return err
+	}
+
+	if err := writeConfig(paths, a); err != nil {
+		return err
+	}
+
+	ctx, err := initCtx()
+	if err != nil {
+		return errors.Wrap(err, "initializing")
+	}
+	defer ctx.DB.Close()
+
+	if err := finish(*ctx, a); err != nil {
+		return err
+	}
+
+	log.Success(i18n.T("onboarding.done", filepath.Join(a.DataDir, consts.DnoteDirName)))
+	if a.LocalOnly {
+		log.Plain(i18n.T("onboarding.local_only"))
+	}
+	log.Plain("\n")
+
+	return nil
+}
@@ -0,0 +1,202 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package onboarding
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/config"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// scriptedPrompter answers the questions with the given answers in order, in
+// which an empty answer takes the default
+type scriptedPrompter struct {
+	t       *testing.T
+	answers []string
+}
+
+func (p *scriptedPrompter) next(question string) string {
+	if len(p.answers) == 0 {
+		p.t.Fatalf("unexpected question '%s'", question)
+	}
+
+	ret := p.answers[0]
+	p.answers = p.answers[1:]
+
+	return ret
+}
+
+func (p *scriptedPrompter) Input(question, defaultValue string) (string, error) {
+	if answer := p.next(question); answer != "" {
+		return answer, nil
+	}
+
+	return defaultValue, nil
+}
+
+func (p *scriptedPrompter) Password(question string) (string, error) {
+	return p.next(question), nil
+}
+
+func (p *scriptedPrompter) Confirm(question string, defaultValue bool) (bool, error) {
+	switch p.next(question) {
+	case "y":
+		return true, nil
+	case "n":
+		return false, nil
+	}
+
+	return defaultValue, nil
+}
+
+func TestAsk(t *testing.T) {
+	defaults := Answers{
+		DataDir:     "/home/user/.local/share",
+		APIEndpoint: "https://api.getdnote.com",
+		Editor:      "vi",
+		Example:     true,
+	}
+
+	testCases := []struct {
+		name     string
+		answers  []string
+		expected Answers
+	}{
+		{
+			name:     "defaults",
+			answers:  []string{"", "", "", "", "", "", ""},
+			expected: defaults,
+		},
+		{
+			name:    "local only",
+			answers: []string{"/srv/notes", "n", "nvim", "n"},
+			expected: Answers{
+				DataDir:     "/srv/notes",
+				LocalOnly:   true,
+				APIEndpoint: "https://api.getdnote.com",
+				Editor:      "nvim",
+			},
+		},
+		{
+			name:    "log in",
+			answers: []string{"", "y", "https://dnote.example.com/api", "y", "alice@example.com", "pass1234", "", "n"},
+			expected: Answers{
+				DataDir:     "/home/user/.local/share",
+				APIEndpoint: "https://dnote.example.com/api",
+				Email:       "alice@example.com",
+				Password:    "pass1234",
+				Editor:      "vi",
+			},
+		},
+		{
+			name:     "sync without logging in",
+			answers:  []string{"", "y", "", "n", "", ""},
+			expected: defaults,
+		},
+		{
+			name:    "endpoint with a trailing slash",
+			answers: []string{"", "y", "http://localhost:3000/", "n", "", ""},
+			expected: Answers{
+				DataDir:     "/home/user/.local/share",
+				APIEndpoint: "http://localhost:3000",
+				Editor:      "vi",
+				Example:     true,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &scriptedPrompter{t: t, answers: tc.answers}
+
+			got, err := Ask(p, defaults)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "asking"))
+			}
+
+			assert.DeepEqual(t, got, tc.expected, "answers mismatch")
+			assert.Equal(t, len(p.answers), 0, "every answer should be used")
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := t.TempDir()
+	paths := context.Paths{Home: dir, Config: dir, Data: dir, Cache: dir}
+	initCtx := func() (*context.DnoteCtx, error) {
+		return infra.InitAt(dir, "https://api.getdnote.com", "test")
+	}
+
+	p := &scriptedPrompter{t: t, answers: []string{dataDir, "n", "nano", "y"}}
+	if err := run(p, paths, "https://api.getdnote.com", initCtx); err != nil {
+		t.Fatal(errors.Wrap(err, "running the onboarding"))
+	}
+
+	cf, err := config.Read(context.DnoteCtx{Paths: paths})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the config"))
+	}
+	assert.Equal(t, cf.Editor, "nano", "editor mismatch")
+	assert.Equal(t, cf.LocalOnly, true, "local only mismatch")
+	assert.Equal(t, cf.DataDir, dataDir, "data directory mismatch")
+
+	ctx, err := initCtx()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "initializing"))
+	}
+	defer ctx.DB.Close()
+
+	assert.Equal(t, ctx.Paths.Data, dataDir, "data directory mismatch in the context")
+	assert.Equal(t, ctx.LocalOnly, true, "local only mismatch in the context")
+
+	f := testutils.NewFixture(t, ctx.DB)
+	assert.Equal(t, f.MustCountBooks(), 1, "book count mismatch")
+	assert.Equal(t, f.MustCountNotes(), 1, "note count mismatch")
+
+	var label string
+	if err := ctx.DB.QueryRow("SELECT label FROM books").Scan(&label); err != nil {
+		t.Fatal(errors.Wrap(err, "querying the book"))
+	}
+	assert.Equal(t, label, exampleBook, "book label mismatch")
+
+	t.Run("initialized", func(t *testing.T) {
+		// no question is asked once Dnote is set up
+		p := &scriptedPrompter{t: t}
+		if err := run(p, paths, "https://api.getdnote.com", initCtx); err != nil {
+			t.Fatal(errors.Wrap(err, "running the onboarding"))
+		}
+	})
+}
+
+func TestExpandDir(t *testing.T) {
+	got, err := expandDir("notes")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "expanding a relative path"))
+	}
+
+	assert.Equal(t, filepath.IsAbs(got), true, "the path should be absolute")
+	assert.Equal(t, filepath.Base(got), "notes", "base mismatch")
+}
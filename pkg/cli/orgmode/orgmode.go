@@ -0,0 +1,352 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package orgmode converts the notes between Markdown and Org mode files
+package orgmode
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// timestampFormat is the format of the inactive timestamps in the property
+// drawers. They are in UTC because Org timestamps do not have a time zone.
+const timestampFormat = "[2006-01-02 Mon 15:04]"
+
+// properties of a note
+const (
+	propUUID   = "UUID"
+	propAdded  = "ADDED"
+	propEdited = "EDITED"
+)
+
+var (
+	mdHeadingRegex  = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t]*#*[ \t]*$`)
+	mdFenceRegex    = regexp.MustCompile("^([ \t]*)(```+|~~~+)[ \t]*([^ \t`]*)")
+	mdBulletRegex   = regexp.MustCompile(`^([ \t]*)[*+-]([ \t]+)`)
+	orgHeadingRegex = regexp.MustCompile(`^(\*+)[ \t]+(.*?)[ \t]*$`)
+	orgBeginSrc     = regexp.MustCompile(`(?i)^([ \t]*)#\+begin_src(?:[ \t]+([^ \t]+))?`)
+	orgEndSrc       = regexp.MustCompile(`(?i)^[ \t]*#\+end_src[ \t]*$`)
+	orgBulletRegex  = regexp.MustCompile(`^([ \t]*)\+([ \t]+)`)
+	orgPropRegex    = regexp.MustCompile(`^[ \t]*:([^: \t]+):[ \t]*(.*?)[ \t]*$`)
+	orgTitleRegex   = regexp.MustCompile(`(?i)^#\+title:[ \t]*(.*?)[ \t]*$`)
+	// srcEscapeRegex matches the lines in a source block that Org escapes with
+	// a comma so that they are not read as headings or keywords
+	srcEscapeRegex   = regexp.MustCompile(`^([ \t]*)(,*(?:\*|#\+))`)
+	srcUnescapeRegex = regexp.MustCompile(`^([ \t]*),(,*(?:\*|#\+))`)
+)
+
+// Note is a note in an Org file
+type Note struct {
+	UUID     string
+	AddedOn  int64
+	EditedOn int64
+	// Body is the Markdown body of the note
+	Body string
+}
+
+// File is an Org file of a book
+type File struct {
+	Book  string
+	Notes []Note
+}
+
+// MarkdownToOrg converts the Markdown body of a note into Org syntax. The
+// headings are demoted by one level so that they are nested under the
+// heading of the note, the fenced code blocks become source blocks, and the
+// list bullets become dashes, because a line starting with an asterisk is a
+// heading in Org. The rest of the text is kept as it is.
+func MarkdownToOrg(md string) string {
+	lines := strings.Split(md, "\n")
+
+	var fence, indent string
+	inCode := false
+
+	for i, line := range lines {
+		if inCode {
+			// a closing fence is at least as long as the opening one
+			closing := strings.TrimSpace(line)
+			if len(closing) >= len(fence) && strings.Trim(closing, fence[:1]) == "" {
+				lines[i] = indent + "#+END_SRC"
+				inCode = false
+				continue
+			}
+
+			lines[i] = srcEscapeRegex.ReplaceAllString(line, "$1,$2")
+			continue
+		}
+
+		if m := mdFenceRegex.FindStringSubmatch(line); m != nil {
+			indent, fence = m[1], m[2]
+			inCode = true
+
+			lines[i] = indent + "#+BEGIN_SRC"
+			if m[3] != "" {
+				lines[i] += " " + m[3]
+			}
+			continue
+		}
+
+		if m := mdHeadingRegex.FindStringSubmatch(line); m != nil {
+			lines[i] = strings.Repeat("*", len(m[1])+1) + " " + m[2]
+			continue
+		}
+
+		lines[i] = mdBulletRegex.ReplaceAllString(line, "$1-$2")
+	}
+
+	// close a block left open at the end of the note
+	if inCode {
+		lines = append(lines, indent+"#+END_SRC")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// OrgToMarkdown converts the section of a note in Org syntax back into
+// Markdown. It is the inverse of MarkdownToOrg.
+func OrgToMarkdown(org string) string {
+	lines := strings.Split(org, "\n")
+
+	var indent string
+	inCode := false
+
+	for i, line := range lines {
+		if inCode {
+			if orgEndSrc.MatchString(line) {
+				lines[i] = indent + "```"
+				inCode = false
+				continue
+			}
+
+			lines[i] = srcUnescapeRegex.ReplaceAllString(line, "$1$2")
+			continue
+		}
+
+		if m := orgBeginSrc.FindStringSubmatch(line); m != nil {
+			indent = m[1]
+			inCode = true
+
+			lines[i] = indent + "```" + m[2]
+			continue
+		}
+
+		if m := orgHeadingRegex.FindStringSubmatch(line); m != nil {
+			level := len(m[1]) - 1
+			if level < 1 {
+				level = 1
+			}
+			if level > 6 {
+				level = 6
+			}
+
+			lines[i] = strings.Repeat("#", level) + " " + m[2]
+			continue
+		}
+
+		lines[i] = orgBulletRegex.ReplaceAllString(line, "$1-$2")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// splitTitle splits a note body into its title, which is its first line
+// without any Markdown heading marker, and the rest
+func splitTitle(body string) (string, string) {
+	body = strings.TrimLeft(body, "\n")
+
+	parts := strings.SplitN(body, "\n", 2)
+	title := strings.TrimSpace(parts[0])
+	if m := mdHeadingRegex.FindStringSubmatch(title); m != nil {
+		title = m[2]
+	}
+
+	var rest string
+	if len(parts) == 2 {
+		rest = strings.Trim(parts[1], "\n")
+	}
+
+	return title, rest
+}
+
+// joinTitle joins the title and the rest of a note body
+func joinTitle(title, rest string) string {
+	if rest == "" {
+		return title
+	}
+
+	return title + "\n" + rest
+}
+
+// Normalize returns the body that a note with the given body has after it
+// is exported and imported again. The conversion is not lossless, e.g. a
+// heading on the first line becomes plain text, so the bodies should be
+// compared in this form to tell if a note was changed in the Org file.
+func Normalize(body string) string {
+	title, rest := splitTitle(body)
+
+	return joinTitle(title, OrgToMarkdown(MarkdownToOrg(rest)))
+}
+
+func formatTimestamp(ts int64) string {
+	return time.Unix(0, ts).UTC().Format(timestampFormat)
+}
+
+func parseTimestamp(s string) (int64, error) {
+	t, err := time.Parse(timestampFormat, s)
+	if err != nil {
+		// the day of the week is optional
+		t, err = time.Parse("[2006-01-02 15:04]", s)
+		if err != nil {
+			return 0, errors.Errorf("invalid timestamp '%s'", s)
+		}
+	}
+
+	return t.UnixNano(), nil
+}
+
+// Format formats the notes of a book as an Org file. Each note is a
+// top-level heading titled with its first line, followed by a property
+// drawer and the rest of its body.
+func Format(f File) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "#+TITLE: %s\n", f.Book)
+
+	for _, n := range f.Notes {
+		title, rest := splitTitle(n.Body)
+
+		fmt.Fprintf(&b, "\n* %s\n", title)
+		b.WriteString(":PROPERTIES:\n")
+		fmt.Fprintf(&b, ":%s: %s\n", propUUID, n.UUID)
+		fmt.Fprintf(&b, ":%s: %s\n", propAdded, formatTimestamp(n.AddedOn))
+		if n.EditedOn != 0 {
+			fmt.Fprintf(&b, ":%s: %s\n", propEdited, formatTimestamp(n.EditedOn))
+		}
+		b.WriteString(":END:\n")
+
+		if rest != "" {
+			b.WriteString(MarkdownToOrg(rest))
+			b.WriteString("\n")
+		}
+	}
+
+	return b.String()
+}
+
+// Parse parses an Org file into the notes. Each top-level heading is a note.
+// The text before the first heading is ignored except for the title of the
+// file, which is the book.
+func Parse(s string) (File, error) {
+	var ret File
+
+	var current *Note
+	var title string
+	var section []string
+	inDrawer := false
+	inCode := false
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+
+		rest := strings.Trim(OrgToMarkdown(strings.Join(section, "\n")), "\n")
+		current.Body = joinTitle(title, rest)
+		ret.Notes = append(ret.Notes, *current)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if !inCode && strings.HasPrefix(line, "* ") {
+			flush()
+
+			current = &Note{}
+			title = strings.TrimSpace(line[2:])
+			section = nil
+			inDrawer = false
+			continue
+		}
+
+		if current == nil {
+			if m := orgTitleRegex.FindStringSubmatch(line); m != nil {
+				ret.Book = m[1]
+			}
+			continue
+		}
+
+		// the property drawer must directly follow the heading
+		if len(section) == 0 && !inDrawer && strings.EqualFold(strings.TrimSpace(line), ":PROPERTIES:") {
+			inDrawer = true
+			continue
+		}
+		if inDrawer {
+			if strings.EqualFold(strings.TrimSpace(line), ":END:") {
+				inDrawer = false
+				continue
+			}
+
+			m := orgPropRegex.FindStringSubmatch(line)
+			if m == nil {
+				return ret, errors.Errorf("line %d: invalid property '%s'", lineNum, line)
+			}
+
+			var err error
+			switch strings.ToUpper(m[1]) {
+			case propUUID:
+				current.UUID = m[2]
+			case propAdded:
+				current.AddedOn, err = parseTimestamp(m[2])
+			case propEdited:
+				current.EditedOn, err = parseTimestamp(m[2])
+			}
+			if err != nil {
+				return ret, errors.Wrapf(err, "line %d", lineNum)
+			}
+			continue
+		}
+
+		if orgBeginSrc.MatchString(line) {
+			inCode = true
+		} else if orgEndSrc.MatchString(line) {
+			inCode = false
+		}
+
+		section = append(section, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return ret, errors.Wrap(err, "reading the file")
+	}
+	if inDrawer {
+		return ret, errors.New("a property drawer is not closed")
+	}
+
+	flush()
+
+	return ret, nil
+}
@@ -0,0 +1,250 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package orgmode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func TestMarkdownToOrg(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "plain text",
+			input:    "some text\nwith *emphasis*",
+			expected: "some text\nwith *emphasis*",
+		},
+		{
+			name:     "headings",
+			input:    "# h1\n## h2 ##\n###### h6",
+			expected: "** h1\n*** h2\n******* h6",
+		},
+		{
+			name:     "not a heading",
+			input:    "#hashtag\n####### seven",
+			expected: "#hashtag\n####### seven",
+		},
+		{
+			name:     "bullets",
+			input:    "* a\n+ b\n- c",
+			expected: "- a\n- b\n- c",
+		},
+		{
+			name:     "nested lists",
+			input:    "* a\n  * a.1\n    + a.1.1\n  * a.2\n1. b\n   * b.1",
+			expected: "- a\n  - a.1\n    - a.1.1\n  - a.2\n1. b\n   - b.1",
+		},
+		{
+			name:     "src block",
+			input:    "```go\nfunc main() {}\n```",
+			expected: "#+BEGIN_SRC go\nfunc main() {}\n#+END_SRC",
+		},
+		{
+			name:     "src block without a language",
+			input:    "~~~\n* not a list\n~~~",
+			expected: "#+BEGIN_SRC\n,* not a list\n#+END_SRC",
+		},
+		{
+			name:     "src block with lines to escape",
+			input:    "```org\n* heading\n#+TITLE: x\n,* escaped\n  # comment\n- item\n```",
+			expected: "#+BEGIN_SRC org\n,* heading\n,#+TITLE: x\n,,* escaped\n  # comment\n- item\n#+END_SRC",
+		},
+		{
+			name:     "longer closing fence",
+			input:    "````md\n```\ninner\n```\n`````",
+			expected: "#+BEGIN_SRC md\n```\ninner\n```\n#+END_SRC",
+		},
+		{
+			name:     "indented src block in a list",
+			input:    "- step\n  ```sh\n  ls\n  ```",
+			expected: "- step\n  #+BEGIN_SRC sh\n  ls\n  #+END_SRC",
+		},
+		{
+			name:     "unclosed src block",
+			input:    "```\n# not a heading",
+			expected: "#+BEGIN_SRC\n# not a heading\n#+END_SRC",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, MarkdownToOrg(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestOrgToMarkdown(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "headings",
+			input:    "** h1\n*** h2\n******** h7",
+			expected: "# h1\n## h2\n###### h7",
+		},
+		{
+			name:     "bullets",
+			input:    "- a\n  + a.1\n1. b",
+			expected: "- a\n  - a.1\n1. b",
+		},
+		{
+			name:     "src block",
+			input:    "#+BEGIN_SRC go\n,* x\n,,#+y\n** not a heading\n#+END_SRC",
+			expected: "```go\n* x\n,#+y\n** not a heading\n```",
+		},
+		{
+			name:     "lowercase src block",
+			input:    "  #+begin_src\n  code\n  #+end_src",
+			expected: "  ```\n  code\n  ```",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, OrgToMarkdown(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "title", expected: "title"},
+		{input: "title\n\n* a\n  * b\n\n```js\n* x\n```", expected: "title\n- a\n  - b\n\n```js\n* x\n```"},
+		{input: "# title\n## sub", expected: "title\n## sub"},
+		{input: "\ntitle\nbody\n\n", expected: "title\nbody"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got := Normalize(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, Normalize(got), got, "normalizing twice mismatch")
+		})
+	}
+}
+
+func TestFormatParse(t *testing.T) {
+	addedOn := time.Date(2021, time.March, 15, 9, 30, 0, 0, time.UTC).UnixNano()
+	editedOn := time.Date(2021, time.March, 16, 18, 5, 0, 0, time.UTC).UnixNano()
+
+	f := File{
+		Book: "web dev",
+		Notes: []Note{
+			{UUID: "n1-uuid", AddedOn: addedOn, EditedOn: editedOn, Body: "Closures\n\n- capture the scope\n  - even later\n\n```js\nconst f = () => x;\n```"},
+			{UUID: "n2-uuid", AddedOn: addedOn, Body: "single line"},
+			{UUID: "n3-uuid", AddedOn: addedOn, Body: "code with org syntax\n```org\n* heading\n#+TITLE: x\n```"},
+		},
+	}
+
+	s := Format(f)
+
+	expected := `#+TITLE: web dev
+
+* Closures
+:PROPERTIES:
+:UUID: n1-uuid
+:ADDED: [2021-03-15 Mon 09:30]
+:EDITED: [2021-03-16 Tue 18:05]
+:END:
+- capture the scope
+  - even later
+
+#+BEGIN_SRC js
+const f = () => x;
+#+END_SRC
+
+* single line
+:PROPERTIES:
+:UUID: n2-uuid
+:ADDED: [2021-03-15 Mon 09:30]
+:END:
+
+* code with org syntax
+:PROPERTIES:
+:UUID: n3-uuid
+:ADDED: [2021-03-15 Mon 09:30]
+:END:
+#+BEGIN_SRC org
+,* heading
+,#+TITLE: x
+#+END_SRC
+`
+	assert.Equal(t, s, expected, "formatted file mismatch")
+
+	got, err := Parse(s)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "parsing"))
+	}
+	for i, n := range f.Notes {
+		f.Notes[i].Body = Normalize(n.Body)
+	}
+	assert.DeepEqual(t, got, f, "parsed file mismatch")
+}
+
+func TestParse(t *testing.T) {
+	t.Run("without properties", func(t *testing.T) {
+		got, err := Parse("some preamble\n* first\nbody\n** sub\n* second\n")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "parsing"))
+		}
+
+		assert.DeepEqual(t, got, File{
+			Notes: []Note{
+				{Body: "first\nbody\n# sub"},
+				{Body: "second"},
+			},
+		}, "result mismatch")
+	})
+
+	t.Run("timestamp without the day of the week", func(t *testing.T) {
+		got, err := Parse("* n\n:PROPERTIES:\n:ADDED: [2021-03-15 09:30]\n:END:\n")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "parsing"))
+		}
+
+		assert.Equal(t, got.Notes[0].AddedOn, time.Date(2021, time.March, 15, 9, 30, 0, 0, time.UTC).UnixNano(), "added_on mismatch")
+	})
+
+	testCases := []string{
+		"* n\n:PROPERTIES:\n:ADDED: yesterday\n:END:\n",
+		"* n\n:PROPERTIES:\nnot a property\n:END:\n",
+		"* n\n:PROPERTIES:\n:UUID: n1\n",
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc, func(t *testing.T) {
+			_, err := Parse(tc)
+
+			assert.NotEqual(t, err, nil, "error mismatch")
+		})
+	}
+}
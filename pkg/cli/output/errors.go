@@ -0,0 +1,213 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package output
+
+import (
+	stdCtx "context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/credentials"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/migrate"
+	"github.com/dnote/dnote/pkg/cli/sync"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// The codes of the errors that are not defined along with them. Like the codes
+// of the API and the validation errors, they are stable so that the scripts
+// consuming the JSON output can tell the errors apart.
+const (
+	CodeUnknown             = "unknown"
+	CodeNoteNotFound        = "note_not_found"
+	CodeBookNotFound        = "book_not_found"
+	CodeNotLoggedIn         = "not_logged_in"
+	CodeLocalOnly           = "local_only"
+	CodeNotConfirmed        = "not_confirmed"
+	CodeSyncLocked          = "sync_locked"
+	CodeInvalidLogin        = "invalid_login"
+	CodeContentTypeMismatch = "content_type_mismatch"
+	CodeWrongPassphrase     = "wrong_passphrase"
+	CodeAmbiguousPrefix     = "ambiguous_prefix"
+	CodeNonInteractive      = "non_interactive"
+	CodeSchemaMismatch      = "schema_mismatch"
+	CodeDatabaseBusy        = "database_busy"
+	CodeDatabaseReadOnly    = "database_read_only"
+	CodeCanceled            = "canceled"
+)
+
+// formatJSON is the value of the format flags for the machine readable output
+const formatJSON = "json"
+
+// sentinelCodes are the codes of the sentinel errors
+var sentinelCodes = []struct {
+	err  error
+	code string
+}{
+	{dnote.ErrNoteNotFound, CodeNoteNotFound},
+	{dnote.ErrBookNotFound, CodeBookNotFound},
+	{dnote.ErrNotLoggedIn, CodeNotLoggedIn},
+	{dnote.ErrLocalOnly, CodeLocalOnly},
+	{sync.ErrNotConfirmed, CodeNotConfirmed},
+	{infra.ErrSyncLocked, CodeSyncLocked},
+	{client.ErrInvalidLogin, CodeInvalidLogin},
+	{client.ErrContentTypeMismatch, CodeContentTypeMismatch},
+	{credentials.ErrWrongPassphrase, CodeWrongPassphrase},
+	{validate.ErrBookNameReserved, validate.CodeInvalidBookName},
+	{validate.ErrBookNameNumeric, validate.CodeInvalidBookName},
+	{validate.ErrBookNameHasSpace, validate.CodeInvalidBookName},
+	{validate.ErrBookNameEmpty, validate.CodeInvalidBookName},
+	{validate.ErrBookNameMultiline, validate.CodeInvalidBookName},
+	{validate.ErrNoteBodyInvalidUTF8, validate.CodeInvalidNoteBody},
+	{stdCtx.Canceled, CodeCanceled},
+}
+
+// ErrorCode returns the stable code of the given error, looking through the
+// errors that it wraps
+func ErrorCode(err error) string {
+	for _, s := range sentinelCodes {
+		if errors.Is(err, s.err) {
+			return s.code
+		}
+	}
+
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code()
+	}
+	var prefixErr *database.AmbiguousPrefixError
+	if errors.As(err, &prefixErr) {
+		return CodeAmbiguousPrefix
+	}
+	var interactiveErr ui.NonInteractiveError
+	if errors.As(err, &interactiveErr) {
+		return CodeNonInteractive
+	}
+	var schemaErr *migrate.SchemaError
+	if errors.As(err, &schemaErr) {
+		return CodeSchemaMismatch
+	}
+
+	if database.IsBusyError(err) {
+		return CodeDatabaseBusy
+	}
+	if database.IsReadOnlyError(err) {
+		return CodeDatabaseReadOnly
+	}
+
+	return CodeUnknown
+}
+
+// ErrorDetails returns the fields of the given error that are useful to the
+// scripts, or an empty map if the error has none
+func ErrorDetails(err error) map[string]interface{} {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return map[string]interface{}{"status": apiErr.StatusCode, "body": apiErr.Body}
+	}
+	var prefixErr *database.AmbiguousPrefixError
+	if errors.As(err, &prefixErr) {
+		return map[string]interface{}{"prefix": prefixErr.Prefix, "candidates": prefixErr.Candidates}
+	}
+	var interactiveErr ui.NonInteractiveError
+	if errors.As(err, &interactiveErr) {
+		return map[string]interface{}{"action": interactiveErr.Action, "hint": interactiveErr.Hint}
+	}
+	var schemaErr *migrate.SchemaError
+	if errors.As(err, &schemaErr) {
+		return map[string]interface{}{
+			"current": schemaErr.Current,
+			"latest":  schemaErr.Latest,
+			"missing": schemaErr.Missing,
+			"unknown": schemaErr.Unknown,
+		}
+	}
+
+	return map[string]interface{}{}
+}
+
+// ErrorJSON is the machine readable form of an error
+type ErrorJSON struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details"`
+}
+
+// NewErrorJSON returns the machine readable form of the given error
+func NewErrorJSON(err error) ErrorJSON {
+	return ErrorJSON{
+		Code:    ErrorCode(err),
+		Message: err.Error(),
+		Details: ErrorDetails(err),
+	}
+}
+
+// WriteErrorJSON writes the given error to the writer as a JSON object with
+// the machine readable form of the error under the "error" key
+func WriteErrorJSON(w io.Writer, err error) error {
+	v := struct {
+		Error ErrorJSON `json:"error"`
+	}{Error: NewErrorJSON(err)}
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return errors.Wrap(err, "encoding the error")
+	}
+
+	return nil
+}
+
+// ReportedError is an error that a command has already printed, such as in
+// JSON. The caller only exits with a non-zero status upon it.
+type ReportedError struct {
+	Err error
+}
+
+func (e ReportedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error that was printed
+func (e ReportedError) Unwrap() error {
+	return e.Err
+}
+
+// JSONErrors returns a run function that writes the error of the given one to
+// the standard output as JSON if the format flag is json. The error is then
+// returned as a ReportedError to keep the non-zero exit status.
+func JSONErrors(format *string, run infra.RunEFunc) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		err := run(cmd, args)
+		if err == nil || *format != formatJSON {
+			return err
+		}
+
+		if werr := WriteErrorJSON(os.Stdout, err); werr != nil {
+			return errors.Wrap(werr, "writing the error")
+		}
+
+		return ReportedError{Err: err}
+	}
+}
@@ -0,0 +1,156 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package output
+
+import (
+	"bytes"
+	stdCtx "context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/migrate"
+	"github.com/dnote/dnote/pkg/cli/sync"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/dnote/dnote/pkg/dnote"
+	"github.com/pkg/errors"
+)
+
+func TestErrorCode(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected string
+	}{
+		{
+			err:      dnote.ErrNoteNotFound,
+			expected: CodeNoteNotFound,
+		},
+		{
+			err:      errors.Wrap(dnote.ErrBookNotFound, "finding the book"),
+			expected: CodeBookNotFound,
+		},
+		{
+			err:      sync.ErrNotLoggedIn,
+			expected: CodeNotLoggedIn,
+		},
+		{
+			err:      errors.Wrap(sync.ErrNotConfirmed, "applying the changes"),
+			expected: CodeNotConfirmed,
+		},
+		{
+			err:      errors.Wrap(validate.ErrBookNameNumeric, "validating the book name"),
+			expected: validate.CodeInvalidBookName,
+		},
+		{
+			err:      validate.ErrNoteBodyInvalidUTF8,
+			expected: validate.CodeInvalidNoteBody,
+		},
+		{
+			err:      errors.Wrap(&client.APIError{StatusCode: 401, Body: "unauthorized"}, "server responded with an error"),
+			expected: client.CodeUnauthorized,
+		},
+		{
+			err:      &client.APIError{StatusCode: 404},
+			expected: client.CodeNotFound,
+		},
+		{
+			err:      &client.APIError{StatusCode: 413},
+			expected: client.CodeTooLarge,
+		},
+		{
+			err:      &client.APIError{StatusCode: 429},
+			expected: client.CodeRateLimited,
+		},
+		{
+			err:      &client.APIError{StatusCode: 503},
+			expected: client.CodeServerError,
+		},
+		{
+			err:      &client.APIError{StatusCode: 422},
+			expected: client.CodeBadRequest,
+		},
+		{
+			err:      errors.Wrap(&database.AmbiguousPrefixError{Prefix: "abcd"}, "resolving the note"),
+			expected: CodeAmbiguousPrefix,
+		},
+		{
+			err:      ui.NonInteractiveError{Action: "prompt for the password"},
+			expected: CodeNonInteractive,
+		},
+		{
+			err:      errors.Wrap(&migrate.SchemaError{Current: 20, Latest: 19}, "checking the schema"),
+			expected: CodeSchemaMismatch,
+		},
+		{
+			err:      errors.Wrap(stdCtx.Canceled, "sync was interrupted"),
+			expected: CodeCanceled,
+		},
+		{
+			err:      errors.New("something went wrong"),
+			expected: CodeUnknown,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			assert.Equal(t, ErrorCode(tc.err), tc.expected, "code mismatch")
+		})
+	}
+}
+
+func TestWriteErrorJSON(t *testing.T) {
+	t.Run("with details", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := errors.Wrap(&client.APIError{StatusCode: 500, Body: "internal error"}, "server responded with an error")
+
+		if err := WriteErrorJSON(&buf, err); err != nil {
+			t.Fatal(errors.Wrap(err, "writing the error"))
+		}
+
+		var got struct {
+			Error struct {
+				Code    string                 `json:"code"`
+				Message string                 `json:"message"`
+				Details map[string]interface{} `json:"details"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatal(errors.Wrapf(err, "decoding the output %s", buf.String()))
+		}
+
+		assert.Equal(t, got.Error.Code, client.CodeServerError, "code mismatch")
+		assert.Equal(t, got.Error.Message, `server responded with an error: response 500 "internal error"`, "message mismatch")
+		assert.Equal(t, got.Error.Details["status"], float64(500), "status mismatch")
+		assert.Equal(t, got.Error.Details["body"], "internal error", "body mismatch")
+	})
+
+	t.Run("without details", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		if err := WriteErrorJSON(&buf, dnote.ErrBookNotFound); err != nil {
+			t.Fatal(errors.Wrap(err, "writing the error"))
+		}
+
+		assert.Equal(t, buf.String(), `{"error":{"code":"book_not_found","message":"book not found","details":{}}}`+"\n", "output mismatch")
+	})
+}
@@ -24,32 +24,93 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
 )
 
 // NoteInfo prints a note information
-func NoteInfo(info database.NoteInfo) {
-	log.Infof("book name: %s\n", info.BookLabel)
-	log.Infof("created at: %s\n", time.Unix(0, info.AddedOn).Format("Jan 2, 2006 3:04pm (MST)"))
+func NoteInfo(ctx context.DnoteCtx, info database.NoteInfo) {
+	now := ctx.Clock.Now().Local()
+
+	log.Info(i18n.T("output.book_name", info.BookLabel))
+	log.Info(i18n.T("output.created_at", FormatTime(time.Unix(0, info.AddedOn), now, ctx.TimeFormat)))
 	if info.EditedOn != 0 {
-		log.Infof("updated at: %s\n", time.Unix(0, info.EditedOn).Format("Jan 2, 2006 3:04pm (MST)"))
+		log.Info(i18n.T("output.updated_at", FormatTime(time.Unix(0, info.EditedOn), now, ctx.TimeFormat)))
 	}
-	log.Infof("note id: %d\n", info.RowID)
-	log.Infof("note uuid: %s\n", info.UUID)
+	log.Info(i18n.T("output.note_id", info.RowID))
+	log.Info(i18n.T("output.note_uuid", info.UUID))
 
-	fmt.Printf("\n------------------------content------------------------\n")
+	fmt.Print(i18n.T("output.content_start"))
 	fmt.Printf("%s", info.Content)
 	fmt.Printf("\n-------------------------------------------------------\n")
 }
 
+// NoteStats prints the word, character and line counts of a note, the time it
+// was last edited, and how often it has been viewed on this device
+func NoteStats(ctx context.DnoteCtx, info database.NoteInfo) {
+	now := ctx.Clock.Now().Local()
+
+	edited := info.EditedOn
+	if edited == 0 {
+		edited = info.AddedOn
+	}
+
+	log.Info(i18n.T("output.stats",
+		utils.CountWords(info.Content),
+		utils.CountChars(info.Content),
+		utils.CountLines(info.Content),
+		FormatTime(time.Unix(0, edited), now, ctx.TimeFormat),
+	))
+
+	if info.ViewCount == 0 {
+		log.Info(i18n.T("output.views_never"))
+	} else {
+		log.Info(i18n.T("output.views", info.ViewCount, FormatTime(time.Unix(0, info.LastViewedAt), now, ctx.TimeFormat)))
+	}
+}
+
+// NoteShares prints the share links of a note that have not expired
+func NoteShares(ctx context.DnoteCtx, shares []database.Share) {
+	now := ctx.Clock.Now().Local()
+
+	for _, s := range shares {
+		var expiresAt time.Time
+		if s.ExpiresAt != 0 {
+			expiresAt = time.Unix(0, s.ExpiresAt)
+		}
+
+		log.Info(i18n.T("output.share", s.URL, FormatExpiry(expiresAt, now, ctx.TimeFormat)))
+	}
+}
+
+// NoteDevice prints the device on which a note was added or last edited
+// locally
+func NoteDevice(ctx context.DnoteCtx, info database.NoteInfo) {
+	switch info.OriginDevice {
+	case "":
+		log.Info(i18n.T("output.device_elsewhere"))
+	case ctx.DeviceID:
+		name := ctx.DeviceName
+		if name == "" {
+			name = info.OriginDevice
+		}
+
+		log.Info(i18n.T("output.device_this", name, info.OriginDevice))
+	default:
+		log.Info(i18n.T("output.device", info.OriginDevice))
+	}
+}
+
 func NoteContent(info database.NoteInfo) {
 	fmt.Printf("%s", info.Content)
 }
 
 // BookInfo prints a note information
 func BookInfo(info database.BookInfo) {
-	log.Infof("book name: %s\n", info.Name)
-	log.Infof("book id: %d\n", info.RowID)
-	log.Infof("book uuid: %s\n", info.UUID)
+	log.Info(i18n.T("output.book_name", info.Name))
+	log.Info(i18n.T("output.book_id", info.RowID))
+	log.Info(i18n.T("output.book_uuid", info.UUID))
 }
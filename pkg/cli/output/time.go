@@ -0,0 +1,99 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package output
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/i18n"
+)
+
+// daysBetween returns the number of calendar days from t to now. It compares
+// the dates rather than the durations so that a day shortened or lengthened by
+// a daylight saving time transition still counts as a single day.
+func daysBetween(t, now time.Time) int {
+	y1, m1, d1 := t.Date()
+	y2, m2, d2 := now.Date()
+
+	from := time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC)
+
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// FormatTime returns a human readable representation of the time t, converted to
+// the location of now. If layout is empty, t is rendered relative to now, e.g.
+// "3h ago", "2d ago" or "Mar 4". Otherwise, t is formatted with the layout.
+func FormatTime(t, now time.Time, layout string) string {
+	t = t.In(now.Location())
+
+	if layout != "" {
+		return t.Format(layout)
+	}
+
+	diff := now.Sub(t)
+
+	// times in the future, most likely caused by a clock skew, are treated as now
+	if diff < time.Minute {
+		return i18n.T("time.just_now")
+	}
+	if diff < time.Hour {
+		return i18n.T("time.minutes_ago", int(diff/time.Minute))
+	}
+	if diff < 24*time.Hour {
+		return i18n.T("time.hours_ago", int(diff/time.Hour))
+	}
+
+	days := daysBetween(t, now)
+	if days < 7 {
+		return i18n.T("time.days_ago", days)
+	}
+
+	if t.Year() == now.Year() {
+		return t.Format("Jan 2")
+	}
+
+	return t.Format("Jan 2, 2006")
+}
+
+// FormatExpiry returns a human readable representation of the time t at which
+// something expires, relative to now, e.g. "expires in 3h" or "expired". A zero
+// t is never. If layout is not empty, t is formatted with the layout.
+func FormatExpiry(t, now time.Time, layout string) string {
+	if t.IsZero() {
+		return i18n.T("time.never_expires")
+	}
+	if !t.After(now) {
+		return i18n.T("time.expired")
+	}
+	if layout != "" {
+		return i18n.T("time.expires_at", t.In(now.Location()).Format(layout))
+	}
+
+	left := t.Sub(now)
+	if left < time.Hour {
+		// the last seconds count as a minute rather than as none
+		return i18n.T("time.expires_in_minutes", int((left+time.Minute-1)/time.Minute))
+	}
+	if left < 24*time.Hour {
+		return i18n.T("time.expires_in_hours", int(left/time.Hour))
+	}
+
+	return i18n.T("time.expires_in_days", int(left/(24*time.Hour)))
+}
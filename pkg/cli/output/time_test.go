@@ -0,0 +1,191 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package output
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading location %s: %s", name, err)
+	}
+
+	return loc
+}
+
+func TestFormatTime(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+	now := time.Date(2021, time.March, 15, 12, 0, 0, 0, ny)
+
+	testCases := []struct {
+		t        time.Time
+		now      time.Time
+		layout   string
+		expected string
+	}{
+		{
+			t:        now,
+			now:      now,
+			expected: "just now",
+		},
+		{
+			t:        now.Add(-59 * time.Second),
+			now:      now,
+			expected: "just now",
+		},
+		{
+			// clock skew
+			t:        now.Add(5 * time.Minute),
+			now:      now,
+			expected: "just now",
+		},
+		{
+			t:        now.Add(-3 * time.Minute),
+			now:      now,
+			expected: "3m ago",
+		},
+		{
+			t:        now.Add(-3 * time.Hour),
+			now:      now,
+			expected: "3h ago",
+		},
+		{
+			// spans the spring forward transition on 2021-03-14, a 23 hour day
+			t:        time.Date(2021, time.March, 13, 12, 0, 0, 0, ny),
+			now:      now,
+			expected: "2d ago",
+		},
+		{
+			// spans the fall back transition on 2021-11-07, a 25 hour day
+			t:        time.Date(2021, time.November, 6, 12, 0, 0, 0, ny),
+			now:      time.Date(2021, time.November, 8, 12, 0, 0, 0, ny),
+			expected: "2d ago",
+		},
+		{
+			// 00:30 EDT to 03:30 EST is four hours apart
+			t:        time.Date(2021, time.November, 7, 0, 30, 0, 0, ny),
+			now:      time.Date(2021, time.November, 7, 3, 30, 0, 0, ny),
+			expected: "4h ago",
+		},
+		{
+			t:        time.Date(2021, time.March, 4, 9, 0, 0, 0, ny),
+			now:      now,
+			expected: "Mar 4",
+		},
+		{
+			// converted to the local timezone before computing the date
+			t:        time.Date(2021, time.March, 5, 3, 0, 0, 0, time.UTC),
+			now:      now,
+			expected: "Mar 4",
+		},
+		{
+			t:        time.Date(2020, time.December, 25, 9, 0, 0, 0, ny),
+			now:      now,
+			expected: "Dec 25, 2020",
+		},
+		{
+			t:        time.Date(2021, time.March, 15, 13, 4, 5, 0, time.UTC),
+			now:      now,
+			layout:   time.RFC3339,
+			expected: "2021-03-15T09:04:05-04:00",
+		},
+		{
+			t:        time.Date(2021, time.March, 4, 9, 0, 0, 0, ny),
+			now:      now,
+			layout:   "2006/01/02 15:04",
+			expected: "2021/03/04 09:00",
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			result := FormatTime(tc.t, tc.now, tc.layout)
+
+			assert.Equal(t, result, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestFormatExpiry(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+	now := time.Date(2021, time.March, 15, 12, 0, 0, 0, ny)
+
+	testCases := []struct {
+		t        time.Time
+		layout   string
+		expected string
+	}{
+		{
+			t:        time.Time{},
+			expected: "never expires",
+		},
+		{
+			t:        time.Time{},
+			layout:   time.RFC3339,
+			expected: "never expires",
+		},
+		{
+			t:        now,
+			expected: "expired",
+		},
+		{
+			t:        now.Add(-3 * time.Hour),
+			layout:   time.RFC3339,
+			expected: "expired",
+		},
+		{
+			// the last seconds count as a minute
+			t:        now.Add(10 * time.Second),
+			expected: "expires in 1m",
+		},
+		{
+			t:        now.Add(59 * time.Minute),
+			expected: "expires in 59m",
+		},
+		{
+			t:        now.Add(3*time.Hour + 30*time.Minute),
+			expected: "expires in 3h",
+		},
+		{
+			t:        now.Add(7 * 24 * time.Hour),
+			expected: "expires in 7d",
+		},
+		{
+			// converted to the local timezone
+			t:        time.Date(2021, time.March, 16, 13, 4, 5, 0, time.UTC),
+			layout:   time.RFC3339,
+			expected: "expires 2021-03-16T09:04:05-04:00",
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			result := FormatExpiry(tc.t, now, tc.layout)
+
+			assert.Equal(t, result, tc.expected, "result mismatch")
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package retention decides which notes of a book expire under its retention
+// policy. It only evaluates the policies; the notes are removed by the caller.
+package retention
+
+import (
+	"sort"
+	"time"
+)
+
+// Policy is the retention policy of a book. A zero MaxAge or MaxCount sets no
+// limit.
+type Policy struct {
+	// MaxAge is the age past which a note expires, counted from its last change
+	MaxAge time.Duration
+	// MaxCount is the number of the most recently changed notes to keep
+	MaxCount int
+}
+
+// IsZero returns true if the policy sets no limit
+func (p Policy) IsZero() bool {
+	return p.MaxAge <= 0 && p.MaxCount <= 0
+}
+
+// Note is a note evaluated against a policy. The timestamps are in
+// nanoseconds since the epoch, as in the database, and EditedOn is zero for a
+// note that has never been edited.
+type Note struct {
+	RowID    int
+	UUID     string
+	AddedOn  int64
+	EditedOn int64
+}
+
+// LastChanged returns the time of the last change to the note
+func (n Note) LastChanged() int64 {
+	if n.EditedOn > n.AddedOn {
+		return n.EditedOn
+	}
+
+	return n.AddedOn
+}
+
+// Expired returns the notes that expire under the given policy at the given
+// time, from the least recently changed. A note expires if it was last
+// changed longer than MaxAge ago, or if it is not among the MaxCount most
+// recently changed notes. The notes changed at the same time are ordered by
+// their rowid, so that the later added one is kept. The given slice is not
+// modified.
+func Expired(p Policy, notes []Note, now time.Time) []Note {
+	ret := []Note{}
+	if p.IsZero() {
+		return ret
+	}
+
+	sorted := make([]Note, len(notes))
+	copy(sorted, notes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].LastChanged(), sorted[j].LastChanged()
+		if a != b {
+			return a > b
+		}
+
+		return sorted[i].RowID > sorted[j].RowID
+	})
+
+	var cutoff int64
+	if p.MaxAge > 0 {
+		cutoff = now.Add(-p.MaxAge).UnixNano()
+	}
+
+	for i, n := range sorted {
+		overCount := p.MaxCount > 0 && i >= p.MaxCount
+		tooOld := p.MaxAge > 0 && n.LastChanged() < cutoff
+
+		if overCount || tooOld {
+			ret = append(ret, n)
+		}
+	}
+
+	// from the least recently changed
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+
+	return ret
+}
@@ -0,0 +1,185 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package retention
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func getUUIDs(notes []Note) []string {
+	ret := []string{}
+	for _, n := range notes {
+		ret = append(ret, n.UUID)
+	}
+
+	return ret
+}
+
+func TestPolicyIsZero(t *testing.T) {
+	testCases := []struct {
+		policy   Policy
+		expected bool
+	}{
+		{Policy{}, true},
+		{Policy{MaxAge: time.Hour}, false},
+		{Policy{MaxCount: 1}, false},
+		{Policy{MaxAge: time.Hour, MaxCount: 1}, false},
+		{Policy{MaxAge: -time.Hour, MaxCount: -1}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v", tc.policy), func(t *testing.T) {
+			assert.Equal(t, tc.policy.IsZero(), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestNoteLastChanged(t *testing.T) {
+	assert.Equal(t, Note{AddedOn: 10}.LastChanged(), int64(10), "never edited")
+	assert.Equal(t, Note{AddedOn: 10, EditedOn: 20}.LastChanged(), int64(20), "edited")
+	assert.Equal(t, Note{AddedOn: 10, EditedOn: 5}.LastChanged(), int64(10), "edited before added")
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2020, time.January, 31, 0, 0, 0, 0, time.UTC)
+	day := int64(24 * time.Hour)
+	ago := func(days int64) int64 {
+		return now.UnixNano() - days*day
+	}
+
+	// n1 is the least recently changed and n5 the most, though n2 was added first
+	notes := []Note{
+		{RowID: 1, UUID: "n3", AddedOn: ago(20)},
+		{RowID: 2, UUID: "n2", AddedOn: ago(100), EditedOn: ago(40)},
+		{RowID: 3, UUID: "n5", AddedOn: ago(1)},
+		{RowID: 4, UUID: "n1", AddedOn: ago(60)},
+		{RowID: 5, UUID: "n4", AddedOn: ago(10)},
+	}
+
+	testCases := []struct {
+		name     string
+		policy   Policy
+		notes    []Note
+		expected []string
+	}{
+		{
+			name:     "no limit",
+			policy:   Policy{},
+			notes:    notes,
+			expected: []string{},
+		},
+		{
+			name:     "no notes",
+			policy:   Policy{MaxAge: time.Hour, MaxCount: 1},
+			notes:    []Note{},
+			expected: []string{},
+		},
+		{
+			name:     "max age",
+			policy:   Policy{MaxAge: 30 * 24 * time.Hour},
+			notes:    notes,
+			expected: []string{"n1", "n2"},
+		},
+		{
+			name:     "max age counts from the last edit",
+			policy:   Policy{MaxAge: 50 * 24 * time.Hour},
+			notes:    notes,
+			expected: []string{"n1"},
+		},
+		{
+			name:     "max age keeps a note exactly at the limit",
+			policy:   Policy{MaxAge: 20 * 24 * time.Hour},
+			notes:    notes,
+			expected: []string{"n1", "n2"},
+		},
+		{
+			name:     "max age with every note recent",
+			policy:   Policy{MaxAge: 365 * 24 * time.Hour},
+			notes:    notes,
+			expected: []string{},
+		},
+		{
+			name:     "max count",
+			policy:   Policy{MaxCount: 2},
+			notes:    notes,
+			expected: []string{"n1", "n2", "n3"},
+		},
+		{
+			name:     "max count equal to the number of notes",
+			policy:   Policy{MaxCount: 5},
+			notes:    notes,
+			expected: []string{},
+		},
+		{
+			name:     "max count greater than the number of notes",
+			policy:   Policy{MaxCount: 500},
+			notes:    notes,
+			expected: []string{},
+		},
+		{
+			name:     "both limits with max count stricter",
+			policy:   Policy{MaxAge: 50 * 24 * time.Hour, MaxCount: 2},
+			notes:    notes,
+			expected: []string{"n1", "n2", "n3"},
+		},
+		{
+			name:     "both limits with max age stricter",
+			policy:   Policy{MaxAge: 15 * 24 * time.Hour, MaxCount: 4},
+			notes:    notes,
+			expected: []string{"n1", "n2", "n3"},
+		},
+		{
+			name:   "max count keeps the later added of the notes changed at the same time",
+			policy: Policy{MaxCount: 1},
+			notes: []Note{
+				{RowID: 2, UUID: "a", AddedOn: ago(1)},
+				{RowID: 1, UUID: "b", AddedOn: ago(1)},
+			},
+			expected: []string{"b"},
+		},
+		{
+			name:   "notes in the future are kept",
+			policy: Policy{MaxAge: time.Hour},
+			notes: []Note{
+				{RowID: 1, UUID: "a", AddedOn: now.UnixNano() + day},
+			},
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Expired(tc.policy, tc.notes, now)
+
+			assert.DeepEqual(t, getUUIDs(got), tc.expected, "result mismatch")
+		})
+	}
+
+	t.Run("does not modify the notes", func(t *testing.T) {
+		input := make([]Note, len(notes))
+		copy(input, notes)
+
+		Expired(Policy{MaxCount: 1}, input, now)
+
+		assert.DeepEqual(t, input, notes, "notes mismatch")
+	})
+}
@@ -0,0 +1,123 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// backend synchronizes the local notes and books with a remote copy within the
+// given transaction
+type backend interface {
+	// sync brings the local database and the remote copy up to date with
+	// each other
+	sync(ctx context.DnoteCtx, tx *database.DB, timer *phaseTimer) error
+	// needsSession reports whether the backend requires the user to be
+	// logged in to the server
+	needsSession() bool
+}
+
+// serverBackend syncs with a remote copy through a transport, using the usn
+// of the notes and the books to find the changes
+type serverBackend struct {
+	// full syncs all the data instead of only the changes since the last sync
+	full bool
+	// direction is which of the remote and the local changes are synced
+	direction direction
+	// transport is the transport to the remote copy. If nil, the HTTP
+	// transport to the dnote server is used.
+	transport Transport
+	// limiter caps the changes got from the remote copy
+	limiter *limiter
+}
+
+func (b serverBackend) sync(ctx context.DnoteCtx, tx *database.DB, timer *phaseTimer) error {
+	records, err := getDirtyRecords(ctx, tx)
+	if err != nil {
+		return errors.Wrap(err, "getting the changes for the mirrors")
+	}
+
+	tr := b.transport
+	if tr == nil {
+		tr = NewHTTPTransport(ctx)
+	}
+
+	if err := performSync(ctx, tr, tx, b.full, b.direction, timer, b.limiter); err != nil {
+		return err
+	}
+
+	// the share links are kept only by the server, which a custom transport
+	// does not reach
+	if b.transport == nil && b.direction != directionPush {
+		if err := refreshShares(ctx, tx); err != nil {
+			return errors.Wrap(err, "refreshing the share links")
+		}
+	}
+
+	// the local changes are still dirty and are queued by the next sync
+	if b.direction == directionPull || b.limiter.stopped() {
+		return nil
+	}
+
+	return queueMirrorChanges(ctx, tx, records)
+}
+
+func (b serverBackend) needsSession() bool {
+	return b.transport == nil
+}
+
+// newBackend returns the backend for the options, which is the one set in the
+// config unless a transport is given
+func newBackend(ctx context.DnoteCtx, opts Options) (backend, error) {
+	dir, err := opts.direction()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Initial && len(opts.Books) == 0 {
+		return nil, errors.New("the books to sync must be given for the initial sync")
+	}
+	if len(opts.Books) > 0 && dir == directionPush {
+		return nil, errors.New("a push-only sync cannot change the books to sync")
+	}
+
+	lim := newLimiter(opts.Limits)
+
+	if opts.Transport != nil {
+		return serverBackend{full: opts.Full, direction: dir, transport: opts.Transport, limiter: lim}, nil
+	}
+	if ctx.SyncBackend == consts.SyncBackendGit {
+		if dir != directionBoth {
+			return nil, errors.New("the git backend cannot sync in only one direction")
+		}
+		if opts.Limits != (Limits{}) {
+			return nil, errors.New("the git backend does not support the download and operation caps")
+		}
+		if len(opts.Books) > 0 {
+			return nil, errors.New("the git backend cannot sync only some of the books")
+		}
+
+		return gitBackend{dir: ctx.GitDir, full: opts.Full}, nil
+	}
+
+	return serverBackend{full: opts.Full, direction: dir, limiter: lim}, nil
+}
@@ -0,0 +1,287 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+// benchmarkFullSyncNotes applies a full sync list of new notes with the given
+// function, starting every iteration from a database without notes
+func benchmarkFullSyncNotes(b *testing.B, apply func(ctx context.DnoteCtx, tx *database.DB, notes map[string]client.SyncFragNote) error) {
+	db := database.InitTestDB(b, filepath.Join(b.TempDir(), "bench.db"), &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(b, db)
+
+	ctx := context.DnoteCtx{DB: db, Logger: log.NewLogger(ioutil.Discard, ioutil.Discard)}
+
+	for i := 0; i < 20; i++ {
+		database.MustExec(b, "inserting a book", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", fmt.Sprintf("b%d-uuid", i), fmt.Sprintf("b%d-label", i), i+1)
+	}
+
+	notes := map[string]client.SyncFragNote{}
+	for i := 0; i < 10000; i++ {
+		uuid := fmt.Sprintf("n%d-uuid", i)
+		notes[uuid] = client.SyncFragNote{
+			UUID:     uuid,
+			BookUUID: fmt.Sprintf("b%d-uuid", i%20),
+			USN:      100 + i,
+			AddedOn:  1541108743,
+			Body:     fmt.Sprintf("note %d body", i),
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		database.MustExec(b, "deleting notes", db, "DELETE FROM notes")
+		// copy the notes because they are removed from the map as they are applied
+		iterNotes := make(map[string]client.SyncFragNote, len(notes))
+		for uuid, n := range notes {
+			iterNotes[uuid] = n
+		}
+		b.StartTimer()
+
+		tx, err := db.Begin()
+		if err != nil {
+			b.Fatal(errors.Wrap(err, "beginning a transaction").Error())
+		}
+		if err := apply(ctx, tx, iterNotes); err != nil {
+			tx.Rollback()
+			b.Fatal(errors.Wrap(err, "applying notes").Error())
+		}
+		if err := tx.Commit(); err != nil {
+			b.Fatal(errors.Wrap(err, "committing").Error())
+		}
+	}
+}
+
+func BenchmarkFullSyncNotes(b *testing.B) {
+	b.Run("per-record", func(b *testing.B) {
+		benchmarkFullSyncNotes(b, func(ctx context.DnoteCtx, tx *database.DB, notes map[string]client.SyncFragNote) error {
+			stmts := mustPrepareStmts(b, tx)
+			for _, n := range notes {
+				if err := fullSyncNote(ctx, tx, stmts, n); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+
+	b.Run("bulk", func(b *testing.B) {
+		benchmarkFullSyncNotes(b, func(ctx context.DnoteCtx, tx *database.DB, notes map[string]client.SyncFragNote) error {
+			return fullSyncNotes(ctx, tx, mustPrepareStmts(b, tx), notes)
+		})
+	})
+}
+
+// BenchmarkStepSyncNotes applies 10,000 note fragments in a step sync, half of
+// which update existing notes and half of which are new
+func BenchmarkStepSyncNotes(b *testing.B) {
+	db := database.InitTestDB(b, filepath.Join(b.TempDir(), "bench.db"), &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(b, db)
+
+	for i := 0; i < 20; i++ {
+		database.MustExec(b, "inserting a book", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", fmt.Sprintf("b%d-uuid", i), fmt.Sprintf("b%d-label", i), i+1)
+	}
+
+	var existing []database.Note
+	var frags []client.SyncFragNote
+	for i := 0; i < 10000; i++ {
+		uuid := fmt.Sprintf("n%d-uuid", i)
+		bookUUID := fmt.Sprintf("b%d-uuid", i%20)
+
+		if i%2 == 0 {
+			existing = append(existing, database.NewNote(uuid, bookUUID, "old body", 1541108743, 0, i+1, false, false, false))
+		}
+
+		frags = append(frags, client.SyncFragNote{
+			UUID:     uuid,
+			BookUUID: bookUUID,
+			USN:      20000 + i,
+			AddedOn:  1541108743,
+			EditedOn: 1541108744,
+			Body:     fmt.Sprintf("note %d body", i),
+		})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		database.MustExec(b, "deleting notes", db, "DELETE FROM notes")
+		if err := database.InsertNotes(db, existing); err != nil {
+			b.Fatal(errors.Wrap(err, "inserting existing notes").Error())
+		}
+		b.StartTimer()
+
+		tx, err := db.Begin()
+		if err != nil {
+			b.Fatal(errors.Wrap(err, "beginning a transaction").Error())
+		}
+		stmts := mustPrepareStmts(b, tx)
+		for _, n := range frags {
+			if err := stepSyncNote(tx, stmts, n); err != nil {
+				tx.Rollback()
+				b.Fatal(errors.Wrap(err, "applying a note").Error())
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			b.Fatal(errors.Wrap(err, "committing").Error())
+		}
+	}
+}
+
+// BenchmarkCleanLocalNotes cleans 100,000 local notes against a full sync list
+// that contains all but 1,000 of them
+func BenchmarkCleanLocalNotes(b *testing.B) {
+	db := database.InitTestDB(b, filepath.Join(b.TempDir(), "bench.db"), &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(b, db)
+
+	ctx := context.DnoteCtx{DB: db, Clock: clock.NewMock(), Logger: log.NewLogger(ioutil.Discard, ioutil.Discard)}
+
+	database.MustExec(b, "inserting a book", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "b1-uuid", "b1-label", 1)
+
+	list := syncList{
+		Notes:         map[string]client.SyncFragNote{},
+		Books:         map[string]client.SyncFragBook{},
+		ExpungedNotes: map[string]struct{}{},
+		ExpungedBooks: map[string]struct{}{},
+	}
+	var notes []database.Note
+	for i := 0; i < 100000; i++ {
+		uuid := fmt.Sprintf("n%d-uuid", i)
+		notes = append(notes, database.NewNote(uuid, "b1-uuid", "body", 1541108743, 0, i+1, false, false, false))
+
+		if i%100 != 0 {
+			list.Notes[uuid] = client.SyncFragNote{UUID: uuid}
+		}
+	}
+	if err := database.InsertNotes(db, notes); err != nil {
+		b.Fatal(errors.Wrap(err, "inserting notes").Error())
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			b.Fatal(errors.Wrap(err, "beginning a transaction").Error())
+		}
+		if err := cleanLocalNotes(ctx, tx, &list); err != nil {
+			tx.Rollback()
+			b.Fatal(errors.Wrap(err, "cleaning notes").Error())
+		}
+		tx.Rollback()
+	}
+}
+
+// makeNoteFragments returns sync fragments of 100 new notes each, whose bodies
+// are of the given length
+func makeNoteFragments(fragmentCount, bodyLength int) []Changes {
+	var ret []Changes
+	for i := 0; i < fragmentCount; i++ {
+		frag := Changes{Next: Cursor(strconv.Itoa((i + 1) * 100)), More: true, ExpungedNotes: []string{fmt.Sprintf("expunged-%d-uuid", i)}}
+		for j := 0; j < 100; j++ {
+			usn := i*100 + j + 1
+			frag.Notes = append(frag.Notes, client.SyncFragNote{
+				UUID:     fmt.Sprintf("n%d-uuid", usn),
+				BookUUID: "b1-uuid",
+				USN:      usn,
+				AddedOn:  1541108743,
+				Body:     strings.Repeat("a", bodyLength),
+			})
+		}
+
+		ret = append(ret, frag)
+	}
+
+	return ret
+}
+
+// BenchmarkFullSyncMemory applies a full sync list of 100,000 notes with 1KB
+// bodies, and reports the heap still in use once the notes are applied and only
+// the expunged records remain to be synced
+func BenchmarkFullSyncMemory(b *testing.B) {
+	db := database.InitTestDB(b, filepath.Join(b.TempDir(), "bench.db"), &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	defer database.TeardownTestDB(b, db)
+
+	ctx := context.DnoteCtx{DB: db, Logger: log.NewLogger(ioutil.Discard, ioutil.Discard)}
+
+	database.MustExec(b, "inserting a book", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "b1-uuid", "b1-label", 1)
+
+	var retained int64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		database.MustExec(b, "deleting notes", db, "DELETE FROM notes")
+		var before runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		fragments := makeNoteFragments(1000, 1000)
+		b.StartTimer()
+
+		list, err := processFragments(fragments)
+		if err != nil {
+			b.Fatal(errors.Wrap(err, "processing fragments").Error())
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			b.Fatal(errors.Wrap(err, "beginning a transaction").Error())
+		}
+		stmts := mustPrepareStmts(b, tx)
+		if err := fullSyncNotes(ctx, tx, stmts, list.Notes); err != nil {
+			tx.Rollback()
+			b.Fatal(errors.Wrap(err, "applying notes").Error())
+		}
+		list.Notes = nil
+
+		b.StopTimer()
+		var after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		retained += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+		b.StartTimer()
+
+		for uuid := range list.ExpungedNotes {
+			if err := syncDeleteNote(ctx, tx, stmts, uuid, list.Books); err != nil {
+				tx.Rollback()
+				b.Fatal(errors.Wrap(err, "deleting a note").Error())
+			}
+		}
+
+		stmts.Close()
+		tx.Rollback()
+	}
+
+	b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+}
@@ -0,0 +1,794 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+var (
+	convergenceSeed = flag.Int64("sync.seed", 1, "first seed of TestSyncConvergence")
+	convergenceRuns = flag.Int("sync.runs", 30, "number of seeds TestSyncConvergence runs with")
+)
+
+// simLabels is deliberately small so that the random operations often
+// collide on book labels
+var simLabels = []string{"js", "go", "css", "sql", "linux"}
+
+type simBook struct {
+	uuid    string
+	label   string
+	usn     int
+	deleted bool
+}
+
+type simNote struct {
+	uuid     string
+	bookUUID string
+	body     string
+	addedOn  int64
+	editedOn int64
+	usn      int
+	public   bool
+	deleted  bool
+}
+
+// simServer is an in-memory model of the sync API of the server. It mirrors
+// how the server assigns USNs, builds the sync fragments and marks deleted
+// resources, so that the sync code can be exercised against a server whose
+// state can also be changed by another simulated client.
+type simServer struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu             sync.Mutex
+	maxUSN         int
+	now            int64
+	fullSyncBefore int
+	pageSize       int
+	seq            int
+	books          map[string]*simBook
+	notes          map[string]*simNote
+	// idempotent makes the server create a note only once for an
+	// idempotency key, and keys maps the keys it got to the notes
+	idempotent bool
+	keys       map[string]string
+}
+
+func newSimServer(t *testing.T, pageSize int) *simServer {
+	s := &simServer{
+		t:        t,
+		now:      1541108743,
+		pageSize: pageSize,
+		books:    map[string]*simBook{},
+		notes:    map[string]*simNote{},
+		keys:     map[string]string{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+func (s *simServer) close() {
+	s.server.Close()
+}
+
+func (s *simServer) nextUSN() int {
+	s.maxUSN++
+	return s.maxUSN
+}
+
+func (s *simServer) nextUUID() string {
+	s.seq++
+	return fmt.Sprintf("5e5e5e5e-0000-4000-8000-%012d", s.seq)
+}
+
+func (s *simServer) labelTaken(label string) bool {
+	for _, b := range s.books {
+		if b.label == label {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *simServer) createBook(label string) *simBook {
+	b := &simBook{uuid: s.nextUUID(), label: label, usn: s.nextUSN()}
+	s.books[b.uuid] = b
+
+	return b
+}
+
+func (s *simServer) updateBook(b *simBook, label string) {
+	b.label = label
+	b.usn = s.nextUSN()
+	b.deleted = false
+}
+
+func (s *simServer) deleteBook(b *simBook) {
+	for _, n := range s.sortedNotes() {
+		if n.bookUUID == b.uuid && !n.deleted {
+			s.deleteNote(n)
+		}
+	}
+
+	b.label = ""
+	b.usn = s.nextUSN()
+	b.deleted = true
+}
+
+func (s *simServer) createNote(bookUUID, body string) *simNote {
+	n := &simNote{uuid: s.nextUUID(), bookUUID: bookUUID, body: body, addedOn: s.now, usn: s.nextUSN()}
+	s.notes[n.uuid] = n
+
+	return n
+}
+
+func (s *simServer) updateNote(n *simNote, bookUUID, body string) {
+	n.bookUUID = bookUUID
+	n.body = body
+	n.editedOn = s.now
+	n.usn = s.nextUSN()
+	n.deleted = false
+}
+
+func (s *simServer) deleteNote(n *simNote) {
+	n.body = ""
+	n.usn = s.nextUSN()
+	n.deleted = true
+}
+
+// sortedBooks returns the books ordered by uuid so that a seed always
+// produces the same operations
+func (s *simServer) sortedBooks() []*simBook {
+	ret := []*simBook{}
+	for _, b := range s.books {
+		ret = append(ret, b)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].uuid < ret[j].uuid })
+
+	return ret
+}
+
+// sortedNotes returns the notes ordered by uuid
+func (s *simServer) sortedNotes() []*simNote {
+	ret := []*simNote{}
+	for _, n := range s.notes {
+		ret = append(ret, n)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].uuid < ret[j].uuid })
+
+	return ret
+}
+
+// fragment returns the resources changed after the given usn, at most
+// pageSize of them, in the same way as the server
+func (s *simServer) fragment(afterUSN int) client.SyncFragment {
+	type item struct {
+		usn  int
+		book *simBook
+		note *simNote
+	}
+
+	var items []item
+	for _, b := range s.books {
+		if b.usn > afterUSN {
+			items = append(items, item{usn: b.usn, book: b})
+		}
+	}
+	for _, n := range s.notes {
+		if n.usn > afterUSN {
+			items = append(items, item{usn: n.usn, note: n})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].usn < items[j].usn })
+
+	ret := client.SyncFragment{
+		UserMaxUSN:    s.maxUSN,
+		CurrentTime:   s.now,
+		Notes:         []client.SyncFragNote{},
+		Books:         []client.SyncFragBook{},
+		ExpungedNotes: []string{},
+		ExpungedBooks: []string{},
+	}
+	for i := 0; i < len(items) && i < s.pageSize; i++ {
+		it := items[i]
+		ret.FragMaxUSN = it.usn
+
+		if b := it.book; b != nil {
+			if b.deleted {
+				ret.ExpungedBooks = append(ret.ExpungedBooks, b.uuid)
+			} else {
+				ret.Books = append(ret.Books, client.SyncFragBook{UUID: b.uuid, USN: b.usn, Label: b.label})
+			}
+		} else if n := it.note; n != nil {
+			if n.deleted {
+				ret.ExpungedNotes = append(ret.ExpungedNotes, n.uuid)
+			} else {
+				ret.Notes = append(ret.Notes, client.SyncFragNote{
+					UUID:     n.uuid,
+					BookUUID: n.bookUUID,
+					USN:      n.usn,
+					AddedOn:  n.addedOn,
+					EditedOn: n.editedOn,
+					Body:     n.body,
+					Public:   n.public,
+				})
+			}
+		}
+	}
+
+	return ret
+}
+
+func (s *simServer) respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.t.Errorf("encoding the simulated response: %s", err)
+	}
+}
+
+func (s *simServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now++
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.t.Errorf("reading the request body: %s", err)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v3/"), "/")
+	resource := parts[0]
+	var uuid string
+	if len(parts) > 1 {
+		uuid = parts[1]
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v3/sync/state":
+		s.respond(w, http.StatusOK, client.GetSyncStateResp{FullSyncBefore: s.fullSyncBefore, MaxUSN: s.maxUSN, CurrentTime: s.now})
+	case r.Method == "GET" && r.URL.Path == "/v3/limits":
+		// like a self-hosted server, the simulated server does not limit the account
+		http.Error(w, "not found", http.StatusNotFound)
+	case r.Method == "GET" && r.URL.Path == "/v3/sync/fragment":
+		afterUSN, err := strconv.Atoi(r.URL.Query().Get("after_usn"))
+		if err != nil {
+			http.Error(w, "invalid after_usn", http.StatusBadRequest)
+			return
+		}
+
+		s.respond(w, http.StatusOK, client.GetSyncFragmentResp{Fragment: s.fragment(afterUSN)})
+	case r.Method == "GET" && resource == "books" && uuid != "":
+		b, ok := s.books[uuid]
+		if !ok || b.deleted {
+			http.Error(w, "book not found", http.StatusNotFound)
+			return
+		}
+
+		s.respond(w, http.StatusOK, client.RespBook{UUID: b.uuid, USN: b.usn, Label: b.label})
+	case r.Method == "POST" && resource == "books":
+		var p client.CreateBookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		if s.labelTaken(p.Name) {
+			http.Error(w, "duplicate book exists", http.StatusConflict)
+			return
+		}
+
+		b := s.createBook(p.Name)
+		s.respond(w, http.StatusCreated, client.CreateBookResp{Book: client.RespBook{UUID: b.uuid, USN: b.usn, Label: b.label}})
+	case r.Method == "PATCH" && resource == "books":
+		b, ok := s.books[uuid]
+		if !ok {
+			http.Error(w, "book not found", http.StatusNotFound)
+			return
+		}
+
+		var p struct {
+			Name *string `json:"name"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		label := b.label
+		if p.Name != nil {
+			label = *p.Name
+		}
+
+		s.updateBook(b, label)
+		s.respond(w, http.StatusOK, client.UpdateBookResp{Book: client.RespBook{UUID: b.uuid, USN: b.usn, Label: b.label}})
+	case r.Method == "DELETE" && resource == "books":
+		b, ok := s.books[uuid]
+		if !ok {
+			http.Error(w, "book not found", http.StatusNotFound)
+			return
+		}
+
+		s.deleteBook(b)
+		s.respond(w, http.StatusOK, client.DeleteBookResp{Book: client.RespBook{UUID: b.uuid, USN: b.usn, Label: b.label}})
+	case r.Method == "POST" && resource == "notes":
+		var p client.CreateNotePayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.books[p.BookUUID]; !ok {
+			http.Error(w, "book not found", http.StatusNotFound)
+			return
+		}
+
+		key := r.Header.Get(client.IdempotencyKeyHeader)
+		if uuid, ok := s.keys[key]; ok && s.idempotent {
+			n := s.notes[uuid]
+			s.respond(w, http.StatusCreated, client.CreateNoteResp{Result: client.RespNote{UUID: n.uuid, USN: n.usn, Body: n.body, AddedOn: n.addedOn}})
+			return
+		}
+
+		n := s.createNote(p.BookUUID, p.Body)
+		if key != "" {
+			s.keys[key] = n.uuid
+		}
+		s.respond(w, http.StatusCreated, client.CreateNoteResp{Result: client.RespNote{UUID: n.uuid, USN: n.usn, Body: n.body, AddedOn: n.addedOn}})
+	case r.Method == "PATCH" && resource == "notes":
+		n, ok := s.notes[uuid]
+		if !ok {
+			http.Error(w, "note not found", http.StatusNotFound)
+			return
+		}
+
+		var p struct {
+			BookUUID *string `json:"book_uuid"`
+			Body     *string `json:"content"`
+			Public   *bool   `json:"public"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		bookUUID, content := n.bookUUID, n.body
+		if p.BookUUID != nil {
+			bookUUID = *p.BookUUID
+		}
+		if p.Body != nil {
+			content = *p.Body
+		}
+		if _, ok := s.books[bookUUID]; !ok {
+			http.Error(w, "book not found", http.StatusNotFound)
+			return
+		}
+		if p.Public != nil {
+			n.public = *p.Public
+		}
+
+		s.updateNote(n, bookUUID, content)
+		s.respond(w, http.StatusOK, client.UpdateNoteResp{Result: client.RespNote{UUID: n.uuid, USN: n.usn, Body: n.body}})
+	case r.Method == "DELETE" && resource == "notes":
+		n, ok := s.notes[uuid]
+		if !ok {
+			http.Error(w, "note not found", http.StatusNotFound)
+			return
+		}
+
+		s.deleteNote(n)
+		s.respond(w, http.StatusOK, client.DeleteNoteResp{Result: client.RespNote{UUID: n.uuid, USN: n.usn}})
+	default:
+		s.t.Errorf("unexpected request to the simulated server: %s %s", r.Method, r.URL.Path)
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// convergence runs random operations on a local database and a simulated
+// server for a single seed
+type convergence struct {
+	t    *testing.T
+	seed int64
+	rnd  *rand.Rand
+	ctx  context.DnoteCtx
+	srv  *simServer
+	seq  int
+	// ops is the history of the operations, printed when an invariant fails
+	ops []string
+}
+
+func (c *convergence) logOp(format string, v ...interface{}) {
+	c.ops = append(c.ops, fmt.Sprintf(format, v...))
+}
+
+func (c *convergence) fail(format string, v ...interface{}) {
+	c.t.Fatalf("%s\n\noperations:\n  %s\n\nreproduce with: go test ./cmd/sync -run TestSyncConvergence -sync.seed=%d -sync.runs=1",
+		fmt.Sprintf(format, v...), strings.Join(c.ops, "\n  "), c.seed)
+}
+
+func (c *convergence) nextBody() string {
+	c.seq++
+	return fmt.Sprintf("body %d", c.seq)
+}
+
+func (c *convergence) nextLocalUUID() string {
+	c.seq++
+	return fmt.Sprintf("10ca1000-0000-4000-8000-%012d", c.seq)
+}
+
+func (c *convergence) pickLabel() string {
+	return simLabels[c.rnd.Intn(len(simLabels))]
+}
+
+// localBooks returns the uuids of the local books that are not deleted
+func (c *convergence) localBooks() []string {
+	return c.queryStrings("SELECT uuid FROM books WHERE NOT deleted ORDER BY uuid")
+}
+
+// localNotes returns the uuids of the local notes that are not deleted
+func (c *convergence) localNotes() []string {
+	return c.queryStrings("SELECT uuid FROM notes WHERE NOT deleted ORDER BY uuid")
+}
+
+func (c *convergence) queryStrings(query string, args ...interface{}) []string {
+	rows, err := c.ctx.DB.Query(query, args...)
+	if err != nil {
+		c.t.Fatal(errors.Wrap(err, "querying").Error())
+	}
+	defer rows.Close()
+
+	ret := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			c.t.Fatal(errors.Wrap(err, "scanning").Error())
+		}
+		ret = append(ret, s)
+	}
+
+	return ret
+}
+
+func (c *convergence) exec(message, query string, args ...interface{}) {
+	database.MustExec(c.t, message, c.ctx.DB, query, args...)
+}
+
+// localOp performs a random mutation in the same way as the commands do
+func (c *convergence) localOp() {
+	db := c.ctx.DB
+	books := c.localBooks()
+	notes := c.localNotes()
+
+	switch op := c.rnd.Intn(6); {
+	case op == 0 || len(notes) == 0:
+		label := c.pickLabel()
+		body := c.nextBody()
+
+		var bookUUID string
+		uuids := c.queryStrings("SELECT uuid FROM books WHERE label = ?", label)
+		if len(uuids) == 0 {
+			bookUUID = c.nextLocalUUID()
+			b := database.NewBook(bookUUID, label, 0, false, true)
+			if err := b.Insert(db); err != nil {
+				c.t.Fatal(errors.Wrap(err, "inserting a local book").Error())
+			}
+		} else {
+			bookUUID = uuids[0]
+		}
+
+		n := database.NewNote(c.nextLocalUUID(), bookUUID, body, c.ctx.Clock.Now().UnixNano(), 0, 0, false, false, true)
+		if err := n.Insert(db); err != nil {
+			c.t.Fatal(errors.Wrap(err, "inserting a local note").Error())
+		}
+		c.logOp("local: add %s to %s", n.UUID, label)
+	case op == 1:
+		uuid := notes[c.rnd.Intn(len(notes))]
+		body := c.nextBody()
+		c.exec("editing a local note", "UPDATE notes SET body = ?, edited_on = ?, dirty = ? WHERE uuid = ?", body, c.ctx.Clock.Now().UnixNano(), true, uuid)
+		c.logOp("local: edit %s", uuid)
+	case op == 2:
+		uuid := notes[c.rnd.Intn(len(notes))]
+		bookUUID := books[c.rnd.Intn(len(books))]
+		c.exec("moving a local note", "UPDATE notes SET book_uuid = ?, edited_on = ?, dirty = ? WHERE uuid = ?", bookUUID, c.ctx.Clock.Now().UnixNano(), true, uuid)
+		c.logOp("local: move %s to %s", uuid, bookUUID)
+	case op == 3:
+		uuid := notes[c.rnd.Intn(len(notes))]
+		c.exec("removing a local note", "UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE uuid = ?", true, true, "", uuid)
+		c.logOp("local: remove note %s", uuid)
+	case op == 4:
+		uuid := books[c.rnd.Intn(len(books))]
+		label := c.pickLabel()
+		if len(c.queryStrings("SELECT uuid FROM books WHERE label = ?", label)) > 0 {
+			return
+		}
+		if err := database.UpdateBookName(db, uuid, label); err != nil {
+			c.t.Fatal(errors.Wrap(err, "renaming a local book").Error())
+		}
+		c.logOp("local: rename %s to %s", uuid, label)
+	case op == 5:
+		uuid := books[c.rnd.Intn(len(books))]
+		c.exec("removing notes of a local book", "UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE book_uuid = ?", true, true, "", uuid)
+		c.exec("removing a local book", "UPDATE books SET deleted = ?, dirty = ?, label = ? WHERE uuid = ?", true, true, c.nextLocalUUID(), uuid)
+		c.logOp("local: remove book %s", uuid)
+	}
+}
+
+// remoteOp performs a random mutation on the server as another client would
+func (c *convergence) remoteOp() {
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var books []*simBook
+	for _, b := range s.sortedBooks() {
+		if !b.deleted {
+			books = append(books, b)
+		}
+	}
+	var notes []*simNote
+	for _, n := range s.sortedNotes() {
+		if !n.deleted {
+			notes = append(notes, n)
+		}
+	}
+
+	switch op := c.rnd.Intn(7); {
+	case op == 0 || len(books) == 0:
+		label := c.pickLabel()
+		if s.labelTaken(label) {
+			return
+		}
+		b := s.createBook(label)
+		c.logOp("remote: create book %s %s", b.uuid, label)
+	case op == 1 || len(notes) == 0:
+		b := books[c.rnd.Intn(len(books))]
+		n := s.createNote(b.uuid, c.nextBody())
+		c.logOp("remote: add %s to %s", n.uuid, b.uuid)
+	case op == 2:
+		n := notes[c.rnd.Intn(len(notes))]
+		s.updateNote(n, n.bookUUID, c.nextBody())
+		c.logOp("remote: edit %s", n.uuid)
+	case op == 3:
+		n := notes[c.rnd.Intn(len(notes))]
+		b := books[c.rnd.Intn(len(books))]
+		s.updateNote(n, b.uuid, n.body)
+		c.logOp("remote: move %s to %s", n.uuid, b.uuid)
+	case op == 4:
+		n := notes[c.rnd.Intn(len(notes))]
+		s.deleteNote(n)
+		c.logOp("remote: remove note %s", n.uuid)
+	case op == 5:
+		b := books[c.rnd.Intn(len(books))]
+		label := c.pickLabel()
+		if s.labelTaken(label) {
+			return
+		}
+		s.updateBook(b, label)
+		c.logOp("remote: rename %s to %s", b.uuid, label)
+	case op == 6:
+		b := books[c.rnd.Intn(len(books))]
+		s.deleteBook(b)
+		c.logOp("remote: remove book %s", b.uuid)
+	}
+}
+
+func (c *convergence) sync() {
+	c.logOp("sync")
+
+	if err := infra.WithTx(c.ctx.DB, func(tx *database.DB) error {
+		return performSync(c.ctx, NewHTTPTransport(c.ctx), tx, false, directionBoth, newPhaseTimer(time.Now), nil)
+	}); err != nil {
+		c.fail("performing sync: %s", err)
+	}
+}
+
+// checkInvariants asserts the properties that must hold after every sync
+func (c *convergence) checkInvariants() {
+	db := c.ctx.DB
+
+	if dups := c.queryStrings("SELECT label FROM books GROUP BY label HAVING count(*) > 1"); len(dups) > 0 {
+		c.fail("duplicate book labels: %v", dups)
+	}
+
+	if dangling := c.queryStrings("SELECT uuid FROM notes WHERE book_uuid NOT IN (SELECT uuid FROM books)"); len(dangling) > 0 {
+		c.fail("notes with dangling book_uuid: %v", dangling)
+	}
+
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+
+	// a dirty resource must be one that the next sync can upload: if it has
+	// been synced the server must know it, and if not, it must be new
+	for _, table := range []string{"notes", "books"} {
+		rows, err := db.Query(fmt.Sprintf("SELECT uuid, usn FROM %s WHERE dirty", table))
+		if err != nil {
+			c.t.Fatal(errors.Wrapf(err, "querying dirty %s", table).Error())
+		}
+
+		for rows.Next() {
+			var uuid string
+			var usn int
+			if err := rows.Scan(&uuid, &usn); err != nil {
+				rows.Close()
+				c.t.Fatal(errors.Wrap(err, "scanning").Error())
+			}
+
+			var known bool
+			if table == "notes" {
+				_, known = c.srv.notes[uuid]
+			} else {
+				_, known = c.srv.books[uuid]
+			}
+
+			if usn > 0 && !known {
+				rows.Close()
+				c.fail("dirty %s %s has usn %d but is unknown to the server", table, uuid, usn)
+			}
+			if usn == 0 && known {
+				rows.Close()
+				c.fail("dirty %s %s has no usn but exists on the server", table, uuid)
+			}
+		}
+		rows.Close()
+	}
+}
+
+// quiesce syncs until there is nothing left to send or receive
+func (c *convergence) quiesce() {
+	for i := 0; i < 5; i++ {
+		c.sync()
+		c.checkInvariants()
+
+		var dirty, lastMaxUSN int
+		database.MustScan(c.t, "counting dirty resources", c.ctx.DB.QueryRow("SELECT (SELECT count(*) FROM notes WHERE dirty) + (SELECT count(*) FROM books WHERE dirty)"), &dirty)
+		database.MustScan(c.t, "getting last max usn", c.ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+
+		c.srv.mu.Lock()
+		maxUSN := c.srv.maxUSN
+		c.srv.mu.Unlock()
+
+		if dirty == 0 && lastMaxUSN == maxUSN {
+			return
+		}
+	}
+
+	c.fail("the client did not settle after repeated syncs")
+}
+
+// checkConverged asserts that the client and the server hold the same books and notes
+func (c *convergence) checkConverged() {
+	local := map[string]string{}
+	for _, row := range c.queryStrings("SELECT 'book ' || uuid || ' ' || label FROM books") {
+		local[row] = row
+	}
+	for _, row := range c.queryStrings("SELECT 'note ' || notes.uuid || ' ' || books.label || ' ' || notes.body FROM notes INNER JOIN books ON books.uuid = notes.book_uuid") {
+		local[row] = row
+	}
+
+	remote := map[string]string{}
+	c.srv.mu.Lock()
+	for _, b := range c.srv.books {
+		if !b.deleted {
+			row := fmt.Sprintf("book %s %s", b.uuid, b.label)
+			remote[row] = row
+		}
+	}
+	for _, n := range c.srv.notes {
+		if !n.deleted {
+			row := fmt.Sprintf("note %s %s %s", n.uuid, c.srv.books[n.bookUUID].label, n.body)
+			remote[row] = row
+		}
+	}
+	c.srv.mu.Unlock()
+
+	var diff []string
+	for row := range local {
+		if _, ok := remote[row]; !ok {
+			diff = append(diff, "local only:  "+row)
+		}
+	}
+	for row := range remote {
+		if _, ok := local[row]; !ok {
+			diff = append(diff, "server only: "+row)
+		}
+	}
+	if len(diff) > 0 {
+		sort.Strings(diff)
+		c.fail("client and server diverged:\n  %s", strings.Join(diff, "\n  "))
+	}
+}
+
+func runConvergence(t *testing.T, seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Cache: dir, Config: dir, Data: dir}, nil)
+	defer database.TeardownTestDB(t, ctx.DB)
+	ctx.SessionKey = "someSessionKey"
+	ctx.Logger = log.NewLogger(ioutil.Discard, ioutil.Discard)
+
+	database.MustExec(t, "inserting last max usn", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+
+	// a small page size makes the client fetch several fragments
+	srv := newSimServer(t, 1+rnd.Intn(5))
+	defer srv.close()
+	ctx.APIEndpoint = srv.server.URL
+
+	c := &convergence{t: t, seed: seed, rnd: rnd, ctx: ctx, srv: srv}
+
+	for batch := 0; batch < 8; batch++ {
+		for i := rnd.Intn(5); i > 0; i-- {
+			c.localOp()
+		}
+		for i := rnd.Intn(5); i > 0; i-- {
+			c.remoteOp()
+		}
+
+		// occasionally make the server require a full sync
+		if rnd.Intn(5) == 0 {
+			srv.mu.Lock()
+			srv.fullSyncBefore = int(srv.now) + 1
+			srv.mu.Unlock()
+			c.logOp("remote: require full sync")
+		}
+
+		c.sync()
+		c.checkInvariants()
+	}
+
+	c.quiesce()
+	c.checkConverged()
+}
+
+// TestSyncConvergence runs random interleavings of local and remote changes
+// through the sync and asserts that the client and the server converge. Use
+// -sync.seed and -sync.runs to explore more seeds or to reproduce a failure.
+func TestSyncConvergence(t *testing.T) {
+	for i := 0; i < *convergenceRuns; i++ {
+		seed := *convergenceSeed + int64(i)
+
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			runConvergence(t, seed)
+		})
+	}
+}
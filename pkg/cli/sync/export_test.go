@@ -0,0 +1,36 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+)
+
+// NewSimTransport returns an HTTP transport to a new simulated server
+func NewSimTransport(t *testing.T) Transport {
+	s := newSimServer(t, 10)
+	t.Cleanup(s.close)
+
+	return NewHTTPTransport(context.DnoteCtx{
+		APIEndpoint: s.server.URL,
+		SessionKey:  "test-session-key",
+	})
+}
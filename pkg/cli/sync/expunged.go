@@ -0,0 +1,36 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+)
+
+// archiveExpunged keeps the notes selected by the condition in the archive of
+// the expunged notes, so that a note removed on another device can still be
+// found and restored here. It must be called before the notes are deleted.
+func archiveExpunged(ctx context.DnoteCtx, tx *database.DB, stmts *stmtCache, cond string, args ...interface{}) error {
+	if !infra.ExpungedArchiveOn(ctx) {
+		return nil
+	}
+
+	return database.ArchiveNotes(tx, stmts.now, cond, args...)
+}
@@ -0,0 +1,157 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+// newArchiveCtx returns a context whose syncs keep the expunged notes
+func newArchiveCtx(db *database.DB) context.DnoteCtx {
+	return context.DnoteCtx{DB: db, Clock: clock.NewMock(), ExpungedMaxAge: 30 * 24 * time.Hour, ExpungedMaxSize: 10000000}
+}
+
+func mustGetExpungedNotes(t *testing.T, db *database.DB) []database.ExpungedNote {
+	ret, err := database.GetExpungedNotes(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the expunged notes"))
+	}
+
+	return ret
+}
+
+func TestSyncDeleteNote_archive(t *testing.T) {
+	t.Run("on", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		f := testutils.NewFixture(t, db)
+		b1 := f.Book("b1-label")
+		n1 := f.Note(b1, "n1 body", testutils.With.USN(10), testutils.With.EditedOn(1541108744))
+		n2 := f.Note(b1, "n2 body", testutils.With.USN(11), testutils.With.Deleted())
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "beginning a transaction"))
+		}
+
+		stmts := mustPrepareStmts(t, tx)
+		for _, uuid := range []string{n1.UUID, n2.UUID} {
+			if err := syncDeleteNote(newArchiveCtx(db), tx, stmts, uuid, nil); err != nil {
+				tx.Rollback()
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+		}
+
+		tx.Commit()
+
+		// test
+		assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch")
+
+		// the body of the locally removed note is already gone
+		got := mustGetExpungedNotes(t, db)
+		assert.Equal(t, len(got), 1, "archived count mismatch")
+		assert.DeepEqual(t, got[0], database.ExpungedNote{
+			ID:         got[0].ID,
+			NoteUUID:   n1.UUID,
+			BookLabel:  "b1-label",
+			Body:       "n1 body",
+			AddedOn:    n1.AddedOn,
+			EditedOn:   1541108744,
+			ExpungedAt: testSyncTime,
+		}, "archived note mismatch")
+	})
+
+	t.Run("off", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		f := testutils.NewFixture(t, db)
+		b1 := f.Book("b1-label")
+		n1 := f.Note(b1, "n1 body", testutils.With.USN(10))
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "beginning a transaction"))
+		}
+
+		if err := syncDeleteNote(context.DnoteCtx{}, tx, mustPrepareStmts(t, tx), n1.UUID, nil); err != nil {
+			tx.Rollback()
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		tx.Commit()
+
+		// test
+		assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch")
+		assert.Equal(t, len(mustGetExpungedNotes(t, db)), 0, "archived count mismatch")
+	})
+}
+
+func TestSyncDeleteBook_archive(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, dbPath, nil)
+	defer database.TeardownTestDB(t, db)
+
+	f := testutils.NewFixture(t, db)
+	b1 := f.Book("b1-label", testutils.With.USN(1))
+	b2 := f.Book("b2-label", testutils.With.USN(2))
+	n1 := f.Note(b1, "n1 body", testutils.With.USN(10))
+	n2 := f.Note(b1, "n2 body", testutils.With.USN(11))
+	f.Note(b1, "", testutils.With.USN(12), testutils.With.Deleted())
+	n4 := f.Note(b2, "n4 body", testutils.With.USN(13))
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	if err := syncDeleteBook(newArchiveCtx(db), tx, mustPrepareStmts(t, tx), b1.UUID); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	tx.Commit()
+
+	// test
+	assert.Equal(t, f.MustCountNotes(), 1, "note count mismatch")
+	assert.DeepEqual(t, f.MustGetNote(n4.UUID), n4, "n4 mismatch")
+
+	got := mustGetExpungedNotes(t, db)
+	uuids := map[string]string{}
+	for _, n := range got {
+		assert.Equal(t, n.BookLabel, "b1-label", "book label mismatch")
+		assert.Equal(t, n.ExpungedAt, testSyncTime, "expunged at mismatch")
+		uuids[n.NoteUUID] = n.Body
+	}
+	assert.DeepEqual(t, uuids, map[string]string{n1.UUID: "n1 body", n2.UUID: "n2 body"}, "archived notes mismatch")
+}
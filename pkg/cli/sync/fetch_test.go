@@ -0,0 +1,329 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	stdCtx "context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/pkg/errors"
+)
+
+// pagedServer serves the given number of sync fragments, each of which holds
+// a single note and is 10 usn apart from the previous one. The last fragment
+// has no frag_max_usn. It records the after_usn of the requests it receives.
+type pagedServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	afterUSNs []int
+	requested chan int
+
+	// handle is called before a fragment is served. It returns the status to
+	// respond with, or 0 to serve the fragment.
+	handle func(r *http.Request, afterUSN int) int
+}
+
+func newPagedServer(t *testing.T, pages int) *pagedServer {
+	s := &pagedServer{
+		requested: make(chan int, pages+1),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		afterUSN, err := strconv.Atoi(r.URL.Query().Get("after_usn"))
+		if err != nil {
+			t.Errorf("parsing after_usn: %s", err)
+			return
+		}
+
+		s.mu.Lock()
+		s.afterUSNs = append(s.afterUSNs, afterUSN)
+		s.mu.Unlock()
+		s.requested <- afterUSN
+
+		if s.handle != nil {
+			if status := s.handle(r, afterUSN); status != 0 {
+				w.WriteHeader(status)
+				return
+			}
+		}
+
+		frag := client.SyncFragment{
+			Notes: []client.SyncFragNote{{UUID: fmt.Sprintf("n%d-uuid", afterUSN), USN: afterUSN + 10}},
+		}
+		if afterUSN/10 < pages-1 {
+			frag.FragMaxUSN = afterUSN + 10
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(client.GetSyncFragmentResp{Fragment: frag}); err != nil {
+			t.Errorf("encoding the fragment: %s", err)
+		}
+	}))
+
+	return s
+}
+
+// requests returns the after_usn of the requests received so far
+func (s *pagedServer) requests() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]int{}, s.afterUSNs...)
+}
+
+// waitRequest waits until the server receives a request for the given after_usn
+func (s *pagedServer) waitRequest(afterUSN int) error {
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case usn := <-s.requested:
+			if usn == afterUSN {
+				return nil
+			}
+		case <-timeout:
+			return errors.Errorf("timed out waiting for the request after usn %d", afterUSN)
+		}
+	}
+}
+
+func newFetchTestCtx(s *pagedServer) context.DnoteCtx {
+	return context.DnoteCtx{
+		APIEndpoint: s.URL,
+		SessionKey:  "test-session-key",
+	}
+}
+
+func TestFetchFragments_order(t *testing.T) {
+	s := newPagedServer(t, 5)
+	defer s.Close()
+
+	var got []string
+	err := fetchFragments(newFetchTestCtx(s), NewHTTPTransport(newFetchTestCtx(s)), nil, func(frag Changes) error {
+		got = append(got, frag.Notes[0].UUID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.DeepEqual(t, got, []string{"n0-uuid", "n10-uuid", "n20-uuid", "n30-uuid", "n40-uuid"}, "processed fragments mismatch")
+	assert.DeepEqual(t, s.requests(), []int{0, 10, 20, 30, 40}, "requests mismatch")
+}
+
+// TestFetchFragments_overlap tests that the next fragment is fetched while the
+// previous one is processed
+func TestFetchFragments_overlap(t *testing.T) {
+	s := newPagedServer(t, 4)
+	defer s.Close()
+
+	// the server is slow to respond
+	s.handle = func(r *http.Request, afterUSN int) int {
+		time.Sleep(20 * time.Millisecond)
+		return 0
+	}
+
+	var processed int
+	err := fetchFragments(newFetchTestCtx(s), NewHTTPTransport(newFetchTestCtx(s)), nil, func(frag Changes) error {
+		// the request for the next fragment arrives before this one is processed
+		if frag.More {
+			next, err := strconv.Atoi(string(frag.Next))
+			if err != nil {
+				return errors.Wrap(err, "parsing the next cursor")
+			}
+			if err := s.waitRequest(next); err != nil {
+				return err
+			}
+		}
+
+		processed++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, processed, 4, "processed count mismatch")
+}
+
+func TestFetchFragments_processError(t *testing.T) {
+	s := newPagedServer(t, 10)
+	defer s.Close()
+
+	var processed int
+	err := fetchFragments(newFetchTestCtx(s), NewHTTPTransport(newFetchTestCtx(s)), nil, func(frag Changes) error {
+		processed++
+		if processed == 2 {
+			return errors.New("test error")
+		}
+
+		return nil
+	})
+
+	assert.NotEqual(t, err, nil, "error mismatch")
+	assert.Equal(t, processed, 2, "processed count mismatch")
+
+	// the fetching stopped with at most the buffered fragment and the one in
+	// flight, which may reach the server after it was aborted
+	time.Sleep(20 * time.Millisecond)
+	if n := len(s.requests()); n > 2+fragmentBufferSize+1 {
+		t.Errorf("fetched %d fragments after the processing failed at the second", n)
+	}
+}
+
+func TestFetchFragments_fetchError(t *testing.T) {
+	s := newPagedServer(t, 5)
+	defer s.Close()
+
+	s.handle = func(r *http.Request, afterUSN int) int {
+		if afterUSN == 20 {
+			return http.StatusInternalServerError
+		}
+
+		return 0
+	}
+
+	var got []string
+	err := fetchFragments(newFetchTestCtx(s), NewHTTPTransport(newFetchTestCtx(s)), nil, func(frag Changes) error {
+		got = append(got, frag.Notes[0].UUID)
+		return nil
+	})
+
+	assert.NotEqual(t, err, nil, "error mismatch")
+	assert.DeepEqual(t, got, []string{"n0-uuid", "n10-uuid"}, "processed fragments mismatch")
+	assert.DeepEqual(t, s.requests(), []int{0, 10, 20}, "requests mismatch")
+}
+
+// TestFetchFragments_cancel tests that canceling the context, as upon an
+// interrupt, aborts the request in flight and stops the fetching
+func TestFetchFragments_cancel(t *testing.T) {
+	s := newPagedServer(t, 5)
+	defer s.Close()
+
+	aborted := make(chan struct{})
+	s.handle = func(r *http.Request, afterUSN int) int {
+		if afterUSN == 20 {
+			<-r.Context().Done()
+			close(aborted)
+		}
+
+		return 0
+	}
+
+	reqCtx, cancel := stdCtx.WithCancel(stdCtx.Background())
+	defer cancel()
+
+	ctx := newFetchTestCtx(s)
+	ctx.Ctx = reqCtx
+
+	go func() {
+		if err := s.waitRequest(20); err != nil {
+			t.Error(err)
+		}
+		cancel()
+	}()
+
+	var processed int
+	err := fetchFragments(ctx, NewHTTPTransport(ctx), nil, func(frag Changes) error {
+		processed++
+		return nil
+	})
+
+	assert.Equal(t, errors.Is(err, stdCtx.Canceled), true, fmt.Sprintf("error mismatch: %v", err))
+	assert.Equal(t, processed, 2, "processed count mismatch")
+
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request in flight was not aborted")
+	}
+	assert.DeepEqual(t, s.requests(), []int{0, 10, 20}, "requests mismatch")
+}
+
+// TestFetchFragments_repeatedCursor tests that the fetching stops when the
+// server gives a page that does not advance the cursor, rather than getting
+// the page forever
+func TestFetchFragments_repeatedCursor(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		afterUSN, err := strconv.Atoi(r.URL.Query().Get("after_usn"))
+		if err != nil {
+			t.Errorf("parsing after_usn: %s", err)
+			return
+		}
+
+		// the page after usn 10 points back at itself
+		frag := client.SyncFragment{FragMaxUSN: afterUSN + 10}
+		if afterUSN == 10 {
+			frag.FragMaxUSN = 10
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(client.GetSyncFragmentResp{Fragment: frag}); err != nil {
+			t.Errorf("encoding the fragment: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.DnoteCtx{APIEndpoint: ts.URL, SessionKey: "test-session-key"}
+
+	var processed int
+	err := fetchFragments(ctx, NewHTTPTransport(ctx), nil, func(frag Changes) error {
+		processed++
+		return nil
+	})
+
+	assert.NotEqual(t, err, nil, "error mismatch")
+	assert.Equal(t, processed, 1, "processed count mismatch")
+	assert.Equal(t, requests, 2, "request count mismatch")
+}
+
+func TestFetchFragments_pageSize(t *testing.T) {
+	s := newPagedServer(t, 2)
+	defer s.Close()
+
+	var limits []string
+	s.handle = func(r *http.Request, afterUSN int) int {
+		limits = append(limits, r.URL.Query().Get("limit"))
+		return 0
+	}
+
+	ctx := newFetchTestCtx(s)
+	ctx.SyncPageSize = 50
+	err := fetchFragments(ctx, NewHTTPTransport(ctx), nil, func(frag Changes) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.DeepEqual(t, limits, []string{"50", "50"}, "limits mismatch")
+}
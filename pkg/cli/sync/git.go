@@ -0,0 +1,706 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// gitRemote is the remote that the git backend pulls from and pushes to
+	gitRemote = "origin"
+	// gitEmptyTree is the hash of the empty tree, which is the base of the
+	// changes when the whole repository is imported
+	gitEmptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	// gitNoteExt is the extension of the note files
+	gitNoteExt = ".md"
+	// gitFrontMatterDelim delimits the front matter of a note file
+	gitFrontMatterDelim = "---\n"
+)
+
+// gitBackend syncs through a git repository in which each book is a directory
+// named after its label, and each note is a Markdown file named after its uuid
+// with its metadata in the front matter. The working tree of the repository
+// is managed by dnote. Instead of the usn, the last synced commit is used to
+// find the changes made elsewhere.
+type gitBackend struct {
+	dir string
+	// full imports the whole repository instead of only the changes since the
+	// last synced commit
+	full bool
+}
+
+func (gitBackend) needsSession() bool {
+	return false
+}
+
+// gitNoteMeta is the front matter of a note file
+type gitNoteMeta struct {
+	AddedOn  int64 `yaml:"added_on"`
+	EditedOn int64 `yaml:"edited_on,omitempty"`
+	Public   bool  `yaml:"public,omitempty"`
+}
+
+// marshalGitNote returns the content of the file of the given note
+func marshalGitNote(n database.Note) ([]byte, error) {
+	meta, err := yaml.Marshal(gitNoteMeta{AddedOn: n.AddedOn, EditedOn: n.EditedOn, Public: n.Public})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling the front matter")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(gitFrontMatterDelim)
+	buf.Write(meta)
+	buf.WriteString(gitFrontMatterDelim)
+	buf.WriteString(n.Body)
+
+	return buf.Bytes(), nil
+}
+
+// unmarshalGitNote parses the content of a note file. A file without a front
+// matter, e.g. one added by hand, is a note added at the given time.
+func unmarshalGitNote(b []byte, now int64) (gitNoteMeta, string, error) {
+	s := utils.NormalizeBody(string(b))
+
+	if !strings.HasPrefix(s, gitFrontMatterDelim) {
+		return gitNoteMeta{AddedOn: now}, s, nil
+	}
+
+	rest := s[len(gitFrontMatterDelim):]
+	idx := strings.Index(rest, "\n"+gitFrontMatterDelim)
+	if idx == -1 {
+		return gitNoteMeta{}, "", errors.New("the front matter is not closed")
+	}
+
+	var meta gitNoteMeta
+	if err := yaml.Unmarshal([]byte(rest[:idx+1]), &meta); err != nil {
+		return gitNoteMeta{}, "", errors.Wrap(err, "unmarshalling the front matter")
+	}
+
+	return meta, rest[idx+1+len(gitFrontMatterDelim):], nil
+}
+
+// getGitNotePath returns the path of the file of a note relative to the
+// repository. The label is escaped so that a label containing a slash is a
+// single directory.
+func getGitNotePath(label, uuid string) string {
+	return filepath.Join(url.PathEscape(label), uuid+gitNoteExt)
+}
+
+// parseGitNotePath returns the label of the book and the uuid of the note of
+// the file at the given path, as printed by git. The files that are not notes
+// are not ok.
+func parseGitNotePath(path string) (string, string, bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || strings.HasPrefix(parts[0], ".") || !strings.HasSuffix(parts[1], gitNoteExt) {
+		return "", "", false
+	}
+
+	label, err := url.PathUnescape(parts[0])
+	if err != nil || label == "" {
+		return "", "", false
+	}
+
+	uuid := strings.TrimSuffix(parts[1], gitNoteExt)
+	if uuid == "" {
+		return "", "", false
+	}
+
+	return label, uuid, true
+}
+
+// run runs git in the repository and returns its output
+func (b gitBackend) run(ctx context.DnoteCtx, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx.Context(), "git", args...)
+	cmd.Dir = b.dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	log.Debug("running git %s\n", strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "running git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// test runs a git command that reports the result with its exit status
+func (b gitBackend) test(ctx context.DnoteCtx, args ...string) (bool, error) {
+	_, err := b.run(ctx, args...)
+	if err == nil {
+		return true, nil
+	}
+	if _, ok := errors.Cause(err).(*exec.ExitError); ok {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// resolve returns the commit that the given revision refers to, or an empty
+// string if it does not exist
+func (b gitBackend) resolve(ctx context.DnoteCtx, rev string) (string, error) {
+	out, err := b.run(ctx, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		if _, ok := errors.Cause(err).(*exec.ExitError); ok {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// hasRemote checks if the repository has the remote to sync with
+func (b gitBackend) hasRemote(ctx context.DnoteCtx) (bool, error) {
+	out, err := b.run(ctx, "remote")
+	if err != nil {
+		return false, errors.Wrap(err, "listing the remotes")
+	}
+
+	for _, r := range strings.Fields(out) {
+		if r == gitRemote {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// fetch fetches the remote, if any, and returns the commit that the local
+// database is brought up to date with, or an empty string if there is none
+func (b gitBackend) fetch(ctx context.DnoteCtx, branch string, remote bool) (string, error) {
+	if !remote {
+		return b.resolve(ctx, "HEAD")
+	}
+
+	if _, err := b.run(ctx, "fetch", gitRemote); err != nil {
+		return "", errors.Wrap(err, "fetching the remote")
+	}
+
+	return b.resolve(ctx, "refs/remotes/"+gitRemote+"/"+branch)
+}
+
+// getChangeBase returns the commit from which the changes are imported. It is
+// the last synced commit if the history still contains it, or the empty tree
+// to import the whole repository.
+func (b gitBackend) getChangeBase(ctx context.DnoteCtx, tx *database.DB, upstream string) (string, error) {
+	var lastCommit string
+	err := database.GetSystem(tx, consts.SystemLastGitCommit, &lastCommit)
+	if err != nil && errors.Cause(err) != sql.ErrNoRows {
+		return "", errors.Wrap(err, "getting the last synced commit")
+	}
+
+	if lastCommit == "" || b.full {
+		return gitEmptyTree, nil
+	}
+
+	ok, err := b.test(ctx, "merge-base", "--is-ancestor", lastCommit, upstream)
+	if err != nil {
+		return "", errors.Wrap(err, "checking the ancestry of the last synced commit")
+	}
+	if !ok {
+		log.Debug("the last synced commit %s is not an ancestor of %s. importing the whole repository\n", lastCommit, upstream)
+		return gitEmptyTree, nil
+	}
+
+	return lastCommit, nil
+}
+
+// gitChange is a change of a file between two commits
+type gitChange struct {
+	// status is A for an added file, M for a modified one and D for a deleted one
+	status string
+	path   string
+}
+
+// getChanges returns the changes of the files between the given commits
+func (b gitBackend) getChanges(ctx context.DnoteCtx, from, to string) ([]gitChange, error) {
+	out, err := b.run(ctx, "diff", "--name-status", "--no-renames", "-z", from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "diffing the commits")
+	}
+
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	if len(fields) == 1 && fields[0] == "" {
+		return nil, nil
+	}
+	if len(fields)%2 != 0 {
+		return nil, errors.Errorf("unexpected diff output %q", out)
+	}
+
+	ret := make([]gitChange, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		ret = append(ret, gitChange{status: fields[i], path: fields[i+1]})
+	}
+
+	return ret, nil
+}
+
+// getGitBook returns the uuid of the book with the given label, creating it or
+// restoring it if it was deleted locally, because a note added elsewhere is
+// kept over the deletion of its book
+func getGitBook(ctx context.DnoteCtx, tx *database.DB, label string) (string, error) {
+	var uuid string
+	var deleted bool
+	err := tx.QueryRow("SELECT uuid, deleted FROM books WHERE label = ?", label).Scan(&uuid, &deleted)
+	if err == sql.ErrNoRows {
+		uuid, err = utils.GenerateUUID()
+		if err != nil {
+			return "", errors.Wrap(err, "generating uuid")
+		}
+
+		b := database.NewBook(uuid, label, 0, false, false)
+		if err := b.Insert(tx); err != nil {
+			return "", errors.Wrapf(err, "inserting book %s", label)
+		}
+
+		var c database.FieldChanges
+		c.Field("label", "", label)
+		if err := journalGit(ctx, tx, database.OpAddBook, "", uuid, c); err != nil {
+			return "", err
+		}
+
+		return uuid, nil
+	} else if err != nil {
+		return "", errors.Wrapf(err, "getting book %s", label)
+	}
+
+	if deleted {
+		if _, err := tx.Exec("UPDATE books SET deleted = ?, dirty = ? WHERE uuid = ?", false, false, uuid); err != nil {
+			return "", errors.Wrapf(err, "restoring book %s", label)
+		}
+	}
+
+	return uuid, nil
+}
+
+// importGitNote applies a note file added or modified elsewhere. A note that
+// was also changed locally is merged with the conflict markers, as a note
+// changed both locally and on the server is.
+func (b gitBackend) importGitNote(ctx context.DnoteCtx, tx *database.DB, label, uuid, path string) error {
+	content, err := ioutil.ReadFile(filepath.Join(b.dir, filepath.FromSlash(path)))
+	if err != nil {
+		return errors.Wrap(err, "reading the file")
+	}
+
+	meta, body, err := unmarshalGitNote(content, ctx.Clock.Now().UnixNano())
+	if err != nil {
+		return errors.Wrap(err, "parsing the file")
+	}
+
+	bookUUID, err := getGitBook(ctx, tx, label)
+	if err != nil {
+		return errors.Wrap(err, "getting the book")
+	}
+
+	var local database.Note
+	err = tx.QueryRow("SELECT uuid, book_uuid, body, edited_on, dirty, deleted FROM notes WHERE uuid = ?", uuid).
+		Scan(&local.UUID, &local.BookUUID, &local.Body, &local.EditedOn, &local.Dirty, &local.Deleted)
+	if err == sql.ErrNoRows {
+		n := database.NewNote(uuid, bookUUID, body, meta.AddedOn, meta.EditedOn, 0, meta.Public, false, false)
+		if n.Position, err = database.NextNotePosition(tx, bookUUID); err != nil {
+			return errors.Wrap(err, "finding the position")
+		}
+		if err := n.Insert(tx); err != nil {
+			return errors.Wrap(err, "inserting the note")
+		}
+
+		var c database.FieldChanges
+		c.Body("", body)
+		return journalGit(ctx, tx, database.OpAddNote, uuid, bookUUID, c)
+	} else if err != nil {
+		return errors.Wrap(err, "getting the local note")
+	}
+
+	// a note deleted locally is restored with the changes made elsewhere
+	if !local.Dirty || local.Deleted {
+		n := database.NewNote(uuid, bookUUID, body, meta.AddedOn, meta.EditedOn, 0, meta.Public, false, false)
+		if err := n.Update(tx); err != nil {
+			return errors.Wrap(err, "updating the note")
+		}
+
+		return journalGitEdit(ctx, tx, local, bookUUID, body)
+	}
+
+	if local.Body == body && local.BookUUID == bookUUID {
+		return nil
+	}
+
+	mr, err := mergeNoteFields(tx, local, client.SyncFragNote{UUID: uuid, BookUUID: bookUUID, Body: body, EditedOn: meta.EditedOn})
+	if err != nil {
+		return errors.Wrap(err, "merging the note")
+	}
+
+	if _, err := tx.Exec("UPDATE notes SET book_uuid = ?, body = ?, edited_on = ? WHERE uuid = ?", mr.bookUUID, mr.body, mr.editedOn, uuid); err != nil {
+		return errors.Wrap(err, "updating the local note")
+	}
+
+	return journalGitEdit(ctx, tx, local, mr.bookUUID, mr.body)
+}
+
+// journalGit records a change that the git backend makes to a local note or
+// book
+func journalGit(ctx context.DnoteCtx, tx *database.DB, op, noteUUID, bookUUID string, c database.FieldChanges) error {
+	return database.InsertAction(tx, database.Action{
+		Timestamp: ctx.Clock.Now().UnixNano(),
+		Actor:     database.ActorSyncDownload,
+		Operation: op,
+		NoteUUID:  noteUUID,
+		BookUUID:  bookUUID,
+		Summary:   c.String(),
+		Device:    ctx.DeviceID,
+	})
+}
+
+// journalGitEdit records the change to the local copy of a note, unless
+// nothing changed
+func journalGitEdit(ctx context.DnoteCtx, tx *database.DB, local database.Note, bookUUID, body string) error {
+	if local.BookUUID == bookUUID && local.Body == body && !local.Deleted {
+		return nil
+	}
+
+	var c database.FieldChanges
+	c.Field("book_uuid", local.BookUUID, bookUUID)
+	c.Body(local.Body, body)
+	op := database.NoteEditOperation(local.BookUUID, bookUUID, local.Body, body)
+
+	return journalGit(ctx, tx, op, local.UUID, bookUUID, c)
+}
+
+// deleteGitNote deletes a note deleted elsewhere. A note changed locally is
+// kept over the deletion.
+func deleteGitNote(ctx context.DnoteCtx, tx *database.DB, uuid string) error {
+	var bookUUID, body string
+	var dirty, deleted bool
+	err := tx.QueryRow("SELECT book_uuid, body, dirty, deleted FROM notes WHERE uuid = ?", uuid).Scan(&bookUUID, &body, &dirty, &deleted)
+	if err == sql.ErrNoRows || (err == nil && dirty) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "getting the local note")
+	}
+
+	if _, err := tx.Exec("DELETE FROM notes WHERE uuid = ?", uuid); err != nil {
+		return errors.Wrap(err, "deleting the note")
+	}
+	if deleted {
+		return nil
+	}
+
+	var c database.FieldChanges
+	c.Body(body, "")
+	return journalGit(ctx, tx, database.OpRemoveNote, uuid, bookUUID, c)
+}
+
+// importChanges applies the changes made elsewhere to the local database. The
+// working tree must be at the commit that the changes lead to.
+func (b gitBackend) importChanges(ctx context.DnoteCtx, tx *database.DB, changes []gitChange) error {
+	for _, c := range changes {
+		label, uuid, ok := parseGitNotePath(c.path)
+		if !ok {
+			log.Debug("skipping %s which is not a note\n", c.path)
+			continue
+		}
+
+		if c.status == "D" {
+			if err := deleteGitNote(ctx, tx, uuid); err != nil {
+				return errors.Wrapf(err, "deleting note %s", uuid)
+			}
+
+			continue
+		}
+
+		if err := b.importGitNote(ctx, tx, label, uuid, c.path); err != nil {
+			return errors.Wrapf(err, "importing %s", c.path)
+		}
+	}
+
+	return nil
+}
+
+// export writes the notes in the local database to the working tree, and
+// removes the files of the notes that no longer exist
+func (b gitBackend) export(tx *database.DB) error {
+	rows, err := tx.Query(`SELECT notes.uuid, books.label, notes.body, notes.added_on, notes.edited_on, notes.public
+		FROM notes INNER JOIN books ON books.uuid = notes.book_uuid
+		WHERE notes.deleted = false AND books.deleted = false`)
+	if err != nil {
+		return errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	files := map[string][]byte{}
+	for rows.Next() {
+		var n database.Note
+		var label string
+		if err := rows.Scan(&n.UUID, &label, &n.Body, &n.AddedOn, &n.EditedOn, &n.Public); err != nil {
+			return errors.Wrap(err, "scanning a note")
+		}
+
+		content, err := marshalGitNote(n)
+		if err != nil {
+			return errors.Wrapf(err, "marshalling note %s", n.UUID)
+		}
+
+		files[getGitNotePath(label, n.UUID)] = content
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "iterating notes")
+	}
+
+	if err := b.removeStaleFiles(files); err != nil {
+		return errors.Wrap(err, "removing the files of the removed notes")
+	}
+
+	for path, content := range files {
+		p := filepath.Join(b.dir, path)
+
+		existing, err := ioutil.ReadFile(p)
+		if err == nil && bytes.Equal(existing, content) {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return errors.Wrapf(err, "creating the directory of %s", path)
+		}
+		if err := ioutil.WriteFile(p, content, 0644); err != nil {
+			return errors.Wrapf(err, "writing %s", path)
+		}
+	}
+
+	return nil
+}
+
+// removeStaleFiles removes the note files that are not in the given files, and
+// the book directories left empty
+func (b gitBackend) removeStaleFiles(files map[string][]byte) error {
+	dirs, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return errors.Wrap(err, "reading the repository")
+	}
+
+	for _, d := range dirs {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(filepath.Join(b.dir, d.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", d.Name())
+		}
+
+		remaining := len(entries)
+		for _, e := range entries {
+			path := filepath.Join(d.Name(), e.Name())
+			if e.IsDir() || !strings.HasSuffix(e.Name(), gitNoteExt) {
+				continue
+			}
+			if _, ok := files[path]; ok {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(b.dir, path)); err != nil {
+				return errors.Wrapf(err, "removing %s", path)
+			}
+			remaining--
+		}
+
+		if remaining == 0 {
+			if err := os.Remove(filepath.Join(b.dir, d.Name())); err != nil {
+				return errors.Wrapf(err, "removing %s", d.Name())
+			}
+		}
+	}
+
+	return nil
+}
+
+// commit commits the changes in the working tree, if any. The commit is made
+// as dnote if the user's identity is not configured in git.
+func (b gitBackend) commit(ctx context.DnoteCtx) error {
+	if _, err := b.run(ctx, "add", "--all"); err != nil {
+		return errors.Wrap(err, "staging the changes")
+	}
+
+	out, err := b.run(ctx, "status", "--porcelain")
+	if err != nil {
+		return errors.Wrap(err, "getting the status")
+	}
+	if out == "" {
+		return nil
+	}
+
+	args := []string{"commit", "--quiet", "--message", "dnote sync"}
+	if ok, err := b.test(ctx, "config", "user.email"); err != nil {
+		return errors.Wrap(err, "checking the git identity")
+	} else if !ok {
+		args = append([]string{"-c", "user.name=dnote", "-c", "user.email=dnote@localhost"}, args...)
+	}
+
+	if _, err := b.run(ctx, args...); err != nil {
+		return errors.Wrap(err, "committing the changes")
+	}
+
+	return nil
+}
+
+// finishGitSync removes the notes and the books deleted locally, which are no longer
+// in the repository, marks the rest as synced and records the synced commit.
+// The books without any note are removed because a directory cannot be empty
+// in git.
+func finishGitSync(tx *database.DB, commit string) error {
+	queries := []string{
+		"DELETE FROM notes WHERE deleted OR book_uuid IN (SELECT uuid FROM books WHERE deleted)",
+		"DELETE FROM books WHERE deleted",
+		"UPDATE notes SET dirty = false WHERE dirty",
+		"UPDATE books SET dirty = false WHERE dirty",
+		"DELETE FROM books WHERE NOT EXISTS (SELECT 1 FROM notes WHERE notes.book_uuid = books.uuid)",
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return errors.Wrapf(err, "executing %s", q)
+		}
+	}
+
+	if err := database.UpsertSystem(tx, consts.SystemLastGitCommit, commit); err != nil {
+		return errors.Wrap(err, "saving the synced commit")
+	}
+
+	return nil
+}
+
+func (b gitBackend) sync(ctx context.DnoteCtx, tx *database.DB, timer *phaseTimer) error {
+	ok, err := utils.FileExists(filepath.Join(b.dir, ".git"))
+	if err != nil {
+		return errors.Wrap(err, "checking the repository")
+	}
+	if !ok {
+		return errors.Errorf("%s is not a git repository. Clone the repository of your notes there, or set sync.gitDir in the config file", b.dir)
+	}
+
+	out, err := b.run(ctx, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return errors.Wrap(err, "getting the current branch")
+	}
+	branch := strings.TrimSpace(out)
+
+	remote, err := b.hasRemote(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("%s", i18n.T("sync.resolving_delta"))
+
+	stopDownload := timer.track(phaseDownload)
+	upstream, err := b.fetch(ctx, branch, remote)
+	stopDownload()
+	if err != nil {
+		return err
+	}
+
+	stopApply := timer.track(phaseApply)
+	if upstream != "" {
+		// the unpushed commits of a failed sync are discarded because the
+		// changes in them are still marked dirty
+		if _, err := b.run(ctx, "reset", "--hard", "--quiet", upstream); err != nil {
+			stopApply()
+			return errors.Wrap(err, "checking out the remote changes")
+		}
+
+		base, err := b.getChangeBase(ctx, tx, upstream)
+		if err != nil {
+			stopApply()
+			return err
+		}
+		changes, err := b.getChanges(ctx, base, upstream)
+		if err != nil {
+			stopApply()
+			return err
+		}
+
+		ctx.Logger.Rawf("%s", i18n.T("sync.total", len(changes)))
+		timer.count(phaseDownload, len(changes))
+
+		if err := b.importChanges(ctx, tx, changes); err != nil {
+			stopApply()
+			return errors.Wrap(err, "importing the changes")
+		}
+	}
+	stopApply()
+
+	ctx.Logger.Rawf("%s", i18n.T("sync.done"))
+
+	// every local change is committed
+	dirty, err := database.CountDirty(tx)
+	if err != nil {
+		return errors.Wrap(err, "counting the local changes")
+	}
+	timer.count(phaseUpload, dirty.Notes.Total()+dirty.Books.Total())
+
+	defer timer.track(phaseUpload)()
+
+	ctx.Logger.Infof("%s", i18n.T("sync.sending_changes"))
+
+	if err := b.export(tx); err != nil {
+		return errors.Wrap(err, "exporting the notes")
+	}
+	if err := b.commit(ctx); err != nil {
+		return err
+	}
+
+	head, err := b.resolve(ctx, "HEAD")
+	if err != nil {
+		return errors.Wrap(err, "getting the head commit")
+	}
+
+	if remote && head != "" && head != upstream {
+		if _, err := b.run(ctx, "push", "--quiet", gitRemote, "HEAD:refs/heads/"+branch); err != nil {
+			return errors.Wrap(err, "pushing the changes")
+		}
+	}
+
+	if err := finishGitSync(tx, head); err != nil {
+		return err
+	}
+	timer.countUploaded(countSent(dirty.Notes, database.ChangeCounts{}), countSent(dirty.Books, database.ChangeCounts{}))
+
+	ctx.Logger.Rawf("%s", i18n.T("sync.done"))
+
+	return nil
+}
@@ -0,0 +1,316 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+func mustRunGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(errors.Wrapf(err, "running git %s: %s", strings.Join(args, " "), out))
+	}
+
+	return string(out)
+}
+
+// gitMachine is a client that syncs with the git backend
+type gitMachine struct {
+	ctx     context.DnoteCtx
+	backend gitBackend
+}
+
+// newGitRemote creates a bare repository that serves as the remote
+func newGitRemote(t *testing.T) string {
+	dir := filepath.Join(t.TempDir(), "remote.git")
+	mustRunGit(t, filepath.Dir(dir), "init", "--quiet", "--bare", dir)
+
+	return dir
+}
+
+// newGitMachine creates a client with its own database and clone of the remote
+func newGitMachine(t *testing.T, remote string) gitMachine {
+	db := database.InitTestDB(t, "dnote.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+	t.Cleanup(func() {
+		database.TeardownTestDB(t, db)
+	})
+
+	dir := filepath.Join(t.TempDir(), "notes")
+	mustRunGit(t, filepath.Dir(dir), "clone", "--quiet", remote, dir)
+
+	ctx := context.DnoteCtx{
+		DB:     db,
+		Clock:  clock.NewMock(),
+		Logger: log.Default(),
+	}
+
+	return gitMachine{ctx: ctx, backend: gitBackend{dir: dir}}
+}
+
+func (m gitMachine) sync(t *testing.T) {
+	err := infra.WithTx(m.ctx.DB, func(tx *database.DB) error {
+		return m.backend.sync(m.ctx, tx, newPhaseTimer(time.Now))
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "syncing"))
+	}
+}
+
+func (m gitMachine) getNote(t *testing.T, uuid string) (database.Note, string) {
+	var n database.Note
+	var label string
+	database.MustScan(t, fmt.Sprintf("getting note %s", uuid),
+		m.ctx.DB.QueryRow(`SELECT notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.public, notes.dirty, notes.deleted, books.label
+		FROM notes INNER JOIN books ON books.uuid = notes.book_uuid WHERE notes.uuid = ?`, uuid),
+		&n.UUID, &n.Body, &n.AddedOn, &n.EditedOn, &n.Public, &n.Dirty, &n.Deleted, &label)
+
+	return n, label
+}
+
+func (m gitMachine) countNotes(t *testing.T) int {
+	var count int
+	database.MustScan(t, "counting notes", m.ctx.DB.QueryRow("SELECT count(*) FROM notes"), &count)
+
+	return count
+}
+
+func TestGitSync(t *testing.T) {
+	t.Run("new notes", func(t *testing.T) {
+		// set up
+		remote := newGitRemote(t)
+		a := newGitMachine(t, remote)
+		b := newGitMachine(t, remote)
+
+		database.MustExec(t, "inserting b1", a.ctx.DB, "INSERT INTO books (uuid, label, dirty) VALUES (?, ?, ?)", "b1-uuid", "js", true)
+		database.MustExec(t, "inserting n1", a.ctx.DB, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, public, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743, 1541108744, true, true)
+
+		// execute
+		a.sync(t)
+		b.sync(t)
+
+		// test
+		n1, label := b.getNote(t, "n1-uuid")
+		assert.Equal(t, label, "js", "label mismatch")
+		assert.Equal(t, n1.Body, "n1 body", "body mismatch")
+		assert.Equal(t, n1.AddedOn, int64(1541108743), "added_on mismatch")
+		assert.Equal(t, n1.EditedOn, int64(1541108744), "edited_on mismatch")
+		assert.Equal(t, n1.Public, true, "public mismatch")
+		assert.Equal(t, n1.Dirty, false, "dirty mismatch")
+
+		aN1, _ := a.getNote(t, "n1-uuid")
+		assert.Equal(t, aN1.Dirty, false, "local note was not marked synced")
+
+		var lastCommit string
+		database.MustScan(t, "getting the last commit", a.ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastGitCommit), &lastCommit)
+		assert.Equal(t, lastCommit, strings.TrimSpace(mustRunGit(t, remote, "rev-parse", "HEAD")), "last commit mismatch")
+	})
+
+	t.Run("edits and deletions", func(t *testing.T) {
+		// set up
+		remote := newGitRemote(t)
+		a := newGitMachine(t, remote)
+		b := newGitMachine(t, remote)
+
+		database.MustExec(t, "inserting b1", a.ctx.DB, "INSERT INTO books (uuid, label, dirty) VALUES (?, ?, ?)", "b1-uuid", "js", true)
+		database.MustExec(t, "inserting b2", a.ctx.DB, "INSERT INTO books (uuid, label, dirty) VALUES (?, ?, ?)", "b2-uuid", "css", true)
+		database.MustExec(t, "inserting n1", a.ctx.DB, "INSERT INTO notes (uuid, book_uuid, body, added_on, dirty) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743, true)
+		database.MustExec(t, "inserting n2", a.ctx.DB, "INSERT INTO notes (uuid, book_uuid, body, added_on, dirty) VALUES (?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", "n2 body", 1541108743, true)
+		database.MustExec(t, "inserting n3", a.ctx.DB, "INSERT INTO notes (uuid, book_uuid, body, added_on, dirty) VALUES (?, ?, ?, ?, ?)", "n3-uuid", "b2-uuid", "n3 body", 1541108743, true)
+		a.sync(t)
+		b.sync(t)
+
+		database.MustExec(t, "editing n1", b.ctx.DB, "UPDATE notes SET body = ?, dirty = ? WHERE uuid = ?", "n1 body edited", true, "n1-uuid")
+		database.MustExec(t, "deleting n2", b.ctx.DB, "UPDATE notes SET deleted = ?, body = ?, dirty = ? WHERE uuid = ?", true, "", true, "n2-uuid")
+		database.MustExec(t, "moving n3", b.ctx.DB, "UPDATE notes SET book_uuid = (SELECT uuid FROM books WHERE label = ?), dirty = ? WHERE uuid = ?", "js", true, "n3-uuid")
+
+		// execute
+		b.sync(t)
+		a.sync(t)
+
+		// test
+		n1, _ := a.getNote(t, "n1-uuid")
+		assert.Equal(t, n1.Body, "n1 body edited", "n1 body mismatch")
+		assert.Equal(t, n1.Dirty, false, "n1 dirty mismatch")
+
+		n3, label := a.getNote(t, "n3-uuid")
+		assert.Equal(t, label, "js", "n3 label mismatch")
+		assert.Equal(t, n3.Dirty, false, "n3 dirty mismatch")
+
+		assert.Equal(t, a.countNotes(t), 2, "a note count mismatch")
+		assert.Equal(t, b.countNotes(t), 2, "b note count mismatch")
+
+		var bookCount int
+		database.MustScan(t, "counting books", a.ctx.DB.QueryRow("SELECT count(*) FROM books"), &bookCount)
+		assert.Equal(t, bookCount, 1, "the empty book was not removed")
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		// set up
+		remote := newGitRemote(t)
+		a := newGitMachine(t, remote)
+		b := newGitMachine(t, remote)
+
+		database.MustExec(t, "inserting b1", a.ctx.DB, "INSERT INTO books (uuid, label, dirty) VALUES (?, ?, ?)", "b1-uuid", "js", true)
+		database.MustExec(t, "inserting n1", a.ctx.DB, "INSERT INTO notes (uuid, book_uuid, body, added_on, dirty) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743, true)
+		a.sync(t)
+		b.sync(t)
+
+		database.MustExec(t, "editing n1 on a", a.ctx.DB, "UPDATE notes SET body = ?, dirty = ? WHERE uuid = ?", "n1 body a", true, "n1-uuid")
+		database.MustExec(t, "editing n1 on b", b.ctx.DB, "UPDATE notes SET body = ?, dirty = ? WHERE uuid = ?", "n1 body b", true, "n1-uuid")
+
+		// execute
+		a.sync(t)
+		b.sync(t)
+		a.sync(t)
+
+		// test
+		expected := reportBodyConflict("n1 body b", "n1 body a")
+
+		bN1, _ := b.getNote(t, "n1-uuid")
+		assert.Equal(t, bN1.Body, expected, "b body mismatch")
+
+		aN1, _ := a.getNote(t, "n1-uuid")
+		assert.Equal(t, aN1.Body, expected, "a body mismatch")
+		assert.Equal(t, aN1.Dirty, false, "a dirty mismatch")
+	})
+
+	t.Run("without a remote", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, "dnote.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+		defer database.TeardownTestDB(t, db)
+
+		dir := t.TempDir()
+		mustRunGit(t, dir, "init", "--quiet")
+
+		m := gitMachine{
+			ctx:     context.DnoteCtx{DB: db, Clock: clock.NewMock(), Logger: log.Default()},
+			backend: gitBackend{dir: dir},
+		}
+
+		database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, dirty) VALUES (?, ?, ?)", "b1-uuid", "js", true)
+		database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, dirty) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743, true)
+
+		// execute
+		m.sync(t)
+		m.sync(t)
+
+		// test
+		log := mustRunGit(t, dir, "log", "--oneline")
+		assert.Equal(t, strings.Count(log, "\n"), 1, "commit count mismatch")
+
+		files := mustRunGit(t, dir, "ls-files")
+		assert.Equal(t, files, "js/n1-uuid.md\n", "files mismatch")
+	})
+
+	t.Run("not a repository", func(t *testing.T) {
+		db := database.InitTestDB(t, "dnote.db", &database.TestDBOptions{Mode: database.TestDBModeTempDir})
+		defer database.TeardownTestDB(t, db)
+
+		m := gitMachine{
+			ctx:     context.DnoteCtx{DB: db, Clock: clock.NewMock(), Logger: log.Default()},
+			backend: gitBackend{dir: t.TempDir()},
+		}
+
+		err := infra.WithTx(db, func(tx *database.DB) error {
+			return m.backend.sync(m.ctx, tx, newPhaseTimer(time.Now))
+		})
+
+		assert.NotEqual(t, err, nil, "error was not returned")
+		assert.Equal(t, strings.Contains(err.Error(), "is not a git repository"), true, "error mismatch")
+	})
+}
+
+func TestGitNote(t *testing.T) {
+	n := database.Note{Body: "# title\n---\nbody\n", AddedOn: 1541108743, EditedOn: 1541108744, Public: true}
+
+	b, err := marshalGitNote(n)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "marshalling"))
+	}
+	assert.Equal(t, string(b), "---\nadded_on: 1541108743\nedited_on: 1541108744\npublic: true\n---\n# title\n---\nbody\n", "content mismatch")
+
+	meta, body, err := unmarshalGitNote(b, 1)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "unmarshalling"))
+	}
+	assert.Equal(t, meta, gitNoteMeta{AddedOn: 1541108743, EditedOn: 1541108744, Public: true}, "meta mismatch")
+	assert.Equal(t, body, n.Body, "body mismatch")
+
+	t.Run("without a front matter", func(t *testing.T) {
+		meta, body, err := unmarshalGitNote([]byte("body\r\n"), 1541108745)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "unmarshalling"))
+		}
+
+		assert.Equal(t, meta, gitNoteMeta{AddedOn: 1541108745}, "meta mismatch")
+		assert.Equal(t, body, "body\n", "body mismatch")
+	})
+
+	t.Run("unclosed front matter", func(t *testing.T) {
+		_, _, err := unmarshalGitNote([]byte("---\nadded_on: 1\nbody"), 1)
+
+		assert.NotEqual(t, err, nil, "error was not returned")
+	})
+}
+
+func TestParseGitNotePath(t *testing.T) {
+	testCases := []struct {
+		path  string
+		label string
+		uuid  string
+		ok    bool
+	}{
+		{path: "js/n1-uuid.md", label: "js", uuid: "n1-uuid", ok: true},
+		{path: "a%2Fb/n1-uuid.md", label: "a/b", uuid: "n1-uuid", ok: true},
+		{path: "README.md", ok: false},
+		{path: "js/n1-uuid.txt", ok: false},
+		{path: "js/sub/n1-uuid.md", ok: false},
+		{path: ".github/n1-uuid.md", ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			label, uuid, ok := parseGitNotePath(tc.path)
+
+			assert.Equal(t, label, tc.label, "label mismatch")
+			assert.Equal(t, uuid, tc.uuid, "uuid mismatch")
+			assert.Equal(t, ok, tc.ok, "ok mismatch")
+		})
+	}
+
+	assert.Equal(t, getGitNotePath("a/b", "n1-uuid"), filepath.Join("a%2Fb", "n1-uuid.md"), "path mismatch")
+}
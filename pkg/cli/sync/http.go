@@ -0,0 +1,252 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	stdCtx "context"
+	"net/http"
+	"strconv"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/pkg/errors"
+)
+
+// httpTransport exchanges the changes with the dnote server through its HTTP
+// API. Its cursor is the usn of the change in decimal, which is the same as the
+// last max usn kept by the earlier versions.
+type httpTransport struct {
+	ctx context.DnoteCtx
+}
+
+// NewHTTPTransport returns a transport to the server at the API endpoint of
+// the context, authenticated with its session key
+func NewHTTPTransport(ctx context.DnoteCtx) Transport {
+	return httpTransport{ctx: ctx}
+}
+
+// with returns the context for a request canceled with c
+func (t httpTransport) with(c stdCtx.Context) context.DnoteCtx {
+	ret := t.ctx
+	ret.Ctx = c
+
+	return ret
+}
+
+// USNCursor returns the cursor of the dnote server after the change of the
+// given usn, such as to load an account export taken at that usn
+func USNCursor(usn int) Cursor {
+	return encodeUSN(usn)
+}
+
+func encodeUSN(usn int) Cursor {
+	return Cursor(strconv.Itoa(usn))
+}
+
+// decodeUSN returns the usn of the cursor. A nil cursor is the usn 0.
+func decodeUSN(c Cursor) (int, error) {
+	if len(c) == 0 {
+		return 0, nil
+	}
+
+	usn, err := strconv.Atoi(string(c))
+	if err != nil {
+		return 0, errors.Errorf("invalid cursor '%s'", c)
+	}
+
+	return usn, nil
+}
+
+func (t httpTransport) State(c stdCtx.Context) (State, error) {
+	resp, err := client.GetSyncState(t.with(c))
+	if err != nil {
+		return State{}, err
+	}
+
+	return State{
+		FullSyncBefore: resp.FullSyncBefore,
+		Cursor:         encodeUSN(resp.MaxUSN),
+		CurrentTime:    resp.CurrentTime,
+		MinVersion:     resp.MinCLIVersion,
+		LatestVersion:  resp.LatestCLIVersion,
+	}, nil
+}
+
+func (t httpTransport) Changes(c stdCtx.Context, after Cursor) (Changes, error) {
+	return t.getFragment(c, after, client.GetSyncFragment)
+}
+
+// manifest gets the page of changes without the bodies of the notes and the
+// labels of the books
+func (t httpTransport) manifest(c stdCtx.Context, after Cursor) (Changes, error) {
+	return t.getFragment(c, after, client.GetSyncManifest)
+}
+
+func (t httpTransport) getFragment(c stdCtx.Context, after Cursor, get func(context.DnoteCtx, int) (client.GetSyncFragmentResp, error)) (Changes, error) {
+	afterUSN, err := decodeUSN(after)
+	if err != nil {
+		return Changes{}, err
+	}
+
+	resp, err := get(t.with(c), afterUSN)
+	if err != nil {
+		return Changes{}, err
+	}
+
+	frag := resp.Fragment
+	ret := Changes{
+		Notes:         frag.Notes,
+		Books:         frag.Books,
+		ExpungedNotes: frag.ExpungedNotes,
+		ExpungedBooks: frag.ExpungedBooks,
+		CurrentTime:   frag.CurrentTime,
+		Size:          resp.Size,
+		// an empty fragment marks the end
+		More: frag.FragMaxUSN != 0,
+		Next: encodeUSN(afterUSN),
+	}
+	if ret.More {
+		ret.Next = encodeUSN(frag.FragMaxUSN)
+	}
+
+	return ret, nil
+}
+
+// countChanges returns the number of the changes between the cursors, as every
+// change is assigned the next usn
+func (t httpTransport) countChanges(after, latest Cursor) (int, bool) {
+	afterUSN, err := decodeUSN(after)
+	if err != nil {
+		return 0, false
+	}
+	latestUSN, err := decodeUSN(latest)
+	if err != nil {
+		return 0, false
+	}
+
+	return latestUSN - afterUSN, true
+}
+
+// quota returns the limits of the account. A server that does not report them,
+// such as a self-hosted one, does not limit the account.
+func (t httpTransport) quota(c stdCtx.Context) (Quota, error) {
+	resp, err := client.GetLimits(t.with(c))
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return Quota{}, nil
+	}
+	if err != nil {
+		return Quota{}, err
+	}
+
+	return Quota{
+		MaxNotes: resp.MaxNotes,
+		Notes:    resp.NoteCount,
+		MaxBooks: resp.MaxBooks,
+		Books:    resp.BookCount,
+	}, nil
+}
+
+func (t httpTransport) book(c stdCtx.Context, uuid string) (client.SyncFragBook, bool, error) {
+	resp, err := client.GetBook(t.with(c), uuid)
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return client.SyncFragBook{}, false, nil
+	}
+	if err != nil {
+		return client.SyncFragBook{}, false, err
+	}
+
+	return client.SyncFragBook{
+		UUID:      resp.UUID,
+		USN:       resp.USN,
+		CreatedAt: resp.CreatedAt,
+		UpdatedAt: resp.UpdatedAt,
+		Label:     resp.Label,
+	}, true, nil
+}
+
+func (t httpTransport) Follows(after Cursor, m Mutation) bool {
+	afterUSN, err := decodeUSN(after)
+	if err != nil {
+		return false
+	}
+
+	return m.USN == afterUSN+1
+}
+
+func (t httpTransport) CreateBook(c stdCtx.Context, label string) (Mutation, error) {
+	resp, err := client.CreateBook(t.with(c), label)
+	if err != nil {
+		return Mutation{}, err
+	}
+
+	return Mutation{UUID: resp.Book.UUID, USN: resp.Book.USN, Cursor: encodeUSN(resp.Book.USN)}, nil
+}
+
+func (t httpTransport) UpdateBook(c stdCtx.Context, uuid, label string) (Mutation, error) {
+	resp, err := client.UpdateBook(t.with(c), label, uuid)
+	if err != nil {
+		return Mutation{}, err
+	}
+
+	return Mutation{UUID: resp.Book.UUID, USN: resp.Book.USN, Cursor: encodeUSN(resp.Book.USN)}, nil
+}
+
+func (t httpTransport) DeleteBook(c stdCtx.Context, uuid string) (Mutation, error) {
+	resp, err := client.DeleteBook(t.with(c), uuid)
+	if err != nil {
+		return Mutation{}, err
+	}
+
+	return Mutation{UUID: resp.Book.UUID, USN: resp.Book.USN, Cursor: encodeUSN(resp.Book.USN)}, nil
+}
+
+func (t httpTransport) CreateNote(c stdCtx.Context, bookUUID, body string) (Mutation, error) {
+	return t.createNoteOnce(c, bookUUID, body, "")
+}
+
+// createNoteOnce creates the note with the idempotency key, which the server
+// uses to create the note only once if it supports it
+func (t httpTransport) createNoteOnce(c stdCtx.Context, bookUUID, body, key string) (Mutation, error) {
+	resp, err := client.CreateNote(t.with(c), bookUUID, body, key)
+	if err != nil {
+		return Mutation{}, err
+	}
+
+	return Mutation{UUID: resp.Result.UUID, USN: resp.Result.USN, Cursor: encodeUSN(resp.Result.USN)}, nil
+}
+
+func (t httpTransport) UpdateNote(c stdCtx.Context, uuid, bookUUID, body string, public bool) (Mutation, error) {
+	resp, err := client.UpdateNote(t.with(c), uuid, bookUUID, body, public)
+	if err != nil {
+		return Mutation{}, err
+	}
+
+	return Mutation{UUID: resp.Result.UUID, USN: resp.Result.USN, Cursor: encodeUSN(resp.Result.USN)}, nil
+}
+
+func (t httpTransport) DeleteNote(c stdCtx.Context, uuid string) (Mutation, error) {
+	resp, err := client.DeleteNote(t.with(c), uuid)
+	if err != nil {
+		return Mutation{}, err
+	}
+
+	return Mutation{UUID: resp.Result.UUID, USN: resp.Result.USN, Cursor: encodeUSN(resp.Result.USN)}, nil
+}
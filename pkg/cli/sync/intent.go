@@ -0,0 +1,418 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	stdCtx "context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+// idempotentCreator is implemented by the transports that can send a key with
+// the creation of a note, by which the remote copy creates the note only once
+// however many times it is sent
+type idempotentCreator interface {
+	createNoteOnce(c stdCtx.Context, bookUUID, body, key string) (Mutation, error)
+}
+
+// uploadIntent is a note that a sync is creating on the remote copy. It is
+// recorded before the note is sent, so that the sync after an interrupted one
+// finds the note that was created instead of creating it again.
+type uploadIntent struct {
+	NoteUUID string
+	// Key is the idempotency key sent with the creation
+	Key      string
+	BookUUID string
+	// BodyHash is the hash of the body as it was sent
+	BodyHash string
+	// ServerUUID and ServerUSN are those of the created note, which are
+	// known once the remote copy responds
+	ServerUUID string
+	ServerUSN  int
+}
+
+// intentLog is the log of the upload intents. It is kept in a database of its
+// own so that every write to it is committed at once, even though the sync
+// that writes it is rolled back. A nil log records nothing.
+type intentLog struct {
+	db      *database.DB
+	intents map[string]uploadIntent
+	// unchecked are the uuids of the notes whose creation was not confirmed
+	// and has not been looked for in the changes of the remote copy
+	unchecked map[string]bool
+}
+
+const intentSchema = `CREATE TABLE IF NOT EXISTS upload_intents (
+	note_uuid text PRIMARY KEY,
+	key text NOT NULL,
+	book_uuid text NOT NULL,
+	body_hash text NOT NULL,
+	server_uuid text NOT NULL DEFAULT '',
+	server_usn integer NOT NULL DEFAULT 0
+)`
+
+// intentLogPath returns the path to the database of the upload intents
+func intentLogPath(ctx context.DnoteCtx) string {
+	return filepath.Join(ctx.Paths.Data, consts.DnoteDirName, consts.UploadIntentsFilename)
+}
+
+// openIntentLog opens the log of the upload intents in the data directory and
+// loads the intents left by the earlier syncs. It returns a nil log if the
+// context has no data directory.
+func openIntentLog(ctx context.DnoteCtx) (*intentLog, error) {
+	if ctx.Paths.Data == "" {
+		return nil, nil
+	}
+
+	path := intentLogPath(ctx)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(err, "creating the data directory")
+	}
+	db, err := database.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening the upload intent log")
+	}
+
+	l := &intentLog{db: db, intents: map[string]uploadIntent{}, unchecked: map[string]bool{}}
+	if err := l.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *intentLog) load() error {
+	if _, err := l.db.Exec(intentSchema); err != nil {
+		return errors.Wrap(err, "creating the upload intent table")
+	}
+
+	rows, err := l.db.Query("SELECT note_uuid, key, book_uuid, body_hash, server_uuid, server_usn FROM upload_intents")
+	if err != nil {
+		return errors.Wrap(err, "querying the upload intents")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var in uploadIntent
+		if err := rows.Scan(&in.NoteUUID, &in.Key, &in.BookUUID, &in.BodyHash, &in.ServerUUID, &in.ServerUSN); err != nil {
+			return errors.Wrap(err, "scanning an upload intent")
+		}
+
+		l.intents[in.NoteUUID] = in
+		if in.ServerUUID == "" {
+			l.unchecked[in.NoteUUID] = true
+		}
+	}
+
+	return errors.Wrap(rows.Err(), "reading the upload intents")
+}
+
+// Close closes the database of the log
+func (l *intentLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.db.Close()
+}
+
+// noteUUIDs returns the uuids of the local notes of the intents in order
+func (l *intentLog) noteUUIDs() []string {
+	ret := make([]string, 0, len(l.intents))
+	for uuid := range l.intents {
+		ret = append(ret, uuid)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// hashNoteBody returns the hash of the body of a note as it is sent
+func hashNoteBody(body string) string {
+	sum := sha256.Sum256([]byte(utils.NormalizeBody(body)))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// record records the intent to create the note before it is sent. The key of
+// the earlier intent for the note is reused if the note was not changed since,
+// so that a remote copy that got the earlier creation does not create the note
+// again.
+func (l *intentLog) record(note database.Note) (uploadIntent, error) {
+	if l == nil {
+		return uploadIntent{}, nil
+	}
+
+	in := uploadIntent{NoteUUID: note.UUID, BookUUID: note.BookUUID, BodyHash: hashNoteBody(note.Body)}
+	if prev, ok := l.intents[note.UUID]; ok && prev.BookUUID == in.BookUUID && prev.BodyHash == in.BodyHash {
+		in.Key = prev.Key
+	} else {
+		key, err := utils.GenerateUUID()
+		if err != nil {
+			return uploadIntent{}, errors.Wrap(err, "generating the idempotency key")
+		}
+		in.Key = key
+	}
+
+	if _, err := l.db.Exec("INSERT OR REPLACE INTO upload_intents (note_uuid, key, book_uuid, body_hash, server_uuid, server_usn) VALUES (?, ?, ?, ?, '', 0)",
+		in.NoteUUID, in.Key, in.BookUUID, in.BodyHash); err != nil {
+		return uploadIntent{}, errors.Wrapf(err, "recording the upload of the note %s", note.UUID)
+	}
+	l.intents[note.UUID] = in
+
+	return in, nil
+}
+
+// resolve records the note created on the remote copy for the local note
+func (l *intentLog) resolve(noteUUID, serverUUID string, serverUSN int) error {
+	if l == nil {
+		return nil
+	}
+
+	if _, err := l.db.Exec("UPDATE upload_intents SET server_uuid = ?, server_usn = ? WHERE note_uuid = ?", serverUUID, serverUSN, noteUUID); err != nil {
+		return errors.Wrapf(err, "recording the upload of the note %s", noteUUID)
+	}
+
+	in := l.intents[noteUUID]
+	in.ServerUUID = serverUUID
+	in.ServerUSN = serverUSN
+	l.intents[noteUUID] = in
+	delete(l.unchecked, noteUUID)
+
+	return nil
+}
+
+// remove removes the intent of the note
+func (l *intentLog) remove(noteUUID string) error {
+	if _, err := l.db.Exec("DELETE FROM upload_intents WHERE note_uuid = ?", noteUUID); err != nil {
+		return errors.Wrapf(err, "removing the upload intent of the note %s", noteUUID)
+	}
+
+	delete(l.intents, noteUUID)
+	delete(l.unchecked, noteUUID)
+
+	return nil
+}
+
+// checked marks the intents as looked for in the changes of the remote copy.
+// The notes of the ones that were not found were not created, and can be sent
+// again.
+func (l *intentLog) checked() {
+	if l == nil {
+		return
+	}
+
+	l.unchecked = map[string]bool{}
+}
+
+// isUnchecked reports whether the note might have been created on the remote
+// copy by an interrupted sync, which is not known until the changes of the
+// remote copy are got
+func (l *intentLog) isUnchecked(noteUUID string) bool {
+	return l != nil && l.unchecked[noteUUID]
+}
+
+// getUnsentNote returns the local note of the uuid if it was never sent
+func getUnsentNote(tx *database.DB, uuid string) (database.Note, bool, error) {
+	var n database.Note
+	err := tx.QueryRow("SELECT uuid, book_uuid, body, added_on, edited_on, public, deleted FROM notes WHERE uuid = ? AND usn = 0", uuid).
+		Scan(&n.UUID, &n.BookUUID, &n.Body, &n.AddedOn, &n.EditedOn, &n.Public, &n.Deleted)
+	if err == sql.ErrNoRows {
+		return n, false, nil
+	}
+	if err != nil {
+		return n, false, errors.Wrapf(err, "getting the note %s", uuid)
+	}
+
+	return n, true, nil
+}
+
+// noteExists reports whether a local note has the uuid
+func noteExists(tx *database.DB, uuid string) (bool, error) {
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", uuid).Scan(&count); err != nil {
+		return false, errors.Wrapf(err, "checking the note %s", uuid)
+	}
+
+	return count > 0, nil
+}
+
+// remapNote makes the local note the one created from it on the remote copy.
+// The note stays dirty if it was changed after it was sent, so that the change
+// is sent as an update.
+func remapNote(ctx context.DnoteCtx, tx *database.DB, note database.Note, in uploadIntent, serverUUID string, serverUSN int) error {
+	note.USN = serverUSN
+	note.Dirty = note.Deleted || hashNoteBody(note.Body) != in.BodyHash || note.BookUUID != in.BookUUID
+	if err := note.Update(tx); err != nil {
+		return errors.Wrap(err, "updating the note")
+	}
+
+	ctx.FileLogger.Info("uuid_remap", log.Fields{"kind": "note", "old_uuid": note.UUID, "new_uuid": serverUUID, "usn": serverUSN})
+	ctx.Logger.Verbosef("note %s: found on the server as %s (sent by an interrupted sync)\n", note.UUID, serverUUID)
+
+	if err := note.UpdateUUID(tx, serverUUID); err != nil {
+		return errors.Wrap(err, "updating note uuid")
+	}
+
+	return nil
+}
+
+// reconcileIntents settles the intents left by the earlier syncs before a sync
+// makes any change. The ones whose notes were sent by a sync that was then
+// committed are removed, and the notes of the ones that got a response from
+// the remote copy but were rolled back are made the remote notes.
+func reconcileIntents(ctx context.DnoteCtx, tx *database.DB, l *intentLog) error {
+	if l == nil {
+		return nil
+	}
+
+	for _, uuid := range l.noteUUIDs() {
+		in := l.intents[uuid]
+
+		note, ok, err := getUnsentNote(tx, uuid)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := l.remove(uuid); err != nil {
+				return err
+			}
+			continue
+		}
+		if in.ServerUUID == "" {
+			continue
+		}
+
+		exists, err := noteExists(tx, in.ServerUUID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err := l.remove(uuid); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := remapNote(ctx, tx, note, in, in.ServerUUID, in.ServerUSN); err != nil {
+			return errors.Wrap(err, "remapping a note sent by an interrupted sync")
+		}
+	}
+
+	return nil
+}
+
+// skipNote removes the note from the sync list as one that the local copy
+// already has
+func skipNote(list *syncList, uuid string) {
+	delete(list.Notes, uuid)
+	if list.SkippedNotes == nil {
+		list.SkippedNotes = map[string]struct{}{}
+	}
+	list.SkippedNotes[uuid] = struct{}{}
+}
+
+// reconcileList looks for the notes of the unconfirmed intents in the changes
+// of the remote copy. A note without an idempotency key support may have been
+// created by an interrupted sync that never got the response, and is found by
+// its body among the notes that are not known locally. The local note is then
+// made the remote one instead of being created again. The remote notes that
+// the local copy already has through the intents are removed from the list.
+func reconcileList(ctx context.DnoteCtx, tx *database.DB, l *intentLog, list *syncList) error {
+	if l == nil || len(l.intents) == 0 {
+		return nil
+	}
+
+	var hashes map[string]string
+	claimed := map[string]bool{}
+
+	for _, uuid := range l.noteUUIDs() {
+		in := l.intents[uuid]
+		if in.ServerUUID != "" {
+			if n, ok := list.Notes[in.ServerUUID]; ok && n.USN <= in.ServerUSN {
+				skipNote(list, in.ServerUUID)
+			}
+			continue
+		}
+
+		note, ok, err := getUnsentNote(tx, uuid)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if hashes == nil {
+			hashes = map[string]string{}
+			for serverUUID, n := range list.Notes {
+				if !n.Deleted {
+					hashes[serverUUID] = hashNoteBody(n.Body)
+				}
+			}
+		}
+
+		serverUUID, serverUSN := "", 0
+		for candidate, hash := range hashes {
+			if hash != in.BodyHash || claimed[candidate] {
+				continue
+			}
+			usn := list.Notes[candidate].USN
+			if serverUUID != "" && (usn > serverUSN || (usn == serverUSN && candidate > serverUUID)) {
+				continue
+			}
+
+			exists, err := noteExists(tx, candidate)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				serverUUID, serverUSN = candidate, usn
+			}
+		}
+		if serverUUID == "" {
+			continue
+		}
+		claimed[serverUUID] = true
+
+		// the match is recorded first so that it is not looked for again if
+		// this sync is rolled back too
+		if err := l.resolve(uuid, serverUUID, serverUSN); err != nil {
+			return err
+		}
+		if err := remapNote(ctx, tx, note, in, serverUUID, serverUSN); err != nil {
+			return errors.Wrap(err, "remapping a note sent by an interrupted sync")
+		}
+		skipNote(list, serverUUID)
+	}
+
+	return nil
+}
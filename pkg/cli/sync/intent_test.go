@@ -0,0 +1,345 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"bytes"
+	stdCtx "context"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+func TestIntentLog(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.DnoteCtx{Paths: context.Paths{Data: dir}}
+
+	l, err := openIntentLog(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the log").Error())
+	}
+
+	n1 := database.Note{UUID: "n1-uuid", BookUUID: "b1-uuid", Body: "n1 body"}
+	in1, err := l.record(n1)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "recording n1").Error())
+	}
+	n2 := database.Note{UUID: "n2-uuid", BookUUID: "b1-uuid", Body: "n2 body"}
+	in2, err := l.record(n2)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "recording n2").Error())
+	}
+	assert.NotEqual(t, in1.Key, "", "n1 key mismatch")
+	assert.NotEqual(t, in1.Key, in2.Key, "n2 key mismatch")
+	assert.Equal(t, in1.BodyHash, hashNoteBody("n1 body"), "n1 hash mismatch")
+
+	// an unchanged note keeps its key, and a changed one gets a new one
+	again, err := l.record(n1)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "recording n1 again").Error())
+	}
+	assert.Equal(t, again.Key, in1.Key, "unchanged key mismatch")
+	n2.Body = "n2 body edited"
+	changed, err := l.record(n2)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "recording the changed n2").Error())
+	}
+	assert.NotEqual(t, changed.Key, in2.Key, "changed key mismatch")
+
+	if err := l.resolve("n1-uuid", "s1-uuid", 7); err != nil {
+		t.Fatal(errors.Wrap(err, "resolving n1").Error())
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the log").Error())
+	}
+
+	// the intents are kept across the syncs
+	l, err = openIntentLog(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reopening the log").Error())
+	}
+	defer l.Close()
+
+	assert.DeepEqual(t, l.intents, map[string]uploadIntent{
+		"n1-uuid": {NoteUUID: "n1-uuid", Key: in1.Key, BookUUID: "b1-uuid", BodyHash: in1.BodyHash, ServerUUID: "s1-uuid", ServerUSN: 7},
+		"n2-uuid": {NoteUUID: "n2-uuid", Key: changed.Key, BookUUID: "b1-uuid", BodyHash: changed.BodyHash},
+	}, "intents mismatch")
+	assert.Equal(t, l.isUnchecked("n1-uuid"), false, "n1 unchecked mismatch")
+	assert.Equal(t, l.isUnchecked("n2-uuid"), true, "n2 unchecked mismatch")
+
+	if err := l.remove("n2-uuid"); err != nil {
+		t.Fatal(errors.Wrap(err, "removing n2").Error())
+	}
+	assert.Equal(t, len(l.intents), 1, "intent count mismatch")
+	assert.Equal(t, l.isUnchecked("n2-uuid"), false, "removed n2 unchecked mismatch")
+}
+
+func TestIntentLog_nil(t *testing.T) {
+	l, err := openIntentLog(context.DnoteCtx{})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the log").Error())
+	}
+
+	in, err := l.record(database.Note{UUID: "n1-uuid", Body: "n1 body"})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "recording").Error())
+	}
+	assert.Equal(t, in.Key, "", "key mismatch")
+	assert.Equal(t, l.resolve("n1-uuid", "s1-uuid", 1), nil, "resolve error mismatch")
+	assert.Equal(t, l.isUnchecked("n1-uuid"), false, "unchecked mismatch")
+	assert.Equal(t, l.Close(), nil, "close error mismatch")
+}
+
+var errCrash = errors.New("simulated crash")
+
+// crashingTransport simulates a sync killed while it creates the notes on the
+// server. It lets the given number of the creations through, and then fails
+// either before the next one is sent or after it is sent but before its
+// response is handled.
+type crashingTransport struct {
+	Transport
+	creates   int
+	afterSend bool
+}
+
+func (t *crashingTransport) CreateNote(c stdCtx.Context, bookUUID, body string) (Mutation, error) {
+	return t.createNoteOnce(c, bookUUID, body, "")
+}
+
+func (t *crashingTransport) createNoteOnce(c stdCtx.Context, bookUUID, body, key string) (Mutation, error) {
+	if t.creates == 0 && !t.afterSend {
+		return Mutation{}, errCrash
+	}
+
+	m, err := t.Transport.(idempotentCreator).createNoteOnce(c, bookUUID, body, key)
+	if t.creates == 0 {
+		return Mutation{}, errCrash
+	}
+	t.creates--
+
+	return m, err
+}
+
+// intentEnv is a local database in sync with a simulated server that has a
+// single book
+type intentEnv struct {
+	t        *testing.T
+	ctx      context.DnoteCtx
+	srv      *simServer
+	bookUUID string
+	out      *bytes.Buffer
+}
+
+func newIntentEnv(t *testing.T) *intentEnv {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Cache: dir, Config: dir, Data: dir}, nil)
+	t.Cleanup(func() { database.TeardownTestDB(t, ctx.DB) })
+	ctx.SessionKey = "someSessionKey"
+	out := &bytes.Buffer{}
+	ctx.Logger = log.NewLogger(out, ioutil.Discard)
+
+	srv := newSimServer(t, 10)
+	t.Cleanup(srv.close)
+	ctx.APIEndpoint = srv.server.URL
+
+	b := srv.createBook("js")
+	database.MustExec(t, "inserting the book", ctx.DB, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", b.uuid, b.label, b.usn, false)
+	database.MustExec(t, "inserting last max usn", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, b.usn)
+	database.MustExec(t, "inserting last sync at", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 1)
+
+	return &intentEnv{t: t, ctx: ctx, srv: srv, bookUUID: b.uuid, out: out}
+}
+
+func (e *intentEnv) addNote(uuid, body string) {
+	n := database.NewNote(uuid, e.bookUUID, body, 1, 0, 0, false, false, true)
+	if err := n.Insert(e.ctx.DB); err != nil {
+		e.t.Fatal(errors.Wrap(err, "inserting a local note").Error())
+	}
+}
+
+func (e *intentEnv) sync(tr Transport, dir direction) error {
+	return infra.WithTx(e.ctx.DB, func(tx *database.DB) error {
+		return performSync(e.ctx, tr, tx, false, dir, newPhaseTimer(time.Now), nil)
+	})
+}
+
+func (e *intentEnv) mustSync(tr Transport, dir direction) {
+	if err := e.sync(tr, dir); err != nil {
+		e.t.Fatal(errors.Wrap(err, "syncing").Error())
+	}
+}
+
+// crash runs a sync that is killed while creating the notes, and checks that
+// its changes are rolled back
+func (e *intentEnv) crash(creates int, afterSend bool) {
+	err := e.sync(&crashingTransport{Transport: NewHTTPTransport(e.ctx), creates: creates, afterSend: afterSend}, directionBoth)
+	if errors.Cause(err) != errCrash {
+		e.t.Fatalf("sync error mismatch: %v", err)
+	}
+}
+
+// localNotes returns the local notes as "uuid usn dirty body"
+func (e *intentEnv) localNotes() []string {
+	rows, err := e.ctx.DB.Query("SELECT uuid || ' ' || usn || ' ' || dirty || ' ' || body FROM notes ORDER BY uuid")
+	if err != nil {
+		e.t.Fatal(errors.Wrap(err, "querying notes").Error())
+	}
+	defer rows.Close()
+
+	var ret []string
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			e.t.Fatal(errors.Wrap(err, "scanning a note").Error())
+		}
+		ret = append(ret, row)
+	}
+
+	return ret
+}
+
+// serverNotes returns the notes on the server in the same form as localNotes
+func (e *intentEnv) serverNotes() []string {
+	e.srv.mu.Lock()
+	defer e.srv.mu.Unlock()
+
+	var ret []string
+	for _, n := range e.srv.notes {
+		if !n.deleted {
+			ret = append(ret, strings.Join([]string{n.uuid, strconv.Itoa(n.usn), "0", n.body}, " "))
+		}
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+func TestSync_crashAfterResponse(t *testing.T) {
+	e := newIntentEnv(t)
+	e.addNote("n1-uuid", "n1 body")
+	e.addNote("n2-uuid", "n2 body")
+
+	// n1 is created and its response handled before the sync is killed
+	e.crash(1, false)
+	assert.Equal(t, len(e.serverNotes()), 1, "server note count mismatch after the crash")
+	assert.DeepEqual(t, e.localNotes(), []string{"n1-uuid 0 1 n1 body", "n2-uuid 0 1 n2 body"}, "local notes mismatch after the crash")
+
+	e.mustSync(NewHTTPTransport(e.ctx), directionBoth)
+
+	server := e.serverNotes()
+	assert.Equal(t, len(server), 2, "server note count mismatch")
+	assert.DeepEqual(t, e.localNotes(), server, "local notes mismatch")
+}
+
+func TestSync_crashBeforeResponse(t *testing.T) {
+	testCases := []struct {
+		name       string
+		idempotent bool
+	}{
+		{name: "idempotent server", idempotent: true},
+		{name: "server without idempotency keys", idempotent: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newIntentEnv(t)
+			e.srv.idempotent = tc.idempotent
+			e.addNote("n1-uuid", "n1 body")
+
+			// n1 is created but the sync is killed before the response
+			e.crash(0, true)
+			assert.Equal(t, len(e.serverNotes()), 1, "server note count mismatch after the crash")
+
+			e.mustSync(NewHTTPTransport(e.ctx), directionBoth)
+
+			server := e.serverNotes()
+			assert.Equal(t, len(server), 1, "server note count mismatch")
+			assert.DeepEqual(t, e.localNotes(), server, "local notes mismatch")
+			assert.Equal(t, len(e.srv.keys), 1, "idempotency key count mismatch")
+		})
+	}
+}
+
+func TestSync_crashBeforeResponse_edited(t *testing.T) {
+	e := newIntentEnv(t)
+	e.addNote("n1-uuid", "n1 body")
+
+	e.crash(0, true)
+	database.MustExec(t, "editing n1", e.ctx.DB, "UPDATE notes SET body = ? WHERE uuid = ?", "n1 body edited", "n1-uuid")
+
+	e.mustSync(NewHTTPTransport(e.ctx), directionBoth)
+
+	// the created note is updated with the edit instead of being duplicated
+	server := e.serverNotes()
+	assert.Equal(t, len(server), 1, "server note count mismatch")
+	assert.Equal(t, strings.HasSuffix(server[0], " n1 body edited"), true, "server body mismatch")
+	assert.DeepEqual(t, e.localNotes(), server, "local notes mismatch")
+}
+
+func TestSync_crashBeforeResponse_pushOnly(t *testing.T) {
+	e := newIntentEnv(t)
+	e.addNote("n1-uuid", "n1 body")
+	e.addNote("n2-uuid", "n2 body")
+
+	// n1 is created but the sync is killed before the response
+	e.crash(0, true)
+
+	// a push-only sync does not get the changes to find n1 in, and holds it
+	// back while sending n2
+	e.mustSync(NewHTTPTransport(e.ctx), directionPush)
+	assert.Equal(t, len(e.serverNotes()), 2, "server note count mismatch after the push")
+	local := e.localNotes()
+	assert.Equal(t, local[len(local)-1], "n1-uuid 0 1 n1 body", "held note mismatch")
+	assert.Equal(t, strings.Contains(e.out.String(), "1 new notes were not uploaded"), true, "warning mismatch")
+
+	e.mustSync(NewHTTPTransport(e.ctx), directionBoth)
+
+	server := e.serverNotes()
+	assert.Equal(t, len(server), 2, "server note count mismatch")
+	assert.DeepEqual(t, e.localNotes(), server, "local notes mismatch")
+}
+
+func TestSync_intentRemoved(t *testing.T) {
+	e := newIntentEnv(t)
+	e.addNote("n1-uuid", "n1 body")
+
+	e.mustSync(NewHTTPTransport(e.ctx), directionBoth)
+	// the intent of the committed sync is removed by the next one
+	e.mustSync(NewHTTPTransport(e.ctx), directionBoth)
+
+	l, err := openIntentLog(e.ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the log").Error())
+	}
+	defer l.Close()
+
+	assert.Equal(t, len(l.intents), 0, "intent count mismatch")
+	assert.Equal(t, len(e.serverNotes()), 1, "server note count mismatch")
+}
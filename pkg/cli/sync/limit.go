@@ -0,0 +1,160 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	stdCtx "context"
+	"strconv"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+)
+
+// Limits caps the changes that a sync gets from the remote copy, such as on a
+// metered connection. A zero value is no limit.
+type Limits struct {
+	// MaxDownload is the number of bytes of the changes to download
+	MaxDownload int64
+	// MaxOps is the number of the changes to apply
+	MaxOps int
+}
+
+// StopReason is the limit that stopped a sync before it got all the changes
+type StopReason string
+
+const (
+	// StopDownloadCap is the reason of a sync that reached Limits.MaxDownload
+	StopDownloadCap StopReason = "download_cap"
+	// StopOpsCap is the reason of a sync that reached Limits.MaxOps
+	StopOpsCap StopReason = "ops_cap"
+	// StopTimeout is the reason of a sync that reached its total timeout
+	StopTimeout StopReason = "timeout"
+)
+
+// Message returns the human readable reason
+func (r StopReason) Message() string {
+	switch r {
+	case StopDownloadCap:
+		return i18n.T("sync.stop_download_cap")
+	case StopTimeout:
+		return i18n.T("sync.stop_timeout")
+	}
+
+	return i18n.T("sync.stop_ops_cap")
+}
+
+// limiter keeps count of the changes that a sync has got against its limits.
+// The pages are counted as a whole so that the sync stops at a cursor from
+// which the next one continues, which means that the last page can exceed a
+// limit.
+type limiter struct {
+	limits     Limits
+	downloaded int64
+	ops        int
+	// reason is the limit that was reached, or empty
+	reason StopReason
+	// remaining is the number of the changes left after the sync stopped,
+	// or -1 if it is unknown
+	remaining int
+}
+
+func newLimiter(l Limits) *limiter {
+	return &limiter{limits: l, remaining: -1}
+}
+
+// add counts the given page, and reports whether a limit has been reached
+func (l *limiter) add(ch Changes) bool {
+	if l == nil {
+		return false
+	}
+
+	l.downloaded += ch.Size
+	l.ops += len(ch.Notes) + len(ch.Books) + len(ch.ExpungedNotes) + len(ch.ExpungedBooks)
+
+	switch {
+	case l.limits.MaxDownload > 0 && l.downloaded >= l.limits.MaxDownload:
+		l.reason = StopDownloadCap
+	case l.limits.MaxOps > 0 && l.ops >= l.limits.MaxOps:
+		l.reason = StopOpsCap
+	}
+
+	return l.reason != ""
+}
+
+// stopped reports whether a limit has been reached
+func (l *limiter) stopped() bool {
+	return l != nil && l.reason != ""
+}
+
+// timeout stops the sync if ctx has reached its deadline, which is set by the
+// total timeout, and reports whether it has. The changes got and sent before
+// then are kept, rather than rolled back as when ctx is canceled.
+func (l *limiter) timeout(ctx context.DnoteCtx) bool {
+	if l == nil || ctx.Context().Err() != stdCtx.DeadlineExceeded {
+		return false
+	}
+
+	l.reason = StopTimeout
+
+	return true
+}
+
+// changeCounter is implemented by the transports that can count the changes
+// between two cursors
+type changeCounter interface {
+	countChanges(after, latest Cursor) (int, bool)
+}
+
+// countRemaining records the number of the changes after the last cursor up to
+// the latest one, if the transport can count them
+func (l *limiter) countRemaining(tr Transport, after, latest Cursor) {
+	c, ok := tr.(changeCounter)
+	if !ok {
+		return
+	}
+
+	if n, ok := c.countChanges(after, latest); ok && n >= 0 {
+		l.remaining = n
+	}
+}
+
+// formatCount formats the given number with the thousands separated by commas
+func formatCount(n int) string {
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+
+	s := strconv.Itoa(n)
+
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+
+	return s
+}
+
+// stopMessage returns the message for a sync that was stopped early with the
+// given number of the changes left, which is negative if it is unknown
+func stopMessage(reason StopReason, remaining int) string {
+	if remaining < 0 {
+		return i18n.T("sync.stopped_early_unknown", reason.Message())
+	}
+
+	return i18n.T("sync.stopped_early", reason.Message(), formatCount(remaining))
+}
@@ -0,0 +1,250 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/migrate"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// setupPages makes the server serve the given number of pages of two books each
+func setupPages(srv *testutils.MockServer, pages int) {
+	maxUSN := pages * 2
+
+	srv.SetSyncState(client.GetSyncStateResp{MaxUSN: maxUSN, CurrentTime: 1541108743})
+	for i := 0; i < pages; i++ {
+		after := i * 2
+
+		srv.SetFragment(after, client.SyncFragment{
+			FragMaxUSN:  after + 2,
+			UserMaxUSN:  maxUSN,
+			CurrentTime: 1541108743,
+			Books: []client.SyncFragBook{
+				{UUID: fmt.Sprintf("b%d-uuid", after+1), USN: after + 1, Label: fmt.Sprintf("b%d", after+1)},
+				{UUID: fmt.Sprintf("b%d-uuid", after+2), USN: after + 2, Label: fmt.Sprintf("b%d", after+2)},
+			},
+		})
+	}
+}
+
+func mustSyncWithLimiter(t *testing.T, ctx context.DnoteCtx, lim *limiter) {
+	if err := infra.WithTx(ctx.DB, func(tx *database.DB) error {
+		return performSync(ctx, NewHTTPTransport(ctx), tx, false, directionBoth, newPhaseTimer(time.Now), lim)
+	}); err != nil {
+		t.Fatal(errors.Wrap(err, "performing sync"))
+	}
+}
+
+func TestPerformSync_limits(t *testing.T) {
+	testCases := []struct {
+		limits            Limits
+		expectedReason    StopReason
+		expectedCursor    int
+		expectedRemaining int
+	}{
+		{
+			limits:            Limits{MaxOps: 3},
+			expectedReason:    StopOpsCap,
+			expectedCursor:    4,
+			expectedRemaining: 16,
+		},
+		{
+			limits:            Limits{MaxOps: 4},
+			expectedReason:    StopOpsCap,
+			expectedCursor:    4,
+			expectedRemaining: 16,
+		},
+		{
+			// every page exceeds the cap, but is still applied
+			limits:            Limits{MaxDownload: 1},
+			expectedReason:    StopDownloadCap,
+			expectedCursor:    2,
+			expectedRemaining: 18,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			srv := testutils.NewMockServer(t)
+			defer srv.Close()
+			setupPages(srv, 10)
+
+			ctx := context.InitTestCtx(t, paths, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			testutils.Login(t, &ctx)
+			ctx.APIEndpoint = srv.URL()
+
+			db := ctx.DB
+			database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+			database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 1541108742)
+			testutils.NewFixture(t, db).Book("local", testutils.With.Dirty())
+
+			// stop early
+			lim := newLimiter(tc.limits)
+			mustSyncWithLimiter(t, ctx, lim)
+
+			assert.Equal(t, lim.reason, tc.expectedReason, "reason mismatch")
+			assert.Equal(t, lim.remaining, tc.expectedRemaining, "remaining mismatch")
+
+			var lastMaxUSN, bookCount int
+			database.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+			database.MustScan(t, "counting the server books", db.QueryRow("SELECT count(*) FROM books WHERE usn > 0"), &bookCount)
+			assert.Equal(t, lastMaxUSN, tc.expectedCursor, "last max usn mismatch")
+			assert.Equal(t, bookCount, tc.expectedCursor, "book count mismatch")
+			// the local changes are sent only after all the changes are got
+			assert.DeepEqual(t, srv.CreatedBookLabels(), []string(nil), "created book labels mismatch")
+
+			// resume
+			mustSyncWithLimiter(t, ctx, nil)
+
+			database.MustScan(t, "counting the server books", db.QueryRow("SELECT count(*) FROM books WHERE usn > 0 AND label != ?", "local"), &bookCount)
+			assert.Equal(t, bookCount, 20, "book count after resuming mismatch")
+			assert.DeepEqual(t, srv.CreatedBookLabels(), []string{"local"}, "created book labels after resuming mismatch")
+		})
+	}
+}
+
+func TestRun_totalTimeout(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+	setupPages(srv, 10)
+	// every page takes a while, so that the sync gets only some of them in time
+	srv.Delay("GET", "/v3/sync/fragment", 100*time.Millisecond)
+
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting remote schema", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemRemoteSchema, len(migrate.RemoteSequence))
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 1541108742)
+	testutils.NewFixture(t, db).Book("local", testutils.With.Dirty())
+
+	// execute
+	timeout := 450 * time.Millisecond
+	start := time.Now()
+	report, err := Run(ctx, Options{Timeouts: &context.SyncTimeouts{TotalTimeout: timeout}})
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "syncing"))
+	}
+
+	// test
+	if elapsed < timeout || elapsed > timeout+time.Second {
+		t.Errorf("the sync stopped after %s rather than at the timeout of %s", elapsed, timeout)
+	}
+	assert.Equal(t, report.StoppedEarly, StopTimeout, "reason mismatch")
+	assert.Equal(t, report.UploadedBooks, 0, "uploaded books mismatch")
+
+	// the pages got in time are saved along with the cursor
+	var lastMaxUSN, bookCount int
+	database.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	database.MustScan(t, "counting the server books", db.QueryRow("SELECT count(*) FROM books WHERE usn > 0"), &bookCount)
+	if lastMaxUSN == 0 || lastMaxUSN >= 20 {
+		t.Errorf("last max usn %d should be between the first and the last page", lastMaxUSN)
+	}
+	assert.Equal(t, bookCount, lastMaxUSN, "book count mismatch")
+	assert.Equal(t, report.Remaining, 20-lastMaxUSN, "remaining mismatch")
+	assert.DeepEqual(t, srv.CreatedBookLabels(), []string(nil), "created book labels mismatch")
+
+	// resume without a timeout
+	if _, err := Run(ctx, Options{Timeouts: &context.SyncTimeouts{}}); err != nil {
+		t.Fatal(errors.Wrap(err, "resuming"))
+	}
+
+	database.MustScan(t, "counting the server books", db.QueryRow("SELECT count(*) FROM books WHERE usn > 0 AND label != ?", "local"), &bookCount)
+	assert.Equal(t, bookCount, 20, "book count after resuming mismatch")
+	assert.DeepEqual(t, srv.CreatedBookLabels(), []string{"local"}, "created book labels after resuming mismatch")
+}
+
+func TestRun_capUploadCounts(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+	setupPages(srv, 10)
+
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting remote schema", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemRemoteSchema, len(migrate.RemoteSequence))
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 1541108742)
+	f := testutils.NewFixture(t, db)
+	b := f.Book("local", testutils.With.Dirty())
+	f.Note(b, "local note", testutils.With.Dirty())
+
+	// execute
+	report, err := Run(ctx, Options{Limits: Limits{MaxOps: 3}})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "syncing"))
+	}
+
+	// test
+	// the cap stops the sync before the local changes are sent
+	assert.Equal(t, report.StoppedEarly, StopOpsCap, "reason mismatch")
+	assert.Equal(t, report.UploadedNotes, 0, "uploaded notes mismatch")
+	assert.Equal(t, report.UploadedBooks, 0, "uploaded books mismatch")
+	assert.DeepEqual(t, srv.CreatedBookLabels(), []string(nil), "created book labels mismatch")
+
+	// resume without a cap
+	report, err = Run(ctx, Options{})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "resuming"))
+	}
+
+	assert.Equal(t, report.StoppedEarly, StopReason(""), "reason after resuming mismatch")
+	assert.Equal(t, report.UploadedNotes, 1, "uploaded notes after resuming mismatch")
+	assert.Equal(t, report.UploadedBooks, 1, "uploaded books after resuming mismatch")
+}
+
+func TestFormatCount(t *testing.T) {
+	testCases := []struct {
+		n        int
+		expected string
+	}{
+		{0, "0"},
+		{17, "17"},
+		{999, "999"},
+		{1000, "1,000"},
+		{1432, "1,432"},
+		{1234567, "1,234,567"},
+		{-1432, "-1,432"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			assert.Equal(t, formatCount(tc.n), tc.expected, "result mismatch")
+		})
+	}
+}
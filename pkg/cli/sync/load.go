@@ -0,0 +1,64 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package sync
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// ErrAlreadySynced is an error for loading the changes of a remote copy into a
+// database that has synced before
+var ErrAlreadySynced = errors.New("this database has synced before, so only a sync can bring it up to date")
+
+// Load applies the changes of a remote copy that are got other than through a
+// transport, such as from an account export, as a full sync that keeps the
+// local notes and books. The cursor and the time of the changes are saved as
+// those of the last sync, so that the next sync gets only the changes after
+// them. It fails with ErrAlreadySynced if the database has synced before,
+// because the changes may be older than the ones it has.
+func Load(ctx context.DnoteCtx, ch Changes) error {
+	if err := infra.AcquireSyncLock(ctx); err != nil {
+		return errors.Wrap(err, "acquiring the sync lock")
+	}
+	defer func() {
+		if err := infra.ReleaseSyncLock(ctx); err != nil {
+			log.Errorf("%s\n", errors.Wrap(err, "releasing the sync lock").Error())
+		}
+	}()
+
+	return infra.WithTx(ctx.DB, func(tx *database.DB) error {
+		lastSyncAt, err := getLastSyncAt(tx)
+		if err != nil {
+			return errors.Wrap(err, "getting the last sync time")
+		}
+		if lastSyncAt != 0 {
+			return ErrAlreadySynced
+		}
+
+		list, err := processFragments([]Changes{ch})
+		if err != nil {
+			return errors.Wrap(err, "processing the changes")
+		}
+
+		return applyFullList(ctx, tx, &list)
+	})
+}
@@ -0,0 +1,113 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestLoad(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+	f := testutils.NewFixture(t, db)
+	local := f.Book("local", testutils.With.Dirty())
+
+	ch := Changes{
+		Books: []client.SyncFragBook{
+			{UUID: "b1-uuid", USN: 1, Label: "js"},
+			{UUID: "b2-uuid", USN: 4, Deleted: true},
+		},
+		Notes: []client.SyncFragNote{
+			{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 2, AddedOn: 1, Body: "n1 body", Public: true},
+			{UUID: "n2-uuid", BookUUID: "b1-uuid", USN: 3, AddedOn: 2, EditedOn: 3, Body: "n2 body"},
+			{UUID: "n3-uuid", BookUUID: "b1-uuid", USN: 5, Deleted: true},
+		},
+		Next:        USNCursor(5),
+		CurrentTime: 1541108743,
+	}
+
+	if err := Load(ctx, ch); err != nil {
+		t.Fatal(errors.Wrap(err, "loading"))
+	}
+
+	// test
+	b1 := f.MustGetBook("b1-uuid")
+	assert.Equal(t, b1.Label, "js", "b1 label mismatch")
+	assert.Equal(t, b1.USN, 1, "b1 usn mismatch")
+	assert.Equal(t, b1.Dirty, false, "b1 dirty mismatch")
+	assert.Equal(t, f.MustGetBook(local.UUID).Dirty, true, "local book dirty mismatch")
+
+	n2 := f.MustGetNote("n2-uuid")
+	assert.Equal(t, n2.Body, "n2 body", "n2 body mismatch")
+	assert.Equal(t, n2.USN, 3, "n2 usn mismatch")
+	assert.Equal(t, n2.EditedOn, int64(3), "n2 edited_on mismatch")
+	assert.Equal(t, n2.Dirty, false, "n2 dirty mismatch")
+	assert.Equal(t, f.MustGetNote("n1-uuid").Public, true, "n1 public mismatch")
+
+	var lastMaxUSN, lastSyncAt int
+	database.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	database.MustScan(t, "getting last sync at", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastSyncAt), &lastSyncAt)
+	assert.Equal(t, lastMaxUSN, 5, "last max usn mismatch")
+	assert.Equal(t, lastSyncAt, 1541108743, "last sync at mismatch")
+
+	t.Run("next sync continues after the changes", func(t *testing.T) {
+		srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 6, CurrentTime: 1541108744})
+		srv.SetFragment(5, client.SyncFragment{
+			FragMaxUSN:  6,
+			UserMaxUSN:  6,
+			CurrentTime: 1541108744,
+			Notes: []client.SyncFragNote{
+				{UUID: "n4-uuid", BookUUID: "b1-uuid", USN: 6, AddedOn: 4, Body: "n4 body"},
+			},
+		})
+
+		if err := infra.WithTx(db, func(tx *database.DB) error {
+			return performSync(ctx, NewHTTPTransport(ctx), tx, false, directionPull, newPhaseTimer(time.Now), nil)
+		}); err != nil {
+			t.Fatal(errors.Wrap(err, "syncing"))
+		}
+
+		assert.Equal(t, f.MustGetNote("n4-uuid").Body, "n4 body", "n4 body mismatch")
+		assert.Equal(t, f.MustCountNotes(), 4, "note count mismatch")
+	})
+
+	t.Run("already synced", func(t *testing.T) {
+		err := Load(ctx, ch)
+
+		assert.Equal(t, errors.Cause(err), ErrAlreadySynced, "error mismatch")
+	})
+}
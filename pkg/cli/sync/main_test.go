@@ -0,0 +1,83 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+var testDir = "../tmp"
+
+var paths context.Paths = context.Paths{
+	Home:   testDir,
+	Cache:  testDir,
+	Config: testDir,
+	Data:   testDir,
+}
+
+var dbPath = filepath.Join(testDir, "test.db")
+
+// TestMain clears the test directory, which is shared with the tests of the
+// other packages, before the tests run. The tests here create their databases
+// at paths such as ../tmp/.dnote, which the migrate tests leave behind as the
+// legacy dnote directory.
+func TestMain(m *testing.M) {
+	if err := os.RemoveAll(testDir); err != nil {
+		log.Print(errors.Wrap(err, "clearing the test directory").Error())
+		os.Exit(1)
+	}
+
+	os.Exit(m.Run())
+}
+
+// testSyncTime is the time of the syncs whose statements are prepared by
+// mustPrepareStmts
+const testSyncTime = int64(1541108743000000000)
+
+// mustPrepareStmts prepares the sync statements in the given transaction. The
+// statements are closed when the transaction ends.
+func mustPrepareStmts(t testing.TB, tx *database.DB) *stmtCache {
+	stmts, err := newStmtCache(tx, testSyncTime, "")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "preparing statements").Error())
+	}
+
+	return stmts
+}
+
+// mustGetActions returns the actions in the journal without their ids
+func mustGetActions(t testing.TB, db *database.DB) []database.Action {
+	actions, err := database.GetActions(db, database.ActionFilter{})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting actions").Error())
+	}
+
+	for i := range actions {
+		actions[i].ID = 0
+	}
+
+	return actions
+}
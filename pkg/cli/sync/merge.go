@@ -24,7 +24,11 @@ import (
 	"strings"
 
 	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/ui"
 	"github.com/dnote/dnote/pkg/cli/utils"
 	"github.com/dnote/dnote/pkg/cli/utils/diff"
 	"github.com/pkg/errors"
@@ -155,6 +159,94 @@ func getConflictsBookUUID(tx *database.DB) (string, error) {
 	return ret, nil
 }
 
+// preferServerNote decides if the server copy of a note replaces the local copy
+// that was changed locally, according to the given conflict strategy. An empty
+// strategy is newer.
+func preferServerNote(strategy string, localNote database.Note, serverNote client.SyncFragNote) bool {
+	switch strategy {
+	case consts.ConflictStrategyLocal:
+		return false
+	case consts.ConflictStrategyServer:
+		return true
+	default:
+		return serverNote.EditedOn > localNote.EditedOn
+	}
+}
+
+// labelPolicy decides which of the books is renamed when a downloaded book has
+// the label of another local book
+type labelPolicy struct {
+	// policy is one of the consts.DuplicateLabel values. The local book is
+	// renamed if it is empty.
+	policy string
+	// listNotes is the number of the notes that are downloaded with each book
+	listNotes map[string]int
+	// choose asks the user which of the books to rename, given the numbers
+	// of their notes, and reports whether it is the downloaded one
+	choose func(label string, localNotes, incomingNotes int) (bool, error)
+}
+
+// newLabelPolicy returns the policy for the duplicate labels in the context,
+// for a sync that downloads the given notes
+func newLabelPolicy(ctx context.DnoteCtx, notes map[string]client.SyncFragNote) labelPolicy {
+	listNotes := map[string]int{}
+	for _, n := range notes {
+		if !n.Deleted {
+			listNotes[n.BookUUID]++
+		}
+	}
+
+	return labelPolicy{policy: ctx.SyncDuplicateLabelPolicy, listNotes: listNotes, choose: chooseRenamedBook}
+}
+
+// renameIncoming reports whether the downloaded book is renamed instead of the
+// local book with its label. The policy that prompts renames the local book in
+// the non-interactive mode.
+func (p labelPolicy) renameIncoming(tx *database.DB, b client.SyncFragBook) (bool, error) {
+	switch p.policy {
+	case consts.DuplicateLabelRenameIncoming:
+		return true, nil
+	case consts.DuplicateLabelPrompt:
+		if !ui.IsInteractive() {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+
+	var localNotes int
+	if err := tx.QueryRow(`SELECT count(*) FROM notes
+		WHERE book_uuid IN (SELECT uuid FROM books WHERE label = ? AND uuid != ?) AND NOT deleted`, b.Label, b.UUID).Scan(&localNotes); err != nil {
+		return false, errors.Wrapf(err, "counting the notes of the local book %s", b.Label)
+	}
+
+	// the notes of the downloaded book are either downloaded with it or
+	// already local
+	var incomingNotes int
+	if err := tx.QueryRow("SELECT count(*) FROM notes WHERE book_uuid = ? AND NOT deleted", b.UUID).Scan(&incomingNotes); err != nil {
+		return false, errors.Wrapf(err, "counting the notes of the book %s", b.UUID)
+	}
+	if n := p.listNotes[b.UUID]; n > incomingNotes {
+		incomingNotes = n
+	}
+
+	return p.choose(b.Label, localNotes, incomingNotes)
+}
+
+// chooseRenamedBook asks the user which of the books with the same label to
+// rename, and reports whether it is the downloaded one
+func chooseRenamedBook(label string, localNotes, incomingNotes int) (bool, error) {
+	idx, err := ui.PromptSelect(i18n.T("sync.duplicate_label", label), "", []string{
+		i18n.T("sync.duplicate_label_local", localNotes),
+		i18n.T("sync.duplicate_label_incoming", incomingNotes),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return idx == 1, nil
+}
+
 // noteMergeReport holds the result of a field-by-field merge of two copies of notes
 type noteMergeReport struct {
 	body     string
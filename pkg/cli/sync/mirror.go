@@ -0,0 +1,416 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// The kinds of the resources pushed to the mirrors
+const (
+	mirrorKindBook = "book"
+	mirrorKindNote = "note"
+)
+
+// The operations pushed to the mirrors
+const (
+	mirrorOpCreate = "create"
+	mirrorOpUpdate = "update"
+	mirrorOpDelete = "delete"
+)
+
+// mirrorChange is a change to push to a mirror. The uuid is that of the
+// resource on the server, and the content is read when the change is pushed
+// so that the mirror receives the latest copy.
+type mirrorChange struct {
+	Kind string `json:"kind"`
+	Op   string `json:"op"`
+	UUID string `json:"uuid"`
+}
+
+// mirrorState is what is kept in the system table for each mirror: the
+// changes yet to be pushed, in order, and the uuids of the resources on the
+// mirror by their uuids on the server, because the mirror assigns its own
+// uuids to the resources created on it
+type mirrorState struct {
+	Pending []mirrorChange    `json:"pending"`
+	UUIDs   map[string]string `json:"uuids"`
+}
+
+// add queues the change, merging it with the pending changes to the same
+// resource. A pending creation or update already pushes the latest copy,
+// and a deletion cancels them, or drops out altogether if the resource has
+// never reached the mirror.
+func (s *mirrorState) add(c mirrorChange) {
+	if c.Op == mirrorOpDelete {
+		kept := []mirrorChange{}
+		created := false
+		for _, p := range s.Pending {
+			if p.Kind == c.Kind && p.UUID == c.UUID {
+				created = created || p.Op == mirrorOpCreate
+				continue
+			}
+
+			kept = append(kept, p)
+		}
+		s.Pending = kept
+
+		if created && s.UUIDs[c.UUID] == "" {
+			return
+		}
+	} else {
+		for _, p := range s.Pending {
+			if p.Kind == c.Kind && p.UUID == c.UUID {
+				return
+			}
+		}
+	}
+
+	s.Pending = append(s.Pending, c)
+}
+
+func mirrorStateKey(endpoint string) string {
+	return consts.SystemMirrorStatePrefix + endpoint
+}
+
+func readMirrorState(db *database.DB, endpoint string) (mirrorState, error) {
+	ret := mirrorState{UUIDs: map[string]string{}}
+
+	var val string
+	err := database.GetSystem(db, mirrorStateKey(endpoint), &val)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return ret, nil
+	} else if err != nil {
+		return ret, errors.Wrap(err, "getting the mirror state")
+	}
+
+	if err := json.Unmarshal([]byte(val), &ret); err != nil {
+		return ret, errors.Wrap(err, "unmarshalling the mirror state")
+	}
+	if ret.UUIDs == nil {
+		ret.UUIDs = map[string]string{}
+	}
+
+	return ret, nil
+}
+
+func writeMirrorState(db *database.DB, endpoint string, s mirrorState) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "marshalling the mirror state")
+	}
+
+	if err := database.UpsertSystem(db, mirrorStateKey(endpoint), string(b)); err != nil {
+		return errors.Wrap(err, "saving the mirror state")
+	}
+
+	return nil
+}
+
+// dirtyRecord is a resource changed locally, found by its rowid because a
+// new resource gets the uuid from the server during the sync
+type dirtyRecord struct {
+	kind  string
+	op    string
+	rowID int
+	uuid  string
+}
+
+// getDirtyRecords returns the local changes to push to the mirrors after the
+// sync, if there are any mirrors. The books are created and updated before
+// the notes that may be in them, and deleted after the notes are moved out.
+func getDirtyRecords(ctx context.DnoteCtx, tx *database.DB) ([]dirtyRecord, error) {
+	if len(ctx.SyncMirrors) == 0 {
+		return nil, nil
+	}
+
+	query := func(kind, table, cond string) ([]dirtyRecord, error) {
+		rows, err := tx.Query("SELECT rowid, uuid, usn, deleted FROM " + table + " WHERE dirty AND " + cond + " ORDER BY rowid")
+		if err != nil {
+			return nil, errors.Wrapf(err, "querying dirty %s", table)
+		}
+		defer rows.Close()
+
+		ret := []dirtyRecord{}
+		for rows.Next() {
+			r := dirtyRecord{kind: kind}
+			var usn int
+			var deleted bool
+			if err := rows.Scan(&r.rowID, &r.uuid, &usn, &deleted); err != nil {
+				return nil, errors.Wrap(err, "scanning a row")
+			}
+
+			switch {
+			case usn == 0 && deleted:
+				// never synced, so it is expunged without reaching the server
+				continue
+			case usn == 0:
+				r.op = mirrorOpCreate
+			case deleted:
+				r.op = mirrorOpDelete
+			default:
+				r.op = mirrorOpUpdate
+			}
+
+			ret = append(ret, r)
+		}
+
+		return ret, rows.Err()
+	}
+
+	books, err := query(mirrorKindBook, "books", "NOT deleted")
+	if err != nil {
+		return nil, err
+	}
+	notes, err := query(mirrorKindNote, "notes", "true")
+	if err != nil {
+		return nil, err
+	}
+	deletedBooks, err := query(mirrorKindBook, "books", "deleted")
+	if err != nil {
+		return nil, err
+	}
+
+	ret := append(books, notes...)
+	return append(ret, deletedBooks...), nil
+}
+
+// queueMirrorChanges adds the local changes, which have just been synced, to
+// the pending changes of each mirror. It is called in the transaction of the
+// sync, so that the changes are queued if and only if the sync succeeds.
+func queueMirrorChanges(ctx context.DnoteCtx, tx *database.DB, records []dirtyRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	changes := []mirrorChange{}
+	for _, r := range records {
+		c := mirrorChange{Kind: r.kind, Op: r.op, UUID: r.uuid}
+
+		// a new resource has the uuid from the server by now
+		if r.op == mirrorOpCreate {
+			table := "books"
+			if r.kind == mirrorKindNote {
+				table = "notes"
+			}
+
+			err := tx.QueryRow("SELECT uuid FROM "+table+" WHERE rowid = ?", r.rowID).Scan(&c.UUID)
+			if err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				return errors.Wrapf(err, "getting the uuid of %s %s", r.kind, r.uuid)
+			}
+		}
+
+		changes = append(changes, c)
+	}
+
+	for _, m := range ctx.SyncMirrors {
+		s, err := readMirrorState(tx, m.Endpoint)
+		if err != nil {
+			return errors.Wrapf(err, "reading the state of the mirror %s", m.Endpoint)
+		}
+
+		for _, c := range changes {
+			s.add(c)
+		}
+
+		if err := writeMirrorState(tx, m.Endpoint, s); err != nil {
+			return errors.Wrapf(err, "writing the state of the mirror %s", m.Endpoint)
+		}
+	}
+
+	return nil
+}
+
+// mirrorBookUUID returns the uuid on the mirror of the book with the given
+// uuid on the server, creating the book on the mirror if it is not there yet
+func mirrorBookUUID(ctx context.DnoteCtx, s *mirrorState, bookUUID string) (string, error) {
+	if uuid := s.UUIDs[bookUUID]; uuid != "" {
+		return uuid, nil
+	}
+
+	var label string
+	if err := ctx.DB.QueryRow("SELECT label FROM books WHERE uuid = ?", bookUUID).Scan(&label); err != nil {
+		return "", errors.Wrapf(err, "finding the book %s", bookUUID)
+	}
+
+	resp, err := client.CreateBook(ctx, label)
+	if err != nil {
+		return "", errors.Wrap(err, "creating a book")
+	}
+	s.UUIDs[bookUUID] = resp.Book.UUID
+
+	return resp.Book.UUID, nil
+}
+
+// pushBook pushes the change to a book to the mirror
+func pushBook(ctx context.DnoteCtx, s *mirrorState, c mirrorChange) error {
+	mirrorUUID := s.UUIDs[c.UUID]
+
+	if c.Op == mirrorOpDelete {
+		if mirrorUUID == "" {
+			return nil
+		}
+
+		if _, err := client.DeleteBook(ctx, mirrorUUID); err != nil {
+			return errors.Wrap(err, "deleting a book")
+		}
+		delete(s.UUIDs, c.UUID)
+
+		return nil
+	}
+
+	var label string
+	err := ctx.DB.QueryRow("SELECT label FROM books WHERE uuid = ? AND NOT deleted", c.UUID).Scan(&label)
+	if err == sql.ErrNoRows {
+		// removed since, which queues a deletion
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "finding the book")
+	}
+
+	if mirrorUUID == "" {
+		_, err := mirrorBookUUID(ctx, s, c.UUID)
+		return err
+	}
+
+	if _, err := client.UpdateBook(ctx, label, mirrorUUID); err != nil {
+		return errors.Wrap(err, "updating a book")
+	}
+
+	return nil
+}
+
+// pushNote pushes the change to a note to the mirror
+func pushNote(ctx context.DnoteCtx, s *mirrorState, c mirrorChange) error {
+	mirrorUUID := s.UUIDs[c.UUID]
+
+	if c.Op == mirrorOpDelete {
+		if mirrorUUID == "" {
+			return nil
+		}
+
+		if _, err := client.DeleteNote(ctx, mirrorUUID); err != nil {
+			return errors.Wrap(err, "deleting a note")
+		}
+		delete(s.UUIDs, c.UUID)
+
+		return nil
+	}
+
+	var bookUUID, body string
+	var public bool
+	err := ctx.DB.QueryRow("SELECT book_uuid, body, public FROM notes WHERE uuid = ? AND NOT deleted", c.UUID).Scan(&bookUUID, &body, &public)
+	if err == sql.ErrNoRows {
+		// removed since, which queues a deletion
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "finding the note")
+	}
+
+	mirrorBook, err := mirrorBookUUID(ctx, s, bookUUID)
+	if err != nil {
+		return err
+	}
+
+	if mirrorUUID == "" {
+		resp, err := client.CreateNote(ctx, mirrorBook, body, "")
+		if err != nil {
+			return errors.Wrap(err, "creating a note")
+		}
+		s.UUIDs[c.UUID] = resp.Result.UUID
+
+		if !public {
+			return nil
+		}
+		mirrorUUID = resp.Result.UUID
+	}
+
+	if _, err := client.UpdateNote(ctx, mirrorUUID, mirrorBook, body, public); err != nil {
+		return errors.Wrap(err, "updating a note")
+	}
+
+	return nil
+}
+
+// pushMirror pushes the pending changes to the mirror in order. The state is
+// saved after each change so that the changes pushed before a failure are
+// not pushed again.
+func pushMirror(ctx context.DnoteCtx, m context.SyncMirror) (int, error) {
+	s, err := readMirrorState(ctx.DB, m.Endpoint)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading the mirror state")
+	}
+
+	mctx := ctx
+	mctx.APIEndpoint = m.Endpoint
+	mctx.SessionKey = m.APIKey
+
+	count := 0
+	for len(s.Pending) > 0 {
+		c := s.Pending[0]
+
+		var err error
+		if c.Kind == mirrorKindBook {
+			err = pushBook(mctx, &s, c)
+		} else {
+			err = pushNote(mctx, &s, c)
+		}
+		if err != nil {
+			if writeErr := writeMirrorState(ctx.DB, m.Endpoint, s); writeErr != nil {
+				log.Debug("saving the mirror state: %s\n", writeErr)
+			}
+
+			return count, errors.Wrapf(err, "%s %s", c.Kind, c.UUID)
+		}
+
+		s.Pending = s.Pending[1:]
+		count++
+
+		if err := writeMirrorState(ctx.DB, m.Endpoint, s); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// pushMirrors pushes the pending changes to each mirror. A mirror that
+// fails keeps its pending changes for the next sync, and does not fail the
+// sync, which has already succeeded.
+func pushMirrors(ctx context.DnoteCtx) {
+	for _, m := range ctx.SyncMirrors {
+		count, err := pushMirror(ctx, m)
+		if err != nil {
+			ctx.Logger.Warnf("%s\n", errors.Wrapf(err, "pushing to the mirror %s", m.Endpoint).Error())
+			continue
+		}
+
+		ctx.Logger.Verbosef("pushed %d changes to the mirror %s\n", count, m.Endpoint)
+	}
+}
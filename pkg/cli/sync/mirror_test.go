@@ -0,0 +1,272 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+func TestMirrorStateAdd(t *testing.T) {
+	testCases := []struct {
+		name     string
+		uuids    map[string]string
+		pending  []mirrorChange
+		change   mirrorChange
+		expected []mirrorChange
+	}{
+		{
+			name:     "new",
+			pending:  []mirrorChange{{Kind: mirrorKindBook, Op: mirrorOpCreate, UUID: "b1"}},
+			change:   mirrorChange{Kind: mirrorKindNote, Op: mirrorOpCreate, UUID: "n1"},
+			expected: []mirrorChange{{Kind: mirrorKindBook, Op: mirrorOpCreate, UUID: "b1"}, {Kind: mirrorKindNote, Op: mirrorOpCreate, UUID: "n1"}},
+		},
+		{
+			name:     "update after a pending creation",
+			pending:  []mirrorChange{{Kind: mirrorKindNote, Op: mirrorOpCreate, UUID: "n1"}},
+			change:   mirrorChange{Kind: mirrorKindNote, Op: mirrorOpUpdate, UUID: "n1"},
+			expected: []mirrorChange{{Kind: mirrorKindNote, Op: mirrorOpCreate, UUID: "n1"}},
+		},
+		{
+			name:     "same uuid of another kind",
+			pending:  []mirrorChange{{Kind: mirrorKindBook, Op: mirrorOpUpdate, UUID: "x"}},
+			change:   mirrorChange{Kind: mirrorKindNote, Op: mirrorOpUpdate, UUID: "x"},
+			expected: []mirrorChange{{Kind: mirrorKindBook, Op: mirrorOpUpdate, UUID: "x"}, {Kind: mirrorKindNote, Op: mirrorOpUpdate, UUID: "x"}},
+		},
+		{
+			name:     "deletion after a pending creation",
+			pending:  []mirrorChange{{Kind: mirrorKindNote, Op: mirrorOpCreate, UUID: "n1"}, {Kind: mirrorKindNote, Op: mirrorOpCreate, UUID: "n2"}},
+			change:   mirrorChange{Kind: mirrorKindNote, Op: mirrorOpDelete, UUID: "n1"},
+			expected: []mirrorChange{{Kind: mirrorKindNote, Op: mirrorOpCreate, UUID: "n2"}},
+		},
+		{
+			name:     "deletion after a pending update",
+			uuids:    map[string]string{"n1": "mirror-n1"},
+			pending:  []mirrorChange{{Kind: mirrorKindNote, Op: mirrorOpUpdate, UUID: "n1"}},
+			change:   mirrorChange{Kind: mirrorKindNote, Op: mirrorOpDelete, UUID: "n1"},
+			expected: []mirrorChange{{Kind: mirrorKindNote, Op: mirrorOpDelete, UUID: "n1"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := mirrorState{Pending: tc.pending, UUIDs: tc.uuids}
+			s.add(tc.change)
+
+			assert.DeepEqual(t, s.Pending, tc.expected, "pending mismatch")
+		})
+	}
+}
+
+// mirrorSync syncs with the server and pushes to the mirrors as the sync
+// command does
+func mirrorSync(t *testing.T, ctx context.DnoteCtx) {
+	err := infra.WithTx(ctx.DB, func(tx *database.DB) error {
+		return serverBackend{}.sync(ctx, tx, newPhaseTimer(time.Now))
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "syncing"))
+	}
+
+	pushMirrors(ctx)
+}
+
+// assertMirrored asserts that the mirror has the local books and notes,
+// and that the uuids of the mirror state map the local ones to them
+func assertMirrored(t *testing.T, ctx context.DnoteCtx, endpoint string, mirror *simServer) {
+	s, err := readMirrorState(ctx.DB, endpoint)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the mirror state"))
+	}
+	assert.Equal(t, len(s.Pending), 0, "pending count mismatch")
+
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+
+	mirrorBooks := 0
+	for _, b := range mirror.books {
+		if !b.deleted {
+			mirrorBooks++
+		}
+	}
+	mirrorNotes := 0
+	for _, n := range mirror.notes {
+		if !n.deleted {
+			mirrorNotes++
+		}
+	}
+
+	books, err := ctx.DB.Query("SELECT uuid, label FROM books WHERE NOT deleted")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "querying books"))
+	}
+	defer books.Close()
+
+	localBooks := 0
+	for books.Next() {
+		var uuid, label string
+		if err := books.Scan(&uuid, &label); err != nil {
+			t.Fatal(errors.Wrap(err, "scanning a book"))
+		}
+		localBooks++
+
+		b, ok := mirror.books[s.UUIDs[uuid]]
+		if !ok || b.deleted {
+			t.Fatalf("book %s is not on the mirror", uuid)
+		}
+		assert.Equal(t, b.label, label, "book label mismatch")
+	}
+
+	notes, err := ctx.DB.Query("SELECT uuid, book_uuid, body, public FROM notes WHERE NOT deleted")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "querying notes"))
+	}
+	defer notes.Close()
+
+	localNotes := 0
+	for notes.Next() {
+		var uuid, bookUUID, body string
+		var public bool
+		if err := notes.Scan(&uuid, &bookUUID, &body, &public); err != nil {
+			t.Fatal(errors.Wrap(err, "scanning a note"))
+		}
+		localNotes++
+
+		n, ok := mirror.notes[s.UUIDs[uuid]]
+		if !ok || n.deleted {
+			t.Fatalf("note %s is not on the mirror", uuid)
+		}
+		assert.Equal(t, n.bookUUID, s.UUIDs[bookUUID], "note book mismatch")
+		assert.Equal(t, n.body, body, "note body mismatch")
+		assert.Equal(t, n.public, public, "note public mismatch")
+	}
+
+	assert.Equal(t, mirrorBooks, localBooks, "mirror book count mismatch")
+	assert.Equal(t, mirrorNotes, localNotes, "mirror note count mismatch")
+}
+
+func TestSync_mirrors(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Cache: dir, Config: dir, Data: dir}, nil)
+	defer database.TeardownTestDB(t, ctx.DB)
+	ctx.SessionKey = "someSessionKey"
+
+	var out bytes.Buffer
+	ctx.Logger = log.NewLogger(&out, &bytes.Buffer{})
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+
+	primary := newSimServer(t, 100)
+	defer primary.close()
+	ctx.APIEndpoint = primary.server.URL
+
+	mirror1 := newSimServer(t, 100)
+	defer mirror1.close()
+
+	// the second mirror fails every request while it is down
+	mirror2 := newSimServer(t, 100)
+	defer mirror2.close()
+	var mirror2Down int32 = 1
+	mirror2Proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&mirror2Down) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		mirror2.handle(w, r)
+	}))
+	defer mirror2Proxy.Close()
+
+	ctx.SyncMirrors = []context.SyncMirror{
+		{Endpoint: mirror1.server.URL, APIKey: "mirror1-key"},
+		{Endpoint: mirror2Proxy.URL, APIKey: "mirror2-key"},
+	}
+
+	// creations
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b1-uuid", "js", 0, true)
+	database.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b2-uuid", "css", 0, true)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 0, "n1 body", 1541108743, true)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, public, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "b2-uuid", 0, "n2 body", 1541108743, true, true)
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n3-uuid", "b2-uuid", 0, "n3 body", 1541108743, true)
+
+	mirrorSync(t, ctx)
+
+	assert.Equal(t, len(primary.notes), 3, "primary note count mismatch")
+	assertMirrored(t, ctx, mirror1.server.URL, mirror1)
+	assert.Equal(t, strings.Contains(out.String(), "pushing to the mirror "+mirror2Proxy.URL), true, "no warning for the mirror that is down")
+	assert.Equal(t, len(mirror2.notes), 0, "mirror2 received notes while down")
+
+	s2, err := readMirrorState(db, mirror2Proxy.URL)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the state of mirror2"))
+	}
+	assert.Equal(t, len(s2.Pending), 5, "mirror2 pending count mismatch")
+
+	// updates and deletions while mirror2 is still down
+	var n1UUID, n3UUID, b1UUID string
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT uuid FROM notes WHERE body = ?", "n1 body"), &n1UUID)
+	database.MustScan(t, "getting n3", db.QueryRow("SELECT uuid FROM notes WHERE body = ?", "n3 body"), &n3UUID)
+	database.MustScan(t, "getting b1", db.QueryRow("SELECT uuid FROM books WHERE label = ?", "js"), &b1UUID)
+	n3MirrorUUID := mirrorUUIDOf(t, ctx, mirror1.server.URL, n3UUID)
+	database.MustExec(t, "editing n1", db, "UPDATE notes SET body = ?, dirty = ? WHERE uuid = ?", "n1 body edited", true, n1UUID)
+	database.MustExec(t, "removing n3", db, "UPDATE notes SET deleted = ?, body = ?, dirty = ? WHERE uuid = ?", true, "", true, n3UUID)
+	database.MustExec(t, "renaming b1", db, "UPDATE books SET label = ?, dirty = ? WHERE uuid = ?", "javascript", true, b1UUID)
+
+	mirrorSync(t, ctx)
+
+	assertMirrored(t, ctx, mirror1.server.URL, mirror1)
+	assert.Equal(t, mirror1.notes[n3MirrorUUID].deleted, true, "n3 was not deleted on mirror1")
+	assert.Equal(t, mirrorUUIDOf(t, ctx, mirror1.server.URL, n3UUID), "", "n3 uuid mismatch")
+
+	// mirror2 comes back up and receives everything
+	atomic.StoreInt32(&mirror2Down, 0)
+	mirrorSync(t, ctx)
+
+	assertMirrored(t, ctx, mirror1.server.URL, mirror1)
+	assertMirrored(t, ctx, mirror2Proxy.URL, mirror2)
+
+	// n3 was created and deleted while mirror2 was down, so it never reached it
+	assert.Equal(t, len(mirror2.notes), 2, "mirror2 note count mismatch")
+}
+
+// mirrorUUIDOf returns the uuid on the mirror of the resource with the given
+// local uuid, or an empty string if there is none
+func mirrorUUIDOf(t *testing.T, ctx context.DnoteCtx, endpoint, uuid string) string {
+	s, err := readMirrorState(ctx.DB, endpoint)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the mirror state"))
+	}
+
+	return s.UUIDs[uuid]
+}
@@ -0,0 +1,152 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/log"
+)
+
+// The phases of a sync whose wall time is measured
+const (
+	phaseDownload = "download"
+	phaseApply    = "apply"
+	phaseUpload   = "upload"
+	phaseClean    = "clean"
+)
+
+var phases = []string{phaseDownload, phaseApply, phaseUpload, phaseClean}
+
+// Phase is the wall time spent in a phase of a sync
+type Phase struct {
+	// Name is "download", "apply", "upload" or "clean"
+	Name string
+	// Items is the number of the changes downloaded, or of the notes and the
+	// books uploaded. It is 0 for the other phases.
+	Items    int
+	Duration time.Duration
+}
+
+// phaseTimer accumulates the wall time spent in each phase of a sync, and the
+// number of the items handled in the phases that count them. The time is got
+// from now, such as the Now of the clock of the context, whose readings must
+// be monotonic.
+type phaseTimer struct {
+	now       func() time.Time
+	start     time.Time
+	end       time.Time
+	durations map[string]time.Duration
+	items     map[string]int
+	// uploadedNotes and uploadedBooks are the numbers of the new and the
+	// edited notes and books that were sent
+	uploadedNotes int
+	uploadedBooks int
+}
+
+func newPhaseTimer(now func() time.Time) *phaseTimer {
+	return &phaseTimer{
+		now:       now,
+		start:     now(),
+		durations: map[string]time.Duration{},
+		items:     map[string]int{},
+	}
+}
+
+// track starts measuring the given phase and returns a function that stops it
+func (t *phaseTimer) track(phase string) func() {
+	start := t.now()
+
+	return func() {
+		t.durations[phase] += t.now().Sub(start)
+	}
+}
+
+// count adds the given number of the items handled in the phase
+func (t *phaseTimer) count(phase string, n int) {
+	t.items[phase] += n
+}
+
+// countUploaded adds the numbers of the new and the edited notes and books
+// that were sent
+func (t *phaseTimer) countUploaded(notes, books int) {
+	t.uploadedNotes += notes
+	t.uploadedBooks += books
+}
+
+// stop marks the end of the sync
+func (t *phaseTimer) stop() {
+	t.end = t.now()
+}
+
+func (t *phaseTimer) total() time.Duration {
+	return t.end.Sub(t.start)
+}
+
+// summary returns a line describing where the wall time went
+func (t *phaseTimer) summary() string {
+	var parts []string
+	for _, phase := range phases {
+		parts = append(parts, fmt.Sprintf("%s %s", phase, t.durations[phase].Round(time.Millisecond)))
+	}
+
+	return i18n.T("sync.phase_summary", t.total().Round(time.Millisecond), strings.Join(parts, ", "))
+}
+
+// fields returns the durations in milliseconds and the numbers of the items
+// for the log file
+func (t *phaseTimer) fields() log.Fields {
+	ret := log.Fields{"total_ms": t.total().Milliseconds()}
+	for _, phase := range phases {
+		ret[phase+"_ms"] = t.durations[phase].Milliseconds()
+	}
+	ret["download_items"] = t.items[phaseDownload]
+	ret["upload_items"] = t.items[phaseUpload]
+
+	return ret
+}
+
+// phases returns the time spent in each phase, in the order of the phases
+func (t *phaseTimer) phases() []Phase {
+	ret := make([]Phase, 0, len(phases))
+	for _, phase := range phases {
+		ret = append(ret, Phase{Name: phase, Items: t.items[phase], Duration: t.durations[phase]})
+	}
+
+	return ret
+}
+
+// line returns the time spent in each phase and the numbers of the items for
+// the summary of the sync
+func (t *phaseTimer) line() string {
+	return i18n.T("sync.phase_line",
+		formatCount(t.items[phaseDownload]), formatSeconds(t.durations[phaseDownload]),
+		formatSeconds(t.durations[phaseApply]),
+		formatCount(t.items[phaseUpload]), formatSeconds(t.durations[phaseUpload]),
+		formatSeconds(t.durations[phaseClean]))
+}
+
+// formatSeconds formats a duration in seconds to a tenth of a second, such as
+// 3.2s
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
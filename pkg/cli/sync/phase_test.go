@@ -0,0 +1,68 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/clock"
+)
+
+func TestPhaseTimer(t *testing.T) {
+	c := clock.NewStepping(time.Date(2018, time.November, 1, 0, 0, 0, 0, time.UTC), 300*time.Millisecond)
+	timer := newPhaseTimer(c.Now)
+
+	stopDownload := timer.track(phaseDownload)
+	stopDownload()
+	stopApply := timer.track(phaseApply)
+	stopApply()
+	stopDownload = timer.track(phaseDownload)
+	stopDownload()
+	timer.count(phaseDownload, 1200)
+	timer.count(phaseDownload, 4)
+	timer.count(phaseUpload, 17)
+	timer.stop()
+
+	assert.Equal(t, timer.summary(), "took 2.1s (download 600ms, apply 300ms, upload 0s, clean 0s)", "summary mismatch")
+	assert.DeepEqual(t, timer.fields(), log.Fields{
+		"total_ms":       int64(2100),
+		"download_ms":    int64(600),
+		"apply_ms":       int64(300),
+		"upload_ms":      int64(0),
+		"clean_ms":       int64(0),
+		"download_items": 1204,
+		"upload_items":   17,
+	}, "fields mismatch")
+	assert.DeepEqual(t, timer.phases(), []Phase{
+		{Name: phaseDownload, Items: 1204, Duration: 600 * time.Millisecond},
+		{Name: phaseApply, Items: 0, Duration: 300 * time.Millisecond},
+		{Name: phaseUpload, Items: 17, Duration: 0},
+		{Name: phaseClean, Items: 0, Duration: 0},
+	}, "phases mismatch")
+	assert.Equal(t, timer.line(), "downloaded 1,204 items in 0.6s, applied in 0.3s, uploaded 17 items in 0.0s, cleaned in 0.0s\n", "line mismatch")
+}
+
+func TestFormatSeconds(t *testing.T) {
+	assert.Equal(t, formatSeconds(3200*time.Millisecond), "3.2s", "result mismatch")
+	assert.Equal(t, formatSeconds(4849*time.Millisecond), "4.8s", "result mismatch")
+	assert.Equal(t, formatSeconds(0), "0.0s", "result mismatch")
+}
@@ -0,0 +1,273 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	stdCtx "context"
+	"fmt"
+	"sort"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/pkg/errors"
+)
+
+// CleanItem is a note or a book in a preview of a full sync
+type CleanItem struct {
+	// Kind is "note" or "book"
+	Kind string
+	UUID string
+	// Description is the first line of the body of a note, or the label of a
+	// book. It can be empty for the ones only on the remote copy, whose
+	// contents are not downloaded by the preview.
+	Description string
+	// Unsynced reports whether the local note or book has changes that were
+	// not uploaded
+	Unsynced bool
+}
+
+// String describes the item to the user
+func (i CleanItem) String() string {
+	if i.Kind == "note" {
+		return describeNote(i.UUID, i.Description)
+	}
+	if i.Description == "" {
+		return describeBook(i.UUID)
+	}
+
+	return describeBook(i.Description)
+}
+
+// CleanPreview is how a full sync would reconcile the local notes and books
+// with the ones on the remote copy. The books come before the notes in each
+// group.
+type CleanPreview struct {
+	// Upload is the new local ones, which are uploaded
+	Upload []CleanItem
+	// Expunge is the other local ones that are not on the remote copy, which
+	// are expunged. The unsynced ones can be kept instead by the sync safety
+	// policy.
+	Expunge []CleanItem
+	// Download is the ones only on the remote copy, which are downloaded
+	Download []CleanItem
+}
+
+// manifestReader is implemented by the transports that can get the pages of
+// changes without the bodies of the notes and the labels of the books
+type manifestReader interface {
+	manifest(c stdCtx.Context, after Cursor) (Changes, error)
+}
+
+// manifestTransport gets the pages of changes of a transport through its
+// manifest
+type manifestTransport struct {
+	Transport
+	reader manifestReader
+}
+
+func (t manifestTransport) Changes(c stdCtx.Context, after Cursor) (Changes, error) {
+	return t.reader.manifest(c, after)
+}
+
+// withManifest returns the transport that gets only the manifest of the pages
+// of changes if the given one can, or the given one otherwise
+func withManifest(tr Transport) Transport {
+	if r, ok := tr.(manifestReader); ok {
+		return manifestTransport{Transport: tr, reader: r}
+	}
+
+	return tr
+}
+
+// PreviewClean gets the uuids of all the notes and the books on the remote
+// copy, and returns how a full sync would reconcile the local ones with them,
+// without changing the local data.
+func PreviewClean(ctx context.DnoteCtx, opts Options) (CleanPreview, error) {
+	if opts.Timeouts != nil {
+		ctx.SyncTimeouts = *opts.Timeouts
+	}
+
+	tr := opts.Transport
+	if tr == nil {
+		if ctx.SyncBackend == consts.SyncBackendGit {
+			return CleanPreview{}, errors.New("the git backend does not support the preview of a full sync")
+		}
+
+		var err error
+		if ctx, err = loadSession(ctx); err != nil {
+			return CleanPreview{}, err
+		}
+
+		tr = NewHTTPTransport(ctx)
+	}
+
+	ctx.Logger.Infof("%s", i18n.T("sync.resolving_delta"))
+	list, err := getSyncList(ctx, withManifest(tr), nil, nil)
+	if err != nil {
+		return CleanPreview{}, errors.Wrap(err, "getting sync list")
+	}
+	ctx.Logger.Rawf("%s", i18n.T("sync.total", list.getLength()))
+	ctx.Logger.Rawf("%s", i18n.T("sync.done"))
+
+	// the temporary tables of the server uuids are discarded with the
+	// transaction
+	tx, err := ctx.DB.Begin()
+	if err != nil {
+		return CleanPreview{}, errors.Wrap(err, "beginning a transaction")
+	}
+	defer tx.Rollback()
+
+	// the manifest has no labels, so only the books that are local are
+	// known to be in the scope
+	if err := applyScope(tx, &list); err != nil {
+		return CleanPreview{}, err
+	}
+
+	return previewClean(tx, &list)
+}
+
+// previewClean returns how the full sync list would be reconciled with the
+// local notes and books, using the same membership as cleanLocalNotes and
+// cleanLocalBooks
+func previewClean(tx *database.DB, list *syncList) (CleanPreview, error) {
+	var ret CleanPreview
+
+	if err := loadServerUUIDs(tx, "server_books", list.bookUUIDs()); err != nil {
+		return ret, errors.Wrap(err, "loading server books")
+	}
+	if err := loadServerUUIDs(tx, "server_notes", list.noteUUIDs()); err != nil {
+		return ret, errors.Wrap(err, "loading server notes")
+	}
+
+	for _, t := range []struct {
+		table, kind, serverTable string
+	}{
+		{table: "books", kind: "book", serverTable: "server_books"},
+		{table: "notes", kind: "note", serverTable: "server_notes"},
+	} {
+		uploadCond := fmt.Sprintf("dirty AND usn = 0 AND NOT deleted AND uuid NOT IN (SELECT uuid FROM temp.%s)", t.serverTable)
+		upload, err := queryCleanItems(tx, t.table, t.kind, uploadCond)
+		if err != nil {
+			return ret, errors.Wrapf(err, "getting local %s to upload", t.table)
+		}
+		expunge, err := queryCleanItems(tx, t.table, t.kind, expungeCond(t.serverTable))
+		if err != nil {
+			return ret, errors.Wrapf(err, "getting local %s to expunge", t.table)
+		}
+
+		ret.Upload = append(ret.Upload, upload...)
+		ret.Expunge = append(ret.Expunge, expunge...)
+	}
+
+	localBooks, err := localUUIDs(tx, "books")
+	if err != nil {
+		return ret, errors.Wrap(err, "getting local books")
+	}
+	localNotes, err := localUUIDs(tx, "notes")
+	if err != nil {
+		return ret, errors.Wrap(err, "getting local notes")
+	}
+
+	// the removed ones on the remote copy are not downloaded
+	var books, notes []CleanItem
+	for uuid, b := range list.Books {
+		if !b.Deleted && !localBooks[uuid] {
+			books = append(books, CleanItem{Kind: "book", UUID: uuid, Description: b.Label})
+		}
+	}
+	for uuid, n := range list.Notes {
+		if !n.Deleted && !localNotes[uuid] {
+			notes = append(notes, CleanItem{Kind: "note", UUID: uuid, Description: firstLine(n.Body)})
+		}
+	}
+	sortByUUID(books)
+	sortByUUID(notes)
+	ret.Download = append(books, notes...)
+
+	return ret, nil
+}
+
+// queryCleanItems returns the rows of the given table that match the condition,
+// ordered by uuid
+func queryCleanItems(tx *database.DB, table, kind, cond string) ([]CleanItem, error) {
+	// the body of a note or the label of a book describes it to the user
+	descColumn := "body"
+	if table == "books" {
+		descColumn = "label"
+	}
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT uuid, dirty, deleted, %s FROM %s WHERE %s ORDER BY uuid", descColumn, table, cond))
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying %s", table)
+	}
+	defer rows.Close()
+
+	var ret []CleanItem
+	for rows.Next() {
+		var uuid, desc string
+		var dirty, deleted bool
+		if err := rows.Scan(&uuid, &dirty, &deleted, &desc); err != nil {
+			return nil, errors.Wrapf(err, "scanning a row for local %s", kind)
+		}
+
+		if table == "notes" {
+			desc = firstLine(desc)
+		}
+
+		ret = append(ret, CleanItem{Kind: kind, UUID: uuid, Description: desc, Unsynced: dirty && !deleted})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "iterating local %s", table)
+	}
+
+	return ret, nil
+}
+
+// localUUIDs returns the set of the uuids in the given local table
+func localUUIDs(tx *database.DB, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT uuid FROM %s", table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying %s", table)
+	}
+	defer rows.Close()
+
+	ret := map[string]bool{}
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, errors.Wrapf(err, "scanning a row for local %s", table)
+		}
+
+		ret[uuid] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "iterating local %s", table)
+	}
+
+	return ret, nil
+}
+
+// sortByUUID sorts the items by uuid
+func sortByUUID(items []CleanItem) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].UUID < items[j].UUID
+	})
+}
@@ -0,0 +1,174 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestPreviewClean(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, "../tmp/.dnote", nil)
+	defer database.TeardownTestDB(t, db)
+
+	// the list of TestCleanLocalNotes and TestCleanLocalBooks, with a note
+	// only on the server and one removed on it
+	list := syncList{
+		Notes: map[string]client.SyncFragNote{
+			"n1-uuid": {
+				UUID: "n1-uuid",
+			},
+			"n2-uuid": {
+				UUID: "n2-uuid",
+			},
+			"n7-uuid": {
+				UUID: "n7-uuid",
+				Body: "n7 body\nsecond line",
+			},
+			"n8-uuid": {
+				UUID:    "n8-uuid",
+				Deleted: true,
+			},
+		},
+		Books: map[string]client.SyncFragBook{
+			"b1-uuid": {
+				UUID: "b1-uuid",
+			},
+			"b2-uuid": {
+				UUID: "b2-uuid",
+			},
+		},
+		ExpungedNotes: map[string]struct{}{
+			"n3-uuid": {},
+			"n4-uuid": {},
+		},
+		ExpungedBooks: map[string]struct{}{
+			"b3-uuid": {},
+			"b4-uuid": {},
+		},
+		Cursor:         Cursor("1"),
+		MaxCurrentTime: 2,
+	}
+
+	f := testutils.NewFixture(t, db)
+
+	// existent in the server
+	b1 := f.Book("b1-label", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+	f.Book("b3-label", testutils.With.UUID("b3-uuid"), testutils.With.Dirty())
+	// non-existent in the server but in valid state
+	f.Book("b5-label", testutils.With.UUID("b5-uuid"), testutils.With.Dirty())
+	// non-existent in the server and in an invalid state
+	f.Book("b7-label", testutils.With.UUID("b7-uuid"), testutils.With.USN(11))
+	f.Book("b8-label", testutils.With.UUID("b8-uuid"), testutils.With.USN(12), testutils.With.Dirty())
+
+	f.Note(b1, "n1 body", testutils.With.UUID("n1-uuid"), testutils.With.USN(10))
+	f.Note(b1, "n2 body", testutils.With.UUID("n2-uuid"), testutils.With.Dirty())
+	f.Note(b1, "n6 body", testutils.With.UUID("n6-uuid"), testutils.With.Dirty())
+	f.Note(b1, "n5 body", testutils.With.UUID("n5-uuid"), testutils.With.USN(7), testutils.With.Deleted(), testutils.With.Dirty())
+	f.Note(b1, "n10 body", testutils.With.UUID("n10-uuid"))
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	got, err := previewClean(tx, &list)
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Rollback()
+
+	// test
+	assert.DeepEqual(t, got, CleanPreview{
+		Upload: []CleanItem{
+			{Kind: "book", UUID: "b5-uuid", Description: "b5-label", Unsynced: true},
+			{Kind: "note", UUID: "n6-uuid", Description: "n6 body", Unsynced: true},
+		},
+		Expunge: []CleanItem{
+			{Kind: "book", UUID: "b7-uuid", Description: "b7-label"},
+			{Kind: "book", UUID: "b8-uuid", Description: "b8-label", Unsynced: true},
+			{Kind: "note", UUID: "n10-uuid", Description: "n10 body"},
+			{Kind: "note", UUID: "n5-uuid", Description: "n5 body"},
+		},
+		Download: []CleanItem{
+			{Kind: "book", UUID: "b2-uuid"},
+			{Kind: "note", UUID: "n7-uuid", Description: "n7 body"},
+		},
+	}, "preview mismatch")
+
+	assert.Equal(t, f.MustCountBooks(), 5, "book count mismatch")
+	assert.Equal(t, f.MustCountNotes(), 5, "note count mismatch")
+}
+
+func TestPreviewClean_manifest(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+	srv.SetFragment(0, client.SyncFragment{
+		FragMaxUSN:  2,
+		UserMaxUSN:  2,
+		CurrentTime: 1541108743,
+		Books:       []client.SyncFragBook{{UUID: "b1-uuid", USN: 1}},
+		Notes:       []client.SyncFragNote{{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 2}},
+	})
+
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("b1-label", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+	n2 := f.Note(b1, "n2 body", testutils.With.UUID("n2-uuid"), testutils.With.USN(3))
+
+	// execute
+	got, err := PreviewClean(ctx, Options{})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	assert.DeepEqual(t, got, CleanPreview{
+		Expunge:  []CleanItem{{Kind: "note", UUID: "n2-uuid", Description: "n2 body"}},
+		Download: []CleanItem{{Kind: "note", UUID: "n1-uuid"}},
+	}, "preview mismatch")
+
+	// the fragments are got without the contents
+	for _, req := range srv.Requests() {
+		q, err := url.ParseQuery(req.Query)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "parsing the query"))
+		}
+
+		assert.Equal(t, req.Path, "/v3/sync/fragment", "path mismatch")
+		assert.Equal(t, q.Get("fields"), "uuid", "fields mismatch")
+	}
+	assert.DeepEqual(t, f.MustGetNote(n2.UUID), n2, "n2 mismatch")
+}
@@ -0,0 +1,176 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package sync
+
+import (
+	stdCtx "context"
+	"fmt"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/pkg/errors"
+)
+
+// Quota is the numbers of the notes and the books that an account may hold on
+// the remote copy, and the numbers it holds. A zero maximum is no limit.
+type Quota struct {
+	MaxNotes int
+	Notes    int
+	MaxBooks int
+	Books    int
+}
+
+// quotaReporter is implemented by the transports whose remote copy limits the
+// notes and the books of an account
+type quotaReporter interface {
+	quota(ctx stdCtx.Context) (Quota, error)
+}
+
+// QuotaError is the error of a sync whose new notes or books do not fit in the
+// limits of the account. Nothing is uploaded, unless the sync is partial.
+type QuotaError struct {
+	// Kind is "note" or "book"
+	Kind string
+	// New is the number of the new notes or books to upload
+	New int
+	// Over is how many of them do not fit
+	Over int
+	// Max is the limit of the account
+	Max int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%d of the %d new %ss do not fit in the limit of %d %ss of the account. Run 'dnote sync --partial' to upload the oldest ones that fit, or remove some %ss", e.Over, e.New, e.Kind, e.Max, e.Kind, e.Kind)
+}
+
+// allowance is how many of the new notes and books a sync may upload. A
+// negative number is no limit.
+type allowance struct {
+	notes int
+	books int
+}
+
+var unlimited = allowance{notes: -1, books: -1}
+
+// room returns how many more of the notes or the books fit in the given limit,
+// or -1 if there is no limit
+func room(max, count int) int {
+	if max <= 0 {
+		return -1
+	}
+	if count >= max {
+		return 0
+	}
+
+	return max - count
+}
+
+// checkQuota compares the new notes and books with the limits of the account,
+// if the transport reports them. It fails with a QuotaError if they do not fit,
+// unless ctx.SyncPartial is set, and returns how many of them may be uploaded.
+func checkQuota(ctx context.DnoteCtx, tr Transport, tx *database.DB) (allowance, error) {
+	r, ok := tr.(quotaReporter)
+	if !ok {
+		return unlimited, nil
+	}
+
+	newNotes, newBooks, err := countRecords(tx, "dirty AND usn = 0")
+	if err != nil {
+		return unlimited, errors.Wrap(err, "counting the new notes and books")
+	}
+	// the limits are only got when there is something that they can stop
+	if newNotes == 0 && newBooks == 0 {
+		return unlimited, nil
+	}
+
+	q, err := r.quota(ctx.Context())
+	if err != nil {
+		return unlimited, errors.Wrap(err, "getting the limits of the account")
+	}
+
+	a := allowance{notes: room(q.MaxNotes, q.Notes), books: room(q.MaxBooks, q.Books)}
+	if a == unlimited || ctx.SyncPartial {
+		return a, nil
+	}
+
+	if a.books >= 0 && newBooks > a.books {
+		return a, &QuotaError{Kind: "book", New: newBooks, Over: newBooks - a.books, Max: q.MaxBooks}
+	}
+	if a.notes >= 0 && newNotes > a.notes {
+		return a, &QuotaError{Kind: "note", New: newNotes, Over: newNotes - a.notes, Max: q.MaxNotes}
+	}
+
+	return a, nil
+}
+
+// heldNotes returns the uuids of the notes that are not sent because they do
+// not fit in the allowance, which takes the oldest new notes first, or because
+// they are in a new book that was not sent for the same reason
+func heldNotes(tx *database.DB, a allowance) (map[string]bool, error) {
+	ret := map[string]bool{}
+	if a == unlimited {
+		return ret, nil
+	}
+
+	rows, err := tx.Query(`SELECT notes.uuid, books.usn = 0 AND books.dirty
+		FROM notes INNER JOIN books ON books.uuid = notes.book_uuid
+		WHERE notes.dirty AND NOT notes.deleted AND (notes.usn = 0 OR (books.usn = 0 AND books.dirty))
+		ORDER BY notes.added_on, notes.rowid`)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the new notes")
+	}
+	defer rows.Close()
+
+	room := a.notes
+	for rows.Next() {
+		var uuid string
+		var inNewBook bool
+		if err := rows.Scan(&uuid, &inNewBook); err != nil {
+			return nil, errors.Wrap(err, "scanning a new note")
+		}
+
+		switch {
+		case inNewBook, room == 0:
+			ret[uuid] = true
+		case room > 0:
+			room--
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating the new notes")
+	}
+
+	return ret, nil
+}
+
+// reportHeld warns about the new notes and books that were not sent because
+// they do not fit in the limits of the account
+func reportHeld(ctx context.DnoteCtx, tx *database.DB) error {
+	notes, books, err := countRecords(tx, "dirty AND usn = 0")
+	if err != nil {
+		return errors.Wrap(err, "counting the notes and the books held back")
+	}
+	if notes == 0 && books == 0 {
+		return nil
+	}
+
+	ctx.Logger.Warnf("%s", i18n.T("sync.quota_held", notes, books))
+
+	return nil
+}
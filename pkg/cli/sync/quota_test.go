@@ -0,0 +1,173 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package sync
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestRoom(t *testing.T) {
+	testCases := []struct {
+		max      int
+		count    int
+		expected int
+	}{
+		{max: 0, count: 5, expected: -1},
+		{max: 10, count: 4, expected: 6},
+		{max: 10, count: 10, expected: 0},
+		{max: 10, count: 12, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("max %d count %d", tc.max, tc.count), func(t *testing.T) {
+			assert.Equal(t, room(tc.max, tc.count), tc.expected, "room mismatch")
+		})
+	}
+}
+
+func sorted(s []string) []string {
+	ret := append([]string(nil), s...)
+	sort.Strings(ret)
+
+	return ret
+}
+
+func TestSendChanges_quota(t *testing.T) {
+	testCases := []struct {
+		name    string
+		limits  *client.GetLimitsResp
+		partial bool
+		// expectedErr is the expected QuotaError, if any
+		expectedErr   *QuotaError
+		expectedBooks []string
+		expectedNotes []string
+		// expectedDirty are the bodies of the notes left to upload
+		expectedDirty []string
+	}{
+		{
+			name:          "server without limits",
+			limits:        nil,
+			expectedBooks: []string{"b2"},
+			expectedNotes: []string{"n1 body", "n2 body", "n3 body", "n4 body"},
+		},
+		{
+			name:          "within the limits",
+			limits:        &client.GetLimitsResp{MaxNotes: 10, NoteCount: 6, MaxBooks: 3, BookCount: 1},
+			expectedBooks: []string{"b2"},
+			expectedNotes: []string{"n1 body", "n2 body", "n3 body", "n4 body"},
+		},
+		{
+			name:          "notes above the limit",
+			limits:        &client.GetLimitsResp{MaxNotes: 10, NoteCount: 8},
+			expectedErr:   &QuotaError{Kind: "note", New: 4, Over: 2, Max: 10},
+			expectedDirty: []string{"n1 body", "n2 body", "n3 body", "n4 body"},
+		},
+		{
+			name:          "books above the limit",
+			limits:        &client.GetLimitsResp{MaxBooks: 1, BookCount: 1},
+			expectedErr:   &QuotaError{Kind: "book", New: 1, Over: 1, Max: 1},
+			expectedDirty: []string{"n1 body", "n2 body", "n3 body", "n4 body"},
+		},
+		{
+			name:          "partial notes",
+			limits:        &client.GetLimitsResp{MaxNotes: 10, NoteCount: 8},
+			partial:       true,
+			expectedBooks: []string{"b2"},
+			// the oldest notes
+			expectedNotes: []string{"n2 body", "n3 body"},
+			expectedDirty: []string{"n1 body", "n4 body"},
+		},
+		{
+			name:    "partial books",
+			limits:  &client.GetLimitsResp{MaxBooks: 1, BookCount: 1},
+			partial: true,
+			// the note in the book that is held back is held back too
+			expectedNotes: []string{"n1 body", "n2 body", "n3 body"},
+			expectedDirty: []string{"n4 body"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := testutils.NewMockServer(t)
+			defer srv.Close()
+			if tc.limits != nil {
+				srv.SetLimits(*tc.limits)
+			}
+
+			// set up
+			ctx := context.InitTestCtx(t, paths, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			testutils.Login(t, &ctx)
+			ctx.APIEndpoint = srv.URL()
+			ctx.SyncPartial = tc.partial
+
+			db := ctx.DB
+			f := testutils.NewFixture(t, db)
+			b1 := f.Book("b1", testutils.With.USN(1))
+			b2 := f.Book("b2", testutils.With.Dirty())
+			f.Note(b1, "n1 body", testutils.With.Dirty(), testutils.With.AddedOn(3))
+			f.Note(b1, "n2 body", testutils.With.Dirty(), testutils.With.AddedOn(1))
+			f.Note(b1, "n3 body", testutils.With.Dirty(), testutils.With.AddedOn(2))
+			f.Note(b2, "n4 body", testutils.With.Dirty(), testutils.With.AddedOn(4))
+
+			// execute
+			err := infra.WithTx(db, func(tx *database.DB) error {
+				_, err := sendChanges(ctx, NewHTTPTransport(ctx), tx, nil)
+				return err
+			})
+
+			// test
+			if tc.expectedErr != nil {
+				var qe *QuotaError
+				assert.Equal(t, errors.As(err, &qe), true, fmt.Sprintf("error mismatch: %v", err))
+				assert.DeepEqual(t, qe, tc.expectedErr, "quota error mismatch")
+			} else if err != nil {
+				t.Fatal(errors.Wrap(err, "sending changes"))
+			}
+
+			assert.DeepEqual(t, srv.CreatedBookLabels(), tc.expectedBooks, "created books mismatch")
+			assert.DeepEqual(t, sorted(srv.CreatedNoteBodies()), tc.expectedNotes, "created notes mismatch")
+
+			var dirty []string
+			rows, err := db.Query("SELECT body FROM notes WHERE dirty ORDER BY body")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "getting the dirty notes"))
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var body string
+				if err := rows.Scan(&body); err != nil {
+					t.Fatal(errors.Wrap(err, "scanning a dirty note"))
+				}
+				dirty = append(dirty, body)
+			}
+			assert.DeepEqual(t, dirty, tc.expectedDirty, "dirty notes mismatch")
+		})
+	}
+}
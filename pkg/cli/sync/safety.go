@@ -0,0 +1,197 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/backup"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+)
+
+// ErrNotConfirmed is an error for declining a change of a sync that discards
+// local data
+var ErrNotConfirmed = errors.New("the changes that discard local data were not confirmed. Use --force to apply them")
+
+// autoBackup takes a snapshot of the database before the sync changes it, if
+// ctx.SyncAutoBackup is set, so that the data that the sync discards can be
+// restored
+func autoBackup(ctx context.DnoteCtx) error {
+	if ctx.SyncAutoBackup == 0 {
+		return nil
+	}
+
+	path, err := backup.AutoSnapshot(ctx.DB, backup.AutoDir(ctx.Paths.Cache), ctx.SyncAutoBackup, ctx.Clock.Now())
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		log.Debug("skipped the snapshot of the unchanged database\n")
+		return nil
+	}
+
+	log.Debug("took a snapshot of the database at %s\n", path)
+	ctx.FileLogger.Info("auto_backup", log.Fields{"path": path})
+
+	return nil
+}
+
+// unsafeAction is a change of a sync that discards local data
+type unsafeAction int
+
+const (
+	// actionExpunge removes the local notes or books that are not on the server
+	actionExpunge unsafeAction = iota
+	// actionExpungeUnsynced removes the local notes or books that are not on
+	// the server and have changes that were not sent to it
+	actionExpungeUnsynced
+	// actionOverwrite replaces a note changed locally with the server copy
+	actionOverwrite
+	// actionDeleteBook removes a book deleted on the server along with its notes
+	actionDeleteBook
+)
+
+// question returns the confirmation prompt for applying the action to the
+// given number of notes or books
+func (a unsafeAction) question(count int, kind string) string {
+	noun := i18n.T("sync.notes")
+	if kind == "books" {
+		noun = i18n.T("sync.books")
+	}
+
+	switch a {
+	case actionExpungeUnsynced:
+		return i18n.T("sync.confirm_expunge_unsynced", count, noun)
+	case actionOverwrite:
+		return i18n.T("sync.confirm_overwrite", count, noun)
+	case actionDeleteBook:
+		return i18n.T("sync.confirm_delete_book", count, noun)
+	default:
+		return i18n.T("sync.confirm_expunge", count, noun)
+	}
+}
+
+// safetyDecision is how an unsafe action is applied
+type safetyDecision int
+
+const (
+	// decisionProceed applies the action
+	decisionProceed safetyDecision = iota
+	// decisionConfirm applies the action if the user confirms it
+	decisionConfirm
+	// decisionPreserve keeps the local data, in a recovery book or as a
+	// conflict copy, before applying the action
+	decisionPreserve
+)
+
+// safetyPolicy decides how the unsafe actions of a sync are applied according
+// to the sync safety level
+type safetyPolicy struct {
+	level string
+	// confirm lists the items that an action applies to and asks the user
+	// whether to apply it
+	confirm func(question string, items []string) (bool, error)
+}
+
+// newSafetyPolicy returns the policy for the sync safety level of the context
+func newSafetyPolicy(ctx context.DnoteCtx) safetyPolicy {
+	return safetyPolicy{level: ctx.SyncSafety, confirm: confirmUnsafe}
+}
+
+// decide returns how the given action is applied
+func (p safetyPolicy) decide(a unsafeAction) safetyDecision {
+	switch p.level {
+	case consts.SyncSafetyPermissive:
+		return decisionProceed
+	case consts.SyncSafetyStrict:
+		return decisionConfirm
+	}
+
+	// the local changes that were not sent to the server cannot be synced
+	// back once discarded
+	if a == actionExpungeUnsynced || a == actionOverwrite {
+		return decisionPreserve
+	}
+
+	return decisionProceed
+}
+
+// resolve decides how the action is applied to the given notes or books, and
+// asks for a confirmation if needed. It returns ErrNotConfirmed if the user
+// declines it. The kind is the plural noun of the items.
+func (p safetyPolicy) resolve(a unsafeAction, kind string, items []string) (safetyDecision, error) {
+	if len(items) == 0 {
+		return decisionProceed, nil
+	}
+
+	d := p.decide(a)
+	if d != decisionConfirm {
+		return d, nil
+	}
+
+	ok, err := p.confirm(a.question(len(items), kind), items)
+	if err != nil {
+		return d, errors.Wrap(err, "confirming the changes")
+	}
+	if !ok {
+		return d, ErrNotConfirmed
+	}
+
+	return decisionProceed, nil
+}
+
+// confirmUnsafe prints the items and asks the user to confirm the change
+func confirmUnsafe(question string, items []string) (bool, error) {
+	if err := ui.RequireInteractive("confirm the changes of the sync", "--force"); err != nil {
+		return false, err
+	}
+
+	for _, item := range items {
+		log.Plainf("  %s\n", item)
+	}
+
+	return ui.Confirm(question, "--force", false)
+}
+
+// describeNote returns the uuid and the first line of the body of a note for a
+// confirmation prompt
+func describeNote(uuid, body string) string {
+	return i18n.T("sync.describe_note", uuid, firstLine(body))
+}
+
+// firstLine returns the first line of the body of a note, shortened to 50
+// characters
+func firstLine(body string) string {
+	line := strings.SplitN(strings.TrimSpace(body), "\n", 2)[0]
+	if r := []rune(line); len(r) > 50 {
+		line = string(r[:50]) + "..."
+	}
+
+	return line
+}
+
+// describeBook returns the label of a book for a confirmation prompt
+func describeBook(label string) string {
+	return i18n.T("sync.describe_book", label)
+}
@@ -0,0 +1,386 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/backup"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+func TestSafetyPolicyDecide(t *testing.T) {
+	testCases := []struct {
+		level    string
+		expected map[unsafeAction]safetyDecision
+	}{
+		{
+			level: consts.SyncSafetyStrict,
+			expected: map[unsafeAction]safetyDecision{
+				actionExpunge:         decisionConfirm,
+				actionExpungeUnsynced: decisionConfirm,
+				actionOverwrite:       decisionConfirm,
+				actionDeleteBook:      decisionConfirm,
+			},
+		},
+		{
+			level: consts.SyncSafetyNormal,
+			expected: map[unsafeAction]safetyDecision{
+				actionExpunge:         decisionProceed,
+				actionExpungeUnsynced: decisionPreserve,
+				actionOverwrite:       decisionPreserve,
+				actionDeleteBook:      decisionProceed,
+			},
+		},
+		// the context of a test or of an older caller may not set the level
+		{
+			level: "",
+			expected: map[unsafeAction]safetyDecision{
+				actionExpunge:         decisionProceed,
+				actionExpungeUnsynced: decisionPreserve,
+				actionOverwrite:       decisionPreserve,
+				actionDeleteBook:      decisionProceed,
+			},
+		},
+		{
+			level: consts.SyncSafetyPermissive,
+			expected: map[unsafeAction]safetyDecision{
+				actionExpunge:         decisionProceed,
+				actionExpungeUnsynced: decisionProceed,
+				actionOverwrite:       decisionProceed,
+				actionDeleteBook:      decisionProceed,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.level, func(t *testing.T) {
+			p := safetyPolicy{level: tc.level}
+
+			for a, expected := range tc.expected {
+				assert.Equal(t, p.decide(a), expected, fmt.Sprintf("decision mismatch for action %d", a))
+			}
+		})
+	}
+}
+
+func TestSafetyPolicyResolve(t *testing.T) {
+	testCases := []struct {
+		name             string
+		level            string
+		items            []string
+		confirmed        bool
+		expected         safetyDecision
+		expectedErr      error
+		expectedPrompted bool
+	}{
+		{
+			name:     "no items",
+			level:    consts.SyncSafetyStrict,
+			expected: decisionProceed,
+		},
+		{
+			name:     "normal",
+			level:    consts.SyncSafetyNormal,
+			items:    []string{"note n1-uuid: n1 body"},
+			expected: decisionPreserve,
+		},
+		{
+			name:             "strict confirmed",
+			level:            consts.SyncSafetyStrict,
+			items:            []string{"note n1-uuid: n1 body"},
+			confirmed:        true,
+			expected:         decisionProceed,
+			expectedPrompted: true,
+		},
+		{
+			name:             "strict declined",
+			level:            consts.SyncSafetyStrict,
+			items:            []string{"note n1-uuid: n1 body"},
+			confirmed:        false,
+			expected:         decisionConfirm,
+			expectedErr:      ErrNotConfirmed,
+			expectedPrompted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var prompted []string
+			p := safetyPolicy{
+				level: tc.level,
+				confirm: func(question string, items []string) (bool, error) {
+					prompted = items
+					return tc.confirmed, nil
+				},
+			}
+
+			got, err := p.resolve(actionOverwrite, "notes", tc.items)
+
+			assert.Equal(t, got, tc.expected, "decision mismatch")
+			assert.Equal(t, errors.Cause(err), tc.expectedErr, "error mismatch")
+			assert.Equal(t, prompted != nil, tc.expectedPrompted, "prompt mismatch")
+			if tc.expectedPrompted {
+				assert.DeepEqual(t, prompted, tc.items, "prompted items mismatch")
+			}
+		})
+	}
+}
+
+func TestDescribeNote(t *testing.T) {
+	assert.Equal(t, describeNote("n1-uuid", "\nfirst line\nsecond line"), "note n1-uuid: first line", "multiline mismatch")
+	assert.Equal(t, describeNote("n1-uuid", "ab"), "note n1-uuid: ab", "short mismatch")
+
+	long := "0123456789012345678901234567890123456789012345678901234567890123456789"
+	assert.Equal(t, describeNote("n1-uuid", long), "note n1-uuid: 01234567890123456789012345678901234567890123456789...", "long mismatch")
+}
+
+// setStdin replaces the standard input with the given input until the test ends
+func setStdin(t *testing.T, input string) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := ioutil.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the input"))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the input"))
+	}
+
+	orig := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = orig
+		f.Close()
+	})
+}
+
+// setupUnknownNote sets up a server that only has the book b1, and a local
+// copy that also has a note the server does not know about
+func setupUnknownNote(t *testing.T, ctx *context.DnoteCtx) (*testutils.MockServer, *testutils.Fixture) {
+	srv := testutils.NewMockServer(t)
+	srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 1, CurrentTime: 1541108743})
+	srv.SetFragment(0, client.SyncFragment{
+		FragMaxUSN:  1,
+		UserMaxUSN:  1,
+		CurrentTime: 1541108743,
+		Books:       []client.SyncFragBook{{UUID: "b1-uuid", USN: 1, Label: "b1-label"}},
+	})
+
+	testutils.Login(t, ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 1)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 1541108742)
+
+	f := testutils.NewFixture(t, db)
+	b1 := f.Book("b1-label", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+	f.Note(b1, "n1 body", testutils.With.UUID("n1-uuid"), testutils.With.USN(5))
+
+	return srv, f
+}
+
+func TestPerformSync_strictExpunge(t *testing.T) {
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	ctx.SyncSafety = consts.SyncSafetyStrict
+
+	srv, f := setupUnknownNote(t, &ctx)
+	defer srv.Close()
+
+	fullSync := func() error {
+		return infra.WithTx(ctx.DB, func(tx *database.DB) error {
+			return performSync(ctx, NewHTTPTransport(ctx), tx, true, directionBoth, newPhaseTimer(time.Now), nil)
+		})
+	}
+
+	// the expunge cannot be confirmed in the non-interactive mode
+	ui.SetInteractive(false)
+	defer ui.SetInteractive(ui.IsTerminal())
+
+	err := fullSync()
+	_, ok := errors.Cause(err).(ui.NonInteractiveError)
+	assert.Equal(t, ok, true, fmt.Sprintf("error mismatch: %v", err))
+	assert.Equal(t, f.MustCountNotes(), 1, "note count mismatch after the non-interactive sync")
+
+	ui.SetInteractive(true)
+
+	setStdin(t, "n\n")
+	err = fullSync()
+	assert.Equal(t, errors.Cause(err), ErrNotConfirmed, "error mismatch after declining")
+	assert.Equal(t, f.MustCountNotes(), 1, "note count mismatch after declining")
+
+	setStdin(t, "y\n")
+	if err := fullSync(); err != nil {
+		t.Fatal(errors.Wrap(err, "performing sync after confirming"))
+	}
+	assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch after confirming")
+}
+
+func TestRun_force(t *testing.T) {
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	ctx.SyncSafety = consts.SyncSafetyStrict
+
+	srv, f := setupUnknownNote(t, &ctx)
+	defer srv.Close()
+
+	ui.SetInteractive(false)
+	defer ui.SetInteractive(ui.IsTerminal())
+
+	if _, err := Run(ctx, Options{Transport: NewHTTPTransport(ctx), Full: true, Force: true}); err != nil {
+		t.Fatal(errors.Wrap(err, "syncing"))
+	}
+
+	assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch")
+}
+
+func TestRun_autoBackup(t *testing.T) {
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	ctx.SyncAutoBackup = 2
+
+	srv, f := setupUnknownNote(t, &ctx)
+	defer srv.Close()
+
+	if _, err := Run(ctx, Options{Transport: NewHTTPTransport(ctx), Full: true, Force: true}); err != nil {
+		t.Fatal(errors.Wrap(err, "syncing"))
+	}
+	assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch after the sync")
+
+	path, err := backup.LatestAutoSnapshot(backup.AutoDir(ctx.Paths.Cache))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "finding the snapshot"))
+	}
+	assert.NotEqual(t, path, "", "no snapshot was taken")
+
+	// the note expunged by the sync is brought back
+	if err := database.Restore(ctx.DB, path); err != nil {
+		t.Fatal(errors.Wrap(err, "restoring the snapshot"))
+	}
+	assert.Equal(t, f.MustGetNote("n1-uuid").Body, "n1 body", "body mismatch after the restore")
+}
+
+func TestCleanLocal_preserveUnsynced(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/.dnote", nil)
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db, Clock: clock.NewMock(), SyncSafety: consts.SyncSafetyNormal}
+
+	list := syncList{
+		Notes:         map[string]client.SyncFragNote{},
+		Books:         map[string]client.SyncFragBook{},
+		ExpungedNotes: map[string]struct{}{},
+		ExpungedBooks: map[string]struct{}{},
+	}
+
+	f := testutils.NewFixture(t, db)
+	// neither the book nor its notes are on the server
+	b1 := f.Book("b1-label", testutils.With.USN(1))
+	b2 := f.Book("b2-label", testutils.With.USN(2), testutils.With.Dirty())
+	n1 := f.Note(b1, "n1 body", testutils.With.USN(3), testutils.With.Dirty())
+	n2 := f.Note(b1, "n2 body", testutils.With.USN(4))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+	if err := cleanLocalNotes(ctx, tx, &list); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "cleaning notes").Error())
+	}
+	if err := cleanLocalBooks(ctx, tx, &list); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "cleaning books").Error())
+	}
+	tx.Commit()
+
+	// the note with unsynced changes is kept as a new note in a recovery book
+	var recoveredUUID string
+	database.MustScan(t, "getting the recovery book", db.QueryRow("SELECT uuid FROM books WHERE label = ?", "recovered"), &recoveredUUID)
+	assert.DeepEqual(t, f.MustGetBook(recoveredUUID), database.Book{UUID: recoveredUUID, Label: "recovered", USN: 0, Dirty: true}, "recovery book mismatch")
+
+	got := f.MustGetNote(n1.UUID)
+	assert.Equal(t, got.BookUUID, recoveredUUID, "n1 book mismatch")
+	assert.Equal(t, got.USN, 0, "n1 usn mismatch")
+	assert.Equal(t, got.Dirty, true, "n1 dirty mismatch")
+	assert.Equal(t, got.Body, n1.Body, "n1 body mismatch")
+
+	// the book with unsynced changes is kept as a new book
+	assert.DeepEqual(t, f.MustGetBook(b2.UUID), database.Book{UUID: b2.UUID, Label: "b2-label", USN: 0, Dirty: true}, "b2 mismatch")
+
+	var count int
+	database.MustScan(t, "counting n2", db.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", n2.UUID), &count)
+	assert.Equal(t, count, 0, "n2 count mismatch")
+	database.MustScan(t, "counting b1", db.QueryRow("SELECT count(*) FROM books WHERE uuid = ?", b1.UUID), &count)
+	assert.Equal(t, count, 0, "b1 count mismatch")
+}
+
+func TestFullSyncNote_conflictCopy(t *testing.T) {
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	ctx.ConflictStrategy = consts.ConflictStrategyServer
+	ctx.SyncSafety = consts.SyncSafetyNormal
+
+	db := ctx.DB
+	f := testutils.NewFixture(t, db)
+	b1 := f.Book("b1-label", testutils.With.USN(1))
+	n1 := f.Note(b1, "n1 body client", testutils.With.USN(2), testutils.With.Dirty(), testutils.With.EditedOn(1541219322))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+	n := client.SyncFragNote{UUID: n1.UUID, BookUUID: b1.UUID, USN: 2, AddedOn: n1.AddedOn, EditedOn: 1541219321, Body: "n1 body server"}
+	if err := fullSyncNote(ctx, tx, mustPrepareStmts(t, tx), n); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+	tx.Commit()
+
+	// the server copy wins by the conflict strategy
+	got := f.MustGetNote(n1.UUID)
+	assert.Equal(t, got.Body, "n1 body server", "n1 body mismatch")
+	assert.Equal(t, got.Dirty, false, "n1 dirty mismatch")
+
+	// and the local copy is kept in the conflicts book
+	var copyBody, copyBook string
+	var copyUSN int
+	var copyDirty bool
+	database.MustScan(t, "getting the copy", db.QueryRow("SELECT notes.body, books.label, notes.usn, notes.dirty FROM notes INNER JOIN books ON books.uuid = notes.book_uuid WHERE notes.uuid != ?", n1.UUID), &copyBody, &copyBook, &copyUSN, &copyDirty)
+	assert.Equal(t, copyBody, "n1 body client", "copy body mismatch")
+	assert.Equal(t, copyBook, "conflicts", "copy book mismatch")
+	assert.Equal(t, copyUSN, 0, "copy usn mismatch")
+	assert.Equal(t, copyDirty, true, "copy dirty mismatch")
+}
@@ -0,0 +1,310 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/pkg/errors"
+)
+
+// bookScope is the set of the books that a scoped sync keeps in the local copy.
+// The remote copy does not filter the changes by book, so the changes out of
+// the scope are got and skipped.
+type bookScope struct {
+	// labels are the labels of the books in the scope
+	labels map[string]bool
+	// books are the uuids of the books in the scope, which are the local ones
+	// and the ones on the remote copy with one of the labels. A book stays in
+	// the scope after it is renamed, and a book created locally is in it.
+	books map[string]bool
+	// local is whether the local notes moved out of the scope are removed
+	local bool
+}
+
+// normalizeScope trims the given labels and returns them sorted without the
+// empty and the duplicate ones
+func normalizeScope(labels []string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+
+	for _, l := range labels {
+		l = strings.TrimSpace(l)
+		if l == "" || seen[l] {
+			continue
+		}
+
+		seen[l] = true
+		ret = append(ret, l)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// readScope returns the labels of the books that the syncs are scoped to, or
+// nil if they get every book
+func readScope(tx *database.DB) ([]string, error) {
+	var val string
+	err := database.GetSystem(tx, consts.SystemSyncBooks, &val)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "getting the scope of the sync")
+	}
+
+	var ret []string
+	if err := json.Unmarshal([]byte(val), &ret); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling the scope of the sync")
+	}
+
+	return ret, nil
+}
+
+func writeScope(tx *database.DB, labels []string) error {
+	b, err := json.Marshal(labels)
+	if err != nil {
+		return errors.Wrap(err, "marshalling the scope of the sync")
+	}
+
+	if err := database.UpsertSystem(tx, consts.SystemSyncBooks, string(b)); err != nil {
+		return errors.Wrap(err, "saving the scope of the sync")
+	}
+
+	return nil
+}
+
+// updateScope saves the books given by ctx.SyncBooks as the scope of the syncs
+// and returns the labels that are new to it. The scope can be set only by the
+// first sync, with ctx.SyncInitial, and can then only be widened, because
+// dnote cannot tell which local notes of a book dropped from the scope are
+// kept on purpose.
+func updateScope(ctx context.DnoteCtx, tx *database.DB, fresh bool) ([]string, error) {
+	if len(ctx.SyncBooks) == 0 {
+		return nil, nil
+	}
+
+	saved, err := readScope(tx)
+	if err != nil {
+		return nil, err
+	}
+	given := normalizeScope(ctx.SyncBooks)
+	if len(given) == 0 {
+		return nil, errors.New("no book to sync is given")
+	}
+
+	if ctx.SyncInitial {
+		if !fresh {
+			return nil, errors.New("--initial is only for the first sync of a database. Use --books without it to add books to the scope of the syncs")
+		}
+
+		return nil, writeScope(tx, given)
+	}
+	if saved == nil {
+		return nil, errors.New("the syncs are not scoped to books. Scope them with --initial on the first sync of a database")
+	}
+
+	isGiven := map[string]bool{}
+	for _, l := range given {
+		isGiven[l] = true
+	}
+	isSaved := map[string]bool{}
+	var dropped []string
+	for _, l := range saved {
+		isSaved[l] = true
+		if !isGiven[l] {
+			dropped = append(dropped, l)
+		}
+	}
+	if len(dropped) > 0 {
+		return nil, errors.Errorf("books cannot be dropped from the scope of the syncs. Give %s along with the books to add", strings.Join(dropped, ", "))
+	}
+
+	added := []string{}
+	for _, l := range given {
+		if !isSaved[l] {
+			added = append(added, l)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	return added, writeScope(tx, given)
+}
+
+// loadScope returns the scope saved by updateScope with the local books in it,
+// or nil if the syncs get every book
+func loadScope(tx *database.DB) (*bookScope, error) {
+	labels, err := readScope(tx)
+	if err != nil {
+		return nil, err
+	}
+	if labels == nil {
+		return nil, nil
+	}
+
+	s := newBookScope(labels)
+	s.local = true
+
+	rows, err := tx.Query("SELECT uuid FROM books")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the local books")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, errors.Wrap(err, "scanning a local book")
+		}
+
+		s.books[uuid] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating the local books")
+	}
+
+	return s, nil
+}
+
+// applyScope filters the list by the scope saved by updateScope, if any
+func applyScope(tx *database.DB, list *syncList) error {
+	s, err := loadScope(tx)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return nil
+	}
+
+	if err := s.filter(tx, list); err != nil {
+		return errors.Wrap(err, "filtering the books out of the scope")
+	}
+
+	return nil
+}
+
+// newBookScope returns a scope of the books with the given labels
+func newBookScope(labels []string) *bookScope {
+	s := &bookScope{labels: map[string]bool{}, books: map[string]bool{}}
+	for _, l := range labels {
+		s.labels[l] = true
+	}
+
+	return s
+}
+
+// filter moves the books and the notes out of the scope from the list to its
+// skipped ones, so that a full sync does not mistake the local ones for
+// missing on the remote copy. A local note moved to a book out of the scope is
+// removed as if it were expunged.
+func (s *bookScope) filter(tx *database.DB, list *syncList) error {
+	for uuid, b := range list.Books {
+		if s.labels[b.Label] {
+			s.books[uuid] = true
+		}
+	}
+	for uuid := range list.Books {
+		if !s.books[uuid] {
+			delete(list.Books, uuid)
+			list.SkippedBooks[uuid] = struct{}{}
+		}
+	}
+
+	for uuid, n := range list.Notes {
+		if s.books[n.BookUUID] {
+			continue
+		}
+
+		delete(list.Notes, uuid)
+
+		if s.local {
+			var count int
+			if err := tx.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", uuid).Scan(&count); err != nil {
+				return errors.Wrapf(err, "checking local note %s", uuid)
+			}
+			if count > 0 {
+				list.ExpungedNotes[uuid] = struct{}{}
+				continue
+			}
+		}
+
+		list.SkippedNotes[uuid] = struct{}{}
+	}
+
+	return nil
+}
+
+// backfill gets the notes and the books with the given labels, which are new
+// to the scope of the syncs, from the whole remote copy, and merges them as a
+// full sync does. The cursor is left as it is so that the step sync after it
+// gets the changes to the rest of the scope.
+func backfill(ctx context.DnoteCtx, tr Transport, tx *database.DB, labels []string, timer *phaseTimer) error {
+	ctx.Logger.Infof("%s", i18n.T("sync.backfill", strings.Join(labels, ", ")))
+
+	// a backfill stopped early would never be resumed, so it is not limited
+	stopDownload := timer.track(phaseDownload)
+	list, err := getSyncList(ctx, tr, nil, nil)
+	stopDownload()
+	if err != nil {
+		return errors.Wrap(err, "getting sync list")
+	}
+	timer.count(phaseDownload, list.getLength())
+
+	if err := newBookScope(labels).filter(tx, &list); err != nil {
+		return errors.Wrap(err, "filtering the books to backfill")
+	}
+	if err := checkList(ctx, tr, tx, &list); err != nil {
+		return err
+	}
+	ctx.Logger.Rawf("%s", i18n.T("sync.total", len(list.Books)+len(list.Notes)))
+
+	defer timer.track(phaseApply)()
+
+	stmts, err := newStmtCache(tx, ctx.Clock.Now().UnixNano(), ctx.DeviceID)
+	if err != nil {
+		return errors.Wrap(err, "preparing statements")
+	}
+	defer stmts.Close()
+	stmts.labels = newLabelPolicy(ctx, list.Notes)
+	strategies, err := newStrategyPolicy(ctx, tx)
+	if err != nil {
+		return err
+	}
+	stmts.strategies = strategies
+
+	if err := fullSyncBooks(ctx, tx, stmts, list.Books); err != nil {
+		return errors.Wrap(err, "merging books")
+	}
+	if err := fullSyncNotes(ctx, tx, stmts, list.Notes); err != nil {
+		return errors.Wrap(err, "merging notes")
+	}
+
+	ctx.Logger.Rawf("%s", i18n.T("sync.done"))
+
+	return nil
+}
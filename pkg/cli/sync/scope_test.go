@@ -0,0 +1,315 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+// getUUIDs returns the sorted uuids of the rows in the given table
+func getUUIDs(t *testing.T, db *database.DB, table string) []string {
+	rows, err := db.Query("SELECT uuid FROM " + table + " ORDER BY uuid")
+	if err != nil {
+		t.Fatal(errors.Wrapf(err, "getting %s", table))
+	}
+	defer rows.Close()
+
+	ret := []string{}
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			t.Fatal(errors.Wrapf(err, "scanning %s", table))
+		}
+
+		ret = append(ret, uuid)
+	}
+
+	return ret
+}
+
+func TestPerformSync_scope(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	books := []client.SyncFragBook{
+		{UUID: "b1-uuid", USN: 1, Label: "linux"},
+		{UUID: "b2-uuid", USN: 2, Label: "recipes"},
+		{UUID: "b3-uuid", USN: 3, Label: "work"},
+	}
+	notes := []client.SyncFragNote{
+		{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 4, AddedOn: 1541108743, Body: "n1 body"},
+		{UUID: "n2-uuid", BookUUID: "b2-uuid", USN: 5, AddedOn: 1541108743, Body: "n2 body"},
+		{UUID: "n3-uuid", BookUUID: "b3-uuid", USN: 6, AddedOn: 1541108743, Body: "n3 body"},
+	}
+	srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 6, CurrentTime: 1541108743})
+	srv.SetFragment(0, client.SyncFragment{FragMaxUSN: 6, UserMaxUSN: 6, CurrentTime: 1541108743, Books: books, Notes: notes})
+
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+	db := ctx.DB
+
+	run := func(c context.DnoteCtx) error {
+		return infra.WithTx(db, func(tx *database.DB) error {
+			return performSync(c, NewHTTPTransport(c), tx, false, directionBoth, newPhaseTimer(time.Now), nil)
+		})
+	}
+
+	// the initial sync of a new database
+	initial := ctx
+	initial.SyncBooks = []string{"recipes", " linux"}
+	initial.SyncInitial = true
+	if err := run(initial); err != nil {
+		t.Fatal(errors.Wrap(err, "performing the initial sync"))
+	}
+
+	assert.DeepEqual(t, getUUIDs(t, db, "books"), []string{"b1-uuid", "b2-uuid"}, "books mismatch after the initial sync")
+	assert.DeepEqual(t, getUUIDs(t, db, "notes"), []string{"n1-uuid", "n2-uuid"}, "notes mismatch after the initial sync")
+
+	scope, err := readScope(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the scope"))
+	}
+	assert.DeepEqual(t, scope, []string{"linux", "recipes"}, "scope mismatch after the initial sync")
+
+	// a later sync keeps to the scope
+	srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 8, CurrentTime: 1541108800})
+	srv.SetFragment(6, client.SyncFragment{
+		FragMaxUSN:  8,
+		UserMaxUSN:  8,
+		CurrentTime: 1541108800,
+		Notes: []client.SyncFragNote{
+			{UUID: "n4-uuid", BookUUID: "b1-uuid", USN: 7, AddedOn: 1541108800, Body: "n4 body"},
+			{UUID: "n5-uuid", BookUUID: "b3-uuid", USN: 8, AddedOn: 1541108800, Body: "n5 body"},
+		},
+	})
+	if err := run(ctx); err != nil {
+		t.Fatal(errors.Wrap(err, "performing the step sync"))
+	}
+
+	assert.DeepEqual(t, getUUIDs(t, db, "books"), []string{"b1-uuid", "b2-uuid"}, "books mismatch after the step sync")
+	assert.DeepEqual(t, getUUIDs(t, db, "notes"), []string{"n1-uuid", "n2-uuid", "n4-uuid"}, "notes mismatch after the step sync")
+
+	// adding a book to the scope gets its notes from before the last sync
+	// and after it
+	srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 9, CurrentTime: 1541108900})
+	srv.SetFragment(0, client.SyncFragment{FragMaxUSN: 6, UserMaxUSN: 9, CurrentTime: 1541108900, Books: books, Notes: notes})
+	srv.SetFragment(6, client.SyncFragment{
+		FragMaxUSN:  9,
+		UserMaxUSN:  9,
+		CurrentTime: 1541108900,
+		Notes: []client.SyncFragNote{
+			{UUID: "n4-uuid", BookUUID: "b1-uuid", USN: 7, AddedOn: 1541108800, Body: "n4 body"},
+			{UUID: "n5-uuid", BookUUID: "b3-uuid", USN: 8, AddedOn: 1541108800, Body: "n5 body"},
+			{UUID: "n6-uuid", BookUUID: "b3-uuid", USN: 9, AddedOn: 1541108900, Body: "n6 body"},
+		},
+	})
+	// the step sync after the backfill continues from the last sync
+	srv.SetFragment(8, client.SyncFragment{
+		FragMaxUSN:  9,
+		UserMaxUSN:  9,
+		CurrentTime: 1541108900,
+		Notes: []client.SyncFragNote{
+			{UUID: "n6-uuid", BookUUID: "b3-uuid", USN: 9, AddedOn: 1541108900, Body: "n6 body"},
+		},
+	})
+
+	expanded := ctx
+	expanded.SyncBooks = []string{"linux", "recipes", "work"}
+	if err := run(expanded); err != nil {
+		t.Fatal(errors.Wrap(err, "performing the sync with a new book"))
+	}
+
+	assert.DeepEqual(t, getUUIDs(t, db, "books"), []string{"b1-uuid", "b2-uuid", "b3-uuid"}, "books mismatch after the backfill")
+	assert.DeepEqual(t, getUUIDs(t, db, "notes"), []string{"n1-uuid", "n2-uuid", "n3-uuid", "n4-uuid", "n5-uuid", "n6-uuid"}, "notes mismatch after the backfill")
+
+	scope, err = readScope(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the scope"))
+	}
+	assert.DeepEqual(t, scope, []string{"linux", "recipes", "work"}, "scope mismatch after the backfill")
+
+	var lastMaxUSN int
+	database.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	assert.Equal(t, lastMaxUSN, 9, "last max usn mismatch")
+}
+
+func TestBookScopeFilter(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/.dnote", nil)
+	defer database.TeardownTestDB(t, db)
+
+	// b1 was renamed on the server since it was synced
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "b1-uuid", "linux", 1)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743, 2)
+	database.MustExec(t, "inserting the scope", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSyncBooks, `["linux","recipes"]`)
+
+	list := newSyncList()
+	list.add(Changes{
+		Books: []client.SyncFragBook{
+			{UUID: "b1-uuid", Label: "unix"},
+			{UUID: "b2-uuid", Label: "recipes"},
+			{UUID: "b3-uuid", Label: "work"},
+		},
+		Notes: []client.SyncFragNote{
+			// moved out of the scope
+			{UUID: "n1-uuid", BookUUID: "b3-uuid"},
+			{UUID: "n2-uuid", BookUUID: "b2-uuid"},
+			{UUID: "n3-uuid", BookUUID: "b3-uuid"},
+			// in a local book that has not changed
+			{UUID: "n4-uuid", BookUUID: "b1-uuid"},
+		},
+	})
+
+	if err := applyScope(db, &list); err != nil {
+		t.Fatal(errors.Wrap(err, "applying the scope"))
+	}
+
+	keys := func(m map[string]struct{}) []string {
+		ret := []string{}
+		for k := range m {
+			ret = append(ret, k)
+		}
+		sort.Strings(ret)
+
+		return ret
+	}
+	var books, notes []string
+	for uuid := range list.Books {
+		books = append(books, uuid)
+	}
+	for uuid := range list.Notes {
+		notes = append(notes, uuid)
+	}
+	sort.Strings(books)
+	sort.Strings(notes)
+
+	assert.DeepEqual(t, books, []string{"b1-uuid", "b2-uuid"}, "books mismatch")
+	assert.DeepEqual(t, notes, []string{"n2-uuid", "n4-uuid"}, "notes mismatch")
+	assert.DeepEqual(t, keys(list.SkippedBooks), []string{"b3-uuid"}, "skipped books mismatch")
+	assert.DeepEqual(t, keys(list.SkippedNotes), []string{"n3-uuid"}, "skipped notes mismatch")
+	assert.DeepEqual(t, keys(list.ExpungedNotes), []string{"n1-uuid"}, "expunged notes mismatch")
+
+	// a full sync must not take the skipped ones for missing on the server
+	noteUUIDs := list.noteUUIDs()
+	sort.Strings(noteUUIDs)
+	assert.DeepEqual(t, noteUUIDs, []string{"n1-uuid", "n2-uuid", "n3-uuid", "n4-uuid"}, "note uuids mismatch")
+}
+
+func TestUpdateScope(t *testing.T) {
+	testCases := []struct {
+		name          string
+		saved         string
+		fresh         bool
+		books         []string
+		initial       bool
+		expectedAdded []string
+		expectedScope []string
+		expectedErr   string
+	}{
+		{
+			name:  "no books",
+			saved: `["linux"]`,
+			// the saved scope is kept
+			expectedScope: []string{"linux"},
+		},
+		{
+			name:          "initial",
+			fresh:         true,
+			books:         []string{"recipes", "linux", "linux", ""},
+			initial:       true,
+			expectedScope: []string{"linux", "recipes"},
+		},
+		{
+			name:        "initial after a sync",
+			books:       []string{"linux"},
+			initial:     true,
+			expectedErr: "--initial is only for the first sync",
+		},
+		{
+			name:        "not scoped",
+			books:       []string{"linux"},
+			expectedErr: "the syncs are not scoped to books",
+		},
+		{
+			name:          "added",
+			saved:         `["linux","recipes"]`,
+			books:         []string{"linux", "go", "recipes"},
+			expectedAdded: []string{"go"},
+			expectedScope: []string{"go", "linux", "recipes"},
+		},
+		{
+			name:          "unchanged",
+			saved:         `["linux","recipes"]`,
+			books:         []string{"recipes", "linux"},
+			expectedScope: []string{"linux", "recipes"},
+		},
+		{
+			name:        "dropped",
+			saved:       `["linux","recipes"]`,
+			books:       []string{"linux", "go"},
+			expectedErr: "Give recipes along with the books to add",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := database.InitTestDB(t, "../tmp/.dnote", nil)
+			defer database.TeardownTestDB(t, db)
+
+			if tc.saved != "" {
+				database.MustExec(t, "inserting the scope", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSyncBooks, tc.saved)
+			}
+
+			ctx := context.DnoteCtx{SyncBooks: tc.books, SyncInitial: tc.initial}
+			added, err := updateScope(ctx, db, tc.fresh)
+			if tc.expectedErr != "" {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				assert.Equal(t, strings.Contains(err.Error(), tc.expectedErr), true, "error mismatch: "+err.Error())
+				return
+			}
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "updating the scope"))
+			}
+
+			scope, err := readScope(db)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "reading the scope"))
+			}
+
+			assert.DeepEqual(t, added, tc.expectedAdded, "added mismatch")
+			assert.DeepEqual(t, scope, tc.expectedScope, "scope mismatch")
+		})
+	}
+}
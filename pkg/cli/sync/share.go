@@ -0,0 +1,58 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/pkg/errors"
+)
+
+// refreshShares gets the share links of the notes that have any cached, so
+// that the links revoked on another device are dropped. The links of the notes
+// that were removed are dropped without asking the server. The links are only
+// cached for listing them, so a failure to get them is reported in the verbose
+// output and the rest are left as they are.
+func refreshShares(ctx context.DnoteCtx, tx *database.DB) error {
+	uuids, err := database.GetSharedNoteUUIDs(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, uuid := range uuids {
+		var count int
+		if err := tx.QueryRow("SELECT count(*) FROM notes WHERE uuid = ? AND NOT deleted", uuid).Scan(&count); err != nil {
+			return errors.Wrapf(err, "checking the note %s", uuid)
+		}
+		if count == 0 {
+			if err := database.ReplaceNoteShares(tx, uuid, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := infra.RefreshShares(ctx, tx, uuid); err != nil {
+			ctx.Logger.Verbosef("not refreshing the share links: %s\n", err)
+			return nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func getCachedShareIDs(t *testing.T, db *database.DB) []string {
+	shares, err := database.GetShares(db, "", 0)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the shares"))
+	}
+
+	ret := []string{}
+	for _, s := range shares {
+		ret = append(ret, s.ID)
+	}
+
+	return ret
+}
+
+func TestRefreshShares(t *testing.T) {
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+
+		// s1 was revoked on another device
+		resp := client.GetSharesResp{Shares: []client.Share{
+			{ID: "s2", NoteUUID: "n1-uuid", URL: "https://example.com/s/s2"},
+		}}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(errors.Wrap(err, "encoding the response"))
+		}
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = ts.URL
+
+	db := ctx.DB
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "b1-uuid", "b1-label", 1)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 2, "n1 body", 1541108743)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted) VALUES (?, ?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", 3, "", 1541108743, true)
+	for _, s := range []database.Share{
+		{ID: "s1", NoteUUID: "n1-uuid", CreatedAt: 1},
+		{ID: "s3", NoteUUID: "n2-uuid", CreatedAt: 2},
+		{ID: "s4", NoteUUID: "n3-uuid", CreatedAt: 3},
+	} {
+		if err := database.SaveShare(db, s); err != nil {
+			t.Fatal(errors.Wrapf(err, "saving %s", s.ID))
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+	if err := refreshShares(ctx, tx); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "refreshing the shares"))
+	}
+	tx.Commit()
+
+	// the server is asked only about the note that still exists
+	assert.DeepEqual(t, requested, []string{"/v1/notes/n1-uuid/shares"}, "requests mismatch")
+	assert.DeepEqual(t, getCachedShareIDs(t, db), []string{"s2"}, "cached shares mismatch")
+}
+
+func TestRefreshShares_unsupported(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = ts.URL
+
+	db := ctx.DB
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "b1-uuid", "b1-label", 1)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 2, "n1 body", 1541108743)
+	if err := database.SaveShare(db, database.Share{ID: "s1", NoteUUID: "n1-uuid"}); err != nil {
+		t.Fatal(errors.Wrap(err, "saving s1"))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+	// the sync does not fail because of the links
+	if err := refreshShares(ctx, tx); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "refreshing the shares"))
+	}
+	tx.Commit()
+
+	assert.DeepEqual(t, getCachedShareIDs(t, db), []string{"s1"}, "cached shares mismatch")
+}
@@ -0,0 +1,120 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"database/sql"
+
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// stmtCache holds the statements that are executed for every item in the sync
+// list. They are prepared once per sync rather than once per item.
+type stmtCache struct {
+	// now is the time of the sync, at which its changes are journaled
+	now int64
+	// device is the id of the device on which the changes are journaled
+	device string
+	// labels decides which book is renamed when a downloaded book has the
+	// label of another local book. The local one is renamed by default.
+	labels labelPolicy
+	// strategies decides how the notes changed both locally and on the server
+	// are resolved. The notes are merged with conflict markers by default.
+	strategies strategyPolicy
+
+	getNote        *sql.Stmt
+	insertNote     *sql.Stmt
+	overwriteNote  *sql.Stmt
+	updateNote     *sql.Stmt
+	updateNoteUSN  *sql.Stmt
+	getBook        *sql.Stmt
+	getBookDeleted *sql.Stmt
+	countBookLabel *sql.Stmt
+	insertBook     *sql.Stmt
+	updateBook     *sql.Stmt
+	insertAction   *sql.Stmt
+}
+
+// newStmtCache prepares the statements in the given database or transaction
+// for a sync at the given time on the given device. The caller must close the
+// cache.
+func newStmtCache(tx *database.DB, now int64, device string) (*stmtCache, error) {
+	c := &stmtCache{now: now, device: device}
+
+	stmts := []struct {
+		dest  **sql.Stmt
+		query string
+	}{
+		{&c.getNote, "SELECT body, usn, book_uuid, edited_on, dirty, deleted FROM notes WHERE uuid = ?"},
+		{&c.insertNote, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM notes WHERE book_uuid = ?))"},
+		{&c.overwriteNote, "UPDATE notes SET usn = ?, book_uuid = ?, body = ?, edited_on = ?, deleted = ?, public = ?, dirty = ? WHERE uuid = ?"},
+		{&c.updateNote, "UPDATE notes SET usn = ?, book_uuid = ?, body = ?, edited_on = ?, deleted = ?  WHERE uuid = ?"},
+		{&c.updateNoteUSN, "UPDATE notes SET usn = ? WHERE uuid = ?"},
+		{&c.getBook, "SELECT usn, dirty FROM books WHERE uuid = ?"},
+		{&c.getBookDeleted, "SELECT deleted FROM books WHERE uuid = ?"},
+		{&c.countBookLabel, "SELECT count(*) FROM books WHERE label = ? AND uuid != ?"},
+		{&c.insertBook, "INSERT INTO books (uuid, label, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?)"},
+		{&c.updateBook, "UPDATE books SET usn = ?, uuid = ?, label = ?, deleted = ?, dirty = ? WHERE uuid = ?"},
+		{&c.insertAction, "INSERT INTO actions (timestamp, actor, operation, note_uuid, book_uuid, summary, device) VALUES (?, ?, ?, ?, ?, ?, ?)"},
+	}
+
+	for _, s := range stmts {
+		stmt, err := tx.Prepare(s.query)
+		if err != nil {
+			c.Close()
+			return nil, errors.Wrapf(err, "preparing %s", s.query)
+		}
+
+		*s.dest = stmt
+	}
+
+	return c, nil
+}
+
+// Close closes all prepared statements in the cache
+func (c *stmtCache) Close() error {
+	var ret error
+
+	for _, stmt := range []*sql.Stmt{c.getNote, c.insertNote, c.overwriteNote, c.updateNote, c.updateNoteUSN, c.getBook, c.getBookDeleted, c.countBookLabel, c.insertBook, c.updateBook, c.insertAction} {
+		if stmt == nil {
+			continue
+		}
+
+		if err := stmt.Close(); err != nil && ret == nil {
+			ret = errors.Wrap(err, "closing a prepared statement")
+		}
+	}
+
+	return ret
+}
+
+// journal records a change that the sync makes to a local note or book
+func (c *stmtCache) journal(actor, op, noteUUID, bookUUID string, changes database.FieldChanges) error {
+	if _, err := c.insertAction.Exec(c.now, actor, op, noteUUID, bookUUID, changes.String(), c.device); err != nil {
+		return errors.Wrapf(err, "journaling %s", op)
+	}
+
+	return nil
+}
+
+// action returns an action of the sync
+func (c *stmtCache) action(actor, op string) database.Action {
+	return database.Action{Timestamp: c.now, Actor: actor, Operation: op, Device: c.device}
+}
@@ -0,0 +1,210 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"sort"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// noteStrategy resolves a note that was changed locally and whose server copy
+// is different, and takes the usn of the server copy
+type noteStrategy func(tx *database.DB, stmts *stmtCache, serverNote client.SyncFragNote, localNote database.Note) error
+
+// noteStrategies are the conflict strategies that a book can be set to, by name
+var noteStrategies = map[string]noteStrategy{
+	consts.ConflictStrategyLocal:        keepLocalNote,
+	consts.ConflictStrategyServer:       keepServerNote,
+	consts.ConflictStrategyNewer:        keepNewerNote,
+	consts.ConflictStrategyMerge:        mergeNoteBodies,
+	consts.ConflictStrategyConflictCopy: copyConflictNote,
+}
+
+// StrategyNames returns the names of the conflict strategies that a book can be
+// set to, sorted
+func StrategyNames() []string {
+	ret := []string{}
+	for name := range noteStrategies {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// IsStrategy reports whether the given name is a conflict strategy that a book
+// can be set to
+func IsStrategy(name string) bool {
+	_, ok := noteStrategies[name]
+	return ok
+}
+
+// keepLocalNote keeps the local copy, which stays dirty to be uploaded
+func keepLocalNote(tx *database.DB, stmts *stmtCache, serverNote client.SyncFragNote, localNote database.Note) error {
+	if _, err := stmts.updateNoteUSN.Exec(serverNote.USN, serverNote.UUID); err != nil {
+		return errors.Wrapf(err, "updating the usn of local note %s", serverNote.UUID)
+	}
+
+	return nil
+}
+
+// keepServerNote overwrites the local copy with the server copy
+func keepServerNote(tx *database.DB, stmts *stmtCache, serverNote client.SyncFragNote, localNote database.Note) error {
+	n := serverNote
+	if _, err := stmts.overwriteNote.Exec(n.USN, n.BookUUID, n.Body, n.EditedOn, n.Deleted, n.Public, false, n.UUID); err != nil {
+		return errors.Wrapf(err, "overwriting local note %s", n.UUID)
+	}
+
+	return journalNoteChange(stmts, n.UUID, localNote, n.BookUUID, n.Body, n.Deleted)
+}
+
+// keepNewerNote keeps the copy edited more recently, or the local copy if
+// both were edited at the same time
+func keepNewerNote(tx *database.DB, stmts *stmtCache, serverNote client.SyncFragNote, localNote database.Note) error {
+	if preferServerNote(consts.ConflictStrategyNewer, localNote, serverNote) {
+		return keepServerNote(tx, stmts, serverNote, localNote)
+	}
+
+	return keepLocalNote(tx, stmts, serverNote, localNote)
+}
+
+// mergeNoteBodies merges the copies with conflict markers, and the result
+// stays dirty to be uploaded
+func mergeNoteBodies(tx *database.DB, stmts *stmtCache, serverNote client.SyncFragNote, localNote database.Note) error {
+	mr, err := mergeNoteFields(tx, localNote, serverNote)
+	if err != nil {
+		return errors.Wrapf(err, "reporting note conflict for note %s", localNote.UUID)
+	}
+
+	if _, err := stmts.updateNote.Exec(serverNote.USN, mr.bookUUID, mr.body, mr.editedOn, serverNote.Deleted, serverNote.UUID); err != nil {
+		return errors.Wrapf(err, "updating local note %s", serverNote.UUID)
+	}
+
+	return journalNoteChange(stmts, serverNote.UUID, localNote, mr.bookUUID, mr.body, serverNote.Deleted)
+}
+
+// copyConflictNote keeps the server copy, and the local copy as a new note in
+// the conflicts book
+func copyConflictNote(tx *database.DB, stmts *stmtCache, serverNote client.SyncFragNote, localNote database.Note) error {
+	if err := copyConflict(tx, stmts, serverNote, localNote); err != nil {
+		return errors.Wrapf(err, "copying local note %s", serverNote.UUID)
+	}
+
+	return keepServerNote(tx, stmts, serverNote, localNote)
+}
+
+// strategyPolicy decides how the notes that were changed both locally and on
+// the server are resolved, by the conflict strategies of their books
+type strategyPolicy struct {
+	// books is the names of the conflict strategies by the uuid of the book
+	books map[string]string
+	// fallback is the strategy of the notes of the other books, which is set
+	// by sync.conflictStrategy. If empty, they are merged with conflict
+	// markers.
+	fallback string
+	// safety decides if the local copies discarded by the fallback are kept
+	// in the conflicts book
+	safety safetyPolicy
+	// logger prints the chosen strategies in the verbose output, if set
+	logger *log.Logger
+}
+
+// newStrategyPolicy returns the policy for the conflict strategies of the books
+// in the given database, falling back to the configured one
+func newStrategyPolicy(ctx context.DnoteCtx, tx *database.DB) (strategyPolicy, error) {
+	strategies, err := database.GetBookStrategies(tx)
+	if err != nil {
+		return strategyPolicy{}, errors.Wrap(err, "getting the conflict strategies")
+	}
+
+	books := map[string]string{}
+	for _, s := range strategies {
+		books[s.BookUUID] = s.Strategy
+	}
+
+	return strategyPolicy{books: books, fallback: ctx.ConflictStrategy, safety: newSafetyPolicy(ctx), logger: ctx.Logger}, nil
+}
+
+func (p strategyPolicy) verbosef(format string, v ...interface{}) {
+	if p.logger != nil {
+		p.logger.Verbosef(format, v...)
+	}
+}
+
+// resolve resolves the given copies of a note by the conflict strategy of the
+// book of the local copy, or by the fallback if the book has none. It reports
+// false without changing the note if neither is set, or if the note was not
+// changed locally. A deletion on either side is not resolved by the
+// strategies. A local copy discarded by the fallback is subject to
+// sync.safety, like the one overwritten by a full sync.
+func (p strategyPolicy) resolve(tx *database.DB, stmts *stmtCache, serverNote client.SyncFragNote, localNote database.Note) (bool, error) {
+	if !localNote.Dirty || localNote.Deleted || serverNote.Deleted {
+		return false, nil
+	}
+
+	source := "of its book"
+	name, byBook := p.books[localNote.BookUUID]
+	if !byBook {
+		name = p.fallback
+		source = "of sync.conflictStrategy"
+	}
+
+	s, ok := noteStrategies[name]
+	if !ok {
+		return false, nil
+	}
+
+	p.verbosef("note %s: resolving with the %s conflict strategy %s (server usn %d, local usn %d)\n", serverNote.UUID, name, source, serverNote.USN, localNote.USN)
+	if !byBook && discardsLocalNote(name, localNote, serverNote) {
+		d, err := p.safety.resolve(actionOverwrite, "notes", []string{describeNote(serverNote.UUID, localNote.Body)})
+		if err != nil {
+			return true, errors.Wrapf(err, "overwriting local note %s", serverNote.UUID)
+		}
+		if d == decisionPreserve {
+			if err := copyConflict(tx, stmts, serverNote, localNote); err != nil {
+				return true, errors.Wrapf(err, "copying local note %s", serverNote.UUID)
+			}
+		}
+	}
+
+	if err := s(tx, stmts, serverNote, localNote); err != nil {
+		return true, errors.Wrapf(err, "resolving note %s with the %s conflict strategy", serverNote.UUID, name)
+	}
+
+	return true, nil
+}
+
+// discardsLocalNote checks if the conflict strategy of the given name overwrites
+// the local copy of a note with the server copy without keeping it
+func discardsLocalNote(name string, localNote database.Note, serverNote client.SyncFragNote) bool {
+	switch name {
+	case consts.ConflictStrategyServer:
+		return true
+	case consts.ConflictStrategyNewer:
+		return preferServerNote(name, localNote, serverNote)
+	}
+
+	return false
+}
@@ -0,0 +1,211 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestStrategyNames(t *testing.T) {
+	assert.DeepEqual(t, StrategyNames(), []string{
+		consts.ConflictStrategyConflictCopy,
+		consts.ConflictStrategyLocal,
+		consts.ConflictStrategyMerge,
+		consts.ConflictStrategyNewer,
+		consts.ConflictStrategyServer,
+	}, "names mismatch")
+	assert.Equal(t, IsStrategy(consts.ConflictStrategyLocal), true, "local mismatch")
+	assert.Equal(t, IsStrategy("keep-local"), false, "keep-local mismatch")
+}
+
+// TestMergeNote_strategies tests the merge strategies against a note edited
+// both locally and on the server since the last sync
+func TestMergeNote_strategies(t *testing.T) {
+	const localBody = "local body\n"
+	const serverBody = "server body\n"
+
+	type expected struct {
+		body  string
+		dirty bool
+		// copied is whether the local copy is kept in the conflicts book
+		copied bool
+	}
+
+	testCases := []struct {
+		strategy string
+		// fallback is the strategy set by sync.conflictStrategy
+		fallback string
+		// safety is sync.safety
+		safety         string
+		serverEditedOn int64
+		expected       expected
+	}{
+		{
+			strategy: "",
+			expected: expected{body: reportBodyConflict(localBody, serverBody), dirty: true},
+		},
+		{
+			// the local copy discarded by sync.conflictStrategy is kept by sync.safety
+			strategy: "",
+			fallback: consts.ConflictStrategyServer,
+			expected: expected{body: serverBody, dirty: false, copied: true},
+		},
+		{
+			strategy: "",
+			fallback: consts.ConflictStrategyServer,
+			safety:   consts.SyncSafetyPermissive,
+			expected: expected{body: serverBody, dirty: false},
+		},
+		{
+			strategy:       "",
+			fallback:       consts.ConflictStrategyNewer,
+			serverEditedOn: 1541108744,
+			expected:       expected{body: serverBody, dirty: false, copied: true},
+		},
+		{
+			strategy:       "",
+			fallback:       consts.ConflictStrategyNewer,
+			serverEditedOn: 1541108742,
+			expected:       expected{body: localBody, dirty: true},
+		},
+		{
+			strategy: "",
+			fallback: consts.ConflictStrategyLocal,
+			expected: expected{body: localBody, dirty: true},
+		},
+		{
+			// the strategy of the book takes precedence over sync.conflictStrategy
+			strategy: consts.ConflictStrategyLocal,
+			fallback: consts.ConflictStrategyServer,
+			expected: expected{body: localBody, dirty: true},
+		},
+		{
+			strategy: consts.ConflictStrategyLocal,
+			expected: expected{body: localBody, dirty: true},
+		},
+		{
+			strategy: consts.ConflictStrategyServer,
+			expected: expected{body: serverBody, dirty: false},
+		},
+		{
+			strategy:       consts.ConflictStrategyNewer,
+			serverEditedOn: 1541108744,
+			expected:       expected{body: serverBody, dirty: false},
+		},
+		{
+			strategy:       consts.ConflictStrategyNewer,
+			serverEditedOn: 1541108742,
+			expected:       expected{body: localBody, dirty: true},
+		},
+		{
+			strategy: consts.ConflictStrategyMerge,
+			expected: expected{body: reportBodyConflict(localBody, serverBody), dirty: true},
+		},
+		{
+			strategy: consts.ConflictStrategyConflictCopy,
+			expected: expected{body: serverBody, dirty: false, copied: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, full := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s fallback %s safety %s server edited at %d full %t", tc.strategy, tc.fallback, tc.safety, tc.serverEditedOn, full), func(t *testing.T) {
+				// set up
+				ctx := context.InitTestCtx(t, paths, nil)
+				defer context.TeardownTestCtx(t, ctx)
+				ctx.ConflictStrategy = tc.fallback
+				ctx.SyncSafety = tc.safety
+
+				var out bytes.Buffer
+				ctx.Logger = log.NewLogger(&out, &out)
+				ctx.Logger.SetLevel(log.LevelVerbose)
+
+				db := ctx.DB
+				f := testutils.NewFixture(t, db)
+				b1 := f.Book("journal", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+				f.Note(b1, localBody, testutils.With.UUID("n1-uuid"), testutils.With.USN(2), testutils.With.EditedOn(1541108743), testutils.With.Dirty())
+				if err := database.SetBookStrategy(db, b1.UUID, tc.strategy); err != nil {
+					t.Fatal(errors.Wrap(err, "setting the strategy"))
+				}
+
+				serverEditedOn := tc.serverEditedOn
+				if serverEditedOn == 0 {
+					serverEditedOn = 1541108744
+				}
+				n := client.SyncFragNote{UUID: "n1-uuid", BookUUID: b1.UUID, USN: 5, AddedOn: 1541108742, EditedOn: serverEditedOn, Body: serverBody}
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "beginning a transaction"))
+				}
+				stmts := mustPrepareStmts(t, tx)
+				stmts.strategies, err = newStrategyPolicy(ctx, tx)
+				if err != nil {
+					tx.Rollback()
+					t.Fatal(errors.Wrap(err, "getting the strategies"))
+				}
+
+				if full {
+					err = fullSyncNote(ctx, tx, stmts, n)
+				} else {
+					err = stepSyncNote(tx, stmts, n)
+				}
+				stmts.Close()
+				if err != nil {
+					tx.Rollback()
+					t.Fatal(errors.Wrap(err, "executing"))
+				}
+				tx.Commit()
+
+				// test
+				n1 := f.MustGetNote("n1-uuid")
+				assert.Equal(t, n1.Body, tc.expected.body, "body mismatch")
+				assert.Equal(t, n1.Dirty, tc.expected.dirty, "dirty mismatch")
+				assert.Equal(t, n1.USN, 5, "usn mismatch")
+				assert.Equal(t, n1.BookUUID, b1.UUID, "book mismatch")
+
+				var copies int
+				database.MustScan(t, "counting copies", db.QueryRow(`SELECT count(*) FROM notes
+					WHERE book_uuid IN (SELECT uuid FROM books WHERE label = ?) AND body = ? AND dirty`, "conflicts", localBody), &copies)
+				assert.Equal(t, copies == 1, tc.expected.copied, "copied mismatch")
+
+				if tc.strategy != "" {
+					msg := fmt.Sprintf("note n1-uuid: resolving with the %s conflict strategy of its book", tc.strategy)
+					assert.Equal(t, strings.Contains(out.String(), msg), true, fmt.Sprintf("verbose output mismatch: %s", out.String()))
+				} else if tc.fallback != "" {
+					msg := fmt.Sprintf("note n1-uuid: resolving with the %s conflict strategy of sync.conflictStrategy", tc.fallback)
+					assert.Equal(t, strings.Contains(out.String(), msg), true, fmt.Sprintf("verbose output mismatch: %s", out.String()))
+				}
+			})
+		}
+	}
+}
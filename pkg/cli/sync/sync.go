@@ -0,0 +1,2233 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package sync syncs the local notes and books with the server or a git
+// repository
+package sync
+
+import (
+	"bytes"
+	stdCtx "context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/hooks"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/migrate"
+	"github.com/dnote/dnote/pkg/cli/upgrade"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	modeInsert = iota
+	modeUpdate
+)
+
+// ErrNotLoggedIn is an error for syncing with the server without a session key
+var ErrNotLoggedIn = errors.New("not logged in")
+
+// ErrLocalOnly is an error for syncing with the server without a session key
+// after the user chose not to sync
+var ErrLocalOnly = errors.New("dnote is set up to keep the notes locally only. Run 'dnote login' to sync with a server")
+
+// Options are the options of a sync
+type Options struct {
+	// Full syncs all the data instead of only the changes since the last sync
+	Full bool
+	// Transport is the transport to sync through instead of the backend set
+	// in the config
+	Transport Transport
+	// PullOnly gets the remote changes without sending the local ones
+	PullOnly bool
+	// PushOnly sends the local changes without getting the remote ones
+	PushOnly bool
+	// Force applies the changes that discard local data regardless of the
+	// sync safety level
+	Force bool
+	// Limits caps the changes got from the remote copy. A sync that reaches
+	// them stops early, and the next one continues from where it stopped.
+	Limits Limits
+	// Timeouts overrides the retries and the timeouts in the config if not
+	// nil. A sync that reaches its total timeout stops early like one that
+	// reaches Limits.
+	Timeouts *context.SyncTimeouts
+	// Partial uploads the oldest of the new notes and books that fit in the
+	// limits of the account when not all of them do. The others stay to be
+	// uploaded by a later sync.
+	Partial bool
+	// Books scopes the sync to the books with the given labels, such as to
+	// keep only some of them on a small device. The scope is saved, and the
+	// later syncs keep to it. Books can be added to it later, and the next
+	// sync gets their notes.
+	Books []string
+	// Initial sets the scope given by Books on the first sync of a database
+	Initial bool
+}
+
+// direction is which of the remote and the local changes a sync applies
+type direction int
+
+const (
+	// directionBoth gets the remote changes and sends the local ones
+	directionBoth direction = iota
+	// directionPull only gets the remote changes
+	directionPull
+	// directionPush only sends the local changes
+	directionPush
+)
+
+// direction returns the direction of the sync set by the options
+func (o Options) direction() (direction, error) {
+	switch {
+	case o.PullOnly && o.PushOnly:
+		return directionBoth, errors.New("a sync cannot be both pull-only and push-only")
+	case o.PullOnly:
+		return directionPull, nil
+	case o.PushOnly:
+		return directionPush, nil
+	}
+
+	return directionBoth, nil
+}
+
+// Report is the result of a sync
+type Report struct {
+	// Backend is the consts.SyncBackend value that the notes were synced with
+	Backend string
+	// UploadedNotes and UploadedBooks are the numbers of the local changes
+	// that were sent
+	UploadedNotes int
+	UploadedBooks int
+	// Notes and Books are the numbers of the notes and the books after the sync
+	Notes    int
+	Books    int
+	Duration time.Duration
+	// StoppedEarly is the limit that stopped the sync before it got all the
+	// changes, or empty if it got them all
+	StoppedEarly StopReason
+	// Remaining is the number of the changes left for the next sync if it
+	// stopped early, or -1 if it is unknown
+	Remaining int
+	// Phases is the time spent in each phase of the sync, and the numbers of
+	// the items downloaded and uploaded
+	Phases []Phase
+
+	timer *phaseTimer
+}
+
+// PhaseSummary returns a line describing the time spent in each phase
+func (r Report) PhaseSummary() string {
+	if r.timer == nil {
+		return ""
+	}
+
+	return r.timer.summary()
+}
+
+// getLastSyncAt returns the time of the last sync. A missing record means
+// that the client has never synced.
+func getLastSyncAt(tx *database.DB) (int, error) {
+	var ret int
+
+	err := database.GetSystem(tx, consts.SystemLastSyncAt, &ret)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return ret, errors.Wrap(err, "querying last sync time")
+	}
+
+	return ret, nil
+}
+
+// getLastCursor returns the cursor of the last change seen from the remote
+// copy. It is kept under the key of the last max_usn, which is the cursor of the
+// server. A missing record means that the client has never synced.
+func getLastCursor(tx *database.DB) (Cursor, error) {
+	var ret string
+
+	err := database.GetSystem(tx, consts.SystemLastMaxUSN, &ret)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "querying the last cursor")
+	}
+	if ret == "" {
+		return nil, nil
+	}
+
+	return Cursor(ret), nil
+}
+
+// syncList is an aggregation of resources represented in the pages of changes
+type syncList struct {
+	Notes         map[string]client.SyncFragNote
+	Books         map[string]client.SyncFragBook
+	ExpungedNotes map[string]struct{}
+	ExpungedBooks map[string]struct{}
+	// SkippedNotes and SkippedBooks are on the remote copy but out of the
+	// scope of the sync
+	SkippedNotes map[string]struct{}
+	SkippedBooks map[string]struct{}
+	// Cursor is the position after the last page
+	Cursor         Cursor
+	MaxCurrentTime int64
+}
+
+func (l syncList) getLength() int {
+	return len(l.Notes) + len(l.Books) + len(l.ExpungedNotes) + len(l.ExpungedBooks)
+}
+
+// noteUUIDs returns the uuids of the notes on the remote copy, including the
+// expunged and the skipped ones
+func (l syncList) noteUUIDs() []string {
+	ret := make([]string, 0, len(l.Notes)+len(l.ExpungedNotes)+len(l.SkippedNotes))
+	for uuid := range l.Notes {
+		ret = append(ret, uuid)
+	}
+	for uuid := range l.ExpungedNotes {
+		ret = append(ret, uuid)
+	}
+	for uuid := range l.SkippedNotes {
+		ret = append(ret, uuid)
+	}
+
+	return ret
+}
+
+// bookUUIDs returns the uuids of the books on the remote copy, including the
+// expunged and the skipped ones
+func (l syncList) bookUUIDs() []string {
+	ret := make([]string, 0, len(l.Books)+len(l.ExpungedBooks)+len(l.SkippedBooks))
+	for uuid := range l.Books {
+		ret = append(ret, uuid)
+	}
+	for uuid := range l.ExpungedBooks {
+		ret = append(ret, uuid)
+	}
+	for uuid := range l.SkippedBooks {
+		ret = append(ret, uuid)
+	}
+
+	return ret
+}
+
+// newSyncList returns an empty syncList
+func newSyncList() syncList {
+	return syncList{
+		Notes:         map[string]client.SyncFragNote{},
+		Books:         map[string]client.SyncFragBook{},
+		ExpungedNotes: map[string]struct{}{},
+		ExpungedBooks: map[string]struct{}{},
+		SkippedNotes:  map[string]struct{}{},
+		SkippedBooks:  map[string]struct{}{},
+	}
+}
+
+// add adds the items in the given page of changes to the list
+func (l *syncList) add(ch Changes) error {
+	for _, note := range ch.Notes {
+		l.Notes[note.UUID] = note
+	}
+	for _, book := range ch.Books {
+		l.Books[book.UUID] = book
+	}
+	for _, uuid := range ch.ExpungedBooks {
+		l.ExpungedBooks[uuid] = struct{}{}
+	}
+	for _, uuid := range ch.ExpungedNotes {
+		l.ExpungedNotes[uuid] = struct{}{}
+	}
+
+	if ch.Next != nil {
+		l.Cursor = ch.Next
+	}
+	if ch.CurrentTime > l.MaxCurrentTime {
+		l.MaxCurrentTime = ch.CurrentTime
+	}
+
+	return nil
+}
+
+// processFragments categorizes items in pages of changes into a sync list
+func processFragments(pages []Changes) (syncList, error) {
+	sl := newSyncList()
+
+	for _, ch := range pages {
+		if err := sl.add(ch); err != nil {
+			return syncList{}, errors.Wrap(err, "adding a fragment")
+		}
+	}
+
+	return sl, nil
+}
+
+// errLimitReached stops the fetching of the pages once a limit is reached
+var errLimitReached = errors.New("a limit of the sync is reached")
+
+// getSyncList gets all the changes after the given cursor and aggregates them
+// into a syncList data structure. If the limiter stops the sync early, or the
+// total timeout is reached, the list has the changes up to its cursor.
+func getSyncList(ctx context.DnoteCtx, tr Transport, after Cursor, lim *limiter) (syncList, error) {
+	ret := newSyncList()
+	ret.Cursor = after
+
+	err := fetchFragments(ctx, tr, after, func(ch Changes) error {
+		if err := ret.add(ch); err != nil {
+			return err
+		}
+		if lim.add(ch) {
+			return errLimitReached
+		}
+
+		return nil
+	})
+	// the changes got before the total timeout are kept
+	if err != nil && errors.Cause(err) != errLimitReached && !lim.timeout(ctx) {
+		return syncList{}, errors.Wrap(err, "getting sync fragments")
+	}
+
+	return ret, nil
+}
+
+// fragmentBufferSize is the number of sync fragments fetched ahead of the one
+// being processed
+const fragmentBufferSize = 1
+
+// fetchResult is a page of changes fetched from the remote copy, or the error
+// that occurred while fetching it
+type fetchResult struct {
+	after   Cursor
+	changes Changes
+	err     error
+}
+
+// fetchFragments repeatedly gets the pages of changes after the given cursor until
+// there is no more new data remaining, and calls process with each of them in order.
+// The next page is fetched while process handles the previous one. If either
+// fails, the other is canceled, and fetchFragments returns only after the fetching
+// has stopped.
+func fetchFragments(ctx context.DnoteCtx, tr Transport, after Cursor, process func(Changes) error) error {
+	fetchCtx, cancel := stdCtx.WithCancel(ctx.Context())
+
+	results := make(chan fetchResult, fragmentBufferSize)
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	go func() {
+		defer close(done)
+		defer close(results)
+
+		next := after
+
+		for {
+			ch, err := tr.Changes(fetchCtx, next)
+			// a page that does not advance the cursor would be got forever
+			if err == nil && ch.More && bytes.Equal(ch.Next, next) {
+				err = errors.Errorf("the server repeated the cursor '%s'", next)
+			}
+
+			select {
+			case results <- fetchResult{after: next, changes: ch, err: err}:
+			case <-fetchCtx.Done():
+				return
+			}
+
+			// if there is an error or no more data, stop
+			if err != nil || !ch.More {
+				return
+			}
+
+			next = ch.Next
+		}
+	}()
+
+	var complete bool
+	for r := range results {
+		if r.err != nil {
+			return errors.Wrap(r.err, "getting sync fragment")
+		}
+
+		logFragment(ctx, r.after, r.changes)
+		log.Debug("received sync fragment: %+v\n", r.changes)
+
+		if err := process(r.changes); err != nil {
+			return errors.Wrap(err, "processing sync fragment")
+		}
+
+		complete = !r.changes.More
+	}
+
+	// the fetching stops before the last fragment only if it is canceled
+	if !complete {
+		return errors.Wrap(fetchCtx.Err(), "getting sync fragment")
+	}
+
+	return nil
+}
+
+// logFragment writes the summary of a page of changes to the log file, and the
+// items in it at the debug level
+func logFragment(ctx context.DnoteCtx, after Cursor, frag Changes) {
+	ctx.FileLogger.Info("fragment", log.Fields{
+		"device":         ctx.DeviceID,
+		"after":          string(after),
+		"next":           string(frag.Next),
+		"more":           frag.More,
+		"current_time":   frag.CurrentTime,
+		"notes":          len(frag.Notes),
+		"books":          len(frag.Books),
+		"expunged_notes": len(frag.ExpungedNotes),
+		"expunged_books": len(frag.ExpungedBooks),
+	})
+
+	if ctx.FileLogger.Level() < log.FileLevelDebug {
+		return
+	}
+
+	notes := []log.Fields{}
+	for _, n := range frag.Notes {
+		notes = append(notes, log.Fields{"uuid": n.UUID, "book_uuid": n.BookUUID, "usn": n.USN, "deleted": n.Deleted})
+	}
+	books := []log.Fields{}
+	for _, b := range frag.Books {
+		books = append(books, log.Fields{"uuid": b.UUID, "usn": b.USN, "deleted": b.Deleted})
+	}
+
+	ctx.FileLogger.Debug("fragment_items", log.Fields{
+		"after":          string(after),
+		"notes":          notes,
+		"books":          books,
+		"expunged_notes": frag.ExpungedNotes,
+		"expunged_books": frag.ExpungedBooks,
+	})
+}
+
+// mergeBook inserts or updates the given book in the local database.
+// If another book with a duplicate label exists locally, it renames the duplicate,
+// or the given book if the label policy decides so, by appending a number and
+// marks it dirty so that the new label is uploaded.
+func mergeBook(tx *database.DB, stmts *stmtCache, b client.SyncFragBook, mode int) error {
+	// The server sends a deleted book without its label. Only the deletion is
+	// applied so that the local label is not overwritten with an empty one.
+	if b.Deleted {
+		if mode == modeUpdate {
+			var deleted bool
+			err := stmts.getBookDeleted.QueryRow(b.UUID).Scan(&deleted)
+			if err != nil && err != sql.ErrNoRows {
+				return errors.Wrapf(err, "checking if local book %s is deleted", b.UUID)
+			}
+
+			if _, err := tx.Exec("UPDATE books SET usn = ?, deleted = ?, dirty = ? WHERE uuid = ?", b.USN, true, false, b.UUID); err != nil {
+				return errors.Wrapf(err, "deleting local book %s", b.UUID)
+			}
+
+			if err == nil && !deleted {
+				if err := stmts.journal(database.ActorSyncDownload, database.OpRemoveBook, "", b.UUID, database.FieldChanges{}); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	var count int
+	if err := stmts.countBookLabel.QueryRow(b.Label, b.UUID).Scan(&count); err != nil {
+		return errors.Wrapf(err, "checking for books with a duplicate label %s", b.Label)
+	}
+
+	// if duplicate exists locally, rename it or the given book and mark it dirty
+	label, dirty := b.Label, false
+	if count > 0 {
+		incoming, err := stmts.labels.renameIncoming(tx, b)
+		if err != nil {
+			return errors.Wrapf(err, "deciding which book labeled %s to rename", b.Label)
+		}
+
+		newLabel, err := database.ResolveLabel(tx, b.Label)
+		if err != nil {
+			return errors.Wrap(err, "getting a new book label for conflict resolution")
+		}
+
+		if incoming {
+			label, dirty = newLabel, true
+		} else if err := renameDuplicate(tx, stmts, b, newLabel); err != nil {
+			return err
+		}
+	}
+
+	if mode == modeInsert {
+		if _, err := stmts.insertBook.Exec(b.UUID, label, b.USN, dirty, false); err != nil {
+			return errors.Wrapf(err, "inserting note with uuid %s", b.UUID)
+		}
+
+		var c database.FieldChanges
+		c.Field("label", "", label)
+		if err := stmts.journal(database.ActorSyncDownload, database.OpAddBook, "", b.UUID, c); err != nil {
+			return err
+		}
+	} else if mode == modeUpdate {
+		var oldLabel string
+		err := tx.QueryRow("SELECT label FROM books WHERE uuid = ?", b.UUID).Scan(&oldLabel)
+		if err != nil && err != sql.ErrNoRows {
+			return errors.Wrapf(err, "getting the label of local book %s", b.UUID)
+		}
+		exists := err == nil
+
+		// The state from the server overwrites the local state. In other words, the server change always wins.
+		// The label and the deleted flag are the only fields that can be changed locally, and the server
+		// overwrites both of them. Therefore the book is no longer dirty, and uploading it would send
+		// the stale local state back to the server, unless its label is renamed for a duplicate.
+		if _, err := stmts.updateBook.Exec(b.USN, b.UUID, label, b.Deleted, dirty, b.UUID); err != nil {
+			return errors.Wrapf(err, "updating local book %s", b.UUID)
+		}
+
+		if exists && oldLabel != label {
+			var c database.FieldChanges
+			c.Field("label", oldLabel, label)
+			if err := stmts.journal(database.ActorSyncDownload, database.OpRenameBook, "", b.UUID, c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// renameDuplicate renames the local books other than the given one that have
+// its label, and marks them dirty
+func renameDuplicate(tx *database.DB, stmts *stmtCache, b client.SyncFragBook, newLabel string) error {
+	a := stmts.action(database.ActorSyncDownload, database.OpRenameBook)
+	var c database.FieldChanges
+	c.Field("label", b.Label, newLabel)
+	a.Summary = c.String()
+	if err := database.InsertBookActions(tx, a, "label = ? AND uuid != ?", b.Label, b.UUID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE books SET label = ?, dirty = ? WHERE label = ? AND uuid != ?", newLabel, true, b.Label, b.UUID); err != nil {
+		return errors.Wrap(err, "resolving duplicate book label")
+	}
+
+	return nil
+}
+
+// mergeBookFromServer applies the server copy of a book in a full or step sync.
+// A book that does not exist locally is inserted. A local copy is overwritten if
+// the server copy has a higher usn, and is no longer dirty because the server
+// copy overwrites every field that can be changed locally. Otherwise, the local
+// copy is at least as new as the server copy and is kept along with its dirty
+// flag.
+func mergeBookFromServer(tx *database.DB, stmts *stmtCache, b client.SyncFragBook) error {
+	var localUSN int
+	var dirty bool
+	err := stmts.getBook.QueryRow(b.UUID).Scan(&localUSN, &dirty)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrapf(err, "getting local book %s", b.UUID)
+	}
+
+	mode := modeUpdate
+	if err == sql.ErrNoRows {
+		mode = modeInsert
+	} else if b.USN <= localUSN {
+		return nil
+	}
+
+	if err := mergeBook(tx, stmts, b, mode); err != nil {
+		return errors.Wrap(err, "resolving book")
+	}
+
+	return nil
+}
+
+func mergeNote(tx *database.DB, stmts *stmtCache, serverNote client.SyncFragNote, localNote database.Note) error {
+	var bookDeleted bool
+	err := stmts.getBookDeleted.QueryRow(localNote.BookUUID).Scan(&bookDeleted)
+	if err != nil {
+		return errors.Wrapf(err, "checking if local book %s is deleted", localNote.BookUUID)
+	}
+
+	// if the book is deleted, noop
+	if bookDeleted {
+		return nil
+	}
+
+	// if the local copy is deleted, and it was edited on the server, override with server values and mark it not dirty.
+	if localNote.Deleted {
+		if _, err := stmts.overwriteNote.Exec(serverNote.USN, serverNote.BookUUID, serverNote.Body, serverNote.EditedOn, serverNote.Deleted, serverNote.Public, false, serverNote.UUID); err != nil {
+			return errors.Wrapf(err, "updating local note %s", serverNote.UUID)
+		}
+
+		return journalNoteChange(stmts, serverNote.UUID, localNote, serverNote.BookUUID, serverNote.Body, serverNote.Deleted)
+	}
+
+	// a note changed both locally and on the server is resolved by the
+	// conflict strategy of its book, or by the configured one
+	if ok, err := stmts.strategies.resolve(tx, stmts, serverNote, localNote); err != nil || ok {
+		return err
+	}
+	if localNote.Dirty {
+		stmts.strategies.verbosef("note %s: merging with conflict markers (no conflict strategy)\n", serverNote.UUID)
+	}
+
+	return mergeNoteBodies(tx, stmts, serverNote, localNote)
+}
+
+// journalNoteChange records the change from the server to the local copy of a
+// note, unless nothing changed
+func journalNoteChange(stmts *stmtCache, uuid string, local database.Note, bookUUID, body string, deleted bool) error {
+	if deleted {
+		if local.Deleted {
+			return nil
+		}
+
+		var c database.FieldChanges
+		c.Body(local.Body, "")
+		return stmts.journal(database.ActorSyncDownload, database.OpRemoveNote, uuid, local.BookUUID, c)
+	}
+	if local.BookUUID == bookUUID && local.Body == body && !local.Deleted {
+		return nil
+	}
+
+	var c database.FieldChanges
+	c.Field("book_uuid", local.BookUUID, bookUUID)
+	c.Body(local.Body, body)
+	op := database.NoteEditOperation(local.BookUUID, bookUUID, local.Body, body)
+
+	return stmts.journal(database.ActorSyncDownload, op, uuid, bookUUID, c)
+}
+
+// journalNoteAdd records a note from the server inserted locally
+func journalNoteAdd(stmts *stmtCache, n client.SyncFragNote) error {
+	if n.Deleted {
+		return nil
+	}
+
+	var c database.FieldChanges
+	c.Body("", n.Body)
+	return stmts.journal(database.ActorSyncDownload, database.OpAddNote, n.UUID, n.BookUUID, c)
+}
+
+func stepSyncNote(tx *database.DB, stmts *stmtCache, n client.SyncFragNote) error {
+	var localNote database.Note
+	err := stmts.getNote.QueryRow(n.UUID).
+		Scan(&localNote.Body, &localNote.USN, &localNote.BookUUID, &localNote.EditedOn, &localNote.Dirty, &localNote.Deleted)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrapf(err, "getting local note %s", n.UUID)
+	}
+
+	// if note exists in the server and does not exist in the client, insert the note.
+	if err == sql.ErrNoRows {
+		if _, err := stmts.insertNote.Exec(n.UUID, n.BookUUID, n.Body, n.AddedOn, n.EditedOn, n.USN, n.Public, n.Deleted, false, n.BookUUID); err != nil {
+			return errors.Wrapf(err, "inserting note with uuid %s", n.UUID)
+		}
+		if err := journalNoteAdd(stmts, n); err != nil {
+			return err
+		}
+	} else {
+		if err := mergeNote(tx, stmts, n, localNote); err != nil {
+			return errors.Wrap(err, "merging local note")
+		}
+	}
+
+	return nil
+}
+
+func fullSyncNote(ctx context.DnoteCtx, tx *database.DB, stmts *stmtCache, n client.SyncFragNote) error {
+	var localNote database.Note
+	err := stmts.getNote.QueryRow(n.UUID).
+		Scan(&localNote.Body, &localNote.USN, &localNote.BookUUID, &localNote.EditedOn, &localNote.Dirty, &localNote.Deleted)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrapf(err, "getting local note %s", n.UUID)
+	}
+
+	// if note exists in the server and does not exist in the client, insert the note.
+	if err == sql.ErrNoRows {
+		ctx.Logger.Verbosef("note %s: inserting (not found locally)\n", n.UUID)
+
+		if _, err := stmts.insertNote.Exec(n.UUID, n.BookUUID, n.Body, n.AddedOn, n.EditedOn, n.USN, n.Public, n.Deleted, false, n.BookUUID); err != nil {
+			return errors.Wrapf(err, "inserting note with uuid %s", n.UUID)
+		}
+		if err := journalNoteAdd(stmts, n); err != nil {
+			return err
+		}
+	} else if n.USN > localNote.USN {
+		ctx.Logger.Verbosef("note %s: merging (server usn %d > local usn %d)\n", n.UUID, n.USN, localNote.USN)
+
+		if err := mergeNote(tx, stmts, n, localNote); err != nil {
+			return errors.Wrap(err, "merging local note")
+		}
+	} else if n.USN == localNote.USN && localNote.Dirty && preferServerNote(ctx.ConflictStrategy, localNote, n) {
+		strategy := ctx.ConflictStrategy
+		if strategy == "" {
+			strategy = consts.ConflictStrategyNewer
+		}
+		ctx.Logger.Verbosef("note %s: overwriting with the server copy (same usn %d, %s conflict strategy)\n", n.UUID, n.USN, strategy)
+
+		// a removal that was not uploaded discards nothing
+		if !localNote.Deleted {
+			d, err := newSafetyPolicy(ctx).resolve(actionOverwrite, "notes", []string{describeNote(n.UUID, localNote.Body)})
+			if err != nil {
+				return errors.Wrapf(err, "overwriting local note %s", n.UUID)
+			}
+			if d == decisionPreserve {
+				if err := copyConflict(tx, stmts, n, localNote); err != nil {
+					return errors.Wrapf(err, "copying local note %s", n.UUID)
+				}
+			}
+		}
+
+		if _, err := stmts.overwriteNote.Exec(n.USN, n.BookUUID, n.Body, n.EditedOn, n.Deleted, n.Public, false, n.UUID); err != nil {
+			return errors.Wrapf(err, "overwriting local note %s", n.UUID)
+		}
+		if err := journalNoteChange(stmts, n.UUID, localNote, n.BookUUID, n.Body, n.Deleted); err != nil {
+			return err
+		}
+	} else {
+		ctx.Logger.Verbosef("note %s: skipping (server usn %d <= local usn %d)\n", n.UUID, n.USN, localNote.USN)
+	}
+
+	return nil
+}
+
+// copyConflict keeps the local copy of a note that is overwritten by the server
+// copy as a new note in the conflicts book, which is uploaded by the next sync
+func copyConflict(tx *database.DB, stmts *stmtCache, n client.SyncFragNote, localNote database.Note) error {
+	bookUUID, err := getConflictsBookUUID(tx)
+	if err != nil {
+		return errors.Wrap(err, "getting the conflicts book uuid")
+	}
+
+	uuid, err := utils.GenerateUUID()
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmts.insertNote.Exec(uuid, bookUUID, localNote.Body, n.AddedOn, localNote.EditedOn, 0, false, false, true, bookUUID); err != nil {
+		return errors.Wrap(err, "inserting the copy")
+	}
+
+	var c database.FieldChanges
+	c.Body("", localNote.Body)
+	return stmts.journal(database.ActorSyncDownload, database.OpAddNote, uuid, bookUUID, c)
+}
+
+// syncDeleteNote deletes the local copy of a note expunged on the server. If
+// pruning is enabled, the book of the note is also deleted if the note was its
+// last one, unless the book is in the given books from the server.
+func syncDeleteNote(ctx context.DnoteCtx, tx *database.DB, stmts *stmtCache, noteUUID string, books map[string]client.SyncFragBook) error {
+	var localUSN int
+	var dirty, deleted bool
+	var bookUUID, body string
+	err := tx.QueryRow("SELECT usn, dirty, deleted, book_uuid, body FROM notes WHERE uuid = ?", noteUUID).Scan(&localUSN, &dirty, &deleted, &bookUUID, &body)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrapf(err, "getting local note %s", noteUUID)
+	}
+
+	// if note does not exist on client, noop
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	// if local copy is dirty, noop. it will be uploaded to the server later
+	if dirty {
+		return nil
+	}
+
+	if err := archiveExpunged(ctx, tx, stmts, "notes.uuid = ?", noteUUID); err != nil {
+		return errors.Wrapf(err, "archiving local note %s", noteUUID)
+	}
+	_, err = tx.Exec("DELETE FROM notes WHERE uuid = ?", noteUUID)
+	if err != nil {
+		return errors.Wrapf(err, "deleting local note %s", noteUUID)
+	}
+	if !deleted {
+		var c database.FieldChanges
+		c.Body(body, "")
+		if err := stmts.journal(database.ActorSyncDownload, database.OpRemoveNote, noteUUID, bookUUID, c); err != nil {
+			return err
+		}
+	}
+
+	if !ctx.PruneEmptyBooks {
+		return nil
+	}
+	if _, ok := books[bookUUID]; ok {
+		return nil
+	}
+	if err := pruneEmptyBook(tx, stmts, bookUUID); err != nil {
+		return errors.Wrapf(err, "pruning book %s", bookUUID)
+	}
+
+	return nil
+}
+
+// pruneEmptyBook deletes the local book if it has no notes and is not dirty
+func pruneEmptyBook(tx *database.DB, stmts *stmtCache, bookUUID string) error {
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM notes WHERE book_uuid = ?", bookUUID).Scan(&count); err != nil {
+		return errors.Wrap(err, "counting notes")
+	}
+	if count > 0 {
+		return nil
+	}
+
+	res, err := tx.Exec("DELETE FROM books WHERE uuid = ? AND NOT dirty", bookUUID)
+	if err != nil {
+		return errors.Wrap(err, "deleting the book")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting the deleted books")
+	}
+	if n > 0 {
+		log.Debug("pruned empty book %s\n", bookUUID)
+
+		if err := stmts.journal(database.ActorSyncDownload, database.OpRemoveBook, "", bookUUID, database.FieldChanges{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkNotesPristine checks that none of the notes in the given book are dirty
+func checkNotesPristine(tx *database.DB, bookUUID string) (bool, error) {
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM notes WHERE book_uuid = ? AND dirty", bookUUID).Scan(&count); err != nil {
+		return false, errors.Wrapf(err, "counting notes that are dirty in book %s", bookUUID)
+	}
+
+	if count > 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// syncDeleteBook deletes the local copy of a book expunged on the server along
+// with its notes, unless the book or any of its notes were changed locally
+func syncDeleteBook(ctx context.DnoteCtx, tx *database.DB, stmts *stmtCache, bookUUID string) error {
+	var localUSN int
+	var dirty, deleted bool
+	var label string
+	err := tx.QueryRow("SELECT usn, dirty, deleted, label FROM books WHERE uuid = ?", bookUUID).Scan(&localUSN, &dirty, &deleted, &label)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrapf(err, "getting local book %s", bookUUID)
+	}
+
+	// if book does not exist on client, noop
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	// if local copy is dirty, noop. it will be uploaded to the server later
+	if dirty {
+		return nil
+	}
+
+	ok, err := checkNotesPristine(tx, bookUUID)
+	if err != nil {
+		return errors.Wrap(err, "checking if any notes are dirty in book")
+	}
+	// if the local book is not pristine, do not delete but mark it as dirty
+	// so that it can be uploaded to the server later and become un-deleted
+	if !ok {
+		_, err = tx.Exec("UPDATE books SET dirty = ? WHERE uuid = ?", true, bookUUID)
+		if err != nil {
+			return errors.Wrapf(err, "marking a book dirty with uuid %s", bookUUID)
+		}
+
+		return nil
+	}
+
+	// a book that was already removed locally discards nothing
+	if !deleted {
+		if _, err := newSafetyPolicy(ctx).resolve(actionDeleteBook, "books", []string{describeBook(label)}); err != nil {
+			return errors.Wrapf(err, "deleting local book %s", bookUUID)
+		}
+	}
+
+	a := stmts.action(database.ActorSyncDownload, database.OpRemoveNote)
+	a.Summary = "removed with the book"
+	if err := database.InsertNoteActions(tx, a, "book_uuid = ? AND NOT deleted", bookUUID); err != nil {
+		return err
+	}
+	if err := archiveExpunged(ctx, tx, stmts, "notes.book_uuid = ?", bookUUID); err != nil {
+		return errors.Wrapf(err, "archiving local notes of the book %s", bookUUID)
+	}
+	_, err = tx.Exec("DELETE FROM notes WHERE book_uuid = ?", bookUUID)
+	if err != nil {
+		return errors.Wrapf(err, "deleting local notes of the book %s", bookUUID)
+	}
+
+	_, err = tx.Exec("DELETE FROM books WHERE uuid = ?", bookUUID)
+	if err != nil {
+		return errors.Wrapf(err, "deleting local book %s", bookUUID)
+	}
+
+	if deleted {
+		return nil
+	}
+
+	return stmts.journal(database.ActorSyncDownload, database.OpRemoveBook, "", bookUUID, database.FieldChanges{})
+}
+
+// getLocalUSNs returns the usn of every row in the given table, keyed by uuid
+func getLocalUSNs(tx *database.DB, table string) (map[string]int, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT uuid, usn FROM %s", table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting local %s", table)
+	}
+	defer rows.Close()
+
+	ret := map[string]int{}
+	for rows.Next() {
+		var uuid string
+		var usn int
+		if err := rows.Scan(&uuid, &usn); err != nil {
+			return nil, errors.Wrapf(err, "scanning a row of local %s", table)
+		}
+
+		ret[uuid] = usn
+	}
+
+	return ret, nil
+}
+
+// getDirtyUUIDs returns the uuids of the dirty rows in the given table
+func getDirtyUUIDs(tx *database.DB, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT uuid FROM %s WHERE dirty", table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting dirty %s", table)
+	}
+	defer rows.Close()
+
+	ret := map[string]bool{}
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, errors.Wrapf(err, "scanning a row of dirty %s", table)
+		}
+
+		ret[uuid] = true
+	}
+
+	return ret, nil
+}
+
+// noteInsertChunkSize is the number of new notes inserted at once during a
+// full sync
+const noteInsertChunkSize = 1000
+
+// positionCounter hands out the positions that append the notes to the end of
+// their books, in the order they are taken
+type positionCounter struct {
+	tx   *database.DB
+	next map[string]float64
+}
+
+func newPositionCounter(tx *database.DB) *positionCounter {
+	return &positionCounter{tx: tx, next: map[string]float64{}}
+}
+
+// take returns the position after the last note in the given book, and the
+// position after it for the next note
+func (c *positionCounter) take(bookUUID string) (float64, error) {
+	ret, ok := c.next[bookUUID]
+	if !ok {
+		var err error
+		if ret, err = database.NextNotePosition(c.tx, bookUUID); err != nil {
+			return 0, errors.Wrapf(err, "finding the last position in the book %s", bookUUID)
+		}
+	}
+
+	c.next[bookUUID] = ret + 1
+
+	return ret, nil
+}
+
+// fullSyncNotes merges the notes in a full sync list. The notes that do not exist
+// locally are inserted in bulk, and only the others are merged one by one. The
+// notes are removed from the given map as they are applied so that their bodies
+// can be freed.
+func fullSyncNotes(ctx context.DnoteCtx, tx *database.DB, stmts *stmtCache, notes map[string]client.SyncFragNote) error {
+	localUSNs, err := getLocalUSNs(tx, "notes")
+	if err != nil {
+		return errors.Wrap(err, "getting local notes")
+	}
+	dirtyUUIDs, err := getDirtyUUIDs(tx, "notes")
+	if err != nil {
+		return errors.Wrap(err, "getting dirty notes")
+	}
+
+	var news []client.SyncFragNote
+	var merges []client.SyncFragNote
+	for uuid, n := range notes {
+		localUSN, ok := localUSNs[n.UUID]
+		if !ok {
+			// the new notes are inserted below, and keep their bodies until then
+			news = append(news, client.SyncFragNote{UUID: n.UUID, AddedOn: n.AddedOn})
+			continue
+		}
+
+		delete(notes, uuid)
+
+		if n.USN > localUSN || (n.USN == localUSN && dirtyUUIDs[n.UUID]) {
+			// a dirty note with the same usn is resolved by the conflict strategy
+			merges = append(merges, n)
+		} else {
+			ctx.Logger.Verbosef("note %s: skipping (server usn %d <= local usn %d)\n", n.UUID, n.USN, localUSN)
+		}
+	}
+
+	// the new notes are appended to their books in the order they were added
+	sort.Slice(news, func(i, j int) bool {
+		if news[i].AddedOn != news[j].AddedOn {
+			return news[i].AddedOn < news[j].AddedOn
+		}
+
+		return news[i].UUID < news[j].UUID
+	})
+
+	positions := newPositionCounter(tx)
+	inserts := make([]database.Note, 0, noteInsertChunkSize)
+	actions := make([]database.Action, 0, noteInsertChunkSize)
+	for _, item := range news {
+		n := notes[item.UUID]
+		delete(notes, item.UUID)
+
+		ctx.Logger.Verbosef("note %s: inserting (not found locally)\n", n.UUID)
+
+		position, err := positions.take(n.BookUUID)
+		if err != nil {
+			return err
+		}
+
+		note := database.NewNote(n.UUID, n.BookUUID, n.Body, n.AddedOn, n.EditedOn, n.USN, n.Public, n.Deleted, false)
+		note.Position = position
+		inserts = append(inserts, note)
+		if !n.Deleted {
+			actions = append(actions, noteAddAction(stmts, n))
+		}
+
+		if len(inserts) == noteInsertChunkSize {
+			if err := insertNotes(tx, inserts, actions); err != nil {
+				return err
+			}
+
+			inserts = inserts[:0]
+			actions = actions[:0]
+		}
+	}
+
+	if err := insertNotes(tx, inserts, actions); err != nil {
+		return err
+	}
+
+	for _, n := range merges {
+		if err := fullSyncNote(ctx, tx, stmts, n); err != nil {
+			return errors.Wrap(err, "merging note")
+		}
+	}
+
+	return nil
+}
+
+// noteAddAction returns the action of inserting a note from the server
+func noteAddAction(stmts *stmtCache, n client.SyncFragNote) database.Action {
+	var c database.FieldChanges
+	c.Body("", n.Body)
+
+	a := stmts.action(database.ActorSyncDownload, database.OpAddNote)
+	a.NoteUUID = n.UUID
+	a.BookUUID = n.BookUUID
+	a.Summary = c.String()
+
+	return a
+}
+
+// insertNotes inserts the notes from the server along with their actions
+func insertNotes(tx *database.DB, notes []database.Note, actions []database.Action) error {
+	if err := database.InsertNotes(tx, notes); err != nil {
+		return errors.Wrap(err, "inserting notes")
+	}
+	if err := database.InsertActions(tx, actions); err != nil {
+		return errors.Wrap(err, "journaling the inserted notes")
+	}
+
+	return nil
+}
+
+// fullSyncBooks merges the books in a full sync list. The books that exist
+// locally are matched by uuid and merged first, so that a book renamed on the
+// server frees its old label before the new books are checked against the
+// local labels. The new books that do not conflict with a local label are then
+// inserted in bulk, and only the others are merged one by one.
+func fullSyncBooks(ctx context.DnoteCtx, tx *database.DB, stmts *stmtCache, books map[string]client.SyncFragBook) error {
+	localUSNs, err := getLocalUSNs(tx, "books")
+	if err != nil {
+		return errors.Wrap(err, "getting local books")
+	}
+
+	var created []client.SyncFragBook
+	for _, b := range books {
+		localUSN, ok := localUSNs[b.UUID]
+		if !ok {
+			// a deleted book does not need to be created locally
+			if !b.Deleted {
+				created = append(created, b)
+			}
+
+			continue
+		}
+
+		if b.USN > localUSN {
+			if err := mergeBook(tx, stmts, b, modeUpdate); err != nil {
+				return errors.Wrap(err, "merging book")
+			}
+		}
+	}
+
+	localLabels := map[string]bool{}
+	rows, err := tx.Query("SELECT label FROM books")
+	if err != nil {
+		return errors.Wrap(err, "getting local book labels")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return errors.Wrap(err, "scanning a local book label")
+		}
+
+		localLabels[label] = true
+	}
+
+	var inserts []database.Book
+	var actions []database.Action
+	var merges []client.SyncFragBook
+	for _, b := range created {
+		if localLabels[b.Label] {
+			merges = append(merges, b)
+			continue
+		}
+
+		inserts = append(inserts, database.NewBook(b.UUID, b.Label, b.USN, false, false))
+
+		var c database.FieldChanges
+		c.Field("label", "", b.Label)
+		a := stmts.action(database.ActorSyncDownload, database.OpAddBook)
+		a.BookUUID = b.UUID
+		a.Summary = c.String()
+		actions = append(actions, a)
+	}
+
+	// insert first so that resolving a duplicate label during the merges
+	// takes the new books into account
+	if err := database.InsertBooks(tx, inserts); err != nil {
+		return errors.Wrap(err, "inserting books")
+	}
+	if err := database.InsertActions(tx, actions); err != nil {
+		return errors.Wrap(err, "journaling the inserted books")
+	}
+
+	for _, b := range merges {
+		if err := mergeBook(tx, stmts, b, modeInsert); err != nil {
+			return errors.Wrap(err, "merging book")
+		}
+	}
+
+	return nil
+}
+
+// loadServerUUIDs stores the uuids of the notes or books in the full sync list,
+// including the expunged ones, in the given temporary table
+func loadServerUUIDs(tx *database.DB, table string, uuids []string) error {
+	if _, err := tx.Exec(fmt.Sprintf("CREATE TEMP TABLE %s (uuid text PRIMARY KEY) WITHOUT ROWID", table)); err != nil {
+		return errors.Wrapf(err, "creating %s", table)
+	}
+
+	// inserting in order appends to the primary key
+	sort.Strings(uuids)
+
+	if err := database.InsertUUIDs(tx, "temp."+table, uuids); err != nil {
+		return errors.Wrapf(err, "inserting into %s", table)
+	}
+
+	return nil
+}
+
+// expungeCond returns the condition of the local rows that are not in the full
+// sync list, whose uuids are loaded in the given temporary table
+func expungeCond(serverTable string) string {
+	return fmt.Sprintf("NOT (dirty AND usn = 0) AND uuid NOT IN (SELECT uuid FROM temp.%s)", serverTable)
+}
+
+// cleanLocal deletes the rows of the given table that are not in the full sync
+// list, whose uuids are loaded in the given temporary table. A row that is not
+// on the server is valid only if it is new and has not been uploaded (i.e. dirty
+// and usn is 0). The rows with changes that were not uploaded are kept in the
+// local copy as new ones instead if the sync safety policy preserves them.
+func cleanLocal(ctx context.DnoteCtx, tx *database.DB, table, kind, serverTable string) error {
+	cond := expungeCond(serverTable)
+
+	// the body of a note or the label of a book describes it to the user
+	descColumn := "body"
+	if table == "books" {
+		descColumn = "label"
+	}
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT uuid, usn, dirty, deleted, %s FROM %s WHERE %s", descColumn, table, cond))
+	if err != nil {
+		return errors.Wrapf(err, "getting local %s to expunge", table)
+	}
+	defer rows.Close()
+
+	var synced, unsynced []string
+	var syncedFields, unsyncedFields []log.Fields
+	for rows.Next() {
+		var uuid, desc string
+		var usn int
+		var dirty, deleted bool
+		if err := rows.Scan(&uuid, &usn, &dirty, &deleted, &desc); err != nil {
+			return errors.Wrapf(err, "scanning a row for local %s", kind)
+		}
+
+		fields := log.Fields{"kind": kind, "uuid": uuid, "usn": usn, "dirty": dirty, "reason": "not found on the server"}
+		if table == "notes" {
+			desc = describeNote(uuid, desc)
+		} else {
+			desc = describeBook(desc)
+		}
+
+		// a removal that was not uploaded discards nothing
+		if dirty && !deleted {
+			unsynced = append(unsynced, desc)
+			unsyncedFields = append(unsyncedFields, fields)
+		} else {
+			synced = append(synced, desc)
+			syncedFields = append(syncedFields, fields)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrapf(err, "iterating local %s", table)
+	}
+	rows.Close()
+
+	policy := newSafetyPolicy(ctx)
+	if _, err := policy.resolve(actionExpunge, table, synced); err != nil {
+		return errors.Wrapf(err, "expunging local %s", table)
+	}
+	d, err := policy.resolve(actionExpungeUnsynced, table, unsynced)
+	if err != nil {
+		return errors.Wrapf(err, "expunging local %s with unsynced changes", table)
+	}
+	if d == decisionPreserve {
+		if err := preserveUnsynced(ctx, tx, table, cond+" AND dirty AND NOT deleted"); err != nil {
+			return errors.Wrapf(err, "keeping local %s with unsynced changes", table)
+		}
+
+		unsyncedFields = nil
+	}
+
+	for _, fields := range append(syncedFields, unsyncedFields...) {
+		ctx.FileLogger.Info("expunge", fields)
+	}
+
+	// the notes and the books removed locally were journaled when they were removed
+	a := database.Action{Timestamp: ctx.Clock.Now().UnixNano(), Actor: database.ActorSyncClean, Summary: "not found on the server", Device: ctx.DeviceID}
+	if table == "notes" {
+		a.Operation = database.OpRemoveNote
+		err = database.InsertNoteActions(tx, a, cond+" AND NOT deleted")
+	} else {
+		a.Operation = database.OpRemoveBook
+		err = database.InsertBookActions(tx, a, cond+" AND NOT deleted")
+	}
+	if err != nil {
+		return errors.Wrapf(err, "journaling the expunged %s", table)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", table, cond)); err != nil {
+		return errors.Wrapf(err, "expunging local %s", table)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("DROP TABLE temp.%s", serverTable)); err != nil {
+		return errors.Wrapf(err, "dropping %s", serverTable)
+	}
+
+	return nil
+}
+
+// preserveUnsynced makes the rows of the given table that match the condition
+// new, so that they are uploaded as new notes or books by the next sync rather
+// than expunged. The notes are moved to a recovery book because their books may
+// be expunged.
+func preserveUnsynced(ctx context.DnoteCtx, tx *database.DB, table, cond string) error {
+	if table == "books" {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE books SET usn = 0 WHERE %s", cond)); err != nil {
+			return errors.Wrap(err, "resetting the books")
+		}
+
+		return nil
+	}
+
+	bookUUID, label, err := database.CreateRecoveryBook(tx)
+	if err != nil {
+		return errors.Wrap(err, "creating the recovery book")
+	}
+
+	a := database.Action{Timestamp: ctx.Clock.Now().UnixNano(), Actor: database.ActorSyncClean, Operation: database.OpMoveNote, Summary: fmt.Sprintf("not found on the server. Kept in %s", label), Device: ctx.DeviceID}
+	if err := database.InsertNoteActions(tx, a, cond); err != nil {
+		return errors.Wrap(err, "journaling the kept notes")
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("UPDATE notes SET usn = 0, book_uuid = ? WHERE %s", cond), bookUUID)
+	if err != nil {
+		return errors.Wrap(err, "moving the notes")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting the moved notes")
+	}
+
+	log.Warn(i18n.T("sync.kept_notes", n, label))
+
+	return nil
+}
+
+// cleanLocalNotes deletes from the local database any notes that are in invalid state
+// judging by the full list of resources in the server. Concretely, the only acceptable
+// situation in which a local note is not present in the server is if it is new and has not been
+// uploaded (i.e. dirty and usn is 0). Otherwise, it is a result of some kind of error and should be cleaned.
+func cleanLocalNotes(ctx context.DnoteCtx, tx *database.DB, fullList *syncList) error {
+	if err := loadServerUUIDs(tx, "server_notes", fullList.noteUUIDs()); err != nil {
+		return errors.Wrap(err, "loading server notes")
+	}
+
+	if err := cleanLocal(ctx, tx, "notes", "note", "server_notes"); err != nil {
+		return errors.Wrap(err, "expunging notes")
+	}
+
+	return nil
+}
+
+// cleanLocalBooks deletes from the local database any books that are in invalid state.
+// A local book is matched with the server by uuid only, so a book in the full
+// list is kept regardless of its label, such as one renamed on another device.
+func cleanLocalBooks(ctx context.DnoteCtx, tx *database.DB, fullList *syncList) error {
+	if err := loadServerUUIDs(tx, "server_books", fullList.bookUUIDs()); err != nil {
+		return errors.Wrap(err, "loading server books")
+	}
+
+	if err := cleanLocal(ctx, tx, "books", "book", "server_books"); err != nil {
+		return errors.Wrap(err, "expunging books")
+	}
+
+	return nil
+}
+
+// fullSync gets all the data from the remote copy and merges it. If clean is
+// true, the local notes and books that are not found in it are expunged,
+// unless the limiter stops the sync before it gets all the data.
+func fullSync(ctx context.DnoteCtx, tr Transport, tx *database.DB, clean bool, intents *intentLog, timer *phaseTimer, lim *limiter) error {
+	log.Debug("performing a full sync\n")
+	ctx.Logger.Infof("%s", i18n.T("sync.resolving_delta"))
+
+	stopDownload := timer.track(phaseDownload)
+	list, err := getSyncList(ctx, tr, nil, lim)
+	stopDownload()
+	if err != nil {
+		return errors.Wrap(err, "getting sync list")
+	}
+	timer.count(phaseDownload, list.getLength())
+
+	if err := applyScope(tx, &list); err != nil {
+		return err
+	}
+	if err := checkList(ctx, tr, tx, &list); err != nil {
+		return err
+	}
+	if err := reconcileList(ctx, tx, intents, &list); err != nil {
+		return errors.Wrap(err, "looking for the notes sent by an interrupted sync")
+	}
+
+	ctx.Logger.Rawf("%s", i18n.T("sync.total", list.getLength()))
+
+	// clean resources that are in erroneous states, which is only safe with
+	// the full list
+	if clean && !lim.stopped() {
+		stopClean := timer.track(phaseClean)
+		if err := cleanLocalNotes(ctx, tx, &list); err != nil {
+			return errors.Wrap(err, "cleaning up local notes")
+		}
+		if err := cleanLocalBooks(ctx, tx, &list); err != nil {
+			return errors.Wrap(err, "cleaning up local books")
+		}
+		stopClean()
+	}
+
+	stopApply := timer.track(phaseApply)
+	err = applyFullList(ctx, tx, &list)
+	stopApply()
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Rawf("%s", i18n.T("sync.done"))
+
+	return nil
+}
+
+// applyFullList merges the notes and the books in a full sync list, applies
+// the removals in it, and saves the cursor and the time of the list as those of
+// the last sync
+func applyFullList(ctx context.DnoteCtx, tx *database.DB, list *syncList) error {
+	stmts, err := newStmtCache(tx, ctx.Clock.Now().UnixNano(), ctx.DeviceID)
+	if err != nil {
+		return errors.Wrap(err, "preparing statements")
+	}
+	defer stmts.Close()
+	stmts.labels = newLabelPolicy(ctx, list.Notes)
+	strategies, err := newStrategyPolicy(ctx, tx)
+	if err != nil {
+		return err
+	}
+	stmts.strategies = strategies
+
+	// merge books first so that the notes moved to a new book can refer to it
+	if err := fullSyncBooks(ctx, tx, stmts, list.Books); err != nil {
+		return errors.Wrap(err, "merging books")
+	}
+	if err := fullSyncNotes(ctx, tx, stmts, list.Notes); err != nil {
+		return errors.Wrap(err, "merging notes")
+	}
+	// release the emptied map so that only the expunged uuids are retained
+	list.Notes = nil
+
+	for noteUUID := range list.ExpungedNotes {
+		if err := syncDeleteNote(ctx, tx, stmts, noteUUID, list.Books); err != nil {
+			return errors.Wrap(err, "deleting note")
+		}
+	}
+	for bookUUID := range list.ExpungedBooks {
+		if err := syncDeleteBook(ctx, tx, stmts, bookUUID); err != nil {
+			return errors.Wrap(err, "deleting book")
+		}
+	}
+
+	if err := saveSyncState(tx, list.MaxCurrentTime, list.Cursor); err != nil {
+		return errors.Wrap(err, "saving sync state")
+	}
+
+	return nil
+}
+
+func stepSync(ctx context.DnoteCtx, tr Transport, tx *database.DB, after Cursor, intents *intentLog, timer *phaseTimer, lim *limiter) error {
+	log.Debug("performing a step sync\n")
+
+	ctx.Logger.Infof("%s", i18n.T("sync.resolving_delta"))
+
+	stopDownload := timer.track(phaseDownload)
+	list, err := getSyncList(ctx, tr, after, lim)
+	stopDownload()
+	if err != nil {
+		return errors.Wrap(err, "getting sync list")
+	}
+	timer.count(phaseDownload, list.getLength())
+
+	if err := applyScope(tx, &list); err != nil {
+		return err
+	}
+	if err := checkList(ctx, tr, tx, &list); err != nil {
+		return err
+	}
+	if err := reconcileList(ctx, tx, intents, &list); err != nil {
+		return errors.Wrap(err, "looking for the notes sent by an interrupted sync")
+	}
+
+	ctx.Logger.Rawf("%s", i18n.T("sync.total", list.getLength()))
+
+	defer timer.track(phaseApply)()
+
+	stmts, err := newStmtCache(tx, ctx.Clock.Now().UnixNano(), ctx.DeviceID)
+	if err != nil {
+		return errors.Wrap(err, "preparing statements")
+	}
+	defer stmts.Close()
+	stmts.labels = newLabelPolicy(ctx, list.Notes)
+	strategies, err := newStrategyPolicy(ctx, tx)
+	if err != nil {
+		return err
+	}
+	stmts.strategies = strategies
+
+	// merge books first so that the notes moved to a new book can refer to it
+	for _, book := range list.Books {
+		if err := mergeBookFromServer(tx, stmts, book); err != nil {
+			return errors.Wrap(err, "merging book")
+		}
+	}
+	for uuid, note := range list.Notes {
+		// remove the applied note so that its body can be freed
+		delete(list.Notes, uuid)
+
+		if err := stepSyncNote(tx, stmts, note); err != nil {
+			return errors.Wrap(err, "merging note")
+		}
+	}
+	list.Notes = nil
+
+	for noteUUID := range list.ExpungedNotes {
+		if err := syncDeleteNote(ctx, tx, stmts, noteUUID, list.Books); err != nil {
+			return errors.Wrap(err, "deleting note")
+		}
+	}
+	for bookUUID := range list.ExpungedBooks {
+		if err := syncDeleteBook(ctx, tx, stmts, bookUUID); err != nil {
+			return errors.Wrap(err, "deleting book")
+		}
+	}
+
+	err = saveSyncState(tx, list.MaxCurrentTime, list.Cursor)
+	if err != nil {
+		return errors.Wrap(err, "saving sync state")
+	}
+
+	ctx.Logger.Rawf("%s", i18n.T("sync.done"))
+
+	return nil
+}
+
+func sendBooks(ctx context.DnoteCtx, tr Transport, tx *database.DB, a allowance) (bool, error) {
+	isBehind := false
+	room := a.books
+
+	// send the deletions and the renames before the new books, so that a label
+	// freed by one of them on the server can be taken by a new book. The
+	// oldest new books are sent first, in case not all of them fit in the
+	// limit of the account.
+	rows, err := tx.Query("SELECT uuid, label, usn, deleted FROM books WHERE dirty ORDER BY deleted DESC, usn = 0, rowid")
+	if err != nil {
+		return isBehind, errors.Wrap(err, "getting syncable books")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var book database.Book
+
+		if err = rows.Scan(&book.UUID, &book.Label, &book.USN, &book.Deleted); err != nil {
+			return isBehind, errors.Wrap(err, "scanning a syncable book")
+		}
+
+		log.Debug("sending book %s\n", book.UUID)
+
+		var m Mutation
+
+		// if new, create it in the server, or else, update.
+		if book.USN == 0 {
+			if book.Deleted {
+				ctx.Logger.Verbosef("book %s: expunging locally (added and deleted without being synced)\n", book.UUID)
+
+				err = book.Expunge(tx)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "expunging a book locally")
+				}
+
+				continue
+			} else if room == 0 {
+				ctx.Logger.Verbosef("book %s: holding back (above the limit of the account)\n", book.UUID)
+
+				continue
+			} else {
+				ctx.Logger.Verbosef("book %s: creating on the server\n", book.UUID)
+
+				if room > 0 {
+					room--
+				}
+
+				m, err = tr.CreateBook(ctx.Context(), book.Label)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "creating a book")
+				}
+
+				_, err = tx.Exec("UPDATE notes SET book_uuid = ? WHERE book_uuid = ?", m.UUID, book.UUID)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "updating book_uuids of notes")
+				}
+
+				book.Dirty = false
+				book.USN = m.USN
+				err = book.Update(tx)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "marking book dirty")
+				}
+
+				ctx.FileLogger.Info("uuid_remap", log.Fields{"kind": "book", "old_uuid": book.UUID, "new_uuid": m.UUID, "usn": m.USN})
+
+				err = book.UpdateUUID(tx, m.UUID)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "updating book uuid")
+				}
+			}
+		} else {
+			if book.Deleted {
+				ctx.Logger.Verbosef("book %s: deleting on the server\n", book.UUID)
+
+				m, err = tr.DeleteBook(ctx.Context(), book.UUID)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "deleting a book")
+				}
+
+				err = book.Expunge(tx)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "expunging a book locally")
+				}
+			} else {
+				ctx.Logger.Verbosef("book %s: updating on the server\n", book.UUID)
+
+				m, err = tr.UpdateBook(ctx.Context(), book.UUID, book.Label)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "updating a book")
+				}
+
+				book.Dirty = false
+				book.USN = m.USN
+				err = book.Update(tx)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "marking book dirty")
+				}
+			}
+		}
+
+		behind, err := advanceCursor(tr, tx, m)
+		if err != nil {
+			return isBehind, err
+		}
+		isBehind = isBehind || behind
+
+		log.Debug("sent book %s. response USN %d. behind: %t\n", book.UUID, m.USN, behind)
+	}
+
+	return isBehind, nil
+}
+
+// noteSendError wraps the error of sending the note. A note that the server
+// refuses as too large stays dirty and fails every sync, so the error explains
+// how to fix it and how the size limit on writing notes relates to it.
+func noteSendError(ctx context.DnoteCtx, err error, note database.Note, message string) error {
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusRequestEntityTooLarge {
+		return errors.Wrap(err, message)
+	}
+
+	size := int64(len(note.Body))
+	hint := "Split the note or make it smaller with dnote edit"
+	if ctx.NoteSizeMax > 0 && size <= ctx.NoteSizeMax {
+		hint = fmt.Sprintf("%s, and set noteSize.max in the config, now %d bytes, to the limit of the server to refuse such notes when they are written", hint, ctx.NoteSizeMax)
+	} else if ctx.NoteSizeMax > 0 {
+		hint = fmt.Sprintf("%s. It is above the limit of %d bytes, which was overridden with --force", hint, ctx.NoteSizeMax)
+	}
+
+	return errors.Wrapf(err, "%s: the note %s is %d bytes, which is too large for the server. %s", message, note.UUID, size, hint)
+}
+
+func sendNotes(ctx context.DnoteCtx, tr Transport, tx *database.DB, a allowance, intents *intentLog) (bool, error) {
+	isBehind := false
+	unchecked := 0
+
+	held, err := heldNotes(tx, a)
+	if err != nil {
+		return isBehind, errors.Wrap(err, "getting the notes above the limit of the account")
+	}
+
+	rows, err := tx.Query("SELECT uuid, book_uuid, body, public, deleted, usn, added_on FROM notes WHERE dirty")
+	if err != nil {
+		return isBehind, errors.Wrap(err, "getting syncable notes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var note database.Note
+
+		if err = rows.Scan(&note.UUID, &note.BookUUID, &note.Body, &note.Public, &note.Deleted, &note.USN, &note.AddedOn); err != nil {
+			return isBehind, errors.Wrap(err, "scanning a syncable note")
+		}
+
+		if held[note.UUID] {
+			ctx.Logger.Verbosef("note %s: holding back (above the limit of the account)\n", note.UUID)
+			continue
+		}
+
+		log.Debug("sending note %s\n", note.UUID)
+
+		// store the body as it is sent so that the local copy equals the server copy
+		note.Body = utils.NormalizeBody(note.Body)
+
+		var m Mutation
+
+		// if new, create it in the server, or else, update.
+		if note.USN == 0 {
+			if note.Deleted {
+				ctx.Logger.Verbosef("note %s: expunging locally (added and deleted without being synced)\n", note.UUID)
+
+				// if a note was added and deleted locally, simply expunge
+				err = note.Expunge(tx)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "expunging a note locally")
+				}
+
+				continue
+			} else {
+				// the note might have been created by an interrupted sync,
+				// which is not known without the changes of the server
+				if intents.isUnchecked(note.UUID) {
+					ctx.Logger.Verbosef("note %s: holding back (sent by an interrupted sync)\n", note.UUID)
+					unchecked++
+					continue
+				}
+
+				ctx.Logger.Verbosef("note %s: creating on the server\n", note.UUID)
+
+				in, err := intents.record(note)
+				if err != nil {
+					return isBehind, err
+				}
+				if c, ok := tr.(idempotentCreator); ok && in.Key != "" {
+					m, err = c.createNoteOnce(ctx.Context(), note.BookUUID, note.Body, in.Key)
+				} else {
+					m, err = tr.CreateNote(ctx.Context(), note.BookUUID, note.Body)
+				}
+				if err != nil {
+					return isBehind, noteSendError(ctx, err, note, "creating a note")
+				}
+				if err := intents.resolve(note.UUID, m.UUID, m.USN); err != nil {
+					return isBehind, err
+				}
+
+				note.Dirty = false
+				note.USN = m.USN
+				err = note.Update(tx)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "marking note dirty")
+				}
+
+				ctx.FileLogger.Info("uuid_remap", log.Fields{"kind": "note", "old_uuid": note.UUID, "new_uuid": m.UUID, "usn": m.USN})
+
+				err = note.UpdateUUID(tx, m.UUID)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "updating note uuid")
+				}
+			}
+		} else {
+			if note.Deleted {
+				ctx.Logger.Verbosef("note %s: deleting on the server\n", note.UUID)
+
+				m, err = tr.DeleteNote(ctx.Context(), note.UUID)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "deleting a note")
+				}
+
+				err = note.Expunge(tx)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "expunging a note locally")
+				}
+			} else {
+				ctx.Logger.Verbosef("note %s: updating on the server\n", note.UUID)
+
+				m, err = tr.UpdateNote(ctx.Context(), note.UUID, note.BookUUID, note.Body, note.Public)
+				if err != nil {
+					return isBehind, noteSendError(ctx, err, note, "updating a note")
+				}
+
+				note.Dirty = false
+				note.USN = m.USN
+				err = note.Update(tx)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "marking note dirty")
+				}
+			}
+		}
+
+		behind, err := advanceCursor(tr, tx, m)
+		if err != nil {
+			return isBehind, err
+		}
+		isBehind = isBehind || behind
+
+		log.Debug("sent note %s. response USN %d. behind: %t\n", note.UUID, m.USN, behind)
+	}
+
+	if unchecked > 0 {
+		ctx.Logger.Warnf("%s", i18n.T("sync.intent_held", unchecked))
+	}
+
+	return isBehind, nil
+}
+
+func sendChanges(ctx context.DnoteCtx, tr Transport, tx *database.DB, intents *intentLog) (bool, error) {
+	ctx.Logger.Infof("%s", i18n.T("sync.sending_changes"))
+
+	delta, err := countDirty(tx)
+	if err != nil {
+		return false, err
+	}
+
+	ctx.Logger.Rawf("%s", i18n.T("sync.total", delta))
+
+	a, err := checkQuota(ctx, tr, tx)
+	if err != nil {
+		return false, err
+	}
+
+	behind1, err := sendBooks(ctx, tr, tx, a)
+	if err != nil {
+		return behind1, errors.Wrap(err, "sending books")
+	}
+
+	behind2, err := sendNotes(ctx, tr, tx, a, intents)
+	if err != nil {
+		return behind2, errors.Wrap(err, "sending notes")
+	}
+
+	ctx.Logger.Rawf("%s", i18n.T("sync.done"))
+
+	if a != unlimited {
+		if err := reportHeld(ctx, tx); err != nil {
+			return behind1 || behind2, err
+		}
+	}
+
+	isBehind := behind1 || behind2
+
+	return isBehind, nil
+}
+
+// countDirty returns the number of the notes and the books with the changes
+// to upload
+func countDirty(tx *database.DB) (int, error) {
+	var ret int
+	if err := tx.QueryRow("SELECT (SELECT count(*) FROM notes WHERE dirty) + (SELECT count(*) FROM books WHERE dirty)").Scan(&ret); err != nil {
+		return 0, errors.Wrap(err, "counting the local changes")
+	}
+
+	return ret, nil
+}
+
+// upload sends the local changes, adding the time and the number of the notes
+// and the books sent to the upload phase. The ones sent before an error are
+// counted too.
+func upload(ctx context.DnoteCtx, tr Transport, tx *database.DB, intents *intentLog, timer *phaseTimer) (bool, error) {
+	before, err := database.CountDirty(tx)
+	if err != nil {
+		return false, errors.Wrap(err, "counting the local changes")
+	}
+
+	stop := timer.track(phaseUpload)
+	isBehind, err := sendChanges(ctx, tr, tx, intents)
+	stop()
+
+	if after, cErr := database.CountDirty(tx); cErr == nil {
+		timer.count(phaseUpload, before.Notes.Total()+before.Books.Total()-after.Notes.Total()-after.Books.Total())
+		timer.countUploaded(countSent(before.Notes, after.Notes), countSent(before.Books, after.Books))
+	}
+
+	return isBehind, err
+}
+
+// countSent returns the number of the new and the edited records that were
+// sent, given the local changes before and after sending them
+func countSent(before, after database.ChangeCounts) int {
+	return before.Created + before.Updated - after.Created - after.Updated
+}
+
+func updateLastCursor(tx *database.DB, c Cursor) error {
+	if err := database.UpsertSystem(tx, consts.SystemLastMaxUSN, string(c)); err != nil {
+		return errors.Wrapf(err, "updating %s", consts.SystemLastMaxUSN)
+	}
+
+	return nil
+}
+
+// advanceCursor moves the last cursor to that of the mutation if no other
+// change was made on the remote copy in between. Otherwise, it reports that
+// the client is behind and needs to get the changes it missed.
+func advanceCursor(tr Transport, tx *database.DB, m Mutation) (bool, error) {
+	cursor, err := getLastCursor(tx)
+	if err != nil {
+		return false, errors.Wrap(err, "getting the last cursor")
+	}
+
+	if !tr.Follows(cursor, m) {
+		return true, nil
+	}
+
+	if err := updateLastCursor(tx, m.Cursor); err != nil {
+		return false, errors.Wrap(err, "updating the last cursor")
+	}
+
+	return false, nil
+}
+
+func updateLastSyncAt(tx *database.DB, val int64) error {
+	if err := database.UpsertSystem(tx, consts.SystemLastSyncAt, val); err != nil {
+		return errors.Wrapf(err, "updating %s", consts.SystemLastSyncAt)
+	}
+
+	return nil
+}
+
+func saveSyncState(tx *database.DB, serverTime int64, cursor Cursor) error {
+	if err := updateLastCursor(tx, cursor); err != nil {
+		return errors.Wrap(err, "updating the last cursor")
+	}
+	if err := updateLastSyncAt(tx, serverTime); err != nil {
+		return errors.Wrap(err, "updating last sync at")
+	}
+
+	return nil
+}
+
+// performSync gets the changes from the remote copy through the transport and
+// sends the local changes to it, or does only one of them depending on the
+// direction. The given database can be either a transaction or a connection.
+// The time spent in each phase is added to the timer. If the limiter stops the
+// sync early, the changes got so far are applied and the local changes are not
+// sent. The limiter can be nil for no limit. The notes created on the remote
+// copy are recorded in the upload intent log first, so that those sent by an
+// interrupted sync are not created again.
+func performSync(ctx context.DnoteCtx, tr Transport, tx *database.DB, full bool, dir direction, timer *phaseTimer, lim *limiter) error {
+	intents, err := openIntentLog(ctx)
+	if err != nil {
+		return err
+	}
+	defer intents.Close()
+	if err := reconcileIntents(ctx, tx, intents); err != nil {
+		return errors.Wrap(err, "reconciling the upload intents")
+	}
+
+	if dir == directionPush {
+		return pushChanges(ctx, tr, tx, intents, timer, lim)
+	}
+
+	syncState, err := tr.State(ctx.Context())
+	if err != nil {
+		return errors.Wrap(err, "getting the sync state from the server")
+	}
+	// an unsupported version is warned about before any change is made, and
+	// the sync goes on in case the server still accepts it
+	upgrade.WarnUnsupported(ctx, syncState.MinVersion)
+	if err := upgrade.SaveLatest(tx, syncState.LatestVersion); err != nil {
+		return err
+	}
+	lastSyncAt, err := getLastSyncAt(tx)
+	if err != nil {
+		return errors.Wrap(err, "getting the last sync time")
+	}
+	lastCursor, err := getLastCursor(tx)
+	if err != nil {
+		return errors.Wrap(err, "getting the last cursor")
+	}
+
+	log.Debug("lastSyncAt: %d, lastCursor: %s, syncState: %+v\n", lastSyncAt, lastCursor, syncState)
+
+	added, err := updateScope(ctx, tx, lastSyncAt == 0 && lastCursor == nil)
+	if err != nil {
+		return err
+	}
+
+	full = full || lastSyncAt < syncState.FullSyncBefore
+	// a full sync gets the books new to the scope along with the others
+	if len(added) > 0 && !full {
+		if err := backfill(ctx, tr, tx, added, timer); err != nil {
+			return errors.Wrap(err, "getting the books added to the scope")
+		}
+	}
+
+	var syncErr error
+	if full {
+		// the local changes that are not sent would be expunged as unknown
+		// to the server
+		syncErr = fullSync(ctx, tr, tx, dir == directionBoth, intents, timer, lim)
+	} else if !bytes.Equal(lastCursor, syncState.Cursor) {
+		syncErr = stepSync(ctx, tr, tx, lastCursor, intents, timer, lim)
+	} else {
+		// if no need to sync from the server, simply update the last sync timestamp and proceed to send changes
+		err = updateLastSyncAt(tx, syncState.CurrentTime)
+		if err != nil {
+			return errors.Wrap(err, "updating last sync at")
+		}
+	}
+	if syncErr != nil {
+		return errors.Wrap(syncErr, "syncing changes from the server")
+	}
+	if err := infra.CapExpungedArchive(ctx, tx); err != nil {
+		return err
+	}
+
+	// the next sync continues from the saved cursor
+	if lim.stopped() {
+		return countRemaining(tr, tx, syncState.Cursor, lim)
+	}
+	// the notes of the intents not found in the changes were not created
+	intents.checked()
+
+	if dir == directionPull {
+		return nil
+	}
+
+	isBehind, err := upload(ctx, tr, tx, intents, timer)
+	// the changes sent before the total timeout are kept, and the rest are
+	// sent by the next sync
+	if err != nil && lim.timeout(ctx) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "sending changes")
+	}
+
+	// if server state gets ahead of that of client during the sync, do an additional step sync
+	if isBehind {
+		log.Debug("performing another step sync because client is behind\n")
+
+		updatedCursor, err := getLastCursor(tx)
+		if err != nil {
+			return errors.Wrap(err, "getting the new last cursor")
+		}
+
+		err = stepSync(ctx, tr, tx, updatedCursor, intents, timer, lim)
+		if err != nil {
+			return errors.Wrap(err, "performing the follow-up step sync")
+		}
+		// the number of the changes left is unknown because the remote copy
+		// has changed since its state was got
+		if lim.stopped() {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// countRemaining records in the limiter the number of the changes between the
+// saved cursor and the latest one
+func countRemaining(tr Transport, tx *database.DB, latest Cursor, lim *limiter) error {
+	cursor, err := getLastCursor(tx)
+	if err != nil {
+		return errors.Wrap(err, "getting the last cursor")
+	}
+
+	lim.countRemaining(tr, cursor, latest)
+
+	return nil
+}
+
+// pushChanges sends the local changes without getting those of the remote copy.
+// The last cursor is kept so that the remote changes made since the last sync,
+// which were not merged, are still synced by the next one. The new notes that
+// might have been created by an interrupted sync are held back, as the changes
+// to find them in are not got.
+func pushChanges(ctx context.DnoteCtx, tr Transport, tx *database.DB, intents *intentLog, timer *phaseTimer, lim *limiter) error {
+	lastCursor, err := getLastCursor(tx)
+	if err != nil {
+		return errors.Wrap(err, "getting the last cursor")
+	}
+
+	_, err = upload(ctx, tr, tx, intents, timer)
+	// the changes sent before the total timeout are kept
+	if err != nil && !lim.timeout(ctx) {
+		return errors.Wrap(err, "sending changes")
+	}
+
+	if err := updateLastCursor(tx, lastCursor); err != nil {
+		return errors.Wrap(err, "restoring the last cursor")
+	}
+
+	return nil
+}
+
+// reportPhases reports the time spent in each phase of a sync in the verbose
+// output and the log file
+func reportPhases(ctx context.DnoteCtx, timer *phaseTimer) {
+	ctx.Logger.Verbosef("sync %s\n", timer.summary())
+	fields := timer.fields()
+	fields["device"] = ctx.DeviceID
+	ctx.FileLogger.Info("phases", fields)
+}
+
+// countRecords returns the numbers of the notes and the books that are not
+// deleted and match the condition
+func countRecords(db *database.DB, cond string) (int, int, error) {
+	var notes, books int
+
+	if err := db.QueryRow("SELECT count(*) FROM notes WHERE deleted = false AND " + cond).Scan(&notes); err != nil {
+		return 0, 0, errors.Wrap(err, "counting notes")
+	}
+	if err := db.QueryRow("SELECT count(*) FROM books WHERE deleted = false AND " + cond).Scan(&books); err != nil {
+		return 0, 0, errors.Wrap(err, "counting books")
+	}
+
+	return notes, books, nil
+}
+
+// loadSession returns the context with the session key of the user, or an
+// error if the user is not logged in
+func loadSession(ctx context.DnoteCtx) (context.DnoteCtx, error) {
+	ctx, err := infra.LoadSessionKey(ctx)
+	if err != nil {
+		return ctx, errors.Wrap(err, "loading the session key")
+	}
+	if ctx.SessionKey == "" {
+		if ctx.LocalOnly {
+			return ctx, ErrLocalOnly
+		}
+
+		return ctx, ErrNotLoggedIn
+	}
+
+	return ctx, nil
+}
+
+// Run syncs the notes and the books with the backend set in the config,
+// pushes the local changes to the mirrors and runs the sync hook. The sync is
+// aborted when ctx.Ctx is canceled, leaving the local data unchanged. If it
+// reaches the deadline of ctx.Ctx or its total timeout while it gets or sends
+// the changes, it stops early and keeps the changes exchanged so far.
+func Run(ctx context.DnoteCtx, opts Options) (Report, error) {
+	report := Report{Backend: ctx.SyncBackend, Remaining: -1}
+
+	if opts.Force {
+		ctx.SyncSafety = consts.SyncSafetyPermissive
+	}
+	if opts.Partial {
+		ctx.SyncPartial = true
+	}
+	ctx.SyncBooks = opts.Books
+	ctx.SyncInitial = opts.Initial
+	if opts.Timeouts != nil {
+		ctx.SyncTimeouts = *opts.Timeouts
+	}
+	if t := ctx.SyncTimeouts.TotalTimeout; t > 0 {
+		c, cancel := stdCtx.WithTimeout(ctx.Context(), t)
+		defer cancel()
+
+		ctx.Ctx = c
+	}
+
+	b, err := newBackend(ctx, opts)
+	if err != nil {
+		return report, err
+	}
+	if b.needsSession() {
+		if ctx, err = loadSession(ctx); err != nil {
+			return report, err
+		}
+	}
+
+	if err := infra.AcquireSyncLock(ctx); err != nil {
+		return report, errors.Wrap(err, "acquiring the sync lock")
+	}
+	defer func() {
+		if err := infra.ReleaseSyncLock(ctx); err != nil {
+			log.Errorf("%s\n", errors.Wrap(err, "releasing the sync lock").Error())
+		}
+	}()
+
+	if b.needsSession() {
+		if err := migrate.Run(ctx, migrate.RemoteSequence, migrate.RemoteMode); err != nil {
+			return report, errors.Wrap(err, "running remote migrations")
+		}
+	}
+
+	// taken under the lock so that no other sync changes the database in
+	// between
+	if err := autoBackup(ctx); err != nil {
+		return report, errors.Wrap(err, "backing up the database before the sync")
+	}
+
+	startedAt := time.Now()
+	timer := newPhaseTimer(ctx.Clock.Now)
+	err = infra.WithTx(ctx.DB, func(tx *database.DB) error {
+		return b.sync(ctx, tx, timer)
+	})
+	timer.stop()
+	reportPhases(ctx, timer)
+	report.timer = timer
+	report.Phases = timer.phases()
+	report.UploadedNotes = timer.uploadedNotes
+	report.UploadedBooks = timer.uploadedBooks
+
+	if err != nil {
+		if ctx.Context().Err() == stdCtx.DeadlineExceeded {
+			return report, errors.Wrap(err, "sync timed out")
+		}
+		if ctx.Context().Err() != nil {
+			return report, errors.Wrap(err, "sync was interrupted")
+		}
+
+		return report, err
+	}
+
+	if sb, ok := b.(serverBackend); ok && sb.limiter.stopped() {
+		report.StoppedEarly = sb.limiter.reason
+		report.Remaining = sb.limiter.remaining
+
+		ctx.Logger.Warnf("%s", stopMessage(report.StoppedEarly, report.Remaining))
+	} else {
+		ctx.Logger.Successf("%s", i18n.T("sync.success"))
+	}
+	ctx.Logger.Printf("%s", timer.line())
+
+	// no time is left for the mirrors after the total timeout
+	if _, ok := b.(serverBackend); ok && report.StoppedEarly != StopTimeout {
+		pushMirrors(ctx)
+	}
+
+	report.Duration = time.Since(startedAt)
+	report.Notes, report.Books, err = countRecords(ctx.DB, "true")
+	if err != nil {
+		log.Warnf("%s\n", errors.Wrap(err, "counting the notes and the books after the sync").Error())
+		return report, nil
+	}
+
+	if ctx.Hooks.OnSyncComplete != "" {
+		ctx.Hooks.SyncComplete(hooks.SyncSummary{
+			Backend:       report.Backend,
+			UploadedNotes: report.UploadedNotes,
+			UploadedBooks: report.UploadedBooks,
+			Notes:         report.Notes,
+			Books:         report.Books,
+			Duration:      report.Duration.Milliseconds(),
+		})
+	}
+
+	return report, nil
+}
@@ -0,0 +1,4132 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/migrate"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+func TestProcessFragments(t *testing.T) {
+	fragments := []Changes{
+		{
+			Next:        Cursor("10"),
+			More:        true,
+			CurrentTime: 1550436136,
+			Notes: []client.SyncFragNote{
+				{
+					UUID: "45546de0-40ed-45cf-9bfc-62ce729a7d3d",
+					Body: "Lorem ipsum dolor sit amet, consectetur adipiscing elit.\n Donec ac libero efficitur, posuere dui non, egestas lectus.\n Aliquam urna ligula, sagittis eu volutpat vel, consequat et augue.\n\n Ut mi urna, dignissim a ex eget, venenatis accumsan sem. Praesent facilisis, ligula hendrerit auctor varius, mauris metus hendrerit dolor, sit amet pulvinar.",
+				},
+				{
+					UUID: "a25a5336-afe9-46c4-b881-acab911c0bc3",
+					Body: "foo bar baz quz\nqux",
+				},
+			},
+			Books: []client.SyncFragBook{
+				{
+					UUID:  "e8ac6f25-d95b-435a-9fae-094f7506a5ac",
+					Label: "foo",
+				},
+				{
+					UUID:  "05fd8b95-ddcd-4071-9380-4358ffb8a436",
+					Label: "foo-bar-baz-1000",
+				},
+			},
+			ExpungedNotes: []string{},
+			ExpungedBooks: []string{},
+		},
+	}
+
+	// exec
+	sl, err := processFragments(fragments)
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	expected := syncList{
+		Notes: map[string]client.SyncFragNote{
+			"45546de0-40ed-45cf-9bfc-62ce729a7d3d": {
+				UUID: "45546de0-40ed-45cf-9bfc-62ce729a7d3d",
+				Body: "Lorem ipsum dolor sit amet, consectetur adipiscing elit.\n Donec ac libero efficitur, posuere dui non, egestas lectus.\n Aliquam urna ligula, sagittis eu volutpat vel, consequat et augue.\n\n Ut mi urna, dignissim a ex eget, venenatis accumsan sem. Praesent facilisis, ligula hendrerit auctor varius, mauris metus hendrerit dolor, sit amet pulvinar.",
+			},
+			"a25a5336-afe9-46c4-b881-acab911c0bc3": {
+				UUID: "a25a5336-afe9-46c4-b881-acab911c0bc3",
+				Body: "foo bar baz quz\nqux",
+			},
+		},
+		Books: map[string]client.SyncFragBook{
+			"e8ac6f25-d95b-435a-9fae-094f7506a5ac": {
+				UUID:  "e8ac6f25-d95b-435a-9fae-094f7506a5ac",
+				Label: "foo",
+			},
+			"05fd8b95-ddcd-4071-9380-4358ffb8a436": {
+				UUID:  "05fd8b95-ddcd-4071-9380-4358ffb8a436",
+				Label: "foo-bar-baz-1000",
+			},
+		},
+		ExpungedNotes:  map[string]struct{}{},
+		ExpungedBooks:  map[string]struct{}{},
+		SkippedNotes:   map[string]struct{}{},
+		SkippedBooks:   map[string]struct{}{},
+		Cursor:         Cursor("10"),
+		MaxCurrentTime: 1550436136,
+	}
+
+	// test
+	assert.DeepEqual(t, sl, expected, "syncList mismatch")
+}
+
+func TestGetLastSyncAt(t *testing.T) {
+	testCases := []struct {
+		name     string
+		setup    func(t *testing.T, db *database.DB)
+		expected int
+	}{
+		{
+			name:     "fresh database",
+			setup:    func(t *testing.T, db *database.DB) {},
+			expected: 0,
+		},
+		{
+			name: "deleted record",
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "setting up last_sync_at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 1541108743)
+				database.MustExec(t, "deleting last_sync_at", db, "DELETE FROM system WHERE key = ?", consts.SystemLastSyncAt)
+			},
+			expected: 0,
+		},
+		{
+			name: "existing record",
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "setting up last_sync_at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 1541108743)
+			},
+			expected: 1541108743,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			db := database.InitTestDB(t, "../tmp/.dnote", nil)
+			defer database.TeardownTestDB(t, db)
+			tc.setup(t, db)
+
+			// exec
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+			}
+
+			got, err := getLastSyncAt(tx)
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, "getting last_sync_at").Error())
+			}
+
+			tx.Commit()
+
+			// test
+			assert.Equal(t, got, tc.expected, "last_sync_at mismatch")
+		})
+	}
+}
+
+func TestGetLastCursor(t *testing.T) {
+	testCases := []struct {
+		name     string
+		setup    func(t *testing.T, db *database.DB)
+		expected Cursor
+	}{
+		{
+			name:     "fresh database",
+			setup:    func(t *testing.T, db *database.DB) {},
+			expected: nil,
+		},
+		{
+			name: "deleted record",
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "setting up last_max_usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 20001)
+				database.MustExec(t, "deleting last_max_usn", db, "DELETE FROM system WHERE key = ?", consts.SystemLastMaxUSN)
+			},
+			expected: nil,
+		},
+		{
+			name: "existing record",
+			setup: func(t *testing.T, db *database.DB) {
+				database.MustExec(t, "setting up last_max_usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 20001)
+			},
+			expected: Cursor("20001"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			db := database.InitTestDB(t, "../tmp/.dnote", nil)
+			defer database.TeardownTestDB(t, db)
+			tc.setup(t, db)
+
+			// exec
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+			}
+
+			got, err := getLastCursor(tx)
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, "getting the last cursor").Error())
+			}
+
+			tx.Commit()
+
+			// test
+			assert.DeepEqual(t, got, tc.expected, "cursor mismatch")
+		})
+	}
+}
+
+func TestSyncDeleteNote(t *testing.T) {
+	t.Run("exists on server only", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		f := testutils.NewFixture(t, db)
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		if err := syncDeleteNote(context.DnoteCtx{}, tx, mustPrepareStmts(t, tx), "nonexistent-note-uuid", nil); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		assert.Equalf(t, f.MustCountNotes(), 0, "note count mismatch")
+		assert.Equalf(t, f.MustCountBooks(), 0, "book count mismatch")
+	})
+
+	t.Run("local copy is dirty", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		f := testutils.NewFixture(t, db)
+		b1 := f.Book("b1-label")
+		n1 := f.Note(b1, "n1 body", testutils.With.USN(10), testutils.With.Dirty())
+		n2 := f.Note(b1, "n2 body", testutils.With.USN(11), testutils.With.Dirty())
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction for test case").Error())
+		}
+
+		if err := syncDeleteNote(context.DnoteCtx{}, tx, mustPrepareStmts(t, tx), n1.UUID, nil); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		// do not delete note if local copy is dirty
+		assert.Equalf(t, f.MustCountNotes(), 2, "note count mismatch for test case")
+		assert.Equalf(t, f.MustCountBooks(), 1, "book count mismatch for test case")
+
+		assert.DeepEqual(t, f.MustGetNote(n1.UUID), n1, "n1 mismatch for test case")
+		assert.DeepEqual(t, f.MustGetNote(n2.UUID), n2, "n2 mismatch for test case")
+	})
+
+	t.Run("local copy is not dirty", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		f := testutils.NewFixture(t, db)
+		b1 := f.Book("b1-label")
+		n1 := f.Note(b1, "n1 body", testutils.With.USN(10))
+		n2 := f.Note(b1, "n2 body", testutils.With.USN(11))
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction for test case").Error())
+		}
+
+		if err := syncDeleteNote(context.DnoteCtx{}, tx, mustPrepareStmts(t, tx), n1.UUID, nil); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		assert.Equalf(t, f.MustCountNotes(), 1, "note count mismatch for test case")
+		assert.Equalf(t, f.MustCountBooks(), 1, "book count mismatch for test case")
+
+		assert.DeepEqual(t, f.MustGetNote(n2.UUID), n2, "n2 mismatch for test case")
+	})
+
+	t.Run("prune empty books", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			// otherNote adds another note to the book of the deleted note
+			otherNote bool
+			// inFragment adds the book of the deleted note to the books from the server
+			inFragment bool
+			prune      bool
+			bookDirty  bool
+			expectBook bool
+		}{
+			{
+				name:       "last note",
+				prune:      true,
+				expectBook: false,
+			},
+			{
+				name:       "last note, pruning disabled",
+				prune:      false,
+				expectBook: true,
+			},
+			{
+				name:       "not last note",
+				otherNote:  true,
+				prune:      true,
+				expectBook: true,
+			},
+			{
+				name:       "book in fragment",
+				inFragment: true,
+				prune:      true,
+				expectBook: true,
+			},
+			{
+				name:       "book dirty",
+				bookDirty:  true,
+				prune:      true,
+				expectBook: true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				// set up
+				db := database.InitTestDB(t, dbPath, nil)
+				defer database.TeardownTestDB(t, db)
+
+				f := testutils.NewFixture(t, db)
+				bookOpts := []testutils.FixtureOption{testutils.With.USN(5)}
+				if tc.bookDirty {
+					bookOpts = append(bookOpts, testutils.With.Dirty())
+				}
+				b1 := f.Book("b1-label", bookOpts...)
+				n1 := f.Note(b1, "n1 body", testutils.With.USN(10))
+				if tc.otherNote {
+					f.Note(b1, "n2 body", testutils.With.USN(11))
+				}
+
+				books := map[string]client.SyncFragBook{}
+				if tc.inFragment {
+					books[b1.UUID] = client.SyncFragBook{UUID: b1.UUID, USN: 12, Label: "b1-label-edited"}
+				}
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+				}
+
+				ctx := context.DnoteCtx{PruneEmptyBooks: tc.prune}
+				if err := syncDeleteNote(ctx, tx, mustPrepareStmts(t, tx), n1.UUID, books); err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, "executing").Error())
+				}
+
+				tx.Commit()
+
+				// test
+				var bookCount int
+				database.MustScan(t, "counting b1",
+					db.QueryRow("SELECT count(*) FROM books WHERE uuid = ?", b1.UUID), &bookCount)
+				var noteCount int
+				database.MustScan(t, "counting n1",
+					db.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", n1.UUID), &noteCount)
+
+				assert.Equal(t, noteCount, 0, "n1 count mismatch")
+				assert.Equal(t, bookCount == 1, tc.expectBook, "b1 existence mismatch")
+			})
+		}
+	})
+}
+
+func TestSyncDeleteBook(t *testing.T) {
+	t.Run("exists on server only", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+		ctx := context.DnoteCtx{DB: db}
+		database.MustExec(t, "inserting b1 for test case %d", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+
+		var b1 database.Book
+		database.MustScan(t, "getting b1 for test case",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b1-uuid"),
+			&b1.UUID, &b1.Label, &b1.USN, &b1.Dirty)
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		if err := syncDeleteBook(ctx, tx, mustPrepareStmts(t, tx), "nonexistent-book-uuid"); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 0, "note count mismatch")
+		assert.Equalf(t, bookCount, 1, "book count mismatch")
+
+		var b1Record database.Book
+		database.MustScan(t, "getting b1 for test case",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b1-uuid"),
+			&b1Record.UUID, &b1Record.Label, &b1Record.USN, &b1Record.Dirty)
+
+		assert.Equal(t, b1Record.UUID, b1.UUID, "b1 UUID mismatch for test case")
+		assert.Equal(t, b1Record.Label, b1.Label, "b1 Label mismatch for test case")
+		assert.Equal(t, b1Record.USN, b1.USN, "b1 USN mismatch for test case")
+		assert.Equal(t, b1Record.Dirty, b1.Dirty, "b1 Dirty mismatch for test case")
+	})
+
+	t.Run("local copy is dirty", func(t *testing.T) {
+		b1UUID := testutils.MustGenerateUUID(t)
+
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+		ctx := context.DnoteCtx{DB: db}
+
+		database.MustExec(t, "inserting b1 for test case %d", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", b1UUID, "b1-label", 12, true)
+		database.MustExec(t, "inserting n1 for test case %d", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", b1UUID, 10, "n1 body", 1541108743, false, true)
+
+		var b1 database.Book
+		database.MustScan(t, "getting b1 for test case",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", b1UUID),
+			&b1.UUID, &b1.Label, &b1.USN, &b1.Dirty)
+		var n1 database.Note
+		database.MustScan(t, "getting n1 for test case",
+			db.QueryRow("SELECT uuid, book_uuid, usn, added_on, edited_on, body,  deleted, dirty FROM notes WHERE uuid = ?", "n1-uuid"),
+			&n1.UUID, &n1.BookUUID, &n1.USN, &n1.AddedOn, &n1.EditedOn, &n1.Body, &n1.Deleted, &n1.Dirty)
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction for test case").Error())
+		}
+
+		if err := syncDeleteBook(ctx, tx, mustPrepareStmts(t, tx), b1UUID); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes for test case", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books for test case", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		// do not delete note if local copy is dirty
+		assert.Equalf(t, noteCount, 1, "note count mismatch for test case")
+		assert.Equalf(t, bookCount, 1, "book count mismatch for test case")
+
+		var b1Record database.Book
+		database.MustScan(t, "getting b1Record for test case",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", b1UUID),
+			&b1Record.UUID, &b1Record.Label, &b1Record.USN, &b1Record.Dirty)
+		var n1Record database.Note
+		database.MustScan(t, "getting n1 for test case",
+			db.QueryRow("SELECT uuid, book_uuid, usn, added_on, edited_on, body, deleted, dirty FROM notes WHERE uuid = ?", n1.UUID),
+			&n1Record.UUID, &n1Record.BookUUID, &n1Record.USN, &n1Record.AddedOn, &n1Record.EditedOn, &n1Record.Body, &n1Record.Deleted, &n1Record.Dirty)
+
+		assert.Equal(t, b1Record.UUID, b1.UUID, "b1 UUID mismatch for test case")
+		assert.Equal(t, b1Record.Label, b1.Label, "b1 Label mismatch for test case")
+		assert.Equal(t, b1Record.USN, b1.USN, "b1 USN mismatch for test case")
+		assert.Equal(t, b1Record.Dirty, b1.Dirty, "b1 Dirty mismatch for test case")
+
+		assert.Equal(t, n1Record.UUID, n1.UUID, "n1 UUID mismatch for test case")
+		assert.Equal(t, n1Record.BookUUID, n1.BookUUID, "n1 BookUUID mismatch for test case")
+		assert.Equal(t, n1Record.USN, n1.USN, "n1 USN mismatch for test case")
+		assert.Equal(t, n1Record.AddedOn, n1.AddedOn, "n1 AddedOn mismatch for test case")
+		assert.Equal(t, n1Record.EditedOn, n1.EditedOn, "n1 EditedOn mismatch for test case")
+		assert.Equal(t, n1Record.Body, n1.Body, "n1 Body mismatch for test case")
+		assert.Equal(t, n1Record.Deleted, n1.Deleted, "n1 Deleted mismatch for test case")
+		assert.Equal(t, n1Record.Dirty, n1.Dirty, "n1 Dirty mismatch for test case")
+	})
+
+	t.Run("local copy is not dirty", func(t *testing.T) {
+		b1UUID := testutils.MustGenerateUUID(t)
+		b2UUID := testutils.MustGenerateUUID(t)
+
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+		ctx := context.DnoteCtx{DB: db}
+
+		database.MustExec(t, "inserting b1 for test case %d", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b1UUID, "b1-label")
+		database.MustExec(t, "inserting n1 for test case %d", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", b1UUID, 10, "n1 body", 1541108743, false, false)
+		database.MustExec(t, "inserting b2 for test case %d", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b2UUID, "b2-label")
+		database.MustExec(t, "inserting n2 for test case %d", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", b2UUID, 11, "n2 body", 1541108743, false, false)
+
+		var b2 database.Book
+		database.MustScan(t, "getting b2 for test case",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", b2UUID),
+			&b2.UUID, &b2.Label, &b2.USN, &b2.Dirty)
+		var n2 database.Note
+		database.MustScan(t, "getting n2 for test case",
+			db.QueryRow("SELECT uuid, book_uuid, usn, added_on, edited_on, body,  deleted, dirty FROM notes WHERE uuid = ?", "n2-uuid"),
+			&n2.UUID, &n2.BookUUID, &n2.USN, &n2.AddedOn, &n2.EditedOn, &n2.Body, &n2.Deleted, &n2.Dirty)
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction for test case").Error())
+		}
+
+		if err := syncDeleteBook(ctx, tx, mustPrepareStmts(t, tx), b1UUID); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes for test case", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books for test case", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 1, "note count mismatch for test case")
+		assert.Equalf(t, bookCount, 1, "book count mismatch for test case")
+
+		var b2Record database.Book
+		database.MustScan(t, "getting b2 for test case",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", b2UUID),
+			&b2Record.UUID, &b2Record.Label, &b2Record.USN, &b2Record.Dirty)
+		var n2Record database.Note
+		database.MustScan(t, "getting n2 for test case",
+			db.QueryRow("SELECT uuid, book_uuid, usn, added_on, edited_on, body,  deleted, dirty FROM notes WHERE uuid = ?", n2.UUID),
+			&n2Record.UUID, &n2Record.BookUUID, &n2Record.USN, &n2Record.AddedOn, &n2Record.EditedOn, &n2Record.Body, &n2Record.Deleted, &n2Record.Dirty)
+
+		assert.Equal(t, b2Record.UUID, b2.UUID, "b2 UUID mismatch for test case")
+		assert.Equal(t, b2Record.Label, b2.Label, "b2 Label mismatch for test case")
+		assert.Equal(t, b2Record.USN, b2.USN, "b2 USN mismatch for test case")
+		assert.Equal(t, b2Record.Dirty, b2.Dirty, "b2 Dirty mismatch for test case")
+
+		assert.Equal(t, n2Record.UUID, n2.UUID, "n2 UUID mismatch for test case")
+		assert.Equal(t, n2Record.BookUUID, n2.BookUUID, "n2 BookUUID mismatch for test case")
+		assert.Equal(t, n2Record.USN, n2.USN, "n2 USN mismatch for test case")
+		assert.Equal(t, n2Record.AddedOn, n2.AddedOn, "n2 AddedOn mismatch for test case")
+		assert.Equal(t, n2Record.EditedOn, n2.EditedOn, "n2 EditedOn mismatch for test case")
+		assert.Equal(t, n2Record.Body, n2.Body, "n2 Body mismatch for test case")
+		assert.Equal(t, n2Record.Deleted, n2.Deleted, "n2 Deleted mismatch for test case")
+		assert.Equal(t, n2Record.Dirty, n2.Dirty, "n2 Dirty mismatch for test case")
+	})
+
+	t.Run("local copy has at least one note that is dirty", func(t *testing.T) {
+		b1UUID := testutils.MustGenerateUUID(t)
+
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+		ctx := context.DnoteCtx{DB: db}
+
+		database.MustExec(t, "inserting b1 for test case %d", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b1UUID, "b1-label")
+		database.MustExec(t, "inserting n1 for test case %d", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", b1UUID, 10, "n1 body", 1541108743, false, true)
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction for test case").Error())
+		}
+
+		if err := syncDeleteBook(ctx, tx, mustPrepareStmts(t, tx), b1UUID); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes for test case", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books for test case", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+		assert.Equalf(t, noteCount, 1, "note count mismatch for test case")
+		assert.Equalf(t, bookCount, 1, "book count mismatch for test case")
+
+		var b1Record database.Book
+		database.MustScan(t, "getting b1 for test case",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", b1UUID),
+			&b1Record.UUID, &b1Record.Label, &b1Record.USN, &b1Record.Dirty)
+		var n1Record database.Note
+		database.MustScan(t, "getting n1 for test case",
+			db.QueryRow("SELECT uuid, book_uuid, usn, added_on, body,deleted, dirty FROM notes WHERE uuid = ?", "n1-uuid"),
+			&n1Record.UUID, &n1Record.BookUUID, &n1Record.USN, &n1Record.AddedOn, &n1Record.Body, &n1Record.Deleted, &n1Record.Dirty)
+
+		assert.Equal(t, b1Record.UUID, b1UUID, "b1 UUID mismatch for test case")
+		assert.Equal(t, b1Record.Label, "b1-label", "b1 Label mismatch for test case")
+		assert.Equal(t, b1Record.Dirty, true, "b1 Dirty mismatch for test case")
+
+		assert.Equal(t, n1Record.UUID, "n1-uuid", "n1 UUID mismatch for test case")
+		assert.Equal(t, n1Record.BookUUID, b1UUID, "n1 BookUUID mismatch for test case")
+		assert.Equal(t, n1Record.USN, 10, "n1 USN mismatch for test case")
+		assert.Equal(t, n1Record.AddedOn, int64(1541108743), "n1 AddedOn mismatch for test case")
+		assert.Equal(t, n1Record.Body, "n1 body", "n1 Body mismatch for test case")
+		assert.Equal(t, n1Record.Deleted, false, "n1 Deleted mismatch for test case")
+		assert.Equal(t, n1Record.Dirty, true, "n1 Dirty mismatch for test case")
+	})
+}
+
+func TestFullSyncNote(t *testing.T) {
+	t.Run("exists on server only", func(t *testing.T) {
+		// set up
+		ctx := context.InitTestCtx(t, paths, nil)
+		defer context.TeardownTestCtx(t, ctx)
+
+		db := ctx.DB
+
+		b1UUID := testutils.MustGenerateUUID(t)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b1UUID, "b1-label")
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		n := client.SyncFragNote{
+			UUID:     "n1-uuid",
+			BookUUID: b1UUID,
+			USN:      128,
+			AddedOn:  1541232118,
+			EditedOn: 1541219321,
+			Body:     "n1-body",
+			Deleted:  false,
+		}
+
+		if err := fullSyncNote(ctx, tx, mustPrepareStmts(t, tx), n); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 1, "note count mismatch")
+		assert.Equalf(t, bookCount, 1, "book count mismatch")
+
+		n1 := testutils.MustGetNote(t, db, n.UUID)
+		testutils.AssertNoteEqual(t, n1, database.Note{
+			UUID:     n.UUID,
+			BookUUID: n.BookUUID,
+			USN:      n.USN,
+			AddedOn:  n.AddedOn,
+			EditedOn: n.EditedOn,
+			Body:     n.Body,
+			Public:   n.Public,
+			Deleted:  n.Deleted,
+			Dirty:    false,
+			Position: 1,
+		}, "RowID")
+	})
+
+	t.Run("exists on server and client", func(t *testing.T) {
+		b1UUID := testutils.MustGenerateUUID(t)
+		b2UUID := testutils.MustGenerateUUID(t)
+		conflictBookUUID := testutils.MustGenerateUUID(t)
+
+		testCases := []struct {
+			conflictStrategy string
+			syncSafety       string
+			addedOn          int64
+			clientUSN        int
+			clientEditedOn   int64
+			clientBody       string
+			clientDeleted    bool
+			clientBookUUID   string
+			clientDirty      bool
+			serverUSN        int
+			serverEditedOn   int64
+			serverBody       string
+			serverDeleted    bool
+			serverBookUUID   string
+			expectedUSN      int
+			expectedAddedOn  int64
+			expectedEditedOn int64
+			expectedBody     string
+			expectedDeleted  bool
+			expectedBookUUID string
+			expectedDirty    bool
+		}{
+			// server has higher usn and client is dirty
+			{
+				clientDirty:      true,
+				clientUSN:        1,
+				clientEditedOn:   0,
+				clientBody:       "n1 body",
+				clientDeleted:    false,
+				clientBookUUID:   b1UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body edited",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody: `<<<<<<< Local
+Moved to the book b1-label
+=======
+Moved to the book b2-label
+>>>>>>> Server
+
+<<<<<<< Local
+n1 body
+=======
+n1 body edited
+>>>>>>> Server
+`,
+				expectedDeleted:  false,
+				expectedBookUUID: conflictBookUUID,
+				expectedDirty:    true,
+			},
+			{
+				clientDirty:      true,
+				clientUSN:        1,
+				clientEditedOn:   0,
+				clientBody:       "n1 body",
+				clientDeleted:    false,
+				clientBookUUID:   b1UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body edited",
+				serverDeleted:    false,
+				serverBookUUID:   b1UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody: `<<<<<<< Local
+n1 body
+=======
+n1 body edited
+>>>>>>> Server
+`,
+				expectedDeleted:  false,
+				expectedBookUUID: b1UUID,
+				expectedDirty:    true,
+			},
+			// server has higher usn and client deleted locally
+			{
+				clientDirty:      true,
+				clientUSN:        1,
+				clientEditedOn:   0,
+				clientBody:       "",
+				clientDeleted:    true,
+				clientBookUUID:   b1UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body server",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody:     "n1 body server",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    false,
+			},
+			// server has higher usn and client is not dirty
+			{
+				clientDirty:      false,
+				clientUSN:        1,
+				clientEditedOn:   0,
+				clientBody:       "n1 body",
+				clientDeleted:    false,
+				clientBookUUID:   b1UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body edited",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody:     "n1 body edited",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    false,
+			},
+			// they're in sync
+			{
+				clientDirty:      true,
+				clientUSN:        21,
+				clientEditedOn:   1541219321,
+				clientBody:       "n1 body",
+				clientDeleted:    false,
+				clientBookUUID:   b2UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody:     "n1 body",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    true,
+			},
+			// they have the same usn but client is dirty, e.g. after the client database
+			// was restored from a backup. With the local strategy, the local copy is kept
+			// and will be uploaded to the server.
+			{
+				conflictStrategy: consts.ConflictStrategyLocal,
+				clientDirty:      true,
+				clientUSN:        21,
+				clientEditedOn:   1541219320,
+				clientBody:       "n1 body client",
+				clientDeleted:    false,
+				clientBookUUID:   b2UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body server",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219320,
+				expectedBody:     "n1 body client",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    true,
+			},
+			// same usn, client is dirty and the server copy is newer. By default, the
+			// newer copy is kept. The permissive safety discards the local copy.
+			{
+				syncSafety:       consts.SyncSafetyPermissive,
+				clientDirty:      true,
+				clientUSN:        21,
+				clientEditedOn:   1541219320,
+				clientBody:       "n1 body client",
+				clientDeleted:    false,
+				clientBookUUID:   b1UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body server",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody:     "n1 body server",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    false,
+			},
+			// same usn, client is dirty and the client copy is newer
+			{
+				conflictStrategy: consts.ConflictStrategyNewer,
+				clientDirty:      true,
+				clientUSN:        21,
+				clientEditedOn:   1541219322,
+				clientBody:       "n1 body client",
+				clientDeleted:    false,
+				clientBookUUID:   b2UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body server",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219322,
+				expectedBody:     "n1 body client",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    true,
+			},
+			// same usn, client is dirty and both copies were edited at the same time
+			{
+				conflictStrategy: consts.ConflictStrategyNewer,
+				clientDirty:      true,
+				clientUSN:        21,
+				clientEditedOn:   1541219321,
+				clientBody:       "n1 body client",
+				clientDeleted:    false,
+				clientBookUUID:   b2UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body server",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody:     "n1 body client",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    true,
+			},
+			// same usn, client deleted the note, and the server strategy is used
+			{
+				conflictStrategy: consts.ConflictStrategyServer,
+				clientDirty:      true,
+				clientUSN:        21,
+				clientEditedOn:   1541219322,
+				clientBody:       "",
+				clientDeleted:    true,
+				clientBookUUID:   b2UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body server",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody:     "n1 body server",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    false,
+			},
+		}
+
+		for idx, tc := range testCases {
+			t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+				// set up
+				ctx := context.InitTestCtx(t, paths, nil)
+				defer context.TeardownTestCtx(t, ctx)
+
+				ctx.ConflictStrategy = tc.conflictStrategy
+				ctx.SyncSafety = tc.syncSafety
+
+				db := ctx.DB
+
+				database.MustExec(t, fmt.Sprintf("inserting b1 for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b1UUID, "b1-label")
+				database.MustExec(t, fmt.Sprintf("inserting b2 for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b2UUID, "b2-label")
+				database.MustExec(t, fmt.Sprintf("inserting conflitcs book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", conflictBookUUID, "conflicts")
+				n1UUID := testutils.MustGenerateUUID(t)
+				database.MustExec(t, fmt.Sprintf("inserting n1 for test case %d", idx), db, "INSERT INTO notes (uuid, book_uuid, usn, added_on, edited_on, body, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", n1UUID, tc.clientBookUUID, tc.clientUSN, tc.addedOn, tc.clientEditedOn, tc.clientBody, tc.clientDeleted, tc.clientDirty)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+				}
+
+				// update all fields but uuid and bump usn
+				n := client.SyncFragNote{
+					UUID:     n1UUID,
+					BookUUID: tc.serverBookUUID,
+					USN:      tc.serverUSN,
+					AddedOn:  tc.addedOn,
+					EditedOn: tc.serverEditedOn,
+					Body:     tc.serverBody,
+					Deleted:  tc.serverDeleted,
+				}
+
+				if err := fullSyncNote(ctx, tx, mustPrepareStmts(t, tx), n); err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+				}
+
+				tx.Commit()
+
+				// test
+				var noteCount, bookCount int
+				database.MustScan(t, fmt.Sprintf("counting notes for test case %d", idx), db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+				database.MustScan(t, fmt.Sprintf("counting books for test case %d", idx), db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+				assert.Equalf(t, noteCount, 1, fmt.Sprintf("note count mismatch for test case %d", idx))
+				assert.Equalf(t, bookCount, 3, fmt.Sprintf("book count mismatch for test case %d", idx))
+
+				n1 := testutils.MustGetNote(t, db, n.UUID)
+				testutils.AssertNoteEqual(t, n1, database.Note{
+					UUID:     n.UUID,
+					BookUUID: tc.expectedBookUUID,
+					USN:      tc.expectedUSN,
+					AddedOn:  tc.expectedAddedOn,
+					EditedOn: tc.expectedEditedOn,
+					Body:     tc.expectedBody,
+					Deleted:  tc.expectedDeleted,
+					Dirty:    tc.expectedDirty,
+				}, "RowID")
+			})
+		}
+	})
+
+	t.Run("keeps the position", func(t *testing.T) {
+		for _, dirty := range []bool{false, true} {
+			t.Run(fmt.Sprintf("dirty %t", dirty), func(t *testing.T) {
+				// set up
+				ctx := context.InitTestCtx(t, paths, nil)
+				defer context.TeardownTestCtx(t, ctx)
+
+				db := ctx.DB
+
+				f := testutils.NewFixture(t, db)
+				b1 := f.Book("b1-label", testutils.With.USN(1))
+				b2 := f.Book("b2-label", testutils.With.USN(2))
+				f.Note(b2, "n2 body", testutils.With.USN(2), testutils.With.Position(9))
+				opts := []testutils.FixtureOption{testutils.With.USN(1), testutils.With.Position(2.5)}
+				if dirty {
+					opts = append(opts, testutils.With.Dirty())
+				}
+				n1 := f.Note(b1, "n1 body", opts...)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+				}
+
+				n := client.SyncFragNote{
+					UUID:     n1.UUID,
+					BookUUID: b2.UUID,
+					USN:      21,
+					AddedOn:  n1.AddedOn,
+					EditedOn: 1541219321,
+					Body:     "n1 body edited",
+				}
+
+				if err := fullSyncNote(ctx, tx, mustPrepareStmts(t, tx), n); err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, "executing").Error())
+				}
+
+				tx.Commit()
+
+				// test
+				got := f.MustGetNote(n1.UUID)
+				assert.Equal(t, got.USN, 21, "usn mismatch")
+				assert.Equal(t, got.Position, 2.5, "position mismatch")
+			})
+		}
+	})
+
+	t.Run("appends a new note", func(t *testing.T) {
+		// set up
+		ctx := context.InitTestCtx(t, paths, nil)
+		defer context.TeardownTestCtx(t, ctx)
+
+		db := ctx.DB
+
+		f := testutils.NewFixture(t, db)
+		b1 := f.Book("b1-label", testutils.With.USN(1))
+		f.Note(b1, "n1 body", testutils.With.USN(1), testutils.With.Position(3.5))
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		n := client.SyncFragNote{
+			UUID:     "n2-uuid",
+			BookUUID: b1.UUID,
+			USN:      21,
+			AddedOn:  1541232118,
+			Body:     "n2 body",
+		}
+
+		if err := fullSyncNote(ctx, tx, mustPrepareStmts(t, tx), n); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		assert.Equal(t, f.MustGetNote("n2-uuid").Position, 4.5, "position mismatch")
+	})
+}
+
+func TestFullSyncNotes(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "b1-uuid", "b1-label", 1)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 1541108743, 2)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, usn) VALUES (?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", "n2 body", 1541108743, 20)
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n3-uuid", "b1-uuid", "n3 body", 1541108743, 1541108744, 30, true)
+
+	// more new notes than are inserted at once
+	notes := map[string]client.SyncFragNote{
+		"n1-uuid": {UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 10, AddedOn: 1541108743, Body: "n1 body edited"},
+		"n2-uuid": {UUID: "n2-uuid", BookUUID: "b1-uuid", USN: 10, AddedOn: 1541108743, Body: "n2 body stale"},
+		// dirty locally with the same usn, and edited more recently on the server
+		"n3-uuid": {UUID: "n3-uuid", BookUUID: "b1-uuid", USN: 30, AddedOn: 1541108743, EditedOn: 1541108745, Body: "n3 body server"},
+	}
+	newCount := noteInsertChunkSize*2 + 1
+	for i := 0; i < newCount; i++ {
+		uuid := fmt.Sprintf("new-%d-uuid", i)
+		notes[uuid] = client.SyncFragNote{UUID: uuid, BookUUID: "b1-uuid", USN: 100 + i, AddedOn: 1541108743, Body: "new body"}
+	}
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+	if err := fullSyncNotes(ctx, tx, mustPrepareStmts(t, tx), notes); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+	tx.Commit()
+
+	// test
+	assert.Equal(t, len(notes), 0, "the applied notes should have been removed from the map")
+
+	var noteCount int
+	database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+	// n3 is overwritten by the newer server copy of the same usn, and the safety
+	// policy keeps its local copy as a new note in the conflicts book
+	assert.Equal(t, noteCount, newCount+4, "note count mismatch")
+
+	var n1Body, n2Body, n3Body string
+	var n3Dirty bool
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT body FROM notes WHERE uuid = ?", "n1-uuid"), &n1Body)
+	database.MustScan(t, "getting n2", db.QueryRow("SELECT body FROM notes WHERE uuid = ?", "n2-uuid"), &n2Body)
+	database.MustScan(t, "getting n3", db.QueryRow("SELECT body, dirty FROM notes WHERE uuid = ?", "n3-uuid"), &n3Body, &n3Dirty)
+	assert.Equal(t, n1Body, "n1 body edited", "n1 body mismatch")
+	assert.Equal(t, n2Body, "n2 body", "n2 body mismatch")
+	assert.Equal(t, n3Body, "n3 body server", "n3 body mismatch")
+	assert.Equal(t, n3Dirty, false, "n3 dirty mismatch")
+
+	// the new notes are appended in the order they were added, and then by uuid
+	var positionCount int
+	var minPosition, maxPosition, new0Position float64
+	database.MustScan(t, "getting the positions", db.QueryRow("SELECT count(DISTINCT position), min(position), max(position) FROM notes WHERE uuid LIKE 'new-%'"), &positionCount, &minPosition, &maxPosition)
+	database.MustScan(t, "getting new-0", db.QueryRow("SELECT position FROM notes WHERE uuid = ?", "new-0-uuid"), &new0Position)
+	assert.Equal(t, positionCount, newCount, "position count mismatch")
+	assert.Equal(t, minPosition, float64(1), "min position mismatch")
+	assert.Equal(t, maxPosition, float64(newCount), "max position mismatch")
+	assert.Equal(t, new0Position, float64(1), "new-0 position mismatch")
+}
+
+// TestFullSyncBooks_deleted tests that the deleted books sent by the server
+// without a label do not leave books with an empty label
+func TestFullSyncBooks_deleted(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "b1-uuid", "js", 1)
+
+	books := map[string]client.SyncFragBook{
+		"b1-uuid": {UUID: "b1-uuid", USN: 5, Label: "", Deleted: true},
+		"b2-uuid": {UUID: "b2-uuid", USN: 6, Label: "", Deleted: true},
+		"b3-uuid": {UUID: "b3-uuid", USN: 7, Label: "", Deleted: true},
+	}
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+	if err := fullSyncBooks(ctx, tx, mustPrepareStmts(t, tx), books); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+	tx.Commit()
+
+	// test
+	var bookCount, emptyCount int
+	database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+	database.MustScan(t, "counting books with an empty label", db.QueryRow("SELECT count(*) FROM books WHERE label = ''"), &emptyCount)
+	assert.Equal(t, bookCount, 1, "book count mismatch")
+	assert.Equal(t, emptyCount, 0, "empty label count mismatch")
+
+	var b1 database.Book
+	database.MustScan(t, "getting b1",
+		db.QueryRow("SELECT label, usn, dirty, deleted FROM books WHERE uuid = ?", "b1-uuid"),
+		&b1.Label, &b1.USN, &b1.Dirty, &b1.Deleted)
+	assert.Equal(t, b1.Label, "js", "b1 label mismatch")
+	assert.Equal(t, b1.USN, 5, "b1 usn mismatch")
+	assert.Equal(t, b1.Dirty, false, "b1 dirty mismatch")
+	assert.Equal(t, b1.Deleted, true, "b1 deleted mismatch")
+}
+
+// TestFullSyncBooks_renamed tests that a book renamed on the server is matched
+// by uuid, and that its old label is free for a new book from the server
+func TestFullSyncBooks_renamed(t *testing.T) {
+	testCases := []struct {
+		name   string
+		books  map[string]client.SyncFragBook
+		labels map[string]string
+	}{
+		{
+			name: "renamed",
+			books: map[string]client.SyncFragBook{
+				"b1-uuid": {UUID: "b1-uuid", USN: 5, Label: "css"},
+			},
+			labels: map[string]string{"b1-uuid": "css"},
+		},
+		{
+			name: "old label reused",
+			books: map[string]client.SyncFragBook{
+				"b1-uuid": {UUID: "b1-uuid", USN: 5, Label: "css"},
+				"b2-uuid": {UUID: "b2-uuid", USN: 6, Label: "js"},
+			},
+			labels: map[string]string{"b1-uuid": "css", "b2-uuid": "js"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			ctx := context.InitTestCtx(t, paths, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			db := ctx.DB
+
+			database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b1-uuid", "js", 1, false)
+
+			// execute
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "beginning a transaction"))
+			}
+			if err := fullSyncBooks(ctx, tx, mustPrepareStmts(t, tx), tc.books); err != nil {
+				tx.Rollback()
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+			tx.Commit()
+
+			// test
+			var bookCount int
+			database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+			assert.Equal(t, bookCount, len(tc.labels), "book count mismatch")
+
+			for uuid, label := range tc.labels {
+				var got string
+				var dirty bool
+				database.MustScan(t, "getting "+uuid, db.QueryRow("SELECT label, dirty FROM books WHERE uuid = ?", uuid), &got, &dirty)
+				assert.Equal(t, got, label, uuid+" label mismatch")
+				assert.Equal(t, dirty, false, uuid+" dirty mismatch")
+			}
+		})
+	}
+}
+
+func TestMergeBookFromServer(t *testing.T) {
+	type book struct {
+		usn     int
+		label   string
+		dirty   bool
+		deleted bool
+	}
+
+	testCases := []struct {
+		name   string
+		client *book
+		// another is a book with a different uuid
+		another         book
+		server          client.SyncFragBook
+		expected        book
+		expectedAnother book
+	}{
+		{
+			name:            "exists on server only",
+			another:         book{usn: 555, label: "b2-label", dirty: true},
+			server:          client.SyncFragBook{USN: 1, Label: "b1-label"},
+			expected:        book{usn: 1, label: "b1-label"},
+			expectedAnother: book{usn: 555, label: "b2-label", dirty: true},
+		},
+		{
+			name:            "exists on server only with a duplicate label",
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 1, Label: "foo"},
+			expected:        book{usn: 1, label: "foo"},
+			expectedAnother: book{usn: 2, label: "foo_2", dirty: true},
+		},
+		{
+			name:            "server has higher usn and client is dirty",
+			client:          &book{usn: 1, label: "b1-label-client", dirty: true},
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 3, Label: "b1-label-server"},
+			expected:        book{usn: 3, label: "b1-label-server"},
+			expectedAnother: book{usn: 2, label: "foo"},
+		},
+		{
+			name:            "server has higher usn and client deleted the book",
+			client:          &book{usn: 1, label: "b1-label", dirty: true, deleted: true},
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 3, Label: "b1-label-server"},
+			expected:        book{usn: 3, label: "b1-label-server"},
+			expectedAnother: book{usn: 2, label: "foo"},
+		},
+		{
+			name:            "server has higher usn and deleted the book",
+			client:          &book{usn: 1, label: "b1-label", dirty: true},
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 3, Label: "", Deleted: true},
+			expected:        book{usn: 3, label: "b1-label", deleted: true},
+			expectedAnother: book{usn: 2, label: "foo"},
+		},
+		{
+			name:            "server has higher usn and client is not dirty",
+			client:          &book{usn: 1, label: "b1-label"},
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 3, Label: "b1-label-server"},
+			expected:        book{usn: 3, label: "b1-label-server"},
+			expectedAnother: book{usn: 2, label: "foo"},
+		},
+		{
+			name:            "server has higher usn and takes the label of another book",
+			client:          &book{usn: 1, label: "b1-label"},
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 3, Label: "foo"},
+			expected:        book{usn: 3, label: "foo"},
+			expectedAnother: book{usn: 2, label: "foo_2", dirty: true},
+		},
+		{
+			// the book used to be renamed to a new label and marked dirty because its
+			// own label was taken as a duplicate, and the stale copy was uploaded
+			name:            "server has higher usn and the same label",
+			client:          &book{usn: 1, label: "b1-label"},
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 3, Label: "b1-label"},
+			expected:        book{usn: 3, label: "b1-label"},
+			expectedAnother: book{usn: 2, label: "foo"},
+		},
+		{
+			name:            "they are in sync",
+			client:          &book{usn: 3, label: "b1-label"},
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 3, Label: "b1-label"},
+			expected:        book{usn: 3, label: "b1-label"},
+			expectedAnother: book{usn: 2, label: "foo"},
+		},
+		{
+			name:            "they have the same usn but client is dirty",
+			client:          &book{usn: 3, label: "b1-label-client", dirty: true},
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 3, Label: "b1-label"},
+			expected:        book{usn: 3, label: "b1-label-client", dirty: true},
+			expectedAnother: book{usn: 2, label: "foo"},
+		},
+		{
+			name:            "client has higher usn",
+			client:          &book{usn: 5, label: "b1-label-client", dirty: true},
+			another:         book{usn: 2, label: "foo"},
+			server:          client.SyncFragBook{USN: 3, Label: "foo"},
+			expected:        book{usn: 5, label: "b1-label-client", dirty: true},
+			expectedAnother: book{usn: 2, label: "foo"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			db := database.InitTestDB(t, dbPath, nil)
+			defer database.TeardownTestDB(t, db)
+
+			if tc.client != nil {
+				database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b1-uuid", tc.client.usn, tc.client.label, tc.client.dirty, tc.client.deleted)
+			}
+			database.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b2-uuid", tc.another.usn, tc.another.label, tc.another.dirty, tc.another.deleted)
+
+			// execute
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "beginning a transaction"))
+			}
+
+			b := tc.server
+			b.UUID = "b1-uuid"
+			if err := mergeBookFromServer(tx, mustPrepareStmts(t, tx), b); err != nil {
+				tx.Rollback()
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			tx.Commit()
+
+			// test
+			var b1, b2 book
+			database.MustScan(t, "getting b1",
+				db.QueryRow("SELECT usn, label, dirty, deleted FROM books WHERE uuid = ?", "b1-uuid"),
+				&b1.usn, &b1.label, &b1.dirty, &b1.deleted)
+			database.MustScan(t, "getting b2",
+				db.QueryRow("SELECT usn, label, dirty, deleted FROM books WHERE uuid = ?", "b2-uuid"),
+				&b2.usn, &b2.label, &b2.dirty, &b2.deleted)
+
+			assert.Equal(t, b1, tc.expected, "b1 mismatch")
+			assert.Equal(t, b2, tc.expectedAnother, "b2 mismatch")
+		})
+	}
+}
+
+func TestStepSyncNote(t *testing.T) {
+	t.Run("exists on server only", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		b1UUID := testutils.MustGenerateUUID(t)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b1UUID, "b1-label")
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		n := client.SyncFragNote{
+			UUID:     "n1-uuid",
+			BookUUID: b1UUID,
+			USN:      128,
+			AddedOn:  1541232118,
+			EditedOn: 1541219321,
+			Body:     "n1-body",
+			Deleted:  false,
+		}
+
+		if err := stepSyncNote(tx, mustPrepareStmts(t, tx), n); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 1, "note count mismatch")
+		assert.Equalf(t, bookCount, 1, "book count mismatch")
+
+		n1 := testutils.MustGetNote(t, db, n.UUID)
+		testutils.AssertNoteEqual(t, n1, database.Note{
+			UUID:     n.UUID,
+			BookUUID: n.BookUUID,
+			USN:      n.USN,
+			AddedOn:  n.AddedOn,
+			EditedOn: n.EditedOn,
+			Body:     n.Body,
+			Public:   n.Public,
+			Deleted:  n.Deleted,
+			Dirty:    false,
+			Position: 1,
+		}, "RowID")
+	})
+
+	t.Run("exists on server and client", func(t *testing.T) {
+		b1UUID := testutils.MustGenerateUUID(t)
+		b2UUID := testutils.MustGenerateUUID(t)
+		conflictBookUUID := testutils.MustGenerateUUID(t)
+
+		testCases := []struct {
+			addedOn          int64
+			clientUSN        int
+			clientEditedOn   int64
+			clientBody       string
+			clientDeleted    bool
+			clientBookUUID   string
+			clientDirty      bool
+			serverUSN        int
+			serverEditedOn   int64
+			serverBody       string
+			serverDeleted    bool
+			serverBookUUID   string
+			expectedUSN      int
+			expectedAddedOn  int64
+			expectedEditedOn int64
+			expectedBody     string
+			expectedDeleted  bool
+			expectedBookUUID string
+			expectedDirty    bool
+		}{
+			{
+				clientDirty:      true,
+				clientUSN:        1,
+				clientEditedOn:   0,
+				clientBody:       "n1 body",
+				clientDeleted:    false,
+				clientBookUUID:   b1UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body edited",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody: `<<<<<<< Local
+Moved to the book b1-label
+=======
+Moved to the book b2-label
+>>>>>>> Server
+
+<<<<<<< Local
+n1 body
+=======
+n1 body edited
+>>>>>>> Server
+`,
+				expectedDeleted:  false,
+				expectedBookUUID: conflictBookUUID,
+				expectedDirty:    true,
+			},
+			// if deleted locally, resurrect it
+			{
+				clientDirty:      true,
+				clientUSN:        1,
+				clientEditedOn:   1541219321,
+				clientBody:       "",
+				clientDeleted:    true,
+				clientBookUUID:   b1UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body edited",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody:     "n1 body edited",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    false,
+			},
+			{
+				clientDirty:      false,
+				clientUSN:        1,
+				clientEditedOn:   0,
+				clientBody:       "n1 body",
+				clientDeleted:    false,
+				clientBookUUID:   b1UUID,
+				addedOn:          1541232118,
+				serverUSN:        21,
+				serverEditedOn:   1541219321,
+				serverBody:       "n1 body edited",
+				serverDeleted:    false,
+				serverBookUUID:   b2UUID,
+				expectedUSN:      21,
+				expectedAddedOn:  1541232118,
+				expectedEditedOn: 1541219321,
+				expectedBody:     "n1 body edited",
+				expectedDeleted:  false,
+				expectedBookUUID: b2UUID,
+				expectedDirty:    false,
+			},
+		}
+
+		for idx, tc := range testCases {
+			t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+				// set up
+				db := database.InitTestDB(t, dbPath, nil)
+				defer database.TeardownTestDB(t, db)
+
+				database.MustExec(t, fmt.Sprintf("inserting b1 for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b1UUID, "b1-label")
+				database.MustExec(t, fmt.Sprintf("inserting b2 for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b2UUID, "b2-label")
+				database.MustExec(t, fmt.Sprintf("inserting conflitcs book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", conflictBookUUID, "conflicts")
+				n1UUID := testutils.MustGenerateUUID(t)
+				database.MustExec(t, fmt.Sprintf("inserting n1 for test case %d", idx), db, "INSERT INTO notes (uuid, book_uuid, usn, added_on, edited_on, body,  deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", n1UUID, tc.clientBookUUID, tc.clientUSN, tc.addedOn, tc.clientEditedOn, tc.clientBody, tc.clientDeleted, tc.clientDirty)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+				}
+
+				// update all fields but uuid and bump usn
+				n := client.SyncFragNote{
+					UUID:     n1UUID,
+					BookUUID: tc.serverBookUUID,
+					USN:      tc.serverUSN,
+					AddedOn:  tc.addedOn,
+					EditedOn: tc.serverEditedOn,
+					Body:     tc.serverBody,
+					Deleted:  tc.serverDeleted,
+				}
+
+				if err := stepSyncNote(tx, mustPrepareStmts(t, tx), n); err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+				}
+
+				tx.Commit()
+
+				// test
+				var noteCount, bookCount int
+				database.MustScan(t, fmt.Sprintf("counting notes for test case %d", idx), db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+				database.MustScan(t, fmt.Sprintf("counting books for test case %d", idx), db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+				assert.Equalf(t, noteCount, 1, fmt.Sprintf("note count mismatch for test case %d", idx))
+				assert.Equalf(t, bookCount, 3, fmt.Sprintf("book count mismatch for test case %d", idx))
+
+				n1 := testutils.MustGetNote(t, db, n.UUID)
+				testutils.AssertNoteEqual(t, n1, database.Note{
+					UUID:     n.UUID,
+					BookUUID: tc.expectedBookUUID,
+					USN:      tc.expectedUSN,
+					AddedOn:  tc.expectedAddedOn,
+					EditedOn: tc.expectedEditedOn,
+					Body:     tc.expectedBody,
+					Deleted:  tc.expectedDeleted,
+					Dirty:    tc.expectedDirty,
+				}, "RowID")
+			})
+		}
+	})
+
+	t.Run("keeps the origin device", func(t *testing.T) {
+		for _, dirty := range []bool{false, true} {
+			t.Run(fmt.Sprintf("dirty %t", dirty), func(t *testing.T) {
+				// set up
+				db := database.InitTestDB(t, dbPath, nil)
+				defer database.TeardownTestDB(t, db)
+
+				f := testutils.NewFixture(t, db)
+				b1 := f.Book("b1-label", testutils.With.USN(1))
+				opts := []testutils.FixtureOption{testutils.With.USN(1), testutils.With.OriginDevice("device-1")}
+				if dirty {
+					opts = append(opts, testutils.With.Dirty())
+				}
+				n1 := f.Note(b1, "n1 body", opts...)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+				}
+
+				n := client.SyncFragNote{
+					UUID:     n1.UUID,
+					BookUUID: b1.UUID,
+					USN:      21,
+					AddedOn:  n1.AddedOn,
+					EditedOn: 1541219321,
+					Body:     "n1 body edited",
+				}
+
+				if err := stepSyncNote(tx, mustPrepareStmts(t, tx), n); err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, "executing").Error())
+				}
+
+				tx.Commit()
+
+				// test
+				got := f.MustGetNote(n1.UUID)
+				assert.Equal(t, got.USN, 21, "usn mismatch")
+				assert.Equal(t, got.OriginDevice, "device-1", "origin device mismatch")
+			})
+		}
+	})
+}
+
+func TestMergeBook(t *testing.T) {
+	t.Run("insert, no duplicates", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		// test
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		b1 := client.SyncFragBook{
+			UUID:    "b1-uuid",
+			USN:     12,
+			AddedOn: 1541108743,
+			Label:   "b1-label",
+			Deleted: false,
+		}
+
+		if err := mergeBook(tx, mustPrepareStmts(t, tx), b1, modeInsert); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// execute
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 0, "note count mismatch")
+		assert.Equalf(t, bookCount, 1, "book count mismatch")
+
+		var b1Record database.Book
+		database.MustScan(t, "getting b1",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b1-uuid"),
+			&b1Record.UUID, &b1Record.Label, &b1Record.USN, &b1Record.Dirty)
+
+		assert.Equal(t, b1Record.UUID, b1.UUID, "b1 UUID mismatch")
+		assert.Equal(t, b1Record.Label, b1.Label, "b1 Label mismatch")
+		assert.Equal(t, b1Record.USN, b1.USN, "b1 USN mismatch")
+	})
+
+	t.Run("insert, 1 duplicate", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b1-uuid", 1, "foo", false, false)
+
+		// test
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		b := client.SyncFragBook{
+			UUID:    "b2-uuid",
+			USN:     12,
+			AddedOn: 1541108743,
+			Label:   "foo",
+			Deleted: false,
+		}
+
+		if err := mergeBook(tx, mustPrepareStmts(t, tx), b, modeInsert); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// execute
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 0, "note count mismatch")
+		assert.Equalf(t, bookCount, 2, "book count mismatch")
+
+		var b1Record, b2Record database.Book
+		database.MustScan(t, "getting b1",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b1-uuid"),
+			&b1Record.UUID, &b1Record.Label, &b1Record.USN, &b1Record.Dirty)
+		database.MustScan(t, "getting b2",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b2-uuid"),
+			&b2Record.UUID, &b2Record.Label, &b2Record.USN, &b2Record.Dirty)
+
+		assert.Equal(t, b1Record.Label, "foo_2", "b1 Label mismatch")
+		assert.Equal(t, b1Record.USN, 1, "b1 USN mismatch")
+		assert.Equal(t, b1Record.Dirty, true, "b1 should have been marked dirty")
+
+		assert.Equal(t, b2Record.Label, "foo", "b2 Label mismatch")
+		assert.Equal(t, b2Record.USN, 12, "b2 USN mismatch")
+		assert.Equal(t, b2Record.Dirty, false, "b2 Dirty mismatch")
+	})
+
+	t.Run("insert, 3 duplicates", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b1-uuid", 1, "foo", false, false)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b2-uuid", 2, "foo_2", true, false)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b3-uuid", 3, "foo_3", false, false)
+
+		// test
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		b := client.SyncFragBook{
+			UUID:    "b4-uuid",
+			USN:     12,
+			AddedOn: 1541108743,
+			Label:   "foo",
+			Deleted: false,
+		}
+
+		if err := mergeBook(tx, mustPrepareStmts(t, tx), b, modeInsert); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// execute
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 0, "note count mismatch")
+		assert.Equalf(t, bookCount, 4, "book count mismatch")
+
+		var b1Record, b2Record, b3Record, b4Record database.Book
+		database.MustScan(t, "getting b1",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b1-uuid"),
+			&b1Record.UUID, &b1Record.Label, &b1Record.USN, &b1Record.Dirty)
+		database.MustScan(t, "getting b2",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b2-uuid"),
+			&b2Record.UUID, &b2Record.Label, &b2Record.USN, &b2Record.Dirty)
+		database.MustScan(t, "getting b3",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b3-uuid"),
+			&b3Record.UUID, &b3Record.Label, &b3Record.USN, &b3Record.Dirty)
+		database.MustScan(t, "getting b4",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b4-uuid"),
+			&b4Record.UUID, &b4Record.Label, &b4Record.USN, &b4Record.Dirty)
+
+		assert.Equal(t, b1Record.Label, "foo_4", "b1 Label mismatch")
+		assert.Equal(t, b1Record.USN, 1, "b1 USN mismatch")
+		assert.Equal(t, b1Record.Dirty, true, "b1 Dirty mismatch")
+
+		assert.Equal(t, b2Record.Label, "foo_2", "b2 Label mismatch")
+		assert.Equal(t, b2Record.USN, 2, "b2 USN mismatch")
+		assert.Equal(t, b2Record.Dirty, true, "b2 Dirty mismatch")
+
+		assert.Equal(t, b3Record.Label, "foo_3", "b3 Label mismatch")
+		assert.Equal(t, b3Record.USN, 3, "b3 USN mismatch")
+		assert.Equal(t, b3Record.Dirty, false, "b3 Dirty mismatch")
+
+		assert.Equal(t, b4Record.Label, "foo", "b4 Label mismatch")
+		assert.Equal(t, b4Record.USN, 12, "b4 USN mismatch")
+		assert.Equal(t, b4Record.Dirty, false, "b4 Dirty mismatch")
+	})
+
+	t.Run("update, no duplicates", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		// test
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		b1UUID := testutils.MustGenerateUUID(t)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", b1UUID, 1, "b1-label", false, false)
+
+		b1 := client.SyncFragBook{
+			UUID:    b1UUID,
+			USN:     12,
+			AddedOn: 1541108743,
+			Label:   "b1-label-edited",
+			Deleted: false,
+		}
+
+		if err := mergeBook(tx, mustPrepareStmts(t, tx), b1, modeUpdate); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// execute
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 0, "note count mismatch")
+		assert.Equalf(t, bookCount, 1, "book count mismatch")
+
+		var b1Record database.Book
+		database.MustScan(t, "getting b1",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", b1UUID),
+			&b1Record.UUID, &b1Record.Label, &b1Record.USN, &b1Record.Dirty)
+
+		assert.Equal(t, b1Record.UUID, b1UUID, "b1 UUID mismatch")
+		assert.Equal(t, b1Record.Label, "b1-label-edited", "b1 Label mismatch")
+		assert.Equal(t, b1Record.USN, 12, "b1 USN mismatch")
+	})
+
+	t.Run("update, 1 duplicate", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b1-uuid", 1, "foo", false, false)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b2-uuid", 2, "bar", false, false)
+
+		// test
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		b := client.SyncFragBook{
+			UUID:    "b1-uuid",
+			USN:     12,
+			AddedOn: 1541108743,
+			Label:   "bar",
+			Deleted: false,
+		}
+
+		if err := mergeBook(tx, mustPrepareStmts(t, tx), b, modeUpdate); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// execute
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 0, "note count mismatch")
+		assert.Equalf(t, bookCount, 2, "book count mismatch")
+
+		var b1Record, b2Record database.Book
+		database.MustScan(t, "getting b1",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b1-uuid"),
+			&b1Record.UUID, &b1Record.Label, &b1Record.USN, &b1Record.Dirty)
+		database.MustScan(t, "getting b2",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b2-uuid"),
+			&b2Record.UUID, &b2Record.Label, &b2Record.USN, &b2Record.Dirty)
+
+		assert.Equal(t, b1Record.Label, "bar", "b1 Label mismatch")
+		assert.Equal(t, b1Record.USN, 12, "b1 USN mismatch")
+		assert.Equal(t, b1Record.Dirty, false, "b1 Dirty mismatch")
+
+		assert.Equal(t, b2Record.Label, "bar_2", "b2 Label mismatch")
+		assert.Equal(t, b2Record.USN, 2, "b2 USN mismatch")
+		assert.Equal(t, b2Record.Dirty, true, "b2 Dirty mismatch")
+	})
+
+	t.Run("update, 3 duplicate", func(t *testing.T) {
+		// set uj
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b1-uuid", 1, "foo", false, false)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b2-uuid", 2, "bar", false, false)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b3-uuid", 3, "bar_2", true, false)
+		database.MustExec(t, "inserting book", db, "INSERT INTO books (uuid, usn, label, dirty, deleted) VALUES (?, ?, ?, ?, ?)", "b4-uuid", 4, "bar_3", false, false)
+
+		// test
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		b := client.SyncFragBook{
+			UUID:    "b1-uuid",
+			USN:     12,
+			AddedOn: 1541108743,
+			Label:   "bar",
+			Deleted: false,
+		}
+
+		if err := mergeBook(tx, mustPrepareStmts(t, tx), b, modeUpdate); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// execute
+		var noteCount, bookCount int
+		database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+		database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+		assert.Equalf(t, noteCount, 0, "note count mismatch")
+		assert.Equalf(t, bookCount, 4, "book count mismatch")
+
+		var b1Record, b2Record, b3Record, b4Record database.Book
+		database.MustScan(t, "getting b1",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b1-uuid"),
+			&b1Record.UUID, &b1Record.Label, &b1Record.USN, &b1Record.Dirty)
+		database.MustScan(t, "getting b2",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b2-uuid"),
+			&b2Record.UUID, &b2Record.Label, &b2Record.USN, &b2Record.Dirty)
+		database.MustScan(t, "getting b3",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b3-uuid"),
+			&b3Record.UUID, &b3Record.Label, &b3Record.USN, &b3Record.Dirty)
+		database.MustScan(t, "getting b4",
+			db.QueryRow("SELECT uuid, label, usn, dirty FROM books WHERE uuid = ?", "b4-uuid"),
+			&b4Record.UUID, &b4Record.Label, &b4Record.USN, &b4Record.Dirty)
+
+		assert.Equal(t, b1Record.Label, "bar", "b1 Label mismatch")
+		assert.Equal(t, b1Record.USN, 12, "b1 USN mismatch")
+		assert.Equal(t, b1Record.Dirty, false, "b1 Dirty mismatch")
+
+		assert.Equal(t, b2Record.Label, "bar_4", "b2 Label mismatch")
+		assert.Equal(t, b2Record.USN, 2, "b2 USN mismatch")
+		assert.Equal(t, b2Record.Dirty, true, "b2 Dirty mismatch")
+
+		assert.Equal(t, b3Record.Label, "bar_2", "b3 Label mismatch")
+		assert.Equal(t, b3Record.USN, 3, "b3 USN mismatch")
+		assert.Equal(t, b3Record.Dirty, true, "b3 Dirty mismatch")
+
+		assert.Equal(t, b4Record.Label, "bar_3", "b4 Label mismatch")
+		assert.Equal(t, b4Record.USN, 4, "b4 USN mismatch")
+		assert.Equal(t, b4Record.Dirty, false, "b4 Dirty mismatch")
+	})
+
+	t.Run("insert, 1 duplicate, rename incoming", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		f := testutils.NewFixture(t, db)
+		f.Book("foo", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		b := client.SyncFragBook{
+			UUID:    "b2-uuid",
+			USN:     12,
+			AddedOn: 1541108743,
+			Label:   "foo",
+			Deleted: false,
+		}
+
+		stmts := mustPrepareStmts(t, tx)
+		stmts.labels = labelPolicy{policy: consts.DuplicateLabelRenameIncoming}
+		if err := mergeBook(tx, stmts, b, modeInsert); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		b1Record := f.MustGetBook("b1-uuid")
+		assert.Equal(t, b1Record.Label, "foo", "b1 Label mismatch")
+		assert.Equal(t, b1Record.Dirty, false, "b1 Dirty mismatch")
+
+		// the new label is uploaded
+		b2Record := f.MustGetBook("b2-uuid")
+		assert.Equal(t, b2Record.Label, "foo_2", "b2 Label mismatch")
+		assert.Equal(t, b2Record.USN, 12, "b2 USN mismatch")
+		assert.Equal(t, b2Record.Dirty, true, "b2 Dirty mismatch")
+	})
+
+	t.Run("update, 1 duplicate, rename incoming", func(t *testing.T) {
+		// set up
+		db := database.InitTestDB(t, dbPath, nil)
+		defer database.TeardownTestDB(t, db)
+
+		f := testutils.NewFixture(t, db)
+		f.Book("foo", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+		f.Book("bar", testutils.With.UUID("b2-uuid"), testutils.With.USN(2))
+
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+
+		b := client.SyncFragBook{
+			UUID:    "b1-uuid",
+			USN:     12,
+			AddedOn: 1541108743,
+			Label:   "bar",
+			Deleted: false,
+		}
+
+		stmts := mustPrepareStmts(t, tx)
+		stmts.labels = labelPolicy{policy: consts.DuplicateLabelRenameIncoming}
+		if err := mergeBook(tx, stmts, b, modeUpdate); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		b1Record := f.MustGetBook("b1-uuid")
+		assert.Equal(t, b1Record.Label, "bar_2", "b1 Label mismatch")
+		assert.Equal(t, b1Record.USN, 12, "b1 USN mismatch")
+		assert.Equal(t, b1Record.Dirty, true, "b1 Dirty mismatch")
+
+		b2Record := f.MustGetBook("b2-uuid")
+		assert.Equal(t, b2Record.Label, "bar", "b2 Label mismatch")
+		assert.Equal(t, b2Record.Dirty, false, "b2 Dirty mismatch")
+	})
+
+	t.Run("update, 1 duplicate, prompt", func(t *testing.T) {
+		testCases := []struct {
+			name        string
+			interactive bool
+			incoming    bool
+			// expectedLabels are the labels of b1 and b2
+			expectedLabels []string
+			expectedDirty  []bool
+			expectedPrompt bool
+		}{
+			{
+				name:           "rename incoming",
+				interactive:    true,
+				incoming:       true,
+				expectedLabels: []string{"bar_2", "bar"},
+				expectedDirty:  []bool{true, false},
+				expectedPrompt: true,
+			},
+			{
+				name:           "rename local",
+				interactive:    true,
+				incoming:       false,
+				expectedLabels: []string{"bar", "bar_2"},
+				expectedDirty:  []bool{false, true},
+				expectedPrompt: true,
+			},
+			{
+				name:           "non-interactive",
+				interactive:    false,
+				expectedLabels: []string{"bar", "bar_2"},
+				expectedDirty:  []bool{false, true},
+				expectedPrompt: false,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				ui.SetInteractive(tc.interactive)
+				defer ui.SetInteractive(ui.IsTerminal())
+
+				// set up
+				db := database.InitTestDB(t, dbPath, nil)
+				defer database.TeardownTestDB(t, db)
+
+				f := testutils.NewFixture(t, db)
+				b1 := f.Book("foo", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+				b2 := f.Book("bar", testutils.With.UUID("b2-uuid"), testutils.With.USN(2))
+				f.Note(b1, "n1 body")
+				f.Note(b2, "n2 body")
+				f.Note(b2, "n3 body")
+				f.Note(b2, "n4 body", testutils.With.Deleted())
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+				}
+
+				b := client.SyncFragBook{
+					UUID:    "b1-uuid",
+					USN:     12,
+					AddedOn: 1541108743,
+					Label:   "bar",
+					Deleted: false,
+				}
+
+				var prompted bool
+				stmts := mustPrepareStmts(t, tx)
+				stmts.labels = labelPolicy{
+					policy: consts.DuplicateLabelPrompt,
+					// more notes are downloaded with the book than it has locally
+					listNotes: map[string]int{"b1-uuid": 3},
+					choose: func(label string, localNotes, incomingNotes int) (bool, error) {
+						prompted = true
+
+						assert.Equal(t, label, "bar", "label mismatch")
+						assert.Equal(t, localNotes, 2, "local note count mismatch")
+						assert.Equal(t, incomingNotes, 3, "incoming note count mismatch")
+
+						return tc.incoming, nil
+					},
+				}
+				if err := mergeBook(tx, stmts, b, modeUpdate); err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, "executing").Error())
+				}
+
+				tx.Commit()
+
+				// test
+				assert.Equal(t, prompted, tc.expectedPrompt, "prompted mismatch")
+
+				b1Record := f.MustGetBook("b1-uuid")
+				b2Record := f.MustGetBook("b2-uuid")
+				assert.DeepEqual(t, []string{b1Record.Label, b2Record.Label}, tc.expectedLabels, "labels mismatch")
+				assert.DeepEqual(t, []bool{b1Record.Dirty, b2Record.Dirty}, tc.expectedDirty, "dirty mismatch")
+			})
+		}
+	})
+}
+
+func TestSaveServerState(t *testing.T) {
+	testCases := []struct {
+		name     string
+		existing bool
+	}{
+		{
+			name:     "existing records",
+			existing: true,
+		},
+		{
+			name:     "missing records",
+			existing: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			ctx := context.InitTestCtx(t, paths, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			testutils.Login(t, &ctx)
+
+			db := ctx.DB
+
+			if tc.existing {
+				database.MustExec(t, "inserting last synced at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, int64(1231108742))
+				database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 8)
+			}
+
+			// execute
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+			}
+
+			serverTime := int64(1541108743)
+			serverMaxUSN := 100
+
+			err = saveSyncState(tx, serverTime, Cursor(strconv.Itoa(serverMaxUSN)))
+			if err != nil {
+				tx.Rollback()
+				t.Fatalf(errors.Wrap(err, "executing").Error())
+			}
+
+			tx.Commit()
+
+			// test
+			var lastSyncedAt int64
+			var lastMaxUSN int
+			var count int
+
+			database.MustScan(t, "getting system value",
+				db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastSyncAt), &lastSyncedAt)
+			database.MustScan(t, "getting system value",
+				db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+			database.MustScan(t, "counting system records",
+				db.QueryRow("SELECT count(*) FROM system WHERE key IN (?, ?)", consts.SystemLastSyncAt, consts.SystemLastMaxUSN), &count)
+
+			assert.Equal(t, lastSyncedAt, serverTime, "last synced at mismatch")
+			assert.Equal(t, lastMaxUSN, serverMaxUSN, "last max usn mismatch")
+			assert.Equal(t, count, 2, "system record count mismatch")
+		})
+	}
+}
+
+// TestSendBooks tests that books are put to correct 'buckets' by running a test server and recording the
+// uuid from the incoming data. It also tests that the uuid of the created books and book_uuids of their notes
+// are updated accordingly based on the server response.
+func TestSendBooks(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+
+	// should be ignored
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false, false)
+	database.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b2-uuid", "b2-label", 2, false, false)
+	// should be created
+	database.MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b3-uuid", "b3-label", 0, false, true)
+	database.MustExec(t, "inserting b4", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b4-uuid", "b4-label", 0, false, true)
+	// should be only expunged locally without syncing to server
+	database.MustExec(t, "inserting b5", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b5-uuid", "b5-label", 0, true, true)
+	// should be deleted
+	database.MustExec(t, "inserting b6", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b6-uuid", "b6-label", 10, true, true)
+	// should be updated
+	database.MustExec(t, "inserting b7", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b7-uuid", "b7-label", 11, false, true)
+	database.MustExec(t, "inserting b8", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b8-uuid", "b8-label", 18, false, true)
+
+	// some random notes
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 10, "n1 body", 1541108743, false, false)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "b5-uuid", 10, "n2 body", 1541108743, false, false)
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n3-uuid", "b6-uuid", 10, "n3 body", 1541108743, false, false)
+	database.MustExec(t, "inserting n4", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n4-uuid", "b7-uuid", 10, "n4 body", 1541108743, false, false)
+	// notes that belong to the created book. Their book_uuid should be updated.
+	database.MustExec(t, "inserting n5", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n5-uuid", "b3-uuid", 10, "n5 body", 1541108743, false, false)
+	database.MustExec(t, "inserting n6", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n6-uuid", "b3-uuid", 10, "n6 body", 1541108743, false, false)
+	database.MustExec(t, "inserting n7", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n7-uuid", "b4-uuid", 10, "n7 body", 1541108743, false, false)
+
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	ctx.APIEndpoint = srv.URL()
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	if _, err := sendBooks(ctx, NewHTTPTransport(ctx), tx, unlimited); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Commit()
+
+	// test
+
+	createdLabels := srv.CreatedBookLabels()
+	sort.Strings(createdLabels)
+
+	assert.DeepEqual(t, createdLabels, []string{"b3-label", "b4-label"}, "createdLabels mismatch")
+	assert.DeepEqual(t, srv.UpdatedBookUUIDs(), []string{"b7-uuid", "b8-uuid"}, "updatesUUIDs mismatch")
+	assert.DeepEqual(t, srv.DeletedBookUUIDs(), []string{"b6-uuid"}, "deletedUUIDs mismatch")
+
+	var b1, b2, b3, b4, b7, b8 database.Book
+	database.MustScan(t, "getting b1", db.QueryRow("SELECT uuid, dirty FROM books WHERE label = ?", "b1-label"), &b1.UUID, &b1.Dirty)
+	database.MustScan(t, "getting b2", db.QueryRow("SELECT uuid, dirty FROM books WHERE label = ?", "b2-label"), &b2.UUID, &b2.Dirty)
+	database.MustScan(t, "getting b3", db.QueryRow("SELECT uuid, dirty FROM books WHERE label = ?", "b3-label"), &b3.UUID, &b3.Dirty)
+	database.MustScan(t, "getting b4", db.QueryRow("SELECT uuid, dirty FROM books WHERE label = ?", "b4-label"), &b4.UUID, &b4.Dirty)
+	database.MustScan(t, "getting b7", db.QueryRow("SELECT uuid, dirty FROM books WHERE label = ?", "b7-label"), &b7.UUID, &b7.Dirty)
+	database.MustScan(t, "getting b8", db.QueryRow("SELECT uuid, dirty FROM books WHERE label = ?", "b8-label"), &b8.UUID, &b8.Dirty)
+
+	var bookCount int
+	database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+	assert.Equalf(t, bookCount, 6, "book count mismatch")
+
+	assert.Equal(t, b1.Dirty, false, "b1 Dirty mismatch")
+	assert.Equal(t, b2.Dirty, false, "b2 Dirty mismatch")
+	assert.Equal(t, b3.Dirty, false, "b3 Dirty mismatch")
+	assert.Equal(t, b4.Dirty, false, "b4 Dirty mismatch")
+	assert.Equal(t, b7.Dirty, false, "b7 Dirty mismatch")
+	assert.Equal(t, b8.Dirty, false, "b8 Dirty mismatch")
+	assert.Equal(t, b1.UUID, "b1-uuid", "b1 UUID mismatch")
+	assert.Equal(t, b2.UUID, "b2-uuid", "b2 UUID mismatch")
+	// uuids of created books should have been updated
+	assert.Equal(t, b3.UUID, "server-b3-label-uuid", "b3 UUID mismatch")
+	assert.Equal(t, b4.UUID, "server-b4-label-uuid", "b4 UUID mismatch")
+	assert.Equal(t, b7.UUID, "b7-uuid", "b7 UUID mismatch")
+	assert.Equal(t, b8.UUID, "b8-uuid", "b8 UUID mismatch")
+
+	var n1, n2, n3, n4, n5, n6, n7 database.Note
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT book_uuid FROM notes WHERE body = ?", "n1 body"), &n1.BookUUID)
+	database.MustScan(t, "getting n2", db.QueryRow("SELECT book_uuid FROM notes WHERE body = ?", "n2 body"), &n2.BookUUID)
+	database.MustScan(t, "getting n3", db.QueryRow("SELECT book_uuid FROM notes WHERE body = ?", "n3 body"), &n3.BookUUID)
+	database.MustScan(t, "getting n4", db.QueryRow("SELECT book_uuid FROM notes WHERE body = ?", "n4 body"), &n4.BookUUID)
+	database.MustScan(t, "getting n5", db.QueryRow("SELECT book_uuid FROM notes WHERE body = ?", "n5 body"), &n5.BookUUID)
+	database.MustScan(t, "getting n6", db.QueryRow("SELECT book_uuid FROM notes WHERE body = ?", "n6 body"), &n6.BookUUID)
+	database.MustScan(t, "getting n7", db.QueryRow("SELECT book_uuid FROM notes WHERE body = ?", "n7 body"), &n7.BookUUID)
+	assert.Equal(t, n1.BookUUID, "b1-uuid", "n1 bookUUID mismatch")
+	assert.Equal(t, n2.BookUUID, "b5-uuid", "n2 bookUUID mismatch")
+	assert.Equal(t, n3.BookUUID, "b6-uuid", "n3 bookUUID mismatch")
+	assert.Equal(t, n4.BookUUID, "b7-uuid", "n4 bookUUID mismatch")
+	assert.Equal(t, n5.BookUUID, "server-b3-label-uuid", "n5 bookUUID mismatch")
+	assert.Equal(t, n6.BookUUID, "server-b3-label-uuid", "n6 bookUUID mismatch")
+	assert.Equal(t, n7.BookUUID, "server-b4-label-uuid", "n7 bookUUID mismatch")
+}
+
+func TestSendBooks_order(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+
+	// the new books sort before the others by uuid, and are inserted first
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "a1-uuid", "js", 0, false, true)
+	database.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "a2-uuid", "css", 0, false, true)
+	database.MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b3-uuid", "go", 10, false, true)
+	database.MustExec(t, "inserting b4", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b4-uuid", "", 11, true, true)
+
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	ctx.APIEndpoint = srv.URL()
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	if _, err := sendBooks(ctx, NewHTTPTransport(ctx), tx, unlimited); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Commit()
+
+	// test
+	var got []string
+	for _, r := range srv.Requests() {
+		got = append(got, r.Method+" "+r.Path)
+	}
+
+	// the deletion and the update come first, because they may free a label
+	// taken by a new book
+	assert.Equal(t, len(got), 4, "request count mismatch")
+	assert.Equal(t, got[0], "DELETE /v3/books/b4-uuid", "request 0 mismatch")
+	assert.Equal(t, got[1], "PATCH /v3/books/b3-uuid", "request 1 mismatch")
+	assert.Equal(t, got[2], "POST /v3/books", "request 2 mismatch")
+	assert.Equal(t, got[3], "POST /v3/books", "request 3 mismatch")
+}
+
+func TestSendBooks_isBehind(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	srv.DefaultUSN = 11
+	defer srv.Close()
+
+	t.Run("create book", func(t *testing.T) {
+		testCases := []struct {
+			systemLastMaxUSN int
+			expectedIsBehind bool
+		}{
+			{
+				systemLastMaxUSN: 10,
+				expectedIsBehind: false,
+			},
+			{
+				systemLastMaxUSN: 9,
+				expectedIsBehind: true,
+			},
+		}
+
+		for idx, tc := range testCases {
+			func() {
+				// set up
+				ctx := context.InitTestCtx(t, paths, nil)
+				ctx.APIEndpoint = srv.URL()
+				defer context.TeardownTestCtx(t, ctx)
+				testutils.Login(t, &ctx)
+
+				db := ctx.DB
+
+				database.MustExec(t, fmt.Sprintf("inserting last max usn for test case %d", idx), db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, tc.systemLastMaxUSN)
+				database.MustExec(t, fmt.Sprintf("inserting b1 for test case %d", idx), db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 0, false, true)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+				}
+
+				isBehind, err := sendBooks(ctx, NewHTTPTransport(ctx), tx, unlimited)
+				if err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+				}
+
+				tx.Commit()
+
+				// test
+				assert.Equal(t, isBehind, tc.expectedIsBehind, fmt.Sprintf("isBehind mismatch for test case %d", idx))
+			}()
+		}
+	})
+
+	t.Run("delete book", func(t *testing.T) {
+		testCases := []struct {
+			systemLastMaxUSN int
+			expectedIsBehind bool
+		}{
+			{
+				systemLastMaxUSN: 10,
+				expectedIsBehind: false,
+			},
+			{
+				systemLastMaxUSN: 9,
+				expectedIsBehind: true,
+			},
+		}
+
+		for idx, tc := range testCases {
+			func() {
+				// set up
+				ctx := context.InitTestCtx(t, paths, nil)
+				ctx.APIEndpoint = srv.URL()
+				defer context.TeardownTestCtx(t, ctx)
+				testutils.Login(t, &ctx)
+
+				db := ctx.DB
+
+				database.MustExec(t, fmt.Sprintf("inserting last max usn for test case %d", idx), db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, tc.systemLastMaxUSN)
+				database.MustExec(t, fmt.Sprintf("inserting b1 for test case %d", idx), db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 1, true, true)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+				}
+
+				isBehind, err := sendBooks(ctx, NewHTTPTransport(ctx), tx, unlimited)
+				if err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+				}
+
+				tx.Commit()
+
+				// test
+				assert.Equal(t, isBehind, tc.expectedIsBehind, fmt.Sprintf("isBehind mismatch for test case %d", idx))
+			}()
+		}
+	})
+
+	t.Run("update book", func(t *testing.T) {
+		testCases := []struct {
+			systemLastMaxUSN int
+			expectedIsBehind bool
+		}{
+			{
+				systemLastMaxUSN: 10,
+				expectedIsBehind: false,
+			},
+			{
+				systemLastMaxUSN: 9,
+				expectedIsBehind: true,
+			},
+		}
+
+		for idx, tc := range testCases {
+			func() {
+				// set up
+				ctx := context.InitTestCtx(t, paths, nil)
+				ctx.APIEndpoint = srv.URL()
+				defer context.TeardownTestCtx(t, ctx)
+				testutils.Login(t, &ctx)
+
+				db := ctx.DB
+
+				database.MustExec(t, fmt.Sprintf("inserting last max usn for test case %d", idx), db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, tc.systemLastMaxUSN)
+				database.MustExec(t, fmt.Sprintf("inserting b1 for test case %d", idx), db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 11, false, true)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+				}
+
+				isBehind, err := sendBooks(ctx, NewHTTPTransport(ctx), tx, unlimited)
+				if err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+				}
+
+				tx.Commit()
+
+				// test
+				assert.Equal(t, isBehind, tc.expectedIsBehind, fmt.Sprintf("isBehind mismatch for test case %d", idx))
+			}()
+		}
+	})
+}
+
+// TestSendNotes tests that notes are put to correct 'buckets' by running a test server and recording the
+// uuid from the incoming data.
+func TestSendNotes(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+
+	b1UUID := "b1-uuid"
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", b1UUID, "b1-label", 1, false, false)
+
+	// should be ignored
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", b1UUID, 10, "n1-body", 1541108743, false, false)
+	// should be created
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", b1UUID, 0, "n2-body", 1541108743, false, true)
+	// should be updated
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n3-uuid", b1UUID, 11, "n3-body", 1541108743, false, true)
+	// should be only expunged locally without syncing to server
+	database.MustExec(t, "inserting n4", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n4-uuid", b1UUID, 0, "n4-body", 1541108743, true, true)
+	// should be deleted
+	database.MustExec(t, "inserting n5", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n5-uuid", b1UUID, 17, "n5-body", 1541108743, true, true)
+	// should be created
+	database.MustExec(t, "inserting n6", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n6-uuid", b1UUID, 0, "n6-body", 1541108743, false, true)
+	// should be ignored
+	database.MustExec(t, "inserting n7", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n7-uuid", b1UUID, 12, "n7-body", 1541108743, false, false)
+	// should be updated
+	database.MustExec(t, "inserting n8", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n8-uuid", b1UUID, 17, "n8-body", 1541108743, false, true)
+	// should be deleted
+	database.MustExec(t, "inserting n9", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n9-uuid", b1UUID, 17, "n9-body", 1541108743, true, true)
+	// should be created
+	database.MustExec(t, "inserting n10", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n10-uuid", b1UUID, 0, "n10-body", 1541108743, false, true)
+
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	ctx.APIEndpoint = srv.URL()
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	if _, err := sendNotes(ctx, NewHTTPTransport(ctx), tx, unlimited, nil); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Commit()
+
+	// test
+	createdBodys := srv.CreatedNoteBodies()
+	sort.Strings(createdBodys)
+
+	assert.DeepEqual(t, createdBodys, []string{"n10-body", "n2-body", "n6-body"}, "createdBodys mismatch")
+	assert.DeepEqual(t, srv.UpdatedNoteUUIDs(), []string{"n3-uuid", "n8-uuid"}, "updatedUUIDs mismatch")
+	assert.DeepEqual(t, srv.DeletedNoteUUIDs(), []string{"n5-uuid", "n9-uuid"}, "deletedUUIDs mismatch")
+
+	var noteCount int
+	database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+	assert.Equalf(t, noteCount, 7, "note count mismatch")
+
+	var n1, n2, n3, n6, n7, n8, n10 database.Note
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT uuid, added_on, dirty FROM notes WHERE body = ?", "n1-body"), &n1.UUID, &n1.AddedOn, &n1.Dirty)
+	database.MustScan(t, "getting n2", db.QueryRow("SELECT uuid, added_on, dirty FROM notes WHERE body = ?", "n2-body"), &n2.UUID, &n2.AddedOn, &n2.Dirty)
+	database.MustScan(t, "getting n3", db.QueryRow("SELECT uuid, added_on, dirty FROM notes WHERE body = ?", "n3-body"), &n3.UUID, &n3.AddedOn, &n3.Dirty)
+	database.MustScan(t, "getting n6", db.QueryRow("SELECT uuid, added_on, dirty FROM notes WHERE body = ?", "n6-body"), &n6.UUID, &n6.AddedOn, &n6.Dirty)
+	database.MustScan(t, "getting n7", db.QueryRow("SELECT uuid, added_on, dirty FROM notes WHERE body = ?", "n7-body"), &n7.UUID, &n7.AddedOn, &n7.Dirty)
+	database.MustScan(t, "getting n8", db.QueryRow("SELECT uuid, added_on, dirty FROM notes WHERE body = ?", "n8-body"), &n8.UUID, &n8.AddedOn, &n8.Dirty)
+	database.MustScan(t, "getting n10", db.QueryRow("SELECT uuid, added_on, dirty FROM notes WHERE body = ?", "n10-body"), &n10.UUID, &n10.AddedOn, &n10.Dirty)
+
+	assert.Equalf(t, noteCount, 7, "note count mismatch")
+
+	assert.Equal(t, n1.Dirty, false, "n1 Dirty mismatch")
+	assert.Equal(t, n2.Dirty, false, "n2 Dirty mismatch")
+	assert.Equal(t, n3.Dirty, false, "n3 Dirty mismatch")
+	assert.Equal(t, n6.Dirty, false, "n6 Dirty mismatch")
+	assert.Equal(t, n7.Dirty, false, "n7 Dirty mismatch")
+	assert.Equal(t, n8.Dirty, false, "n8 Dirty mismatch")
+	assert.Equal(t, n10.Dirty, false, "n10 Dirty mismatch")
+
+	assert.Equal(t, n1.AddedOn, int64(1541108743), "n1 AddedOn mismatch")
+	assert.Equal(t, n2.AddedOn, int64(1541108743), "n2 AddedOn mismatch")
+	assert.Equal(t, n3.AddedOn, int64(1541108743), "n3 AddedOn mismatch")
+	assert.Equal(t, n6.AddedOn, int64(1541108743), "n6 AddedOn mismatch")
+	assert.Equal(t, n7.AddedOn, int64(1541108743), "n7 AddedOn mismatch")
+	assert.Equal(t, n8.AddedOn, int64(1541108743), "n8 AddedOn mismatch")
+	assert.Equal(t, n10.AddedOn, int64(1541108743), "n10 AddedOn mismatch")
+
+	// UUIDs of created notes should have been updated with those from the server response
+	assert.Equal(t, n1.UUID, "n1-uuid", "n1 UUID mismatch")
+	assert.Equal(t, n2.UUID, "server-n2-body-uuid", "n2 UUID mismatch")
+	assert.Equal(t, n3.UUID, "n3-uuid", "n3 UUID mismatch")
+	assert.Equal(t, n6.UUID, "server-n6-body-uuid", "n6 UUID mismatch")
+	assert.Equal(t, n7.UUID, "n7-uuid", "n7 UUID mismatch")
+	assert.Equal(t, n8.UUID, "n8-uuid", "n8 UUID mismatch")
+	assert.Equal(t, n10.UUID, "server-n10-body-uuid", "n10 UUID mismatch")
+}
+
+// TestSendNotes_normalizeBody tests that the bodies are stored as they are sent,
+// so that the local copies equal the server copies after the sync
+func TestSendNotes_normalizeBody(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false, false)
+	// should be created
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 0, "n1\r\nbody\xff", 1541108743, false, true)
+	// should be updated
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", 11, "n2\r\nbody\xfe", 1541108743, false, true)
+
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	ctx.APIEndpoint = srv.URL()
+
+	// execute
+	send := func() {
+		if err := infra.WithTx(db, func(tx *database.DB) error {
+			_, err := sendNotes(ctx, NewHTTPTransport(ctx), tx, unlimited, nil)
+			return err
+		}); err != nil {
+			t.Fatal(errors.Wrap(err, "sending notes"))
+		}
+	}
+	send()
+
+	// test
+	n1Body := "n1\nbody\uFFFD"
+	n2Body := "n2\nbody\uFFFD"
+
+	assert.DeepEqual(t, srv.CreatedNoteBodies(), []string{n1Body}, "created bodies mismatch")
+
+	var sentN2Body string
+	for _, req := range srv.Requests() {
+		if req.Method == "PATCH" && req.Path == "/v3/notes/n2-uuid" {
+			var payload struct {
+				Body string `json:"content"`
+			}
+			if err := json.Unmarshal(req.Body, &payload); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding the n2 payload"))
+			}
+
+			sentN2Body = payload.Body
+		}
+	}
+	assert.Equal(t, sentN2Body, n2Body, "sent n2 body mismatch")
+
+	var n1LocalBody, n2LocalBody string
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT body FROM notes WHERE uuid != ?", "n2-uuid"), &n1LocalBody)
+	database.MustScan(t, "getting n2", db.QueryRow("SELECT body FROM notes WHERE uuid = ?", "n2-uuid"), &n2LocalBody)
+	assert.Equal(t, n1LocalBody, n1Body, "n1 local body mismatch")
+	assert.Equal(t, n2LocalBody, n2Body, "n2 local body mismatch")
+
+	// nothing is left to send in the next sync
+	requestCount := len(srv.Requests())
+	send()
+	assert.Equal(t, len(srv.Requests()), requestCount, "request count mismatch for the next sync")
+}
+
+func TestSendNotes_addedOn(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+
+	// should be created
+	b1UUID := "b1-uuid"
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "b1-label")
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", b1UUID, 0, "n1-body", 1541108743, false, true)
+
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	ctx.APIEndpoint = srv.URL()
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	if _, err := sendNotes(ctx, NewHTTPTransport(ctx), tx, unlimited, nil); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Commit()
+
+	// test
+	var n1 database.Note
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT uuid, added_on, dirty FROM notes WHERE body = ?", "n1-body"), &n1.UUID, &n1.AddedOn, &n1.Dirty)
+	assert.Equal(t, n1.AddedOn, int64(1541108743), "n1 AddedOn mismatch")
+}
+
+func TestSendNotes_tooLarge(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	srv.Fail("POST", "/v3/notes", http.StatusRequestEntityTooLarge, 1)
+
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+	ctx.NoteSizeMax = 1000
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+
+	f := testutils.NewFixture(t, db)
+	b1 := f.Book("b1-label")
+	n1 := f.Note(b1, "n1 body", testutils.With.Dirty())
+
+	// execute
+	err := infra.WithTx(db, func(tx *database.DB) error {
+		_, err := sendNotes(ctx, NewHTTPTransport(ctx), tx, unlimited, nil)
+		return err
+	})
+
+	// test
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	expected := fmt.Sprintf("creating a note: the note %s is 7 bytes, which is too large for the server. Split the note or make it smaller with dnote edit, and set noteSize.max in the config, now 1000 bytes", n1.UUID)
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected an error containing %q but got %q", expected, err.Error())
+	}
+
+	var apiErr *client.APIError
+	assert.Equal(t, errors.As(err, &apiErr), true, "api error mismatch")
+	assert.Equal(t, apiErr.Code(), client.CodeTooLarge, "error code mismatch")
+	assert.DeepEqual(t, f.MustGetNote(n1.UUID), n1, "n1 mismatch")
+}
+
+func TestSendNotes_isBehind(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	srv.DefaultUSN = 11
+	defer srv.Close()
+
+	t.Run("create note", func(t *testing.T) {
+		testCases := []struct {
+			systemLastMaxUSN int
+			expectedIsBehind bool
+		}{
+			{
+				systemLastMaxUSN: 10,
+				expectedIsBehind: false,
+			},
+			{
+				systemLastMaxUSN: 9,
+				expectedIsBehind: true,
+			},
+		}
+
+		for idx, tc := range testCases {
+			func() {
+				// set up
+				ctx := context.InitTestCtx(t, paths, nil)
+				defer context.TeardownTestCtx(t, ctx)
+				testutils.Login(t, &ctx)
+				ctx.APIEndpoint = srv.URL()
+
+				db := ctx.DB
+
+				database.MustExec(t, fmt.Sprintf("inserting last max usn for test case %d", idx), db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, tc.systemLastMaxUSN)
+				database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false, false)
+				database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 1, "n1 body", 1541108743, false, true)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+				}
+
+				isBehind, err := sendNotes(ctx, NewHTTPTransport(ctx), tx, unlimited, nil)
+				if err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+				}
+
+				tx.Commit()
+
+				// test
+				assert.Equal(t, isBehind, tc.expectedIsBehind, fmt.Sprintf("isBehind mismatch for test case %d", idx))
+			}()
+		}
+	})
+
+	t.Run("delete note", func(t *testing.T) {
+		testCases := []struct {
+			systemLastMaxUSN int
+			expectedIsBehind bool
+		}{
+			{
+				systemLastMaxUSN: 10,
+				expectedIsBehind: false,
+			},
+			{
+				systemLastMaxUSN: 9,
+				expectedIsBehind: true,
+			},
+		}
+
+		for idx, tc := range testCases {
+			func() {
+				// set up
+				ctx := context.InitTestCtx(t, paths, nil)
+				defer context.TeardownTestCtx(t, ctx)
+				testutils.Login(t, &ctx)
+				ctx.APIEndpoint = srv.URL()
+
+				db := ctx.DB
+
+				database.MustExec(t, fmt.Sprintf("inserting last max usn for test case %d", idx), db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, tc.systemLastMaxUSN)
+				database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false, false)
+				database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 2, "n1 body", 1541108743, true, true)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+				}
+
+				isBehind, err := sendNotes(ctx, NewHTTPTransport(ctx), tx, unlimited, nil)
+				if err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+				}
+
+				tx.Commit()
+
+				// test
+				assert.Equal(t, isBehind, tc.expectedIsBehind, fmt.Sprintf("isBehind mismatch for test case %d", idx))
+			}()
+		}
+	})
+
+	t.Run("update note", func(t *testing.T) {
+		testCases := []struct {
+			systemLastMaxUSN int
+			expectedIsBehind bool
+		}{
+			{
+				systemLastMaxUSN: 10,
+				expectedIsBehind: false,
+			},
+			{
+				systemLastMaxUSN: 9,
+				expectedIsBehind: true,
+			},
+		}
+
+		for idx, tc := range testCases {
+			func() {
+				// set up
+				ctx := context.InitTestCtx(t, paths, nil)
+				defer context.TeardownTestCtx(t, ctx)
+				testutils.Login(t, &ctx)
+				ctx.APIEndpoint = srv.URL()
+
+				db := ctx.DB
+
+				database.MustExec(t, fmt.Sprintf("inserting last max usn for test case %d", idx), db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, tc.systemLastMaxUSN)
+				database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false, false)
+				database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 8, "n1 body", 1541108743, false, true)
+
+				// execute
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+				}
+
+				isBehind, err := sendNotes(ctx, NewHTTPTransport(ctx), tx, unlimited, nil)
+				if err != nil {
+					tx.Rollback()
+					t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+				}
+
+				tx.Commit()
+
+				// test
+				assert.Equal(t, isBehind, tc.expectedIsBehind, fmt.Sprintf("isBehind mismatch for test case %d", idx))
+			}()
+		}
+	})
+}
+
+func TestMergeNote(t *testing.T) {
+	b1UUID := "b1-uuid"
+	b2UUID := "b2-uuid"
+	conflictBookUUID := testutils.MustGenerateUUID(t)
+
+	testCases := []struct {
+		addedOn          int64
+		clientUSN        int
+		clientEditedOn   int64
+		clientBody       string
+		clientDeleted    bool
+		clientBookUUID   string
+		clientDirty      bool
+		serverUSN        int
+		serverEditedOn   int64
+		serverBody       string
+		serverDeleted    bool
+		serverBookUUID   string
+		expectedUSN      int
+		expectedAddedOn  int64
+		expectedEditedOn int64
+		expectedBody     string
+		expectedDeleted  bool
+		expectedBookUUID string
+		expectedDirty    bool
+	}{
+		// local copy is not dirty
+		{
+			clientDirty:      false,
+			clientUSN:        1,
+			clientEditedOn:   0,
+			clientBody:       "n1 body",
+			clientDeleted:    false,
+			clientBookUUID:   b1UUID,
+			addedOn:          1541232118,
+			serverUSN:        21,
+			serverEditedOn:   1541219321,
+			serverBody:       "n1 body edited",
+			serverDeleted:    false,
+			serverBookUUID:   b1UUID,
+			expectedUSN:      21,
+			expectedAddedOn:  1541232118,
+			expectedEditedOn: 1541219321,
+			expectedBody:     "n1 body edited",
+			expectedDeleted:  false,
+			expectedBookUUID: b1UUID,
+			expectedDirty:    false,
+		},
+		// local copy is dirty and needs conflict resolution
+		{
+			clientDirty:      true,
+			clientUSN:        1,
+			clientEditedOn:   1541219320,
+			clientBody:       "n1 body",
+			clientDeleted:    false,
+			clientBookUUID:   b1UUID,
+			addedOn:          1541232118,
+			serverUSN:        21,
+			serverEditedOn:   1541219321,
+			serverBody:       "n1 body edited",
+			serverDeleted:    false,
+			serverBookUUID:   b1UUID,
+			expectedUSN:      21,
+			expectedAddedOn:  1541232118,
+			expectedEditedOn: 1541219321,
+			expectedBody: `<<<<<<< Local
+n1 body
+=======
+n1 body edited
+>>>>>>> Server
+`,
+			expectedDeleted:  false,
+			expectedBookUUID: b1UUID,
+			expectedDirty:    true,
+		},
+		{
+			clientDirty:      true,
+			clientUSN:        1,
+			clientEditedOn:   1541219319,
+			clientBody:       "n1 body",
+			clientDeleted:    false,
+			clientBookUUID:   b1UUID,
+			addedOn:          1541232118,
+			serverUSN:        21,
+			serverEditedOn:   1541219321,
+			serverBody:       "n1 body edited",
+			serverDeleted:    false,
+			serverBookUUID:   b2UUID,
+			expectedUSN:      21,
+			expectedAddedOn:  1541232118,
+			expectedEditedOn: 1541219321,
+			expectedBody: `<<<<<<< Local
+Moved to the book b1-label
+=======
+Moved to the book b2-label
+>>>>>>> Server
+
+<<<<<<< Local
+n1 body
+=======
+n1 body edited
+>>>>>>> Server
+`,
+			expectedDeleted:  false,
+			expectedBookUUID: conflictBookUUID,
+			expectedDirty:    true,
+		},
+		// deleted locally and edited on server
+		{
+			clientDirty:      true,
+			clientUSN:        1,
+			clientEditedOn:   1541219321,
+			clientBody:       "",
+			clientDeleted:    true,
+			clientBookUUID:   b1UUID,
+			addedOn:          1541232118,
+			serverUSN:        21,
+			serverEditedOn:   1541219321,
+			serverBody:       "n1 body edited",
+			serverDeleted:    false,
+			serverBookUUID:   b2UUID,
+			expectedUSN:      21,
+			expectedAddedOn:  1541232118,
+			expectedEditedOn: 1541219321,
+			expectedBody:     "n1 body edited",
+			expectedDeleted:  false,
+			expectedBookUUID: b2UUID,
+			expectedDirty:    false,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			// set up
+			db := database.InitTestDB(t, "../tmp/.dnote", nil)
+			defer database.TeardownTestDB(t, db)
+
+			database.MustExec(t, fmt.Sprintf("inserting b1 for test case %d", idx), db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", b1UUID, "b1-label", 5, false)
+			database.MustExec(t, fmt.Sprintf("inserting b2 for test case %d", idx), db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", b2UUID, "b2-label", 6, false)
+			database.MustExec(t, fmt.Sprintf("inserting conflitcs book for test case %d", idx), db, "INSERT INTO books (uuid, label) VALUES (?, ?)", conflictBookUUID, "conflicts")
+			n1UUID := testutils.MustGenerateUUID(t)
+			database.MustExec(t, fmt.Sprintf("inserting n1 for test case %d", idx), db, "INSERT INTO notes (uuid, book_uuid, usn, added_on, edited_on, body, deleted, dirty, view_count, last_viewed_at) VALUES (?, ?, ?,  ?, ?, ?, ?, ?, ?, ?)", n1UUID, b1UUID, tc.clientUSN, tc.addedOn, tc.clientEditedOn, tc.clientBody, tc.clientDeleted, tc.clientDirty, 4, 1541232200)
+
+			// execute
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+			}
+
+			// update all fields but uuid and bump usn
+			fragNote := client.SyncFragNote{
+				UUID:     n1UUID,
+				BookUUID: tc.serverBookUUID,
+				USN:      tc.serverUSN,
+				AddedOn:  tc.addedOn,
+				EditedOn: tc.serverEditedOn,
+				Body:     tc.serverBody,
+				Deleted:  tc.serverDeleted,
+			}
+			localNote := testutils.MustGetNote(t, db, n1UUID)
+
+			if err := mergeNote(tx, mustPrepareStmts(t, tx), fragNote, localNote); err != nil {
+				tx.Rollback()
+				t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+			}
+
+			tx.Commit()
+
+			// test
+			var noteCount, bookCount int
+			database.MustScan(t, fmt.Sprintf("counting notes for test case %d", idx), db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+			database.MustScan(t, fmt.Sprintf("counting books for test case %d", idx), db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+
+			assert.Equalf(t, noteCount, 1, fmt.Sprintf("note count mismatch for test case %d", idx))
+			assert.Equalf(t, bookCount, 3, fmt.Sprintf("book count mismatch for test case %d", idx))
+
+			n1Record := testutils.MustGetNote(t, db, n1UUID)
+			b1Record := testutils.MustGetBook(t, db, b1UUID)
+			b2Record := testutils.MustGetBook(t, db, b2UUID)
+
+			testutils.AssertBookEqual(t, b1Record, database.Book{
+				UUID:  b1UUID,
+				Label: "b1-label",
+				USN:   5,
+			})
+			testutils.AssertBookEqual(t, b2Record, database.Book{
+				UUID:  b2UUID,
+				Label: "b2-label",
+				USN:   6,
+			})
+			testutils.AssertNoteEqual(t, n1Record, database.Note{
+				UUID:     n1UUID,
+				BookUUID: tc.expectedBookUUID,
+				USN:      tc.expectedUSN,
+				AddedOn:  tc.expectedAddedOn,
+				EditedOn: tc.expectedEditedOn,
+				Body:     tc.expectedBody,
+				Deleted:  tc.expectedDeleted,
+				Dirty:    tc.expectedDirty,
+				// the view stats are local and kept through the merge
+				ViewCount:    4,
+				LastViewedAt: 1541232200,
+			}, "RowID")
+		})
+	}
+}
+
+func TestCheckBookPristine(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, "../tmp/.dnote", nil)
+	defer database.TeardownTestDB(t, db)
+
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b1-uuid", "b1-label", 5, false)
+	database.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b2-uuid", "b2-label", 6, false)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, added_on, body, dirty) VALUES (?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 1541108743, "n1 body", false)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, added_on, body, dirty) VALUES (?, ?, ?, ?, ?)", "n2-uuid", "b1-uuid", 1541108743, "n2 body", false)
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, added_on, body, dirty) VALUES (?, ?, ?, ?, ?)", "n3-uuid", "b1-uuid", 1541108743, "n3 body", true)
+	database.MustExec(t, "inserting n4", db, "INSERT INTO notes (uuid, book_uuid, added_on, body, dirty) VALUES (?, ?, ?, ?, ?)", "n4-uuid", "b2-uuid", 1541108743, "n4 body", false)
+	database.MustExec(t, "inserting n5", db, "INSERT INTO notes (uuid, book_uuid, added_on, body, dirty) VALUES (?, ?, ?, ?, ?)", "n5-uuid", "b2-uuid", 1541108743, "n5 body", false)
+
+	t.Run("b1", func(t *testing.T) {
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+		got, err := checkNotesPristine(tx, "b1-uuid")
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		assert.Equal(t, got, false, "b1 should not be pristine")
+	})
+
+	t.Run("b2", func(t *testing.T) {
+		// execute
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+		got, err := checkNotesPristine(tx, "b2-uuid")
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "executing").Error())
+		}
+
+		tx.Commit()
+
+		// test
+		assert.Equal(t, got, true, "b2 should be pristine")
+	})
+}
+
+func TestCleanLocalNotes(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, "../tmp/.dnote", nil)
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db, Clock: clock.NewMock()}
+
+	list := syncList{
+		Notes: map[string]client.SyncFragNote{
+			"n1-uuid": {
+				UUID: "n1-uuid",
+			},
+			"n2-uuid": {
+				UUID: "n2-uuid",
+			},
+		},
+		Books: map[string]client.SyncFragBook{
+			"b1-uuid": {
+				UUID: "b1-uuid",
+			},
+			"b2-uuid": {
+				UUID: "b2-uuid",
+			},
+		},
+		ExpungedNotes: map[string]struct{}{
+			"n3-uuid": {},
+			"n4-uuid": {},
+		},
+		ExpungedBooks: map[string]struct{}{
+			"b3-uuid": {},
+			"b4-uuid": {},
+		},
+		Cursor:         Cursor("1"),
+		MaxCurrentTime: 2,
+	}
+
+	f := testutils.NewFixture(t, db)
+	b1 := f.Book("b1-label", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+
+	// exists in the list
+	n1 := f.Note(b1, "n1 body", testutils.With.UUID("n1-uuid"), testutils.With.USN(10))
+	n2 := f.Note(b1, "n2 body", testutils.With.UUID("n2-uuid"), testutils.With.Dirty())
+	// non-existent in the list but in valid state
+	// (created in the cli and hasn't been uploaded)
+	n6 := f.Note(b1, "n6 body", testutils.With.Dirty())
+	// non-existent in the list and in an invalid state
+	f.Note(b1, "n5 body", testutils.With.USN(7), testutils.With.Deleted(), testutils.With.Dirty())
+	f.Note(b1, "n9 body", testutils.With.USN(17), testutils.With.Deleted())
+	n10 := f.Note(b1, "n10 body")
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	if err := cleanLocalNotes(ctx, tx, &list); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Commit()
+
+	// test
+	assert.Equal(t, f.MustCountNotes(), 3, "note count mismatch")
+
+	assert.DeepEqual(t, f.MustGetNote(n1.UUID), n1, "n1 mismatch")
+	assert.DeepEqual(t, f.MustGetNote(n2.UUID), n2, "n2 mismatch")
+	assert.DeepEqual(t, f.MustGetNote(n6.UUID), n6, "n6 mismatch")
+
+	// the expunged notes that were already removed are not journaled again
+	assert.DeepEqual(t, mustGetActions(t, db), []database.Action{
+		{Timestamp: ctx.Clock.Now().UnixNano(), Actor: database.ActorSyncClean, Operation: database.OpRemoveNote, NoteUUID: n10.UUID, BookUUID: b1.UUID, Summary: "not found on the server"},
+	}, "actions mismatch")
+}
+
+func TestCleanLocalBooks(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, "../tmp/.dnote", nil)
+	defer database.TeardownTestDB(t, db)
+
+	ctx := context.DnoteCtx{DB: db, Clock: clock.NewMock()}
+
+	list := syncList{
+		Notes: map[string]client.SyncFragNote{
+			"n1-uuid": {
+				UUID: "n1-uuid",
+			},
+			"n2-uuid": {
+				UUID: "n2-uuid",
+			},
+		},
+		Books: map[string]client.SyncFragBook{
+			"b1-uuid": {
+				UUID: "b1-uuid",
+			},
+			"b2-uuid": {
+				UUID: "b2-uuid",
+			},
+		},
+		ExpungedNotes: map[string]struct{}{
+			"n3-uuid": {},
+			"n4-uuid": {},
+		},
+		ExpungedBooks: map[string]struct{}{
+			"b3-uuid": {},
+			"b4-uuid": {},
+		},
+		Cursor:         Cursor("1"),
+		MaxCurrentTime: 2,
+	}
+
+	f := testutils.NewFixture(t, db)
+
+	// existent in the server
+	b1 := f.Book("b1-label", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+	b3 := f.Book("b3-label", testutils.With.UUID("b3-uuid"), testutils.With.Dirty())
+	// non-existent in the server but in valid state
+	b5 := f.Book("b5-label", testutils.With.Deleted(), testutils.With.Dirty())
+	// non-existent in the server and in an invalid state
+	f.Book("b6-label", testutils.With.USN(10), testutils.With.Deleted(), testutils.With.Dirty())
+	b7 := f.Book("b7-label", testutils.With.USN(11))
+	b8 := f.Book("b8-label")
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	if err := cleanLocalBooks(ctx, tx, &list); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Commit()
+
+	// test
+	assert.Equal(t, f.MustCountBooks(), 3, "book count mismatch")
+
+	assert.DeepEqual(t, f.MustGetBook(b1.UUID), b1, "b1 mismatch")
+	assert.DeepEqual(t, f.MustGetBook(b3.UUID), b3, "b3 mismatch")
+	assert.DeepEqual(t, f.MustGetBook(b5.UUID), b5, "b5 mismatch")
+
+	// the expunged books that were already removed are not journaled again
+	actions := mustGetActions(t, db)
+	got := map[string]bool{}
+	for _, a := range actions {
+		assert.Equal(t, a.Actor, database.ActorSyncClean, "actor mismatch")
+		assert.Equal(t, a.Operation, database.OpRemoveBook, "operation mismatch")
+		got[a.BookUUID] = true
+	}
+	assert.Equal(t, len(actions), 2, "action count mismatch")
+	assert.DeepEqual(t, got, map[string]bool{b7.UUID: true, b8.UUID: true}, "journaled books mismatch")
+}
+
+// TestSyncJournal tests that the changes applied from the server are journaled
+func TestSyncJournal(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, "../tmp/.dnote", nil)
+	defer database.TeardownTestDB(t, db)
+	ctx := context.DnoteCtx{DB: db}
+
+	f := testutils.NewFixture(t, db)
+	b1 := f.Book("b1-label", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+	f.Book("b2-label", testutils.With.UUID("b2-uuid"), testutils.With.USN(2))
+	b3 := f.Book("b3-label", testutils.With.UUID("b3-uuid"), testutils.With.USN(3))
+	f.Note(b1, "n1 body", testutils.With.UUID("n1-uuid"), testutils.With.USN(4))
+	f.Note(b1, "n2 body", testutils.With.UUID("n2-uuid"), testutils.With.USN(5))
+	f.Note(b1, "n3 body", testutils.With.UUID("n3-uuid"), testutils.With.USN(6))
+	f.Note(b1, "n4 body", testutils.With.UUID("n4-uuid"), testutils.With.USN(7))
+	f.Note(b3, "n5 body", testutils.With.UUID("n5-uuid"), testutils.With.USN(8))
+	// a note that was already removed locally
+	f.Note(b3, "", testutils.With.UUID("n7-uuid"), testutils.With.USN(9), testutils.With.Deleted())
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+	stmts := mustPrepareStmts(t, tx)
+
+	books := []client.SyncFragBook{
+		{UUID: "b4-uuid", USN: 10, Label: "b4-label"},
+		{UUID: "b2-uuid", USN: 11, Label: "b2-renamed"},
+	}
+	for _, b := range books {
+		if err := mergeBookFromServer(tx, stmts, b); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrapf(err, "merging book %s", b.UUID).Error())
+		}
+	}
+
+	notes := []client.SyncFragNote{
+		{UUID: "n6-uuid", BookUUID: "b4-uuid", USN: 12, AddedOn: 1541108743, Body: "n6 body"},
+		{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 13, AddedOn: 1541108743, Body: "n1 body edited"},
+		{UUID: "n2-uuid", BookUUID: "b2-uuid", USN: 14, AddedOn: 1541108743, Body: "n2 body"},
+		{UUID: "n3-uuid", BookUUID: "b1-uuid", USN: 15, AddedOn: 1541108743, Deleted: true},
+	}
+	for _, n := range notes {
+		if err := stepSyncNote(tx, stmts, n); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrapf(err, "syncing note %s", n.UUID).Error())
+		}
+	}
+
+	if err := syncDeleteNote(context.DnoteCtx{}, tx, stmts, "n4-uuid", nil); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "deleting a note").Error())
+	}
+	if err := syncDeleteBook(ctx, tx, stmts, "b3-uuid"); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "deleting a book").Error())
+	}
+
+	tx.Commit()
+
+	// test
+	action := func(op, noteUUID, bookUUID, summary string) database.Action {
+		return database.Action{Timestamp: testSyncTime, Actor: database.ActorSyncDownload, Operation: op, NoteUUID: noteUUID, BookUUID: bookUUID, Summary: summary}
+	}
+
+	assert.DeepEqual(t, mustGetActions(t, db), []database.Action{
+		action(database.OpAddBook, "", "b4-uuid", `label: "" -> "b4-label"`),
+		action(database.OpRenameBook, "", "b2-uuid", `label: "b2-label" -> "b2-renamed"`),
+		action(database.OpAddNote, "n6-uuid", "b4-uuid", "body: 0 -> 7 bytes"),
+		action(database.OpEditNote, "n1-uuid", "b1-uuid", "body: 7 -> 14 bytes"),
+		action(database.OpMoveNote, "n2-uuid", "b2-uuid", `book_uuid: "b1-uuid" -> "b2-uuid"`),
+		action(database.OpRemoveNote, "n3-uuid", "b1-uuid", "body: 7 -> 0 bytes"),
+		action(database.OpRemoveNote, "n4-uuid", "b1-uuid", "body: 7 -> 0 bytes"),
+		action(database.OpRemoveNote, "n5-uuid", "b3-uuid", "removed with the book"),
+		action(database.OpRemoveBook, "", "b3-uuid", ""),
+	}, "actions mismatch")
+}
+
+// TestSync_logLevels tests that a full sync followed by sending changes prints output
+// according to the level of the logger.
+func TestSync_logLevels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/sync/fragment" && r.Method == "GET" {
+			resp := client.GetSyncFragmentResp{
+				Fragment: client.SyncFragment{
+					CurrentTime: 1541108743,
+					Books: []client.SyncFragBook{
+						{UUID: "b1-uuid", USN: 1, Label: "b1-label"},
+					},
+					Notes: []client.SyncFragNote{
+						{UUID: "n2-uuid", BookUUID: "b1-uuid", USN: 2, Body: "n2 body", AddedOn: 1541108743},
+					},
+				},
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if r.URL.Path == "/v3/notes" && r.Method == "POST" {
+			resp := client.CreateNoteResp{
+				Result: client.RespNote{
+					UUID: "n1-server-uuid",
+					USN:  3,
+				},
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// the server does not limit the account
+		if r.URL.Path == "/v3/limits" && r.Method == "GET" {
+			http.NotFound(w, r)
+			return
+		}
+
+		t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	run := func(level log.Level) (string, string) {
+		ctx := context.InitTestCtx(t, paths, nil)
+		defer context.TeardownTestCtx(t, ctx)
+		testutils.Login(t, &ctx)
+		ctx.APIEndpoint = ts.URL
+
+		var out, errOut bytes.Buffer
+		ctx.Logger = log.NewLogger(&out, &errOut)
+		ctx.Logger.SetLevel(level)
+
+		db := ctx.DB
+		database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+		database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+		database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false)
+		database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 0, "n1 body", 1541108743, true)
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+		}
+		if err := fullSync(ctx, NewHTTPTransport(ctx), tx, true, nil, newPhaseTimer(time.Now), nil); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "performing a full sync").Error())
+		}
+		if _, err := sendChanges(ctx, NewHTTPTransport(ctx), tx, nil); err != nil {
+			tx.Rollback()
+			t.Fatalf(errors.Wrap(err, "sending changes").Error())
+		}
+		tx.Commit()
+
+		return out.String(), errOut.String()
+	}
+
+	t.Run("quiet", func(t *testing.T) {
+		out, errOut := run(log.LevelQuiet)
+
+		assert.Equal(t, out, "", "output mismatch")
+		assert.Equal(t, errOut, "", "error output mismatch")
+	})
+
+	t.Run("normal", func(t *testing.T) {
+		out, errOut := run(log.LevelNormal)
+
+		assert.Equal(t, strings.Contains(out, "resolving delta. (total 2). done.\n"), true, fmt.Sprintf("delta progress missing in %q", out))
+		assert.Equal(t, strings.Contains(out, "sending changes. (total 1). done.\n"), true, fmt.Sprintf("send progress missing in %q", out))
+		assert.Equal(t, errOut, "", "error output mismatch")
+	})
+
+	t.Run("verbose", func(t *testing.T) {
+		out, errOut := run(log.LevelVerbose)
+
+		assert.Equal(t, strings.Contains(out, "resolving delta. (total 2). done.\n"), true, fmt.Sprintf("delta progress missing in %q", out))
+		assert.Equal(t, strings.Contains(errOut, "note n2-uuid: inserting (not found locally)"), true, fmt.Sprintf("merge decision missing in %q", errOut))
+		assert.Equal(t, strings.Contains(errOut, "note n1-uuid: creating on the server"), true, fmt.Sprintf("send decision missing in %q", errOut))
+		assert.Equal(t, strings.Contains(out, "VERBOSE"), false, "verbose messages should not be written to the output")
+	})
+}
+
+func TestSync_logFile(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/sync/fragment" && r.Method == "GET" {
+			resp := client.GetSyncFragmentResp{
+				Fragment: client.SyncFragment{
+					CurrentTime: 1541108743,
+					Books: []client.SyncFragBook{
+						{UUID: "b1-uuid", USN: 1, Label: "b1-label"},
+					},
+					Notes: []client.SyncFragNote{
+						{UUID: "n2-uuid", BookUUID: "b1-uuid", USN: 2, Body: "n2 body", AddedOn: 1541108743},
+					},
+				},
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if r.URL.Path == "/v3/notes" && r.Method == "POST" {
+			resp := client.CreateNoteResp{
+				Result: client.RespNote{
+					UUID: "n1-server-uuid",
+					USN:  3,
+				},
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// the server does not limit the account
+		if r.URL.Path == "/v3/limits" && r.Method == "GET" {
+			http.NotFound(w, r)
+			return
+		}
+
+		t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = ts.URL
+
+	logPath := filepath.Join(t.TempDir(), "dnote.log")
+	ctx.FileLogger = log.NewFileLogger(logPath, log.FileLevelDebug)
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b1-uuid", "b1-label", 1, false)
+	database.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b2-uuid", "b2-label", 4, false)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 0, "n1 body", 1541108743, true)
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n3-uuid", "b1-uuid", 5, "n3 body", 1541108743, false)
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+	if err := fullSync(ctx, NewHTTPTransport(ctx), tx, true, nil, newPhaseTimer(time.Now), nil); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "performing a full sync").Error())
+	}
+	if _, err := sendChanges(ctx, NewHTTPTransport(ctx), tx, nil); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "sending changes").Error())
+	}
+	tx.Commit()
+
+	if err := ctx.FileLogger.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the log file"))
+	}
+
+	// test
+	b, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the log file"))
+	}
+
+	entries := map[string][]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var entry map[string]interface{}
+		testutils.MustUnmarshalJSON(t, []byte(line), &entry)
+
+		event := entry["event"].(string)
+		entries[event] = append(entries[event], entry)
+	}
+
+	assert.Equal(t, len(entries["fragment"]), 1, "fragment entry count mismatch")
+	assert.Equal(t, entries["fragment"][0]["notes"], float64(1), "fragment notes mismatch")
+	assert.Equal(t, entries["fragment"][0]["books"], float64(1), "fragment books mismatch")
+	assert.Equal(t, len(entries["fragment_items"]), 1, "fragment_items entry count mismatch")
+
+	expunged := map[string]string{}
+	for _, entry := range entries["expunge"] {
+		expunged[entry["uuid"].(string)] = entry["kind"].(string)
+	}
+	assert.DeepEqual(t, expunged, map[string]string{"n3-uuid": "note", "b2-uuid": "book"}, "expunge entries mismatch")
+
+	assert.Equal(t, len(entries["uuid_remap"]), 1, "uuid_remap entry count mismatch")
+	assert.Equal(t, entries["uuid_remap"][0]["kind"], "note", "uuid_remap kind mismatch")
+	assert.Equal(t, entries["uuid_remap"][0]["old_uuid"], "n1-uuid", "uuid_remap old_uuid mismatch")
+	assert.Equal(t, entries["uuid_remap"][0]["new_uuid"], "n1-server-uuid", "uuid_remap new_uuid mismatch")
+
+	var calls []string
+	for _, entry := range entries["http"] {
+		calls = append(calls, fmt.Sprintf("%s %s %v", entry["method"], entry["path"], entry["status"]))
+	}
+	assert.DeepEqual(t, calls, []string{"GET /v3/sync/fragment 200", "GET /v3/limits 404", "POST /v3/notes 200"}, "http entries mismatch")
+}
+
+func TestPerformSync(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	srv.SetSyncState(client.GetSyncStateResp{CurrentTime: 1541108743})
+	srv.SetUSN("server-n1 body-uuid", 1)
+
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b1-uuid", "b1-label", 0, false)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 0, "n1 body", 1541108743, true)
+
+	// execute without a transaction
+	timer := newPhaseTimer(clock.NewStepping(time.Date(2018, time.November, 1, 0, 0, 0, 0, time.UTC), time.Second).Now)
+	if err := performSync(ctx, NewHTTPTransport(ctx), db, false, directionBoth, timer, nil); err != nil {
+		t.Fatal(errors.Wrap(err, "performing sync"))
+	}
+
+	// test
+	var lastSyncAt int64
+	var lastMaxUSN int
+	database.MustScan(t, "getting last sync at", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastSyncAt), &lastSyncAt)
+	database.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	assert.Equal(t, lastSyncAt, int64(1541108743), "last sync at mismatch")
+	assert.Equal(t, lastMaxUSN, 1, "last max usn mismatch")
+
+	var n1 database.Note
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT uuid, usn, dirty FROM notes"), &n1.UUID, &n1.USN, &n1.Dirty)
+	assert.Equal(t, n1.UUID, "server-n1 body-uuid", "n1 uuid mismatch")
+	assert.Equal(t, n1.USN, 1, "n1 usn mismatch")
+	assert.Equal(t, n1.Dirty, false, "n1 dirty mismatch")
+	assert.Equal(t, timer.durations[phaseUpload], time.Second, "upload duration mismatch")
+}
+
+func TestPerformSync_versions(t *testing.T) {
+	testCases := []struct {
+		name            string
+		version         string
+		expectedWarning bool
+	}{
+		{name: "unsupported", version: "0.9.3", expectedWarning: true},
+		{name: "supported", version: "0.10.1", expectedWarning: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := testutils.NewMockServer(t)
+			defer srv.Close()
+			srv.SetSyncState(client.GetSyncStateResp{CurrentTime: 1541108743, MinCLIVersion: "0.10.0", LatestCLIVersion: "0.12.0"})
+
+			ctx := context.InitTestCtx(t, paths, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			testutils.Login(t, &ctx)
+			ctx.APIEndpoint = srv.URL()
+			ctx.Version = tc.version
+
+			var out, errOut bytes.Buffer
+			ctx.Logger = log.NewLogger(&out, &errOut)
+
+			db := ctx.DB
+			database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+			database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+
+			if err := performSync(ctx, NewHTTPTransport(ctx), db, false, directionBoth, newPhaseTimer(time.Now), nil); err != nil {
+				t.Fatal(errors.Wrap(err, "performing sync"))
+			}
+
+			assert.Equal(t, strings.Contains(out.String(), "is no longer supported by the server, which requires 0.10.0 or later"), tc.expectedWarning, fmt.Sprintf("warning mismatch in %q", out.String()))
+
+			var latest string
+			database.MustScan(t, "getting the latest version", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLatestVersion), &latest)
+			assert.Equal(t, latest, "0.12.0", "latest version mismatch")
+		})
+	}
+}
+
+func TestPerformSync_bookOverwrittenByServer(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 3, CurrentTime: 1541108743})
+	srv.SetFragment(1, client.SyncFragment{
+		FragMaxUSN:  3,
+		UserMaxUSN:  3,
+		CurrentTime: 1541108743,
+		Books:       []client.SyncFragBook{{UUID: "b1-uuid", USN: 3, Label: "b1-label-server"}},
+	})
+
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 1)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 1541108742)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b1-uuid", "b1-label-client", 1, true)
+
+	// execute
+	if err := infra.WithTx(db, func(tx *database.DB) error {
+		return performSync(ctx, NewHTTPTransport(ctx), tx, false, directionBoth, newPhaseTimer(time.Now), nil)
+	}); err != nil {
+		t.Fatal(errors.Wrap(err, "performing sync"))
+	}
+
+	// test
+	var label string
+	var dirty bool
+	database.MustScan(t, "getting b1", db.QueryRow("SELECT label, dirty FROM books WHERE uuid = ?", "b1-uuid"), &label, &dirty)
+	assert.Equal(t, label, "b1-label-server", "label mismatch")
+	assert.Equal(t, dirty, false, "dirty mismatch")
+
+	// the stale local copy must not be uploaded
+	assert.DeepEqual(t, srv.UpdatedBookUUIDs(), []string(nil), "updated book uuids mismatch")
+}
+
+// TestPerformSync_bookRenamedByServer tests that a full sync applies a rename
+// made on another device to a clean local book as a label update, without
+// renaming it for a duplicate or expunging it
+func TestPerformSync_bookRenamedByServer(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	srv.SetSyncState(client.GetSyncStateResp{FullSyncBefore: 1541108743, MaxUSN: 4, CurrentTime: 1541108743})
+	srv.SetFragment(0, client.SyncFragment{
+		FragMaxUSN:  4,
+		UserMaxUSN:  4,
+		CurrentTime: 1541108743,
+		Books:       []client.SyncFragBook{{UUID: "b1-uuid", USN: 3, Label: "b1-label-new"}},
+		Notes:       []client.SyncFragNote{{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 4, AddedOn: 1541108742, Body: "n1 body"}},
+	})
+
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 2)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 1541108742)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b1-uuid", "b1-label-old", 1, false)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, usn, added_on, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", "n1 body", 2, 1541108742, false)
+
+	// execute
+	if err := infra.WithTx(db, func(tx *database.DB) error {
+		return performSync(ctx, NewHTTPTransport(ctx), tx, false, directionBoth, newPhaseTimer(time.Now), nil)
+	}); err != nil {
+		t.Fatal(errors.Wrap(err, "performing sync"))
+	}
+
+	// test
+	var bookCount, noteCount int
+	database.MustScan(t, "counting books", db.QueryRow("SELECT count(*) FROM books"), &bookCount)
+	database.MustScan(t, "counting notes", db.QueryRow("SELECT count(*) FROM notes"), &noteCount)
+	assert.Equal(t, bookCount, 1, "book count mismatch")
+	assert.Equal(t, noteCount, 1, "note count mismatch")
+
+	var label string
+	var usn int
+	var dirty, deleted bool
+	database.MustScan(t, "getting b1", db.QueryRow("SELECT label, usn, dirty, deleted FROM books WHERE uuid = ?", "b1-uuid"), &label, &usn, &dirty, &deleted)
+	assert.Equal(t, label, "b1-label-new", "label mismatch")
+	assert.Equal(t, usn, 3, "usn mismatch")
+	assert.Equal(t, dirty, false, "dirty mismatch")
+	assert.Equal(t, deleted, false, "deleted mismatch")
+
+	var removed int
+	database.MustScan(t, "counting removals", db.QueryRow("SELECT count(*) FROM actions WHERE operation = ?", database.OpRemoveBook), &removed)
+	assert.Equal(t, removed, 0, "removed book count mismatch")
+	assert.DeepEqual(t, srv.UpdatedBookUUIDs(), []string(nil), "updated book uuids mismatch")
+}
+
+func TestPerformSync_serverError(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	srv.SetSyncState(client.GetSyncStateResp{CurrentTime: 1541108743})
+	srv.Fail("POST", "/v3/notes", http.StatusInternalServerError, 1)
+
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+
+	f := testutils.NewFixture(t, db)
+	b1 := f.Book("b1-label")
+	n1 := f.Note(b1, "n1 body", testutils.With.Dirty())
+
+	// execute
+	err := infra.WithTx(db, func(tx *database.DB) error {
+		return performSync(ctx, NewHTTPTransport(ctx), tx, false, directionBoth, newPhaseTimer(time.Now), nil)
+	})
+
+	// test
+	assert.NotEqual(t, err, nil, "error mismatch")
+	assert.DeepEqual(t, f.MustGetNote(n1.UUID), n1, "n1 mismatch")
+
+	var lastSyncAt int64
+	database.MustScan(t, "getting last sync at", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastSyncAt), &lastSyncAt)
+	assert.Equal(t, lastSyncAt, int64(0), "last sync at mismatch")
+
+	// the state, the limits of the account and the failed creation
+	requests := srv.Requests()
+	assert.Equal(t, len(requests), 3, "request count mismatch")
+	assert.Equal(t, requests[2].Status, http.StatusInternalServerError, "status mismatch")
+	assert.DeepEqual(t, srv.CreatedNoteBodies(), []string(nil), "created note bodies mismatch")
+}
+
+// TestRun_environment tests that the sync reaches the endpoint with the
+// session key set in the environment, instead of those in the config and the
+// credentials store
+func TestRun_environment(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	srv.SetSyncState(client.GetSyncStateResp{CurrentTime: 1541108743})
+
+	homeDir := t.TempDir()
+	t.Setenv(consts.HomeEnv, homeDir)
+	t.Setenv(consts.APIEndpointEnv, srv.URL())
+	t.Setenv(consts.APIKeyEnv, "env-session-key")
+
+	// set up
+	ctx, err := infra.Init("http://config.invalid", "test", false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "initializing").Error())
+	}
+	defer ctx.DB.Close()
+	ctx.Logger = log.NewLogger(ioutil.Discard, ioutil.Discard)
+
+	// skip the remote migrations, which are for the data synced before them
+	database.MustExec(t, "inserting remote schema", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemRemoteSchema, len(migrate.RemoteSequence))
+
+	// execute
+	if _, err := Run(*ctx, Options{}); err != nil {
+		t.Fatal(errors.Wrap(err, "syncing").Error())
+	}
+
+	// test
+	assert.Equal(t, ctx.Paths.Data, homeDir, "data path mismatch")
+	assert.Equal(t, ctx.APIEndpoint, srv.URL(), "api endpoint mismatch")
+
+	requests := srv.Requests()
+	assert.NotEqual(t, len(requests), 0, "the server received no request")
+	for _, req := range requests {
+		assert.Equal(t, req.Authorization, "Bearer env-session-key", fmt.Sprintf("authorization mismatch for %s %s", req.Method, req.Path))
+	}
+}
+
+func TestPerformSync_pullOnly(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 2, CurrentTime: 1541108743})
+	srv.SetFragment(0, client.SyncFragment{
+		FragMaxUSN:  2,
+		UserMaxUSN:  2,
+		CurrentTime: 1541108743,
+		Books:       []client.SyncFragBook{{UUID: "b1-uuid", USN: 1, Label: "b1-label"}},
+		Notes:       []client.SyncFragNote{{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 2, AddedOn: 1541108743, Body: "n1 body"}},
+	})
+
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+
+	f := testutils.NewFixture(t, db)
+	b2 := f.Book("b2-label", testutils.With.Dirty())
+	n2 := f.Note(b2, "n2 body", testutils.With.Dirty())
+	// a note edited locally that the server does not know about, which a full
+	// sync would otherwise expunge
+	n3 := f.Note(b2, "n3 body", testutils.With.USN(5), testutils.With.Dirty())
+
+	// execute a full sync
+	if err := infra.WithTx(db, func(tx *database.DB) error {
+		return performSync(ctx, NewHTTPTransport(ctx), tx, true, directionPull, newPhaseTimer(time.Now), nil)
+	}); err != nil {
+		t.Fatal(errors.Wrap(err, "performing sync"))
+	}
+
+	// test
+	for _, req := range srv.Requests() {
+		assert.Equal(t, req.Method, "GET", fmt.Sprintf("method mismatch for %s", req.Path))
+	}
+
+	var lastMaxUSN int
+	database.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	assert.Equal(t, lastMaxUSN, 2, "last max usn mismatch")
+
+	var n1Body string
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT body FROM notes WHERE uuid = ?", "n1-uuid"), &n1Body)
+	assert.Equal(t, n1Body, "n1 body", "n1 body mismatch")
+
+	assert.DeepEqual(t, f.MustGetNote(n2.UUID), n2, "n2 mismatch")
+	assert.DeepEqual(t, f.MustGetNote(n3.UUID), n3, "n3 mismatch")
+}
+
+func TestPerformSync_pushOnly(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 3, CurrentTime: 1541108743})
+	srv.SetUSN("server-n1 body-uuid", 1)
+
+	// set up
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+
+	db := ctx.DB
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", "b1-uuid", "b1-label", 0, false)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, dirty) VALUES (?, ?, ?, ?, ?, ?)", "n1-uuid", "b1-uuid", 0, "n1 body", 1541108743, true)
+
+	// execute
+	if err := infra.WithTx(db, func(tx *database.DB) error {
+		return performSync(ctx, NewHTTPTransport(ctx), tx, false, directionPush, newPhaseTimer(time.Now), nil)
+	}); err != nil {
+		t.Fatal(errors.Wrap(err, "performing sync"))
+	}
+
+	// test
+	// only the limits of the account are got before sending the new note
+	for _, req := range srv.Requests() {
+		if req.Path == "/v3/limits" {
+			continue
+		}
+		assert.NotEqual(t, req.Method, "GET", fmt.Sprintf("method mismatch for %s", req.Path))
+	}
+	assert.DeepEqual(t, srv.CreatedNoteBodies(), []string{"n1 body"}, "created note bodies mismatch")
+
+	var lastMaxUSN int
+	var lastSyncAt int64
+	database.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	database.MustScan(t, "getting last sync at", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastSyncAt), &lastSyncAt)
+	assert.Equal(t, lastMaxUSN, 0, "last max usn mismatch")
+	assert.Equal(t, lastSyncAt, int64(0), "last sync at mismatch")
+
+	var n1 database.Note
+	database.MustScan(t, "getting n1", db.QueryRow("SELECT uuid, usn, dirty FROM notes"), &n1.UUID, &n1.USN, &n1.Dirty)
+	assert.Equal(t, n1.UUID, "server-n1 body-uuid", "n1 uuid mismatch")
+	assert.Equal(t, n1.USN, 1, "n1 usn mismatch")
+	assert.Equal(t, n1.Dirty, false, "n1 dirty mismatch")
+}
+
+func TestOptionsDirection(t *testing.T) {
+	testCases := []struct {
+		opts     Options
+		expected direction
+	}{
+		{opts: Options{}, expected: directionBoth},
+		{opts: Options{PullOnly: true}, expected: directionPull},
+		{opts: Options{PushOnly: true}, expected: directionPush},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v", tc.opts), func(t *testing.T) {
+			got, err := tc.opts.direction()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.Equal(t, got, tc.expected, "direction mismatch")
+		})
+	}
+
+	_, err := Options{PullOnly: true, PushOnly: true}.direction()
+	assert.NotEqual(t, err, nil, "no error for both directions")
+}
+
+func TestRun_notLoggedIn(t *testing.T) {
+	testCases := []struct {
+		localOnly bool
+		expected  error
+	}{
+		{localOnly: false, expected: ErrNotLoggedIn},
+		{localOnly: true, expected: ErrLocalOnly},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("local only %t", tc.localOnly), func(t *testing.T) {
+			ctx := context.InitTestCtx(t, paths, nil)
+			defer context.TeardownTestCtx(t, ctx)
+			ctx.LocalOnly = tc.localOnly
+
+			_, err := Run(ctx, Options{})
+			assert.Equal(t, err, tc.expected, "error mismatch")
+		})
+	}
+}
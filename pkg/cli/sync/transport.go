@@ -0,0 +1,120 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	stdCtx "context"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+)
+
+// Cursor is a position in the history of the changes to the remote copy. It is
+// opaque to the sync engine, which only keeps the cursor of the last change it
+// has seen in the system table and hands it back to the transport. A nil
+// cursor is the position before any change.
+type Cursor []byte
+
+// State is the state of the remote copy
+type State struct {
+	// FullSyncBefore is the time before which a client must get all the notes
+	// and books with a full sync, rather than only the changes since its last
+	// sync
+	FullSyncBefore int
+	// Cursor is the position of the latest change
+	Cursor Cursor
+	// CurrentTime is the time of the remote copy, which is recorded as the
+	// time of the sync
+	CurrentTime int64
+	// MinVersion is the oldest version of dnote that the remote copy
+	// supports, and LatestVersion is the latest release. Either is empty if
+	// it is not advertised.
+	MinVersion    string
+	LatestVersion string
+}
+
+// Changes is a page of the changes after a cursor
+type Changes struct {
+	// Notes and Books are the notes and the books added or changed
+	Notes []client.SyncFragNote
+	Books []client.SyncFragBook
+	// ExpungedNotes and ExpungedBooks are the uuids of the notes and the
+	// books removed
+	ExpungedNotes []string
+	ExpungedBooks []string
+	// Next is the position after the changes in the page, from which the next
+	// page is fetched
+	Next Cursor
+	// More reports whether there may be more changes after Next
+	More        bool
+	CurrentTime int64
+	// Size is the number of bytes downloaded for the page, or 0 if the
+	// transport does not count them
+	Size int64
+}
+
+// Mutation is the result of pushing a change to a note or a book
+type Mutation struct {
+	// UUID is the uuid of the note or the book on the remote copy, which may
+	// differ from the local one for a new note or book
+	UUID string
+	// USN is the version of the note or the book after the change
+	USN int
+	// Cursor is the position of the change
+	Cursor Cursor
+}
+
+// Transport is the means by which the sync engine exchanges the changes with
+// the remote copy. NewHTTPTransport returns the reference implementation,
+// which talks to the dnote server.
+//
+// A transport must keep the following contract, which transporttest.Run
+// verifies:
+//
+//   - Every change to a note or a book is assigned a usn greater than that of
+//     any earlier change to it, and a cursor after those of the earlier changes
+//     to any note or book. A usn is never 0, which marks a note or a book that
+//     was never synced.
+//   - Changes pages through the latest version of every note and book changed
+//     after the cursor, in the order of the changes, until More is false.
+//     Changes with a nil cursor returns the full state. A removed note or book
+//     is reported in ExpungedNotes or ExpungedBooks.
+//   - Once all pages are fetched, the Next cursor of the last page equals the
+//     Cursor of State, provided that nothing changed in between.
+//   - Follows reports whether a mutation is the only change after the cursor,
+//     in which case the engine moves its cursor to that of the mutation without
+//     fetching the change back.
+//   - A book label is unique among the books that are not removed.
+//
+// The methods are called with a context that is canceled when the sync is
+// aborted.
+type Transport interface {
+	// State returns the current state of the remote copy
+	State(ctx stdCtx.Context) (State, error)
+	// Changes returns the page of the changes after the cursor
+	Changes(ctx stdCtx.Context, after Cursor) (Changes, error)
+	// Follows reports whether the mutation is the change right after the cursor
+	Follows(after Cursor, m Mutation) bool
+
+	CreateBook(ctx stdCtx.Context, label string) (Mutation, error)
+	UpdateBook(ctx stdCtx.Context, uuid, label string) (Mutation, error)
+	DeleteBook(ctx stdCtx.Context, uuid string) (Mutation, error)
+	CreateNote(ctx stdCtx.Context, bookUUID, body string) (Mutation, error)
+	UpdateNote(ctx stdCtx.Context, uuid, bookUUID, body string, public bool) (Mutation, error)
+	DeleteNote(ctx stdCtx.Context, uuid string) (Mutation, error)
+}
@@ -0,0 +1,290 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package transporttest provides a suite of tests that verifies a sync
+// transport keeps the contract of sync.Transport.
+package transporttest
+
+import (
+	"bytes"
+	stdCtx "context"
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/sync"
+	"github.com/pkg/errors"
+)
+
+// maxPages is the number of pages after which the changes are deemed to
+// never end
+const maxPages = 1000
+
+// snapshot is the result of fetching all the changes after a cursor
+type snapshot struct {
+	notes         map[string]client.SyncFragNote
+	books         map[string]client.SyncFragBook
+	expungedNotes map[string]bool
+	expungedBooks map[string]bool
+	cursor        sync.Cursor
+}
+
+// fetch fetches all the pages of the changes after the cursor
+func fetch(t *testing.T, tr sync.Transport, after sync.Cursor) snapshot {
+	ret := snapshot{
+		notes:         map[string]client.SyncFragNote{},
+		books:         map[string]client.SyncFragBook{},
+		expungedNotes: map[string]bool{},
+		expungedBooks: map[string]bool{},
+		cursor:        after,
+	}
+
+	for i := 0; i < maxPages; i++ {
+		ch, err := tr.Changes(stdCtx.Background(), ret.cursor)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "fetching the changes"))
+		}
+
+		for _, n := range ch.Notes {
+			ret.notes[n.UUID] = n
+			delete(ret.expungedNotes, n.UUID)
+		}
+		for _, b := range ch.Books {
+			ret.books[b.UUID] = b
+			delete(ret.expungedBooks, b.UUID)
+		}
+		for _, uuid := range ch.ExpungedNotes {
+			ret.expungedNotes[uuid] = true
+			delete(ret.notes, uuid)
+		}
+		for _, uuid := range ch.ExpungedBooks {
+			ret.expungedBooks[uuid] = true
+			delete(ret.books, uuid)
+		}
+		if ch.Next != nil {
+			ret.cursor = ch.Next
+		}
+
+		if !ch.More {
+			return ret
+		}
+	}
+
+	t.Fatalf("the changes did not end after %d pages", maxPages)
+	return ret
+}
+
+func mustState(t *testing.T, tr sync.Transport) sync.State {
+	s, err := tr.State(stdCtx.Background())
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the state"))
+	}
+
+	return s
+}
+
+func mustCreateBook(t *testing.T, tr sync.Transport, label string) sync.Mutation {
+	m, err := tr.CreateBook(stdCtx.Background(), label)
+	if err != nil {
+		t.Fatal(errors.Wrapf(err, "creating the book %s", label))
+	}
+
+	return m
+}
+
+func mustCreateNote(t *testing.T, tr sync.Transport, bookUUID, body string) sync.Mutation {
+	m, err := tr.CreateNote(stdCtx.Background(), bookUUID, body)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating a note"))
+	}
+
+	return m
+}
+
+// checkMutation checks that the mutation has a uuid and a usn greater than
+// the given one
+func checkMutation(t *testing.T, m sync.Mutation, prevUSN int) {
+	if m.UUID == "" {
+		t.Error("the mutation has no uuid")
+	}
+	if m.USN <= prevUSN {
+		t.Errorf("usn %d is not greater than %d", m.USN, prevUSN)
+	}
+}
+
+// checkCursor checks that the changes ended at the cursor of the state
+func checkCursor(t *testing.T, tr sync.Transport, s snapshot) {
+	state := mustState(t, tr)
+	if !bytes.Equal(s.cursor, state.Cursor) {
+		t.Errorf("the changes ended at '%s' but the state is at '%s'", s.cursor, state.Cursor)
+	}
+}
+
+// Run runs the contract tests against the transports returned by
+// newTransport. Every call must return a transport to a new, empty remote
+// copy.
+func Run(t *testing.T, newTransport func(t *testing.T) sync.Transport) {
+	t.Run("empty", func(t *testing.T) {
+		tr := newTransport(t)
+
+		s := fetch(t, tr, nil)
+		assert.Equal(t, len(s.notes), 0, "notes count mismatch")
+		assert.Equal(t, len(s.books), 0, "books count mismatch")
+		checkCursor(t, tr, s)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		tr := newTransport(t)
+
+		b := mustCreateBook(t, tr, "js")
+		checkMutation(t, b, 0)
+		n := mustCreateNote(t, tr, b.UUID, "n1 body")
+		checkMutation(t, n, b.USN)
+
+		s := fetch(t, tr, nil)
+		assert.Equal(t, len(s.books), 1, "books count mismatch")
+		assert.Equal(t, s.books[b.UUID].Label, "js", "book label mismatch")
+		assert.Equal(t, s.books[b.UUID].USN, b.USN, "book usn mismatch")
+		assert.Equal(t, len(s.notes), 1, "notes count mismatch")
+		assert.Equal(t, s.notes[n.UUID].Body, "n1 body", "note body mismatch")
+		assert.Equal(t, s.notes[n.UUID].BookUUID, b.UUID, "note book_uuid mismatch")
+		assert.Equal(t, s.notes[n.UUID].USN, n.USN, "note usn mismatch")
+		checkCursor(t, tr, s)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		tr := newTransport(t)
+
+		b1 := mustCreateBook(t, tr, "js")
+		b2 := mustCreateBook(t, tr, "css")
+		n := mustCreateNote(t, tr, b1.UUID, "n1 body")
+		before := mustState(t, tr).Cursor
+
+		bu, err := tr.UpdateBook(stdCtx.Background(), b2.UUID, "go")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "updating the book"))
+		}
+		checkMutation(t, bu, n.USN)
+		assert.Equal(t, bu.UUID, b2.UUID, "book uuid mismatch")
+
+		nu, err := tr.UpdateNote(stdCtx.Background(), n.UUID, b2.UUID, "n1 body edited", false)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "updating the note"))
+		}
+		checkMutation(t, nu, bu.USN)
+		assert.Equal(t, nu.UUID, n.UUID, "note uuid mismatch")
+
+		// only the changed note and book are after the cursor
+		s := fetch(t, tr, before)
+		assert.Equal(t, len(s.books), 1, "books count mismatch")
+		assert.Equal(t, s.books[b2.UUID].Label, "go", "book label mismatch")
+		assert.Equal(t, s.books[b2.UUID].USN, bu.USN, "book usn mismatch")
+		assert.Equal(t, len(s.notes), 1, "notes count mismatch")
+		assert.Equal(t, s.notes[n.UUID].Body, "n1 body edited", "note body mismatch")
+		assert.Equal(t, s.notes[n.UUID].BookUUID, b2.UUID, "note book_uuid mismatch")
+		assert.Equal(t, s.notes[n.UUID].USN, nu.USN, "note usn mismatch")
+		checkCursor(t, tr, s)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		tr := newTransport(t)
+
+		b1 := mustCreateBook(t, tr, "js")
+		b2 := mustCreateBook(t, tr, "css")
+		n1 := mustCreateNote(t, tr, b1.UUID, "n1 body")
+		n2 := mustCreateNote(t, tr, b2.UUID, "n2 body")
+		before := mustState(t, tr).Cursor
+
+		nd, err := tr.DeleteNote(stdCtx.Background(), n1.UUID)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "deleting the note"))
+		}
+		checkMutation(t, nd, n2.USN)
+		bd, err := tr.DeleteBook(stdCtx.Background(), b2.UUID)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "deleting the book"))
+		}
+		checkMutation(t, bd, nd.USN)
+
+		s := fetch(t, tr, before)
+		assert.Equal(t, s.expungedNotes[n1.UUID], true, "n1 expunged mismatch")
+		assert.Equal(t, s.expungedBooks[b2.UUID], true, "b2 expunged mismatch")
+		checkCursor(t, tr, s)
+
+		s = fetch(t, tr, nil)
+		assert.Equal(t, len(s.books), 1, "books count mismatch")
+		if _, ok := s.books[b1.UUID]; !ok {
+			t.Error("b1 is missing from the full state")
+		}
+		if _, ok := s.notes[n1.UUID]; ok {
+			t.Error("the removed note is in the full state")
+		}
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		tr := newTransport(t)
+
+		b := mustCreateBook(t, tr, "js")
+		var uuids []string
+		for i := 0; i < 25; i++ {
+			n := mustCreateNote(t, tr, b.UUID, fmt.Sprintf("n%d body", i))
+			uuids = append(uuids, n.UUID)
+		}
+
+		s := fetch(t, tr, nil)
+		assert.Equal(t, len(s.notes), len(uuids), "notes count mismatch")
+		for i, uuid := range uuids {
+			assert.Equal(t, s.notes[uuid].Body, fmt.Sprintf("n%d body", i), "note body mismatch")
+		}
+		checkCursor(t, tr, s)
+
+		// nothing is after the last cursor
+		s = fetch(t, tr, s.cursor)
+		assert.Equal(t, len(s.notes)+len(s.books), 0, "changes count mismatch")
+	})
+
+	t.Run("follows", func(t *testing.T) {
+		tr := newTransport(t)
+
+		before := mustState(t, tr).Cursor
+		b := mustCreateBook(t, tr, "js")
+		assert.Equal(t, tr.Follows(before, b), true, "the only change does not follow")
+		assert.Equal(t, bytes.Equal(mustState(t, tr).Cursor, b.Cursor), true, "mutation cursor mismatch")
+
+		before = b.Cursor
+		mustCreateNote(t, tr, b.UUID, "n1 body")
+		n := mustCreateNote(t, tr, b.UUID, "n2 body")
+		assert.Equal(t, tr.Follows(before, n), false, "a change follows past another one")
+	})
+
+	t.Run("duplicate label", func(t *testing.T) {
+		tr := newTransport(t)
+
+		b := mustCreateBook(t, tr, "js")
+		if _, err := tr.CreateBook(stdCtx.Background(), "js"); err == nil {
+			t.Error("created a book with a duplicate label")
+		}
+
+		// the label of a removed book can be reused
+		if _, err := tr.DeleteBook(stdCtx.Background(), b.UUID); err != nil {
+			t.Fatal(errors.Wrap(err, "deleting the book"))
+		}
+		mustCreateBook(t, tr, "js")
+	})
+}
@@ -0,0 +1,184 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	stdCtx "context"
+	"sort"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// bookGetter is implemented by the transports that can get a single book from
+// the remote copy
+type bookGetter interface {
+	// book returns the book with the given uuid, and whether it exists
+	book(ctx stdCtx.Context, uuid string) (client.SyncFragBook, bool, error)
+}
+
+// invalidRecord is a note or a book from the remote copy that is not applied
+// because it is not valid
+type invalidRecord struct {
+	// Kind is "note" or "book"
+	Kind   string
+	UUID   string
+	Reason string
+}
+
+// maxUUIDLen is the length of the longest uuid accepted from the remote copy
+const maxUUIDLen = 64
+
+// isUUID reports whether s is a well formed uuid of a note or a book on the
+// remote copy. The dnote server uses RFC 4122 uuids, but the transports are
+// free to use any token of letters, digits, hyphens and underscores.
+func isUUID(s string) bool {
+	if s == "" || len(s) > maxUUIDLen {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateList removes from the list the notes and the books that cannot be
+// applied, and returns them. A note must be in a book that is in the list, in
+// the local copy, or on the remote copy, from which it is got and added to the
+// list. The notes removed are marked as skipped so that their local copies
+// are kept.
+func validateList(ctx context.DnoteCtx, tr Transport, tx *database.DB, list *syncList) ([]invalidRecord, error) {
+	var ret []invalidRecord
+
+	for key := range list.Books {
+		if !isUUID(key) {
+			ret = append(ret, invalidRecord{Kind: "book", UUID: key, Reason: "invalid uuid"})
+			delete(list.Books, key)
+		}
+	}
+	for key := range list.ExpungedBooks {
+		if !isUUID(key) {
+			ret = append(ret, invalidRecord{Kind: "book", UUID: key, Reason: "invalid uuid"})
+			delete(list.ExpungedBooks, key)
+		}
+	}
+	for key := range list.ExpungedNotes {
+		if !isUUID(key) {
+			ret = append(ret, invalidRecord{Kind: "note", UUID: key, Reason: "invalid uuid"})
+			delete(list.ExpungedNotes, key)
+		}
+	}
+
+	resolved := map[string]bool{}
+	for key, n := range list.Notes {
+		var reason string
+		switch {
+		case !isUUID(key):
+			reason = "invalid uuid"
+		case !isUUID(n.BookUUID):
+			reason = "invalid book uuid"
+		default:
+			ok, err := resolveBook(ctx, tr, tx, list, n.BookUUID, resolved)
+			if err != nil {
+				return nil, errors.Wrapf(err, "resolving the book %s", n.BookUUID)
+			}
+			if ok {
+				continue
+			}
+
+			reason = "book not found"
+		}
+
+		ret = append(ret, invalidRecord{Kind: "note", UUID: key, Reason: reason})
+		delete(list.Notes, key)
+		list.SkippedNotes[key] = struct{}{}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Kind != ret[j].Kind {
+			return ret[i].Kind < ret[j].Kind
+		}
+		return ret[i].UUID < ret[j].UUID
+	})
+
+	return ret, nil
+}
+
+// resolveBook reports whether the book with the given uuid is in the list, in
+// the local copy, or on the remote copy, and caches the result in resolved
+func resolveBook(ctx context.DnoteCtx, tr Transport, tx *database.DB, list *syncList, bookUUID string, resolved map[string]bool) (bool, error) {
+	if ok, seen := resolved[bookUUID]; seen {
+		return ok, nil
+	}
+	if _, ok := list.Books[bookUUID]; ok {
+		return true, nil
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM books WHERE uuid = ?", bookUUID).Scan(&count); err != nil {
+		return false, errors.Wrap(err, "finding the local book")
+	}
+	if count > 0 {
+		resolved[bookUUID] = true
+		return true, nil
+	}
+
+	g, ok := tr.(bookGetter)
+	if !ok {
+		resolved[bookUUID] = false
+		return false, nil
+	}
+	b, ok, err := g.book(ctx.Context(), bookUUID)
+	if err != nil {
+		return false, errors.Wrap(err, "getting the book")
+	}
+	if ok && b.UUID == bookUUID {
+		list.Books[bookUUID] = b
+		return true, nil
+	}
+
+	resolved[bookUUID] = false
+	return false, nil
+}
+
+// checkList validates the list and reports the notes and the books that are
+// skipped because they are not valid
+func checkList(ctx context.DnoteCtx, tr Transport, tx *database.DB, list *syncList) error {
+	invalid, err := validateList(ctx, tr, tx, list)
+	if err != nil {
+		return errors.Wrap(err, "validating the sync list")
+	}
+
+	for _, r := range invalid {
+		ctx.Logger.Warnf("%s", i18n.T("sync.invalid_record", r.Kind, r.UUID, r.Reason))
+		ctx.FileLogger.Info("invalid_record", log.Fields{"kind": r.Kind, "uuid": r.UUID, "reason": r.Reason})
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestIsUUID(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected bool
+	}{
+		{input: "6a0d4a0a-9d8b-4d4f-8c55-0ad0e6f7bd8e", expected: true},
+		{input: "b1-uuid", expected: true},
+		{input: "server_b1", expected: true},
+		{input: "", expected: false},
+		{input: "b1 uuid", expected: false},
+		{input: "../b1", expected: false},
+		{input: "b1\n", expected: false},
+		{input: "bé", expected: false},
+		{input: strings.Repeat("a", maxUUIDLen), expected: true},
+		{input: strings.Repeat("a", maxUUIDLen+1), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.input), func(t *testing.T) {
+			assert.Equal(t, isUUID(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestValidateList(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+	srv.SetBook("b3-uuid", client.RespBook{UUID: "b3-uuid", USN: 3, Label: "fetched"})
+
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+	db := ctx.DB
+
+	testutils.NewFixture(t, db).Book("local", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+
+	list := newSyncList()
+	list.add(Changes{
+		Books: []client.SyncFragBook{
+			{UUID: "b2-uuid", USN: 2, Label: "listed"},
+			{UUID: "b 4", USN: 4, Label: "malformed"},
+		},
+		Notes: []client.SyncFragNote{
+			// in a local book, a book in the list, and a book on the server
+			{UUID: "n1-uuid", BookUUID: "b1-uuid"},
+			{UUID: "n2-uuid", BookUUID: "b2-uuid"},
+			{UUID: "n3-uuid", BookUUID: "b3-uuid"},
+			// in a book that is nowhere, which is looked up once
+			{UUID: "n4-uuid", BookUUID: "missing-uuid"},
+			{UUID: "n5-uuid", BookUUID: "missing-uuid"},
+			{UUID: "n6-uuid", BookUUID: ""},
+			{UUID: "", BookUUID: "b1-uuid"},
+			// in the book with a malformed uuid
+			{UUID: "n7-uuid", BookUUID: "b 4"},
+		},
+		ExpungedNotes: []string{"n8-uuid", "n8\x00"},
+		ExpungedBooks: []string{"b5-uuid", ""},
+	})
+
+	invalid, err := validateList(ctx, NewHTTPTransport(ctx), db, &list)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "validating the list"))
+	}
+
+	assert.DeepEqual(t, invalid, []invalidRecord{
+		{Kind: "book", UUID: "", Reason: "invalid uuid"},
+		{Kind: "book", UUID: "b 4", Reason: "invalid uuid"},
+		{Kind: "note", UUID: "", Reason: "invalid uuid"},
+		{Kind: "note", UUID: "n4-uuid", Reason: "book not found"},
+		{Kind: "note", UUID: "n5-uuid", Reason: "book not found"},
+		{Kind: "note", UUID: "n6-uuid", Reason: "invalid book uuid"},
+		{Kind: "note", UUID: "n7-uuid", Reason: "invalid book uuid"},
+		{Kind: "note", UUID: "n8\x00", Reason: "invalid uuid"},
+	}, "invalid records mismatch")
+
+	keys := func(m map[string]struct{}) []string {
+		ret := []string{}
+		for k := range m {
+			ret = append(ret, k)
+		}
+		sort.Strings(ret)
+
+		return ret
+	}
+	var books, notes []string
+	for uuid := range list.Books {
+		books = append(books, uuid)
+	}
+	for uuid := range list.Notes {
+		notes = append(notes, uuid)
+	}
+	sort.Strings(books)
+	sort.Strings(notes)
+
+	assert.DeepEqual(t, books, []string{"b2-uuid", "b3-uuid"}, "books mismatch")
+	assert.Equal(t, list.Books["b3-uuid"].Label, "fetched", "fetched book label mismatch")
+	assert.DeepEqual(t, notes, []string{"n1-uuid", "n2-uuid", "n3-uuid"}, "notes mismatch")
+	assert.DeepEqual(t, keys(list.SkippedNotes), []string{"", "n4-uuid", "n5-uuid", "n6-uuid", "n7-uuid"}, "skipped notes mismatch")
+	assert.DeepEqual(t, keys(list.ExpungedNotes), []string{"n8-uuid"}, "expunged notes mismatch")
+	assert.DeepEqual(t, keys(list.ExpungedBooks), []string{"b5-uuid"}, "expunged books mismatch")
+
+	var lookups []string
+	for _, r := range srv.Requests() {
+		if r.Method == "GET" && strings.HasPrefix(r.Path, "/v3/books/") {
+			lookups = append(lookups, r.Path)
+		}
+	}
+	sort.Strings(lookups)
+	assert.DeepEqual(t, lookups, []string{"/v3/books/b3-uuid", "/v3/books/missing-uuid"}, "book lookups mismatch")
+}
+
+func TestPerformSync_invalidRecords(t *testing.T) {
+	srv := testutils.NewMockServer(t)
+	defer srv.Close()
+
+	srv.SetSyncState(client.GetSyncStateResp{MaxUSN: 4, CurrentTime: 1541108743})
+	srv.SetFragment(0, client.SyncFragment{
+		FragMaxUSN:  4,
+		UserMaxUSN:  4,
+		CurrentTime: 1541108743,
+		Books:       []client.SyncFragBook{{UUID: "b1-uuid", USN: 1, Label: "b1-label"}},
+		Notes: []client.SyncFragNote{
+			{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 2, AddedOn: 1541108743, Body: "n1 body"},
+			{UUID: "n2-uuid", BookUUID: "missing-uuid", USN: 3, AddedOn: 1541108743, Body: "n2 body server"},
+			{UUID: "n3 uuid", BookUUID: "b1-uuid", USN: 4, AddedOn: 1541108743, Body: "n3 body"},
+		},
+	})
+
+	ctx := context.InitTestCtx(t, paths, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+	ctx.APIEndpoint = srv.URL()
+	db := ctx.DB
+
+	// the local copy of a note whose server copy is not valid, which a full
+	// sync must not take for missing on the server
+	f := testutils.NewFixture(t, db)
+	b1 := f.Book("b1-label", testutils.With.UUID("b1-uuid"), testutils.With.USN(1))
+	f.Note(b1, "n2 body", testutils.With.UUID("n2-uuid"), testutils.With.USN(1))
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	database.MustExec(t, "inserting last sync at", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastSyncAt, 0)
+
+	if err := infra.WithTx(db, func(tx *database.DB) error {
+		return performSync(ctx, NewHTTPTransport(ctx), tx, false, directionBoth, newPhaseTimer(time.Now), nil)
+	}); err != nil {
+		t.Fatal(errors.Wrap(err, "performing sync"))
+	}
+
+	assert.DeepEqual(t, getUUIDs(t, db, "notes"), []string{"n1-uuid", "n2-uuid"}, "notes mismatch")
+	assert.Equal(t, f.MustGetNote("n2-uuid").Body, "n2 body", "the local copy of the invalid note should be kept")
+
+	var lastMaxUSN int
+	database.MustScan(t, "getting last max usn", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	assert.Equal(t, lastMaxUSN, 4, "last max usn mismatch")
+}
@@ -0,0 +1,81 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/cli/database"
+)
+
+// fieldDiff returns a line for every exported field of the given structs
+// that differs, skipping the ignored fields. It fails the test if an ignored
+// field does not exist so that a typo cannot silently disable a comparison.
+func fieldDiff(t *testing.T, got, want interface{}, ignoreFields []string) []string {
+	gv := reflect.ValueOf(got)
+	wv := reflect.ValueOf(want)
+	typ := gv.Type()
+
+	ignored := map[string]bool{}
+	for _, name := range ignoreFields {
+		if _, ok := typ.FieldByName(name); !ok {
+			t.Fatalf("%s has no field %s to ignore", typ.Name(), name)
+		}
+		ignored[name] = true
+	}
+
+	var ret []string
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" || ignored[f.Name] {
+			continue
+		}
+
+		g := gv.Field(i).Interface()
+		w := wv.Field(i).Interface()
+		if !reflect.DeepEqual(g, w) {
+			ret = append(ret, fmt.Sprintf("  %s: got %#v, want %#v", f.Name, g, w))
+		}
+	}
+
+	return ret
+}
+
+// AssertNoteEqual errors the test with a field-by-field diff if the notes
+// differ in any field other than the ignored ones, e.g. "RowID"
+func AssertNoteEqual(t *testing.T, got, want database.Note, ignoreFields ...string) {
+	t.Helper()
+
+	if diff := fieldDiff(t, got, want, ignoreFields); len(diff) > 0 {
+		t.Errorf("note %s mismatch:\n%s", want.UUID, strings.Join(diff, "\n"))
+	}
+}
+
+// AssertBookEqual errors the test with a field-by-field diff if the books
+// differ in any field other than the ignored ones
+func AssertBookEqual(t *testing.T, got, want database.Book, ignoreFields ...string) {
+	t.Helper()
+
+	if diff := fieldDiff(t, got, want, ignoreFields); len(diff) > 0 {
+		t.Errorf("book %s mismatch:\n%s", want.UUID, strings.Join(diff, "\n"))
+	}
+}
@@ -0,0 +1,104 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package testutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/color"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// CmdNow is the current time of the commands run by RunCmd. Tests should set
+// the timestamps of their fixtures relative to it.
+var CmdNow = time.Date(2021, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+// CmdResult is the result of a command run by RunCmd
+type CmdResult struct {
+	Stdout []byte
+	Stderr []byte
+	Err    error
+}
+
+// RunCmd runs the command returned by newCmd with the given arguments in process,
+// and captures what it writes to the standard output and the standard error.
+// The command reads the time from a clock frozen at CmdNow in UTC, and prints
+// without colors, so that its output is stable.
+func RunCmd(t *testing.T, ctx context.DnoteCtx, newCmd func(context.DnoteCtx) *cobra.Command, args ...string) CmdResult {
+	dir := t.TempDir()
+
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating the stdout file"))
+	}
+	defer stdout.Close()
+
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating the stderr file"))
+	}
+	defer stderr.Close()
+
+	c := clock.NewMock()
+	c.SetNow(CmdNow)
+	ctx.Clock = c
+
+	logger := log.Default()
+	origOut, origErrOut := logger.Output()
+	origStdout, origStderr := os.Stdout, os.Stderr
+	origNoColor, origLocal := color.NoColor, time.Local
+
+	logger.SetOutput(stdout, stderr)
+	os.Stdout, os.Stderr = stdout, stderr
+	color.NoColor, time.Local = true, time.UTC
+
+	defer func() {
+		logger.SetOutput(origOut, origErrOut)
+		os.Stdout, os.Stderr = origStdout, origStderr
+		color.NoColor, time.Local = origNoColor, origLocal
+	}()
+
+	// the errors are returned without the usage, as under the root command
+	cmd := newCmd(ctx)
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	cmd.SetArgs(args)
+	cmd.SetOut(stdout)
+	cmd.SetErr(stderr)
+
+	var ret CmdResult
+	ret.Err = cmd.Execute()
+
+	if ret.Stdout, err = ioutil.ReadFile(stdout.Name()); err != nil {
+		t.Fatal(errors.Wrap(err, "reading the stdout"))
+	}
+	if ret.Stderr, err = ioutil.ReadFile(stderr.Name()); err != nil {
+		t.Fatal(errors.Wrap(err, "reading the stderr"))
+	}
+
+	return ret
+}
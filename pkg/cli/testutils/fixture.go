@@ -0,0 +1,232 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package testutils
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// fixtureAddedOn is the default added_on of the notes created by a Fixture
+const fixtureAddedOn = 1541108743
+
+// fixtureAttrs holds the attributes of a fixture record that can be
+// overridden by FixtureOptions
+type fixtureAttrs struct {
+	uuid     string
+	usn      int
+	addedOn  int64
+	editedOn int64
+	public   bool
+	deleted  bool
+	dirty    bool
+	// originDevice is the origin_device of a note
+	originDevice string
+	// position is the position of a note
+	position float64
+	// viewCount is the view_count of a note
+	viewCount int
+	// lastViewedAt is the last_viewed_at of a note
+	lastViewedAt int64
+}
+
+// FixtureOption overrides a default attribute of a fixture record
+type FixtureOption func(*fixtureAttrs)
+
+type fixtureOptions struct{}
+
+// With provides the FixtureOptions, e.g. With.USN(3)
+var With fixtureOptions
+
+// UUID sets the uuid instead of generating one
+func (fixtureOptions) UUID(uuid string) FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.uuid = uuid
+	}
+}
+
+// USN sets the usn
+func (fixtureOptions) USN(usn int) FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.usn = usn
+	}
+}
+
+// AddedOn sets the added_on of a note
+func (fixtureOptions) AddedOn(addedOn int64) FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.addedOn = addedOn
+	}
+}
+
+// EditedOn sets the edited_on of a note
+func (fixtureOptions) EditedOn(editedOn int64) FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.editedOn = editedOn
+	}
+}
+
+// Public marks a note as public
+func (fixtureOptions) Public() FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.public = true
+	}
+}
+
+// Deleted marks the record as deleted
+func (fixtureOptions) Deleted() FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.deleted = true
+	}
+}
+
+// Dirty marks the record as dirty
+func (fixtureOptions) Dirty() FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.dirty = true
+	}
+}
+
+// OriginDevice sets the origin_device of a note
+func (fixtureOptions) OriginDevice(device string) FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.originDevice = device
+	}
+}
+
+// Position sets the position of a note
+func (fixtureOptions) Position(position float64) FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.position = position
+	}
+}
+
+// Viewed sets the view_count and the last_viewed_at of a note
+func (fixtureOptions) Viewed(count int, at int64) FixtureOption {
+	return func(a *fixtureAttrs) {
+		a.viewCount = count
+		a.lastViewedAt = at
+	}
+}
+
+// Fixture inserts books and notes into a test database with sensible defaults
+// so that a test only needs to specify the attributes it cares about. Use
+// database.MustExec for the states that the builder cannot express.
+type Fixture struct {
+	t  *testing.T
+	db *database.DB
+}
+
+// NewFixture returns a new Fixture for the given database
+func NewFixture(t *testing.T, db *database.DB) *Fixture {
+	return &Fixture{t: t, db: db}
+}
+
+func (f *Fixture) attrs(opts []FixtureOption) fixtureAttrs {
+	a := fixtureAttrs{
+		addedOn: fixtureAddedOn,
+	}
+	for _, opt := range opts {
+		opt(&a)
+	}
+
+	if a.uuid == "" {
+		a.uuid = MustGenerateUUID(f.t)
+	}
+
+	return a
+}
+
+// Book inserts a book with the given label and returns it
+func (f *Fixture) Book(label string, opts ...FixtureOption) database.Book {
+	a := f.attrs(opts)
+
+	b := database.NewBook(a.uuid, label, a.usn, a.deleted, a.dirty)
+	if err := b.Insert(f.db); err != nil {
+		f.t.Fatal(errors.Wrapf(err, "inserting the fixture book %s", label).Error())
+	}
+
+	return b
+}
+
+// Note inserts a note with the given body in the given book and returns it
+func (f *Fixture) Note(book database.Book, body string, opts ...FixtureOption) database.Note {
+	a := f.attrs(opts)
+
+	n := database.NewNote(a.uuid, book.UUID, body, a.addedOn, a.editedOn, a.usn, a.public, a.deleted, a.dirty)
+	n.OriginDevice = a.originDevice
+	n.Position = a.position
+	n.ViewCount = a.viewCount
+	n.LastViewedAt = a.lastViewedAt
+	if err := n.Insert(f.db); err != nil {
+		f.t.Fatal(errors.Wrap(err, "inserting the fixture note").Error())
+	}
+
+	return f.MustGetNote(n.UUID)
+}
+
+// MustGetBook reads all columns of the book with the given uuid
+func (f *Fixture) MustGetBook(uuid string) database.Book {
+	return MustGetBook(f.t, f.db, uuid)
+}
+
+// MustGetNote reads all columns of the note with the given uuid
+func (f *Fixture) MustGetNote(uuid string) database.Note {
+	return MustGetNote(f.t, f.db, uuid)
+}
+
+// MustGetBook reads every column of the book with the given uuid. Keep the
+// query in sync with the books table so that AssertBookEqual covers new columns.
+func MustGetBook(t *testing.T, db *database.DB, uuid string) database.Book {
+	var ret database.Book
+	database.MustScan(t, "getting the book "+uuid,
+		db.QueryRow("SELECT uuid, label, usn, deleted, dirty FROM books WHERE uuid = ?", uuid),
+		&ret.UUID, &ret.Label, &ret.USN, &ret.Deleted, &ret.Dirty)
+
+	return ret
+}
+
+// MustGetNote reads every column of the note with the given uuid. Keep the
+// query in sync with the notes table so that AssertNoteEqual covers new columns.
+func MustGetNote(t *testing.T, db *database.DB, uuid string) database.Note {
+	var ret database.Note
+	database.MustScan(t, "getting the note "+uuid,
+		db.QueryRow("SELECT rowid, uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty, origin_device, position, view_count, last_viewed_at FROM notes WHERE uuid = ?", uuid),
+		&ret.RowID, &ret.UUID, &ret.BookUUID, &ret.Body, &ret.AddedOn, &ret.EditedOn, &ret.USN, &ret.Public, &ret.Deleted, &ret.Dirty, &ret.OriginDevice, &ret.Position, &ret.ViewCount, &ret.LastViewedAt)
+
+	return ret
+}
+
+// MustCountNotes returns the number of notes in the database
+func (f *Fixture) MustCountNotes() int {
+	var ret int
+	database.MustScan(f.t, "counting notes", f.db.QueryRow("SELECT count(*) FROM notes"), &ret)
+
+	return ret
+}
+
+// MustCountBooks returns the number of books in the database
+func (f *Fixture) MustCountBooks() int {
+	var ret int
+	database.MustScan(f.t, "counting books", f.db.QueryRow("SELECT count(*) FROM books"), &ret)
+
+	return ret
+}
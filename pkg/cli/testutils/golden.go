@@ -0,0 +1,58 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package testutils
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// AssertGolden compares got with the content of the golden file testdata/<name>.golden.
+// If the -update flag is passed, it writes got to the golden file instead.
+func AssertGolden(t *testing.T, got []byte, name string) {
+	path := filepath.Join("testdata", name+".golden")
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(errors.Wrap(err, "creating the testdata directory"))
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(errors.Wrapf(err, "updating the golden file %s", path))
+		}
+
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrapf(err, "reading the golden file %s. Run the test with -update to create it", path))
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("output does not match the golden file %s. Run the test with -update to update it.\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
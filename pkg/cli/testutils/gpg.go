@@ -0,0 +1,56 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package testutils
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// GPGRecipient is the email address of the key created by SetupGPG
+const GPGRecipient = "test@example.com"
+
+// SetupGPG points gpg to a new keyring with a throwaway key for
+// GPGRecipient that has no passphrase. It skips the test if gpg is not
+// installed.
+func SetupGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	// the agent socket is in the home directory, whose path must be short
+	home, err := ioutil.TempDir("", "gnupg")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating the gpg home directory"))
+	}
+	t.Setenv("GNUPGHOME", home)
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		os.RemoveAll(home)
+	})
+
+	cmd := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "Dnote Test <"+GPGRecipient+">", "future-default", "default", "never")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatal(errors.Wrapf(err, "generating a key: %s", out))
+	}
+}
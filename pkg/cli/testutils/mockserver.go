@@ -0,0 +1,496 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package testutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The request and response types below mirror the wire format of the API.
+// They are declared here rather than imported from the client package
+// because the client tests depend on this package.
+
+type mockBookPayload struct {
+	Name *string `json:"name"`
+}
+
+type mockNotePayload struct {
+	BookUUID *string `json:"book_uuid"`
+	Body     *string `json:"content"`
+	Public   *bool   `json:"public"`
+}
+
+type mockRespBook struct {
+	UUID  string `json:"uuid"`
+	USN   int    `json:"usn"`
+	Label string `json:"label"`
+}
+
+type mockRespNoteBook struct {
+	UUID string `json:"uuid"`
+}
+
+type mockRespNote struct {
+	UUID   string           `json:"uuid"`
+	USN    int              `json:"usn"`
+	Body   string           `json:"content"`
+	Public bool             `json:"public"`
+	Book   mockRespNoteBook `json:"book"`
+}
+
+// MockRequest is a request received by a MockServer
+type MockRequest struct {
+	Method string
+	Path   string
+	// Query is the encoded query of the URL
+	Query string
+	Body  []byte
+	// Authorization is the value of the Authorization header
+	Authorization string
+	// Status is the status code of the response
+	Status int
+}
+
+type mockFailure struct {
+	method string
+	path   string
+	status int
+	count  int
+}
+
+type mockDelay struct {
+	method   string
+	path     string
+	duration time.Duration
+}
+
+// MockServer is a test server that implements the book, note and sync
+// endpoints of the API. It records the requests it receives and responds
+// with the canned data configured by the test.
+//
+// A created book or note is assigned the uuid "server-<label>-uuid" or
+// "server-<body>-uuid" respectively, and every mutation responds with the usn
+// set by SetUSN for the uuid, or DefaultUSN.
+type MockServer struct {
+	// DefaultUSN is the usn of the books and notes in the responses, unless
+	// it is set for the uuid by SetUSN
+	DefaultUSN int
+
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	usns      map[string]int
+	state     interface{}
+	limits    interface{}
+	fragments map[int]interface{}
+	books     map[string]interface{}
+	failures  []*mockFailure
+	delays    []mockDelay
+	requests  []MockRequest
+
+	createdBookLabels []string
+	updatedBookUUIDs  []string
+	deletedBookUUIDs  []string
+	createdNoteBodies []string
+	updatedNoteUUIDs  []string
+	deletedNoteUUIDs  []string
+}
+
+// NewMockServer starts a new MockServer. The caller should Close it when done.
+func NewMockServer(t *testing.T) *MockServer {
+	s := &MockServer{
+		t:         t,
+		usns:      map[string]int{},
+		fragments: map[int]interface{}{},
+		books:     map[string]interface{}{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// URL returns the base URL of the server, to be used as the API endpoint
+func (s *MockServer) URL() string {
+	return s.server.URL
+}
+
+// Close shuts down the server
+func (s *MockServer) Close() {
+	s.server.Close()
+}
+
+// SetUSN sets the usn in the responses for the book or note with the given uuid
+func (s *MockServer) SetUSN(uuid string, usn int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.usns[uuid] = usn
+}
+
+// SetSyncState sets the response of the sync state endpoint, e.g. a
+// client.GetSyncStateResp
+func (s *MockServer) SetSyncState(state interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = state
+}
+
+// SetLimits sets the response of the limits endpoint, e.g. a
+// client.GetLimitsResp. The endpoint responds with 404, as the servers that do
+// not limit the accounts, until it is set.
+func (s *MockServer) SetLimits(limits interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits = limits
+}
+
+// SetFragment sets the fragment, e.g. a client.SyncFragment, in the response
+// of the sync fragment endpoint for the given after_usn. An empty fragment is
+// returned for an after_usn without one.
+func (s *MockServer) SetFragment(afterUSN int, fragment interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fragments[afterUSN] = fragment
+}
+
+// SetBook sets the response of the book endpoint, e.g. a client.RespBook, for
+// the book with the given uuid. The endpoint responds with 404 for a book
+// without one.
+func (s *MockServer) SetBook(uuid string, book interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.books[uuid] = book
+}
+
+// Fail makes the next count requests with the given method to the given path,
+// or to a path under it, fail with the given status code
+func (s *MockServer) Fail(method, path string, status, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &mockFailure{
+		method: method,
+		path:   path,
+		status: status,
+		count:  count,
+	})
+}
+
+// Delay makes the requests with the given method to the given path, or to a
+// path under it, wait for the given duration before they are handled, as on a
+// slow connection. A request canceled by the client stops waiting.
+func (s *MockServer) Delay(method, path string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delays = append(s.delays, mockDelay{method: method, path: path, duration: d})
+}
+
+// Requests returns the requests received by the server in order
+func (s *MockServer) Requests() []MockRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]MockRequest(nil), s.requests...)
+}
+
+// CreatedBookLabels returns the labels of the created books in order
+func (s *MockServer) CreatedBookLabels() []string {
+	return s.recorded(&s.createdBookLabels)
+}
+
+// UpdatedBookUUIDs returns the uuids of the updated books in order
+func (s *MockServer) UpdatedBookUUIDs() []string {
+	return s.recorded(&s.updatedBookUUIDs)
+}
+
+// DeletedBookUUIDs returns the uuids of the deleted books in order
+func (s *MockServer) DeletedBookUUIDs() []string {
+	return s.recorded(&s.deletedBookUUIDs)
+}
+
+// CreatedNoteBodies returns the bodies of the created notes in order
+func (s *MockServer) CreatedNoteBodies() []string {
+	return s.recorded(&s.createdNoteBodies)
+}
+
+// UpdatedNoteUUIDs returns the uuids of the updated notes in order
+func (s *MockServer) UpdatedNoteUUIDs() []string {
+	return s.recorded(&s.updatedNoteUUIDs)
+}
+
+// DeletedNoteUUIDs returns the uuids of the deleted notes in order
+func (s *MockServer) DeletedNoteUUIDs() []string {
+	return s.recorded(&s.deletedNoteUUIDs)
+}
+
+func (s *MockServer) recorded(list *[]string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), *list...)
+}
+
+func (s *MockServer) usn(uuid string) int {
+	if usn, ok := s.usns[uuid]; ok {
+		return usn
+	}
+
+	return s.DefaultUSN
+}
+
+// matchFailure returns the status code of the failure injected for the request
+func (s *MockServer) matchFailure(r *http.Request) (int, bool) {
+	for _, f := range s.failures {
+		if f.count <= 0 || !matchesPath(r, f.method, f.path) {
+			continue
+		}
+
+		f.count--
+		return f.status, true
+	}
+
+	return 0, false
+}
+
+// matchesPath reports whether the request is to the given path or to a path
+// under it
+func matchesPath(r *http.Request, method, path string) bool {
+	return r.Method == method && (r.URL.Path == path || strings.HasPrefix(r.URL.Path, path+"/"))
+}
+
+// delay returns the duration for which the request waits before it is handled
+func (s *MockServer) delay(r *http.Request) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ret time.Duration
+	for _, d := range s.delays {
+		if matchesPath(r, d.method, d.path) {
+			ret += d.duration
+		}
+	}
+
+	return ret
+}
+
+func (s *MockServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if d := s.delay(r); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req := MockRequest{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Body: body, Authorization: r.Header.Get("Authorization")}
+
+	if status, ok := s.matchFailure(r); ok {
+		req.Status = status
+		s.requests = append(s.requests, req)
+
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	resp, status := s.route(r, body)
+	req.Status = status
+	s.requests = append(s.requests, req)
+
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// route dispatches the request and returns the response and the status code
+func (s *MockServer) route(r *http.Request, body []byte) (interface{}, int) {
+	p := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v3/sync/state":
+		if s.state == nil {
+			return map[string]interface{}{}, http.StatusOK
+		}
+		return s.state, http.StatusOK
+	case r.Method == "GET" && r.URL.Path == "/v3/limits":
+		if s.limits == nil {
+			return nil, http.StatusNotFound
+		}
+		return s.limits, http.StatusOK
+	case r.Method == "GET" && r.URL.Path == "/v3/sync/fragment":
+		return s.getFragment(r)
+	case len(p) == 2 && p[0] == "v3" && p[1] == "books" && r.Method == "POST":
+		return s.createBook(body)
+	case len(p) == 3 && p[0] == "v3" && p[1] == "books":
+		return s.mutateBook(r.Method, p[2], body)
+	case len(p) == 2 && p[0] == "v3" && p[1] == "notes" && r.Method == "POST":
+		return s.createNote(body)
+	case len(p) == 3 && p[0] == "v3" && p[1] == "notes":
+		return s.mutateNote(r.Method, p[2], body)
+	}
+
+	s.t.Errorf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
+	return nil, http.StatusNotFound
+}
+
+func (s *MockServer) decode(body []byte, v interface{}) bool {
+	if err := json.Unmarshal(body, v); err != nil {
+		s.t.Errorf("decoding payload in the test server: %s", err)
+		return false
+	}
+
+	return true
+}
+
+func (s *MockServer) getFragment(r *http.Request) (interface{}, int) {
+	afterUSN, err := strconv.Atoi(r.URL.Query().Get("after_usn"))
+	if err != nil {
+		s.t.Errorf("invalid after_usn %q", r.URL.Query().Get("after_usn"))
+		return nil, http.StatusBadRequest
+	}
+
+	fragment, ok := s.fragments[afterUSN]
+	if !ok {
+		fragment = map[string]interface{}{}
+	}
+
+	return map[string]interface{}{"fragment": fragment}, http.StatusOK
+}
+
+func (s *MockServer) createBook(body []byte) (interface{}, int) {
+	var payload mockBookPayload
+	if !s.decode(body, &payload) || payload.Name == nil {
+		return nil, http.StatusBadRequest
+	}
+
+	s.createdBookLabels = append(s.createdBookLabels, *payload.Name)
+
+	uuid := fmt.Sprintf("server-%s-uuid", *payload.Name)
+	book := mockRespBook{UUID: uuid, USN: s.usn(uuid), Label: *payload.Name}
+
+	return map[string]interface{}{"book": book}, http.StatusOK
+}
+
+func (s *MockServer) mutateBook(method, uuid string, body []byte) (interface{}, int) {
+	book := mockRespBook{UUID: uuid, USN: s.usn(uuid)}
+
+	switch method {
+	case "GET":
+		b, ok := s.books[uuid]
+		if !ok {
+			return nil, http.StatusNotFound
+		}
+		return b, http.StatusOK
+	case "PATCH":
+		var payload mockBookPayload
+		if !s.decode(body, &payload) {
+			return nil, http.StatusBadRequest
+		}
+		if payload.Name != nil {
+			book.Label = *payload.Name
+		}
+
+		s.updatedBookUUIDs = append(s.updatedBookUUIDs, uuid)
+		return map[string]interface{}{"book": book}, http.StatusOK
+	case "DELETE":
+		s.deletedBookUUIDs = append(s.deletedBookUUIDs, uuid)
+		return map[string]interface{}{"status": http.StatusOK, "book": book}, http.StatusOK
+	}
+
+	s.t.Errorf("unrecognized endpoint reached Method: %s Path: /v3/books/%s", method, uuid)
+	return nil, http.StatusMethodNotAllowed
+}
+
+func (s *MockServer) createNote(body []byte) (interface{}, int) {
+	var payload mockNotePayload
+	if !s.decode(body, &payload) || payload.Body == nil || payload.BookUUID == nil {
+		return nil, http.StatusBadRequest
+	}
+
+	s.createdNoteBodies = append(s.createdNoteBodies, *payload.Body)
+
+	uuid := fmt.Sprintf("server-%s-uuid", *payload.Body)
+	note := mockRespNote{
+		UUID: uuid,
+		USN:  s.usn(uuid),
+		Body: *payload.Body,
+		Book: mockRespNoteBook{UUID: *payload.BookUUID},
+	}
+
+	return map[string]interface{}{"result": note}, http.StatusOK
+}
+
+func (s *MockServer) mutateNote(method, uuid string, body []byte) (interface{}, int) {
+	note := mockRespNote{UUID: uuid, USN: s.usn(uuid)}
+
+	switch method {
+	case "PATCH":
+		var payload mockNotePayload
+		if !s.decode(body, &payload) {
+			return nil, http.StatusBadRequest
+		}
+		if payload.Body != nil {
+			note.Body = *payload.Body
+		}
+		if payload.BookUUID != nil {
+			note.Book.UUID = *payload.BookUUID
+		}
+		if payload.Public != nil {
+			note.Public = *payload.Public
+		}
+
+		s.updatedNoteUUIDs = append(s.updatedNoteUUIDs, uuid)
+		return map[string]interface{}{"status": http.StatusOK, "result": note}, http.StatusOK
+	case "DELETE":
+		s.deletedNoteUUIDs = append(s.deletedNoteUUIDs, uuid)
+		return map[string]interface{}{"status": http.StatusOK, "result": note}, http.StatusOK
+	}
+
+	s.t.Errorf("unrecognized endpoint reached Method: %s Path: /v3/notes/%s", method, uuid)
+	return nil, http.StatusMethodNotAllowed
+}
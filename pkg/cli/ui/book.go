@@ -0,0 +1,254 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ui
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+)
+
+// maxBookDistance is the maximum edit distance at which two book names are
+// considered similar
+const maxBookDistance = 2
+
+// isSubsequence checks if all characters of sub appear in s in the same order
+func isSubsequence(sub, s string) bool {
+	r := []rune(s)
+
+	i := 0
+	for _, c := range sub {
+		for i < len(r) && r[i] != c {
+			i++
+		}
+		if i == len(r) {
+			return false
+		}
+		i++
+	}
+
+	return true
+}
+
+// matchBooks returns the labels matching the given name, ignoring case. Labels
+// that are equal to the name take precedence over the ones that start with it,
+// which in turn take precedence over the ones that merely contain its
+// characters in order.
+func matchBooks(name string, labels []string) []string {
+	n := strings.ToLower(name)
+
+	var equal, prefix, fuzzy []string
+	for _, label := range labels {
+		l := strings.ToLower(label)
+
+		if l == n {
+			equal = append(equal, label)
+		} else if strings.HasPrefix(l, n) {
+			prefix = append(prefix, label)
+		} else if isSubsequence(n, l) {
+			fuzzy = append(fuzzy, label)
+		}
+	}
+
+	if len(equal) > 0 {
+		return equal
+	}
+	if len(prefix) > 0 {
+		return prefix
+	}
+
+	return fuzzy
+}
+
+// similarBooks returns the labels that are within a small edit distance of the
+// given name. A distance is only meaningful if it is shorter than the name
+// itself, so that short names are not deemed similar to every other short name.
+func similarBooks(name string, labels []string) []string {
+	n := strings.ToLower(name)
+
+	var ret []string
+	for _, label := range labels {
+		d := utils.EditDistance(n, strings.ToLower(label))
+
+		if d <= maxBookDistance && d < len([]rune(n)) {
+			ret = append(ret, label)
+		}
+	}
+
+	return ret
+}
+
+// closeBooks returns the labels that the given name matches or is similar to,
+// which are likely to be what a name with a typo meant
+func closeBooks(name string, labels []string) []string {
+	ret := matchBooks(name, labels)
+	for _, label := range similarBooks(name, labels) {
+		if !contains(ret, label) {
+			ret = append(ret, label)
+		}
+	}
+
+	return ret
+}
+
+func contains(labels []string, name string) bool {
+	for _, label := range labels {
+		if label == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResolveBook returns the label of an existing book referred to by the given
+// name. If no book has the exact label, the name is looked up as a prefix of
+// the uuid of a book, and then matched against existing labels. A single match
+// is used automatically, and multiple matches are presented to the user to
+// choose from if a terminal is attached. If nothing matches, the name is
+// returned as it is.
+func ResolveBook(ctx context.DnoteCtx, name string) (string, error) {
+	labels, err := database.GetBookLabels(ctx.DB)
+	if err != nil {
+		return "", errors.Wrap(err, "getting book labels")
+	}
+
+	if contains(labels, name) {
+		return name, nil
+	}
+
+	if database.IsUUIDPrefix(name) {
+		uuid, err := database.ResolveBookUUID(ctx.DB, name, false)
+		if err == nil {
+			info, err := database.GetBookInfo(ctx.DB, uuid)
+			if err != nil {
+				return "", errors.Wrap(err, "getting the book")
+			}
+
+			return info.Name, nil
+		} else if err != sql.ErrNoRows {
+			return "", errors.Wrap(err, "resolving the uuid prefix")
+		}
+	}
+
+	matches := matchBooks(name, labels)
+
+	switch len(matches) {
+	case 0:
+		return name, nil
+	case 1:
+		log.Info(i18n.T("book.using", matches[0], name))
+		return matches[0], nil
+	}
+
+	if !IsInteractive() {
+		return "", errors.Errorf("book '%s' is ambiguous. Candidates: %s", name, strings.Join(matches, ", "))
+	}
+
+	idx, err := PromptSelect(i18n.T("book.which", name), "", matches)
+	if err != nil {
+		return "", errors.Wrap(err, "choosing a book")
+	}
+
+	return matches[idx], nil
+}
+
+// ConfirmNewBook checks if a book with the given name should be created. If
+// the name is similar to that of an existing book, which is likely to be a typo,
+// the user is asked for a confirmation unless Dnote runs in the non-interactive
+// mode, in which case an error is returned.
+func ConfirmNewBook(ctx context.DnoteCtx, name string) (bool, error) {
+	labels, err := database.GetBookLabels(ctx.DB)
+	if err != nil {
+		return false, errors.Wrap(err, "getting book labels")
+	}
+
+	similar := similarBooks(name, labels)
+	if len(similar) == 0 {
+		return true, nil
+	}
+
+	msg := fmt.Sprintf("book '%s' does not exist but is similar to %s", name, strings.Join(similar, ", "))
+
+	if err := RequireInteractive("confirm creating a new book", "--create"); err != nil {
+		return false, errors.Wrap(err, msg)
+	}
+
+	ok, err := Confirm(i18n.T("book.confirm_similar", name, strings.Join(similar, ", ")), "--create", false)
+	if err != nil {
+		return false, errors.Wrap(err, "getting confirmation")
+	}
+
+	return ok, nil
+}
+
+// AllowNewBook checks if a book with the given name, which does not exist, may
+// be created under the policy, which is one of the consts.AutoCreateBook values.
+// Under the false policy, an error listing the books with close names is
+// returned. Under the prompt policy, the user is asked for a confirmation
+// unless Dnote runs in the non-interactive mode, in which case an error is
+// returned. The hint is the flag that creates the book regardless, if any.
+func AllowNewBook(ctx context.DnoteCtx, name, policy, hint string) (bool, error) {
+	if policy != consts.AutoCreateBookFalse && policy != consts.AutoCreateBookPrompt {
+		return true, nil
+	}
+
+	labels, err := database.GetBookLabels(ctx.DB)
+	if err != nil {
+		return false, errors.Wrap(err, "getting book labels")
+	}
+
+	msg := fmt.Sprintf("book '%s' does not exist", name)
+	matches := closeBooks(name, labels)
+	if len(matches) > 0 {
+		msg = fmt.Sprintf("%s. Close matches: %s", msg, strings.Join(matches, ", "))
+	}
+
+	if policy == consts.AutoCreateBookFalse {
+		if hint != "" {
+			return false, errors.Errorf("%s. Use %s to create it", msg, hint)
+		}
+
+		return false, errors.New(msg)
+	}
+
+	if err := RequireInteractive("confirm creating a new book", hint); err != nil {
+		return false, errors.Wrap(err, msg)
+	}
+
+	question := i18n.T("book.confirm_new", name)
+	if len(matches) > 0 {
+		question = i18n.T("book.confirm_new_close", name, strings.Join(matches, ", "))
+	}
+
+	ok, err := Confirm(question, hint, false)
+	if err != nil {
+		return false, errors.Wrap(err, "getting confirmation")
+	}
+
+	return ok, nil
+}
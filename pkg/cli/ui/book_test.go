@@ -0,0 +1,301 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ui
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func TestMatchBooks(t *testing.T) {
+	labels := []string{"css", "golang", "Go-Concurrency", "javascript", "JS", "linux"}
+
+	testCases := []struct {
+		name     string
+		expected []string
+	}{
+		{
+			name:     "js",
+			expected: []string{"JS"},
+		},
+		{
+			name:     "GOLANG",
+			expected: []string{"golang"},
+		},
+		{
+			name:     "go",
+			expected: []string{"golang", "Go-Concurrency"},
+		},
+		{
+			name:     "lin",
+			expected: []string{"linux"},
+		},
+		{
+			name:     "jvscr",
+			expected: []string{"javascript"},
+		},
+		{
+			name:     "rust",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.DeepEqual(t, matchBooks(tc.name, labels), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestSimilarBooks(t *testing.T) {
+	labels := []string{"css", "golang", "java", "js", "linux"}
+
+	testCases := []struct {
+		name     string
+		expected []string
+	}{
+		{
+			name:     "golnag",
+			expected: []string{"golang"},
+		},
+		{
+			name:     "jave",
+			expected: []string{"java"},
+		},
+		{
+			name:     "Linus",
+			expected: []string{"linux"},
+		},
+		{
+			// distances as long as the name itself are not meaningful
+			name:     "go",
+			expected: nil,
+		},
+		{
+			name:     "python",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.DeepEqual(t, similarBooks(tc.name, labels), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestResolveBook(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{
+			name:     "js",
+			expected: "js",
+		},
+		{
+			name:     "Linux",
+			expected: "linux",
+		},
+		{
+			name:     "lin",
+			expected: "linux",
+		},
+		{
+			name:     "rust",
+			expected: "rust",
+		},
+		{
+			name:     "c0ffee",
+			expected: "css",
+		},
+		{
+			name:     "c0ffee00-00",
+			expected: "css",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("name %s", tc.name), func(t *testing.T) {
+			// set up
+			ctx := context.InitTestCtx(t, context.Paths{
+				Data:  "../tmp",
+				Cache: "../tmp",
+			}, nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			database.MustExec(t, "inserting b1", ctx.DB, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "js")
+			database.MustExec(t, "inserting b2", ctx.DB, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "linux")
+			database.MustExec(t, "inserting b3", ctx.DB, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b3-uuid", "javascript")
+			database.MustExec(t, "inserting b4", ctx.DB, "INSERT INTO books (uuid, label) VALUES (?, ?)", "c0ffee00-0000-4000-8000-000000000000", "css")
+
+			// execute
+			result, err := ResolveBook(ctx, tc.name)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			// test
+			assert.Equal(t, result, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestConfirmNewBook_nonInteractive(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, context.Paths{
+		Data:  "../tmp",
+		Cache: "../tmp",
+	}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+	setInteractive(t, false)
+
+	database.MustExec(t, "inserting b1", ctx.DB, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "golang")
+
+	t.Run("similar", func(t *testing.T) {
+		ok, err := ConfirmNewBook(ctx, "golnag")
+
+		assert.Equal(t, ok, false, "result mismatch")
+		assert.Equal(t, errors.Cause(err), NonInteractiveError{Action: "confirm creating a new book", Hint: "--create"}, "error mismatch")
+	})
+
+	t.Run("not similar", func(t *testing.T) {
+		ok, err := ConfirmNewBook(ctx, "python")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, ok, true, "result mismatch")
+	})
+}
+
+// setStdin makes the prompts read the given input for the duration of the test
+func setStdin(t *testing.T, input string) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := ioutil.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the input"))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the input"))
+	}
+
+	orig := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = orig
+		f.Close()
+	})
+}
+
+func TestAllowNewBook(t *testing.T) {
+	ctx := context.InitTestCtx(t, context.Paths{
+		Data:  "../tmp",
+		Cache: "../tmp",
+	}, nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	database.MustExec(t, "inserting b1", ctx.DB, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b1-uuid", "golang")
+	database.MustExec(t, "inserting b2", ctx.DB, "INSERT INTO books (uuid, label) VALUES (?, ?)", "b2-uuid", "go-concurrency")
+
+	t.Run("true", func(t *testing.T) {
+		setInteractive(t, false)
+
+		ok, err := AllowNewBook(ctx, "golnag", consts.AutoCreateBookTrue, "--create")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, ok, true, "result mismatch")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		setInteractive(t, false)
+
+		ok, err := AllowNewBook(ctx, "golnag", "", "--create")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, ok, true, "result mismatch")
+	})
+
+	t.Run("false", func(t *testing.T) {
+		ok, err := AllowNewBook(ctx, "golnag", consts.AutoCreateBookFalse, "--create")
+
+		assert.Equal(t, ok, false, "result mismatch")
+		assert.Equal(t, err.Error(), "book 'golnag' does not exist. Close matches: golang. Use --create to create it", "error mismatch")
+	})
+
+	t.Run("false without close matches", func(t *testing.T) {
+		ok, err := AllowNewBook(ctx, "python", consts.AutoCreateBookFalse, "")
+
+		assert.Equal(t, ok, false, "result mismatch")
+		assert.Equal(t, err.Error(), "book 'python' does not exist", "error mismatch")
+	})
+
+	t.Run("false with fuzzy matches", func(t *testing.T) {
+		_, err := AllowNewBook(ctx, "go", consts.AutoCreateBookFalse, "")
+
+		assert.Equal(t, err.Error(), "book 'go' does not exist. Close matches: go-concurrency, golang", "error mismatch")
+	})
+
+	t.Run("prompt confirmed", func(t *testing.T) {
+		setInteractive(t, true)
+		setStdin(t, "y\n")
+
+		ok, err := AllowNewBook(ctx, "golnag", consts.AutoCreateBookPrompt, "--create")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, ok, true, "result mismatch")
+	})
+
+	t.Run("prompt declined", func(t *testing.T) {
+		setInteractive(t, true)
+		setStdin(t, "\n")
+
+		ok, err := AllowNewBook(ctx, "python", consts.AutoCreateBookPrompt, "--create")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, ok, false, "result mismatch")
+	})
+
+	t.Run("prompt non-interactive", func(t *testing.T) {
+		setInteractive(t, false)
+
+		ok, err := AllowNewBook(ctx, "python", consts.AutoCreateBookPrompt, "--create")
+
+		assert.Equal(t, ok, false, "result mismatch")
+		assert.Equal(t, errors.Cause(err), NonInteractiveError{Action: "confirm creating a new book", Hint: "--create"}, "error mismatch")
+	})
+}
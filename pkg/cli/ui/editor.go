@@ -24,11 +24,15 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/cli/validate"
 	"github.com/pkg/errors"
 )
 
@@ -37,7 +41,7 @@ import (
 func GetTmpContentPath(ctx context.DnoteCtx) (string, error) {
 	for i := 0; ; i++ {
 		filename := fmt.Sprintf("%s_%d.%s", consts.TmpContentFileBase, i, consts.TmpContentFileExt)
-		candidate := fmt.Sprintf("%s/%s", ctx.Paths.Cache, filename)
+		candidate := filepath.Join(ctx.Paths.Cache, filename)
 
 		ok, err := utils.FileExists(candidate)
 		if err != nil {
@@ -78,16 +82,63 @@ func getEditorCommand() string {
 	return ret
 }
 
+// splitCommand splits a command into the program and its arguments. Quotes
+// group the words so that a path with spaces, as is common on Windows, can be
+// used. Backslashes are not escapes because they separate the directories in
+// Windows paths.
+func splitCommand(s string) []string {
+	var ret []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				ret = append(ret, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		ret = append(ret, word.String())
+	}
+
+	return ret
+}
+
 func newEditorCmd(ctx context.DnoteCtx, fpath string) (*exec.Cmd, error) {
-	args := strings.Fields(ctx.Editor)
+	args := splitCommand(ctx.Editor)
+	if len(args) == 0 {
+		return nil, errors.New("no editor is set. Set the editor in the config file")
+	}
 	args = append(args, fpath)
 
 	return exec.Command(args[0], args[1:]...), nil
 }
 
 // GetEditorInput gets the user input by launching a text editor and waiting for
-// it to exit
-func GetEditorInput(ctx context.DnoteCtx, fpath string) (string, error) {
+// it to exit. The hint names the flag that gives the input in the
+// non-interactive mode, in which no editor is launched.
+func GetEditorInput(ctx context.DnoteCtx, fpath, hint string) (string, error) {
+	if err := RequireInteractive("launch an editor", hint); err != nil {
+		return "", err
+	}
+
 	ok, err := utils.FileExists(fpath)
 	if err != nil {
 		return "", errors.Wrapf(err, "checking if the file exists at %s", fpath)
@@ -136,3 +187,40 @@ func GetEditorInput(ctx context.DnoteCtx, fpath string) (string, error) {
 
 	return raw, nil
 }
+
+// NormalizeContent normalizes the content of a note before it is saved. Content
+// that is not valid UTF-8, such as one pasted from a binary file, is rejected
+// unless force is true, in which case the invalid bytes are replaced.
+func NormalizeContent(content string, force bool) (string, error) {
+	if err := validate.NoteBody(content); err != nil {
+		if !force {
+			return "", errors.Wrap(err, "use --force to replace the invalid bytes")
+		}
+
+		log.Warn(i18n.T("editor.invalid_utf8"))
+	}
+
+	return utils.NormalizeBody(content), nil
+}
+
+// CheckContentSize checks the size of the content of a note before it is
+// saved, so that a note too large for the server is not found only when it is
+// synced. Content above ctx.NoteSizeMax is rejected unless force is true, and
+// content above ctx.NoteSizeWarn is saved with a warning.
+func CheckContentSize(ctx context.DnoteCtx, content string, force bool) error {
+	size := int64(len(content))
+
+	if err := validate.NoteSize(content, ctx.NoteSizeMax); err != nil {
+		if !force {
+			return errors.Wrap(err, "split the note, or keep the content in a file and note the path. Use --force if the server accepts larger notes")
+		}
+
+		log.Warn(i18n.T("editor.size_forced", size, ctx.NoteSizeMax))
+		return nil
+	}
+	if ctx.NoteSizeWarn > 0 && size > ctx.NoteSizeWarn {
+		log.Warn(i18n.T("editor.size_large", size, ctx.NoteSizeWarn))
+	}
+
+	return nil
+}
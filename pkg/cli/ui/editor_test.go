@@ -21,6 +21,7 @@ package ui
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/dnote/dnote/pkg/assert"
@@ -41,7 +42,7 @@ func TestGetTmpContentPath(t *testing.T) {
 			t.Fatal(errors.Wrap(err, "executing"))
 		}
 
-		expected := fmt.Sprintf("%s/%s", ctx.Paths.Cache, "DNOTE_TMPCONTENT_0.md")
+		expected := filepath.Join(ctx.Paths.Cache, "DNOTE_TMPCONTENT_0.md")
 		assert.Equal(t, res, expected, "filename did not match")
 	})
 
@@ -53,7 +54,7 @@ func TestGetTmpContentPath(t *testing.T) {
 		}, nil)
 		defer context.TeardownTestCtx(t, ctx)
 
-		p := fmt.Sprintf("%s/%s", ctx.Paths.Cache, "DNOTE_TMPCONTENT_0.md")
+		p := filepath.Join(ctx.Paths.Cache, "DNOTE_TMPCONTENT_0.md")
 		if _, err := os.Create(p); err != nil {
 			t.Fatal(errors.Wrap(err, "preparing the conflicting file"))
 		}
@@ -65,7 +66,7 @@ func TestGetTmpContentPath(t *testing.T) {
 		}
 
 		// test
-		expected := fmt.Sprintf("%s/%s", ctx.Paths.Cache, "DNOTE_TMPCONTENT_1.md")
+		expected := filepath.Join(ctx.Paths.Cache, "DNOTE_TMPCONTENT_1.md")
 		assert.Equal(t, res, expected, "filename did not match")
 	})
 
@@ -77,11 +78,11 @@ func TestGetTmpContentPath(t *testing.T) {
 		}, nil)
 		defer context.TeardownTestCtx(t, ctx)
 
-		p1 := fmt.Sprintf("%s/%s", ctx.Paths.Cache, "DNOTE_TMPCONTENT_0.md")
+		p1 := filepath.Join(ctx.Paths.Cache, "DNOTE_TMPCONTENT_0.md")
 		if _, err := os.Create(p1); err != nil {
 			t.Fatal(errors.Wrap(err, "preparing the conflicting file"))
 		}
-		p2 := fmt.Sprintf("%s/%s", ctx.Paths.Cache, "DNOTE_TMPCONTENT_1.md")
+		p2 := filepath.Join(ctx.Paths.Cache, "DNOTE_TMPCONTENT_1.md")
 		if _, err := os.Create(p2); err != nil {
 			t.Fatal(errors.Wrap(err, "preparing the conflicting file"))
 		}
@@ -93,7 +94,51 @@ func TestGetTmpContentPath(t *testing.T) {
 		}
 
 		// test
-		expected := fmt.Sprintf("%s/%s", ctx.Paths.Cache, "DNOTE_TMPCONTENT_2.md")
+		expected := filepath.Join(ctx.Paths.Cache, "DNOTE_TMPCONTENT_2.md")
 		assert.Equal(t, res, expected, "filename did not match")
 	})
 }
+
+func TestNormalizeContent(t *testing.T) {
+	testCases := []struct {
+		input       string
+		force       bool
+		expected    string
+		expectedErr bool
+	}{
+		{input: "foo\r\nbar", force: false, expected: "foo\nbar"},
+		{input: "foo\xffbar", force: false, expectedErr: true},
+		{input: "foo\xffbar\r\n", force: true, expected: "foo\uFFFDbar\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q force %t", tc.input, tc.force), func(t *testing.T) {
+			got, err := NormalizeContent(tc.input, tc.force)
+
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+			assert.Equal(t, got, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "vim", expected: []string{"vim"}},
+		{input: "code -n -w", expected: []string{"code", "-n", "-w"}},
+		{input: "  subl   -n\t-w ", expected: []string{"subl", "-n", "-w"}},
+		{input: `"C:\Program Files\Notepad++\notepad++.exe" -multiInst`, expected: []string{`C:\Program Files\Notepad++\notepad++.exe`, "-multiInst"}},
+		{input: `C:\Windows\notepad.exe`, expected: []string{`C:\Windows\notepad.exe`}},
+		{input: `'/Applications/Sublime Text.app/subl' -w`, expected: []string{"/Applications/Sublime Text.app/subl", "-w"}},
+		{input: `emacs --eval ""`, expected: []string{"emacs", "--eval", ""}},
+		{input: "", expected: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			assert.DeepEqual(t, splitCommand(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
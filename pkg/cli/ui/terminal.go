@@ -22,6 +22,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -30,6 +31,56 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// interactive reports whether the user can be prompted. Unless it is set by
+// SetInteractive, the user is prompted only if the standard input is a terminal.
+var interactive = IsTerminal
+
+// SetInteractive sets whether the user can be prompted regardless of the
+// standard input
+func SetInteractive(ok bool) {
+	interactive = func() bool { return ok }
+}
+
+// IsInteractive returns true if the user can be prompted
+func IsInteractive() bool {
+	return interactive()
+}
+
+// IsTerminal returns true if the standard input is attached to a terminal
+func IsTerminal() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// NonInteractiveError is returned instead of prompting the user if Dnote runs
+// in the non-interactive mode
+type NonInteractiveError struct {
+	// Action is what would have been done, such as prompting for an answer
+	Action string
+	// Hint is the flag or the environment variable that answers the prompt
+	// instead. It may be empty.
+	Hint string
+}
+
+func (e NonInteractiveError) Error() string {
+	msg := fmt.Sprintf("cannot %s in the non-interactive mode", e.Action)
+	if e.Hint == "" {
+		return msg
+	}
+
+	return fmt.Sprintf("%s. Use %s", msg, e.Hint)
+}
+
+// RequireInteractive returns a NonInteractiveError for the given action if the
+// user cannot be prompted. The hint names the flag or the environment variable
+// that would have made the prompt unnecessary.
+func RequireInteractive(action, hint string) error {
+	if IsInteractive() {
+		return nil
+	}
+
+	return NonInteractiveError{Action: action, Hint: hint}
+}
+
 func readInput() (string, error) {
 	reader := bufio.NewReader(os.Stdin)
 	input, err := reader.ReadString('\n')
@@ -40,11 +91,33 @@ func readInput() (string, error) {
 	return strings.Trim(input, "\r\n"), nil
 }
 
-// PromptInput prompts the user input and saves the result to the destination
-func PromptInput(message string, dest *string) error {
-	log.Askf(message, false)
+// ask prompts the user with the message and returns the input. Every prompt
+// goes through it so that none of them waits for an input that cannot come.
+func ask(message, hint string, masked bool) (string, error) {
+	if err := RequireInteractive(fmt.Sprintf("prompt for '%s'", message), hint); err != nil {
+		return "", err
+	}
 
-	input, err := readInput()
+	log.Askf(message, masked)
+
+	if !masked {
+		return readInput()
+	}
+
+	password, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return "", errors.Wrap(err, "reading the password")
+	}
+
+	fmt.Println("")
+
+	return string(password), nil
+}
+
+// PromptInput prompts the user input and saves the result to the destination.
+// The hint names the flag that answers the prompt in the non-interactive mode.
+func PromptInput(message, hint string, dest *string) error {
+	input, err := ask(message, hint, false)
 	if err != nil {
 		return errors.Wrap(err, "getting user input")
 	}
@@ -56,23 +129,20 @@ func PromptInput(message string, dest *string) error {
 
 // PromptPassword prompts the user input a password and saves the result to the destination.
 // The input is masked, meaning it is not echoed on the terminal.
-func PromptPassword(message string, dest *string) error {
-	log.Askf(message, true)
-
-	password, err := terminal.ReadPassword(int(syscall.Stdin))
+func PromptPassword(message, hint string, dest *string) error {
+	password, err := ask(message, hint, true)
 	if err != nil {
 		return errors.Wrap(err, "getting user input")
 	}
 
-	fmt.Println("")
-
-	*dest = string(password)
+	*dest = password
 
 	return nil
 }
 
-// Confirm prompts for user input to confirm a choice
-func Confirm(question string, optimistic bool) (bool, error) {
+// Confirm prompts for user input to confirm a choice. The hint names the flag
+// that confirms it in the non-interactive mode.
+func Confirm(question, hint string, optimistic bool) (bool, error) {
 	var choices string
 	if optimistic {
 		choices = "(Y/n)"
@@ -82,8 +152,8 @@ func Confirm(question string, optimistic bool) (bool, error) {
 
 	message := fmt.Sprintf("%s %s", question, choices)
 
-	var input string
-	if err := PromptInput(message, &input); err != nil {
+	input, err := ask(message, hint, false)
+	if err != nil {
 		return false, errors.Wrap(err, "Failed to get user input")
 	}
 
@@ -95,3 +165,27 @@ func Confirm(question string, optimistic bool) (bool, error) {
 
 	return confirmed, nil
 }
+
+// PromptSelect prints the choices in a numbered list and prompts the user to
+// pick one of them. It returns the index of the chosen item.
+func PromptSelect(message, hint string, choices []string) (int, error) {
+	if err := RequireInteractive(fmt.Sprintf("prompt for '%s'", message), hint); err != nil {
+		return 0, err
+	}
+
+	for i, choice := range choices {
+		log.Plainf("%s %s\n", log.ColorYellow.Sprintf("(%d)", i+1), choice)
+	}
+
+	input, err := ask(fmt.Sprintf("%s (1-%d)", message, len(choices)), hint, false)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting user input")
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil || n < 1 || n > len(choices) {
+		return 0, errors.Errorf("invalid choice '%s'", input)
+	}
+
+	return n - 1, nil
+}
@@ -0,0 +1,100 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ui
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+// setInteractive sets whether the user can be prompted for the duration of
+// the test
+func setInteractive(t *testing.T, ok bool) {
+	SetInteractive(ok)
+	t.Cleanup(func() {
+		interactive = IsTerminal
+	})
+}
+
+func TestNonInteractiveError(t *testing.T) {
+	testCases := []struct {
+		err      NonInteractiveError
+		expected string
+	}{
+		{
+			err:      NonInteractiveError{Action: "launch an editor", Hint: "--content"},
+			expected: "cannot launch an editor in the non-interactive mode. Use --content",
+		},
+		{
+			err:      NonInteractiveError{Action: "prompt for 'which book?'"},
+			expected: "cannot prompt for 'which book?' in the non-interactive mode",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			assert.Equal(t, tc.err.Error(), tc.expected, "message mismatch")
+		})
+	}
+}
+
+func TestPrompts_nonInteractive(t *testing.T) {
+	setInteractive(t, false)
+
+	var dest string
+	_, confirmErr := Confirm("remove this note?", "--yes", false)
+	_, selectErr := PromptSelect("which book?", "", []string{"js", "javascript"})
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected NonInteractiveError
+	}{
+		{
+			name:     "input",
+			err:      PromptInput("email", "--username", &dest),
+			expected: NonInteractiveError{Action: "prompt for 'email'", Hint: "--username"},
+		},
+		{
+			name:     "password",
+			err:      PromptPassword("password", "--password", &dest),
+			expected: NonInteractiveError{Action: "prompt for 'password'", Hint: "--password"},
+		},
+		{
+			name:     "confirm",
+			err:      confirmErr,
+			expected: NonInteractiveError{Action: "prompt for 'remove this note? (y/N)'", Hint: "--yes"},
+		},
+		{
+			name:     "select",
+			err:      selectErr,
+			expected: NonInteractiveError{Action: "prompt for 'which book?'"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, errors.Cause(tc.err), tc.expected, "error mismatch")
+		})
+	}
+
+	assert.Equal(t, dest, "", "destination mismatch")
+}
@@ -0,0 +1,488 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	stdCtx "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// releaseTagPrefix is the prefix of the tags of the CLI releases, such as
+// cli-v1.0.0
+const releaseTagPrefix = "cli-v"
+
+// downloadTimeout is how long a file of a release can take to download
+const downloadTimeout = 5 * time.Minute
+
+// Release is a release of the CLI
+type Release struct {
+	// Version is the version of the release, such as 0.15.0
+	Version string
+	// Assets are the download URLs of the files of the release by their names
+	Assets map[string]string
+}
+
+// Source finds the latest stable release
+type Source interface {
+	Latest(ctx stdCtx.Context) (Release, error)
+}
+
+// Fetcher downloads the file at a URL
+type Fetcher interface {
+	Fetch(ctx stdCtx.Context, url string) ([]byte, error)
+}
+
+// FS is the file system in which the executable is replaced
+type FS interface {
+	// Executable returns the path of the running executable
+	Executable() (string, error)
+	Stat(name string) (os.FileInfo, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+}
+
+// versionFromTag returns the version of the release with the given tag
+func versionFromTag(tag string) string {
+	return strings.TrimPrefix(tag, releaseTagPrefix)
+}
+
+// githubSource finds the latest release on GitHub
+type githubSource struct {
+	client *github.Client
+}
+
+// NewGitHubSource returns a source that finds the latest release with the
+// given GitHub client
+func NewGitHubSource(gh *github.Client) Source {
+	return githubSource{client: gh}
+}
+
+func (s githubSource) Latest(ctx stdCtx.Context) (Release, error) {
+	release, err := fetchLatestStableRelease(ctx, s.client, 1)
+	if err != nil {
+		return Release{}, errors.Wrap(err, "fetching the latest stable release")
+	}
+
+	ret := Release{
+		Version: versionFromTag(release.GetTagName()),
+		Assets:  map[string]string{},
+	}
+	for _, asset := range release.Assets {
+		ret.Assets[asset.GetName()] = asset.GetBrowserDownloadURL()
+	}
+
+	return ret, nil
+}
+
+// manifest is the document that describes the latest release, for a source
+// other than GitHub
+type manifest struct {
+	Version string            `json:"version"`
+	Assets  map[string]string `json:"assets"`
+}
+
+// manifestSource finds the latest release in a manifest
+type manifestSource struct {
+	url     string
+	fetcher Fetcher
+}
+
+// NewManifestSource returns a source that finds the latest release in the
+// manifest at the given URL, which is a JSON object with the version and the
+// download URLs of the files by their names, such as
+// {"version":"0.15.0","assets":{"dnote_0.15.0_checksums.txt":"https://..."}}
+func NewManifestSource(url string, f Fetcher) Source {
+	return manifestSource{url: url, fetcher: f}
+}
+
+func (s manifestSource) Latest(ctx stdCtx.Context) (Release, error) {
+	b, err := s.fetcher.Fetch(ctx, s.url)
+	if err != nil {
+		return Release{}, errors.Wrap(err, "fetching the manifest")
+	}
+
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return Release{}, errors.Wrapf(err, "decoding the manifest at %s", s.url)
+	}
+	if m.Version == "" {
+		return Release{}, errors.Errorf("the manifest at %s has no version", s.url)
+	}
+
+	return Release{Version: strings.TrimPrefix(m.Version, "v"), Assets: m.Assets}, nil
+}
+
+// httpFetcher downloads the files over HTTP
+type httpFetcher struct {
+	client *http.Client
+}
+
+// NewHTTPFetcher returns a fetcher that downloads the files with the given
+// client
+func NewHTTPFetcher(c *http.Client) Fetcher {
+	return httpFetcher{client: c}
+}
+
+func (f httpFetcher) Fetch(ctx stdCtx.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "making a request")
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "downloading %s", url)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("downloading %s: %s", url, res.Status)
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", url)
+	}
+
+	return b, nil
+}
+
+// osFS is the file system of the operating system
+type osFS struct{}
+
+func (osFS) Executable() (string, error) {
+	p, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	// a symlink, such as one made by a package manager, is kept and the file
+	// it points to is replaced
+	return filepath.EvalSymlinks(p)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// parseVersion parses a version in MAJOR.MINOR.PATCH
+func parseVersion(v string) ([3]int, error) {
+	var ret [3]int
+
+	parts := strings.Split(strings.TrimPrefix(v, "v"), ".")
+	if len(parts) != 3 {
+		return ret, errors.Errorf("invalid version '%s'", v)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return ret, errors.Errorf("invalid version '%s'", v)
+		}
+
+		ret[i] = n
+	}
+
+	return ret, nil
+}
+
+// IsNewer tells if the version a is newer than the version b, both of which are
+// in MAJOR.MINOR.PATCH
+func IsNewer(a, b string) (bool, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return false, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] > vb[i], nil
+		}
+	}
+
+	return false, nil
+}
+
+// assetName returns the name of the tarball of a release for a platform
+func assetName(version, goos, goarch string) string {
+	return fmt.Sprintf("dnote_%s_%s_%s.tar.gz", version, goos, goarch)
+}
+
+// checksumsName returns the name of the file with the SHA-256 checksums of
+// the tarballs of a release
+func checksumsName(version string) string {
+	return fmt.Sprintf("dnote_%s_checksums.txt", version)
+}
+
+// binaryName returns the name of the executable in the tarball for a platform
+func binaryName(goos string) string {
+	if goos == "windows" {
+		return "dnote.exe"
+	}
+
+	return "dnote"
+}
+
+// verifyChecksum checks the SHA-256 checksum of the file with the given name
+// against the one listed for it in the checksums, which has a line of the
+// hex digest and the name for each file, as printed by shasum
+func verifyChecksum(data []byte, name string, checksums []byte) error {
+	var want string
+
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			want = strings.ToLower(fields[0])
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "reading the checksums")
+	}
+	if want == "" {
+		return errors.Errorf("no checksum is published for %s", name)
+	}
+
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return errors.Errorf("the checksum of %s does not match. Expected %s but got %s", name, want, got)
+	}
+
+	return nil
+}
+
+// extractBinary returns the file with the given name in the given gzipped
+// tarball
+func extractBinary(tarball []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(tarball))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing the tarball")
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading the tarball")
+		}
+
+		if hdr.Typeflag == tar.TypeReg && path.Base(hdr.Name) == name {
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "extracting %s", name)
+			}
+
+			return b, nil
+		}
+	}
+
+	return nil, errors.Errorf("the tarball has no %s", name)
+}
+
+// replace replaces the executable at the given path with the given binary,
+// keeping its permissions. The binary is written next to the executable and
+// renamed over it, so that a failure never leaves a partially written
+// executable. Windows does not allow replacing a running executable but
+// allows renaming it, so there the running one is moved aside to path.old,
+// which the next upgrade removes. It returns true if dnote must be restarted
+// for the new binary to take effect.
+func replace(fs FS, p string, data []byte, goos string) (bool, error) {
+	info, err := fs.Stat(p)
+	if err != nil {
+		return false, errors.Wrap(err, "reading the executable")
+	}
+
+	staged := p + ".new"
+	if err := fs.WriteFile(staged, data, info.Mode().Perm()); err != nil {
+		return false, errors.Wrap(err, "writing the new executable")
+	}
+
+	if goos != "windows" {
+		if err := fs.Rename(staged, p); err != nil {
+			fs.Remove(staged)
+			return false, errors.Wrap(err, "replacing the executable")
+		}
+
+		return false, nil
+	}
+
+	old := p + ".old"
+	if err := fs.Remove(old); err != nil && !os.IsNotExist(err) {
+		fs.Remove(staged)
+		return false, errors.Wrap(err, "removing the executable left by the last upgrade")
+	}
+	if err := fs.Rename(p, old); err != nil {
+		fs.Remove(staged)
+		return false, errors.Wrap(err, "moving the running executable aside")
+	}
+	if err := fs.Rename(staged, p); err != nil {
+		// put the running executable back so that dnote still runs
+		fs.Rename(old, p)
+		fs.Remove(staged)
+		return false, errors.Wrap(err, "replacing the executable")
+	}
+
+	return true, nil
+}
+
+// Updater checks for the latest release and installs it in place of the
+// running executable
+type Updater struct {
+	Source  Source
+	Fetcher Fetcher
+	FS      FS
+	// GOOS and GOARCH are the platform whose binary is installed
+	GOOS   string
+	GOARCH string
+}
+
+// NewUpdater returns an updater for the platform of the running executable,
+// which finds the latest release in the manifest at the given URL, or on
+// GitHub if it is empty
+func NewUpdater(manifestURL string) Updater {
+	fetcher := NewHTTPFetcher(&http.Client{Timeout: downloadTimeout})
+
+	source := NewGitHubSource(github.NewClient(nil))
+	if manifestURL != "" {
+		source = NewManifestSource(manifestURL, fetcher)
+	}
+
+	return Updater{
+		Source:  source,
+		Fetcher: fetcher,
+		FS:      osFS{},
+		GOOS:    runtime.GOOS,
+		GOARCH:  runtime.GOARCH,
+	}
+}
+
+// Check returns the latest release, and whether it is newer than the given
+// current version
+func (u Updater) Check(ctx stdCtx.Context, current string) (Release, bool, error) {
+	if _, err := parseVersion(current); err != nil {
+		return Release{}, false, errors.Errorf("the current version '%s' is not a release, and cannot be upgraded", current)
+	}
+
+	latest, err := u.Source.Latest(ctx)
+	if err != nil {
+		return Release{}, false, err
+	}
+
+	ok, err := IsNewer(latest.Version, current)
+	if err != nil {
+		return Release{}, false, errors.Wrap(err, "comparing the versions")
+	}
+
+	return latest, ok, nil
+}
+
+// InstallResult is the result of an installation of a release
+type InstallResult struct {
+	// Path is the path of the replaced executable
+	Path string
+	// Restart is true if dnote must be restarted for the new version to take
+	// effect
+	Restart bool
+}
+
+// Install downloads the binary of the given release for the platform of the
+// updater, verifies it against the published SHA-256 checksum, and replaces
+// the running executable with it. The executable is left as it is if any of
+// the steps fails.
+func (u Updater) Install(ctx stdCtx.Context, r Release) (InstallResult, error) {
+	name := assetName(r.Version, u.GOOS, u.GOARCH)
+	url, ok := r.Assets[name]
+	if !ok {
+		return InstallResult{}, errors.Errorf("the release %s has no binary for %s/%s", r.Version, u.GOOS, u.GOARCH)
+	}
+	checksumsURL, ok := r.Assets[checksumsName(r.Version)]
+	if !ok {
+		return InstallResult{}, errors.Errorf("the release %s has no checksums to verify the binary", r.Version)
+	}
+
+	tarball, err := u.Fetcher.Fetch(ctx, url)
+	if err != nil {
+		return InstallResult{}, errors.Wrap(err, "downloading the binary")
+	}
+	checksums, err := u.Fetcher.Fetch(ctx, checksumsURL)
+	if err != nil {
+		return InstallResult{}, errors.Wrap(err, "downloading the checksums")
+	}
+	if err := verifyChecksum(tarball, name, checksums); err != nil {
+		return InstallResult{}, err
+	}
+
+	bin, err := extractBinary(tarball, binaryName(u.GOOS))
+	if err != nil {
+		return InstallResult{}, err
+	}
+
+	p, err := u.FS.Executable()
+	if err != nil {
+		return InstallResult{}, errors.Wrap(err, "finding the executable")
+	}
+
+	restart, err := replace(u.FS, p, bin, u.GOOS)
+	if err != nil {
+		return InstallResult{}, err
+	}
+
+	return InstallResult{Path: p, Restart: restart}, nil
+}
@@ -0,0 +1,440 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	stdCtx "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+type fakeSource struct {
+	release Release
+}
+
+func (s fakeSource) Latest(ctx stdCtx.Context) (Release, error) {
+	return s.release, nil
+}
+
+// fakeFetcher serves the files by their URLs
+type fakeFetcher map[string][]byte
+
+func (f fakeFetcher) Fetch(ctx stdCtx.Context, url string) ([]byte, error) {
+	b, ok := f[url]
+	if !ok {
+		return nil, errors.Errorf("downloading %s: 404 Not Found", url)
+	}
+
+	return b, nil
+}
+
+type fakeFile struct {
+	data []byte
+	mode os.FileMode
+}
+
+type fakeFileInfo struct {
+	name string
+	file fakeFile
+}
+
+func (i fakeFileInfo) Name() string       { return i.name }
+func (i fakeFileInfo) Size() int64        { return int64(len(i.file.data)) }
+func (i fakeFileInfo) Mode() os.FileMode  { return i.file.mode }
+func (i fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i fakeFileInfo) IsDir() bool        { return false }
+func (i fakeFileInfo) Sys() interface{}   { return nil }
+
+// fakeFS is a file system in memory. A rename of a path in failRenames fails.
+type fakeFS struct {
+	exe         string
+	files       map[string]fakeFile
+	failRenames map[string]bool
+}
+
+func newFakeFS(exe string, data string) *fakeFS {
+	return &fakeFS{
+		exe:         exe,
+		files:       map[string]fakeFile{exe: {data: []byte(data), mode: 0755}},
+		failRenames: map[string]bool{},
+	}
+}
+
+func (fs *fakeFS) Executable() (string, error) {
+	return fs.exe, nil
+}
+
+func (fs *fakeFS) Stat(name string) (os.FileInfo, error) {
+	f, ok := fs.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	return fakeFileInfo{name: name, file: f}, nil
+}
+
+func (fs *fakeFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	fs.files[name] = fakeFile{data: data, mode: perm}
+	return nil
+}
+
+func (fs *fakeFS) Rename(oldpath, newpath string) error {
+	f, ok := fs.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if fs.failRenames[oldpath] {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+	}
+
+	delete(fs.files, oldpath)
+	fs.files[newpath] = f
+	return nil
+}
+
+func (fs *fakeFS) Remove(name string) error {
+	if _, ok := fs.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	delete(fs.files, name)
+	return nil
+}
+
+// contents returns the content of each file by its name
+func (fs *fakeFS) contents() map[string]string {
+	ret := map[string]string{}
+	for name, f := range fs.files {
+		ret[name] = string(f.data)
+	}
+
+	return ret
+}
+
+// makeTarball returns a gzipped tarball of the given files, laid out as the
+// release builds do
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for name, content := range files {
+		hdr := &tar.Header{Name: "./" + name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(errors.Wrap(err, "writing a header"))
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(errors.Wrap(err, "writing a file"))
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the tarball"))
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the gzip writer"))
+	}
+
+	return buf.Bytes()
+}
+
+func checksumLine(data []byte, name string) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name)
+}
+
+func TestIsNewer(t *testing.T) {
+	testCases := []struct {
+		a           string
+		b           string
+		expected    bool
+		expectedErr bool
+	}{
+		{a: "0.15.0", b: "0.14.2", expected: true},
+		{a: "0.14.10", b: "0.14.9", expected: true},
+		{a: "1.0.0", b: "0.99.99", expected: true},
+		{a: "0.14.2", b: "0.14.2", expected: false},
+		{a: "0.14.1", b: "0.14.2", expected: false},
+		{a: "v0.15.0", b: "0.14.2", expected: true},
+		{a: "0.15.0", b: "master", expectedErr: true},
+		{a: "0.15", b: "0.14.2", expectedErr: true},
+		{a: "0.15.0-beta", b: "0.14.2", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s", tc.a, tc.b), func(t *testing.T) {
+			got, err := IsNewer(tc.a, tc.b)
+
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+			assert.Equal(t, got, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("tarball")
+	other := []byte("other")
+
+	testCases := []struct {
+		name        string
+		checksums   string
+		expectedErr string
+	}{
+		{
+			name:      "match",
+			checksums: checksumLine(other, "dnote_0.15.0_darwin_amd64.tar.gz") + checksumLine(data, "dnote_0.15.0_linux_amd64.tar.gz"),
+		},
+		{
+			name:      "binary mode",
+			checksums: strings.Replace(checksumLine(data, "dnote_0.15.0_linux_amd64.tar.gz"), "  ", " *", 1),
+		},
+		{
+			name:        "mismatch",
+			checksums:   checksumLine(other, "dnote_0.15.0_linux_amd64.tar.gz"),
+			expectedErr: "the checksum of dnote_0.15.0_linux_amd64.tar.gz does not match",
+		},
+		{
+			name:        "missing",
+			checksums:   checksumLine(data, "dnote_0.15.0_darwin_amd64.tar.gz"),
+			expectedErr: "no checksum is published for dnote_0.15.0_linux_amd64.tar.gz",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyChecksum(data, "dnote_0.15.0_linux_amd64.tar.gz", []byte(tc.checksums))
+
+			if tc.expectedErr == "" {
+				assert.Equal(t, err, nil, "error mismatch")
+			} else if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Fatalf("expected an error containing %q but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestExtractBinary(t *testing.T) {
+	tarball := makeTarball(t, map[string]string{"README.md": "readme", "dnote": "binary"})
+
+	got, err := extractBinary(tarball, "dnote")
+	assert.Equal(t, err, nil, "error mismatch")
+	assert.Equal(t, string(got), "binary", "binary mismatch")
+
+	_, err = extractBinary(tarball, "dnote.exe")
+	assert.NotEqual(t, err, nil, "error mismatch for a missing binary")
+
+	_, err = extractBinary([]byte("not a tarball"), "dnote")
+	assert.NotEqual(t, err, nil, "error mismatch for an invalid tarball")
+}
+
+func TestReplace(t *testing.T) {
+	t.Run("unix", func(t *testing.T) {
+		fs := newFakeFS("/usr/local/bin/dnote", "old")
+
+		restart, err := replace(fs, fs.exe, []byte("new"), "linux")
+
+		assert.Equal(t, err, nil, "error mismatch")
+		assert.Equal(t, restart, false, "restart mismatch")
+		assert.DeepEqual(t, fs.contents(), map[string]string{"/usr/local/bin/dnote": "new"}, "files mismatch")
+		assert.Equal(t, fs.files["/usr/local/bin/dnote"].mode, os.FileMode(0755), "mode mismatch")
+	})
+
+	t.Run("unix rename fails", func(t *testing.T) {
+		fs := newFakeFS("/usr/local/bin/dnote", "old")
+		fs.failRenames["/usr/local/bin/dnote.new"] = true
+
+		_, err := replace(fs, fs.exe, []byte("new"), "linux")
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+		assert.DeepEqual(t, fs.contents(), map[string]string{"/usr/local/bin/dnote": "old"}, "files mismatch")
+	})
+
+	t.Run("windows", func(t *testing.T) {
+		fs := newFakeFS(`C:\dnote\dnote.exe`, "old")
+		// left by the last upgrade
+		fs.files[`C:\dnote\dnote.exe.old`] = fakeFile{data: []byte("older")}
+
+		restart, err := replace(fs, fs.exe, []byte("new"), "windows")
+
+		assert.Equal(t, err, nil, "error mismatch")
+		assert.Equal(t, restart, true, "restart mismatch")
+		assert.DeepEqual(t, fs.contents(), map[string]string{
+			`C:\dnote\dnote.exe`:     "new",
+			`C:\dnote\dnote.exe.old`: "old",
+		}, "files mismatch")
+	})
+
+	t.Run("windows rename fails", func(t *testing.T) {
+		fs := newFakeFS(`C:\dnote\dnote.exe`, "old")
+		fs.failRenames[`C:\dnote\dnote.exe.new`] = true
+
+		_, err := replace(fs, fs.exe, []byte("new"), "windows")
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+		assert.DeepEqual(t, fs.contents(), map[string]string{`C:\dnote\dnote.exe`: "old"}, "files mismatch")
+	})
+}
+
+func newTestRelease(t *testing.T, binary string) (Release, fakeFetcher) {
+	tarball := makeTarball(t, map[string]string{"dnote": binary, "README.md": "readme"})
+
+	release := Release{
+		Version: "0.15.0",
+		Assets: map[string]string{
+			"dnote_0.15.0_linux_amd64.tar.gz": "https://example.com/dnote_0.15.0_linux_amd64.tar.gz",
+			"dnote_0.15.0_checksums.txt":      "https://example.com/dnote_0.15.0_checksums.txt",
+		},
+	}
+	fetcher := fakeFetcher{
+		"https://example.com/dnote_0.15.0_linux_amd64.tar.gz": tarball,
+		"https://example.com/dnote_0.15.0_checksums.txt":      []byte(checksumLine(tarball, "dnote_0.15.0_linux_amd64.tar.gz")),
+	}
+
+	return release, fetcher
+}
+
+func TestUpdaterCheck(t *testing.T) {
+	testCases := []struct {
+		current     string
+		expected    bool
+		expectedErr bool
+	}{
+		{current: "0.14.2", expected: true},
+		{current: "0.15.0", expected: false},
+		{current: "0.16.0", expected: false},
+		{current: "master", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.current, func(t *testing.T) {
+			u := Updater{Source: fakeSource{release: Release{Version: "0.15.0"}}}
+
+			got, ok, err := u.Check(stdCtx.Background(), tc.current)
+
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+			assert.Equal(t, ok, tc.expected, "result mismatch")
+			if err == nil {
+				assert.Equal(t, got.Version, "0.15.0", "version mismatch")
+			}
+		})
+	}
+}
+
+func TestUpdaterInstall(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		release, fetcher := newTestRelease(t, "new")
+		fs := newFakeFS("/usr/local/bin/dnote", "old")
+		u := Updater{Fetcher: fetcher, FS: fs, GOOS: "linux", GOARCH: "amd64"}
+
+		got, err := u.Install(stdCtx.Background(), release)
+
+		assert.Equal(t, err, nil, "error mismatch")
+		assert.Equal(t, got, InstallResult{Path: "/usr/local/bin/dnote"}, "result mismatch")
+		assert.DeepEqual(t, fs.contents(), map[string]string{"/usr/local/bin/dnote": "new"}, "files mismatch")
+	})
+
+	t.Run("tampered binary", func(t *testing.T) {
+		release, fetcher := newTestRelease(t, "new")
+		fetcher["https://example.com/dnote_0.15.0_linux_amd64.tar.gz"] = makeTarball(t, map[string]string{"dnote": "evil"})
+		fs := newFakeFS("/usr/local/bin/dnote", "old")
+		u := Updater{Fetcher: fetcher, FS: fs, GOOS: "linux", GOARCH: "amd64"}
+
+		_, err := u.Install(stdCtx.Background(), release)
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+		assert.DeepEqual(t, fs.contents(), map[string]string{"/usr/local/bin/dnote": "old"}, "files mismatch")
+	})
+
+	t.Run("no binary for the platform", func(t *testing.T) {
+		release, fetcher := newTestRelease(t, "new")
+		fs := newFakeFS("/usr/local/bin/dnote", "old")
+		u := Updater{Fetcher: fetcher, FS: fs, GOOS: "linux", GOARCH: "riscv64"}
+
+		_, err := u.Install(stdCtx.Background(), release)
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+		assert.DeepEqual(t, fs.contents(), map[string]string{"/usr/local/bin/dnote": "old"}, "files mismatch")
+	})
+
+	t.Run("no checksums", func(t *testing.T) {
+		release, fetcher := newTestRelease(t, "new")
+		delete(release.Assets, "dnote_0.15.0_checksums.txt")
+		fs := newFakeFS("/usr/local/bin/dnote", "old")
+		u := Updater{Fetcher: fetcher, FS: fs, GOOS: "linux", GOARCH: "amd64"}
+
+		_, err := u.Install(stdCtx.Background(), release)
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+		assert.DeepEqual(t, fs.contents(), map[string]string{"/usr/local/bin/dnote": "old"}, "files mismatch")
+	})
+}
+
+func TestManifestSource(t *testing.T) {
+	fetcher := fakeFetcher{
+		"https://example.com/latest.json": []byte(`{"version":"v0.15.0","assets":{"dnote_0.15.0_checksums.txt":"https://example.com/checksums.txt"}}`),
+		"https://example.com/empty.json":  []byte(`{}`),
+	}
+
+	got, err := NewManifestSource("https://example.com/latest.json", fetcher).Latest(stdCtx.Background())
+	assert.Equal(t, err, nil, "error mismatch")
+	assert.DeepEqual(t, got, Release{
+		Version: "0.15.0",
+		Assets:  map[string]string{"dnote_0.15.0_checksums.txt": "https://example.com/checksums.txt"},
+	}, "release mismatch")
+
+	_, err = NewManifestSource("https://example.com/empty.json", fetcher).Latest(stdCtx.Background())
+	assert.NotEqual(t, err, nil, "error mismatch for a manifest without a version")
+}
+
+func TestGitHubSource(t *testing.T) {
+	tagBeta := "cli-v0.16.0-beta"
+	tag := "cli-v0.15.0"
+	prerelease := true
+	name := "dnote_0.15.0_linux_amd64.tar.gz"
+	assetURL := "https://github.com/dnote/dnote/releases/download/cli-v0.15.0/dnote_0.15.0_linux_amd64.tar.gz"
+
+	gh, mux := setupGithubClient(t)
+	mux.HandleFunc("/repos/dnote/dnote/releases", func(w http.ResponseWriter, r *http.Request) {
+		releases := []*github.RepositoryRelease{
+			{TagName: &tagBeta, Prerelease: &prerelease},
+			{TagName: &tag, Assets: []github.ReleaseAsset{{Name: &name, BrowserDownloadURL: &assetURL}}},
+		}
+		if err := json.NewEncoder(w).Encode(releases); err != nil {
+			t.Fatal(errors.Wrap(err, "responding with mock releases"))
+		}
+	})
+
+	got, err := NewGitHubSource(gh).Latest(stdCtx.Background())
+	assert.Equal(t, err, nil, "error mismatch")
+	assert.DeepEqual(t, got, Release{Version: "0.15.0", Assets: map[string]string{name: assetURL}}, "release mismatch")
+}
@@ -0,0 +1,103 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	"database/sql"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/i18n"
+	"github.com/pkg/errors"
+)
+
+// nudgeInterval is a day
+var nudgeInterval int64 = 86400
+
+// WarnUnsupported warns if this version of dnote is older than the given
+// minimum version supported by the server. It does nothing if either is not a
+// release version.
+func WarnUnsupported(ctx context.DnoteCtx, minVersion string) {
+	if minVersion == "" {
+		return
+	}
+
+	older, err := IsNewer(minVersion, ctx.Version)
+	if err != nil || !older {
+		return
+	}
+
+	ctx.Logger.Warnf("%s", i18n.T("upgrade.unsupported", ctx.Version, minVersion))
+}
+
+// SaveLatest records the latest version advertised by the server, which Nudge
+// tells about
+func SaveLatest(db *database.DB, version string) error {
+	if version == "" {
+		return nil
+	}
+
+	if err := database.UpsertSystem(db, consts.SystemLatestVersion, version); err != nil {
+		return errors.Wrap(err, "saving the latest version")
+	}
+
+	return nil
+}
+
+// Nudge prints a line if the latest version recorded by SaveLatest is newer
+// than this one, at most once a day. It does nothing if the nudge is turned
+// off in the config or if this version is not a release.
+func Nudge(ctx context.DnoteCtx) error {
+	if !ctx.UpgradeNudge {
+		return nil
+	}
+
+	var latest string
+	err := ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLatestVersion).Scan(&latest)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "getting the latest version")
+	}
+
+	newer, err := IsNewer(latest, ctx.Version)
+	if err != nil || !newer {
+		return nil
+	}
+
+	var lastNudge int64
+	err = ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastUpgradeNudge).Scan(&lastNudge)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "getting the last upgrade nudge")
+	}
+
+	now := ctx.Clock.Now().Unix()
+	if now-lastNudge <= nudgeInterval {
+		return nil
+	}
+
+	ctx.Logger.Printf("%s", i18n.T("upgrade.nudge", latest, ctx.Version))
+
+	if err := database.UpsertSystem(ctx.DB, consts.SystemLastUpgradeNudge, now); err != nil {
+		return errors.Wrap(err, "updating the last upgrade nudge")
+	}
+
+	return nil
+}
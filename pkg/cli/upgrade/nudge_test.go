@@ -0,0 +1,136 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+func TestNudge(t *testing.T) {
+	now := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name              string
+		version           string
+		latest            string
+		lastNudge         int64
+		disabled          bool
+		expected          bool
+		expectedLastNudge int64
+	}{
+		{name: "never nudged", version: "0.10.1", latest: "0.12.0", expected: true, expectedLastNudge: now.Unix()},
+		{name: "nudged within a day", version: "0.10.1", latest: "0.12.0", lastNudge: now.Unix() - nudgeInterval, expected: false, expectedLastNudge: now.Unix() - nudgeInterval},
+		{name: "nudged a day ago", version: "0.10.1", latest: "0.12.0", lastNudge: now.Unix() - nudgeInterval - 1, expected: true, expectedLastNudge: now.Unix()},
+		{name: "up to date", version: "0.12.0", latest: "0.12.0", expected: false},
+		{name: "newer than the latest", version: "0.13.0", latest: "0.12.0", expected: false},
+		{name: "no latest version", version: "0.10.1", expected: false},
+		{name: "development build", version: "master", latest: "0.12.0", expected: false},
+		{name: "disabled", version: "0.10.1", latest: "0.12.0", disabled: true, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+			defer database.TeardownTestDB(t, db)
+
+			if tc.latest != "" {
+				database.MustExec(t, "inserting the latest version", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLatestVersion, tc.latest)
+			}
+			if tc.lastNudge != 0 {
+				database.MustExec(t, "inserting the last nudge", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastUpgradeNudge, tc.lastNudge)
+			}
+
+			var out, errOut bytes.Buffer
+			c := clock.NewMock()
+			c.SetNow(now)
+			ctx := context.DnoteCtx{DB: db, Clock: c, Logger: log.NewLogger(&out, &errOut), Version: tc.version, UpgradeNudge: !tc.disabled}
+
+			if err := Nudge(ctx); err != nil {
+				t.Fatal(errors.Wrap(err, "nudging"))
+			}
+
+			assert.Equal(t, strings.Contains(out.String(), "dnote 0.12.0 is available, you have 0.10.1 — run dnote upgrade"), tc.expected, "nudge mismatch")
+
+			var lastNudge int64
+			err := db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastUpgradeNudge).Scan(&lastNudge)
+			if err != nil && err != sql.ErrNoRows {
+				t.Fatal(errors.Wrap(err, "getting the last nudge"))
+			}
+			assert.Equal(t, lastNudge, tc.expectedLastNudge, "last nudge mismatch")
+		})
+	}
+}
+
+func TestWarnUnsupported(t *testing.T) {
+	testCases := []struct {
+		version    string
+		minVersion string
+		expected   bool
+	}{
+		{version: "0.9.3", minVersion: "0.10.0", expected: true},
+		{version: "0.10.0", minVersion: "0.10.0", expected: false},
+		{version: "0.10.1", minVersion: "0.10.0", expected: false},
+		{version: "0.9.3", minVersion: "", expected: false},
+		{version: "master", minVersion: "0.10.0", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.version+" "+tc.minVersion, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			ctx := context.DnoteCtx{Logger: log.NewLogger(&out, &errOut), Version: tc.version}
+
+			WarnUnsupported(ctx, tc.minVersion)
+
+			assert.Equal(t, strings.Contains(out.String(), "dnote "+tc.version+" is no longer supported by the server"), tc.expected, "warning mismatch")
+		})
+	}
+}
+
+func TestSaveLatest(t *testing.T) {
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	if err := SaveLatest(db, ""); err != nil {
+		t.Fatal(errors.Wrap(err, "saving an empty version"))
+	}
+	var count int
+	database.MustScan(t, "counting the latest version", db.QueryRow("SELECT count(*) FROM system WHERE key = ?", consts.SystemLatestVersion), &count)
+	assert.Equal(t, count, 0, "count mismatch")
+
+	for _, v := range []string{"0.12.0", "0.13.0"} {
+		if err := SaveLatest(db, v); err != nil {
+			t.Fatal(errors.Wrapf(err, "saving %s", v))
+		}
+	}
+	var latest string
+	database.MustScan(t, "getting the latest version", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLatestVersion), &latest)
+	assert.Equal(t, latest, "0.13.0", "latest version mismatch")
+}
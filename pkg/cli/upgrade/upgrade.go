@@ -22,10 +22,10 @@ import (
 	stdCtx "context"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/i18n"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/ui"
 	"github.com/google/go-github/github"
@@ -45,7 +45,7 @@ func shouldCheckUpdate(ctx context.DnoteCtx) (bool, error) {
 		return false, errors.Wrap(err, "getting last_udpate")
 	}
 
-	now := time.Now().Unix()
+	now := ctx.Clock.Now().Unix()
 
 	return now-lastUpgrade > upgradeInterval, nil
 }
@@ -53,7 +53,7 @@ func shouldCheckUpdate(ctx context.DnoteCtx) (bool, error) {
 func touchLastUpgrade(ctx context.DnoteCtx) error {
 	db := ctx.DB
 
-	now := time.Now().Unix()
+	now := ctx.Clock.Now().Unix()
 	_, err := db.Exec("UPDATE system SET value = ? WHERE key = ?", now, consts.SystemLastUpgrade)
 	if err != nil {
 		return errors.Wrap(err, "updating last_upgrade")
@@ -62,13 +62,15 @@ func touchLastUpgrade(ctx context.DnoteCtx) error {
 	return nil
 }
 
-func fetchLatestStableTag(gh *github.Client, page int) (string, error) {
+// fetchLatestStableRelease returns the latest CLI release that is not a
+// pre-release, looking from the given page of the releases
+func fetchLatestStableRelease(ctx stdCtx.Context, gh *github.Client, page int) (*github.RepositoryRelease, error) {
 	params := github.ListOptions{
 		Page: page,
 	}
-	releases, resp, err := gh.Repositories.ListReleases(stdCtx.Background(), "dnote", "dnote", &params)
+	releases, resp, err := gh.Repositories.ListReleases(ctx, "dnote", "dnote", &params)
 	if err != nil {
-		return "", errors.Wrapf(err, "fetching releases page %d", page)
+		return nil, errors.Wrapf(err, "fetching releases page %d", page)
 	}
 
 	for _, release := range releases {
@@ -76,19 +78,28 @@ func fetchLatestStableTag(gh *github.Client, page int) (string, error) {
 		isStable := !release.GetPrerelease()
 
 		if strings.HasPrefix(tag, "cli-") && isStable {
-			return tag, nil
+			return release, nil
 		}
 	}
 
 	if page == resp.LastPage {
-		return "", errors.New("No CLI release was found")
+		return nil, errors.New("No CLI release was found")
+	}
+
+	return fetchLatestStableRelease(ctx, gh, page+1)
+}
+
+func fetchLatestStableTag(gh *github.Client, page int) (string, error) {
+	release, err := fetchLatestStableRelease(stdCtx.Background(), gh, page)
+	if err != nil {
+		return "", err
 	}
 
-	return fetchLatestStableTag(gh, page+1)
+	return release.GetTagName(), nil
 }
 
 func checkVersion(ctx context.DnoteCtx) error {
-	log.Infof("current version is %s\n", ctx.Version)
+	log.Info(i18n.T("upgrade.current", ctx.Version))
 
 	// Fetch the latest version
 	gh := github.NewClient(nil)
@@ -97,21 +108,25 @@ func checkVersion(ctx context.DnoteCtx) error {
 		return errors.Wrap(err, "fetching the latest stable release")
 	}
 
-	// releases are tagged in a form of cli-v1.0.0
-	latestVersion := latestTag[5:]
-	log.Infof("latest version is %s\n", latestVersion)
+	latestVersion := versionFromTag(latestTag)
+	log.Info(i18n.T("upgrade.latest", latestVersion))
 
 	if latestVersion == ctx.Version {
-		log.Success("you are up-to-date\n\n")
+		log.Success(i18n.T("upgrade.up_to_date"))
 	} else {
-		log.Infof("to upgrade, see https://github.com/dnote/dnote\n")
+		log.Info(i18n.T("upgrade.how"))
 	}
 
 	return nil
 }
 
-// Check triggers update if needed
+// Check triggers update if needed. It does nothing in the non-interactive
+// mode, so that the prompt is left for the next interactive command.
 func Check(ctx context.DnoteCtx) error {
+	if !ui.IsInteractive() {
+		return nil
+	}
+
 	shouldCheck, err := shouldCheckUpdate(ctx)
 	if err != nil {
 		return errors.Wrap(err, "checking if dnote should check update")
@@ -126,7 +141,7 @@ func Check(ctx context.DnoteCtx) error {
 	}
 
 	fmt.Printf("\n")
-	willCheck, err := ui.Confirm("check for upgrade?", true)
+	willCheck, err := ui.Confirm(i18n.T("upgrade.confirm"), "", true)
 	if err != nil {
 		return errors.Wrap(err, "getting user confirmation")
 	}
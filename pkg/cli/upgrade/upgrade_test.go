@@ -25,8 +25,13 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/clock"
 	"github.com/google/go-github/github"
 	"github.com/pkg/errors"
 )
@@ -173,3 +178,71 @@ func TestFetchLatestStableTag_paginated(t *testing.T) {
 	// test
 	assert.Equal(t, got, tagCLI0_1_1, "result mismatch")
 }
+
+func TestShouldCheckUpdate(t *testing.T) {
+	now := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
+
+	testCases := []struct {
+		lastUpgrade int64
+		expected    bool
+	}{
+		{
+			lastUpgrade: now.Unix(),
+			expected:    false,
+		},
+		{
+			lastUpgrade: now.Unix() - upgradeInterval,
+			expected:    false,
+		},
+		{
+			lastUpgrade: now.Unix() - upgradeInterval - 1,
+			expected:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("last upgrade %d", tc.lastUpgrade), func(t *testing.T) {
+			// set up
+			db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+			defer database.TeardownTestDB(t, db)
+
+			database.MustExec(t, "inserting last upgrade", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastUpgrade, tc.lastUpgrade)
+
+			c := clock.NewMock()
+			c.SetNow(now)
+			ctx := context.DnoteCtx{DB: db, Clock: c}
+
+			// execute
+			got, err := shouldCheckUpdate(ctx)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			// test
+			assert.Equal(t, got, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestTouchLastUpgrade(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer database.TeardownTestDB(t, db)
+
+	database.MustExec(t, "inserting last upgrade", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastUpgrade, 1)
+
+	c := clock.NewMock()
+	now := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
+	c.SetNow(now)
+	ctx := context.DnoteCtx{DB: db, Clock: c}
+
+	// execute
+	if err := touchLastUpgrade(ctx); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	var lastUpgrade int64
+	database.MustScan(t, "getting last upgrade", db.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastUpgrade), &lastUpgrade)
+	assert.Equal(t, lastUpgrade, now.Unix(), "last upgrade mismatch")
+}
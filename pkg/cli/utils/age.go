@@ -0,0 +1,68 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ParseAge parses an age in days, such as 30d, or in a Go duration, such as
+// 12h. An empty age is zero.
+func ParseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	var ret time.Duration
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.Errorf("invalid age '%s'. Use a number of days such as 30d, or a duration such as 12h", s)
+		}
+
+		ret = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, errors.Errorf("invalid age '%s'. Use a number of days such as 30d, or a duration such as 12h", s)
+		}
+
+		ret = d
+	}
+
+	if ret < 0 {
+		return 0, errors.Errorf("invalid age '%s'. It cannot be negative", s)
+	}
+
+	return ret, nil
+}
+
+// FormatAge formats an age as ParseAge parses it, in days if it is a whole
+// number of days
+func FormatAge(d time.Duration) string {
+	day := 24 * time.Hour
+	if d > 0 && d%day == 0 {
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	}
+
+	return d.String()
+}
@@ -0,0 +1,75 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestParseAge(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    time.Duration
+		expectedErr bool
+	}{
+		{input: "", expected: 0},
+		{input: "30d", expected: 30 * 24 * time.Hour},
+		{input: "0d", expected: 0},
+		{input: "12h", expected: 12 * time.Hour},
+		{input: "1h30m", expected: 90 * time.Minute},
+		{input: "d", expectedErr: true},
+		{input: "30", expectedErr: true},
+		{input: "-1d", expectedErr: true},
+		{input: "1w", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseAge(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+		})
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	testCases := []struct {
+		input    time.Duration
+		expected string
+	}{
+		{input: 0, expected: "0s"},
+		{input: 90 * 24 * time.Hour, expected: "90d"},
+		{input: 12 * time.Hour, expected: "12h0m0s"},
+		{input: 36 * time.Hour, expected: "36h0m0s"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			got := FormatAge(tc.input)
+			assert.Equal(t, got, tc.expected, "result mismatch")
+
+			parsed, err := ParseAge(got)
+			assert.Equal(t, err, nil, "parse error mismatch")
+			assert.Equal(t, parsed, tc.input, "parsed mismatch")
+		})
+	}
+}
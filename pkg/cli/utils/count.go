@@ -0,0 +1,71 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// isIdeograph checks if the given character is written without spaces between
+// the words, in which case each character counts as a word
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// CountWords returns the number of the words in the given text. The words are
+// separated by the Unicode whitespace, and each Chinese or Japanese character
+// counts as a word.
+func CountWords(s string) int {
+	var ret int
+	var inWord bool
+
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			inWord = false
+		case isIdeograph(r):
+			ret++
+			inWord = false
+		case !inWord:
+			ret++
+			inWord = true
+		}
+	}
+
+	return ret
+}
+
+// CountChars returns the number of the characters in the given text, which is
+// the number of the runes rather than the bytes. It matches the length of the
+// text in SQLite.
+func CountChars(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// CountLines returns the number of the lines in the given text. A trailing
+// newline does not start a new line.
+func CountLines(s string) int {
+	if s == "" {
+		return 0
+	}
+
+	return strings.Count(strings.TrimSuffix(s, "\n"), "\n") + 1
+}
@@ -0,0 +1,96 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestCountWords(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int
+	}{
+		{"", 0},
+		{"   ", 0},
+		{"hello", 1},
+		{"hello world", 2},
+		{"  hello\n\tworld  ", 2},
+		// no-break and ideographic spaces
+		{"hello\u00a0world\u3000again", 3},
+		{"don't-stop me", 2},
+		{"日本語", 3},
+		{"日本語のテキスト", 8},
+		{"Go言語 is fun", 5},
+		{"안녕하세요 세계", 2},
+		{"🎉", 1},
+		{"party 🎉🎉🎉", 2},
+		{"👨‍👩‍👧 family", 2},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			assert.Equal(t, CountWords(tc.input), tc.expected, "word count mismatch")
+		})
+	}
+}
+
+func TestCountChars(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"héllo", 5},
+		{"日本語", 3},
+		{"🎉", 1},
+		{"hi 🎉", 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			assert.Equal(t, CountChars(tc.input), tc.expected, "char count mismatch")
+		})
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int
+	}{
+		{"", 0},
+		{"one", 1},
+		{"one\n", 1},
+		{"one\ntwo", 2},
+		{"one\ntwo\n", 2},
+		{"one\n\nthree", 3},
+		{"\n", 1},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			assert.Equal(t, CountLines(tc.input), tc.expected, "line count mismatch")
+		})
+	}
+}
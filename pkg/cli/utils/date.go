@@ -0,0 +1,80 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DateLayout is the layout of a date in a date expression
+const DateLayout = "2006-01-02"
+
+// The keywords of a date expression
+const (
+	DateToday     = "today"
+	DateYesterday = "yesterday"
+)
+
+// IsDateExpr tells if the given string is a date expression, that is today,
+// yesterday or a date in YYYY-MM-DD
+func IsDateExpr(s string) bool {
+	if s == DateToday || s == DateYesterday {
+		return true
+	}
+
+	_, err := time.Parse(DateLayout, s)
+	return err == nil
+}
+
+// ParseDay parses a date expression and returns the start of the day in the
+// location of now. today and yesterday are the days of now and of the day
+// before it.
+func ParseDay(s string, now time.Time) (time.Time, error) {
+	y, m, d := now.Date()
+
+	switch s {
+	case DateToday:
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), nil
+	case DateYesterday:
+		// the date is moved rather than the time, so that a day that is not 24
+		// hours long because of a daylight saving change is not skipped
+		return time.Date(y, m, d-1, 0, 0, 0, 0, now.Location()), nil
+	}
+
+	t, err := time.ParseInLocation(DateLayout, s, now.Location())
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid date '%s'. Use today, yesterday or a date in YYYY-MM-DD", s)
+	}
+
+	return t, nil
+}
+
+// DayRange returns the start of the day of the given time and the start of
+// the next day in its location, which can be 23 or 25 hours apart on the day
+// of a daylight saving change
+func DayRange(t time.Time) (time.Time, time.Time) {
+	y, m, d := t.Date()
+
+	start := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	end := time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+
+	return start, end
+}
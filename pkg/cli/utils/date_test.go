@@ -0,0 +1,157 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(errors.Wrapf(err, "loading the location %s", name))
+	}
+
+	return loc
+}
+
+func TestIsDateExpr(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected bool
+	}{
+		{input: "today", expected: true},
+		{input: "yesterday", expected: true},
+		{input: "2024-06-01", expected: true},
+		{input: "Today", expected: false},
+		{input: "tomorrow", expected: false},
+		{input: "2024-6-1", expected: false},
+		{input: "2024-02-30", expected: false},
+		{input: "12", expected: false},
+		{input: "", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			assert.Equal(t, IsDateExpr(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestParseDay(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+
+	testCases := []struct {
+		name        string
+		input       string
+		now         time.Time
+		expected    time.Time
+		expectedErr bool
+	}{
+		{
+			name:     "today",
+			input:    "today",
+			now:      time.Date(2024, time.June, 1, 15, 4, 5, 0, ny),
+			expected: time.Date(2024, time.June, 1, 0, 0, 0, 0, ny),
+		},
+		{
+			name:     "yesterday",
+			input:    "yesterday",
+			now:      time.Date(2024, time.June, 1, 15, 4, 5, 0, ny),
+			expected: time.Date(2024, time.May, 31, 0, 0, 0, 0, ny),
+		},
+		{
+			name:     "yesterday in the new year",
+			input:    "yesterday",
+			now:      time.Date(2024, time.January, 1, 0, 0, 0, 0, ny),
+			expected: time.Date(2023, time.December, 31, 0, 0, 0, 0, ny),
+		},
+		{
+			// 24 hours before is still the 3rd, which is 25 hours long
+			name:     "yesterday after the end of daylight saving time",
+			input:    "yesterday",
+			now:      time.Date(2024, time.November, 4, 0, 30, 0, 0, ny),
+			expected: time.Date(2024, time.November, 3, 0, 0, 0, 0, ny),
+		},
+		{
+			// 24 hours before is the 9th, as the 10th is 23 hours long
+			name:     "yesterday after the start of daylight saving time",
+			input:    "yesterday",
+			now:      time.Date(2024, time.March, 11, 23, 30, 0, 0, ny),
+			expected: time.Date(2024, time.March, 10, 0, 0, 0, 0, ny),
+		},
+		{
+			name:     "date",
+			input:    "2024-06-01",
+			now:      time.Date(2024, time.June, 10, 0, 0, 0, 0, ny),
+			expected: time.Date(2024, time.June, 1, 0, 0, 0, 0, ny),
+		},
+		{
+			name:        "invalid date",
+			input:       "06/01/2024",
+			now:         time.Date(2024, time.June, 10, 0, 0, 0, 0, ny),
+			expectedErr: true,
+		},
+		{
+			name:        "unknown keyword",
+			input:       "tomorrow",
+			now:         time.Date(2024, time.June, 10, 0, 0, 0, 0, ny),
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseDay(tc.input, tc.now)
+
+			assert.Equal(t, err != nil, tc.expectedErr, "error mismatch")
+			assert.Equal(t, got.Equal(tc.expected), true, "result mismatch")
+		})
+	}
+}
+
+func TestDayRange(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+
+	testCases := []struct {
+		name     string
+		input    time.Time
+		expected time.Duration
+	}{
+		{name: "normal day", input: time.Date(2024, time.June, 1, 12, 0, 0, 0, ny), expected: 24 * time.Hour},
+		{name: "start of daylight saving time", input: time.Date(2024, time.March, 10, 12, 0, 0, 0, ny), expected: 23 * time.Hour},
+		{name: "end of daylight saving time", input: time.Date(2024, time.November, 3, 12, 0, 0, 0, ny), expected: 25 * time.Hour},
+		{name: "end of the year", input: time.Date(2024, time.December, 31, 23, 59, 0, 0, ny), expected: 24 * time.Hour},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end := DayRange(tc.input)
+
+			y, m, d := tc.input.Date()
+			assert.Equal(t, start.Equal(time.Date(y, m, d, 0, 0, 0, 0, ny)), true, "start mismatch")
+			assert.Equal(t, end.Sub(start), tc.expected, "length mismatch")
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// regexAPIVersion matches a path ending in an API version such as /v3
+var regexAPIVersion = regexp.MustCompile(`/v\d+$`)
+
+// NormalizeEndpoint validates an API endpoint and returns it without the
+// trailing slashes, so that a path can be appended to it. The endpoint must be
+// an http or https URL, and may have a path under which the server is served,
+// but not the API version, which dnote adds to the requests itself. An empty
+// endpoint is returned as it is.
+func NormalizeEndpoint(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return "", nil
+	}
+
+	if !strings.Contains(s, "://") {
+		return "", errors.Errorf("the API endpoint '%s' has no scheme. Use http:// or https://, as in https://%s", raw, strings.TrimRight(s, "/"))
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", errors.Errorf("the API endpoint '%s' is not a valid URL", raw)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", errors.Errorf("the API endpoint '%s' has the scheme '%s'. Use http:// or https://", raw, u.Scheme)
+	}
+	if u.Host == "" {
+		return "", errors.Errorf("the API endpoint '%s' has no host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.Errorf("the API endpoint '%s' cannot have a query or a fragment", raw)
+	}
+
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	if v := regexAPIVersion.FindString(path); v != "" {
+		base := scheme + "://" + u.Host + strings.TrimSuffix(path, v)
+		return "", errors.Errorf("the API endpoint '%s' ends in the API version %s, which dnote adds itself. Use %s", raw, v, base)
+	}
+
+	return scheme + "://" + u.Host + path, nil
+}
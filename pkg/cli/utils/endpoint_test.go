@@ -0,0 +1,87 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestNormalizeEndpoint(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "", expected: ""},
+		{input: "https://api.getdnote.com", expected: "https://api.getdnote.com"},
+		{input: "https://api.getdnote.com/", expected: "https://api.getdnote.com"},
+		{input: "https://api.getdnote.com//", expected: "https://api.getdnote.com"},
+		{input: " https://api.getdnote.com\n", expected: "https://api.getdnote.com"},
+		{input: "HTTPS://api.getdnote.com", expected: "https://api.getdnote.com"},
+		{input: "http://localhost:3000", expected: "http://localhost:3000"},
+		{input: "http://localhost:3000/", expected: "http://localhost:3000"},
+		{input: "http://127.0.0.1:3000", expected: "http://127.0.0.1:3000"},
+		{input: "https://example.com/dnote/api", expected: "https://example.com/dnote/api"},
+		{input: "https://example.com/dnote/api/", expected: "https://example.com/dnote/api"},
+		{input: "https://example.com/v1beta", expected: "https://example.com/v1beta"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := NormalizeEndpoint(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestNormalizeEndpoint_invalid(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "api.getdnote.com", expected: "has no scheme. Use http:// or https://, as in https://api.getdnote.com"},
+		{input: "api.getdnote.com/", expected: "as in https://api.getdnote.com"},
+		{input: "localhost:3000", expected: "as in https://localhost:3000"},
+		{input: "ftp://example.com", expected: "has the scheme 'ftp'"},
+		{input: "https://", expected: "has no host"},
+		{input: "https://example.com?key=1", expected: "cannot have a query or a fragment"},
+		{input: "https://api.getdnote.com/v1", expected: "ends in the API version /v1, which dnote adds itself. Use https://api.getdnote.com"},
+		{input: "https://api.getdnote.com/v3/", expected: "ends in the API version /v3"},
+		{input: "http://localhost:3000/api/v1", expected: "Use http://localhost:3000/api"},
+		{input: "https://exa mple.com", expected: "is not a valid URL"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			_, err := NormalizeEndpoint(tc.input)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			assert.Equal(t, strings.Contains(err.Error(), tc.expected), true, fmt.Sprintf("error mismatch in %q", err.Error()))
+		})
+	}
+}
@@ -129,7 +129,7 @@ func CopyFile(src, dest string) error {
 		return errors.Wrap(err, "getting the file info for the input file")
 	}
 
-	if err = os.Chmod(dest, fi.Mode()); err != nil {
+	if err = setPerm(dest, fi.Mode()); err != nil {
 		return errors.Wrap(err, "copying permission to the output file")
 	}
 
@@ -140,3 +140,56 @@ func CopyFile(src, dest string) error {
 
 	return nil
 }
+
+// WriteFileAtomic writes the data to the file at the given path so that the
+// file has either the previous or the new content even if the process crashes
+// in the middle. It writes a temporary file in the same directory, flushes it
+// to disk and renames it over the destination.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "creating a temporary file")
+	}
+	tmpPath := tmp.Name()
+
+	// remove the temporary file unless it has been renamed
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return errors.Wrap(err, "writing the temporary file")
+	}
+	if err := tmp.Sync(); err != nil {
+		return errors.Wrap(err, "flushing the temporary file to disk")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "closing the temporary file")
+	}
+	if err := setPerm(tmpPath, perm); err != nil {
+		return errors.Wrap(err, "setting the permission of the temporary file")
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Wrap(err, "renaming the temporary file")
+	}
+	renamed = true
+
+	// Flush the rename to disk. Directories cannot be synced on some platforms,
+	// in which case the rename is still atomic but may not be durable.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
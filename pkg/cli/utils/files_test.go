@@ -0,0 +1,102 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the directory"))
+	}
+
+	ret := []string{}
+	for _, e := range entries {
+		ret = append(ret, e.Name())
+	}
+
+	return ret
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dnoterc")
+
+	if err := WriteFileAtomic(path, []byte("editor: vi\n"), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing a new file"))
+	}
+	if err := WriteFileAtomic(path, []byte("editor: nvim\n"), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "overwriting the file"))
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the file"))
+	}
+	assert.Equal(t, string(b), "editor: nvim\n", "content mismatch")
+
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting the file info"))
+		}
+		assert.Equal(t, fi.Mode().Perm(), os.FileMode(0600), "permission mismatch")
+	}
+
+	assert.DeepEqual(t, listDir(t, dir), []string{"dnoterc"}, "temporary files were left behind")
+}
+
+func TestWriteFileAtomic_failure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dnoterc")
+
+	if err := ioutil.WriteFile(path, []byte("editor: vi\n"), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the existing file"))
+	}
+
+	// writing to a missing directory fails before the destination is touched
+	err := WriteFileAtomic(filepath.Join(dir, "missing", "dnoterc"), []byte("editor: nvim\n"), 0644)
+	assert.NotEqual(t, err, nil, "error was not returned for a missing directory")
+
+	// renaming over a directory fails after the temporary file is written
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "creating a directory"))
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing a file in the directory"))
+	}
+	err = WriteFileAtomic(filepath.Join(dir, "sub"), []byte("editor: nvim\n"), 0644)
+	assert.NotEqual(t, err, nil, "error was not returned for renaming over a directory")
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the file"))
+	}
+	assert.Equal(t, string(b), "editor: vi\n", "existing file was modified")
+	assert.DeepEqual(t, listDir(t, dir), []string{"dnoterc", "sub"}, "temporary files were left behind")
+}
@@ -0,0 +1,34 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+//go:build windows
+
+package utils
+
+import (
+	"os"
+)
+
+// setPerm sets the permission bits of the file at the given path. Windows only
+// has a read-only attribute, and some filesystems do not support changing it,
+// so the permission is advisory and a failure to set it is ignored.
+func setPerm(path string, perm os.FileMode) error {
+	os.Chmod(path, perm)
+
+	return nil
+}
@@ -0,0 +1,49 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+//go:build windows
+
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+// TestWriteFileAtomic_unixPerm tests that a unix permission does not prevent
+// writing a file on Windows
+func TestWriteFileAtomic_unixPerm(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnoterc")
+
+	if err := WriteFileAtomic(path, []byte("foo"), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the file"))
+	}
+	if err := WriteFileAtomic(path, []byte("bar"), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "overwriting the file"))
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading the file"))
+	}
+	assert.Equal(t, string(b), "bar", "content mismatch")
+}
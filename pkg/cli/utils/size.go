@@ -0,0 +1,67 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// byteUnits are the multipliers of the units of a byte size. The units with an
+// i are powers of 1024, and the others are powers of 1000.
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"m":   1e6,
+	"mb":  1e6,
+	"g":   1e9,
+	"gb":  1e9,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+}
+
+// regexByteSize matches a number followed by an optional unit
+var regexByteSize = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([a-zA-Z]*)$`)
+
+// ParseByteSize parses a byte size such as 500KB, 5MB or 1.5GiB. A number
+// without a unit is in bytes.
+func ParseByteSize(s string) (int64, error) {
+	m := regexByteSize.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, errors.Errorf("invalid size '%s'", s)
+	}
+
+	unit, ok := byteUnits[strings.ToLower(m[2])]
+	if !ok {
+		return 0, errors.Errorf("unknown unit '%s' in the size '%s'. Use B, KB, MB, GB, KiB, MiB or GiB", m[2], s)
+	}
+
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing the size '%s'", s)
+	}
+
+	return int64(n * unit), nil
+}
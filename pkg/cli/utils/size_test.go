@@ -0,0 +1,63 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestParseByteSize(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512B", 512},
+		{"500KB", 500000},
+		{"5MB", 5000000},
+		{"5mb", 5000000},
+		{"5 MB", 5000000},
+		{"5M", 5000000},
+		{"1.5GB", 1500000000},
+		{"1KiB", 1024},
+		{"2MiB", 2097152},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseByteSize(tc.input)
+			if err != nil {
+				t.Fatalf("parsing '%s': %s", tc.input, err)
+			}
+
+			assert.Equal(t, got, tc.expected, "size mismatch")
+		})
+	}
+
+	for _, input := range []string{"", "MB", "-5MB", "5TB", "5 M B"} {
+		t.Run(input, func(t *testing.T) {
+			if _, err := ParseByteSize(input); err == nil {
+				t.Errorf("expected an error for '%s'", input)
+			}
+		})
+	}
+}
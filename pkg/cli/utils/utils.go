@@ -20,6 +20,7 @@ package utils
 
 import (
 	"regexp"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -46,3 +47,12 @@ func IsNumber(s string) bool {
 
 	return regexNumber.MatchString(s)
 }
+
+// NormalizeBody normalizes the line endings of a note body to LF and replaces
+// the invalid UTF-8 byte sequences with the replacement character, as the JSON
+// encoder does when the body is sent to the server
+func NormalizeBody(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+
+	return strings.ToValidUTF8(body, "\uFFFD")
+}
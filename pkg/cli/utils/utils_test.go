@@ -0,0 +1,47 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestNormalizeBody(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"foo", "foo"},
+		{"foo\nbar", "foo\nbar"},
+		{"foo\r\nbar\r\n", "foo\nbar\n"},
+		{"foo\rbar", "foo\rbar"},
+		{"日本語 🎉", "日本語 🎉"},
+		{"foo\xffbar", "foo\uFFFDbar"},
+		{"foo\xc3\x28\r\n", "foo\uFFFD(\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.input), func(t *testing.T) {
+			assert.Equal(t, NormalizeBody(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
@@ -42,6 +42,14 @@ func TestValidateBookName(t *testing.T) {
 			input:    "",
 			expected: ErrBookNameEmpty,
 		},
+		{
+			input:    " ",
+			expected: ErrBookNameEmpty,
+		},
+		{
+			input:    "\t\n",
+			expected: ErrBookNameEmpty,
+		},
 		{
 			input:    "foo bar",
 			expected: ErrBookNameHasSpace,
@@ -94,11 +102,11 @@ func TestValidateBookName(t *testing.T) {
 		// multiline
 		{
 			input:    "\n",
-			expected: ErrBookNameMultiline,
+			expected: ErrBookNameEmpty,
 		},
 		{
 			input:    "\n\n",
-			expected: ErrBookNameMultiline,
+			expected: ErrBookNameEmpty,
 		},
 		{
 			input:    "foo\n",
@@ -114,11 +122,11 @@ func TestValidateBookName(t *testing.T) {
 		},
 		{
 			input:    "\r\n",
-			expected: ErrBookNameMultiline,
+			expected: ErrBookNameEmpty,
 		},
 		{
 			input:    "\r\n\r\n",
-			expected: ErrBookNameMultiline,
+			expected: ErrBookNameEmpty,
 		},
 		{
 			input:    "foo\r\n",
@@ -134,7 +142,7 @@ func TestValidateBookName(t *testing.T) {
 		},
 		{
 			input:    "\n\r\n",
-			expected: ErrBookNameMultiline,
+			expected: ErrBookNameEmpty,
 		},
 		{
 			input:    "foo\nbar\r\n",
@@ -25,6 +25,9 @@ import (
 	"github.com/pkg/errors"
 )
 
+// CodeInvalidBookName is the stable code of the errors for an invalid book name
+const CodeInvalidBookName = "invalid_book_name"
+
 var reservedBookNames = []string{"trash", "conflicts"}
 
 // ErrBookNameReserved is an error incidating that the specified book name is reserved
@@ -54,7 +57,7 @@ func isReservedName(name string) bool {
 
 // BookName validates a book name
 func BookName(name string) error {
-	if name == "" {
+	if strings.TrimSpace(name) == "" {
 		return ErrBookNameEmpty
 	}
 
@@ -0,0 +1,64 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package validate
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// CodeInvalidNoteBody is the stable code of the errors for an invalid note body
+const CodeInvalidNoteBody = "invalid_note_body"
+
+// ErrNoteBodyInvalidUTF8 is an error for a note body that is not valid UTF-8
+var ErrNoteBodyInvalidUTF8 = errors.New("The content is not valid UTF-8")
+
+// NoteBody validates a note body
+func NoteBody(body string) error {
+	if !utf8.ValidString(body) {
+		return ErrNoteBodyInvalidUTF8
+	}
+
+	return nil
+}
+
+// NoteSizeError is an error for a note body above the size limit
+type NoteSizeError struct {
+	// Size is the number of bytes in the body
+	Size int64
+	// Limit is the maximum number of bytes allowed
+	Limit int64
+}
+
+func (e *NoteSizeError) Error() string {
+	return fmt.Sprintf("The content is %d bytes, above the limit of %d bytes", e.Size, e.Limit)
+}
+
+// NoteSize validates the size of a note body against the limit in bytes. A
+// limit of zero or less is not enforced.
+func NoteSize(body string, limit int64) error {
+	size := int64(len(body))
+	if limit > 0 && size > limit {
+		return &NoteSizeError{Size: size, Limit: limit}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package validate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestNoteBody(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected error
+	}{
+		{
+			input:    "foo\nbar",
+			expected: nil,
+		},
+		{
+			input:    "日本語 🎉",
+			expected: nil,
+		},
+		{
+			input:    "foo\xffbar",
+			expected: ErrNoteBodyInvalidUTF8,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.input), func(t *testing.T) {
+			assert.Equal(t, NoteBody(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestNoteSize(t *testing.T) {
+	testCases := []struct {
+		input    string
+		limit    int64
+		expected error
+	}{
+		{
+			input:    "foo",
+			limit:    3,
+			expected: nil,
+		},
+		{
+			input:    "foo",
+			limit:    2,
+			expected: &NoteSizeError{Size: 3, Limit: 2},
+		},
+		{
+			input:    "日本",
+			limit:    5,
+			expected: &NoteSizeError{Size: 6, Limit: 5},
+		},
+		{
+			input:    "foo",
+			limit:    0,
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q %d", tc.input, tc.limit), func(t *testing.T) {
+			assert.DeepEqual(t, NoteSize(tc.input, tc.limit), tc.expected, "result mismatch")
+		})
+	}
+}
@@ -20,11 +20,10 @@
 package clock
 
 import (
+	"sync"
 	"time"
 )
 
-//TODO: use mutex to avoid race
-
 // Clock is an interface to the standard library time.
 // It is used to implement a real or a mock clock. The latter is used in tests.
 type Clock interface {
@@ -37,21 +36,47 @@ func (c *clock) Now() time.Time {
 	return time.Now()
 }
 
-// Mock is a mock instance of clock
+// Mock is a mock instance of clock that is frozen at the time set by SetNow
 type Mock struct {
+	mu          sync.Mutex
 	currentTime time.Time
 }
 
 // SetNow sets the current time for the mock clock
 func (c *Mock) SetNow(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.currentTime = t
 }
 
 // Now returns the current time
 func (c *Mock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return c.currentTime
 }
 
+// Stepping is a mock clock that advances by a fixed step every time it is read,
+// so that successive timestamps are distinct and predictable
+type Stepping struct {
+	mu   sync.Mutex
+	next time.Time
+	step time.Duration
+}
+
+// Now returns the current time and advances the clock by the step
+func (c *Stepping) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ret := c.next
+	c.next = c.next.Add(c.step)
+
+	return ret
+}
+
 // New returns an instance of a real clock
 func New() Clock {
 	return &clock{}
@@ -63,3 +88,12 @@ func NewMock() *Mock {
 		currentTime: time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC),
 	}
 }
+
+// NewStepping returns an instance of a stepping clock that starts at the given
+// time and advances by the given step
+func NewStepping(start time.Time, step time.Duration) *Stepping {
+	return &Stepping{
+		next: start,
+		step: step,
+	}
+}
@@ -0,0 +1,44 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestMock(t *testing.T) {
+	c := NewMock()
+	now := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
+	c.SetNow(now)
+
+	assert.Equal(t, c.Now(), now, "first read mismatch")
+	assert.Equal(t, c.Now(), now, "second read mismatch")
+}
+
+func TestStepping(t *testing.T) {
+	start := time.Date(2017, time.March, 14, 21, 15, 0, 0, time.UTC)
+	c := NewStepping(start, time.Second)
+
+	assert.Equal(t, c.Now(), start, "first read mismatch")
+	assert.Equal(t, c.Now(), start.Add(time.Second), "second read mismatch")
+	assert.Equal(t, c.Now(), start.Add(2*time.Second), "third read mismatch")
+}
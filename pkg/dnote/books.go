@@ -0,0 +1,144 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnote
+
+import (
+	"database/sql"
+
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/pkg/errors"
+)
+
+// Books returns the books sorted by label
+func (s *Store) Books() ([]Book, error) {
+	rows, err := s.ctx.DB.Query(`SELECT books.uuid, books.label, count(notes.uuid) note_count
+	FROM books
+	LEFT JOIN notes ON notes.book_uuid = books.uuid AND notes.deleted = false
+	WHERE books.deleted = false
+	GROUP BY books.uuid
+	ORDER BY books.label ASC;`)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying books")
+	}
+	defer rows.Close()
+
+	ret := []Book{}
+	for rows.Next() {
+		var b Book
+		if err := rows.Scan(&b.UUID, &b.Label, &b.NoteCount); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		ret = append(ret, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating rows")
+	}
+
+	return ret, nil
+}
+
+// getBookUUID returns the uuid of the book with the given label that is not
+// deleted
+func getBookUUID(db *database.DB, label string) (string, error) {
+	var uuid string
+	err := db.QueryRow("SELECT uuid FROM books WHERE label = ? AND deleted = false", label).Scan(&uuid)
+	if err == sql.ErrNoRows {
+		return "", ErrBookNotFound
+	} else if err != nil {
+		return "", errors.Wrap(err, "querying the book")
+	}
+
+	return uuid, nil
+}
+
+// RenameBook changes the label of the book. The change is uploaded on the
+// next sync.
+func (s *Store) RenameBook(label, newLabel string) (Book, error) {
+	if err := validate.BookName(newLabel); err != nil {
+		return Book{}, errors.Wrap(err, "validating book name")
+	}
+
+	var ret Book
+	err := infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		uuid, err := getBookUUID(tx, label)
+		if err != nil {
+			return err
+		}
+
+		if err := database.UpdateBookName(tx, uuid, newLabel); err != nil {
+			return errors.Wrap(err, "updating the book name")
+		}
+
+		var c database.FieldChanges
+		c.Field("label", label, newLabel)
+		if err := s.journal(tx, database.OpRenameBook, "", uuid, c); err != nil {
+			return err
+		}
+
+		ret.UUID = uuid
+		ret.Label = newLabel
+		if err := tx.QueryRow("SELECT count(*) FROM notes WHERE book_uuid = ? AND deleted = false", uuid).Scan(&ret.NoteCount); err != nil {
+			return errors.Wrap(err, "counting the notes")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Book{}, err
+	}
+
+	return ret, nil
+}
+
+// RemoveBook removes the book and all its notes. The removal is uploaded on
+// the next sync.
+func (s *Store) RemoveBook(label string) error {
+	return infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		bookUUID, err := getBookUUID(tx, label)
+		if err != nil {
+			return err
+		}
+
+		a := database.Action{Timestamp: s.ctx.Clock.Now().UnixNano(), Actor: database.ActorCLI, Operation: database.OpRemoveNote, Summary: "removed with the book", Device: s.ctx.DeviceID}
+		if err := database.InsertNoteActions(tx, a, "book_uuid = ? AND deleted = false", bookUUID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE book_uuid = ?", true, true, "", bookUUID); err != nil {
+			return errors.Wrap(err, "removing notes in the book")
+		}
+
+		// override the label with a random string
+		uniqLabel, err := utils.GenerateUUID()
+		if err != nil {
+			return errors.Wrap(err, "generating uuid to override with")
+		}
+
+		if _, err := tx.Exec("UPDATE books SET deleted = ?, dirty = ?, label = ? WHERE uuid = ?", true, true, uniqLabel, bookUUID); err != nil {
+			return errors.Wrap(err, "removing the book")
+		}
+
+		var c database.FieldChanges
+		c.Field("label", label, "")
+		return s.journal(tx, database.OpRemoveBook, "", bookUUID, c)
+	})
+}
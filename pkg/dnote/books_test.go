@@ -0,0 +1,103 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnote
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestBooks(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	b2 := f.Book("css")
+	f.Book("deleted", testutils.With.Deleted())
+	f.Note(b1, "n1 body")
+	f.Note(b1, "n2 body")
+	f.Note(b1, "", testutils.With.Deleted())
+
+	got, err := s.Books()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "listing books"))
+	}
+
+	assert.DeepEqual(t, got, []Book{
+		{UUID: b2.UUID, Label: "css", NoteCount: 0},
+		{UUID: b1.UUID, Label: "js", NoteCount: 2},
+	}, "books mismatch")
+}
+
+func TestRenameBook(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("js")
+	f.Note(b, "n1 body")
+
+	got, err := s.RenameBook("js", "javascript")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "renaming the book"))
+	}
+
+	assert.DeepEqual(t, got, Book{UUID: b.UUID, Label: "javascript", NoteCount: 1}, "book mismatch")
+
+	book := f.MustGetBook(b.UUID)
+	assert.Equal(t, book.Label, "javascript", "label mismatch")
+	assert.Equal(t, book.Dirty, true, "dirty mismatch")
+
+	_, err = s.RenameBook("js", "ts")
+	assert.Equal(t, err, ErrBookNotFound, "error mismatch")
+
+	assert.DeepEqual(t, mustGetActions(t, ctx.DB), []database.Action{
+		{Timestamp: testNow.UnixNano(), Actor: database.ActorCLI, Operation: database.OpRenameBook, BookUUID: b.UUID, Summary: `label: "js" -> "javascript"`},
+	}, "actions mismatch")
+}
+
+func TestRemoveBook(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	b2 := f.Book("css")
+	n1 := f.Note(b1, "n1 body")
+	n2 := f.Note(b2, "n2 body")
+	f.Note(b1, "", testutils.With.Deleted())
+
+	if err := s.RemoveBook("js"); err != nil {
+		t.Fatal(errors.Wrap(err, "removing the book"))
+	}
+
+	book := f.MustGetBook(b1.UUID)
+	assert.Equal(t, book.Deleted, true, "book deleted mismatch")
+	assert.NotEqual(t, book.Label, "js", "the label should be freed")
+	assert.Equal(t, f.MustGetNote(n1.UUID).Deleted, true, "n1 deleted mismatch")
+	assert.Equal(t, f.MustGetNote(n2.UUID).Deleted, false, "n2 deleted mismatch")
+
+	assert.Equal(t, s.RemoveBook("js"), ErrBookNotFound, "error mismatch")
+
+	// the notes that were already removed are not journaled again
+	ts := testNow.UnixNano()
+	assert.DeepEqual(t, mustGetActions(t, ctx.DB), []database.Action{
+		{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpRemoveNote, NoteUUID: n1.UUID, BookUUID: b1.UUID, Summary: "removed with the book"},
+		{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpRemoveBook, BookUUID: b1.UUID, Summary: `label: "js" -> ""`},
+	}, "actions mismatch")
+}
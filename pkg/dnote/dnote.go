@@ -0,0 +1,161 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package dnote is the Go API for embedding Dnote in other programs. It works
+// on the same database and config as the command line interface, so that a
+// program can add, query and sync the notes without running the dnote command.
+package dnote
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/pkg/errors"
+)
+
+// DefaultAPIEndpoint is the API endpoint written to the config of a new store
+const DefaultAPIEndpoint = "https://api.getdnote.com"
+
+// version is the version reported to the server by a store opened by
+// OpenStore
+const version = "master"
+
+var (
+	// ErrNoteNotFound is an error for a note that does not exist
+	ErrNoteNotFound = errors.New("note not found")
+	// ErrBookNotFound is an error for a book that does not exist
+	ErrBookNotFound = errors.New("book not found")
+)
+
+// Store is a Dnote database along with its config
+type Store struct {
+	ctx context.DnoteCtx
+	// owned indicates whether the store opened the database and closes it
+	owned bool
+}
+
+// OpenStore opens the store whose config, data and cache are all kept in the
+// given directory, creating and migrating it as needed. An empty directory
+// opens the store of the current user, which the dnote command uses.
+func OpenStore(dir string) (*Store, error) {
+	var ctx *context.DnoteCtx
+	var err error
+	if dir == "" {
+		ctx, err = infra.Init(DefaultAPIEndpoint, version, false)
+	} else {
+		ctx, err = infra.InitAt(dir, DefaultAPIEndpoint, version)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "initializing dnote")
+	}
+
+	return &Store{ctx: *ctx, owned: true}, nil
+}
+
+// NewStore returns a store on the given context. The database is owned by the
+// caller, and is not closed by Close.
+func NewStore(ctx context.DnoteCtx) *Store {
+	return &Store{ctx: ctx}
+}
+
+// Close closes the database if the store opened it
+func (s *Store) Close() error {
+	if !s.owned {
+		return nil
+	}
+
+	s.ctx.FileLogger.Close()
+
+	return s.ctx.DB.Close()
+}
+
+// Note is a note in a book
+type Note struct {
+	UUID string
+	// Index is the number by which the dnote command refers to the note. It
+	// does not change as long as the note exists.
+	Index int
+	Book  string
+	Body  string
+	// AddedOn is when the note was added
+	AddedOn time.Time
+	// EditedOn is when the note was last edited. It is zero if it has never
+	// been edited.
+	EditedOn time.Time
+}
+
+// Book is a book of notes
+type Book struct {
+	UUID      string
+	Label     string
+	NoteCount int
+}
+
+// journal records a change made through the store in the journal, in the
+// transaction of the change
+func (s *Store) journal(tx *database.DB, op, noteUUID, bookUUID string, c database.FieldChanges) error {
+	return s.journalAs(tx, database.ActorCLI, op, noteUUID, bookUUID, c)
+}
+
+// journalAs records a change made through the store by the given actor
+func (s *Store) journalAs(tx *database.DB, actor, op, noteUUID, bookUUID string, c database.FieldChanges) error {
+	return database.InsertAction(tx, database.Action{
+		Timestamp: s.ctx.Clock.Now().UnixNano(),
+		Actor:     actor,
+		Operation: op,
+		NoteUUID:  noteUUID,
+		BookUUID:  bookUUID,
+		Summary:   c.String(),
+		Device:    s.ctx.DeviceID,
+	})
+}
+
+// fromUnixNano converts a timestamp in the database into a time, leaving
+// zero as the zero time
+func fromUnixNano(ts int64) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ts)
+}
+
+// getNote returns the note with the given uuid that is not deleted
+func getNote(db *database.DB, uuid string) (Note, error) {
+	var n Note
+	var addedOn, editedOn int64
+
+	err := db.QueryRow(`SELECT notes.uuid, notes.rowid, books.label, notes.body, notes.added_on, notes.edited_on
+		FROM notes
+		INNER JOIN books ON books.uuid = notes.book_uuid
+		WHERE notes.uuid = ? AND notes.deleted = false`, uuid).
+		Scan(&n.UUID, &n.Index, &n.Book, &n.Body, &addedOn, &editedOn)
+	if err == sql.ErrNoRows {
+		return n, ErrNoteNotFound
+	} else if err != nil {
+		return n, errors.Wrap(err, "querying the note")
+	}
+
+	n.AddedOn = fromUnixNano(addedOn)
+	n.EditedOn = fromUnixNano(editedOn)
+
+	return n, nil
+}
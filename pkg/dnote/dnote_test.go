@@ -0,0 +1,101 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnote
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+var testNow = time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+// setupStore returns a store on a test database
+func setupStore(t *testing.T) (*Store, context.DnoteCtx) {
+	dir := t.TempDir()
+	ctx := context.InitTestCtx(t, context.Paths{Home: dir, Data: dir, Config: dir, Cache: dir}, nil)
+	t.Cleanup(func() {
+		context.TeardownTestCtx(t, ctx)
+	})
+
+	c := clock.NewMock()
+	c.SetNow(testNow)
+	ctx.Clock = c
+
+	return NewStore(ctx), ctx
+}
+
+// mustGetActions returns the actions in the journal without their ids
+func mustGetActions(t *testing.T, db *database.DB) []database.Action {
+	actions, err := database.GetActions(db, database.ActionFilter{})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting actions"))
+	}
+
+	for i := range actions {
+		actions[i].ID = 0
+	}
+
+	return actions
+}
+
+func TestOpenStore(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "opening the store"))
+	}
+
+	if _, err := s.AddNote("js", "n1 body"); err != nil {
+		t.Fatal(errors.Wrap(err, "adding a note"))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the store"))
+	}
+
+	// the notes are kept in the directory across the stores
+	s, err = OpenStore(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reopening the store"))
+	}
+	defer s.Close()
+
+	notes, err := s.Notes(NoteFilter{})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "listing notes"))
+	}
+
+	assert.Equal(t, len(notes), 1, "note count mismatch")
+	assert.Equal(t, notes[0].Book, "js", "book mismatch")
+	assert.Equal(t, notes[0].Body, "n1 body", "body mismatch")
+
+	dbPath := filepath.Join(dir, consts.DnoteDirName, consts.DnoteDBFileName)
+	if _, err := os.Stat(dbPath); err != nil {
+		t.Fatal(errors.Wrap(err, "checking the database in the directory"))
+	}
+}
@@ -0,0 +1,654 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnote
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/bulkedit"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/hooks"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/pkg/errors"
+)
+
+// The keys by which the notes are sorted
+const (
+	// SortAdded sorts the notes by the time they were added. It is the default.
+	SortAdded = "added"
+	// SortEdited sorts the notes by the time they were last edited, or added
+	// if they have never been edited
+	SortEdited = "edited"
+	// SortIndex sorts the notes by their index
+	SortIndex = "index"
+)
+
+// sortColumns are the columns by which the notes are sorted for each key
+var sortColumns = map[string]string{
+	SortAdded:  "notes.added_on",
+	SortEdited: "CASE notes.edited_on WHEN 0 THEN notes.added_on ELSE notes.edited_on END",
+	SortIndex:  "notes.rowid",
+}
+
+// NoteFilter selects the notes to list
+type NoteFilter struct {
+	// Book is the label of the book whose notes are listed. The notes in all
+	// books are listed if it is empty.
+	Book string
+	// Limit is the maximum number of notes to list. If zero, all notes are listed.
+	Limit  int
+	Offset int
+	// Sort is one of the Sort keys, and defaults to SortAdded if empty
+	Sort string
+}
+
+func (f NoteFilter) validate() error {
+	if f.Limit < 0 {
+		return errors.New("the limit must not be negative")
+	}
+	if f.Offset < 0 {
+		return errors.New("the offset must not be negative")
+	}
+	if _, ok := sortColumns[f.Sort]; !ok && f.Sort != "" {
+		return errors.Errorf("invalid sort '%s'. It must be one of added, edited and index", f.Sort)
+	}
+
+	return nil
+}
+
+// NoteUpdate is a change to a note. The empty fields are left unchanged.
+type NoteUpdate struct {
+	// Book is the label of the book to move the note to
+	Book string
+	Body string
+	// CreateBook creates the book to move the note to if it does not exist.
+	// Otherwise moving the note to a missing book is an error.
+	CreateBook bool
+}
+
+// normalizeBody validates the body of a note and normalizes it to be saved
+func normalizeBody(body string) (string, error) {
+	if err := validate.NoteBody(body); err != nil {
+		return "", err
+	}
+
+	return utils.NormalizeBody(body), nil
+}
+
+// getOrCreateBook returns the uuid of the book with the given label, creating
+// the book if it does not exist
+func (s *Store) getOrCreateBook(tx *database.DB, label string) (string, error) {
+	var ret string
+	err := tx.QueryRow("SELECT uuid FROM books WHERE label = ?", label).Scan(&ret)
+	if err == nil {
+		return ret, nil
+	} else if err != sql.ErrNoRows {
+		return "", errors.Wrap(err, "finding the book")
+	}
+
+	ret, err = utils.GenerateUUID()
+	if err != nil {
+		return "", errors.Wrap(err, "generating uuid")
+	}
+
+	b := database.NewBook(ret, label, 0, false, true)
+	if err := b.Insert(tx); err != nil {
+		return "", errors.Wrap(err, "creating the book")
+	}
+
+	var c database.FieldChanges
+	c.Field("label", "", label)
+	if err := s.journal(tx, database.OpAddBook, "", ret, c); err != nil {
+		return "", err
+	}
+
+	return ret, nil
+}
+
+// AddNote adds a note with the body to the book with the given label, creating
+// the book if it does not exist. The note and the new book are uploaded on
+// the next sync.
+func (s *Store) AddNote(book, body string) (Note, error) {
+	if err := validate.BookName(book); err != nil {
+		return Note{}, errors.Wrap(err, "invalid book name")
+	}
+	if body == "" {
+		return Note{}, errors.New("empty content")
+	}
+	body, err := normalizeBody(body)
+	if err != nil {
+		return Note{}, errors.Wrap(err, "invalid content")
+	}
+
+	var uuid string
+	err = infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		var err error
+		uuid, err = s.insertNote(tx, book, body, s.ctx.Clock.Now().UnixNano(), 0)
+
+		return err
+	})
+	if err != nil {
+		return Note{}, err
+	}
+
+	return s.noteAdded(uuid)
+}
+
+// insertNote inserts a new note with the body into the book with the given
+// label, creating the book if it does not exist, and returns its uuid. The
+// note is uploaded on the next sync.
+func (s *Store) insertNote(tx *database.DB, book, body string, addedOn, editedOn int64) (string, error) {
+	bookUUID, err := s.getOrCreateBook(tx, book)
+	if err != nil {
+		return "", err
+	}
+
+	uuid, err := utils.GenerateUUID()
+	if err != nil {
+		return "", errors.Wrap(err, "generating uuid")
+	}
+
+	n := database.NewNote(uuid, bookUUID, body, addedOn, editedOn, 0, false, false, true)
+	n.OriginDevice = s.ctx.DeviceID
+	if n.Position, err = database.NextNotePosition(tx, bookUUID); err != nil {
+		return "", errors.Wrap(err, "finding the position")
+	}
+	if err := n.Insert(tx); err != nil {
+		return "", errors.Wrap(err, "creating the note")
+	}
+
+	var c database.FieldChanges
+	c.Field("book", "", book)
+	c.Body("", body)
+	if err := s.journal(tx, database.OpAddNote, uuid, bookUUID, c); err != nil {
+		return "", err
+	}
+
+	return uuid, nil
+}
+
+// noteAdded runs the hook of the added note with the given uuid and returns it
+func (s *Store) noteAdded(uuid string) (Note, error) {
+	info, err := database.GetNoteInfoByUUID(s.ctx.DB, uuid)
+	if err != nil {
+		return Note{}, errors.Wrap(err, "getting the note")
+	}
+	s.ctx.Hooks.NoteAdded(hooks.NewNote(info))
+
+	return getNote(s.ctx.DB, uuid)
+}
+
+// Note returns the note with the given uuid
+func (s *Store) Note(uuid string) (Note, error) {
+	return getNote(s.ctx.DB, uuid)
+}
+
+// Notes returns the notes selected by the filter
+func (s *Store) Notes(filter NoteFilter) ([]Note, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	sort := filter.Sort
+	if sort == "" {
+		sort = SortAdded
+	}
+
+	cond := "notes.deleted = false"
+	args := []interface{}{}
+	if filter.Book != "" {
+		var bookUUID string
+		err := s.ctx.DB.QueryRow("SELECT uuid FROM books WHERE label = ?", filter.Book).Scan(&bookUUID)
+		if err == sql.ErrNoRows {
+			return nil, ErrBookNotFound
+		} else if err != nil {
+			return nil, errors.Wrap(err, "querying the book")
+		}
+
+		cond += " AND notes.book_uuid = ?"
+		args = append(args, bookUUID)
+	}
+
+	// A negative limit means no limit. The rowid breaks ties so that the pages
+	// neither overlap nor skip any note.
+	limit := -1
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := fmt.Sprintf(`SELECT notes.uuid, notes.rowid, books.label, notes.body, notes.added_on, notes.edited_on
+	FROM notes
+	INNER JOIN books ON books.uuid = notes.book_uuid
+	WHERE %s
+	ORDER BY %s ASC, notes.rowid ASC
+	LIMIT ? OFFSET ?;`, cond, sortColumns[sort])
+
+	rows, err := s.ctx.DB.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	ret := []Note{}
+	for rows.Next() {
+		var n Note
+		var addedOn, editedOn int64
+		if err := rows.Scan(&n.UUID, &n.Index, &n.Book, &n.Body, &addedOn, &editedOn); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+		n.AddedOn = fromUnixNano(addedOn)
+		n.EditedOn = fromUnixNano(editedOn)
+
+		ret = append(ret, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating rows")
+	}
+
+	return ret, nil
+}
+
+// LastNote returns the note that was added last. If the book is not empty,
+// only the notes in the book with the label are considered.
+func (s *Store) LastNote(book string) (Note, error) {
+	cond := "deleted = false"
+	args := []interface{}{}
+	if book != "" {
+		bookUUID, err := getBookUUID(s.ctx.DB, book)
+		if err != nil {
+			return Note{}, err
+		}
+
+		cond += " AND book_uuid = ?"
+		args = append(args, bookUUID)
+	}
+
+	var uuid string
+	err := s.ctx.DB.QueryRow(fmt.Sprintf(`SELECT uuid
+	FROM notes
+	WHERE %s
+	ORDER BY added_on DESC, rowid DESC
+	LIMIT 1;`, cond), args...).Scan(&uuid)
+	if err == sql.ErrNoRows {
+		return Note{}, ErrNoteNotFound
+	} else if err != nil {
+		return Note{}, errors.Wrap(err, "querying the last note")
+	}
+
+	return getNote(s.ctx.DB, uuid)
+}
+
+// UpdateNote changes the note with the given uuid. The change is uploaded on
+// the next sync.
+func (s *Store) UpdateNote(uuid string, u NoteUpdate) (Note, error) {
+	if u.CreateBook && u.Book != "" {
+		if err := validate.BookName(u.Book); err != nil {
+			return Note{}, errors.Wrap(err, "invalid book name")
+		}
+	}
+
+	body := u.Body
+	if body != "" {
+		var err error
+		if body, err = normalizeBody(body); err != nil {
+			return Note{}, errors.Wrap(err, "invalid content")
+		}
+	}
+
+	err := infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		var rowID int
+		var bookUUID, bookLabel, oldBody string
+		err := tx.QueryRow(`SELECT notes.rowid, notes.book_uuid, books.label, notes.body
+			FROM notes
+			INNER JOIN books ON books.uuid = notes.book_uuid
+			WHERE notes.uuid = ? AND notes.deleted = false`, uuid).Scan(&rowID, &bookUUID, &bookLabel, &oldBody)
+		if err == sql.ErrNoRows {
+			return ErrNoteNotFound
+		} else if err != nil {
+			return errors.Wrap(err, "querying the note")
+		}
+
+		var c database.FieldChanges
+		newBookUUID, newBody := bookUUID, oldBody
+		if u.Book != "" {
+			var targetBookUUID string
+			if u.CreateBook {
+				targetBookUUID, err = s.getOrCreateBook(tx, u.Book)
+			} else {
+				targetBookUUID, err = database.GetBookUUID(tx, u.Book)
+			}
+			if err != nil {
+				return errors.Wrap(err, "finding book uuid")
+			}
+			if targetBookUUID == bookUUID {
+				return errors.New("book has not changed")
+			}
+
+			if err := database.UpdateNoteBook(tx, s.ctx.Clock, rowID, targetBookUUID, s.ctx.DeviceID); err != nil {
+				return errors.Wrap(err, "moving book")
+			}
+
+			newBookUUID = targetBookUUID
+			c.Field("book", bookLabel, u.Book)
+		}
+		if body != "" {
+			if body == oldBody {
+				return errors.New("Nothing changed")
+			}
+
+			if err := database.UpdateNoteContent(tx, s.ctx.Clock, rowID, body, s.ctx.DeviceID); err != nil {
+				return errors.Wrap(err, "changing content")
+			}
+
+			newBody = body
+			c.Body(oldBody, body)
+		}
+
+		op := database.NoteEditOperation(bookUUID, newBookUUID, oldBody, newBody)
+		return s.journal(tx, op, uuid, newBookUUID, c)
+	})
+	if err != nil {
+		return Note{}, err
+	}
+
+	info, err := database.GetNoteInfoByUUID(s.ctx.DB, uuid)
+	if err != nil {
+		return Note{}, errors.Wrap(err, "getting the note")
+	}
+	s.ctx.Hooks.NoteEdited(hooks.NewNote(info))
+
+	return getNote(s.ctx.DB, uuid)
+}
+
+// AppendNote appends the text to the body of the note with the given uuid as
+// a new line. The body is read in the transaction that writes it so that an
+// edit made in the meantime is kept. The change is uploaded on the next sync.
+func (s *Store) AppendNote(uuid, text string) (Note, error) {
+	if strings.TrimSpace(text) == "" {
+		return Note{}, errors.New("empty content")
+	}
+
+	err := infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		var rowID int
+		var bookUUID, oldBody string
+		err := tx.QueryRow("SELECT rowid, book_uuid, body FROM notes WHERE uuid = ? AND deleted = false", uuid).Scan(&rowID, &bookUUID, &oldBody)
+		if err == sql.ErrNoRows {
+			return ErrNoteNotFound
+		} else if err != nil {
+			return errors.Wrap(err, "querying the note")
+		}
+
+		body, err := normalizeBody(strings.TrimRight(oldBody, "\r\n") + "\n" + text)
+		if err != nil {
+			return errors.Wrap(err, "invalid content")
+		}
+		if err := database.UpdateNoteContent(tx, s.ctx.Clock, rowID, body, s.ctx.DeviceID); err != nil {
+			return errors.Wrap(err, "changing content")
+		}
+
+		var c database.FieldChanges
+		c.Body(oldBody, body)
+		return s.journal(tx, database.OpEditNote, uuid, bookUUID, c)
+	})
+	if err != nil {
+		return Note{}, err
+	}
+
+	info, err := database.GetNoteInfoByUUID(s.ctx.DB, uuid)
+	if err != nil {
+		return Note{}, errors.Wrap(err, "getting the note")
+	}
+	s.ctx.Hooks.NoteEdited(hooks.NewNote(info))
+
+	return getNote(s.ctx.DB, uuid)
+}
+
+// ReorderNote moves the note with the given uuid right before the target note
+// in the same book, or right after it if after is true. The order is local
+// and is not synced.
+func (s *Store) ReorderNote(uuid, targetUUID string, after bool) (Note, error) {
+	err := infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		var rowID, targetRowID int
+		var bookUUID, targetBookUUID string
+		var position float64
+		err := tx.QueryRow("SELECT rowid, book_uuid, position FROM notes WHERE uuid = ? AND deleted = false", uuid).Scan(&rowID, &bookUUID, &position)
+		if err == sql.ErrNoRows {
+			return ErrNoteNotFound
+		} else if err != nil {
+			return errors.Wrap(err, "querying the note")
+		}
+		err = tx.QueryRow("SELECT rowid, book_uuid FROM notes WHERE uuid = ? AND deleted = false", targetUUID).Scan(&targetRowID, &targetBookUUID)
+		if err == sql.ErrNoRows {
+			return ErrNoteNotFound
+		} else if err != nil {
+			return errors.Wrap(err, "querying the target note")
+		}
+		if bookUUID != targetBookUUID {
+			return errors.New("the notes are in different books")
+		}
+
+		newPosition, err := database.ReorderNote(tx, rowID, targetRowID, after)
+		if err != nil {
+			return errors.Wrap(err, "reordering the note")
+		}
+
+		var c database.FieldChanges
+		c.Field("position", formatPosition(position), formatPosition(newPosition))
+		return s.journal(tx, database.OpReorderNote, uuid, bookUUID, c)
+	})
+	if err != nil {
+		return Note{}, err
+	}
+
+	return getNote(s.ctx.DB, uuid)
+}
+
+// formatPosition formats a position in the journal
+func formatPosition(p float64) string {
+	return strconv.FormatFloat(p, 'g', -1, 64)
+}
+
+// RemoveNote removes the note with the given uuid. The removal is uploaded
+// on the next sync.
+func (s *Store) RemoveNote(uuid string) error {
+	return infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		var bookUUID, body string
+		err := tx.QueryRow("SELECT book_uuid, body FROM notes WHERE uuid = ? AND deleted = false", uuid).Scan(&bookUUID, &body)
+		if err == sql.ErrNoRows {
+			return ErrNoteNotFound
+		} else if err != nil {
+			return errors.Wrap(err, "querying the note")
+		}
+
+		if _, err := tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE uuid = ?", true, true, "", uuid); err != nil {
+			return errors.Wrap(err, "removing the note")
+		}
+
+		var c database.FieldChanges
+		c.Body(body, "")
+		return s.journal(tx, database.OpRemoveNote, uuid, bookUUID, c)
+	})
+}
+
+// ExpireNotes removes the notes with the given uuids that expired under the
+// retention policy of their book. The notes are removed together, and the
+// removals are uploaded on the next sync. The notes that are already removed
+// are skipped. It returns the number of the notes removed.
+func (s *Store) ExpireNotes(uuids []string) (int, error) {
+	var count int
+
+	err := infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		count = 0
+
+		for _, uuid := range uuids {
+			var bookUUID, body string
+			err := tx.QueryRow("SELECT book_uuid, body FROM notes WHERE uuid = ? AND deleted = false", uuid).Scan(&bookUUID, &body)
+			if err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				return errors.Wrapf(err, "querying the note %s", uuid)
+			}
+
+			if _, err := tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE uuid = ?", true, true, "", uuid); err != nil {
+				return errors.Wrapf(err, "removing the note %s", uuid)
+			}
+
+			var c database.FieldChanges
+			c.Body(body, "")
+			if err := s.journalAs(tx, database.ActorRetention, database.OpRemoveNote, uuid, bookUUID, c); err != nil {
+				return err
+			}
+
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// ApplyBookEdit applies the changes made to the notes of the book with the
+// given label in a bulk edit. The changes are made together, and fail with
+// ErrNoteNotFound if a note to update or remove is no longer in the book. They
+// are uploaded on the next sync.
+func (s *Store) ApplyBookEdit(book string, c bulkedit.Changes) error {
+	updated := make([]bulkedit.Note, len(c.Updated))
+	for i, n := range c.Updated {
+		body, err := normalizeBody(n.Body)
+		if err != nil {
+			return errors.Wrapf(err, "invalid content of the note %s", n.UUID)
+		}
+
+		updated[i] = bulkedit.Note{UUID: n.UUID, Body: body}
+	}
+	added := make([]string, len(c.Added))
+	for i, body := range c.Added {
+		var err error
+		if added[i], err = normalizeBody(body); err != nil {
+			return errors.Wrap(err, "invalid content of a new note")
+		}
+	}
+
+	var addedUUIDs []string
+	err := infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		addedUUIDs = nil
+
+		bookUUID, err := getBookUUID(tx, book)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range updated {
+			var rowID int
+			var oldBody string
+			err := tx.QueryRow("SELECT rowid, body FROM notes WHERE uuid = ? AND book_uuid = ? AND deleted = false", n.UUID, bookUUID).Scan(&rowID, &oldBody)
+			if err == sql.ErrNoRows {
+				return errors.Wrapf(ErrNoteNotFound, "updating the note %s", n.UUID)
+			} else if err != nil {
+				return errors.Wrapf(err, "querying the note %s", n.UUID)
+			}
+			if n.Body == oldBody {
+				continue
+			}
+
+			if err := database.UpdateNoteContent(tx, s.ctx.Clock, rowID, n.Body, s.ctx.DeviceID); err != nil {
+				return errors.Wrapf(err, "changing the content of the note %s", n.UUID)
+			}
+
+			var fc database.FieldChanges
+			fc.Body(oldBody, n.Body)
+			if err := s.journal(tx, database.OpEditNote, n.UUID, bookUUID, fc); err != nil {
+				return err
+			}
+		}
+
+		for _, uuid := range c.Removed {
+			var body string
+			err := tx.QueryRow("SELECT body FROM notes WHERE uuid = ? AND book_uuid = ? AND deleted = false", uuid, bookUUID).Scan(&body)
+			if err == sql.ErrNoRows {
+				return errors.Wrapf(ErrNoteNotFound, "removing the note %s", uuid)
+			} else if err != nil {
+				return errors.Wrapf(err, "querying the note %s", uuid)
+			}
+
+			if _, err := tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE uuid = ?", true, true, "", uuid); err != nil {
+				return errors.Wrapf(err, "removing the note %s", uuid)
+			}
+
+			var fc database.FieldChanges
+			fc.Body(body, "")
+			if err := s.journal(tx, database.OpRemoveNote, uuid, bookUUID, fc); err != nil {
+				return err
+			}
+		}
+
+		for _, body := range added {
+			uuid, err := utils.GenerateUUID()
+			if err != nil {
+				return errors.Wrap(err, "generating uuid")
+			}
+
+			n := database.NewNote(uuid, bookUUID, body, s.ctx.Clock.Now().UnixNano(), 0, 0, false, false, true)
+			n.OriginDevice = s.ctx.DeviceID
+			if n.Position, err = database.NextNotePosition(tx, bookUUID); err != nil {
+				return errors.Wrap(err, "finding the position")
+			}
+			if err := n.Insert(tx); err != nil {
+				return errors.Wrap(err, "creating the note")
+			}
+
+			var fc database.FieldChanges
+			fc.Field("book", "", book)
+			fc.Body("", body)
+			if err := s.journal(tx, database.OpAddNote, uuid, bookUUID, fc); err != nil {
+				return err
+			}
+
+			addedUUIDs = append(addedUUIDs, uuid)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, uuid := range addedUUIDs {
+		info, err := database.GetNoteInfoByUUID(s.ctx.DB, uuid)
+		if err != nil {
+			return errors.Wrap(err, "getting the note")
+		}
+		s.ctx.Hooks.NoteAdded(hooks.NewNote(info))
+	}
+	for _, n := range updated {
+		info, err := database.GetNoteInfoByUUID(s.ctx.DB, n.UUID)
+		if err != nil {
+			return errors.Wrap(err, "getting the note")
+		}
+		s.ctx.Hooks.NoteEdited(hooks.NewNote(info))
+	}
+
+	return nil
+}
@@ -0,0 +1,485 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnote
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/bulkedit"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestAddNote(t *testing.T) {
+	t.Run("new book", func(t *testing.T) {
+		s, ctx := setupStore(t)
+		f := testutils.NewFixture(t, ctx.DB)
+
+		n, err := s.AddNote("js", "n1 body\r\n")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "adding a note"))
+		}
+
+		assert.Equal(t, n.Book, "js", "book mismatch")
+		assert.Equal(t, n.Body, "n1 body\n", "body mismatch")
+		assert.Equal(t, n.AddedOn.Equal(testNow), true, "added_on mismatch")
+		assert.Equal(t, n.EditedOn.IsZero(), true, "edited_on mismatch")
+		assert.Equal(t, f.MustCountBooks(), 1, "book count mismatch")
+
+		note := f.MustGetNote(n.UUID)
+		assert.Equal(t, note.Dirty, true, "note dirty mismatch")
+		assert.Equal(t, note.RowID, n.Index, "index mismatch")
+
+		ts := testNow.UnixNano()
+		assert.DeepEqual(t, mustGetActions(t, ctx.DB), []database.Action{
+			{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpAddBook, BookUUID: note.BookUUID, Summary: `label: "" -> "js"`},
+			{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpAddNote, NoteUUID: n.UUID, BookUUID: note.BookUUID, Summary: `book: "" -> "js", body: 0 -> 8 bytes`},
+		}, "actions mismatch")
+	})
+
+	t.Run("existing book", func(t *testing.T) {
+		s, ctx := setupStore(t)
+		f := testutils.NewFixture(t, ctx.DB)
+		b := f.Book("js")
+		f.Note(b, "n0 body", testutils.With.Position(2.5))
+
+		n, err := s.AddNote("js", "n1 body")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "adding a note"))
+		}
+
+		assert.Equal(t, f.MustCountBooks(), 1, "book count mismatch")
+		assert.Equal(t, f.MustGetNote(n.UUID).BookUUID, b.UUID, "book uuid mismatch")
+		assert.Equal(t, f.MustGetNote(n.UUID).Position, 3.5, "position mismatch")
+
+		actions := mustGetActions(t, ctx.DB)
+		assert.Equal(t, len(actions), 1, "action count mismatch")
+		assert.Equal(t, actions[0].Operation, database.OpAddNote, "operation mismatch")
+	})
+
+	t.Run("origin device", func(t *testing.T) {
+		_, ctx := setupStore(t)
+		ctx.DeviceID = "device-1"
+		s := NewStore(ctx)
+		f := testutils.NewFixture(t, ctx.DB)
+
+		n, err := s.AddNote("js", "n1 body")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "adding a note"))
+		}
+
+		assert.Equal(t, f.MustGetNote(n.UUID).OriginDevice, "device-1", "origin device mismatch")
+		for _, a := range mustGetActions(t, ctx.DB) {
+			assert.Equal(t, a.Device, "device-1", "action device mismatch")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		s, ctx := setupStore(t)
+		f := testutils.NewFixture(t, ctx.DB)
+
+		if _, err := s.AddNote("js", ""); err == nil {
+			t.Error("expected an error for an empty body")
+		}
+		if _, err := s.AddNote("js", "\xff"); err == nil {
+			t.Error("expected an error for a body that is not valid UTF-8")
+		}
+		if _, err := s.AddNote(" ", "n1 body"); err == nil {
+			t.Error("expected an error for a blank book name")
+		}
+
+		assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch")
+		assert.Equal(t, f.MustCountBooks(), 0, "book count mismatch")
+		assert.Equal(t, len(mustGetActions(t, ctx.DB)), 0, "action count mismatch")
+	})
+}
+
+func TestNotes(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	b2 := f.Book("css")
+	n1 := f.Note(b1, "n1 body", testutils.With.AddedOn(3), testutils.With.EditedOn(5))
+	n2 := f.Note(b1, "n2 body", testutils.With.AddedOn(1))
+	n3 := f.Note(b1, "n3 body", testutils.With.AddedOn(2), testutils.With.EditedOn(6))
+	n4 := f.Note(b2, "n4 body", testutils.With.AddedOn(4))
+	f.Note(b1, "", testutils.With.AddedOn(6), testutils.With.Deleted())
+
+	testCases := []struct {
+		name   string
+		filter NoteFilter
+		want   []string
+	}{
+		{"all", NoteFilter{}, []string{n2.UUID, n3.UUID, n1.UUID, n4.UUID}},
+		{"book", NoteFilter{Book: "js"}, []string{n2.UUID, n3.UUID, n1.UUID}},
+		{"edited", NoteFilter{Book: "js", Sort: SortEdited}, []string{n2.UUID, n1.UUID, n3.UUID}},
+		{"index", NoteFilter{Sort: SortIndex}, []string{n1.UUID, n2.UUID, n3.UUID, n4.UUID}},
+		{"page", NoteFilter{Limit: 2, Offset: 1}, []string{n3.UUID, n1.UUID}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			notes, err := s.Notes(tc.filter)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "listing notes"))
+			}
+
+			got := []string{}
+			for _, n := range notes {
+				got = append(got, n.UUID)
+			}
+
+			assert.DeepEqual(t, got, tc.want, "notes mismatch")
+		})
+	}
+
+	t.Run("unknown book", func(t *testing.T) {
+		_, err := s.Notes(NoteFilter{Book: "go"})
+		assert.Equal(t, err, ErrBookNotFound, "error mismatch")
+	})
+
+	t.Run("invalid sort", func(t *testing.T) {
+		if _, err := s.Notes(NoteFilter{Sort: "usn"}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestUpdateNote(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	b2 := f.Book("css")
+	n := f.Note(b1, "n1 body", testutils.With.AddedOn(1))
+
+	got, err := s.UpdateNote(n.UUID, NoteUpdate{Book: "css", Body: "n1 body edited"})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "updating the note"))
+	}
+
+	assert.Equal(t, got.Book, "css", "book mismatch")
+	assert.Equal(t, got.Body, "n1 body edited", "body mismatch")
+	assert.Equal(t, got.EditedOn.Equal(testNow), true, "edited_on mismatch")
+
+	note := f.MustGetNote(n.UUID)
+	assert.Equal(t, note.BookUUID, b2.UUID, "book uuid mismatch")
+	assert.Equal(t, note.Dirty, true, "dirty mismatch")
+
+	if _, err := s.UpdateNote(n.UUID, NoteUpdate{Body: "n1 body edited"}); err == nil {
+		t.Error("expected an error for an unchanged body")
+	}
+	if _, err := s.UpdateNote("missing-uuid", NoteUpdate{Body: "body"}); err != ErrNoteNotFound {
+		t.Errorf("expected ErrNoteNotFound but got %v", err)
+	}
+
+	// moving the note without changing its body
+	if _, err := s.UpdateNote(n.UUID, NoteUpdate{Book: "js"}); err != nil {
+		t.Fatal(errors.Wrap(err, "moving the note"))
+	}
+
+	ts := testNow.UnixNano()
+	assert.DeepEqual(t, mustGetActions(t, ctx.DB), []database.Action{
+		{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpEditNote, NoteUUID: n.UUID, BookUUID: b2.UUID, Summary: `book: "js" -> "css", body: 7 -> 14 bytes`},
+		{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpMoveNote, NoteUUID: n.UUID, BookUUID: b1.UUID, Summary: `book: "css" -> "js"`},
+	}, "actions mismatch")
+}
+
+func TestUpdateNote_originDevice(t *testing.T) {
+	_, ctx := setupStore(t)
+	ctx.DeviceID = "device-2"
+	s := NewStore(ctx)
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	f.Book("css")
+	n1 := f.Note(b1, "n1 body", testutils.With.OriginDevice("device-1"))
+	n2 := f.Note(b1, "n2 body", testutils.With.OriginDevice("device-1"))
+
+	if _, err := s.UpdateNote(n1.UUID, NoteUpdate{Body: "n1 body edited"}); err != nil {
+		t.Fatal(errors.Wrap(err, "editing the note"))
+	}
+	if _, err := s.UpdateNote(n2.UUID, NoteUpdate{Book: "css"}); err != nil {
+		t.Fatal(errors.Wrap(err, "moving the note"))
+	}
+
+	assert.Equal(t, f.MustGetNote(n1.UUID).OriginDevice, "device-2", "n1 origin device mismatch")
+	assert.Equal(t, f.MustGetNote(n2.UUID).OriginDevice, "device-2", "n2 origin device mismatch")
+
+	actions := mustGetActions(t, ctx.DB)
+	assert.Equal(t, len(actions), 2, "action count mismatch")
+	for _, a := range actions {
+		assert.Equal(t, a.Device, "device-2", "action device mismatch")
+	}
+}
+
+func TestUpdateNote_createBook(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	n := f.Note(b1, "n1 body")
+
+	if _, err := s.UpdateNote(n.UUID, NoteUpdate{Book: "css"}); err == nil {
+		t.Error("expected an error for a missing book")
+	}
+	assert.Equal(t, f.MustCountBooks(), 1, "book count mismatch for a missing book")
+
+	got, err := s.UpdateNote(n.UUID, NoteUpdate{Book: "css", CreateBook: true})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "moving the note to a new book"))
+	}
+
+	assert.Equal(t, got.Book, "css", "book mismatch")
+	assert.Equal(t, f.MustCountBooks(), 2, "book count mismatch")
+
+	note := f.MustGetNote(n.UUID)
+	book := f.MustGetBook(note.BookUUID)
+	assert.Equal(t, book.Label, "css", "book label mismatch")
+	assert.Equal(t, book.Dirty, true, "book dirty mismatch")
+}
+
+func TestLastNote(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	b2 := f.Book("css")
+	f.Book("go")
+	n1 := f.Note(b1, "n1 body", testutils.With.AddedOn(3))
+	f.Note(b1, "n2 body", testutils.With.AddedOn(1))
+	n3 := f.Note(b2, "n3 body", testutils.With.AddedOn(2))
+	f.Note(b2, "n4 body", testutils.With.AddedOn(4), testutils.With.Deleted())
+
+	got, err := s.LastNote("")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the last note"))
+	}
+	assert.Equal(t, got.UUID, n1.UUID, "last note mismatch")
+
+	got, err = s.LastNote("css")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting the last note in a book"))
+	}
+	assert.Equal(t, got.UUID, n3.UUID, "last note in the book mismatch")
+
+	_, err = s.LastNote("go")
+	assert.Equal(t, err, ErrNoteNotFound, "error mismatch for an empty book")
+	_, err = s.LastNote("python")
+	assert.Equal(t, err, ErrBookNotFound, "error mismatch for a missing book")
+}
+
+func TestAppendNote(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("js")
+	n := f.Note(b, "n1 body\n", testutils.With.USN(1))
+
+	got, err := s.AppendNote(n.UUID, "more text")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "appending to the note"))
+	}
+
+	assert.Equal(t, got.Body, "n1 body\nmore text", "body mismatch")
+	assert.Equal(t, got.EditedOn.Equal(testNow), true, "edited_on mismatch")
+
+	note := f.MustGetNote(n.UUID)
+	assert.Equal(t, note.Dirty, true, "dirty mismatch")
+	assert.Equal(t, note.USN, 1, "usn mismatch")
+
+	// an edit made after the note was read is kept
+	database.MustExec(t, "editing the note", ctx.DB, "UPDATE notes SET body = ? WHERE uuid = ?", "edited body", n.UUID)
+	got, err = s.AppendNote(n.UUID, "last line")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "appending to the edited note"))
+	}
+	assert.Equal(t, got.Body, "edited body\nlast line", "body mismatch after an edit")
+
+	if _, err := s.AppendNote(n.UUID, " \n"); err == nil {
+		t.Error("expected an error for an empty text")
+	}
+	if _, err := s.AppendNote("missing-uuid", "text"); err != ErrNoteNotFound {
+		t.Errorf("expected ErrNoteNotFound but got %v", err)
+	}
+
+	ts := testNow.UnixNano()
+	assert.DeepEqual(t, mustGetActions(t, ctx.DB), []database.Action{
+		{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpEditNote, NoteUUID: n.UUID, BookUUID: b.UUID, Summary: "body: 8 -> 17 bytes"},
+		{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpEditNote, NoteUUID: n.UUID, BookUUID: b.UUID, Summary: "body: 11 -> 21 bytes"},
+	}, "actions mismatch")
+}
+
+func TestReorderNote(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b1 := f.Book("js")
+	b2 := f.Book("css")
+	n1 := f.Note(b1, "n1 body", testutils.With.Position(1))
+	n2 := f.Note(b1, "n2 body", testutils.With.Position(2))
+	n3 := f.Note(b1, "n3 body", testutils.With.Position(3))
+	n4 := f.Note(b2, "n4 body", testutils.With.Position(1))
+
+	if _, err := s.ReorderNote(n3.UUID, n1.UUID, true); err != nil {
+		t.Fatal(errors.Wrap(err, "reordering the note"))
+	}
+
+	note := f.MustGetNote(n3.UUID)
+	assert.Equal(t, note.Position, 1.5, "position mismatch")
+	assert.Equal(t, note.Dirty, false, "dirty mismatch")
+	assert.Equal(t, f.MustGetNote(n2.UUID).Position, float64(2), "n2 position mismatch")
+
+	if _, err := s.ReorderNote(n1.UUID, n4.UUID, false); err == nil {
+		t.Error("expected an error for a note in another book")
+	}
+	if _, err := s.ReorderNote("missing-uuid", n1.UUID, false); err != ErrNoteNotFound {
+		t.Errorf("expected ErrNoteNotFound but got %v", err)
+	}
+
+	assert.DeepEqual(t, mustGetActions(t, ctx.DB), []database.Action{
+		{Timestamp: testNow.UnixNano(), Actor: database.ActorCLI, Operation: database.OpReorderNote, NoteUUID: n3.UUID, BookUUID: b1.UUID, Summary: `position: "3" -> "1.5"`},
+	}, "actions mismatch")
+}
+
+func TestRemoveNote(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("js")
+	n := f.Note(b, "n1 body")
+
+	if err := s.RemoveNote(n.UUID); err != nil {
+		t.Fatal(errors.Wrap(err, "removing the note"))
+	}
+
+	note := f.MustGetNote(n.UUID)
+	assert.Equal(t, note.Deleted, true, "deleted mismatch")
+	assert.Equal(t, note.Dirty, true, "dirty mismatch")
+	assert.Equal(t, note.Body, "", "body mismatch")
+
+	_, err := s.Note(n.UUID)
+	assert.Equal(t, err, ErrNoteNotFound, "Note error mismatch")
+	assert.Equal(t, s.RemoveNote(n.UUID), ErrNoteNotFound, "RemoveNote error mismatch")
+
+	assert.DeepEqual(t, mustGetActions(t, ctx.DB), []database.Action{
+		{Timestamp: testNow.UnixNano(), Actor: database.ActorCLI, Operation: database.OpRemoveNote, NoteUUID: n.UUID, BookUUID: b.UUID, Summary: "body: 7 -> 0 bytes"},
+	}, "actions mismatch")
+}
+
+func TestExpireNotes(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+	b := f.Book("js")
+	n1 := f.Note(b, "n1 body")
+	n2 := f.Note(b, "n2 body")
+	n3 := f.Note(b, "n3", testutils.With.Deleted())
+	n4 := f.Note(b, "n4 body")
+
+	count, err := s.ExpireNotes([]string{n1.UUID, n2.UUID, n3.UUID, "unknown-uuid"})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "expiring the notes"))
+	}
+	assert.Equal(t, count, 2, "count mismatch")
+
+	for _, uuid := range []string{n1.UUID, n2.UUID} {
+		note := f.MustGetNote(uuid)
+		assert.Equal(t, note.Deleted, true, "deleted mismatch for "+uuid)
+		assert.Equal(t, note.Dirty, true, "dirty mismatch for "+uuid)
+		assert.Equal(t, note.Body, "", "body mismatch for "+uuid)
+	}
+	assert.Equal(t, f.MustGetNote(n4.UUID).Deleted, false, "n4 deleted mismatch")
+
+	assert.DeepEqual(t, mustGetActions(t, ctx.DB), []database.Action{
+		{Timestamp: testNow.UnixNano(), Actor: database.ActorRetention, Operation: database.OpRemoveNote, NoteUUID: n1.UUID, BookUUID: b.UUID, Summary: "body: 7 -> 0 bytes"},
+		{Timestamp: testNow.UnixNano(), Actor: database.ActorRetention, Operation: database.OpRemoveNote, NoteUUID: n2.UUID, BookUUID: b.UUID, Summary: "body: 7 -> 0 bytes"},
+	}, "actions mismatch")
+}
+
+func TestApplyBookEdit(t *testing.T) {
+	t.Run("changes", func(t *testing.T) {
+		s, ctx := setupStore(t)
+		f := testutils.NewFixture(t, ctx.DB)
+		b := f.Book("js")
+		n1 := f.Note(b, "n1 body")
+		n2 := f.Note(b, "n2 body")
+		n3 := f.Note(b, "n3 body")
+
+		err := s.ApplyBookEdit("js", bulkedit.Changes{
+			Updated: []bulkedit.Note{{UUID: n1.UUID, Body: "n1 body\r\nedited"}},
+			Added:   []string{"n4 body"},
+			Removed: []string{n2.UUID},
+		})
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "applying the edit"))
+		}
+
+		note1 := f.MustGetNote(n1.UUID)
+		assert.Equal(t, note1.Body, "n1 body\nedited", "n1 body mismatch")
+		assert.Equal(t, note1.Dirty, true, "n1 dirty mismatch")
+		note2 := f.MustGetNote(n2.UUID)
+		assert.Equal(t, note2.Deleted, true, "n2 deleted mismatch")
+		assert.Equal(t, note2.Dirty, true, "n2 dirty mismatch")
+		assert.Equal(t, f.MustGetNote(n3.UUID).Dirty, false, "n3 dirty mismatch")
+
+		notes, err := s.Notes(NoteFilter{Book: "js"})
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "listing the notes"))
+		}
+		assert.Equal(t, len(notes), 3, "note count mismatch")
+		n4 := notes[2]
+		assert.Equal(t, n4.Body, "n4 body", "n4 body mismatch")
+		assert.Equal(t, f.MustGetNote(n4.UUID).Dirty, true, "n4 dirty mismatch")
+
+		ts := testNow.UnixNano()
+		assert.DeepEqual(t, mustGetActions(t, ctx.DB), []database.Action{
+			{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpEditNote, NoteUUID: n1.UUID, BookUUID: b.UUID, Summary: "body: 7 -> 14 bytes"},
+			{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpRemoveNote, NoteUUID: n2.UUID, BookUUID: b.UUID, Summary: "body: 7 -> 0 bytes"},
+			{Timestamp: ts, Actor: database.ActorCLI, Operation: database.OpAddNote, NoteUUID: n4.UUID, BookUUID: b.UUID, Summary: `book: "" -> "js", body: 0 -> 7 bytes`},
+		}, "actions mismatch")
+	})
+
+	t.Run("note not in the book", func(t *testing.T) {
+		s, ctx := setupStore(t)
+		f := testutils.NewFixture(t, ctx.DB)
+		b1 := f.Book("js")
+		b2 := f.Book("css")
+		n1 := f.Note(b1, "n1 body")
+		n2 := f.Note(b2, "n2 body")
+
+		err := s.ApplyBookEdit("js", bulkedit.Changes{
+			Updated: []bulkedit.Note{{UUID: n1.UUID, Body: "n1 edited"}},
+			Removed: []string{n2.UUID},
+		})
+		assert.Equal(t, errors.Cause(err), ErrNoteNotFound, "error mismatch")
+
+		// nothing is changed
+		assert.Equal(t, f.MustGetNote(n1.UUID).Body, "n1 body", "n1 body mismatch")
+		assert.Equal(t, f.MustGetNote(n2.UUID).Deleted, false, "n2 deleted mismatch")
+	})
+
+	t.Run("book not found", func(t *testing.T) {
+		s, _ := setupStore(t)
+
+		err := s.ApplyBookEdit("js", bulkedit.Changes{Added: []string{"n1 body"}})
+		assert.Equal(t, err, ErrBookNotFound, "error mismatch")
+	})
+}
+
+func TestFromUnixNano(t *testing.T) {
+	assert.Equal(t, fromUnixNano(0).IsZero(), true, "zero mismatch")
+	assert.Equal(t, fromUnixNano(testNow.UnixNano()).Equal(testNow), true, "time mismatch")
+	assert.Equal(t, fromUnixNano(1).Equal(time.Unix(0, 1)), true, "nanosecond mismatch")
+}
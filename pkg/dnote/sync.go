@@ -0,0 +1,145 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnote
+
+import (
+	stdCtx "context"
+
+	"github.com/dnote/dnote/pkg/cli/accountexport"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/sync"
+)
+
+// SyncOptions are the options of a sync
+type SyncOptions = sync.Options
+
+// SyncLimits caps the changes that a sync gets, such as on a metered connection
+type SyncLimits = sync.Limits
+
+// SyncTimeouts decides how a sync retries its requests and how long they and
+// the sync can take
+type SyncTimeouts = context.SyncTimeouts
+
+// Transport is the means by which a sync exchanges the changes with the
+// remote copy, which can be set in SyncOptions to sync through a remote other
+// than the dnote server
+type Transport = sync.Transport
+
+// SyncReport is the result of a sync
+type SyncReport = sync.Report
+
+// SyncPhase is the time spent in a phase of a sync, in a SyncReport
+type SyncPhase = sync.Phase
+
+// SyncCleanPreview is how a full sync would reconcile the local notes and
+// books with the remote copy
+type SyncCleanPreview = sync.CleanPreview
+
+// SyncCleanItem is a note or a book in a SyncCleanPreview
+type SyncCleanItem = sync.CleanItem
+
+// ErrNotLoggedIn is an error for syncing with the server without logging in
+var ErrNotLoggedIn = sync.ErrNotLoggedIn
+
+// ErrLocalOnly is an error for syncing with the server without logging in
+// after the user chose to keep the notes locally only
+var ErrLocalOnly = sync.ErrLocalOnly
+
+// SyncQuotaError is the error of a sync whose new notes or books do not fit in
+// the limits of the account
+type SyncQuotaError = sync.QuotaError
+
+// ErrAlreadySynced is an error for loading an account export into a store that
+// has synced before
+var ErrAlreadySynced = sync.ErrAlreadySynced
+
+// Syncer syncs a store with the backend set in its config
+type Syncer struct {
+	store *Store
+}
+
+// NewSyncer returns a syncer for the store
+func NewSyncer(s *Store) *Syncer {
+	return &Syncer{store: s}
+}
+
+// Sync syncs the notes and the books, and pushes the local changes to the
+// mirrors. The sync is rolled back if ctx is canceled before it completes. If
+// ctx or the total timeout expires, the sync stops early instead, keeping the
+// changes exchanged so far.
+func (y *Syncer) Sync(ctx stdCtx.Context, opts SyncOptions) (SyncReport, error) {
+	dctx := y.store.ctx
+	dctx.Ctx = ctx
+
+	return sync.Run(dctx, opts)
+}
+
+// PreviewClean returns how a full sync would reconcile the local notes and
+// books with the ones on the remote copy, leaving the store unchanged. Only
+// the uuids of the remote ones are downloaded if the transport supports it.
+func (y *Syncer) PreviewClean(ctx stdCtx.Context, opts SyncOptions) (SyncCleanPreview, error) {
+	dctx := y.store.ctx
+	dctx.Ctx = ctx
+
+	return sync.PreviewClean(dctx, opts)
+}
+
+// LoadAccountExport loads the notes and the books of an account export of the
+// dnote server into the store, keeping their uuids and usns, as if they were
+// got by a full sync at the time of the export. The next sync then gets only
+// the changes made to the account after the export. The removed notes and
+// books in the export, and the notes in the removed books, are left out,
+// since the store has never had them. It fails with ErrAlreadySynced if the
+// store has synced before.
+func (y *Syncer) LoadAccountExport(e accountexport.Export) error {
+	ch := sync.Changes{
+		Books:       []client.SyncFragBook{},
+		Notes:       []client.SyncFragNote{},
+		Next:        sync.USNCursor(e.Metadata.MaxUSN),
+		CurrentTime: e.Metadata.ExportedAt,
+	}
+
+	books := map[string]bool{}
+	for _, b := range e.Books {
+		if b.Deleted {
+			continue
+		}
+
+		books[b.UUID] = true
+		ch.Books = append(ch.Books, client.SyncFragBook{UUID: b.UUID, USN: b.USN, Label: b.Label, AddedOn: b.AddedOn})
+	}
+	for _, n := range e.Notes {
+		if n.Deleted || !books[n.BookUUID] {
+			continue
+		}
+
+		ch.Notes = append(ch.Notes, client.SyncFragNote{
+			UUID:     n.UUID,
+			BookUUID: n.BookUUID,
+			USN:      n.USN,
+			AddedOn:  n.AddedOn,
+			EditedOn: n.EditedOn,
+			Body:     n.Body,
+			Public:   n.Public,
+		})
+	}
+
+	return sync.Load(y.store.ctx, ch)
+}
@@ -0,0 +1,141 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnote
+
+import (
+	stdCtx "context"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/accountexport"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestSync(t *testing.T) {
+	t.Run("not logged in", func(t *testing.T) {
+		s, _ := setupStore(t)
+
+		_, err := NewSyncer(s).Sync(stdCtx.Background(), SyncOptions{})
+		assert.Equal(t, errors.Cause(err), ErrNotLoggedIn, "error mismatch")
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		_, ctx := setupStore(t)
+		testutils.Login(t, &ctx)
+		// skip the remote migrations, which the mock server does not serve
+		database.MustExec(t, "inserting remote schema", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemRemoteSchema, 1)
+
+		srv := testutils.NewMockServer(t)
+		defer srv.Close()
+		srv.SetSyncState(client.GetSyncStateResp{CurrentTime: testNow.Unix()})
+		ctx.APIEndpoint = srv.URL()
+
+		s := NewStore(ctx)
+		if _, err := s.AddNote("js", "n1 body"); err != nil {
+			t.Fatal(errors.Wrap(err, "adding a note"))
+		}
+
+		report, err := NewSyncer(s).Sync(stdCtx.Background(), SyncOptions{})
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "syncing"))
+		}
+
+		assert.Equal(t, report.Backend, ctx.SyncBackend, "backend mismatch")
+		assert.Equal(t, report.UploadedNotes, 1, "uploaded notes mismatch")
+		assert.Equal(t, report.UploadedBooks, 1, "uploaded books mismatch")
+		assert.Equal(t, report.Notes, 1, "notes mismatch")
+		assert.Equal(t, report.Books, 1, "books mismatch")
+		assert.DeepEqual(t, srv.CreatedBookLabels(), []string{"js"}, "created books mismatch")
+		assert.DeepEqual(t, srv.CreatedNoteBodies(), []string{"n1 body"}, "created notes mismatch")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		_, ctx := setupStore(t)
+		testutils.Login(t, &ctx)
+		database.MustExec(t, "inserting remote schema", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemRemoteSchema, 1)
+
+		srv := testutils.NewMockServer(t)
+		defer srv.Close()
+		ctx.APIEndpoint = srv.URL()
+
+		s := NewStore(ctx)
+		if _, err := s.AddNote("js", "n1 body"); err != nil {
+			t.Fatal(errors.Wrap(err, "adding a note"))
+		}
+
+		c, cancel := stdCtx.WithCancel(stdCtx.Background())
+		cancel()
+
+		if _, err := NewSyncer(s).Sync(c, SyncOptions{}); err == nil {
+			t.Fatal("expected an error")
+		}
+
+		// the note is still to be uploaded on the next sync
+		notes, err := s.Notes(NoteFilter{})
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "listing notes"))
+		}
+		assert.Equal(t, testutils.MustGetNote(t, ctx.DB, notes[0].UUID).Dirty, true, "dirty mismatch")
+	})
+}
+
+func TestLoadAccountExport(t *testing.T) {
+	s, ctx := setupStore(t)
+	f := testutils.NewFixture(t, ctx.DB)
+
+	e := accountexport.Export{
+		Metadata: accountexport.Metadata{FormatVersion: 1, UserUUID: "u1-uuid", MaxUSN: 7, ExportedAt: testNow.Unix()},
+		Books: []accountexport.Book{
+			{UUID: "b1-uuid", USN: 1, Label: "js"},
+			{UUID: "b2-uuid", USN: 6, Deleted: true},
+		},
+		Notes: []accountexport.Note{
+			{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 2, AddedOn: 1, EditedOn: 2, Body: "n1 body", Public: true},
+			{UUID: "n2-uuid", BookUUID: "b1-uuid", USN: 3, Deleted: true},
+			{UUID: "n3-uuid", BookUUID: "b2-uuid", USN: 5, Body: "n3 body"},
+			{UUID: "n4-uuid", BookUUID: "b2-uuid", USN: 7, Deleted: true},
+		},
+	}
+
+	if err := NewSyncer(s).LoadAccountExport(e); err != nil {
+		t.Fatal(errors.Wrap(err, "loading"))
+	}
+
+	assert.Equal(t, f.MustCountBooks(), 1, "book count mismatch")
+	assert.Equal(t, f.MustCountNotes(), 1, "note count mismatch")
+
+	n1 := f.MustGetNote("n1-uuid")
+	assert.Equal(t, n1.BookUUID, "b1-uuid", "n1 book mismatch")
+	assert.Equal(t, n1.USN, 2, "n1 usn mismatch")
+	assert.Equal(t, n1.Public, true, "n1 public mismatch")
+	assert.Equal(t, n1.Dirty, false, "n1 dirty mismatch")
+
+	var lastMaxUSN string
+	var lastSyncAt int64
+	database.MustScan(t, "getting last max usn", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastMaxUSN), &lastMaxUSN)
+	database.MustScan(t, "getting last sync at", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemLastSyncAt), &lastSyncAt)
+	assert.Equal(t, lastMaxUSN, "7", "last max usn mismatch")
+	assert.Equal(t, lastSyncAt, testNow.Unix(), "last sync at mismatch")
+
+	assert.Equal(t, errors.Cause(NewSyncer(s).LoadAccountExport(e)), ErrAlreadySynced, "error mismatch")
+}
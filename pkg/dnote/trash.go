@@ -0,0 +1,57 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnote
+
+import (
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/pkg/errors"
+)
+
+// RestoreExpungedNote adds back the note with the given id in the archive of
+// the notes that the syncs removed because they were removed on another
+// device. As the note is gone from the server, it is restored as a new note
+// with a new uuid, in the book it was in, which is created if it does not
+// exist. The note keeps its times, leaves the archive, and is uploaded on the
+// next sync.
+func (s *Store) RestoreExpungedNote(id int) (Note, error) {
+	var uuid string
+	err := infra.WithTxRetry(s.ctx.DB, func(tx *database.DB) error {
+		e, err := database.GetExpungedNote(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := validate.BookName(e.BookLabel); err != nil {
+			return errors.Wrap(err, "invalid book name")
+		}
+
+		uuid, err = s.insertNote(tx, e.BookLabel, e.Body, e.AddedOn, e.EditedOn)
+		if err != nil {
+			return err
+		}
+
+		return database.DeleteExpungedNote(tx, id)
+	})
+	if err != nil {
+		return Note{}, err
+	}
+
+	return s.noteAdded(uuid)
+}
@@ -0,0 +1,86 @@
+/* Copyright (C) 2019, 2020, 2021, 2022 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnote
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestRestoreExpungedNote(t *testing.T) {
+	t.Run("new book", func(t *testing.T) {
+		s, ctx := setupStore(t)
+		f := testutils.NewFixture(t, ctx.DB)
+		database.MustExec(t, "archiving n1", ctx.DB, "INSERT INTO expunged_archive (note_uuid, book_label, body, added_on, edited_on, expunged_at) VALUES (?, ?, ?, ?, ?, ?)",
+			"n1-uuid", "js", "n1 body", 1541108743000000000, 1541108744000000000, 1541108745000000000)
+
+		n, err := s.RestoreExpungedNote(1)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "restoring the note"))
+		}
+
+		// the note is new to the server
+		assert.NotEqual(t, n.UUID, "n1-uuid", "uuid mismatch")
+		assert.Equal(t, n.Book, "js", "book mismatch")
+		assert.Equal(t, n.Body, "n1 body", "body mismatch")
+		assert.Equal(t, n.AddedOn.Equal(fromUnixNano(1541108743000000000)), true, "added_on mismatch")
+		assert.Equal(t, n.EditedOn.Equal(fromUnixNano(1541108744000000000)), true, "edited_on mismatch")
+
+		note := f.MustGetNote(n.UUID)
+		assert.Equal(t, note.Dirty, true, "note dirty mismatch")
+		assert.Equal(t, f.MustGetBook(note.BookUUID).Dirty, true, "book dirty mismatch")
+
+		var count int
+		database.MustScan(t, "counting the archived notes", ctx.DB.QueryRow("SELECT count(*) FROM expunged_archive"), &count)
+		assert.Equal(t, count, 0, "archived count mismatch")
+	})
+
+	t.Run("existing book", func(t *testing.T) {
+		s, ctx := setupStore(t)
+		f := testutils.NewFixture(t, ctx.DB)
+		b := f.Book("js")
+		database.MustExec(t, "archiving n1", ctx.DB, "INSERT INTO expunged_archive (note_uuid, book_label, body, added_on, expunged_at) VALUES (?, ?, ?, ?, ?)",
+			"n1-uuid", "js", "n1 body", 1541108743000000000, 1541108745000000000)
+
+		n, err := s.RestoreExpungedNote(1)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "restoring the note"))
+		}
+
+		assert.Equal(t, f.MustCountBooks(), 1, "book count mismatch")
+		assert.Equal(t, f.MustGetNote(n.UUID).BookUUID, b.UUID, "book uuid mismatch")
+		assert.Equal(t, n.EditedOn.IsZero(), true, "edited_on mismatch")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s, ctx := setupStore(t)
+		f := testutils.NewFixture(t, ctx.DB)
+
+		if _, err := s.RestoreExpungedNote(1); err == nil {
+			t.Fatal("expected an error")
+		}
+
+		assert.Equal(t, f.MustCountNotes(), 0, "note count mismatch")
+		assert.Equal(t, f.MustCountBooks(), 0, "book count mismatch")
+	})
+}
@@ -107,6 +107,11 @@ type Config struct {
 	DB                  PostgresConfig
 	AssetBaseURL        string
 	HTTP500Page         []byte
+	// MinCLIVersion is the oldest version of the CLI that the server
+	// supports, and LatestCLIVersion is the latest release of the CLI. They
+	// are advertised to the clients with the sync state if not empty.
+	MinCLIVersion    string
+	LatestCLIVersion string
 }
 
 func getAppEnv() string {
@@ -135,6 +140,8 @@ func Load() Config {
 		DB:                  loadDBConfig(),
 		AssetBaseURL:        "",
 		HTTP500Page:         assets.MustGetHTTP500ErrorPage(),
+		MinCLIVersion:       os.Getenv("MinCLIVersion"),
+		LatestCLIVersion:    os.Getenv("LatestCLIVersion"),
 	}
 
 	if err := validate(c); err != nil {
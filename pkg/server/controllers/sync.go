@@ -107,6 +107,23 @@ type SyncFragBook struct {
 	Deleted   bool      `json:"deleted"`
 }
 
+// uuidsOnly returns the fragment without the bodies of the notes and the labels
+// of the books, such as for a client that compares only which of them exist
+func (f SyncFragment) uuidsOnly() SyncFragment {
+	ret := f
+	ret.Notes = make([]SyncFragNote, len(f.Notes))
+	ret.Books = make([]SyncFragBook, len(f.Books))
+
+	for i, n := range f.Notes {
+		ret.Notes[i] = SyncFragNote{UUID: n.UUID, BookUUID: n.BookUUID, USN: n.USN, Deleted: n.Deleted}
+	}
+	for i, b := range f.Books {
+		ret.Books[i] = SyncFragBook{UUID: b.UUID, USN: b.USN, Deleted: b.Deleted}
+	}
+
+	return ret
+}
+
 // NewFragBook presents the given book as a SyncFragBook
 func NewFragBook(book database.Book) SyncFragBook {
 	return SyncFragBook{
@@ -275,11 +292,26 @@ func (s *Sync) GetSyncFragment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var uuidsOnly bool
+	switch fields := r.URL.Query().Get("fields"); fields {
+	case "":
+	case "uuid":
+		uuidsOnly = true
+	default:
+		middleware.DoError(w, fmt.Sprintf("invalid fields '%s'", fields), nil, http.StatusBadRequest)
+		return
+	}
+
 	fragment, err := s.newFragment(user.ID, user.MaxUSN, afterUSN, limit)
 	if err != nil {
 		middleware.DoError(w, "getting fragment", err, http.StatusInternalServerError)
 		return
 	}
+	// a client that compares only which notes and books exist leaves out
+	// their contents
+	if uuidsOnly {
+		fragment = fragment.uuidsOnly()
+	}
 
 	response := GetSyncFragmentResp{
 		Fragment: fragment,
@@ -292,6 +324,13 @@ type GetSyncStateResp struct {
 	FullSyncBefore int   `json:"full_sync_before"`
 	MaxUSN         int   `json:"max_usn"`
 	CurrentTime    int64 `json:"current_time"`
+	// UserUUID identifies the account, such as for a client to check that an
+	// account export is of the same account
+	UserUUID string `json:"user_uuid"`
+	// MinCLIVersion and LatestCLIVersion are the oldest version of the CLI
+	// that the server supports and the latest release, if configured
+	MinCLIVersion    string `json:"min_cli_version,omitempty"`
+	LatestCLIVersion string `json:"latest_cli_version,omitempty"`
 }
 
 // GetSyncState responds with a sync fragment
@@ -306,7 +345,10 @@ func (s *Sync) GetSyncState(w http.ResponseWriter, r *http.Request) {
 		FullSyncBefore: fullSyncBefore,
 		MaxUSN:         user.MaxUSN,
 		// TODO: exposing server time means we probably shouldn't seed random generator with time?
-		CurrentTime: s.app.Clock.Now().Unix(),
+		CurrentTime:      s.app.Clock.Now().Unix(),
+		UserUUID:         user.UUID,
+		MinCLIVersion:    s.app.Config.MinCLIVersion,
+		LatestCLIVersion: s.app.Config.LatestCLIVersion,
 	}
 
 	log.WithFields(log.Fields{
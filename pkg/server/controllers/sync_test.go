@@ -19,12 +19,19 @@
 package controllers
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"reflect"
 	"testing"
 
 	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/app"
+	"github.com/dnote/dnote/pkg/server/config"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
 	"github.com/pkg/errors"
 )
 
@@ -91,3 +98,69 @@ func TestParseGetSyncFragmentQuery(t *testing.T) {
 		assert.Equal(t, limit, tc.limit, fmt.Sprintf("limit mismatch for test case %d", idx))
 	}
 }
+
+func TestGetSyncState(t *testing.T) {
+	defer testutils.ClearData(testutils.DB)
+
+	// Setup
+	server := MustNewServer(t, &app.App{
+		Clock:  clock.NewMock(),
+		Config: config.Config{MinCLIVersion: "0.10.0", LatestCLIVersion: "0.12.0"},
+	})
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@test.com", "pass1234")
+	testutils.MustExec(t, testutils.DB.Model(&user).Update("max_usn", 1234), "preparing user max_usn")
+
+	// Execute
+	req := testutils.MakeReq(server.URL, "GET", "/api/v3/sync/state", "")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var payload GetSyncStateResp
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+
+	var userRecord database.User
+	testutils.MustExec(t, testutils.DB.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+	assert.Equal(t, payload.MaxUSN, 1234, "max_usn mismatch")
+	assert.Equal(t, payload.UserUUID, userRecord.UUID, "user_uuid mismatch")
+	assert.Equal(t, payload.MinCLIVersion, "0.10.0", "min_cli_version mismatch")
+	assert.Equal(t, payload.LatestCLIVersion, "0.12.0", "latest_cli_version mismatch")
+}
+
+func TestSyncFragmentUUIDsOnly(t *testing.T) {
+	frag := SyncFragment{
+		FragMaxUSN:  3,
+		UserMaxUSN:  5,
+		CurrentTime: 1541108743,
+		Notes: []SyncFragNote{
+			{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 2, AddedOn: 1541108743, Body: "n1 body", Public: true},
+			{UUID: "n2-uuid", BookUUID: "b1-uuid", USN: 3, Deleted: true},
+		},
+		Books:         []SyncFragBook{{UUID: "b1-uuid", USN: 1, AddedOn: 1541108743, Label: "js"}},
+		ExpungedNotes: []string{"n3-uuid"},
+		ExpungedBooks: []string{},
+	}
+
+	got := frag.uuidsOnly()
+
+	assert.DeepEqual(t, got, SyncFragment{
+		FragMaxUSN:  3,
+		UserMaxUSN:  5,
+		CurrentTime: 1541108743,
+		Notes: []SyncFragNote{
+			{UUID: "n1-uuid", BookUUID: "b1-uuid", USN: 2},
+			{UUID: "n2-uuid", BookUUID: "b1-uuid", USN: 3, Deleted: true},
+		},
+		Books:         []SyncFragBook{{UUID: "b1-uuid", USN: 1}},
+		ExpungedNotes: []string{"n3-uuid"},
+		ExpungedBooks: []string{},
+	}, "fragment mismatch")
+	assert.Equal(t, frag.Notes[0].Body, "n1 body", "the original fragment was modified")
+}